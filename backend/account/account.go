@@ -0,0 +1,215 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/otp"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// ResetPurpose keys password reset codes
+	ResetPurpose = "password_reset"
+	// PhonePurpose keys codes that confirm a new phone number
+	PhonePurpose = "phone_change"
+	// MinPasswordLength is the shortest password accepted on reset
+	MinPasswordLength = 8
+
+	resetMessage       = "Your TujiFund password reset code is %s. It expires in 10 minutes. If you did not ask to reset your password, ignore this message."
+	phoneMessage       = "Your TujiFund code to confirm this phone number is %s. It expires in 10 minutes."
+	phoneChangedNotice = "The phone number on your TujiFund account has been changed. If you did not do this, contact your chama officials immediately."
+)
+
+var (
+	ErrPhoneTaken = errors.New("phone number is already used by another account")
+	ErrSamePhone  = errors.New("that is already your phone number")
+)
+
+// findUser returns the user with the given email address or phone number
+func findUser(ctx context.Context, db *sql.DB, email, phone string) (string, error) {
+	var userID string
+	var err error
+	if email != "" {
+		err = db.QueryRowContext(ctx, `SELECT user_id FROM users WHERE lower(email) = ?`, otp.Normalize(email)).Scan(&userID)
+	} else {
+		err = db.QueryRowContext(ctx, `
+			SELECT user_id FROM users WHERE REPLACE(REPLACE(phone_number, ' ', ''), '-', '') = ?`,
+			otp.Normalize(phone),
+		).Scan(&userID)
+	}
+	return userID, err
+}
+
+// RequestReset sends a password reset code to email (through mailer) or phone
+// (through sms). Nothing is sent when no account matches, and no error is
+// returned either, so the response does not reveal who is registered.
+func RequestReset(ctx context.Context, db *sql.DB, mailer, sms otp.Sender, email, phone string) error {
+	userID, err := findUser(ctx, db, email, phone)
+	if err == sql.ErrNoRows {
+		slog.InfoContext(ctx, "Password reset requested for unknown account")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Password reset requested", "user_id", userID)
+	if email != "" {
+		return otp.Issue(ctx, db, mailer, ResetPurpose, email, resetMessage)
+	}
+	return otp.Issue(ctx, db, sms, ResetPurpose, phone, resetMessage)
+}
+
+// ResetPassword checks the reset code sent to email or phone, sets the new
+// password and signs the user out of every session
+func ResetPassword(ctx context.Context, db *sql.DB, email, phone, code, password string, e audit.Entry) error {
+	userID, err := findUser(ctx, db, email, phone)
+	if err == sql.ErrNoRows {
+		return otp.ErrInvalid
+	}
+	if err != nil {
+		return err
+	}
+	destination := phone
+	if email != "" {
+		destination = email
+	}
+	if err := otp.Verify(ctx, db, ResetPurpose, destination, code); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?`,
+		string(hash), userID)
+	if err != nil {
+		return err
+	}
+	if err := EndSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+	e.UserID, e.Action, e.EntityType, e.EntityID = userID, "password.reset", "user", userID
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EndSessions signs the user out everywhere
+func EndSessions(ctx context.Context, tx *sql.Tx, userID string) error {
+	for _, q := range []string{
+		`DELETE FROM sessions WHERE user_id = ?`,
+		`DELETE FROM two_factor_sessions WHERE user_id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RequestPhoneChange sends a code to phone to prove the user owns it
+func RequestPhoneChange(ctx context.Context, db *sql.DB, sms otp.Sender, userID, phone string) error {
+	if err := checkPhone(ctx, db, userID, phone); err != nil {
+		return err
+	}
+	return otp.Issue(ctx, db, sms, PhonePurpose, phone, phoneMessage)
+}
+
+// ChangePhone checks the code sent by RequestPhoneChange and makes phone the
+// user's number. Phone numbers identify members in M-Pesa payments, so the
+// change is audited and the old number is told about it.
+func ChangePhone(ctx context.Context, db *sql.DB, sms otp.Sender, userID, phone, code string, e audit.Entry) error {
+	if err := checkPhone(ctx, db, userID, phone); err != nil {
+		return err
+	}
+	if err := otp.Verify(ctx, db, PhonePurpose, phone, code); err != nil {
+		return err
+	}
+
+	var old string
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(phone_number, '') FROM users WHERE user_id = ?`, userID).Scan(&old)
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET phone_number = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?`,
+		otp.Normalize(phone), userID)
+	if err != nil {
+		return err
+	}
+	e.UserID, e.Action, e.EntityType, e.EntityID = userID, "user.phone_change", "user", userID
+	e.OldValues = map[string]string{"phone": old}
+	e.NewValues = map[string]string{"phone": otp.Normalize(phone)}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if old != "" {
+		if err := sms.Send(ctx, old, phoneChangedNotice); err != nil {
+			slog.ErrorContext(ctx, "Failed to notify previous phone number", "user_id", userID, "error", err)
+		}
+	}
+	return nil
+}
+
+// checkPhone rejects the user's current number and numbers used by other accounts
+func checkPhone(ctx context.Context, db *sql.DB, userID, phone string) error {
+	owner, err := findUser(ctx, db, "", phone)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		return err
+	case owner == userID:
+		return ErrSamePhone
+	default:
+		return ErrPhoneTaken
+	}
+}
+
+// SetBirthday records the user's date of birth (YYYY-MM-DD), for birthday
+// greetings, or forgets it when date is empty
+func SetBirthday(ctx context.Context, db *sql.DB, userID, date string, e audit.Entry) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET date_of_birth = NULLIF(?, ''), updated_at = CURRENT_TIMESTAMP WHERE user_id = ?`, date, userID)
+	if err != nil {
+		return err
+	}
+	// The date itself is personal data and stays out of the audit log
+	e.UserID, e.Action, e.EntityType, e.EntityID = userID, "user.birthday_change", "user", userID
+	e.NewValues = map[string]bool{"shared": date != ""}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,207 @@
+package account
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/otp"
+	"tujifund-app/backend/validation"
+)
+
+// ForgotPasswordHandler sends a reset code to {"email"} or {"phone"}. It always
+// answers 202 so it cannot be used to find out who has an account.
+func ForgotPasswordHandler(db *sql.DB, mailer, sms otp.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Email string `json:"email"`
+			Phone string `json:"phone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		validateIdentifier(v, request.Email, request.Phone)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		err := RequestReset(r.Context(), db, mailer, sms, request.Email, request.Phone)
+		if err != nil && !errors.Is(err, otp.ErrTooSoon) {
+			http.Error(w, "Failed to send reset code", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"message": i18n.T(i18n.FromRequest(r), "auth.reset_sent", nil)})
+	}
+}
+
+// ResetPasswordHandler sets a new password with the code from ForgotPasswordHandler
+func ResetPasswordHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Email    string `json:"email"`
+			Phone    string `json:"phone"`
+			Code     string `json:"code"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		validateIdentifier(v, request.Email, request.Phone)
+		v.Required("code", request.Code)
+		if v.Required("password", request.Password) {
+			v.MinLength("password", request.Password, MinPasswordLength)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		err := ResetPassword(r.Context(), db, request.Email, request.Phone, request.Code, request.Password, audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": i18n.T(i18n.FromRequest(r), "auth.password_reset", nil)})
+	}
+}
+
+// PhoneChangeHandler sends a confirmation code to the caller's new {"phone"}
+func PhoneChangeHandler(db *sql.DB, sms otp.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Phone string `json:"phone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("phone", request.Phone) {
+			v.Phone("phone", request.Phone)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		if !writeError(w, RequestPhoneChange(r.Context(), db, sms, userID, request.Phone)) {
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// ConfirmPhoneHandler completes a phone number change with {"phone", "code"}
+func ConfirmPhoneHandler(db *sql.DB, sms otp.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Phone string `json:"phone"`
+			Code  string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("phone", request.Phone) {
+			v.Phone("phone", request.Phone)
+		}
+		v.Required("code", request.Code)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		err := ChangePhone(r.Context(), db, sms, userID, request.Phone, request.Code, audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"phone": otp.Normalize(request.Phone)})
+	}
+}
+
+// validateIdentifier requires exactly one of email and phone
+func validateIdentifier(v *validation.Validator, email, phone string) {
+	switch {
+	case email != "":
+		v.Email("email", email)
+	case phone != "":
+		v.Phone("phone", phone)
+	default:
+		v.Required("email", email)
+	}
+}
+
+// writeError writes err as a response and reports whether the handler may continue
+func writeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, otp.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, otp.ErrTooSoon), errors.Is(err, otp.ErrAttempts):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, breaker.ErrUnavailable):
+		breaker.WriteError(w, err)
+	case errors.Is(err, ErrPhoneTaken), errors.Is(err, ErrSamePhone):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// BirthdayHandler sets the caller's {"dateOfBirth"} (YYYY-MM-DD), or clears
+// it when empty. It is optional and only used for birthday greetings.
+func BirthdayHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			DateOfBirth string `json:"dateOfBirth"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.DateOfBirth != "" {
+			v := validation.New()
+			v.Date("dateOfBirth", request.DateOfBirth)
+			if !v.Valid() {
+				validation.WriteErrors(w, r, v.Errors())
+				return
+			}
+		}
+
+		if err := SetBirthday(r.Context(), db, userID, request.DateOfBirth, audit.FromRequest(r, audit.Entry{})); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
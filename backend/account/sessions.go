@@ -0,0 +1,209 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/database"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrWrongPassword is returned when the current password does not match
+var ErrWrongPassword = errors.New("current password is incorrect")
+
+// DeviceName guesses a readable device name, such as "Chrome on Android",
+// from a User-Agent header
+func DeviceName(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	platform := ""
+	for _, p := range []struct{ match, name string }{
+		{"android", "Android"},
+		{"iphone", "iPhone"},
+		{"ipad", "iPad"},
+		{"windows", "Windows"},
+		{"mac os", "Mac"},
+		{"linux", "Linux"},
+	} {
+		if strings.Contains(ua, p.match) {
+			platform = p.name
+			break
+		}
+	}
+	browser := ""
+	for _, b := range []struct{ match, name string }{
+		{"edg/", "Edge"},
+		{"opr/", "Opera"},
+		{"chrome/", "Chrome"},
+		{"firefox/", "Firefox"},
+		{"safari/", "Safari"},
+		{"okhttp", "TujiFund app"},
+	} {
+		if strings.Contains(ua, b.match) {
+			browser = b.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
+}
+
+// ChangePassword sets a new password after checking the current one, then
+// signs the user out everywhere
+func ChangePassword(ctx context.Context, db *sql.DB, userID, current, password string, e audit.Entry) error {
+	var hash sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE user_id = ?`, userID).Scan(&hash)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(current)) != nil {
+		return ErrWrongPassword
+	}
+	newHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?`,
+		string(newHash), userID)
+	if err != nil {
+		return err
+	}
+	if err := EndSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+	e.Action, e.EntityType, e.EntityID = "password.change", "user", userID
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SessionsHandler lists the caller's signed-in devices
+func SessionsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		sessions, err := database.ListSessions(r.Context(), db, userID, r.Header.Get("X-Session-ID"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+	}
+}
+
+// RevokeSessionHandler signs out the caller's {sessionId} session
+func RevokeSessionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		err := database.RevokeSession(r.Context(), db, userID, mux.Vars(r)["sessionId"])
+		if err == sql.ErrNoRows {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RevokeOtherSessionsHandler signs out every session except the one making the request
+func RevokeOtherSessionsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		n, err := database.RevokeOtherSessions(r.Context(), db, userID, r.Header.Get("X-Session-ID"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"revoked": n})
+	}
+}
+
+// LogoutHandler ends the session making the request
+func LogoutHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := database.DeleteSession(db, r.Header.Get("X-Session-ID")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ChangePasswordHandler changes the caller's password and signs them out everywhere
+func ChangePasswordHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			CurrentPassword string `json:"currentPassword"`
+			NewPassword     string `json:"newPassword"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("currentPassword", request.CurrentPassword)
+		if v.Required("newPassword", request.NewPassword) {
+			v.MinLength("newPassword", request.NewPassword, MinPasswordLength)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		err := ChangePassword(r.Context(), db, userID, request.CurrentPassword, request.NewPassword, audit.FromRequest(r, audit.Entry{}))
+		if errors.Is(err, ErrWrongPassword) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
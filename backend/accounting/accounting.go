@@ -0,0 +1,256 @@
+// Package accounting exports a chama's ledger as journals that QuickBooks and
+// Xero can import, so chamas that keep formal books can bring each month's
+// transactions across. Every ledger entry becomes a balanced journal between
+// the fund it moved money in or out of and an account chosen by its type.
+package accounting
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+)
+
+// Account types, named as in QuickBooks' IIF account list
+const (
+	AccountBank   = "BANK"
+	AccountAsset  = "OASSET"
+	AccountEquity = "EQUITY"
+	AccountIncome = "INC"
+	AccountCost   = "EXP"
+)
+
+// AccountTypes lists every account type
+var AccountTypes = []string{AccountBank, AccountAsset, AccountEquity, AccountIncome, AccountCost}
+
+// Kinds of mapping key
+const (
+	KindFund  = "fund"
+	KindEntry = "entry_type"
+)
+
+// ErrUnknownKey is returned when updating a mapping for a key the chama does not have
+var ErrUnknownKey = errors.New("no fund or entry type with this key")
+
+// Account is where a fund or entry type is booked in the chama's accounting
+// package. Key is "fund:<account id>" or "entry_type:<type>".
+type Account struct {
+	Key    string `json:"key"`
+	Kind   string `json:"kind"`
+	Label  string `json:"label"` // the fund's name or the entry type
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Custom bool   `json:"custom"` // set by the chama rather than a default
+}
+
+// defaults are the accounts entry types are booked to until a chama maps
+// them. Money paid out to members reduces the same equity it was paid into.
+var defaults = map[string]Account{
+	ledger.TypeOpeningBalance:   {Code: "3000", Name: "Opening Balance Equity", Type: AccountEquity},
+	ledger.TypeContribution:     {Code: "3100", Name: "Member Contributions", Type: AccountEquity},
+	ledger.TypeWithdrawal:       {Code: "3100", Name: "Member Contributions", Type: AccountEquity},
+	ledger.TypeShareCapital:     {Code: "3200", Name: "Share Capital", Type: AccountEquity},
+	ledger.TypeDistribution:     {Code: "3300", Name: "Member Distributions", Type: AccountEquity},
+	ledger.TypeAdjustment:       {Code: "3900", Name: "Adjustments", Type: AccountEquity},
+	ledger.TypeLoanDisbursement: {Code: "1200", Name: "Loans to Members", Type: AccountAsset},
+	ledger.TypeLoanRepayment:    {Code: "1200", Name: "Loans to Members", Type: AccountAsset},
+	ledger.TypeInvestment:       {Code: "1500", Name: "Investments", Type: AccountAsset},
+	ledger.TypeTransfer:         {Code: "1900", Name: "Fund Transfers", Type: AccountAsset},
+	ledger.TypeInterChama:       {Code: "1950", Name: "Inter-Chama Transfers", Type: AccountAsset},
+	ledger.TypeFine:             {Code: "4100", Name: "Fines", Type: AccountIncome},
+	ledger.TypeInterest:         {Code: "4200", Name: "Interest Income", Type: AccountIncome},
+	ledger.TypeLoanInterest:     {Code: "4200", Name: "Interest Income", Type: AccountIncome},
+	ledger.TypeIncome:           {Code: "4900", Name: "Other Income", Type: AccountIncome},
+	ledger.TypeExpense:          {Code: "5000", Name: "Operating Expenses", Type: AccountCost},
+	ledger.TypeSavingsInterest:  {Code: "5200", Name: "Interest Paid to Members", Type: AccountCost},
+}
+
+// Accounts returns the chama's mapping for each of its funds and every entry
+// type, with the chama's own choices in place of the defaults. Funds default
+// to bank accounts 1010, 1020... in the order they were opened.
+func Accounts(ctx context.Context, db *sql.DB, chamaID string) ([]Account, error) {
+	custom := map[string]Account{}
+	rows, err := db.QueryContext(ctx, `
+		SELECT source_key, account_code, account_name, account_type FROM accounting_accounts WHERE chama_id = ?`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var a Account
+		if err := rows.Scan(&a.Key, &a.Code, &a.Name, &a.Type); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		custom[a.Key] = a
+	}
+	rows.Close()
+
+	rows, err = db.QueryContext(ctx, `SELECT id, name FROM chama_accounts WHERE chama_id = ? ORDER BY created_at, id`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	var list []Account
+	for n := 1; rows.Next(); n++ {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, Account{
+			Key: KindFund + ":" + id, Kind: KindFund, Label: name,
+			Code: fmt.Sprintf("%d", 1000+10*n), Name: name, Type: AccountBank,
+		})
+	}
+	rows.Close()
+	for _, t := range ledger.Types {
+		a := defaults[t]
+		a.Key, a.Kind, a.Label = KindEntry+":"+t, KindEntry, t
+		list = append(list, a)
+	}
+
+	for i, a := range list {
+		if c, ok := custom[a.Key]; ok {
+			list[i].Code, list[i].Name, list[i].Type, list[i].Custom = c.Code, c.Name, c.Type, true
+		}
+	}
+	return list, nil
+}
+
+// SetAccounts saves the chama's own mapping for the given keys. An account
+// with no name goes back to the default; one with no type keeps its type.
+func SetAccounts(ctx context.Context, db *sql.DB, chamaID string, accounts []Account) error {
+	current, err := Accounts(ctx, db, chamaID)
+	if err != nil {
+		return err
+	}
+	known := map[string]Account{}
+	for _, a := range current {
+		known[a.Key] = a
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, a := range accounts {
+		was, ok := known[a.Key]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownKey, a.Key)
+		}
+		if a.Type == "" {
+			a.Type = was.Type
+		}
+		if strings.TrimSpace(a.Name) == "" {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM accounting_accounts WHERE chama_id = ? AND source_key = ?`,
+				chamaID, a.Key); err != nil {
+				return err
+			}
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO accounting_accounts (chama_id, source_key, account_code, account_name, account_type)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (chama_id, source_key) DO UPDATE SET
+				account_code = excluded.account_code, account_name = excluded.account_name,
+				account_type = excluded.account_type, updated_at = CURRENT_TIMESTAMP`,
+			chamaID, a.Key, strings.TrimSpace(a.Code), strings.TrimSpace(a.Name), a.Type)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Line is one side of a journal. Positive amounts are debits and negative
+// amounts credits, so the lines of a journal add up to zero.
+type Line struct {
+	Account Account
+	Amount  money.Money
+}
+
+// Journal is one ledger entry as a double entry transaction
+type Journal struct {
+	EntryID   string
+	Date      time.Time
+	Type      string
+	Member    string // the member's name, if any
+	Reference string
+	Memo      string
+	Lines     []Line
+}
+
+// Journals turns the chama's ledger entries between from and to into journals
+// using its account mapping. Money into a fund debits the fund and credits the
+// entry type's account, or the account a mapping hook books the entry to;
+// money out does the opposite.
+func Journals(ctx context.Context, db *sql.DB, chamaID string, from, to time.Time) ([]Journal, error) {
+	accounts, err := Accounts(ctx, db, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	byKey := map[string]Account{}
+	for _, a := range accounts {
+		byKey[a.Key] = a
+	}
+
+	entries, err := ledger.List(ctx, db, ledger.Filter{ChamaID: chamaID, From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+	names, err := memberNames(ctx, db, chamaID)
+	if err != nil {
+		return nil, err
+	}
+
+	var journals []Journal
+	for _, e := range entries {
+		if e.Amount.IsZero() {
+			continue
+		}
+		memo := e.Description
+		if memo == "" {
+			memo = strings.ReplaceAll(e.Type, "_", " ")
+		}
+		booked, ok := hooked(ctx, db, e)
+		if !ok {
+			booked = byKey[KindEntry+":"+e.Type]
+		}
+		journals = append(journals, Journal{
+			EntryID: e.ID, Date: e.EffectiveAt, Type: e.Type, Member: names[e.MemberID], Reference: e.Reference, Memo: memo,
+			Lines: []Line{
+				{Account: byKey[KindFund+":"+e.AccountID], Amount: e.Amount},
+				{Account: booked, Amount: e.Amount.Negate()},
+			},
+		})
+	}
+	return journals, nil
+}
+
+// memberNames maps everyone with entries in the chama's ledger, including
+// members who have left, to their name
+func memberNames(ctx context.Context, db *sql.DB, chamaID string) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT u.user_id, TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, ''))
+		FROM users u WHERE u.user_id IN (SELECT DISTINCT member_id FROM ledger_entries WHERE chama_id = ?)`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[string]string{}
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
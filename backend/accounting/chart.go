@@ -0,0 +1,227 @@
+package accounting
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/ledger"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrAccountNotFound is returned for a chart account that does not exist
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrDuplicateCode is returned when adding an account with a code already in the chart
+	ErrDuplicateCode = errors.New("an account with this code already exists")
+	// ErrHasPostings is returned when deleting an account that entries are booked to
+	ErrHasPostings = errors.New("account has postings and cannot be deleted")
+)
+
+// ChartAccount is an account in the chart of accounts. Built-in accounts are
+// those entry types are booked to by default; the rest are added by platform
+// staff for every chama, or by a chama for itself.
+type ChartAccount struct {
+	ID          string    `json:"id,omitempty"`
+	ChamaID     string    `json:"chamaId,omitempty"` // empty for built-in and platform accounts
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Description string    `json:"description,omitempty"`
+	BuiltIn     bool      `json:"builtIn"`
+	CreatedBy   string    `json:"createdBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt,omitempty"`
+}
+
+// Mapping is a hook that books some ledger entries to an account other than
+// the one mapped to their type, for instance expenses by category. Account is
+// asked about each entry when the books are exported and the first hook to
+// answer wins. Posted reports whether any of a chama's entries are booked to
+// the account with code, or any chama's when chamaID is empty, so that the
+// account is not deleted from under them.
+type Mapping struct {
+	Account func(ctx context.Context, db *sql.DB, e ledger.Entry) (Account, bool)
+	Posted  func(ctx context.Context, db *sql.DB, chamaID, code string) (bool, error)
+}
+
+// Mappings are the registered mapping hooks, consulted in order
+var Mappings []Mapping
+
+// Chart returns the accounts a chama can book to: the built-in accounts,
+// those added by platform staff and the chama's own, by code. An empty
+// chamaID returns the platform's chart.
+func Chart(ctx context.Context, db *sql.DB, chamaID string) ([]ChartAccount, error) {
+	seen := map[string]bool{}
+	var list []ChartAccount
+	for _, a := range defaults {
+		if !seen[a.Code] {
+			seen[a.Code] = true
+			list = append(list, ChartAccount{Code: a.Code, Name: a.Name, Type: a.Type, BuiltIn: true})
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, COALESCE(chama_id, ''), code, name, account_type, COALESCE(description, ''), created_by, created_at
+		FROM chart_accounts WHERE chama_id IS NULL OR chama_id = ?`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a ChartAccount
+		if err := rows.Scan(&a.ID, &a.ChamaID, &a.Code, &a.Name, &a.Type, &a.Description, &a.CreatedBy,
+			&a.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list, nil
+}
+
+// AddAccount adds an account to a chama's chart, or to every chama's when
+// a.ChamaID is empty. Codes must be unique within the chart.
+func AddAccount(ctx context.Context, db *sql.DB, a ChartAccount, e audit.Entry) (ChartAccount, error) {
+	a.Code, a.Name, a.Description = strings.TrimSpace(a.Code), strings.TrimSpace(a.Name), strings.TrimSpace(a.Description)
+	chart, err := Chart(ctx, db, a.ChamaID)
+	if err != nil {
+		return a, err
+	}
+	for _, c := range chart {
+		if strings.EqualFold(c.Code, a.Code) {
+			return a, ErrDuplicateCode
+		}
+	}
+	if a.ChamaID == "" {
+		// A platform account must not clash with any chama's own
+		var n int
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM chart_accounts WHERE LOWER(code) = LOWER(?)`,
+			a.Code).Scan(&n); err != nil {
+			return a, err
+		}
+		if n > 0 {
+			return a, ErrDuplicateCode
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback()
+
+	a.ID, a.CreatedBy, a.CreatedAt = uuid.NewString(), e.UserID, time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chart_accounts (id, chama_id, code, name, account_type, description, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, nullIfEmpty(a.ChamaID), a.Code, a.Name, a.Type, nullIfEmpty(a.Description), a.CreatedBy)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return a, ErrDuplicateCode
+		}
+		return a, err
+	}
+	e.Action, e.EntityType, e.EntityID = "chart_account.create", "chart_account", a.ID
+	e.NewValues = a
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return a, err
+	}
+	return a, tx.Commit()
+}
+
+// DeleteAccount removes an account a chama added, or a platform account when
+// chamaID is empty. Accounts that entries are booked to are kept.
+func DeleteAccount(ctx context.Context, db *sql.DB, chamaID, id string, e audit.Entry) error {
+	var a ChartAccount
+	err := db.QueryRowContext(ctx, `
+		SELECT id, COALESCE(chama_id, ''), code, name, account_type FROM chart_accounts WHERE id = ?`, id).
+		Scan(&a.ID, &a.ChamaID, &a.Code, &a.Name, &a.Type)
+	if err == sql.ErrNoRows || (err == nil && a.ChamaID != chamaID) {
+		return ErrAccountNotFound
+	}
+	if err != nil {
+		return err
+	}
+	posted, err := Posted(ctx, db, a.ChamaID, a.Code)
+	if err != nil {
+		return err
+	}
+	if posted {
+		return ErrHasPostings
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM chart_accounts WHERE id = ?`, id); err != nil {
+		return err
+	}
+	e.Action, e.EntityType, e.EntityID = "chart_account.delete", "chart_account", id
+	e.OldValues = a
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Posted reports whether any of a chama's ledger entries, or any chama's
+// when chamaID is empty, are booked to the account with code, either
+// through the chama's account mapping or a mapping hook
+func Posted(ctx context.Context, db *sql.DB, chamaID, code string) (bool, error) {
+	query := `
+		SELECT COUNT(*) FROM accounting_accounts m
+		WHERE LOWER(m.account_code) = LOWER(?) AND EXISTS (
+			SELECT 1 FROM ledger_entries l WHERE l.chama_id = m.chama_id
+			AND (m.source_key = 'fund:' || l.account_id OR m.source_key = 'entry_type:' || l.entry_type))`
+	args := []interface{}{code}
+	if chamaID != "" {
+		query += ` AND m.chama_id = ?`
+		args = append(args, chamaID)
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return true, nil
+	}
+	for _, m := range Mappings {
+		if m.Posted == nil {
+			continue
+		}
+		if posted, err := m.Posted(ctx, db, chamaID, code); err != nil || posted {
+			return posted, err
+		}
+	}
+	return false, nil
+}
+
+// hooked returns the account a mapping hook books e to, if any
+func hooked(ctx context.Context, db *sql.DB, e ledger.Entry) (Account, bool) {
+	for _, m := range Mappings {
+		if m.Account == nil {
+			continue
+		}
+		if a, ok := m.Account(ctx, db, e); ok {
+			return a, true
+		}
+	}
+	return Account{}, false
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,154 @@
+package accounting
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"tujifund-app/backend/money"
+)
+
+// Export formats
+const (
+	FormatIIF        = "iif"  // QuickBooks Desktop import file
+	FormatQuickBooks = "qbo"  // QuickBooks Online journal entry CSV
+	FormatXero       = "xero" // Xero manual journal CSV
+)
+
+// Formats lists every export format
+var Formats = []string{FormatIIF, FormatQuickBooks, FormatXero}
+
+// ErrUnknownFormat is returned for an export format that is not in Formats
+var ErrUnknownFormat = errors.New("unknown accounting export format")
+
+// FileType returns the content type and file extension of format
+func FileType(format string) (contentType, ext string) {
+	if format == FormatIIF {
+		return "text/plain; charset=utf-8", "iif"
+	}
+	return "text/csv; charset=utf-8", "csv"
+}
+
+// Write writes journals in format. prefix starts each journal number, e.g.
+// the month being exported, so numbers stay unique across exports.
+func Write(w io.Writer, format, prefix string, journals []Journal) error {
+	switch format {
+	case FormatIIF:
+		return writeIIF(w, journals)
+	case FormatQuickBooks:
+		return writeQuickBooks(w, prefix, journals)
+	case FormatXero:
+		return writeXero(w, prefix, journals)
+	}
+	return ErrUnknownFormat
+}
+
+// writeIIF writes the accounts used followed by one general journal per
+// entry. Member names go in the memo since IIF names must already exist in
+// the company file.
+func writeIIF(w io.Writer, journals []Journal) error {
+	var b strings.Builder
+	row := func(cells ...string) {
+		for i, c := range cells {
+			cells[i] = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(c)
+		}
+		b.WriteString(strings.Join(cells, "\t") + "\r\n")
+	}
+
+	row("!ACCNT", "NAME", "ACCNTTYPE", "ACCNUM")
+	for _, a := range used(journals) {
+		row("ACCNT", a.Name, a.Type, a.Code)
+	}
+	row("!TRNS", "TRNSID", "TRNSTYPE", "DATE", "ACCNT", "NAME", "AMOUNT", "DOCNUM", "MEMO")
+	row("!SPL", "SPLID", "TRNSTYPE", "DATE", "ACCNT", "NAME", "AMOUNT", "DOCNUM", "MEMO")
+	row("!ENDTRNS")
+	for _, j := range journals {
+		date := j.Date.Format("01/02/2006")
+		for i, l := range j.Lines {
+			kind := "SPL"
+			if i == 0 {
+				kind = "TRNS"
+			}
+			row(kind, "", "GENERAL JOURNAL", date, l.Account.Name, "", decimal(l.Amount), truncate(j.Reference, 11), memo(j))
+		}
+		row("ENDTRNS")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeQuickBooks writes the columns of QuickBooks Online's journal entry import
+func writeQuickBooks(w io.Writer, prefix string, journals []Journal) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Journal No", "Journal Date", "Account", "Debits", "Credits", "Description", "Name"})
+	for n, j := range journals {
+		for _, l := range j.Lines {
+			debit, credit := decimal(l.Amount), ""
+			if l.Amount.IsNegative() {
+				debit, credit = "", decimal(l.Amount.Negate())
+			}
+			cw.Write([]string{journalNo(prefix, n), j.Date.Format("02/01/2006"), l.Account.Name, debit, credit, memo(j), ""})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeXero writes the columns of Xero's manual journal import. Xero groups
+// lines into journals by narration and date, so each narration starts with
+// the journal number.
+func writeXero(w io.Writer, prefix string, journals []Journal) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"*Narration", "*Date", "Description", "*AccountCode", "*TaxRate", "*Amount", "TrackingName1", "TrackingOption1"})
+	for n, j := range journals {
+		narration := journalNo(prefix, n) + " " + memo(j)
+		for _, l := range j.Lines {
+			cw.Write([]string{narration, j.Date.Format("02/01/2006"), j.Reference, l.Account.Code, "Tax Exempt", decimal(l.Amount), "", ""})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// used lists the accounts journals post to, sorted by code
+func used(journals []Journal) []Account {
+	seen := map[string]Account{}
+	for _, j := range journals {
+		for _, l := range j.Lines {
+			seen[l.Account.Name] = l.Account
+		}
+	}
+	list := make([]Account, 0, len(seen))
+	for _, a := range seen {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].Code+list[i].Name < list[k].Code+list[k].Name })
+	return list
+}
+
+func journalNo(prefix string, n int) string {
+	return fmt.Sprintf("%s-%04d", prefix, n+1)
+}
+
+func memo(j Journal) string {
+	if j.Member == "" {
+		return j.Memo
+	}
+	return j.Memo + " - " + j.Member
+}
+
+// decimal formats m in major units without thousands separators, as
+// accounting imports expect
+func decimal(m money.Money) string {
+	return strings.ReplaceAll(m.Decimal(), ",", "")
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,382 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/flags"
+	"tujifund-app/backend/reports"
+	"tujifund-app/backend/validation"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// managerRoles may change the chama's account mapping
+var managerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// Export is a record of books exported for a period
+type Export struct {
+	ID         string    `json:"id"`
+	Format     string    `json:"format"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Journals   int       `json:"journals"`
+	ExportedBy string    `json:"exportedBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Exports returns the chama's exports, newest first
+func Exports(ctx context.Context, db *sql.DB, chamaID string) ([]Export, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, format, period_start, period_end, journal_count, exported_by, created_at
+		FROM accounting_exports WHERE chama_id = ? ORDER BY created_at DESC`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Export{}
+	for rows.Next() {
+		var e Export
+		if err := rows.Scan(&e.ID, &e.Format, &e.From, &e.To, &e.Journals, &e.ExportedBy, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// AccountsHandler returns the {chamaId} chama's account mapping
+func AccountsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := Accounts(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// SetAccountsHandler maps the {chamaId} chama's funds and entry types to
+// accounts in its accounting package. The body is a list of {key, code, name,
+// type}; a blank name restores the default.
+func SetAccountsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var accounts []Account
+		if err := json.NewDecoder(r.Body).Decode(&accounts); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		for i, a := range accounts {
+			v.Required(fmt.Sprintf("accounts[%d].key", i), a.Key)
+			if a.Type != "" {
+				v.OneOf(fmt.Sprintf("accounts[%d].type", i), a.Type, AccountTypes...)
+			}
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		old, _ := Accounts(r.Context(), db, chamaID)
+		err := SetAccounts(r.Context(), db, chamaID, accounts)
+		if errors.Is(err, ErrUnknownKey) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		list, err := Accounts(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "accounting.map_accounts", EntityType: "chama", EntityID: chamaID,
+			OldValues: old, NewValues: list,
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit account mapping", "chama_id", chamaID, "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// ExportHandler downloads the {chamaId} chama's books for a period as
+// ?format=iif (QuickBooks Desktop), qbo (QuickBooks Online) or xero. The
+// period is ?month=YYYY-MM, or ?from= and ?to= dates, and defaults to last month.
+func ExportHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		q := r.URL.Query()
+		format := q.Get("format")
+		v := validation.New()
+		if v.Required("format", format) {
+			v.OneOf("format", format, Formats...)
+		}
+		p, err := period(q.Get("month"), q.Get("from"), q.Get("to"))
+		if err != nil {
+			v.Add("month", validation.CodeDate, nil)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		journals, err := Journals(r.Context(), db, chamaID, p.From, p.To)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if err := Write(&buf, format, p.From.Format("200601"), journals); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = db.ExecContext(r.Context(), `
+			INSERT INTO accounting_exports (id, chama_id, format, period_start, period_end, journal_count, exported_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			uuid.NewString(), chamaID, format, p.From, p.To, len(journals), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to record accounting export", "chama_id", chamaID, "error", err)
+		}
+
+		contentType, ext := FileType(format)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="books-%s-%s.%s"`,
+			p.From.Format("20060102"), p.To.AddDate(0, 0, -1).Format("20060102"), ext))
+		w.Write(buf.Bytes())
+	}
+}
+
+// ExportsHandler lists the {chamaId} chama's past exports, so officials can
+// see which months have been taken across
+func ExportsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := Exports(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// ChartHandler returns the {chamaId} chama's chart of accounts
+func ChartHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		writeChart(w, r, db, chamaID)
+	}
+}
+
+// AddChartAccountHandler adds an account of the {chamaId} chama's own to its
+// chart, for chamas with custom charts of accounts turned on. The body is
+// {code, name, type, description}.
+func AddChartAccountHandler(db *sql.DB, featureFlags *flags.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, managerRoles...) ||
+			!featureFlags.Enabled(r.Context(), flags.CustomChartOfAccounts, flags.Subject{ChamaID: chamaID}) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		addChartAccount(w, r, db, chamaID, userID)
+	}
+}
+
+// DeleteChartAccountHandler removes an account the {chamaId} chama added, if
+// nothing is booked to it
+func DeleteChartAccountHandler(db *sql.DB, featureFlags *flags.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, managerRoles...) ||
+			!featureFlags.Enabled(r.Context(), flags.CustomChartOfAccounts, flags.Subject{ChamaID: chamaID}) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		deleteChartAccount(w, r, db, chamaID, userID)
+	}
+}
+
+// AdminChartHandler returns the platform's chart of accounts, offered to every chama
+func AdminChartHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeChart(w, r, db, "")
+	}
+}
+
+// AdminAddChartAccountHandler adds an account to every chama's chart. The
+// body is {code, name, type, description}.
+func AdminAddChartAccountHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		addChartAccount(w, r, db, "", userID)
+	}
+}
+
+// AdminDeleteChartAccountHandler removes a platform account that no chama
+// has entries booked to
+func AdminDeleteChartAccountHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		deleteChartAccount(w, r, db, "", userID)
+	}
+}
+
+func writeChart(w http.ResponseWriter, r *http.Request, db *sql.DB, chamaID string) {
+	list, err := Chart(r.Context(), db, chamaID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func addChartAccount(w http.ResponseWriter, r *http.Request, db *sql.DB, chamaID, userID string) {
+	var a ChartAccount
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	v := validation.New()
+	v.Required("code", strings.TrimSpace(a.Code))
+	v.Required("name", strings.TrimSpace(a.Name))
+	if v.Required("type", a.Type) {
+		v.OneOf("type", a.Type, AccountTypes...)
+	}
+	if !v.Valid() {
+		validation.WriteErrors(w, r, v.Errors())
+		return
+	}
+
+	a = ChartAccount{ChamaID: chamaID, Code: a.Code, Name: a.Name, Type: a.Type, Description: a.Description}
+	a, err := AddAccount(r.Context(), db, a, audit.FromRequest(r, audit.Entry{UserID: userID}))
+	if err != nil {
+		writeChartError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+func deleteChartAccount(w http.ResponseWriter, r *http.Request, db *sql.DB, chamaID, userID string) {
+	err := DeleteAccount(r.Context(), db, chamaID, mux.Vars(r)["accountId"], audit.FromRequest(r, audit.Entry{UserID: userID}))
+	if err != nil {
+		writeChartError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeChartError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrAccountNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrDuplicateCode), errors.Is(err, ErrHasPostings):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// period reads an export period. to is inclusive, so it runs to the end of that day.
+func period(month, from, to string) (reports.Period, error) {
+	switch {
+	case month != "":
+		t, err := time.Parse("2006-01", month)
+		if err != nil {
+			return reports.Period{}, err
+		}
+		return reports.Month(t), nil
+	case from != "" || to != "":
+		f, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return reports.Period{}, err
+		}
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil || t.Before(f) {
+			return reports.Period{}, errors.New("invalid period")
+		}
+		return reports.Period{From: f, To: t.AddDate(0, 0, 1)}, nil
+	}
+	return reports.Month(time.Now().UTC().AddDate(0, -1, 0)), nil
+}
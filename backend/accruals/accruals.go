@@ -0,0 +1,261 @@
+package accruals
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/loans"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/rules"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of accrual
+const (
+	KindLoan    = "loan"
+	KindSavings = "savings"
+)
+
+// Accrual frequencies
+const (
+	Daily   = "daily"
+	Monthly = "monthly"
+)
+
+// Accrual is interest accrued on one loan or one member's savings for a period
+type Accrual struct {
+	ID        string      `json:"id"`
+	ChamaID   string      `json:"chamaId"`
+	Kind      string      `json:"kind"`
+	SourceID  string      `json:"sourceId"` // the loan, or the member for savings
+	MemberID  string      `json:"memberId"`
+	Period    string      `json:"period"`
+	Basis     money.Money `json:"basis"`
+	RateBps   int64       `json:"rateBps"`
+	Days      int         `json:"days"`
+	Amount    money.Money `json:"amount"`
+	AccountID string      `json:"accountId,omitempty"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+// Period is a half-open range [From, To) that interest is accrued for. Key
+// names it, YYYY-MM-DD for a day and YYYY-MM for a month.
+type Period struct {
+	Key  string
+	From time.Time
+	To   time.Time
+}
+
+// Days is the length of p in days
+func (p Period) Days() int {
+	return int(p.To.Sub(p.From).Hours() / 24)
+}
+
+// LastPeriod returns the most recent complete period before now
+func LastPeriod(frequency string, now time.Time) Period {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if frequency == Daily {
+		from := today.AddDate(0, 0, -1)
+		return Period{Key: from.Format("2006-01-02"), From: from, To: today}
+	}
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -1, 0)
+	return Period{Key: from.Format("2006-01"), From: from, To: to}
+}
+
+// Accrue posts interest for the chama's last complete period under its rules:
+// on each running loan at the loan's rate, and on each member's savings at the
+// chama's savings rate. Each loan and member is accrued at most once per
+// period, so it is safe to run again. Accruals that cannot be posted, for
+// example because a fund's rules do not allow it, are logged and skipped.
+func Accrue(ctx context.Context, db *sql.DB, chamaID string, now time.Time) ([]Accrual, error) {
+	r, err := rules.Current(ctx, db, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	p := LastPeriod(r.InterestAccrual, now)
+
+	running, err := loans.Running(ctx, db, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Accrual
+	for _, l := range running {
+		from := p.From
+		if l.DisbursedAt.After(from) {
+			from = l.DisbursedAt
+		}
+		if !from.Before(p.To) {
+			continue
+		}
+		basis := l.Principal
+		if l.InterestType == loans.InterestReducing {
+			basis = l.Outstanding()
+		}
+		account, err := loans.Fund(ctx, db, l)
+		if err != nil {
+			slog.ErrorContext(ctx, "No fund to accrue loan interest to", "loan_id", l.ID, "error", err)
+			continue
+		}
+		days := int(p.To.Sub(from).Hours() / 24)
+		pending = append(pending, Accrual{
+			Kind: KindLoan, SourceID: l.ID, MemberID: l.BorrowerID, Basis: basis, RateBps: l.InterestRateBps,
+			Days: days, Amount: money.New(loans.Interest(basis.Amount, l.InterestRateBps, int64(days)), basis.Currency),
+			AccountID: account,
+		})
+	}
+
+	if r.SavingsInterestRateBps > 0 {
+		savings, err := savingsAccruals(ctx, db, chamaID, p, r.SavingsInterestRateBps)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, savings...)
+	}
+
+	var posted []Accrual
+	for _, a := range pending {
+		a.ChamaID, a.Period = chamaID, p.Key
+		ok, err := post(ctx, db, a, p)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to post interest accrual", "chama_id", chamaID, "kind", a.Kind,
+				"source_id", a.SourceID, "period", p.Key, "error", err)
+			continue
+		}
+		if ok {
+			posted = append(posted, a)
+		}
+	}
+	return posted, nil
+}
+
+// savingsAccruals works out interest on each member's savings balance at the
+// end of p
+func savingsAccruals(ctx context.Context, db *sql.DB, chamaID string, p Period, rateBps int64) ([]Accrual, error) {
+	var account string
+	err := db.QueryRowContext(ctx, `
+		SELECT id FROM chama_accounts
+		WHERE chama_id = ? AND status = 'active'
+		  AND (allowed_debits IS NULL OR allowed_debits = '' OR ',' || allowed_debits || ',' LIKE ?)
+		ORDER BY account_type = 'savings' DESC, created_at LIMIT 1`,
+		chamaID, "%,"+ledger.TypeInterest+",%").Scan(&account)
+	if err == sql.ErrNoRows {
+		slog.WarnContext(ctx, "No fund may pay savings interest", "chama_id", chamaID)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT member_id, currency, SUM(amount_minor) FROM ledger_entries
+		WHERE chama_id = ? AND member_id IS NOT NULL AND entry_type IN (?, ?, ?, ?, ?) AND effective_at < ?
+		GROUP BY member_id, currency HAVING SUM(amount_minor) > 0`,
+		chamaID, ledger.TypeContribution, ledger.TypeOpeningBalance, ledger.TypeSavingsInterest, ledger.TypeDistribution,
+		ledger.TypeWithdrawal,
+		p.To.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Accrual
+	for rows.Next() {
+		a := Accrual{Kind: KindSavings, RateBps: rateBps, Days: p.Days(), AccountID: account}
+		if err := rows.Scan(&a.MemberID, &a.Basis.Currency, &a.Basis.Amount); err != nil {
+			return nil, err
+		}
+		a.SourceID = a.MemberID
+		a.Amount = money.New(loans.Interest(a.Basis.Amount, rateBps, int64(a.Days)), a.Basis.Currency)
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// post records a and posts it to the ledger, reporting false if it was
+// already accrued. Accrued interest is not cash, so each accrual is a pair
+// of entries that leave the fund's balance unchanged: loan interest is
+// income to the chama and is added to the borrower's loan, and savings
+// interest is paid out of the chama's interest income onto the member's savings.
+func post(ctx context.Context, db *sql.DB, a Accrual, p Period) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer txn.Rollback(tx)
+
+	a.ID = uuid.NewString()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO interest_accruals
+		(id, chama_id, kind, source_id, member_id, period, period_start, period_end, basis_minor, rate_bps, days,
+		 amount_minor, currency, account_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (kind, source_id, period) DO NOTHING`,
+		a.ID, a.ChamaID, a.Kind, a.SourceID, a.MemberID, a.Period, p.From.Format("2006-01-02 15:04:05"),
+		p.To.Format("2006-01-02 15:04:05"), a.Basis.Amount, a.RateBps, a.Days, a.Amount.Amount, a.Amount.Currency, a.AccountID)
+	if err != nil {
+		return false, fmt.Errorf("failed to record accrual: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if !a.Amount.IsZero() {
+		legs := []ledger.Entry{
+			{Type: ledger.TypeInterest, Amount: a.Amount, Description: "Loan interest accrued for " + a.Period},
+			{Type: ledger.TypeLoanInterest, Amount: a.Amount.Negate(), MemberID: a.MemberID,
+				Description: "Interest for " + a.Period},
+		}
+		if a.Kind == KindSavings {
+			legs = []ledger.Entry{
+				{Type: ledger.TypeSavingsInterest, Amount: a.Amount, MemberID: a.MemberID,
+					Description: "Savings interest for " + a.Period},
+				{Type: ledger.TypeInterest, Amount: a.Amount.Negate(), Description: "Savings interest paid for " + a.Period},
+			}
+		}
+		for _, e := range legs {
+			e.ChamaID, e.AccountID, e.Reference, e.EffectiveAt = a.ChamaID, a.AccountID, a.ID, p.To
+			if _, err := ledger.Post(ctx, tx, e); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, txn.Commit(tx)
+}
+
+// RegisterAccrualJob accrues interest for every active chama each night.
+// Chamas that accrue monthly are posted on the first run of the month and
+// skipped after.
+func RegisterAccrualJob(s *jobs.Scheduler, db *sql.DB) {
+	s.Register("interest_accrual", jobs.Daily{Hour: 1}, func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `SELECT id FROM chamas WHERE status = 'active'`)
+		if err != nil {
+			return err
+		}
+		var chamaIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			chamaIDs = append(chamaIDs, id)
+		}
+		rows.Close()
+
+		now := time.Now().UTC()
+		for _, id := range chamaIDs {
+			if _, err := Accrue(ctx, db, id, now); err != nil {
+				slog.ErrorContext(ctx, "Failed to accrue interest", "chama_id", id, "error", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,107 @@
+package accruals
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestLastPeriod(t *testing.T) {
+	tests := []struct {
+		frequency string
+		now       time.Time
+		want      Period
+		days      int
+	}{
+		{Monthly, time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC),
+			Period{"2026-09", time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}, 30},
+		{Monthly, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			Period{"2025-12", time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}, 31},
+		{Daily, time.Date(2026, 3, 1, 1, 0, 0, 0, time.UTC),
+			Period{"2026-02-28", time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}, 1},
+	}
+	for _, tt := range tests {
+		got := LastPeriod(tt.frequency, tt.now)
+		if got.Key != tt.want.Key || !got.From.Equal(tt.want.From) || !got.To.Equal(tt.want.To) {
+			t.Errorf("LastPeriod(%s, %s) = %+v, want %+v", tt.frequency, tt.now, got, tt.want)
+		}
+		if got.Days() != tt.days {
+			t.Errorf("LastPeriod(%s, %s) is %d days, want %d", tt.frequency, tt.now, got.Days(), tt.days)
+		}
+	}
+}
+
+func TestAccrue(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", "file:"+t.TempDir()+"/accruals.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	schema, err := os.ReadFile("../database/database_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		string(schema),
+		`INSERT INTO users (user_id, username, email) VALUES ('u1', 'u1', 'u1@example.com')`,
+		`INSERT INTO chamas (id, name, type, created_by) VALUES ('c1', 'Chama', 'savings', 'u1')`,
+		`INSERT INTO chama_accounts (id, chama_id, name, account_type) VALUES ('a1', 'c1', 'Loans', 'loans')`,
+		// flat on the whole principal, reducing on what is still owed, and
+		// one disbursed after the first period
+		`INSERT INTO loans (id, application_id, chama_id, borrower_id, loan_product_id, principal_minor, interest_rate_bps,
+			interest_type, term, disbursement_date, total_repaid_minor, status) VALUES
+			('l1', 'x', 'c1', 'u1', 'p', 100000, 1200, 'flat', 180, '2026-08-10 00:00:00', 40000, 'active'),
+			('l2', 'x', 'c1', 'u1', 'p', 100000, 1200, 'reducing', 180, '2026-09-21 00:00:00', 40000, 'active'),
+			('l3', 'x', 'c1', 'u1', 'p', 100000, 1200, 'flat', 180, '2026-10-05 00:00:00', 0, 'active'),
+			('l4', 'x', 'c1', 'u1', 'p', 100000, 1200, 'flat', 180, '2026-08-10 00:00:00', 100000, 'completed')`,
+	} {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want map[string]int64 // accrued by loan
+	}{
+		{"september", time.Date(2026, 10, 16, 1, 0, 0, 0, time.UTC), map[string]int64{"l1": 986, "l2": 197}},
+		{"september again", time.Date(2026, 10, 17, 1, 0, 0, 0, time.UTC), map[string]int64{}},
+		{"october", time.Date(2026, 11, 1, 1, 0, 0, 0, time.UTC), map[string]int64{"l1": 1019, "l2": 612, "l3": 888}},
+	}
+	for _, tt := range tests {
+		posted, err := Accrue(ctx, db, "c1", tt.now)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := map[string]int64{}
+		for _, a := range posted {
+			got[a.SourceID] = a.Amount.Amount
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: accrued %v, want %v", tt.name, got, tt.want)
+		}
+		for id, amount := range tt.want {
+			if got[id] != amount {
+				t.Errorf("%s: accrued %d on %s, want %d", tt.name, got[id], id, amount)
+			}
+		}
+	}
+
+	// One accrual per loan per period, each posted as a balanced pair
+	var accruals, entries, balance int64
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM interest_accruals`).Scan(&accruals); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(amount_minor), 0) FROM ledger_entries`).Scan(&entries, &balance); err != nil {
+		t.Fatal(err)
+	}
+	if accruals != 5 || entries != 10 || balance != 0 {
+		t.Errorf("%d accruals posted as %d entries totalling %d, want 5 as 10 totalling 0", accruals, entries, balance)
+	}
+}
@@ -0,0 +1,125 @@
+package accruals
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/money"
+
+	"github.com/gorilla/mux"
+)
+
+// Report is a chama's accrued interest over a date range, with totals
+type Report struct {
+	ChamaID         string      `json:"chamaId"`
+	From            string      `json:"from"`
+	To              string      `json:"to"`
+	Accruals        []Accrual   `json:"accruals"`
+	LoanInterest    money.Money `json:"loanInterest"`
+	SavingsInterest money.Money `json:"savingsInterest"`
+}
+
+// BuildReport lists the accruals for periods starting in [from, to), optionally of one kind
+func BuildReport(ctx context.Context, db *sql.DB, chamaID, kind string, from, to time.Time) (Report, error) {
+	currency, err := money.ChamaCurrency(db, chamaID)
+	if err != nil {
+		return Report{}, err
+	}
+	rep := Report{
+		ChamaID:         chamaID,
+		From:            from.Format("2006-01-02"),
+		To:              to.AddDate(0, 0, -1).Format("2006-01-02"),
+		Accruals:        []Accrual{},
+		LoanInterest:    money.New(0, currency),
+		SavingsInterest: money.New(0, currency),
+	}
+
+	query := `
+		SELECT id, chama_id, kind, source_id, member_id, period, basis_minor, rate_bps, days, amount_minor, currency,
+		       COALESCE(account_id, ''), created_at
+		FROM interest_accruals
+		WHERE chama_id = ? AND period_start >= ? AND period_start < ?`
+	args := []interface{}{chamaID, from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05")}
+	if kind != "" {
+		query += ` AND kind = ?`
+		args = append(args, kind)
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY period_start, kind, member_id`, args...)
+	if err != nil {
+		return rep, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Accrual
+		if err := rows.Scan(&a.ID, &a.ChamaID, &a.Kind, &a.SourceID, &a.MemberID, &a.Period, &a.Basis.Amount,
+			&a.RateBps, &a.Days, &a.Amount.Amount, &a.Amount.Currency, &a.AccountID, &a.CreatedAt); err != nil {
+			return rep, err
+		}
+		a.Basis.Currency = a.Amount.Currency
+		rep.Accruals = append(rep.Accruals, a)
+
+		total := &rep.LoanInterest
+		if a.Kind == KindSavings {
+			total = &rep.SavingsInterest
+		}
+		if *total, err = total.Add(a.Amount); err != nil {
+			return rep, err
+		}
+	}
+	return rep, rows.Err()
+}
+
+// ReportHandler returns the {chamaId} chama's accrual report for ?from= and
+// ?to= (YYYY-MM-DD, inclusive; the current year by default), optionally only
+// one ?kind= (loan or savings). Officials only.
+func ReportHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		q := r.URL.Query()
+		now := time.Now().UTC()
+		from := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		to := from.AddDate(1, 0, 0)
+		var err error
+		if v := q.Get("from"); v != "" {
+			if from, err = time.Parse("2006-01-02", v); err != nil {
+				http.Error(w, "from must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if to, err = time.Parse("2006-01-02", v); err != nil {
+				http.Error(w, "to must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+			to = to.AddDate(0, 0, 1)
+		}
+		kind := q.Get("kind")
+		if kind != "" && kind != KindLoan && kind != KindSavings {
+			http.Error(w, "kind must be loan or savings", http.StatusBadRequest)
+			return
+		}
+
+		rep, err := BuildReport(r.Context(), db, chamaID, kind, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep)
+	}
+}
@@ -0,0 +1,276 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/account"
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/money"
+)
+
+// RoleAdmin is the users.role value for platform staff
+const RoleAdmin = "admin"
+
+var (
+	ErrSelf         = errors.New("you cannot suspend your own account")
+	ErrSuspended    = errors.New("account is already suspended")
+	ErrNotSuspended = errors.New("account is not suspended")
+)
+
+// User is a platform user as seen by staff
+type User struct {
+	UserID           string    `json:"userId"`
+	Username         string    `json:"username"`
+	Name             string    `json:"name"`
+	Email            string    `json:"email"`
+	Phone            string    `json:"phone,omitempty"`
+	Role             string    `json:"role"`
+	Verified         bool      `json:"verified"`
+	SuspendedAt      string    `json:"suspendedAt,omitempty"`
+	SuspensionReason string    `json:"suspensionReason,omitempty"`
+	Chamas           int       `json:"chamas"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// Chama is a chama as seen by staff
+type Chama struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	Type      string      `json:"type"`
+	CreatedBy string      `json:"createdBy"`
+	Members   int         `json:"members"`
+	Balance   money.Money `json:"balance"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+// Metrics is a snapshot of platform activity
+type Metrics struct {
+	Users               int           `json:"users"`
+	NewUsers7d          int           `json:"newUsers7d"`
+	SuspendedUsers      int           `json:"suspendedUsers"`
+	ActiveSessions      int           `json:"activeSessions"`
+	Chamas              int           `json:"chamas"`
+	ActiveMembers       int           `json:"activeMembers"`
+	LedgerEntries24h    int           `json:"ledgerEntries24h"`
+	PendingJoinRequests int           `json:"pendingJoinRequests"`
+	FailedCallbacks     int           `json:"failedCallbacks"`
+	FailedJobs24h       int           `json:"failedJobs24h"`
+	Balances            []money.Money `json:"balances"` // funds held in chama accounts, per currency
+	GeneratedAt         time.Time     `json:"generatedAt"`
+}
+
+// IsAdmin reports whether the user is platform staff
+func IsAdmin(db *sql.DB, userID string) bool {
+	var role string
+	err := db.QueryRow(`SELECT role FROM users WHERE user_id = ?`, userID).Scan(&role)
+	return err == nil && role == RoleAdmin
+}
+
+// IsSuspended reports whether the user's account is suspended
+func IsSuspended(db *sql.DB, userID string) (bool, error) {
+	var suspended bool
+	err := db.QueryRow(`SELECT suspended_at IS NOT NULL FROM users WHERE user_id = ?`, userID).Scan(&suspended)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return suspended, err
+}
+
+// likePattern escapes q for use in a LIKE ... ESCAPE '\' pattern
+func likePattern(q string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(q)) + "%"
+}
+
+// SearchUsers finds users whose name, username, email or phone contains q.
+// With suspendedOnly set only suspended accounts are returned.
+func SearchUsers(ctx context.Context, db *sql.DB, q string, suspendedOnly bool, limit int) ([]User, error) {
+	query := `
+		SELECT u.user_id, u.username, TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')),
+		       u.email, COALESCE(u.phone_number, ''), u.role, COALESCE(u.is_verified, 0),
+		       COALESCE(u.suspended_at, ''), COALESCE(u.suspension_reason, ''),
+		       (SELECT COUNT(*) FROM chama_members m WHERE m.user_id = u.user_id AND m.status = 'active'),
+		       u.created_at
+		FROM users u WHERE 1 = 1`
+	var args []interface{}
+	if q != "" {
+		query += ` AND (lower(u.username) LIKE ? ESCAPE '\' OR lower(u.email) LIKE ? ESCAPE '\'
+			OR u.phone_number LIKE ? ESCAPE '\'
+			OR lower(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')) LIKE ? ESCAPE '\')`
+		p := likePattern(q)
+		args = append(args, p, p, p, p)
+	}
+	if suspendedOnly {
+		query += ` AND u.suspended_at IS NOT NULL`
+	}
+	query += ` ORDER BY u.created_at DESC LIMIT ?`
+	args = append(args, clampLimit(limit))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.UserID, &u.Username, &u.Name, &u.Email, &u.Phone, &u.Role, &u.Verified,
+			&u.SuspendedAt, &u.SuspensionReason, &u.Chamas, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SearchChamas finds chamas whose name contains q
+func SearchChamas(ctx context.Context, db *sql.DB, q string, limit int) ([]Chama, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.id, c.name, c.type, c.created_by, c.currency,
+		       (SELECT COUNT(*) FROM chama_members m WHERE m.chama_id = c.id AND m.status = 'active'),
+		       (SELECT COALESCE(SUM(a.balance_minor), 0) FROM chama_accounts a WHERE a.chama_id = c.id),
+		       c.created_at
+		FROM chamas c
+		WHERE lower(c.name) LIKE ? ESCAPE '\'
+		ORDER BY c.created_at DESC LIMIT ?`,
+		likePattern(q), clampLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chamas := []Chama{}
+	for rows.Next() {
+		var c Chama
+		if err := rows.Scan(&c.ID, &c.Name, &c.Type, &c.CreatedBy, &c.Balance.Currency, &c.Members,
+			&c.Balance.Amount, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		chamas = append(chamas, c)
+	}
+	return chamas, rows.Err()
+}
+
+// GetMetrics collects platform-wide counts
+func GetMetrics(ctx context.Context, db *sql.DB) (Metrics, error) {
+	now := time.Now().UTC()
+	day := now.Add(-24 * time.Hour).Format("2006-01-02 15:04:05")
+	m := Metrics{GeneratedAt: now}
+
+	counts := []struct {
+		dest  *int
+		query string
+		args  []interface{}
+	}{
+		{&m.Users, `SELECT COUNT(*) FROM users`, nil},
+		{&m.NewUsers7d, `SELECT COUNT(*) FROM users WHERE created_at >= ?`, []interface{}{now.AddDate(0, 0, -7).Format("2006-01-02 15:04:05")}},
+		{&m.SuspendedUsers, `SELECT COUNT(*) FROM users WHERE suspended_at IS NOT NULL`, nil},
+		{&m.ActiveSessions, `SELECT COUNT(*) FROM sessions WHERE expires_at > ?`, []interface{}{now.Format("2006-01-02 15:04:05")}},
+		{&m.Chamas, `SELECT COUNT(*) FROM chamas`, nil},
+		{&m.ActiveMembers, `SELECT COUNT(*) FROM chama_members WHERE status = 'active'`, nil},
+		{&m.LedgerEntries24h, `SELECT COUNT(*) FROM ledger_entries WHERE created_at >= ?`, []interface{}{day}},
+		{&m.PendingJoinRequests, `SELECT COUNT(*) FROM join_requests WHERE status = 'pending_approval'`, nil},
+		{&m.FailedCallbacks, `SELECT COUNT(*) FROM payment_callbacks WHERE status = 'failed'`, nil},
+		{&m.FailedJobs24h, `SELECT COUNT(*) FROM job_runs WHERE status = 'failed' AND started_at >= ?`, []interface{}{day}},
+	}
+	for _, c := range counts {
+		if err := db.QueryRowContext(ctx, c.query, c.args...).Scan(c.dest); err != nil {
+			return m, err
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT currency, SUM(balance_minor) FROM chama_accounts GROUP BY currency ORDER BY currency`)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+	m.Balances = []money.Money{}
+	for rows.Next() {
+		var b money.Money
+		if err := rows.Scan(&b.Currency, &b.Amount); err != nil {
+			return m, err
+		}
+		m.Balances = append(m.Balances, b)
+	}
+	return m, rows.Err()
+}
+
+// Suspend blocks a user from signing in and ends their sessions
+func Suspend(ctx context.Context, db *sql.DB, userID, reason string, e audit.Entry) error {
+	if userID == e.UserID {
+		return ErrSelf
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users SET suspended_at = CURRENT_TIMESTAMP, suspension_reason = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND suspended_at IS NULL`, reason, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return notFoundOr(ctx, tx, userID, ErrSuspended)
+	}
+	if err := account.EndSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+	e.Action, e.EntityType, e.EntityID = "user.suspend", "user", userID
+	e.NewValues = map[string]string{"reason": reason}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Unsuspend lets a suspended user sign in again
+func Unsuspend(ctx context.Context, db *sql.DB, userID string, e audit.Entry) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users SET suspended_at = NULL, suspension_reason = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND suspended_at IS NOT NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return notFoundOr(ctx, tx, userID, ErrNotSuspended)
+	}
+	e.Action, e.EntityType, e.EntityID = "user.unsuspend", "user", userID
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// notFoundOr returns sql.ErrNoRows if the user does not exist, otherwise err
+func notFoundOr(ctx context.Context, tx *sql.Tx, userID string, err error) error {
+	var exists bool
+	if qerr := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE user_id = ?)`, userID).Scan(&exists); qerr != nil {
+		return qerr
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+func clampLimit(limit int) int {
+	if limit <= 0 || limit > 200 {
+		return 50
+	}
+	return limit
+}
@@ -0,0 +1,205 @@
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/payments"
+
+	"github.com/gorilla/mux"
+)
+
+// Require restricts next to platform staff and records every call in the
+// audit log as "admin.<action>", so staff activity can itself be reviewed.
+// It must run inside the session middleware.
+func Require(db *sql.DB, action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		if !IsAdmin(db, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		err := audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			Action:     "admin." + action,
+			EntityType: "admin",
+			NewValues: map[string]interface{}{
+				"method": r.Method,
+				"path":   r.URL.Path,
+				"query":  r.URL.RawQuery,
+				"vars":   mux.Vars(r),
+			},
+		}))
+		if err != nil {
+			// Staff actions must not go unrecorded
+			slog.ErrorContext(r.Context(), "Failed to audit admin request", "action", action, "error", err)
+			http.Error(w, "Failed to record admin action", http.StatusInternalServerError)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// UsersHandler searches users by ?q=, optionally only ?suspended=true ones
+func UsersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		users, err := SearchUsers(r.Context(), db, q.Get("q"), q.Get("suspended") == "true", limit(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	}
+}
+
+// ChamasHandler searches chamas by ?q=
+func ChamasHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamas, err := SearchChamas(r.Context(), db, r.URL.Query().Get("q"), limit(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(chamas)
+	}
+}
+
+// MetricsHandler returns platform-wide counts
+func MetricsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, err := GetMetrics(r.Context(), db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	}
+}
+
+// SuspendHandler suspends the {userId} account with {"reason"}
+func SuspendHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+			http.Error(w, "A reason is required to suspend an account", http.StatusBadRequest)
+			return
+		}
+		err := Suspend(r.Context(), db, mux.Vars(r)["userId"], request.Reason, audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// UnsuspendHandler lifts the suspension on the {userId} account
+func UnsuspendHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := Unsuspend(r.Context(), db, mux.Vars(r)["userId"], audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CallbacksHandler lists payment provider callbacks, filtered by ?provider= and ?status=
+func CallbacksHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		list, err := payments.List(r.Context(), db, q.Get("provider"), q.Get("status"), limit(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// RetryCallbackHandler processes the {callbackId} payment callback again
+func RetryCallbackHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := payments.Process(r.Context(), db, mux.Vars(r)["callbackId"])
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// AuditLogsHandler searches the audit log by ?userId=, ?action= (prefix),
+// ?entityType=, ?entityId=, ?from= and ?to= (YYYY-MM-DD)
+func AuditLogsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		f := audit.Filter{
+			UserID:     q.Get("userId"),
+			Action:     q.Get("action"),
+			EntityType: q.Get("entityType"),
+			EntityID:   q.Get("entityId"),
+			Limit:      limit(r),
+		}
+		var err error
+		if v := q.Get("from"); v != "" {
+			if f.Since, err = time.Parse("2006-01-02", v); err != nil {
+				http.Error(w, "from must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if f.Until, err = time.Parse("2006-01-02", v); err != nil {
+				http.Error(w, "to must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+			f.Until = f.Until.AddDate(0, 0, 1)
+		}
+
+		entries, err := audit.Search(r.Context(), db, f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// limit reads ?limit=, leaving defaults to the query functions
+func limit(r *http.Request) int {
+	n, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	return n
+}
+
+// writeError writes err as a response and reports whether the handler may continue
+func writeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, ErrSelf):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrSuspended), errors.Is(err, ErrNotSuspended), errors.Is(err, payments.ErrProcessed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package analytics
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/chamas"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultMonths is how many months of history are shown unless ?months= says otherwise
+const DefaultMonths = 12
+
+func monthsParam(r *http.Request) int {
+	if n, err := strconv.Atoi(r.URL.Query().Get("months")); err == nil && n > 0 && n <= 60 {
+		return n
+	}
+	return DefaultMonths
+}
+
+// ChamaHandler returns the {chamaId} chama's health with its history, for
+// its officials
+func ChamaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		rep, err := BuildReport(r.Context(), db, chamaID, monthsParam(r), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep)
+	}
+}
+
+// PlatformHandler returns health across all chamas by month. Wrap it in
+// admin.Require.
+func PlatformHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := Platform(r.Context(), db, monthsParam(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
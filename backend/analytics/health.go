@@ -0,0 +1,396 @@
+// Package analytics tracks how healthy each chama is: how much of what is
+// due gets collected, how much of the loan book has defaulted, how many
+// members leave, and how many turn up to meetings. The indicators are kept
+// month by month, with a 0-100 health score combining them, so officials can
+// see their trend. Platform staff see them only aggregated across chamas.
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/dashboard"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/readonly"
+	"tujifund-app/backend/rules"
+)
+
+// Weights of each indicator in the health score. An indicator that does not
+// apply, e.g. loan defaults in a chama that has never lent, is left out and
+// the others count for more.
+var (
+	WeightCollection = 40
+	WeightDefaults   = 25
+	WeightRetention  = 15
+	WeightAttendance = 20
+)
+
+// Health is a chama's indicators for one month. Rates are in basis points
+// of 100%; a nil rate means the indicator did not apply that month.
+type Health struct {
+	ChamaID           string    `json:"chamaId"`
+	Period            string    `json:"period"` // YYYY-MM
+	ExpectedMinor     int64     `json:"expectedMinor"`
+	CollectedMinor    int64     `json:"collectedMinor"` // counting each member up to what they owed
+	CollectionRateBps *int64    `json:"collectionRateBps"`
+	LoanBookMinor     int64     `json:"loanBookMinor"` // outstanding principal at the month's close
+	DefaultedMinor    int64     `json:"defaultedMinor"`
+	DefaultRatioBps   *int64    `json:"defaultRatioBps"`
+	MembersAtStart    int       `json:"membersAtStart"`
+	MembersJoined     int       `json:"membersJoined"`
+	MembersLeft       int       `json:"membersLeft"`
+	ChurnBps          *int64    `json:"churnBps"`
+	Meetings          int       `json:"meetings"`
+	AttendanceRateBps *int64    `json:"attendanceRateBps"`
+	Score             *int      `json:"score"` // nil when no indicator applied
+	Final             bool      `json:"final"` // false while the month is still running
+	ComputedAt        time.Time `json:"computedAt"`
+}
+
+// rate returns part as basis points of whole, or nil when whole is zero
+func rate(part, whole int64) *int64 {
+	if whole <= 0 {
+		return nil
+	}
+	bps := part * 10000 / whole
+	return &bps
+}
+
+// score combines the indicators that apply into 0-100, or nil when none do
+func (h Health) score() *int {
+	var total, weights int64
+	add := func(bps *int64, weight int, good bool) {
+		if bps == nil {
+			return
+		}
+		v := min(max(*bps, 0), 10000)
+		if !good {
+			v = 10000 - v
+		}
+		total += v * int64(weight)
+		weights += int64(weight)
+	}
+	add(h.CollectionRateBps, WeightCollection, true)
+	add(h.DefaultRatioBps, WeightDefaults, false)
+	add(h.ChurnBps, WeightRetention, false)
+	add(h.AttendanceRateBps, WeightAttendance, true)
+	if weights == 0 {
+		return nil
+	}
+	score := int((total/weights + 50) / 100)
+	return &score
+}
+
+// monthOf returns the month [start, end) containing t
+func monthOf(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// Compute works out a chama's indicators for the month containing at, as of
+// now. Loan figures are the loan book as it stands now, so they describe the
+// month's close only when computed just after it.
+func Compute(ctx context.Context, db *sql.DB, chamaID string, at, now time.Time) (Health, error) {
+	start, end := monthOf(at)
+	h := Health{ChamaID: chamaID, Period: start.Format("2006-01"), Final: !now.Before(end), ComputedAt: now.UTC()}
+	if err := collection(ctx, db, &h, start, end); err != nil {
+		return h, fmt.Errorf("collection: %w", err)
+	}
+	if err := defaults(ctx, db, &h); err != nil {
+		return h, fmt.Errorf("defaults: %w", err)
+	}
+	if err := churn(ctx, db, &h, start, end); err != nil {
+		return h, fmt.Errorf("churn: %w", err)
+	}
+	if err := attendance(ctx, db, &h, start, end); err != nil {
+		return h, fmt.Errorf("attendance: %w", err)
+	}
+	h.Score = h.score()
+	return h, nil
+}
+
+// collection compares what members paid in the month with what fell due,
+// one contribution for each cycle due in the month from each member, under
+// the rules in force at the month's close
+func collection(ctx context.Context, db *sql.DB, h *Health, start, end time.Time) error {
+	r, _, err := rules.At(ctx, db, h.ChamaID, end.Add(-time.Second))
+	if err != nil {
+		return err
+	}
+	cycles := int64(0)
+	for t := start; t.Before(end); {
+		c := dashboard.CycleAt(r, t)
+		if !c.Due.Before(start) && c.Due.Before(end) {
+			cycles++
+		}
+		t = c.End
+	}
+	owed := r.ContributionAmountMinor * cycles
+	if owed <= 0 {
+		return nil
+	}
+
+	// Members who were in the chama during the month: still active, or
+	// leaving no earlier than its start
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.user_id, COALESCE(SUM(d.amount_minor), 0)
+		FROM chama_members m
+		LEFT JOIN contribution_days d ON d.chama_id = m.chama_id AND d.member_id = m.user_id
+			AND d.day >= ? AND d.day < ?
+		WHERE m.chama_id = ? AND m.join_date < ? AND (m.status = 'active' OR EXISTS (
+			SELECT 1 FROM member_exits x WHERE x.chama_id = m.chama_id AND x.member_id = m.user_id
+			  AND x.status IN ('approved', 'paid', 'closed') AND x.effective_date >= ?))
+		GROUP BY m.user_id`,
+		start.Format("2006-01-02"), end.Format("2006-01-02"), h.ChamaID, end.Format("2006-01-02 15:04:05"),
+		start.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var memberID string
+		var paid int64
+		if err := rows.Scan(&memberID, &paid); err != nil {
+			return err
+		}
+		h.ExpectedMinor += owed
+		h.CollectedMinor += min(paid, owed)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	h.CollectionRateBps = rate(h.CollectedMinor, h.ExpectedMinor)
+	return nil
+}
+
+// defaults is the share of the outstanding loan book that has defaulted
+func defaults(ctx context.Context, db *sql.DB, h *Health) error {
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(principal_minor - total_repaid_minor), 0),
+		       COALESCE(SUM(CASE WHEN status = 'defaulted' THEN principal_minor - total_repaid_minor END), 0)
+		FROM loans
+		WHERE chama_id = ? AND status IN ('active', 'defaulted') AND deleted_at IS NULL`,
+		h.ChamaID).Scan(&h.LoanBookMinor, &h.DefaultedMinor)
+	if err != nil {
+		return err
+	}
+	h.DefaultRatioBps = rate(h.DefaultedMinor, h.LoanBookMinor)
+	return nil
+}
+
+// churn is the share of the members at the start of the month who left in it
+func churn(ctx context.Context, db *sql.DB, h *Health, start, end time.Time) error {
+	from, to := start.Format("2006-01-02"), end.Format("2006-01-02")
+	err := db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM chama_members WHERE chama_id = ? AND join_date < ?)
+			- (SELECT COUNT(*) FROM member_exits WHERE chama_id = ? AND status IN ('approved', 'paid', 'closed') AND effective_date < ?),
+			(SELECT COUNT(*) FROM chama_members WHERE chama_id = ? AND join_date >= ? AND join_date < ?),
+			(SELECT COUNT(*) FROM member_exits WHERE chama_id = ? AND status IN ('approved', 'paid', 'closed')
+			   AND effective_date >= ? AND effective_date < ?)`,
+		h.ChamaID, start.Format("2006-01-02 15:04:05"), h.ChamaID, from,
+		h.ChamaID, start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"),
+		h.ChamaID, from, to,
+	).Scan(&h.MembersAtStart, &h.MembersJoined, &h.MembersLeft)
+	if err != nil {
+		return err
+	}
+	h.ChurnBps = rate(int64(h.MembersLeft), int64(h.MembersAtStart))
+	return nil
+}
+
+// attendance is the share of invited members present at the month's
+// completed meetings. Excused absences count as absences.
+func attendance(ctx context.Context, db *sql.DB, h *Health, start, end time.Time) error {
+	var present, invited int64
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT m.id),
+		       COALESCE(SUM(CASE WHEN a.attendance_status = 'present' THEN 1 ELSE 0 END), 0),
+		       COUNT(a.id)
+		FROM meetings m
+		LEFT JOIN meeting_attendance a ON a.meeting_id = m.id AND a.attendance_status != 'pending'
+		WHERE m.chama_id = ? AND m.status = 'completed' AND m.meeting_date >= ? AND m.meeting_date < ?`,
+		h.ChamaID, start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"),
+	).Scan(&h.Meetings, &present, &invited)
+	if err != nil {
+		return err
+	}
+	h.AttendanceRateBps = rate(present, invited)
+	return nil
+}
+
+// save stores h, replacing the month's earlier figures
+func save(ctx context.Context, db *sql.DB, h Health) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO chama_health (chama_id, period, expected_minor, collected_minor, collection_rate_bps,
+			loan_book_minor, defaulted_minor, default_ratio_bps, members_at_start, members_joined, members_left,
+			churn_bps, meetings, attendance_rate_bps, score, final, computed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(chama_id, period) DO UPDATE SET
+			expected_minor = excluded.expected_minor, collected_minor = excluded.collected_minor,
+			collection_rate_bps = excluded.collection_rate_bps, loan_book_minor = excluded.loan_book_minor,
+			defaulted_minor = excluded.defaulted_minor, default_ratio_bps = excluded.default_ratio_bps,
+			members_at_start = excluded.members_at_start, members_joined = excluded.members_joined,
+			members_left = excluded.members_left, churn_bps = excluded.churn_bps, meetings = excluded.meetings,
+			attendance_rate_bps = excluded.attendance_rate_bps, score = excluded.score, final = excluded.final,
+			computed_at = excluded.computed_at`,
+		h.ChamaID, h.Period, h.ExpectedMinor, h.CollectedMinor, h.CollectionRateBps,
+		h.LoanBookMinor, h.DefaultedMinor, h.DefaultRatioBps, h.MembersAtStart, h.MembersJoined, h.MembersLeft,
+		h.ChurnBps, h.Meetings, h.AttendanceRateBps, h.Score, h.Final, h.ComputedAt.Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// Refresh computes and stores a chama's figures for the current month and,
+// unless they are already final, the previous month's
+func Refresh(ctx context.Context, db *sql.DB, chamaID string, now time.Time) error {
+	start, _ := monthOf(now)
+	previous := start.AddDate(0, -1, 0)
+	var final bool
+	err := db.QueryRowContext(ctx, `SELECT final FROM chama_health WHERE chama_id = ? AND period = ?`,
+		chamaID, previous.Format("2006-01")).Scan(&final)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	months := []time.Time{now}
+	if !final {
+		months = append(months, previous)
+	}
+	for _, at := range months {
+		h, err := Compute(ctx, db, chamaID, at, now)
+		if err != nil {
+			return err
+		}
+		if err := save(ctx, db, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const columns = `chama_id, period, expected_minor, collected_minor, collection_rate_bps, loan_book_minor,
+	defaulted_minor, default_ratio_bps, members_at_start, members_joined, members_left, churn_bps, meetings,
+	attendance_rate_bps, score, final, computed_at`
+
+func scan(rows *sql.Rows) (Health, error) {
+	var h Health
+	var collection, defaults, churn, attendance, score sql.NullInt64
+	err := rows.Scan(&h.ChamaID, &h.Period, &h.ExpectedMinor, &h.CollectedMinor, &collection, &h.LoanBookMinor,
+		&h.DefaultedMinor, &defaults, &h.MembersAtStart, &h.MembersJoined, &h.MembersLeft, &churn, &h.Meetings,
+		&attendance, &score, &h.Final, &h.ComputedAt)
+	h.CollectionRateBps, h.DefaultRatioBps = nullable(collection), nullable(defaults)
+	h.ChurnBps, h.AttendanceRateBps = nullable(churn), nullable(attendance)
+	if score.Valid {
+		n := int(score.Int64)
+		h.Score = &n
+	}
+	return h, err
+}
+
+func nullable(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int64
+}
+
+// History returns a chama's last months of figures, oldest first
+func History(ctx context.Context, db *sql.DB, chamaID string, months int) ([]Health, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+columns+` FROM (
+			SELECT `+columns+` FROM chama_health WHERE chama_id = ? ORDER BY period DESC LIMIT ?
+		) h ORDER BY period`, chamaID, months)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Health{}
+	for rows.Next() {
+		h, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, h)
+	}
+	return list, rows.Err()
+}
+
+// Report is a chama's health for its officials
+type Report struct {
+	Current Health   `json:"current"`
+	History []Health `json:"history"` // oldest first, ending with Current
+	// CollectionTrendBps is the change in collection rate from the previous
+	// month, and nil without a rate for both months
+	CollectionTrendBps *int64 `json:"collectionTrendBps"`
+	// ScoreTrend is likewise the change in score
+	ScoreTrend *int `json:"scoreTrend"`
+}
+
+// BuildReport returns a chama's current month, computed afresh, with the
+// months before it. In read-only maintenance mode the current month is as
+// last computed.
+func BuildReport(ctx context.Context, db *sql.DB, chamaID string, months int, now time.Time) (Report, error) {
+	var rep Report
+	if !readonly.Enabled() {
+		if err := Refresh(ctx, db, chamaID, now); err != nil {
+			return rep, err
+		}
+	}
+	history, err := History(ctx, db, chamaID, months)
+	if err != nil {
+		return rep, err
+	}
+	rep.History = history
+	if len(history) == 0 {
+		return rep, nil
+	}
+	rep.Current = history[len(history)-1]
+	if len(history) > 1 {
+		prev := history[len(history)-2]
+		if rep.Current.Score != nil && prev.Score != nil {
+			trend := *rep.Current.Score - *prev.Score
+			rep.ScoreTrend = &trend
+		}
+		if rep.Current.CollectionRateBps != nil && prev.CollectionRateBps != nil {
+			trend := *rep.Current.CollectionRateBps - *prev.CollectionRateBps
+			rep.CollectionTrendBps = &trend
+		}
+	}
+	return rep, nil
+}
+
+// RegisterJob refreshes every active chama's figures nightly, so each month
+// is finalised just after it closes
+func RegisterJob(s *jobs.Scheduler, db *sql.DB) {
+	s.Register("chama_health", jobs.Daily{Hour: 2}, func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `SELECT id FROM chamas WHERE status = 'active'`)
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, id := range ids {
+			if err := Refresh(ctx, db, id, now); err != nil {
+				slog.ErrorContext(ctx, "Failed to compute chama health", "chama_id", id, "error", err)
+			}
+		}
+		return nil
+	})
+}
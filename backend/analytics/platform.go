@@ -0,0 +1,74 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+)
+
+// MinChamas is the fewest chamas a month's platform figures are given for,
+// so no single chama's figures can be picked out of them
+const MinChamas = 5
+
+// Score bands
+const (
+	BandHealthy = "healthy" // score 70 and over
+	BandWatch   = "watch"   // 40 to 69
+	BandAtRisk  = "at_risk" // under 40
+)
+
+// PlatformHealth is one month's health across all chamas, with nothing
+// identifying any of them. Chamas without a score that month are counted but
+// left out of the average and the bands.
+type PlatformHealth struct {
+	Period                   string         `json:"period"`
+	Chamas                   int            `json:"chamas"`
+	AverageScore             *int64         `json:"averageScore"`
+	Bands                    map[string]int `json:"bands"`
+	AverageCollectionRateBps *int64         `json:"averageCollectionRateBps"`
+	AverageDefaultRatioBps   *int64         `json:"averageDefaultRatioBps"`
+	AverageChurnBps          *int64         `json:"averageChurnBps"`
+	AverageAttendanceRateBps *int64         `json:"averageAttendanceRateBps"`
+}
+
+// Platform returns the last months of platform-wide figures, oldest first.
+// Months with fewer than MinChamas chamas are left out.
+func Platform(ctx context.Context, db *sql.DB, months int) ([]PlatformHealth, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT period, COUNT(*), CAST(ROUND(AVG(score)) AS INTEGER),
+		       SUM(CASE WHEN score >= 70 THEN 1 ELSE 0 END),
+		       SUM(CASE WHEN score >= 40 AND score < 70 THEN 1 ELSE 0 END),
+		       SUM(CASE WHEN score < 40 THEN 1 ELSE 0 END),
+		       CAST(AVG(collection_rate_bps) AS INTEGER), CAST(AVG(default_ratio_bps) AS INTEGER),
+		       CAST(AVG(churn_bps) AS INTEGER), CAST(AVG(attendance_rate_bps) AS INTEGER)
+		FROM chama_health
+		GROUP BY period HAVING COUNT(*) >= ?
+		ORDER BY period DESC LIMIT ?`, MinChamas, months)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []PlatformHealth{}
+	for rows.Next() {
+		var p PlatformHealth
+		var healthy, watch, atRisk int
+		var score, collection, defaults, churn, attendance sql.NullInt64
+		if err := rows.Scan(&p.Period, &p.Chamas, &score, &healthy, &watch, &atRisk,
+			&collection, &defaults, &churn, &attendance); err != nil {
+			return nil, err
+		}
+		p.AverageScore = nullable(score)
+		p.Bands = map[string]int{BandHealthy: healthy, BandWatch: watch, BandAtRisk: atRisk}
+		p.AverageCollectionRateBps, p.AverageDefaultRatioBps = nullable(collection), nullable(defaults)
+		p.AverageChurnBps, p.AverageAttendanceRateBps = nullable(churn), nullable(attendance)
+		list = append(list, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Oldest first, like a chama's history
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list, nil
+}
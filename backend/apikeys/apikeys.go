@@ -0,0 +1,342 @@
+// Package apikeys lets SACCO partners call the API from their own systems.
+// Chama officials issue keys scoped to their chama and to a set of scopes;
+// a key acts with the rights of the official who issued it, but only on
+// routes opened to keys with the matching scope. A key can be sent as a
+// bearer token directly, or exchanged for a short-lived access token with
+// the OAuth2 client credentials grant, the key's ID being the client ID.
+// Only hashes of secrets and tokens are stored. Each key has its own rate
+// limit, and requests are counted per key and day.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Scopes a key can be given
+const (
+	ScopeTransactionsRead  = "transactions:read"
+	ScopeMembersRead       = "members:read"
+	ScopeReportsRead       = "reports:read"
+	ScopeContributionsRead = "contributions:read"
+)
+
+// Scopes lists every scope, in the order they are shown
+var Scopes = []string{ScopeTransactionsRead, ScopeMembersRead, ScopeReportsRead, ScopeContributionsRead}
+
+const (
+	// keyPrefix starts every API key, so leaked keys are easy to recognise
+	keyPrefix = "tjf_"
+	// tokenPrefix starts every OAuth2 access token
+	tokenPrefix = "tjf_at_"
+	// TokenLifetime is how long an access token is valid
+	TokenLifetime = time.Hour
+	// DefaultRateLimit is a key's requests per minute unless set when issued
+	DefaultRateLimit = 60
+	// MaxRateLimit caps the requests per minute a key can be given
+	MaxRateLimit = 600
+)
+
+var (
+	ErrInvalidKey   = errors.New("invalid API key")
+	ErrInvalidToken = errors.New("invalid or expired access token")
+	ErrInvalidScope = errors.New("unknown scope")
+	ErrNoScopes     = errors.New("at least one scope is required")
+	ErrNotFound     = errors.New("API key not found")
+	ErrRevoked      = errors.New("API key already revoked")
+)
+
+// Key is an issued API key. Secret is only set when the key is issued.
+type Key struct {
+	ID         string     `json:"id"`
+	ChamaID    string     `json:"chamaId"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int        `json:"rateLimit"` // requests per minute
+	Secret     string     `json:"secret,omitempty"`
+	CreatedBy  string     `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// HasScope reports whether the key was given scope
+func (k Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Token is an OAuth2 access token response
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// Issue creates a key for chamaID. The returned key carries its secret,
+// which cannot be shown again.
+func Issue(ctx context.Context, db *sql.DB, chamaID, name string, scopes []string, rateLimit int, by string) (Key, error) {
+	if len(scopes) == 0 {
+		return Key{}, ErrNoScopes
+	}
+	for _, s := range scopes {
+		if !validScope(s) {
+			return Key{}, ErrInvalidScope
+		}
+	}
+	if rateLimit <= 0 {
+		rateLimit = DefaultRateLimit
+	}
+	if rateLimit > MaxRateLimit {
+		rateLimit = MaxRateLimit
+	}
+	secret, err := randomString(24)
+	if err != nil {
+		return Key{}, err
+	}
+	k := Key{
+		ID:        uuid.NewString(),
+		ChamaID:   chamaID,
+		Name:      name,
+		Scopes:    scopes,
+		RateLimit: rateLimit,
+		CreatedBy: by,
+		CreatedAt: time.Now().UTC(),
+	}
+	_, err = txn.From(ctx, db).ExecContext(ctx, `
+		INSERT INTO api_keys (id, chama_id, name, scopes, rate_limit, secret_hash, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		k.ID, chamaID, name, strings.Join(scopes, " "), rateLimit, hash(secret), by, k.CreatedAt)
+	if err != nil {
+		return Key{}, err
+	}
+	k.Secret = keyPrefix + k.ID + "_" + secret
+	return k, nil
+}
+
+// List returns the chama's keys, newest first
+func List(ctx context.Context, db *sql.DB, chamaID string) ([]Key, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+columns+` FROM api_keys WHERE chama_id = ? ORDER BY created_at DESC`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []Key{}
+	for rows.Next() {
+		k, _, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// Get returns a key
+func Get(ctx context.Context, db *sql.DB, id string) (Key, error) {
+	k, _, err := scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM api_keys WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return k, ErrNotFound
+	}
+	return k, err
+}
+
+// Revoke stops a key, and the access tokens issued to it, from working
+func Revoke(ctx context.Context, db *sql.DB, id string) error {
+	q := txn.From(ctx, db)
+	res, err := q.ExecContext(ctx, `UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRevoked
+	}
+	_, err = q.ExecContext(ctx, `DELETE FROM api_tokens WHERE key_id = ?`, id)
+	return err
+}
+
+// Authenticate returns the active key for a bearer credential, which is
+// either an API key or an access token issued with IssueToken. For tokens
+// the key's scopes are narrowed to those granted to the token.
+func Authenticate(ctx context.Context, db *sql.DB, credential string) (Key, error) {
+	if strings.HasPrefix(credential, tokenPrefix) {
+		return authenticateToken(ctx, db, credential)
+	}
+	id, secret, ok := parseKey(credential)
+	if !ok {
+		return Key{}, ErrInvalidKey
+	}
+	return verifySecret(ctx, db, id, secret)
+}
+
+// IssueToken exchanges a client ID and secret for an access token with the
+// requested scopes, or all of the key's scopes if none are requested
+func IssueToken(ctx context.Context, db *sql.DB, clientID, clientSecret string, requested []string) (Token, error) {
+	// The secret may be given on its own or as the full key
+	if id, secret, ok := parseKey(clientSecret); ok && id == clientID {
+		clientSecret = secret
+	}
+	k, err := verifySecret(ctx, db, clientID, clientSecret)
+	if err != nil {
+		return Token{}, err
+	}
+	scopes := k.Scopes
+	if len(requested) > 0 {
+		for _, s := range requested {
+			if !k.HasScope(s) {
+				return Token{}, ErrInvalidScope
+			}
+		}
+		scopes = requested
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return Token{}, err
+	}
+	token := tokenPrefix + secret
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO api_tokens (token_hash, key_id, scopes, expires_at) VALUES (?, ?, ?, ?)`,
+		hash(token), k.ID, strings.Join(scopes, " "), time.Now().UTC().Add(TokenLifetime))
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(TokenLifetime.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// RegisterCleanupJob deletes expired access tokens
+func RegisterCleanupJob(s *jobs.Scheduler, db *sql.DB) {
+	s.Register("api_token_cleanup", jobs.Every(time.Hour), func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, `DELETE FROM api_tokens WHERE expires_at < ?`, time.Now().UTC())
+		return err
+	})
+}
+
+func verifySecret(ctx context.Context, db *sql.DB, id, secret string) (Key, error) {
+	k, secretHash, err := scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM api_keys WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return Key{}, ErrInvalidKey
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	if k.RevokedAt != nil || subtle.ConstantTimeCompare([]byte(hash(secret)), []byte(secretHash)) != 1 {
+		return Key{}, ErrInvalidKey
+	}
+	return k, nil
+}
+
+func authenticateToken(ctx context.Context, db *sql.DB, token string) (Key, error) {
+	var keyID, scopes string
+	var expiresAt time.Time
+	err := db.QueryRowContext(ctx, `SELECT key_id, scopes, expires_at FROM api_tokens WHERE token_hash = ?`,
+		hash(token)).Scan(&keyID, &scopes, &expiresAt)
+	if err == sql.ErrNoRows {
+		return Key{}, ErrInvalidToken
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	if time.Now().After(expiresAt) {
+		return Key{}, ErrInvalidToken
+	}
+	k, err := Get(ctx, db, keyID)
+	if err == ErrNotFound || (err == nil && k.RevokedAt != nil) {
+		return Key{}, ErrInvalidToken
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	return k, nil
+}
+
+// Touch records that the key was just used
+func Touch(ctx context.Context, db *sql.DB, id string) error {
+	_, err := db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
+
+const columns = `id, chama_id, name, scopes, rate_limit, secret_hash, created_by, created_at, last_used_at, revoked_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (Key, string, error) {
+	var k Key
+	var scopes, secretHash string
+	var lastUsed, revoked sql.NullTime
+	err := row.Scan(&k.ID, &k.ChamaID, &k.Name, &scopes, &k.RateLimit, &secretHash, &k.CreatedBy, &k.CreatedAt,
+		&lastUsed, &revoked)
+	if err != nil {
+		return k, "", err
+	}
+	k.Scopes = strings.Fields(scopes)
+	if lastUsed.Valid {
+		k.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		k.RevokedAt = &revoked.Time
+	}
+	return k, secretHash, nil
+}
+
+// parseKey splits "tjf_<id>_<secret>"
+func parseKey(key string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return "", "", false
+	}
+	rest := strings.TrimPrefix(key, keyPrefix)
+	i := strings.LastIndexByte(rest, '_')
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+func validScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hash is SHA-256; secrets and tokens are random, so a slow hash adds nothing
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,240 @@
+package apikeys
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// Usage is the number of requests a key made under a scope on a day
+type Usage struct {
+	Day      string `json:"day"`
+	Scope    string `json:"scope"`
+	Requests int    `json:"requests"`
+}
+
+// CreateHandler issues a key for the {chamaId} chama, for its officials.
+// The response holds the key's secret, which is not shown again.
+func CreateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficialContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			Name      string   `json:"name"`
+			Scopes    []string `json:"scopes"`
+			RateLimit int      `json:"rateLimit"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("name", request.Name)
+		if len(request.Scopes) == 0 {
+			v.Add("scopes", "required", nil)
+		}
+		for _, s := range request.Scopes {
+			v.OneOf("scopes", s, Scopes...)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		k, err := Issue(r.Context(), db, chamaID, request.Name, request.Scopes, request.RateLimit, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = audit.Record(r.Context(), txn.From(r.Context(), db), audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "api_key.issue", EntityType: "api_key", EntityID: k.ID,
+			NewValues: map[string]interface{}{"chamaId": chamaID, "name": k.Name, "scopes": k.Scopes, "rateLimit": k.RateLimit},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit API key issue", "key", k.ID, "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(k)
+	}
+}
+
+// ListHandler lists the {chamaId} chama's keys, without their secrets
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficialContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		keys, err := List(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// RevokeHandler revokes the {keyId} key, for officials of its chama
+func RevokeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		k, ok := officialKey(w, r, db, userID)
+		if !ok {
+			return
+		}
+		if err := Revoke(r.Context(), db, k.ID); err != nil {
+			if errors.Is(err, ErrRevoked) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err := audit.Record(r.Context(), txn.From(r.Context(), db), audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "api_key.revoke", EntityType: "api_key", EntityID: k.ID,
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit API key revocation", "key", k.ID, "error", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// UsageHandler returns the {keyId} key's daily request counts for the last
+// ?days= days (default 30)
+func UsageHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		k, ok := officialKey(w, r, db, userID)
+		if !ok {
+			return
+		}
+		days := 30
+		if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 {
+			days = d
+		}
+		since := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT day, scope, requests FROM api_usage WHERE key_id = ? AND day > ? ORDER BY day DESC, scope`,
+			k.ID, since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		usage := []Usage{}
+		for rows.Next() {
+			var u Usage
+			if err := rows.Scan(&u.Day, &u.Scope, &u.Requests); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			usage = append(usage, u)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage)
+	}
+}
+
+// officialKey loads the {keyId} key, writing an error unless userID is an
+// official of its chama
+func officialKey(w http.ResponseWriter, r *http.Request, db *sql.DB, userID string) (Key, bool) {
+	k, err := Get(r.Context(), db, mux.Vars(r)["keyId"])
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return k, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return k, false
+	}
+	if !chamas.IsOfficialContext(r.Context(), db, k.ChamaID, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return k, false
+	}
+	return k, true
+}
+
+// TokenHandler is the OAuth2 token endpoint for the client credentials
+// grant (RFC 6749 section 4.4). The client authenticates with HTTP Basic
+// or client_id and client_secret form fields; errors are OAuth2 error
+// responses.
+func TokenHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if err := r.ParseForm(); err != nil {
+			oauthError(w, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		if r.PostForm.Get("grant_type") != "client_credentials" {
+			oauthError(w, http.StatusBadRequest, "unsupported_grant_type")
+			return
+		}
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok {
+			clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		if clientID == "" || clientSecret == "" {
+			oauthError(w, http.StatusUnauthorized, "invalid_client")
+			return
+		}
+
+		token, err := IssueToken(r.Context(), db, clientID, clientSecret, strings.Fields(r.PostForm.Get("scope")))
+		switch {
+		case errors.Is(err, ErrInvalidKey):
+			w.Header().Set("WWW-Authenticate", `Basic realm="tujifund"`)
+			oauthError(w, http.StatusUnauthorized, "invalid_client")
+			return
+		case errors.Is(err, ErrInvalidScope):
+			oauthError(w, http.StatusBadRequest, "invalid_scope")
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(token)
+	}
+}
+
+func oauthError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
@@ -0,0 +1,526 @@
+// Package approvals holds spending to a chama's approval tiers: below one
+// amount the treasurer alone may approve, above it two officials must, and
+// above another the members vote. The tiers are part of the chama's rules.
+// Expenses and payouts are checked against them when they are created, and
+// the tier they fall in is copied onto their request, so a later change to
+// the rules does not move the goalposts for spending already waiting.
+package approvals
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/rules"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/votes"
+
+	"github.com/google/uuid"
+)
+
+// What is being approved
+const (
+	SubjectExpense      = "expense"
+	SubjectDisbursement = "disbursement"
+)
+
+// Request statuses
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// Decisions
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+)
+
+// VoteDays is how long members have to vote on spending in a vote tier
+var VoteDays = 7
+
+// VoteRejection is the reason recorded on spending the members voted down
+const VoteRejection = "Rejected by a vote of the members"
+
+var (
+	// ErrNotFound is returned when the subject has no approval request
+	ErrNotFound = errors.New("approval request not found")
+	// ErrDecided is returned when deciding a request that is no longer pending
+	ErrDecided = errors.New("approval request has already been decided")
+	// ErrNotEligible is returned when the user does not hold a role the tier allows to approve
+	ErrNotEligible = errors.New("your role cannot approve this request")
+	// ErrSelfApproval is returned when the requester approves their own request
+	ErrSelfApproval = errors.New("requests must be approved by someone other than the requester")
+	// ErrAlreadyApproved is returned when an official approves the same request twice
+	ErrAlreadyApproved = errors.New("you have already approved this request")
+	// ErrVoteRequired is returned when approving a request the members are voting on
+	ErrVoteRequired = errors.New("this request is decided by a vote of the members")
+)
+
+// Request is spending waiting for the approvals its tier requires
+type Request struct {
+	ID          string      `json:"id"`
+	ChamaID     string      `json:"chamaId"`
+	SubjectType string      `json:"subjectType"`
+	SubjectID   string      `json:"subjectId"`
+	Amount      money.Money `json:"amount"`
+	Required    int         `json:"required"` // approvals needed; 0 when put to a vote
+	Roles       []string    `json:"roles"`    // who may approve
+	Vote        bool        `json:"vote"`
+	VoteID      string      `json:"voteId,omitempty"`
+	Status      string      `json:"status"`
+	RequestedBy string      `json:"requestedBy"`
+	Decisions   []Decision  `json:"decisions"`
+	DecidedAt   *time.Time  `json:"decidedAt,omitempty"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
+
+// Approvals counts the approvals given so far
+func (r Request) Approvals() int {
+	n := 0
+	for _, d := range r.Decisions {
+		if d.Decision == DecisionApprove {
+			n++
+		}
+	}
+	return n
+}
+
+// Decision is one official's approval or rejection
+type Decision struct {
+	UserID    string    `json:"userId"`
+	Decision  string    `json:"decision"`
+	Reason    string    `json:"reason,omitempty"`
+	DecidedAt time.Time `json:"decidedAt"`
+}
+
+// Tier returns the approval tier amount falls in under the chama's current
+// rules, or false when its usual approval applies
+func Tier(ctx context.Context, db *sql.DB, chamaID string, amount money.Money) (rules.ApprovalTier, bool, error) {
+	r, err := rules.Current(ctx, db, chamaID)
+	if err != nil {
+		return rules.ApprovalTier{}, false, err
+	}
+	t, ok := r.ApprovalTier(amount)
+	return t, ok, nil
+}
+
+// Open records r as waiting for the approvals tier requires, inside the
+// transaction that creates its subject. firstApprover, when set and allowed
+// by the tier, counts as the first approval: a payout counts the official
+// who sends it. The returned request is already approved when that is
+// enough. A vote tier's vote is opened by StartVote once tx commits.
+func Open(ctx context.Context, tx *sql.Tx, r Request, tier rules.ApprovalTier, firstApprover string) (Request, error) {
+	r.ID, r.Status, r.Vote = uuid.NewString(), StatusPending, tier.Vote
+	r.Roles = tier.Roles
+	if len(r.Roles) == 0 {
+		r.Roles = chamas.OfficialRoles
+	}
+	if !tier.Vote {
+		r.Required = tier.Approvers
+	}
+	roles, err := json.Marshal(r.Roles)
+	if err != nil {
+		return r, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO approval_requests
+		(id, chama_id, subject_type, subject_id, amount_minor, currency, required, roles, vote, requested_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.ChamaID, r.SubjectType, r.SubjectID, r.Amount.Amount, r.Amount.Currency, r.Required, string(roles),
+		r.Vote, r.RequestedBy)
+	if err != nil {
+		return r, fmt.Errorf("failed to record approval request: %w", err)
+	}
+
+	if firstApprover == "" || r.Vote {
+		return r, nil
+	}
+	ok, err := hasRole(ctx, tx, r, firstApprover)
+	if err != nil || !ok {
+		return r, err
+	}
+	if err := decide(ctx, tx, r.ID, firstApprover, DecisionApprove, ""); err != nil {
+		return r, err
+	}
+	r.Decisions = []Decision{{UserID: firstApprover, Decision: DecisionApprove, DecidedAt: time.Now().UTC()}}
+	if r.Approvals() < r.Required {
+		return r, nil
+	}
+	r.Status = StatusApproved
+	return r, settle(ctx, tx, r.ID, r.Status)
+}
+
+// StartVote opens the members' vote on a request in a vote tier and tells
+// them about it
+func StartVote(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, r Request, title string, entry audit.Entry) (Request, error) {
+	if !r.Vote || r.VoteID != "" {
+		return r, nil
+	}
+	v, err := votes.Open(ctx, db, votes.Vote{
+		ChamaID:     r.ChamaID,
+		SubjectType: votes.SubjectApproval,
+		SubjectID:   r.ID,
+		Title:       title,
+		ClosesAt:    time.Now().UTC().AddDate(0, 0, VoteDays),
+		CreatedBy:   r.RequestedBy,
+	}, entry)
+	if err != nil {
+		return r, err
+	}
+	if _, err := txn.From(ctx, db).ExecContext(ctx, `UPDATE approval_requests SET vote_id = ? WHERE id = ?`, v.ID, r.ID); err != nil {
+		return r, err
+	}
+	r.VoteID = v.ID
+	if notifier != nil {
+		votes.NotifyOpened(ctx, db, notifier, v)
+	}
+	return r, nil
+}
+
+// hasRole reports whether userID holds one of the roles that may approve r
+func hasRole(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}, r Request, userID string) (bool, error) {
+	args := []interface{}{r.ChamaID, userID}
+	for _, role := range r.Roles {
+		args = append(args, role)
+	}
+	var n int
+	err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM chama_members WHERE chama_id = ? AND user_id = ? AND status = 'active'
+		AND role IN (?`+strings.Repeat(", ?", len(r.Roles)-1)+`)`, args...).Scan(&n)
+	return n > 0, err
+}
+
+// decide records userID's decision on a request, replacing any earlier one
+func decide(ctx context.Context, tx *sql.Tx, requestID, userID, decision, reason string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO approval_decisions (request_id, user_id, decision, reason) VALUES (?, ?, ?, ?)
+		ON CONFLICT (request_id, user_id) DO UPDATE SET decision = excluded.decision, reason = excluded.reason,
+			decided_at = CURRENT_TIMESTAMP`,
+		requestID, userID, decision, nullIfEmpty(reason))
+	return err
+}
+
+const columns = `id, chama_id, subject_type, subject_id, amount_minor, currency, required, roles, vote,
+	COALESCE(vote_id, ''), status, requested_by, decided_at, created_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Request, error) {
+	var r Request
+	var roles string
+	err := row.Scan(&r.ID, &r.ChamaID, &r.SubjectType, &r.SubjectID, &r.Amount.Amount, &r.Amount.Currency,
+		&r.Required, &roles, &r.Vote, &r.VoteID, &r.Status, &r.RequestedBy, &r.DecidedAt, &r.CreatedAt)
+	if err != nil {
+		return r, err
+	}
+	return r, json.Unmarshal([]byte(roles), &r.Roles)
+}
+
+// decisions loads the decisions made on r
+func decisions(ctx context.Context, db *sql.DB, r *Request) error {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT user_id, decision, COALESCE(reason, ''), decided_at FROM approval_decisions
+		WHERE request_id = ? ORDER BY decided_at, user_id`, r.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	r.Decisions = []Decision{}
+	for rows.Next() {
+		var d Decision
+		if err := rows.Scan(&d.UserID, &d.Decision, &d.Reason, &d.DecidedAt); err != nil {
+			return err
+		}
+		r.Decisions = append(r.Decisions, d)
+	}
+	return rows.Err()
+}
+
+// Get returns a request with its decisions
+func Get(ctx context.Context, db *sql.DB, id string) (Request, error) {
+	r, err := scan(txn.From(ctx, db).QueryRowContext(ctx, `SELECT `+columns+` FROM approval_requests WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return r, ErrNotFound
+	}
+	if err != nil {
+		return r, err
+	}
+	return r, decisions(ctx, db, &r)
+}
+
+// ForSubject returns the request an expense or payout is waiting on, or
+// ErrNotFound when it fell under the chama's usual approval
+func ForSubject(ctx context.Context, db *sql.DB, subjectType, subjectID string) (Request, error) {
+	var id string
+	err := txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT id FROM approval_requests WHERE subject_type = ? AND subject_id = ?`, subjectType, subjectID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return Request{}, ErrNotFound
+	}
+	if err != nil {
+		return Request{}, err
+	}
+	return Get(ctx, db, id)
+}
+
+// List returns a chama's requests, newest first, optionally by status
+func List(ctx context.Context, db *sql.DB, chamaID, status string) ([]Request, error) {
+	query, args := `SELECT `+columns+` FROM approval_requests WHERE chama_id = ?`, []interface{}{chamaID}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	rows, err := txn.From(ctx, db).QueryContext(ctx, query+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	list := []Request{}
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if err := decisions(ctx, db, &list[i]); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// check returns why userID may not decide r, if they may not
+func check(ctx context.Context, db *sql.DB, r Request, userID string) error {
+	ok, err := hasRole(ctx, db, r, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotEligible
+	}
+	return nil
+}
+
+// Approve adds the entry's user's approval to a request, approving it once
+// it has as many as its tier requires. Approving a request that is already
+// approved returns it unchanged, so a caller whose follow-up failed can
+// finish the job.
+func Approve(ctx context.Context, db *sql.DB, id string, e audit.Entry) (Request, error) {
+	r, err := Get(ctx, db, id)
+	if err != nil {
+		return r, err
+	}
+	if err := check(ctx, db, r, e.UserID); err != nil {
+		return r, err
+	}
+	switch {
+	case r.Status == StatusApproved:
+		return r, nil
+	case r.Status != StatusPending:
+		return r, ErrDecided
+	case r.Vote:
+		return r, ErrVoteRequired
+	}
+	for _, d := range r.Decisions {
+		if d.UserID == e.UserID && d.Decision == DecisionApprove {
+			return r, ErrAlreadyApproved
+		}
+	}
+	if r.RequestedBy == e.UserID {
+		return r, ErrSelfApproval
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return r, err
+	}
+	defer txn.Rollback(tx)
+	if err := decide(ctx, tx, r.ID, e.UserID, DecisionApprove, ""); err != nil {
+		return r, err
+	}
+	status := StatusPending
+	if r.Approvals()+1 >= r.Required {
+		status = StatusApproved
+		if err := settle(ctx, tx, r.ID, status); err != nil {
+			return r, err
+		}
+	}
+	e.Action, e.EntityType, e.EntityID = "approval.approve", r.SubjectType, r.SubjectID
+	e.NewValues = map[string]interface{}{
+		"requestId": r.ID, "approvals": r.Approvals() + 1, "required": r.Required, "status": status,
+	}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return r, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return r, err
+	}
+	return Get(ctx, db, id)
+}
+
+// Reject turns a request down. Any official the tier allows to approve can
+// reject it, except while the members are voting on it.
+func Reject(ctx context.Context, db *sql.DB, id, reason string, e audit.Entry) (Request, error) {
+	r, err := Get(ctx, db, id)
+	if err != nil {
+		return r, err
+	}
+	if err := check(ctx, db, r, e.UserID); err != nil {
+		return r, err
+	}
+	if r.Status != StatusPending {
+		return r, ErrDecided
+	}
+	if r.VoteID != "" {
+		return r, ErrVoteRequired
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return r, err
+	}
+	defer txn.Rollback(tx)
+	if err := decide(ctx, tx, r.ID, e.UserID, DecisionReject, reason); err != nil {
+		return r, err
+	}
+	if err := settle(ctx, tx, r.ID, StatusRejected); err != nil {
+		return r, err
+	}
+	e.Action, e.EntityType, e.EntityID = "approval.reject", r.SubjectType, r.SubjectID
+	e.NewValues = map[string]string{"requestId": r.ID, "reason": reason}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return r, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return r, err
+	}
+	return Get(ctx, db, id)
+}
+
+// settle closes a pending request
+func settle(ctx context.Context, tx *sql.Tx, id, status string) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE approval_requests SET status = ?, decided_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		status, id, StatusPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrDecided
+	}
+	return nil
+}
+
+// Hooks act on a request once the members' vote has decided it, e.g. send
+// an approved payout. They are keyed by subject type.
+var Hooks = map[string]func(ctx context.Context, db *sql.DB, r Request) error{}
+
+// RegisterVoteHook makes approval votes approve or reject the request they
+// were opened for, then runs the subject's hook
+func RegisterVoteHook() {
+	votes.OutcomeHooks[votes.SubjectApproval] = func(ctx context.Context, db *sql.DB, v votes.Vote) error {
+		status := StatusRejected
+		if v.Status == votes.StatusPassed {
+			status = StatusApproved
+		}
+		tx, err := txn.Begin(ctx, db)
+		if err != nil {
+			return err
+		}
+		defer txn.Rollback(tx)
+		if err := settle(ctx, tx, v.SubjectID, status); errors.Is(err, ErrDecided) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := txn.Commit(tx); err != nil {
+			return err
+		}
+
+		r, err := Get(ctx, db, v.SubjectID)
+		if err != nil {
+			return err
+		}
+		if hook, ok := Hooks[r.SubjectType]; ok {
+			return hook(ctx, db, r)
+		}
+		return nil
+	}
+}
+
+// Approvers returns the officials who can still approve r: those in its
+// roles who are not its requester and have not approved it yet
+func Approvers(ctx context.Context, db *sql.DB, r Request) ([]string, error) {
+	ids, err := chamas.MembersWithRoleContext(ctx, db, r.ChamaID, r.Roles...)
+	if err != nil {
+		return nil, err
+	}
+	done := map[string]bool{r.RequestedBy: true}
+	for _, d := range r.Decisions {
+		done[d.UserID] = true
+	}
+	var approvers []string
+	for _, id := range ids {
+		if !done[id] {
+			approvers = append(approvers, id)
+		}
+	}
+	return approvers, nil
+}
+
+// Notify asks the officials who can still approve r to review it. Requests
+// in a vote tier are announced to the members by StartVote instead.
+func Notify(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, r Request) {
+	if notifier == nil || r.Vote || r.Status != StatusPending {
+		return
+	}
+	approvers, err := Approvers(ctx, db, r)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load approvers", "request_id", r.ID, "error", err)
+		return
+	}
+	var chama string
+	txn.From(ctx, db).QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, r.ChamaID).Scan(&chama)
+	params := map[string]string{
+		"chama":     chama,
+		"subject":   i18n.T(i18n.Default, "approval."+r.SubjectType, nil),
+		"amount":    r.Amount.String(),
+		"approvals": strconv.Itoa(r.Approvals()),
+		"required":  strconv.Itoa(r.Required),
+	}
+	for _, id := range approvers {
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "approval.requested", nil),
+			Message:   i18n.T(i18n.Default, "notification.approval_requested", params),
+			Type:      notifications.TypeChama,
+			RelatedID: r.SubjectID,
+		})
+	}
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
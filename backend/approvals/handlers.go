@@ -0,0 +1,35 @@
+package approvals
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"tujifund-app/backend/chamas"
+
+	"github.com/gorilla/mux"
+)
+
+// ListHandler lists the {chamaId} chama's approval requests with the
+// decisions made on them, for its officials. Supports ?status=.
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficialContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := List(r.Context(), db, chamaID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
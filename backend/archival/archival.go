@@ -0,0 +1,225 @@
+// Package archival keeps ledger_entries small as chamas build up years of
+// history. Entries from closed years older than KeepYears move to
+// ledger_entries_archive. One carry-forward entry per account, member and
+// entry type is left in their place, so balances and all-time totals read
+// from ledger_entries are unchanged, while statements and reports read the
+// ledger_history view and still see every archived entry.
+package archival
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/ledger"
+
+	"github.com/google/uuid"
+)
+
+// KeepYears is how many years of entries stay in ledger_entries, on top of
+// the current year. Kenyan law requires financial records to be kept for
+// seven years, so older ones are rarely looked at.
+var KeepYears = 7
+
+var (
+	// ErrNothingToArchive is returned when a chama has no entries before the cutoff
+	ErrNothingToArchive = errors.New("no entries to archive")
+	// ErrCutoffNotLater is returned when a cutoff is not after the chama's last archive
+	ErrCutoffNotLater = errors.New("cutoff must be later than the last archive")
+)
+
+// Archive is one archive run for a chama
+type Archive struct {
+	ID            string    `json:"id"`
+	ChamaID       string    `json:"chamaId"`
+	Cutoff        time.Time `json:"cutoff"`
+	Entries       int       `json:"entries"`
+	CarryForwards int       `json:"carryForwards"`
+	ArchivedBy    string    `json:"archivedBy,omitempty"`
+	ArchivedAt    time.Time `json:"archivedAt"`
+}
+
+// Cutoff returns the start of the oldest year that is kept, so only whole
+// closed years are archived
+func Cutoff(now time.Time) time.Time {
+	return time.Date(now.UTC().Year()-KeepYears, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Run moves the chama's entries effective before cutoff into the archive.
+// The carry-forward entries from earlier runs are replaced by new ones
+// covering everything archived so far. Account balances are not touched, as
+// the carry-forward entries add up to exactly what was archived.
+func Run(ctx context.Context, db *sql.DB, chamaID string, cutoff time.Time, by string) (Archive, error) {
+	a := Archive{ID: uuid.NewString(), ChamaID: chamaID, Cutoff: cutoff.UTC(), ArchivedBy: by, ArchivedAt: time.Now().UTC()}
+	before := a.Cutoff.Format("2006-01-02 15:04:05")
+
+	var last time.Time
+	err := db.QueryRowContext(ctx, `SELECT cutoff FROM ledger_archives WHERE chama_id = ? ORDER BY cutoff DESC LIMIT 1`,
+		chamaID).Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		return a, err
+	}
+	if err == nil && !a.Cutoff.After(last) {
+		return a, ErrCutoffNotLater
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ledger_archives (id, chama_id, cutoff, entry_count, carry_forward_count, archived_by, archived_at)
+		VALUES (?, ?, ?, 0, 0, ?, ?)`,
+		a.ID, chamaID, a.Cutoff, nullIfEmpty(by), a.ArchivedAt)
+	if err != nil {
+		return a, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO ledger_entries_archive
+		(seq, id, chama_id, account_id, member_id, entry_type, amount_minor, currency, reference, description,
+		 effective_at, created_by, created_at, chain_seq, prev_hash, hash, archive_id)
+		SELECT seq, id, chama_id, account_id, member_id, entry_type, amount_minor, currency, reference, description,
+		       effective_at, created_by, created_at, chain_seq, prev_hash, hash, ?
+		FROM ledger_entries WHERE chama_id = ? AND archive_id IS NULL AND effective_at < ?`,
+		a.ID, chamaID, before)
+	if err != nil {
+		return a, err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return a, ErrNothingToArchive
+	}
+	a.Entries = int(n)
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM ledger_entries WHERE chama_id = ? AND (archive_id IS NOT NULL OR effective_at < ?)`,
+		chamaID, before)
+	if err != nil {
+		return a, err
+	}
+
+	// Carry forward everything archived so far, dated just before the cutoff
+	// so that balances as of any later date include it
+	type total struct {
+		accountID, entryType string
+		memberID             sql.NullString
+		amount               int64
+		currency             string
+	}
+	var totals []total
+	rows, err := tx.QueryContext(ctx, `
+		SELECT account_id, member_id, entry_type, SUM(amount_minor), currency FROM ledger_entries_archive
+		WHERE chama_id = ? GROUP BY account_id, member_id, entry_type, currency HAVING SUM(amount_minor) != 0`, chamaID)
+	if err != nil {
+		return a, err
+	}
+	for rows.Next() {
+		var t total
+		if err := rows.Scan(&t.accountID, &t.memberID, &t.entryType, &t.amount, &t.currency); err != nil {
+			rows.Close()
+			return a, err
+		}
+		totals = append(totals, t)
+	}
+	rows.Close()
+	effective := a.Cutoff.Add(-time.Second).Format("2006-01-02 15:04:05")
+	for _, t := range totals {
+		seq, err := ledger.NextSeq(ctx, tx, chamaID)
+		if err != nil {
+			return a, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO ledger_entries
+			(id, chama_id, account_id, member_id, entry_type, amount_minor, currency, description, effective_at, archive_id, seq)
+			VALUES (?, ?, ?, ?, ?, ?, ?, 'Carried forward from archive', ?, ?, ?)`,
+			uuid.NewString(), chamaID, t.accountID, t.memberID, t.entryType, t.amount, t.currency, effective, a.ID, seq)
+		if err != nil {
+			return a, err
+		}
+	}
+	a.CarryForwards = len(totals)
+
+	_, err = tx.ExecContext(ctx, `UPDATE ledger_archives SET entry_count = ?, carry_forward_count = ? WHERE id = ?`,
+		a.Entries, a.CarryForwards, a.ID)
+	if err != nil {
+		return a, err
+	}
+	return a, tx.Commit()
+}
+
+// List returns the chama's archive runs, newest first
+func List(ctx context.Context, db *sql.DB, chamaID string) ([]Archive, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, chama_id, cutoff, entry_count, carry_forward_count, COALESCE(archived_by, ''), archived_at
+		FROM ledger_archives WHERE chama_id = ? ORDER BY cutoff DESC`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Archive{}
+	for rows.Next() {
+		var a Archive
+		if err := rows.Scan(&a.ID, &a.ChamaID, &a.Cutoff, &a.Entries, &a.CarryForwards, &a.ArchivedBy, &a.ArchivedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// RunAll archives every chama with entries before the cutoff for now
+func RunAll(ctx context.Context, db *sql.DB, now time.Time) (int, error) {
+	cutoff := Cutoff(now)
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT chama_id FROM ledger_entries WHERE archive_id IS NULL AND effective_at < ?`,
+		cutoff.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	var chamaIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		chamaIDs = append(chamaIDs, id)
+	}
+	rows.Close()
+
+	archived := 0
+	for _, id := range chamaIDs {
+		a, err := Run(ctx, db, id, cutoff, "")
+		if errors.Is(err, ErrNothingToArchive) || errors.Is(err, ErrCutoffNotLater) {
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to archive ledger entries", "chama_id", id, "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Archived ledger entries", "chama_id", id, "entries", a.Entries, "cutoff", cutoff)
+		archived++
+	}
+	return archived, nil
+}
+
+// RegisterJob archives closed years on the first of each month, so a year
+// is archived early in the January after it passes KeepYears
+func RegisterJob(s *jobs.Scheduler, db *sql.DB) {
+	s.Register("ledger_archival", jobs.Monthly{Day: 1, Hour: 3}, func(ctx context.Context) error {
+		_, err := RunAll(ctx, db, time.Now().UTC())
+		return err
+	})
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
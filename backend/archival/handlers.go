@@ -0,0 +1,87 @@
+package archival
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/chamas"
+
+	"github.com/gorilla/mux"
+)
+
+// ListHandler lists the {chamaId} chama's archive runs
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := List(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// RunHandler archives the {chamaId} chama's entries now, for platform staff.
+// {"before": "YYYY-MM-DD"} sets the cutoff, which must be the start of a
+// year; it defaults to the scheduled job's cutoff.
+func RunHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staffID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Before string `json:"before"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		cutoff := Cutoff(time.Now())
+		if request.Before != "" {
+			t, err := time.Parse("2006-01-02", request.Before)
+			if err != nil || t.Month() != time.January || t.Day() != 1 {
+				http.Error(w, "before must be the first of January (YYYY-01-01)", http.StatusBadRequest)
+				return
+			}
+			if t.After(cutoff) {
+				http.Error(w, "Only years older than the retention period can be archived", http.StatusBadRequest)
+				return
+			}
+			cutoff = t
+		}
+
+		a, err := Run(r.Context(), db, mux.Vars(r)["chamaId"], cutoff, staffID)
+		switch {
+		case errors.Is(err, ErrNothingToArchive):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, ErrCutoffNotLater):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	}
+}
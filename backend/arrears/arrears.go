@@ -0,0 +1,264 @@
+package arrears
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/dashboard"
+	"tujifund-app/backend/loans"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/rules"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of arrears
+const (
+	KindContribution = "contribution"
+	KindLoan         = "loan"
+)
+
+// Escalation levels, recording who has been told about an arrear
+const (
+	LevelMember     = 1
+	LevelGuarantors = 2
+	LevelOfficials  = 3
+)
+
+var (
+	// GuarantorDays is how long a loan is overdue before its guarantors are told
+	GuarantorDays = 7
+	// OfficialDays is how long an arrear runs before the chama's officials are told
+	OfficialDays = 14
+	// DefaultDays is how long a loan is overdue before it is marked defaulted
+	DefaultDays = 90
+)
+
+// MaxCycles is how many past contribution cycles are checked for shortfalls
+const MaxCycles = 12
+
+// Arrear is an overdue amount owed by a member
+type Arrear struct {
+	ID              string      `json:"id"`
+	ChamaID         string      `json:"chamaId"`
+	MemberID        string      `json:"memberId"`
+	MemberName      string      `json:"memberName"`
+	Kind            string      `json:"kind"`
+	SourceID        string      `json:"sourceId"`
+	Owed            money.Money `json:"owed"`
+	DueDate         string      `json:"dueDate"`
+	DaysOverdue     int         `json:"daysOverdue"`
+	EscalationLevel int         `json:"escalationLevel"`
+	DetectedAt      time.Time   `json:"detectedAt"`
+	ResolvedAt      string      `json:"resolvedAt,omitempty"`
+}
+
+// finding is an overdue amount found by a scan
+type finding struct {
+	memberID string
+	kind     string
+	sourceID string
+	owed     money.Money
+	due      time.Time // of the oldest unpaid cycle or instalment
+	days     int
+}
+
+// daysSince counts whole days from t to now
+func daysSince(t, now time.Time) int {
+	return int(now.Sub(t).Hours() / 24)
+}
+
+// contributionFindings finds members who have paid less than the expected
+// contribution over the last MaxCycles closed cycles since they joined.
+// Payments settle the oldest cycles first, so a late payment clears the
+// oldest shortfall.
+func contributionFindings(ctx context.Context, db *sql.DB, chamaID, currency string, r rules.Rules, now time.Time) ([]finding, error) {
+	if r.ContributionAmountMinor <= 0 {
+		return nil, nil
+	}
+	cycles := []dashboard.Cycle{dashboard.LastClosedCycle(r, now)}
+	for len(cycles) < MaxCycles {
+		cycles = append([]dashboard.Cycle{dashboard.CycleAt(r, cycles[0].Start.AddDate(0, 0, -1))}, cycles...)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.user_id, m.join_date, COALESCE(SUM(d.amount_minor), 0)
+		FROM chama_members m
+		LEFT JOIN contribution_days d ON d.chama_id = m.chama_id AND d.member_id = m.user_id AND d.day >= ?
+		WHERE m.chama_id = ? AND m.status = 'active'
+		GROUP BY m.user_id, m.join_date`,
+		cycles[0].Start.Format("2006-01-02"), chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []finding
+	for rows.Next() {
+		var memberID string
+		var joined time.Time
+		var paid int64
+		if err := rows.Scan(&memberID, &joined, &paid); err != nil {
+			return nil, err
+		}
+		var expected int64
+		var due time.Time
+		for _, c := range cycles {
+			if c.Due.Before(joined) {
+				continue
+			}
+			expected += r.ContributionAmountMinor
+			if expected > paid && due.IsZero() {
+				due = c.Due
+			}
+		}
+		if expected > paid {
+			found = append(found, finding{
+				memberID: memberID,
+				kind:     KindContribution,
+				sourceID: chamaID,
+				owed:     money.New(expected-paid, currency),
+				due:      due,
+				days:     daysSince(due.AddDate(0, 0, r.ContributionGraceDays), now),
+			})
+		}
+	}
+	return found, rows.Err()
+}
+
+// loanFindings finds loans whose repayments are behind their current schedule
+func loanFindings(ctx context.Context, db *sql.DB, chamaID string, r rules.Rules, now time.Time) ([]finding, error) {
+	running, err := loans.Running(ctx, db, chamaID)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []finding
+	for _, l := range running {
+		s, err := loans.Current(ctx, db, l)
+		if err != nil {
+			return nil, err
+		}
+		expected, due := s.Expected(l.Repaid.Amount, r.LoanGraceDays, now)
+		if expected > l.Repaid.Amount {
+			found = append(found, finding{
+				memberID: l.BorrowerID,
+				kind:     KindLoan,
+				sourceID: l.ID,
+				owed:     money.New(expected-l.Repaid.Amount, l.Principal.Currency),
+				due:      due,
+				days:     daysSince(due.AddDate(0, 0, r.LoanGraceDays), now),
+			})
+		}
+	}
+	return found, nil
+}
+
+// record opens or updates the arrear for f and returns it with the
+// escalation level it had before
+func record(ctx context.Context, db *sql.DB, chamaID string, f finding) (Arrear, int, error) {
+	a := Arrear{ChamaID: chamaID, MemberID: f.memberID, Kind: f.kind, SourceID: f.sourceID, Owed: f.owed,
+		DueDate: f.due.Format("2006-01-02"), DaysOverdue: f.days}
+	err := db.QueryRowContext(ctx, `
+		SELECT id, escalation_level, detected_at FROM arrears
+		WHERE kind = ? AND source_id = ? AND member_id = ? AND resolved_at IS NULL`,
+		f.kind, f.sourceID, f.memberID).Scan(&a.ID, &a.EscalationLevel, &a.DetectedAt)
+	if err == sql.ErrNoRows {
+		a.ID, a.DetectedAt = uuid.NewString(), time.Now().UTC()
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO arrears (id, chama_id, member_id, kind, source_id, amount_owed_minor, currency, due_date, days_overdue)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			a.ID, chamaID, f.memberID, f.kind, f.sourceID, f.owed.Amount, f.owed.Currency, a.DueDate, f.days)
+		return a, 0, err
+	}
+	if err != nil {
+		return a, 0, err
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE arrears SET amount_owed_minor = ?, due_date = ?, days_overdue = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, f.owed.Amount, a.DueDate, f.days, a.ID)
+	return a, a.EscalationLevel, err
+}
+
+// resolve closes the chama's open arrears that are no longer owed
+func resolve(ctx context.Context, db *sql.DB, chamaID string, open map[string]bool) error {
+	rows, err := db.QueryContext(ctx, `SELECT id FROM arrears WHERE chama_id = ? AND resolved_at IS NULL`, chamaID)
+	if err != nil {
+		return err
+	}
+	var paid []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		if !open[id] {
+			paid = append(paid, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range paid {
+		_, err := db.ExecContext(ctx, `
+			UPDATE arrears SET resolved_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filter narrows a List query. Zero values are ignored.
+type Filter struct {
+	Kind      string
+	MemberID  string
+	MemberIDs []string // any of these members
+	MinDays   int
+}
+
+// List returns the chama's open arrears, longest overdue first
+func List(ctx context.Context, db *sql.DB, chamaID string, f Filter) ([]Arrear, error) {
+	query := `
+		SELECT a.id, a.chama_id, a.member_id,
+		       COALESCE(NULLIF(TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')), ''), u.username, ''),
+		       a.kind, a.source_id, a.amount_owed_minor, a.currency, date(a.due_date), a.days_overdue,
+		       a.escalation_level, a.detected_at, COALESCE(a.resolved_at, '')
+		FROM arrears a LEFT JOIN users u ON u.user_id = a.member_id
+		WHERE a.chama_id = ? AND a.resolved_at IS NULL AND a.days_overdue >= ?`
+	args := []interface{}{chamaID, f.MinDays}
+	if f.Kind != "" {
+		query += ` AND a.kind = ?`
+		args = append(args, f.Kind)
+	}
+	if f.MemberID != "" {
+		query += ` AND a.member_id = ?`
+		args = append(args, f.MemberID)
+	}
+	if len(f.MemberIDs) > 0 {
+		query += ` AND a.member_id IN (?` + strings.Repeat(", ?", len(f.MemberIDs)-1) + `)`
+		for _, id := range f.MemberIDs {
+			args = append(args, id)
+		}
+	}
+	query += ` ORDER BY a.days_overdue DESC, a.amount_owed_minor DESC`
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Arrear{}
+	for rows.Next() {
+		var a Arrear
+		if err := rows.Scan(&a.ID, &a.ChamaID, &a.MemberID, &a.MemberName, &a.Kind, &a.SourceID, &a.Owed.Amount,
+			&a.Owed.Currency, &a.DueDate, &a.DaysOverdue, &a.EscalationLevel, &a.DetectedAt, &a.ResolvedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,93 @@
+package arrears
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+
+	"github.com/gorilla/mux"
+)
+
+// Report is the defaulters report for a chama
+type Report struct {
+	ChamaID   string        `json:"chamaId"`
+	Arrears   []Arrear      `json:"arrears"`
+	Members   int           `json:"members"`   // distinct members in arrears
+	TotalOwed []money.Money `json:"totalOwed"` // per currency
+}
+
+func report(chamaID string, list []Arrear) Report {
+	rep := Report{ChamaID: chamaID, Arrears: list, TotalOwed: []money.Money{}}
+	members := map[string]bool{}
+	totals := map[string]int{}
+	for _, a := range list {
+		members[a.MemberID] = true
+		i, ok := totals[a.Owed.Currency]
+		if !ok {
+			i = len(rep.TotalOwed)
+			totals[a.Owed.Currency] = i
+			rep.TotalOwed = append(rep.TotalOwed, money.New(0, a.Owed.Currency))
+		}
+		rep.TotalOwed[i].Amount += a.Owed.Amount
+	}
+	rep.Members = len(members)
+	return rep
+}
+
+// DefaultersHandler returns the {chamaId} chama's open arrears, optionally
+// filtered by ?kind=, ?memberId= and ?minDays=. Only officials can view it.
+func DefaultersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		q := r.URL.Query()
+		minDays, _ := strconv.Atoi(q.Get("minDays"))
+		list, err := List(r.Context(), db, chamaID, Filter{Kind: q.Get("kind"), MemberID: q.Get("memberId"), MinDays: minDays})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report(chamaID, list))
+	}
+}
+
+// DetectHandler scans the {chamaId} chama for arrears now rather than
+// waiting for the daily job, and returns the updated report
+func DetectHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := Detect(r.Context(), db, notifier, chamaID, time.Now().UTC())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report(chamaID, list))
+	}
+}
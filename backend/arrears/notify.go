@@ -0,0 +1,205 @@
+package arrears
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/dashboard"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/rules"
+)
+
+// Detect scans a chama for overdue contributions and loan instalments as of
+// now, records them, resolves arrears that have been paid and escalates
+// notifications as arrears age: first the member, then a loan's guarantors,
+// then the chama's officials
+func Detect(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, chamaID string, now time.Time) ([]Arrear, error) {
+	var chamaName, currency string
+	err := db.QueryRowContext(ctx, `SELECT name, currency FROM chamas WHERE id = ?`, chamaID).Scan(&chamaName, &currency)
+	if err != nil {
+		return nil, err
+	}
+	r, err := rules.Current(ctx, db, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	if err := dashboard.Ensure(ctx, db, chamaID); err != nil {
+		return nil, err
+	}
+
+	found, err := contributionFindings(ctx, db, chamaID, currency, r, now)
+	if err != nil {
+		return nil, err
+	}
+	loans, err := loanFindings(ctx, db, chamaID, r, now)
+	if err != nil {
+		return nil, err
+	}
+	found = append(found, loans...)
+
+	open := map[string]bool{}
+	for _, f := range found {
+		a, level, err := record(ctx, db, chamaID, f)
+		if err != nil {
+			return nil, err
+		}
+		open[a.ID] = true
+		if f.kind == KindLoan && f.days >= DefaultDays {
+			if err := markDefaulted(ctx, db, f.sourceID, f.days); err != nil {
+				return nil, err
+			}
+		}
+		escalate(ctx, db, notifier, chamaName, a, level)
+	}
+	if err := resolve(ctx, db, chamaID, open); err != nil {
+		return nil, err
+	}
+	return List(ctx, db, chamaID, Filter{})
+}
+
+// target returns the escalation level an arrear days overdue should have reached
+func target(kind string, days int) int {
+	switch {
+	case days >= OfficialDays:
+		return LevelOfficials
+	case days >= GuarantorDays && kind == KindLoan:
+		return LevelGuarantors
+	default:
+		return LevelMember
+	}
+}
+
+// escalate notifies everyone a's age calls for who has not been told yet,
+// then records the level reached
+func escalate(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, chamaName string, a Arrear, from int) {
+	to := target(a.Kind, a.DaysOverdue)
+	if to <= from {
+		return
+	}
+	var member string
+	db.QueryRowContext(ctx, `
+		SELECT COALESCE(NULLIF(TRIM(COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')), ''), username)
+		FROM users WHERE user_id = ?`, a.MemberID).Scan(&member)
+	params := map[string]string{
+		"member": member,
+		"chama":  chamaName,
+		"amount": a.Owed.String(),
+		"days":   strconv.Itoa(a.DaysOverdue),
+		"kind":   i18n.T(i18n.Default, "arrears."+a.Kind, nil),
+	}
+
+	for level := from + 1; level <= to; level++ {
+		var recipients []string
+		key := "notification.arrears_member"
+		switch level {
+		case LevelMember:
+			recipients = []string{a.MemberID}
+		case LevelGuarantors:
+			key = "notification.arrears_guarantor"
+			recipients = guarantors(ctx, db, a.SourceID)
+		case LevelOfficials:
+			key = "notification.arrears_official"
+			var err error
+			if recipients, err = chamas.MembersWithRole(db, a.ChamaID, chamas.OfficialRoles...); err != nil {
+				slog.ErrorContext(ctx, "Failed to load chama officials", "chama_id", a.ChamaID, "error", err)
+				return
+			}
+		}
+		if notifier != nil {
+			for _, userID := range recipients {
+				notifier.Notify(ctx, notifications.Notification{
+					UserID:    userID,
+					Title:     i18n.T(i18n.Default, "arrears.title", nil),
+					Message:   i18n.T(i18n.Default, key, params),
+					Type:      notifications.TypeChama,
+					RelatedID: a.ID,
+				})
+			}
+		}
+	}
+
+	_, err := db.ExecContext(ctx, `UPDATE arrears SET escalation_level = ? WHERE id = ?`, to, a.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to record arrears escalation", "arrear_id", a.ID, "error", err)
+	}
+}
+
+// guarantors returns the approved guarantors of a loan
+func guarantors(ctx context.Context, db *sql.DB, loanID string) []string {
+	rows, err := db.QueryContext(ctx, `
+		SELECT guarantor_id FROM loan_guarantors WHERE loan_id = ? AND status = 'approved'`, loanID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load loan guarantors", "loan_id", loanID, "error", err)
+		return nil
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// markDefaulted flags an active loan as defaulted
+func markDefaulted(ctx context.Context, db *sql.DB, loanID string, days int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE loans SET status = 'defaulted', updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'active'`, loanID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	err = audit.Record(ctx, tx, audit.Entry{
+		Action: "loan.default", EntityType: "loan", EntityID: loanID,
+		NewValues: map[string]int{"daysOverdue": days},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RegisterDetectionJob scans every active chama for arrears each morning
+func RegisterDetectionJob(s *jobs.Scheduler, db *sql.DB, notifier *notifications.Notifier) {
+	s.Register("arrears_detection", jobs.Daily{Hour: 6}, func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `SELECT id FROM chamas WHERE status = 'active'`)
+		if err != nil {
+			return err
+		}
+		var chamaIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			chamaIDs = append(chamaIDs, id)
+		}
+		rows.Close()
+
+		now := time.Now().UTC()
+		for _, id := range chamaIDs {
+			if _, err := Detect(ctx, db, notifier, id, now); err != nil {
+				slog.ErrorContext(ctx, "Failed to detect arrears", "chama_id", id, "error", err)
+			}
+		}
+		return nil
+	})
+}
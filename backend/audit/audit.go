@@ -0,0 +1,231 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/ratelimit"
+	"tujifund-app/backend/readonly"
+
+	"github.com/google/uuid"
+)
+
+// Execer is satisfied by both *sql.DB and *sql.Tx, so entries can be written
+// in the same transaction as the change they describe
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Entry is one audit log record. OldValues and NewValues are stored as JSON.
+// EffectiveAt is set for changes that took effect at a different time from
+// when they were recorded, such as a backdated role change.
+type Entry struct {
+	ID          string      `json:"id"`
+	UserID      string      `json:"userId,omitempty"`
+	Action      string      `json:"action"`
+	EntityType  string      `json:"entityType"`
+	EntityID    string      `json:"entityId,omitempty"`
+	OldValues   interface{} `json:"oldValues,omitempty"`
+	NewValues   interface{} `json:"newValues,omitempty"`
+	IPAddress   string      `json:"ipAddress,omitempty"`
+	UserAgent   string      `json:"userAgent,omitempty"`
+	Timestamp   string      `json:"timestamp"`
+	EffectiveAt string      `json:"effectiveAt,omitempty"`
+}
+
+// Record writes e to the audit log. It writes in read-only maintenance mode
+// too, as reads such as data exports are audited.
+func Record(ctx context.Context, db Execer, e Entry) error {
+	oldValues, err := marshal(e.OldValues)
+	if err != nil {
+		return err
+	}
+	newValues, err := marshal(e.NewValues)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(readonly.Allow(ctx), `
+		INSERT INTO audit_logs (id, user_id, action, entity_type, entity_id, old_values, new_values, ip_address, user_agent,
+		                        effective_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		uuid.NewString(), nullIfEmpty(e.UserID), e.Action, e.EntityType, nullIfEmpty(e.EntityID),
+		oldValues, newValues, nullIfEmpty(e.IPAddress), nullIfEmpty(e.UserAgent), nullIfEmpty(e.EffectiveAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// FromRequest fills in the acting user, client IP and user agent from r
+func FromRequest(r *http.Request, e Entry) Entry {
+	if userID, ok := r.Context().Value("userID").(string); ok && e.UserID == "" {
+		e.UserID = userID
+	}
+	e.IPAddress = ratelimit.ClientIP(r)
+	e.UserAgent = r.UserAgent()
+	return e
+}
+
+// List returns the audit trail for an entity, oldest first
+func List(ctx context.Context, db *sql.DB, entityType, entityID string) ([]Entry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+columns+`
+		FROM audit_logs WHERE entity_type = ? AND entity_id = ? ORDER BY timestamp, rowid`,
+		entityType, entityID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAll(rows)
+}
+
+// Filter narrows Search. Empty fields match everything.
+type Filter struct {
+	UserID     string
+	Action     string // matches actions starting with this prefix
+	EntityType string
+	EntityID   string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// Search returns audit entries matching f across all entities, newest first
+func Search(ctx context.Context, db *sql.DB, f Filter) ([]Entry, error) {
+	query := `SELECT ` + columns + ` FROM audit_logs WHERE 1 = 1`
+	var args []interface{}
+	if f.UserID != "" {
+		query += ` AND user_id = ?`
+		args = append(args, f.UserID)
+	}
+	if f.Action != "" {
+		query += ` AND action LIKE ? ESCAPE '\'`
+		args = append(args, strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Action)+"%")
+	}
+	if f.EntityType != "" {
+		query += ` AND entity_type = ?`
+		args = append(args, f.EntityType)
+	}
+	if f.EntityID != "" {
+		query += ` AND entity_id = ?`
+		args = append(args, f.EntityID)
+	}
+	if !f.Since.IsZero() {
+		query += ` AND timestamp >= ?`
+		args = append(args, f.Since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !f.Until.IsZero() {
+		query += ` AND timestamp < ?`
+		args = append(args, f.Until.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 100
+	}
+	query += ` ORDER BY timestamp DESC, rowid DESC LIMIT ?`
+	args = append(args, f.Limit)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAll(rows)
+}
+
+// chamaEntities are the entity types recorded against something a chama
+// owns, with the table that holds it, so ForChama can find a chama's whole
+// trail. Entries about the chama itself use entity type "chama".
+var chamaEntities = []struct{ entityType, table string }{
+	{"chama_account", "chama_accounts"},
+	{"chama_member", "chama_members"},
+	{"chama_rules", "chama_rules"},
+	{"contribution", "contributions"},
+	{"contribution_reminder", "contribution_reminders"},
+	{"disbursement", "disbursements"},
+	{"loan", "loans"},
+	{"member_exit", "member_exits"},
+	{"share_transaction", "share_transactions"},
+	{"statement_import", "statement_imports"},
+	{"statement_line", "statement_lines"},
+	{"sync_mutation", "sync_mutations"},
+	{"treasurer_handover", "treasurer_handovers"},
+	{"vote", "votes"},
+}
+
+// ForChama returns the audit trail of a chama and everything it owns, newest
+// first. Only f's Since, Until and Limit apply.
+func ForChama(ctx context.Context, db *sql.DB, chamaID string, f Filter) ([]Entry, error) {
+	query := `SELECT ` + columns + ` FROM audit_logs WHERE ((entity_type = 'chama' AND entity_id = ?)`
+	args := []interface{}{chamaID}
+	for _, e := range chamaEntities {
+		query += ` OR (entity_type = ? AND entity_id IN (SELECT id FROM ` + e.table + ` WHERE chama_id = ?))`
+		args = append(args, e.entityType, chamaID)
+	}
+	query += `)`
+	if !f.Since.IsZero() {
+		query += ` AND timestamp >= ?`
+		args = append(args, f.Since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !f.Until.IsZero() {
+		query += ` AND timestamp < ?`
+		args = append(args, f.Until.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 100
+	}
+	query += ` ORDER BY timestamp DESC, rowid DESC LIMIT ?`
+	args = append(args, f.Limit)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAll(rows)
+}
+
+const columns = `id, COALESCE(user_id, ''), action, entity_type, COALESCE(entity_id, ''),
+	COALESCE(old_values, ''), COALESCE(new_values, ''), COALESCE(ip_address, ''), COALESCE(user_agent, ''), timestamp,
+	COALESCE(effective_at, '')`
+
+func scanAll(rows *sql.Rows) ([]Entry, error) {
+	defer rows.Close()
+	list := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var oldValues, newValues string
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Action, &e.EntityType, &e.EntityID,
+			&oldValues, &newValues, &e.IPAddress, &e.UserAgent, &e.Timestamp, &e.EffectiveAt); err != nil {
+			return nil, err
+		}
+		if oldValues != "" {
+			e.OldValues = json.RawMessage(oldValues)
+		}
+		if newValues != "" {
+			e.NewValues = json.RawMessage(newValues)
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func marshal(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit values: %w", err)
+	}
+	return string(b), nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
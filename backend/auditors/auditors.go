@@ -0,0 +1,244 @@
+// Package auditors gives external auditors time-limited, read-only access to
+// one chama's books: its statements, ledger, audit trail and documents.
+// Auditors sign in with their own accounts but are not members, so none of
+// the member-facing routes open to them, and what they are shown leaves out
+// members' contact details. Everything an auditor views is logged for the
+// chama's officials to review.
+package auditors
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+
+	"github.com/google/uuid"
+)
+
+// MaxDays is the longest an auditor's access can last; a longer audit needs
+// a fresh grant
+const MaxDays = 90
+
+var (
+	// ErrNotFound is returned when no account has the auditor's email
+	ErrNotFound = errors.New("no account has this email; the auditor must sign up first")
+	// ErrInvalidExpiry is returned when access would end in the past or after MaxDays
+	ErrInvalidExpiry = fmt.Errorf("access must end within %d days from now", MaxDays)
+	// ErrActive is returned when granting access to an auditor who already has it
+	ErrActive = errors.New("this auditor already has access to the chama")
+	// ErrNotActive is returned when revoking access that has expired or been revoked
+	ErrNotActive = errors.New("access has already ended")
+)
+
+// Access is an auditor's grant to one chama
+type Access struct {
+	ID        string     `json:"id"`
+	ChamaID   string     `json:"chamaId"`
+	ChamaName string     `json:"chamaName,omitempty"`
+	UserID    string     `json:"userId"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	Firm      string     `json:"firm,omitempty"`
+	Purpose   string     `json:"purpose,omitempty"`
+	GrantedBy string     `json:"grantedBy"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedBy string     `json:"revokedBy,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Active reports whether the access can still be used at now
+func (a Access) Active(now time.Time) bool {
+	return a.RevokedAt == nil && now.Before(a.ExpiresAt)
+}
+
+const columns = `a.id, a.chama_id, c.name, a.user_id,
+	COALESCE(NULLIF(TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')), ''), u.username), u.email,
+	COALESCE(a.firm, ''), COALESCE(a.purpose, ''), a.granted_by, a.expires_at, COALESCE(a.revoked_by, ''), a.revoked_at,
+	a.created_at
+	FROM chama_auditors a JOIN chamas c ON c.id = a.chama_id JOIN users u ON u.user_id = a.user_id`
+
+func scan(row interface{ Scan(...interface{}) error }) (Access, error) {
+	var a Access
+	var revokedAt sql.NullTime
+	err := row.Scan(&a.ID, &a.ChamaID, &a.ChamaName, &a.UserID, &a.Name, &a.Email, &a.Firm, &a.Purpose, &a.GrantedBy,
+		&a.ExpiresAt, &a.RevokedBy, &revokedAt, &a.CreatedAt)
+	if revokedAt.Valid {
+		a.RevokedAt = &revokedAt.Time
+	}
+	return a, err
+}
+
+func list(ctx context.Context, db *sql.DB, where string, args ...interface{}) ([]Access, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+columns+` WHERE `+where+` ORDER BY a.created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Access{}
+	for rows.Next() {
+		a, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// Grant gives the auditor with email read-only access to a.ChamaID until
+// a.ExpiresAt. e carries the request's audit details.
+func Grant(ctx context.Context, db *sql.DB, a Access, email string, e audit.Entry) (Access, error) {
+	now := time.Now().UTC()
+	if !a.ExpiresAt.After(now) || a.ExpiresAt.After(now.AddDate(0, 0, MaxDays)) {
+		return a, ErrInvalidExpiry
+	}
+	err := db.QueryRowContext(ctx, `SELECT user_id FROM users WHERE email = ?`, email).Scan(&a.UserID)
+	if err == sql.ErrNoRows {
+		return a, ErrNotFound
+	}
+	if err != nil {
+		return a, err
+	}
+	if _, err := Active(ctx, db, a.ChamaID, a.UserID); err == nil {
+		return a, ErrActive
+	} else if err != sql.ErrNoRows {
+		return a, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback()
+	a.ID = uuid.NewString()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chama_auditors (id, chama_id, user_id, firm, purpose, granted_by, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.ChamaID, a.UserID, nullIfEmpty(a.Firm), nullIfEmpty(a.Purpose), a.GrantedBy,
+		a.ExpiresAt.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return a, fmt.Errorf("failed to grant auditor access: %w", err)
+	}
+	e.Action, e.EntityType, e.EntityID = "auditor.grant", "chama", a.ChamaID
+	e.NewValues = map[string]interface{}{"accessId": a.ID, "auditorId": a.UserID, "firm": a.Firm, "expiresAt": a.ExpiresAt}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return a, err
+	}
+	if err := tx.Commit(); err != nil {
+		return a, err
+	}
+	return Get(ctx, db, a.ID)
+}
+
+// Get returns an access by ID
+func Get(ctx context.Context, db *sql.DB, id string) (Access, error) {
+	return scan(db.QueryRowContext(ctx, `SELECT `+columns+` WHERE a.id = ?`, id))
+}
+
+// Active returns the user's access to the chama that is in force now, or
+// sql.ErrNoRows
+func Active(ctx context.Context, db *sql.DB, chamaID, userID string) (Access, error) {
+	return scan(db.QueryRowContext(ctx, `
+		SELECT `+columns+`
+		WHERE a.chama_id = ? AND a.user_id = ? AND a.revoked_at IS NULL AND a.expires_at > ?
+		ORDER BY a.expires_at DESC LIMIT 1`,
+		chamaID, userID, time.Now().UTC().Format("2006-01-02 15:04:05")))
+}
+
+// List returns every access granted for the chama, newest first
+func List(ctx context.Context, db *sql.DB, chamaID string) ([]Access, error) {
+	return list(ctx, db, `a.chama_id = ?`, chamaID)
+}
+
+// Mine returns the user's accesses that are in force now
+func Mine(ctx context.Context, db *sql.DB, userID string) ([]Access, error) {
+	return list(ctx, db, `a.user_id = ? AND a.revoked_at IS NULL AND a.expires_at > ?`,
+		userID, time.Now().UTC().Format("2006-01-02 15:04:05"))
+}
+
+// Revoke ends the chama's access id straight away
+func Revoke(ctx context.Context, db *sql.DB, chamaID, id string, e audit.Entry) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		UPDATE chama_auditors SET revoked_by = ?, revoked_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND chama_id = ? AND revoked_at IS NULL AND expires_at > ?`,
+		e.UserID, id, chamaID, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotActive
+	}
+	e.Action, e.EntityType, e.EntityID = "auditor.revoke", "chama", chamaID
+	e.NewValues = map[string]string{"accessId": id}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// View is one read an auditor made
+type View struct {
+	ID        string    `json:"id"`
+	AccessID  string    `json:"accessId"`
+	UserID    string    `json:"userId"`
+	Resource  string    `json:"resource"`
+	Path      string    `json:"path"`
+	Query     string    `json:"query,omitempty"`
+	IPAddress string    `json:"ipAddress,omitempty"`
+	ViewedAt  time.Time `json:"viewedAt"`
+}
+
+// LogView records that the auditor viewed something under access a
+func LogView(ctx context.Context, db *sql.DB, a Access, v View) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO auditor_views (id, access_id, chama_id, user_id, resource, path, query, ip_address)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		uuid.NewString(), a.ID, a.ChamaID, a.UserID, v.Resource, v.Path, nullIfEmpty(v.Query), nullIfEmpty(v.IPAddress))
+	if err != nil {
+		return fmt.Errorf("failed to log auditor view: %w", err)
+	}
+	return nil
+}
+
+// Views returns what auditors viewed in the chama, newest first, optionally
+// for one access only
+func Views(ctx context.Context, db *sql.DB, chamaID, accessID string, limit int) ([]View, error) {
+	query := `
+		SELECT id, access_id, user_id, resource, path, COALESCE(query, ''), COALESCE(ip_address, ''), viewed_at
+		FROM auditor_views WHERE chama_id = ?`
+	args := []interface{}{chamaID}
+	if accessID != "" {
+		query += ` AND access_id = ?`
+		args = append(args, accessID)
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY viewed_at DESC, rowid DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []View{}
+	for rows.Next() {
+		var v View
+		if err := rows.Scan(&v.ID, &v.AccessID, &v.UserID, &v.Resource, &v.Path, &v.Query, &v.IPAddress, &v.ViewedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
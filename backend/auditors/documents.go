@@ -0,0 +1,69 @@
+package auditors
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Document kinds an auditor can download
+const (
+	KindMinutes = "minutes"
+	KindExpense = "expense"
+)
+
+// Document is a file kept for a chama: published meeting minutes, or the
+// receipt or invoice attached to an expense
+type Document struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Title    string    `json:"title"`
+	Date     time.Time `json:"date"`
+	MimeType string    `json:"mimeType,omitempty"`
+}
+
+// Documents lists the chama's documents, newest first
+func Documents(ctx context.Context, db *sql.DB, chamaID string) ([]Document, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT mm.id, ?, m.title, m.meeting_date, COALESCE(mm.mime_type, '')
+		FROM meeting_minutes mm JOIN meetings m ON m.id = mm.meeting_id
+		WHERE m.chama_id = ? AND mm.is_published AND mm.storage_key IS NOT NULL
+		UNION ALL
+		SELECT id, ?, description, incurred_at, COALESCE(mime_type, '')
+		FROM expenses WHERE chama_id = ? AND storage_key IS NOT NULL
+		ORDER BY 4 DESC`,
+		KindMinutes, chamaID, KindExpense, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Document{}
+	for rows.Next() {
+		var d Document
+		if err := rows.Scan(&d.ID, &d.Kind, &d.Title, &d.Date, &d.MimeType); err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// documentKey returns where the chama's document is stored, or sql.ErrNoRows
+func documentKey(ctx context.Context, db *sql.DB, chamaID, kind, id string) (string, error) {
+	var key string
+	var err error
+	switch kind {
+	case KindMinutes:
+		err = db.QueryRowContext(ctx, `
+			SELECT mm.storage_key FROM meeting_minutes mm JOIN meetings m ON m.id = mm.meeting_id
+			WHERE mm.id = ? AND m.chama_id = ? AND mm.is_published AND mm.storage_key IS NOT NULL`,
+			id, chamaID).Scan(&key)
+	case KindExpense:
+		err = db.QueryRowContext(ctx, `
+			SELECT storage_key FROM expenses WHERE id = ? AND chama_id = ? AND storage_key IS NOT NULL`,
+			id, chamaID).Scan(&key)
+	default:
+		err = sql.ErrNoRows
+	}
+	return key, err
+}
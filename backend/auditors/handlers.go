@@ -0,0 +1,399 @@
+package auditors
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/ratelimit"
+	"tujifund-app/backend/reports"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// Require restricts next to auditors with access in force to the {chamaId}
+// chama and logs the request as a view of resource. Only register it on GET
+// routes: auditor access is read-only. It must run inside the session
+// middleware.
+func Require(db *sql.DB, resource string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		a, err := Active(r.Context(), db, mux.Vars(r)["chamaId"], userID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = LogView(r.Context(), db, a, View{
+			Resource:  resource,
+			Path:      r.URL.Path,
+			Query:     r.URL.RawQuery,
+			IPAddress: ratelimit.ClientIP(r),
+		})
+		if err != nil {
+			// Auditor reads must not go unrecorded
+			slog.ErrorContext(r.Context(), "Failed to log auditor view", "resource", resource, "error", err)
+			http.Error(w, "Failed to record view", http.StatusInternalServerError)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// officialOnly writes an error response and returns false unless the user
+// is an official of the {chamaId} chama
+func officialOnly(db *sql.DB, w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return "", "", false
+	}
+	chamaID := mux.Vars(r)["chamaId"]
+	if !chamas.IsOfficial(db, chamaID, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return userID, chamaID, false
+	}
+	return userID, chamaID, true
+}
+
+// GrantHandler gives an auditor, by the email they signed up with, access
+// to the {chamaId} chama until expiresAt (YYYY-MM-DD), for its officials
+func GrantHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, chamaID, ok := officialOnly(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Email     string `json:"email"`
+			Firm      string `json:"firm"`
+			Purpose   string `json:"purpose"`
+			ExpiresAt string `json:"expiresAt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("email", request.Email) {
+			v.Email("email", request.Email)
+		}
+		if v.Required("expiresAt", request.ExpiresAt) {
+			v.Date("expiresAt", request.ExpiresAt)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		// Access runs to the end of the expiry date
+		expires, _ := time.Parse("2006-01-02", request.ExpiresAt)
+
+		a, err := Grant(r.Context(), db, Access{
+			ChamaID:   chamaID,
+			Firm:      request.Firm,
+			Purpose:   request.Purpose,
+			GrantedBy: userID,
+			ExpiresAt: expires.AddDate(0, 0, 1),
+		}, request.Email, audit.FromRequest(r, audit.Entry{UserID: userID}))
+		switch {
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, ErrInvalidExpiry):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrActive):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// ListHandler lists the auditor access granted for the {chamaId} chama, for its officials
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, chamaID, ok := officialOnly(db, w, r)
+		if !ok {
+			return
+		}
+		list, err := List(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// RevokeHandler ends the {accessId} access to the {chamaId} chama early, for its officials
+func RevokeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, chamaID, ok := officialOnly(db, w, r)
+		if !ok {
+			return
+		}
+		err := Revoke(r.Context(), db, chamaID, mux.Vars(r)["accessId"], audit.FromRequest(r, audit.Entry{UserID: userID}))
+		if errors.Is(err, ErrNotActive) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ViewsHandler returns what auditors viewed in the {chamaId} chama, for one
+// ?accessId= or all, up to ?limit= (default 100), for its officials
+func ViewsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, chamaID, ok := officialOnly(db, w, r)
+		if !ok {
+			return
+		}
+		list, err := Views(r.Context(), db, chamaID, r.URL.Query().Get("accessId"), limit(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// MineHandler lists the chamas the user can audit now
+func MineHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		list, err := Mine(r.Context(), db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+func limit(r *http.Request) int {
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 500 {
+		return n
+	}
+	return 100
+}
+
+// period reads ?from=YYYY-MM-DD&to=YYYY-MM-DD (inclusive); either may be left out
+func period(r *http.Request) (time.Time, time.Time, bool) {
+	var from, to time.Time
+	var err error
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, false
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, false
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, true
+}
+
+// The handlers below serve auditors and must be wrapped in Require
+
+// LedgerHandler returns the {chamaId} chama's ledger entries, oldest first,
+// filtered by accountId, memberId, type, from and to, with limit/offset paging
+func LedgerHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, ok := period(r)
+		if !ok {
+			http.Error(w, "from and to must be dates in the format YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		f := ledger.Filter{
+			ChamaID:   mux.Vars(r)["chamaId"],
+			AccountID: q.Get("accountId"),
+			MemberID:  q.Get("memberId"),
+			Type:      q.Get("type"),
+			From:      from,
+			To:        to,
+		}
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		if offset < 0 {
+			offset = 0
+		}
+		where, args := f.Where()
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT `+ledger.Columns+` FROM `+ledger.History+` WHERE `+where+`
+			ORDER BY `+ledger.Order+` LIMIT ? OFFSET ?`,
+			append(args, limit(r), offset)...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		entries := []ledger.Entry{}
+		for rows.Next() {
+			e, err := ledger.Scan(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entries = append(entries, e)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"entries": entries,
+			"limit":   limit(r),
+			"offset":  offset,
+		})
+	}
+}
+
+// VerifyHandler checks the {chamaId} chama's ledger hash chain
+func VerifyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, err := ledger.Verify(r.Context(), db, mux.Vars(r)["chamaId"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// StatementHandler returns the {chamaId} chama's statement of account for
+// ?accountId= (all accounts if empty) between from and to
+func StatementHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, ok := period(r)
+		if !ok {
+			http.Error(w, "from and to must be dates in the format YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		s, err := reports.BuildAccountStatement(r.Context(), db, mux.Vars(r)["chamaId"], r.URL.Query().Get("accountId"),
+			reports.Period{From: from, To: to})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// MemberStatementHandler returns the {userId} member's statement in the
+// {chamaId} chama between from and to. The member is identified by ID only.
+func MemberStatementHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if !chamas.IsMember(db, vars["chamaId"], vars["userId"]) {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		from, to, ok := period(r)
+		if !ok {
+			http.Error(w, "from and to must be dates in the format YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		s, err := reports.BuildMemberStatement(r.Context(), db, vars["chamaId"], vars["userId"], reports.Period{From: from, To: to})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.MemberName = ""
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// AuditTrailHandler returns the {chamaId} chama's audit trail between from
+// and to, newest first, up to ?limit=, without the IP addresses and devices
+// it was recorded from
+func AuditTrailHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, ok := period(r)
+		if !ok {
+			http.Error(w, "from and to must be dates in the format YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		entries, err := audit.ForChama(r.Context(), db, mux.Vars(r)["chamaId"], audit.Filter{Since: from, Until: to, Limit: limit(r)})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range entries {
+			entries[i].IPAddress, entries[i].UserAgent = "", ""
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// DocumentsHandler lists the {chamaId} chama's documents
+func DocumentsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := Documents(r.Context(), db, mux.Vars(r)["chamaId"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// DocumentHandler redirects to a short-lived download link for the {chamaId}
+// chama's {kind} document {documentId}
+func DocumentHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		key, err := documentKey(r.Context(), db, vars["chamaId"], vars["kind"], vars["documentId"])
+		if err == sql.ErrNoRows {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		url, err := store.SignedURL(key, 5*time.Minute)
+		if err != nil {
+			http.Error(w, "Failed to create download link", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/gorilla/sessions"
@@ -76,25 +76,25 @@ func HandleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 	// Encode user data to JSON
 	jsonData, err := json.Marshal(userData)
 	if err != nil {
-		log.Printf("failed to marshal user data: %v", err)
+		slog.ErrorContext(r.Context(), "failed to marshal user data", "error", err)
 		return
 	}
 
 	// Make the API request
 	apiResp, err := http.Post("http://localhost:8080/api/register", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		log.Printf("failed to make register API call: %v", err)
+		slog.ErrorContext(r.Context(), "failed to make register API call", "error", err)
 		return
 
 	}
 	defer apiResp.Body.Close()
 
 	body, _ := io.ReadAll(apiResp.Body)
-	log.Printf("Response body: %s", body)
+	slog.DebugContext(r.Context(), "register API response", "body", string(body))
 
 	// Check the response status
 	if apiResp.StatusCode != http.StatusCreated {
-		log.Printf("register API call failed with status: %s", apiResp.Status)
+		slog.ErrorContext(r.Context(), "register API call failed", "status", apiResp.Status)
 		return
 
 	}
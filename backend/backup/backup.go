@@ -0,0 +1,351 @@
+// Package backup continuously replicates the SQLite database to object
+// storage, Litestream-style, for point-in-time recovery. A snapshot of the
+// database file is taken daily; between snapshots every committed
+// transaction is shipped from the WAL within seconds. Restore rebuilds the
+// database as of any time since the oldest snapshot kept.
+//
+// Replicas are laid out under a prefix as
+//
+//	manifest.json                  snapshots kept and the database page size
+//	<index>/snapshot.gz            the database file when snapshot <index> was taken
+//	<index>/<seq>.wal.gz           WAL frames shipped after it, in order
+//
+// so restoring needs nothing but Get.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/storage"
+)
+
+// ErrNoSnapshot is returned when restoring to a time before the oldest snapshot
+var ErrNoSnapshot = errors.New("no snapshot taken before the restore time")
+
+// Snapshot is one snapshot in the manifest
+type Snapshot struct {
+	Index    int       `json:"index"`
+	TakenAt  time.Time `json:"takenAt"`
+	Segments int       `json:"segments"` // WAL segments shipped after it; -1 while it is the latest
+}
+
+type manifest struct {
+	PageSize  int        `json:"pageSize"`
+	Snapshots []Snapshot `json:"snapshots"` // oldest first
+}
+
+// Replicator ships the database at Path to Store under Prefix
+type Replicator struct {
+	Path          string
+	Store         storage.Backend
+	Prefix        string
+	Interval      time.Duration // how often new transactions are shipped
+	SnapshotEvery time.Duration
+	Retain        int // snapshots kept, with the WAL shipped after them
+
+	db       *sql.DB
+	lock     *sql.Tx // a read transaction, held so that only the replicator checkpoints
+	manifest manifest
+	seq      int       // next WAL segment
+	header   walHeader // of the WAL being followed; zero until it has frames
+	offset   int64     // shipped up to here
+	checksum [2]uint32 // WAL checksum at offset
+}
+
+// FromEnv configures replication of the database at path to store when
+// REPLICA_PREFIX is set, or returns nil. REPLICA_INTERVAL (default 10s),
+// REPLICA_SNAPSHOT_EVERY (24h) and REPLICA_RETAIN (7 snapshots) tune it.
+func FromEnv(path string, store storage.Backend) (*Replicator, error) {
+	prefix := os.Getenv("REPLICA_PREFIX")
+	if prefix == "" {
+		return nil, nil
+	}
+	r := &Replicator{Path: path, Store: store, Prefix: prefix, Interval: 10 * time.Second, SnapshotEvery: 24 * time.Hour, Retain: 7}
+	var err error
+	if v := os.Getenv("REPLICA_INTERVAL"); v != "" {
+		if r.Interval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid REPLICA_INTERVAL: %w", err)
+		}
+	}
+	if v := os.Getenv("REPLICA_SNAPSHOT_EVERY"); v != "" {
+		if r.SnapshotEvery, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid REPLICA_SNAPSHOT_EVERY: %w", err)
+		}
+	}
+	if v := os.Getenv("REPLICA_RETAIN"); v != "" {
+		if r.Retain, err = strconv.Atoi(v); err != nil || r.Retain < 1 {
+			return nil, fmt.Errorf("invalid REPLICA_RETAIN: %q", v)
+		}
+	}
+	return r, nil
+}
+
+// DisableAutoCheckpoint stops the app's connection checkpointing the WAL, so
+// no frames are checkpointed away before they are shipped. The replicator
+// checkpoints when it takes a snapshot. db must have a single connection.
+func DisableAutoCheckpoint(db *sql.DB) error {
+	_, err := db.Exec(`PRAGMA wal_autocheckpoint = 0`)
+	return err
+}
+
+// Run replicates until ctx is done, starting with a snapshot
+func (r *Replicator) Run(ctx context.Context) error {
+	db, err := sql.Open("sqlite", r.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(2) // one for the read lock, one to checkpoint
+	r.db = db
+	for _, pragma := range []string{`PRAGMA busy_timeout = 5000`, `PRAGMA journal_mode = WAL`} {
+		if _, err := db.ExecContext(ctx, pragma); err != nil {
+			return fmt.Errorf("failed to set up replication: %w", err)
+		}
+	}
+	if err := r.loadManifest(ctx); err != nil {
+		return err
+	}
+	if err := r.snapshot(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Ship what is left, as the app stops with us
+			r.sync(context.Background())
+			return nil
+		case <-ticker.C:
+			if err := r.sync(ctx); err != nil {
+				slog.ErrorContext(ctx, "Failed to replicate database", "error", err)
+			}
+		}
+	}
+}
+
+// sync ships new transactions, and takes a new snapshot when one is due or
+// the WAL was restarted behind the replicator's back
+func (r *Replicator) sync(ctx context.Context) error {
+	latest := r.manifest.Snapshots[len(r.manifest.Snapshots)-1]
+	if time.Since(latest.TakenAt) >= r.SnapshotEvery {
+		return r.snapshot(ctx)
+	}
+
+	wal := r.Path + "-wal"
+	info, err := os.Stat(wal)
+	if os.IsNotExist(err) || (err == nil && info.Size() < walHeaderSize) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	raw := make([]byte, walHeaderSize)
+	f, err := os.Open(wal)
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(f, raw)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	h, err := readWALHeader(raw)
+	if err != nil {
+		return err
+	}
+	if r.offset == 0 {
+		r.header, r.offset, r.checksum = h, walHeaderSize, h.checksum
+	} else if h.salt != r.header.salt || info.Size() < r.offset {
+		slog.WarnContext(ctx, "WAL was restarted by another connection; taking a new snapshot")
+		return r.snapshot(ctx)
+	}
+
+	frames, checksum, err := committedFrames(wal, r.header, r.offset, r.checksum)
+	if err != nil || len(frames) == 0 {
+		return err
+	}
+	if err := r.put(ctx, r.segmentKey(latest.Index, r.seq), frames, time.Now().UTC()); err != nil {
+		return err
+	}
+	r.seq++
+	r.offset += int64(len(frames))
+	r.checksum = checksum
+	return nil
+}
+
+// snapshot checkpoints the whole WAL into the database file and uploads the
+// file as a new snapshot. Frames written after the checkpoint are shipped
+// as the new snapshot's segments.
+func (r *Replicator) snapshot(ctx context.Context) error {
+	r.unlock()
+	var busy, logFrames, checkpointed int
+	err := r.db.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed)
+	if err == nil && busy != 0 {
+		err = errors.New("database busy")
+	}
+	if lockErr := r.relock(ctx); err == nil {
+		err = lockErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint for snapshot: %w", err)
+	}
+
+	// Nothing else checkpoints, so the file stays as it is while the read lock is held
+	data, err := os.ReadFile(r.Path)
+	if err != nil {
+		return err
+	}
+	if r.manifest.PageSize, err = pageSize(data); err != nil {
+		return err
+	}
+	index := 0
+	if n := len(r.manifest.Snapshots); n > 0 {
+		index = r.manifest.Snapshots[n-1].Index + 1
+		r.manifest.Snapshots[n-1].Segments = r.seq
+	}
+	takenAt := time.Now().UTC()
+	if err := r.put(ctx, r.snapshotKey(index), data, takenAt); err != nil {
+		return err
+	}
+	r.manifest.Snapshots = append(r.manifest.Snapshots, Snapshot{Index: index, TakenAt: takenAt, Segments: -1})
+	r.seq, r.header, r.offset, r.checksum = 0, walHeader{}, 0, [2]uint32{}
+
+	for len(r.manifest.Snapshots) > r.Retain {
+		r.drop(ctx, r.manifest.Snapshots[0])
+		r.manifest.Snapshots = r.manifest.Snapshots[1:]
+	}
+	b, _ := json.Marshal(r.manifest)
+	if err := r.Store.Put(ctx, r.Prefix+"/manifest.json", bytes.NewReader(b), int64(len(b)), "application/json"); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Database snapshot replicated", "index", index, "bytes", len(data))
+	return nil
+}
+
+// loadManifest picks up the replica from an earlier run. The segments
+// shipped after its latest snapshot are counted, so they can be dropped
+// with it later.
+func (r *Replicator) loadManifest(ctx context.Context) error {
+	m, err := readManifest(ctx, r.Store, r.Prefix)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n := len(m.Snapshots); n > 0 && m.Snapshots[n-1].Segments < 0 {
+		last := &m.Snapshots[n-1]
+		for last.Segments = 0; ; last.Segments++ {
+			rc, err := r.Store.Get(ctx, r.segmentKey(last.Index, last.Segments))
+			if err != nil {
+				break
+			}
+			rc.Close()
+		}
+	}
+	r.manifest = m
+	return nil
+}
+
+// drop deletes a snapshot and its segments
+func (r *Replicator) drop(ctx context.Context, s Snapshot) {
+	keys := []string{r.snapshotKey(s.Index)}
+	for seq := 0; seq < s.Segments; seq++ {
+		keys = append(keys, r.segmentKey(s.Index, seq))
+	}
+	for _, key := range keys {
+		if err := r.Store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to delete old replica", "key", key, "error", err)
+		}
+	}
+}
+
+func (r *Replicator) relock(ctx context.Context) error {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	// A read transaction only takes its lock once it reads
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master`).Scan(&n); err != nil {
+		tx.Rollback()
+		return err
+	}
+	r.lock = tx
+	return nil
+}
+
+func (r *Replicator) unlock() {
+	if r.lock != nil {
+		r.lock.Rollback()
+		r.lock = nil
+	}
+}
+
+// put uploads data gzipped, prefixed with the time it was taken
+func (r *Replicator) put(ctx context.Context, key string, data []byte, at time.Time) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	binary.Write(zw, binary.BigEndian, at.UnixNano())
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return r.Store.Put(ctx, key, &buf, int64(buf.Len()), "application/gzip")
+}
+
+func (r *Replicator) snapshotKey(index int) string { return snapshotKey(r.Prefix, index) }
+
+func (r *Replicator) segmentKey(index, seq int) string { return segmentKey(r.Prefix, index, seq) }
+
+func snapshotKey(prefix string, index int) string {
+	return fmt.Sprintf("%s/%08d/snapshot.gz", prefix, index)
+}
+
+func segmentKey(prefix string, index, seq int) string {
+	return fmt.Sprintf("%s/%08d/%08d.wal.gz", prefix, index, seq)
+}
+
+func readManifest(ctx context.Context, store storage.Backend, prefix string) (manifest, error) {
+	var m manifest
+	rc, err := store.Get(ctx, prefix+"/manifest.json")
+	if err != nil {
+		return m, err
+	}
+	defer rc.Close()
+	return m, json.NewDecoder(rc).Decode(&m)
+}
+
+// get downloads an object written by put
+func get(ctx context.Context, store storage.Backend, key string) ([]byte, time.Time, error) {
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rc.Close()
+	zr, err := gzip.NewReader(rc)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var at int64
+	if err := binary.Read(zr, binary.BigEndian, &at); err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := io.ReadAll(zr)
+	return data, time.Unix(0, at).UTC(), err
+}
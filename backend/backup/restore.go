@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"tujifund-app/backend/storage"
+)
+
+// Restore rebuilds the database replicated under prefix as it was at at, or
+// as of the last shipped transaction when at is zero, and writes it to out.
+// It returns the time of the last transactions included, which is up to one
+// replication interval before at.
+func Restore(ctx context.Context, store storage.Backend, prefix string, at time.Time, out string) (time.Time, error) {
+	m, err := readManifest(ctx, store, prefix)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read replica manifest: %w", err)
+	}
+	var snap *Snapshot
+	for i := range m.Snapshots {
+		if at.IsZero() || !m.Snapshots[i].TakenAt.After(at) {
+			snap = &m.Snapshots[i]
+		}
+	}
+	if snap == nil {
+		return time.Time{}, ErrNoSnapshot
+	}
+
+	data, restoredTo, err := get(ctx, store, snapshotKey(prefix, snap.Index))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to download snapshot %d: %w", snap.Index, err)
+	}
+	size, err := pageSize(data)
+	if err != nil {
+		return time.Time{}, err
+	}
+	f, err := os.OpenFile(out, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return time.Time{}, err
+	}
+
+	for seq := 0; snap.Segments < 0 || seq < snap.Segments; seq++ {
+		frames, shippedAt, err := get(ctx, store, segmentKey(prefix, snap.Index, seq))
+		if errors.Is(err, storage.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return restoredTo, fmt.Errorf("failed to download WAL segment %d/%d: %w", snap.Index, seq, err)
+		}
+		if !at.IsZero() && shippedAt.After(at) {
+			break
+		}
+		if err := applyFrames(f, size, frames); err != nil {
+			return restoredTo, fmt.Errorf("failed to apply WAL segment %d/%d: %w", snap.Index, seq, err)
+		}
+		restoredTo = shippedAt
+	}
+	return restoredTo, f.Sync()
+}
+
+// Snapshots lists the snapshots kept under prefix, oldest first
+func Snapshots(ctx context.Context, store storage.Backend, prefix string) ([]Snapshot, error) {
+	m, err := readManifest(ctx, store, prefix)
+	return m.Snapshots, err
+}
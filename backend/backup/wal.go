@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// SQLite's WAL file format: a 32 byte header, then frames of a 24 byte
+// header and one page. A transaction's last frame records the database size
+// in pages; frames belong to the current WAL only if their salts match the
+// header's and their checksums continue the chain.
+// See https://www.sqlite.org/fileformat2.html#walformat
+const (
+	walHeaderSize   = 32
+	frameHeaderSize = 24
+)
+
+var errBadWAL = errors.New("not a SQLite WAL file")
+
+// walHeader is the part of the WAL header needed to follow its frames
+type walHeader struct {
+	bigEndian bool
+	pageSize  int
+	salt      [8]byte
+	checksum  [2]uint32
+}
+
+func readWALHeader(b []byte) (walHeader, error) {
+	var h walHeader
+	if len(b) < walHeaderSize {
+		return h, errBadWAL
+	}
+	switch binary.BigEndian.Uint32(b[0:4]) {
+	case 0x377f0682:
+	case 0x377f0683:
+		h.bigEndian = true
+	default:
+		return h, errBadWAL
+	}
+	h.pageSize = int(binary.BigEndian.Uint32(b[8:12]))
+	copy(h.salt[:], b[16:24])
+	h.checksum = walChecksum(h.bigEndian, [2]uint32{}, b[:24])
+	if h.checksum[0] != binary.BigEndian.Uint32(b[24:28]) || h.checksum[1] != binary.BigEndian.Uint32(b[28:32]) {
+		return h, errBadWAL
+	}
+	return h, nil
+}
+
+// walChecksum continues the WAL checksum chain from s over b
+func walChecksum(bigEndian bool, s [2]uint32, b []byte) [2]uint32 {
+	order := binary.ByteOrder(binary.LittleEndian)
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(b); i += 8 {
+		s[0] += order.Uint32(b[i:]) + s[1]
+		s[1] += order.Uint32(b[i+4:]) + s[0]
+	}
+	return s
+}
+
+// committedFrames reads the frames of the WAL at path from offset, and
+// returns the bytes up to the end of the last complete transaction with the
+// checksum at that point. It stops at the first frame that is not part of
+// the current WAL, such as a leftover from before the WAL was restarted or
+// one still being written.
+func committedFrames(path string, h walHeader, offset int64, checksum [2]uint32) ([]byte, [2]uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, checksum, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, checksum, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, checksum, err
+	}
+
+	size := frameHeaderSize + h.pageSize
+	committed, committedSum := 0, checksum
+	for n := 0; n+size <= len(data); n += size {
+		frame := data[n : n+size]
+		if [8]byte(frame[8:16]) != h.salt {
+			break
+		}
+		checksum = walChecksum(h.bigEndian, checksum, frame[:8])
+		checksum = walChecksum(h.bigEndian, checksum, frame[frameHeaderSize:])
+		if checksum[0] != binary.BigEndian.Uint32(frame[16:20]) || checksum[1] != binary.BigEndian.Uint32(frame[20:24]) {
+			break
+		}
+		if binary.BigEndian.Uint32(frame[4:8]) != 0 {
+			committed, committedSum = n+size, checksum
+		}
+	}
+	return data[:committed], committedSum, nil
+}
+
+// applyFrames writes shipped frames into the database file f. They are
+// complete transactions, so after each commit frame the file is cut to the
+// size it records.
+func applyFrames(f *os.File, pageSize int, frames []byte) error {
+	size := frameHeaderSize + pageSize
+	if len(frames)%size != 0 {
+		return errors.New("WAL segment is not a whole number of frames")
+	}
+	for n := 0; n < len(frames); n += size {
+		frame := frames[n : n+size]
+		page := int64(binary.BigEndian.Uint32(frame[0:4]))
+		if _, err := f.WriteAt(frame[frameHeaderSize:], (page-1)*int64(pageSize)); err != nil {
+			return err
+		}
+		if pages := int64(binary.BigEndian.Uint32(frame[4:8])); pages != 0 {
+			if err := f.Truncate(pages * int64(pageSize)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pageSize reads the page size from a database file header
+func pageSize(header []byte) (int, error) {
+	if len(header) < 100 || string(header[:16]) != "SQLite format 3\x00" {
+		return 0, errors.New("not a SQLite database")
+	}
+	n := int(binary.BigEndian.Uint16(header[16:18]))
+	if n == 1 {
+		n = 65536
+	}
+	return n, nil
+}
@@ -0,0 +1,422 @@
+// Package billing charges chamas for the platform. Each month's usage is
+// metered per chama: members active in the month, SMS sent on the chama's
+// behalf and payment transactions (mobile money and bank contributions and
+// payouts). On the first of the month an invoice for the month before is
+// generated; a chama whose invoice is still unpaid GraceDays after it fell
+// due is suspended, which makes it read-only until the fees are paid.
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Invoice statuses
+const (
+	StatusIssued = "issued"
+	StatusPaid   = "paid"
+	StatusVoid   = "void"
+)
+
+// Usage kinds recorded as events; active members and payments are counted
+// from the chama's own records
+const (
+	KindSMS = "sms"
+)
+
+// Pricing is what the platform charges, in minor units of Currency
+type Pricing struct {
+	Currency       string `json:"currency"`
+	PerMember      int64  `json:"perMember"`      // each member active in the month
+	PerSMS         int64  `json:"perSms"`         // each SMS sent
+	PerTransaction int64  `json:"perTransaction"` // each payment transaction
+}
+
+var (
+	// Prices are the current prices; invoices keep the prices they were issued at
+	Prices = Pricing{Currency: "KES", PerMember: 2000, PerSMS: 100, PerTransaction: 500}
+	// DueDays is how long a chama has to pay an invoice
+	DueDays = 14
+	// GraceDays is how long after an invoice falls due the chama is suspended
+	GraceDays = 14
+)
+
+var (
+	ErrNotFound    = errors.New("invoice not found")
+	ErrNotIssued   = errors.New("invoice is not awaiting payment")
+	ErrInvalidDate = errors.New("month must be YYYY-MM")
+)
+
+// Usage is what a chama used in a month
+type Usage struct {
+	ChamaID       string `json:"chamaId"`
+	Period        string `json:"period"` // YYYY-MM
+	ActiveMembers int    `json:"activeMembers"`
+	SMS           int    `json:"sms"`
+	Transactions  int    `json:"transactions"`
+}
+
+// Invoice is a month's platform fees for a chama
+type Invoice struct {
+	ID            string     `json:"id"`
+	ChamaID       string     `json:"chamaId"`
+	Period        string     `json:"period"`
+	ActiveMembers int        `json:"activeMembers"`
+	SMS           int        `json:"sms"`
+	Transactions  int        `json:"transactions"`
+	Pricing       Pricing    `json:"pricing"`
+	AmountMinor   int64      `json:"amountMinor"`
+	Currency      string     `json:"currency"`
+	Status        string     `json:"status"`
+	IssuedAt      time.Time  `json:"issuedAt"`
+	DueAt         time.Time  `json:"dueAt"`
+	PaidAt        *time.Time `json:"paidAt,omitempty"`
+	PaymentRef    string     `json:"paymentReference,omitempty"`
+}
+
+// Record meters quantity units of kind for the chama
+func Record(ctx context.Context, db *sql.DB, chamaID, kind string, quantity int) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO usage_events (id, chama_id, kind, quantity) VALUES (?, ?, ?, ?)`,
+		uuid.NewString(), chamaID, kind, quantity)
+	return err
+}
+
+// monthBounds returns the start of period and of the month after
+func monthBounds(period string) (time.Time, time.Time, error) {
+	start, err := time.Parse("2006-01", period)
+	if err != nil {
+		return start, start, ErrInvalidDate
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// Measure counts the chama's usage in period. A member is active in a month
+// when they joined before it ended and are still active, or left during it.
+func Measure(ctx context.Context, db *sql.DB, chamaID, period string) (Usage, error) {
+	u := Usage{ChamaID: chamaID, Period: period}
+	start, end, err := monthBounds(period)
+	if err != nil {
+		return u, err
+	}
+	from, to := start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05")
+
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM chama_members
+		WHERE chama_id = ? AND join_date < ? AND (status = 'active' OR updated_at >= ?)`,
+		chamaID, to, from).Scan(&u.ActiveMembers)
+	if err != nil {
+		return u, fmt.Errorf("failed to count active members: %w", err)
+	}
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(quantity), 0) FROM usage_events
+		WHERE chama_id = ? AND kind = ? AND occurred_at >= ? AND occurred_at < ?`,
+		chamaID, KindSMS, from, to).Scan(&u.SMS)
+	if err != nil {
+		return u, fmt.Errorf("failed to count SMS: %w", err)
+	}
+	err = db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM contributions
+			 WHERE chama_id = ? AND status = 'completed' AND payment_method IS NOT NULL AND payment_method != 'cash'
+			   AND contribution_date >= ? AND contribution_date < ?)
+			+ (SELECT COUNT(*) FROM disbursements
+			   WHERE chama_id = ? AND status = 'completed' AND completed_at >= ? AND completed_at < ?)`,
+		chamaID, from, to, chamaID, from, to).Scan(&u.Transactions)
+	if err != nil {
+		return u, fmt.Errorf("failed to count payment transactions: %w", err)
+	}
+	return u, nil
+}
+
+// Price returns what usage costs at p
+func (p Pricing) Price(u Usage) int64 {
+	return int64(u.ActiveMembers)*p.PerMember + int64(u.SMS)*p.PerSMS + int64(u.Transactions)*p.PerTransaction
+}
+
+// Generate issues the chama's invoice for period at the current prices. It
+// returns the existing invoice if one has been issued already.
+func Generate(ctx context.Context, db *sql.DB, chamaID, period string, now time.Time) (Invoice, error) {
+	inv, _, err := generate(ctx, db, chamaID, period, now)
+	return inv, err
+}
+
+// generate is Generate, also reporting whether the invoice is new
+func generate(ctx context.Context, db *sql.DB, chamaID, period string, now time.Time) (Invoice, bool, error) {
+	if inv, err := find(ctx, db, `chama_id = ? AND period = ?`, chamaID, period); err == nil {
+		return inv, false, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return inv, false, err
+	}
+
+	u, err := Measure(ctx, db, chamaID, period)
+	if err != nil {
+		return Invoice{}, false, err
+	}
+	inv := Invoice{
+		ID:            uuid.NewString(),
+		ChamaID:       chamaID,
+		Period:        period,
+		ActiveMembers: u.ActiveMembers,
+		SMS:           u.SMS,
+		Transactions:  u.Transactions,
+		Pricing:       Prices,
+		AmountMinor:   Prices.Price(u),
+		Currency:      Prices.Currency,
+		Status:        StatusIssued,
+		IssuedAt:      now.UTC(),
+		DueAt:         now.UTC().AddDate(0, 0, DueDays),
+	}
+	if inv.AmountMinor == 0 {
+		inv.Status = StatusPaid
+		inv.PaidAt = &inv.IssuedAt
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO platform_invoices (id, chama_id, period, active_members, sms, transactions, price_per_member,
+		                               price_per_sms, price_per_transaction, amount_minor, currency, status,
+		                               issued_at, due_at, paid_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		inv.ID, chamaID, period, inv.ActiveMembers, inv.SMS, inv.Transactions, inv.Pricing.PerMember,
+		inv.Pricing.PerSMS, inv.Pricing.PerTransaction, inv.AmountMinor, inv.Currency, inv.Status,
+		inv.IssuedAt, inv.DueAt, inv.PaidAt)
+	return inv, err == nil, err
+}
+
+// GenerateAll issues last month's invoice to every chama that is not
+// dissolved and does not have it yet, returning how many were issued
+func GenerateAll(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, now time.Time) (int, error) {
+	period := now.AddDate(0, -1, 0).Format("2006-01")
+	ids, err := chamaIDs(ctx, db, `SELECT id FROM chamas WHERE status != 'dissolved'`)
+	if err != nil {
+		return 0, err
+	}
+	issued := 0
+	for _, id := range ids {
+		inv, created, err := generate(ctx, db, id, period, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to generate invoice", "chama_id", id, "period", period, "error", err)
+			continue
+		}
+		if !created {
+			continue
+		}
+		issued++
+		if inv.Status == StatusIssued {
+			notifyOfficials(ctx, db, notifier, id, inv.ID, "Platform invoice",
+				fmt.Sprintf("Your TujiFund invoice for %s is %s %s, due on %s.",
+					period, inv.Currency, formatMinor(inv.AmountMinor), inv.DueAt.Format("2 Jan 2006")))
+		}
+	}
+	return issued, nil
+}
+
+// List returns the chama's invoices, newest first
+func List(ctx context.Context, db *sql.DB, chamaID string) ([]Invoice, error) {
+	return query(ctx, db, `chama_id = ? ORDER BY period DESC`, chamaID)
+}
+
+// ListByStatus returns every chama's invoices with status, or all, oldest due first
+func ListByStatus(ctx context.Context, db *sql.DB, status string) ([]Invoice, error) {
+	if status == "" {
+		return query(ctx, db, `1 = 1 ORDER BY due_at`)
+	}
+	return query(ctx, db, `status = ? ORDER BY due_at`, status)
+}
+
+// Get returns an invoice
+func Get(ctx context.Context, db *sql.DB, id string) (Invoice, error) {
+	return find(ctx, db, `id = ?`, id)
+}
+
+// MarkPaid records payment of an issued invoice and lifts the chama's
+// suspension once nothing it owes is overdue
+func MarkPaid(ctx context.Context, db *sql.DB, id, reference string, now time.Time) (Invoice, error) {
+	res, err := txn.From(ctx, db).ExecContext(ctx, `
+		UPDATE platform_invoices SET status = ?, paid_at = ?, payment_reference = ? WHERE id = ? AND status = ?`,
+		StatusPaid, now.UTC(), reference, id, StatusIssued)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := Get(ctx, db, id); err != nil {
+			return Invoice{}, err
+		}
+		return Invoice{}, ErrNotIssued
+	}
+	inv, err := Get(ctx, db, id)
+	if err != nil {
+		return inv, err
+	}
+	if overdue, err := hasOverdue(ctx, db, inv.ChamaID, now); err == nil && !overdue {
+		err = Unsuspend(ctx, db, inv.ChamaID)
+		if err != nil {
+			return inv, err
+		}
+	}
+	return inv, nil
+}
+
+// Void cancels an issued invoice, e.g. one issued in error
+func Void(ctx context.Context, db *sql.DB, id string) error {
+	res, err := txn.From(ctx, db).ExecContext(ctx, `UPDATE platform_invoices SET status = ? WHERE id = ? AND status = ?`,
+		StatusVoid, id, StatusIssued)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := Get(ctx, db, id); err != nil {
+			return err
+		}
+		return ErrNotIssued
+	}
+	return nil
+}
+
+// Suspended reports whether the chama is suspended for unpaid fees
+func Suspended(ctx context.Context, db *sql.DB, chamaID string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM billing_suspensions WHERE chama_id = ?`, chamaID).Scan(&n)
+	return n > 0, err
+}
+
+// Unsuspend lifts the chama's suspension
+func Unsuspend(ctx context.Context, db *sql.DB, chamaID string) error {
+	_, err := txn.From(ctx, db).ExecContext(ctx, `DELETE FROM billing_suspensions WHERE chama_id = ?`, chamaID)
+	return err
+}
+
+// SuspendOverdue suspends chamas with an invoice unpaid GraceDays after its
+// due date, and tells their officials
+func SuspendOverdue(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, now time.Time) (int, error) {
+	ids, err := chamaIDs(ctx, db, `
+		SELECT DISTINCT chama_id FROM platform_invoices
+		WHERE status = ? AND due_at < ?
+		  AND chama_id NOT IN (SELECT chama_id FROM billing_suspensions)`,
+		StatusIssued, now.UTC().AddDate(0, 0, -GraceDays))
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO billing_suspensions (chama_id, reason, suspended_at) VALUES (?, ?, ?)`,
+			id, "unpaid platform fees", now.UTC())
+		if err != nil {
+			return 0, err
+		}
+		slog.InfoContext(ctx, "Suspended chama for unpaid platform fees", "chama_id", id)
+		notifyOfficials(ctx, db, notifier, id, id, "Chama suspended",
+			"Your chama has been suspended for unpaid platform fees and is read-only until they are paid.")
+	}
+	return len(ids), nil
+}
+
+// RegisterJobs generates invoices on the first of each month and suspends
+// chamas with overdue invoices daily
+func RegisterJobs(s *jobs.Scheduler, db *sql.DB, notifier *notifications.Notifier) {
+	s.Register("billing_invoices", jobs.Monthly{Day: 1, Hour: 4}, func(ctx context.Context) error {
+		_, err := GenerateAll(ctx, db, notifier, time.Now())
+		return err
+	})
+	s.Register("billing_suspensions", jobs.Daily{Hour: 9}, func(ctx context.Context) error {
+		_, err := SuspendOverdue(ctx, db, notifier, time.Now())
+		return err
+	})
+}
+
+func hasOverdue(ctx context.Context, db *sql.DB, chamaID string, now time.Time) (bool, error) {
+	var n int
+	err := txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM platform_invoices WHERE chama_id = ? AND status = ? AND due_at < ?`,
+		chamaID, StatusIssued, now.UTC()).Scan(&n)
+	return n > 0, err
+}
+
+const invoiceColumns = `id, chama_id, period, active_members, sms, transactions, price_per_member, price_per_sms,
+	price_per_transaction, amount_minor, currency, status, issued_at, due_at, paid_at, COALESCE(payment_reference, '')`
+
+func find(ctx context.Context, db *sql.DB, where string, args ...interface{}) (Invoice, error) {
+	list, err := query(ctx, db, where+` LIMIT 1`, args...)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if len(list) == 0 {
+		return Invoice{}, ErrNotFound
+	}
+	return list[0], nil
+}
+
+func query(ctx context.Context, db *sql.DB, where string, args ...interface{}) ([]Invoice, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `SELECT `+invoiceColumns+` FROM platform_invoices WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Invoice{}
+	for rows.Next() {
+		var inv Invoice
+		var paid sql.NullTime
+		err := rows.Scan(&inv.ID, &inv.ChamaID, &inv.Period, &inv.ActiveMembers, &inv.SMS, &inv.Transactions,
+			&inv.Pricing.PerMember, &inv.Pricing.PerSMS, &inv.Pricing.PerTransaction, &inv.AmountMinor, &inv.Currency,
+			&inv.Status, &inv.IssuedAt, &inv.DueAt, &paid, &inv.PaymentRef)
+		if err != nil {
+			return nil, err
+		}
+		inv.Pricing.Currency = inv.Currency
+		if paid.Valid {
+			inv.PaidAt = &paid.Time
+		}
+		list = append(list, inv)
+	}
+	return list, rows.Err()
+}
+
+func chamaIDs(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// notifyOfficials tells the chama's officials about its billing
+func notifyOfficials(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, chamaID, relatedID, title, message string) {
+	if notifier == nil {
+		return
+	}
+	officials, err := chamas.MembersWithRole(db, chamaID, chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to find chama officials", "chama_id", chamaID, "error", err)
+		return
+	}
+	for _, userID := range officials {
+		if err := notifier.Notify(ctx, notifications.Notification{
+			UserID: userID, Title: title, Message: message, Type: notifications.TypeSystem, RelatedID: relatedID, Priority: notifications.PriorityHigh,
+		}); err != nil {
+			slog.ErrorContext(ctx, "Failed to notify official", "chama_id", chamaID, "user_id", userID, "error", err)
+		}
+	}
+}
+
+func formatMinor(minor int64) string {
+	return fmt.Sprintf("%d.%02d", minor/100, minor%100)
+}
@@ -0,0 +1,167 @@
+package billing
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/txn"
+
+	"github.com/gorilla/mux"
+)
+
+// UsageHandler returns the {chamaId} chama's metered usage for ?month=
+// (YYYY-MM, default this month) and what it would cost at current prices,
+// for its officials
+func UsageHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, ok := authorizeOfficial(db, w, r)
+		if !ok {
+			return
+		}
+		month := r.URL.Query().Get("month")
+		if month == "" {
+			month = time.Now().UTC().Format("2006-01")
+		}
+		u, err := Measure(r.Context(), db, chamaID, month)
+		if errors.Is(err, ErrInvalidDate) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		suspended, err := Suspended(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"usage":          u,
+			"pricing":        Prices,
+			"estimatedMinor": Prices.Price(u),
+			"suspended":      suspended,
+		})
+	}
+}
+
+// InvoicesHandler lists the {chamaId} chama's invoices, for its officials
+func InvoicesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, ok := authorizeOfficial(db, w, r)
+		if !ok {
+			return
+		}
+		list, err := List(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// AdminInvoicesHandler lists every chama's invoices, optionally by ?status=
+func AdminInvoicesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := ListByStatus(r.Context(), db, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// PayHandler records payment of the {invoiceId} invoice with the payment's
+// reference, lifting the chama's suspension if nothing else is overdue
+func PayHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		var request struct {
+			Reference string `json:"reference"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.Reference == "" {
+			http.Error(w, "reference is required", http.StatusBadRequest)
+			return
+		}
+		inv, err := MarkPaid(r.Context(), db, mux.Vars(r)["invoiceId"], request.Reference, time.Now())
+		if !writeInvoiceError(w, err) {
+			return
+		}
+		err = audit.Record(r.Context(), txn.From(r.Context(), db), audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "billing.invoice_paid", EntityType: "platform_invoice", EntityID: inv.ID,
+			OldValues: map[string]interface{}{"status": StatusIssued},
+			NewValues: map[string]interface{}{"status": StatusPaid, "reference": request.Reference},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit invoice payment", "invoice", inv.ID, "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(inv)
+	}
+}
+
+// VoidHandler cancels the {invoiceId} invoice
+func VoidHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		id := mux.Vars(r)["invoiceId"]
+		if !writeInvoiceError(w, Void(r.Context(), db, id)) {
+			return
+		}
+		err := audit.Record(r.Context(), txn.From(r.Context(), db), audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "billing.invoice_void", EntityType: "platform_invoice", EntityID: id,
+			OldValues: map[string]interface{}{"status": StatusIssued},
+			NewValues: map[string]interface{}{"status": StatusVoid},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit invoice void", "invoice", id, "error", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeInvoiceError writes the response for err and reports whether it was nil
+func writeInvoiceError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNotIssued):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// authorizeOfficial returns the {chamaId} chama for its officials, writing
+// an error response and returning false otherwise
+func authorizeOfficial(db *sql.DB, w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return "", false
+	}
+	chamaID := mux.Vars(r)["chamaId"]
+	if !chamas.IsOfficial(db, chamaID, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return chamaID, true
+}
@@ -0,0 +1,58 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+
+	"tujifund-app/backend/otp"
+	"tujifund-app/backend/tenancy"
+
+	"github.com/gorilla/mux"
+)
+
+// meteredSender counts the SMS sent on behalf of a chama
+type meteredSender struct {
+	db   *sql.DB
+	next otp.Sender
+}
+
+// MeterSMS wraps sender so that each SMS sent while serving a chama's
+// route is recorded against the chama. SMS sent outside a chama, such as
+// sign-in codes, are the platform's and are not billed.
+func MeterSMS(db *sql.DB, sender otp.Sender) otp.Sender {
+	return meteredSender{db: db, next: sender}
+}
+
+func (m meteredSender) Send(ctx context.Context, to, message string) error {
+	if err := m.next.Send(ctx, to, message); err != nil {
+		return err
+	}
+	if chamaID := tenancy.ChamaID(ctx); chamaID != "" {
+		if err := Record(ctx, m.db, chamaID, KindSMS, 1); err != nil {
+			slog.ErrorContext(ctx, "Failed to meter SMS", "chama_id", chamaID, "error", err)
+		}
+	}
+	return nil
+}
+
+// Enforce blocks changes to chamas suspended for unpaid fees; they can still
+// be read, and become writable again as soon as the fees are paid
+func Enforce(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				if chamaID := mux.Vars(r)["chamaId"]; chamaID != "" {
+					if suspended, err := Suspended(r.Context(), db, chamaID); err == nil && suspended {
+						http.Error(w, "Chama is suspended for unpaid platform fees", http.StatusPaymentRequired)
+						return
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Package breaker protects calls to external providers. Each provider gets
+// a Breaker that paces calls to the provider's rate limit and, after a run
+// of failures, stops calling it for a while so that an outage fails fast
+// instead of tying up requests, jobs and database connections in timeouts.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"tujifund-app/backend/ratelimit"
+)
+
+// Circuit states
+const (
+	StateClosed   = "closed"    // calls go through
+	StateOpen     = "open"      // calls are refused until the cooldown ends
+	StateHalfOpen = "half_open" // one trial call is let through
+)
+
+// ErrUnavailable is matched by every error a Breaker returns without calling
+// the provider. Work that fails with it should be left queued and retried.
+var ErrUnavailable = errors.New("provider is temporarily unavailable")
+
+// UnavailableError is returned when a call is refused: the circuit is open,
+// or the provider's rate limit would make it wait too long
+type UnavailableError struct {
+	Provider   string
+	Reason     string        // "circuit open" or "rate limited"
+	RetryAfter time.Duration // when a call is likely to be let through
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s is temporarily unavailable (%s)", e.Provider, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrUnavailable) match
+func (e *UnavailableError) Is(target error) bool { return target == ErrUnavailable }
+
+// RetryAfter returns how long to wait before retrying after err, or 0 if
+// err is not an UnavailableError
+func RetryAfter(err error) time.Duration {
+	var u *UnavailableError
+	if errors.As(err, &u) {
+		return u.RetryAfter
+	}
+	return 0
+}
+
+// Config tunes a Breaker. Zero fields take the defaults.
+type Config struct {
+	Failures int              // consecutive failures that open the circuit; 5 by default
+	Cooldown time.Duration    // how long the circuit stays open; 30 seconds by default
+	Timeout  time.Duration    // deadline for each call; 15 seconds by default
+	Rate     ratelimit.Config // the provider's rate limit; none if Rate is zero
+	// MaxWait is how long a call may wait for the rate limit before it is
+	// refused, so that short bursts are smoothed rather than rejected; 2
+	// seconds by default
+	MaxWait time.Duration
+}
+
+// Status is a snapshot of a Breaker for monitoring
+type Status struct {
+	Provider  string     `json:"provider"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"` // consecutive failures so far
+	OpenedAt  *time.Time `json:"openedAt,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+	Calls     int64      `json:"calls"`
+	Refused   int64      `json:"refused"` // calls refused while open or rate limited
+}
+
+// Breaker guards one provider
+type Breaker struct {
+	name    string
+	conf    Config
+	limiter *ratelimit.Limiter
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	trial     bool // a half-open trial call is in flight
+	lastError string
+	calls     int64
+	refused   int64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Breaker{}
+)
+
+// New creates the Breaker for the named provider and registers it for
+// Statuses. Creating a second Breaker with the same name replaces the first.
+func New(name string, conf Config) *Breaker {
+	if conf.Failures <= 0 {
+		conf.Failures = 5
+	}
+	if conf.Cooldown <= 0 {
+		conf.Cooldown = 30 * time.Second
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 15 * time.Second
+	}
+	if conf.MaxWait <= 0 {
+		conf.MaxWait = 2 * time.Second
+	}
+	b := &Breaker{name: name, conf: conf, state: StateClosed}
+	if conf.Rate.Rate > 0 {
+		b.limiter = ratelimit.New("provider:"+name, conf.Rate, nil)
+	}
+	registryMu.Lock()
+	registry[name] = b
+	registryMu.Unlock()
+	return b
+}
+
+// Name returns the provider the Breaker guards
+func (b *Breaker) Name() string { return b.name }
+
+// Do calls fn with a deadline unless the circuit is open or the rate limit
+// cannot be met within MaxWait, in which case it returns an
+// UnavailableError. fn's error counts as a provider failure; fn should
+// return nil for answers the provider gave deliberately, such as rejecting
+// a request, so that only outages open the circuit.
+func (b *Breaker) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := b.admit(time.Now()); err != nil {
+		return err
+	}
+	if err := b.pace(ctx); err != nil {
+		b.release()
+		return err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, b.conf.Timeout)
+	defer cancel()
+	err := fn(callCtx)
+	if err != nil && ctx.Err() != nil {
+		// The caller gave up; that says nothing about the provider
+		b.release()
+		return err
+	}
+	b.record(err, time.Now())
+	return err
+}
+
+// admit refuses calls while the circuit is open, and lets one trial call
+// through once the cooldown is over
+func (b *Breaker) admit(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls++
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.conf.Cooldown {
+		b.state = StateHalfOpen
+	}
+	switch {
+	case b.state == StateOpen:
+		b.refused++
+		return &UnavailableError{Provider: b.name, Reason: "circuit open", RetryAfter: b.conf.Cooldown - now.Sub(b.openedAt)}
+	case b.state == StateHalfOpen && b.trial:
+		b.refused++
+		return &UnavailableError{Provider: b.name, Reason: "circuit open", RetryAfter: time.Second}
+	case b.state == StateHalfOpen:
+		b.trial = true
+	}
+	return nil
+}
+
+// pace waits for the rate limit, up to MaxWait
+func (b *Breaker) pace(ctx context.Context) error {
+	if b.limiter == nil {
+		return nil
+	}
+	deadline := time.Now().Add(b.conf.MaxWait)
+	for {
+		ok, wait := b.limiter.Allow("all")
+		if ok {
+			return nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			b.mu.Lock()
+			b.refused++
+			b.mu.Unlock()
+			return &UnavailableError{Provider: b.name, Reason: "rate limited", RetryAfter: wait}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// release gives up a half-open trial that was not made
+func (b *Breaker) release() {
+	b.mu.Lock()
+	b.trial = false
+	b.mu.Unlock()
+}
+
+// record counts the outcome of a call, opening the circuit after too many
+// consecutive failures or a failed trial and closing it after a success
+func (b *Breaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if err == nil {
+		b.state, b.failures = StateClosed, 0
+		return
+	}
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == StateHalfOpen || b.failures >= b.conf.Failures {
+		b.state, b.openedAt = StateOpen, now
+	}
+}
+
+// Status returns the Breaker's current state
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := Status{
+		Provider: b.name, State: b.state, Failures: b.failures, LastError: b.lastError,
+		Calls: b.calls, Refused: b.refused,
+	}
+	if b.state != StateClosed {
+		opened := b.openedAt
+		s.OpenedAt = &opened
+	}
+	return s
+}
+
+// Statuses returns the state of every registered Breaker, by provider name
+func Statuses() []Status {
+	registryMu.Lock()
+	list := make([]Status, 0, len(registry))
+	for _, b := range registry {
+		list = append(list, b.Status())
+	}
+	registryMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Provider < list[j].Provider })
+	return list
+}
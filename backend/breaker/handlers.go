@@ -0,0 +1,27 @@
+package breaker
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// WriteError answers a request that failed with an UnavailableError with
+// 503 and a Retry-After header, so clients back off instead of retrying at once
+func WriteError(w http.ResponseWriter, err error) {
+	seconds := int(math.Ceil(RetryAfter(err).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+// StatusHandler lists every provider's breaker for monitoring
+func StatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Statuses())
+	}
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// Fetch returns the value cached at key, or calls load and caches its result
+// for ttl. Cache failures are logged and fall through to load, so a cache
+// outage slows reads down rather than breaking them.
+func Fetch[T any](ctx context.Context, c Cache, key string, ttl time.Duration, load func() (T, error)) (T, error) {
+	if c == nil {
+		return load()
+	}
+	if b, ok, err := c.Get(ctx, key); err != nil {
+		slog.WarnContext(ctx, "Cache read failed", "key", key, "error", err)
+	} else if ok {
+		var v T
+		if err := json.Unmarshal(b, &v); err == nil {
+			return v, nil
+		}
+	}
+
+	v, err := load()
+	if err != nil {
+		return v, err
+	}
+	if b, err := json.Marshal(v); err == nil {
+		if err := c.Set(ctx, key, b, ttl); err != nil {
+			slog.WarnContext(ctx, "Cache write failed", "key", key, "error", err)
+		}
+	}
+	return v, nil
+}
+
+// Chama data is cached under a per-chama generation number. Bumping the
+// generation invalidates everything cached for the chama at once, without
+// having to know which keys exist; the old entries simply expire.
+
+func generationKey(chamaID string) string {
+	return "chama:" + chamaID + ":gen"
+}
+
+// ChamaKey returns the cache key for name within the chama's current generation,
+// e.g. ChamaKey(ctx, c, id, "report:2024-01-01:2024-02-01")
+func ChamaKey(ctx context.Context, c Cache, chamaID, name string) string {
+	gen := "0"
+	if c != nil {
+		if b, ok, err := c.Get(ctx, generationKey(chamaID)); err == nil && ok {
+			gen = string(b)
+		}
+	}
+	return "chama:" + chamaID + ":" + gen + ":" + name
+}
+
+// InvalidateChama drops everything cached for the chama. Call it after any
+// write that changes balances, members or settings.
+func InvalidateChama(ctx context.Context, c Cache, chamaID string) {
+	if c == nil || chamaID == "" {
+		return
+	}
+	if _, err := c.Incr(ctx, generationKey(chamaID)); err != nil {
+		slog.ErrorContext(ctx, "Failed to invalidate chama cache", "chama_id", chamaID, "error", err)
+	}
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cache is a shared key-value cache. It is only ever an optimisation:
+// callers must cope with misses and treat errors as misses.
+type Cache interface {
+	// Get returns the value stored at key and whether it was found
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value at key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes keys
+	Delete(ctx context.Context, keys ...string) error
+	// Incr atomically increments the integer at key, starting from 0
+	Incr(ctx context.Context, key string) (int64, error)
+}
+
+// NewFromEnv returns a Redis cache when REDIS_URL is set
+// (redis://[:password@]host:port[/db]), otherwise an in-process one
+func NewFromEnv() (Cache, error) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		return NewMemory(), nil
+	}
+	c, err := NewRedis(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return c, nil
+}
+
+type item struct {
+	value   []byte
+	expires time.Time // zero for no expiry
+}
+
+// Memory is a Cache held in this process. It suits a single server instance;
+// run several behind a load balancer with Redis instead.
+type Memory struct {
+	mu    sync.Mutex
+	items map[string]item
+	sets  int
+}
+
+// NewMemory creates an empty in-process cache
+func NewMemory() *Memory {
+	return &Memory{items: map[string]item{}}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !it.expires.IsZero() && time.Now().After(it.expires) {
+		delete(m.items, key)
+		return nil, false, nil
+	}
+	return it.value, true, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it := item{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		it.expires = time.Now().Add(ttl)
+	}
+	m.items[key] = it
+	// Expired entries are only dropped when read, so sweep now and then
+	// to keep keys from abandoned generations from piling up
+	if m.sets++; m.sets%1000 == 0 {
+		m.sweep()
+	}
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.items, key)
+	}
+	return nil
+}
+
+func (m *Memory) Incr(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	if it, ok := m.items[key]; ok {
+		var err error
+		if n, err = strconv.ParseInt(string(it.value), 10, 64); err != nil {
+			return 0, fmt.Errorf("value at %s is not an integer", key)
+		}
+	}
+	n++
+	m.items[key] = item{value: []byte(strconv.FormatInt(n, 10))}
+	return n, nil
+}
+
+// sweep drops expired entries. The caller must hold m.mu.
+func (m *Memory) sweep() {
+	now := time.Now()
+	for key, it := range m.items {
+		if !it.expires.IsZero() && now.After(it.expires) {
+			delete(m.items, key)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+// Package calendar knows the days money can move in Kenya. M-Pesa agents
+// and banks close on weekends and public holidays, so due dates that would
+// land on one are moved to a day they are open.
+//
+// Public holidays are those of the Public Holidays Act, Easter included,
+// and a holiday falling on a Sunday is also kept on the day after. Platform
+// staff add the holidays gazetted each year, such as Idd-ul-Fitr, and may
+// cancel a statutory one. Each chama may open days it would otherwise be
+// closed on, e.g. every Saturday if its agents trade then, or close days of
+// its own.
+package calendar
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Date is the layout of days in the calendar's tables and API
+const Date = "2006-01-02"
+
+// Statutory holidays on a fixed date, by month and day
+var Statutory = []struct {
+	Month time.Month
+	Day   int
+	Name  string
+}{
+	{time.January, 1, "New Year's Day"},
+	{time.May, 1, "Labour Day"},
+	{time.June, 1, "Madaraka Day"},
+	{time.October, 10, "Mazingira Day"},
+	{time.October, 20, "Mashujaa Day"},
+	{time.December, 12, "Jamhuri Day"},
+	{time.December, 25, "Christmas Day"},
+	{time.December, 26, "Boxing Day"},
+}
+
+// ErrInvalidDate is returned for days not given as YYYY-MM-DD
+var ErrInvalidDate = errors.New("date must be YYYY-MM-DD")
+
+// Holiday is a public holiday, or with Observed false, the cancellation of
+// a statutory one
+type Holiday struct {
+	Date      string `json:"date"`
+	Name      string `json:"name"`
+	Observed  bool   `json:"observed"`
+	Gazetted  bool   `json:"gazetted"` // added by platform staff, rather than statutory
+	CreatedBy string `json:"createdBy,omitempty"`
+}
+
+// Day is a chama's override of whether it can pay on a day
+type Day struct {
+	Date string `json:"date"`
+	Open bool   `json:"open"`
+	Note string `json:"note,omitempty"`
+}
+
+// Calendar is the days money moves for one chama. The zero Calendar knows
+// the statutory holidays and weekends only.
+type Calendar struct {
+	Gazetted      map[string]Holiday `json:"-"` // by date, including cancellations
+	OpenSaturdays bool               `json:"openSaturdays"`
+	Days          map[string]Day     `json:"-"` // the chama's overrides, by date
+}
+
+// Querier is satisfied by *sql.DB, *sql.Tx and *sql.Conn
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// easter returns Easter Sunday of year, by the anonymous Gregorian algorithm
+func easter(year int, loc *time.Location) time.Time {
+	a, b, c := year%19, year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	return time.Date(year, time.Month(month), (h+l-7*m+114)%31+1, 0, 0, 0, 0, loc)
+}
+
+// Holidays returns the public holidays of year by date, after gazetted
+// additions and cancellations, with those on a Sunday also kept on the next
+// day that is not already a holiday
+func (c Calendar) Holidays(year int, loc *time.Location) map[string]string {
+	var days []time.Time
+	names := map[string]string{}
+	add := func(t time.Time, name string) {
+		key := t.Format(Date)
+		if h, ok := c.Gazetted[key]; ok && !h.Observed {
+			return
+		}
+		if _, ok := names[key]; !ok {
+			days = append(days, t)
+		}
+		names[key] = name
+	}
+	for _, h := range Statutory {
+		add(time.Date(year, h.Month, h.Day, 0, 0, 0, 0, loc), h.Name)
+	}
+	e := easter(year, loc)
+	add(e.AddDate(0, 0, -2), "Good Friday")
+	add(e.AddDate(0, 0, 1), "Easter Monday")
+	for _, h := range c.Gazetted {
+		if t, err := time.ParseInLocation(Date, h.Date, loc); err == nil && t.Year() == year && h.Observed {
+			add(t, h.Name)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	for _, t := range days {
+		if t.Weekday() != time.Sunday {
+			continue
+		}
+		next := t.AddDate(0, 0, 1)
+		for names[next.Format(Date)] != "" {
+			next = next.AddDate(0, 0, 1)
+		}
+		names[next.Format(Date)] = names[t.Format(Date)] + " (observed)"
+	}
+	return names
+}
+
+// Holiday returns the name of the public holiday on t, if it is one
+func (c Calendar) Holiday(t time.Time) (string, bool) {
+	name, ok := c.Holidays(t.Year(), t.Location())[t.Format(Date)]
+	return name, ok
+}
+
+// Open reports whether money can move on t for the chama
+func (c Calendar) Open(t time.Time) bool {
+	if d, ok := c.Days[t.Format(Date)]; ok {
+		return d.Open
+	}
+	switch t.Weekday() {
+	case time.Sunday:
+		return false
+	case time.Saturday:
+		if !c.OpenSaturdays {
+			return false
+		}
+	}
+	_, holiday := c.Holiday(t)
+	return !holiday
+}
+
+// Next returns t if it is open, or the same time on the first open day
+// after it
+func (c Calendar) Next(t time.Time) time.Time {
+	for i := 0; i < 31 && !c.Open(t); i++ {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// Previous returns t if it is open, or the same time on the last open day
+// before it
+func (c Calendar) Previous(t time.Time) time.Time {
+	for i := 0; i < 31 && !c.Open(t); i++ {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// Within moves a due date t that is not open to the open day before it, or
+// after it when that would leave [start, end), so that money paid on the
+// new due date still counts towards the same period
+func (c Calendar) Within(t, start, end time.Time) time.Time {
+	if prev := c.Previous(t); !prev.Before(start) {
+		return prev
+	}
+	if next := c.Next(t); next.Before(end) {
+		return next
+	}
+	return t
+}
+
+// Load returns the chama's calendar: the gazetted holidays, and its own
+// overrides
+func Load(ctx context.Context, q Querier, chamaID string) (Calendar, error) {
+	var c Calendar
+	var err error
+	if c.Gazetted, err = gazetted(ctx, q); err != nil {
+		return c, err
+	}
+	err = q.QueryRowContext(ctx, `SELECT open_saturdays FROM chama_calendars WHERE chama_id = ?`, chamaID).
+		Scan(&c.OpenSaturdays)
+	if err != nil && err != sql.ErrNoRows {
+		return c, err
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT day, open, COALESCE(note, '') FROM chama_calendar_days WHERE chama_id = ?`, chamaID)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+	c.Days = map[string]Day{}
+	for rows.Next() {
+		var d Day
+		if err := rows.Scan(&d.Date, &d.Open, &d.Note); err != nil {
+			return c, err
+		}
+		c.Days[d.Date] = d
+	}
+	return c, rows.Err()
+}
+
+func gazetted(ctx context.Context, q Querier) (map[string]Holiday, error) {
+	rows, err := q.QueryContext(ctx, `SELECT day, name, observed, COALESCE(created_by, '') FROM public_holidays`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := map[string]Holiday{}
+	for rows.Next() {
+		h := Holiday{Gazetted: true}
+		if err := rows.Scan(&h.Date, &h.Name, &h.Observed, &h.CreatedBy); err != nil {
+			return nil, err
+		}
+		list[h.Date] = h
+	}
+	return list, rows.Err()
+}
+
+// List returns the public holidays of year in date order, with the gazetted
+// cancellations of statutory holidays
+func List(ctx context.Context, q Querier, year int) ([]Holiday, error) {
+	g, err := gazetted(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	c := Calendar{Gazetted: g}
+	list := []Holiday{}
+	for date, name := range c.Holidays(year, time.UTC) {
+		h := g[date]
+		list = append(list, Holiday{Date: date, Name: name, Observed: true, Gazetted: h.Gazetted && h.Observed, CreatedBy: h.CreatedBy})
+	}
+	for date, h := range g {
+		if !h.Observed && date[:4] == strconv.Itoa(year) {
+			list = append(list, h)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Date < list[j].Date })
+	return list, nil
+}
+
+// parse checks date is YYYY-MM-DD
+func parse(date string) error {
+	if _, err := time.Parse(Date, date); err != nil {
+		return ErrInvalidDate
+	}
+	return nil
+}
+
+// SetHoliday adds a gazetted holiday on h.Date, or with h.Observed false
+// cancels the statutory holiday on it
+func SetHoliday(ctx context.Context, db *sql.DB, h Holiday) error {
+	if err := parse(h.Date); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO public_holidays (day, name, observed, created_by) VALUES (?, ?, ?, ?)
+		ON CONFLICT(day) DO UPDATE SET name = excluded.name, observed = excluded.observed,
+		    created_by = excluded.created_by, created_at = CURRENT_TIMESTAMP`,
+		h.Date, h.Name, h.Observed, h.CreatedBy)
+	return err
+}
+
+// RemoveHoliday removes the gazetted holiday or cancellation on date,
+// reporting whether there was one
+func RemoveHoliday(ctx context.Context, db *sql.DB, date string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM public_holidays WHERE day = ?`, date)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// SetOpenSaturdays records whether the chama can pay on Saturdays
+func SetOpenSaturdays(ctx context.Context, db *sql.DB, chamaID string, open bool, by string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO chama_calendars (chama_id, open_saturdays, updated_by) VALUES (?, ?, ?)
+		ON CONFLICT(chama_id) DO UPDATE SET open_saturdays = excluded.open_saturdays,
+		    updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP`,
+		chamaID, open, by)
+	return err
+}
+
+// SetDay overrides whether the chama can pay on d.Date
+func SetDay(ctx context.Context, db *sql.DB, chamaID string, d Day, by string) error {
+	if err := parse(d.Date); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO chama_calendar_days (chama_id, day, open, note, created_by) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(chama_id, day) DO UPDATE SET open = excluded.open, note = excluded.note,
+		    created_by = excluded.created_by, created_at = CURRENT_TIMESTAMP`,
+		chamaID, d.Date, d.Open, nullIfEmpty(d.Note), by)
+	return err
+}
+
+// RemoveDay removes the chama's override of date, reporting whether there
+// was one
+func RemoveDay(ctx context.Context, db *sql.DB, chamaID, date string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM chama_calendar_days WHERE chama_id = ? AND day = ?`, chamaID, date)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,281 @@
+package calendar
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+
+	"github.com/gorilla/mux"
+)
+
+// UpcomingDays is how far ahead a chama's calendar lists the days it is closed
+const UpcomingDays = 90
+
+// Closed is a day a chama cannot pay on, and why
+type Closed struct {
+	Date   string `json:"date"`
+	Reason string `json:"reason"` // the holiday's name, "weekend" or the chama's note
+}
+
+// HolidaysHandler lists the public holidays of ?year=, this year by default
+func HolidaysHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		year := time.Now().Year()
+		if v := r.URL.Query().Get("year"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 2000 || n > 2100 {
+				http.Error(w, "year must be between 2000 and 2100", http.StatusBadRequest)
+				return
+			}
+			year = n
+		}
+		list, err := List(r.Context(), db, year)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// SetHolidayHandler adds the public holiday gazetted on {date}, or with
+// "observed": false cancels the statutory holiday on it
+func SetHolidayHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		var request struct {
+			Name     string `json:"name"`
+			Observed *bool  `json:"observed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		h := Holiday{Date: mux.Vars(r)["date"], Name: strings.TrimSpace(request.Name), Observed: true, CreatedBy: userID}
+		if request.Observed != nil {
+			h.Observed = *request.Observed
+		}
+		if h.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		if !writeCalendarError(w, SetHoliday(r.Context(), db, h)) {
+			return
+		}
+		err := audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "calendar.holiday_set", EntityType: "public_holiday", EntityID: h.Date,
+			NewValues: map[string]interface{}{"name": h.Name, "observed": h.Observed},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit public holiday", "date", h.Date, "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h)
+	}
+}
+
+// RemoveHolidayHandler removes the gazetted holiday or cancellation on {date}
+func RemoveHolidayHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		date := mux.Vars(r)["date"]
+		removed, err := RemoveHoliday(r.Context(), db, date)
+		if !writeCalendarError(w, err) {
+			return
+		}
+		if !removed {
+			http.Error(w, "Holiday not found", http.StatusNotFound)
+			return
+		}
+		err = audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "calendar.holiday_removed", EntityType: "public_holiday", EntityID: date,
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit public holiday removal", "date", date, "error", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ChamaHandler returns the {chamaId} chama's calendar: whether it pays on
+// Saturdays, its overrides, and the days it is closed over the next
+// UpcomingDays, for its members
+func ChamaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		writeChamaCalendar(w, r, db, chamaID)
+	}
+}
+
+// UpdateChamaHandler sets whether the {chamaId} chama pays on Saturdays,
+// for its officials
+func UpdateChamaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, userID, ok := authorizeOfficial(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			OpenSaturdays bool `json:"openSaturdays"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !writeCalendarError(w, SetOpenSaturdays(r.Context(), db, chamaID, request.OpenSaturdays, userID)) {
+			return
+		}
+		err := audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "calendar.updated", EntityType: "chama", EntityID: chamaID,
+			NewValues: map[string]interface{}{"openSaturdays": request.OpenSaturdays},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit chama calendar", "chama_id", chamaID, "error", err)
+		}
+		writeChamaCalendar(w, r, db, chamaID)
+	}
+}
+
+// SetDayHandler opens or closes {date} for the {chamaId} chama, with an
+// optional note, for its officials
+func SetDayHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, userID, ok := authorizeOfficial(db, w, r)
+		if !ok {
+			return
+		}
+		var d Day
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		d.Date, d.Note = mux.Vars(r)["date"], strings.TrimSpace(d.Note)
+		if !writeCalendarError(w, SetDay(r.Context(), db, chamaID, d, userID)) {
+			return
+		}
+		err := audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "calendar.day_set", EntityType: "chama", EntityID: chamaID,
+			NewValues: d,
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit chama calendar day", "chama_id", chamaID, "error", err)
+		}
+		writeChamaCalendar(w, r, db, chamaID)
+	}
+}
+
+// RemoveDayHandler removes the {chamaId} chama's override of {date}, for
+// its officials
+func RemoveDayHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, userID, ok := authorizeOfficial(db, w, r)
+		if !ok {
+			return
+		}
+		date := mux.Vars(r)["date"]
+		removed, err := RemoveDay(r.Context(), db, chamaID, date)
+		if !writeCalendarError(w, err) {
+			return
+		}
+		if !removed {
+			http.Error(w, "Day not found", http.StatusNotFound)
+			return
+		}
+		err = audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "calendar.day_removed", EntityType: "chama", EntityID: chamaID,
+			OldValues: map[string]interface{}{"date": date},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit chama calendar day", "chama_id", chamaID, "error", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeChamaCalendar(w http.ResponseWriter, r *http.Request, db *sql.DB, chamaID string) {
+	c, err := Load(r.Context(), db, chamaID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	days := []Day{}
+	for _, d := range c.Days {
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+
+	closed := []Closed{}
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for t := today; t.Before(today.AddDate(0, 0, UpcomingDays)); t = t.AddDate(0, 0, 1) {
+		if c.Open(t) {
+			continue
+		}
+		reason := "weekend"
+		if d, ok := c.Days[t.Format(Date)]; ok {
+			reason = d.Note
+			if reason == "" {
+				reason = "closed by the chama"
+			}
+		} else if name, ok := c.Holiday(t); ok {
+			reason = name
+		}
+		closed = append(closed, Closed{Date: t.Format(Date), Reason: reason})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chamaId":       chamaID,
+		"openSaturdays": c.OpenSaturdays,
+		"days":          days,
+		"closed":        closed,
+	})
+}
+
+// authorizeOfficial returns the {chamaId} chama and the caller for its
+// officials, writing an error response and returning false otherwise
+func authorizeOfficial(db *sql.DB, w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return "", "", false
+	}
+	chamaID := mux.Vars(r)["chamaId"]
+	if !chamas.IsOfficial(db, chamaID, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", "", false
+	}
+	return chamaID, userID, true
+}
+
+// writeCalendarError writes the response for err and reports whether it was nil
+func writeCalendarError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrInvalidDate):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package chamas
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"tujifund-app/backend/tenancy"
+
+	"github.com/gorilla/mux"
+)
+
+// MemberRecord is a member as returned by the list and export APIs
+type MemberRecord struct {
+	UserID    string `json:"userId"`
+	Username  string `json:"username"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Phone     string `json:"phoneNumber"`
+	Role      string `json:"role"`
+	Status    string `json:"status"`
+	JoinDate  string `json:"joinDate"`
+}
+
+// MemberQuery returns the SQL and arguments for listing a chama's members,
+// honouring the optional status and role query parameters
+func MemberQuery(r *http.Request) (string, []interface{}) {
+	return MembersQuery(mux.Vars(r)["chamaId"], r.URL.Query().Get("status"), r.URL.Query().Get("role"))
+}
+
+// MembersQuery returns the SQL and arguments for listing chamaID's members,
+// optionally only those with status and role
+func MembersQuery(chamaID, status, role string) (string, []interface{}) {
+	query := `
+		SELECT u.user_id, u.username, COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), u.email,
+		       COALESCE(u.phone_number, ''), m.role, m.status, m.join_date
+		FROM chama_members m JOIN users u ON u.user_id = m.user_id
+		WHERE m.chama_id = ?`
+	args := []interface{}{chamaID}
+	if status != "" {
+		query += " AND m.status = ?"
+		args = append(args, status)
+	}
+	if role != "" {
+		query += " AND m.role = ?"
+		args = append(args, role)
+	}
+	return query + " ORDER BY u.first_name, u.last_name", args
+}
+
+// ScanMember reads a row selected by MemberQuery
+func ScanMember(rows *sql.Rows) (MemberRecord, error) {
+	var m MemberRecord
+	err := rows.Scan(&m.UserID, &m.Username, &m.FirstName, &m.LastName, &m.Email, &m.Phone, &m.Role, &m.Status, &m.JoinDate)
+	return m, err
+}
+
+// MembersHandler lists the members of the {chamaId} chama. Only members can see the list.
+func MembersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		if !IsMember(db, mux.Vars(r)["chamaId"], userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// Scoped as well, so the list can only ever hold this chama's members
+		query, args := MemberQuery(r)
+		rows, err := tenancy.Scoped(db).On("m.chama_id").QueryContext(r.Context(), query, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		members := []MemberRecord{}
+		for rows.Next() {
+			m, err := ScanMember(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			members = append(members, m)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(members)
+	}
+}
@@ -0,0 +1,138 @@
+package chamas
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"tujifund-app/backend/tenancy"
+	"tujifund-app/backend/txn"
+)
+
+// Member roles
+const (
+	RoleAdmin       = "admin"
+	RoleChairperson = "chairperson"
+	RoleTreasurer   = "treasurer"
+	RoleSecretary   = "secretary"
+	RoleMember      = "member"
+)
+
+// OfficialRoles can manage chama records on behalf of members
+var OfficialRoles = []string{RoleAdmin, RoleChairperson, RoleTreasurer, RoleSecretary}
+
+// MemberRole returns the user's role in an active membership, or "" if the
+// user is not an active member of the chama. Like the other lookups here it
+// is scoped to the chama it names, whatever the caller is scoped to.
+func MemberRole(db *sql.DB, chamaID, userID string) (string, error) {
+	return MemberRoleContext(context.Background(), db, chamaID, userID)
+}
+
+// MemberRoleContext is MemberRole within the request's transaction, if ctx
+// carries one
+func MemberRoleContext(ctx context.Context, db *sql.DB, chamaID, userID string) (string, error) {
+	var role string
+	ctx = tenancy.WithChama(ctx, chamaID)
+	err := txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT role FROM chama_members
+		WHERE chama_id = ? AND user_id = ? AND status = 'active'`,
+		chamaID, userID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// IsMember reports whether the user is an active member of the chama
+func IsMember(db *sql.DB, chamaID, userID string) bool {
+	return IsMemberContext(context.Background(), db, chamaID, userID)
+}
+
+// IsMemberContext is IsMember within the request's transaction
+func IsMemberContext(ctx context.Context, db *sql.DB, chamaID, userID string) bool {
+	role, err := MemberRoleContext(ctx, db, chamaID, userID)
+	return err == nil && role != ""
+}
+
+// IsOfficial reports whether the user holds an official role in the chama
+func IsOfficial(db *sql.DB, chamaID, userID string) bool {
+	return HasRoleContext(context.Background(), db, chamaID, userID, OfficialRoles...)
+}
+
+// IsOfficialContext is IsOfficial within the request's transaction
+func IsOfficialContext(ctx context.Context, db *sql.DB, chamaID, userID string) bool {
+	return HasRoleContext(ctx, db, chamaID, userID, OfficialRoles...)
+}
+
+// IsOfficialAnywhere reports whether the user holds an official role in any chama
+func IsOfficialAnywhere(db *sql.DB, userID string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(tenancy.WithAllChamas(context.Background()), `
+		SELECT EXISTS (
+			SELECT 1 FROM chama_members
+			WHERE user_id = ? AND status = 'active' AND role IN (?`+strings.Repeat(", ?", len(OfficialRoles)-1)+`)
+		)`,
+		append([]interface{}{userID}, toArgs(OfficialRoles)...)...,
+	).Scan(&exists)
+	return exists, err
+}
+
+func toArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+// HasRole reports whether the user's role in the chama is one of roles
+func HasRole(db *sql.DB, chamaID, userID string, roles ...string) bool {
+	return HasRoleContext(context.Background(), db, chamaID, userID, roles...)
+}
+
+// HasRoleContext is HasRole within the request's transaction
+func HasRoleContext(ctx context.Context, db *sql.DB, chamaID, userID string, roles ...string) bool {
+	role, err := MemberRoleContext(ctx, db, chamaID, userID)
+	if err != nil || role == "" {
+		return false
+	}
+	for _, r := range roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// MembersWithRole returns the user IDs of the chama's active members whose
+// role is one of roles
+func MembersWithRole(db *sql.DB, chamaID string, roles ...string) ([]string, error) {
+	return MembersWithRoleContext(context.Background(), db, chamaID, roles...)
+}
+
+// MembersWithRoleContext is MembersWithRole within the request's transaction
+func MembersWithRoleContext(ctx context.Context, db *sql.DB, chamaID string, roles ...string) ([]string, error) {
+	query := `SELECT user_id FROM chama_members WHERE chama_id = ? AND status = 'active' AND role IN (?` +
+		strings.Repeat(", ?", len(roles)-1) + `)`
+	args := []interface{}{chamaID}
+	for _, r := range roles {
+		args = append(args, r)
+	}
+	ctx = tenancy.WithChama(ctx, chamaID)
+	rows, err := txn.From(ctx, db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
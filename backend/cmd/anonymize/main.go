@@ -0,0 +1,80 @@
+// Command anonymize copies a Tujifund database with every member replaced by
+// realistic fakes, so developers can debug against production-shaped data
+// without handling anyone's personal data:
+//
+//	go run ./cmd/anonymize -src data/tujifund.db -out /tmp/tujifund-anon.db
+//
+// The source database is only read. Uploaded files are not copied, so
+// document downloads in the copy fail. Every account in the copy can sign in
+// with -password.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"tujifund-app/backend/privacy"
+
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	src := flag.String("src", "data/tujifund.db", "database to anonymize; it is not changed")
+	out := flag.String("out", "", "path of the anonymized copy, which must not exist")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed for the fakes; the same seed gives the same fakes")
+	password := flag.String("password", "tujifund-dev", "password set on every account in the copy")
+	flag.Parse()
+
+	if err := run(context.Background(), *src, *out, *seed, *password); err != nil {
+		fmt.Fprintln(os.Stderr, "anonymize:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, src, out string, seed int64, password string) error {
+	if out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	if _, err := os.Stat(out); err == nil {
+		return fmt.Errorf("%s already exists", out)
+	}
+
+	source, err := sql.Open("sqlite", "file:"+src+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	// VACUUM INTO takes a consistent copy even while the app is writing
+	if _, err := source.ExecContext(ctx, `VACUUM INTO ?`, out); err != nil {
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", out)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	sum, err := privacy.Anonymize(ctx, db, seed, password)
+	if err != nil {
+		os.Remove(out)
+		return fmt.Errorf("failed to anonymize copy: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `VACUUM`); err != nil {
+		// Deleted values may linger in free pages until the copy is vacuumed
+		os.Remove(out)
+		return fmt.Errorf("failed to vacuum copy: %w", err)
+	}
+	slog.Info("Anonymized database written", "out", out, "seed", seed, "users", sum.Users, "phones", sum.Phones,
+		"references", sum.References, "documents", sum.Documents)
+	return nil
+}
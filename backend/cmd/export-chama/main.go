@@ -0,0 +1,84 @@
+// Command export-chama writes a chama and everything it owns to a file, to
+// be loaded into another deployment with cmd/import-chama:
+//
+//	go run ./cmd/export-chama -db data/tujifund.db -chama <chama id> -out chama.json.gz -files /tmp/chama-files
+//
+// With -files, the uploaded files the export lists are downloaded into that
+// directory under their storage keys, from storage configured from the
+// same environment as the app. The database is only read, and the export is
+// consistent even while the app is running.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"tujifund-app/backend/portable"
+	"tujifund-app/backend/storage"
+
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	path := flag.String("db", "data/tujifund.db", "database to export from; it is not changed")
+	chamaID := flag.String("chama", "", "chama to export")
+	out := flag.String("out", "", "path of the export, which must not exist")
+	files := flag.String("files", "", "directory to download the chama's uploaded files into; not downloaded if empty")
+	flag.Parse()
+
+	if err := run(context.Background(), *path, *chamaID, *out, *files); err != nil {
+		fmt.Fprintln(os.Stderr, "export-chama:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, path, chamaID, out, files string) error {
+	if chamaID == "" || out == "" {
+		return fmt.Errorf("-chama and -out are required")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	trailer, err := portable.Export(ctx, db, chamaID, f)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(out)
+		return err
+	}
+	total := 0
+	for table, n := range trailer.Rows {
+		fmt.Printf("%s\t%d\n", table, n)
+		total += n
+	}
+	fmt.Printf("Exported %d rows and a manifest of %d documents to %s\n", total, len(trailer.Documents), out)
+
+	if files == "" {
+		return nil
+	}
+	store, err := storage.NewFromEnv()
+	if err != nil {
+		return err
+	}
+	n, err := portable.SaveFiles(ctx, store, trailer.Documents, files)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Downloaded %d files to %s\n", n, files)
+	return nil
+}
@@ -0,0 +1,85 @@
+// Command import-chama loads a chama written by cmd/export-chama into this
+// deployment's database, under new IDs:
+//
+//	go run ./cmd/import-chama -db data/tujifund.db -in chama.json.gz -files /tmp/chama-files
+//
+// Members who already have an account here, by email or phone number, keep
+// it; the others are created with their exported passwords. With -files,
+// the uploaded files downloaded alongside the export are uploaded to
+// storage configured from the same environment as the app. The database's
+// schema must be at least as new as the one exported from. Nothing is
+// written if the import fails.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"tujifund-app/backend/portable"
+	"tujifund-app/backend/storage"
+
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	path := flag.String("db", "data/tujifund.db", "database to import into")
+	in := flag.String("in", "", "export to import")
+	name := flag.String("name", "", "new name for the chama; it keeps its exported name if empty")
+	by := flag.String("by", "", "user ID of the platform staff member running the import, for the audit log")
+	files := flag.String("files", "", "directory the chama's uploaded files were downloaded into; not uploaded if empty")
+	flag.Parse()
+
+	if err := run(context.Background(), *path, *in, *files, portable.Options{Name: *name, By: *by}); err != nil {
+		fmt.Fprintln(os.Stderr, "import-chama:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, path, in, files string, opts portable.Options) error {
+	if in == "" {
+		return fmt.Errorf("-in is required")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := portable.Import(ctx, db, f, opts)
+	if err != nil {
+		return err
+	}
+	for table, n := range res.Rows {
+		fmt.Printf("%s\t%d\n", table, n)
+	}
+	fmt.Printf("Imported chama %s as %s: %d users created, %d matched to existing accounts\n",
+		res.SourceChamaID, res.ChamaID, res.UsersCreated, res.UsersMatched)
+
+	if files == "" {
+		if len(res.Documents) > 0 {
+			fmt.Printf("%d documents are listed in the export; copy them across with -files\n", len(res.Documents))
+		}
+		return nil
+	}
+	store, err := storage.NewFromEnv()
+	if err != nil {
+		return err
+	}
+	n, err := portable.LoadFiles(ctx, store, res.Documents, files)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Uploaded %d files from %s\n", n, files)
+	return nil
+}
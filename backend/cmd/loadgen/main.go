@@ -0,0 +1,74 @@
+// Command loadgen fills a database with synthetic chamas, members and
+// contributions for load testing:
+//
+//	go run ./cmd/loadgen -db data/loadtest.db
+//	go run ./cmd/loadgen -driver postgres -dsn "host=localhost dbname=tujifund_load sslmode=disable"
+//
+// The defaults create 10,000 chamas of 10 members with 1,000,000
+// contributions over the last year. The schema is created first if the
+// database is empty. Never point it at a database real members use.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tujifund-app/backend/loadtest"
+)
+
+func main() {
+	conf := loadtest.DefaultConfig
+	driver := flag.String("driver", "sqlite", "sqlite or postgres")
+	path := flag.String("db", "data/loadtest.db", "SQLite database to fill")
+	dsn := flag.String("dsn", "", "PostgreSQL connection string")
+	schema := flag.String("schema", "", "schema file (default database/database_schema.sql, or database/schema_postgres.sql for postgres)")
+	flag.IntVar(&conf.Chamas, "chamas", conf.Chamas, "chamas to create")
+	flag.IntVar(&conf.MembersPerChama, "members", conf.MembersPerChama, "members in each chama")
+	flag.IntVar(&conf.Contributions, "contributions", conf.Contributions, "contributions to create, spread over the chamas")
+	flag.IntVar(&conf.Months, "months", conf.Months, "months of history the contributions are spread over")
+	flag.Int64Var(&conf.Seed, "seed", conf.Seed, "seed for the generated data")
+	flag.Parse()
+
+	if err := run(context.Background(), *driver, *path, *dsn, *schema, conf); err != nil {
+		fmt.Fprintln(os.Stderr, "loadgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, driver, path, dsn, schema string, conf loadtest.Config) error {
+	if driver == "sqlite" {
+		dsn = "file:" + path + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	}
+	if schema == "" {
+		schema = filepath.Join("database", "database_schema.sql")
+		if driver == "postgres" {
+			schema = filepath.Join("database", "schema_postgres.sql")
+		}
+	}
+	db, err := loadtest.Open(driver, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := loadtest.InitializeSchema(ctx, db, schema); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	step := max(conf.Chamas/20, 1)
+	sum, err := loadtest.Generate(ctx, db, conf, func(s loadtest.Summary) {
+		if s.Chamas%step == 0 {
+			fmt.Printf("%d/%d chamas\t%d contributions\t%s\n", s.Chamas, conf.Chamas, s.Contributions, time.Since(start).Round(time.Second))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created %d chamas, %d members and %d contributions in %s\n",
+		sum.Chamas, sum.Members, sum.Contributions, time.Since(start).Round(time.Second))
+	return nil
+}
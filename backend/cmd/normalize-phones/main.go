@@ -0,0 +1,56 @@
+// Command normalize-phones rewrites the phone numbers stored before numbers
+// were normalised into E.164 form, +254XXXXXXXXX, so that payments can be
+// matched to members whichever way their numbers were typed:
+//
+//	go run ./cmd/normalize-phones -db data/tujifund.db -dry-run
+//
+// Numbers that are not Kenyan mobile numbers, such as masked or foreign
+// ones, are counted and left alone. It runs in one transaction and is safe
+// to run again.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"tujifund-app/backend/phone"
+
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	path := flag.String("db", "data/tujifund.db", "database to normalise")
+	dryRun := flag.Bool("dry-run", false, "report what would change without changing it")
+	flag.Parse()
+
+	if err := run(context.Background(), *path, *dryRun); err != nil {
+		fmt.Fprintln(os.Stderr, "normalize-phones:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, path string, dryRun bool) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	results, err := phone.Backfill(ctx, db, dryRun)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		fmt.Printf("%s.%s\tchecked %d\tupdated %d\tinvalid %d\n", r.Table, r.Column, r.Checked, r.Updated, r.Invalid)
+	}
+	if dryRun {
+		fmt.Println("dry run: nothing was changed")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Command rebuild-ledger derives chamas' ledger entries, account balances and
+// dashboard summaries again from the ledger event stream, e.g. after a
+// projection bug has been fixed:
+//
+//	go run ./cmd/rebuild-ledger -db data/tujifund.db -chama <chama id>
+//
+// Every chama is rebuilt if -chama is empty. Entries posted before
+// LEDGER_EVENT_SOURCING was turned on are imported into the stream first.
+// Stop the app while it runs.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"tujifund-app/backend/dashboard"
+	"tujifund-app/backend/ledger"
+
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	path := flag.String("db", "data/tujifund.db", "database to rebuild")
+	chamaID := flag.String("chama", "", "chama to rebuild; every chama if empty")
+	flag.Parse()
+
+	if err := run(context.Background(), *path, *chamaID); err != nil {
+		fmt.Fprintln(os.Stderr, "rebuild-ledger:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, path, chamaID string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	dashboard.RegisterProjection()
+
+	ids := []string{chamaID}
+	if chamaID == "" {
+		if ids, err = chamaIDs(ctx, db); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		r, err := ledger.Rebuild(ctx, db, id)
+		if err != nil {
+			return fmt.Errorf("chama %s: %w", id, err)
+		}
+		fmt.Printf("%s\timported %d\treplayed %d\tarchived %d\n", r.ChamaID, r.Imported, r.Replayed, r.Archived)
+	}
+	return nil
+}
+
+func chamaIDs(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id FROM chamas ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,84 @@
+// Command restore rebuilds the database from its replica in object storage
+// as it was at a point in time, e.g. just before an accidental delete:
+//
+//	go run ./cmd/restore -to 2026-10-16T09:30:00+03:00 -out data/restored.db
+//
+// Storage is configured from the same environment as the app, and the
+// replica is found under REPLICA_PREFIX unless -prefix is given. -list shows
+// the snapshots kept, i.e. how far back a restore can go. Stop the app and
+// move the restored file into place to recover.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"tujifund-app/backend/backup"
+	"tujifund-app/backend/storage"
+
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	prefix := flag.String("prefix", os.Getenv("REPLICA_PREFIX"), "where the replica is kept in storage")
+	to := flag.String("to", "", "restore to this time (RFC 3339); the latest replicated state if empty")
+	out := flag.String("out", "", "path of the restored database, which must not exist")
+	list := flag.Bool("list", false, "list the snapshots kept instead of restoring")
+	flag.Parse()
+
+	if err := run(context.Background(), *prefix, *to, *out, *list); err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, prefix, to, out string, list bool) error {
+	if prefix == "" {
+		return fmt.Errorf("-prefix or REPLICA_PREFIX is required")
+	}
+	store, err := storage.NewFromEnv()
+	if err != nil {
+		return err
+	}
+	if list {
+		snapshots, err := backup.Snapshots(ctx, store, prefix)
+		if err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			fmt.Printf("%d\t%s\n", s.Index, s.TakenAt.Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	if out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	var at time.Time
+	if to != "" {
+		if at, err = time.Parse(time.RFC3339, to); err != nil {
+			return fmt.Errorf("-to must be an RFC 3339 time such as 2026-10-16T09:30:00+03:00")
+		}
+	}
+	restoredTo, err := backup.Restore(ctx, store, prefix, at, out)
+	if err != nil {
+		os.Remove(out)
+		return err
+	}
+
+	db, err := sql.Open("sqlite", out)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result); err != nil || result != "ok" {
+		return fmt.Errorf("restored database failed its integrity check: %v %s", err, result)
+	}
+	fmt.Printf("Restored %s as of %s\n", out, restoredTo.Format(time.RFC3339))
+	return nil
+}
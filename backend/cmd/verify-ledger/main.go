@@ -0,0 +1,86 @@
+// Command verify-ledger checks chamas' ledger hash chains, proving that no
+// entry has been edited, removed or reordered since it was posted:
+//
+//	go run ./cmd/verify-ledger -db data/tujifund.db -chama <chama id>
+//
+// Every chama is checked if -chama is empty. It prints each chain's head
+// hash; a head noted at an earlier audit must still appear in the chain for
+// the books up to then to be unchanged. It exits with status 2 if any chain
+// is broken. The database is only read.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"tujifund-app/backend/ledger"
+
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	path := flag.String("db", "data/tujifund.db", "database to verify; it is not changed")
+	chamaID := flag.String("chama", "", "chama to verify; every chama if empty")
+	flag.Parse()
+
+	ok, err := run(context.Background(), *path, *chamaID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "verify-ledger:", err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(2)
+	}
+}
+
+func run(ctx context.Context, path, chamaID string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		return false, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	ids := []string{chamaID}
+	if chamaID == "" {
+		if ids, err = chamaIDs(ctx, db); err != nil {
+			return false, err
+		}
+	}
+	ok := true
+	for _, id := range ids {
+		v, err := ledger.Verify(ctx, db, id)
+		if err != nil {
+			return false, fmt.Errorf("chama %s: %w", id, err)
+		}
+		if !v.Valid {
+			ok = false
+			fmt.Printf("%s\tBROKEN at entry %s: %s\n", v.ChamaID, v.BrokenAt, v.Reason)
+			continue
+		}
+		fmt.Printf("%s\tok\t%d entries\t%d unsealed\thead %s\n", v.ChamaID, v.Entries, v.Unsealed, v.Head)
+	}
+	return ok, nil
+}
+
+func chamaIDs(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id FROM chamas ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
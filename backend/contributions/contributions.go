@@ -0,0 +1,479 @@
+package contributions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/fx"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/receipts"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Contribution statuses
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+var (
+	// ErrNoFund is returned when a chama has no open fund to receive contributions
+	ErrNoFund = errors.New("chama has no open fund to receive contributions")
+	// ErrNotPending is returned when confirming or rejecting a settled contribution
+	ErrNotPending = errors.New("contribution is no longer pending")
+	// ErrNotManual is returned when confirming a contribution its provider reports on
+	ErrNotManual = errors.New("only bank transfers are confirmed by hand")
+	// ErrSelfConfirmation is returned when an official confirms their own transfer
+	ErrSelfConfirmation = errors.New("another official must confirm your own transfer")
+)
+
+// Contribution is a member's payment into a chama fund
+type Contribution struct {
+	ID                string      `json:"id"`
+	ChamaID           string      `json:"chamaId"`
+	MemberID          string      `json:"memberId"`
+	AccountID         string      `json:"accountId"`
+	Amount            money.Money `json:"amount"`
+	Date              time.Time   `json:"contributionDate"`
+	Method            string      `json:"paymentMethod,omitempty"`
+	Reference         string      `json:"transactionReference,omitempty"`
+	ProviderReference string      `json:"-"`
+	Status            string      `json:"status"`
+	ProofKey          string      `json:"-"`
+	HasProof          bool        `json:"hasProof"`
+	ConfirmedBy       string      `json:"confirmedBy,omitempty"`
+	Notes             string      `json:"notes,omitempty"`
+	// Original is what the member paid in another currency. Amount is in that
+	// currency until the contribution completes and is converted into the
+	// fund's currency at ExchangeRate (money.RateScale).
+	Original     *money.Money `json:"originalAmount,omitempty"`
+	ExchangeRate int64        `json:"exchangeRate,omitempty"`
+}
+
+const columns = `id, chama_id, member_id, account_id, amount_minor, currency, contribution_date,
+	COALESCE(payment_method, ''), COALESCE(transaction_reference, ''), COALESCE(provider_reference, ''), status,
+	COALESCE(payment_proof_url, ''), COALESCE(confirmed_by, ''), COALESCE(notes, ''), original_amount_minor,
+	COALESCE(original_currency, ''), COALESCE(exchange_rate, 0)`
+
+func scan(row interface{ Scan(...interface{}) error }) (Contribution, error) {
+	var c Contribution
+	var original sql.NullInt64
+	var originalCurrency string
+	err := row.Scan(&c.ID, &c.ChamaID, &c.MemberID, &c.AccountID, &c.Amount.Amount, &c.Amount.Currency, &c.Date,
+		&c.Method, &c.Reference, &c.ProviderReference, &c.Status, &c.ProofKey, &c.ConfirmedBy, &c.Notes, &original,
+		&originalCurrency, &c.ExchangeRate)
+	c.HasProof = c.ProofKey != ""
+	if original.Valid {
+		m := money.New(original.Int64, originalCurrency)
+		c.Original = &m
+	}
+	return c, err
+}
+
+// Get returns a single contribution
+func Get(ctx context.Context, db *sql.DB, id string) (Contribution, error) {
+	return scan(txn.From(ctx, db).QueryRowContext(ctx, `SELECT `+columns+` FROM contributions WHERE id = ?`, id))
+}
+
+// List returns a chama's contributions, newest first, optionally filtered by
+// payment method and status
+func List(ctx context.Context, db *sql.DB, chamaID, method, status string) ([]Contribution, error) {
+	query := `SELECT ` + columns + ` FROM contributions WHERE chama_id = ?`
+	args := []interface{}{chamaID}
+	if method != "" {
+		query += ` AND payment_method = ?`
+		args = append(args, method)
+	}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	rows, err := txn.From(ctx, db).QueryContext(ctx, query+` ORDER BY contribution_date DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Contribution{}
+	for rows.Next() {
+		c, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// ForMembers returns the chama's contributions from each of memberIDs,
+// newest first, in one query
+func ForMembers(ctx context.Context, db *sql.DB, chamaID string, memberIDs []string) (map[string][]Contribution, error) {
+	byMember := map[string][]Contribution{}
+	if len(memberIDs) == 0 {
+		return byMember, nil
+	}
+	args := []interface{}{chamaID}
+	for _, id := range memberIDs {
+		args = append(args, id)
+	}
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT `+columns+` FROM contributions
+		WHERE chama_id = ? AND member_id IN (?`+strings.Repeat(", ?", len(memberIDs)-1)+`)
+		ORDER BY contribution_date DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		byMember[c.MemberID] = append(byMember[c.MemberID], c)
+	}
+	return byMember, rows.Err()
+}
+
+// DefaultFund is the fund contributions go to when the member does not pick
+// one: the chama's savings fund, or failing that its oldest open fund
+func DefaultFund(ctx context.Context, db *sql.DB, chamaID string) (string, error) {
+	var id string
+	err := txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT id FROM chama_accounts WHERE chama_id = ? AND status = 'active'
+		ORDER BY account_type = 'savings' DESC, account_type = 'general' DESC, created_at LIMIT 1`,
+		chamaID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", ErrNoFund
+	}
+	return id, err
+}
+
+// Request records a pending contribution and asks provider to collect it
+// from phone. Mobile money contributions are completed by the provider's
+// callback; bank transfers by an official with Confirm.
+func Request(ctx context.Context, db *sql.DB, provider payments.Provider, c Contribution, phone string) (Contribution, error) {
+	if provider == nil {
+		return c, payments.ErrNoProvider
+	}
+	if c.Amount.Amount <= 0 {
+		return c, errors.New("contribution amount must be positive")
+	}
+	if c.AccountID == "" {
+		var err error
+		if c.AccountID, err = DefaultFund(ctx, db, c.ChamaID); err != nil {
+			return c, err
+		}
+	}
+	c.Method, c.Date = provider.Name(), time.Now().UTC()
+	if err := insert(ctx, db, &c); err != nil {
+		return c, err
+	}
+
+	ref, err := provider.InitiatePayment(ctx, payments.PaymentRequest{
+		Reference: c.ID, Phone: phone, Amount: c.Amount, Description: "Contribution",
+	})
+	if err != nil {
+		txn.From(ctx, db).ExecContext(ctx, `UPDATE contributions SET status = ?, notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			StatusFailed, err.Error(), c.ID)
+		return c, err
+	}
+	c.ProviderReference = ref
+	_, err = txn.From(ctx, db).ExecContext(ctx, `
+		UPDATE contributions SET provider_reference = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, ref, c.ID)
+	return c, err
+}
+
+// Checkout records a pending contribution and opens provider's hosted
+// checkout page for it, typically a card payment from a member abroad in
+// their own currency. The provider's webhook completes it, converting the
+// amount into the fund's currency.
+func Checkout(ctx context.Context, db *sql.DB, provider payments.CheckoutProvider, c Contribution) (Contribution, payments.Checkout, error) {
+	if provider == nil {
+		return c, payments.Checkout{}, payments.ErrNoProvider
+	}
+	if c.Amount.Amount <= 0 {
+		return c, payments.Checkout{}, errors.New("contribution amount must be positive")
+	}
+	if c.AccountID == "" {
+		var err error
+		if c.AccountID, err = DefaultFund(ctx, db, c.ChamaID); err != nil {
+			return c, payments.Checkout{}, err
+		}
+	}
+	c.Method, c.Date = provider.Name(), time.Now().UTC()
+	if err := insert(ctx, db, &c); err != nil {
+		return c, payments.Checkout{}, err
+	}
+
+	checkout, err := provider.CreateCheckout(ctx, payments.PaymentRequest{
+		Reference: c.ID, Amount: c.Amount, Description: "Contribution",
+	})
+	if err != nil {
+		txn.From(ctx, db).ExecContext(ctx, `UPDATE contributions SET status = ?, notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			StatusFailed, err.Error(), c.ID)
+		return c, checkout, err
+	}
+	c.ProviderReference = checkout.Reference
+	_, err = txn.From(ctx, db).ExecContext(ctx, `
+		UPDATE contributions SET provider_reference = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, checkout.Reference, c.ID)
+	return c, checkout, err
+}
+
+// Record adds a contribution that was paid outside the app, e.g. straight to
+// the paybill, and completes it. c needs a member, amount, method and date.
+func Record(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, c Contribution, recordedBy string) (Contribution, error) {
+	if c.Amount.Amount <= 0 {
+		return c, errors.New("contribution amount must be positive")
+	}
+	if c.AccountID == "" {
+		var err error
+		if c.AccountID, err = DefaultFund(ctx, db, c.ChamaID); err != nil {
+			return c, err
+		}
+	}
+	if err := insert(ctx, db, &c); err != nil {
+		return c, err
+	}
+	if err := settle(ctx, db, store, notifier, c, recordedBy); err != nil {
+		txn.From(ctx, db).ExecContext(ctx, `UPDATE contributions SET status = ?, notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			StatusFailed, err.Error(), c.ID)
+		return c, err
+	}
+	return Get(ctx, db, c.ID)
+}
+
+// insert stores c as a new pending contribution
+func insert(ctx context.Context, db *sql.DB, c *Contribution) error {
+	c.ID, c.Status, c.HasProof = uuid.NewString(), StatusPending, c.ProofKey != ""
+	_, err := txn.From(ctx, db).ExecContext(ctx, `
+		INSERT INTO contributions (id, chama_id, member_id, account_id, amount_minor, currency, contribution_date,
+		                           payment_method, transaction_reference, status, payment_proof_url, notes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.ChamaID, c.MemberID, c.AccountID, c.Amount.Amount, c.Amount.Currency, c.Date, c.Method,
+		nullIfEmpty(c.Reference), c.Status, nullIfEmpty(c.ProofKey), nullIfEmpty(c.Notes))
+	if err != nil {
+		return fmt.Errorf("failed to record contribution: %w", err)
+	}
+	return nil
+}
+
+// Processor completes or fails contributions from provider's callbacks,
+// posting completed ones to the ledger and issuing a receipt. A completed
+// or failed callback is only acted on once the provider confirms it. Callbacks for
+// contributions already settled are ignored, so replays are safe.
+func Processor(provider payments.Provider, store storage.Backend, notifier *notifications.Notifier) payments.Processor {
+	return func(ctx context.Context, db *sql.DB, payload []byte) error {
+		res, err := provider.HandleCallback(payload)
+		if err != nil {
+			return err
+		}
+		if res, err = payments.Confirm(ctx, provider, res); err != nil {
+			return err
+		}
+		return apply(ctx, db, store, notifier, provider.Name(), res)
+	}
+}
+
+// apply settles the pending contribution a provider result reports on
+func apply(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, provider string, res payments.PaymentResult) error {
+	c, err := scan(txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT `+columns+` FROM contributions WHERE payment_method = ? AND provider_reference = ?`,
+		provider, res.ProviderReference))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no %s contribution for payment %s", provider, res.ProviderReference)
+	}
+	if err != nil {
+		return err
+	}
+	// A contribution given up on by the reconciler is still completed if the
+	// money turns up after all
+	if c.Status == StatusCompleted || (c.Status == StatusFailed && res.Status != payments.PaymentCompleted) {
+		return nil
+	}
+
+	switch res.Status {
+	case payments.PaymentPending:
+		return nil
+	case payments.PaymentFailed:
+		if !res.Confirmed {
+			return fmt.Errorf("%w: %s payment %s", payments.ErrUnconfirmed, provider, res.ProviderReference)
+		}
+		_, err := txn.From(ctx, db).ExecContext(ctx, `
+			UPDATE contributions SET status = ?, notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+			StatusFailed, res.Message, c.ID, StatusPending)
+		return err
+	}
+	// Only a payment the provider has confirmed is settled. Its status
+	// queries do not all repeat the amount, which it then vouches for as
+	// requested; an amount that is reported must be the contribution's.
+	switch {
+	case !res.Confirmed:
+		return fmt.Errorf("%w: %s payment %s", payments.ErrUnconfirmed, provider, res.ProviderReference)
+	case res.Amount == 0:
+	case res.Amount != c.Amount.Amount:
+		return fmt.Errorf("%s paid %d but contribution %s is for %d", provider, res.Amount, c.ID, c.Amount.Amount)
+	}
+	if res.Receipt != "" {
+		c.Reference = res.Receipt
+	}
+	return settle(ctx, db, store, notifier, c, "")
+}
+
+// Confirm completes a pending bank transfer once an official has matched it
+// against the bank statement
+func Confirm(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, id, confirmedBy string) (Contribution, error) {
+	c, err := Get(ctx, db, id)
+	if err != nil {
+		return c, err
+	}
+	switch {
+	case c.Method != payments.ProviderBank:
+		return c, ErrNotManual
+	case c.Status != StatusPending:
+		return c, ErrNotPending
+	case c.MemberID == confirmedBy:
+		return c, ErrSelfConfirmation
+	}
+	if err := settle(ctx, db, store, notifier, c, confirmedBy); err != nil {
+		return c, err
+	}
+	return Get(ctx, db, id)
+}
+
+// Match completes the pending contribution id with a payment found on a
+// statement. reference is the statement's transaction code, if any.
+func Match(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, id, reference, matchedBy string) (Contribution, error) {
+	c, err := Get(ctx, db, id)
+	if err != nil {
+		return c, err
+	}
+	if c.Status != StatusPending {
+		return c, ErrNotPending
+	}
+	if reference != "" {
+		c.Reference = reference
+	}
+	if err := settle(ctx, db, store, notifier, c, matchedBy); err != nil {
+		return c, err
+	}
+	return Get(ctx, db, id)
+}
+
+// Reject fails a pending bank transfer that could not be matched
+func Reject(ctx context.Context, db *sql.DB, id, rejectedBy, reason string) (Contribution, error) {
+	c, err := Get(ctx, db, id)
+	if err != nil {
+		return c, err
+	}
+	if c.Method != payments.ProviderBank {
+		return c, ErrNotManual
+	}
+	res, err := txn.From(ctx, db).ExecContext(ctx, `
+		UPDATE contributions SET status = ?, notes = ?, confirmed_by = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?`,
+		StatusFailed, reason, rejectedBy, id, StatusPending)
+	if err != nil {
+		return c, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c, ErrNotPending
+	}
+	return Get(ctx, db, id)
+}
+
+// settle completes c and issues its receipt
+func settle(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, c Contribution, confirmedBy string) error {
+	if err := complete(ctx, db, &c, confirmedBy); err != nil {
+		return err
+	}
+	_, err := receipts.Issue(ctx, db, store, notifier, receipts.Payment{
+		ChamaID: c.ChamaID, UserID: c.MemberID, Type: receipts.PaymentContribution, PaymentID: c.ID,
+		Amount: c.Amount, Method: c.Method, Reference: c.Reference, PaidAt: time.Now().UTC(),
+	})
+	if err != nil {
+		// The payment stands; the receipt can be issued again later
+		slog.ErrorContext(ctx, "Failed to issue contribution receipt", "contribution_id", c.ID, "error", err)
+	}
+	return nil
+}
+
+// complete marks a pending contribution paid, or one the reconciler gave up
+// on, and posts it to the ledger. An amount paid in another currency is first
+// converted into the fund's currency at the day's rate, which is kept on both
+// the contribution and its ledger entry.
+func complete(ctx context.Context, db *sql.DB, c *Contribution, confirmedBy string) error {
+	var currency string
+	if err := txn.From(ctx, db).QueryRowContext(ctx, `SELECT currency FROM chama_accounts WHERE id = ?`, c.AccountID).Scan(&currency); err != nil {
+		return fmt.Errorf("failed to load fund %s: %w", c.AccountID, err)
+	}
+	now := time.Now().UTC()
+	amount, conversion, err := fx.Convert(ctx, db, c.Amount, currency, now)
+	if err != nil {
+		return err
+	}
+	var original, originalCurrency, rate interface{}
+	if conversion != nil {
+		original, originalCurrency, rate = conversion.Original.Amount, conversion.Original.Currency, conversion.Rate
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE contributions SET status = ?, transaction_reference = ?, confirmed_by = ?, amount_minor = ?, currency = ?,
+			original_amount_minor = ?, original_currency = ?, exchange_rate = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status IN (?, ?)`,
+		StatusCompleted, nullIfEmpty(c.Reference), nullIfEmpty(confirmedBy), amount.Amount, amount.Currency,
+		original, originalCurrency, rate, c.ID, StatusPending, StatusFailed)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	_, err = ledger.Post(ctx, tx, ledger.Entry{
+		ChamaID:     c.ChamaID,
+		AccountID:   c.AccountID,
+		MemberID:    c.MemberID,
+		Type:        ledger.TypeContribution,
+		Amount:      amount,
+		Reference:   c.ID,
+		Description: strings.TrimSpace("Contribution via " + c.Method + " " + c.Reference),
+		CreatedBy:   confirmedBy,
+		EffectiveAt: now,
+		FX:          conversion,
+	})
+	if err != nil {
+		return err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return err
+	}
+	if conversion != nil {
+		c.Amount, c.Original, c.ExchangeRate = amount, &conversion.Original, conversion.Rate
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
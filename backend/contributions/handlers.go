@@ -0,0 +1,483 @@
+package contributions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/fx"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/phone"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/validation"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// MaxProofSize is the largest accepted deposit slip
+const MaxProofSize = 10 << 20 // 10 MB
+
+// ConfirmerRoles may confirm or reject bank transfers
+var ConfirmerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// PayHandler starts a contribution to the {chamaId} chama paid with the
+// mobile money provider. The payment prompt goes to phone, or the caller's
+// own number when it is left out.
+func PayHandler(db *sql.DB, provider string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMemberContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Amount    string `json:"amount"`
+			AccountID string `json:"accountId"`
+			Phone     string `json:"phone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.Phone == "" {
+			txn.From(r.Context(), db).QueryRowContext(r.Context(), `SELECT COALESCE(phone_number, '') FROM users WHERE user_id = ?`, userID).
+				Scan(&request.Phone)
+		}
+		v := validation.New()
+		if v.Required("amount", request.Amount) {
+			v.Amount("amount", request.Amount)
+		}
+		if v.Required("phone", request.Phone) {
+			v.Phone("phone", request.Phone)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		request.Phone = phone.Canonical(request.Phone)
+
+		currency, err := money.ChamaCurrencyContext(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		amount, _ := money.Parse(request.Amount, currency)
+		p, _ := payments.Lookup(provider)
+		c, err := Request(r.Context(), db, p, Contribution{
+			ChamaID: chamaID, MemberID: userID, AccountID: request.AccountID, Amount: amount,
+		}, request.Phone)
+		switch {
+		case errors.Is(err, payments.ErrNoProvider):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case errors.Is(err, breaker.ErrUnavailable):
+			breaker.WriteError(w, err)
+			return
+		case errors.Is(err, ErrNoFund):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// CardHandler starts a card contribution to the {chamaId} chama with
+// {"amount", "currency", "accountId"}, the currency defaulting to the
+// chama's. It answers with the contribution and the checkout page to send
+// the member to; the amount is converted into the chama's currency once paid.
+func CardHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMemberContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Amount    string `json:"amount"`
+			Currency  string `json:"currency"`
+			AccountID string `json:"accountId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		request.Currency = strings.ToUpper(strings.TrimSpace(request.Currency))
+		v := validation.New()
+		if v.Required("amount", request.Amount) {
+			v.Amount("amount", request.Amount)
+		}
+		if request.Currency != "" {
+			v.OneOf("currency", request.Currency, money.Codes()...)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		currency, err := money.ChamaCurrencyContext(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		if request.Currency == "" {
+			request.Currency = currency
+		}
+		// Refuse up front rather than take money that cannot be converted
+		if _, err := fx.Rate(r.Context(), db, request.Currency, currency, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		amount, err := money.Parse(request.Amount, request.Currency)
+		if err != nil {
+			v.Add("amount", validation.CodeAmount, nil)
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		p, _ := payments.Lookup(payments.ProviderCard)
+		provider, _ := p.(payments.CheckoutProvider)
+		c, checkout, err := Checkout(r.Context(), db, provider, Contribution{
+			ChamaID: chamaID, MemberID: userID, AccountID: request.AccountID, Amount: amount,
+		})
+		switch {
+		case errors.Is(err, payments.ErrNoProvider):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case errors.Is(err, breaker.ErrUnavailable):
+			breaker.WriteError(w, err)
+			return
+		case errors.Is(err, ErrNoFund):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"contribution": c, "checkout": checkout})
+	}
+}
+
+// BankTransferHandler records a contribution the caller paid into the
+// {chamaId} chama's bank account. It accepts a multipart form with amount,
+// reference (the bank's transaction reference), an optional accountId, an
+// optional currency for members paying from abroad and the deposit slip as
+// "file". The contribution waits for an official to confirm it, and an amount
+// in another currency is converted into the chama's when it is confirmed.
+func BankTransferHandler(db *sql.DB, store storage.Backend, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMemberContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MaxProofSize+1<<20)
+		if err := r.ParseMultipartForm(MaxProofSize); err != nil {
+			http.Error(w, "Invalid upload or file too large", http.StatusBadRequest)
+			return
+		}
+		amount := r.FormValue("amount")
+		reference := strings.TrimSpace(r.FormValue("reference"))
+		paidIn := strings.ToUpper(strings.TrimSpace(r.FormValue("currency")))
+
+		v := validation.New()
+		if v.Required("amount", amount) {
+			v.Amount("amount", amount)
+		}
+		v.Required("reference", reference)
+		if paidIn != "" {
+			v.OneOf("currency", paidIn, money.Codes()...)
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			v.Add("file", validation.CodeRequired, nil)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		defer file.Close()
+
+		currency, err := money.ChamaCurrencyContext(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		mimeType, err := storage.DetectType(file, header.Size, MaxProofSize, storage.DocumentTypes)
+		if errors.Is(err, storage.ErrTooLarge) {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Only JPEG, PNG and PDF files are accepted", http.StatusUnsupportedMediaType)
+			return
+		}
+		key := "contributions/" + chamaID + "/" + uuid.NewString() + storage.Extensions[mimeType]
+		if err := store.Put(r.Context(), key, file, header.Size, mimeType); err != nil {
+			http.Error(w, "Failed to store document", http.StatusInternalServerError)
+			return
+		}
+
+		if paidIn == "" {
+			paidIn = currency
+		}
+		c := Contribution{ChamaID: chamaID, MemberID: userID, AccountID: r.FormValue("accountId"),
+			Reference: reference, ProofKey: key}
+		if c.Amount, err = money.Parse(amount, paidIn); err != nil {
+			v.Add("amount", validation.CodeAmount, nil)
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		c, err = Request(r.Context(), db, payments.BankTransfer{}, c, "")
+		if errors.Is(err, ErrNoFund) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyConfirmers(r.Context(), db, notifier, c)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// PendingHandler lists the {chamaId} chama's bank transfers awaiting confirmation
+func PendingHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRoleContext(r.Context(), db, chamaID, userID, ConfirmerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := List(r.Context(), db, chamaID, payments.ProviderBank, StatusPending)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// ConfirmHandler confirms the {contributionId} bank transfer, posting it to
+// the ledger and issuing the member's receipt
+func ConfirmHandler(db *sql.DB, store storage.Backend, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reviewHandler(db, w, r, "confirm_bank_transfer", func(c Contribution, userID string) (Contribution, error) {
+			return Confirm(r.Context(), db, store, notifier, c.ID, userID)
+		})
+	}
+}
+
+// RejectHandler rejects the {contributionId} bank transfer with a reason
+func RejectHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || strings.TrimSpace(request.Reason) == "" {
+			http.Error(w, "A reason is required when rejecting a transfer", http.StatusBadRequest)
+			return
+		}
+		reviewHandler(db, w, r, "reject_bank_transfer", func(c Contribution, userID string) (Contribution, error) {
+			c, err := Reject(r.Context(), db, c.ID, userID, strings.TrimSpace(request.Reason))
+			if err == nil {
+				notifyRejected(r.Context(), db, notifier, c)
+			}
+			return c, err
+		})
+	}
+}
+
+func reviewHandler(db *sql.DB, w http.ResponseWriter, r *http.Request, action string, review func(c Contribution, userID string) (Contribution, error)) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	c, err := Get(r.Context(), db, mux.Vars(r)["contributionId"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Contribution not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !chamas.HasRoleContext(r.Context(), db, c.ChamaID, userID, ConfirmerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	c, err = review(c, userID)
+	switch {
+	case errors.Is(err, ErrNotPending), errors.Is(err, ErrNotManual), errors.Is(err, ErrSelfConfirmation):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ledger.ErrAccountClosed), errors.Is(err, ledger.ErrCurrencyMismatch),
+		errors.Is(err, ledger.ErrPeriodClosed), errors.Is(err, ledger.ErrPeriodLocked):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, fx.ErrNoRate):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = audit.Record(r.Context(), txn.From(r.Context(), db), audit.FromRequest(r, audit.Entry{
+		UserID: userID, Action: action, EntityType: "contribution", EntityID: c.ID,
+		NewValues: map[string]interface{}{"status": c.Status, "reference": c.Reference, "notes": c.Notes},
+	}))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to audit bank transfer review", "contribution_id", c.ID, "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// CheckHandler asks the provider about the {contributionId} contribution if
+// it is still pending, for apps waiting on a payment whose callback is late
+func CheckHandler(db *sql.DB, store storage.Backend, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		c, err := Get(r.Context(), db, mux.Vars(r)["contributionId"])
+		if err != nil || (c.MemberID != userID && !chamas.IsOfficialContext(r.Context(), db, c.ChamaID, userID)) {
+			http.Error(w, "Contribution not found", http.StatusNotFound)
+			return
+		}
+		if err := Check(r.Context(), db, store, notifier, c, time.Now().UTC()); err != nil {
+			if errors.Is(err, breaker.ErrUnavailable) {
+				breaker.WriteError(w, err)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if c, err = Get(r.Context(), db, c.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// ProofHandler redirects to the deposit slip of the {contributionId} contribution
+func ProofHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		c, err := Get(r.Context(), db, mux.Vars(r)["contributionId"])
+		if err != nil || c.ProofKey == "" ||
+			(c.MemberID != userID && !chamas.HasRoleContext(r.Context(), db, c.ChamaID, userID, ConfirmerRoles...)) {
+			http.Error(w, "Deposit slip not found", http.StatusNotFound)
+			return
+		}
+		url, err := store.SignedURL(c.ProofKey, 5*time.Minute)
+		if err != nil {
+			http.Error(w, "Failed to create download link", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
+
+// notifyConfirmers asks the chama's officials, other than the member, to check c
+func notifyConfirmers(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, c Contribution) {
+	if notifier == nil {
+		return
+	}
+	officials, err := chamas.MembersWithRoleContext(ctx, db, c.ChamaID, ConfirmerRoles...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load transfer confirmers", "contribution_id", c.ID, "error", err)
+		return
+	}
+	var member string
+	txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT TRIM(COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')) FROM users WHERE user_id = ?`,
+		c.MemberID).Scan(&member)
+	params := map[string]string{"member": member, "amount": c.Amount.String(), "reference": c.Reference}
+	for _, id := range officials {
+		if id == c.MemberID {
+			continue
+		}
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "bank_transfer.submitted", nil),
+			Message:   i18n.T(i18n.Default, "notification.bank_transfer_submitted", params),
+			Type:      notifications.TypeChama,
+			RelatedID: c.ID,
+		})
+	}
+}
+
+// notifyRejected tells the member their transfer was not confirmed
+func notifyRejected(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, c Contribution) {
+	if notifier == nil {
+		return
+	}
+	notifier.Notify(ctx, notifications.Notification{
+		UserID: c.MemberID,
+		Title:  i18n.T(i18n.Default, "bank_transfer.rejected", nil),
+		Message: i18n.T(i18n.Default, "notification.bank_transfer_rejected", map[string]string{
+			"amount": c.Amount.String(), "reference": c.Reference, "reason": c.Notes,
+		}),
+		Type:      notifications.TypeChama,
+		RelatedID: c.ID,
+	})
+}
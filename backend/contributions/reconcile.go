@@ -0,0 +1,123 @@
+package contributions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/txn"
+)
+
+// StuckAfter is how long a mobile money contribution waits for its callback
+// before the reconciler asks the provider about it
+const StuckAfter = 5 * time.Minute
+
+// ExpireAfter is how long a mobile money contribution may stay pending before
+// it is given up as failed. A late success still completes it.
+const ExpireAfter = 24 * time.Hour
+
+// Check asks c's provider for the outcome of a pending contribution and
+// settles it. Contributions pending past ExpireAfter that the provider cannot
+// account for are marked failed, unless the provider is down, in which case
+// they wait for it to come back. Bank transfers wait for an official.
+func Check(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, c Contribution, now time.Time) error {
+	if c.Status != StatusPending || c.Method == payments.ProviderBank {
+		return nil
+	}
+	expired := now.Sub(c.Date) > ExpireAfter
+
+	provider, err := payments.Lookup(c.Method)
+	if err != nil || c.ProviderReference == "" {
+		if expired {
+			return expire(ctx, db, c, "No payment request reached the provider")
+		}
+		return nil
+	}
+	res, err := provider.QueryStatus(ctx, c.ProviderReference)
+	if err != nil {
+		if expired && !errors.Is(err, breaker.ErrUnavailable) {
+			return expire(ctx, db, c, "Provider could not confirm the payment: "+err.Error())
+		}
+		return err
+	}
+	if res.Status == payments.PaymentPending {
+		if expired {
+			return expire(ctx, db, c, "No result from the provider")
+		}
+		return nil
+	}
+	res.Confirmed = true
+	return apply(ctx, db, store, notifier, c.Method, res)
+}
+
+// expire gives up on a pending contribution
+func expire(ctx context.Context, db *sql.DB, c Contribution, reason string) error {
+	_, err := txn.From(ctx, db).ExecContext(ctx, `
+		UPDATE contributions SET status = ?, notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		StatusFailed, "Expired: "+reason, c.ID, StatusPending)
+	return err
+}
+
+// Reconcile checks every mobile money contribution pending for longer than
+// StuckAfter, so a lost callback does not leave it pending for ever. Once a
+// provider is found to be unavailable its remaining contributions are left
+// for the next run.
+func Reconcile(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, now time.Time) (int, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT `+columns+` FROM contributions
+		WHERE status = ? AND payment_method != ? AND created_at < ?
+		ORDER BY created_at`,
+		StatusPending, payments.ProviderBank, now.Add(-StuckAfter).UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	var stuck []Contribution
+	for rows.Next() {
+		c, err := scan(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stuck = append(stuck, c)
+	}
+	rows.Close()
+
+	settled := 0
+	down := map[string]bool{}
+	for _, c := range stuck {
+		if down[c.Method] {
+			continue
+		}
+		if err := Check(ctx, db, store, notifier, c, now); err != nil {
+			if errors.Is(err, breaker.ErrUnavailable) {
+				slog.WarnContext(ctx, "Provider unavailable, deferring reconciliation", "provider", c.Method, "error", err)
+				down[c.Method] = true
+				continue
+			}
+			slog.WarnContext(ctx, "Failed to reconcile contribution", "contribution_id", c.ID, "provider", c.Method, "error", err)
+			continue
+		}
+		if after, err := Get(ctx, db, c.ID); err == nil && after.Status != StatusPending {
+			settled++
+		}
+	}
+	return settled, nil
+}
+
+// RegisterReconcileJob checks stuck mobile money contributions every few minutes
+func RegisterReconcileJob(s *jobs.Scheduler, db *sql.DB, store storage.Backend, notifier *notifications.Notifier) {
+	s.Register("payment_reconciliation", jobs.Every(StuckAfter), func(ctx context.Context) error {
+		settled, err := Reconcile(ctx, db, store, notifier, time.Now().UTC())
+		if settled > 0 {
+			slog.InfoContext(ctx, "Reconciled stuck contributions", "settled", settled)
+		}
+		return err
+	})
+}
@@ -0,0 +1,254 @@
+package dashboard
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/rules"
+)
+
+// Cycle is one contribution cycle, the half-open range [Start, End), with
+// contributions due on Due
+type Cycle struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Due   time.Time `json:"due"`
+}
+
+// CycleAt returns the contribution cycle containing t. Monthly cycles are
+// calendar months; weekly and biweekly cycles end on the due day the rules
+// set. A due day the chama cannot pay on, such as a weekend or public
+// holiday, is moved to the open day before it within the cycle.
+func CycleAt(r rules.Rules, t time.Time) Cycle {
+	t = t.UTC()
+	due := r.ContributionDueDate(t)
+	var c Cycle
+	switch r.ContributionFrequency {
+	case rules.FrequencyWeekly:
+		c = Cycle{Start: due.AddDate(0, 0, -6), End: due.AddDate(0, 0, 1)}
+	case rules.FrequencyBiweekly:
+		c = Cycle{Start: due.AddDate(0, 0, -13), End: due.AddDate(0, 0, 1)}
+	default:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		c = Cycle{Start: start, End: start.AddDate(0, 1, 0)}
+	}
+	c.Due = r.Calendar.Within(due, c.Start, c.End)
+	return c
+}
+
+// LastClosedCycle returns the latest cycle whose due date and grace period
+// have passed by now. Members who paid less than the expected amount in it
+// are in arrears.
+func LastClosedCycle(r rules.Rules, now time.Time) Cycle {
+	c := CycleAt(r, now)
+	for i := 0; i < 3 && now.Before(c.Due.AddDate(0, 0, r.ContributionGraceDays+1)); i++ {
+		c = CycleAt(r, c.Start.AddDate(0, 0, -1))
+	}
+	return c
+}
+
+// ChamaSummary is the chama dashboard
+type ChamaSummary struct {
+	ChamaID           string      `json:"chamaId"`
+	Members           int         `json:"members"`
+	TotalSavings      money.Money `json:"totalSavings"`
+	OutstandingLoans  money.Money `json:"outstandingLoans"`
+	Cycle             Cycle       `json:"cycle"`
+	Expected          money.Money `json:"expected"`  // from active members this cycle
+	Collected         money.Money `json:"collected"` // this cycle, counting each member up to the expected amount
+	CollectionRateBps int64       `json:"collectionRateBps"`
+	PaidMembers       int         `json:"paidMembers"`
+	ArrearsCycle      Cycle       `json:"arrearsCycle"`
+	ArrearsCount      int         `json:"arrearsCount"`
+	UpdatedAt         time.Time   `json:"updatedAt"`
+}
+
+// MemberSummary is a member's dashboard within one chama
+type MemberSummary struct {
+	ChamaID            string      `json:"chamaId"`
+	ChamaName          string      `json:"chamaName"`
+	MemberID           string      `json:"memberId"`
+	Savings            money.Money `json:"savings"`
+	LoanOutstanding    money.Money `json:"loanOutstanding"`
+	LastContributionAt string      `json:"lastContributionAt,omitempty"`
+	Cycle              Cycle       `json:"cycle"`
+	CycleExpected      money.Money `json:"cycleExpected"`
+	CycleContributed   money.Money `json:"cycleContributed"`
+	Arrears            money.Money `json:"arrears"` // shortfall in the last closed cycle
+}
+
+// ChamaDashboard builds the chama dashboard from the summary tables
+func ChamaDashboard(ctx context.Context, db *sql.DB, chamaID string) (ChamaSummary, error) {
+	s := ChamaSummary{ChamaID: chamaID}
+	currency, err := money.ChamaCurrency(db, chamaID)
+	if err != nil {
+		return s, err
+	}
+	r, err := rules.Current(ctx, db, chamaID)
+	if err != nil {
+		return s, err
+	}
+
+	if err := Ensure(ctx, db, chamaID); err != nil {
+		return s, err
+	}
+	var savings, loans int64
+	err = db.QueryRowContext(ctx, `
+		SELECT savings_minor, loans_outstanding_minor, updated_at FROM chama_summaries WHERE chama_id = ?`,
+		chamaID).Scan(&savings, &loans, &s.UpdatedAt)
+	if err != nil {
+		return s, err
+	}
+	s.TotalSavings, s.OutstandingLoans = money.New(savings, currency), money.New(loans, currency)
+
+	now := time.Now().UTC()
+	s.Cycle, s.ArrearsCycle = CycleAt(r, now), LastClosedCycle(r, now)
+	expected := r.ContributionAmountMinor
+
+	current, err := memberTotals(ctx, db, chamaID, s.Cycle)
+	if err != nil {
+		return s, err
+	}
+	var collected int64
+	for _, paid := range current {
+		if paid >= expected {
+			s.PaidMembers++
+		}
+		collected += min(paid, expected)
+	}
+	s.Members = len(current)
+	s.Expected = money.New(expected*int64(s.Members), currency)
+	s.Collected = money.New(collected, currency)
+	if s.Expected.Amount > 0 {
+		s.CollectionRateBps = collected * 10000 / s.Expected.Amount
+	}
+
+	if expected > 0 {
+		closed, err := memberTotals(ctx, db, chamaID, s.ArrearsCycle)
+		if err != nil {
+			return s, err
+		}
+		for _, paid := range closed {
+			if paid < expected {
+				s.ArrearsCount++
+			}
+		}
+	}
+	return s, nil
+}
+
+// memberTotals returns what each active member contributed in c
+func memberTotals(ctx context.Context, db *sql.DB, chamaID string, c Cycle) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.user_id, COALESCE(SUM(d.amount_minor), 0)
+		FROM chama_members m
+		LEFT JOIN contribution_days d ON d.chama_id = m.chama_id AND d.member_id = m.user_id
+			AND d.day >= ? AND d.day < ?
+		WHERE m.chama_id = ? AND m.status = 'active'
+		GROUP BY m.user_id`,
+		c.Start.Format("2006-01-02"), c.End.Format("2006-01-02"), chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := map[string]int64{}
+	for rows.Next() {
+		var memberID string
+		var paid int64
+		if err := rows.Scan(&memberID, &paid); err != nil {
+			return nil, err
+		}
+		totals[memberID] = paid
+	}
+	return totals, rows.Err()
+}
+
+// MemberDashboard builds a member's dashboard within a chama
+func MemberDashboard(ctx context.Context, db *sql.DB, chamaID, memberID string) (MemberSummary, error) {
+	s := MemberSummary{ChamaID: chamaID, MemberID: memberID}
+	var currency string
+	err := db.QueryRowContext(ctx, `SELECT name, currency FROM chamas WHERE id = ?`, chamaID).Scan(&s.ChamaName, &currency)
+	if err != nil {
+		return s, err
+	}
+	r, err := rules.Current(ctx, db, chamaID)
+	if err != nil {
+		return s, err
+	}
+
+	if err := Ensure(ctx, db, chamaID); err != nil {
+		return s, err
+	}
+	var savings, loan int64
+	err = db.QueryRowContext(ctx, `
+		SELECT savings_minor, loan_outstanding_minor, COALESCE(last_contribution_at, '')
+		FROM member_summaries WHERE chama_id = ? AND member_id = ?`,
+		chamaID, memberID).Scan(&savings, &loan, &s.LastContributionAt)
+	if err != nil && err != sql.ErrNoRows {
+		return s, err
+	}
+	s.Savings, s.LoanOutstanding = money.New(savings, currency), money.New(loan, currency)
+
+	now := time.Now().UTC()
+	s.Cycle = CycleAt(r, now)
+	s.CycleExpected = r.ContributionAmount(currency)
+	paid, err := contributed(ctx, db, chamaID, memberID, s.Cycle)
+	if err != nil {
+		return s, err
+	}
+	s.CycleContributed = money.New(paid, currency)
+
+	s.Arrears = money.New(0, currency)
+	if r.ContributionAmountMinor > 0 {
+		closed, err := contributed(ctx, db, chamaID, memberID, LastClosedCycle(r, now))
+		if err != nil {
+			return s, err
+		}
+		if closed < r.ContributionAmountMinor {
+			s.Arrears.Amount = r.ContributionAmountMinor - closed
+		}
+	}
+	return s, nil
+}
+
+// contributed returns what one member contributed in c
+func contributed(ctx context.Context, db *sql.DB, chamaID, memberID string, c Cycle) (int64, error) {
+	var total int64
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount_minor), 0) FROM contribution_days
+		WHERE chama_id = ? AND member_id = ? AND day >= ? AND day < ?`,
+		chamaID, memberID, c.Start.Format("2006-01-02"), c.End.Format("2006-01-02")).Scan(&total)
+	return total, err
+}
+
+// MyDashboards returns the user's dashboard in each chama they are an active member of
+func MyDashboards(ctx context.Context, db *sql.DB, userID string) ([]MemberSummary, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT chama_id FROM chama_members WHERE user_id = ? AND status = 'active' ORDER BY join_date`, userID)
+	if err != nil {
+		return nil, err
+	}
+	var chamaIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		chamaIDs = append(chamaIDs, id)
+	}
+	rows.Close()
+
+	list := []MemberSummary{}
+	for _, id := range chamaIDs {
+		s, err := MemberDashboard(ctx, db, id, userID)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
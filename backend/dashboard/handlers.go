@@ -0,0 +1,90 @@
+package dashboard
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/cache"
+	"tujifund-app/backend/chamas"
+
+	"github.com/gorilla/mux"
+)
+
+// CacheTTL is how long a chama dashboard is cached. Postings invalidate it
+// straight away; the TTL covers membership changes and cycle rollover.
+const CacheTTL = time.Minute
+
+// ChamaHandler returns the {chamaId} chama dashboard to its members
+func ChamaHandler(db *sql.DB, c cache.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		key := cache.ChamaKey(r.Context(), c, chamaID, "dashboard")
+		s, err := cache.Fetch(r.Context(), c, key, CacheTTL, func() (ChamaSummary, error) {
+			return ChamaDashboard(r.Context(), db, chamaID)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// MemberHandler returns the {userId} member's dashboard in the {chamaId}
+// chama. Members can see their own; officials can see anyone's.
+func MemberHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		vars := mux.Vars(r)
+		chamaID, memberID := vars["chamaId"], vars["userId"]
+		if memberID == userID && !chamas.IsMember(db, chamaID, userID) ||
+			memberID != userID && !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		s, err := MemberDashboard(r.Context(), db, chamaID, memberID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// MineHandler returns the caller's dashboard in every chama they belong to,
+// for the app's home screen
+func MineHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		list, err := MyDashboards(r.Context(), db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
@@ -0,0 +1,189 @@
+package dashboard
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/ledger"
+)
+
+// savingsDelta and loanDelta return how much e moves a member's savings and
+// outstanding loan balance. Disbursements are debits, so the loan balance
+// grows by the negated amount and shrinks as repayments come in.
+func savingsDelta(e ledger.Entry) int64 {
+	if e.Type == ledger.TypeContribution || e.Type == ledger.TypeOpeningBalance || e.Type == ledger.TypeSavingsInterest ||
+		e.Type == ledger.TypeDistribution || e.Type == ledger.TypeWithdrawal {
+		return e.Amount.Amount
+	}
+	return 0
+}
+
+func loanDelta(e ledger.Entry) int64 {
+	if e.Type == ledger.TypeLoanDisbursement || e.Type == ledger.TypeLoanRepayment || e.Type == ledger.TypeLoanInterest {
+		return -e.Amount.Amount
+	}
+	return 0
+}
+
+// Project applies a ledger entry to the summary tables. The first entry for
+// a chama without summaries builds them from its whole ledger instead, which
+// backfills history posted before the summaries existed.
+func Project(ctx context.Context, tx *sql.Tx, e ledger.Entry) error {
+	savings, loans := savingsDelta(e), loanDelta(e)
+	if savings == 0 && loans == 0 {
+		return nil
+	}
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM chama_summaries WHERE chama_id = ?)`, e.ChamaID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return rebuild(ctx, tx, e.ChamaID)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chama_summaries (chama_id, savings_minor, loans_outstanding_minor) VALUES (?, ?, ?)
+		ON CONFLICT(chama_id) DO UPDATE SET
+			savings_minor = savings_minor + excluded.savings_minor,
+			loans_outstanding_minor = loans_outstanding_minor + excluded.loans_outstanding_minor,
+			updated_at = CURRENT_TIMESTAMP`,
+		e.ChamaID, savings, loans)
+	if err != nil || e.MemberID == "" {
+		return err
+	}
+
+	var contributedAt interface{}
+	if e.Type == ledger.TypeContribution {
+		contributedAt = e.EffectiveAt.UTC().Format("2006-01-02 15:04:05")
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO member_summaries (chama_id, member_id, savings_minor, loan_outstanding_minor, last_contribution_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(chama_id, member_id) DO UPDATE SET
+			savings_minor = savings_minor + excluded.savings_minor,
+			loan_outstanding_minor = loan_outstanding_minor + excluded.loan_outstanding_minor,
+			last_contribution_at = CASE
+				WHEN excluded.last_contribution_at > COALESCE(member_summaries.last_contribution_at, '')
+				THEN excluded.last_contribution_at ELSE member_summaries.last_contribution_at END,
+			updated_at = CURRENT_TIMESTAMP`,
+		e.ChamaID, e.MemberID, savings, loans, contributedAt)
+	if err != nil || e.Type != ledger.TypeContribution {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO contribution_days (chama_id, member_id, day, amount_minor) VALUES (?, ?, ?, ?)
+		ON CONFLICT(chama_id, member_id, day) DO UPDATE SET amount_minor = amount_minor + excluded.amount_minor`,
+		e.ChamaID, e.MemberID, e.EffectiveAt.UTC().Format("2006-01-02"), e.Amount.Amount)
+	return err
+}
+
+// RegisterProjection keeps the summaries up to date as entries are posted
+// and clears them when a chama's ledger is rebuilt, so the replay derives
+// them afresh
+func RegisterProjection() {
+	ledger.Projections = append(ledger.Projections, Project)
+	ledger.Resets = append(ledger.Resets, reset)
+}
+
+func reset(ctx context.Context, tx *sql.Tx, chamaID string) error {
+	for _, table := range []string{"chama_summaries", "member_summaries", "contribution_days"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE chama_id = ?`, chamaID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuild recomputes a chama's summaries from its ledger
+func Rebuild(ctx context.Context, db *sql.DB, chamaID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := rebuild(ctx, tx, chamaID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func rebuild(ctx context.Context, tx *sql.Tx, chamaID string) error {
+	if err := reset(ctx, tx, chamaID); err != nil {
+		return err
+	}
+
+	sums := `
+		COALESCE(SUM(CASE WHEN entry_type IN (?, ?, ?, ?, ?) THEN amount_minor ELSE 0 END), 0),
+		-COALESCE(SUM(CASE WHEN entry_type IN (?, ?, ?) THEN amount_minor ELSE 0 END), 0)`
+	types := []interface{}{ledger.TypeContribution, ledger.TypeOpeningBalance, ledger.TypeSavingsInterest,
+		ledger.TypeDistribution, ledger.TypeWithdrawal, ledger.TypeLoanDisbursement, ledger.TypeLoanRepayment, ledger.TypeLoanInterest}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO chama_summaries (chama_id, savings_minor, loans_outstanding_minor)
+		SELECT ?, `+sums+` FROM ledger_entries WHERE chama_id = ?`,
+		append(append([]interface{}{chamaID}, types...), chamaID)...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO member_summaries (chama_id, member_id, savings_minor, loan_outstanding_minor, last_contribution_at)
+		SELECT chama_id, member_id, `+sums+`,
+		       MAX(CASE WHEN entry_type = ? THEN effective_at END)
+		FROM ledger_entries WHERE chama_id = ? AND member_id IS NOT NULL
+		GROUP BY chama_id, member_id`,
+		append(types, ledger.TypeContribution, chamaID)...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO contribution_days (chama_id, member_id, day, amount_minor)
+		SELECT chama_id, member_id, date(effective_at), SUM(amount_minor)
+		FROM ledger_entries WHERE chama_id = ? AND member_id IS NOT NULL AND entry_type = ?
+		GROUP BY chama_id, member_id, date(effective_at)`,
+		chamaID, ledger.TypeContribution)
+	return err
+}
+
+// Ensure builds a chama's summaries if it has never been summarised, so
+// readers see its full history before its next posting
+func Ensure(ctx context.Context, db *sql.DB, chamaID string) error {
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM chama_summaries WHERE chama_id = ?)`, chamaID).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	return Rebuild(ctx, db, chamaID)
+}
+
+// RegisterReconcileJob rebuilds every chama's summaries nightly, so any
+// drift from entries written outside ledger.Post is corrected within a day
+func RegisterReconcileJob(s *jobs.Scheduler, db *sql.DB) {
+	s.Register("dashboard_reconcile", jobs.Daily{Hour: 2}, func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `SELECT id FROM chamas`)
+		if err != nil {
+			return err
+		}
+		var chamaIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			chamaIDs = append(chamaIDs, id)
+		}
+		rows.Close()
+
+		for _, id := range chamaIDs {
+			if err := Rebuild(ctx, db, id); err != nil {
+				slog.ErrorContext(ctx, "Failed to rebuild dashboard summaries", "chama_id", id, "error", err)
+			}
+		}
+		return nil
+	})
+}
@@ -3,13 +3,35 @@ package database
 import (
 	"context"
 	"database/sql"
+
+	"tujifund-app/backend/database/querywatch"
+	"tujifund-app/backend/database/stmthook"
+	"tujifund-app/backend/logging"
+	"tujifund-app/backend/readonly"
 )
 
+// open opens the database, refusing statements that write in read-only
+// maintenance mode, logging each statement with the request ID from its
+// context and, when conf.DetectNPlusOne is set, counting its queries per
+// request
+func open(conf DBConfig, driverName, dsn string) (*sql.DB, error) {
+	hooks := []stmthook.Hook{{Before: readonly.CheckStatement}, {After: logging.Query}}
+	if conf.DetectNPlusOne {
+		hooks = append(hooks, querywatch.Hook)
+	}
+	return stmthook.Open(driverName, dsn, hooks...)
+}
+
 // BaseDriver provides common implementations for the DBDriver interface
 type BaseDriver struct {
 	db *sql.DB
 }
 
+// DB returns the connection pool the driver opened
+func (d *BaseDriver) DB() *sql.DB {
+	return d.db
+}
+
 // Close closes the database connection
 func (d *BaseDriver) Close() error {
 	return d.db.Close()
@@ -20,7 +42,7 @@ func (d *BaseDriver) Ping() error {
 	return d.db.Ping()
 }
 
-// BeginTx starts the a transaction
+// BeginTx starts a transaction
 func (d *BaseDriver) BeginTx(ctx context.Context) (*sql.Tx, error) {
 	return d.db.BeginTx(ctx, nil)
 }
@@ -30,7 +52,12 @@ func (d *BaseDriver) Exec(query string, args ...interface{}) (sql.Result, error)
 	return d.db.Exec(query, args...)
 }
 
+// Query executes a query that returns rows
+func (d *BaseDriver) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.db.Query(query, args...)
+}
+
 // QueryRow executes a query that return a single row
 func (d *BaseDriver) QueryRow(query string, args ...interface{}) *sql.Row {
 	return d.db.QueryRow(query, args...)
-} 
\ No newline at end of file
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"tujifund-app/backend/logging"
+)
+
+func TestQueriesAreLoggedWithTheRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logging.New(&buf, true))
+	logging.SetLevel(slog.LevelDebug)
+	defer logging.SetLevel(slog.LevelInfo)
+
+	dbi, err := NewDBInstance(DBConfig{Driver: "sqlite", SQLitePath: filepath.Join(t.TempDir(), "tujifund.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbi.Close()
+	db := dbi.GetDB()
+
+	handler := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		if err := db.QueryRowContext(r.Context(), `SELECT 1`).Scan(&n); err != nil {
+			t.Errorf("SELECT 1: %v", err)
+		}
+		if _, err := db.ExecContext(r.Context(), `DELETE FROM missing_table`); err == nil {
+			t.Error("DELETE FROM missing_table succeeded")
+		}
+		stmt, err := db.PrepareContext(r.Context(), `SELECT 2`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stmt.Close()
+		if err := stmt.QueryRowContext(r.Context()).Scan(&n); err != nil {
+			t.Errorf("SELECT 2: %v", err)
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/chamas", nil)
+	req.Header.Set(logging.RequestIDHeader, "req-123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	tests := []struct {
+		query, msg, level string
+	}{
+		{`SELECT 1`, "db query", "DEBUG"},
+		{`DELETE FROM missing_table`, "db query failed", "ERROR"},
+		{`SELECT 2`, "db query", "DEBUG"},
+	}
+	records := map[string]map[string]any{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("log line %q: %v", scanner.Text(), err)
+		}
+		if query, ok := record["query"].(string); ok {
+			if _, seen := records[query]; seen {
+				t.Errorf("%s logged more than once", query)
+			}
+			records[query] = record
+		}
+	}
+	for _, tt := range tests {
+		record, ok := records[tt.query]
+		if !ok {
+			t.Errorf("%s was not logged", tt.query)
+			continue
+		}
+		if record["request_id"] != "req-123" {
+			t.Errorf("%s logged with request_id %v, want req-123", tt.query, record["request_id"])
+		}
+		if record["msg"] != tt.msg || record["level"] != tt.level {
+			t.Errorf("%s logged as %v %q, want %s %q", tt.query, record["level"], record["msg"], tt.level, tt.msg)
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package database
 
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
 // DBConfig holds database configuration
 type DBConfig struct {
 	// Common settings
@@ -8,16 +15,65 @@ type DBConfig struct {
 
 	// SQLite specific
 	SQLitePath string
-	
+
 	// PostgreSQL specific
-	Host string
-	Port int
+	Host     string
+	Port     int
 	UserName string
 	Password string
-	SSLMode string 
+	SSLMode  string
+	RLSRoles []string // roles the row-level security policies apply to; PUBLIC if empty
+
+	// Tenancy: chamas listed in tenant_databases get a database of their own
+	TenantDatabases bool
+	TenantDir       string // where tenant SQLite databases are kept
+
+	// Development: warn about statements repeated within one request
+	DetectNPlusOne bool
 
 	// Connection pool settings
 	MaxOpenConns int
 	MaxIdleConns int
 }
 
+// ConfigFromEnv reads the database configuration from the environment.
+// DB_DRIVER picks the driver, SQLite by default, keeping its database at
+// DB_PATH. PostgreSQL is reached with DB_HOST, DB_PORT, DB_USER,
+// DB_PASSWORD, DB_NAME and DB_SSLMODE, with its row-level security
+// policies applying to the comma separated DB_RLS_ROLES.
+func ConfigFromEnv() (DBConfig, error) {
+	conf := DBConfig{Driver: os.Getenv("DB_DRIVER")}
+	switch conf.Driver {
+	case "", "sqlite":
+		conf.Driver = "sqlite"
+		conf.SQLitePath = envOr("DB_PATH", "data/tujifund.db")
+	case "postgres":
+		port, err := strconv.Atoi(envOr("DB_PORT", "5432"))
+		if err != nil {
+			return DBConfig{}, fmt.Errorf("invalid DB_PORT: %w", err)
+		}
+		conf.Host = envOr("DB_HOST", "localhost")
+		conf.Port = port
+		conf.UserName = os.Getenv("DB_USER")
+		conf.Password = os.Getenv("DB_PASSWORD")
+		conf.DBName = envOr("DB_NAME", "tujifund")
+		conf.SSLMode = envOr("DB_SSLMODE", "disable")
+		for _, role := range strings.Split(os.Getenv("DB_RLS_ROLES"), ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				conf.RLSRoles = append(conf.RLSRoles, role)
+			}
+		}
+		conf.MaxOpenConns = 10
+		conf.MaxIdleConns = 5
+	default:
+		return DBConfig{}, fmt.Errorf("unsupported database driver: %s", conf.Driver)
+	}
+	return conf, nil
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -8,12 +8,13 @@ import (
 // DBDriver defines the interface that all database drivers must implement
 type DBDriver interface {
 	// Connection management
-	Connect(config DBConfig) error 
+	Connect(config DBConfig) error
 	Close() error
 	Ping() error
+	DB() *sql.DB
 
 	// Transaction management
-	BeginTx(ctx context.Context) (*sql.Tx, error) 
+	BeginTx(ctx context.Context) (*sql.Tx, error)
 
 	//Query execution
 	Exec(query string, args ...interface{}) (sql.Result, error)
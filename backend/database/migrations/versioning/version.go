@@ -1,11 +1,16 @@
-package versioning 
+package versioning
+
+import (
+	"database/sql"
+	"time"
+)
 
 type MigrationVersion struct {
-	ID int64
-	Version string
+	ID        int64
+	Version   string
 	AppliedAt time.Time
-	Status string
-	Detail string
+	Status    string
+	Detail    string
 }
 
 func CreateVersionTable(db *sql.DB) error {
@@ -15,5 +20,5 @@ func CreateVersionTable(db *sql.DB) error {
 
 func RecordMigration(db *sql.DB, version MigrationVersion) error {
 	// TODO:: Implementation to record migratuion attempt
-	return error
+	return nil
 }
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"tujifund-app/backend/database/tenantdriver"
+	"tujifund-app/backend/readonly"
+)
+
+// PostgresDriver implements the DBDriver interface for PostgreSQL. Tables
+// holding chama data are protected by row-level security policies, applied
+// by InitializeSchema, and every statement only sees the rows of the chama
+// in its context, or of every chama for tenancy.WithAllChamas.
+type PostgresDriver struct {
+	BaseDriver
+	conf DBConfig
+}
+
+var _ DBDriver = &PostgresDriver{}
+
+// Connect establishes a connection to the PostgreSQL database
+func (d *PostgresDriver) Connect(conf DBConfig) error {
+	db, err := open(conf, tenantdriver.DriverName, postgresDSN(conf))
+	if err != nil {
+		return fmt.Errorf("failed to connect to PostgreSQL database: %w", err)
+	}
+
+	// Test the connection
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to ping PostgreSQL database: %w", err)
+	}
+
+	// Set connection pool settings
+	db.SetMaxOpenConns(conf.MaxOpenConns)
+	db.SetMaxIdleConns(conf.MaxIdleConns)
+
+	d.db = db
+	d.conf = conf
+	return nil
+}
+
+// postgresDSN returns lib/pq's connection string for conf, quoting each
+// value so that a password may hold spaces or quotes
+func postgresDSN(conf DBConfig) string {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	var b strings.Builder
+	for _, kv := range []struct{ key, value string }{
+		{"host", conf.Host},
+		{"port", strconv.Itoa(conf.Port)},
+		{"user", conf.UserName},
+		{"password", conf.Password},
+		{"dbname", conf.DBName},
+		{"sslmode", conf.SSLMode},
+	} {
+		if kv.value == "" || kv.value == "0" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s='%s'", kv.key, quote.Replace(kv.value))
+	}
+	return b.String()
+}
+
+// InitializeSchema creates the tables from schema_postgres.sql and applies
+// the row-level security policies
+func (d *PostgresDriver) InitializeSchema() error {
+	schema, err := os.ReadFile(filepath.Join("database", "schema_postgres.sql"))
+	if err != nil {
+		return fmt.Errorf("failed to read schema file: %w", err)
+	}
+	// The schema is kept up to date in read-only mode too
+	ctx := readonly.Allow(context.Background())
+	if _, err := d.db.ExecContext(ctx, string(schema)); err != nil {
+		if !strings.Contains(err.Error(), "already exists") {
+			return fmt.Errorf("failed to execute schema: %w", err)
+		}
+	}
+
+	if err := ApplyRowLevelSecurity(ctx, d.db, d.conf.RLSRoles); err != nil {
+		return fmt.Errorf("failed to apply row-level security: %w", err)
+	}
+	return nil
+}
+
+// GetDialect returns the SQL dialect name
+func (d *PostgresDriver) GetDialect() string {
+	return "postgres"
+}
+
+// TransformQuery converts ? placeholders to PostgreSQL's $1, $2, ... It is
+// applied to every statement by the driver Connect opens.
+func (d *PostgresDriver) TransformQuery(query string) string {
+	return tenantdriver.Rebind(query)
+}
@@ -0,0 +1,258 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"tujifund-app/backend/tenancy"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want DBConfig
+		err  bool
+	}{
+		{
+			name: "sqlite by default",
+			want: DBConfig{Driver: "sqlite", SQLitePath: "data/tujifund.db"},
+		},
+		{
+			name: "sqlite path",
+			env:  map[string]string{"DB_DRIVER": "sqlite", "DB_PATH": "/var/lib/tujifund.db"},
+			want: DBConfig{Driver: "sqlite", SQLitePath: "/var/lib/tujifund.db"},
+		},
+		{
+			name: "postgres",
+			env: map[string]string{
+				"DB_DRIVER": "postgres", "DB_HOST": "db", "DB_PORT": "6432", "DB_USER": "app",
+				"DB_PASSWORD": "secret", "DB_NAME": "chamas", "DB_SSLMODE": "require", "DB_RLS_ROLES": "app, reports",
+			},
+			want: DBConfig{
+				Driver: "postgres", Host: "db", Port: 6432, UserName: "app", Password: "secret",
+				DBName: "chamas", SSLMode: "require", RLSRoles: []string{"app", "reports"},
+				MaxOpenConns: 10, MaxIdleConns: 5,
+			},
+		},
+		{
+			name: "postgres defaults",
+			env:  map[string]string{"DB_DRIVER": "postgres"},
+			want: DBConfig{
+				Driver: "postgres", Host: "localhost", Port: 5432, DBName: "tujifund", SSLMode: "disable",
+				MaxOpenConns: 10, MaxIdleConns: 5,
+			},
+		},
+		{name: "bad port", env: map[string]string{"DB_DRIVER": "postgres", "DB_PORT": "five"}, err: true},
+		{name: "unknown driver", env: map[string]string{"DB_DRIVER": "mysql"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"DB_DRIVER", "DB_PATH", "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME", "DB_SSLMODE", "DB_RLS_ROLES"} {
+				t.Setenv(key, tt.env[key])
+			}
+			got, err := ConfigFromEnv()
+			if (err != nil) != tt.err {
+				t.Fatalf("ConfigFromEnv() error = %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if strings.Join(got.RLSRoles, ",") != strings.Join(tt.want.RLSRoles, ",") {
+				t.Errorf("RLSRoles = %q, want %q", got.RLSRoles, tt.want.RLSRoles)
+			}
+			got.RLSRoles, tt.want.RLSRoles = nil, nil
+			if !configEqual(got, tt.want) {
+				t.Errorf("ConfigFromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func configEqual(a, b DBConfig) bool {
+	return a.Driver == b.Driver && a.SQLitePath == b.SQLitePath && a.Host == b.Host && a.Port == b.Port &&
+		a.UserName == b.UserName && a.Password == b.Password && a.DBName == b.DBName && a.SSLMode == b.SSLMode &&
+		a.MaxOpenConns == b.MaxOpenConns && a.MaxIdleConns == b.MaxIdleConns
+}
+
+func TestNewDriver(t *testing.T) {
+	tests := []struct {
+		driver  string
+		dialect string
+	}{
+		{"", "sqlite"},
+		{"sqlite", "sqlite"},
+		{"postgres", "postgres"},
+	}
+	for _, tt := range tests {
+		d, err := NewDriver(DBConfig{Driver: tt.driver})
+		if err != nil {
+			t.Fatalf("NewDriver(%q) error = %v", tt.driver, err)
+		}
+		if got := d.GetDialect(); got != tt.dialect {
+			t.Errorf("NewDriver(%q) dialect = %q, want %q", tt.driver, got, tt.dialect)
+		}
+	}
+	if _, err := NewDriver(DBConfig{Driver: "mysql"}); err == nil {
+		t.Error("NewDriver(mysql) succeeded, want error")
+	}
+}
+
+func TestPostgresDSN(t *testing.T) {
+	tests := []struct {
+		name string
+		conf DBConfig
+		want string
+	}{
+		{
+			name: "all settings",
+			conf: DBConfig{Host: "db", Port: 5432, UserName: "app", Password: "secret", DBName: "tujifund", SSLMode: "disable"},
+			want: `host='db' port='5432' user='app' password='secret' dbname='tujifund' sslmode='disable'`,
+		},
+		{
+			name: "quoted password",
+			conf: DBConfig{Host: "db", Password: `it's a \secret`},
+			want: `host='db' password='it\'s a \\secret'`,
+		},
+		{
+			name: "unset settings left to lib/pq",
+			conf: DBConfig{DBName: "tujifund"},
+			want: `dbname='tujifund'`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := postgresDSN(tt.conf); got != tt.want {
+				t.Errorf("postgresDSN() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostgresTransformQuery(t *testing.T) {
+	d := &PostgresDriver{}
+	tests := []struct {
+		query, want string
+	}{
+		{`SELECT * FROM chamas WHERE id = ?`, `SELECT * FROM chamas WHERE id = $1`},
+		{`UPDATE loans SET status = ? WHERE id = ? AND chama_id = ?`, `UPDATE loans SET status = $1 WHERE id = $2 AND chama_id = $3`},
+		{`SELECT '?' FROM users WHERE user_id = ?`, `SELECT '?' FROM users WHERE user_id = $1`},
+		{"SELECT 1 -- why?\nFROM users WHERE email = ?", "SELECT 1 -- why?\nFROM users WHERE email = $1"},
+	}
+	for _, tt := range tests {
+		if got := d.TransformQuery(tt.query); got != tt.want {
+			t.Errorf("TransformQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRowLevelSecurity(t *testing.T) {
+	tables := map[string]string{"chamas": "id", "loans": "chama_id"}
+	check := `("%s" = current_setting('app.chama_id', true) OR current_setting('app.all_chamas', true) = 'on')`
+	chamasCheck := strings.Replace(check, "%s", "id", 1)
+	loansCheck := strings.Replace(check, "%s", "chama_id", 1)
+
+	tests := []struct {
+		name  string
+		roles []string
+		want  []string
+	}{
+		{
+			name: "public by default",
+			want: []string{
+				`ALTER TABLE "chamas" ENABLE ROW LEVEL SECURITY`,
+				`ALTER TABLE "chamas" FORCE ROW LEVEL SECURITY`,
+				`DROP POLICY IF EXISTS "chamas_chama_public" ON "chamas"`,
+				`CREATE POLICY "chamas_chama_public" ON "chamas" FOR ALL TO PUBLIC USING ` + chamasCheck + ` WITH CHECK ` + chamasCheck,
+				`ALTER TABLE "loans" ENABLE ROW LEVEL SECURITY`,
+				`ALTER TABLE "loans" FORCE ROW LEVEL SECURITY`,
+				`DROP POLICY IF EXISTS "loans_chama_public" ON "loans"`,
+				`CREATE POLICY "loans_chama_public" ON "loans" FOR ALL TO PUBLIC USING ` + loansCheck + ` WITH CHECK ` + loansCheck,
+			},
+		},
+		{
+			name:  "one policy per role",
+			roles: []string{"app", "Reports"},
+			want: []string{
+				`ALTER TABLE "chamas" ENABLE ROW LEVEL SECURITY`,
+				`ALTER TABLE "chamas" FORCE ROW LEVEL SECURITY`,
+				`DROP POLICY IF EXISTS "chamas_chama_app" ON "chamas"`,
+				`CREATE POLICY "chamas_chama_app" ON "chamas" FOR ALL TO "app" USING ` + chamasCheck + ` WITH CHECK ` + chamasCheck,
+				`DROP POLICY IF EXISTS "chamas_chama_reports" ON "chamas"`,
+				`CREATE POLICY "chamas_chama_reports" ON "chamas" FOR ALL TO "Reports" USING ` + chamasCheck + ` WITH CHECK ` + chamasCheck,
+				`ALTER TABLE "loans" ENABLE ROW LEVEL SECURITY`,
+				`ALTER TABLE "loans" FORCE ROW LEVEL SECURITY`,
+				`DROP POLICY IF EXISTS "loans_chama_app" ON "loans"`,
+				`CREATE POLICY "loans_chama_app" ON "loans" FOR ALL TO "app" USING ` + loansCheck + ` WITH CHECK ` + loansCheck,
+				`DROP POLICY IF EXISTS "loans_chama_reports" ON "loans"`,
+				`CREATE POLICY "loans_chama_reports" ON "loans" FOR ALL TO "Reports" USING ` + loansCheck + ` WITH CHECK ` + loansCheck,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RowLevelSecurity(tables, tt.roles)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("RowLevelSecurity() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+// TestPostgresRowLevelSecurityScopesRows runs against the PostgreSQL server
+// the DB_ settings name when DB_DRIVER=postgres, connecting as a role that
+// is not a superuser, as superusers bypass row-level security
+func TestPostgresRowLevelSecurityScopesRows(t *testing.T) {
+	conf, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Driver != "postgres" {
+		t.Skip("set DB_DRIVER=postgres and the DB_ settings to run against PostgreSQL")
+	}
+	dbi, err := NewDBInstance(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbi.Close()
+	db := dbi.GetDB()
+
+	ctx := context.Background()
+	all := tenancy.WithAllChamas(ctx)
+	if _, err := db.ExecContext(ctx, `CREATE TABLE rls_test_items (chama_id TEXT NOT NULL, name TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	defer db.ExecContext(ctx, `DROP TABLE rls_test_items`)
+	if err := ApplyRowLevelSecurity(ctx, db, conf.RLSRoles); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chama := range []string{"a", "b"} {
+		if _, err := db.ExecContext(tenancy.WithChama(ctx, chama), `INSERT INTO rls_test_items (chama_id, name) VALUES (?, ?)`, chama, "item"); err != nil {
+			t.Fatalf("insert for chama %s: %v", chama, err)
+		}
+	}
+	if _, err := db.ExecContext(tenancy.WithChama(ctx, "a"), `INSERT INTO rls_test_items (chama_id, name) VALUES (?, ?)`, "b", "smuggled"); err == nil {
+		t.Error("chama a inserted a row for chama b")
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want int
+	}{
+		{"one chama", tenancy.WithChama(ctx, "a"), 1},
+		{"every chama", all, 2},
+		{"unscoped", ctx, 0},
+	}
+	for _, tt := range tests {
+		var n int
+		if err := db.QueryRowContext(tt.ctx, `SELECT COUNT(*) FROM rls_test_items`).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if n != tt.want {
+			t.Errorf("%s: saw %d rows, want %d", tt.name, n, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package querywatch catches N+1 query patterns during development. Its Hook
+// counts every query run with a request's context against that request; when the same statement runs Threshold
+// times or more in one request, typically once per row of an earlier
+// result, a warning is logged with the statement, how often it ran and the
+// call stack that first crossed the threshold.
+//
+// Counting costs a lock and, once per pattern, a stack walk, so it is meant
+// for development and staging, switched on with DETECT_N_PLUS_ONE=true.
+package querywatch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"tujifund-app/backend/database/stmthook"
+)
+
+// Threshold is how many runs of one statement in a trace are reported
+var Threshold = 5
+
+// modulePrefix marks the application's own frames in call stacks, along
+// with those of package main
+const modulePrefix = "tujifund-app/"
+
+type traceKey struct{}
+
+// trace counts the statements run under one request or job
+type trace struct {
+	name  string
+	start time.Time
+
+	mu      sync.Mutex
+	queries map[string]*pattern
+	order   []string
+}
+
+type pattern struct {
+	count int
+	stack string // where the threshold was crossed
+}
+
+// Trace starts counting the queries run with the returned context. finish
+// logs the repeated ones.
+func Trace(ctx context.Context, name string) (context.Context, func()) {
+	t := &trace{name: name, start: time.Now(), queries: map[string]*pattern{}}
+	return context.WithValue(ctx, traceKey{}, t), func() { t.report(ctx) }
+}
+
+// Middleware traces every request
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, finish := Trace(r.Context(), r.Method+" "+r.URL.Path)
+		defer finish()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Hook counts the statements a database runs, once it is opened with
+// stmthook.Open
+var Hook = stmthook.Hook{Before: observe}
+
+// observe counts query against the trace in ctx, if there is one
+func observe(ctx context.Context, query string) error {
+	t, ok := ctx.Value(traceKey{}).(*trace)
+	if !ok {
+		return nil
+	}
+	key := normalize(query)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.queries[key]
+	if !ok {
+		p = &pattern{}
+		t.queries[key] = p
+		t.order = append(t.order, key)
+	}
+	p.count++
+	if p.count == Threshold {
+		p.stack = callers()
+	}
+	return nil
+}
+
+// report logs each statement that ran Threshold times or more
+func (t *trace) report(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range t.order {
+		p := t.queries[key]
+		if p.count < Threshold {
+			continue
+		}
+		slog.WarnContext(ctx, "Possible N+1 query", "trace", t.name, "query", key, "count", p.count,
+			"duration_ms", time.Since(t.start).Milliseconds(), "stack", p.stack)
+	}
+}
+
+// normalize collapses whitespace so that one statement written over several
+// lines is counted as one
+func normalize(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// callers returns the application's frames of the current call stack,
+// innermost first, skipping database/sql and the database packages
+func callers() string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var lines []string
+	for {
+		f, more := frames.Next()
+		own := strings.HasPrefix(f.Function, modulePrefix) || strings.HasPrefix(f.Function, "main.")
+		if own && !strings.Contains(f.Function, "/database/querywatch.") && !strings.Contains(f.Function, "/database/stmthook.") {
+			lines = append(lines, fmt.Sprintf("%s (%s:%d)", strings.TrimPrefix(f.Function, modulePrefix), f.File, f.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"tujifund-app/backend/database/tenantdriver"
+)
+
+// The settings the PostgreSQL driver scopes each session with
+const (
+	TenantSetting    = tenantdriver.TenantSetting
+	AllChamasSetting = tenantdriver.AllChamasSetting
+)
+
+// tenantCheck admits a row whose column matches the session's chama, or any
+// row when the session is scoped to every chama. With neither set it admits
+// none.
+const tenantCheck = `(%s = current_setting('` + TenantSetting + `', true) OR current_setting('` + AllChamasSetting + `', true) = 'on')`
+
+// RowLevelSecurity returns the statements that protect chama data in
+// PostgreSQL: every table with a chama_id column, and chamas itself, gets
+// row-level security forced on (so the table owner is bound too) and one
+// policy per role limiting it to the session's chama. roles defaults to
+// PUBLIC. It is a defence in depth against a query missing its chama
+// condition, and is safe to run again.
+func RowLevelSecurity(tables map[string]string, roles []string) []string {
+	if len(roles) == 0 {
+		roles = []string{"PUBLIC"}
+	}
+	var stmts []string
+	for _, table := range sortedKeys(tables) {
+		check := fmt.Sprintf(tenantCheck, quoteIdent(tables[table]))
+		t := quoteIdent(table)
+		stmts = append(stmts,
+			fmt.Sprintf(`ALTER TABLE %s ENABLE ROW LEVEL SECURITY`, t),
+			fmt.Sprintf(`ALTER TABLE %s FORCE ROW LEVEL SECURITY`, t))
+		for _, role := range roles {
+			policy := quoteIdent(table + "_chama_" + strings.ToLower(role))
+			grantee := role
+			if role != "PUBLIC" {
+				grantee = quoteIdent(role)
+			}
+			stmts = append(stmts,
+				fmt.Sprintf(`DROP POLICY IF EXISTS %s ON %s`, policy, t),
+				fmt.Sprintf(`CREATE POLICY %s ON %s FOR ALL TO %s USING %s WITH CHECK %s`, policy, t, grantee, check, check))
+		}
+	}
+	return stmts
+}
+
+// ApplyRowLevelSecurity finds the tables holding chama data in the current
+// schema and applies RowLevelSecurity to them in one transaction
+func ApplyRowLevelSecurity(ctx context.Context, db *sql.DB, roles []string) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.table_name FROM information_schema.columns c
+		JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = current_schema() AND c.column_name = 'chama_id' AND t.table_type = 'BASE TABLE'`)
+	if err != nil {
+		return err
+	}
+	tables := map[string]string{"chamas": "id"}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables[table] = "chama_id"
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range RowLevelSecurity(tables, roles) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
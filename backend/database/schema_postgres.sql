@@ -0,0 +1,2004 @@
+-- Tujifund/ChamaVault Database Schema for PostgreSQL
+-- A comprehensive schema for managing group savings and investments
+--
+-- This is database_schema.sql for the PostgreSQL driver; changes to one
+-- belong in the other. Row-level security is applied on top of it by
+-- database.ApplyRowLevelSecurity, and the search indexes by
+-- search.Postgres.EnsureIndexes.
+
+-- Users table to store user information
+CREATE TABLE IF NOT EXISTS users (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id TEXT UNIQUE NOT NULL,
+    username TEXT UNIQUE NOT NULL,
+    email TEXT UNIQUE NOT NULL,
+    password_hash TEXT ,
+    first_name TEXT,
+    last_name TEXT,
+    phone_number TEXT,
+    national_id TEXT,
+    profile_image_url TEXT,
+    country TEXT,
+    bio TEXT,
+    date_of_birth DATE, -- optional, shared by the member for birthday greetings
+    auth_provider TEXT DEFAULT 'none',
+    is_verified BIGINT DEFAULT 0,
+    role TEXT NOT NULL DEFAULT 'user', -- user, admin (platform staff)
+    suspended_at TIMESTAMP, -- set by platform staff; suspended users cannot sign in
+    suspension_reason TEXT,
+    erased_at TIMESTAMP, -- personal data anonymised at the member's request
+    merged_into TEXT, -- the user this duplicate record was merged into by platform staff
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login TIMESTAMP,
+    metadata JSON
+);
+
+-- User verification table
+CREATE TABLE IF NOT EXISTS user_verification (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    verification_token TEXT NOT NULL,
+    type TEXT NOT NULL, -- email, phone, etc.
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, type)
+);
+
+-- Session management
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    token TEXT NOT NULL,
+    ip_address TEXT,
+    user_agent TEXT,
+    device_name TEXT, -- e.g. "Chrome on Android", shown in the device list
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_activity TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+-- Chamas table to store group information
+CREATE TABLE IF NOT EXISTS chamas (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT,
+    type TEXT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES', -- ISO 4217 code used for all chama amounts
+    icon_url TEXT,
+    status TEXT NOT NULL DEFAULT 'active', -- active, dormant, dissolving, dissolved
+    archived_at TIMESTAMP, -- set when dissolved; the chama is read-only from then on
+    created_by TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    settings JSON
+);
+
+-- Chama memberships
+CREATE TABLE IF NOT EXISTS chama_members (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    role TEXT NOT NULL DEFAULT 'member', -- admin, treasurer, secretary, member, etc.
+    join_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    status TEXT NOT NULL DEFAULT 'active', -- active, pending, inactive, suspended
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(chama_id, user_id)
+);
+
+-- Chama accounts/wallets
+CREATE TABLE IF NOT EXISTS chama_accounts (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    account_type TEXT NOT NULL, -- general, savings, welfare, education, loans, merry-go-round, shares
+    balance_minor BIGINT NOT NULL DEFAULT 0, -- amounts are stored in minor units (cents)
+    currency TEXT NOT NULL DEFAULT 'KES',
+    description TEXT,
+    status TEXT NOT NULL DEFAULT 'active', -- active, closed
+    allowed_debits TEXT, -- comma-separated ledger entry types that may take money out; NULL allows any
+    min_balance_minor BIGINT, -- debits may not take the balance below this; NULL for no floor
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(chama_id, name)
+);
+
+-- Chama Contributions/Payments
+CREATE TABLE IF NOT EXISTS contributions (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    account_id TEXT NOT NULL REFERENCES chama_accounts(id) ON DELETE CASCADE,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    contribution_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    payment_method TEXT, -- bank transfer, mobile money, cash, etc.
+    transaction_reference TEXT,
+    provider_reference TEXT, -- the provider's ID for a payment in progress, e.g. an M-Pesa checkout request
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, completed, failed
+    payment_proof_url TEXT, -- storage key of the deposit slip for bank transfers
+    confirmed_by TEXT REFERENCES users(user_id), -- official who confirmed or rejected a bank transfer
+    notes TEXT,
+    original_amount_minor BIGINT, -- what a member paid in another currency, before conversion on completion
+    original_currency TEXT,
+    exchange_rate BIGINT, -- units of currency per unit of original_currency, scaled by 1,000,000
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_contributions_provider_ref ON contributions(provider_reference) WHERE provider_reference IS NOT NULL;
+
+-- Loan products/types
+CREATE TABLE IF NOT EXISTS loan_products (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT REFERENCES chamas(id) ON DELETE CASCADE, -- NULL means system-wide loan product
+    name TEXT NOT NULL,
+    description TEXT,
+    interest_rate_bps BIGINT NOT NULL, -- basis points, 100 = 1%
+    interest_type TEXT NOT NULL DEFAULT 'flat', -- flat, reducing, compound
+    min_amount_minor BIGINT NOT NULL,
+    max_amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    min_term BIGINT NOT NULL, -- in days
+    max_term BIGINT NOT NULL, -- in days
+    grace_period BIGINT NOT NULL DEFAULT 0, -- in days
+    savings_multiplier_bps BIGINT NOT NULL DEFAULT 0, -- caps a member's loan at this multiple of their savings, 30000 = 3x; 0 for no cap
+    required_guarantors BIGINT NOT NULL DEFAULT 0,
+    late_payment_fee_minor BIGINT,
+    early_payment_fee_minor BIGINT,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Loan applications
+CREATE TABLE IF NOT EXISTS loan_applications (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT REFERENCES chamas(id) ON DELETE SET NULL, -- NULL for platform loans
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    loan_product_id TEXT NOT NULL REFERENCES loan_products(id) ON DELETE CASCADE,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    term BIGINT NOT NULL, -- in days
+    purpose TEXT,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected, disbursed, completed
+    application_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    approved_by TEXT REFERENCES users(user_id) ON DELETE SET NULL,
+    approval_date TIMESTAMP,
+    rejection_reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Members an applicant has asked to guarantee their loan
+CREATE TABLE IF NOT EXISTS loan_application_guarantors (
+    application_id TEXT NOT NULL REFERENCES loan_applications(id) ON DELETE CASCADE,
+    guarantor_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, accepted, declined
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (application_id, guarantor_id)
+);
+
+-- Items pledged as security for a loan: title deeds, logbooks, shares. They
+-- are pledged against the application and follow it into the loan, and into
+-- any top-up that replaces the loan, until it is repaid.
+CREATE TABLE IF NOT EXISTS loan_collateral (
+    id TEXT PRIMARY KEY,
+    application_id TEXT NOT NULL REFERENCES loan_applications(id) ON DELETE CASCADE,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    owner_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    kind TEXT NOT NULL, -- title_deed, logbook, shares, other
+    description TEXT NOT NULL,
+    reference TEXT, -- title, registration or certificate number
+    declared_minor BIGINT NOT NULL DEFAULT 0, -- the owner's estimate
+    value_minor BIGINT NOT NULL DEFAULT 0, -- the officials' valuation
+    currency TEXT NOT NULL DEFAULT 'KES',
+    valued_by TEXT REFERENCES users(user_id),
+    valued_at TIMESTAMP,
+    status TEXT NOT NULL DEFAULT 'pledged', -- pledged, released
+    released_by TEXT REFERENCES users(user_id),
+    released_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_loan_collateral_application ON loan_collateral(application_id);
+
+-- Scans and photos of pledged collateral
+CREATE TABLE IF NOT EXISTS loan_collateral_documents (
+    id TEXT PRIMARY KEY,
+    collateral_id TEXT NOT NULL REFERENCES loan_collateral(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    storage_key TEXT NOT NULL,
+    mime_type TEXT NOT NULL,
+    uploaded_by TEXT NOT NULL REFERENCES users(user_id),
+    uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Loans table
+CREATE TABLE IF NOT EXISTS loans (
+    id TEXT PRIMARY KEY,
+    application_id TEXT NOT NULL REFERENCES loan_applications(id) ON DELETE CASCADE,
+    chama_id TEXT REFERENCES chamas(id) ON DELETE SET NULL, -- NULL for platform loans
+    borrower_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    loan_product_id TEXT NOT NULL REFERENCES loan_products(id) ON DELETE CASCADE,
+    principal_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    interest_rate_bps BIGINT NOT NULL,
+    interest_type TEXT NOT NULL,
+    term BIGINT NOT NULL, -- in days
+    disbursement_date TIMESTAMP,
+    expected_end_date TIMESTAMP,
+    actual_end_date TIMESTAMP,
+    total_repaid_minor BIGINT NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, active, completed, defaulted, refinanced
+    parent_loan_id TEXT REFERENCES loans(id), -- the loan a top-up replaced
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+-- Loan Repayments
+CREATE TABLE IF NOT EXISTS loan_repayments (
+    id TEXT PRIMARY KEY,
+    loan_id TEXT NOT NULL REFERENCES loans(id) ON DELETE CASCADE,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    principal_minor BIGINT NOT NULL,
+    interest_minor BIGINT NOT NULL,
+    penalties_minor BIGINT NOT NULL DEFAULT 0,
+    payment_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    payment_method TEXT,
+    transaction_reference TEXT,
+    status TEXT NOT NULL DEFAULT 'completed', -- pending, completed, failed
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Ledger entries from closed years, moved out of ledger_entries to keep it
+-- small. Each archive run leaves one carry-forward entry per account, member
+-- and entry type in ledger_entries, so all-time totals are unchanged.
+CREATE TABLE IF NOT EXISTS ledger_archives (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    cutoff TIMESTAMP NOT NULL, -- entries effective before this were archived
+    entry_count BIGINT NOT NULL,
+    carry_forward_count BIGINT NOT NULL,
+    archived_by TEXT REFERENCES users(user_id), -- NULL for the scheduled job
+    archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_archives_chama ON ledger_archives(chama_id, cutoff);
+
+-- Ledger entries: every money movement in a chama. Credits are positive and
+-- debits negative so an account balance is SUM(amount_minor).
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    account_id TEXT NOT NULL REFERENCES chama_accounts(id) ON DELETE CASCADE,
+    member_id TEXT REFERENCES users(user_id) ON DELETE SET NULL,
+    entry_type TEXT NOT NULL, -- contribution, fine, loan_disbursement, loan_repayment, interest, income, expense, transfer, adjustment, opening_balance, investment, loan_interest, savings_interest, share_capital, distribution, withdrawal, inter_chama
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    reference TEXT,
+    description TEXT,
+    effective_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- when the money actually moved
+    created_by TEXT REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    archive_id TEXT REFERENCES ledger_archives(id), -- set on the carry-forward entries that stand in for archived ones
+    seq BIGINT, -- posting order within the chama, from ledger_sequences
+    chain_seq BIGINT, -- position in the chama's hash chain; carry-forward entries are not in it
+    prev_hash TEXT,
+    hash TEXT -- SHA-256 of the entry's contents, chain_seq and prev_hash
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_account ON ledger_entries(account_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_member ON ledger_entries(chama_id, member_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_effective ON ledger_entries(chama_id, effective_at);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_chain ON ledger_entries(chama_id, chain_seq);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_entries_seq ON ledger_entries(chama_id, seq);
+
+-- The last posting sequence number given out in each chama's ledger,
+-- claimed by the posting transaction so entries are numbered in the order
+-- they are posted
+CREATE TABLE IF NOT EXISTS ledger_sequences (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    last_seq BIGINT NOT NULL
+);
+
+-- Loan Guarantors
+CREATE TABLE IF NOT EXISTS loan_guarantors (
+    id TEXT PRIMARY KEY,
+    loan_id TEXT NOT NULL REFERENCES loans(id) ON DELETE CASCADE,
+    guarantor_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    guarantee_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(loan_id, guarantor_id)
+);
+
+-- Shares Configuration. Chamas that issue shares sell them at share_value.
+CREATE TABLE IF NOT EXISTS shares_config (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    share_value_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    min_shares_per_member BIGINT NOT NULL DEFAULT 1,
+    max_shares_per_member BIGINT,
+    updated_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(chama_id)
+);
+
+-- Member Shares: the share register
+CREATE TABLE IF NOT EXISTS member_shares (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    shares_count BIGINT NOT NULL DEFAULT 0,
+    total_value_minor BIGINT NOT NULL DEFAULT 0, -- what the member paid for their shares
+    currency TEXT NOT NULL DEFAULT 'KES',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(chama_id, member_id)
+);
+
+-- Share Transactions (purchases and transfers). Transfers between members
+-- stay pending until an official approves them.
+CREATE TABLE IF NOT EXISTS share_transactions (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    transaction_type TEXT NOT NULL, -- purchase, transfer
+    shares_count BIGINT NOT NULL,
+    share_price_minor BIGINT NOT NULL,
+    total_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    recipient_id TEXT REFERENCES users(user_id) ON DELETE SET NULL, -- for transfers
+    transaction_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, completed, rejected
+    requested_by TEXT NOT NULL,
+    decided_by TEXT,
+    decided_at TIMESTAMP,
+    rejection_reason TEXT,
+    ledger_entry_id TEXT REFERENCES ledger_entries(id), -- for purchases
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_share_transactions_chama ON share_transactions(chama_id, status, created_at);
+
+-- Meetings
+CREATE TABLE IF NOT EXISTS meetings (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    description TEXT,
+    meeting_date TIMESTAMP NOT NULL,
+    location TEXT,
+    meeting_type TEXT NOT NULL, -- regular, emergency, agm
+    is_virtual BOOLEAN DEFAULT FALSE,
+    virtual_meeting_link TEXT,
+    status TEXT NOT NULL DEFAULT 'scheduled', -- scheduled, ongoing, completed, cancelled
+    absence_fine_minor BIGINT NOT NULL DEFAULT 0, -- charged to members marked absent
+    currency TEXT NOT NULL DEFAULT 'KES',
+    created_by TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Meeting Agenda Items
+CREATE TABLE IF NOT EXISTS meeting_agenda (
+    id TEXT PRIMARY KEY,
+    meeting_id TEXT NOT NULL REFERENCES meetings(id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    description TEXT,
+    order_index BIGINT NOT NULL,
+    duration_minutes BIGINT,
+    presenter_id TEXT REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Meeting Attendance
+CREATE TABLE IF NOT EXISTS meeting_attendance (
+    id TEXT PRIMARY KEY,
+    meeting_id TEXT NOT NULL REFERENCES meetings(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    attendance_status TEXT NOT NULL DEFAULT 'pending', -- pending, present, absent, excused
+    rsvp TEXT, -- yes, no, maybe
+    rsvp_at TIMESTAMP,
+    check_in_time TIMESTAMP,
+    check_out_time TIMESTAMP,
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(meeting_id, member_id)
+);
+
+-- Meeting Minutes
+CREATE TABLE IF NOT EXISTS meeting_minutes (
+    id TEXT PRIMARY KEY,
+    meeting_id TEXT NOT NULL REFERENCES meetings(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    storage_key TEXT, -- attached minutes document
+    mime_type TEXT,
+    recorded_by TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    is_published BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Group Goals/Projects
+CREATE TABLE IF NOT EXISTS goals (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT REFERENCES users(user_id) ON DELETE CASCADE, -- NULL for chama-wide goals
+    account_id TEXT REFERENCES chama_accounts(id) ON DELETE SET NULL, -- NULL tracks the whole chama
+    title TEXT NOT NULL,
+    description TEXT,
+    target_amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    start_date TIMESTAMP NOT NULL,
+    target_date TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active', -- active, achieved, missed, cancelled
+    last_milestone BIGINT NOT NULL DEFAULT 0, -- highest percentage milestone announced
+    achieved_at TIMESTAMP,
+    created_by TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_goals_chama ON goals(chama_id, status);
+
+-- Notifications
+CREATE TABLE IF NOT EXISTS notifications (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    message TEXT NOT NULL,
+    type TEXT NOT NULL, -- system, chama, meeting, payment, etc.
+    related_id TEXT, -- Could be chama_id, meeting_id, etc.
+    is_read BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Audit Logs
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id TEXT PRIMARY KEY,
+    user_id TEXT REFERENCES users(user_id) ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    entity_type TEXT NOT NULL, -- user, chama, loan, etc.
+    entity_id TEXT,
+    old_values JSON,
+    new_values JSON,
+    ip_address TEXT,
+    user_agent TEXT,
+    timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    effective_at TIMESTAMP -- when the change took effect, if not when it was recorded
+);
+
+-- Chama indexes
+CREATE INDEX IF NOT EXISTS idx_chamas_creator ON chamas(created_by);
+CREATE INDEX IF NOT EXISTS idx_chama_members_chama ON chama_members(chama_id);
+CREATE INDEX IF NOT EXISTS idx_chama_members_user ON chama_members(user_id);
+
+-- Financial indexes
+CREATE INDEX IF NOT EXISTS idx_contributions_chama ON contributions(chama_id);
+CREATE INDEX IF NOT EXISTS idx_contributions_member ON contributions(member_id);
+
+-- Loan indexes
+CREATE INDEX IF NOT EXISTS idx_loans_borrower ON loans(borrower_id);
+CREATE INDEX IF NOT EXISTS idx_loans_chama ON loans(chama_id);
+CREATE INDEX IF NOT EXISTS idx_loan_applications_user ON loan_applications(user_id);
+CREATE INDEX IF NOT EXISTS idx_loan_repayments_loan ON loan_repayments(loan_id);
+CREATE INDEX IF NOT EXISTS idx_loans_status ON loans(status);
+
+-- Meeting indexes
+CREATE INDEX IF NOT EXISTS idx_meetings_chama ON meetings(chama_id);
+CREATE INDEX IF NOT EXISTS idx_meeting_attendance_meeting ON meeting_attendance(meeting_id);
+CREATE INDEX IF NOT EXISTS idx_meeting_attendance_member ON meeting_attendance(member_id);
+
+-- Notification indexes
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_type ON notifications(type);
+
+-- How each user wants notifications delivered outside the app
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id TEXT PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    channels TEXT, -- comma-separated channel names; NULL for all channels
+    mode TEXT NOT NULL DEFAULT 'immediate', -- immediate, digest
+    quiet_from BIGINT, -- hour quiet hours start (local time); NULL for none
+    quiet_to BIGINT, -- hour quiet hours end
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Notifications held back from delivery, for a daily digest, until quiet hours
+-- end or until an unavailable provider recovers
+CREATE TABLE IF NOT EXISTS notification_queue (
+    notification_id TEXT PRIMARY KEY REFERENCES notifications(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    digest BOOLEAN NOT NULL DEFAULT FALSE, -- TRUE when waiting for the digest
+    channels TEXT, -- comma-separated channels to retry after a provider outage; NULL for all
+    queued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_notification_queue_user ON notification_queue(user_id);
+
+-- KYC verification status, one row per user
+CREATE TABLE IF NOT EXISTS kyc_verifications (
+    user_id TEXT PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'unverified', -- unverified, pending, verified, rejected
+    provider TEXT, -- manual or the name of an external verification provider
+    reviewed_by TEXT REFERENCES users(user_id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP,
+    rejection_reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- KYC documents uploaded by members (ID front/back, passport, selfie)
+CREATE TABLE IF NOT EXISTS kyc_documents (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    document_type TEXT NOT NULL, -- national_id_front, national_id_back, passport, selfie
+    storage_key TEXT NOT NULL,
+    mime_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- KYC status history for auditing every status change
+CREATE TABLE IF NOT EXISTS kyc_status_history (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    changed_by TEXT,
+    reason TEXT,
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_kyc_documents_user ON kyc_documents(user_id);
+CREATE INDEX IF NOT EXISTS idx_kyc_status_history_user ON kyc_status_history(user_id);
+
+-- Receipts issued for confirmed contributions and loan repayments
+CREATE TABLE IF NOT EXISTS receipts (
+    id TEXT PRIMARY KEY,
+    receipt_number TEXT UNIQUE NOT NULL, -- e.g. RCT-2025-000042, sequential per chama
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    payment_type TEXT NOT NULL, -- contribution, loan_repayment
+    payment_id TEXT NOT NULL,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    payment_method TEXT,
+    transaction_reference TEXT,
+    storage_key TEXT NOT NULL,
+    issued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(payment_type, payment_id)
+);
+
+-- Last receipt number used per chama
+CREATE TABLE IF NOT EXISTS receipt_sequences (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    last_number BIGINT NOT NULL DEFAULT 0,
+    series TEXT -- e.g. FY2026, the financial year numbering last restarted for; the payment's calendar year when NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_receipts_user ON receipts(user_id);
+
+-- Background job runs, used so scheduled jobs run once per period across restarts
+CREATE TABLE IF NOT EXISTS job_runs (
+    id TEXT PRIMARY KEY,
+    job_name TEXT NOT NULL,
+    run_key TEXT NOT NULL, -- e.g. 2025-03 for a monthly job
+    status TEXT NOT NULL DEFAULT 'running', -- running, completed, failed
+    error TEXT,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    UNIQUE(job_name, run_key)
+);
+
+-- API keys issued to SACCO partners. A key acts as the official who issued
+-- it, within its chama and scopes. Only a hash of the secret is kept.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY, -- also the OAuth2 client ID
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    scopes TEXT NOT NULL, -- space separated, e.g. "transactions:read members:read"
+    rate_limit BIGINT NOT NULL, -- requests per minute
+    secret_hash TEXT NOT NULL,
+    created_by TEXT NOT NULL REFERENCES users(user_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_chama ON api_keys(chama_id);
+
+-- OAuth2 access tokens from the client credentials grant, by hash
+CREATE TABLE IF NOT EXISTS api_tokens (
+    token_hash TEXT PRIMARY KEY,
+    key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    scopes TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Requests made with each API key, per day and scope
+CREATE TABLE IF NOT EXISTS api_usage (
+    key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    day TEXT NOT NULL, -- YYYY-MM-DD, UTC
+    scope TEXT NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (key_id, day, scope)
+);
+
+-- Chamas served from a database of their own (database-per-tenant mode).
+-- The shared database keeps chamas, chama_members and users for them.
+CREATE TABLE IF NOT EXISTS tenant_databases (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,
+    isolated_by TEXT REFERENCES users(user_id),
+    isolated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Nightly database maintenance: integrity check, WAL checkpoint, ANALYZE and
+-- incremental vacuum. The latest run is shown by /api/health.
+CREATE TABLE IF NOT EXISTS maintenance_runs (
+    id TEXT PRIMARY KEY,
+    status TEXT NOT NULL, -- ok, failed
+    tasks TEXT NOT NULL, -- JSON array of each task's name, status, detail and duration
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_maintenance_runs_started ON maintenance_runs(started_at);
+
+-- Generated statements and financial reports
+CREATE TABLE IF NOT EXISTS reports (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    user_id TEXT REFERENCES users(user_id) ON DELETE CASCADE, -- NULL for chama-wide reports
+    report_type TEXT NOT NULL, -- member_statement, chama_financial
+    period TEXT NOT NULL, -- e.g. 2025-03
+    format TEXT NOT NULL, -- pdf, xlsx
+    storage_key TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_unique
+    ON reports(chama_id, COALESCE(user_id, ''), report_type, period, format);
+
+-- Fines charged to members (absence, late payments, ...). A fine becomes
+-- chama income through the ledger only once it is paid.
+CREATE TABLE IF NOT EXISTS fines (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    reason TEXT NOT NULL, -- meeting_absence, late_contribution, late_repayment, other
+    description TEXT,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    status TEXT NOT NULL DEFAULT 'unpaid', -- unpaid, paid, waived
+    related_id TEXT, -- meeting, contribution or loan the fine was charged for
+    issued_by TEXT,
+    ledger_entry_id TEXT REFERENCES ledger_entries(id),
+    settled_by TEXT,
+    settled_at TIMESTAMP,
+    waiver_reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_fines_chama_member ON fines(chama_id, member_id, status);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_fines_related ON fines(reason, related_id, member_id) WHERE related_id IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_entity ON audit_logs(entity_type, entity_id);
+
+-- Default voting thresholds for each chama. Thresholds are in basis points:
+-- quorum is the share of eligible members who must vote, majority the share
+-- of yes/no ballots that must be yes (strictly more than).
+CREATE TABLE IF NOT EXISTS voting_settings (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    quorum_bps BIGINT NOT NULL DEFAULT 5000,
+    majority_bps BIGINT NOT NULL DEFAULT 5000,
+    ballot_type TEXT NOT NULL DEFAULT 'open', -- open, anonymous
+    updated_by TEXT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Votes and resolutions. Thresholds are copied from voting_settings when the
+-- vote opens so later setting changes do not affect it.
+CREATE TABLE IF NOT EXISTS votes (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    subject_type TEXT NOT NULL, -- loan_approval, rule_change, expenditure, general
+    subject_id TEXT,
+    title TEXT NOT NULL,
+    description TEXT,
+    ballot_type TEXT NOT NULL, -- open, anonymous
+    quorum_bps BIGINT NOT NULL,
+    majority_bps BIGINT NOT NULL,
+    eligible_count BIGINT NOT NULL,
+    closes_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'open', -- open, passed, rejected, no_quorum, cancelled
+    yes_count BIGINT NOT NULL DEFAULT 0,
+    no_count BIGINT NOT NULL DEFAULT 0,
+    abstain_count BIGINT NOT NULL DEFAULT 0,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    closed_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_votes_chama ON votes(chama_id, status);
+CREATE INDEX IF NOT EXISTS idx_votes_closing ON votes(status, closes_at);
+
+-- Who may vote and who has voted. Recorded for every ballot type so members
+-- cannot vote twice, even when their choice is anonymous.
+CREATE TABLE IF NOT EXISTS vote_participants (
+    vote_id TEXT NOT NULL REFERENCES votes(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    voted_at TIMESTAMP,
+    PRIMARY KEY (vote_id, member_id)
+);
+
+-- Ballots. voter_id is NULL for anonymous votes.
+CREATE TABLE IF NOT EXISTS ballots (
+    id TEXT PRIMARY KEY,
+    vote_id TEXT NOT NULL REFERENCES votes(id) ON DELETE CASCADE,
+    voter_id TEXT REFERENCES users(user_id) ON DELETE CASCADE,
+    choice TEXT NOT NULL, -- yes, no, abstain
+    cast_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_ballots_vote ON ballots(vote_id);
+
+-- Versioned chama rules (constitution). Each version applies from its
+-- effective date until the next active version takes over.
+CREATE TABLE IF NOT EXISTS chama_rules (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    rules JSON NOT NULL,
+    effective_from TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active', -- proposed, active, rejected
+    vote_id TEXT REFERENCES votes(id) ON DELETE SET NULL,
+    notes TEXT,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(chama_id, version)
+);
+CREATE INDEX IF NOT EXISTS idx_chama_rules_effective ON chama_rules(chama_id, status, effective_from);
+
+-- Investments made from pooled funds (land, money market, shares, ...). The
+-- purchase and any disposal move cash through the ledger; the asset itself is
+-- carried on the balance sheet at its latest valuation.
+CREATE TABLE IF NOT EXISTS investments (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    account_id TEXT NOT NULL REFERENCES chama_accounts(id),
+    name TEXT NOT NULL,
+    asset_type TEXT NOT NULL, -- land, money_market, shares, bonds, property, business, other
+    description TEXT,
+    cost_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    acquired_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active', -- active, disposed
+    disposal_minor BIGINT,
+    disposed_at TIMESTAMP,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_investments_chama ON investments(chama_id, status);
+
+-- Valuations of an investment over time
+CREATE TABLE IF NOT EXISTS investment_valuations (
+    id TEXT PRIMARY KEY,
+    investment_id TEXT NOT NULL REFERENCES investments(id) ON DELETE CASCADE,
+    value_minor BIGINT NOT NULL,
+    valued_at TIMESTAMP NOT NULL,
+    notes TEXT,
+    recorded_by TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_investment_valuations ON investment_valuations(investment_id, valued_at);
+
+-- Income (rent, dividends, interest) and expenses (rates, maintenance)
+-- attributed to an investment. Each one is also a ledger entry.
+CREATE TABLE IF NOT EXISTS investment_transactions (
+    id TEXT PRIMARY KEY,
+    investment_id TEXT NOT NULL REFERENCES investments(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL, -- income, expense
+    amount_minor BIGINT NOT NULL,
+    description TEXT,
+    ledger_entry_id TEXT NOT NULL REFERENCES ledger_entries(id),
+    occurred_at TIMESTAMP NOT NULL,
+    recorded_by TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_investment_transactions ON investment_transactions(investment_id, occurred_at);
+
+-- Chama expenses. An expense is posted to the ledger only once approved.
+CREATE TABLE IF NOT EXISTS expenses (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    account_id TEXT NOT NULL REFERENCES chama_accounts(id),
+    category TEXT NOT NULL,
+    description TEXT NOT NULL,
+    payee TEXT,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    incurred_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected
+    storage_key TEXT, -- receipt or invoice
+    mime_type TEXT,
+    requested_by TEXT NOT NULL,
+    decided_by TEXT,
+    decided_at TIMESTAMP,
+    rejection_reason TEXT,
+    ledger_entry_id TEXT REFERENCES ledger_entries(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_expenses_chama ON expenses(chama_id, status, incurred_at);
+
+-- Monthly spending limits per expense category. alert_level records which
+-- alerts have been sent for the period: 1 approaching, 2 exceeded.
+CREATE TABLE IF NOT EXISTS expense_budgets (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    category TEXT NOT NULL,
+    period TEXT NOT NULL, -- YYYY-MM
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    alert_bps BIGINT NOT NULL DEFAULT 8000,
+    alert_level BIGINT NOT NULL DEFAULT 0,
+    updated_by TEXT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(chama_id, category, period)
+);
+
+-- Transfers between a chama's funds (accounts). A transfer moves money only
+-- once a second official approves it.
+CREATE TABLE IF NOT EXISTS fund_transfers (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    from_account_id TEXT NOT NULL REFERENCES chama_accounts(id),
+    to_account_id TEXT NOT NULL REFERENCES chama_accounts(id),
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected
+    requested_by TEXT NOT NULL,
+    decided_by TEXT,
+    decided_at TIMESTAMP,
+    rejection_reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_fund_transfers_chama ON fund_transfers(chama_id, status);
+
+-- One-time codes sent by SMS. Only a hash of the code is stored.
+CREATE TABLE IF NOT EXISTS otp_codes (
+    id TEXT PRIMARY KEY,
+    purpose TEXT NOT NULL, -- invitation, two_factor, password_reset, phone_change
+    destination TEXT NOT NULL, -- phone number or email address the code was sent to
+    code_hash TEXT NOT NULL,
+    attempts BIGINT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    consumed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_otp_codes_destination ON otp_codes(purpose, destination, created_at);
+
+-- Invitations to join a chama. Anyone with the code can use it until it
+-- expires, is revoked or reaches max_uses.
+CREATE TABLE IF NOT EXISTS chama_invitations (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    code TEXT UNIQUE NOT NULL,
+    role TEXT NOT NULL DEFAULT 'member',
+    note TEXT,
+    max_uses BIGINT NOT NULL DEFAULT 1,
+    uses BIGINT NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'active', -- active, revoked
+    expires_at TIMESTAMP NOT NULL,
+    invited_by TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_chama_invitations_chama ON chama_invitations(chama_id, status);
+
+-- A user's request to join through an invitation. It moves from otp_sent to
+-- pending_approval once the phone is verified, then to approved or rejected.
+-- Requests made from a chama's public profile have no invitation and start
+-- at pending_approval.
+CREATE TABLE IF NOT EXISTS join_requests (
+    id TEXT PRIMARY KEY,
+    invitation_id TEXT REFERENCES chama_invitations(id) ON DELETE CASCADE,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    phone TEXT NOT NULL,
+    message TEXT, -- the requester's note to the officials
+    status TEXT NOT NULL DEFAULT 'otp_sent', -- otp_sent, pending_approval, approved, rejected
+    verified_at TIMESTAMP,
+    decided_by TEXT,
+    decided_at TIMESTAMP,
+    rejection_reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(chama_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_join_requests_chama ON join_requests(chama_id, status);
+
+-- Two-factor authentication settings. The row is created when a user starts
+-- enrolling and enabled_at is set once they confirm their first code.
+CREATE TABLE IF NOT EXISTS user_two_factor (
+    user_id TEXT PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    method TEXT NOT NULL, -- totp, sms
+    totp_secret TEXT,
+    totp_last_step BIGINT NOT NULL DEFAULT 0, -- last accepted time step, so a code cannot be replayed
+    enabled_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Single-use recovery codes for when the second factor is unavailable
+CREATE TABLE IF NOT EXISTS two_factor_recovery_codes (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_two_factor_recovery_codes_user ON two_factor_recovery_codes(user_id);
+
+-- Sessions (by X-Session-ID) that recently passed a second-factor check
+CREATE TABLE IF NOT EXISTS two_factor_sessions (
+    session_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    verified_at TIMESTAMP NOT NULL
+);
+
+-- Callbacks received from payment providers. They are kept after processing
+-- so failed ones can be inspected and replayed.
+CREATE TABLE IF NOT EXISTS payment_callbacks (
+    id TEXT PRIMARY KEY,
+    provider TEXT NOT NULL, -- mpesa, airtel, ...
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'received', -- received, processed, failed
+    error TEXT,
+    attempts BIGINT NOT NULL DEFAULT 0,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_payment_callbacks_status ON payment_callbacks(status, received_at);
+
+-- Feature flags, toggled at runtime from the admin API. A flag is on for a
+-- request when it is enabled and the chama or user is listed, or the user
+-- falls inside the rollout percentage.
+CREATE TABLE IF NOT EXISTS feature_flags (
+    key TEXT PRIMARY KEY,
+    description TEXT,
+    enabled BIGINT NOT NULL DEFAULT 0, -- master switch; off means off for everyone
+    rollout_percent BIGINT NOT NULL DEFAULT 0, -- 0-100 of users, chosen by a stable hash
+    chama_ids TEXT, -- comma-separated chamas the flag is always on for
+    user_ids TEXT, -- comma-separated users the flag is always on for
+    updated_by TEXT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Dashboard summaries, updated by every ledger posting so dashboards read a
+-- few rows instead of scanning the ledger. dashboard.Rebuild recomputes them.
+CREATE TABLE IF NOT EXISTS chama_summaries (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    savings_minor BIGINT NOT NULL DEFAULT 0, -- contributions and opening balances
+    loans_outstanding_minor BIGINT NOT NULL DEFAULT 0, -- disbursed less repaid principal
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS member_summaries (
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    savings_minor BIGINT NOT NULL DEFAULT 0,
+    loan_outstanding_minor BIGINT NOT NULL DEFAULT 0,
+    last_contribution_at TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chama_id, member_id)
+);
+
+-- Contributions per member per day, so a cycle's collections are a short range read
+CREATE TABLE IF NOT EXISTS contribution_days (
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    amount_minor BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (chama_id, member_id, day)
+);
+CREATE INDEX IF NOT EXISTS idx_contribution_days_day ON contribution_days(chama_id, day);
+
+-- Overdue contributions and loan instalments found by the daily arrears job.
+-- A row stays open while money is owed and is resolved once it is paid.
+CREATE TABLE IF NOT EXISTS arrears (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    kind TEXT NOT NULL, -- contribution, loan
+    source_id TEXT NOT NULL, -- the loan, or the chama for contributions
+    amount_owed_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    due_date DATE NOT NULL, -- of the oldest unpaid cycle or instalment
+    days_overdue BIGINT NOT NULL DEFAULT 0,
+    escalation_level BIGINT NOT NULL DEFAULT 0, -- 1 member, 2 guarantors, 3 officials notified
+    detected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_arrears_open ON arrears(kind, source_id, member_id) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_arrears_chama ON arrears(chama_id, resolved_at);
+
+-- Versions of a loan's repayment schedule. A restructure supersedes the
+-- current version rather than changing it, so earlier schedules stay on
+-- record. repaid_minor is what had been repaid when the version started.
+CREATE TABLE IF NOT EXISTS loan_schedules (
+    loan_id TEXT NOT NULL REFERENCES loans(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    starts_at TIMESTAMP NOT NULL,
+    principal_minor BIGINT NOT NULL,
+    repaid_minor BIGINT NOT NULL DEFAULT 0,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    interest_rate_bps BIGINT NOT NULL,
+    interest_type TEXT NOT NULL,
+    term BIGINT NOT NULL, -- in days
+    change_id TEXT, -- the loan change that created it
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    superseded_at TIMESTAMP,
+    PRIMARY KEY (loan_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS loan_instalments (
+    loan_id TEXT NOT NULL,
+    version BIGINT NOT NULL,
+    number BIGINT NOT NULL,
+    due_date DATE NOT NULL,
+    principal_minor BIGINT NOT NULL,
+    interest_minor BIGINT NOT NULL,
+    PRIMARY KEY (loan_id, version, number),
+    FOREIGN KEY (loan_id, version) REFERENCES loan_schedules(loan_id, version) ON DELETE CASCADE
+);
+
+-- Requests to restructure or top up a loan. Like fund transfers they take
+-- effect only once a second official approves them.
+CREATE TABLE IF NOT EXISTS loan_changes (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    loan_id TEXT NOT NULL REFERENCES loans(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL, -- restructure, top_up
+    term BIGINT NOT NULL, -- in days from approval
+    interest_rate_bps BIGINT NOT NULL,
+    interest_type TEXT NOT NULL,
+    top_up_minor BIGINT NOT NULL DEFAULT 0,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    account_id TEXT REFERENCES chama_accounts(id), -- fund a top-up is paid from
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected
+    requested_by TEXT NOT NULL,
+    decided_by TEXT,
+    decided_at TIMESTAMP,
+    rejection_reason TEXT,
+    new_loan_id TEXT REFERENCES loans(id), -- the loan a top-up created
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_loan_changes_chama ON loan_changes(chama_id, status);
+
+-- Interest accrued by the nightly accrual job on loans and on members'
+-- savings. A loan or member is accrued once per period, which is YYYY-MM-DD
+-- for chamas that accrue daily and YYYY-MM for monthly.
+CREATE TABLE IF NOT EXISTS interest_accruals (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL, -- loan, savings
+    source_id TEXT NOT NULL, -- the loan, or the member for savings
+    member_id TEXT NOT NULL,
+    period TEXT NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    period_end TIMESTAMP NOT NULL,
+    basis_minor BIGINT NOT NULL, -- principal or savings balance interest was charged on
+    rate_bps BIGINT NOT NULL, -- a year
+    days BIGINT NOT NULL,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    account_id TEXT REFERENCES chama_accounts(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(kind, source_id, period)
+);
+CREATE INDEX IF NOT EXISTS idx_interest_accruals_chama ON interest_accruals(chama_id, period_start);
+
+-- Treasurer handovers. The handover report is a snapshot taken when the
+-- handover starts; roles change once the outgoing and incoming treasurers
+-- and the chairperson have all acknowledged it.
+CREATE TABLE IF NOT EXISTS treasurer_handovers (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    outgoing_id TEXT NOT NULL REFERENCES users(user_id),
+    incoming_id TEXT NOT NULL REFERENCES users(user_id),
+    effective_at TIMESTAMP, -- when the new treasurer took over; completion time if not given
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, completed, cancelled
+    report JSON NOT NULL,
+    initiated_by TEXT NOT NULL,
+    outgoing_ack_at TIMESTAMP,
+    incoming_ack_at TIMESTAMP,
+    chair_ack_by TEXT,
+    chair_ack_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    cancelled_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_treasurer_handovers_chama ON treasurer_handovers(chama_id, status);
+
+-- Dissolutions. Members vote to dissolve; once the vote passes the chama is
+-- dissolving until its funds are distributed, when it is dissolved and
+-- archived.
+CREATE TABLE IF NOT EXISTS chama_dissolutions (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'proposed', -- proposed, approved, rejected, completed
+    vote_id TEXT REFERENCES votes(id),
+    method TEXT, -- how the surplus was shared, from the rules in force when distributed
+    proposed_by TEXT NOT NULL,
+    distributed_by TEXT,
+    distributed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_chama_dissolutions_chama ON chama_dissolutions(chama_id, status);
+
+-- What each member was paid when their chama was dissolved
+CREATE TABLE IF NOT EXISTS dissolution_payouts (
+    dissolution_id TEXT NOT NULL REFERENCES chama_dissolutions(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL,
+    savings_minor BIGINT NOT NULL,
+    shares_minor BIGINT NOT NULL,
+    surplus_minor BIGINT NOT NULL, -- negative when the chama had a deficit
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    PRIMARY KEY (dissolution_id, member_id)
+);
+
+-- Members leaving a chama. After the notice period in the chama's rules the
+-- member's savings and share capital are refunded, less loans and fines they
+-- owe; savings backing loans they guarantee are held until those are repaid.
+CREATE TABLE IF NOT EXISTS member_exits (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL,
+    reason TEXT,
+    status TEXT NOT NULL DEFAULT 'requested', -- requested, approved, rejected, cancelled, paid, closed
+    effective_date DATE NOT NULL, -- end of the notice period; the refund is paid from then
+    savings_minor BIGINT NOT NULL DEFAULT 0,
+    shares_minor BIGINT NOT NULL DEFAULT 0,
+    loans_minor BIGINT NOT NULL DEFAULT 0,
+    fines_minor BIGINT NOT NULL DEFAULT 0,
+    held_minor BIGINT NOT NULL DEFAULT 0, -- savings backing loans the member guarantees
+    refund_minor BIGINT NOT NULL DEFAULT 0,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    account_id TEXT REFERENCES chama_accounts(id),
+    requested_by TEXT NOT NULL,
+    decided_by TEXT,
+    decided_at TIMESTAMP,
+    decision_reason TEXT,
+    paid_by TEXT,
+    paid_at TIMESTAMP,
+    closed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_member_exits_chama ON member_exits(chama_id, status);
+
+-- USSD sessions. The gateway sends every step of a session to the same
+-- endpoint; the state is where the member is in the menus.
+CREATE TABLE IF NOT EXISTS ussd_sessions (
+    id TEXT PRIMARY KEY, -- the gateway's session ID
+    phone_number TEXT NOT NULL,
+    user_id TEXT,
+    state TEXT NOT NULL,
+    data TEXT NOT NULL DEFAULT '{}', -- choices made so far, as JSON
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_ussd_sessions_updated ON ussd_sessions(updated_at);
+
+-- Money sent from a chama fund to a member's phone: loan disbursements and
+-- payouts such as a merry-go-round turn. The fund is debited when the payout
+-- is sent and credited back if the provider reports it failed.
+CREATE TABLE IF NOT EXISTS disbursements (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id),
+    account_id TEXT NOT NULL REFERENCES chama_accounts(id),
+    purpose TEXT NOT NULL, -- loan, payout
+    loan_id TEXT REFERENCES loans(id),
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    phone_number TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    provider_reference TEXT, -- e.g. the M-Pesa B2C conversation ID
+    receipt TEXT, -- the provider's transaction code once delivered
+    status TEXT NOT NULL DEFAULT 'pending', -- awaiting_approval, pending, completed, failed, rejected
+    error TEXT,
+    remarks TEXT,
+    requested_by TEXT NOT NULL REFERENCES users(user_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_disbursements_chama ON disbursements(chama_id, status);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_disbursements_provider_ref ON disbursements(provider, provider_reference) WHERE provider_reference IS NOT NULL;
+
+-- Uploaded M-Pesa paybill statements and bank exports. Each credit line is
+-- matched to a contribution, or queued as unmatched for an official.
+CREATE TABLE IF NOT EXISTS statement_imports (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    account_id TEXT NOT NULL REFERENCES chama_accounts(id), -- fund new contributions are recorded to
+    source TEXT NOT NULL, -- mpesa, bank
+    filename TEXT NOT NULL,
+    total_lines BIGINT NOT NULL DEFAULT 0,
+    matched_lines BIGINT NOT NULL DEFAULT 0,
+    unmatched_lines BIGINT NOT NULL DEFAULT 0,
+    duplicate_lines BIGINT NOT NULL DEFAULT 0,
+    imported_by TEXT NOT NULL REFERENCES users(user_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_statement_imports_chama ON statement_imports(chama_id, created_at);
+
+CREATE TABLE IF NOT EXISTS statement_lines (
+    id TEXT PRIMARY KEY,
+    import_id TEXT NOT NULL REFERENCES statement_imports(id) ON DELETE CASCADE,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    row_number BIGINT NOT NULL,
+    transaction_date TIMESTAMP NOT NULL,
+    reference TEXT, -- M-Pesa receipt or bank transaction reference
+    phone_number TEXT,
+    payer_name TEXT,
+    account_reference TEXT, -- the paybill account number the payer entered
+    description TEXT,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    status TEXT NOT NULL, -- matched, unmatched, duplicate, ignored
+    member_id TEXT REFERENCES users(user_id),
+    contribution_id TEXT REFERENCES contributions(id),
+    matched_by TEXT REFERENCES users(user_id),
+    matched_at TIMESTAMP,
+    note TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_statement_lines_chama ON statement_lines(chama_id, status);
+CREATE INDEX IF NOT EXISTS idx_statement_lines_reference ON statement_lines(chama_id, reference);
+
+-- A chama's own choice of account in QuickBooks or Xero for a fund
+-- ("fund:<account id>") or ledger entry type ("entry_type:<type>"). Keys with
+-- no row use the defaults.
+CREATE TABLE IF NOT EXISTS accounting_accounts (
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    source_key TEXT NOT NULL,
+    account_code TEXT NOT NULL DEFAULT '',
+    account_name TEXT NOT NULL,
+    account_type TEXT NOT NULL, -- BANK, OASSET, EQUITY, INC, EXP
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chama_id, source_key)
+);
+
+-- Accounts added to the chart of accounts, beyond the built-in ones that
+-- entry types are booked to by default. Accounts with no chama are added by
+-- platform staff and offered to every chama.
+CREATE TABLE IF NOT EXISTS chart_accounts (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT REFERENCES chamas(id) ON DELETE CASCADE,
+    code TEXT NOT NULL,
+    name TEXT NOT NULL,
+    account_type TEXT NOT NULL, -- BANK, OASSET, EQUITY, INC, EXP
+    description TEXT,
+    created_by TEXT NOT NULL REFERENCES users(user_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chart_accounts_code ON chart_accounts(COALESCE(chama_id, ''), code);
+
+-- Books exported to an accounting package, one row per download
+CREATE TABLE IF NOT EXISTS accounting_exports (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    format TEXT NOT NULL, -- iif, qbo, xero
+    period_start TIMESTAMP NOT NULL,
+    period_end TIMESTAMP NOT NULL, -- exclusive
+    journal_count BIGINT NOT NULL,
+    exported_by TEXT NOT NULL REFERENCES users(user_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_accounting_exports_chama ON accounting_exports(chama_id, created_at);
+
+-- Accounts anonymised under a data protection erasure request. The user row
+-- stays, scrubbed, so the chama ledgers it appears in still balance.
+CREATE TABLE IF NOT EXISTS data_erasures (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id),
+    requested_by TEXT NOT NULL REFERENCES users(user_id), -- the member, or platform staff
+    reason TEXT,
+    erased_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_data_erasures_user ON data_erasures(user_id);
+
+CREATE TABLE IF NOT EXISTS ledger_entries_archive (
+    seq BIGINT NOT NULL, -- the entry's seq in ledger_entries, keeping its posting order
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL,
+    account_id TEXT NOT NULL,
+    member_id TEXT,
+    entry_type TEXT NOT NULL,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL,
+    reference TEXT,
+    description TEXT,
+    effective_at TIMESTAMP,
+    created_by TEXT,
+    created_at TIMESTAMP,
+    chain_seq BIGINT,
+    prev_hash TEXT,
+    hash TEXT,
+    archive_id TEXT NOT NULL REFERENCES ledger_archives(id)
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_archive_effective ON ledger_entries_archive(chama_id, effective_at);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_archive_member ON ledger_entries_archive(chama_id, member_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_archive_chain ON ledger_entries_archive(chama_id, chain_seq);
+
+-- The full ledger: live and archived entries, without the carry-forward
+-- entries that stand in for the archived ones. Statements and reports read
+-- this so archived periods stay queryable.
+CREATE OR REPLACE VIEW ledger_history AS
+    SELECT seq, id, chama_id, account_id, member_id, entry_type, amount_minor, currency, reference,
+           description, effective_at, created_by, created_at
+    FROM ledger_entries WHERE archive_id IS NULL
+    UNION ALL
+    SELECT seq, id, chama_id, account_id, member_id, entry_type, amount_minor, currency, reference,
+           description, effective_at, created_by, created_at
+    FROM ledger_entries_archive;
+
+-- The ledger's event stream when LEDGER_EVENT_SOURCING is on: every posting
+-- in order, from which ledger_entries, balances and projections are rebuilt.
+-- Events are never changed or removed.
+CREATE TABLE IF NOT EXISTS ledger_events (
+    seq BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id),
+    event_type TEXT NOT NULL,
+    entry_id TEXT NOT NULL,
+    payload TEXT NOT NULL, -- JSON
+    recorded_at TIMESTAMP NOT NULL,
+    imported BOOLEAN NOT NULL DEFAULT FALSE -- from an entry posted before event sourcing was on
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_events_chama ON ledger_events(chama_id, seq);
+CREATE INDEX IF NOT EXISTS idx_ledger_events_entry ON ledger_events(entry_id);
+CREATE OR REPLACE FUNCTION ledger_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger events are append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS ledger_events_no_update ON ledger_events;
+CREATE TRIGGER ledger_events_no_update BEFORE UPDATE ON ledger_events
+    FOR EACH ROW EXECUTE FUNCTION ledger_events_append_only();
+DROP TRIGGER IF EXISTS ledger_events_no_delete ON ledger_events;
+CREATE TRIGGER ledger_events_no_delete BEFORE DELETE ON ledger_events
+    FOR EACH ROW EXECUTE FUNCTION ledger_events_append_only();
+
+-- Metered usage billed to chamas that is not counted from their own records,
+-- e.g. SMS sent on their behalf
+CREATE TABLE IF NOT EXISTS usage_events (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL, -- sms
+    quantity BIGINT NOT NULL DEFAULT 1,
+    occurred_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_usage_events_chama ON usage_events(chama_id, kind, occurred_at);
+
+-- Monthly platform fee invoices, keeping the usage and prices they were issued at
+CREATE TABLE IF NOT EXISTS platform_invoices (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    period TEXT NOT NULL, -- YYYY-MM
+    active_members BIGINT NOT NULL,
+    sms BIGINT NOT NULL,
+    transactions BIGINT NOT NULL,
+    price_per_member BIGINT NOT NULL,
+    price_per_sms BIGINT NOT NULL,
+    price_per_transaction BIGINT NOT NULL,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    status TEXT NOT NULL DEFAULT 'issued', -- issued, paid, void
+    issued_at TIMESTAMP NOT NULL,
+    due_at TIMESTAMP NOT NULL,
+    paid_at TIMESTAMP,
+    payment_reference TEXT,
+    UNIQUE(chama_id, period)
+);
+CREATE INDEX IF NOT EXISTS idx_platform_invoices_status ON platform_invoices(status, due_at);
+
+-- Chamas suspended for unpaid platform fees; they are read-only until paid
+CREATE TABLE IF NOT EXISTS billing_suspensions (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    suspended_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- When each chama reminds members to contribute; chamas without a row use
+-- the default schedule
+CREATE TABLE IF NOT EXISTS reminder_schedules (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    offsets TEXT NOT NULL, -- comma-separated days relative to the due date, e.g. -3,0,2
+    escalate_after BIGINT NOT NULL DEFAULT 2, -- missed cycles in a row before officials are told; 0 for never
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_by TEXT REFERENCES users(user_id),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Contribution reminders and escalations sent, so none is sent twice
+CREATE TABLE IF NOT EXISTS contribution_reminders (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    kind TEXT NOT NULL, -- reminder, escalation
+    cycle_due DATE NOT NULL,
+    offset_days BIGINT NOT NULL DEFAULT 0,
+    recipients BIGINT NOT NULL DEFAULT 1,
+    sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(chama_id, member_id, kind, cycle_due, offset_days)
+);
+CREATE INDEX IF NOT EXISTS idx_contribution_reminders_chama ON contribution_reminders(chama_id, sent_at);
+
+-- Mutations the mobile app made offline and synced, so a retried sync does
+-- not apply them twice
+CREATE TABLE IF NOT EXISTS sync_mutations (
+    id TEXT PRIMARY KEY, -- generated by the app
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    device_id TEXT,
+    type TEXT NOT NULL, -- attendance, rsvp, cash_contribution
+    status TEXT NOT NULL, -- applied, conflict, rejected
+    error TEXT,
+    made_at TIMESTAMP, -- on the device
+    synced_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_sync_mutations_user ON sync_mutations(user_id, synced_at);
+CREATE INDEX IF NOT EXISTS idx_meetings_updated ON meetings(chama_id, updated_at);
+CREATE INDEX IF NOT EXISTS idx_contributions_updated ON contributions(chama_id, updated_at);
+
+-- Time-limited, read-only access for external auditors to one chama's books
+CREATE TABLE IF NOT EXISTS chama_auditors (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    firm TEXT,
+    purpose TEXT,
+    granted_by TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_by TEXT,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_chama_auditors_chama ON chama_auditors(chama_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_chama_auditors_user ON chama_auditors(user_id, expires_at);
+
+-- Everything an auditor viewed, for the chama's officials to review
+CREATE TABLE IF NOT EXISTS auditor_views (
+    id TEXT PRIMARY KEY,
+    access_id TEXT NOT NULL REFERENCES chama_auditors(id) ON DELETE CASCADE,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    resource TEXT NOT NULL, -- ledger, statement, member_statement, audit_trail, documents, document, verify
+    path TEXT NOT NULL,
+    query TEXT,
+    ip_address TEXT,
+    viewed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_auditor_views_chama ON auditor_views(chama_id, viewed_at);
+
+-- Domain events waiting to be published to the message broker, written in
+-- the same transaction as the change they describe. Published events are
+-- kept for a week.
+CREATE TABLE IF NOT EXISTS event_outbox (
+    seq BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY, -- publish order
+    id TEXT NOT NULL UNIQUE,
+    event_type TEXT NOT NULL,
+    schema_version BIGINT NOT NULL,
+    chama_id TEXT NOT NULL,
+    payload TEXT NOT NULL, -- JSON envelope as published
+    attempts BIGINT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_event_outbox_published ON event_outbox(published_at, seq);
+
+-- Suspicious activity flagged by the fraud detection rules. Each pattern is
+-- raised once, keyed by the rule and the record that tripped it, and stays
+-- open until an official or platform admin confirms or dismisses it.
+CREATE TABLE IF NOT EXISTS fraud_alerts (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    rule TEXT NOT NULL, -- repeated_reversals, unknown_phone, self_approval, after_hours
+    severity TEXT NOT NULL, -- high, medium
+    subject_type TEXT NOT NULL, -- contribution, ledger_entry, disbursement, loan_application, loan_change
+    subject_id TEXT NOT NULL,
+    member_id TEXT REFERENCES users(user_id), -- whose money it concerns
+    actor_id TEXT REFERENCES users(user_id), -- who did the suspicious thing; not told of the alert and cannot review it
+    details JSON,
+    status TEXT NOT NULL DEFAULT 'open', -- open, confirmed, dismissed
+    reviewed_by TEXT REFERENCES users(user_id),
+    reviewed_at TIMESTAMP,
+    review_note TEXT,
+    detected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_fraud_alerts_subject ON fraud_alerts(rule, subject_id);
+CREATE INDEX IF NOT EXISTS idx_fraud_alerts_chama ON fraud_alerts(chama_id, status);
+CREATE INDEX IF NOT EXISTS idx_fraud_alerts_status ON fraud_alerts(status, detected_at);
+
+-- Expenses and payouts waiting on a chama's approval tiers. The tier in
+-- force when the spending was requested is copied here, so a later rules
+-- change does not alter what spending already waiting needs.
+CREATE TABLE IF NOT EXISTS approval_requests (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    subject_type TEXT NOT NULL, -- expense, disbursement
+    subject_id TEXT NOT NULL,
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES',
+    required BIGINT NOT NULL, -- distinct approvals needed; 0 when put to a vote
+    roles TEXT NOT NULL, -- JSON list of the roles that may approve
+    vote BIGINT NOT NULL DEFAULT 0, -- decided by a vote of the members
+    vote_id TEXT REFERENCES votes(id),
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected
+    requested_by TEXT NOT NULL REFERENCES users(user_id),
+    decided_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_approval_requests_subject ON approval_requests(subject_type, subject_id);
+CREATE INDEX IF NOT EXISTS idx_approval_requests_chama ON approval_requests(chama_id, status);
+
+-- Each official's approval or rejection of a request
+CREATE TABLE IF NOT EXISTS approval_decisions (
+    request_id TEXT NOT NULL REFERENCES approval_requests(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(user_id),
+    decision TEXT NOT NULL, -- approve, reject
+    reason TEXT,
+    decided_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (request_id, user_id)
+);
+
+-- A chama's profile for people looking for a group to join. Chamas are only
+-- listed once their officials make the profile public.
+CREATE TABLE IF NOT EXISTS chama_profiles (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    public BIGINT NOT NULL DEFAULT 0,
+    description TEXT, -- shown instead of the chama's own description when set
+    location TEXT,
+    criteria TEXT, -- who may join
+    accepting_requests BIGINT NOT NULL DEFAULT 1,
+    updated_by TEXT REFERENCES users(user_id),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_chama_profiles_public ON chama_profiles(public);
+
+-- Each user's referral code, created the first time they look it up
+CREATE TABLE IF NOT EXISTS referral_codes (
+    user_id TEXT PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    code TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Sign-ups attributed to a referrer. A user is referred at most once.
+CREATE TABLE IF NOT EXISTS referrals (
+    id TEXT PRIMARY KEY,
+    referrer_id TEXT NOT NULL REFERENCES users(user_id),
+    referred_id TEXT NOT NULL UNIQUE REFERENCES users(user_id),
+    code TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'signed_up', -- signed_up, contributed
+    signed_up_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    first_contribution_id TEXT REFERENCES contributions(id),
+    first_contribution_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_referrals_referrer ON referrals(referrer_id, signed_up_at);
+CREATE INDEX IF NOT EXISTS idx_referrals_pending ON referrals(first_contribution_at);
+
+-- Rewards accrued to referrers, at the amount the rules set when accrued
+CREATE TABLE IF NOT EXISTS referral_rewards (
+    id TEXT PRIMARY KEY,
+    referral_id TEXT NOT NULL REFERENCES referrals(id),
+    referrer_id TEXT NOT NULL REFERENCES users(user_id),
+    kind TEXT NOT NULL, -- sign_up, first_contribution
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL,
+    accrued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (referral_id, kind)
+);
+CREATE INDEX IF NOT EXISTS idx_referral_rewards_referrer ON referral_rewards(referrer_id, accrued_at);
+
+-- Money members have loaded to pay their chamas from, one balance per currency
+CREATE TABLE IF NOT EXISTS wallets (
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    currency TEXT NOT NULL,
+    balance_minor BIGINT NOT NULL DEFAULT 0 CHECK (balance_minor >= 0),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, currency)
+);
+
+-- Money into and out of wallets. Amounts are positive into the wallet.
+CREATE TABLE IF NOT EXISTS wallet_transactions (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    kind TEXT NOT NULL, -- top_up, contribution, refund
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, completed, failed
+    method TEXT, -- the provider a top-up was paid with, or wallet
+    provider_reference TEXT,
+    receipt TEXT, -- the provider's transaction code
+    chama_id TEXT REFERENCES chamas(id),
+    reference TEXT, -- the contribution paid, or the debit refunded
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_wallet_transactions_user ON wallet_transactions(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_wallet_transactions_provider ON wallet_transactions(method, provider_reference);
+
+-- Standing orders paying a member's contribution from their wallet each cycle
+CREATE TABLE IF NOT EXISTS standing_orders (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    amount_minor BIGINT NOT NULL DEFAULT 0, -- 0 pays the chama's contribution amount
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active', -- active, cancelled
+    last_paid_due DATE, -- due date of the last cycle paid
+    last_shortfall_due DATE, -- due date of the last cycle the member was told the wallet was short
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, chama_id)
+);
+CREATE INDEX IF NOT EXISTS idx_standing_orders_status ON standing_orders(status);
+
+-- Organisations, such as SACCOs, that run several chamas
+CREATE TABLE IF NOT EXISTS umbrellas (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS umbrella_admins (
+    umbrella_id TEXT NOT NULL REFERENCES umbrellas(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (umbrella_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_umbrella_admins_user ON umbrella_admins(user_id);
+
+-- A chama belongs to at most one umbrella
+CREATE TABLE IF NOT EXISTS umbrella_chamas (
+    umbrella_id TEXT NOT NULL REFERENCES umbrellas(id) ON DELETE CASCADE,
+    chama_id TEXT NOT NULL UNIQUE REFERENCES chamas(id) ON DELETE CASCADE,
+    added_by TEXT NOT NULL,
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (umbrella_id, chama_id)
+);
+
+-- Money moved between chamas under one umbrella, posted to both chamas' ledgers once approved
+CREATE TABLE IF NOT EXISTS umbrella_transfers (
+    id TEXT PRIMARY KEY,
+    umbrella_id TEXT NOT NULL REFERENCES umbrellas(id) ON DELETE CASCADE,
+    from_chama_id TEXT NOT NULL REFERENCES chamas(id),
+    from_account_id TEXT NOT NULL REFERENCES chama_accounts(id),
+    to_chama_id TEXT NOT NULL REFERENCES chamas(id),
+    to_account_id TEXT NOT NULL REFERENCES chama_accounts(id),
+    amount_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected
+    requested_by TEXT NOT NULL,
+    decided_by TEXT,
+    decided_at TIMESTAMP,
+    rejection_reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_umbrella_transfers_umbrella ON umbrella_transfers(umbrella_id, status);
+
+-- The latest pack sent to officials ahead of each meeting
+CREATE TABLE IF NOT EXISTS meeting_packs (
+    meeting_id TEXT PRIMARY KEY REFERENCES meetings(id) ON DELETE CASCADE,
+    storage_key TEXT NOT NULL,
+    generated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Closed financial years. Nothing can be posted with an effective date in a
+-- year unless it has been reopened, which a second official must approve.
+CREATE TABLE IF NOT EXISTS financial_years (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    label TEXT NOT NULL, -- e.g. 2025, or 2025-26 for a year starting mid-year
+    start_date TIMESTAMP NOT NULL,
+    end_date TIMESTAMP NOT NULL, -- exclusive
+    status TEXT NOT NULL DEFAULT 'closed', -- closed, reopen_requested, reopened
+    closed_by TEXT NOT NULL REFERENCES users(user_id),
+    closed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    reopen_reason TEXT,
+    reopen_requested_by TEXT REFERENCES users(user_id),
+    reopened_by TEXT REFERENCES users(user_id),
+    reopened_at TIMESTAMP,
+    reports_generated_at TIMESTAMP, -- when the year's report and member statements were stored
+    UNIQUE(chama_id, label)
+);
+CREATE INDEX IF NOT EXISTS idx_financial_years_chama ON financial_years(chama_id, start_date);
+
+-- Closing balances of a financial year, carried forward as the next year's
+-- opening figures: one row per fund, and one per member for their savings
+CREATE TABLE IF NOT EXISTS financial_year_balances (
+    year_id TEXT NOT NULL REFERENCES financial_years(id) ON DELETE CASCADE,
+    account_id TEXT REFERENCES chama_accounts(id) ON DELETE CASCADE,
+    member_id TEXT REFERENCES users(user_id) ON DELETE CASCADE,
+    balance_minor BIGINT NOT NULL,
+    currency TEXT NOT NULL DEFAULT 'KES'
+);
+CREATE INDEX IF NOT EXISTS idx_financial_year_balances_year ON financial_year_balances(year_id);
+
+-- Reconciled months locked against backdated postings. An unlocked month
+-- keeps its row, with who unlocked it and why, until it is locked again.
+CREATE TABLE IF NOT EXISTS period_locks (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    period TEXT NOT NULL, -- YYYY-MM
+    status TEXT NOT NULL DEFAULT 'locked', -- locked, unlocked
+    locked_by TEXT NOT NULL REFERENCES users(user_id),
+    locked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    unlocked_by TEXT REFERENCES users(user_id),
+    unlocked_at TIMESTAMP,
+    unlock_reason TEXT,
+    UNIQUE(chama_id, period)
+);
+
+-- Daily exchange rates from the configured provider, cached so that every
+-- conversion on a day uses the same rate
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    base TEXT NOT NULL,
+    quote TEXT NOT NULL,
+    rate_date TEXT NOT NULL, -- YYYY-MM-DD
+    rate BIGINT NOT NULL, -- units of quote per unit of base, scaled by 1,000,000
+    source TEXT NOT NULL,
+    fetched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (base, quote, rate_date)
+);
+
+-- How ledger entries paid in another currency were converted when posted.
+-- Keyed by entry rather than referencing ledger_entries so that it survives
+-- archiving and rebuilds.
+CREATE TABLE IF NOT EXISTS ledger_entry_fx (
+    entry_id TEXT PRIMARY KEY,
+    original_minor BIGINT NOT NULL, -- what the member paid
+    original_currency TEXT NOT NULL,
+    rate BIGINT NOT NULL, -- units of the entry's currency per unit paid, scaled by 1,000,000
+    rate_date TEXT NOT NULL, -- YYYY-MM-DD
+    source TEXT NOT NULL
+);
+
+-- Possible duplicate member records found when members are created, for
+-- platform staff to merge or dismiss
+CREATE TABLE IF NOT EXISTS member_duplicates (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(user_id), -- the newly created record
+    duplicate_of TEXT NOT NULL REFERENCES users(user_id), -- the existing record it resembles
+    score DOUBLE PRECISION NOT NULL, -- 0 to 1
+    reasons TEXT NOT NULL, -- comma-separated: phone, national_id, name
+    status TEXT NOT NULL DEFAULT 'open', -- open, merged, dismissed
+    reviewed_by TEXT REFERENCES users(user_id),
+    reviewed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, duplicate_of)
+);
+CREATE INDEX IF NOT EXISTS idx_member_duplicates_status ON member_duplicates(status);
+
+-- Keys login tokens are signed with. Public keys are published at
+-- /.well-known/jwks.json until expires_at, which is set once a key has been
+-- replaced and every token it signed has expired.
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id TEXT PRIMARY KEY, -- the kid: the key's RFC 7638 thumbprint
+    algorithm TEXT NOT NULL, -- ES256
+    private_key TEXT NOT NULL, -- PKCS#8 PEM, or "v1:" and the PEM encrypted with JWT_KEY_ENCRYPTION_KEY
+    created_by TEXT REFERENCES users(user_id), -- NULL when created by the server
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    activates_at TIMESTAMP NOT NULL, -- when it starts signing
+    expires_at TIMESTAMP
+);
+
+-- Monthly totals of ledger_history by account, member and entry type, kept
+-- up to date as entries are posted so balances over long ranges do not
+-- re-add every entry. Checked against the ledger nightly.
+CREATE TABLE IF NOT EXISTS ledger_balances (
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    account_id TEXT NOT NULL REFERENCES chama_accounts(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL DEFAULT '', -- '' for entries without a member
+    entry_type TEXT NOT NULL,
+    period TEXT NOT NULL, -- YYYY-MM of the entries' effective_at
+    amount_minor BIGINT NOT NULL DEFAULT 0,
+    entries BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (chama_id, account_id, member_id, entry_type, period)
+);
+
+-- Each chama's document vault: constitution, registration certificate, bank
+-- mandates. Uploading again adds a version; expires_at is the current
+-- version's, which officials are reminded about.
+CREATE TABLE IF NOT EXISTS chama_documents (
+    id TEXT PRIMARY KEY,
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    category TEXT NOT NULL, -- constitution, registration_certificate, bank_mandate, agreement, other
+    title TEXT NOT NULL,
+    view_roles TEXT, -- comma-separated member roles that may view it, NULL for every member
+    current_version BIGINT NOT NULL DEFAULT 1,
+    expires_at TIMESTAMP,
+    created_by TEXT NOT NULL REFERENCES users(user_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    archived_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_chama_documents_chama ON chama_documents(chama_id);
+CREATE INDEX IF NOT EXISTS idx_chama_documents_expires ON chama_documents(expires_at);
+
+CREATE TABLE IF NOT EXISTS chama_document_versions (
+    id TEXT PRIMARY KEY,
+    document_id TEXT NOT NULL REFERENCES chama_documents(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    storage_key TEXT NOT NULL,
+    file_name TEXT,
+    mime_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    sha256 TEXT NOT NULL,
+    expires_at TIMESTAMP,
+    notes TEXT,
+    uploaded_by TEXT NOT NULL REFERENCES users(user_id),
+    uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(document_id, version)
+);
+
+-- Milestones celebrated with members, e.g. anniversaries and a 100th
+-- contribution. Rules are edited by platform staff; the trigger is one the
+-- code provides (see milestones.Triggers).
+CREATE TABLE IF NOT EXISTS milestone_rules (
+    key TEXT PRIMARY KEY,
+    description TEXT,
+    trigger_type TEXT NOT NULL, -- chama_anniversary, member_anniversary, birthday, contribution_count, loan_repaid
+    threshold BIGINT NOT NULL DEFAULT 0, -- e.g. the contribution count; meaning depends on the trigger
+    audience TEXT NOT NULL DEFAULT 'member', -- member, chama
+    title TEXT NOT NULL, -- templates with {name}, {chama}, {count}, {years}, {amount}
+    message TEXT NOT NULL,
+    enabled BIGINT NOT NULL DEFAULT 1,
+    updated_by TEXT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Each milestone celebrated, once per rule, chama, member and occurrence
+CREATE TABLE IF NOT EXISTS milestone_events (
+    id TEXT PRIMARY KEY,
+    rule_key TEXT NOT NULL,
+    chama_id TEXT NOT NULL DEFAULT '', -- '' for milestones outside any chama, e.g. birthdays
+    member_id TEXT NOT NULL DEFAULT '', -- '' for the chama's own milestones
+    occurrence TEXT NOT NULL, -- e.g. the anniversary's year, or the repaid loan's ID
+    title TEXT NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(rule_key, chama_id, member_id, occurrence)
+);
+CREATE INDEX IF NOT EXISTS idx_milestone_events_member ON milestone_events(member_id);
+
+-- Monthly chama health indicators and score (see the analytics package).
+-- Rates are basis points of 100%, NULL when the indicator did not apply.
+-- The current month is recomputed nightly; a month is final once computed
+-- after it closed.
+CREATE TABLE IF NOT EXISTS chama_health (
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    period TEXT NOT NULL, -- YYYY-MM
+    expected_minor BIGINT NOT NULL DEFAULT 0,
+    collected_minor BIGINT NOT NULL DEFAULT 0,
+    collection_rate_bps BIGINT,
+    loan_book_minor BIGINT NOT NULL DEFAULT 0,
+    defaulted_minor BIGINT NOT NULL DEFAULT 0,
+    default_ratio_bps BIGINT,
+    members_at_start BIGINT NOT NULL DEFAULT 0,
+    members_joined BIGINT NOT NULL DEFAULT 0,
+    members_left BIGINT NOT NULL DEFAULT 0,
+    churn_bps BIGINT,
+    meetings BIGINT NOT NULL DEFAULT 0,
+    attendance_rate_bps BIGINT,
+    score BIGINT, -- 0-100, NULL when no indicator applied
+    final BOOLEAN NOT NULL DEFAULT FALSE,
+    computed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chama_id, period)
+);
+CREATE INDEX IF NOT EXISTS idx_chama_health_period ON chama_health(period);
+
+-- Plan each chama is on, for its quota (see the quota package); chamas
+-- without a row are on the default plan
+CREATE TABLE IF NOT EXISTS chama_plans (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    plan TEXT NOT NULL, -- free, standard, premium
+    set_by TEXT, -- platform staff member who moved the chama
+    set_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Limits of a chama's plan overridden by platform staff
+CREATE TABLE IF NOT EXISTS quota_overrides (
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    limit_name TEXT NOT NULL, -- members, sms_per_month, storage_bytes
+    max_value BIGINT NOT NULL, -- -1 for unlimited
+    reason TEXT,
+    expires_at TIMESTAMP, -- the plan's limit applies again from then; NULL for good
+    set_by TEXT,
+    set_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chama_id, limit_name)
+);
+
+-- Public holidays gazetted by platform staff on top of the statutory ones
+-- the calendar package knows, or with observed FALSE the cancellation of a
+-- statutory holiday
+CREATE TABLE IF NOT EXISTS public_holidays (
+    day TEXT PRIMARY KEY, -- YYYY-MM-DD
+    name TEXT NOT NULL,
+    observed BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Whether each chama can pay on Saturdays; chamas without a row cannot
+CREATE TABLE IF NOT EXISTS chama_calendars (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    open_saturdays BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_by TEXT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Days a chama opens or closes against the public calendar; due dates are
+-- moved off the days it is closed
+CREATE TABLE IF NOT EXISTS chama_calendar_days (
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    day TEXT NOT NULL, -- YYYY-MM-DD
+    open BOOLEAN NOT NULL,
+    note TEXT,
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chama_id, day)
+);
+
+-- Accounts members have deleted themselves. The account is deactivated
+-- until erase_after, when it is erased unless the member recovered it
+-- first, which removes the row.
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id TEXT PRIMARY KEY REFERENCES users(user_id),
+    reason TEXT,
+    requested_at TIMESTAMP NOT NULL,
+    erase_after TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_account_deletions_erase_after ON account_deletions(erase_after);
+
+-- Chamas set up through the onboarding wizard, live once every step is
+-- done. Chamas without a row predate the wizard and are live.
+CREATE TABLE IF NOT EXISTS chama_onboarding (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    started_by TEXT NOT NULL REFERENCES users(user_id),
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    live_at TIMESTAMP,
+    live_by TEXT REFERENCES users(user_id)
+);
+
+-- Onboarding steps each chama has completed: create_chama, set_rules,
+-- invite_members, connect_paybill
+CREATE TABLE IF NOT EXISTS chama_onboarding_steps (
+    chama_id TEXT NOT NULL REFERENCES chamas(id) ON DELETE CASCADE,
+    step TEXT NOT NULL,
+    completed_by TEXT REFERENCES users(user_id),
+    completed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chama_id, step)
+);
+
+-- The M-Pesa paybill or till each chama's members pay into
+CREATE TABLE IF NOT EXISTS chama_paybills (
+    chama_id TEXT PRIMARY KEY REFERENCES chamas(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL, -- paybill, till
+    number TEXT NOT NULL,
+    account_reference TEXT, -- paybills only
+    connected_by TEXT REFERENCES users(user_id),
+    connected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
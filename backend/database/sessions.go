@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"tujifund-app/backend/readonly"
+
+	"github.com/google/uuid"
+)
+
+// SessionTimeout is how long a session may sit idle before it expires
+const SessionTimeout = 30 * time.Minute
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// bookkeeping is the context sessions are kept with, which may write in
+// read-only maintenance mode so that members can still sign in and read
+var bookkeeping = readonly.Allow(context.Background())
+
+// Session is one signed-in device. Every request looks its session up, so
+// deleting the row signs the device out immediately.
+type Session struct {
+	ID           string    `json:"id"`
+	DeviceName   string    `json:"deviceName"`
+	IPAddress    string    `json:"ipAddress"`
+	UserAgent    string    `json:"userAgent"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastActivity time.Time `json:"lastActivity"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	Current      bool      `json:"current"`
+}
+
+// CreateSession inserts a new session into the database and returns its ID
+func CreateSession(db *sql.DB, userID, token, ip, userAgent, deviceName string, duration time.Duration) (string, error) {
+	sessionID := uuid.NewString()
+	now := time.Now().UTC()
+	_, err := db.ExecContext(bookkeeping, `
+		INSERT INTO sessions (id, user_id, token, ip_address, user_agent, device_name, expires_at, created_at, last_activity)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sessionID, userID, token, ip, userAgent, deviceName,
+		now.Add(duration).Format(timeLayout), now.Format(timeLayout), now.Format(timeLayout),
+	)
+	return sessionID, err
+}
+
+// IsValidSession checks if a session is still valid and returns its user
+func IsValidSession(db *sql.DB, sessionID string) (bool, string, error) {
+	var userID string
+	var lastActivity, expiresAt time.Time
+
+	err := db.QueryRow(`
+		SELECT user_id, last_activity, expires_at FROM sessions WHERE id = ?`, sessionID,
+	).Scan(&userID, &lastActivity, &expiresAt)
+	if err == sql.ErrNoRows {
+		return false, "", nil
+	} else if err != nil {
+		return false, "", err
+	}
+
+	// Check if session has expired due to inactivity or timeout
+	if time.Since(lastActivity) > SessionTimeout || time.Now().After(expiresAt) {
+		return false, "", DeleteSession(db, sessionID)
+	}
+	return true, userID, nil
+}
+
+// UpdateSessionActivity updates the last activity timestamp
+func UpdateSessionActivity(db *sql.DB, sessionID string) error {
+	_, err := db.ExecContext(bookkeeping, `
+		UPDATE sessions SET last_activity = ? WHERE id = ?`,
+		time.Now().UTC().Format(timeLayout), sessionID)
+	return err
+}
+
+// DeleteSession removes a session
+func DeleteSession(db *sql.DB, sessionID string) error {
+	_, err := db.ExecContext(bookkeeping, `DELETE FROM sessions WHERE id = ?`, sessionID)
+	return err
+}
+
+// ListSessions returns the user's live sessions, most recently used first.
+// The session with ID current is flagged.
+func ListSessions(ctx context.Context, db *sql.DB, userID, current string) ([]Session, error) {
+	cutoff := time.Now().UTC()
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, COALESCE(device_name, ''), COALESCE(ip_address, ''), COALESCE(user_agent, ''),
+		       created_at, last_activity, expires_at
+		FROM sessions
+		WHERE user_id = ? AND expires_at > ? AND last_activity > ?
+		ORDER BY last_activity DESC`,
+		userID, cutoff.Format(timeLayout), cutoff.Add(-SessionTimeout).Format(timeLayout),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.DeviceName, &s.IPAddress, &s.UserAgent, &s.CreatedAt, &s.LastActivity, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		s.Current = s.ID == current
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession signs out one of the user's sessions. It returns
+// sql.ErrNoRows if the user has no such session.
+func RevokeSession(ctx context.Context, db *sql.DB, userID, sessionID string) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ? AND user_id = ?`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeOtherSessions signs out all of the user's sessions except keep and
+// returns how many were ended
+func RevokeOtherSessions(ctx context.Context, db *sql.DB, userID, keep string) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ? AND id <> ?`, userID, keep)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CleanupExpiredSessions deletes sessions that have expired or gone idle
+func CleanupExpiredSessions(ctx context.Context, db *sql.DB) error {
+	now := time.Now().UTC()
+	_, err := db.ExecContext(ctx, `
+		DELETE FROM sessions WHERE last_activity < ? OR expires_at < ?`,
+		now.Add(-SessionTimeout).Format(timeLayout), now.Format(timeLayout))
+	return err
+}
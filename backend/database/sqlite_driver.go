@@ -1,43 +1,49 @@
 package database
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"tujifund-app/backend/readonly"
+
 	_ "modernc.org/sqlite"
 )
 
 // SQLiteDriver implements the DBDriver interface for SQLite
 type SQLiteDriver struct {
-	db *sql.DB
+	BaseDriver
 	conf DBConfig
 }
 
-// Connect establishes a connection to the SQLite database
+var _ DBDriver = &SQLiteDriver{}
+
+// Connect establishes a connection to the SQLite database at
+// conf.SQLitePath. Each connection runs in WAL mode, so reads carry on
+// beside a write, and waits for the write lock rather than failing as busy;
+// transactions take the lock when they begin, as they are used to write.
+// Foreign keys are not enforced: the schema's reference users(id) while
+// the app keys users by user_id.
 func (d *SQLiteDriver) Connect(conf DBConfig) error {
 	if err := os.MkdirAll(filepath.Dir(conf.SQLitePath), 0755); err != nil {
 		return fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	dsn := fmt.Sprintf("file:%s?cache=shared&_journal_mode=WAL", conf.SQLitePath)
-	db, err := sql.Open("sqlite", dsn)
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate", conf.SQLitePath)
+	db, err := open(conf, "sqlite", dsn)
 	if err != nil {
+		return fmt.Errorf("failed to connect to the SQLite database: %w", err)
 	}
 
 	// Test the connection
 	if err = db.Ping(); err != nil {
+		db.Close()
 		return fmt.Errorf("failed to ping the SQLite database: %w", err)
 	}
 
-	// Enable foreign key Support 
-	if _, err := db.Exec("PRAGMA foreign_keys = ON:"); err != nil {
-		return fmt.Errorf("failed to enable foreign keys: %w", err)
-	}
-
-	// SEt connection pool settings
+	// Set connection pool settings
 	db.SetMaxOpenConns(conf.MaxOpenConns)
 	db.SetMaxIdleConns(conf.MaxIdleConns)
 
@@ -49,28 +55,29 @@ func (d *SQLiteDriver) Connect(conf DBConfig) error {
 // InitializeSchema creates tables and initializes the database
 func (d *SQLiteDriver) InitializeSchema() error {
 	// Read the schema file
-	path  := filepath.Join("database", "database_schema.sql")
-	path, err := os.ReadFile(path)
+	path := filepath.Join("database", "database_schema.sql")
+	schema, err := os.ReadFile(path)
 	if err != nil {
-		return	fmt.Errorf("failed to read sche,a file: %w", err)
+		return fmt.Errorf("failed to read schema file: %w", err)
 	}
 
-	// Execute the schema
-	if _, err := d.db.Exec(string(schema)); err != nil {
+	// Execute the schema, which is kept up to date in read-only mode too
+	if _, err := d.db.ExecContext(readonly.Allow(context.Background()), string(schema)); err != nil {
 		// Ignore "already exists" errors
 		if !strings.Contains(err.Error(), "already exists") {
-			return fmt.Errorf("failed to excute schema: %w", err)
-		} 
+			return fmt.Errorf("failed to execute schema: %w", err)
+		}
 	}
 	return nil
 }
 
-// GetDialet returns the SQL dialect name
-func (d *SQLiteDriver) GetDialet() string {
+// GetDialect returns the SQL dialect name
+func (d *SQLiteDriver) GetDialect() string {
 	return "sqlite"
 }
 
-// TransformQuery converts a generic SQL query to SQLite syntax
-func (s *SQLiteDriver) TransformQuery(query string) string {
-	return quer
-}
\ No newline at end of file
+// TransformQuery converts a generic SQL query to SQLite syntax, which it
+// already is
+func (d *SQLiteDriver) TransformQuery(query string) string {
+	return query
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteDriverConnect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "tujifund.db")
+	dbi, err := NewDBInstance(DBConfig{Driver: "sqlite", SQLitePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbi.Close()
+	db := dbi.GetDB()
+
+	tests := []struct {
+		pragma, want string
+	}{
+		{"journal_mode", "wal"},
+		{"busy_timeout", "5000"},
+		{"foreign_keys", "0"},
+	}
+	for _, tt := range tests {
+		var got string
+		if err := db.QueryRow("PRAGMA " + tt.pragma).Scan(&got); err != nil {
+			t.Fatalf("PRAGMA %s: %v", tt.pragma, err)
+		}
+		if got != tt.want {
+			t.Errorf("PRAGMA %s = %s, want %s", tt.pragma, got, tt.want)
+		}
+	}
+}
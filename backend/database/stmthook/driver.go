@@ -0,0 +1,218 @@
+package stmthook
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+// connector opens hooked connections to the wrapped driver
+type connector struct {
+	driver driver.Driver
+	dsn    string
+	hooks  []Hook
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	var (
+		conn driver.Conn
+		err  error
+	)
+	if dc, ok := c.driver.(driver.DriverContext); ok {
+		var inner driver.Connector
+		if inner, err = dc.OpenConnector(c.dsn); err != nil {
+			return nil, err
+		}
+		conn, err = inner.Connect(ctx)
+	} else {
+		conn, err = c.driver.Open(c.dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &hookedConn{Conn: conn, hooks: c.hooks}, nil
+}
+
+func (c *connector) Driver() driver.Driver { return c.driver }
+
+// hookedConn runs the hooks around the statements run on a connection.
+// Optional interfaces the wrapped connection lacks answer as database/sql
+// expects of a driver that leaves them out.
+type hookedConn struct {
+	driver.Conn
+	hooks []Hook
+}
+
+func (c *hookedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *hookedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &hookedStmt{Stmt: stmt, query: query, hooks: c.hooks}, nil
+}
+
+func (c *hookedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *hookedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		// database/sql prepares the statement instead, and hookedStmt hooks it
+		return nil, driver.ErrSkip
+	}
+	start, err := before(ctx, c.hooks, query)
+	if err != nil {
+		return nil, err
+	}
+	result, err := e.ExecContext(ctx, query, args)
+	after(ctx, c.hooks, query, start, err)
+	return result, err
+}
+
+func (c *hookedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start, err := before(ctx, c.hooks, query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, query, args)
+	after(ctx, c.hooks, query, start, err)
+	return rows, err
+}
+
+func (c *hookedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *hookedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *hookedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *hookedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// hookedStmt runs the hooks around each run of a prepared statement
+type hookedStmt struct {
+	driver.Stmt
+	query string
+	hooks []Hook
+}
+
+func (s *hookedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start, err := before(ctx, s.hooks, s.query)
+	if err != nil {
+		return nil, err
+	}
+	var result driver.Result
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = e.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = plain(args); err == nil {
+			result, err = s.Stmt.Exec(values)
+		}
+	}
+	after(ctx, s.hooks, s.query, start, err)
+	return result, err
+}
+
+func (s *hookedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start, err := before(ctx, s.hooks, s.query)
+	if err != nil {
+		return nil, err
+	}
+	var rows driver.Rows
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = plain(args); err == nil {
+			rows, err = s.Stmt.Query(values)
+		}
+	}
+	after(ctx, s.hooks, s.query, start, err)
+	return rows, err
+}
+
+func (s *hookedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// before runs the Before hooks in order, stopping at the first to refuse
+// the statement
+func before(ctx context.Context, hooks []Hook, query string) (time.Time, error) {
+	for _, h := range hooks {
+		if h.Before == nil {
+			continue
+		}
+		if err := h.Before(ctx, query); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Now(), nil
+}
+
+// after runs the After hooks, unless the wrapped driver declined the
+// statement with driver.ErrSkip and database/sql will run it another way
+func after(ctx context.Context, hooks []Hook, query string, start time.Time, err error) {
+	if errors.Is(err, driver.ErrSkip) {
+		return
+	}
+	for _, h := range hooks {
+		if h.After != nil {
+			h.After(ctx, query, start, err)
+		}
+	}
+}
+
+// plain converts arguments for drivers that predate named values
+func plain(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, errors.New("stmthook: driver does not support named parameters")
+		}
+		values[i] = a.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,34 @@
+// Package stmthook wraps a database/sql driver so that hooks run around
+// every statement sent through it, whether run directly, prepared or in a
+// transaction. The app opens its databases through it, so a hook sees each
+// statement with the context it was run with: the request's, carrying its
+// request ID, trace and read-only maintenance mode exemption.
+package stmthook
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Hook is run around each statement. Either function may be nil.
+type Hook struct {
+	// Before runs ahead of the statement; an error refuses it, and is
+	// returned in place of the statement's result
+	Before func(ctx context.Context, query string) error
+	// After runs once the statement has run, with when it started and the
+	// error it failed with
+	After func(ctx context.Context, query string, start time.Time, err error)
+}
+
+// Open opens a database like sql.Open, with hooks run around its statements
+func Open(driverName, dsn string, hooks ...Hook) (*sql.DB, error) {
+	// sql.Open does not connect; it is only used to find the driver
+	probe, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	d := probe.Driver()
+	probe.Close()
+	return sql.OpenDB(&connector{driver: d, dsn: dsn, hooks: hooks}), nil
+}
@@ -0,0 +1,201 @@
+// Package tenantdriver is the PostgreSQL driver the app runs on: lib/pq
+// wrapped so that every statement runs scoped to the chama in its context,
+// which row-level security checks, with the app's ? placeholders rewritten
+// for PostgreSQL. Importing it registers the driver as DriverName.
+package tenantdriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"tujifund-app/backend/tenancy"
+
+	"github.com/lib/pq"
+)
+
+// DriverName is the name the driver is registered under
+const DriverName = "postgres+tenancy"
+
+const (
+	// TenantSetting is the PostgreSQL setting holding the chama a session is
+	// scoped to, set from tenancy.ChamaID before each statement
+	TenantSetting = "app.chama_id"
+	// AllChamasSetting is "on" for sessions scoped to every chama, set from
+	// tenancy.AllChamas for background jobs and cross-chama views
+	AllChamasSetting = "app.all_chamas"
+)
+
+func init() {
+	sql.Register(DriverName, tenantDriver{})
+}
+
+type tenantDriver struct{}
+
+func (tenantDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := pq.Driver{}.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &tenantConn{Conn: conn}, nil
+}
+
+// tenantConn sets TenantSetting and AllChamasSetting on its session before
+// each statement whose context differs from the last one's. The settings
+// are for the session rather than a transaction, as most statements run
+// outside one; a rolled back transaction may have undone them, so they are
+// set again after it. It relies on lib/pq's connections implementing the
+// context interfaces, which they do.
+type tenantConn struct {
+	driver.Conn
+	scoped bool
+	chama  string
+	all    string
+}
+
+func (c *tenantConn) scope(ctx context.Context) error {
+	chama, all := tenancy.ChamaID(ctx), ""
+	if tenancy.AllChamas(ctx) {
+		all = "on"
+	}
+	if c.scoped && chama == c.chama && all == c.all {
+		return nil
+	}
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return fmt.Errorf("%s: driver cannot set the session's chama", DriverName)
+	}
+	rows, err := q.QueryContext(ctx, `SELECT set_config('`+TenantSetting+`', $1, false), set_config('`+AllChamasSetting+`', $2, false)`,
+		[]driver.NamedValue{{Ordinal: 1, Value: chama}, {Ordinal: 2, Value: all}})
+	if err != nil {
+		c.scoped = false
+		return fmt.Errorf("failed to set the session's chama: %w", err)
+	}
+	rows.Close()
+	c.scoped, c.chama, c.all = true, chama, all
+	return nil
+}
+
+func (c *tenantConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext scopes the session to ctx, which database/sql also runs
+// the prepared statement with when it prepares on the caller's behalf
+func (c *tenantConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.scope(ctx); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, Rebind(query))
+}
+
+func (c *tenantConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.scope(ctx); err != nil {
+		return nil, err
+	}
+	tx, err := c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tenantTx{Tx: tx, conn: c}, nil
+}
+
+func (c *tenantConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.scope(ctx); err != nil {
+		return nil, err
+	}
+	return e.ExecContext(ctx, Rebind(query), args)
+}
+
+func (c *tenantConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.scope(ctx); err != nil {
+		return nil, err
+	}
+	return q.QueryContext(ctx, Rebind(query), args)
+}
+
+func (c *tenantConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tenantConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tenantConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *tenantConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// tenantTx forgets its connection's settings when it rolls back, as the
+// rollback undoes any set during the transaction
+type tenantTx struct {
+	driver.Tx
+	conn *tenantConn
+}
+
+func (t *tenantTx) Rollback() error {
+	t.conn.scoped = false
+	return t.Tx.Rollback()
+}
+
+// Rebind rewrites ? placeholders as PostgreSQL's $1, $2, ..., leaving
+// question marks in string literals, quoted identifiers and comments alone
+func Rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		switch ch := query[i]; {
+		case ch == '\'' || ch == '"':
+			end := strings.IndexByte(query[i+1:], ch)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case ch == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end])
+			i += end - 1
+		case ch == '?':
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// addedColumns are columns added to tables after they were first created.
+// CREATE TABLE IF NOT EXISTS leaves an existing table as it is, so a
+// database created before a column was added gets it from Upgrade, along
+// with backfill to fill it in for the rows already there.
+var addedColumns = []struct {
+	table, column, definition string
+	backfill                  string
+}{
+	{"users", "national_id", "TEXT", ""},
+	{"users", "date_of_birth", "DATE", ""},
+	{"users", "suspended_at", "TIMESTAMP", ""},
+	{"users", "suspension_reason", "TEXT", ""},
+	{"users", "erased_at", "TIMESTAMP", ""},
+	{"users", "merged_into", "TEXT", ""},
+	// Entries posted before seq was added keep their insertion order
+	{"ledger_entries", "seq", "INTEGER", `UPDATE ledger_entries SET seq = rowid`},
+}
+
+// Upgrade adds the addedColumns an existing SQLite database lacks, each
+// with its backfill in one transaction. Tables that do not exist yet are
+// left to the schema. It is safe to run again.
+func Upgrade(ctx context.Context, db *sql.DB) error {
+	for _, c := range addedColumns {
+		var columns, found int
+		err := db.QueryRowContext(ctx, `
+			SELECT COUNT(*), COALESCE(SUM(name = ?), 0) FROM pragma_table_info(?)`, c.column, c.table).
+			Scan(&columns, &found)
+		if err != nil {
+			return fmt.Errorf("failed to read the columns of %s: %w", c.table, err)
+		}
+		if columns == 0 || found > 0 {
+			continue
+		}
+		if err := addColumn(ctx, db, c.table, c.column, c.definition, c.backfill); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	return nil
+}
+
+func addColumn(ctx context.Context, db *sql.DB, table, column, definition, backfill string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+		return err
+	}
+	if backfill != "" {
+		if _, err := tx.ExecContext(ctx, backfill); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
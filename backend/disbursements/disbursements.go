@@ -0,0 +1,605 @@
+package disbursements
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/approvals"
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/loans"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Disbursement statuses
+const (
+	StatusAwaitingApproval = "awaiting_approval" // held for the approvals its amount's tier requires
+	StatusPending          = "pending"
+	StatusCompleted        = "completed"
+	StatusFailed           = "failed"
+	StatusRejected         = "rejected"
+)
+
+// What a disbursement pays for
+const (
+	PurposeLoan   = "loan"   // the principal of an approved loan
+	PurposePayout = "payout" // any other payout to a member, e.g. their merry-go-round turn
+)
+
+var (
+	// ErrLoanNotPending is returned when disbursing a loan that is not awaiting disbursement
+	ErrLoanNotPending = errors.New("loan is not awaiting disbursement")
+	// ErrInProgress is returned when a loan already has a disbursement pending or completed
+	ErrInProgress = errors.New("loan already has a disbursement in progress")
+	// ErrSelfPayout is returned when an official sends money to themselves
+	ErrSelfPayout = errors.New("another official must send money to you")
+	// ErrNoPhone is returned when the member has no phone number to pay to
+	ErrNoPhone = errors.New("member has no phone number")
+	// ErrNotAwaiting is returned when approving or rejecting a disbursement that is not awaiting approval
+	ErrNotAwaiting = errors.New("disbursement is not awaiting approval")
+)
+
+// Disbursement is money sent from a chama fund to a member's phone. The fund
+// is debited when the payout is sent and the debit reversed if it fails. A
+// payout in one of the chama's approval tiers is held, without touching the
+// fund, until it has the approvals the tier requires.
+type Disbursement struct {
+	ID                string      `json:"id"`
+	ChamaID           string      `json:"chamaId"`
+	MemberID          string      `json:"memberId"`
+	AccountID         string      `json:"accountId"`
+	Purpose           string      `json:"purpose"`
+	LoanID            string      `json:"loanId,omitempty"`
+	Amount            money.Money `json:"amount"`
+	Phone             string      `json:"phone"`
+	Provider          string      `json:"provider"`
+	ProviderReference string      `json:"providerReference,omitempty"`
+	Receipt           string      `json:"receipt,omitempty"`
+	Status            string      `json:"status"`
+	Error             string      `json:"error,omitempty"`
+	Remarks           string      `json:"remarks,omitempty"`
+	RequestedBy       string      `json:"requestedBy"`
+	CreatedAt         time.Time   `json:"createdAt"`
+	CompletedAt       string      `json:"completedAt,omitempty"`
+}
+
+const columns = `id, chama_id, member_id, account_id, purpose, COALESCE(loan_id, ''), amount_minor, currency, phone_number,
+	provider, COALESCE(provider_reference, ''), COALESCE(receipt, ''), status, COALESCE(error, ''), COALESCE(remarks, ''),
+	requested_by, created_at, COALESCE(completed_at, '')`
+
+func scan(row interface{ Scan(...interface{}) error }) (Disbursement, error) {
+	var d Disbursement
+	err := row.Scan(&d.ID, &d.ChamaID, &d.MemberID, &d.AccountID, &d.Purpose, &d.LoanID, &d.Amount.Amount,
+		&d.Amount.Currency, &d.Phone, &d.Provider, &d.ProviderReference, &d.Receipt, &d.Status, &d.Error, &d.Remarks,
+		&d.RequestedBy, &d.CreatedAt, &d.CompletedAt)
+	return d, err
+}
+
+// Get returns a single disbursement
+func Get(ctx context.Context, db *sql.DB, id string) (Disbursement, error) {
+	return scan(txn.From(ctx, db).QueryRowContext(ctx, `SELECT `+columns+` FROM disbursements WHERE id = ?`, id))
+}
+
+// List returns a chama's disbursements, newest first. status is an optional filter.
+func List(ctx context.Context, db *sql.DB, chamaID, status string) ([]Disbursement, error) {
+	query, args := `SELECT `+columns+` FROM disbursements WHERE chama_id = ?`, []interface{}{chamaID}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	rows, err := txn.From(ctx, db).QueryContext(ctx, query+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Disbursement{}
+	for rows.Next() {
+		d, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// Loan sends the principal of a pending loan to the borrower's phone, from
+// accountID or the chama's loans fund. The loan becomes active once the
+// provider reports the money delivered.
+func Loan(ctx context.Context, db *sql.DB, provider payments.Disburser, loanID, phone, accountID string, entry audit.Entry) (Disbursement, error) {
+	l, err := loans.Get(ctx, txn.From(ctx, db), loanID)
+	if err != nil {
+		return Disbursement{}, err
+	}
+	if l.Status != loans.StatusPending || l.ChamaID == "" {
+		return Disbursement{}, ErrLoanNotPending
+	}
+	if accountID == "" {
+		if accountID, err = loans.Fund(ctx, txn.From(ctx, db), l); err != nil {
+			return Disbursement{}, err
+		}
+	}
+	return send(ctx, db, provider, Disbursement{
+		ChamaID: l.ChamaID, MemberID: l.BorrowerID, AccountID: accountID, Purpose: PurposeLoan, LoanID: l.ID,
+		Amount: l.Principal, Phone: phone, Remarks: "Loan disbursement",
+	}, entry)
+}
+
+// Payout sends d.Amount from d.AccountID to d.MemberID's phone
+func Payout(ctx context.Context, db *sql.DB, provider payments.Disburser, d Disbursement, entry audit.Entry) (Disbursement, error) {
+	d.Purpose, d.LoanID = PurposePayout, ""
+	if d.Remarks == "" {
+		d.Remarks = "Chama payout"
+	}
+	return send(ctx, db, provider, d, entry)
+}
+
+// entryType is the ledger entry type d is posted as
+func (d Disbursement) entryType() string {
+	if d.Purpose == PurposeLoan {
+		return ledger.TypeLoanDisbursement
+	}
+	return ledger.TypeDistribution
+}
+
+// reference is the ledger reference of d. Loan disbursements are referenced
+// by the loan, which is how loans.Fund finds the fund a loan came from.
+func (d Disbursement) reference() string {
+	if d.LoanID != "" {
+		return d.LoanID
+	}
+	return d.ID
+}
+
+// send debits the fund, records d and asks provider to pay it out. If the
+// provider turns the payout down the debit is reversed straight away. When
+// d's amount falls in one of the chama's approval tiers, the sender counts
+// as its first approval and d is held awaiting the rest.
+func send(ctx context.Context, db *sql.DB, provider payments.Disburser, d Disbursement, entry audit.Entry) (Disbursement, error) {
+	if provider == nil {
+		return d, payments.ErrNoProvider
+	}
+	if d.MemberID == entry.UserID {
+		return d, ErrSelfPayout
+	}
+	if d.Amount.Amount <= 0 {
+		return d, errors.New("payout amount must be positive")
+	}
+	if d.Phone == "" {
+		txn.From(ctx, db).QueryRowContext(ctx, `SELECT COALESCE(phone_number, '') FROM users WHERE user_id = ?`, d.MemberID).Scan(&d.Phone)
+		if d.Phone == "" {
+			return d, ErrNoPhone
+		}
+	}
+	d.ID, d.Provider, d.Status, d.RequestedBy = uuid.NewString(), provider.Name(), StatusPending, entry.UserID
+	tier, tiered, err := approvals.Tier(ctx, db, d.ChamaID, d.Amount)
+	if err != nil {
+		return d, err
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return d, err
+	}
+	defer txn.Rollback(tx)
+
+	if d.LoanID != "" {
+		var open bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM disbursements WHERE loan_id = ? AND status NOT IN (?, ?))`,
+			d.LoanID, StatusFailed, StatusRejected).Scan(&open)
+		if err != nil {
+			return d, err
+		}
+		if open {
+			return d, ErrInProgress
+		}
+	}
+	if tiered {
+		req, err := approvals.Open(ctx, tx, approvals.Request{
+			ChamaID: d.ChamaID, SubjectType: approvals.SubjectDisbursement, SubjectID: d.ID, Amount: d.Amount,
+			RequestedBy: d.RequestedBy,
+		}, tier, d.RequestedBy)
+		if err != nil {
+			return d, err
+		}
+		if req.Status != approvals.StatusApproved {
+			d.Status = StatusAwaitingApproval
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO disbursements
+		(id, chama_id, member_id, account_id, purpose, loan_id, amount_minor, currency, phone_number, provider, status,
+		 remarks, requested_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.ID, d.ChamaID, d.MemberID, d.AccountID, d.Purpose, nullIfEmpty(d.LoanID), d.Amount.Amount, d.Amount.Currency,
+		d.Phone, d.Provider, d.Status, nullIfEmpty(d.Remarks), d.RequestedBy)
+	if err != nil {
+		return d, fmt.Errorf("failed to record disbursement: %w", err)
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "disbursement.send", "disbursement", d.ID
+	if d.Status == StatusAwaitingApproval {
+		entry.Action = "disbursement.request"
+	} else if err := debit(ctx, tx, d); err != nil {
+		return d, err
+	}
+	entry.NewValues = map[string]interface{}{"memberId": d.MemberID, "purpose": d.Purpose, "loanId": d.LoanID,
+		"amount": d.Amount, "provider": d.Provider}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return d, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return d, err
+	}
+	if d.Status == StatusAwaitingApproval {
+		return Get(ctx, db, d.ID)
+	}
+	return payout(ctx, db, provider, d)
+}
+
+// debit takes d out of its fund
+func debit(ctx context.Context, tx *sql.Tx, d Disbursement) error {
+	// Real money leaves the fund, so it must hold the payout even when its
+	// rules would let the book balance go negative
+	var balance int64
+	err := tx.QueryRowContext(ctx, `SELECT balance_minor FROM chama_accounts WHERE id = ? AND chama_id = ?`,
+		d.AccountID, d.ChamaID).Scan(&balance)
+	if err != nil {
+		return fmt.Errorf("failed to load account %s: %w", d.AccountID, err)
+	}
+	if balance < d.Amount.Amount {
+		return ledger.ErrInsufficientFunds
+	}
+	_, err = ledger.Post(ctx, tx, ledger.Entry{
+		ChamaID:     d.ChamaID,
+		AccountID:   d.AccountID,
+		MemberID:    d.MemberID,
+		Type:        d.entryType(),
+		Amount:      d.Amount.Negate(),
+		Reference:   d.reference(),
+		Description: d.Remarks + " via " + d.Provider,
+		CreatedBy:   d.RequestedBy,
+	})
+	return err
+}
+
+// payout asks provider to pay out a pending disbursement whose fund has been
+// debited, reversing the debit if the provider turns it down
+func payout(ctx context.Context, db *sql.DB, provider payments.Disburser, d Disbursement) (Disbursement, error) {
+	ref, err := provider.Disburse(ctx, payments.PayoutRequest{
+		Reference: d.ID, Phone: d.Phone, Amount: d.Amount, Remarks: d.Remarks,
+	})
+	if err != nil {
+		if ferr := fail(ctx, db, d, err.Error()); ferr != nil {
+			slog.ErrorContext(ctx, "Failed to reverse disbursement", "disbursement_id", d.ID, "error", ferr)
+		}
+		return d, err
+	}
+	_, err = txn.From(ctx, db).ExecContext(ctx, `UPDATE disbursements SET provider_reference = ? WHERE id = ?`, ref, d.ID)
+	if err != nil {
+		return d, err
+	}
+	return Get(ctx, db, d.ID)
+}
+
+// Requested asks the officials who can approve a disbursement awaiting
+// approval to review it, or puts it to the members' vote when its tier
+// requires one
+func Requested(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, d Disbursement, entry audit.Entry) {
+	if d.Status != StatusAwaitingApproval {
+		return
+	}
+	req, err := approvals.ForSubject(ctx, db, approvals.SubjectDisbursement, d.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load disbursement approval", "disbursement_id", d.ID, "error", err)
+		return
+	}
+	if !req.Vote {
+		approvals.Notify(ctx, db, notifier, req)
+		return
+	}
+	var member string
+	txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT TRIM(COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')) FROM users WHERE user_id = ?`,
+		d.MemberID).Scan(&member)
+	title := d.Remarks + ": " + d.Amount.String() + " to " + member
+	if _, err := approvals.StartVote(ctx, db, notifier, req, title, entry); err != nil {
+		slog.ErrorContext(ctx, "Failed to open disbursement vote", "disbursement_id", d.ID, "error", err)
+	}
+}
+
+// Approve adds the entry's user's approval to a disbursement awaiting
+// approval, and sends it once it has all the approvals its tier requires
+func Approve(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Disbursement, error) {
+	d, err := Get(ctx, db, id)
+	if err != nil {
+		return d, err
+	}
+	if d.Status != StatusAwaitingApproval {
+		return d, ErrNotAwaiting
+	}
+	if d.MemberID == entry.UserID {
+		return d, ErrSelfPayout
+	}
+	req, err := approvals.ForSubject(ctx, db, approvals.SubjectDisbursement, id)
+	if err != nil {
+		return d, err
+	}
+	if req, err = approvals.Approve(ctx, db, req.ID, entry); err != nil {
+		return d, err
+	}
+	if req.Status != approvals.StatusApproved {
+		return d, nil
+	}
+	return release(ctx, db, d, entry)
+}
+
+// release sends an approved disbursement: the fund is debited and the
+// payout handed to the provider it was requested through
+func release(ctx context.Context, db *sql.DB, d Disbursement, entry audit.Entry) (Disbursement, error) {
+	provider, err := payments.LookupDisburser(d.Provider)
+	if err != nil {
+		return d, err
+	}
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return d, err
+	}
+	defer txn.Rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `UPDATE disbursements SET status = ? WHERE id = ? AND status = ?`,
+		StatusPending, d.ID, StatusAwaitingApproval)
+	if err != nil {
+		return d, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return d, ErrNotAwaiting
+	}
+	d.Status = StatusPending
+	if err := debit(ctx, tx, d); err != nil {
+		return d, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "disbursement.send", "disbursement", d.ID
+	entry.NewValues = map[string]interface{}{"memberId": d.MemberID, "purpose": d.Purpose, "loanId": d.LoanID,
+		"amount": d.Amount, "provider": d.Provider}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return d, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return d, err
+	}
+	return payout(ctx, db, provider, d)
+}
+
+// Reject turns down a disbursement awaiting approval; nothing leaves the fund
+func Reject(ctx context.Context, db *sql.DB, id, reason string, entry audit.Entry) (Disbursement, error) {
+	d, err := Get(ctx, db, id)
+	if err != nil {
+		return d, err
+	}
+	if d.Status != StatusAwaitingApproval {
+		return d, ErrNotAwaiting
+	}
+	req, err := approvals.ForSubject(ctx, db, approvals.SubjectDisbursement, id)
+	if err != nil {
+		return d, err
+	}
+	if _, err := approvals.Reject(ctx, db, req.ID, reason, entry); err != nil {
+		return d, err
+	}
+	return reject(ctx, db, d, reason)
+}
+
+// reject marks a disbursement awaiting approval rejected
+func reject(ctx context.Context, db *sql.DB, d Disbursement, reason string) (Disbursement, error) {
+	res, err := txn.From(ctx, db).ExecContext(ctx, `
+		UPDATE disbursements SET status = ?, error = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		StatusRejected, reason, d.ID, StatusAwaitingApproval)
+	if err != nil {
+		return d, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return d, ErrNotAwaiting
+	}
+	return Get(ctx, db, d.ID)
+}
+
+// RegisterApprovalHook sends or rejects disbursements once the members' vote
+// on them closes. The requester is told of a rejection; the member is told
+// of the money arriving as usual.
+func RegisterApprovalHook(notifier *notifications.Notifier) {
+	approvals.Hooks[approvals.SubjectDisbursement] = func(ctx context.Context, db *sql.DB, r approvals.Request) error {
+		d, err := Get(ctx, db, r.SubjectID)
+		if err != nil {
+			return err
+		}
+		if r.Status == approvals.StatusApproved {
+			_, err = release(ctx, db, d, audit.Entry{})
+		} else if d, err = reject(ctx, db, d, approvals.VoteRejection); err == nil {
+			notify(ctx, db, notifier, d, d.RequestedBy, "disbursement.rejected", "notification.disbursement_rejected")
+		}
+		if errors.Is(err, ErrNotAwaiting) {
+			return nil
+		}
+		return err
+	}
+}
+
+// fail marks a pending disbursement failed and credits the fund back
+func fail(ctx context.Context, db *sql.DB, d Disbursement, message string) error {
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE disbursements SET status = ?, error = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		StatusFailed, message, d.ID, StatusPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	_, err = ledger.Post(ctx, tx, ledger.Entry{
+		ChamaID:     d.ChamaID,
+		AccountID:   d.AccountID,
+		MemberID:    d.MemberID,
+		Type:        d.entryType(),
+		Amount:      d.Amount,
+		Reference:   d.reference(),
+		Description: "Reversal: " + d.Remarks + " via " + d.Provider + " failed",
+	})
+	if err != nil {
+		return err
+	}
+	return txn.Commit(tx)
+}
+
+// complete marks a pending disbursement delivered and activates its loan
+func complete(ctx context.Context, db *sql.DB, d Disbursement, receipt string) (bool, error) {
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	defer txn.Rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE disbursements SET status = ?, receipt = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		StatusCompleted, nullIfEmpty(receipt), d.ID, StatusPending)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if d.LoanID != "" {
+		now := time.Now().UTC()
+		_, err = tx.ExecContext(ctx, `
+			UPDATE loans SET status = ?, disbursement_date = ?, expected_end_date = DATETIME(?, '+' || term || ' days'),
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND status = ?`,
+			loans.StatusActive, now.Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05"), d.LoanID,
+			loans.StatusPending)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, txn.Commit(tx)
+}
+
+// Processor settles disbursements from a provider's payout results. parse
+// reads the callback payload, e.g. a Disburser's HandlePayoutResult. Results
+// for disbursements already settled are ignored, so replays are safe.
+func Processor(provider string, parse func([]byte) (payments.PaymentResult, error), notifier *notifications.Notifier) payments.Processor {
+	return func(ctx context.Context, db *sql.DB, payload []byte) error {
+		res, err := parse(payload)
+		if err != nil {
+			return err
+		}
+		return apply(ctx, db, notifier, provider, res)
+	}
+}
+
+// TimeoutProcessor handles provider's queue timeouts, read by parse: the
+// payout may still have gone through, so the disbursement is left pending
+// and provider asked for the payout's transaction status, whose result
+// settles it. Timeouts for disbursements already settled are ignored.
+func TimeoutProcessor(provider payments.Disburser, parse func([]byte) (payments.PaymentResult, error)) payments.Processor {
+	return func(ctx context.Context, db *sql.DB, payload []byte) error {
+		res, err := parse(payload)
+		if err != nil {
+			return err
+		}
+		d, err := scan(txn.From(ctx, db).QueryRowContext(ctx, `
+			SELECT `+columns+` FROM disbursements WHERE provider = ? AND provider_reference = ?`,
+			provider.Name(), res.ProviderReference))
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no %s disbursement for payout %s", provider.Name(), res.ProviderReference)
+		}
+		if err != nil || d.Status != StatusPending {
+			return err
+		}
+		slog.WarnContext(ctx, "Payout timed out; querying its status", "disbursement_id", d.ID, "message", res.Message)
+		return provider.QueryPayout(ctx, d.ID, d.ProviderReference)
+	}
+}
+
+// apply settles the pending disbursement a provider result reports on
+func apply(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, provider string, res payments.PaymentResult) error {
+	d, err := scan(txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT `+columns+` FROM disbursements WHERE provider = ? AND provider_reference = ?`,
+		provider, res.ProviderReference))
+	if err == sql.ErrNoRows {
+		// The result can beat the reference being stored; the callback is replayed from the callback log
+		return fmt.Errorf("no %s disbursement for payout %s", provider, res.ProviderReference)
+	}
+	if err != nil {
+		return err
+	}
+	if d.Status != StatusPending {
+		return nil
+	}
+
+	switch res.Status {
+	case payments.PaymentPending:
+		return nil
+	case payments.PaymentFailed:
+		if err := fail(ctx, db, d, res.Message); err != nil {
+			return err
+		}
+		d.Error = res.Message
+		notify(ctx, db, notifier, d, d.RequestedBy, "disbursement.failed", "notification.disbursement_failed")
+		return nil
+	}
+	if res.Amount != 0 && res.Amount != d.Amount.Amount {
+		return fmt.Errorf("%s paid out %d but disbursement %s is for %d", provider, res.Amount, d.ID, d.Amount.Amount)
+	}
+	done, err := complete(ctx, db, d, res.Receipt)
+	if err != nil || !done {
+		return err
+	}
+	d.Receipt = res.Receipt
+	notify(ctx, db, notifier, d, d.MemberID, "disbursement.completed", "notification.disbursement_completed")
+	return nil
+}
+
+func notify(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, d Disbursement, userID, titleKey, messageKey string) {
+	if notifier == nil {
+		return
+	}
+	var chama, member string
+	txn.From(ctx, db).QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, d.ChamaID).Scan(&chama)
+	txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT TRIM(COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')) FROM users WHERE user_id = ?`,
+		d.MemberID).Scan(&member)
+	notifier.Notify(ctx, notifications.Notification{
+		UserID: userID,
+		Title:  i18n.T(i18n.Default, titleKey, nil),
+		Message: i18n.T(i18n.Default, messageKey, map[string]string{
+			"amount": d.Amount.String(), "chama": chama, "member": member, "phone": d.Phone,
+			"receipt": d.Receipt, "reason": d.Error,
+		}),
+		Type:      notifications.TypeChama,
+		RelatedID: d.ID,
+	})
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
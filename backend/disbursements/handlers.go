@@ -0,0 +1,271 @@
+package disbursements
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"tujifund-app/backend/approvals"
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/loans"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/phone"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// managerRoles may send money out of a chama's funds
+var managerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// LoanHandler sends the {loanId} loan to the borrower's phone. The body may
+// name the provider (mpesa by default), the phone (the borrower's own number
+// by default) and the fund (the chama's loans fund by default). A loan in one
+// of the chama's approval tiers is held until it has the approvals it needs.
+func LoanHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		l, err := loans.Get(r.Context(), txn.From(r.Context(), db), mux.Vars(r)["loanId"])
+		if err == sql.ErrNoRows {
+			http.Error(w, "Loan not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !chamas.HasRoleContext(r.Context(), db, l.ChamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Provider  string `json:"provider"`
+			Phone     string `json:"phone"`
+			AccountID string `json:"accountId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.Phone != "" {
+			v := validation.New()
+			v.Phone("phone", request.Phone)
+			if !v.Valid() {
+				validation.WriteErrors(w, r, v.Errors())
+				return
+			}
+			request.Phone = phone.Canonical(request.Phone)
+		}
+
+		provider, _ := payments.LookupDisburser(providerOrDefault(request.Provider))
+		entry := audit.FromRequest(r, audit.Entry{})
+		d, err := Loan(r.Context(), db, provider, l.ID, request.Phone, request.AccountID, entry)
+		if !writeError(w, err) {
+			return
+		}
+		Requested(r.Context(), db, notifier, d, entry)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// PayoutHandler sends money from one of the {chamaId} chama's funds to a
+// member's phone, e.g. their merry-go-round turn. The body takes memberId,
+// amount, accountId and optionally provider, phone and remarks. A payout in
+// one of the chama's approval tiers is held until it has the approvals it needs.
+func PayoutHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRoleContext(r.Context(), db, chamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			MemberID  string `json:"memberId"`
+			Amount    string `json:"amount"`
+			AccountID string `json:"accountId"`
+			Provider  string `json:"provider"`
+			Phone     string `json:"phone"`
+			Remarks   string `json:"remarks"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("memberId", request.MemberID) && !chamas.IsMemberContext(r.Context(), db, chamaID, request.MemberID) {
+			v.Add("memberId", validation.CodeNotFound, nil)
+		}
+		if v.Required("amount", request.Amount) {
+			v.Amount("amount", request.Amount)
+		}
+		v.Required("accountId", request.AccountID)
+		if request.Phone != "" {
+			v.Phone("phone", request.Phone)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		request.Phone = phone.Canonical(request.Phone)
+
+		currency, err := money.ChamaCurrencyContext(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		amount, _ := money.Parse(request.Amount, currency)
+		provider, _ := payments.LookupDisburser(providerOrDefault(request.Provider))
+		entry := audit.FromRequest(r, audit.Entry{})
+		d, err := Payout(r.Context(), db, provider, Disbursement{
+			ChamaID: chamaID, MemberID: request.MemberID, AccountID: request.AccountID, Amount: amount,
+			Phone: request.Phone, Remarks: request.Remarks,
+		}, entry)
+		if !writeError(w, err) {
+			return
+		}
+		Requested(r.Context(), db, notifier, d, entry)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// ListHandler lists the {chamaId} chama's disbursements. Supports ?status=.
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficialContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := List(r.Context(), db, chamaID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// ApproveHandler adds the user's approval to the {disbursementId}
+// disbursement, sending it once it has all the approvals its tier requires
+func ApproveHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := awaiting(db, w, r)
+		if !ok {
+			return
+		}
+		d, err := Approve(r.Context(), db, d.ID, audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// RejectHandler turns down the {disbursementId} disbursement with a reason
+func RejectHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := awaiting(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || strings.TrimSpace(request.Reason) == "" {
+			http.Error(w, "A reason is required when rejecting a disbursement", http.StatusBadRequest)
+			return
+		}
+		d, err := Reject(r.Context(), db, d.ID, strings.TrimSpace(request.Reason), audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		notify(r.Context(), db, notifier, d, d.RequestedBy, "disbursement.rejected", "notification.disbursement_rejected")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// awaiting loads the {disbursementId} disbursement for one of its chama's officials
+func awaiting(db *sql.DB, w http.ResponseWriter, r *http.Request) (Disbursement, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Disbursement{}, false
+	}
+	d, err := Get(r.Context(), db, mux.Vars(r)["disbursementId"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Disbursement not found", http.StatusNotFound)
+		return d, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return d, false
+	}
+	if !chamas.IsOfficialContext(r.Context(), db, d.ChamaID, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return d, false
+	}
+	return d, true
+}
+
+func providerOrDefault(name string) string {
+	if name == "" {
+		return payments.ProviderMpesa
+	}
+	return name
+}
+
+// writeError writes the response for err and reports whether there was none
+func writeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, payments.ErrNoProvider):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, breaker.ErrUnavailable):
+		breaker.WriteError(w, err)
+	case errors.Is(err, approvals.ErrNotEligible):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrLoanNotPending), errors.Is(err, ErrInProgress), errors.Is(err, ErrSelfPayout),
+		errors.Is(err, ErrNotAwaiting), errors.Is(err, approvals.ErrDecided), errors.Is(err, approvals.ErrSelfApproval),
+		errors.Is(err, approvals.ErrAlreadyApproved), errors.Is(err, approvals.ErrVoteRequired):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrNoPhone), errors.Is(err, loans.ErrNoAccount), errors.Is(err, ledger.ErrAccountClosed),
+		errors.Is(err, ledger.ErrCurrencyMismatch), errors.Is(err, ledger.ErrDebitNotAllowed),
+		errors.Is(err, ledger.ErrInsufficientFunds):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+	return false
+}
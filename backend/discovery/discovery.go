@@ -0,0 +1,184 @@
+// Package discovery lets people find chamas to join. A chama's officials can
+// publish a profile with its description, where it meets and who may join;
+// public profiles can be searched and browsed by anyone signed in, who can
+// then ask to join. The request goes to the chama's officials like one made
+// through an invitation. Only what is on the profile is shown: members and
+// money stay private. Chamas still being set up are not listed until they go
+// live.
+package discovery
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+)
+
+var (
+	// ErrNotFound is returned for a chama without a public profile
+	ErrNotFound = errors.New("chama not found")
+	// ErrNotAccepting is returned when asking to join a chama that is not taking requests
+	ErrNotAccepting = errors.New("this chama is not accepting join requests")
+)
+
+// Profile is what a chama shows to people looking for a group to join
+type Profile struct {
+	ChamaID           string    `json:"chamaId"`
+	Name              string    `json:"name"`
+	Type              string    `json:"type"`
+	Description       string    `json:"description,omitempty"`
+	Location          string    `json:"location,omitempty"`
+	Criteria          string    `json:"criteria,omitempty"` // who may join
+	IconURL           string    `json:"iconUrl,omitempty"`
+	MemberCount       int       `json:"memberCount"`
+	Public            bool      `json:"public"`
+	AcceptingRequests bool      `json:"acceptingRequests"`
+	CreatedAt         time.Time `json:"createdAt"`
+	// RequestStatus is the viewer's own join request, if they have made one
+	RequestStatus string `json:"requestStatus,omitempty"`
+}
+
+const columns = `c.id, c.name, c.type, COALESCE(p.description, c.description, ''), COALESCE(p.location, ''),
+	COALESCE(p.criteria, ''), COALESCE(c.icon_url, ''),
+	(SELECT COUNT(*) FROM chama_members m WHERE m.chama_id = c.id AND m.status = 'active'),
+	COALESCE(p.public, 0), COALESCE(p.accepting_requests, 1), c.created_at`
+
+const joins = `FROM chamas c LEFT JOIN chama_profiles p ON p.chama_id = c.id`
+
+// listed limits a query to active chamas with a public profile that are not
+// still being set up
+const listed = `c.status = 'active' AND p.public = 1
+	AND NOT EXISTS (SELECT 1 FROM chama_onboarding o WHERE o.chama_id = c.id AND o.live_at IS NULL)`
+
+func scan(row interface{ Scan(...interface{}) error }) (Profile, error) {
+	var p Profile
+	err := row.Scan(&p.ChamaID, &p.Name, &p.Type, &p.Description, &p.Location, &p.Criteria, &p.IconURL,
+		&p.MemberCount, &p.Public, &p.AcceptingRequests, &p.CreatedAt)
+	return p, err
+}
+
+// Get returns a chama's profile, public or not
+func Get(ctx context.Context, db *sql.DB, chamaID string) (Profile, error) {
+	p, err := scan(db.QueryRowContext(ctx, `SELECT `+columns+` `+joins+` WHERE c.id = ?`, chamaID))
+	if err == sql.ErrNoRows {
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+// GetPublic returns the profile of an active chama that has made it public
+func GetPublic(ctx context.Context, db *sql.DB, chamaID string) (Profile, error) {
+	p, err := scan(db.QueryRowContext(ctx, `SELECT `+columns+` `+joins+`
+		WHERE c.id = ? AND `+listed, chamaID))
+	if err == sql.ErrNoRows {
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+// Set saves a chama's profile. The chama's name, type and icon are edited
+// with the chama itself; an empty description falls back to the chama's own.
+func Set(ctx context.Context, db *sql.DB, p Profile, e audit.Entry) (Profile, error) {
+	old, err := Get(ctx, db, p.ChamaID)
+	if err != nil {
+		return p, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chama_profiles (chama_id, public, description, location, criteria, accepting_requests, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chama_id) DO UPDATE SET public = excluded.public, description = excluded.description,
+			location = excluded.location, criteria = excluded.criteria,
+			accepting_requests = excluded.accepting_requests, updated_by = excluded.updated_by,
+			updated_at = CURRENT_TIMESTAMP`,
+		p.ChamaID, p.Public, nullIfEmpty(p.Description), nullIfEmpty(p.Location), nullIfEmpty(p.Criteria),
+		p.AcceptingRequests, e.UserID)
+	if err != nil {
+		return p, err
+	}
+	e.Action, e.EntityType, e.EntityID = "chama.profile", "chama", p.ChamaID
+	e.OldValues = map[string]interface{}{"public": old.Public, "acceptingRequests": old.AcceptingRequests}
+	e.NewValues = map[string]interface{}{"public": p.Public, "acceptingRequests": p.AcceptingRequests,
+		"location": p.Location, "criteria": p.Criteria}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return p, err
+	}
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
+	return Get(ctx, db, p.ChamaID)
+}
+
+// Filter narrows a search. Zero values are ignored.
+type Filter struct {
+	Text     string // matched against the name, description and location
+	Type     string
+	Location string
+	Limit    int
+	Offset   int
+}
+
+// Search returns the public profiles of active chamas matching f, largest
+// chamas first
+func Search(ctx context.Context, db *sql.DB, f Filter) ([]Profile, error) {
+	query := `SELECT ` + columns + ` ` + joins + ` WHERE ` + listed
+	var args []interface{}
+	for _, term := range strings.Fields(strings.ToLower(f.Text)) {
+		query += ` AND (LOWER(c.name) LIKE ? OR LOWER(COALESCE(p.description, c.description, '')) LIKE ?
+			OR LOWER(COALESCE(p.location, '')) LIKE ?)`
+		like := "%" + term + "%"
+		args = append(args, like, like, like)
+	}
+	if f.Type != "" {
+		query += ` AND c.type = ?`
+		args = append(args, f.Type)
+	}
+	if f.Location != "" {
+		query += ` AND LOWER(COALESCE(p.location, '')) LIKE ?`
+		args = append(args, "%"+strings.ToLower(f.Location)+"%")
+	}
+	limit := f.Limit
+	if limit <= 0 || limit > 50 {
+		limit = 20
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY 8 DESC, c.name LIMIT ? OFFSET ?`,
+		append(args, limit, f.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Profile{}
+	for rows.Next() {
+		p, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// requestStatus returns the status of userID's join request to a chama, or ""
+func requestStatus(ctx context.Context, db *sql.DB, chamaID, userID string) string {
+	var status string
+	db.QueryRowContext(ctx, `SELECT status FROM join_requests WHERE chama_id = ? AND user_id = ?`,
+		chamaID, userID).Scan(&status)
+	return status
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
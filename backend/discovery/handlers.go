@@ -0,0 +1,173 @@
+package discovery
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/invitations"
+	"tujifund-app/backend/notifications"
+
+	"github.com/gorilla/mux"
+)
+
+// SearchHandler lists public chamas matching ?q=, ?type= and ?location=,
+// paged by ?limit= (20 by default, at most 50) and ?offset=
+func SearchHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		f := Filter{Text: q.Get("q"), Type: q.Get("type"), Location: strings.TrimSpace(q.Get("location"))}
+		f.Limit, _ = strconv.Atoi(q.Get("limit"))
+		if n, err := strconv.Atoi(q.Get("offset")); err == nil && n > 0 {
+			f.Offset = n
+		}
+		list, err := Search(r.Context(), db, f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// ProfileHandler returns the {chamaId} chama's public profile, with the
+// caller's own join request status so the app knows whether to offer to join
+func ProfileHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		p, err := GetPublic(r.Context(), db, mux.Vars(r)["chamaId"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		p.RequestStatus = requestStatus(r.Context(), db, p.ChamaID, userID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// JoinHandler asks to join the {chamaId} chama from its public profile, with
+// an optional {"message": "..."} for its officials, who are notified
+func JoinHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		p, err := GetPublic(r.Context(), db, mux.Vars(r)["chamaId"])
+		if err == nil && !p.AcceptingRequests {
+			err = ErrNotAccepting
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		req, err := invitations.RequestToJoin(r.Context(), db, p.ChamaID, userID, strings.TrimSpace(request.Message))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		invitations.NotifyOfficials(r.Context(), db, notifier, req)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(req)
+	}
+}
+
+// GetHandler returns the {chamaId} chama's profile, public or not, for its officials
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		p, err := Get(r.Context(), db, chamaID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// SetHandler lets an official publish or update the {chamaId} chama's
+// profile. The body takes public, description, location, criteria and
+// acceptingRequests (true by default).
+func SetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			Public            bool   `json:"public"`
+			Description       string `json:"description"`
+			Location          string `json:"location"`
+			Criteria          string `json:"criteria"`
+			AcceptingRequests *bool  `json:"acceptingRequests"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		p := Profile{
+			ChamaID:           chamaID,
+			Public:            request.Public,
+			Description:       strings.TrimSpace(request.Description),
+			Location:          strings.TrimSpace(request.Location),
+			Criteria:          strings.TrimSpace(request.Criteria),
+			AcceptingRequests: request.AcceptingRequests == nil || *request.AcceptingRequests,
+		}
+		p, err := Set(r.Context(), db, p, audit.FromRequest(r, audit.Entry{UserID: userID}))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNotAccepting), errors.Is(err, invitations.ErrAlreadyMember),
+		errors.Is(err, invitations.ErrState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,329 @@
+// Package documents is each chama's document vault: its constitution,
+// registration certificate, bank mandates and the like. Uploading a document
+// again adds a version rather than replacing it, so earlier versions stay
+// available. Each document lists the roles that may view it, and officials
+// are reminded before a document expires, e.g. when registration is due for
+// renewal.
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+
+	"github.com/google/uuid"
+)
+
+// Document categories
+const (
+	CategoryConstitution = "constitution"
+	CategoryRegistration = "registration_certificate"
+	CategoryBankMandate  = "bank_mandate"
+	CategoryAgreement    = "agreement"
+	CategoryOther        = "other"
+)
+
+// Categories lists the valid document categories
+var Categories = []string{CategoryConstitution, CategoryRegistration, CategoryBankMandate, CategoryAgreement, CategoryOther}
+
+// ManagerRoles upload, edit and archive documents, and can view all of them
+var ManagerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleSecretary}
+
+// ErrNotFound is returned for documents that do not exist or were archived
+var ErrNotFound = errors.New("document not found")
+
+// Document is a chama document and its current version
+type Document struct {
+	ID        string     `json:"id"`
+	ChamaID   string     `json:"chamaId"`
+	Category  string     `json:"category"`
+	Title     string     `json:"title"`
+	ViewRoles []string   `json:"viewRoles"` // empty when every member may view it
+	Version   int        `json:"version"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	Versions  []Version  `json:"versions,omitempty"`
+}
+
+// Version is one upload of a document
+type Version struct {
+	Version    int        `json:"version"`
+	StorageKey string     `json:"-"`
+	FileName   string     `json:"fileName,omitempty"`
+	MimeType   string     `json:"mimeType"`
+	SizeBytes  int64      `json:"sizeBytes"`
+	SHA256     string     `json:"sha256"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	Notes      string     `json:"notes,omitempty"`
+	UploadedBy string     `json:"uploadedBy"`
+	UploadedAt time.Time  `json:"uploadedAt"`
+}
+
+// Expired reports whether the document's current version has expired by now
+func (d Document) Expired(now time.Time) bool {
+	return d.ExpiresAt != nil && !now.Before(*d.ExpiresAt)
+}
+
+// CanView reports whether a member with role may view d
+func (d Document) CanView(role string) bool {
+	if role == "" {
+		return false
+	}
+	if len(d.ViewRoles) == 0 {
+		return true
+	}
+	for _, r := range d.ViewRoles {
+		if r == role {
+			return true
+		}
+	}
+	for _, r := range ManagerRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Create adds a document with v as its first version
+func Create(ctx context.Context, db *sql.DB, d Document, v Version, entry audit.Entry) (Document, error) {
+	d.ID, d.Version, d.ExpiresAt, d.CreatedBy = uuid.NewString(), 1, v.ExpiresAt, v.UploadedBy
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return d, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chama_documents (id, chama_id, category, title, view_roles, current_version, expires_at, created_by)
+		VALUES (?, ?, ?, ?, ?, 1, ?, ?)`,
+		d.ID, d.ChamaID, d.Category, d.Title, nullIfEmpty(strings.Join(d.ViewRoles, ",")), timestamp(d.ExpiresAt), d.CreatedBy)
+	if err != nil {
+		return d, fmt.Errorf("failed to create document: %w", err)
+	}
+	v.Version = 1
+	if err := insertVersion(ctx, tx, d.ID, v); err != nil {
+		return d, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "document.create", "chama_document", d.ID
+	entry.NewValues = map[string]interface{}{"chamaId": d.ChamaID, "category": d.Category, "title": d.Title,
+		"viewRoles": d.ViewRoles, "sha256": v.SHA256}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return d, err
+	}
+	if err := tx.Commit(); err != nil {
+		return d, err
+	}
+	return Get(ctx, db, d.ID)
+}
+
+// AddVersion makes v the document's current version. The expiry date moves
+// to the new version's, so uploading a renewed certificate ends the reminders
+// for the old one.
+func AddVersion(ctx context.Context, db *sql.DB, id string, v Version, entry audit.Entry) (Document, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Document{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE chama_documents SET current_version = current_version + 1, expires_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND archived_at IS NULL
+		RETURNING current_version`, timestamp(v.ExpiresAt), id).Scan(&v.Version)
+	if err == sql.ErrNoRows {
+		return Document{}, ErrNotFound
+	}
+	if err != nil {
+		return Document{}, err
+	}
+	if err := insertVersion(ctx, tx, id, v); err != nil {
+		return Document{}, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "document.version", "chama_document", id
+	entry.NewValues = map[string]interface{}{"version": v.Version, "sha256": v.SHA256}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return Document{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Document{}, err
+	}
+	return Get(ctx, db, id)
+}
+
+func insertVersion(ctx context.Context, tx *sql.Tx, documentID string, v Version) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO chama_document_versions
+		(id, document_id, version, storage_key, file_name, mime_type, size_bytes, sha256, expires_at, notes, uploaded_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		uuid.NewString(), documentID, v.Version, v.StorageKey, nullIfEmpty(v.FileName), v.MimeType, v.SizeBytes, v.SHA256,
+		timestamp(v.ExpiresAt), nullIfEmpty(v.Notes), v.UploadedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save document version: %w", err)
+	}
+	return nil
+}
+
+// Update changes a document's title, category and the roles that may view it
+func Update(ctx context.Context, db *sql.DB, d Document, entry audit.Entry) (Document, error) {
+	old, err := Get(ctx, db, d.ID)
+	if err != nil {
+		return d, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return d, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE chama_documents SET title = ?, category = ?, view_roles = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND archived_at IS NULL`,
+		d.Title, d.Category, nullIfEmpty(strings.Join(d.ViewRoles, ",")), d.ID)
+	if err != nil {
+		return d, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "document.update", "chama_document", d.ID
+	entry.OldValues = map[string]interface{}{"title": old.Title, "category": old.Category, "viewRoles": old.ViewRoles}
+	entry.NewValues = map[string]interface{}{"title": d.Title, "category": d.Category, "viewRoles": d.ViewRoles}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return d, err
+	}
+	if err := tx.Commit(); err != nil {
+		return d, err
+	}
+	return Get(ctx, db, d.ID)
+}
+
+// Archive removes a document from the vault. Its versions and files are
+// kept for the record.
+func Archive(ctx context.Context, db *sql.DB, id string, entry audit.Entry) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE chama_documents SET archived_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND archived_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "document.archive", "chama_document", id
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const columns = `id, chama_id, category, title, COALESCE(view_roles, ''), current_version, expires_at, created_by,
+	created_at, updated_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Document, error) {
+	var d Document
+	var roles string
+	var expires sql.NullTime
+	err := row.Scan(&d.ID, &d.ChamaID, &d.Category, &d.Title, &roles, &d.Version, &expires, &d.CreatedBy,
+		&d.CreatedAt, &d.UpdatedAt)
+	d.ViewRoles = []string{}
+	if roles != "" {
+		d.ViewRoles = strings.Split(roles, ",")
+	}
+	if expires.Valid {
+		d.ExpiresAt = &expires.Time
+	}
+	return d, err
+}
+
+// Get returns a document with its versions, latest first
+func Get(ctx context.Context, db *sql.DB, id string) (Document, error) {
+	d, err := scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM chama_documents WHERE id = ? AND archived_at IS NULL`, id))
+	if err == sql.ErrNoRows {
+		return d, ErrNotFound
+	}
+	if err != nil {
+		return d, err
+	}
+	d.Versions, err = versions(ctx, db, id)
+	return d, err
+}
+
+func versions(ctx context.Context, db *sql.DB, documentID string) ([]Version, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT version, storage_key, COALESCE(file_name, ''), mime_type, size_bytes, sha256, expires_at, COALESCE(notes, ''),
+		       uploaded_by, uploaded_at
+		FROM chama_document_versions WHERE document_id = ? ORDER BY version DESC`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Version{}
+	for rows.Next() {
+		var v Version
+		var expires sql.NullTime
+		if err := rows.Scan(&v.Version, &v.StorageKey, &v.FileName, &v.MimeType, &v.SizeBytes, &v.SHA256, &expires, &v.Notes,
+			&v.UploadedBy, &v.UploadedAt); err != nil {
+			return nil, err
+		}
+		if expires.Valid {
+			v.ExpiresAt = &expires.Time
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// List returns the chama's documents a member with role may view, only those
+// in category when it is set
+func List(ctx context.Context, db *sql.DB, chamaID, category, role string) ([]Document, error) {
+	query := `SELECT ` + columns + ` FROM chama_documents WHERE chama_id = ? AND archived_at IS NULL`
+	args := []interface{}{chamaID}
+	if category != "" {
+		query += ` AND category = ?`
+		args = append(args, category)
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY category, title`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Document{}
+	for rows.Next() {
+		d, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		if d.CanView(role) {
+			list = append(list, d)
+		}
+	}
+	return list, rows.Err()
+}
+
+// timestamp formats t for storage, or NULL when it is nil
+func timestamp(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
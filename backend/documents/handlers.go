@@ -0,0 +1,372 @@
+package documents
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/quota"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/validation"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// MaxDocumentSize is the largest accepted document
+const MaxDocumentSize = 10 << 20 // 10 MB
+
+// CreateHandler adds a document to the {chamaId} chama's vault. It accepts a
+// multipart form with "file" (PDF or image), "title", "category", optional
+// comma-separated "viewRoles", "expiresAt" (YYYY-MM-DD) and "notes".
+func CreateHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, ManagerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MaxDocumentSize+1<<20)
+		if err := r.ParseMultipartForm(MaxDocumentSize); err != nil {
+			http.Error(w, "Invalid upload or file too large", http.StatusBadRequest)
+			return
+		}
+		d := Document{
+			ChamaID:   chamaID,
+			Title:     strings.TrimSpace(r.FormValue("title")),
+			Category:  r.FormValue("category"),
+			ViewRoles: splitRoles(r.FormValue("viewRoles")),
+		}
+		v := validation.New()
+		v.Required("title", d.Title)
+		v.OneOf("category", d.Category, Categories...)
+		validateRoles(v, d.ViewRoles)
+		expires := formDate(v, r.FormValue("expiresAt"))
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		version, ok := upload(w, r, db, store, chamaID)
+		if !ok {
+			return
+		}
+		version.ExpiresAt, version.UploadedBy = expires, userID
+		d, err := Create(r.Context(), db, d, version, audit.FromRequest(r, audit.Entry{}))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// ListHandler lists the {chamaId} chama's documents the member may view.
+// ?category= narrows the list to one category.
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		role, err := chamas.MemberRole(db, chamaID, userID)
+		if err != nil || role == "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := List(r.Context(), db, chamaID, r.URL.Query().Get("category"), role)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// GetHandler returns the {documentId} document with its version history
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, _, ok := loadDocument(db, w, r, false)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// AddVersionHandler uploads a new version of the {documentId} document, e.g.
+// a renewed registration certificate. It accepts the same "file",
+// "expiresAt" and "notes" fields as CreateHandler.
+func AddVersionHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, userID, ok := loadDocument(db, w, r, true)
+		if !ok {
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MaxDocumentSize+1<<20)
+		if err := r.ParseMultipartForm(MaxDocumentSize); err != nil {
+			http.Error(w, "Invalid upload or file too large", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		expires := formDate(v, r.FormValue("expiresAt"))
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		version, ok := upload(w, r, db, store, d.ChamaID)
+		if !ok {
+			return
+		}
+		version.ExpiresAt, version.UploadedBy = expires, userID
+		d, err := AddVersion(r.Context(), db, d.ID, version, audit.FromRequest(r, audit.Entry{}))
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// UpdateHandler changes the {documentId} document's title, category or the
+// roles that may view it
+func UpdateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, _, ok := loadDocument(db, w, r, true)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			Title     *string   `json:"title"`
+			Category  *string   `json:"category"`
+			ViewRoles *[]string `json:"viewRoles"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.Title != nil {
+			d.Title = strings.TrimSpace(*request.Title)
+		}
+		if request.Category != nil {
+			d.Category = *request.Category
+		}
+		if request.ViewRoles != nil {
+			d.ViewRoles = *request.ViewRoles
+		}
+
+		v := validation.New()
+		v.Required("title", d.Title)
+		v.OneOf("category", d.Category, Categories...)
+		validateRoles(v, d.ViewRoles)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		d, err := Update(r.Context(), db, d, audit.FromRequest(r, audit.Entry{}))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// ArchiveHandler removes the {documentId} document from the vault
+func ArchiveHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, _, ok := loadDocument(db, w, r, true)
+		if !ok {
+			return
+		}
+		err := Archive(r.Context(), db, d.ID, audit.FromRequest(r, audit.Entry{}))
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DownloadHandler redirects to a short-lived link for {version} of the
+// {documentId} document
+func DownloadHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, _, ok := loadDocument(db, w, r, false)
+		if !ok {
+			return
+		}
+		number, err := strconv.Atoi(mux.Vars(r)["version"])
+		if err != nil {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+		var version *Version
+		for i := range d.Versions {
+			if d.Versions[i].Version == number {
+				version = &d.Versions[i]
+			}
+		}
+		if version == nil {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+
+		url, err := store.SignedURL(version.StorageKey, 5*time.Minute)
+		if err != nil {
+			http.Error(w, "Failed to create download link", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
+
+// loadDocument loads the {documentId} document for a member who may view it,
+// or manage it when manage is set, writing an error response and returning
+// false otherwise. Documents a member may not view are reported as not found.
+func loadDocument(db *sql.DB, w http.ResponseWriter, r *http.Request, manage bool) (Document, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Document{}, "", false
+	}
+	d, err := Get(r.Context(), db, mux.Vars(r)["documentId"])
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return d, userID, false
+	}
+	var role string
+	if err == nil {
+		role, err = chamas.MemberRole(db, d.ChamaID, userID)
+	}
+	if err != nil || !d.CanView(role) {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return d, userID, false
+	}
+	if manage && !chamas.HasRole(db, d.ChamaID, userID, ManagerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return d, userID, false
+	}
+	return d, userID, true
+}
+
+// upload stores the request's "file" in the chama's part of store, writing
+// an error response and returning false if it is missing, not an accepted
+// type or more than the chama's plan has room for
+func upload(w http.ResponseWriter, r *http.Request, db *sql.DB, store storage.Backend, chamaID string) (Version, bool) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		v := validation.New()
+		v.Required("file", "")
+		validation.WriteErrors(w, r, v.Errors())
+		return Version{}, false
+	}
+	defer file.Close()
+
+	mimeType, err := storage.DetectType(file, header.Size, MaxDocumentSize, storage.DocumentTypes)
+	if errors.Is(err, storage.ErrTooLarge) {
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return Version{}, false
+	}
+	if err != nil {
+		http.Error(w, "Only JPEG, PNG and PDF files are accepted", http.StatusUnsupportedMediaType)
+		return Version{}, false
+	}
+	if err := quota.Check(r.Context(), db, chamaID, quota.LimitStorage, header.Size); err != nil {
+		quota.WriteError(w, r, err)
+		return Version{}, false
+	}
+	// The checksum lets members confirm a printed copy matches the vault's
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		http.Error(w, "Failed to read document", http.StatusBadRequest)
+		return Version{}, false
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Failed to read document", http.StatusInternalServerError)
+		return Version{}, false
+	}
+
+	key := "documents/" + chamaID + "/" + uuid.NewString() + storage.Extensions[mimeType]
+	if err := store.Put(r.Context(), key, file, header.Size, mimeType); err != nil {
+		http.Error(w, "Failed to store document", http.StatusInternalServerError)
+		return Version{}, false
+	}
+	return Version{
+		StorageKey: key,
+		FileName:   header.Filename,
+		MimeType:   mimeType,
+		SizeBytes:  header.Size,
+		SHA256:     hex.EncodeToString(hash.Sum(nil)),
+		Notes:      strings.TrimSpace(r.FormValue("notes")),
+	}, true
+}
+
+// formDate parses an optional YYYY-MM-DD form value
+func formDate(v *validation.Validator, value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	v.Date("expiresAt", value)
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func splitRoles(value string) []string {
+	roles := []string{}
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func validateRoles(v *validation.Validator, roles []string) {
+	for _, role := range roles {
+		v.OneOf("viewRoles", role, chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer, chamas.RoleSecretary,
+			chamas.RoleMember)
+	}
+}
@@ -0,0 +1,100 @@
+package documents
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+)
+
+// ReminderDays are how many days before expiry officials are reminded to
+// renew a document; 0 is the day it expires
+var ReminderDays = []int{30, 7, 0}
+
+// RegisterExpiryReminderJob reminds each chama's officials about documents
+// expiring within ReminderDays. Each reminder goes out once per version, so
+// uploading the renewed document stops them.
+func RegisterExpiryReminderJob(s *jobs.Scheduler, db *sql.DB, notifier *notifications.Notifier) {
+	s.Register("document_expiry_reminders", jobs.Daily{Hour: 8}, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		rows, err := db.QueryContext(ctx, `
+			SELECT `+columns+` FROM chama_documents
+			WHERE archived_at IS NULL AND expires_at IS NOT NULL AND expires_at < ?`,
+			today.AddDate(0, 0, ReminderDays[0]+1).Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return err
+		}
+		var due []Document
+		for rows.Next() {
+			d, err := scan(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			due = append(due, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, d := range due {
+			days := int(d.ExpiresAt.Sub(today).Hours() / 24)
+			// The smallest offset reached, so a document added a week
+			// before it expires is reminded about once, not three times
+			offset := -1
+			for _, n := range ReminderDays {
+				if days <= n {
+					offset = n
+				}
+			}
+			if offset < 0 {
+				continue
+			}
+			key := fmt.Sprintf("%s:%d:%d", d.ID, d.Version, offset)
+			s.Run(ctx, "document_expiry_reminder", key, func(ctx context.Context) error {
+				return notifyExpiry(ctx, db, notifier, d, days)
+			})
+		}
+		return nil
+	})
+}
+
+func notifyExpiry(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, d Document, days int) error {
+	officials, err := chamas.MembersWithRole(db, d.ChamaID, chamas.OfficialRoles...)
+	if err != nil {
+		return err
+	}
+	var chama string
+	db.QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, d.ChamaID).Scan(&chama)
+	params := map[string]string{
+		"chama": chama,
+		"title": d.Title,
+		"date":  d.ExpiresAt.Format("2 Jan 2006"),
+		"days":  strconv.Itoa(days),
+	}
+	titleKey, messageKey := "document.expiring", "notification.document_expiring"
+	if days <= 0 {
+		titleKey, messageKey = "document.expired", "notification.document_expired"
+	}
+	for _, userID := range officials {
+		if err := notifier.Notify(ctx, notifications.Notification{
+			UserID:    userID,
+			Title:     i18n.T(i18n.Default, titleKey, nil),
+			Message:   i18n.T(i18n.Default, messageKey, params),
+			Type:      notifications.TypeChama,
+			RelatedID: d.ID,
+		}); err != nil {
+			slog.ErrorContext(ctx, "Failed to send document expiry reminder", "document_id", d.ID, "user_id", userID, "error", err)
+		}
+	}
+	return nil
+}
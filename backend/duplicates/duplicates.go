@@ -0,0 +1,334 @@
+package duplicates
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"tujifund-app/backend/audit"
+
+	"github.com/google/uuid"
+)
+
+// Duplicate statuses
+const (
+	StatusOpen      = "open"
+	StatusMerged    = "merged"
+	StatusDismissed = "dismissed"
+)
+
+// Reasons a record is taken for a duplicate
+const (
+	ReasonPhone      = "phone"
+	ReasonNationalID = "national_id"
+	ReasonName       = "name"
+)
+
+// Threshold is the score from which a record is reported as a possible duplicate
+const Threshold = 0.5
+
+// Score weights. A matching national ID is conclusive; a phone number is
+// strong evidence, as numbers are recycled; a similar name alone only
+// merits a look.
+const (
+	weightNationalID = 1.0
+	weightPhone      = 0.7
+	weightName       = 0.6
+	minNameScore     = 0.8
+)
+
+var (
+	// ErrNotFound is returned for an unknown duplicate
+	ErrNotFound = errors.New("duplicate not found")
+	// ErrReviewed is returned when the duplicate has already been merged or dismissed
+	ErrReviewed = errors.New("duplicate has already been reviewed")
+)
+
+// Person is the identifying details of a member being created
+type Person struct {
+	FirstName  string
+	LastName   string
+	Email      string
+	Phone      string
+	NationalID string
+}
+
+// Candidate is an existing user who may be the same person
+type Candidate struct {
+	UserID     string   `json:"userId"`
+	Name       string   `json:"name"`
+	Email      string   `json:"email"`
+	Phone      string   `json:"phone,omitempty"`
+	NationalID string   `json:"nationalId,omitempty"`
+	Score      float64  `json:"score"`
+	Reasons    []string `json:"reasons"`
+}
+
+// Duplicate is a recorded possible duplicate awaiting review by staff
+type Duplicate struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"userId"`
+	DuplicateOf string     `json:"duplicateOf"`
+	Score       float64    `json:"score"`
+	Reasons     []string   `json:"reasons"`
+	Status      string     `json:"status"`
+	ReviewedBy  string     `json:"reviewedBy,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// Queryer is a database or transaction
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// phoneKey is the part of a phone number compared: its last nine digits,
+// which is the subscriber number whether written 07..., 2547... or +2547...
+func phoneKey(phone string) string {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	d := digits.String()
+	if len(d) < 9 {
+		return ""
+	}
+	return d[len(d)-9:]
+}
+
+// nameKey lowercases a name and sorts its words, so that "Kamau John" and
+// "john  kamau" compare equal
+func nameKey(parts ...string) string {
+	words := strings.FieldsFunc(strings.ToLower(strings.Join(parts, " ")), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// nameScore is how alike two name keys are, from 0 to 1, by edit distance
+func nameScore(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Find returns the existing users who may be the same person as p, best
+// match first, leaving out excludeID. Users who have been merged or erased
+// are not considered.
+func Find(ctx context.Context, db Queryer, p Person, excludeID string) ([]Candidate, error) {
+	phone, name := phoneKey(p.Phone), nameKey(p.FirstName, p.LastName)
+	nationalID := strings.ToUpper(strings.TrimSpace(p.NationalID))
+
+	// Narrow the search to users sharing the phone or ID, or whose first or
+	// last name starts like one of p's names; names are scored below
+	where := []string{}
+	var args []interface{}
+	if phone != "" {
+		where = append(where, `substr(replace(replace(replace(COALESCE(phone_number, ''), ' ', ''), '-', ''), '+', ''), -9) = ?`)
+		args = append(args, phone)
+	}
+	if nationalID != "" {
+		where = append(where, `UPPER(national_id) = ?`)
+		args = append(args, nationalID)
+	}
+	for _, word := range strings.Fields(name) {
+		prefix := string([]rune(word)[:min(2, len([]rune(word)))])
+		where = append(where, `lower(substr(COALESCE(first_name, ''), 1, 2)) = ?`, `lower(substr(COALESCE(last_name, ''), 1, 2)) = ?`)
+		args = append(args, prefix, prefix)
+	}
+	if len(where) == 0 {
+		return []Candidate{}, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_id, COALESCE(first_name, ''), COALESCE(last_name, ''), email, COALESCE(phone_number, ''),
+		       COALESCE(national_id, '')
+		FROM users
+		WHERE user_id != ? AND merged_into IS NULL AND erased_at IS NULL AND (`+strings.Join(where, " OR ")+`)`,
+		append([]interface{}{excludeID}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for duplicates: %w", err)
+	}
+	defer rows.Close()
+
+	list := []Candidate{}
+	for rows.Next() {
+		var c Candidate
+		var first, last string
+		if err := rows.Scan(&c.UserID, &first, &last, &c.Email, &c.Phone, &c.NationalID); err != nil {
+			return nil, err
+		}
+		c.Name = strings.TrimSpace(first + " " + last)
+		if nationalID != "" && strings.EqualFold(c.NationalID, nationalID) {
+			c.Score += weightNationalID
+			c.Reasons = append(c.Reasons, ReasonNationalID)
+		}
+		if phone != "" && phoneKey(c.Phone) == phone {
+			c.Score += weightPhone
+			c.Reasons = append(c.Reasons, ReasonPhone)
+		}
+		if s := nameScore(name, nameKey(first, last)); s >= minNameScore {
+			c.Score += weightName * s
+			c.Reasons = append(c.Reasons, ReasonName)
+		}
+		if c.Score = min(c.Score, 1); c.Score >= Threshold {
+			list = append(list, c)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Score > list[j].Score })
+	return list, rows.Err()
+}
+
+// Candidates returns the existing users resembling userID
+func Candidates(ctx context.Context, db Queryer, userID string) ([]Candidate, error) {
+	var p Person
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(first_name, ''), COALESCE(last_name, ''), email, COALESCE(phone_number, ''), COALESCE(national_id, '')
+		FROM users WHERE user_id = ?`, userID).Scan(&p.FirstName, &p.LastName, &p.Email, &p.Phone, &p.NationalID)
+	if err != nil {
+		return nil, err
+	}
+	return Find(ctx, db, p, userID)
+}
+
+// Flag looks for existing users resembling the newly created userID and
+// records each as an open duplicate for staff to review. It returns the
+// candidates found.
+func Flag(ctx context.Context, db Queryer, userID string) ([]Candidate, error) {
+	list, err := Candidates(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range list {
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO member_duplicates (id, user_id, duplicate_of, score, reasons) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(user_id, duplicate_of) DO NOTHING`,
+			uuid.NewString(), userID, c.UserID, c.Score, strings.Join(c.Reasons, ","))
+		if err != nil {
+			return list, fmt.Errorf("failed to record duplicate: %w", err)
+		}
+	}
+	return list, nil
+}
+
+const duplicateColumns = `id, user_id, duplicate_of, score, reasons, status, COALESCE(reviewed_by, ''), reviewed_at, created_at`
+
+func scanDuplicate(row interface{ Scan(...interface{}) error }) (Duplicate, error) {
+	var d Duplicate
+	var reasons string
+	err := row.Scan(&d.ID, &d.UserID, &d.DuplicateOf, &d.Score, &reasons, &d.Status, &d.ReviewedBy, &d.ReviewedAt,
+		&d.CreatedAt)
+	d.Reasons = strings.Split(reasons, ",")
+	return d, err
+}
+
+// List returns recorded duplicates with the given status, or all of them,
+// best match first
+func List(ctx context.Context, db *sql.DB, status string, limit int) ([]Duplicate, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	query := `SELECT ` + duplicateColumns + ` FROM member_duplicates`
+	var args []interface{}
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY score DESC, created_at DESC LIMIT ?`
+	rows, err := db.QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Duplicate{}
+	for rows.Next() {
+		d, err := scanDuplicate(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// Get returns a recorded duplicate
+func Get(ctx context.Context, db *sql.DB, id string) (Duplicate, error) {
+	return get(ctx, db, id)
+}
+
+func get(ctx context.Context, db Queryer, id string) (Duplicate, error) {
+	d, err := scanDuplicate(db.QueryRowContext(ctx, `SELECT `+duplicateColumns+` FROM member_duplicates WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return d, ErrNotFound
+	}
+	return d, err
+}
+
+// Dismiss marks an open duplicate as not the same person
+func Dismiss(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Duplicate, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Duplicate{}, err
+	}
+	defer tx.Rollback()
+
+	d, err := get(ctx, tx, id)
+	if err != nil {
+		return d, err
+	}
+	if d.Status != StatusOpen {
+		return d, ErrReviewed
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE member_duplicates SET status = ?, reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		StatusDismissed, entry.UserID, id)
+	if err != nil {
+		return d, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "user.duplicate_dismiss", "member_duplicate", id
+	entry.NewValues = map[string]interface{}{"user_id": d.UserID, "duplicate_of": d.DuplicateOf}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return d, err
+	}
+	if d, err = get(ctx, tx, id); err != nil {
+		return d, err
+	}
+	return d, tx.Commit()
+}
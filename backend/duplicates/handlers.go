@@ -0,0 +1,111 @@
+package duplicates
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// ListHandler lists recorded duplicates with ?status= (open by default, or
+// "all"), best match first
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = StatusOpen
+		case "all":
+			status = ""
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		list, err := List(r.Context(), db, status, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// CandidatesHandler returns the users resembling the {userId} user now
+func CandidatesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := Candidates(r.Context(), db, mux.Vars(r)["userId"])
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// DismissHandler records that the {duplicateId} duplicate is a different person
+func DismissHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := Dismiss(r.Context(), db, mux.Vars(r)["duplicateId"], audit.FromRequest(r, audit.Entry{}))
+		switch {
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, ErrReviewed):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// MergeHandler merges the {"mergeUserId"} user into the {userId} user,
+// which is the record kept
+func MergeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			MergeUserID string `json:"mergeUserId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("mergeUserId", request.MergeUserID)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		res, err := Merge(r.Context(), db, mux.Vars(r)["userId"], request.MergeUserID, audit.FromRequest(r, audit.Entry{}))
+		switch {
+		case errors.Is(err, ErrUserNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, ErrSameUser):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrAlreadyMerged), errors.Is(err, ErrConflict):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
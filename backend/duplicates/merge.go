@@ -0,0 +1,197 @@
+package duplicates
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"tujifund-app/backend/account"
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/txn"
+)
+
+var (
+	// ErrSameUser is returned when merging a user into itself
+	ErrSameUser = errors.New("cannot merge a user into itself")
+	// ErrUserNotFound is returned when either user does not exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrAlreadyMerged is returned when either user has already been merged away
+	ErrAlreadyMerged = errors.New("user has already been merged into another")
+	// ErrConflict is returned when both records hold the same thing, such as
+	// guaranteeing the same loan, and cannot be combined automatically
+	ErrConflict = errors.New("both records hold the same item and cannot be combined")
+)
+
+// moves are the member records a merge hands over, by table and the column
+// naming the member
+var moves = []struct{ name, table, column string }{
+	{"contributions", "contributions", "member_id"},
+	{"loan_applications", "loan_applications", "user_id"},
+	{"loans", "loans", "borrower_id"},
+	{"loan_guarantees", "loan_guarantors", "guarantor_id"},
+	{"loan_guarantee_requests", "loan_application_guarantors", "guarantor_id"},
+	{"loan_collateral", "loan_collateral", "owner_id"},
+	{"fines", "fines", "member_id"},
+	{"receipts", "receipts", "user_id"},
+	{"kyc_documents", "kyc_documents", "user_id"},
+}
+
+// MergeResult reports what a merge moved
+type MergeResult struct {
+	KeptID   string           `json:"keptId"`
+	MergedID string           `json:"mergedId"`
+	Moved    map[string]int64 `json:"moved"` // rows handed over, by kind
+	// Memberships the merged record held in chamas the kept one was already
+	// in, which were folded into the kept membership
+	CombinedMemberships int `json:"combinedMemberships"`
+	LedgerEntries       int `json:"ledgerEntries"` // balance transfers posted
+}
+
+// Merge folds the mergeID user into keepID in one transaction: memberships,
+// contributions, loans, guarantees, collateral, fines, receipts and KYC
+// documents are handed over, ledger balances are transferred with balancing
+// entries, and the merged account is suspended and marked as merged so it
+// can no longer sign in. The audit log records the merged record's details
+// and everything that moved.
+func Merge(ctx context.Context, db *sql.DB, keepID, mergeID string, entry audit.Entry) (MergeResult, error) {
+	res := MergeResult{KeptID: keepID, MergedID: mergeID, Moved: map[string]int64{}}
+	if keepID == mergeID {
+		return res, ErrSameUser
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer txn.Rollback(tx)
+
+	merged := map[string]interface{}{}
+	for _, id := range []string{keepID, mergeID} {
+		var name, email, phone, nationalID string
+		var mergedInto sql.NullString
+		err := tx.QueryRowContext(ctx, `
+			SELECT TRIM(COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')), email, COALESCE(phone_number, ''),
+			       COALESCE(national_id, ''), merged_into
+			FROM users WHERE user_id = ?`, id).Scan(&name, &email, &phone, &nationalID, &mergedInto)
+		if err == sql.ErrNoRows {
+			return res, ErrUserNotFound
+		}
+		if err != nil {
+			return res, err
+		}
+		if mergedInto.Valid {
+			return res, ErrAlreadyMerged
+		}
+		if id == mergeID {
+			merged = map[string]interface{}{"name": name, "email": email, "phone": phone, "national_id": nationalID}
+		}
+	}
+
+	if res.CombinedMemberships, err = moveMemberships(ctx, tx, keepID, mergeID); err != nil {
+		return res, err
+	}
+	for _, m := range moves {
+		r, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ?`, m.table, m.column, m.column), keepID, mergeID)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				return res, fmt.Errorf("%w: %s", ErrConflict, m.name)
+			}
+			return res, fmt.Errorf("failed to move %s: %w", m.name, err)
+		}
+		if n, _ := r.RowsAffected(); n > 0 {
+			res.Moved[m.name] = n
+		}
+	}
+
+	chamaIDs, err := ledgerChamas(ctx, tx, mergeID)
+	if err != nil {
+		return res, err
+	}
+	for _, chamaID := range chamaIDs {
+		posted, err := ledger.ReassignMember(ctx, tx, chamaID, mergeID, keepID, "merge:"+mergeID, entry.UserID)
+		if err != nil {
+			return res, fmt.Errorf("failed to transfer ledger balances: %w", err)
+		}
+		res.LedgerEntries += len(posted)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET merged_into = ?, suspended_at = COALESCE(suspended_at, CURRENT_TIMESTAMP),
+		       suspension_reason = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?`, keepID, "Merged into "+keepID, mergeID)
+	if err != nil {
+		return res, err
+	}
+	if err := account.EndSessions(ctx, tx, mergeID); err != nil {
+		return res, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE member_duplicates SET status = ?, reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP
+		WHERE status = ? AND (user_id = ? OR duplicate_of = ?)`,
+		StatusMerged, entry.UserID, StatusOpen, mergeID, mergeID)
+	if err != nil {
+		return res, err
+	}
+
+	entry.Action, entry.EntityType, entry.EntityID = "user.merge", "user", mergeID
+	entry.OldValues = merged
+	entry.NewValues = map[string]interface{}{
+		"merged_into":          keepID,
+		"moved":                res.Moved,
+		"combined_memberships": res.CombinedMemberships,
+		"ledger_entries":       res.LedgerEntries,
+	}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return res, err
+	}
+	return res, txn.Commit(tx)
+}
+
+// moveMemberships hands mergeID's chama memberships to keepID. Where keepID
+// is already in the chama the two are folded together, keeping keepID's role
+// and the earlier join date. It returns how many were folded.
+func moveMemberships(ctx context.Context, tx *sql.Tx, keepID, mergeID string) (int, error) {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE chama_members AS k SET join_date = (
+			SELECT m.join_date FROM chama_members m WHERE m.chama_id = k.chama_id AND m.user_id = ?
+		)
+		WHERE k.user_id = ? AND EXISTS (
+			SELECT 1 FROM chama_members m WHERE m.chama_id = k.chama_id AND m.user_id = ? AND m.join_date < k.join_date
+		)`, mergeID, keepID, mergeID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to combine memberships: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM chama_members
+		WHERE user_id = ? AND chama_id IN (SELECT chama_id FROM chama_members WHERE user_id = ?)`, mergeID, keepID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to combine memberships: %w", err)
+	}
+	combined, _ := res.RowsAffected()
+	if _, err := tx.ExecContext(ctx, `UPDATE chama_members SET user_id = ? WHERE user_id = ?`, keepID, mergeID); err != nil {
+		return 0, fmt.Errorf("failed to move memberships: %w", err)
+	}
+	return int(combined), nil
+}
+
+// ledgerChamas returns the chamas whose ledgers hold entries for the member
+func ledgerChamas(ctx context.Context, tx *sql.Tx, memberID string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT chama_id FROM `+ledger.History+` WHERE member_id = ? ORDER BY chama_id`, memberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,86 @@
+// Package etag adds ETags to read endpoints so clients can revalidate
+// instead of downloading unchanged data again. The tag is a hash of the
+// response body; a request whose If-None-Match holds it gets an empty 304
+// Not Modified. The body is still built on the server, so this saves
+// bandwidth rather than work, which is what matters to members paying for
+// mobile data.
+package etag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// recorder holds back the response so its tag can be set before it is sent
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// Handler tags next's successful GET and HEAD responses and answers
+// conditional requests. Responses are per user, so they are marked private
+// and must be revalidated on every use.
+func Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next(w, r)
+			return
+		}
+		rec := &recorder{header: w.Header()}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status != http.StatusOK || w.Header().Get("ETag") != "" {
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(rec.body.Bytes())
+		tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", tag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if Match(r.Header.Get("If-None-Match"), tag) {
+			for _, h := range []string{"Content-Type", "Content-Length", "Content-Disposition"} {
+				w.Header().Del(h)
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(rec.body.Bytes())
+	}
+}
+
+// Match reports whether an If-None-Match header value matches tag, using
+// the weak comparison RFC 9110 requires for If-None-Match
+func Match(header, tag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package etag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	const tag = `"abc"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{``, false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{` * `, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz",W/"abc"`, true},
+		{`"xyz", "abcd"`, false},
+		{`abc`, false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.header, tag); got != tt.want {
+			t.Errorf("Match(%q, %s) = %v, want %v", tt.header, tag, got, tt.want)
+		}
+	}
+	if !Match(`"abc"`, `W/"abc"`) {
+		t.Error(`Match("abc", W/"abc") = false, want true`)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	body := `{"balance":100}`
+	// the tag of body, from a first unconditional request
+	rec := httptest.NewRecorder()
+	Handler(respond(http.StatusOK, body))(rec, httptest.NewRequest(http.MethodGet, "/api/chamas", nil))
+	tag := rec.Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("200 response was not tagged")
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		status      int
+		ifNoneMatch string
+		wantStatus  int
+		wantTag     bool
+		wantBody    string
+	}{
+		{"no If-None-Match", http.MethodGet, http.StatusOK, "", http.StatusOK, true, body},
+		{"matching tag", http.MethodGet, http.StatusOK, tag, http.StatusNotModified, true, ""},
+		{"weak tag", http.MethodGet, http.StatusOK, "W/" + tag, http.StatusNotModified, true, ""},
+		{"any tag", http.MethodGet, http.StatusOK, "*", http.StatusNotModified, true, ""},
+		{"list of tags", http.MethodGet, http.StatusOK, `"stale", ` + tag, http.StatusNotModified, true, ""},
+		{"stale tag", http.MethodGet, http.StatusOK, `"stale"`, http.StatusOK, true, body},
+		{"head", http.MethodHead, http.StatusOK, tag, http.StatusNotModified, true, ""},
+		{"not found", http.MethodGet, http.StatusNotFound, "*", http.StatusNotFound, false, body},
+		{"created", http.MethodGet, http.StatusCreated, tag, http.StatusCreated, false, body},
+		{"post", http.MethodPost, http.StatusOK, tag, http.StatusOK, false, body},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/chamas", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			Handler(respond(tt.status, body))(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("ETag"); (got != "") != tt.wantTag || (tt.wantTag && got != tag) {
+				t.Errorf("ETag = %q, want tagged %v", got, tt.wantTag)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			if tt.wantStatus == http.StatusNotModified && rec.Header().Get("Content-Type") != "" {
+				t.Errorf("304 sent Content-Type %q", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func respond(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+		}
+		w.Write([]byte(body))
+	}
+}
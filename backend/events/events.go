@@ -0,0 +1,194 @@
+// Package events publishes domain events, such as payments received and
+// loans disbursed, to a message broker for external analytics and fraud
+// systems. Events are written to event_outbox in the same transaction as the
+// change they describe, and a Relay publishes them in order, retrying until
+// the broker acknowledges each one. Delivery is at least once: consumers
+// should drop events whose ID they have already seen.
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+)
+
+// Source identifies this app in every event
+const Source = "tujifund"
+
+// Event types
+const (
+	PaymentContribution = "payment.contribution_received"
+	PaymentShares       = "payment.shares_purchased"
+	PaymentWithdrawal   = "payment.withdrawal_paid"
+	PaymentDistribution = "payment.distribution_paid"
+	PaymentExpense      = "payment.expense_paid"
+	LoanDisbursed       = "loan.disbursed"
+	LoanRepayment       = "loan.repayment_received"
+	LoanInterest        = "loan.interest_accrued"
+)
+
+// Schemas holds the current schema version of each event type's data.
+// Bump a type's version whenever its data changes in a way consumers could
+// notice, and describe the change in docs/EVENTS.md.
+var Schemas = map[string]int{
+	PaymentContribution: 1,
+	PaymentShares:       1,
+	PaymentWithdrawal:   1,
+	PaymentDistribution: 1,
+	PaymentExpense:      1,
+	LoanDisbursed:       1,
+	LoanRepayment:       1,
+	LoanInterest:        1,
+}
+
+// Event is one domain event to publish
+type Event struct {
+	ID         string
+	Type       string
+	ChamaID    string
+	OccurredAt time.Time
+	Data       interface{}
+}
+
+// Envelope is what is published for an event
+type Envelope struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schemaVersion"`
+	Source        string          `json:"source"`
+	ChamaID       string          `json:"chamaId"`
+	OccurredAt    time.Time       `json:"occurredAt"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// Emit adds e to the outbox inside tx, so it is published if and only if tx
+// commits. Emitting an event ID twice is a no-op.
+func Emit(ctx context.Context, tx *sql.Tx, e Event) error {
+	version, ok := Schemas[e.Type]
+	if !ok {
+		return fmt.Errorf("event type %q has no schema version", e.Type)
+	}
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(Envelope{
+		ID:            e.ID,
+		Type:          e.Type,
+		SchemaVersion: version,
+		Source:        Source,
+		ChamaID:       e.ChamaID,
+		OccurredAt:    e.OccurredAt.UTC(),
+		Data:          data,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO event_outbox (id, event_type, schema_version, chama_id, payload) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`,
+		e.ID, e.Type, version, e.ChamaID, string(payload))
+	if err != nil {
+		return fmt.Errorf("failed to add event to outbox: %w", err)
+	}
+	return nil
+}
+
+// ledgerTypes maps the ledger entry types that are payments or loan
+// activity to the events they raise
+var ledgerTypes = map[string]string{
+	ledger.TypeContribution:     PaymentContribution,
+	ledger.TypeShareCapital:     PaymentShares,
+	ledger.TypeWithdrawal:       PaymentWithdrawal,
+	ledger.TypeDistribution:     PaymentDistribution,
+	ledger.TypeExpense:          PaymentExpense,
+	ledger.TypeLoanDisbursement: LoanDisbursed,
+	ledger.TypeLoanRepayment:    LoanRepayment,
+	ledger.TypeLoanInterest:     LoanInterest,
+}
+
+// EntryData is the data of events raised from ledger entries (schema version 1)
+type EntryData struct {
+	EntryID     string      `json:"entryId"`
+	AccountID   string      `json:"accountId"`
+	MemberID    string      `json:"memberId,omitempty"`
+	EntryType   string      `json:"entryType"`
+	Amount      money.Money `json:"amount"`
+	Reference   string      `json:"reference,omitempty"`
+	EffectiveAt time.Time   `json:"effectiveAt"`
+}
+
+// project emits the event e raises, if any. The event takes the entry's ID,
+// so replaying the ledger does not raise it again.
+func project(ctx context.Context, tx *sql.Tx, e ledger.Entry) error {
+	eventType, ok := ledgerTypes[e.Type]
+	if !ok {
+		return nil
+	}
+	return Emit(ctx, tx, Event{
+		ID:         e.ID,
+		Type:       eventType,
+		ChamaID:    e.ChamaID,
+		OccurredAt: e.EffectiveAt,
+		Data: EntryData{
+			EntryID:     e.ID,
+			AccountID:   e.AccountID,
+			MemberID:    e.MemberID,
+			EntryType:   e.Type,
+			Amount:      e.Amount,
+			Reference:   e.Reference,
+			EffectiveAt: e.EffectiveAt.UTC(),
+		},
+	})
+}
+
+// RegisterLedgerEvents raises payment and loan events as entries are posted.
+// Only register it when a Relay runs, or the outbox grows without bound.
+func RegisterLedgerEvents() {
+	ledger.Projections = append(ledger.Projections, project)
+}
+
+// Topics routes event types to broker topics (subjects in NATS)
+type Topics struct {
+	// Prefix is put before an event's domain ("payment", "loan") to make
+	// its default topic, e.g. "tujifund.payment"
+	Prefix string
+	// Routes override the default topic for an event type, or for every
+	// type in a domain
+	Routes map[string]string
+}
+
+// Topic returns the topic eventType is published to
+func (t Topics) Topic(eventType string) string {
+	if topic, ok := t.Routes[eventType]; ok {
+		return topic
+	}
+	domain, _, _ := strings.Cut(eventType, ".")
+	if topic, ok := t.Routes[domain]; ok {
+		return topic
+	}
+	return t.Prefix + domain
+}
+
+// ParseTopics reads routes written as "loan=fraud.loans,payment.expense_paid=analytics.expenses"
+func ParseTopics(prefix, routes string) (Topics, error) {
+	t := Topics{Prefix: prefix, Routes: map[string]string{}}
+	for _, route := range strings.Split(routes, ",") {
+		if strings.TrimSpace(route) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(route, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return t, fmt.Errorf("invalid topic route %q", route)
+		}
+		t.Routes[from] = to
+	}
+	return t, nil
+}
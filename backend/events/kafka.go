@@ -0,0 +1,81 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KafkaREST publishes to Kafka through a REST proxy (the Confluent v2 API).
+// The proxy replies once the brokers have the record, and records are keyed
+// by chama so one chama's events land in one partition, in order. Kafka has
+// no broker-side deduplication here, so consumers drop repeated event IDs.
+type KafkaREST struct {
+	URL      string // e.g. https://kafka-rest.internal:8082
+	Username string // optional basic auth
+	Password string
+	Timeout  time.Duration
+	Client   *http.Client
+}
+
+// Publish sends m to topic and waits for its offset
+func (k *KafkaREST) Publish(ctx context.Context, topic string, m Message) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"key": m.Key, "value": json.RawMessage(m.Payload)}},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, k.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(k.URL, "/")+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if k.Username != "" {
+		req.SetBasicAuth(k.Username, k.Password)
+	}
+
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Kafka REST proxy: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Kafka REST proxy returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var result struct {
+		Offsets []struct {
+			Offset *int64  `json:"offset"`
+			Error  *string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("unexpected Kafka REST proxy reply: %w", err)
+	}
+	if len(result.Offsets) != 1 {
+		return fmt.Errorf("Kafka REST proxy acknowledged %d records, not 1", len(result.Offsets))
+	}
+	if o := result.Offsets[0]; o.Error != nil || o.Offset == nil {
+		msg := "no offset"
+		if o.Error != nil {
+			msg = *o.Error
+		}
+		return fmt.Errorf("Kafka rejected the record: %s", msg)
+	}
+	return nil
+}
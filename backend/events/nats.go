@@ -0,0 +1,196 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NATS publishes to a NATS JetStream stream over the client protocol. Each
+// publish waits for the stream's acknowledgement, and carries the event ID
+// as Nats-Msg-Id so JetStream drops a redelivery within its duplicate window.
+// A stream must be configured on the servers to capture the subjects.
+type NATS struct {
+	URL     string // nats://[user:pass@]host:4222, or tls:// for TLS
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	conn  net.Conn
+	r     *bufio.Reader
+	inbox string
+	n     int
+}
+
+// Publish sends m to subject and waits for JetStream to store it
+func (n *NATS) Publish(ctx context.Context, subject string, m Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+	err := n.publish(ctx, subject, m)
+	if err != nil {
+		// Start afresh next time rather than read a stale reply
+		n.conn.Close()
+		n.conn = nil
+	}
+	return err
+}
+
+func (n *NATS) deadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(n.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	return deadline
+}
+
+func (n *NATS) connect(ctx context.Context) error {
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		return fmt.Errorf("invalid NATS URL: %w", err)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	dialer := &net.Dialer{Deadline: n.deadline(ctx)}
+	var conn net.Conn
+	if u.Scheme == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	conn.SetDeadline(n.deadline(ctx))
+	r := bufio.NewReader(conn)
+
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q: %v", line, err)
+	}
+	options := map[string]interface{}{
+		"verbose": false, "pedantic": false, "name": Source, "lang": "go", "version": "1",
+		"protocol": 1, "headers": true, "no_responders": true,
+	}
+	if u.User != nil {
+		options["user"] = u.User.Username()
+		options["pass"], _ = u.User.Password()
+	}
+	connect, _ := json.Marshal(options)
+	inbox := "_INBOX." + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nSUB %s.* 1\r\nPING\r\n", connect, inbox); err != nil {
+		conn.Close()
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return fmt.Errorf("NATS refused the connection: %s", strings.TrimSpace(line))
+		}
+		if strings.HasPrefix(line, "PONG") {
+			break
+		}
+	}
+	n.conn, n.r, n.inbox = conn, r, inbox
+	return nil
+}
+
+func (n *NATS) publish(ctx context.Context, subject string, m Message) error {
+	n.conn.SetDeadline(n.deadline(ctx))
+	n.n++
+	reply := n.inbox + "." + strconv.Itoa(n.n)
+	headers := "NATS/1.0\r\nNats-Msg-Id: " + m.ID + "\r\n\r\n"
+	_, err := fmt.Fprintf(n.conn, "HPUB %s %s %d %d\r\n%s%s\r\n",
+		subject, reply, len(headers), len(headers)+len(m.Payload), headers, m.Payload)
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PING":
+			if _, err := io.WriteString(n.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("NATS: " + strings.TrimPrefix(line, "-ERR "))
+		case strings.HasPrefix(line, "MSG "), strings.HasPrefix(line, "HMSG "):
+			hdr, body, to, err := n.readMsg(line)
+			if err != nil {
+				return err
+			}
+			if to != reply {
+				continue // an ack for an earlier publish we gave up on
+			}
+			if strings.Contains(hdr, " 503") {
+				return fmt.Errorf("no JetStream stream captures subject %s", subject)
+			}
+			var ack struct {
+				Stream string `json:"stream"`
+				Error  *struct {
+					Description string `json:"description"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(body, &ack); err != nil {
+				return fmt.Errorf("unexpected JetStream reply %q", body)
+			}
+			if ack.Error != nil {
+				return errors.New("JetStream: " + ack.Error.Description)
+			}
+			return nil
+		}
+	}
+}
+
+// readMsg reads the rest of a MSG or HMSG, returning its headers, body and subject
+func (n *NATS) readMsg(line string) (string, []byte, string, error) {
+	fields := strings.Fields(line)
+	var hdrLen, total int
+	var err error
+	switch {
+	case fields[0] == "MSG" && len(fields) >= 4:
+		total, err = strconv.Atoi(fields[len(fields)-1])
+	case fields[0] == "HMSG" && len(fields) >= 5:
+		if hdrLen, err = strconv.Atoi(fields[len(fields)-2]); err == nil {
+			total, err = strconv.Atoi(fields[len(fields)-1])
+		}
+	default:
+		return "", nil, "", fmt.Errorf("malformed NATS message %q", line)
+	}
+	if err != nil || hdrLen > total {
+		return "", nil, "", fmt.Errorf("malformed NATS message %q", line)
+	}
+	buf := make([]byte, total+2) // and the trailing CRLF
+	if _, err := io.ReadFull(n.r, buf); err != nil {
+		return "", nil, "", err
+	}
+	return string(buf[:hdrLen]), buf[hdrLen:total], fields[1], nil
+}
@@ -0,0 +1,207 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"tujifund-app/backend/jobs"
+)
+
+// Message is one event as handed to a broker
+type Message struct {
+	ID      string // the event ID, for the broker's or consumer's deduplication
+	Key     string // the chama ID, so one chama's events stay in order
+	Payload []byte // the JSON Envelope
+}
+
+// Publisher sends messages to a broker. Publish returns only once the broker
+// has accepted the message, so that a nil error means it will be delivered.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, m Message) error
+}
+
+// Relay publishes the outbox
+type Relay struct {
+	DB        *sql.DB
+	Publisher Publisher
+	Topics    Topics
+	Interval  time.Duration
+	BatchSize int
+}
+
+// FromEnv configures a relay to the broker named by EVENT_BROKER ("nats" or
+// "kafka"), or returns nil when it is not set. NATS_URL or KAFKA_REST_URL
+// (with KAFKA_REST_USERNAME and KAFKA_REST_PASSWORD) locate the broker.
+// EVENT_TOPIC_PREFIX (default "tujifund.") and EVENT_TOPICS route events to
+// topics, and EVENT_RELAY_INTERVAL (default 5s) sets how often the outbox is
+// checked.
+func FromEnv(db *sql.DB) (*Relay, error) {
+	r := &Relay{DB: db, Interval: 5 * time.Second, BatchSize: 100}
+	switch os.Getenv("EVENT_BROKER") {
+	case "":
+		return nil, nil
+	case "nats":
+		if os.Getenv("NATS_URL") == "" {
+			return nil, fmt.Errorf("NATS_URL is required")
+		}
+		r.Publisher = &NATS{URL: os.Getenv("NATS_URL"), Timeout: 10 * time.Second}
+	case "kafka":
+		if os.Getenv("KAFKA_REST_URL") == "" {
+			return nil, fmt.Errorf("KAFKA_REST_URL is required")
+		}
+		r.Publisher = &KafkaREST{
+			URL:      os.Getenv("KAFKA_REST_URL"),
+			Username: os.Getenv("KAFKA_REST_USERNAME"),
+			Password: os.Getenv("KAFKA_REST_PASSWORD"),
+			Timeout:  10 * time.Second,
+		}
+	default:
+		return nil, fmt.Errorf("unknown event broker %q", os.Getenv("EVENT_BROKER"))
+	}
+
+	prefix, ok := os.LookupEnv("EVENT_TOPIC_PREFIX")
+	if !ok {
+		prefix = Source + "."
+	}
+	var err error
+	if r.Topics, err = ParseTopics(prefix, os.Getenv("EVENT_TOPICS")); err != nil {
+		return nil, fmt.Errorf("invalid EVENT_TOPICS: %w", err)
+	}
+	if v := os.Getenv("EVENT_RELAY_INTERVAL"); v != "" {
+		if r.Interval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid EVENT_RELAY_INTERVAL: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// Run publishes the outbox every Interval until ctx is done
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := r.Publish(ctx); err != nil {
+				slog.ErrorContext(ctx, "Failed to publish events", "error", err)
+			}
+		}
+	}
+}
+
+// Publish sends unpublished events in the order they were emitted and
+// returns how many were sent. It stops at the first the broker does not
+// accept, so that no event overtakes an earlier one; that event is retried
+// on the next run.
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		type pending struct {
+			seq                    int64
+			id, eventType, chamaID string
+			payload                string
+		}
+		rows, err := r.DB.QueryContext(ctx, `
+			SELECT seq, id, event_type, chama_id, payload FROM event_outbox
+			WHERE published_at IS NULL ORDER BY seq LIMIT ?`, r.BatchSize)
+		if err != nil {
+			return sent, err
+		}
+		var batch []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.seq, &p.id, &p.eventType, &p.chamaID, &p.payload); err != nil {
+				rows.Close()
+				return sent, err
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return sent, err
+		}
+		if len(batch) == 0 {
+			return sent, nil
+		}
+
+		for _, p := range batch {
+			err := r.Publisher.Publish(ctx, r.Topics.Topic(p.eventType), Message{ID: p.id, Key: p.chamaID, Payload: []byte(p.payload)})
+			if err != nil {
+				if _, dbErr := r.DB.ExecContext(ctx, `
+					UPDATE event_outbox SET attempts = attempts + 1, last_error = ? WHERE seq = ?`,
+					err.Error(), p.seq); dbErr != nil {
+					slog.ErrorContext(ctx, "Failed to record event publish failure", "event", p.id, "error", dbErr)
+				}
+				return sent, fmt.Errorf("event %s: %w", p.id, err)
+			}
+			_, err = r.DB.ExecContext(ctx, `
+				UPDATE event_outbox SET attempts = attempts + 1, last_error = NULL, published_at = CURRENT_TIMESTAMP
+				WHERE seq = ?`, p.seq)
+			if err != nil {
+				// The event is sent again next run, which at-least-once delivery allows
+				return sent, err
+			}
+			sent++
+		}
+	}
+}
+
+// RetainDays is how long published events stay in the outbox, for
+// troubleshooting consumers
+const RetainDays = 7
+
+// Prune deletes events published more than RetainDays ago
+func Prune(ctx context.Context, db *sql.DB, now time.Time) (int, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM event_outbox WHERE published_at < ?`,
+		now.UTC().AddDate(0, 0, -RetainDays).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// RegisterPruneJob prunes the outbox nightly
+func RegisterPruneJob(s *jobs.Scheduler, db *sql.DB) {
+	s.Register("event_outbox_prune", jobs.Daily{Hour: 3}, func(ctx context.Context) error {
+		_, err := Prune(ctx, db, time.Now())
+		return err
+	})
+}
+
+// Status summarises the outbox for platform staff
+type Status struct {
+	Pending       int        `json:"pending"`
+	OldestPending *time.Time `json:"oldestPending,omitempty"`
+	Attempts      int        `json:"attempts"` // made on the oldest pending event
+	LastError     string     `json:"lastError,omitempty"`
+	Published24h  int        `json:"published24h"`
+}
+
+// GetStatus reports how far behind the relay is
+func GetStatus(ctx context.Context, db *sql.DB, now time.Time) (Status, error) {
+	var s Status
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM event_outbox WHERE published_at IS NULL`).Scan(&s.Pending)
+	if err != nil {
+		return s, err
+	}
+	if s.Pending > 0 {
+		var oldest time.Time
+		err := db.QueryRowContext(ctx, `
+			SELECT created_at, attempts, COALESCE(last_error, '') FROM event_outbox
+			WHERE published_at IS NULL ORDER BY seq LIMIT 1`).Scan(&oldest, &s.Attempts, &s.LastError)
+		if err != nil {
+			return s, err
+		}
+		s.OldestPending = &oldest
+	}
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM event_outbox WHERE published_at >= ?`,
+		now.UTC().Add(-24*time.Hour).Format("2006-01-02 15:04:05")).Scan(&s.Published24h)
+	return s, err
+}
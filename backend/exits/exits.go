@@ -0,0 +1,475 @@
+package exits
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/fines"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/loans"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/rules"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Exit statuses. A paid exit still has savings held back for loans the
+// member guarantees; it is closed once those are released.
+const (
+	StatusRequested = "requested"
+	StatusApproved  = "approved"
+	StatusRejected  = "rejected"
+	StatusCancelled = "cancelled"
+	StatusPaid      = "paid"
+	StatusClosed    = "closed"
+)
+
+var (
+	// ErrInProgress is returned when a member asks to leave while an earlier request is open
+	ErrInProgress = errors.New("member already has an exit in progress")
+	// ErrDecided is returned when deciding, cancelling or paying an exit in the wrong status
+	ErrDecided = errors.New("exit is not in a status that allows this")
+	// ErrSelfApproval is returned when a member approves their own exit
+	ErrSelfApproval = errors.New("members cannot approve their own exit")
+	// ErrNoticePeriod is returned when paying a refund before the notice period is over
+	ErrNoticePeriod = errors.New("notice period has not ended")
+	// ErrOwes is returned when paying a member who owes more than their savings
+	ErrOwes = errors.New("member owes more than their savings; the balance must be paid first")
+	// ErrGuaranteeing is returned when releasing held savings while the member still guarantees loans
+	ErrGuaranteeing = errors.New("member still guarantees running loans")
+)
+
+// Refund is what a leaving member is due: their savings and share capital,
+// less loans and fines they owe. Savings backing loans they guarantee for
+// others are held back.
+type Refund struct {
+	Savings money.Money        `json:"savings"`
+	Shares  money.Money        `json:"shareCapital"`
+	Loans   money.Money        `json:"loans"`
+	Fines   money.Money        `json:"fines"`
+	Held    money.Money        `json:"held"`
+	Amount  money.Money        `json:"amount"` // negative when the member owes the chama
+	Settle  []loans.Settlement `json:"loanSettlements"`
+	Unpaid  []fines.Fine       `json:"unpaidFines"`
+}
+
+// Compute works out the refund due to memberID if they left today. Loans are
+// settled early under the chama's rebate rule.
+func Compute(ctx context.Context, db *sql.DB, chamaID, memberID string) (Refund, error) {
+	currency, err := money.ChamaCurrency(db, chamaID)
+	if err != nil {
+		return Refund{}, err
+	}
+	r := Refund{Savings: money.New(0, currency), Shares: money.New(0, currency), Loans: money.New(0, currency),
+		Fines: money.New(0, currency), Held: money.New(0, currency), Settle: []loans.Settlement{}}
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(CASE WHEN entry_type IN (?, ?, ?, ?, ?) THEN amount_minor END), 0),
+		       COALESCE(SUM(CASE WHEN entry_type = ? THEN amount_minor END), 0)
+		FROM ledger_entries WHERE chama_id = ? AND member_id = ?`,
+		ledger.TypeContribution, ledger.TypeOpeningBalance, ledger.TypeSavingsInterest, ledger.TypeDistribution,
+		ledger.TypeWithdrawal, ledger.TypeShareCapital, chamaID, memberID,
+	).Scan(&r.Savings.Amount, &r.Shares.Amount)
+	if err != nil {
+		return r, err
+	}
+
+	rl, err := rules.Current(ctx, db, chamaID)
+	if err != nil {
+		return r, err
+	}
+	running, err := loans.Running(ctx, db, chamaID)
+	if err != nil {
+		return r, err
+	}
+	now := time.Now().UTC()
+	for _, l := range running {
+		if l.BorrowerID != memberID {
+			continue
+		}
+		st, err := loans.Quote(ctx, db, l, rl.LoanRebateMethod, now)
+		if err != nil {
+			return r, err
+		}
+		r.Settle = append(r.Settle, st)
+		r.Loans.Amount += st.Total.Amount
+	}
+	if r.Unpaid, err = fines.List(ctx, db, chamaID, memberID, fines.StatusUnpaid); err != nil {
+		return r, err
+	}
+	for _, f := range r.Unpaid {
+		r.Fines.Amount += f.Amount.Amount
+	}
+
+	guaranteed, err := guarantees(ctx, db, chamaID, memberID)
+	if err != nil {
+		return r, err
+	}
+	available := r.Savings.Amount + r.Shares.Amount - r.Loans.Amount - r.Fines.Amount
+	r.Held.Amount = min(guaranteed, available, r.Savings.Amount)
+	if r.Held.Amount < 0 {
+		r.Held.Amount = 0
+	}
+	r.Amount = money.New(available-r.Held.Amount, currency)
+	return r, nil
+}
+
+// guarantees is how much of other members' running loans memberID guarantees
+func guarantees(ctx context.Context, db *sql.DB, chamaID, memberID string) (int64, error) {
+	var total int64
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(MIN(g.guarantee_minor, l.principal_minor - l.total_repaid_minor)), 0)
+		FROM loan_guarantors g JOIN loans l ON l.id = g.loan_id
+		WHERE g.guarantor_id = ? AND g.status = 'approved' AND l.chama_id = ? AND l.borrower_id != ?
+		  AND l.status IN (?, ?) AND l.deleted_at IS NULL AND l.principal_minor > l.total_repaid_minor`,
+		memberID, chamaID, memberID, loans.StatusActive, loans.StatusDefaulted).Scan(&total)
+	return total, err
+}
+
+// Exit is a member's request to leave a chama and have their savings refunded
+type Exit struct {
+	ID             string      `json:"id"`
+	ChamaID        string      `json:"chamaId"`
+	MemberID       string      `json:"memberId"`
+	MemberName     string      `json:"memberName"`
+	Reason         string      `json:"reason,omitempty"`
+	Status         string      `json:"status"`
+	EffectiveDate  string      `json:"effectiveDate"`
+	Savings        money.Money `json:"savings"`
+	Shares         money.Money `json:"shareCapital"`
+	Loans          money.Money `json:"loans"`
+	Fines          money.Money `json:"fines"`
+	Held           money.Money `json:"held"`
+	Refund         money.Money `json:"refund"`
+	AccountID      string      `json:"accountId,omitempty"`
+	RequestedBy    string      `json:"requestedBy"`
+	DecidedBy      string      `json:"decidedBy,omitempty"`
+	DecidedAt      string      `json:"decidedAt,omitempty"`
+	DecisionReason string      `json:"decisionReason,omitempty"`
+	PaidBy         string      `json:"paidBy,omitempty"`
+	PaidAt         string      `json:"paidAt,omitempty"`
+	ClosedAt       string      `json:"closedAt,omitempty"`
+	CreatedAt      string      `json:"createdAt"`
+}
+
+const columns = `e.id, e.chama_id, e.member_id, TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')),
+	COALESCE(e.reason, ''), e.status, e.effective_date, e.savings_minor, e.shares_minor, e.loans_minor, e.fines_minor,
+	e.held_minor, e.refund_minor, e.currency, COALESCE(e.account_id, ''), e.requested_by, COALESCE(e.decided_by, ''),
+	COALESCE(e.decided_at, ''), COALESCE(e.decision_reason, ''), COALESCE(e.paid_by, ''), COALESCE(e.paid_at, ''),
+	COALESCE(e.closed_at, ''), e.created_at
+	FROM member_exits e LEFT JOIN users u ON u.user_id = e.member_id`
+
+func scan(row interface{ Scan(...interface{}) error }) (Exit, error) {
+	var e Exit
+	var currency string
+	err := row.Scan(&e.ID, &e.ChamaID, &e.MemberID, &e.MemberName, &e.Reason, &e.Status, &e.EffectiveDate,
+		&e.Savings.Amount, &e.Shares.Amount, &e.Loans.Amount, &e.Fines.Amount, &e.Held.Amount, &e.Refund.Amount,
+		&currency, &e.AccountID, &e.RequestedBy, &e.DecidedBy, &e.DecidedAt, &e.DecisionReason, &e.PaidBy, &e.PaidAt,
+		&e.ClosedAt, &e.CreatedAt)
+	for _, m := range []*money.Money{&e.Savings, &e.Shares, &e.Loans, &e.Fines, &e.Held, &e.Refund} {
+		m.Currency = currency
+	}
+	if len(e.EffectiveDate) > 10 {
+		e.EffectiveDate = e.EffectiveDate[:10]
+	}
+	return e, err
+}
+
+// Get returns a single exit
+func Get(ctx context.Context, db *sql.DB, id string) (Exit, error) {
+	return scan(db.QueryRowContext(ctx, `SELECT `+columns+` WHERE e.id = ?`, id))
+}
+
+// List returns a chama's exits, newest first. status is an optional filter.
+func List(ctx context.Context, db *sql.DB, chamaID, status string) ([]Exit, error) {
+	query, args := `SELECT `+columns+` WHERE e.chama_id = ?`, []interface{}{chamaID}
+	if status != "" {
+		query += ` AND e.status = ?`
+		args = append(args, status)
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY e.created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Exit{}
+	for rows.Next() {
+		e, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// Request records memberID's notice to leave the chama. The refund is
+// worked out now for the record and again when it is paid.
+func Request(ctx context.Context, db *sql.DB, chamaID, memberID, reason string, entry audit.Entry) (Exit, error) {
+	var open int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM member_exits WHERE chama_id = ? AND member_id = ? AND status IN (?, ?)`,
+		chamaID, memberID, StatusRequested, StatusApproved).Scan(&open)
+	if err != nil {
+		return Exit{}, err
+	}
+	if open > 0 {
+		return Exit{}, ErrInProgress
+	}
+	rl, err := rules.Current(ctx, db, chamaID)
+	if err != nil {
+		return Exit{}, err
+	}
+	r, err := Compute(ctx, db, chamaID, memberID)
+	if err != nil {
+		return Exit{}, err
+	}
+
+	id := uuid.NewString()
+	effective := time.Now().UTC().AddDate(0, 0, rl.ExitNoticeDays).Format("2006-01-02")
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Exit{}, err
+	}
+	defer txn.Rollback(tx)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO member_exits
+		(id, chama_id, member_id, reason, status, effective_date, savings_minor, shares_minor, loans_minor, fines_minor,
+		 held_minor, refund_minor, currency, requested_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, chamaID, memberID, reason, StatusRequested, effective, r.Savings.Amount, r.Shares.Amount, r.Loans.Amount,
+		r.Fines.Amount, r.Held.Amount, r.Amount.Amount, r.Amount.Currency, entry.UserID)
+	if err != nil {
+		return Exit{}, fmt.Errorf("failed to record exit: %w", err)
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "member_exit.request", "member_exit", id
+	entry.NewValues = map[string]interface{}{"memberId": memberID, "effectiveDate": effective, "refund": r.Amount}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return Exit{}, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return Exit{}, err
+	}
+	return Get(ctx, db, id)
+}
+
+// Approve approves a requested exit. The member cannot approve their own.
+func Approve(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Exit, error) {
+	return decide(ctx, db, id, StatusApproved, "", entry)
+}
+
+// Reject turns a requested exit down
+func Reject(ctx context.Context, db *sql.DB, id, reason string, entry audit.Entry) (Exit, error) {
+	return decide(ctx, db, id, StatusRejected, reason, entry)
+}
+
+func decide(ctx context.Context, db *sql.DB, id, status, reason string, entry audit.Entry) (Exit, error) {
+	e, err := Get(ctx, db, id)
+	if err != nil {
+		return e, err
+	}
+	if e.MemberID == entry.UserID {
+		return e, ErrSelfApproval
+	}
+	return e, update(ctx, db, e, []string{StatusRequested}, `
+		status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP, decision_reason = ?`,
+		[]interface{}{status, entry.UserID, reason}, "member_exit."+status, entry)
+}
+
+// Cancel withdraws an exit that has not been paid
+func Cancel(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Exit, error) {
+	e, err := Get(ctx, db, id)
+	if err != nil {
+		return e, err
+	}
+	return e, update(ctx, db, e, []string{StatusRequested, StatusApproved}, `status = ?`,
+		[]interface{}{StatusCancelled}, "member_exit.cancel", entry)
+}
+
+// update sets the exit's columns if it is in one of the from statuses, with an audit entry
+func update(ctx context.Context, db *sql.DB, e Exit, from []string, set string, args []interface{}, action string, entry audit.Entry) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback(tx)
+
+	args = append(args, e.ID)
+	for _, s := range from {
+		args = append(args, s)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(from)), ", ")
+	res, err := tx.ExecContext(ctx, `UPDATE member_exits SET `+set+` WHERE id = ? AND status IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrDecided
+	}
+	entry.Action, entry.EntityType, entry.EntityID = action, "member_exit", e.ID
+	entry.OldValues = map[string]string{"status": e.Status}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+	return txn.Commit(tx)
+}
+
+// Pay refunds an approved exit from accountID once the notice period is
+// over. The member's loans are settled and fines paid out of their savings,
+// the rest is paid out bar any amount held for loans they guarantee, their
+// shares are cancelled and their membership ends.
+func Pay(ctx context.Context, db *sql.DB, id, accountID string, entry audit.Entry) (Exit, error) {
+	e, err := Get(ctx, db, id)
+	if err != nil {
+		return e, err
+	}
+	if e.Status != StatusApproved {
+		return e, ErrDecided
+	}
+	if time.Now().UTC().Format("2006-01-02") < e.EffectiveDate {
+		return e, ErrNoticePeriod
+	}
+	r, err := Compute(ctx, db, e.ChamaID, e.MemberID)
+	if err != nil {
+		return e, err
+	}
+	if r.Amount.IsNegative() {
+		return e, ErrOwes
+	}
+	rl, err := rules.Current(ctx, db, e.ChamaID)
+	if err != nil {
+		return e, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return e, err
+	}
+	defer txn.Rollback(tx)
+
+	// Money owed comes in first, so the fund is not drawn down part way through
+	for _, st := range r.Settle {
+		_, err := loans.SettleTx(ctx, tx, st.LoanID, rl.LoanRebateMethod,
+			loans.Payment{Amount: st.Total, Method: "savings", Reference: e.ID}, audit.Entry{UserID: entry.UserID})
+		if err != nil {
+			return e, fmt.Errorf("failed to settle loan %s: %w", st.LoanID, err)
+		}
+	}
+	for _, f := range r.Unpaid {
+		if err := fines.PayTx(ctx, tx, f, accountID, entry.UserID); err != nil {
+			return e, fmt.Errorf("failed to pay fine %s: %w", f.ID, err)
+		}
+	}
+	now := time.Now().UTC()
+	legs := []ledger.Entry{
+		{Type: ledger.TypeWithdrawal, Amount: money.New(r.Held.Amount-r.Savings.Amount, r.Savings.Currency), Description: "Savings refunded on exit"},
+		{Type: ledger.TypeShareCapital, Amount: r.Shares.Negate(), Description: "Share capital refunded on exit"},
+	}
+	for _, l := range legs {
+		if l.Amount.IsZero() {
+			continue
+		}
+		l.ChamaID, l.AccountID, l.MemberID, l.Reference = e.ChamaID, accountID, e.MemberID, e.ID
+		l.EffectiveAt, l.CreatedBy = now, entry.UserID
+		if _, err := ledger.Post(ctx, tx, l); err != nil {
+			return e, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE member_shares SET shares_count = 0, total_value_minor = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE chama_id = ? AND member_id = ?`, e.ChamaID, e.MemberID); err != nil {
+		return e, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE chama_members SET status = 'inactive', updated_at = CURRENT_TIMESTAMP WHERE chama_id = ? AND user_id = ?`,
+		e.ChamaID, e.MemberID); err != nil {
+		return e, err
+	}
+
+	status, closedAt := StatusClosed, interface{}(now.Format("2006-01-02 15:04:05"))
+	if r.Held.Amount > 0 {
+		status, closedAt = StatusPaid, nil
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE member_exits SET status = ?, savings_minor = ?, shares_minor = ?, loans_minor = ?, fines_minor = ?,
+		       held_minor = ?, refund_minor = ?, account_id = ?, paid_by = ?, paid_at = ?, closed_at = ?
+		WHERE id = ? AND status = ?`,
+		status, r.Savings.Amount, r.Shares.Amount, r.Loans.Amount, r.Fines.Amount, r.Held.Amount, r.Amount.Amount,
+		accountID, entry.UserID, now.Format("2006-01-02 15:04:05"), closedAt, e.ID, StatusApproved)
+	if err != nil {
+		return e, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "member_exit.pay", "member_exit", e.ID
+	entry.NewValues = r
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return e, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return e, err
+	}
+	return Get(ctx, db, id)
+}
+
+// Release pays out savings held back on a paid exit once the member no
+// longer guarantees any running loans, and closes the exit
+func Release(ctx context.Context, db *sql.DB, id, accountID string, entry audit.Entry) (Exit, error) {
+	e, err := Get(ctx, db, id)
+	if err != nil {
+		return e, err
+	}
+	if e.Status != StatusPaid {
+		return e, ErrDecided
+	}
+	guaranteed, err := guarantees(ctx, db, e.ChamaID, e.MemberID)
+	if err != nil {
+		return e, err
+	}
+	if guaranteed > 0 {
+		return e, ErrGuaranteeing
+	}
+	r, err := Compute(ctx, db, e.ChamaID, e.MemberID)
+	if err != nil {
+		return e, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return e, err
+	}
+	defer txn.Rollback(tx)
+
+	now := time.Now().UTC()
+	if r.Savings.Amount > 0 {
+		_, err := ledger.Post(ctx, tx, ledger.Entry{
+			ChamaID: e.ChamaID, AccountID: accountID, MemberID: e.MemberID, Type: ledger.TypeWithdrawal,
+			Amount: r.Savings.Negate(), Reference: e.ID, Description: "Held savings released on exit",
+			EffectiveAt: now, CreatedBy: entry.UserID,
+		})
+		if err != nil {
+			return e, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE member_exits SET status = ?, refund_minor = refund_minor + ?, held_minor = 0, closed_at = ?
+		WHERE id = ? AND status = ?`,
+		StatusClosed, max(r.Savings.Amount, 0), now.Format("2006-01-02 15:04:05"), e.ID, StatusPaid)
+	if err != nil {
+		return e, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "member_exit.release", "member_exit", e.ID
+	entry.NewValues = map[string]interface{}{"released": r.Savings}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return e, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return e, err
+	}
+	return Get(ctx, db, id)
+}
@@ -0,0 +1,329 @@
+package exits
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/reports"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// managerRoles may approve exits and pay refunds
+var managerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// RequestHandler gives notice that a member is leaving the {chamaId} chama.
+// Members give their own notice; officials may give it for any member.
+func RequestHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+
+		var request struct {
+			MemberID string `json:"memberId"`
+			Reason   string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.MemberID == "" {
+			request.MemberID = userID
+		}
+		if request.MemberID != userID && !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !chamas.IsMember(db, chamaID, request.MemberID) {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+
+		e, err := Request(r.Context(), db, chamaID, request.MemberID, request.Reason, audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		notifyApprovers(r.Context(), db, notifier, e)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+	}
+}
+
+// ListHandler lists the {chamaId} chama's exits, optionally filtered by
+// ?status=. Officials only.
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := List(r.Context(), db, chamaID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// RefundHandler works out what {memberId} would be refunded if they left the
+// {chamaId} chama today. Members can see their own; officials anyone's.
+func RefundHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		vars := mux.Vars(r)
+		chamaID, memberID := vars["chamaId"], vars["memberId"]
+		if memberID != userID && !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		refund, err := Compute(r.Context(), db, chamaID, memberID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(refund)
+	}
+}
+
+// GetHandler returns the {exitId} exit
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := loadExit(db, w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e)
+	}
+}
+
+// ApproveHandler approves the requested {exitId} exit
+func ApproveHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		manageHandler(db, w, r, func(e Exit) (Exit, error) {
+			return Approve(r.Context(), db, e.ID, audit.FromRequest(r, audit.Entry{}))
+		})
+	}
+}
+
+// RejectHandler turns down the requested {exitId} exit with a reason
+func RejectHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		manageHandler(db, w, r, func(e Exit) (Exit, error) {
+			return Reject(r.Context(), db, e.ID, request.Reason, audit.FromRequest(r, audit.Entry{}))
+		})
+	}
+}
+
+// PayHandler refunds the approved {exitId} exit from the fund in the body
+func PayHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return payHandler(db, notifier, Pay)
+}
+
+// ReleaseHandler pays out savings held back on the {exitId} exit from the
+// fund in the body, once the member no longer guarantees any loans
+func ReleaseHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return payHandler(db, notifier, Release)
+}
+
+func payHandler(db *sql.DB, notifier *notifications.Notifier, pay func(context.Context, *sql.DB, string, string, audit.Entry) (Exit, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			AccountID string `json:"accountId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("accountId", request.AccountID)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		manageHandler(db, w, r, func(e Exit) (Exit, error) {
+			e, err := pay(r.Context(), db, e.ID, request.AccountID, audit.FromRequest(r, audit.Entry{}))
+			if err == nil && notifier != nil {
+				notifier.Notify(r.Context(), notifications.Notification{
+					UserID:    e.MemberID,
+					Title:     i18n.T(i18n.Default, "exit.paid", nil),
+					Message:   i18n.T(i18n.Default, "notification.exit_paid", map[string]string{"amount": e.Refund.String()}),
+					Type:      notifications.TypeChama,
+					RelatedID: e.ID,
+				})
+			}
+			return e, err
+		})
+	}
+}
+
+// manageHandler loads the {exitId} exit for one of the chama's managers and
+// applies change to it
+func manageHandler(db *sql.DB, w http.ResponseWriter, r *http.Request, change func(Exit) (Exit, error)) {
+	e, ok := loadExit(db, w, r)
+	if !ok {
+		return
+	}
+	if !chamas.HasRole(db, e.ChamaID, r.Context().Value("userID").(string), managerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	e, err := change(e)
+	if !writeError(w, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// CancelHandler withdraws the {exitId} exit. The member or an official may cancel it.
+func CancelHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := loadExit(db, w, r)
+		if !ok {
+			return
+		}
+		e, err := Cancel(r.Context(), db, e.ID, audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e)
+	}
+}
+
+// FinalStatement is a member's statement over their whole time in a chama,
+// with the exit that refunded them
+type FinalStatement struct {
+	*reports.MemberStatement
+	Exit Exit `json:"exit"`
+}
+
+// StatementHandler returns the final statement for the refunded {exitId}
+// exit as JSON, or PDF with ?format=pdf
+func StatementHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := loadExit(db, w, r)
+		if !ok {
+			return
+		}
+		if e.Status != StatusPaid && e.Status != StatusClosed {
+			http.Error(w, "Exit has not been paid", http.StatusConflict)
+			return
+		}
+
+		s, err := reports.BuildMemberStatement(r.Context(), db, e.ChamaID, e.MemberID, reports.Period{To: time.Now().UTC()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("format") == "pdf" {
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="final-statement.pdf"`)
+			reports.WriteStatementPDF(w, s, i18n.FromRequest(r))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FinalStatement{MemberStatement: s, Exit: e})
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrInProgress), errors.Is(err, ErrDecided), errors.Is(err, ErrSelfApproval),
+		errors.Is(err, ErrNoticePeriod), errors.Is(err, ErrGuaranteeing):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrOwes), errors.Is(err, ledger.ErrAccountClosed), errors.Is(err, ledger.ErrCurrencyMismatch),
+		errors.Is(err, ledger.ErrDebitNotAllowed), errors.Is(err, ledger.ErrInsufficientFunds):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// loadExit loads the {exitId} exit for the leaving member or an official of
+// its chama, writing an error response and returning false otherwise
+func loadExit(db *sql.DB, w http.ResponseWriter, r *http.Request) (Exit, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Exit{}, false
+	}
+	e, err := Get(r.Context(), db, mux.Vars(r)["exitId"])
+	if err == sql.ErrNoRows || (err == nil && e.MemberID != userID && !chamas.IsOfficial(db, e.ChamaID, userID)) {
+		http.Error(w, "Exit not found", http.StatusNotFound)
+		return e, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return e, false
+	}
+	return e, true
+}
+
+// notifyApprovers asks the chama's managers to review e
+func notifyApprovers(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, e Exit) {
+	if notifier == nil {
+		return
+	}
+	approvers, err := chamas.MembersWithRole(db, e.ChamaID, managerRoles...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load exit approvers", "exit_id", e.ID, "error", err)
+		return
+	}
+	params := map[string]string{"member": e.MemberName, "date": e.EffectiveDate}
+	for _, id := range approvers {
+		if id == e.MemberID {
+			continue
+		}
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "exit.requested", nil),
+			Message:   i18n.T(i18n.Default, "notification.exit_requested", params),
+			Type:      notifications.TypeChama,
+			RelatedID: e.ID,
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package expenses
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/money"
+
+	"github.com/google/uuid"
+)
+
+// Budget alert levels
+const (
+	AlertNone        = 0
+	AlertApproaching = 1
+	AlertExceeded    = 2
+)
+
+// DefaultAlertBps is the share of a budget at which members are warned
+const DefaultAlertBps = 8000
+
+// Budget is a monthly spending limit for one category
+type Budget struct {
+	ID       string      `json:"id"`
+	ChamaID  string      `json:"chamaId"`
+	Category string      `json:"category"`
+	Period   string      `json:"period"`
+	Amount   money.Money `json:"amount"`
+	AlertBps int64       `json:"alertBps"`
+}
+
+// Usage is a category's spending against its budget for a period. Budget is
+// zero for categories with spending but no budget.
+type Usage struct {
+	Category   string      `json:"category"`
+	Period     string      `json:"period"`
+	Budget     money.Money `json:"budget"`
+	Spent      money.Money `json:"spent"`
+	Pending    money.Money `json:"pending"`
+	Remaining  money.Money `json:"remaining"`
+	UsedBps    int64       `json:"usedBps"`
+	AlertBps   int64       `json:"alertBps"`
+	AlertLevel int         `json:"alertLevel"`
+}
+
+// PeriodOf returns the budget period (YYYY-MM) containing t
+func PeriodOf(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// periodRange returns the half-open time range covered by a YYYY-MM period
+func periodRange(period string) (time.Time, time.Time, error) {
+	from, err := time.Parse("2006-01", period)
+	if err != nil {
+		return from, from, fmt.Errorf("invalid budget period %q", period)
+	}
+	return from, from.AddDate(0, 1, 0), nil
+}
+
+// SetBudget creates or replaces the budget for a category and period. Changing
+// the amount re-arms its alerts.
+func SetBudget(ctx context.Context, db *sql.DB, b Budget, updatedBy string) (Budget, error) {
+	if _, _, err := periodRange(b.Period); err != nil {
+		return b, err
+	}
+	if b.AlertBps <= 0 || b.AlertBps > 10000 {
+		b.AlertBps = DefaultAlertBps
+	}
+	b.ID = uuid.NewString()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO expense_budgets (id, chama_id, category, period, amount_minor, currency, alert_bps, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(chama_id, category, period) DO UPDATE SET
+		    amount_minor = excluded.amount_minor, currency = excluded.currency, alert_bps = excluded.alert_bps,
+		    alert_level = 0, updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP`,
+		b.ID, b.ChamaID, b.Category, b.Period, b.Amount.Amount, b.Amount.Currency, b.AlertBps, updatedBy,
+	)
+	if err != nil {
+		return b, fmt.Errorf("failed to save budget: %w", err)
+	}
+	err = db.QueryRowContext(ctx, `SELECT id FROM expense_budgets WHERE chama_id = ? AND category = ? AND period = ?`,
+		b.ChamaID, b.Category, b.Period).Scan(&b.ID)
+	return b, err
+}
+
+// BudgetUsage reports spending against budget for every category that has a
+// budget or any expenses in the period
+func BudgetUsage(ctx context.Context, db *sql.DB, chamaID, period string) ([]Usage, error) {
+	from, to, err := periodRange(period)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := money.ChamaCurrency(db, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.category, COALESCE(b.amount_minor, 0), COALESCE(b.alert_bps, ?), COALESCE(b.alert_level, 0),
+		       COALESCE((SELECT SUM(amount_minor) FROM expenses e WHERE e.chama_id = ? AND e.category = c.category
+		                 AND e.status = 'approved' AND e.incurred_at >= ? AND e.incurred_at < ?), 0),
+		       COALESCE((SELECT SUM(amount_minor) FROM expenses e WHERE e.chama_id = ? AND e.category = c.category
+		                 AND e.status = 'pending' AND e.incurred_at >= ? AND e.incurred_at < ?), 0)
+		FROM (SELECT category FROM expense_budgets WHERE chama_id = ? AND period = ?
+		      UNION
+		      SELECT category FROM expenses WHERE chama_id = ? AND status != 'rejected' AND incurred_at >= ? AND incurred_at < ?) c
+		LEFT JOIN expense_budgets b ON b.chama_id = ? AND b.category = c.category AND b.period = ?
+		ORDER BY c.category`,
+		DefaultAlertBps,
+		chamaID, from.Format(timeLayout), to.Format(timeLayout),
+		chamaID, from.Format(timeLayout), to.Format(timeLayout),
+		chamaID, period,
+		chamaID, from.Format(timeLayout), to.Format(timeLayout),
+		chamaID, period,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Usage{}
+	for rows.Next() {
+		u := Usage{Period: period}
+		u.Budget.Currency, u.Spent.Currency, u.Pending.Currency = currency, currency, currency
+		if err := rows.Scan(&u.Category, &u.Budget.Amount, &u.AlertBps, &u.AlertLevel, &u.Spent.Amount, &u.Pending.Amount); err != nil {
+			return nil, err
+		}
+		u.Remaining, _ = u.Budget.Sub(u.Spent)
+		if u.Budget.Amount > 0 {
+			u.UsedBps = u.Spent.Amount * 10000 / u.Budget.Amount
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+// level returns the alert level u has reached
+func (u Usage) level() int {
+	switch {
+	case u.Budget.Amount <= 0:
+		return AlertNone
+	case u.Spent.Amount > u.Budget.Amount:
+		return AlertExceeded
+	case u.UsedBps >= u.AlertBps:
+		return AlertApproaching
+	}
+	return AlertNone
+}
+
+// raiseAlert moves the category's budget to the level u has reached. It
+// reports whether the level went up, so each alert is only sent once.
+func raiseAlert(ctx context.Context, db *sql.DB, chamaID string, u Usage) (bool, error) {
+	level := u.level()
+	if level <= u.AlertLevel {
+		return false, nil
+	}
+	res, err := db.ExecContext(ctx, `
+		UPDATE expense_budgets SET alert_level = ?
+		WHERE chama_id = ? AND category = ? AND period = ? AND alert_level < ?`,
+		level, chamaID, u.Category, u.Period, level)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
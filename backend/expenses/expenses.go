@@ -0,0 +1,333 @@
+package expenses
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/approvals"
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Expense categories
+const (
+	CategoryAdministration = "administration"
+	CategoryMeetings       = "meetings"
+	CategoryTransport      = "transport"
+	CategoryBankCharges    = "bank_charges"
+	CategoryProfessional   = "professional_fees"
+	CategoryWelfare        = "welfare"
+	CategoryOther          = "other"
+)
+
+// Categories lists the accepted expense categories
+var Categories = []string{CategoryAdministration, CategoryMeetings, CategoryTransport, CategoryBankCharges,
+	CategoryProfessional, CategoryWelfare, CategoryOther}
+
+// Expense statuses
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+var (
+	// ErrDecided is returned when approving or rejecting an expense that is no longer pending
+	ErrDecided = errors.New("expense has already been decided")
+	// ErrSelfApproval is returned when the requester tries to approve their own expense
+	ErrSelfApproval = errors.New("expenses must be approved by someone other than the requester")
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// Expense is money the chama spends
+type Expense struct {
+	ID              string      `json:"id"`
+	ChamaID         string      `json:"chamaId"`
+	AccountID       string      `json:"accountId"`
+	Category        string      `json:"category"`
+	Description     string      `json:"description"`
+	Payee           string      `json:"payee,omitempty"`
+	Amount          money.Money `json:"amount"`
+	IncurredAt      time.Time   `json:"incurredAt"`
+	Status          string      `json:"status"`
+	StorageKey      string      `json:"-"`
+	MimeType        string      `json:"mimeType,omitempty"`
+	HasAttachment   bool        `json:"hasAttachment"`
+	RequestedBy     string      `json:"requestedBy"`
+	DecidedBy       string      `json:"decidedBy,omitempty"`
+	DecidedAt       string      `json:"decidedAt,omitempty"`
+	RejectionReason string      `json:"rejectionReason,omitempty"`
+	LedgerEntryID   string      `json:"ledgerEntryId,omitempty"`
+	CreatedAt       time.Time   `json:"createdAt"`
+}
+
+// Create records a pending expense. When its amount falls in one of the
+// chama's approval tiers, the approvals it needs are recorded with it.
+func Create(ctx context.Context, db *sql.DB, e Expense) (Expense, error) {
+	if e.Amount.Amount <= 0 {
+		return e, errors.New("expense amount must be positive")
+	}
+	e.ID = uuid.NewString()
+	if e.IncurredAt.IsZero() {
+		e.IncurredAt = time.Now().UTC()
+	}
+	tier, tiered, err := approvals.Tier(ctx, db, e.ChamaID, e.Amount)
+	if err != nil {
+		return e, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return e, err
+	}
+	defer txn.Rollback(tx)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO expenses (id, chama_id, account_id, category, description, payee, amount_minor, currency,
+		                      incurred_at, storage_key, mime_type, requested_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.ChamaID, e.AccountID, e.Category, e.Description, nullIfEmpty(e.Payee), e.Amount.Amount, e.Amount.Currency,
+		e.IncurredAt.UTC().Format(timeLayout), nullIfEmpty(e.StorageKey), nullIfEmpty(e.MimeType), e.RequestedBy,
+	)
+	if err != nil {
+		return e, fmt.Errorf("failed to record expense: %w", err)
+	}
+	if tiered {
+		_, err := approvals.Open(ctx, tx, approvals.Request{
+			ChamaID: e.ChamaID, SubjectType: approvals.SubjectExpense, SubjectID: e.ID, Amount: e.Amount,
+			RequestedBy: e.RequestedBy,
+		}, tier, "")
+		if err != nil {
+			return e, err
+		}
+	}
+	if err := txn.Commit(tx); err != nil {
+		return e, err
+	}
+	return Get(ctx, db, e.ID)
+}
+
+const columns = `id, chama_id, account_id, category, description, COALESCE(payee, ''), amount_minor, currency,
+	incurred_at, status, COALESCE(storage_key, ''), COALESCE(mime_type, ''), requested_by, COALESCE(decided_by, ''),
+	COALESCE(decided_at, ''), COALESCE(rejection_reason, ''), COALESCE(ledger_entry_id, ''), created_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Expense, error) {
+	var e Expense
+	err := row.Scan(&e.ID, &e.ChamaID, &e.AccountID, &e.Category, &e.Description, &e.Payee, &e.Amount.Amount,
+		&e.Amount.Currency, &e.IncurredAt, &e.Status, &e.StorageKey, &e.MimeType, &e.RequestedBy, &e.DecidedBy,
+		&e.DecidedAt, &e.RejectionReason, &e.LedgerEntryID, &e.CreatedAt)
+	e.HasAttachment = e.StorageKey != ""
+	return e, err
+}
+
+// Get returns a single expense
+func Get(ctx context.Context, db *sql.DB, id string) (Expense, error) {
+	return scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM expenses WHERE id = ?`, id))
+}
+
+// Filter narrows a List query. Zero values are ignored.
+type Filter struct {
+	ChamaID  string
+	Category string
+	Status   string
+	From     time.Time
+	To       time.Time
+}
+
+// List returns a chama's expenses, newest first
+func List(ctx context.Context, db *sql.DB, f Filter) ([]Expense, error) {
+	query := `SELECT ` + columns + ` FROM expenses WHERE chama_id = ?`
+	args := []interface{}{f.ChamaID}
+	if f.Category != "" {
+		query += " AND category = ?"
+		args = append(args, f.Category)
+	}
+	if f.Status != "" {
+		query += " AND status = ?"
+		args = append(args, f.Status)
+	}
+	if !f.From.IsZero() {
+		query += " AND incurred_at >= ?"
+		args = append(args, f.From.UTC().Format(timeLayout))
+	}
+	if !f.To.IsZero() {
+		query += " AND incurred_at < ?"
+		args = append(args, f.To.UTC().Format(timeLayout))
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY incurred_at DESC, created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Expense{}
+	for rows.Next() {
+		e, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// Approve approves a pending expense and posts it to the ledger as money out
+// of its account on the date it was incurred. An expense in an approval
+// tier is only posted once it has all the approvals its tier requires;
+// until then it is returned still pending.
+func Approve(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Expense, error) {
+	e, err := Get(ctx, db, id)
+	if err != nil {
+		return e, err
+	}
+	if e.Status != StatusPending {
+		return e, ErrDecided
+	}
+	req, err := approvals.ForSubject(ctx, db, approvals.SubjectExpense, id)
+	switch {
+	case err == nil:
+		if req, err = approvals.Approve(ctx, db, req.ID, entry); err != nil {
+			return e, err
+		}
+		if req.Status != approvals.StatusApproved {
+			return e, nil
+		}
+	case errors.Is(err, approvals.ErrNotFound):
+		if e.RequestedBy == entry.UserID {
+			return e, ErrSelfApproval
+		}
+		if !chamas.HasRole(db, e.ChamaID, entry.UserID, ApproverRoles...) {
+			return e, approvals.ErrNotEligible
+		}
+	default:
+		return e, err
+	}
+	return post(ctx, db, e, entry.UserID)
+}
+
+// post posts an approved expense to the ledger
+func post(ctx context.Context, db *sql.DB, e Expense, approvedBy string) (Expense, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return e, err
+	}
+	defer txn.Rollback(tx)
+
+	entry, err := ledger.Post(ctx, tx, ledger.Entry{
+		ChamaID:     e.ChamaID,
+		AccountID:   e.AccountID,
+		Type:        ledger.TypeExpense,
+		Amount:      e.Amount.Negate(),
+		Reference:   e.ID,
+		Description: e.Category + ": " + e.Description,
+		EffectiveAt: e.IncurredAt,
+		CreatedBy:   approvedBy,
+	})
+	if err != nil {
+		return e, err
+	}
+	if err := decide(ctx, tx, e.ID, StatusApproved, approvedBy, entry.ID, ""); err != nil {
+		return e, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return e, err
+	}
+	return Get(ctx, db, e.ID)
+}
+
+// Reject rejects a pending expense; nothing is posted to the ledger
+func Reject(ctx context.Context, db *sql.DB, id, reason string, entry audit.Entry) (Expense, error) {
+	req, err := approvals.ForSubject(ctx, db, approvals.SubjectExpense, id)
+	switch {
+	case err == nil:
+		if _, err := approvals.Reject(ctx, db, req.ID, reason, entry); err != nil {
+			return Expense{}, err
+		}
+	case errors.Is(err, approvals.ErrNotFound):
+		e, err := Get(ctx, db, id)
+		if err != nil {
+			return e, err
+		}
+		if !chamas.HasRole(db, e.ChamaID, entry.UserID, ApproverRoles...) {
+			return e, approvals.ErrNotEligible
+		}
+	default:
+		return Expense{}, err
+	}
+	return reject(ctx, db, id, entry.UserID, reason)
+}
+
+func reject(ctx context.Context, db *sql.DB, id, rejectedBy, reason string) (Expense, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Expense{}, err
+	}
+	defer txn.Rollback(tx)
+
+	if err := decide(ctx, tx, id, StatusRejected, rejectedBy, "", reason); err != nil {
+		return Expense{}, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return Expense{}, err
+	}
+	return Get(ctx, db, id)
+}
+
+func decide(ctx context.Context, tx *sql.Tx, id, status, by, entryID, reason string) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE expenses SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP,
+		       ledger_entry_id = ?, rejection_reason = ?
+		WHERE id = ? AND status = ?`,
+		status, nullIfEmpty(by), nullIfEmpty(entryID), nullIfEmpty(reason), id, StatusPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrDecided
+	}
+	return nil
+}
+
+// RegisterApprovalHook posts or rejects expenses once the members' vote on
+// them closes, and tells the requester the outcome
+func RegisterApprovalHook(notifier *notifications.Notifier) {
+	approvals.Hooks[approvals.SubjectExpense] = func(ctx context.Context, db *sql.DB, r approvals.Request) error {
+		e, err := Get(ctx, db, r.SubjectID)
+		if err != nil {
+			return err
+		}
+		if r.Status == approvals.StatusApproved {
+			e, err = post(ctx, db, e, "")
+		} else {
+			e, err = reject(ctx, db, e.ID, "", approvals.VoteRejection)
+		}
+		if errors.Is(err, ErrDecided) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		notifyDecision(ctx, db, notifier, e)
+		if e.Status == StatusApproved {
+			checkBudget(ctx, db, notifier, e)
+		}
+		return nil
+	}
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,348 @@
+package expenses
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/approvals"
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/validation"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// MaxAttachmentSize is the largest accepted receipt or invoice
+const MaxAttachmentSize = 10 << 20 // 10 MB
+
+// CreateHandler lets an official record an expense for the {chamaId} chama. It
+// accepts a multipart form with category, description, payee, amount,
+// accountId, date and an optional receipt "file". The expense waits for approval.
+func CreateHandler(db *sql.DB, store storage.Backend, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MaxAttachmentSize+1<<20)
+		if err := r.ParseMultipartForm(MaxAttachmentSize); err != nil {
+			http.Error(w, "Invalid upload or file too large", http.StatusBadRequest)
+			return
+		}
+		category := r.FormValue("category")
+		description := strings.TrimSpace(r.FormValue("description"))
+		amount := r.FormValue("amount")
+		accountID := r.FormValue("accountId")
+		date := r.FormValue("date")
+
+		v := validation.New()
+		if v.Required("category", category) {
+			v.OneOf("category", category, Categories...)
+		}
+		v.Required("description", description)
+		if v.Required("amount", amount) {
+			v.Amount("amount", amount)
+		}
+		v.Required("accountId", accountID)
+		var incurred time.Time
+		if date != "" {
+			v.Date("date", date)
+			incurred, _ = time.Parse("2006-01-02", date)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		currency, err := money.ChamaCurrency(db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		e := Expense{
+			ChamaID:     chamaID,
+			AccountID:   accountID,
+			Category:    category,
+			Description: description,
+			Payee:       strings.TrimSpace(r.FormValue("payee")),
+			IncurredAt:  incurred,
+			RequestedBy: userID,
+		}
+		e.Amount, _ = money.Parse(amount, currency)
+
+		file, header, err := r.FormFile("file")
+		if err == nil {
+			defer file.Close()
+			mimeType, err := storage.DetectType(file, header.Size, MaxAttachmentSize, storage.DocumentTypes)
+			if errors.Is(err, storage.ErrTooLarge) {
+				http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Only JPEG, PNG and PDF files are accepted", http.StatusUnsupportedMediaType)
+				return
+			}
+			key := "expenses/" + chamaID + "/" + uuid.NewString() + storage.Extensions[mimeType]
+			if err := store.Put(r.Context(), key, file, header.Size, mimeType); err != nil {
+				http.Error(w, "Failed to store document", http.StatusInternalServerError)
+				return
+			}
+			e.StorageKey, e.MimeType = key, mimeType
+		}
+
+		e, err = Create(r.Context(), db, e)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifySubmitted(r.Context(), db, notifier, e, audit.FromRequest(r, audit.Entry{UserID: userID}))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+	}
+}
+
+// ListHandler lists the {chamaId} chama's expenses. Supports ?category=,
+// ?status= and ?period=YYYY-MM.
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		q := r.URL.Query()
+		f := Filter{ChamaID: chamaID, Category: q.Get("category"), Status: q.Get("status")}
+		if period := q.Get("period"); period != "" {
+			from, to, err := periodRange(period)
+			if err != nil {
+				http.Error(w, "period must be in the format YYYY-MM", http.StatusBadRequest)
+				return
+			}
+			f.From, f.To = from, to
+		}
+		list, err := List(r.Context(), db, f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// ApproveHandler approves the {expenseId} expense and posts it to the ledger.
+// An expense that needs more approvals from its tier stays pending.
+func ApproveHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decideHandler(db, notifier, w, r, func(id, userID string) (Expense, error) {
+			return Approve(r.Context(), db, id, audit.FromRequest(r, audit.Entry{UserID: userID}))
+		})
+	}
+}
+
+// RejectHandler rejects the {expenseId} expense with a reason
+func RejectHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+			http.Error(w, "A reason is required when rejecting an expense", http.StatusBadRequest)
+			return
+		}
+		decideHandler(db, notifier, w, r, func(id, userID string) (Expense, error) {
+			return Reject(r.Context(), db, id, request.Reason, audit.FromRequest(r, audit.Entry{UserID: userID}))
+		})
+	}
+}
+
+func decideHandler(db *sql.DB, notifier *notifications.Notifier, w http.ResponseWriter, r *http.Request, decide func(id, userID string) (Expense, error)) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	e, err := Get(r.Context(), db, mux.Vars(r)["expenseId"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !chamas.IsOfficial(db, e.ChamaID, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	e, err = decide(e.ID, userID)
+	switch {
+	case errors.Is(err, approvals.ErrNotEligible):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, ErrDecided), errors.Is(err, ErrSelfApproval), errors.Is(err, approvals.ErrDecided),
+		errors.Is(err, approvals.ErrSelfApproval), errors.Is(err, approvals.ErrAlreadyApproved),
+		errors.Is(err, approvals.ErrVoteRequired):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ledger.ErrDebitNotAllowed), errors.Is(err, ledger.ErrInsufficientFunds), errors.Is(err, ledger.ErrAccountClosed),
+		errors.Is(err, ledger.ErrPeriodClosed), errors.Is(err, ledger.ErrPeriodLocked):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if e.Status != StatusPending {
+		notifyDecision(r.Context(), db, notifier, e)
+	}
+	if e.Status == StatusApproved {
+		checkBudget(r.Context(), db, notifier, e)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// AttachmentHandler redirects to a short-lived link for the {expenseId} receipt
+func AttachmentHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		e, err := Get(r.Context(), db, mux.Vars(r)["expenseId"])
+		if err != nil || e.StorageKey == "" || !chamas.IsMember(db, e.ChamaID, userID) {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return
+		}
+		url, err := store.SignedURL(e.StorageKey, 5*time.Minute)
+		if err != nil {
+			http.Error(w, "Failed to create download link", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
+
+// BudgetsHandler reports spending against budget for the {chamaId} chama,
+// for ?period=YYYY-MM (the current month by default)
+func BudgetsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		period := r.URL.Query().Get("period")
+		if period == "" {
+			period = PeriodOf(time.Now())
+		}
+		if _, _, err := periodRange(period); err != nil {
+			http.Error(w, "period must be in the format YYYY-MM", http.StatusBadRequest)
+			return
+		}
+		usage, err := BudgetUsage(r.Context(), db, chamaID, period)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage)
+	}
+}
+
+// SetBudgetHandler sets the budget for one category and month of the {chamaId} chama
+func SetBudgetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, ApproverRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Category string `json:"category"`
+			Period   string `json:"period"`
+			Amount   string `json:"amount"`
+			AlertBps int64  `json:"alertBps"` // defaults to DefaultAlertBps
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		v := validation.New()
+		if v.Required("category", request.Category) {
+			v.OneOf("category", request.Category, Categories...)
+		}
+		if v.Required("period", request.Period) {
+			if _, _, err := periodRange(request.Period); err != nil {
+				v.Add("period", validation.CodeDate, nil)
+			}
+		}
+		if v.Required("amount", request.Amount) {
+			v.Amount("amount", request.Amount)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		currency, err := money.ChamaCurrency(db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		amount, _ := money.Parse(request.Amount, currency)
+		b, err := SetBudget(r.Context(), db, Budget{
+			ChamaID:  chamaID,
+			Category: request.Category,
+			Period:   request.Period,
+			Amount:   amount,
+			AlertBps: request.AlertBps,
+		}, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b)
+	}
+}
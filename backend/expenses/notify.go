@@ -0,0 +1,126 @@
+package expenses
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strconv"
+
+	"tujifund-app/backend/approvals"
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/notifications"
+)
+
+// ApproverRoles may approve or reject expenses
+var ApproverRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// notifySubmitted asks the chama's approvers, other than the requester, to
+// review e. An expense in an approval tier goes to the officials its tier
+// allows to approve it, or is put to the members' vote.
+func notifySubmitted(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, e Expense, entry audit.Entry) {
+	req, err := approvals.ForSubject(ctx, db, approvals.SubjectExpense, e.ID)
+	switch {
+	case err == nil && req.Vote:
+		title := i18n.T(i18n.Default, "expense.category."+e.Category, nil) + ": " + e.Description + " (" + e.Amount.String() + ")"
+		if _, err := approvals.StartVote(ctx, db, notifier, req, title, entry); err != nil {
+			slog.ErrorContext(ctx, "Failed to open expense vote", "expense_id", e.ID, "error", err)
+		}
+		return
+	case err == nil:
+		approvals.Notify(ctx, db, notifier, req)
+		return
+	case !errors.Is(err, approvals.ErrNotFound):
+		slog.ErrorContext(ctx, "Failed to load expense approval", "expense_id", e.ID, "error", err)
+		return
+	}
+
+	approvers, err := chamas.MembersWithRole(db, e.ChamaID, ApproverRoles...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load expense approvers", "expense_id", e.ID, "error", err)
+		return
+	}
+	params := messageParams(ctx, db, e)
+	for _, id := range approvers {
+		if id == e.RequestedBy {
+			continue
+		}
+		notify(ctx, notifier, id, e.ID, "expense.submitted", "notification.expense_submitted", params)
+	}
+}
+
+// notifyDecision tells the requester whether e was approved or rejected
+func notifyDecision(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, e Expense) {
+	params := messageParams(ctx, db, e)
+	params["reason"] = e.RejectionReason
+	notify(ctx, notifier, e.RequestedBy, e.ID, "expense."+e.Status, "notification.expense_"+e.Status, params)
+}
+
+// checkBudget alerts the chama's officials the first time approved spending
+// in e's category approaches or exceeds the budget for its month
+func checkBudget(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, e Expense) {
+	usage, err := BudgetUsage(ctx, db, e.ChamaID, PeriodOf(e.IncurredAt))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check expense budget", "expense_id", e.ID, "error", err)
+		return
+	}
+	for _, u := range usage {
+		if u.Category != e.Category {
+			continue
+		}
+		raised, err := raiseAlert(ctx, db, e.ChamaID, u)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to update budget alert", "chama_id", e.ChamaID, "category", u.Category, "error", err)
+			return
+		}
+		if !raised {
+			return
+		}
+
+		titleKey, messageKey := "budget.approaching", "notification.budget_approaching"
+		if u.level() == AlertExceeded {
+			titleKey, messageKey = "budget.exceeded", "notification.budget_exceeded"
+		}
+		params := messageParams(ctx, db, e)
+		params["period"] = u.Period
+		params["spent"] = u.Spent.String()
+		params["budget"] = u.Budget.String()
+		params["percent"] = strconv.FormatInt(u.UsedBps/100, 10)
+
+		officials, err := chamas.MembersWithRole(db, e.ChamaID, chamas.OfficialRoles...)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load chama officials", "chama_id", e.ChamaID, "error", err)
+			return
+		}
+		for _, id := range officials {
+			notify(ctx, notifier, id, e.ID, titleKey, messageKey, params)
+		}
+		return
+	}
+}
+
+func notify(ctx context.Context, notifier *notifications.Notifier, userID, expenseID, titleKey, messageKey string, params map[string]string) {
+	if notifier == nil {
+		return
+	}
+	notifier.Notify(ctx, notifications.Notification{
+		UserID:    userID,
+		Title:     i18n.T(i18n.Default, titleKey, nil),
+		Message:   i18n.T(i18n.Default, messageKey, params),
+		Type:      notifications.TypeChama,
+		RelatedID: expenseID,
+	})
+}
+
+func messageParams(ctx context.Context, db *sql.DB, e Expense) map[string]string {
+	var chama string
+	db.QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, e.ChamaID).Scan(&chama)
+	return map[string]string{
+		"chama":       chama,
+		"category":    i18n.T(i18n.Default, "expense.category."+e.Category, nil),
+		"description": e.Description,
+		"amount":      e.Amount.String(),
+	}
+}
@@ -0,0 +1,165 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/export/xlsx"
+	"tujifund-app/backend/ledger"
+
+	"github.com/gorilla/mux"
+)
+
+// flushEvery controls how often buffered rows are pushed to the client
+const flushEvery = 500
+
+// rowWriter is the common interface of the CSV and XLSX writers
+type rowWriter interface {
+	WriteRow(cells ...interface{}) error
+	Flush() error
+	Close() error
+}
+
+type csvWriter struct{ w *csv.Writer }
+
+func (c csvWriter) WriteRow(cells ...interface{}) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		switch v := cell.(type) {
+		case time.Time:
+			record[i] = v.Format("2006-01-02 15:04:05")
+		case nil:
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c csvWriter) Close() error { return c.Flush() }
+
+// newWriter sets the response headers for ?format=csv (default) or xlsx and returns a row writer
+func newWriter(w http.ResponseWriter, r *http.Request, name string) (rowWriter, error) {
+	stamp := time.Now().Format("20060102")
+	if r.URL.Query().Get("format") == "xlsx" {
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.xlsx"`, name, stamp))
+		return xlsx.NewWriter(w, name)
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`, name, stamp))
+	return csvWriter{csv.NewWriter(w)}, nil
+}
+
+// flush pushes buffered rows through to the client
+func flush(w http.ResponseWriter, rw rowWriter) {
+	rw.Flush()
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// TransactionsHandler streams the chama's ledger entries as CSV or XLSX using
+// the same filters as the transactions list API
+func TransactionsHandler(db *sql.DB) http.HandlerFunc {
+	return ledgerExport(db, "transactions", "")
+}
+
+// ContributionsHandler streams the chama's contribution ledger as CSV or XLSX
+func ContributionsHandler(db *sql.DB) http.HandlerFunc {
+	return ledgerExport(db, "contributions", ledger.TypeContribution)
+}
+
+func ledgerExport(db *sql.DB, name, entryType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, status, msg := ledger.FilterFromRequest(db, r)
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+		if entryType != "" {
+			f.Type = entryType
+		}
+
+		where, args := f.Where()
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT `+ledger.Columns+` FROM `+ledger.History+` WHERE `+where+` ORDER BY `+ledger.Order, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		rw, err := newWriter(w, r, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.WriteRow("Date", "Type", "Member ID", "Account ID", "Amount", "Currency", "Reference", "Description", "Entry ID")
+
+		for n := 1; rows.Next(); n++ {
+			e, err := ledger.Scan(rows)
+			if err != nil {
+				// Headers are already sent, so the best we can do is stop the stream
+				break
+			}
+			rw.WriteRow(e.EffectiveAt, e.Type, e.MemberID, e.AccountID, e.Amount.Decimal(), e.Amount.Currency, e.Reference, e.Description, e.ID)
+			if n%flushEvery == 0 {
+				flush(w, rw)
+			}
+		}
+		rw.Close()
+	}
+}
+
+// MembersHandler streams the chama's member list as CSV or XLSX using the
+// same filters as the members list API. Only officials can export.
+func MembersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		if !chamas.IsOfficial(db, mux.Vars(r)["chamaId"], userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		query, args := chamas.MemberQuery(r)
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		rw, err := newWriter(w, r, "members")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.WriteRow("First Name", "Last Name", "Username", "Email", "Phone", "Role", "Status", "Join Date")
+
+		for n := 1; rows.Next(); n++ {
+			m, err := chamas.ScanMember(rows)
+			if err != nil {
+				break
+			}
+			rw.WriteRow(m.FirstName, m.LastName, m.Username, m.Email, m.Phone, m.Role, m.Status, m.JoinDate)
+			if n%flushEvery == 0 {
+				flush(w, rw)
+			}
+		}
+		rw.Close()
+	}
+}
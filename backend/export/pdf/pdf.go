@@ -0,0 +1,148 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Page geometry in points (A4)
+const (
+	pageWidth  = 595
+	pageHeight = 842
+	margin     = 50
+)
+
+// Document is a minimal text-only PDF writer for statements and reports.
+// It uses the built-in Helvetica fonts, so no font files are embedded.
+type Document struct {
+	pages   []*bytes.Buffer
+	current *bytes.Buffer
+	y       float64
+	title   string
+}
+
+// New creates an empty document
+func New(title string) *Document {
+	d := &Document{title: title}
+	d.newPage()
+	return d
+}
+
+func (d *Document) newPage() {
+	d.current = &bytes.Buffer{}
+	d.pages = append(d.pages, d.current)
+	d.y = pageHeight - margin
+}
+
+func (d *Document) text(font string, size, x float64, s string) {
+	if d.y < margin+size {
+		d.newPage()
+	}
+	fmt.Fprintf(d.current, "BT /%s %.1f Tf %.1f %.1f Td (%s) Tj ET\n", font, size, x, d.y, escape(s))
+}
+
+// Heading writes a bold line
+func (d *Document) Heading(s string) {
+	d.y -= 6
+	d.text("F2", 14, margin, s)
+	d.y -= 20
+}
+
+// Line writes a line of regular text
+func (d *Document) Line(s string) {
+	d.text("F1", 10, margin, s)
+	d.y -= 14
+}
+
+// Row writes cells in fixed-width columns. widths are in points and the last
+// column is right-aligned, which suits amount columns.
+func (d *Document) Row(bold bool, widths []float64, cells ...string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	x := float64(margin)
+	for i, c := range cells {
+		if i >= len(widths) {
+			break
+		}
+		cx := x
+		if i == len(cells)-1 {
+			// Approximate right alignment: Helvetica averages ~0.5em per glyph
+			cx = x + widths[i] - float64(len(c))*5
+		}
+		d.text(font, 10, cx, c)
+		x += widths[i]
+	}
+	d.y -= 14
+}
+
+// Space adds vertical space
+func (d *Document) Space() {
+	d.y -= 10
+}
+
+// WriteTo serialises the document
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var out bytes.Buffer
+	var offsets []int
+
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// 1: catalog, 2: pages, 3-4: fonts, 5: info, then a page + content pair per page
+	n := len(d.pages)
+	kids := make([]string, n)
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+i*2)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	obj(fmt.Sprintf("<< /Title (%s) /Producer (TujiFund) >>", escape(d.title)))
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 7+i*2))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.Len(), p.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.WriteTo(w)
+}
+
+// escape converts s to WinAnsi and escapes PDF string delimiters.
+// Characters outside Latin-1 are replaced with '?'.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString("\\200")
+		case r < 0x20:
+		case r < 0x80:
+			b.WriteRune(r)
+		case r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
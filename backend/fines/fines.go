@@ -0,0 +1,198 @@
+package fines
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Fine statuses
+const (
+	StatusUnpaid = "unpaid"
+	StatusPaid   = "paid"
+	StatusWaived = "waived"
+)
+
+// Fine reasons
+const (
+	ReasonAbsence          = "meeting_absence"
+	ReasonLateContribution = "late_contribution"
+	ReasonLateRepayment    = "late_repayment"
+	ReasonOther            = "other"
+)
+
+// ErrSettled is returned when paying or waiving a fine that is no longer unpaid
+var ErrSettled = errors.New("fine has already been settled")
+
+// Fine is a charge against a member
+type Fine struct {
+	ID            string      `json:"id"`
+	ChamaID       string      `json:"chamaId"`
+	MemberID      string      `json:"memberId"`
+	Reason        string      `json:"reason"`
+	Description   string      `json:"description,omitempty"`
+	Amount        money.Money `json:"amount"`
+	Status        string      `json:"status"`
+	RelatedID     string      `json:"relatedId,omitempty"`
+	IssuedBy      string      `json:"issuedBy,omitempty"`
+	LedgerEntryID string      `json:"ledgerEntryId,omitempty"`
+	SettledAt     string      `json:"settledAt,omitempty"`
+	CreatedAt     string      `json:"createdAt"`
+}
+
+// Issue records an unpaid fine inside tx. Fines with a RelatedID are charged at
+// most once per member and reason, so re-running the same trigger is safe;
+// issued reports whether a new fine was created.
+func Issue(ctx context.Context, tx *sql.Tx, f Fine) (fine Fine, issued bool, err error) {
+	if f.Amount.Amount <= 0 {
+		return f, false, errors.New("fine amount must be positive")
+	}
+	f.ID = uuid.NewString()
+	f.Status = StatusUnpaid
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO fines (id, chama_id, member_id, reason, description, amount_minor, currency, related_id, issued_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		f.ID, f.ChamaID, f.MemberID, f.Reason, nullIfEmpty(f.Description), f.Amount.Amount, f.Amount.Currency,
+		nullIfEmpty(f.RelatedID), nullIfEmpty(f.IssuedBy),
+	)
+	if err != nil {
+		return f, false, fmt.Errorf("failed to issue fine: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return f, n > 0, nil
+}
+
+const columns = `id, chama_id, member_id, reason, COALESCE(description, ''), amount_minor, currency, status,
+	COALESCE(related_id, ''), COALESCE(issued_by, ''), COALESCE(ledger_entry_id, ''), COALESCE(settled_at, ''), created_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Fine, error) {
+	var f Fine
+	err := row.Scan(&f.ID, &f.ChamaID, &f.MemberID, &f.Reason, &f.Description, &f.Amount.Amount, &f.Amount.Currency,
+		&f.Status, &f.RelatedID, &f.IssuedBy, &f.LedgerEntryID, &f.SettledAt, &f.CreatedAt)
+	return f, err
+}
+
+// Get returns a single fine
+func Get(ctx context.Context, db *sql.DB, id string) (Fine, error) {
+	return scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM fines WHERE id = ?`, id))
+}
+
+// List returns a chama's fines, newest first. memberID and status are optional filters.
+func List(ctx context.Context, db *sql.DB, chamaID, memberID, status string) ([]Fine, error) {
+	query := `SELECT ` + columns + ` FROM fines WHERE chama_id = ?`
+	args := []interface{}{chamaID}
+	if memberID != "" {
+		query += " AND member_id = ?"
+		args = append(args, memberID)
+	}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Fine{}
+	for rows.Next() {
+		f, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+// Pay marks a fine as paid into accountID and posts it to the ledger as income
+func Pay(ctx context.Context, db *sql.DB, id, accountID, paidBy string) (Fine, error) {
+	f, err := Get(ctx, db, id)
+	if err != nil {
+		return f, err
+	}
+	if f.Status != StatusUnpaid {
+		return f, ErrSettled
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return f, err
+	}
+	defer txn.Rollback(tx)
+
+	if err := PayTx(ctx, tx, f, accountID, paidBy); err != nil {
+		return f, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return f, err
+	}
+	return Get(ctx, db, id)
+}
+
+// PayTx marks the unpaid fine f as paid within tx, for paying a fine as part
+// of a larger change
+func PayTx(ctx context.Context, tx *sql.Tx, f Fine, accountID, paidBy string) error {
+	entry, err := ledger.Post(ctx, tx, ledger.Entry{
+		ChamaID:     f.ChamaID,
+		AccountID:   accountID,
+		MemberID:    f.MemberID,
+		Type:        ledger.TypeFine,
+		Amount:      f.Amount,
+		Reference:   f.ID,
+		Description: "Fine: " + f.Reason,
+		CreatedBy:   paidBy,
+	})
+	if err != nil {
+		return err
+	}
+	return settle(ctx, tx, f.ID, StatusPaid, paidBy, entry.ID, "")
+}
+
+// Waive cancels an unpaid fine without posting anything to the ledger
+func Waive(ctx context.Context, db *sql.DB, id, waivedBy, reason string) (Fine, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Fine{}, err
+	}
+	defer txn.Rollback(tx)
+
+	if err := settle(ctx, tx, id, StatusWaived, waivedBy, "", reason); err != nil {
+		return Fine{}, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return Fine{}, err
+	}
+	return Get(ctx, db, id)
+}
+
+func settle(ctx context.Context, tx *sql.Tx, id, status, by, entryID, reason string) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE fines SET status = ?, settled_by = ?, settled_at = CURRENT_TIMESTAMP,
+		       ledger_entry_id = ?, waiver_reason = ?
+		WHERE id = ? AND status = ?`,
+		status, by, nullIfEmpty(entryID), nullIfEmpty(reason), id, StatusUnpaid)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrSettled
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,107 @@
+package fines
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"tujifund-app/backend/chamas"
+
+	"github.com/gorilla/mux"
+)
+
+// ListHandler lists the {chamaId} chama's fines. Officials see every member's
+// fines and may filter by memberId; other members only see their own.
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+
+		memberID := r.URL.Query().Get("memberId")
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			if !chamas.IsMember(db, chamaID, userID) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			memberID = userID
+		}
+
+		list, err := List(r.Context(), db, chamaID, memberID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// PayHandler lets the treasurer record payment of the {id} fine into an account
+func PayHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			AccountID string `json:"accountId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.AccountID == "" {
+			http.Error(w, "accountId is required", http.StatusBadRequest)
+			return
+		}
+		settleHandler(db, w, r, func(userID string) (Fine, error) {
+			return Pay(r.Context(), db, mux.Vars(r)["id"], request.AccountID, userID)
+		})
+	}
+}
+
+// WaiveHandler lets the treasurer or chairperson cancel the {id} fine
+func WaiveHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+			http.Error(w, "A reason is required when waiving a fine", http.StatusBadRequest)
+			return
+		}
+		settleHandler(db, w, r, func(userID string) (Fine, error) {
+			return Waive(r.Context(), db, mux.Vars(r)["id"], userID, request.Reason)
+		})
+	}
+}
+
+func settleHandler(db *sql.DB, w http.ResponseWriter, r *http.Request, settle func(userID string) (Fine, error)) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	f, err := Get(r.Context(), db, mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Fine not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !chamas.HasRole(db, f.ChamaID, userID, chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	f, err = settle(userID)
+	if errors.Is(err, ErrSettled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f)
+}
@@ -0,0 +1,251 @@
+package flags
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"tujifund-app/backend/audit"
+)
+
+// Flags for features being rolled out
+const (
+	NewInterestFormula = "new_interest_formula"
+	NewPaymentProvider = "new_payment_provider"
+	// CustomChartOfAccounts lets a chama's managers add accounts of their own to its chart
+	CustomChartOfAccounts = "custom_chart_of_accounts"
+)
+
+// DefaultTTL is how long flags are cached before being reloaded, so changes
+// made by another server instance are picked up
+const DefaultTTL = 30 * time.Second
+
+// Flag is a feature flag and its targeting
+type Flag struct {
+	Key            string    `json:"key"`
+	Description    string    `json:"description"`
+	Enabled        bool      `json:"enabled"`
+	RolloutPercent int       `json:"rolloutPercent"`
+	ChamaIDs       []string  `json:"chamaIds"`
+	UserIDs        []string  `json:"userIds"`
+	UpdatedBy      string    `json:"updatedBy,omitempty"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// Subject is who a flag is evaluated for. Either field may be empty.
+type Subject struct {
+	UserID  string
+	ChamaID string
+}
+
+// On reports whether f is on for sub
+func (f Flag) On(sub Subject) bool {
+	if !f.Enabled {
+		return false
+	}
+	if sub.ChamaID != "" && contains(f.ChamaIDs, sub.ChamaID) {
+		return true
+	}
+	if sub.UserID != "" && contains(f.UserIDs, sub.UserID) {
+		return true
+	}
+	id := sub.UserID
+	if id == "" {
+		id = sub.ChamaID
+	}
+	if id == "" {
+		return f.RolloutPercent >= 100
+	}
+	return bucket(f.Key, id) < f.RolloutPercent
+}
+
+// bucket places id in 0-99. Hashing with the key gives each flag its own
+// independent, stable sample of users.
+func bucket(key, id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key + ":" + id))
+	return int(h.Sum32() % 100)
+}
+
+// Store reads flags from the database and keeps them in memory
+type Store struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu       sync.RWMutex
+	flags    map[string]Flag
+	loadedAt time.Time
+}
+
+// New creates a store that reloads flags every ttl
+func New(db *sql.DB, ttl time.Duration) *Store {
+	return &Store{db: db, ttl: ttl}
+}
+
+// Enabled reports whether the flag key is on for sub. Unknown flags are off,
+// and so is every flag if they cannot be loaded.
+func (s *Store) Enabled(ctx context.Context, key string, sub Subject) bool {
+	flags, err := s.load(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load feature flags", "error", err)
+		return false
+	}
+	f, ok := flags[key]
+	return ok && f.On(sub)
+}
+
+// Evaluate returns every flag's state for sub
+func (s *Store) Evaluate(ctx context.Context, sub Subject) (map[string]bool, error) {
+	flags, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]bool, len(flags))
+	for key, f := range flags {
+		states[key] = f.On(sub)
+	}
+	return states, nil
+}
+
+// load returns the cached flags, reloading them once the TTL has passed
+func (s *Store) load(ctx context.Context) (map[string]Flag, error) {
+	s.mu.RLock()
+	if s.flags != nil && time.Since(s.loadedAt) < s.ttl {
+		defer s.mu.RUnlock()
+		return s.flags, nil
+	}
+	s.mu.RUnlock()
+
+	list, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[string]Flag, len(list))
+	for _, f := range list {
+		flags[f.Key] = f
+	}
+	s.mu.Lock()
+	s.flags, s.loadedAt = flags, time.Now()
+	s.mu.Unlock()
+	return flags, nil
+}
+
+// Refresh drops the cache so the next check reloads, as changes made on
+// other instances or straight in the database would otherwise wait for the TTL
+func (s *Store) Refresh() {
+	s.mu.Lock()
+	s.flags = nil
+	s.mu.Unlock()
+}
+
+// List returns every flag from the database
+func (s *Store) List(ctx context.Context) ([]Flag, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT key, COALESCE(description, ''), enabled, rollout_percent,
+		       COALESCE(chama_ids, ''), COALESCE(user_ids, ''), COALESCE(updated_by, ''), updated_at
+		FROM feature_flags ORDER BY key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Flag{}
+	for rows.Next() {
+		var f Flag
+		var chamaIDs, userIDs string
+		if err := rows.Scan(&f.Key, &f.Description, &f.Enabled, &f.RolloutPercent,
+			&chamaIDs, &userIDs, &f.UpdatedBy, &f.UpdatedAt); err != nil {
+			return nil, err
+		}
+		f.ChamaIDs, f.UserIDs = split(chamaIDs), split(userIDs)
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+// Set creates or replaces a flag. The change takes effect on this instance
+// immediately and on others within the TTL.
+func (s *Store) Set(ctx context.Context, f Flag, e audit.Entry) error {
+	if f.RolloutPercent < 0 {
+		f.RolloutPercent = 0
+	}
+	if f.RolloutPercent > 100 {
+		f.RolloutPercent = 100
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO feature_flags (key, description, enabled, rollout_percent, chama_ids, user_ids, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(key) DO UPDATE SET
+			description = excluded.description, enabled = excluded.enabled,
+			rollout_percent = excluded.rollout_percent, chama_ids = excluded.chama_ids,
+			user_ids = excluded.user_ids, updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP`,
+		f.Key, f.Description, f.Enabled, f.RolloutPercent,
+		strings.Join(f.ChamaIDs, ","), strings.Join(f.UserIDs, ","), e.UserID,
+	)
+	if err != nil {
+		return err
+	}
+	e.Action, e.EntityType, e.EntityID = "flag.set", "feature_flag", f.Key
+	e.NewValues = f
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.Refresh()
+	return nil
+}
+
+// Delete removes a flag, turning it off everywhere. It returns sql.ErrNoRows
+// if there is no such flag.
+func (s *Store) Delete(ctx context.Context, key string, e audit.Entry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM feature_flags WHERE key = ?`, key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	e.Action, e.EntityType, e.EntityID = "flag.delete", "feature_flag", key
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.Refresh()
+	return nil
+}
+
+func split(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package flags
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"tujifund-app/backend/audit"
+
+	"github.com/gorilla/mux"
+)
+
+var keyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,63}$`)
+
+// ListHandler lists every flag and its targeting. Wrap it in admin.Require.
+func ListHandler(store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := store.List(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// SetHandler creates or replaces the {key} flag. Wrap it in admin.Require.
+func SetHandler(store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		if !keyPattern.MatchString(key) {
+			http.Error(w, "Flag keys are lowercase letters, digits and underscores", http.StatusBadRequest)
+			return
+		}
+		var f Flag
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if f.RolloutPercent < 0 || f.RolloutPercent > 100 {
+			http.Error(w, "rolloutPercent must be between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		f.Key = key
+
+		if err := store.Set(r.Context(), f, audit.FromRequest(r, audit.Entry{})); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeleteHandler removes the {key} flag. Wrap it in admin.Require.
+func DeleteHandler(store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := store.Delete(r.Context(), mux.Vars(r)["key"], audit.FromRequest(r, audit.Entry{}))
+		if err == sql.ErrNoRows {
+			http.Error(w, "Flag not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// StateHandler returns which flags are on for the caller, optionally within
+// ?chamaId=, so the app can show or hide features
+func StateHandler(store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		states, err := store.Evaluate(r.Context(), Subject{UserID: userID, ChamaID: r.URL.Query().Get("chamaId")})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(states)
+	}
+}
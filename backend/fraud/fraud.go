@@ -0,0 +1,342 @@
+// Package fraud flags suspicious patterns in a chama's money: contributions
+// reversed again and again, payouts to phone numbers not on file, officials
+// approving their own loans, and money paid out in the middle of the night.
+// Each pattern raises one alert, which is sent to the chama's officials and
+// to platform admins for review. The person whose action raised an alert is
+// neither told of it nor allowed to review it.
+package fraud
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+
+	"github.com/google/uuid"
+)
+
+// Severities
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+)
+
+// Alert statuses
+const (
+	StatusOpen      = "open"
+	StatusConfirmed = "confirmed"
+	StatusDismissed = "dismissed"
+)
+
+var (
+	// ErrNotFound is returned for an alert that does not exist
+	ErrNotFound = errors.New("fraud alert not found")
+	// ErrReviewed is returned when reviewing an alert that is no longer open
+	ErrReviewed = errors.New("fraud alert has already been reviewed")
+	// ErrInvalidStatus is returned for a review that neither confirms nor dismisses
+	ErrInvalidStatus = errors.New("status must be confirmed or dismissed")
+	// ErrOwnAlert is returned when someone reviews an alert about their own actions
+	ErrOwnAlert = errors.New("you cannot review an alert about your own actions")
+)
+
+// Alert is a suspicious pattern raised by a rule
+type Alert struct {
+	ID          string                 `json:"id"`
+	ChamaID     string                 `json:"chamaId"`
+	ChamaName   string                 `json:"chamaName"`
+	Rule        string                 `json:"rule"`
+	Severity    string                 `json:"severity"`
+	SubjectType string                 `json:"subjectType"`
+	SubjectID   string                 `json:"subjectId"`
+	MemberID    string                 `json:"memberId,omitempty"`
+	MemberName  string                 `json:"memberName,omitempty"`
+	ActorID     string                 `json:"actorId,omitempty"`
+	Details     map[string]interface{} `json:"details"`
+	Status      string                 `json:"status"`
+	ReviewedBy  string                 `json:"reviewedBy,omitempty"`
+	ReviewedAt  *time.Time             `json:"reviewedAt,omitempty"`
+	ReviewNote  string                 `json:"reviewNote,omitempty"`
+	DetectedAt  time.Time              `json:"detectedAt"`
+}
+
+// detectors run each rule over a chama
+var detectors = map[string]func(ctx context.Context, db *sql.DB, chamaID string, now time.Time) ([]finding, error){
+	RuleRepeatedReversals: repeatedReversals,
+	RuleUnknownPhone:      unknownPhone,
+	RuleSelfApproval:      selfApproval,
+	RuleAfterHours:        afterHours,
+}
+
+// Detect runs every rule over a chama as of now, records what they find
+// and notifies officials and platform admins of each new alert. It returns
+// the alerts raised by this run; patterns already raised are not raised again.
+func Detect(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, chamaID string, now time.Time) ([]Alert, error) {
+	var raised []Alert
+	for _, rule := range Rules {
+		found, err := detectors[rule](ctx, db, chamaID, now)
+		if err != nil {
+			return raised, fmt.Errorf("rule %s: %w", rule, err)
+		}
+		for _, f := range found {
+			id, err := record(ctx, db, chamaID, f)
+			if err != nil {
+				return raised, err
+			}
+			if id == "" {
+				continue
+			}
+			a, err := Get(ctx, db, id)
+			if err != nil {
+				return raised, err
+			}
+			notify(ctx, db, notifier, a)
+			raised = append(raised, a)
+		}
+	}
+	return raised, nil
+}
+
+// record stores f as an open alert and returns its ID, or "" when the
+// pattern has been raised before
+func record(ctx context.Context, db *sql.DB, chamaID string, f finding) (string, error) {
+	details, err := json.Marshal(f.details)
+	if err != nil {
+		return "", err
+	}
+	id := uuid.NewString()
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO fraud_alerts
+		(id, chama_id, rule, severity, subject_type, subject_id, member_id, actor_id, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (rule, subject_id) DO NOTHING`,
+		id, chamaID, f.rule, f.severity, f.subjectType, f.subjectID, nullIfEmpty(f.memberID), nullIfEmpty(f.actorID),
+		string(details))
+	if err != nil {
+		return "", fmt.Errorf("failed to record fraud alert: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", nil
+	}
+	return id, nil
+}
+
+// notify tells the chama's officials and platform admins of a new alert,
+// leaving out whoever raised it
+func notify(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, a Alert) {
+	if notifier == nil {
+		return
+	}
+	officials, err := chamas.MembersWithRole(db, a.ChamaID, chamas.OfficialRoles...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load chama officials", "chama_id", a.ChamaID, "error", err)
+	}
+	admins, err := platformAdmins(ctx, db)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load platform admins", "error", err)
+	}
+
+	params := map[string]string{
+		"chama":  a.ChamaName,
+		"member": a.MemberName,
+		"rule":   i18n.T(i18n.Default, "fraud.rule."+a.Rule, nil),
+	}
+	key := "notification.fraud_alert"
+	if a.MemberName == "" {
+		key = "notification.fraud_alert_chama"
+	}
+	told := map[string]bool{a.ActorID: true, a.MemberID: true, "": true}
+	for _, userID := range append(officials, admins...) {
+		if told[userID] {
+			continue
+		}
+		told[userID] = true
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    userID,
+			Title:     i18n.T(i18n.Default, "fraud.title", nil),
+			Message:   i18n.T(i18n.Default, key, params),
+			Type:      notifications.TypeChama,
+			RelatedID: a.ID,
+			Priority:  notifications.PriorityHigh,
+		})
+	}
+}
+
+// platformAdmins returns the user IDs of platform staff
+func platformAdmins(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT user_id FROM users WHERE role = 'admin' AND suspended_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+const columns = `a.id, a.chama_id, COALESCE(c.name, ''), a.rule, a.severity, a.subject_type, a.subject_id,
+	COALESCE(a.member_id, ''),
+	COALESCE(NULLIF(TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')), ''), u.username, ''),
+	COALESCE(a.actor_id, ''), COALESCE(a.details, '{}'), a.status, COALESCE(a.reviewed_by, ''), a.reviewed_at,
+	COALESCE(a.review_note, ''), a.detected_at`
+
+const joins = `FROM fraud_alerts a LEFT JOIN chamas c ON c.id = a.chama_id LEFT JOIN users u ON u.user_id = a.member_id`
+
+func scan(row interface{ Scan(...interface{}) error }) (Alert, error) {
+	var a Alert
+	var details string
+	err := row.Scan(&a.ID, &a.ChamaID, &a.ChamaName, &a.Rule, &a.Severity, &a.SubjectType, &a.SubjectID,
+		&a.MemberID, &a.MemberName, &a.ActorID, &details, &a.Status, &a.ReviewedBy, &a.ReviewedAt,
+		&a.ReviewNote, &a.DetectedAt)
+	if err != nil {
+		return a, err
+	}
+	if err := json.Unmarshal([]byte(details), &a.Details); err != nil {
+		return a, fmt.Errorf("invalid details on fraud alert %s: %w", a.ID, err)
+	}
+	return a, nil
+}
+
+// Get returns one alert
+func Get(ctx context.Context, db *sql.DB, id string) (Alert, error) {
+	a, err := scan(db.QueryRowContext(ctx, `SELECT `+columns+` `+joins+` WHERE a.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return a, ErrNotFound
+	}
+	return a, err
+}
+
+// Filter narrows a list of alerts
+type Filter struct {
+	ChamaID string
+	Status  string
+	Rule    string
+	Limit   int
+}
+
+// List returns alerts matching f, newest first
+func List(ctx context.Context, db *sql.DB, f Filter) ([]Alert, error) {
+	var where []string
+	var args []interface{}
+	if f.ChamaID != "" {
+		where, args = append(where, "a.chama_id = ?"), append(args, f.ChamaID)
+	}
+	if f.Status != "" {
+		where, args = append(where, "a.status = ?"), append(args, f.Status)
+	}
+	if f.Rule != "" {
+		where, args = append(where, "a.rule = ?"), append(args, f.Rule)
+	}
+	query := `SELECT ` + columns + ` ` + joins
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY a.detected_at DESC, a.id LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Alert{}
+	for rows.Next() {
+		a, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// Review closes an open alert as confirmed or dismissed, with a note
+func Review(ctx context.Context, db *sql.DB, id, status, note string, e audit.Entry) (Alert, error) {
+	if status != StatusConfirmed && status != StatusDismissed {
+		return Alert{}, ErrInvalidStatus
+	}
+	a, err := Get(ctx, db, id)
+	if err != nil {
+		return a, err
+	}
+	if e.UserID == a.ActorID || e.UserID == a.MemberID {
+		return a, ErrOwnAlert
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		UPDATE fraud_alerts SET status = ?, reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP, review_note = ?
+		WHERE id = ? AND status = ?`,
+		status, e.UserID, nullIfEmpty(note), id, StatusOpen)
+	if err != nil {
+		return a, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return a, ErrReviewed
+	}
+	e.Action, e.EntityType, e.EntityID = "fraud_alert."+status, "fraud_alert", id
+	e.OldValues = map[string]string{"status": a.Status}
+	e.NewValues = map[string]string{"status": status, "rule": a.Rule, "chamaId": a.ChamaID, "note": note}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return a, err
+	}
+	if err := tx.Commit(); err != nil {
+		return a, err
+	}
+	return Get(ctx, db, id)
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// RegisterDetectionJob runs the rules over every active chama each hour
+func RegisterDetectionJob(s *jobs.Scheduler, db *sql.DB, notifier *notifications.Notifier) {
+	s.Register("fraud_detection", jobs.Every(time.Hour), func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `SELECT id FROM chamas WHERE status = 'active'`)
+		if err != nil {
+			return err
+		}
+		var chamaIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			chamaIDs = append(chamaIDs, id)
+		}
+		rows.Close()
+
+		now := time.Now().UTC()
+		for _, id := range chamaIDs {
+			if _, err := Detect(ctx, db, notifier, id, now); err != nil {
+				slog.ErrorContext(ctx, "Failed to run fraud detection", "chama_id", id, "error", err)
+			}
+		}
+		return nil
+	})
+}
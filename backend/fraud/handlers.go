@@ -0,0 +1,163 @@
+package fraud
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/notifications"
+
+	"github.com/gorilla/mux"
+)
+
+func officialOnly(db *sql.DB, w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return "", "", false
+	}
+	chamaID := mux.Vars(r)["chamaId"]
+	if !chamas.IsOfficial(db, chamaID, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return userID, chamaID, false
+	}
+	return userID, chamaID, true
+}
+
+// filter reads ?status=, ?rule= and ?limit= (default 100)
+func filter(r *http.Request) Filter {
+	q := r.URL.Query()
+	f := Filter{Status: q.Get("status"), Rule: q.Get("rule")}
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 && n <= 500 {
+		f.Limit = n
+	}
+	return f
+}
+
+// ListHandler returns the {chamaId} chama's alerts, filtered by ?status=
+// and ?rule=, for its officials
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, chamaID, ok := officialOnly(db, w, r)
+		if !ok {
+			return
+		}
+		f := filter(r)
+		f.ChamaID = chamaID
+		list, err := List(r.Context(), db, f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// DetectHandler runs the rules over the {chamaId} chama now rather than
+// waiting for the hourly job, and returns the alerts it raised
+func DetectHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, chamaID, ok := officialOnly(db, w, r)
+		if !ok {
+			return
+		}
+		raised, err := Detect(r.Context(), db, notifier, chamaID, time.Now().UTC())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if raised == nil {
+			raised = []Alert{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(raised)
+	}
+}
+
+// ReviewHandler confirms or dismisses the {alertId} alert of the {chamaId}
+// chama, for its officials
+func ReviewHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, chamaID, ok := officialOnly(db, w, r)
+		if !ok {
+			return
+		}
+		a, err := Get(r.Context(), db, mux.Vars(r)["alertId"])
+		if err == nil && a.ChamaID != chamaID {
+			err = ErrNotFound
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		review(db, w, r, userID, a.ID)
+	}
+}
+
+// AdminListHandler returns alerts across every chama, filtered by
+// ?chamaId=, ?status= and ?rule=, for platform staff
+func AdminListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f := filter(r)
+		f.ChamaID = r.URL.Query().Get("chamaId")
+		list, err := List(r.Context(), db, f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// AdminReviewHandler confirms or dismisses the {alertId} alert, for
+// platform staff
+func AdminReviewHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		review(db, w, r, userID, mux.Vars(r)["alertId"])
+	}
+}
+
+// review reads {"status": "confirmed"|"dismissed", "note": "..."} and
+// closes the alert
+func review(db *sql.DB, w http.ResponseWriter, r *http.Request, userID, alertID string) {
+	var request struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	a, err := Review(r.Context(), db, alertID, request.Status, strings.TrimSpace(request.Note),
+		audit.FromRequest(r, audit.Entry{UserID: userID}))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidStatus):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrOwnAlert):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrReviewed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
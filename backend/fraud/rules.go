@@ -0,0 +1,218 @@
+package fraud
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/payments"
+)
+
+// Rules
+const (
+	RuleRepeatedReversals = "repeated_reversals"
+	RuleUnknownPhone      = "unknown_phone"
+	RuleSelfApproval      = "self_approval"
+	RuleAfterHours        = "after_hours"
+)
+
+// Rules lists every rule, in the order they run
+var Rules = []string{RuleRepeatedReversals, RuleUnknownPhone, RuleSelfApproval, RuleAfterHours}
+
+var (
+	// ReversalCount is how many of a member's contributions must be reversed
+	// within ReversalDays to raise an alert
+	ReversalCount = 3
+	ReversalDays  = 30
+	// LookbackDays is how far back the other rules look on each run. Alerts
+	// are raised once, so overlapping runs do no harm.
+	LookbackDays = 7
+	// Location is the time zone working hours are judged in
+	Location = time.FixedZone("EAT", 3*60*60)
+	// AfterHoursFrom and AfterHoursTo bound the night, in hours of the day in
+	// Location, when money going out is unusual
+	AfterHoursFrom = 22
+	AfterHoursTo   = 5
+)
+
+// outgoing are the ledger entry types that pay money out of a chama
+var outgoing = []interface{}{ledger.TypeWithdrawal, ledger.TypeLoanDisbursement, ledger.TypeExpense, ledger.TypeDistribution}
+
+// finding is a suspicious record found by a rule
+type finding struct {
+	rule        string
+	severity    string
+	subjectType string
+	subjectID   string
+	memberID    string
+	actorID     string
+	details     map[string]interface{}
+}
+
+// since formats the start of a rule's window for comparison with stored timestamps
+func since(now time.Time, days int) string {
+	return now.UTC().AddDate(0, 0, -days).Format("2006-01-02 15:04:05")
+}
+
+// repeatedReversals finds members with ReversalCount or more contributions
+// reversed within ReversalDays: bank transfers an official rejected, and
+// contribution entries taken back out of the ledger. The latest reversal is
+// the alert's subject, so a fresh run of reversals raises a fresh alert.
+func repeatedReversals(ctx context.Context, db *sql.DB, chamaID string, now time.Time) ([]finding, error) {
+	from := since(now, ReversalDays)
+	rows, err := db.QueryContext(ctx, `
+		SELECT member_id, id, 'contribution', updated_at FROM contributions
+		WHERE chama_id = ? AND status = 'failed' AND confirmed_by IS NOT NULL AND updated_at >= ?
+		UNION ALL
+		SELECT member_id, id, 'ledger_entry', created_at FROM ledger_entries
+		WHERE chama_id = ? AND entry_type = ? AND amount_minor < 0 AND member_id IS NOT NULL
+		  AND archive_id IS NULL AND created_at >= ?
+		ORDER BY 4 DESC`,
+		chamaID, from, chamaID, ledger.TypeContribution, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []finding
+	byMember := map[string]int{}
+	for rows.Next() {
+		var memberID, id, subjectType, at string
+		if err := rows.Scan(&memberID, &id, &subjectType, &at); err != nil {
+			return nil, err
+		}
+		i, ok := byMember[memberID]
+		if !ok {
+			i = len(found)
+			byMember[memberID] = i
+			found = append(found, finding{
+				rule: RuleRepeatedReversals, severity: SeverityMedium, subjectType: subjectType, subjectID: id,
+				memberID: memberID, details: map[string]interface{}{"windowDays": ReversalDays},
+			})
+		}
+		found[i].details["reversals"] = count(found[i].details["reversals"]) + 1
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var repeated []finding
+	for _, f := range found {
+		if count(f.details["reversals"]) >= ReversalCount {
+			repeated = append(repeated, f)
+		}
+	}
+	return repeated, nil
+}
+
+func count(v interface{}) int {
+	n, _ := v.(int)
+	return n
+}
+
+// unknownPhone finds payouts sent to a phone number other than the one on
+// the member's account
+func unknownPhone(ctx context.Context, db *sql.DB, chamaID string, now time.Time) ([]finding, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.id, d.member_id, d.requested_by, d.phone_number, COALESCE(u.phone_number, ''),
+		       d.amount_minor, d.currency, d.purpose
+		FROM disbursements d LEFT JOIN users u ON u.user_id = d.member_id
+		WHERE d.chama_id = ? AND d.created_at >= ?`,
+		chamaID, since(now, LookbackDays))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []finding
+	for rows.Next() {
+		var id, memberID, requestedBy, sentTo, onFile, currency, purpose string
+		var amount int64
+		if err := rows.Scan(&id, &memberID, &requestedBy, &sentTo, &onFile, &amount, &currency, &purpose); err != nil {
+			return nil, err
+		}
+		if onFile != "" && payments.MSISDN(sentTo) == payments.MSISDN(onFile) {
+			continue
+		}
+		found = append(found, finding{
+			rule: RuleUnknownPhone, severity: SeverityHigh, subjectType: "disbursement", subjectID: id,
+			memberID: memberID, actorID: requestedBy,
+			details: map[string]interface{}{
+				"phone": sentTo, "phoneOnFile": onFile, "amount": money.New(amount, currency), "purpose": purpose,
+			},
+		})
+	}
+	return found, rows.Err()
+}
+
+// selfApproval finds loans approved by their own borrower, and loan
+// restructures and top-ups the borrower decided themselves
+func selfApproval(ctx context.Context, db *sql.DB, chamaID string, now time.Time) ([]finding, error) {
+	from := since(now, LookbackDays)
+	rows, err := db.QueryContext(ctx, `
+		SELECT 'loan_application', id, user_id, amount_minor, currency FROM loan_applications
+		WHERE chama_id = ? AND approved_by = user_id AND approval_date >= ?
+		UNION ALL
+		SELECT 'loan_change', c.id, l.borrower_id, c.top_up_minor, c.currency
+		FROM loan_changes c JOIN loans l ON l.id = c.loan_id
+		WHERE c.chama_id = ? AND c.status = 'approved' AND c.decided_by = l.borrower_id AND c.decided_at >= ?`,
+		chamaID, from, chamaID, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []finding
+	for rows.Next() {
+		var subjectType, id, borrowerID, currency string
+		var amount int64
+		if err := rows.Scan(&subjectType, &id, &borrowerID, &amount, &currency); err != nil {
+			return nil, err
+		}
+		found = append(found, finding{
+			rule: RuleSelfApproval, severity: SeverityHigh, subjectType: subjectType, subjectID: id,
+			memberID: borrowerID, actorID: borrowerID,
+			details: map[string]interface{}{"amount": money.New(amount, currency)},
+		})
+	}
+	return found, rows.Err()
+}
+
+// afterHours finds money paid out of the chama in the middle of the night,
+// by when it was recorded
+func afterHours(ctx context.Context, db *sql.DB, chamaID string, now time.Time) ([]finding, error) {
+	args := append([]interface{}{chamaID, since(now, LookbackDays)}, outgoing...)
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, COALESCE(member_id, ''), COALESCE(created_by, ''), entry_type, amount_minor, currency, created_at
+		FROM ledger_entries
+		WHERE chama_id = ? AND created_at >= ? AND amount_minor < 0 AND archive_id IS NULL
+		  AND entry_type IN (?, ?, ?, ?)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []finding
+	for rows.Next() {
+		var id, memberID, createdBy, entryType, currency string
+		var amount int64
+		var at time.Time
+		if err := rows.Scan(&id, &memberID, &createdBy, &entryType, &amount, &currency, &at); err != nil {
+			return nil, err
+		}
+		local := at.In(Location)
+		if hour := local.Hour(); hour < AfterHoursFrom && hour >= AfterHoursTo {
+			continue
+		}
+		found = append(found, finding{
+			rule: RuleAfterHours, severity: SeverityMedium, subjectType: "ledger_entry", subjectID: id,
+			memberID: memberID, actorID: createdBy,
+			details: map[string]interface{}{
+				"entryType": entryType, "amount": money.New(-amount, currency), "recordedAt": local.Format(time.RFC3339),
+			},
+		})
+	}
+	return found, rows.Err()
+}
@@ -0,0 +1,350 @@
+package funds
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Fund types. Each fund is a chama account with its own ledger balance.
+const (
+	TypeGeneral   = "general"
+	TypeSavings   = "savings"
+	TypeWelfare   = "welfare"
+	TypeEducation = "education"
+	TypeLoans     = "loans"
+)
+
+// Types lists the fund types that can be created
+var Types = []string{TypeGeneral, TypeSavings, TypeWelfare, TypeEducation, TypeLoans}
+
+// DefaultDebits are the entry types each fund type may pay out when no rules
+// are given. General funds are unrestricted.
+var DefaultDebits = map[string][]string{
+	TypeSavings:   {ledger.TypeTransfer, ledger.TypeInterest},
+	TypeWelfare:   {ledger.TypeExpense, ledger.TypeTransfer},
+	TypeEducation: {ledger.TypeExpense, ledger.TypeTransfer},
+	TypeLoans:     {ledger.TypeLoanDisbursement, ledger.TypeLoanInterest, ledger.TypeTransfer},
+}
+
+// Fund statuses
+const (
+	StatusActive = "active"
+	StatusClosed = "closed"
+)
+
+// Transfer statuses
+const (
+	TransferPending  = "pending"
+	TransferApproved = "approved"
+	TransferRejected = "rejected"
+)
+
+var (
+	// ErrDecided is returned when approving or rejecting a transfer that is no longer pending
+	ErrDecided = errors.New("transfer has already been decided")
+	// ErrSelfApproval is returned when the requester tries to approve their own transfer
+	ErrSelfApproval = errors.New("transfers must be approved by someone other than the requester")
+	// ErrNotEmpty is returned when closing a fund that still holds money
+	ErrNotEmpty = errors.New("fund balance must be zero before it can be closed")
+)
+
+// Fund is a chama account together with its rules. AllowedDebits is empty
+// when any entry type may take money out; MinBalance is nil when there is no floor.
+type Fund struct {
+	ID            string       `json:"id"`
+	ChamaID       string       `json:"chamaId"`
+	Name          string       `json:"name"`
+	Type          string       `json:"type"`
+	Description   string       `json:"description,omitempty"`
+	Balance       money.Money  `json:"balance"`
+	Status        string       `json:"status"`
+	AllowedDebits []string     `json:"allowedDebits"`
+	MinBalance    *money.Money `json:"minBalance,omitempty"`
+}
+
+// Create opens a new fund. Funds without AllowedDebits get the defaults for their type.
+func Create(ctx context.Context, db *sql.DB, f Fund) (Fund, error) {
+	f.ID = uuid.NewString()
+	if f.AllowedDebits == nil {
+		f.AllowedDebits = DefaultDebits[f.Type]
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO chama_accounts (id, chama_id, name, account_type, currency, description, allowed_debits, min_balance_minor)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.ID, f.ChamaID, f.Name, f.Type, f.Balance.Currency, nullIfEmpty(f.Description),
+		nullIfEmpty(strings.Join(f.AllowedDebits, ",")), minBalance(f.MinBalance),
+	)
+	if err != nil {
+		return f, fmt.Errorf("failed to create fund: %w", err)
+	}
+	return Get(ctx, db, f.ID)
+}
+
+const columns = `id, chama_id, name, account_type, COALESCE(description, ''), balance_minor, currency, status,
+	COALESCE(allowed_debits, ''), min_balance_minor`
+
+func scan(row interface{ Scan(...interface{}) error }) (Fund, error) {
+	var f Fund
+	var debits string
+	var floor sql.NullInt64
+	err := row.Scan(&f.ID, &f.ChamaID, &f.Name, &f.Type, &f.Description, &f.Balance.Amount, &f.Balance.Currency,
+		&f.Status, &debits, &floor)
+	f.AllowedDebits = []string{}
+	if debits != "" {
+		f.AllowedDebits = strings.Split(debits, ",")
+	}
+	if floor.Valid {
+		m := money.New(floor.Int64, f.Balance.Currency)
+		f.MinBalance = &m
+	}
+	return f, err
+}
+
+// Get returns a single fund
+func Get(ctx context.Context, db *sql.DB, id string) (Fund, error) {
+	return scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM chama_accounts WHERE id = ?`, id))
+}
+
+// List returns a chama's funds with their current balances
+func List(ctx context.Context, db *sql.DB, chamaID string) ([]Fund, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+columns+` FROM chama_accounts WHERE chama_id = ? ORDER BY name`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Fund{}
+	for rows.Next() {
+		f, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+// UpdateRules replaces a fund's payout rules and records the change in the audit log
+func UpdateRules(ctx context.Context, db *sql.DB, id string, allowedDebits []string, floor *money.Money, entry audit.Entry) (Fund, error) {
+	old, err := Get(ctx, db, id)
+	if err != nil {
+		return old, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return old, err
+	}
+	defer txn.Rollback(tx)
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE chama_accounts SET allowed_debits = ?, min_balance_minor = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		nullIfEmpty(strings.Join(allowedDebits, ",")), minBalance(floor), id)
+	if err != nil {
+		return old, fmt.Errorf("failed to update fund rules: %w", err)
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "fund.rules", "chama_account", id
+	entry.OldValues = map[string]interface{}{"allowedDebits": old.AllowedDebits, "minBalance": old.MinBalance}
+	entry.NewValues = map[string]interface{}{"allowedDebits": allowedDebits, "minBalance": floor}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return old, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return old, err
+	}
+	return Get(ctx, db, id)
+}
+
+// Close closes an empty fund so nothing more can be posted to it
+func Close(ctx context.Context, db *sql.DB, id string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE chama_accounts SET status = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND balance_minor = 0`, StatusClosed, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotEmpty
+	}
+	return nil
+}
+
+// Transfer is a request to move money between two of a chama's funds
+type Transfer struct {
+	ID              string      `json:"id"`
+	ChamaID         string      `json:"chamaId"`
+	FromAccountID   string      `json:"fromAccountId"`
+	ToAccountID     string      `json:"toAccountId"`
+	Amount          money.Money `json:"amount"`
+	Reason          string      `json:"reason"`
+	Status          string      `json:"status"`
+	RequestedBy     string      `json:"requestedBy"`
+	DecidedBy       string      `json:"decidedBy,omitempty"`
+	DecidedAt       string      `json:"decidedAt,omitempty"`
+	RejectionReason string      `json:"rejectionReason,omitempty"`
+	CreatedAt       time.Time   `json:"createdAt"`
+}
+
+// RequestTransfer records a pending transfer between two funds of the same chama
+func RequestTransfer(ctx context.Context, db *sql.DB, t Transfer) (Transfer, error) {
+	if t.Amount.Amount <= 0 {
+		return t, errors.New("transfer amount must be positive")
+	}
+	if t.FromAccountID == t.ToAccountID {
+		return t, errors.New("cannot transfer a fund to itself")
+	}
+	for _, id := range []string{t.FromAccountID, t.ToAccountID} {
+		f, err := Get(ctx, db, id)
+		if err != nil || f.ChamaID != t.ChamaID {
+			return t, fmt.Errorf("fund %s not found", id)
+		}
+	}
+
+	t.ID = uuid.NewString()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO fund_transfers (id, chama_id, from_account_id, to_account_id, amount_minor, currency, reason, requested_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.ChamaID, t.FromAccountID, t.ToAccountID, t.Amount.Amount, t.Amount.Currency, t.Reason, t.RequestedBy,
+	)
+	if err != nil {
+		return t, fmt.Errorf("failed to request transfer: %w", err)
+	}
+	return GetTransfer(ctx, db, t.ID)
+}
+
+const transferColumns = `id, chama_id, from_account_id, to_account_id, amount_minor, currency, reason, status,
+	requested_by, COALESCE(decided_by, ''), COALESCE(decided_at, ''), COALESCE(rejection_reason, ''), created_at`
+
+func scanTransfer(row interface{ Scan(...interface{}) error }) (Transfer, error) {
+	var t Transfer
+	err := row.Scan(&t.ID, &t.ChamaID, &t.FromAccountID, &t.ToAccountID, &t.Amount.Amount, &t.Amount.Currency,
+		&t.Reason, &t.Status, &t.RequestedBy, &t.DecidedBy, &t.DecidedAt, &t.RejectionReason, &t.CreatedAt)
+	return t, err
+}
+
+// GetTransfer returns a single transfer
+func GetTransfer(ctx context.Context, db *sql.DB, id string) (Transfer, error) {
+	return scanTransfer(db.QueryRowContext(ctx, `SELECT `+transferColumns+` FROM fund_transfers WHERE id = ?`, id))
+}
+
+// ListTransfers returns a chama's transfers, newest first. status is an optional filter.
+func ListTransfers(ctx context.Context, db *sql.DB, chamaID, status string) ([]Transfer, error) {
+	query := `SELECT ` + transferColumns + ` FROM fund_transfers WHERE chama_id = ?`
+	args := []interface{}{chamaID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Transfer{}
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// ApproveTransfer approves a pending transfer and posts it to the ledger as a
+// debit on the source fund and a credit on the destination fund. The source
+// fund's rules apply to the debit.
+func ApproveTransfer(ctx context.Context, db *sql.DB, id, approvedBy string) (Transfer, error) {
+	t, err := GetTransfer(ctx, db, id)
+	if err != nil {
+		return t, err
+	}
+	if t.Status != TransferPending {
+		return t, ErrDecided
+	}
+	if t.RequestedBy == approvedBy {
+		return t, ErrSelfApproval
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return t, err
+	}
+	defer txn.Rollback(tx)
+
+	legs := []ledger.Entry{
+		{AccountID: t.FromAccountID, Amount: t.Amount.Negate()},
+		{AccountID: t.ToAccountID, Amount: t.Amount},
+	}
+	for _, e := range legs {
+		e.ChamaID, e.Type, e.Reference, e.Description, e.CreatedBy = t.ChamaID, ledger.TypeTransfer, t.ID, t.Reason, approvedBy
+		if _, err := ledger.Post(ctx, tx, e); err != nil {
+			return t, err
+		}
+	}
+	if err := decide(ctx, tx, id, TransferApproved, approvedBy, ""); err != nil {
+		return t, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return t, err
+	}
+	return GetTransfer(ctx, db, id)
+}
+
+// RejectTransfer rejects a pending transfer; nothing is posted to the ledger
+func RejectTransfer(ctx context.Context, db *sql.DB, id, rejectedBy, reason string) (Transfer, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Transfer{}, err
+	}
+	defer txn.Rollback(tx)
+
+	if err := decide(ctx, tx, id, TransferRejected, rejectedBy, reason); err != nil {
+		return Transfer{}, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return Transfer{}, err
+	}
+	return GetTransfer(ctx, db, id)
+}
+
+func decide(ctx context.Context, tx *sql.Tx, id, status, by, reason string) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE fund_transfers SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP, rejection_reason = ?
+		WHERE id = ? AND status = ?`,
+		status, by, nullIfEmpty(reason), id, TransferPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrDecided
+	}
+	return nil
+}
+
+func minBalance(m *money.Money) interface{} {
+	if m == nil {
+		return nil
+	}
+	return m.Amount
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,379 @@
+package funds
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// managerRoles may create funds, change their rules and approve transfers
+var managerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// rulesRequest is the JSON form of a fund's payout rules
+type rulesRequest struct {
+	AllowedDebits []string `json:"allowedDebits"`
+	MinBalance    *string  `json:"minBalance"`
+}
+
+// validate checks the rules and returns the minimum balance in currency
+func (req rulesRequest) validate(v *validation.Validator, currency string) *money.Money {
+	for _, t := range req.AllowedDebits {
+		v.OneOf("allowedDebits", t, ledger.Types...)
+	}
+	if req.MinBalance == nil || *req.MinBalance == "" {
+		return nil
+	}
+	v.Amount("minBalance", *req.MinBalance)
+	m, err := money.Parse(*req.MinBalance, currency)
+	if err != nil {
+		return nil
+	}
+	return &m
+}
+
+// ListHandler lists the {chamaId} chama's funds with their balances
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := List(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// CreateHandler opens a new fund for the {chamaId} chama
+func CreateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Name        string `json:"name"`
+			Type        string `json:"type"`
+			Description string `json:"description"`
+			rulesRequest
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		currency, err := money.ChamaCurrency(db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+
+		v := validation.New()
+		v.Required("name", strings.TrimSpace(request.Name))
+		if v.Required("type", request.Type) {
+			v.OneOf("type", request.Type, Types...)
+		}
+		floor := request.rulesRequest.validate(v, currency)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		f, err := Create(r.Context(), db, Fund{
+			ChamaID:       chamaID,
+			Name:          strings.TrimSpace(request.Name),
+			Type:          request.Type,
+			Description:   request.Description,
+			Balance:       money.New(0, currency),
+			AllowedDebits: request.AllowedDebits,
+			MinBalance:    floor,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(f)
+	}
+}
+
+// UpdateRulesHandler replaces the payout rules of the {fundId} fund
+func UpdateRulesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, ok := loadManagedFund(db, w, r)
+		if !ok {
+			return
+		}
+		var request rulesRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		floor := request.validate(v, f.Balance.Currency)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		f, err := UpdateRules(r.Context(), db, f.ID, request.AllowedDebits, floor, audit.FromRequest(r, audit.Entry{}))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(f)
+	}
+}
+
+// CloseHandler closes the empty {fundId} fund
+func CloseHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, ok := loadManagedFund(db, w, r)
+		if !ok {
+			return
+		}
+		err := Close(r.Context(), db, f.ID)
+		if errors.Is(err, ErrNotEmpty) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TransferHandler requests a transfer between two of the {chamaId} chama's
+// funds. Another official must approve it before any money moves.
+func TransferHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			FromAccountID string `json:"fromAccountId"`
+			ToAccountID   string `json:"toAccountId"`
+			Amount        string `json:"amount"`
+			Reason        string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("fromAccountId", request.FromAccountID)
+		v.Required("toAccountId", request.ToAccountID)
+		if v.Required("amount", request.Amount) {
+			v.Amount("amount", request.Amount)
+		}
+		v.Required("reason", request.Reason)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		currency, err := money.ChamaCurrency(db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		amount, _ := money.Parse(request.Amount, currency)
+		t, err := RequestTransfer(r.Context(), db, Transfer{
+			ChamaID:       chamaID,
+			FromAccountID: request.FromAccountID,
+			ToAccountID:   request.ToAccountID,
+			Amount:        amount,
+			Reason:        request.Reason,
+			RequestedBy:   userID,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		notifyApprovers(r.Context(), db, notifier, t)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+// ListTransfersHandler lists the {chamaId} chama's transfers, optionally filtered by ?status=
+func ListTransfersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := ListTransfers(r.Context(), db, chamaID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// ApproveTransferHandler approves the {transferId} transfer and moves the money
+func ApproveTransferHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decideHandler(db, w, r, func(id, userID string) (Transfer, error) {
+			return ApproveTransfer(r.Context(), db, id, userID)
+		})
+	}
+}
+
+// RejectTransferHandler rejects the {transferId} transfer with a reason
+func RejectTransferHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+			http.Error(w, "A reason is required when rejecting a transfer", http.StatusBadRequest)
+			return
+		}
+		decideHandler(db, w, r, func(id, userID string) (Transfer, error) {
+			return RejectTransfer(r.Context(), db, id, userID, request.Reason)
+		})
+	}
+}
+
+func decideHandler(db *sql.DB, w http.ResponseWriter, r *http.Request, decide func(id, userID string) (Transfer, error)) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	t, err := GetTransfer(r.Context(), db, mux.Vars(r)["transferId"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Transfer not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !chamas.HasRole(db, t.ChamaID, userID, managerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	t, err = decide(t.ID, userID)
+	switch {
+	case errors.Is(err, ErrDecided), errors.Is(err, ErrSelfApproval):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ledger.ErrDebitNotAllowed), errors.Is(err, ledger.ErrInsufficientFunds), errors.Is(err, ledger.ErrAccountClosed),
+		errors.Is(err, ledger.ErrPeriodClosed), errors.Is(err, ledger.ErrPeriodLocked):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// loadManagedFund loads the {fundId} fund for a manager of its chama, writing
+// an error response and returning false otherwise
+func loadManagedFund(db *sql.DB, w http.ResponseWriter, r *http.Request) (Fund, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Fund{}, false
+	}
+	f, err := Get(r.Context(), db, mux.Vars(r)["fundId"])
+	if err == sql.ErrNoRows || (err == nil && !chamas.IsMember(db, f.ChamaID, userID)) {
+		http.Error(w, "Fund not found", http.StatusNotFound)
+		return f, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return f, false
+	}
+	if !chamas.HasRole(db, f.ChamaID, userID, managerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return f, false
+	}
+	return f, true
+}
+
+// notifyApprovers asks the chama's other managers to review t
+func notifyApprovers(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, t Transfer) {
+	if notifier == nil {
+		return
+	}
+	approvers, err := chamas.MembersWithRole(db, t.ChamaID, managerRoles...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load transfer approvers", "transfer_id", t.ID, "error", err)
+		return
+	}
+	from, _ := Get(ctx, db, t.FromAccountID)
+	to, _ := Get(ctx, db, t.ToAccountID)
+	params := map[string]string{"amount": t.Amount.String(), "from": from.Name, "to": to.Name, "reason": t.Reason}
+	for _, id := range approvers {
+		if id == t.RequestedBy {
+			continue
+		}
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "transfer.requested", nil),
+			Message:   i18n.T(i18n.Default, "notification.transfer_requested", params),
+			Type:      notifications.TypeChama,
+			RelatedID: t.ID,
+		})
+	}
+}
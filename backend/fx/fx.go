@@ -0,0 +1,208 @@
+// Package fx converts money paid in one currency into another, such as
+// contributions members abroad make in USD or GBP to a chama kept in KES.
+// Rates come from a configured provider once a day and are cached, so every
+// conversion made on a day uses the same rate, which is recorded on the
+// ledger entry it produced.
+package fx
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/httpclient"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+)
+
+// MaxAge is how old a cached rate may be when the provider cannot be reached
+const MaxAge = 3 * 24 * time.Hour
+
+// ErrNoRate is returned when no recent enough rate is known for a pair of currencies
+var ErrNoRate = errors.New("no exchange rate available")
+
+// Quote is the rate for converting From into To on Date
+type Quote struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Rate   int64  `json:"rate"` // money.RateScale units of To per unit of From
+	Date   string `json:"date"` // YYYY-MM-DD
+	Source string `json:"source"`
+}
+
+// Provider supplies the latest exchange rates
+type Provider interface {
+	// Name identifies the provider on cached rates
+	Name() string
+	// Latest returns today's money.RateScale rate from base into each of quotes
+	Latest(ctx context.Context, base string, quotes []string) (map[string]int64, error)
+}
+
+// provider is the configured rate provider, nil when there is none
+var provider Provider
+
+// Use makes p the rate provider. Without one only rates already cached can be used.
+func Use(p Provider) {
+	provider = p
+}
+
+// HTTPConfig configures an exchange-rate API in the style of
+// exchangerate.host: GET {BaseURL}/latest?base=USD&symbols=KES,UGX answering
+// {"base": "USD", "date": "2026-01-31", "rates": {"KES": 129.25}}
+type HTTPConfig struct {
+	BaseURL string
+	APIKey  string // sent as access_key when set
+}
+
+// HTTP fetches rates from an exchange-rate API
+type HTTP struct {
+	conf   HTTPConfig
+	client *httpclient.Client
+}
+
+// NewHTTP creates an exchange-rate API client
+func NewHTTP(conf HTTPConfig) (*HTTP, error) {
+	if conf.BaseURL == "" {
+		return nil, errors.New("exchange-rate API base URL is required")
+	}
+	conf.BaseURL = strings.TrimRight(conf.BaseURL, "/")
+	return &HTTP{conf: conf, client: httpclient.New(httpclient.Config{Provider: "fx"})}, nil
+}
+
+// FromEnv builds an exchange-rate API client from FX_* variables. It returns
+// nil without an error when no provider is configured.
+func FromEnv() (*HTTP, error) {
+	if os.Getenv("FX_BASE_URL") == "" {
+		return nil, nil
+	}
+	return NewHTTP(HTTPConfig{BaseURL: os.Getenv("FX_BASE_URL"), APIKey: os.Getenv("FX_API_KEY")})
+}
+
+// Name implements Provider
+func (h *HTTP) Name() string {
+	if u, err := url.Parse(h.conf.BaseURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "http"
+}
+
+// Latest implements Provider
+func (h *HTTP) Latest(ctx context.Context, base string, quotes []string) (map[string]int64, error) {
+	q := url.Values{"base": {base}, "symbols": {strings.Join(quotes, ",")}}
+	if h.conf.APIKey != "" {
+		q.Set("access_key", h.conf.APIKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.conf.BaseURL+"/latest?"+q.Encode(), http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchange-rate API returned %s", resp.Status)
+	}
+
+	var body struct {
+		Rates map[string]json.Number `json:"rates"`
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid exchange-rate API response: %w", err)
+	}
+	rates := make(map[string]int64, len(body.Rates))
+	for code, value := range body.Rates {
+		rate, err := money.ParseRate(value.String())
+		if err != nil {
+			return nil, err
+		}
+		rates[strings.ToUpper(code)] = rate
+	}
+	return rates, nil
+}
+
+// Rate returns the rate for converting from into to on day: the cached rate
+// for that day, fetched from the provider the first time it is asked for,
+// or failing that the latest cached rate no older than MaxAge
+func Rate(ctx context.Context, db *sql.DB, from, to string, day time.Time) (Quote, error) {
+	date := day.UTC().Format("2006-01-02")
+	if from == to {
+		return Quote{From: from, To: to, Rate: money.RateScale, Date: date}, nil
+	}
+	if q, err := cached(ctx, db, from, to, date, date); err != sql.ErrNoRows {
+		return q, err
+	}
+	if provider != nil && date == time.Now().UTC().Format("2006-01-02") {
+		if err := fetch(ctx, db, from, []string{to}); err == nil {
+			if q, err := cached(ctx, db, from, to, date, date); err != sql.ErrNoRows {
+				return q, err
+			}
+		}
+	}
+	q, err := cached(ctx, db, from, to, day.Add(-MaxAge).UTC().Format("2006-01-02"), date)
+	if err == sql.ErrNoRows {
+		return q, fmt.Errorf("%w: %s to %s", ErrNoRate, from, to)
+	}
+	return q, err
+}
+
+// cached returns the latest cached rate from one currency into another dated
+// between since and until
+func cached(ctx context.Context, db *sql.DB, from, to, since, until string) (Quote, error) {
+	q := Quote{From: from, To: to}
+	err := txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT rate, rate_date, source FROM exchange_rates
+		WHERE base = ? AND quote = ? AND rate_date >= ? AND rate_date <= ?
+		ORDER BY rate_date DESC LIMIT 1`, from, to, since, until,
+	).Scan(&q.Rate, &q.Date, &q.Source)
+	return q, err
+}
+
+// fetch caches today's rates from base into each of quotes
+func fetch(ctx context.Context, db *sql.DB, base string, quotes []string) error {
+	rates, err := provider.Latest(ctx, base, quotes)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s exchange rates: %w", base, err)
+	}
+	date := time.Now().UTC().Format("2006-01-02")
+	for code, rate := range rates {
+		_, err := txn.From(ctx, db).ExecContext(ctx, `
+			INSERT INTO exchange_rates (base, quote, rate_date, rate, source) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (base, quote, rate_date) DO UPDATE SET rate = excluded.rate, source = excluded.source,
+				fetched_at = CURRENT_TIMESTAMP`,
+			base, code, date, rate, provider.Name())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Convert converts m into currency at the rate for at, returning the record
+// of the conversion to keep on the ledger entry. Amounts already in currency
+// come back unchanged with no record.
+func Convert(ctx context.Context, db *sql.DB, m money.Money, currency string, at time.Time) (money.Money, *ledger.FX, error) {
+	if m.Currency == currency {
+		return m, nil, nil
+	}
+	q, err := Rate(ctx, db, m.Currency, currency, at)
+	if err != nil {
+		return m, nil, err
+	}
+	converted, err := m.Convert(currency, q.Rate)
+	if err != nil {
+		return m, nil, err
+	}
+	return converted, &ledger.FX{Original: m, Rate: q.Rate, RateDate: q.Date, Source: q.Source}, nil
+}
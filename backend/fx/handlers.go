@@ -0,0 +1,51 @@
+package fx
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/validation"
+)
+
+// RateHandler returns the rate for converting ?from= into ?to= on ?date=
+// (YYYY-MM-DD, today by default), so members abroad can see what a
+// contribution will come to before they pay
+func RateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, to := strings.ToUpper(q.Get("from")), strings.ToUpper(q.Get("to"))
+		v := validation.New()
+		if v.Required("from", from) {
+			v.OneOf("from", from, money.Codes()...)
+		}
+		if v.Required("to", to) {
+			v.OneOf("to", to, money.Codes()...)
+		}
+		day := time.Now().UTC()
+		if date := q.Get("date"); date != "" {
+			v.Date("date", date)
+			day, _ = time.Parse("2006-01-02", date)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		quote, err := Rate(r.Context(), db, from, to, day)
+		if errors.Is(err, ErrNoRate) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(quote)
+	}
+}
@@ -0,0 +1,59 @@
+package fx
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/money"
+)
+
+// RegisterJob caches each day's rates from every accepted currency into the
+// currencies chamas keep their books in, so conversions during the day do not
+// wait on the provider
+func RegisterJob(s *jobs.Scheduler, db *sql.DB) {
+	s.Register("fx_rates", jobs.Daily{Hour: 1}, func(ctx context.Context) error {
+		if provider == nil {
+			return nil
+		}
+		return Refresh(ctx, db)
+	})
+}
+
+// Refresh caches today's rates for every pair of currencies in use
+func Refresh(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT COALESCE(NULLIF(currency, ''), ?) FROM chamas`, money.DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	var books []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			rows.Close()
+			return err
+		}
+		books = append(books, code)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, base := range money.Codes() {
+		var quotes []string
+		for _, code := range books {
+			if code != base {
+				quotes = append(quotes, code)
+			}
+		}
+		if len(quotes) == 0 {
+			continue
+		}
+		if err := fetch(ctx, db, base, quotes); err != nil {
+			slog.ErrorContext(ctx, "Failed to refresh exchange rates", "base", base, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package goals
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+
+	"github.com/google/uuid"
+)
+
+// Goal statuses
+const (
+	StatusActive    = "active"
+	StatusAchieved  = "achieved"
+	StatusMissed    = "missed"
+	StatusCancelled = "cancelled"
+)
+
+// Milestones are the progress percentages announced to members
+var Milestones = []int{25, 50, 75, 100}
+
+// ErrNotActive is returned when changing a goal that is no longer active
+var ErrNotActive = errors.New("goal is no longer active")
+
+// Goal is a savings target for a chama, one of its accounts, or a single member
+type Goal struct {
+	ID            string      `json:"id"`
+	ChamaID       string      `json:"chamaId"`
+	MemberID      string      `json:"memberId,omitempty"`
+	AccountID     string      `json:"accountId,omitempty"`
+	Title         string      `json:"title"`
+	Description   string      `json:"description,omitempty"`
+	Target        money.Money `json:"target"`
+	StartDate     time.Time   `json:"startDate"`
+	TargetDate    time.Time   `json:"targetDate"`
+	Status        string      `json:"status"`
+	LastMilestone int         `json:"lastMilestone"`
+	CreatedBy     string      `json:"createdBy"`
+}
+
+// Progress is a goal's standing computed from the ledger
+type Progress struct {
+	Goal
+	Saved     money.Money `json:"saved"`
+	Remaining money.Money `json:"remaining"`
+	Percent   int         `json:"percent"`
+	DaysLeft  int         `json:"daysLeft"`
+	// RequiredPerMonth is what still has to be saved each month to hit the target on time
+	RequiredPerMonth money.Money `json:"requiredPerMonth"`
+	OnTrack          bool        `json:"onTrack"`
+}
+
+// Create stores a new active goal
+func Create(ctx context.Context, db *sql.DB, g Goal) (Goal, error) {
+	g.ID = uuid.NewString()
+	g.Status = StatusActive
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO goals
+		(id, chama_id, member_id, account_id, title, description, target_amount_minor, currency, start_date, target_date, status, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		g.ID, g.ChamaID, nullIfEmpty(g.MemberID), nullIfEmpty(g.AccountID), g.Title, nullIfEmpty(g.Description),
+		g.Target.Amount, g.Target.Currency, g.StartDate.UTC().Format("2006-01-02 15:04:05"),
+		g.TargetDate.UTC().Format("2006-01-02 15:04:05"), g.Status, g.CreatedBy)
+	if err != nil {
+		return g, fmt.Errorf("failed to create goal: %w", err)
+	}
+	return g, nil
+}
+
+const columns = `id, chama_id, COALESCE(member_id, ''), COALESCE(account_id, ''), title, COALESCE(description, ''),
+	target_amount_minor, currency, start_date, target_date, status, last_milestone, created_by`
+
+func scan(row interface{ Scan(...interface{}) error }) (Goal, error) {
+	var g Goal
+	err := row.Scan(&g.ID, &g.ChamaID, &g.MemberID, &g.AccountID, &g.Title, &g.Description,
+		&g.Target.Amount, &g.Target.Currency, &g.StartDate, &g.TargetDate, &g.Status, &g.LastMilestone, &g.CreatedBy)
+	return g, err
+}
+
+// Get returns a goal
+func Get(ctx context.Context, db *sql.DB, id string) (Goal, error) {
+	return scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM goals WHERE id = ?`, id))
+}
+
+// List returns a chama's goals visible to memberID: every chama-wide goal and
+// the member's own personal goals. An empty memberID returns every goal.
+func List(ctx context.Context, db *sql.DB, chamaID, memberID string) ([]Goal, error) {
+	query := `SELECT ` + columns + ` FROM goals WHERE chama_id = ? AND status != 'cancelled'`
+	args := []interface{}{chamaID}
+	if memberID != "" {
+		query += ` AND (member_id IS NULL OR member_id = ?)`
+		args = append(args, memberID)
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY target_date`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Goal{}
+	for rows.Next() {
+		g, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, g)
+	}
+	return list, rows.Err()
+}
+
+// Saved returns how much has been saved towards g. Personal goals count the
+// member's savings made since the start date; chama goals use the balance of
+// the goal's account, or of the whole chama when no account is set.
+func Saved(ctx context.Context, db *sql.DB, g Goal) (money.Money, error) {
+	if g.MemberID != "" {
+		total, err := ledger.MemberSavings(ctx, db, g.ChamaID, g.MemberID, time.Time{}, g.Target.Currency)
+		if err != nil {
+			return total, err
+		}
+		before, err := ledger.MemberSavings(ctx, db, g.ChamaID, g.MemberID, g.StartDate, g.Target.Currency)
+		if err != nil {
+			return total, err
+		}
+		return total.Sub(before)
+	}
+	return ledger.Sum(ctx, db, ledger.Filter{ChamaID: g.ChamaID, AccountID: g.AccountID}, g.Target.Currency)
+}
+
+// Measure computes the progress of g as of now
+func Measure(ctx context.Context, db *sql.DB, g Goal) (Progress, error) {
+	p := Progress{Goal: g}
+	saved, err := Saved(ctx, db, g)
+	if err != nil {
+		return p, err
+	}
+	p.Saved = saved
+	p.Remaining = money.New(0, g.Target.Currency)
+	if saved.Amount < g.Target.Amount {
+		p.Remaining = money.New(g.Target.Amount-saved.Amount, g.Target.Currency)
+	}
+	if g.Target.Amount > 0 && saved.Amount > 0 {
+		p.Percent = int(saved.Amount * 100 / g.Target.Amount)
+	}
+
+	now := time.Now()
+	p.DaysLeft = int(g.TargetDate.Sub(now).Hours() / 24)
+	if p.DaysLeft < 0 {
+		p.DaysLeft = 0
+	}
+	months := int64((p.DaysLeft + 29) / 30)
+	if months < 1 {
+		months = 1
+	}
+	p.RequiredPerMonth = money.New((p.Remaining.Amount+months-1)/months, g.Target.Currency)
+
+	// On track when saved at least the share of the target that time elapsed implies
+	total := g.TargetDate.Sub(g.StartDate)
+	elapsed := now.Sub(g.StartDate)
+	p.OnTrack = p.Remaining.Amount == 0 || total <= 0 ||
+		float64(saved.Amount) >= float64(g.Target.Amount)*elapsed.Seconds()/total.Seconds()
+	return p, nil
+}
+
+// Cancel withdraws an active goal
+func Cancel(ctx context.Context, db *sql.DB, id string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE goals SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		StatusCancelled, id, StatusActive)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotActive
+	}
+	return nil
+}
+
+// reached returns the highest milestone at or below percent, or 0
+func reached(percent int) int {
+	hit := 0
+	for _, m := range Milestones {
+		if percent >= m {
+			hit = m
+		}
+	}
+	return hit
+}
+
+// advance records a newly reached milestone and closes the goal once it is
+// achieved or its deadline passes. It returns the milestone to announce, or 0.
+func advance(ctx context.Context, db *sql.DB, p Progress) (int, error) {
+	milestone := reached(p.Percent)
+	status := p.Status
+	switch {
+	case milestone >= 100:
+		status = StatusAchieved
+	case time.Now().After(p.TargetDate):
+		status = StatusMissed
+	}
+	if milestone <= p.LastMilestone && status == p.Status {
+		return 0, nil
+	}
+	if milestone < p.LastMilestone {
+		milestone = p.LastMilestone
+	}
+
+	_, err := db.ExecContext(ctx, `
+		UPDATE goals SET last_milestone = ?, status = ?,
+		       achieved_at = CASE WHEN ? = 'achieved' THEN CURRENT_TIMESTAMP ELSE achieved_at END,
+		       updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?`,
+		milestone, status, status, p.ID, StatusActive)
+	if err != nil {
+		return 0, err
+	}
+	if milestone > p.LastMilestone {
+		return milestone, nil
+	}
+	return 0, nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,156 @@
+package goals
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateHandler creates a goal for the {chamaId} chama. Officials create
+// chama-wide goals; with "personal": true any member sets a goal for themselves.
+func CreateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+
+		var request struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			Target      string `json:"targetAmount"`
+			AccountID   string `json:"accountId"`
+			StartDate   string `json:"startDate"`
+			TargetDate  string `json:"targetDate"`
+			Personal    bool   `json:"personal"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.Personal && !chamas.IsMember(db, chamaID, userID) ||
+			!request.Personal && !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		v := validation.New()
+		v.Required("title", request.Title)
+		if v.Required("targetAmount", request.Target) {
+			v.Amount("targetAmount", request.Target)
+		}
+		start := time.Now().UTC()
+		if request.StartDate != "" {
+			v.Date("startDate", request.StartDate)
+			start, _ = time.Parse("2006-01-02", request.StartDate)
+		}
+		var end time.Time
+		if v.Required("targetDate", request.TargetDate) {
+			v.Date("targetDate", request.TargetDate)
+			end, _ = time.Parse("2006-01-02", request.TargetDate)
+			if !end.IsZero() && !end.After(start) {
+				v.Add("targetDate", validation.CodeDate, nil)
+			}
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		currency, err := money.ChamaCurrency(db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		target, _ := money.Parse(request.Target, currency)
+
+		g := Goal{
+			ChamaID:     chamaID,
+			AccountID:   request.AccountID,
+			Title:       request.Title,
+			Description: request.Description,
+			Target:      target,
+			StartDate:   start,
+			TargetDate:  end,
+			CreatedBy:   userID,
+		}
+		if request.Personal {
+			g.MemberID, g.AccountID = userID, ""
+		}
+		g, err = Create(r.Context(), db, g)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(g)
+	}
+}
+
+// ProgressHandler returns the progress of the {chamaId} chama's goals and the
+// caller's personal goals, as shown on the dashboard
+func ProgressHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		progress, err := Track(r.Context(), db, notifier, chamaID, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(progress)
+	}
+}
+
+// CancelHandler cancels the {goalId} goal. Personal goals can be cancelled by
+// their owner, chama goals by officials.
+func CancelHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		g, err := Get(r.Context(), db, mux.Vars(r)["goalId"])
+		if err == sql.ErrNoRows || (err == nil && g.MemberID != "" && g.MemberID != userID) {
+			http.Error(w, "Goal not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if g.MemberID == "" && !chamas.IsOfficial(db, g.ChamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if err := Cancel(r.Context(), db, g.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
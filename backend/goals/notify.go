@@ -0,0 +1,112 @@
+package goals
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+)
+
+// Track measures every active goal of a chama visible to memberID, announces
+// newly reached milestones and returns the progress of each goal
+func Track(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, chamaID, memberID string) ([]Progress, error) {
+	list, err := List(ctx, db, chamaID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	progress := make([]Progress, 0, len(list))
+	for _, g := range list {
+		p, err := Measure(ctx, db, g)
+		if err != nil {
+			return nil, err
+		}
+		if g.Status == StatusActive {
+			check(ctx, db, notifier, p)
+		}
+		progress = append(progress, p)
+	}
+	return progress, nil
+}
+
+// check advances p's milestone and notifies the goal's audience
+func check(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, p Progress) {
+	milestone, err := advance(ctx, db, p)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to update goal milestone", "goal_id", p.ID, "error", err)
+		return
+	}
+	if milestone == 0 || notifier == nil {
+		return
+	}
+
+	titleKey, messageKey := "goal.milestone", "notification.goal_milestone"
+	if milestone >= 100 {
+		titleKey, messageKey = "goal.achieved", "notification.goal_achieved"
+	}
+	params := map[string]string{
+		"title":   p.Title,
+		"percent": strconv.Itoa(milestone),
+		"saved":   p.Saved.String(),
+		"target":  p.Target.String(),
+	}
+
+	recipients := []string{p.MemberID}
+	if p.MemberID == "" {
+		recipients = nil
+		rows, err := db.QueryContext(ctx, `
+			SELECT user_id FROM chama_members WHERE chama_id = ? AND status = 'active'`, p.ChamaID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load goal recipients", "goal_id", p.ID, "error", err)
+			return
+		}
+		for rows.Next() {
+			var id string
+			if rows.Scan(&id) == nil {
+				recipients = append(recipients, id)
+			}
+		}
+		rows.Close()
+	}
+
+	for _, userID := range recipients {
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    userID,
+			Title:     i18n.T(i18n.Default, titleKey, nil),
+			Message:   i18n.T(i18n.Default, messageKey, params),
+			Type:      notifications.TypeChama,
+			RelatedID: p.ID,
+		})
+	}
+}
+
+// RegisterTrackingJob checks every active goal each morning so milestones are
+// announced even when nobody opens the app
+func RegisterTrackingJob(s *jobs.Scheduler, db *sql.DB, notifier *notifications.Notifier) {
+	s.Register("goal_tracking", jobs.Daily{Hour: 7}, func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `SELECT DISTINCT chama_id FROM goals WHERE status = ?`, StatusActive)
+		if err != nil {
+			return err
+		}
+		var chamaIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			chamaIDs = append(chamaIDs, id)
+		}
+		rows.Close()
+
+		for _, id := range chamaIDs {
+			if _, err := Track(ctx, db, notifier, id, ""); err != nil {
+				slog.ErrorContext(ctx, "Failed to track goals", "chama_id", id, "error", err)
+			}
+		}
+		return nil
+	})
+}
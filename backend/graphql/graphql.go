@@ -0,0 +1,97 @@
+// Package graphql serves the treasurer dashboard's nested queries (chama →
+// members → contributions → arrears) at POST /api/graphql. Resolvers read
+// through the same services as the REST API and apply the same membership
+// checks. Records under a list of members are fetched by request-scoped
+// loaders, one query per chama rather than one per member.
+package graphql
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"tujifund-app/backend/tenancy"
+
+	gql "github.com/graph-gophers/graphql-go"
+)
+
+//go:embed schema.graphql
+var schema string
+
+// Limits on the queries accepted
+const (
+	MaxQuerySize   = 64 << 10
+	MaxDepth       = 8
+	MaxParallelism = 10
+)
+
+var (
+	// ErrForbidden is returned for data the caller's role does not let them see
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotMember is returned for a chama the caller is not an active member of
+	ErrNotMember = errors.New("not a member of this chama")
+)
+
+// Handler answers GraphQL queries from the signed-in user. db is the shared
+// database; chama records are read from the database tenants holds them in.
+func Handler(db *sql.DB, tenants *tenancy.Tenants) http.HandlerFunc {
+	s := gql.MustParseSchema(schema, &resolver{db: db, tenants: tenants},
+		gql.MaxDepth(MaxDepth), gql.MaxParallelism(MaxParallelism))
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var params struct {
+			Query         string                 `json:"query"`
+			OperationName string                 `json:"operationName"`
+			Variables     map[string]interface{} `json:"variables"`
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, MaxQuerySize)
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), requestKey{}, newRequest(db, tenants, userID))
+		res := s.Exec(ctx, params.Query, params.OperationName, params.Variables)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+type requestKey struct{}
+
+// request is the state of one query: who asked, and the loaders batching
+// its reads
+type request struct {
+	userID        string
+	contributions *loader[memberKey, []contributionResolver]
+	arrears       *loader[memberKey, []arrearResolver]
+}
+
+// memberKey names a member of a chama
+type memberKey struct {
+	chamaID  string
+	memberID string
+}
+
+func newRequest(db *sql.DB, tenants *tenancy.Tenants, userID string) *request {
+	return &request{
+		userID: userID,
+		contributions: newLoader(func(ctx context.Context, keys []memberKey) (map[memberKey][]contributionResolver, error) {
+			return loadContributions(ctx, tenants, keys)
+		}),
+		arrears: newLoader(func(ctx context.Context, keys []memberKey) (map[memberKey][]arrearResolver, error) {
+			return loadArrears(ctx, db, keys)
+		}),
+	}
+}
+
+func fromContext(ctx context.Context) *request {
+	return ctx.Value(requestKey{}).(*request)
+}
@@ -0,0 +1,238 @@
+package graphql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tujifund-app/backend/database/stmthook"
+	"tujifund-app/backend/tenancy"
+
+	_ "modernc.org/sqlite"
+)
+
+// queryLog records the statements run against the test database
+type queryLog struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func (l *queryLog) count(table string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, q := range l.queries {
+		if strings.Contains(q, "FROM "+table+" ") || strings.Contains(q, "FROM "+table+"\n") {
+			n++
+		}
+	}
+	return n
+}
+
+// testDB returns a chama with a treasurer, u1, and two members, u2 and u3,
+// each with contributions, and an arrear on u2
+func testDB(t *testing.T) (*sql.DB, *queryLog) {
+	t.Helper()
+	log := &queryLog{}
+	db, err := stmthook.Open("sqlite", "file:"+t.TempDir()+"/graphql.db", stmthook.Hook{
+		After: func(ctx context.Context, query string, start time.Time, err error) {
+			log.mu.Lock()
+			log.queries = append(log.queries, query)
+			log.mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	schema, err := os.ReadFile("../database/database_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		string(schema),
+		`INSERT INTO users (user_id, username, email, first_name) VALUES
+			('u1', 'achieng', 'u1@example.com', 'Achieng'), ('u2', 'baraka', 'u2@example.com', 'Baraka'),
+			('u3', 'chebet', 'u3@example.com', 'Chebet'), ('u4', 'duma', 'u4@example.com', 'Duma')`,
+		`INSERT INTO chamas (id, name, type, created_by) VALUES ('c1', 'Umoja', 'savings', 'u1')`,
+		`INSERT INTO chama_members (id, chama_id, user_id, role) VALUES
+			('m1', 'c1', 'u1', 'treasurer'), ('m2', 'c1', 'u2', 'member'), ('m3', 'c1', 'u3', 'member')`,
+		`INSERT INTO contributions (id, chama_id, member_id, account_id, amount_minor, contribution_date, status) VALUES
+			('k1', 'c1', 'u1', 'a1', 100000, '2026-09-01 10:00:00', 'completed'),
+			('k2', 'c1', 'u2', 'a1', 50000, '2026-09-02 10:00:00', 'completed'),
+			('k3', 'c1', 'u2', 'a1', 50000, '2026-10-02 10:00:00', 'pending'),
+			('k4', 'c1', 'u3', 'a1', 100000, '2026-09-03 10:00:00', 'completed')`,
+		`INSERT INTO arrears (id, chama_id, member_id, kind, source_id, amount_owed_minor, due_date, days_overdue) VALUES
+			('r1', 'c1', 'u2', 'contribution', 'c1', 50000, '2026-09-30', 16)`,
+	} {
+		if _, err := db.ExecContext(context.Background(), q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, log
+}
+
+type response struct {
+	Data struct {
+		Chama *struct {
+			Name    string `json:"name"`
+			Members []struct {
+				ID            string `json:"id"`
+				Contributions *[]struct {
+					ID     string `json:"id"`
+					Amount struct {
+						AmountMinor string `json:"amountMinor"`
+					} `json:"amount"`
+				} `json:"contributions"`
+				Arrears *[]struct {
+					Owed struct {
+						Formatted string `json:"formatted"`
+					} `json:"owed"`
+					DaysOverdue int `json:"daysOverdue"`
+				} `json:"arrears"`
+			} `json:"members"`
+			Arrears *[]struct {
+				ID string `json:"id"`
+			} `json:"arrears"`
+		} `json:"chama"`
+	} `json:"data"`
+	Errors []struct {
+		Message string        `json:"message"`
+		Path    []interface{} `json:"path"`
+	} `json:"errors"`
+}
+
+func query(t *testing.T, h http.HandlerFunc, userID, q string) response {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"query": q})
+	req := httptest.NewRequest(http.MethodPost, "/api/graphql", strings.NewReader(string(body)))
+	req = req.WithContext(context.WithValue(req.Context(), "userID", userID))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var res response
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+const dashboardQuery = `{
+	chama(id: "c1") {
+		name
+		members {
+			id
+			contributions(status: "completed") { id amount { amountMinor } }
+			arrears { owed { formatted } daysOverdue }
+		}
+		arrears { id }
+	}
+}`
+
+func TestDashboardQuery(t *testing.T) {
+	tests := []struct {
+		name          string
+		userID        string
+		contributions map[string]int // completed contributions returned per member; -1 for refused
+		arrears       map[string]int
+		chamaArrears  int // -1 for refused
+		errors        int
+	}{
+		{
+			name: "treasurer sees every member", userID: "u1",
+			contributions: map[string]int{"u1": 1, "u2": 1, "u3": 1},
+			arrears:       map[string]int{"u1": 0, "u2": 1, "u3": 0},
+			chamaArrears:  1,
+		},
+		{
+			name: "member sees their own", userID: "u2",
+			contributions: map[string]int{"u1": -1, "u2": 1, "u3": -1},
+			arrears:       map[string]int{"u1": -1, "u2": 1, "u3": -1},
+			chamaArrears:  -1,
+			errors:        5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, log := testDB(t)
+			res := query(t, Handler(db, tenancy.NewTenants(db, false, nil)), tt.userID, dashboardQuery)
+			if len(res.Errors) != tt.errors {
+				t.Errorf("got %d errors, want %d: %+v", len(res.Errors), tt.errors, res.Errors)
+			}
+			for _, e := range res.Errors {
+				if e.Message != ErrForbidden.Error() {
+					t.Errorf("error %q at %v, want %q", e.Message, e.Path, ErrForbidden)
+				}
+			}
+			c := res.Data.Chama
+			if c == nil || c.Name != "Umoja" || len(c.Members) != 3 {
+				t.Fatalf("chama = %+v", c)
+			}
+			for _, m := range c.Members {
+				if got := length(m.Contributions); got != tt.contributions[m.ID] {
+					t.Errorf("%s: %d contributions, want %d", m.ID, got, tt.contributions[m.ID])
+				}
+				if got := length(m.Arrears); got != tt.arrears[m.ID] {
+					t.Errorf("%s: %d arrears, want %d", m.ID, got, tt.arrears[m.ID])
+				}
+				if m.ID == "u2" && tt.arrears["u2"] == 1 {
+					if a := (*m.Arrears)[0]; a.Owed.Formatted != "KES 500.00" || a.DaysOverdue != 16 {
+						t.Errorf("u2 arrear = %+v", a)
+					}
+				}
+			}
+			if got := length(c.Arrears); got != tt.chamaArrears {
+				t.Errorf("chama arrears = %d, want %d", got, tt.chamaArrears)
+			}
+
+			// One query for every member's contributions, and one for their
+			// arrears besides the chama's own list
+			wantArrears := 1
+			if tt.chamaArrears >= 0 {
+				wantArrears++
+			}
+			if n := log.count("contributions"); n != 1 {
+				t.Errorf("contributions read in %d queries, want 1", n)
+			}
+			if n := log.count("arrears"); n != wantArrears {
+				t.Errorf("arrears read in %d queries, want %d", n, wantArrears)
+			}
+		})
+	}
+}
+
+func length[T any](list *[]T) int {
+	if list == nil {
+		return -1
+	}
+	return len(*list)
+}
+
+func TestChamaAccess(t *testing.T) {
+	db, _ := testDB(t)
+	h := Handler(db, tenancy.NewTenants(db, false, nil))
+
+	res := query(t, h, "u4", `{ chama(id: "c1") { name } }`)
+	if res.Data.Chama != nil || len(res.Errors) != 1 || res.Errors[0].Message != ErrNotMember.Error() {
+		t.Errorf("non-member got %+v", res)
+	}
+
+	res = query(t, h, "u1", `{ chama(id: "c1") { name summary { members totalSavings { amountMinor currency } } } }`)
+	if res.Data.Chama == nil || len(res.Errors) != 0 {
+		t.Errorf("summary: %+v", res)
+	}
+
+	res = query(t, h, "u1", `mutation { chama(id: "c1") { name } }`)
+	if len(res.Errors) == 0 {
+		t.Error("mutation was accepted")
+	}
+}
@@ -0,0 +1,118 @@
+package graphql
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"tujifund-app/backend/arrears"
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/tenancy"
+)
+
+// loader batches one kind of read made while resolving a request. Keys are
+// primed as their parents are resolved, e.g. each member of a list; the
+// first load then fetches every primed key not yet loaded in one call.
+// Later loads, including those of resolvers running alongside, are served
+// from what it fetched. A loader lives for one request, so nothing is
+// cached between requests or users.
+type loader[K comparable, V any] struct {
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	pending []K
+	loaded  map[K]V
+}
+
+func newLoader[K comparable, V any](fetch func(ctx context.Context, keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{fetch: fetch, loaded: map[K]V{}}
+}
+
+// prime adds keys to the next batch
+func (l *loader[K, V]) prime(keys ...K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, k := range keys {
+		if _, ok := l.loaded[k]; !ok {
+			l.pending = append(l.pending, k)
+		}
+	}
+}
+
+// load returns the value for key, fetching it with the primed keys if it
+// has not been fetched yet. Keys fetch finds nothing for get the zero value.
+func (l *loader[K, V]) load(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if v, ok := l.loaded[key]; ok {
+		return v, nil
+	}
+
+	seen := map[K]bool{key: true}
+	keys := []K{key}
+	for _, k := range l.pending {
+		if _, ok := l.loaded[k]; !ok && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	found, err := l.fetch(ctx, keys)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	l.pending = nil
+	for _, k := range keys {
+		l.loaded[k] = found[k]
+	}
+	return l.loaded[key], nil
+}
+
+// byChama groups member keys by chama
+func byChama(keys []memberKey) map[string][]string {
+	chamas := map[string][]string{}
+	for _, k := range keys {
+		chamas[k.chamaID] = append(chamas[k.chamaID], k.memberID)
+	}
+	return chamas
+}
+
+// loadContributions fetches the contributions of members, one query per
+// chama, from the chama's database
+func loadContributions(ctx context.Context, tenants *tenancy.Tenants, keys []memberKey) (map[memberKey][]contributionResolver, error) {
+	found := map[memberKey][]contributionResolver{}
+	for chamaID, memberIDs := range byChama(keys) {
+		ctx := tenancy.WithChama(ctx, chamaID)
+		db, err := tenants.For(ctx, chamaID)
+		if err != nil {
+			return nil, err
+		}
+		byMember, err := contributions.ForMembers(ctx, db, chamaID, memberIDs)
+		if err != nil {
+			return nil, err
+		}
+		for memberID, list := range byMember {
+			k := memberKey{chamaID, memberID}
+			for _, c := range list {
+				found[k] = append(found[k], contributionResolver{c})
+			}
+		}
+	}
+	return found, nil
+}
+
+// loadArrears fetches the open arrears of members, one query per chama
+func loadArrears(ctx context.Context, db *sql.DB, keys []memberKey) (map[memberKey][]arrearResolver, error) {
+	found := map[memberKey][]arrearResolver{}
+	for chamaID, memberIDs := range byChama(keys) {
+		list, err := arrears.List(tenancy.WithChama(ctx, chamaID), db, chamaID, arrears.Filter{MemberIDs: memberIDs})
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range list {
+			k := memberKey{chamaID, a.MemberID}
+			found[k] = append(found[k], arrearResolver{a})
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,244 @@
+package graphql
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/arrears"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/dashboard"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/tenancy"
+
+	gql "github.com/graph-gophers/graphql-go"
+)
+
+// resolver is the Query type
+type resolver struct {
+	db      *sql.DB
+	tenants *tenancy.Tenants
+}
+
+func (r *resolver) Chama(ctx context.Context, args struct{ ID gql.ID }) (*chamaResolver, error) {
+	chamaID := string(args.ID)
+	ctx = tenancy.WithChama(ctx, chamaID)
+	role, err := chamas.MemberRoleContext(ctx, r.db, chamaID, fromContext(ctx).userID)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		return nil, ErrNotMember
+	}
+	c := &chamaResolver{root: r, id: chamaID, official: slices.Contains(chamas.OfficialRoles, role)}
+	if err := r.db.QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, chamaID).Scan(&c.name); err != nil {
+		return nil, err
+	}
+	if c.currency, err = money.ChamaCurrencyContext(ctx, r.db, chamaID); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type chamaResolver struct {
+	root     *resolver
+	id       string
+	name     string
+	currency string
+	official bool // the caller is one of the chama's officials
+}
+
+func (c *chamaResolver) ID() gql.ID       { return gql.ID(c.id) }
+func (c *chamaResolver) Name() string     { return c.name }
+func (c *chamaResolver) Currency() string { return c.currency }
+
+func (c *chamaResolver) Summary(ctx context.Context) (*summaryResolver, error) {
+	s, err := dashboard.ChamaDashboard(tenancy.WithChama(ctx, c.id), c.root.db, c.id)
+	if err != nil {
+		return nil, err
+	}
+	return &summaryResolver{s}, nil
+}
+
+func (c *chamaResolver) Members(ctx context.Context, args struct{ Status, Role *string }) ([]*memberResolver, error) {
+	ctx = tenancy.WithChama(ctx, c.id)
+	db, err := c.root.tenants.For(ctx, c.id)
+	if err != nil {
+		return nil, err
+	}
+	query, qargs := chamas.MembersQuery(c.id, deref(args.Status), deref(args.Role))
+	rows, err := tenancy.Scoped(db).On("m.chama_id").QueryContext(ctx, query, qargs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	req := fromContext(ctx)
+	members := []*memberResolver{}
+	var visible []memberKey
+	for rows.Next() {
+		m, err := chamas.ScanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		r := &memberResolver{chama: c, m: m}
+		if r.visible(req) {
+			visible = append(visible, r.key())
+		}
+		members = append(members, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// The members' contributions and arrears are fetched together when the
+	// first of them is asked for
+	req.contributions.prime(visible...)
+	req.arrears.prime(visible...)
+	return members, nil
+}
+
+func (c *chamaResolver) Arrears(ctx context.Context, args struct {
+	Kind    *string
+	MinDays *int32
+}) (*[]*arrearResolver, error) {
+	if !c.official {
+		return nil, ErrForbidden
+	}
+	f := arrears.Filter{Kind: deref(args.Kind)}
+	if args.MinDays != nil {
+		f.MinDays = int(*args.MinDays)
+	}
+	list, err := arrears.List(tenancy.WithChama(ctx, c.id), c.root.db, c.id, f)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*arrearResolver, len(list))
+	for i, a := range list {
+		result[i] = &arrearResolver{a}
+	}
+	return &result, nil
+}
+
+type summaryResolver struct {
+	s dashboard.ChamaSummary
+}
+
+func (s *summaryResolver) Members() int32               { return int32(s.s.Members) }
+func (s *summaryResolver) TotalSavings() *moneyResolver { return &moneyResolver{s.s.TotalSavings} }
+func (s *summaryResolver) OutstandingLoans() *moneyResolver {
+	return &moneyResolver{s.s.OutstandingLoans}
+}
+func (s *summaryResolver) Cycle() *cycleResolver        { return &cycleResolver{s.s.Cycle} }
+func (s *summaryResolver) Expected() *moneyResolver     { return &moneyResolver{s.s.Expected} }
+func (s *summaryResolver) Collected() *moneyResolver    { return &moneyResolver{s.s.Collected} }
+func (s *summaryResolver) CollectionRateBps() int32     { return int32(s.s.CollectionRateBps) }
+func (s *summaryResolver) PaidMembers() int32           { return int32(s.s.PaidMembers) }
+func (s *summaryResolver) ArrearsCycle() *cycleResolver { return &cycleResolver{s.s.ArrearsCycle} }
+func (s *summaryResolver) ArrearsCount() int32          { return int32(s.s.ArrearsCount) }
+func (s *summaryResolver) UpdatedAt() string            { return s.s.UpdatedAt.UTC().Format(time.RFC3339) }
+
+type cycleResolver struct {
+	c dashboard.Cycle
+}
+
+func (c *cycleResolver) Start() string { return c.c.Start.Format("2006-01-02") }
+func (c *cycleResolver) End() string   { return c.c.End.Format("2006-01-02") }
+func (c *cycleResolver) Due() string   { return c.c.Due.Format("2006-01-02") }
+
+type memberResolver struct {
+	chama *chamaResolver
+	m     chamas.MemberRecord
+}
+
+func (m *memberResolver) ID() gql.ID          { return gql.ID(m.m.UserID) }
+func (m *memberResolver) Username() string    { return m.m.Username }
+func (m *memberResolver) FirstName() string   { return m.m.FirstName }
+func (m *memberResolver) LastName() string    { return m.m.LastName }
+func (m *memberResolver) Email() string       { return m.m.Email }
+func (m *memberResolver) PhoneNumber() string { return m.m.Phone }
+func (m *memberResolver) Role() string        { return m.m.Role }
+func (m *memberResolver) Status() string      { return m.m.Status }
+func (m *memberResolver) JoinDate() string    { return m.m.JoinDate }
+
+func (m *memberResolver) key() memberKey { return memberKey{m.chama.id, m.m.UserID} }
+
+// visible reports whether the caller may see the member's money: their own,
+// or anyone's for officials
+func (m *memberResolver) visible(req *request) bool {
+	return m.chama.official || m.m.UserID == req.userID
+}
+
+func (m *memberResolver) Contributions(ctx context.Context, args struct{ Status *string }) (*[]*contributionResolver, error) {
+	req := fromContext(ctx)
+	if !m.visible(req) {
+		return nil, ErrForbidden
+	}
+	list, err := req.contributions.load(ctx, m.key())
+	if err != nil {
+		return nil, err
+	}
+	result := []*contributionResolver{}
+	for i := range list {
+		if args.Status == nil || list[i].c.Status == *args.Status {
+			result = append(result, &list[i])
+		}
+	}
+	return &result, nil
+}
+
+func (m *memberResolver) Arrears(ctx context.Context) (*[]*arrearResolver, error) {
+	req := fromContext(ctx)
+	if !m.visible(req) {
+		return nil, ErrForbidden
+	}
+	list, err := req.arrears.load(ctx, m.key())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*arrearResolver, len(list))
+	for i := range list {
+		result[i] = &list[i]
+	}
+	return &result, nil
+}
+
+type contributionResolver struct {
+	c contributions.Contribution
+}
+
+func (c *contributionResolver) ID() gql.ID             { return gql.ID(c.c.ID) }
+func (c *contributionResolver) Amount() *moneyResolver { return &moneyResolver{c.c.Amount} }
+func (c *contributionResolver) Date() string           { return c.c.Date.UTC().Format(time.RFC3339) }
+func (c *contributionResolver) PaymentMethod() string  { return c.c.Method }
+func (c *contributionResolver) Reference() string      { return c.c.Reference }
+func (c *contributionResolver) Status() string         { return c.c.Status }
+
+type arrearResolver struct {
+	a arrears.Arrear
+}
+
+func (a *arrearResolver) ID() gql.ID             { return gql.ID(a.a.ID) }
+func (a *arrearResolver) Kind() string           { return a.a.Kind }
+func (a *arrearResolver) SourceID() gql.ID       { return gql.ID(a.a.SourceID) }
+func (a *arrearResolver) Owed() *moneyResolver   { return &moneyResolver{a.a.Owed} }
+func (a *arrearResolver) DueDate() string        { return a.a.DueDate }
+func (a *arrearResolver) DaysOverdue() int32     { return int32(a.a.DaysOverdue) }
+func (a *arrearResolver) EscalationLevel() int32 { return int32(a.a.EscalationLevel) }
+
+type moneyResolver struct {
+	m money.Money
+}
+
+func (m *moneyResolver) AmountMinor() string { return strconv.FormatInt(m.m.Amount, 10) }
+func (m *moneyResolver) Currency() string    { return m.m.Currency }
+func (m *moneyResolver) Formatted() string   { return m.m.String() }
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
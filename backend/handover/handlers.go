@@ -0,0 +1,221 @@
+package handover
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// StartHandler starts a handover of the {chamaId} chama's treasurer role.
+// The chairperson, an admin or the treasurer themselves may start it;
+// outgoingId defaults to the chama's treasurer.
+func StartHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			OutgoingID    string `json:"outgoingId"`
+			IncomingID    string `json:"incomingId"`
+			EffectiveDate string `json:"effectiveDate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.OutgoingID == "" {
+			if chamas.HasRole(db, chamaID, userID, chamas.RoleTreasurer) {
+				request.OutgoingID = userID
+			} else if ids, err := chamas.MembersWithRole(db, chamaID, chamas.RoleTreasurer); err == nil && len(ids) == 1 {
+				request.OutgoingID = ids[0]
+			}
+		}
+		v := validation.New()
+		v.Required("outgoingId", request.OutgoingID)
+		v.Required("incomingId", request.IncomingID)
+		if request.EffectiveDate != "" {
+			v.Date("effectiveDate", request.EffectiveDate)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		if chamas.HasRole(db, chamaID, userID, chamas.RoleTreasurer) && request.OutgoingID != userID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var effective time.Time
+		if request.EffectiveDate != "" {
+			effective, _ = time.Parse("2006-01-02", request.EffectiveDate)
+		}
+		h, err := Start(r.Context(), db, chamaID, request.OutgoingID, request.IncomingID, effective, userID)
+		switch {
+		case errors.Is(err, ErrInProgress):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		notifyParties(r.Context(), db, notifier, h)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(h)
+	}
+}
+
+// ListHandler lists the {chamaId} chama's handovers. Officials only.
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := List(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// GetHandler returns the {handoverId} handover with its report
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := loadHandover(db, w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h)
+	}
+}
+
+// AcknowledgeHandler records the caller's acknowledgement of the {handoverId}
+// handover report, completing the handover once all parties have
+func AcknowledgeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := loadHandover(db, w, r)
+		if !ok {
+			return
+		}
+		h, err := Acknowledge(r.Context(), db, h.ID, audit.FromRequest(r, audit.Entry{}))
+		writeResult(w, h, err)
+	}
+}
+
+// CancelHandler abandons the pending {handoverId} handover. The chairperson,
+// an admin or the outgoing treasurer may cancel it.
+func CancelHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := loadHandover(db, w, r)
+		if !ok {
+			return
+		}
+		userID := r.Context().Value("userID").(string)
+		if userID != h.OutgoingID && !chamas.HasRole(db, h.ChamaID, userID, chamas.RoleAdmin, chamas.RoleChairperson) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h, err := Cancel(r.Context(), db, h.ID, audit.FromRequest(r, audit.Entry{}))
+		writeResult(w, h, err)
+	}
+}
+
+func writeResult(w http.ResponseWriter, h Handover, err error) {
+	switch {
+	case errors.Is(err, ErrNotPending), errors.Is(err, ErrAcknowledged):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ErrNotParty):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+// loadHandover loads the {handoverId} handover for one of its parties or an
+// official of its chama, writing an error response and returning false otherwise
+func loadHandover(db *sql.DB, w http.ResponseWriter, r *http.Request) (Handover, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Handover{}, false
+	}
+	h, err := Get(r.Context(), db, mux.Vars(r)["handoverId"])
+	if err == sql.ErrNoRows || (err == nil && !chamas.IsMember(db, h.ChamaID, userID)) {
+		http.Error(w, "Handover not found", http.StatusNotFound)
+		return h, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return h, false
+	}
+	if userID != h.OutgoingID && userID != h.IncomingID && !chamas.IsOfficial(db, h.ChamaID, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return h, false
+	}
+	return h, true
+}
+
+// notifyParties asks the treasurers and the chairperson, other than whoever
+// started h, to review and acknowledge its report
+func notifyParties(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, h Handover) {
+	if notifier == nil {
+		return
+	}
+	chairs, err := chamas.MembersWithRole(db, h.ChamaID, chamas.RoleChairperson)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load chairperson for handover", "handover_id", h.ID, "error", err)
+	}
+	if len(chairs) == 0 {
+		chairs, _ = chamas.MembersWithRole(db, h.ChamaID, chamas.RoleAdmin)
+	}
+	for _, id := range append([]string{h.OutgoingID, h.IncomingID}, chairs...) {
+		if id == h.InitiatedBy {
+			continue
+		}
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "handover.started", nil),
+			Message:   i18n.T(i18n.Default, "notification.handover_started", nil),
+			Type:      notifications.TypeChama,
+			RelatedID: h.ID,
+		})
+	}
+}
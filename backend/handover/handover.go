@@ -0,0 +1,299 @@
+package handover
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+
+	"github.com/google/uuid"
+)
+
+// Handover statuses
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+)
+
+var (
+	// ErrInProgress is returned when starting a handover while another is pending
+	ErrInProgress = errors.New("a treasurer handover is already in progress")
+	// ErrNotPending is returned when acknowledging or cancelling a finished handover
+	ErrNotPending = errors.New("handover is no longer pending")
+	// ErrNotParty is returned when someone other than the treasurers or the chairperson acknowledges
+	ErrNotParty = errors.New("only the outgoing and incoming treasurers and the chairperson acknowledge a handover")
+	// ErrAcknowledged is returned when a party acknowledges twice
+	ErrAcknowledged = errors.New("handover has already been acknowledged")
+)
+
+// Handover passes the treasurer role from one member to another
+type Handover struct {
+	ID            string    `json:"id"`
+	ChamaID       string    `json:"chamaId"`
+	OutgoingID    string    `json:"outgoingId"`
+	IncomingID    string    `json:"incomingId"`
+	EffectiveAt   string    `json:"effectiveAt,omitempty"`
+	Status        string    `json:"status"`
+	Report        Report    `json:"report"`
+	InitiatedBy   string    `json:"initiatedBy"`
+	OutgoingAckAt string    `json:"outgoingAcknowledgedAt,omitempty"`
+	IncomingAckAt string    `json:"incomingAcknowledgedAt,omitempty"`
+	ChairAckBy    string    `json:"chairAcknowledgedBy,omitempty"`
+	ChairAckAt    string    `json:"chairAcknowledgedAt,omitempty"`
+	CompletedAt   string    `json:"completedAt,omitempty"`
+	CancelledBy   string    `json:"cancelledBy,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+const columns = `id, chama_id, outgoing_id, incoming_id, COALESCE(effective_at, ''), status, report, initiated_by,
+	COALESCE(outgoing_ack_at, ''), COALESCE(incoming_ack_at, ''), COALESCE(chair_ack_by, ''), COALESCE(chair_ack_at, ''),
+	COALESCE(completed_at, ''), COALESCE(cancelled_by, ''), created_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Handover, error) {
+	var h Handover
+	var report string
+	err := row.Scan(&h.ID, &h.ChamaID, &h.OutgoingID, &h.IncomingID, &h.EffectiveAt, &h.Status, &report, &h.InitiatedBy,
+		&h.OutgoingAckAt, &h.IncomingAckAt, &h.ChairAckBy, &h.ChairAckAt, &h.CompletedAt, &h.CancelledBy, &h.CreatedAt)
+	if err != nil {
+		return h, err
+	}
+	return h, json.Unmarshal([]byte(report), &h.Report)
+}
+
+// Get returns a single handover
+func Get(ctx context.Context, db *sql.DB, id string) (Handover, error) {
+	return scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM treasurer_handovers WHERE id = ?`, id))
+}
+
+// List returns a chama's handovers, newest first
+func List(ctx context.Context, db *sql.DB, chamaID string) ([]Handover, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+columns+` FROM treasurer_handovers WHERE chama_id = ? ORDER BY created_at DESC`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Handover{}
+	for rows.Next() {
+		h, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, h)
+	}
+	return list, rows.Err()
+}
+
+// Start opens a handover from the chama's treasurer to another member and
+// takes the handover report. effectiveAt, when not zero, backdates the
+// change to when the new treasurer actually took over; it may not be in the
+// future.
+func Start(ctx context.Context, db *sql.DB, chamaID, outgoingID, incomingID string, effectiveAt time.Time, initiatedBy string) (Handover, error) {
+	if !chamas.HasRole(db, chamaID, outgoingID, chamas.RoleTreasurer) {
+		return Handover{}, errors.New("outgoing member is not the chama's treasurer")
+	}
+	if outgoingID == incomingID || !chamas.IsMember(db, chamaID, incomingID) ||
+		chamas.HasRole(db, chamaID, incomingID, chamas.RoleTreasurer) {
+		return Handover{}, errors.New("incoming treasurer must be another member who is not already treasurer")
+	}
+	if effectiveAt.After(time.Now()) {
+		return Handover{}, errors.New("effective date cannot be in the future")
+	}
+	var pending bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM treasurer_handovers WHERE chama_id = ? AND status = ?)`,
+		chamaID, StatusPending).Scan(&pending)
+	if err != nil {
+		return Handover{}, err
+	}
+	if pending {
+		return Handover{}, ErrInProgress
+	}
+
+	report, err := BuildReport(ctx, db, chamaID, outgoingID)
+	if err != nil {
+		return Handover{}, err
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		return Handover{}, err
+	}
+	var effective interface{}
+	if !effectiveAt.IsZero() {
+		effective = effectiveAt.UTC().Format("2006-01-02 15:04:05")
+	}
+	id := uuid.NewString()
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO treasurer_handovers (id, chama_id, outgoing_id, incoming_id, effective_at, report, initiated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, chamaID, outgoingID, incomingID, effective, string(b), initiatedBy)
+	if err != nil {
+		return Handover{}, fmt.Errorf("failed to start handover: %w", err)
+	}
+	return Get(ctx, db, id)
+}
+
+// Acknowledge records that one of the parties has accepted the handover
+// report. The chairperson's acknowledgement may come from an admin when the
+// chama has no chairperson. Once all three have acknowledged, the handover
+// completes.
+func Acknowledge(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Handover, error) {
+	h, err := Get(ctx, db, id)
+	if err != nil {
+		return h, err
+	}
+	if h.Status != StatusPending {
+		return h, ErrNotPending
+	}
+
+	var column, already string
+	switch {
+	case entry.UserID == h.OutgoingID:
+		column, already = "outgoing_ack_at", h.OutgoingAckAt
+	case entry.UserID == h.IncomingID:
+		column, already = "incoming_ack_at", h.IncomingAckAt
+	case chamas.HasRole(db, h.ChamaID, entry.UserID, chamas.RoleChairperson) || (chamas.HasRole(db, h.ChamaID, entry.UserID, chamas.RoleAdmin) && !hasChairperson(db, h.ChamaID)):
+		column, already = "chair_ack_at", h.ChairAckAt
+	default:
+		return h, ErrNotParty
+	}
+	if already != "" {
+		return h, ErrAcknowledged
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return h, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE treasurer_handovers SET ` + column + ` = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
+	args := []interface{}{id, StatusPending}
+	if column == "chair_ack_at" {
+		query = `UPDATE treasurer_handovers SET chair_ack_at = CURRENT_TIMESTAMP, chair_ack_by = ? WHERE id = ? AND status = ?`
+		args = append([]interface{}{entry.UserID}, args...)
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return h, err
+	}
+	ack := entry
+	ack.Action, ack.EntityType, ack.EntityID = "handover.acknowledge", "treasurer_handover", id
+	ack.NewValues = map[string]string{"acknowledged": column}
+	if err := audit.Record(ctx, tx, ack); err != nil {
+		return h, err
+	}
+
+	var outgoing, incoming, chair sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT outgoing_ack_at, incoming_ack_at, chair_ack_at FROM treasurer_handovers WHERE id = ?`, id,
+	).Scan(&outgoing, &incoming, &chair)
+	if err != nil {
+		return h, err
+	}
+	if outgoing.Valid && incoming.Valid && chair.Valid {
+		if err := complete(ctx, tx, h, entry); err != nil {
+			return h, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return h, err
+	}
+	return Get(ctx, db, id)
+}
+
+// complete swaps the roles and reassigns the outgoing treasurer's pending
+// requests to the incoming treasurer. Each role change is audited with the
+// date it took effect.
+func complete(ctx context.Context, tx *sql.Tx, h Handover, entry audit.Entry) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	effective := h.EffectiveAt
+	if effective == "" {
+		effective = now
+	}
+
+	changes := []struct{ userID, from, to string }{
+		{h.OutgoingID, chamas.RoleTreasurer, chamas.RoleMember},
+		{h.IncomingID, "", chamas.RoleTreasurer},
+	}
+	for _, c := range changes {
+		var memberID, role string
+		err := tx.QueryRowContext(ctx, `
+			SELECT id, role FROM chama_members WHERE chama_id = ? AND user_id = ? AND status = 'active'`,
+			h.ChamaID, c.userID).Scan(&memberID, &role)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("member %s has left the chama", c.userID)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE chama_members SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, c.to, memberID); err != nil {
+			return err
+		}
+		e := entry
+		e.Action, e.EntityType, e.EntityID, e.EffectiveAt = "chama.role_change", "chama_member", memberID, effective
+		e.OldValues = map[string]string{"role": role}
+		e.NewValues = map[string]string{"role": c.to, "userId": c.userID, "chamaId": h.ChamaID, "handoverId": h.ID}
+		if err := audit.Record(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range reassignable {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE `+table+` SET requested_by = ? WHERE chama_id = ? AND requested_by = ? AND status = 'pending'`,
+			h.IncomingID, h.ChamaID, h.OutgoingID); err != nil {
+			return fmt.Errorf("failed to reassign pending %s: %w", table, err)
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		UPDATE treasurer_handovers SET status = ?, completed_at = ?, effective_at = ? WHERE id = ?`,
+		StatusCompleted, now, effective, h.ID)
+	if err != nil {
+		return err
+	}
+	e := entry
+	e.Action, e.EntityType, e.EntityID, e.EffectiveAt = "handover.complete", "treasurer_handover", h.ID, effective
+	e.NewValues = map[string]string{"outgoingId": h.OutgoingID, "incomingId": h.IncomingID}
+	return audit.Record(ctx, tx, e)
+}
+
+// Cancel abandons a pending handover; nothing it would have changed is touched
+func Cancel(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Handover, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Handover{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE treasurer_handovers SET status = ?, cancelled_by = ? WHERE id = ? AND status = ?`,
+		StatusCancelled, entry.UserID, id, StatusPending)
+	if err != nil {
+		return Handover{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Handover{}, ErrNotPending
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "handover.cancel", "treasurer_handover", id
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return Handover{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Handover{}, err
+	}
+	return Get(ctx, db, id)
+}
+
+func hasChairperson(db *sql.DB, chamaID string) bool {
+	ids, err := chamas.MembersWithRole(db, chamaID, chamas.RoleChairperson)
+	return err == nil && len(ids) > 0
+}
@@ -0,0 +1,106 @@
+package handover
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"tujifund-app/backend/funds"
+	"tujifund-app/backend/money"
+)
+
+// reassignable are the tables of requests awaiting approval that pass from
+// the outgoing to the incoming treasurer
+var reassignable = []string{"fund_transfers", "expenses", "loan_changes"}
+
+// Report is what the incoming treasurer takes over: every fund's balance,
+// loans not yet disbursed, requests awaiting approval and items that have
+// not been reconciled
+type Report struct {
+	GeneratedAt      time.Time    `json:"generatedAt"`
+	Funds            []funds.Fund `json:"funds"`
+	PendingLoans     []Item       `json:"pendingLoans"`
+	PendingApprovals []Item       `json:"pendingApprovals"`
+	Unreconciled     []Item       `json:"unreconciled"`
+}
+
+// Item is one line of a handover report. Reassigned is set on requests made
+// by the outgoing treasurer, which pass to the incoming treasurer.
+type Item struct {
+	Kind        string      `json:"kind"`
+	ID          string      `json:"id"`
+	Description string      `json:"description"`
+	MemberID    string      `json:"memberId,omitempty"`
+	Amount      money.Money `json:"amount"`
+	Status      string      `json:"status"`
+	Date        string      `json:"date"`
+	Reassigned  bool        `json:"reassigned,omitempty"`
+}
+
+// BuildReport takes the handover report for a chama whose treasurer is outgoingID
+func BuildReport(ctx context.Context, db *sql.DB, chamaID, outgoingID string) (Report, error) {
+	rep := Report{GeneratedAt: time.Now().UTC()}
+	var err error
+	if rep.Funds, err = funds.List(ctx, db, chamaID); err != nil {
+		return rep, err
+	}
+
+	sections := []struct {
+		list  *[]Item
+		query string
+		args  []interface{}
+	}{
+		{&rep.PendingLoans, `
+			SELECT 'loan_application', id, COALESCE(purpose, ''), user_id, amount_minor, currency, status,
+			       application_date, 0
+			FROM loan_applications WHERE chama_id = ? AND status IN ('pending', 'approved')
+			ORDER BY application_date`,
+			[]interface{}{chamaID}},
+		{&rep.PendingApprovals, `
+			SELECT 'fund_transfer', id, reason, '', amount_minor, currency, status, created_at, requested_by = ?
+			FROM fund_transfers WHERE chama_id = ? AND status = 'pending'
+			UNION ALL
+			SELECT 'expense', id, description, '', amount_minor, currency, status, incurred_at, requested_by = ?
+			FROM expenses WHERE chama_id = ? AND status = 'pending'
+			UNION ALL
+			SELECT 'loan_change', c.id, c.kind || ': ' || c.reason, l.borrower_id, c.top_up_minor, c.currency, c.status,
+			       c.created_at, c.requested_by = ?
+			FROM loan_changes c JOIN loans l ON l.id = c.loan_id WHERE c.chama_id = ? AND c.status = 'pending'
+			ORDER BY 8`,
+			[]interface{}{outgoingID, chamaID, outgoingID, chamaID, outgoingID, chamaID}},
+		{&rep.Unreconciled, `
+			SELECT 'contribution', id, COALESCE(transaction_reference, ''), member_id, amount_minor, currency, status,
+			       contribution_date, 0
+			FROM contributions WHERE chama_id = ? AND status IN ('pending', 'failed')
+			UNION ALL
+			SELECT 'expense_without_receipt', id, description, '', amount_minor, currency, status, incurred_at, 0
+			FROM expenses WHERE chama_id = ? AND status = 'approved' AND storage_key IS NULL
+			ORDER BY 8`,
+			[]interface{}{chamaID, chamaID}},
+	}
+	for _, s := range sections {
+		if *s.list, err = items(ctx, db, s.query, s.args...); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+func items(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]Item, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.Kind, &it.ID, &it.Description, &it.MemberID, &it.Amount.Amount, &it.Amount.Currency,
+			&it.Status, &it.Date, &it.Reassigned); err != nil {
+			return nil, err
+		}
+		list = append(list, it)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,250 @@
+// Package httpclient is the outbound HTTP client shared by the provider
+// integrations: payment providers, the exchange-rate API and object
+// storage. It applies a timeout to every attempt, retries idempotent
+// requests with jittered backoff, signs or authenticates each attempt
+// afresh, and logs requests and responses with secrets redacted.
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Config describes one provider's client. Zero fields take the defaults.
+type Config struct {
+	Provider   string        // names the provider in logs
+	Timeout    time.Duration // for each attempt; 30 seconds by default
+	Retries    int           // further attempts for idempotent requests; 2 by default, negative for none
+	MinBackoff time.Duration // first retry delay before jitter; 200 milliseconds by default
+	MaxBackoff time.Duration // longest delay between attempts; 5 seconds by default
+	// Tokens, when set, authenticates every attempt with a bearer token.
+	// A 401 reply discards the token and the request is tried once more.
+	Tokens *Tokens
+	// Sign, when set, is called before every attempt, so that signatures
+	// carrying a timestamp are fresh on retries
+	Sign func(req *http.Request) error
+	// Redact names fields logged as "[redacted]" besides the usual secrets
+	Redact []string
+	// Sensitive replies, e.g. from a secrets store, never have their
+	// bodies logged
+	Sensitive bool
+}
+
+// Client sends requests for one provider
+type Client struct {
+	conf Config
+	http *http.Client
+}
+
+// New creates a client
+func New(conf Config) *Client {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 30 * time.Second
+	}
+	if conf.Retries == 0 {
+		conf.Retries = 2
+	}
+	if conf.MinBackoff <= 0 {
+		conf.MinBackoff = 200 * time.Millisecond
+	}
+	if conf.MaxBackoff <= 0 {
+		conf.MaxBackoff = 5 * time.Second
+	}
+	return &Client{conf: conf, http: &http.Client{Timeout: conf.Timeout}}
+}
+
+type retryableKey struct{}
+
+// IdempotencyHeader carries the key that lets a provider recognise a
+// repeated request
+const IdempotencyHeader = "Idempotency-Key"
+
+// WithIdempotencyKey sets req's idempotency key, which the provider uses to
+// carry out a repeated request only once, making it safe to retry. key
+// should identify the operation, e.g. the contribution it pays for, so that
+// it is the same on every attempt.
+func WithIdempotencyKey(req *http.Request, key string) *http.Request {
+	req.Header.Set(IdempotencyHeader, key)
+	return Retryable(req)
+}
+
+// Retryable marks req as safe to repeat although its method is not, e.g. a
+// status query the provider takes as a POST
+func Retryable(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), retryableKey{}, true))
+}
+
+// idempotent reports whether req may be sent more than once
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	retryable, _ := req.Context().Value(retryableKey{}).(bool)
+	return retryable
+}
+
+// NewJSONRequest creates a request with payload as its JSON body, or no
+// body if payload is nil
+func NewJSONRequest(ctx context.Context, method, url string, payload interface{}) (*http.Request, error) {
+	body := io.Reader(http.NoBody)
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// Do sends req. Transport errors and replies of 408, 429, 500, 502, 503
+// and 504 are retried when req is idempotent and its body can be sent
+// again, waiting as the provider's Retry-After asks or with jittered
+// exponential backoff. The last reply is returned whatever its status.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	retries := 0
+	if idempotent(req) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil) {
+		retries = max(c.conf.Retries, 0)
+	}
+	reauthorised := false
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		if c.conf.Tokens != nil {
+			token, err := c.conf.Tokens.Token(ctx)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if c.conf.Sign != nil {
+			if err := c.conf.Sign(req); err != nil {
+				return nil, err
+			}
+		}
+
+		start := time.Now()
+		c.logRequest(req, attempt)
+		resp, err := c.http.Do(req)
+		c.logResponse(req, resp, err, time.Since(start))
+
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return nil, err
+		case err == nil && resp.StatusCode == http.StatusUnauthorized && c.conf.Tokens != nil && !reauthorised:
+			// The token was revoked or expired early; nothing was done
+			drain(resp)
+			c.conf.Tokens.Invalidate()
+			reauthorised = true
+			attempt--
+			continue
+		case attempt >= retries || (err == nil && !retryStatus(resp.StatusCode)):
+			return resp, err
+		}
+
+		wait := c.backoff(attempt)
+		if err == nil {
+			if after := retryAfter(resp); after > 0 {
+				wait = min(after, c.conf.MaxBackoff)
+			}
+			drain(resp)
+		}
+		slog.WarnContext(ctx, "Retrying provider request", "provider", c.conf.Provider, "method", req.Method,
+			"url", redactURL(req.URL, c.conf.Redact), "attempt", attempt+1, "wait", wait, "error", describe(resp, err))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff returns a random delay of up to MinBackoff doubled for each
+// earlier attempt, so that clients recovering together do not retry together
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := min(c.conf.MinBackoff<<attempt, c.conf.MaxBackoff)
+	return ceiling/2 + rand.N(ceiling/2+1)
+}
+
+func retryStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter reads a Retry-After header given in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// drain discards a reply that will not be read so its connection is reused
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
+
+func describe(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// ErrStatus is wrapped by StatusError
+var ErrStatus = errors.New("provider returned an error status")
+
+// StatusError describes a reply with an unexpected status
+type StatusError struct {
+	Status int
+	Body   string // the start of the reply, for the logs
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return strconv.Itoa(e.Status) + " " + http.StatusText(e.Status)
+	}
+	return strconv.Itoa(e.Status) + " " + http.StatusText(e.Status) + ": " + e.Body
+}
+
+func (e *StatusError) Unwrap() error { return ErrStatus }
+
+// CheckStatus returns a StatusError, and closes the body, unless resp has
+// a 2xx status
+func CheckStatus(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &StatusError{Status: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+}
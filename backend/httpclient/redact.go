@@ -0,0 +1,163 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLoggedBody is how much of a request or reply body is logged
+const maxLoggedBody = 4 << 10
+
+const redacted = "[redacted]"
+
+// secretWords mark field names whose values are never logged. Names are
+// compared in lower case with "_" and "-" removed.
+var secretWords = []string{"password", "secret", "token", "credential", "passkey", "apikey", "accesskey", "signature"}
+
+// secretNames are field names that are secrets only when they match exactly
+var secretNames = map[string]bool{"pin": true, "cvc": true, "cvv": true, "authorization": true}
+
+// secret reports whether a field called name holds a secret
+func secret(name string, extra []string) bool {
+	n := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(name))
+	if secretNames[n] {
+		return true
+	}
+	for _, w := range secretWords {
+		if strings.Contains(n, w) {
+			return true
+		}
+	}
+	for _, e := range extra {
+		if strings.EqualFold(name, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL returns u with secret query parameters and any password hidden
+func redactURL(u *url.URL, extra []string) string {
+	c := *u
+	if c.User != nil {
+		c.User = url.User(c.User.Username())
+	}
+	if c.RawQuery != "" {
+		c.RawQuery = redactValues(c.Query(), extra).Encode()
+	}
+	return c.String()
+}
+
+func redactValues(values url.Values, extra []string) url.Values {
+	out := url.Values{}
+	for k, v := range values {
+		if secret(k, extra) {
+			v = []string{redacted}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// redactBody returns body, a JSON or form-encoded document, with secret
+// fields hidden. Only the size of bodies in other formats is logged.
+func redactBody(body []byte, contentType string, extra []string) string {
+	switch {
+	case len(body) == 0:
+		return ""
+	case strings.Contains(contentType, "json") || json.Valid(body):
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "[invalid JSON]"
+		}
+		out, _ := json.Marshal(redactJSON(doc, extra))
+		return truncate(string(out))
+	case strings.Contains(contentType, "x-www-form-urlencoded"):
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "[invalid form]"
+		}
+		return truncate(redactValues(values, extra).Encode())
+	}
+	return "[" + strconv.Itoa(len(body)) + " bytes]"
+}
+
+func redactJSON(v interface{}, extra []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if secret(k, extra) {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redactJSON(field, extra)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactJSON(v[i], extra)
+		}
+	}
+	return v
+}
+
+func truncate(s string) string {
+	if len(s) > maxLoggedBody {
+		return s[:maxLoggedBody] + "…"
+	}
+	return s
+}
+
+// debug reports whether bodies are logged, which costs a copy of each
+func debug(ctx context.Context) bool {
+	return slog.Default().Enabled(ctx, slog.LevelDebug)
+}
+
+// logRequest logs an outgoing attempt, with its body at debug level
+func (c *Client) logRequest(req *http.Request, attempt int) {
+	ctx := req.Context()
+	if !debug(ctx) {
+		return
+	}
+	var body string
+	if req.GetBody != nil {
+		if r, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(io.LimitReader(r, 64<<10))
+			r.Close()
+			body = redactBody(data, req.Header.Get("Content-Type"), c.conf.Redact)
+		}
+	}
+	slog.DebugContext(ctx, "Provider request", "provider", c.conf.Provider, "method", req.Method,
+		"url", redactURL(req.URL, c.conf.Redact), "attempt", attempt+1, "body", body)
+}
+
+// logResponse logs the outcome of an attempt. At debug level the start of
+// the reply is logged too, and put back for the caller to read.
+func (c *Client) logResponse(req *http.Request, resp *http.Response, err error, took time.Duration) {
+	ctx := req.Context()
+	attrs := []interface{}{"provider", c.conf.Provider, "method", req.Method,
+		"url", redactURL(req.URL, c.conf.Redact), "duration_ms", took.Milliseconds()}
+	if err != nil {
+		slog.WarnContext(ctx, "Provider request failed", append(attrs, "error", err)...)
+		return
+	}
+	attrs = append(attrs, "status", resp.StatusCode)
+	if !debug(ctx) || c.conf.Sensitive {
+		slog.InfoContext(ctx, "Provider response", attrs...)
+		return
+	}
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+	slog.DebugContext(ctx, "Provider response",
+		append(attrs, "body", redactBody(head, resp.Header.Get("Content-Type"), c.conf.Redact))...)
+}
@@ -0,0 +1,52 @@
+package httpclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenFunc fetches a new access token and reports how long it lasts
+type TokenFunc func(ctx context.Context) (token string, lifetime time.Duration, err error)
+
+// Tokens caches a provider's OAuth access token, fetching a new one shortly
+// before the old one runs out. Concurrent requests share one fetch.
+type Tokens struct {
+	fetch TokenFunc
+	early time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewTokens creates a token cache that fetches with fetch
+func NewTokens(fetch TokenFunc) *Tokens {
+	return &Tokens{fetch: fetch, early: time.Minute}
+}
+
+// Token returns the cached token, fetching a new one if it has run out or
+// is about to
+func (t *Tokens) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+	token, lifetime, err := t.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	// Refresh a little early, but keep very short-lived tokens for half
+	// their life rather than not at all
+	early := min(t.early, lifetime/2)
+	t.token, t.expires = token, time.Now().Add(lifetime-early)
+	return t.token, nil
+}
+
+// Invalidate discards the cached token so the next request fetches another
+func (t *Tokens) Invalidate() {
+	t.mu.Lock()
+	t.token = ""
+	t.mu.Unlock()
+}
@@ -0,0 +1,114 @@
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Supported languages
+const (
+	English = "en"
+	Swahili = "sw"
+)
+
+// Default is used when no supported language is requested
+const Default = English
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+var catalogs = map[string]map[string]string{}
+
+func init() {
+	for _, lang := range []string{English, Swahili} {
+		data, err := localeFS.ReadFile("locales/" + lang + ".json")
+		if err != nil {
+			panic(fmt.Sprintf("i18n: missing catalog for %s: %v", lang, err))
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalog for %s: %v", lang, err))
+		}
+		catalogs[lang] = catalog
+	}
+}
+
+// Supported reports whether lang has a catalog
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Template returns the raw message template for key in lang, falling back to
+// English. It returns an empty string when the key is unknown.
+func Template(lang, key string) string {
+	if msg, ok := catalogs[lang][key]; ok {
+		return msg
+	}
+	return catalogs[Default][key]
+}
+
+// T renders the message for key in lang, replacing {name} placeholders with params.
+// Unknown keys are returned unchanged so missing translations are easy to spot.
+func T(lang, key string, params map[string]string) string {
+	msg := Template(lang, key)
+	if msg == "" {
+		return key
+	}
+	for k, v := range params {
+		msg = strings.ReplaceAll(msg, "{"+k+"}", v)
+	}
+	return msg
+}
+
+type contextKey string
+
+const languageKey contextKey = "language"
+
+// WithLanguage stores a language (e.g. from the user's saved preference) in ctx.
+// It takes priority over the request's Accept-Language header.
+func WithLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey, lang)
+}
+
+// FromRequest picks the response language: the user preference in the context,
+// then the ?lang= query parameter, then Accept-Language, then the default
+func FromRequest(r *http.Request) string {
+	if lang, ok := r.Context().Value(languageKey).(string); ok && Supported(lang) {
+		return lang
+	}
+	if lang := r.URL.Query().Get("lang"); Supported(lang) {
+		return lang
+	}
+	return Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// Negotiate returns the best supported language from an Accept-Language header
+func Negotiate(header string) string {
+	best, bestQ := Default, -1.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.IndexByte(tag, '-'); i > 0 {
+			tag = tag[:i]
+		}
+		if !Supported(tag) {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				fmt.Sscanf(f[2:], "%g", &q)
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
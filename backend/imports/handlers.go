@@ -0,0 +1,130 @@
+package imports
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/quota"
+
+	"github.com/gorilla/mux"
+)
+
+// ImportMembersHandler accepts a multipart upload with a "file" field (CSV or
+// XLSX) and an optional "accountId" for opening balances. With dryRun=true the
+// rows are validated and previewed without writing anything.
+func ImportMembersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		t, ok := readUpload(w, r)
+		if !ok {
+			return
+		}
+		dryRun := r.FormValue("dryRun") == "true"
+
+		res, err := ImportMembers(r.Context(), db, chamaID, r.FormValue("accountId"), userID, t, dryRun)
+		if errors.Is(err, quota.ErrExceeded) {
+			quota.WriteError(w, r, err)
+			return
+		}
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrEmpty) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeResult(w, r, res)
+	}
+}
+
+// ImportHistoryHandler accepts a multipart upload of historical records (CSV
+// or XLSX) and posts them to the ledger with their original dates. "accountId"
+// is used for rows without an account column; dryRun=true previews only.
+func ImportHistoryHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, chamas.RoleAdmin, chamas.RoleTreasurer) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		t, ok := readUpload(w, r)
+		if !ok {
+			return
+		}
+		dryRun := r.FormValue("dryRun") == "true"
+
+		res, err := ImportHistory(r.Context(), db, chamaID, r.FormValue("accountId"), userID, t, dryRun)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrEmpty) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeResult(w, r, res)
+	}
+}
+
+// readUpload parses the "file" field of a multipart request into a Table,
+// writing a 400 response and returning false on failure
+func readUpload(w http.ResponseWriter, r *http.Request) (Table, bool) {
+	if err := r.ParseMultipartForm(MaxFileSize); err != nil {
+		http.Error(w, "Invalid upload or file too large", http.StatusBadRequest)
+		return Table{}, false
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "File is required", http.StatusBadRequest)
+		return Table{}, false
+	}
+	defer file.Close()
+
+	t, err := Read(file, header.Filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return Table{}, false
+	}
+	return t, true
+}
+
+// writeResult responds 422 if any row is invalid, 200 for a dry run and 201 once
+// imported. Row errors are translated into the language requested by r.
+func writeResult(w http.ResponseWriter, r *http.Request, res Result) {
+	lang := i18n.FromRequest(r)
+	for i := range res.Rows {
+		res.Rows[i].Errors = res.Rows[i].Errors.Localize(lang)
+	}
+
+	status := http.StatusCreated
+	switch {
+	case res.Invalid > 0:
+		status = http.StatusUnprocessableEntity
+	case res.DryRun:
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
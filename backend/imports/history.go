@@ -0,0 +1,342 @@
+package imports
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/validation"
+
+	"github.com/google/uuid"
+)
+
+// Historical record types accepted in the "type" column
+const (
+	RecordBalance       = "balance"
+	RecordContribution  = "contribution"
+	RecordFine          = "fine"
+	RecordLoan          = "loan"
+	RecordLoanRepayment = "loan_repayment"
+	RecordIncome        = "income"
+	RecordExpense       = "expense"
+)
+
+// HistoryColumns are the columns read from a historical import file. Every row
+// needs a date, type and amount; member rows also need member_email or member_id.
+var HistoryColumns = []string{"date", "type", "member_email", "member_id", "account", "amount", "reference", "description", "term_days", "interest_rate"}
+
+var recordTypes = []string{RecordBalance, RecordContribution, RecordFine, RecordLoan, RecordLoanRepayment, RecordIncome, RecordExpense}
+
+// entryTypes maps record types to the ledger entry they post and the sign of that entry
+var entryTypes = map[string]struct {
+	entryType string
+	sign      int64
+}{
+	RecordBalance:       {ledger.TypeOpeningBalance, 1},
+	RecordContribution:  {ledger.TypeContribution, 1},
+	RecordFine:          {ledger.TypeFine, 1},
+	RecordLoan:          {ledger.TypeLoanDisbursement, -1},
+	RecordLoanRepayment: {ledger.TypeLoanRepayment, 1},
+	RecordIncome:        {ledger.TypeIncome, 1},
+	RecordExpense:       {ledger.TypeExpense, -1},
+}
+
+// memberRecords are the record types that must name a member
+var memberRecords = map[string]bool{RecordContribution: true, RecordFine: true, RecordLoan: true, RecordLoanRepayment: true}
+
+// historyRow is a validated historical record
+type historyRow struct {
+	line        int
+	date        time.Time
+	kind        string
+	memberID    string
+	accountID   string
+	amount      money.Money
+	reference   string
+	description string
+	termDays    int
+	rateBps     int64
+}
+
+// ImportHistory validates a chama's historical records and, unless dryRun is
+// set or any row is invalid, posts them to the ledger in date order inside one
+// transaction. Each entry keeps its original date as its effective date. Loans
+// are also recorded in the loans table so outstanding balances carry over;
+// repayments are matched to loans by reference.
+func ImportHistory(ctx context.Context, db *sql.DB, chamaID, defaultAccountID, importedBy string, t Table, dryRun bool) (Result, error) {
+	res := Result{DryRun: dryRun}
+
+	loanRefs := map[string]bool{}
+	refs := map[string]bool{}
+	var valid []historyRow
+	for i := range t.Rows {
+		if t.Blank(i) {
+			continue
+		}
+		row, result, err := validateHistory(ctx, db, chamaID, defaultAccountID, t, i, refs, loanRefs)
+		if err != nil {
+			return res, err
+		}
+		res.add(result)
+		if len(result.Errors) == 0 {
+			valid = append(valid, row)
+		}
+	}
+	if res.Total == 0 {
+		return res, ErrEmpty
+	}
+	if dryRun || res.Invalid > 0 {
+		return res, nil
+	}
+
+	// Loans must exist before the repayments that reference them
+	sort.SliceStable(valid, func(i, j int) bool { return valid[i].date.Before(valid[j].date) })
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer txn.Rollback(tx)
+
+	var productID string
+	for _, h := range valid {
+		if h.kind == RecordLoan && productID == "" {
+			if productID, err = historicalLoanProduct(ctx, tx, chamaID, h.amount.Currency); err != nil {
+				return res, err
+			}
+		}
+		if err := postHistory(ctx, tx, chamaID, importedBy, productID, h); err != nil {
+			return res, fmt.Errorf("row %d: %w", h.line, err)
+		}
+	}
+	if err := txn.Commit(tx); err != nil {
+		return res, err
+	}
+	res.Imported = len(valid)
+	return res, nil
+}
+
+func validateHistory(ctx context.Context, db *sql.DB, chamaID, defaultAccountID string, t Table, i int, refs, loanRefs map[string]bool) (historyRow, RowResult, error) {
+	v := validation.New()
+	h := historyRow{
+		line:        i + 2,
+		kind:        strings.ToLower(t.Get(i, "type")),
+		reference:   t.Get(i, "reference"),
+		description: t.Get(i, "description"),
+	}
+	result := RowResult{Row: h.line}
+
+	if v.Required("date", t.Get(i, "date")) {
+		h.date = parseDate(v, "date", t.Get(i, "date"))
+		if h.date.After(time.Now()) {
+			v.Add("date", validation.CodeDate, nil)
+		}
+	}
+	if v.Required("type", h.kind) {
+		v.OneOf("type", h.kind, recordTypes...)
+	}
+	result.Action = h.kind
+
+	// Account: the row's account (id or name), else the default from the request
+	account := t.Get(i, "account")
+	if account == "" {
+		account = defaultAccountID
+	}
+	currency := ""
+	if v.Required("account", account) {
+		err := db.QueryRowContext(ctx, `
+			SELECT id, currency FROM chama_accounts WHERE chama_id = ? AND (id = ? OR name = ?)`,
+			chamaID, account, account,
+		).Scan(&h.accountID, &currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			v.Add("account", validation.CodeNotFound, nil)
+		} else if err != nil {
+			return h, result, fmt.Errorf("failed to look up account %s: %w", account, err)
+		}
+	}
+
+	amount := strings.ReplaceAll(t.Get(i, "amount"), ",", "")
+	if v.Required("amount", amount) {
+		v.Amount("amount", amount)
+		if currency != "" {
+			if m, err := money.Parse(amount, currency); err == nil {
+				h.amount = m
+			}
+		}
+	}
+
+	memberID, memberEmail := t.Get(i, "member_id"), strings.ToLower(t.Get(i, "member_email"))
+	if memberID == "" && memberEmail != "" {
+		err := db.QueryRowContext(ctx, `SELECT user_id FROM users WHERE LOWER(email) = ?`, memberEmail).Scan(&memberID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return h, result, fmt.Errorf("failed to look up %s: %w", memberEmail, err)
+		}
+		if memberID == "" {
+			v.Add("member_email", validation.CodeNotFound, nil)
+		}
+	}
+	if memberID != "" {
+		var exists int
+		err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM chama_members WHERE chama_id = ? AND user_id = ?`, chamaID, memberID).Scan(&exists)
+		if err != nil {
+			return h, result, fmt.Errorf("failed to look up member %s: %w", memberID, err)
+		}
+		if exists == 0 {
+			v.Add("member_id", validation.CodeNotFound, nil)
+		}
+		h.memberID = memberID
+	} else if memberRecords[h.kind] && memberEmail == "" {
+		v.Required("member_email", "")
+	}
+
+	if h.kind == RecordLoan || h.kind == RecordLoanRepayment {
+		v.Required("reference", h.reference)
+	}
+	if h.kind == RecordLoan {
+		h.termDays = 365
+		if d := t.Get(i, "term_days"); d != "" {
+			n, err := strconv.Atoi(d)
+			if err != nil || n <= 0 {
+				v.Add("term_days", validation.CodeAmount, nil)
+			}
+			h.termDays = n
+		}
+		if r := t.Get(i, "interest_rate"); r != "" {
+			bps, err := money.ParsePercent(r)
+			if err != nil {
+				v.Add("interest_rate", validation.CodeAmount, nil)
+			}
+			h.rateBps = bps
+		}
+		loanRefs[h.reference] = true
+	}
+	if h.kind == RecordLoanRepayment && h.reference != "" && !loanRefs[h.reference] {
+		var exists int
+		err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM loans WHERE chama_id = ? AND id = ?`, chamaID, loanID(chamaID, h.reference)).Scan(&exists)
+		if err != nil {
+			return h, result, fmt.Errorf("failed to look up loan %s: %w", h.reference, err)
+		}
+		if exists == 0 {
+			v.Add("reference", validation.CodeNotFound, nil)
+		}
+	}
+
+	// References make re-imports safe: the same reference cannot be posted twice
+	if h.reference != "" && entryTypes[h.kind].entryType != "" {
+		key := h.kind + ":" + h.reference
+		if refs[key] {
+			v.Add("reference", validation.CodeDuplicate, nil)
+		}
+		refs[key] = true
+
+		var exists int
+		err := db.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM ledger_entries WHERE chama_id = ? AND entry_type = ? AND reference = ?`,
+			chamaID, entryTypes[h.kind].entryType, h.reference).Scan(&exists)
+		if err != nil {
+			return h, result, fmt.Errorf("failed to look up reference %s: %w", h.reference, err)
+		}
+		if exists > 0 {
+			v.Add("reference", validation.CodeDuplicate, nil)
+		}
+	}
+
+	result.Errors = v.Errors()
+	return h, result, nil
+}
+
+func postHistory(ctx context.Context, tx *sql.Tx, chamaID, importedBy, productID string, h historyRow) error {
+	kind := entryTypes[h.kind]
+	description := h.description
+	if description == "" {
+		description = "Historical " + strings.ReplaceAll(h.kind, "_", " ") + " (import)"
+	}
+
+	_, err := ledger.Post(ctx, tx, ledger.Entry{
+		ChamaID:     chamaID,
+		AccountID:   h.accountID,
+		MemberID:    h.memberID,
+		Type:        kind.entryType,
+		Amount:      money.New(h.amount.Amount*kind.sign, h.amount.Currency),
+		Reference:   h.reference,
+		Description: description,
+		EffectiveAt: h.date,
+		CreatedBy:   importedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch h.kind {
+	case RecordLoan:
+		return recordLoan(ctx, tx, chamaID, productID, h)
+	case RecordLoanRepayment:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE loans SET total_repaid_minor = total_repaid_minor + ?,
+			       status = CASE WHEN total_repaid_minor + ? >= principal_minor THEN 'completed' ELSE status END,
+			       actual_end_date = CASE WHEN total_repaid_minor + ? >= principal_minor THEN ? ELSE actual_end_date END,
+			       updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`,
+			h.amount.Amount, h.amount.Amount, h.amount.Amount, h.date.Format("2006-01-02 15:04:05"), loanID(chamaID, h.reference))
+		return err
+	}
+	return nil
+}
+
+// recordLoan creates the approved application and active loan behind a historical disbursement
+func recordLoan(ctx context.Context, tx *sql.Tx, chamaID, productID string, h historyRow) error {
+	date := h.date.Format("2006-01-02 15:04:05")
+	applicationID := uuid.NewString()
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO loan_applications
+		(id, chama_id, user_id, loan_product_id, amount_minor, currency, term, purpose, status, application_date, approval_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 'Historical loan (import)', 'disbursed', ?, ?)`,
+		applicationID, chamaID, h.memberID, productID, h.amount.Amount, h.amount.Currency, h.termDays, date, date)
+	if err != nil {
+		return fmt.Errorf("failed to record loan application: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loans
+		(id, application_id, chama_id, borrower_id, loan_product_id, principal_minor, currency, interest_rate_bps,
+		 interest_type, term, disbursement_date, expected_end_date, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'flat', ?, ?, ?, 'active')`,
+		loanID(chamaID, h.reference), applicationID, chamaID, h.memberID, productID, h.amount.Amount, h.amount.Currency,
+		h.rateBps, h.termDays, date, h.date.AddDate(0, 0, h.termDays).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return fmt.Errorf("failed to record loan: %w", err)
+	}
+	return nil
+}
+
+// historicalLoanProduct returns the chama's inactive "Historical loans" product, creating it if needed
+func historicalLoanProduct(ctx context.Context, tx *sql.Tx, chamaID, currency string) (string, error) {
+	const name = "Historical loans"
+	var id string
+	err := tx.QueryRowContext(ctx, `SELECT id FROM loan_products WHERE chama_id = ? AND name = ?`, chamaID, name).Scan(&id)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+
+	id = uuid.NewString()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loan_products
+		(id, chama_id, name, description, interest_rate_bps, min_amount_minor, max_amount_minor, currency, min_term, max_term, is_active)
+		VALUES (?, ?, ?, 'Loans brought over from the chama''s previous records', 0, 0, 0, ?, 0, 0, FALSE)`,
+		id, chamaID, name, currency)
+	return id, err
+}
+
+// loanID derives a stable loan id from the import reference so repayments in
+// later imports can find the loan again
+func loanID(chamaID, reference string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(chamaID+"/"+reference)).String()
+}
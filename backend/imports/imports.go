@@ -0,0 +1,141 @@
+package imports
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/duplicates"
+	"tujifund-app/backend/export/xlsx"
+	"tujifund-app/backend/validation"
+)
+
+// MaxFileSize is the largest spreadsheet accepted for import
+const MaxFileSize = 5 << 20
+
+// MaxRows caps the number of data rows in one import
+const MaxRows = 2000
+
+var (
+	// ErrUnsupportedFormat is returned for files that are neither CSV nor XLSX
+	ErrUnsupportedFormat = errors.New("file must be a .csv or .xlsx spreadsheet")
+	// ErrEmpty is returned when a file has no data rows
+	ErrEmpty = errors.New("file has no data rows")
+	// ErrTooManyRows is returned when a file has more than MaxRows data rows
+	ErrTooManyRows = errors.New("file has too many rows")
+)
+
+// Table is a parsed spreadsheet. Header names are normalised to lower
+// snake_case so "Opening Balance" and "opening_balance" are the same column.
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Read parses a CSV or XLSX file, chosen by the file name's extension
+func Read(r io.Reader, filename string) (Table, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return Table{}, err
+	}
+	if len(data) > MaxFileSize {
+		return Table{}, errors.New("file is too large")
+	}
+
+	var rows [][]string
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		cr := csv.NewReader(bytes.NewReader(data))
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		rows, err = cr.ReadAll()
+	case ".xlsx":
+		rows, err = xlsx.ReadRows(bytes.NewReader(data), int64(len(data)))
+	default:
+		return Table{}, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return Table{}, err
+	}
+	if len(rows) < 2 {
+		return Table{}, ErrEmpty
+	}
+	if len(rows)-1 > MaxRows {
+		return Table{}, ErrTooManyRows
+	}
+
+	t := Table{Rows: rows[1:]}
+	for _, h := range rows[0] {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		t.Header = append(t.Header, strings.NewReplacer(" ", "_", "-", "_").Replace(h))
+	}
+	return t, nil
+}
+
+// Get returns the trimmed value of column in data row i, or "" if absent
+func (t Table) Get(i int, column string) string {
+	for c, h := range t.Header {
+		if h == column && c < len(t.Rows[i]) {
+			return strings.TrimSpace(t.Rows[i][c])
+		}
+	}
+	return ""
+}
+
+// Blank reports whether every cell in data row i is empty
+func (t Table) Blank(i int) bool {
+	for _, c := range t.Rows[i] {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// RowResult reports the outcome of one spreadsheet row. Row is the 1-based
+// line number in the file, counting the header.
+type RowResult struct {
+	Row    int               `json:"row"`
+	Action string            `json:"action,omitempty"`
+	Errors validation.Errors `json:"errors,omitempty"`
+	// Existing users a new member resembles. They do not stop the import;
+	// each is recorded for staff to merge or dismiss.
+	Duplicates []duplicates.Candidate `json:"possibleDuplicates,omitempty"`
+}
+
+// Result summarises an import or dry run
+type Result struct {
+	DryRun   bool        `json:"dryRun"`
+	Total    int         `json:"total"`
+	Valid    int         `json:"valid"`
+	Invalid  int         `json:"invalid"`
+	Imported int         `json:"imported"`
+	Rows     []RowResult `json:"rows"`
+}
+
+func (res *Result) add(row RowResult) {
+	res.Total++
+	if len(row.Errors) > 0 {
+		res.Invalid++
+	} else {
+		res.Valid++
+	}
+	res.Rows = append(res.Rows, row)
+}
+
+// parseDate accepts YYYY-MM-DD text or an Excel date serial number
+func parseDate(v *validation.Validator, field, value string) time.Time {
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 {
+		return xlsx.SerialDate(serial)
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		v.Add(field, validation.CodeDate, nil)
+	}
+	return t
+}
@@ -0,0 +1,216 @@
+package imports
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/duplicates"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/phone"
+	"tujifund-app/backend/quota"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/validation"
+
+	"github.com/google/uuid"
+)
+
+// MemberColumns are the columns read from a member import file. Only
+// first_name and email are required.
+var MemberColumns = []string{"first_name", "last_name", "email", "phone", "national_id", "role", "join_date", "opening_balance"}
+
+var memberRoles = append([]string{chamas.RoleMember}, chamas.OfficialRoles...)
+
+// memberRow is a validated member import row
+type memberRow struct {
+	firstName, lastName, email, phone, nationalID, role string
+	joinDate                                            time.Time
+	balance                                             money.Money
+	userID                                              string // existing user to link, if any
+}
+
+// ImportMembers validates every row of t and, unless dryRun is set or any row
+// is invalid, creates the members and their opening balances in one
+// transaction. Opening balances are posted to accountID.
+func ImportMembers(ctx context.Context, db *sql.DB, chamaID, accountID, importedBy string, t Table, dryRun bool) (Result, error) {
+	res := Result{DryRun: dryRun}
+	currency, err := money.ChamaCurrency(db, chamaID)
+	if err != nil {
+		return res, fmt.Errorf("failed to load chama: %w", err)
+	}
+
+	seen := map[string]int{}
+	var valid []memberRow
+	for i := range t.Rows {
+		if t.Blank(i) {
+			continue
+		}
+		row, result, err := validateMember(ctx, db, chamaID, accountID, currency, t, i, seen)
+		if err != nil {
+			return res, err
+		}
+		res.add(result)
+		if len(result.Errors) == 0 {
+			valid = append(valid, row)
+		}
+	}
+	if res.Total == 0 {
+		return res, ErrEmpty
+	}
+	if res.Invalid == 0 {
+		// Checked on dry runs too, so an import the plan has no room for is caught early
+		if err := quota.Check(ctx, db, chamaID, quota.LimitMembers, int64(len(valid))); err != nil {
+			return res, err
+		}
+	}
+	if dryRun || res.Invalid > 0 {
+		return res, nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer txn.Rollback(tx)
+
+	for _, m := range valid {
+		if err := createMember(ctx, tx, chamaID, accountID, importedBy, m); err != nil {
+			return res, err
+		}
+	}
+	if err := txn.Commit(tx); err != nil {
+		return res, err
+	}
+	res.Imported = len(valid)
+	return res, nil
+}
+
+func validateMember(ctx context.Context, db *sql.DB, chamaID, accountID, currency string, t Table, i int, seen map[string]int) (memberRow, RowResult, error) {
+	v := validation.New()
+	m := memberRow{
+		firstName:  t.Get(i, "first_name"),
+		lastName:   t.Get(i, "last_name"),
+		email:      strings.ToLower(t.Get(i, "email")),
+		phone:      strings.TrimSpace(t.Get(i, "phone")),
+		nationalID: t.Get(i, "national_id"),
+		role:       strings.ToLower(t.Get(i, "role")),
+	}
+	result := RowResult{Row: i + 2, Action: "create"}
+
+	v.Required("first_name", m.firstName)
+	if v.Required("email", m.email) {
+		v.Email("email", m.email)
+	}
+	if m.phone != "" {
+		v.Phone("phone", m.phone)
+		m.phone = phone.Canonical(m.phone)
+	}
+	if m.nationalID != "" {
+		v.NationalID("national_id", m.nationalID)
+	}
+	if m.role == "" {
+		m.role = chamas.RoleMember
+	}
+	v.OneOf("role", m.role, memberRoles...)
+	if d := t.Get(i, "join_date"); d != "" {
+		m.joinDate = parseDate(v, "join_date", d)
+	}
+	if b := t.Get(i, "opening_balance"); b != "" {
+		b = strings.ReplaceAll(b, ",", "")
+		v.Amount("opening_balance", b)
+		if balance, err := money.Parse(b, currency); err == nil {
+			m.balance = balance
+			if accountID == "" {
+				v.Required("accountId", accountID)
+			}
+		}
+	}
+
+	for field, key := range map[string]string{"email": m.email, "phone": m.phone} {
+		if key == "" {
+			continue
+		}
+		if _, dup := seen[field+":"+key]; dup {
+			v.Add(field, validation.CodeDuplicate, nil)
+		}
+		seen[field+":"+key] = i
+	}
+
+	if m.email != "" {
+		err := db.QueryRowContext(ctx, `SELECT user_id FROM users WHERE LOWER(email) = ?`, m.email).Scan(&m.userID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return m, result, fmt.Errorf("failed to look up %s: %w", m.email, err)
+		}
+		if m.userID != "" {
+			result.Action = "link"
+			if chamas.IsMember(db, chamaID, m.userID) {
+				v.Add("email", validation.CodeMember, nil)
+			}
+		}
+	}
+	if m.userID == "" {
+		found, err := duplicates.Find(ctx, db, duplicates.Person{
+			FirstName: m.firstName, LastName: m.lastName, Email: m.email, Phone: m.phone, NationalID: m.nationalID,
+		}, "")
+		if err != nil {
+			return m, result, err
+		}
+		if len(found) > 0 {
+			result.Duplicates = found
+		}
+	}
+
+	result.Errors = v.Errors()
+	return m, result, nil
+}
+
+func createMember(ctx context.Context, tx *sql.Tx, chamaID, accountID, importedBy string, m memberRow) error {
+	if m.userID == "" {
+		m.userID = time.Now().Format("20060102150405") + "_" + uuid.NewString()[:8]
+		username := strings.SplitN(m.email, "@", 2)[0] + "_" + uuid.NewString()[:6]
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO users (user_id, username, email, first_name, last_name, phone_number, national_id, auth_provider, is_verified)
+			VALUES (?, ?, ?, ?, ?, ?, ?, 'import', 0)`,
+			m.userID, username, m.email, m.firstName, m.lastName, m.phone, sql.NullString{String: strings.ToUpper(m.nationalID), Valid: m.nationalID != ""})
+		if err != nil {
+			return fmt.Errorf("failed to create user %s: %w", m.email, err)
+		}
+		if _, err := duplicates.Flag(ctx, tx, m.userID); err != nil {
+			return err
+		}
+	}
+
+	joinDate := m.joinDate
+	if joinDate.IsZero() {
+		joinDate = time.Now().UTC()
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO chama_members (id, chama_id, user_id, role, join_date, status)
+		VALUES (?, ?, ?, ?, ?, 'active')`,
+		uuid.NewString(), chamaID, m.userID, m.role, joinDate.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return fmt.Errorf("failed to add member %s: %w", m.email, err)
+	}
+
+	if m.balance.Amount > 0 {
+		_, err = ledger.Post(ctx, tx, ledger.Entry{
+			ChamaID:     chamaID,
+			AccountID:   accountID,
+			MemberID:    m.userID,
+			Type:        ledger.TypeOpeningBalance,
+			Amount:      m.balance,
+			Description: "Opening balance (member import)",
+			EffectiveAt: joinDate,
+			CreatedBy:   importedBy,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package investments
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// managerRoles may record and change investments
+var managerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// CreateHandler records an investment bought from one of the {chamaId} chama's accounts
+func CreateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Name        string `json:"name"`
+			AssetType   string `json:"assetType"`
+			Description string `json:"description"`
+			AccountID   string `json:"accountId"`
+			Cost        string `json:"cost"`
+			AcquiredAt  string `json:"acquiredAt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		v := validation.New()
+		v.Required("name", request.Name)
+		if v.Required("assetType", request.AssetType) {
+			v.OneOf("assetType", request.AssetType, AssetTypes...)
+		}
+		v.Required("accountId", request.AccountID)
+		if v.Required("cost", request.Cost) {
+			v.Amount("cost", request.Cost)
+		}
+		acquired := parseDate(v, "acquiredAt", request.AcquiredAt)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		currency, err := money.ChamaCurrency(db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		cost, _ := money.Parse(request.Cost, currency)
+
+		inv, err := Create(r.Context(), db, Investment{
+			ChamaID:     chamaID,
+			AccountID:   request.AccountID,
+			Name:        request.Name,
+			AssetType:   request.AssetType,
+			Description: request.Description,
+			Cost:        cost,
+			AcquiredAt:  acquired,
+			CreatedBy:   userID,
+		})
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(inv)
+	}
+}
+
+// ListHandler lists the {chamaId} chama's investments, optionally filtered by ?status=
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := List(r.Context(), db, chamaID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// GetHandler returns the {investmentId} investment with its valuation history
+// and transactions
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inv, ok := loadInvestment(db, w, r, false)
+		if !ok {
+			return
+		}
+		valuations, err := Valuations(r.Context(), db, inv.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		transactions, err := Transactions(r.Context(), db, inv.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"investment":   inv,
+			"return":       inv.Return(),
+			"valuations":   valuations,
+			"transactions": transactions,
+		})
+	}
+}
+
+// RevalueHandler records a new valuation of the {investmentId} investment
+func RevalueHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inv, ok := loadInvestment(db, w, r, true)
+		if !ok {
+			return
+		}
+		var request struct {
+			Value    string `json:"value"`
+			ValuedAt string `json:"valuedAt"`
+			Notes    string `json:"notes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		v := validation.New()
+		if v.Required("value", request.Value) {
+			v.Amount("value", request.Value)
+		}
+		valuedAt := parseDate(v, "valuedAt", request.ValuedAt)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		value, _ := money.Parse(request.Value, inv.Cost.Currency)
+
+		valuation, err := Revalue(r.Context(), db, Valuation{
+			InvestmentID: inv.ID,
+			Value:        value,
+			ValuedAt:     valuedAt,
+			Notes:        request.Notes,
+			RecordedBy:   r.Context().Value("userID").(string),
+		}, audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(valuation)
+	}
+}
+
+// TransactionHandler records income or an expense on the {investmentId} investment
+func TransactionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inv, ok := loadInvestment(db, w, r, true)
+		if !ok {
+			return
+		}
+		var request struct {
+			Kind        string `json:"kind"`
+			Amount      string `json:"amount"`
+			AccountID   string `json:"accountId"`
+			Description string `json:"description"`
+			Date        string `json:"date"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		v := validation.New()
+		if v.Required("kind", request.Kind) {
+			v.OneOf("kind", request.Kind, KindIncome, KindExpense)
+		}
+		if v.Required("amount", request.Amount) {
+			v.Amount("amount", request.Amount)
+		}
+		date := parseDate(v, "date", request.Date)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		amount, _ := money.Parse(request.Amount, inv.Cost.Currency)
+
+		t, err := RecordTransaction(r.Context(), db, Transaction{
+			InvestmentID: inv.ID,
+			Kind:         request.Kind,
+			Amount:       amount,
+			Description:  request.Description,
+			OccurredAt:   date,
+			RecordedBy:   r.Context().Value("userID").(string),
+		}, request.AccountID)
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+// DisposeHandler records the sale of the {investmentId} investment
+func DisposeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inv, ok := loadInvestment(db, w, r, true)
+		if !ok {
+			return
+		}
+		var request struct {
+			Proceeds   string `json:"proceeds"`
+			AccountID  string `json:"accountId"`
+			DisposedAt string `json:"disposedAt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		v := validation.New()
+		if v.Required("proceeds", request.Proceeds) {
+			v.Amount("proceeds", request.Proceeds)
+		}
+		disposedAt := parseDate(v, "disposedAt", request.DisposedAt)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		proceeds, _ := money.Parse(request.Proceeds, inv.Cost.Currency)
+
+		inv, err := Dispose(r.Context(), db, inv.ID, request.AccountID, proceeds, disposedAt, audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(inv)
+	}
+}
+
+// loadInvestment loads the {investmentId} investment and checks that the caller
+// is a member of its chama, or holds a manager role when manage is set
+func loadInvestment(db *sql.DB, w http.ResponseWriter, r *http.Request, manage bool) (Investment, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Investment{}, false
+	}
+	inv, err := Get(r.Context(), db, mux.Vars(r)["investmentId"])
+	if err == sql.ErrNoRows || (err == nil && !chamas.IsMember(db, inv.ChamaID, userID)) {
+		http.Error(w, "Investment not found", http.StatusNotFound)
+		return inv, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return inv, false
+	}
+	if manage && !chamas.HasRole(db, inv.ChamaID, userID, managerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return inv, false
+	}
+	return inv, true
+}
+
+// writeError writes err as a response and reports whether the handler may continue
+func writeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrDisposed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ledger.ErrDebitNotAllowed), errors.Is(err, ledger.ErrInsufficientFunds), errors.Is(err, ledger.ErrAccountClosed):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// parseDate validates an optional YYYY-MM-DD date, returning the zero time when it is empty
+func parseDate(v *validation.Validator, field, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	v.Date(field, value)
+	t, _ := time.Parse("2006-01-02", value)
+	return t
+}
@@ -0,0 +1,447 @@
+package investments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/reports"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Asset types
+const (
+	TypeLand        = "land"
+	TypeMoneyMarket = "money_market"
+	TypeShares      = "shares"
+	TypeBonds       = "bonds"
+	TypeProperty    = "property"
+	TypeBusiness    = "business"
+	TypeOther       = "other"
+)
+
+// AssetTypes lists the accepted asset types
+var AssetTypes = []string{TypeLand, TypeMoneyMarket, TypeShares, TypeBonds, TypeProperty, TypeBusiness, TypeOther}
+
+// Investment statuses
+const (
+	StatusActive   = "active"
+	StatusDisposed = "disposed"
+)
+
+// Transaction kinds
+const (
+	KindIncome  = "income"
+	KindExpense = "expense"
+)
+
+// ErrDisposed is returned when changing an investment that has been sold off
+var ErrDisposed = errors.New("investment has been disposed")
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// Investment is an asset bought with chama funds. Value is the latest
+// valuation (the cost until one is recorded); Income and Expenses are the
+// totals of its transactions.
+type Investment struct {
+	ID          string      `json:"id"`
+	ChamaID     string      `json:"chamaId"`
+	AccountID   string      `json:"accountId"`
+	Name        string      `json:"name"`
+	AssetType   string      `json:"assetType"`
+	Description string      `json:"description,omitempty"`
+	Cost        money.Money `json:"cost"`
+	AcquiredAt  time.Time   `json:"acquiredAt"`
+	Status      string      `json:"status"`
+	Disposal    money.Money `json:"disposalAmount"`
+	DisposedAt  string      `json:"disposedAt,omitempty"`
+	Value       money.Money `json:"currentValue"`
+	Income      money.Money `json:"income"`
+	Expenses    money.Money `json:"expenses"`
+	CreatedBy   string      `json:"createdBy"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
+
+// Return is the investment's gain so far: change in value plus net income
+func (inv Investment) Return() money.Money {
+	gain, _ := inv.Value.Sub(inv.Cost)
+	gain, _ = gain.Add(inv.Income)
+	gain, _ = gain.Sub(inv.Expenses)
+	return gain
+}
+
+// Valuation is the value of an investment on a date
+type Valuation struct {
+	ID           string      `json:"id"`
+	InvestmentID string      `json:"investmentId"`
+	Value        money.Money `json:"value"`
+	ValuedAt     time.Time   `json:"valuedAt"`
+	Notes        string      `json:"notes,omitempty"`
+	RecordedBy   string      `json:"recordedBy"`
+}
+
+// Transaction is income earned or an expense paid on an investment
+type Transaction struct {
+	ID            string      `json:"id"`
+	InvestmentID  string      `json:"investmentId"`
+	Kind          string      `json:"kind"`
+	Amount        money.Money `json:"amount"`
+	Description   string      `json:"description,omitempty"`
+	LedgerEntryID string      `json:"ledgerEntryId"`
+	OccurredAt    time.Time   `json:"occurredAt"`
+	RecordedBy    string      `json:"recordedBy"`
+}
+
+// Create records a new investment and posts its purchase cost out of the
+// funding account
+func Create(ctx context.Context, db *sql.DB, inv Investment) (Investment, error) {
+	if inv.Cost.Amount <= 0 {
+		return inv, errors.New("investment cost must be positive")
+	}
+	inv.ID = uuid.NewString()
+	if inv.AcquiredAt.IsZero() {
+		inv.AcquiredAt = time.Now().UTC()
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer txn.Rollback(tx)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO investments (id, chama_id, account_id, name, asset_type, description, cost_minor, currency, acquired_at, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		inv.ID, inv.ChamaID, inv.AccountID, inv.Name, inv.AssetType, nullIfEmpty(inv.Description),
+		inv.Cost.Amount, inv.Cost.Currency, inv.AcquiredAt.UTC().Format(timeLayout), inv.CreatedBy,
+	)
+	if err != nil {
+		return inv, fmt.Errorf("failed to create investment: %w", err)
+	}
+	_, err = ledger.Post(ctx, tx, ledger.Entry{
+		ChamaID:     inv.ChamaID,
+		AccountID:   inv.AccountID,
+		Type:        ledger.TypeInvestment,
+		Amount:      inv.Cost.Negate(),
+		Reference:   inv.ID,
+		Description: "Investment: " + inv.Name,
+		EffectiveAt: inv.AcquiredAt,
+		CreatedBy:   inv.CreatedBy,
+	})
+	if err != nil {
+		return inv, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return inv, err
+	}
+	return Get(ctx, db, inv.ID)
+}
+
+const columns = `i.id, i.chama_id, i.account_id, i.name, i.asset_type, COALESCE(i.description, ''),
+	i.cost_minor, i.currency, i.acquired_at, i.status, COALESCE(i.disposal_minor, 0), COALESCE(i.disposed_at, ''),
+	COALESCE((SELECT v.value_minor FROM investment_valuations v WHERE v.investment_id = i.id
+	          ORDER BY v.valued_at DESC, v.created_at DESC LIMIT 1), i.cost_minor),
+	COALESCE((SELECT SUM(t.amount_minor) FROM investment_transactions t WHERE t.investment_id = i.id AND t.kind = 'income'), 0),
+	COALESCE((SELECT SUM(t.amount_minor) FROM investment_transactions t WHERE t.investment_id = i.id AND t.kind = 'expense'), 0),
+	i.created_by, i.created_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Investment, error) {
+	var inv Investment
+	err := row.Scan(&inv.ID, &inv.ChamaID, &inv.AccountID, &inv.Name, &inv.AssetType, &inv.Description,
+		&inv.Cost.Amount, &inv.Cost.Currency, &inv.AcquiredAt, &inv.Status, &inv.Disposal.Amount, &inv.DisposedAt,
+		&inv.Value.Amount, &inv.Income.Amount, &inv.Expenses.Amount, &inv.CreatedBy, &inv.CreatedAt)
+	currency := inv.Cost.Currency
+	inv.Disposal.Currency, inv.Value.Currency, inv.Income.Currency, inv.Expenses.Currency = currency, currency, currency, currency
+	return inv, err
+}
+
+// Get returns a single investment
+func Get(ctx context.Context, db *sql.DB, id string) (Investment, error) {
+	return scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM investments i WHERE i.id = ?`, id))
+}
+
+// List returns a chama's investments, newest first. status is an optional filter.
+func List(ctx context.Context, db *sql.DB, chamaID, status string) ([]Investment, error) {
+	query := `SELECT ` + columns + ` FROM investments i WHERE i.chama_id = ?`
+	args := []interface{}{chamaID}
+	if status != "" {
+		query += " AND i.status = ?"
+		args = append(args, status)
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY i.acquired_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Investment{}
+	for rows.Next() {
+		inv, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, inv)
+	}
+	return list, rows.Err()
+}
+
+// Revalue records a new valuation. Valuations change the balance sheet
+// without touching the ledger, so each one is audited.
+func Revalue(ctx context.Context, db *sql.DB, v Valuation, entry audit.Entry) (Valuation, error) {
+	inv, err := Get(ctx, db, v.InvestmentID)
+	if err != nil {
+		return v, err
+	}
+	if inv.Status != StatusActive {
+		return v, ErrDisposed
+	}
+	if v.Value.Amount < 0 {
+		return v, errors.New("valuation cannot be negative")
+	}
+	v.ID = uuid.NewString()
+	v.Value.Currency = inv.Cost.Currency
+	if v.ValuedAt.IsZero() {
+		v.ValuedAt = time.Now().UTC()
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return v, err
+	}
+	defer txn.Rollback(tx)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO investment_valuations (id, investment_id, value_minor, valued_at, notes, recorded_by)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		v.ID, v.InvestmentID, v.Value.Amount, v.ValuedAt.UTC().Format(timeLayout), nullIfEmpty(v.Notes), v.RecordedBy,
+	)
+	if err != nil {
+		return v, fmt.Errorf("failed to record valuation: %w", err)
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "investment.revalue", "investment", inv.ID
+	entry.OldValues = map[string]interface{}{"value": inv.Value}
+	entry.NewValues = map[string]interface{}{"value": v.Value, "valuedAt": v.ValuedAt}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return v, err
+	}
+	return v, txn.Commit(tx)
+}
+
+// Valuations returns an investment's valuation history, oldest first
+func Valuations(ctx context.Context, db *sql.DB, investmentID string) ([]Valuation, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT v.id, v.investment_id, v.value_minor, i.currency, v.valued_at, COALESCE(v.notes, ''), v.recorded_by
+		FROM investment_valuations v JOIN investments i ON i.id = v.investment_id
+		WHERE v.investment_id = ? ORDER BY v.valued_at, v.created_at`, investmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Valuation{}
+	for rows.Next() {
+		var v Valuation
+		if err := rows.Scan(&v.ID, &v.InvestmentID, &v.Value.Amount, &v.Value.Currency, &v.ValuedAt, &v.Notes, &v.RecordedBy); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// RecordTransaction records income or an expense on an investment and posts
+// it to the ledger against accountID (the investment's funding account if empty)
+func RecordTransaction(ctx context.Context, db *sql.DB, t Transaction, accountID string) (Transaction, error) {
+	inv, err := Get(ctx, db, t.InvestmentID)
+	if err != nil {
+		return t, err
+	}
+	if t.Amount.Amount <= 0 {
+		return t, errors.New("amount must be positive")
+	}
+	if accountID == "" {
+		accountID = inv.AccountID
+	}
+	t.ID = uuid.NewString()
+	if t.OccurredAt.IsZero() {
+		t.OccurredAt = time.Now().UTC()
+	}
+
+	entry := ledger.Entry{
+		ChamaID:     inv.ChamaID,
+		AccountID:   accountID,
+		Type:        ledger.TypeIncome,
+		Amount:      t.Amount,
+		Reference:   inv.ID,
+		Description: t.Description,
+		EffectiveAt: t.OccurredAt,
+		CreatedBy:   t.RecordedBy,
+	}
+	switch t.Kind {
+	case KindIncome:
+	case KindExpense:
+		entry.Type, entry.Amount = ledger.TypeExpense, t.Amount.Negate()
+	default:
+		return t, fmt.Errorf("unknown transaction kind %q", t.Kind)
+	}
+	if entry.Description == "" {
+		entry.Description = inv.Name + " " + t.Kind
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return t, err
+	}
+	defer txn.Rollback(tx)
+
+	entry, err = ledger.Post(ctx, tx, entry)
+	if err != nil {
+		return t, err
+	}
+	t.LedgerEntryID = entry.ID
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO investment_transactions (id, investment_id, kind, amount_minor, description, ledger_entry_id, occurred_at, recorded_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.InvestmentID, t.Kind, t.Amount.Amount, nullIfEmpty(t.Description), t.LedgerEntryID,
+		t.OccurredAt.UTC().Format(timeLayout), t.RecordedBy,
+	)
+	if err != nil {
+		return t, fmt.Errorf("failed to record investment transaction: %w", err)
+	}
+	return t, txn.Commit(tx)
+}
+
+// Transactions returns an investment's income and expenses, newest first
+func Transactions(ctx context.Context, db *sql.DB, investmentID string) ([]Transaction, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT t.id, t.investment_id, t.kind, t.amount_minor, i.currency, COALESCE(t.description, ''),
+		       t.ledger_entry_id, t.occurred_at, t.recorded_by
+		FROM investment_transactions t JOIN investments i ON i.id = t.investment_id
+		WHERE t.investment_id = ? ORDER BY t.occurred_at DESC, t.created_at DESC`, investmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Transaction{}
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.InvestmentID, &t.Kind, &t.Amount.Amount, &t.Amount.Currency, &t.Description,
+			&t.LedgerEntryID, &t.OccurredAt, &t.RecordedBy); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// Dispose records the sale of an investment. The cost comes back into
+// accountID as an investment entry and any gain or loss against cost is
+// posted as income or expense, so the account receives exactly the proceeds.
+func Dispose(ctx context.Context, db *sql.DB, id, accountID string, proceeds money.Money, at time.Time, entry audit.Entry) (Investment, error) {
+	inv, err := Get(ctx, db, id)
+	if err != nil {
+		return inv, err
+	}
+	if inv.Status != StatusActive {
+		return inv, ErrDisposed
+	}
+	if accountID == "" {
+		accountID = inv.AccountID
+	}
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+	gain, err := proceeds.Sub(inv.Cost)
+	if err != nil {
+		return inv, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer txn.Rollback(tx)
+
+	entries := []ledger.Entry{{Type: ledger.TypeInvestment, Amount: inv.Cost, Description: "Disposal: " + inv.Name}}
+	if gain.IsNegative() {
+		entries = append(entries, ledger.Entry{Type: ledger.TypeExpense, Amount: gain, Description: "Loss on disposal: " + inv.Name})
+	} else if !gain.IsZero() {
+		entries = append(entries, ledger.Entry{Type: ledger.TypeIncome, Amount: gain, Description: "Gain on disposal: " + inv.Name})
+	}
+	for _, e := range entries {
+		e.ChamaID, e.AccountID, e.Reference, e.EffectiveAt, e.CreatedBy = inv.ChamaID, accountID, inv.ID, at, entry.UserID
+		if _, err := ledger.Post(ctx, tx, e); err != nil {
+			return inv, err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE investments SET status = ?, disposal_minor = ?, disposed_at = ?
+		WHERE id = ? AND status = ?`,
+		StatusDisposed, proceeds.Amount, at.UTC().Format(timeLayout), id, StatusActive)
+	if err != nil {
+		return inv, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return inv, ErrDisposed
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "investment.dispose", "investment", inv.ID
+	entry.OldValues = map[string]interface{}{"value": inv.Value}
+	entry.NewValues = map[string]interface{}{"proceeds": proceeds, "disposedAt": at}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return inv, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return inv, err
+	}
+	return Get(ctx, db, id)
+}
+
+// AssetValues lists the investments a chama held at asAt, each at its latest
+// valuation before that time
+func AssetValues(ctx context.Context, db *sql.DB, chamaID string, asAt time.Time) ([]reports.BalanceSheetItem, error) {
+	at := asAt.UTC().Format(timeLayout)
+	rows, err := db.QueryContext(ctx, `
+		SELECT i.name, i.currency,
+		       COALESCE((SELECT v.value_minor FROM investment_valuations v
+		                 WHERE v.investment_id = i.id AND v.valued_at < ?
+		                 ORDER BY v.valued_at DESC, v.created_at DESC LIMIT 1), i.cost_minor)
+		FROM investments i
+		WHERE i.chama_id = ? AND i.acquired_at < ? AND (i.disposed_at IS NULL OR i.disposed_at >= ?)
+		ORDER BY i.name`,
+		at, chamaID, at, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []reports.BalanceSheetItem
+	for rows.Next() {
+		var item reports.BalanceSheetItem
+		if err := rows.Scan(&item.Name, &item.Amount.Currency, &item.Amount.Amount); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// RegisterAssetSource includes investments in chama balance sheets
+func RegisterAssetSource() {
+	reports.AssetSources = append(reports.AssetSources, AssetValues)
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
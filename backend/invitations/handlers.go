@@ -0,0 +1,391 @@
+package invitations
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/otp"
+	"tujifund-app/backend/quota"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultValidity is how long an invitation lasts when no expiry is given
+	DefaultValidity = 72 * time.Hour
+	// MaxValidity is the longest an invitation may last
+	MaxValidity = 30 * 24 * time.Hour
+)
+
+// link returns the shareable join link for code. APP_BASE_URL points at the web app.
+func link(code string) string {
+	return strings.TrimRight(os.Getenv("APP_BASE_URL"), "/") + "/join/" + code
+}
+
+// CreateHandler lets an official create an invitation to the {chamaId} chama
+func CreateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficialContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		request := struct {
+			Role           string `json:"role"`
+			Note           string `json:"note"`
+			MaxUses        int    `json:"maxUses"`
+			ExpiresInHours int    `json:"expiresInHours"`
+		}{Role: chamas.RoleMember, MaxUses: 1}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.OneOf("role", request.Role, append([]string{chamas.RoleMember}, chamas.OfficialRoles...)...)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		validity := time.Duration(request.ExpiresInHours) * time.Hour
+		if validity <= 0 {
+			validity = DefaultValidity
+		}
+		if validity > MaxValidity {
+			validity = MaxValidity
+		}
+
+		inv, err := Create(r.Context(), db, Invitation{
+			ChamaID:   chamaID,
+			Role:      request.Role,
+			Note:      request.Note,
+			MaxUses:   request.MaxUses,
+			ExpiresAt: time.Now().Add(validity),
+			InvitedBy: userID,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"invitation": inv, "link": link(inv.Code)})
+	}
+}
+
+// ListHandler lists the {chamaId} chama's invitations for officials
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficialContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := List(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// RevokeHandler revokes the {invitationId} invitation
+func RevokeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		inv, err := Get(r.Context(), db, mux.Vars(r)["invitationId"])
+		if err != nil || !chamas.IsOfficialContext(r.Context(), db, inv.ChamaID, userID) {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		if err := Revoke(r.Context(), db, inv.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// PreviewHandler shows what the {code} invitation is for, so the join page can
+// be rendered before the invitee signs in
+func PreviewHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inv, err := Lookup(r.Context(), db, mux.Vars(r)["code"])
+		if err != nil {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		preview := map[string]interface{}{
+			"chamaName": inv.ChamaName,
+			"role":      inv.Role,
+			"expiresAt": inv.ExpiresAt,
+			"valid":     inv.Check() == nil,
+		}
+		if err := inv.Check(); err != nil {
+			preview["reason"] = err.Error()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(preview)
+	}
+}
+
+// AcceptHandler starts joining through the {code} invitation for the signed-in
+// user and sends a verification code to the given phone number
+func AcceptHandler(db *sql.DB, sender otp.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Phone string `json:"phone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("phone", request.Phone) {
+			v.Phone("phone", request.Phone)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		req, err := Accept(r.Context(), db, sender, mux.Vars(r)["code"], userID, request.Phone)
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(req)
+	}
+}
+
+// ResendHandler sends a new verification code for the caller's {requestId} join request
+func ResendHandler(db *sql.DB, sender otp.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := loadOwnRequest(db, w, r)
+		if !ok {
+			return
+		}
+		if !writeError(w, Resend(r.Context(), db, sender, req)) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// VerifyHandler checks the verification code for the caller's {requestId} join
+// request and passes it to the chama's officials for approval
+func VerifyHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := loadOwnRequest(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+			http.Error(w, "code is required", http.StatusBadRequest)
+			return
+		}
+
+		req, err := VerifyPhone(r.Context(), db, req, request.Code)
+		if !writeError(w, err) {
+			return
+		}
+		NotifyOfficials(r.Context(), db, notifier, req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(req)
+	}
+}
+
+// MyRequestsHandler lists the caller's join requests
+func MyRequestsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		list, err := ListRequests(r.Context(), db, "", userID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// RequestsHandler lists join requests to the {chamaId} chama for officials,
+// optionally filtered by ?status=
+func RequestsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficialContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := ListRequests(r.Context(), db, chamaID, "", r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// DecideHandler lets an official approve ("approve": true) or reject the {requestId} join request
+func DecideHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		req, err := GetRequest(r.Context(), db, mux.Vars(r)["requestId"])
+		if err != nil || !chamas.IsOfficialContext(r.Context(), db, req.ChamaID, userID) {
+			http.Error(w, "Join request not found", http.StatusNotFound)
+			return
+		}
+		var request struct {
+			Approve bool   `json:"approve"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !request.Approve && request.Reason == "" {
+			http.Error(w, "A reason is required when rejecting a join request", http.StatusBadRequest)
+			return
+		}
+
+		req, err = Decide(r.Context(), db, req, request.Approve, userID, request.Reason)
+		if errors.Is(err, quota.ErrExceeded) {
+			quota.WriteError(w, r, err)
+			return
+		}
+		if !writeError(w, err) {
+			return
+		}
+		if notifier != nil {
+			params := map[string]string{"chama": req.ChamaName, "reason": req.RejectionReason}
+			notifier.Notify(r.Context(), notifications.Notification{
+				UserID:    req.UserID,
+				Title:     i18n.T(i18n.Default, "join."+req.Status, nil),
+				Message:   i18n.T(i18n.Default, "notification.join_"+req.Status, params),
+				Type:      notifications.TypeChama,
+				RelatedID: req.ChamaID,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(req)
+	}
+}
+
+// loadOwnRequest loads the {requestId} join request if it belongs to the caller
+func loadOwnRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) (JoinRequest, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return JoinRequest{}, false
+	}
+	req, err := GetRequest(r.Context(), db, mux.Vars(r)["requestId"])
+	if err != nil || req.UserID != userID {
+		http.Error(w, "Join request not found", http.StatusNotFound)
+		return req, false
+	}
+	return req, true
+}
+
+// writeError writes err as a response and reports whether the handler may continue
+func writeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+	case errors.Is(err, ErrExpired), errors.Is(err, ErrRevoked), errors.Is(err, ErrUsedUp):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, ErrAlreadyMember), errors.Is(err, ErrState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, otp.ErrTooSoon), errors.Is(err, otp.ErrAttempts):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, breaker.ErrUnavailable):
+		breaker.WriteError(w, err)
+	case errors.Is(err, otp.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// NotifyOfficials tells the chama's officials that req is waiting for approval
+func NotifyOfficials(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, req JoinRequest) {
+	if notifier == nil {
+		return
+	}
+	officials, err := chamas.MembersWithRoleContext(ctx, db, req.ChamaID, chamas.OfficialRoles...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load chama officials", "chama_id", req.ChamaID, "error", err)
+		return
+	}
+	params := map[string]string{"name": req.Name, "chama": req.ChamaName, "phone": req.Phone, "message": req.Message}
+	key := "notification.join_pending_approval"
+	switch {
+	case req.InvitationID == "" && req.Message != "":
+		key = "notification.join_requested_message"
+	case req.InvitationID == "":
+		key = "notification.join_requested"
+	}
+	for _, id := range officials {
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "join.pending_approval", nil),
+			Message:   i18n.T(i18n.Default, key, params),
+			Type:      notifications.TypeChama,
+			RelatedID: req.ID,
+		})
+	}
+}
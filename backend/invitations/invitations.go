@@ -0,0 +1,451 @@
+package invitations
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/otp"
+	"tujifund-app/backend/quota"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Invitation statuses
+const (
+	StatusActive  = "active"
+	StatusRevoked = "revoked"
+)
+
+// Join request statuses
+const (
+	RequestOTPSent         = "otp_sent"
+	RequestPendingApproval = "pending_approval"
+	RequestApproved        = "approved"
+	RequestRejected        = "rejected"
+)
+
+// OTPPurpose keys the phone verification codes sent to invitees
+const OTPPurpose = "invitation"
+
+// otpMessage is the SMS sent with a verification code
+const otpMessage = "Your TujiFund verification code is %s. It expires in 10 minutes."
+
+var (
+	ErrExpired       = errors.New("invitation has expired")
+	ErrRevoked       = errors.New("invitation has been revoked")
+	ErrUsedUp        = errors.New("invitation has already been used")
+	ErrAlreadyMember = errors.New("you are already a member of this chama")
+	ErrState         = errors.New("join request is not in the right state for this action")
+)
+
+// codeAlphabet avoids characters that are easily confused when read aloud or typed
+const codeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// codeLength is the length of invitation codes
+const codeLength = 8
+
+// Invitation is a shareable code that lets people ask to join a chama
+type Invitation struct {
+	ID        string    `json:"id"`
+	ChamaID   string    `json:"chamaId"`
+	ChamaName string    `json:"chamaName"`
+	Code      string    `json:"code"`
+	Role      string    `json:"role"`
+	Note      string    `json:"note,omitempty"`
+	MaxUses   int       `json:"maxUses"`
+	Uses      int       `json:"uses"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	InvitedBy string    `json:"invitedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Check reports why the invitation can no longer be used, or nil if it can
+func (inv Invitation) Check() error {
+	switch {
+	case inv.Status == StatusRevoked:
+		return ErrRevoked
+	case time.Now().After(inv.ExpiresAt):
+		return ErrExpired
+	case inv.Uses >= inv.MaxUses:
+		return ErrUsedUp
+	}
+	return nil
+}
+
+// JoinRequest tracks one user joining through an invitation, or asking to
+// join from the chama's public profile
+type JoinRequest struct {
+	ID              string    `json:"id"`
+	InvitationID    string    `json:"invitationId,omitempty"`
+	ChamaID         string    `json:"chamaId"`
+	ChamaName       string    `json:"chamaName"`
+	UserID          string    `json:"userId"`
+	Name            string    `json:"name"`
+	Phone           string    `json:"phone"`
+	Message         string    `json:"message,omitempty"`
+	Status          string    `json:"status"`
+	VerifiedAt      string    `json:"verifiedAt,omitempty"`
+	DecidedBy       string    `json:"decidedBy,omitempty"`
+	DecidedAt       string    `json:"decidedAt,omitempty"`
+	RejectionReason string    `json:"rejectionReason,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// Create stores a new invitation with a random code
+func Create(ctx context.Context, db *sql.DB, inv Invitation) (Invitation, error) {
+	inv.ID = uuid.NewString()
+	if inv.MaxUses <= 0 {
+		inv.MaxUses = 1
+	}
+	for attempt := 0; ; attempt++ {
+		code, err := generateCode()
+		if err != nil {
+			return inv, err
+		}
+		inv.Code = code
+		_, err = txn.From(ctx, db).ExecContext(ctx, `
+			INSERT INTO chama_invitations (id, chama_id, code, role, note, max_uses, expires_at, invited_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			inv.ID, inv.ChamaID, inv.Code, inv.Role, nullIfEmpty(inv.Note), inv.MaxUses,
+			inv.ExpiresAt.UTC().Format("2006-01-02 15:04:05"), inv.InvitedBy,
+		)
+		if err == nil {
+			break
+		}
+		// Retry on the rare code collision
+		if attempt >= 3 || !strings.Contains(err.Error(), "UNIQUE") {
+			return inv, fmt.Errorf("failed to create invitation: %w", err)
+		}
+	}
+	return Get(ctx, db, inv.ID)
+}
+
+const columns = `i.id, i.chama_id, c.name, i.code, i.role, COALESCE(i.note, ''), i.max_uses, i.uses, i.status,
+	i.expires_at, i.invited_by, i.created_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Invitation, error) {
+	var inv Invitation
+	err := row.Scan(&inv.ID, &inv.ChamaID, &inv.ChamaName, &inv.Code, &inv.Role, &inv.Note, &inv.MaxUses, &inv.Uses,
+		&inv.Status, &inv.ExpiresAt, &inv.InvitedBy, &inv.CreatedAt)
+	return inv, err
+}
+
+// Get returns a single invitation
+func Get(ctx context.Context, db *sql.DB, id string) (Invitation, error) {
+	return scan(txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT `+columns+` FROM chama_invitations i JOIN chamas c ON c.id = i.chama_id WHERE i.id = ?`, id))
+}
+
+// Lookup returns the invitation with the given code. Codes are case-insensitive.
+func Lookup(ctx context.Context, db *sql.DB, code string) (Invitation, error) {
+	return scan(txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT `+columns+` FROM chama_invitations i JOIN chamas c ON c.id = i.chama_id WHERE i.code = ?`,
+		strings.ToUpper(strings.TrimSpace(code))))
+}
+
+// List returns a chama's invitations, newest first
+func List(ctx context.Context, db *sql.DB, chamaID string) ([]Invitation, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT `+columns+` FROM chama_invitations i JOIN chamas c ON c.id = i.chama_id
+		WHERE i.chama_id = ? ORDER BY i.created_at DESC`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Invitation{}
+	for rows.Next() {
+		inv, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, inv)
+	}
+	return list, rows.Err()
+}
+
+// Revoke stops an invitation from being used. Join requests already made through it are unaffected.
+func Revoke(ctx context.Context, db *sql.DB, id string) error {
+	_, err := txn.From(ctx, db).ExecContext(ctx, `UPDATE chama_invitations SET status = ? WHERE id = ?`, StatusRevoked, id)
+	return err
+}
+
+// Accept starts a join request for userID through the invitation with code and
+// sends a verification code to phone. Accepting again before approval resends
+// the code and replaces the phone number.
+func Accept(ctx context.Context, db *sql.DB, sender otp.Sender, code, userID, phone string) (JoinRequest, error) {
+	inv, err := Lookup(ctx, db, code)
+	if err != nil {
+		return JoinRequest{}, err
+	}
+	if err := inv.Check(); err != nil {
+		return JoinRequest{}, err
+	}
+	var status string
+	err = txn.From(ctx, db).QueryRowContext(ctx, `SELECT status FROM chama_members WHERE chama_id = ? AND user_id = ?`,
+		inv.ChamaID, userID).Scan(&status)
+	if err == nil && status != "pending" {
+		return JoinRequest{}, ErrAlreadyMember
+	}
+
+	phone = otp.Normalize(phone)
+	res, err := txn.From(ctx, db).ExecContext(ctx, `
+		INSERT INTO join_requests (id, invitation_id, chama_id, user_id, phone)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(chama_id, user_id) DO UPDATE SET
+		    invitation_id = excluded.invitation_id, phone = excluded.phone, status = 'otp_sent',
+		    verified_at = NULL, decided_by = NULL, decided_at = NULL, rejection_reason = NULL
+		WHERE join_requests.status IN ('otp_sent', 'rejected')`,
+		uuid.NewString(), inv.ID, inv.ChamaID, userID, phone,
+	)
+	if err != nil {
+		return JoinRequest{}, fmt.Errorf("failed to create join request: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return JoinRequest{}, ErrState
+	}
+
+	req, err := requestFor(ctx, db, inv.ChamaID, userID)
+	if err != nil {
+		return req, err
+	}
+	return req, otp.Issue(ctx, db, sender, OTPPurpose, phone, otpMessage)
+}
+
+// RequestToJoin asks to join a chama without an invitation, e.g. one found
+// through its public profile. The request goes straight to the chama's
+// officials with message, using the phone number on the user's account;
+// a pending membership is created as for a verified invitation.
+func RequestToJoin(ctx context.Context, db *sql.DB, chamaID, userID, message string) (JoinRequest, error) {
+	var status, phone string
+	err := txn.From(ctx, db).QueryRowContext(ctx, `SELECT status FROM chama_members WHERE chama_id = ? AND user_id = ?`,
+		chamaID, userID).Scan(&status)
+	if err == nil && status != "pending" {
+		return JoinRequest{}, ErrAlreadyMember
+	}
+	txn.From(ctx, db).QueryRowContext(ctx, `SELECT COALESCE(phone_number, '') FROM users WHERE user_id = ?`, userID).Scan(&phone)
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return JoinRequest{}, err
+	}
+	defer txn.Rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO join_requests (id, chama_id, user_id, phone, message, status)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(chama_id, user_id) DO UPDATE SET
+		    invitation_id = NULL, phone = excluded.phone, message = excluded.message, status = excluded.status,
+		    verified_at = NULL, decided_by = NULL, decided_at = NULL, rejection_reason = NULL
+		WHERE join_requests.status IN ('otp_sent', 'rejected')`,
+		uuid.NewString(), chamaID, userID, phone, nullIfEmpty(message), RequestPendingApproval,
+	)
+	if err != nil {
+		return JoinRequest{}, fmt.Errorf("failed to create join request: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return JoinRequest{}, ErrState
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chama_members (id, chama_id, user_id, role, status)
+		VALUES (?, ?, ?, 'member', 'pending')
+		ON CONFLICT(chama_id, user_id) DO NOTHING`,
+		uuid.NewString(), chamaID, userID)
+	if err != nil {
+		return JoinRequest{}, fmt.Errorf("failed to add pending member: %w", err)
+	}
+	if err := txn.Commit(tx); err != nil {
+		return JoinRequest{}, err
+	}
+	return requestFor(ctx, db, chamaID, userID)
+}
+
+// Resend sends a new verification code for a join request awaiting one
+func Resend(ctx context.Context, db *sql.DB, sender otp.Sender, req JoinRequest) error {
+	if req.Status != RequestOTPSent {
+		return ErrState
+	}
+	return otp.Issue(ctx, db, sender, OTPPurpose, req.Phone, otpMessage)
+}
+
+// VerifyPhone checks the code sent for a join request. On success the request
+// waits for approval, the user's phone number is updated and a pending
+// membership is created, using up one use of the invitation.
+func VerifyPhone(ctx context.Context, db *sql.DB, req JoinRequest, code string) (JoinRequest, error) {
+	if req.Status != RequestOTPSent {
+		return req, ErrState
+	}
+	inv, err := Get(ctx, db, req.InvitationID)
+	if err != nil {
+		return req, err
+	}
+	if err := inv.Check(); err != nil {
+		return req, err
+	}
+	if err := otp.Verify(ctx, db, OTPPurpose, req.Phone, code); err != nil {
+		return req, err
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return req, err
+	}
+	defer txn.Rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE chama_invitations SET uses = uses + 1 WHERE id = ? AND uses < max_uses`, inv.ID)
+	if err != nil {
+		return req, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return req, ErrUsedUp
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE join_requests SET status = ?, verified_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		RequestPendingApproval, req.ID)
+	if err != nil {
+		return req, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chama_members (id, chama_id, user_id, role, status)
+		VALUES (?, ?, ?, ?, 'pending')
+		ON CONFLICT(chama_id, user_id) DO NOTHING`,
+		uuid.NewString(), req.ChamaID, req.UserID, inv.Role)
+	if err != nil {
+		return req, fmt.Errorf("failed to add pending member: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET phone_number = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?`, req.Phone, req.UserID)
+	if err != nil {
+		return req, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return req, err
+	}
+	return GetRequest(ctx, db, req.ID)
+}
+
+// Decide approves or rejects a join request whose phone has been verified.
+// Approval activates the membership, if the chama's plan has room for
+// another member; rejection removes the pending one.
+func Decide(ctx context.Context, db *sql.DB, req JoinRequest, approve bool, by, reason string) (JoinRequest, error) {
+	if approve {
+		if err := quota.Check(ctx, db, req.ChamaID, quota.LimitMembers, 1); err != nil {
+			return req, err
+		}
+	}
+	status, member := RequestApproved, `UPDATE chama_members SET status = 'active', join_date = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP WHERE chama_id = ? AND user_id = ? AND status = 'pending'`
+	if !approve {
+		status, member = RequestRejected, `DELETE FROM chama_members WHERE chama_id = ? AND user_id = ? AND status = 'pending'`
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return req, err
+	}
+	defer txn.Rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE join_requests SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP, rejection_reason = ?
+		WHERE id = ? AND status = ?`,
+		status, by, nullIfEmpty(reason), req.ID, RequestPendingApproval)
+	if err != nil {
+		return req, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return req, ErrState
+	}
+	if _, err := tx.ExecContext(ctx, member, req.ChamaID, req.UserID); err != nil {
+		return req, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return req, err
+	}
+	return GetRequest(ctx, db, req.ID)
+}
+
+const requestColumns = `r.id, COALESCE(r.invitation_id, ''), r.chama_id, c.name, r.user_id,
+	COALESCE(NULLIF(TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')), ''), u.username),
+	r.phone, COALESCE(r.message, ''), r.status, COALESCE(r.verified_at, ''), COALESCE(r.decided_by, ''), COALESCE(r.decided_at, ''),
+	COALESCE(r.rejection_reason, ''), r.created_at`
+
+const requestFrom = ` FROM join_requests r JOIN chamas c ON c.id = r.chama_id JOIN users u ON u.user_id = r.user_id`
+
+func scanRequest(row interface{ Scan(...interface{}) error }) (JoinRequest, error) {
+	var r JoinRequest
+	err := row.Scan(&r.ID, &r.InvitationID, &r.ChamaID, &r.ChamaName, &r.UserID, &r.Name, &r.Phone, &r.Message, &r.Status,
+		&r.VerifiedAt, &r.DecidedBy, &r.DecidedAt, &r.RejectionReason, &r.CreatedAt)
+	return r, err
+}
+
+// GetRequest returns a single join request
+func GetRequest(ctx context.Context, db *sql.DB, id string) (JoinRequest, error) {
+	return scanRequest(txn.From(ctx, db).QueryRowContext(ctx, `SELECT `+requestColumns+requestFrom+` WHERE r.id = ?`, id))
+}
+
+func requestFor(ctx context.Context, db *sql.DB, chamaID, userID string) (JoinRequest, error) {
+	return scanRequest(txn.From(ctx, db).QueryRowContext(ctx, `SELECT `+requestColumns+requestFrom+`
+		WHERE r.chama_id = ? AND r.user_id = ?`, chamaID, userID))
+}
+
+// ListRequests returns join requests, newest first. Either chamaID or userID
+// selects whose requests are listed; status is an optional filter.
+func ListRequests(ctx context.Context, db *sql.DB, chamaID, userID, status string) ([]JoinRequest, error) {
+	query := `SELECT ` + requestColumns + requestFrom + ` WHERE 1 = 1`
+	var args []interface{}
+	if chamaID != "" {
+		query += " AND r.chama_id = ?"
+		args = append(args, chamaID)
+	}
+	if userID != "" {
+		query += " AND r.user_id = ?"
+		args = append(args, userID)
+	}
+	if status != "" {
+		query += " AND r.status = ?"
+		args = append(args, status)
+	}
+	rows, err := txn.From(ctx, db).QueryContext(ctx, query+" ORDER BY r.created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []JoinRequest{}
+	for rows.Next() {
+		r, err := scanRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func generateCode() (string, error) {
+	b := make([]byte, codeLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = codeAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,182 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/tenancy"
+
+	"github.com/google/uuid"
+)
+
+// Schedule decides whether a job is due and which period a run belongs to.
+// Two runs with the same key are never executed twice.
+type Schedule interface {
+	// Key returns the period key for t, e.g. "2025-03" for a monthly job
+	Key(t time.Time) string
+	// Due reports whether the job should run at t
+	Due(t time.Time) bool
+}
+
+// Func is the work a job performs
+type Func func(ctx context.Context) error
+
+type job struct {
+	name     string
+	schedule Schedule
+	fn       Func
+}
+
+// Scheduler runs registered jobs in the background. It polls once a minute and
+// records each run in job_runs, so jobs are not repeated after a restart.
+type Scheduler struct {
+	db     *sql.DB
+	jobs   []job
+	tick   time.Duration
+	paused func() bool
+}
+
+// NewScheduler creates a scheduler backed by the job_runs table
+func NewScheduler(db *sql.DB) *Scheduler {
+	return &Scheduler{db: db, tick: time.Minute}
+}
+
+// Register adds a job
+func (s *Scheduler) Register(name string, schedule Schedule, fn Func) {
+	s.jobs = append(s.jobs, job{name: name, schedule: schedule, fn: fn})
+}
+
+// PauseWhile holds back due jobs while paused reports true. Like runs missed
+// while the server was down, they are caught up later in their period.
+func (s *Scheduler) PauseWhile(paused func() bool) {
+	s.paused = paused
+}
+
+// Start runs the scheduler loop until ctx is cancelled. Jobs work across
+// chamas, so they run able to see every chama's rows.
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx = tenancy.WithAllChamas(ctx)
+	go func() {
+		ticker := time.NewTicker(s.tick)
+		defer ticker.Stop()
+		s.runDue(ctx, time.Now())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.runDue(ctx, now)
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) runDue(ctx context.Context, now time.Time) {
+	if s.paused != nil && s.paused() {
+		return
+	}
+	for _, j := range s.jobs {
+		if j.schedule.Due(now) {
+			if err := s.Run(ctx, j.name, j.schedule.Key(now), j.fn); err != nil {
+				slog.ErrorContext(ctx, "Job failed", "job", j.name, "error", err)
+			}
+		}
+	}
+}
+
+// Run executes fn once for (name, key). It returns nil without running fn if
+// that run has already been claimed.
+func (s *Scheduler) Run(ctx context.Context, name, key string, fn Func) error {
+	id := uuid.NewString()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO job_runs (id, job_name, run_key) VALUES (?, ?, ?)`, id, name, key)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil
+		}
+		return fmt.Errorf("failed to record job run: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Job started", "job", name, "run_key", key)
+	start := time.Now()
+	runErr := safeRun(ctx, fn)
+
+	status, errText := "completed", interface{}(nil)
+	if runErr != nil {
+		status, errText = "failed", runErr.Error()
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE job_runs SET status = ?, error = ?, finished_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		status, errText, id); err != nil {
+		slog.ErrorContext(ctx, "Failed to record job result", "job", name, "error", err)
+	}
+	slog.InfoContext(ctx, "Job finished", "job", name, "run_key", key, "status", status, "duration_ms", time.Since(start).Milliseconds())
+	return runErr
+}
+
+func safeRun(ctx context.Context, fn Func) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
+
+// Every runs a job once per interval
+type Every time.Duration
+
+func (e Every) Key(t time.Time) string {
+	return fmt.Sprint(t.UnixNano() / int64(e))
+}
+
+func (e Every) Due(t time.Time) bool { return true }
+
+// Daily runs a job once a day at or after Hour (local time)
+type Daily struct {
+	Hour int
+}
+
+func (d Daily) Key(t time.Time) string { return t.Format("2006-01-02") }
+
+func (d Daily) Due(t time.Time) bool { return t.Hour() >= d.Hour }
+
+// Monthly runs a job once a month on or after Day at or after Hour (local time)
+type Monthly struct {
+	Day  int
+	Hour int
+}
+
+func (m Monthly) Key(t time.Time) string { return t.Format("2006-01") }
+
+func (m Monthly) Due(t time.Time) bool {
+	return t.Day() > m.Day || (t.Day() == m.Day && t.Hour() >= m.Hour)
+}
+
+// Window runs a job once a day between the hours From and To (local time),
+// for heavy work that must stay out of busy hours. The window may span
+// midnight, e.g. From 23 To 4.
+type Window struct {
+	From int
+	To   int
+}
+
+// Key is the date the window opened on
+func (w Window) Key(t time.Time) string {
+	if w.From > w.To && t.Hour() < w.To {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t.Format("2006-01-02")
+}
+
+func (w Window) Due(t time.Time) bool {
+	h := t.Hour()
+	if w.From <= w.To {
+		return h >= w.From && h < w.To
+	}
+	return h >= w.From || h < w.To
+}
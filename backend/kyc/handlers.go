@@ -0,0 +1,184 @@
+package kyc
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/storage"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// MaxDocumentSize is the largest accepted upload
+const MaxDocumentSize = 5 << 20 // 5 MB
+
+// UploadDocumentHandler accepts a multipart upload with "documentType" and "file" fields
+func UploadDocumentHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MaxDocumentSize+1<<20)
+		if err := r.ParseMultipartForm(MaxDocumentSize); err != nil {
+			http.Error(w, "Invalid upload or file too large", http.StatusBadRequest)
+			return
+		}
+
+		docType := r.FormValue("documentType")
+		if !validDocumentType(docType) {
+			http.Error(w, "Invalid document type", http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "File is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		// Detect the type from the content rather than trusting the client
+		mimeType, err := storage.DetectType(file, header.Size, MaxDocumentSize, storage.DocumentTypes)
+		if errors.Is(err, storage.ErrTooLarge) {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Only JPEG, PNG and PDF files are accepted", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		key := "kyc/" + userID + "/" + uuid.NewString() + storage.Extensions[mimeType]
+		if err := store.Put(r.Context(), key, file, header.Size, mimeType); err != nil {
+			http.Error(w, "Failed to store document", http.StatusInternalServerError)
+			return
+		}
+
+		doc, err := AddDocument(db, Document{
+			UserID:       userID,
+			DocumentType: docType,
+			StorageKey:   key,
+			MimeType:     mimeType,
+			SizeBytes:    header.Size,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(doc)
+	}
+}
+
+// StatusHandler returns the caller's KYC status, documents and history
+func StatusHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		writeStatus(w, db, store, userID)
+	}
+}
+
+// ReviewHandler lets an official approve or reject a member's KYC.
+// The member is identified by the {userId} path variable.
+func ReviewHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reviewerID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		if !isOfficial(db, reviewerID) {
+			http.Error(w, "Only officials can review KYC", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Approved bool   `json:"approved"`
+			Reason   string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !request.Approved && request.Reason == "" {
+			http.Error(w, "A reason is required when rejecting", http.StatusBadRequest)
+			return
+		}
+
+		userID := mux.Vars(r)["userId"]
+		if err := Review(db, userID, reviewerID, Decision{Approved: request.Approved, Reason: request.Reason}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeStatus(w, db, store, userID)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, db *sql.DB, store storage.Backend, userID string) {
+	v, err := GetVerification(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	docs, err := ListDocuments(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Short-lived links so officials can view the documents
+	links := make(map[string]string, len(docs))
+	for _, d := range docs {
+		if url, err := store.SignedURL(d.StorageKey, 15*time.Minute); err == nil {
+			links[d.ID] = url
+		}
+	}
+
+	history, err := History(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"verification": v,
+		"documents":    docs,
+		"documentUrls": links,
+		"history":      history,
+	})
+}
+
+// isOfficial reports whether the user is a platform admin or holds an
+// official role (chairperson, treasurer, secretary) in any chama
+func isOfficial(db *sql.DB, userID string) bool {
+	var count int
+	err := db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM users WHERE id = ? AND role = 'admin')
+		     + (SELECT COUNT(*) FROM chama_members WHERE user_id = ? AND status = 'active'
+		          AND role IN ('admin', 'chairperson', 'treasurer', 'secretary'))`,
+		userID, userID,
+	).Scan(&count)
+	return err == nil && count > 0
+}
+
+func validDocumentType(t string) bool {
+	for _, dt := range DocumentTypes {
+		if t == dt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+package kyc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Verification statuses
+const (
+	StatusUnverified = "unverified"
+	StatusPending    = "pending"
+	StatusVerified   = "verified"
+	StatusRejected   = "rejected"
+)
+
+// Document types members can upload
+const (
+	DocNationalIDFront = "national_id_front"
+	DocNationalIDBack  = "national_id_back"
+	DocPassport        = "passport"
+	DocSelfie          = "selfie"
+)
+
+// DocumentTypes lists the accepted document types
+var DocumentTypes = []string{DocNationalIDFront, DocNationalIDBack, DocPassport, DocSelfie}
+
+// ErrNotVerified is returned by RequireVerified for members without a verified KYC
+var ErrNotVerified = errors.New("KYC verification required")
+
+// Document is a stored KYC document reference
+type Document struct {
+	ID           string `json:"id"`
+	UserID       string `json:"userId"`
+	DocumentType string `json:"documentType"`
+	StorageKey   string `json:"-"`
+	MimeType     string `json:"mimeType"`
+	SizeBytes    int64  `json:"sizeBytes"`
+	UploadedAt   string `json:"uploadedAt"`
+}
+
+// Verification is a member's current KYC state
+type Verification struct {
+	UserID          string `json:"userId"`
+	Status          string `json:"status"`
+	Provider        string `json:"provider,omitempty"`
+	ReviewedBy      string `json:"reviewedBy,omitempty"`
+	ReviewedAt      string `json:"reviewedAt,omitempty"`
+	RejectionReason string `json:"rejectionReason,omitempty"`
+}
+
+// HistoryEntry records one status change
+type HistoryEntry struct {
+	FromStatus string `json:"fromStatus,omitempty"`
+	ToStatus   string `json:"toStatus"`
+	ChangedBy  string `json:"changedBy,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	ChangedAt  string `json:"changedAt"`
+}
+
+// Decision is the outcome of a manual or provider review
+type Decision struct {
+	Approved bool
+	Reason   string
+}
+
+// Provider is implemented by external identity verification services
+// (e.g. Smile ID). Name is stored on the verification record.
+type Provider interface {
+	Name() string
+	Verify(ctx context.Context, userID string, docs []Document) (Decision, error)
+}
+
+// GetVerification returns the KYC state for a user, defaulting to unverified
+func GetVerification(db *sql.DB, userID string) (Verification, error) {
+	v := Verification{UserID: userID, Status: StatusUnverified}
+	var provider, reviewedBy, reviewedAt, reason sql.NullString
+	err := db.QueryRow(`
+		SELECT status, provider, reviewed_by, reviewed_at, rejection_reason
+		FROM kyc_verifications WHERE user_id = ?`, userID,
+	).Scan(&v.Status, &provider, &reviewedBy, &reviewedAt, &reason)
+	if err == sql.ErrNoRows {
+		return v, nil
+	}
+	if err != nil {
+		return v, err
+	}
+	v.Provider, v.ReviewedBy, v.ReviewedAt, v.RejectionReason = provider.String, reviewedBy.String, reviewedAt.String, reason.String
+	return v, nil
+}
+
+// IsVerified reports whether the user has passed KYC
+func IsVerified(db *sql.DB, userID string) (bool, error) {
+	v, err := GetVerification(db, userID)
+	if err != nil {
+		return false, err
+	}
+	return v.Status == StatusVerified, nil
+}
+
+// RequireVerified returns ErrNotVerified unless the user has passed KYC.
+// Loan applications call this before accepting a request.
+func RequireVerified(db *sql.DB, userID string) error {
+	ok, err := IsVerified(db, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotVerified
+	}
+	return nil
+}
+
+// AddDocument stores a document reference and moves the user to pending review
+func AddDocument(db *sql.DB, doc Document) (Document, error) {
+	doc.ID = uuid.NewString()
+	_, err := db.Exec(`
+		INSERT INTO kyc_documents (id, user_id, document_type, storage_key, mime_type, size_bytes)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		doc.ID, doc.UserID, doc.DocumentType, doc.StorageKey, doc.MimeType, doc.SizeBytes,
+	)
+	if err != nil {
+		return doc, fmt.Errorf("failed to save KYC document: %w", err)
+	}
+
+	current, err := GetVerification(db, doc.UserID)
+	if err != nil {
+		return doc, err
+	}
+	if current.Status == StatusUnverified || current.Status == StatusRejected {
+		if err := SetStatus(db, doc.UserID, StatusPending, "", "", "documents uploaded"); err != nil {
+			return doc, err
+		}
+	}
+	return doc, nil
+}
+
+// ListDocuments returns the documents a user has uploaded, newest first
+func ListDocuments(db *sql.DB, userID string) ([]Document, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, document_type, storage_key, mime_type, size_bytes, uploaded_at
+		FROM kyc_documents WHERE user_id = ? ORDER BY uploaded_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []Document
+	for rows.Next() {
+		var d Document
+		if err := rows.Scan(&d.ID, &d.UserID, &d.DocumentType, &d.StorageKey, &d.MimeType, &d.SizeBytes, &d.UploadedAt); err != nil {
+			return nil, err
+		}
+		docs = append(docs, d)
+	}
+	return docs, rows.Err()
+}
+
+// SetStatus changes a user's KYC status and appends a history entry in one transaction
+func SetStatus(db *sql.DB, userID, status, provider, changedBy, reason string) error {
+	current, err := GetVerification(db, userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var rejection interface{}
+	if status == StatusRejected {
+		rejection = reason
+	}
+	var reviewer interface{}
+	if changedBy != "" {
+		reviewer = changedBy
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO kyc_verifications (user_id, status, provider, reviewed_by, reviewed_at, rejection_reason)
+		VALUES (?, ?, ?, ?, CASE WHEN ? IN ('verified', 'rejected') THEN CURRENT_TIMESTAMP END, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			status = excluded.status,
+			provider = COALESCE(excluded.provider, kyc_verifications.provider),
+			reviewed_by = excluded.reviewed_by,
+			reviewed_at = excluded.reviewed_at,
+			rejection_reason = excluded.rejection_reason,
+			updated_at = CURRENT_TIMESTAMP`,
+		userID, status, nullIfEmpty(provider), reviewer, status, rejection,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update KYC status: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO kyc_status_history (id, user_id, from_status, to_status, changed_by, reason)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		uuid.NewString(), userID, current.Status, status, nullIfEmpty(changedBy), nullIfEmpty(reason),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record KYC history: %w", err)
+	}
+	return tx.Commit()
+}
+
+// History returns the status changes for a user, oldest first
+func History(db *sql.DB, userID string) ([]HistoryEntry, error) {
+	rows, err := db.Query(`
+		SELECT COALESCE(from_status, ''), to_status, COALESCE(changed_by, ''), COALESCE(reason, ''), changed_at
+		FROM kyc_status_history WHERE user_id = ? ORDER BY changed_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []HistoryEntry
+	for rows.Next() {
+		var h HistoryEntry
+		if err := rows.Scan(&h.FromStatus, &h.ToStatus, &h.ChangedBy, &h.Reason, &h.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+// Review records a manual decision by an official
+func Review(db *sql.DB, userID, reviewerID string, d Decision) error {
+	status := StatusRejected
+	if d.Approved {
+		status = StatusVerified
+	}
+	return SetStatus(db, userID, status, "manual", reviewerID, d.Reason)
+}
+
+// VerifyWithProvider sends the user's documents to an external provider and records its decision
+func VerifyWithProvider(ctx context.Context, db *sql.DB, p Provider, userID string) (Decision, error) {
+	docs, err := ListDocuments(db, userID)
+	if err != nil {
+		return Decision{}, err
+	}
+	d, err := p.Verify(ctx, userID, docs)
+	if err != nil {
+		return Decision{}, fmt.Errorf("%s verification failed: %w", p.Name(), err)
+	}
+	status := StatusRejected
+	if d.Approved {
+		status = StatusVerified
+	}
+	return d, SetStatus(db, userID, status, p.Name(), "", d.Reason)
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,309 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/txn"
+)
+
+// The ledger's monthly balances are kept in ledger_balances: the total of
+// the entries in History for each account, member, entry type and month.
+// Posting adds to them in the posting's transaction, so totals over years of
+// entries read the whole months from there and only the entries of the
+// partial months at either end of the range.
+
+// addBalance adds e to its month's balance
+func addBalance(ctx context.Context, tx *sql.Tx, e Entry) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO ledger_balances (chama_id, account_id, member_id, entry_type, period, amount_minor, entries)
+		VALUES (?, ?, ?, ?, ?, ?, 1)
+		ON CONFLICT(chama_id, account_id, member_id, entry_type, period) DO UPDATE SET
+			amount_minor = amount_minor + excluded.amount_minor, entries = entries + 1`,
+		e.ChamaID, e.AccountID, e.MemberID, e.Type, PeriodOf(e.EffectiveAt), e.Amount.Amount)
+	if err != nil {
+		return fmt.Errorf("failed to update monthly balance: %w", err)
+	}
+	return nil
+}
+
+// historyBalances totals History the way ledger_balances keeps it
+const historyBalances = `
+	SELECT account_id, COALESCE(member_id, ''), entry_type, substr(effective_at, 1, 7), SUM(amount_minor), COUNT(*)
+	FROM ` + History + ` WHERE chama_id = ?
+	GROUP BY account_id, COALESCE(member_id, ''), entry_type, substr(effective_at, 1, 7)`
+
+// RefreshBalances recomputes a chama's monthly balances from its History.
+// Code that changes ledger_entries other than through Post must call it in
+// the same transaction.
+func RefreshBalances(ctx context.Context, tx *sql.Tx, chamaID string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ledger_balances WHERE chama_id = ?`, chamaID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO ledger_balances (chama_id, account_id, member_id, entry_type, period, amount_minor, entries)
+		SELECT ?, b.* FROM (`+historyBalances+`) b`, chamaID, chamaID)
+	if err != nil {
+		return fmt.Errorf("failed to recompute monthly balances: %w", err)
+	}
+	return nil
+}
+
+// EnsureBalances computes the monthly balances of chamas whose ledger
+// predates them
+func EnsureBalances(ctx context.Context, db *sql.DB) error {
+	ids, err := chamaIDs(ctx, db, `
+		SELECT DISTINCT chama_id FROM `+History+`
+		WHERE chama_id NOT IN (SELECT chama_id FROM ledger_balances)`)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := refresh(ctx, db, id); err != nil {
+			return fmt.Errorf("chama %s: %w", id, err)
+		}
+	}
+	if len(ids) > 0 {
+		slog.InfoContext(ctx, "Computed monthly ledger balances", "chamas", len(ids))
+	}
+	return nil
+}
+
+func refresh(ctx context.Context, db *sql.DB, chamaID string) error {
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback(tx)
+	if err := RefreshBalances(ctx, tx, chamaID); err != nil {
+		return err
+	}
+	return txn.Commit(tx)
+}
+
+// BalanceDrift is a month whose kept balance differs from its History
+type BalanceDrift struct {
+	AccountID string `json:"accountId"`
+	MemberID  string `json:"memberId,omitempty"`
+	Type      string `json:"entryType"`
+	Period    string `json:"period"`
+	Kept      int64  `json:"keptMinor"`
+	Actual    int64  `json:"actualMinor"`
+}
+
+// CheckBalances compares a chama's monthly balances with totals recomputed
+// from its History
+func CheckBalances(ctx context.Context, db *sql.DB, chamaID string) ([]BalanceDrift, error) {
+	type key struct{ account, member, typ, period string }
+	actual := map[key][2]int64{}
+	rows, err := txn.From(ctx, db).QueryContext(ctx, historyBalances, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var k key
+		var amount, entries int64
+		if err := rows.Scan(&k.account, &k.member, &k.typ, &k.period, &amount, &entries); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		actual[k] = [2]int64{amount, entries}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var drift []BalanceDrift
+	rows, err = txn.From(ctx, db).QueryContext(ctx, `
+		SELECT account_id, member_id, entry_type, period, amount_minor, entries FROM ledger_balances
+		WHERE chama_id = ? ORDER BY period, account_id, member_id, entry_type`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k key
+		var amount, entries int64
+		if err := rows.Scan(&k.account, &k.member, &k.typ, &k.period, &amount, &entries); err != nil {
+			return nil, err
+		}
+		if a, ok := actual[k]; !ok || a[0] != amount || a[1] != entries {
+			drift = append(drift, BalanceDrift{AccountID: k.account, MemberID: k.member, Type: k.typ, Period: k.period,
+				Kept: amount, Actual: a[0]})
+		}
+		delete(actual, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Months with entries but no kept balance
+	for k, a := range actual {
+		drift = append(drift, BalanceDrift{AccountID: k.account, MemberID: k.member, Type: k.typ, Period: k.period, Actual: a[0]})
+	}
+	return drift, nil
+}
+
+// RegisterBalanceCheckJob checks every chama's monthly balances nightly and
+// recomputes those that have drifted from the ledger
+func RegisterBalanceCheckJob(s *jobs.Scheduler, db *sql.DB) {
+	s.Register("ledger_balance_check", jobs.Daily{Hour: 3}, func(ctx context.Context) error {
+		ids, err := chamaIDs(ctx, db, `SELECT id FROM chamas`)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			drift, err := CheckBalances(ctx, db, id)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to check monthly ledger balances", "chama_id", id, "error", err)
+				continue
+			}
+			if len(drift) == 0 {
+				continue
+			}
+			slog.WarnContext(ctx, "Monthly ledger balances drifted from the ledger", "chama_id", id, "months", len(drift),
+				"first_period", drift[0].Period, "first_account_id", drift[0].AccountID)
+			if err := refresh(ctx, db, id); err != nil {
+				slog.ErrorContext(ctx, "Failed to recompute monthly ledger balances", "chama_id", id, "error", err)
+			}
+		}
+		return nil
+	})
+}
+
+func chamaIDs(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// sums totals the entries matching f, grouped by the given column of
+// ledger_balances and History, or in one total keyed "" when group is empty.
+// Whole months between f.From and f.To come from ledger_balances.
+func sums(ctx context.Context, db *sql.DB, f Filter, group string) (map[string]int64, error) {
+	first, last := wholeMonths(f.From, f.To)
+	totals := map[string]int64{}
+	if !first.IsZero() && !last.IsZero() && !first.Before(last) {
+		// No whole month in the range
+		return totals, add(ctx, db, totals, group, History, f.Where)
+	}
+
+	err := add(ctx, db, totals, group, "ledger_balances", func() (string, []interface{}) { return f.balanceWhere(first, last) })
+	if err != nil {
+		return nil, err
+	}
+	if !f.From.IsZero() && f.From.Before(first) {
+		head := f
+		head.To = first
+		if err := add(ctx, db, totals, group, History, head.Where); err != nil {
+			return nil, err
+		}
+	}
+	if !f.To.IsZero() && last.Before(f.To) {
+		tail := f
+		tail.From = last
+		if err := add(ctx, db, totals, group, History, tail.Where); err != nil {
+			return nil, err
+		}
+	}
+	return totals, nil
+}
+
+// add adds the amounts in table matching where to totals
+func add(ctx context.Context, db *sql.DB, totals map[string]int64, group, table string, where func() (string, []interface{})) error {
+	column := "''"
+	if group != "" {
+		column = group
+	}
+	cond, args := where()
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT `+column+`, COALESCE(SUM(amount_minor), 0) FROM `+table+` WHERE `+cond+` GROUP BY `+column, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k string
+		var amount int64
+		if err := rows.Scan(&k, &amount); err != nil {
+			return err
+		}
+		totals[k] += amount
+	}
+	return rows.Err()
+}
+
+// wholeMonths returns the start of the first whole month on or after from
+// and the start of the month to falls in. Either is zero when its bound is.
+func wholeMonths(from, to time.Time) (first, last time.Time) {
+	if !from.IsZero() {
+		from = from.UTC()
+		first = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if first.Before(from) {
+			first = first.AddDate(0, 1, 0)
+		}
+	}
+	if !to.IsZero() {
+		to = to.UTC()
+		last = time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return first, last
+}
+
+// balanceWhere builds the ledger_balances condition matching f for the
+// months from first up to last
+func (f Filter) balanceWhere(first, last time.Time) (string, []interface{}) {
+	where := "chama_id = ?"
+	args := []interface{}{f.ChamaID}
+	if f.AccountID != "" {
+		where += " AND account_id = ?"
+		args = append(args, f.AccountID)
+	}
+	if f.MemberID != "" {
+		where += " AND member_id = ?"
+		args = append(args, f.MemberID)
+	}
+	if f.WithMember {
+		where += " AND member_id != ''"
+	}
+	if f.Type != "" {
+		where += " AND entry_type = ?"
+		args = append(args, f.Type)
+	}
+	if len(f.Types) > 0 {
+		where += " AND entry_type IN (?" + strings.Repeat(", ?", len(f.Types)-1) + ")"
+		for _, t := range f.Types {
+			args = append(args, t)
+		}
+	}
+	if !first.IsZero() {
+		where += " AND period >= ?"
+		args = append(args, PeriodOf(first))
+	}
+	if !last.IsZero() {
+		where += " AND period < ?"
+		args = append(args, PeriodOf(last))
+	}
+	return where, args
+}
+
+// SumByAccount returns the total of entries matching f for each account
+func SumByAccount(ctx context.Context, db *sql.DB, f Filter) (map[string]int64, error) {
+	return sums(ctx, db, f, "account_id")
+}
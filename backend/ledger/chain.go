@@ -0,0 +1,235 @@
+package ledger
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"tujifund-app/backend/txn"
+)
+
+// Each chama's entries form a hash chain: an entry's hash covers its
+// contents, its position in the chain and the hash of the entry before it,
+// so editing, removing or reordering any entry after the fact breaks every
+// link after it. Archived entries keep their links, so the chain runs
+// through ledger_entries_archive as well. Carry-forward entries are derived
+// from archived ones and are not part of it.
+
+// hashColumns are the contents an entry's hash covers, read as stored
+const hashColumns = `id, chama_id, account_id, COALESCE(member_id, ''), entry_type, CAST(amount_minor AS TEXT), currency,
+	COALESCE(reference, ''), COALESCE(description, ''), COALESCE(CAST(effective_at AS TEXT), ''),
+	COALESCE(created_by, ''), COALESCE(CAST(created_at AS TEXT), '')`
+
+const hashFields = 12
+
+func hashEntry(seq int64, prev string, fields []string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\n%s\n%s", seq, prev, strings.Join(fields, "\x1f"))))
+	return hex.EncodeToString(sum[:])
+}
+
+// scanFields reads hashColumns after the given leading destinations
+func scanFields(row interface{ Scan(...interface{}) error }, lead ...interface{}) ([]string, error) {
+	fields := make([]string, hashFields)
+	dest := lead
+	for i := range fields {
+		dest = append(dest, &fields[i])
+	}
+	return fields, row.Scan(dest...)
+}
+
+// NextSeq claims the next number in the chama's posting sequence, which
+// orders its entries in ledger_entries and the archive. The claim updates
+// the chama's row in ledger_sequences, so postings to one chama take their
+// numbers one transaction at a time, in the order they commit.
+func NextSeq(ctx context.Context, tx *sql.Tx, chamaID string) (int64, error) {
+	var seq int64
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO ledger_sequences (chama_id, last_seq)
+		SELECT ?, COALESCE(MAX(seq), 0) + 1 FROM (
+			SELECT seq FROM ledger_entries WHERE chama_id = ?
+			UNION ALL
+			SELECT seq FROM ledger_entries_archive WHERE chama_id = ?
+		) AS posted WHERE true
+		ON CONFLICT (chama_id) DO UPDATE SET last_seq = ledger_sequences.last_seq + 1
+		RETURNING last_seq`, chamaID, chamaID, chamaID).Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to number ledger entry: %w", err)
+	}
+	return seq, nil
+}
+
+// Seal links the chama's entries that are not yet in its hash chain onto
+// the end of it, in posting order (seq). Post seals every entry it writes; code
+// that inserts entries into ledger_entries directly must call Seal in the
+// same transaction.
+func Seal(ctx context.Context, tx *sql.Tx, chamaID string) error {
+	var seq int64
+	var prev string
+	err := tx.QueryRowContext(ctx, `
+		SELECT chain_seq, hash FROM (
+			SELECT chain_seq, hash FROM ledger_entries WHERE chama_id = ? AND chain_seq IS NOT NULL
+			UNION ALL
+			SELECT chain_seq, hash FROM ledger_entries_archive WHERE chama_id = ? AND chain_seq IS NOT NULL
+		) AS chain ORDER BY chain_seq DESC LIMIT 1`, chamaID, chamaID).Scan(&seq, &prev)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to load the end of the ledger's hash chain: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, `+hashColumns+` FROM ledger_entries
+		WHERE chama_id = ? AND chain_seq IS NULL AND archive_id IS NULL ORDER BY seq`, chamaID)
+	if err != nil {
+		return err
+	}
+	type link struct {
+		id         string
+		seq        int64
+		prev, hash string
+	}
+	var links []link
+	for rows.Next() {
+		var id string
+		fields, err := scanFields(rows, &id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		seq++
+		l := link{id: id, seq: seq, prev: prev, hash: hashEntry(seq, prev, fields)}
+		links = append(links, l)
+		prev = l.hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		_, err := tx.ExecContext(ctx, `UPDATE ledger_entries SET chain_seq = ?, prev_hash = ?, hash = ? WHERE id = ?`,
+			l.seq, l.prev, l.hash, l.id)
+		if err != nil {
+			return fmt.Errorf("failed to seal ledger entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// Rechain rebuilds the chama's hash chain over its entries, archived ones
+// included, keeping their order in the old chain, then seals any entries
+// not yet in it. It is only for when entries' contents change legitimately,
+// as when a chama is imported from another deployment under new IDs.
+func Rechain(ctx context.Context, tx *sql.Tx, chamaID string) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT 1, id, chain_seq, seq, `+hashColumns+` FROM ledger_entries_archive
+		WHERE chama_id = ? AND chain_seq IS NOT NULL
+		UNION ALL
+		SELECT 0, id, chain_seq, seq, `+hashColumns+` FROM ledger_entries
+		WHERE chama_id = ? AND chain_seq IS NOT NULL
+		ORDER BY 3, 4`, chamaID, chamaID)
+	if err != nil {
+		return err
+	}
+	type link struct {
+		archived bool
+		id       string
+		seq      int64
+		prev     string
+		hash     string
+	}
+	var links []link
+	var seq int64
+	var prev string
+	for rows.Next() {
+		var l link
+		var oldSeq, posted int64
+		fields, err := scanFields(rows, &l.archived, &l.id, &oldSeq, &posted)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		seq++
+		l.seq, l.prev, l.hash = seq, prev, hashEntry(seq, prev, fields)
+		links = append(links, l)
+		prev = l.hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		query := `UPDATE ledger_entries SET chain_seq = ?, prev_hash = ?, hash = ? WHERE id = ?`
+		if l.archived {
+			query = `UPDATE ledger_entries_archive SET chain_seq = ?, prev_hash = ?, hash = ? WHERE id = ?`
+		}
+		if _, err := tx.ExecContext(ctx, query, l.seq, l.prev, l.hash, l.id); err != nil {
+			return fmt.Errorf("failed to relink ledger entry: %w", err)
+		}
+	}
+	return Seal(ctx, tx, chamaID)
+}
+
+// Verification is the result of checking a chama's hash chain
+type Verification struct {
+	ChamaID  string `json:"chamaId"`
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`            // entries checked
+	Unsealed int    `json:"unsealed"`           // entries not yet in the chain, which cannot be checked
+	Head     string `json:"head,omitempty"`     // hash of the last entry; auditors can note it to detect a later rewrite of the whole chain
+	BrokenAt string `json:"brokenAt,omitempty"` // ID of the first entry that fails
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Verify walks the chama's hash chain and reports the first entry whose
+// contents no longer match its hash, or which does not follow the entry
+// before it
+func Verify(ctx context.Context, db *sql.DB, chamaID string) (Verification, error) {
+	v := Verification{ChamaID: chamaID, Valid: true}
+	err := txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM ledger_entries WHERE chama_id = ? AND chain_seq IS NULL AND archive_id IS NULL`,
+		chamaID).Scan(&v.Unsealed)
+	if err != nil {
+		return v, err
+	}
+
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT chain_seq, prev_hash, hash, `+hashColumns+` FROM ledger_entries
+		WHERE chama_id = ? AND chain_seq IS NOT NULL
+		UNION ALL
+		SELECT chain_seq, prev_hash, hash, `+hashColumns+` FROM ledger_entries_archive
+		WHERE chama_id = ? AND chain_seq IS NOT NULL
+		ORDER BY 1`, chamaID, chamaID)
+	if err != nil {
+		return v, err
+	}
+	defer rows.Close()
+
+	var prev string
+	for rows.Next() {
+		var seq int64
+		var prevHash, hash string
+		fields, err := scanFields(rows, &seq, &prevHash, &hash)
+		if err != nil {
+			return v, err
+		}
+		v.Entries++
+		switch {
+		case seq != int64(v.Entries):
+			v.Reason = "an entry before this one is missing from the chain"
+		case prevHash != prev:
+			v.Reason = "entry does not follow the entry before it"
+		case hashEntry(seq, prevHash, fields) != hash:
+			v.Reason = "entry has been changed since it was posted"
+		}
+		if v.Reason != "" {
+			v.Valid, v.BrokenAt = false, fields[0]
+			return v, nil
+		}
+		prev = hash
+	}
+	v.Head = prev
+	return v, rows.Err()
+}
@@ -0,0 +1,141 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"tujifund-app/backend/money"
+
+	_ "modernc.org/sqlite"
+)
+
+// chainDB returns a database with one chama holding three sealed entries,
+// and their IDs in posting order
+func chainDB(t *testing.T) (*sql.DB, []string) {
+	t.Helper()
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", "file:"+t.TempDir()+"/ledger.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	schema, err := os.ReadFile("../database/database_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		string(schema),
+		`INSERT INTO users (user_id, username, email) VALUES ('u1', 'u1', 'u1@example.com')`,
+		`INSERT INTO chamas (id, name, type, created_by) VALUES ('c1', 'Chama', 'savings', 'u1')`,
+		`INSERT INTO chama_accounts (id, chama_id, name, account_type) VALUES ('a1', 'c1', 'Main', 'general')`,
+	} {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	for i := 1; i <= 3; i++ {
+		e, err := PostOne(ctx, db, Entry{
+			ChamaID: "c1", AccountID: "a1", MemberID: "u1", Type: "contribution",
+			Amount: money.New(int64(i)*10000, "KES"), Reference: fmt.Sprintf("R%d", i),
+			EffectiveAt: time.Date(2026, 1, i, 0, 0, 0, 0, time.UTC), CreatedBy: "u1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.ID)
+	}
+	return db, ids
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   string // run with the IDs of the entries as arguments
+		entries  int
+		brokenAt int // index of the entry reported, or -1 when the chain is valid
+		reason   string
+		unsealed int
+	}{
+		{name: "untouched", entries: 3, brokenAt: -1},
+		{
+			name:    "amount changed",
+			tamper:  `UPDATE ledger_entries SET amount_minor = amount_minor + 1 WHERE id = ?2`,
+			entries: 2, brokenAt: 1, reason: "entry has been changed since it was posted",
+		},
+		{
+			name:    "entry deleted",
+			tamper:  `DELETE FROM ledger_entries WHERE id = ?2`,
+			entries: 2, brokenAt: 2, reason: "an entry before this one is missing from the chain",
+		},
+		{
+			name:    "link changed",
+			tamper:  `UPDATE ledger_entries SET prev_hash = 'x' WHERE id = ?3`,
+			entries: 3, brokenAt: 2, reason: "entry does not follow the entry before it",
+		},
+		{
+			name: "entries reordered",
+			tamper: `UPDATE ledger_entries SET chain_seq = CASE id WHEN ?1 THEN 2 ELSE 1 END
+				WHERE id IN (?1, ?2)`,
+			entries: 1, brokenAt: 1, reason: "entry does not follow the entry before it",
+		},
+		{
+			name: "unsealed entry",
+			tamper: `INSERT INTO ledger_entries (id, chama_id, account_id, entry_type, amount_minor, currency, seq)
+				VALUES ('e4', 'c1', 'a1', 'contribution', 100, 'KES', 4)`,
+			entries: 3, brokenAt: -1, unsealed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, ids := chainDB(t)
+			if tt.tamper != "" {
+				if _, err := db.Exec(tt.tamper, ids[0], ids[1], ids[2]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			v, err := Verify(context.Background(), db, "c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Valid != (tt.brokenAt < 0) || v.Entries != tt.entries || v.Reason != tt.reason || v.Unsealed != tt.unsealed {
+				t.Fatalf("got %+v", v)
+			}
+			if tt.brokenAt >= 0 && v.BrokenAt != ids[tt.brokenAt] {
+				t.Errorf("broken at %s, want %s", v.BrokenAt, ids[tt.brokenAt])
+			}
+		})
+	}
+}
+
+func TestSealAfterUnsealed(t *testing.T) {
+	db, _ := chainDB(t)
+	ctx := context.Background()
+	_, err := db.Exec(`INSERT INTO ledger_entries (id, chama_id, account_id, entry_type, amount_minor, currency, seq)
+		VALUES ('e4', 'c1', 'a1', 'contribution', 100, 'KES', 4)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Seal(ctx, tx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := Verify(ctx, db, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid || v.Entries != 4 || v.Unsealed != 0 {
+		t.Errorf("got %+v", v)
+	}
+}
@@ -0,0 +1,216 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/txn"
+)
+
+// EventSourcing makes ledger_events the source of truth for the ledger.
+// Each posting is first appended to the event stream, which cannot be
+// changed once written; ledger_entries, account balances and the registered
+// Projections are then derived from it and can be rebuilt with Rebuild.
+var EventSourcing bool
+
+// Event types
+const (
+	EventPosted = "entry_posted"
+)
+
+// Event is one change to a chama's ledger, in the order it happened
+type Event struct {
+	Seq        int64     `json:"seq"`
+	ChamaID    string    `json:"chamaId"`
+	Type       string    `json:"type"`
+	Entry      Entry     `json:"entry"`
+	RecordedAt time.Time `json:"recordedAt"`
+	Imported   bool      `json:"imported"` // added from an entry posted before event sourcing was on
+}
+
+// Reset clears what a projection derived for a chama, so that a rebuild can
+// replay the chama's events through it from scratch
+type Reset func(ctx context.Context, tx *sql.Tx, chamaID string) error
+
+// Resets run at the start of every rebuild
+var Resets []Reset
+
+// Rebuilt summarises a rebuild
+type Rebuilt struct {
+	ChamaID  string `json:"chamaId"`
+	Imported int    `json:"imported"` // entries added to the stream first
+	Replayed int    `json:"replayed"`
+	Archived int    `json:"archived"` // events skipped as their entries are archived
+}
+
+// appendEvent adds the posting of e to the chama's event stream and returns
+// when it was recorded
+func appendEvent(ctx context.Context, tx *sql.Tx, e Entry) (time.Time, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ledger_events (chama_id, event_type, entry_id, payload, recorded_at) VALUES (?, ?, ?, ?, ?)`,
+		e.ChamaID, EventPosted, e.ID, string(payload), now.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return now, fmt.Errorf("failed to append ledger event: %w", err)
+	}
+	return now, nil
+}
+
+// Events returns the chama's events after seq, oldest first, up to limit
+func Events(ctx context.Context, db *sql.DB, chamaID string, after int64, limit int) ([]Event, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT seq, chama_id, event_type, payload, recorded_at, imported FROM ledger_events
+		WHERE chama_id = ? AND seq > ? ORDER BY seq LIMIT ?`, chamaID, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Event{}
+	for rows.Next() {
+		ev, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ev)
+	}
+	return list, rows.Err()
+}
+
+func scanEvent(row interface{ Scan(...interface{}) error }) (Event, error) {
+	var ev Event
+	var payload string
+	if err := row.Scan(&ev.Seq, &ev.ChamaID, &ev.Type, &payload, &ev.RecordedAt, &ev.Imported); err != nil {
+		return ev, err
+	}
+	return ev, json.Unmarshal([]byte(payload), &ev.Entry)
+}
+
+// Rebuild derives the chama's ledger entries, account balances and
+// projections again from its event stream. Entries posted before event
+// sourcing was turned on are first imported into the stream, so nothing is
+// lost. Entries that have been archived are left to their carry-forward
+// entries. The events themselves are never changed.
+func Rebuild(ctx context.Context, db *sql.DB, chamaID string) (Rebuilt, error) {
+	r := Rebuilt{ChamaID: chamaID}
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return r, err
+	}
+	defer txn.Rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO ledger_events (chama_id, event_type, entry_id, payload, recorded_at, imported)
+		SELECT chama_id, ?, id, json_object(
+			'id', id, 'chamaId', chama_id, 'accountId', account_id, 'memberId', COALESCE(member_id, ''),
+			'entryType', entry_type, 'amount', json_object('amountMinor', amount_minor, 'currency', currency),
+			'reference', COALESCE(reference, ''), 'description', COALESCE(description, ''),
+			'effectiveAt', strftime('%Y-%m-%dT%H:%M:%SZ', effective_at), 'createdBy', COALESCE(created_by, '')),
+			created_at, TRUE
+		FROM `+History+`
+		WHERE chama_id = ? AND id NOT IN (SELECT entry_id FROM ledger_events WHERE chama_id = ?)
+		ORDER BY `+Order,
+		EventPosted, chamaID, chamaID)
+	if err != nil {
+		return r, fmt.Errorf("failed to import entries into the event stream: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	r.Imported = int(n)
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ledger_entries WHERE chama_id = ? AND archive_id IS NULL`, chamaID); err != nil {
+		return r, err
+	}
+	for _, reset := range Resets {
+		if err := reset(ctx, tx, chamaID); err != nil {
+			return r, fmt.Errorf("failed to reset projections: %w", err)
+		}
+	}
+
+	// The carry-forward entries stay in place and stand in for the archived
+	// ones, so the projections see them before the events that follow
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+Columns+` FROM ledger_entries WHERE chama_id = ? AND archive_id IS NOT NULL ORDER BY seq`, chamaID)
+	if err != nil {
+		return r, err
+	}
+	var carried []Entry
+	for rows.Next() {
+		e, err := Scan(rows)
+		if err != nil {
+			rows.Close()
+			return r, err
+		}
+		carried = append(carried, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return r, err
+	}
+	for _, e := range carried {
+		if err := project(ctx, tx, e); err != nil {
+			return r, err
+		}
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT seq, chama_id, event_type, payload, recorded_at, imported FROM ledger_events
+		WHERE chama_id = ? ORDER BY seq`, chamaID)
+	if err != nil {
+		return r, err
+	}
+	var events []Event
+	for rows.Next() {
+		ev, err := scanEvent(rows)
+		if err != nil {
+			rows.Close()
+			return r, err
+		}
+		events = append(events, ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return r, err
+	}
+
+	for _, ev := range events {
+		var archived bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM ledger_entries_archive WHERE id = ?)`, ev.Entry.ID).Scan(&archived)
+		if err != nil {
+			return r, err
+		}
+		if archived {
+			r.Archived++
+			continue
+		}
+		if err := insertEntry(ctx, tx, ev.Entry, ev.RecordedAt); err != nil {
+			return r, err
+		}
+		if err := project(ctx, tx, ev.Entry); err != nil {
+			return r, err
+		}
+		r.Replayed++
+	}
+	// Replaying unchanged events reproduces the same hash chain
+	if err := Seal(ctx, tx, chamaID); err != nil {
+		return r, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE chama_accounts SET balance_minor = (
+			SELECT COALESCE(SUM(amount_minor), 0) FROM ledger_entries WHERE account_id = chama_accounts.id
+		), updated_at = CURRENT_TIMESTAMP
+		WHERE chama_id = ?`, chamaID)
+	if err != nil {
+		return r, fmt.Errorf("failed to rebuild account balances: %w", err)
+	}
+	if err := RefreshBalances(ctx, tx, chamaID); err != nil {
+		return r, err
+	}
+	return r, txn.Commit(tx)
+}
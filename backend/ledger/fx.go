@@ -0,0 +1,60 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+)
+
+// FX records how an entry paid in another currency was converted into the
+// account's currency when it was posted
+type FX struct {
+	Original money.Money `json:"original"` // what the member actually paid
+	Rate     int64       `json:"rate"`     // money.RateScale units of the account's currency per unit paid
+	RateDate string      `json:"rateDate"` // YYYY-MM-DD of the rate used
+	Source   string      `json:"source"`   // the rate provider
+}
+
+// insertFX records e's conversion, if it has one. Rows are keyed by entry
+// rather than tied to ledger_entries so they survive archiving and rebuilds.
+func insertFX(ctx context.Context, tx *sql.Tx, e Entry) error {
+	if e.FX == nil {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO ledger_entry_fx (entry_id, original_minor, original_currency, rate, rate_date, source)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		e.ID, e.FX.Original.Amount, e.FX.Original.Currency, e.FX.Rate, e.FX.RateDate, e.FX.Source)
+	return err
+}
+
+// WithFX fills in the conversions of those entries that were paid in another currency
+func WithFX(ctx context.Context, db *sql.DB, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(entries))
+	args := make([]interface{}, len(entries))
+	for i, e := range entries {
+		index[e.ID], args[i] = i, e.ID
+	}
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT entry_id, original_minor, original_currency, rate, rate_date, source FROM ledger_entry_fx
+		WHERE entry_id IN (?`+strings.Repeat(", ?", len(entries)-1)+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var fx FX
+		if err := rows.Scan(&id, &fx.Original.Amount, &fx.Original.Currency, &fx.Rate, &fx.RateDate, &fx.Source); err != nil {
+			return err
+		}
+		entries[index[id]].FX = &fx
+	}
+	return rows.Err()
+}
@@ -0,0 +1,275 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// FilterFromRequest builds a Filter from the {chamaId} path variable and the
+// accountId, memberId, type, from and to (YYYY-MM-DD, inclusive) query parameters.
+// Members who are not officials only ever see their own entries.
+func FilterFromRequest(db *sql.DB, r *http.Request) (Filter, int, string) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		return Filter{}, http.StatusUnauthorized, "User not authenticated"
+	}
+
+	q := r.URL.Query()
+	f := Filter{
+		ChamaID:   mux.Vars(r)["chamaId"],
+		AccountID: q.Get("accountId"),
+		MemberID:  q.Get("memberId"),
+		Type:      q.Get("type"),
+	}
+	if v := q.Get("from"); v != "" {
+		from, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return f, http.StatusBadRequest, "from must be a date in the format YYYY-MM-DD"
+		}
+		f.From = from
+	}
+	if v := q.Get("to"); v != "" {
+		to, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return f, http.StatusBadRequest, "to must be a date in the format YYYY-MM-DD"
+		}
+		f.To = to.AddDate(0, 0, 1)
+	}
+
+	if !chamas.IsOfficial(db, f.ChamaID, userID) {
+		if !chamas.IsMember(db, f.ChamaID, userID) {
+			return f, http.StatusForbidden, "Forbidden"
+		}
+		f.MemberID = userID
+	}
+	return f, 0, ""
+}
+
+// ListHandler returns ledger entries for a chama, newest first, with limit/offset paging
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, status, msg := FilterFromRequest(db, r)
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if offset < 0 {
+			offset = 0
+		}
+
+		where, args := f.Where()
+		rows, err := txn.From(r.Context(), db).QueryContext(r.Context(), `
+			SELECT `+Columns+` FROM `+History+` WHERE `+where+`
+			ORDER BY effective_at DESC, created_at DESC, seq DESC LIMIT ? OFFSET ?`,
+			append(args, limit, offset)...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		entries := []Entry{}
+		for rows.Next() {
+			e, err := Scan(rows)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entries = append(entries, e)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"entries": entries,
+			"limit":   limit,
+			"offset":  offset,
+		})
+	}
+}
+
+// EventsHandler returns the {chamaId} chama's ledger events after ?after=
+// (a seq), oldest first, up to ?limit= (default 100), for platform staff
+func EventsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 || limit > 1000 {
+			limit = 100
+		}
+		events, err := Events(r.Context(), db, mux.Vars(r)["chamaId"], after, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	}
+}
+
+// RebuildHandler rebuilds the {chamaId} chama's ledger entries, balances and
+// projections from its event stream, for platform staff
+func RebuildHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rebuilt, err := Rebuild(r.Context(), db, mux.Vars(r)["chamaId"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rebuilt)
+	}
+}
+
+// VerifyHandler checks the {chamaId} chama's hash chain, for its officials
+func VerifyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		v, err := Verify(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// periodManagerRoles may lock and unlock a chama's months
+var periodManagerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// PeriodLocksHandler lists the {chamaId} chama's locked and unlocked months to its officials
+func PeriodLocksHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := PeriodLocks(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// LockPeriodHandler locks the {chamaId} chama's {"period"} (YYYY-MM) once it
+// has been reconciled
+func LockPeriodHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, periodManagerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			Period string `json:"period"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		start, err := time.Parse("2006-01", request.Period)
+		if v.Required("period", request.Period) && err != nil {
+			v.Add("period", validation.CodeDate, nil)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		l, err := LockPeriod(r.Context(), db, chamaID, start, audit.FromRequest(r, audit.Entry{}))
+		switch {
+		case errors.Is(err, ErrPeriodNotEnded), errors.Is(err, ErrPeriodAlreadyLocked):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(l)
+	}
+}
+
+// UnlockPeriodHandler unlocks the {chamaId} chama's locked {period} with a
+// {"reason"} so that corrections can be posted into it
+func UnlockPeriodHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		vars := mux.Vars(r)
+		if !chamas.HasRole(db, vars["chamaId"], userID, periodManagerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("reason", request.Reason)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		l, err := UnlockPeriod(r.Context(), db, vars["chamaId"], vars["period"], request.Reason, audit.FromRequest(r, audit.Entry{}))
+		switch {
+		case errors.Is(err, ErrPeriodNotLocked):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l)
+	}
+}
@@ -0,0 +1,358 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Entry types. From the chama's point of view credits (money in) are positive
+// and debits (money out) are negative.
+const (
+	TypeContribution     = "contribution"
+	TypeFine             = "fine"
+	TypeLoanDisbursement = "loan_disbursement"
+	TypeLoanRepayment    = "loan_repayment"
+	TypeInterest         = "interest"
+	TypeIncome           = "income"
+	TypeExpense          = "expense"
+	TypeTransfer         = "transfer"
+	TypeAdjustment       = "adjustment"
+	TypeOpeningBalance   = "opening_balance"
+	TypeInvestment       = "investment"
+	TypeLoanInterest     = "loan_interest"    // interest accrued onto a member's loan
+	TypeSavingsInterest  = "savings_interest" // interest paid onto a member's savings
+	TypeShareCapital     = "share_capital"    // members buying shares
+	TypeDistribution     = "distribution"     // paid out to members when a chama is dissolved
+	TypeWithdrawal       = "withdrawal"       // savings paid back to a member who leaves
+	TypeInterChama       = "inter_chama"      // moved to or from another chama under the same umbrella
+)
+
+// Types lists every entry type
+var Types = []string{TypeContribution, TypeFine, TypeLoanDisbursement, TypeLoanRepayment, TypeInterest, TypeIncome,
+	TypeExpense, TypeTransfer, TypeAdjustment, TypeOpeningBalance, TypeInvestment, TypeLoanInterest, TypeSavingsInterest,
+	TypeShareCapital, TypeDistribution, TypeWithdrawal, TypeInterChama}
+
+// Entry is one money movement on a chama account
+type Entry struct {
+	ID          string      `json:"id"`
+	ChamaID     string      `json:"chamaId"`
+	AccountID   string      `json:"accountId"`
+	MemberID    string      `json:"memberId,omitempty"`
+	Type        string      `json:"entryType"`
+	Amount      money.Money `json:"amount"`
+	Reference   string      `json:"reference,omitempty"`
+	Description string      `json:"description,omitempty"`
+	EffectiveAt time.Time   `json:"effectiveAt"`
+	CreatedBy   string      `json:"createdBy,omitempty"`
+	FX          *FX         `json:"fx,omitempty"` // set when the money came in another currency
+}
+
+var (
+	// ErrCurrencyMismatch is returned when an entry's currency differs from its account
+	ErrCurrencyMismatch = errors.New("entry currency does not match account currency")
+	// ErrAccountClosed is returned when posting to a closed account
+	ErrAccountClosed = errors.New("account is closed")
+	// ErrDebitNotAllowed is returned when an account's rules do not allow the entry type to take money out
+	ErrDebitNotAllowed = errors.New("entry type may not be paid out of this account")
+	// ErrInsufficientFunds is returned when a debit would take an account below its minimum balance
+	ErrInsufficientFunds = errors.New("insufficient funds in account")
+	// ErrPeriodClosed is returned when an entry's effective date falls in a closed financial year
+	ErrPeriodClosed = errors.New("entry falls in a closed financial year")
+)
+
+// Projection keeps a derived table, such as a dashboard summary, in step with
+// the ledger. It runs inside the posting transaction and an error aborts the posting.
+type Projection func(ctx context.Context, tx *sql.Tx, e Entry) error
+
+// Projections are applied to every posted entry
+var Projections []Projection
+
+// OnPost hooks are called for every posted entry, for example to invalidate
+// cached balances. They run once the posting transaction has been committed
+// with txn.Commit, and not at all if it is rolled back, so code that begins
+// a transaction to post in must end it with txn.Commit and txn.Rollback.
+var OnPost []func(ctx context.Context, e Entry)
+
+// Post records e inside tx and updates the account's running balance. Debits
+// are checked against the account's rules: the entry type must be one of its
+// allowed debits and the balance may not fall below its minimum. Nothing may
+// be posted with an effective date in a closed financial year or a locked month.
+func Post(ctx context.Context, tx *sql.Tx, e Entry) (Entry, error) {
+	var accountCurrency, status, allowedDebits string
+	var balance int64
+	var minBalance sql.NullInt64
+	err := tx.QueryRowContext(ctx, `
+		SELECT currency, status, COALESCE(allowed_debits, ''), balance_minor, min_balance_minor
+		FROM chama_accounts WHERE id = ? AND chama_id = ?`,
+		e.AccountID, e.ChamaID,
+	).Scan(&accountCurrency, &status, &allowedDebits, &balance, &minBalance)
+	if err != nil {
+		return e, fmt.Errorf("failed to load account %s: %w", e.AccountID, err)
+	}
+	if accountCurrency != e.Amount.Currency {
+		return e, fmt.Errorf("%w: %s vs %s", ErrCurrencyMismatch, e.Amount.Currency, accountCurrency)
+	}
+	if status == "closed" {
+		return e, ErrAccountClosed
+	}
+	if e.Amount.IsNegative() {
+		if allowedDebits != "" && !contains(strings.Split(allowedDebits, ","), e.Type) {
+			return e, fmt.Errorf("%w: %s", ErrDebitNotAllowed, e.Type)
+		}
+		if minBalance.Valid && balance+e.Amount.Amount < minBalance.Int64 {
+			return e, ErrInsufficientFunds
+		}
+	}
+
+	return write(ctx, tx, e)
+}
+
+// write records e once the account's rules have been checked: it fills in
+// the ID and effective date, then appends, seals and projects the entry and
+// moves the account balance and its month's balance
+func write(ctx context.Context, tx *sql.Tx, e Entry) (Entry, error) {
+	if e.ID == "" {
+		e.ID = uuid.NewString()
+	}
+	if e.EffectiveAt.IsZero() {
+		e.EffectiveAt = time.Now().UTC()
+	}
+	if err := checkOpen(ctx, tx, e.ChamaID, e.EffectiveAt); err != nil {
+		return e, err
+	}
+
+	var err error
+	createdAt := time.Now().UTC()
+	if EventSourcing {
+		if createdAt, err = appendEvent(ctx, tx, e); err != nil {
+			return e, err
+		}
+	}
+	if err := insertEntry(ctx, tx, e, createdAt); err != nil {
+		return e, err
+	}
+	if err := Seal(ctx, tx, e.ChamaID); err != nil {
+		return e, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE chama_accounts SET balance_minor = balance_minor + ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, e.Amount.Amount, e.AccountID)
+	if err != nil {
+		return e, fmt.Errorf("failed to update account balance: %w", err)
+	}
+	if err := addBalance(ctx, tx, e); err != nil {
+		return e, err
+	}
+	if err := project(ctx, tx, e); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// checkOpen returns ErrPeriodClosed when t falls in one of the chama's
+// closed financial years, and ErrPeriodLocked when it falls in a locked
+// month. A year whose reopening is awaiting approval is still closed.
+func checkOpen(ctx context.Context, tx *sql.Tx, chamaID string, t time.Time) error {
+	var closed, locked bool
+	at := t.UTC().Format("2006-01-02 15:04:05")
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM financial_years
+			WHERE chama_id = ? AND status != 'reopened' AND start_date <= ? AND end_date > ?
+		), EXISTS (
+			SELECT 1 FROM period_locks WHERE chama_id = ? AND period = ? AND status = ?
+		)`, chamaID, at, at, chamaID, PeriodOf(t), PeriodLocked).Scan(&closed, &locked)
+	if err != nil {
+		return err
+	}
+	if closed {
+		return ErrPeriodClosed
+	}
+	if locked {
+		return ErrPeriodLocked
+	}
+	return nil
+}
+
+// insertEntry writes e to ledger_entries, next in the chama's posting sequence
+func insertEntry(ctx context.Context, tx *sql.Tx, e Entry, createdAt time.Time) error {
+	seq, err := NextSeq(ctx, tx, e.ChamaID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ledger_entries
+		(id, chama_id, account_id, member_id, entry_type, amount_minor, currency, reference, description, effective_at,
+		 created_by, created_at, seq)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.ChamaID, e.AccountID, nullIfEmpty(e.MemberID), e.Type, e.Amount.Amount, e.Amount.Currency,
+		nullIfEmpty(e.Reference), nullIfEmpty(e.Description), e.EffectiveAt.UTC().Format("2006-01-02 15:04:05"), nullIfEmpty(e.CreatedBy),
+		createdAt.UTC().Format("2006-01-02 15:04:05"), seq,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to post ledger entry: %w", err)
+	}
+	if err := insertFX(ctx, tx, e); err != nil {
+		return fmt.Errorf("failed to record exchange rate: %w", err)
+	}
+	return nil
+}
+
+// project applies the registered projections and hooks to e
+func project(ctx context.Context, tx *sql.Tx, e Entry) error {
+	for _, p := range Projections {
+		if err := p(ctx, tx, e); err != nil {
+			return fmt.Errorf("failed to update projections: %w", err)
+		}
+	}
+	for _, hook := range OnPost {
+		hook := hook
+		txn.AfterCommit(tx, func() { hook(ctx, e) })
+	}
+	return nil
+}
+
+// PostOne posts a single entry in its own transaction
+func PostOne(ctx context.Context, db *sql.DB, e Entry) (Entry, error) {
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return e, err
+	}
+	defer txn.Rollback(tx)
+
+	e, err = Post(ctx, tx, e)
+	if err != nil {
+		return e, err
+	}
+	return e, txn.Commit(tx)
+}
+
+// Filter narrows a List query. Zero values are ignored.
+type Filter struct {
+	ChamaID    string
+	AccountID  string
+	MemberID   string
+	Type       string
+	Types      []string // any of these types
+	WithMember bool     // only entries for a member
+	From       time.Time
+	To         time.Time
+}
+
+// Where builds the SQL condition and arguments for f
+func (f Filter) Where() (string, []interface{}) {
+	where := "chama_id = ?"
+	args := []interface{}{f.ChamaID}
+	if f.AccountID != "" {
+		where += " AND account_id = ?"
+		args = append(args, f.AccountID)
+	}
+	if f.MemberID != "" {
+		where += " AND member_id = ?"
+		args = append(args, f.MemberID)
+	}
+	if f.WithMember {
+		where += " AND member_id IS NOT NULL"
+	}
+	if f.Type != "" {
+		where += " AND entry_type = ?"
+		args = append(args, f.Type)
+	}
+	if len(f.Types) > 0 {
+		where += " AND entry_type IN (?" + strings.Repeat(", ?", len(f.Types)-1) + ")"
+		for _, t := range f.Types {
+			args = append(args, t)
+		}
+	}
+	if !f.From.IsZero() {
+		where += " AND effective_at >= ?"
+		args = append(args, f.From.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !f.To.IsZero() {
+		where += " AND effective_at < ?"
+		args = append(args, f.To.UTC().Format("2006-01-02 15:04:05"))
+	}
+	return where, args
+}
+
+// Columns lists the ledger_entries columns read by Scan, in order
+const Columns = `id, chama_id, account_id, COALESCE(member_id, ''), entry_type, amount_minor, currency,
+	COALESCE(reference, ''), COALESCE(description, ''), effective_at, COALESCE(created_by, '')`
+
+// Scan reads one entry selected with Columns
+func Scan(row interface{ Scan(...interface{}) error }) (Entry, error) {
+	var e Entry
+	err := row.Scan(&e.ID, &e.ChamaID, &e.AccountID, &e.MemberID, &e.Type, &e.Amount.Amount, &e.Amount.Currency,
+		&e.Reference, &e.Description, &e.EffectiveAt, &e.CreatedBy)
+	return e, err
+}
+
+// Order sorts entries by effective date, then in the order they were posted,
+// so running balances come out the same every time
+const Order = `effective_at, created_at, seq`
+
+// History is the view of every entry, live or archived, without the
+// carry-forward entries that stand in for archived ones. Queries over a
+// period read it rather than ledger_entries, which no longer holds entries
+// from archived years; all-time totals may read either.
+const History = `ledger_history`
+
+// List returns entries matching f in Order
+func List(ctx context.Context, db *sql.DB, f Filter) ([]Entry, error) {
+	where, args := f.Where()
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT `+Columns+` FROM `+History+` WHERE `+where+` ORDER BY `+Order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		e, err := Scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Sum returns the total of entries matching f in the given currency
+func Sum(ctx context.Context, db *sql.DB, f Filter, currency string) (money.Money, error) {
+	totals, err := sums(ctx, db, f, "")
+	return money.New(totals[""], currency), err
+}
+
+// MemberSavings returns a member's savings balance (contributions and opening
+// balances) as of before, or in total when before is zero
+func MemberSavings(ctx context.Context, db *sql.DB, chamaID, memberID string, before time.Time, currency string) (money.Money, error) {
+	return Sum(ctx, db, Filter{
+		ChamaID: chamaID, MemberID: memberID, Types: []string{TypeContribution, TypeOpeningBalance}, To: before,
+	}, currency)
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
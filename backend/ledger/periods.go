@@ -0,0 +1,167 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Period lock statuses
+const (
+	PeriodLocked   = "locked"
+	PeriodUnlocked = "unlocked"
+)
+
+var (
+	// ErrPeriodLocked is returned when an entry's effective date falls in a locked month
+	ErrPeriodLocked = errors.New("entry falls in a locked accounting period")
+	// ErrPeriodNotEnded is returned when locking a month that is not over yet
+	ErrPeriodNotEnded = errors.New("accounting period has not ended yet")
+	// ErrPeriodAlreadyLocked is returned when locking a month that is already locked
+	ErrPeriodAlreadyLocked = errors.New("accounting period is already locked")
+	// ErrPeriodNotLocked is returned when unlocking a month that is not locked
+	ErrPeriodNotLocked = errors.New("accounting period is not locked")
+)
+
+// PeriodLock records that one of a chama's months has been reconciled and
+// closed. Nothing may be posted with an effective date inside a locked month
+// until an official unlocks it, giving a reason.
+type PeriodLock struct {
+	ID           string     `json:"id"`
+	ChamaID      string     `json:"chamaId"`
+	Period       string     `json:"period"` // YYYY-MM
+	Status       string     `json:"status"`
+	LockedBy     string     `json:"lockedBy"`
+	LockedAt     time.Time  `json:"lockedAt"`
+	UnlockedBy   string     `json:"unlockedBy,omitempty"`
+	UnlockedAt   *time.Time `json:"unlockedAt,omitempty"`
+	UnlockReason string     `json:"unlockReason,omitempty"`
+}
+
+// PeriodOf returns the YYYY-MM period t falls in
+func PeriodOf(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+const periodLockColumns = `id, chama_id, period, status, locked_by, locked_at, COALESCE(unlocked_by, ''), unlocked_at,
+	COALESCE(unlock_reason, '')`
+
+func scanPeriodLock(row interface{ Scan(...interface{}) error }) (PeriodLock, error) {
+	var l PeriodLock
+	err := row.Scan(&l.ID, &l.ChamaID, &l.Period, &l.Status, &l.LockedBy, &l.LockedAt, &l.UnlockedBy, &l.UnlockedAt,
+		&l.UnlockReason)
+	return l, err
+}
+
+// PeriodLocks returns the chama's locked and unlocked months, latest first
+func PeriodLocks(ctx context.Context, db *sql.DB, chamaID string) ([]PeriodLock, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT `+periodLockColumns+` FROM period_locks WHERE chama_id = ? ORDER BY period DESC`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []PeriodLock{}
+	for rows.Next() {
+		l, err := scanPeriodLock(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	return list, rows.Err()
+}
+
+func getPeriodLock(ctx context.Context, tx *sql.Tx, chamaID, period string) (PeriodLock, error) {
+	return scanPeriodLock(tx.QueryRowContext(ctx, `
+		SELECT `+periodLockColumns+` FROM period_locks WHERE chama_id = ? AND period = ?`, chamaID, period))
+}
+
+// LockPeriod locks the chama's month containing t once it is over, or locks
+// it again after it was unlocked
+func LockPeriod(ctx context.Context, db *sql.DB, chamaID string, t time.Time, entry audit.Entry) (PeriodLock, error) {
+	period := PeriodOf(t)
+	start, _ := time.Parse("2006-01", period)
+	if !time.Now().UTC().After(start.AddDate(0, 1, 0)) {
+		return PeriodLock{}, ErrPeriodNotEnded
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return PeriodLock{}, err
+	}
+	defer txn.Rollback(tx)
+
+	l, err := getPeriodLock(ctx, tx, chamaID, period)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO period_locks (id, chama_id, period, status, locked_by) VALUES (?, ?, ?, ?, ?)`,
+			uuid.NewString(), chamaID, period, PeriodLocked, entry.UserID)
+	case err != nil:
+		return l, err
+	case l.Status == PeriodLocked:
+		return l, ErrPeriodAlreadyLocked
+	default:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE period_locks SET status = ?, locked_by = ?, locked_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			PeriodLocked, entry.UserID, l.ID)
+	}
+	if err != nil {
+		return l, fmt.Errorf("failed to lock period %s: %w", period, err)
+	}
+	if l, err = getPeriodLock(ctx, tx, chamaID, period); err != nil {
+		return l, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "period.lock", "period_lock", l.ID
+	entry.NewValues = map[string]interface{}{"chama_id": chamaID, "period": period}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return l, err
+	}
+	return l, txn.Commit(tx)
+}
+
+// UnlockPeriod unlocks the chama's locked YYYY-MM period so that corrections
+// can be posted into it, recording who unlocked it and why
+func UnlockPeriod(ctx context.Context, db *sql.DB, chamaID, period, reason string, entry audit.Entry) (PeriodLock, error) {
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return PeriodLock{}, err
+	}
+	defer txn.Rollback(tx)
+
+	l, err := getPeriodLock(ctx, tx, chamaID, period)
+	if err == sql.ErrNoRows {
+		return l, ErrPeriodNotLocked
+	}
+	if err != nil {
+		return l, err
+	}
+	if l.Status != PeriodLocked {
+		return l, ErrPeriodNotLocked
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE period_locks SET status = ?, unlocked_by = ?, unlocked_at = CURRENT_TIMESTAMP, unlock_reason = ?
+		WHERE id = ?`, PeriodUnlocked, entry.UserID, reason, l.ID)
+	if err != nil {
+		return l, fmt.Errorf("failed to unlock period %s: %w", period, err)
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "period.unlock", "period_lock", l.ID
+	entry.OldValues = map[string]interface{}{"status": PeriodLocked}
+	entry.NewValues = map[string]interface{}{"status": PeriodUnlocked, "reason": reason}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return l, err
+	}
+	if l, err = getPeriodLock(ctx, tx, chamaID, period); err != nil {
+		return l, err
+	}
+	return l, txn.Commit(tx)
+}
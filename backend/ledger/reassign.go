@@ -0,0 +1,71 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/money"
+)
+
+// ReassignMember moves everything the ledger holds for fromMember in the
+// chama onto toMember, as when two records for the same person are merged.
+// Entries cannot be edited without breaking the hash chain, so for each
+// account and entry type with a balance under fromMember it posts a pair of
+// entries of that type: one taking the balance off fromMember and one
+// putting it on toMember. Each pair nets to nothing on the account, so the
+// account's debit rules do not apply. It returns the entries posted.
+func ReassignMember(ctx context.Context, tx *sql.Tx, chamaID, fromMember, toMember, reference, createdBy string) ([]Entry, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT account_id, entry_type, currency, SUM(amount_minor) FROM `+History+`
+		WHERE chama_id = ? AND member_id = ?
+		GROUP BY account_id, entry_type, currency HAVING SUM(amount_minor) != 0
+		ORDER BY account_id, entry_type`, chamaID, fromMember)
+	if err != nil {
+		return nil, err
+	}
+	type balance struct {
+		accountID, entryType string
+		amount               money.Money
+	}
+	var balances []balance
+	for rows.Next() {
+		var b balance
+		if err := rows.Scan(&b.accountID, &b.entryType, &b.amount.Currency, &b.amount.Amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var posted []Entry
+	for _, b := range balances {
+		for _, leg := range []struct {
+			member string
+			amount money.Money
+		}{{fromMember, b.amount.Negate()}, {toMember, b.amount}} {
+			e, err := write(ctx, tx, Entry{
+				ChamaID:     chamaID,
+				AccountID:   b.accountID,
+				MemberID:    leg.member,
+				Type:        b.entryType,
+				Amount:      leg.amount,
+				Reference:   reference,
+				Description: fmt.Sprintf("Member records merged: %s into %s", fromMember, toMember),
+				EffectiveAt: now,
+				CreatedBy:   createdBy,
+			})
+			if err != nil {
+				return posted, err
+			}
+			posted = append(posted, e)
+		}
+	}
+	return posted, nil
+}
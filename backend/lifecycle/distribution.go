@@ -0,0 +1,334 @@
+package lifecycle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/loans"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/rules"
+	"tujifund-app/backend/txn"
+)
+
+// Ways of sharing a surplus between members on dissolution
+const (
+	BySavings = "savings" // in proportion to each member's savings and share capital
+	Equally   = "equal"
+	ByShares  = "shares" // in proportion to shares held
+)
+
+var (
+	// ErrLoansOutstanding is returned when distributing while members still owe loans
+	ErrLoansOutstanding = errors.New("all loans must be repaid or written off before funds are distributed")
+	// ErrPlanChanged is returned when fund balances move while a distribution is being made
+	ErrPlanChanged = errors.New("fund balances changed, review the distribution again")
+)
+
+// Payout is what one member receives when the chama is dissolved: their
+// savings and share capital back, plus their share of any surplus
+type Payout struct {
+	MemberID string      `json:"memberId"`
+	Name     string      `json:"name"`
+	Savings  money.Money `json:"savings"`
+	Shares   money.Money `json:"shareCapital"`
+	Surplus  money.Money `json:"surplus"` // negative when the chama has a deficit
+	Amount   money.Money `json:"amount"`
+}
+
+// Plan is how a chama's funds are shared out between its members. Pool is
+// everything in the chama's funds; Surplus is what is left once members'
+// savings and share capital are returned.
+type Plan struct {
+	ChamaID string      `json:"chamaId"`
+	Method  string      `json:"method"`
+	Pool    money.Money `json:"pool"`
+	Surplus money.Money `json:"surplus"`
+	Payouts []Payout    `json:"payouts"`
+}
+
+// BuildPlan works out how the chama's funds would be distributed today. A
+// surplus is shared by the chama's dissolution rule. A deficit is always
+// shared in proportion to what each member put in, so no member ends up
+// owing the chama.
+func BuildPlan(ctx context.Context, db *sql.DB, chamaID string) (Plan, error) {
+	r, err := rules.Current(ctx, db, chamaID)
+	if err != nil {
+		return Plan{}, err
+	}
+	currency, err := money.ChamaCurrency(db, chamaID)
+	if err != nil {
+		return Plan{}, err
+	}
+	p := Plan{ChamaID: chamaID, Method: r.DissolutionDistribution, Pool: money.New(0, currency), Payouts: []Payout{}}
+	if err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(balance_minor), 0) FROM chama_accounts WHERE chama_id = ? AND status = 'active'`, chamaID,
+	).Scan(&p.Pool.Amount); err != nil {
+		return p, err
+	}
+
+	// Every active member, and anyone who has left with money still in the chama
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.member_id, TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')),
+		       COALESCE(SUM(CASE WHEN l.entry_type IN (?, ?, ?, ?, ?) THEN l.amount_minor END), 0),
+		       COALESCE(SUM(CASE WHEN l.entry_type = ? THEN l.amount_minor END), 0),
+		       COALESCE((SELECT shares_count FROM member_shares s WHERE s.chama_id = ? AND s.member_id = m.member_id), 0),
+		       m.active
+		FROM (
+			SELECT member_id, MAX(active) AS active FROM (
+				SELECT user_id AS member_id, 1 AS active FROM chama_members WHERE chama_id = ? AND status = 'active'
+				UNION ALL
+				SELECT DISTINCT member_id, 0 FROM ledger_entries WHERE chama_id = ? AND member_id IS NOT NULL
+			) GROUP BY member_id
+		) m
+		LEFT JOIN users u ON u.user_id = m.member_id
+		LEFT JOIN ledger_entries l ON l.chama_id = ? AND l.member_id = m.member_id
+		GROUP BY m.member_id
+		ORDER BY 2`,
+		ledger.TypeContribution, ledger.TypeOpeningBalance, ledger.TypeSavingsInterest, ledger.TypeDistribution,
+		ledger.TypeWithdrawal, ledger.TypeShareCapital, chamaID, chamaID, chamaID, chamaID)
+	if err != nil {
+		return p, err
+	}
+	var contributed, shareCounts, members []int64
+	var put int64
+	for rows.Next() {
+		var pay Payout
+		var count, active int64
+		pay.Savings, pay.Shares = money.New(0, currency), money.New(0, currency)
+		if err := rows.Scan(&pay.MemberID, &pay.Name, &pay.Savings.Amount, &pay.Shares.Amount, &count, &active); err != nil {
+			rows.Close()
+			return p, err
+		}
+		p.Payouts = append(p.Payouts, pay)
+		base := pay.Savings.Amount + pay.Shares.Amount
+		if base < 0 {
+			base = 0
+		}
+		contributed = append(contributed, base)
+		shareCounts = append(shareCounts, count)
+		members = append(members, active)
+		put += base
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return p, err
+	}
+	return p, p.share(put, contributed, shareCounts, members)
+}
+
+// share splits the surplus over p's payouts
+func (p *Plan) share(put int64, contributed, shareCounts, members []int64) error {
+	p.Surplus = money.New(p.Pool.Amount-put, p.Pool.Currency)
+	if len(p.Payouts) == 0 {
+		return nil
+	}
+	ratios := contributed
+	switch {
+	case p.Surplus.IsNegative():
+	case p.Method == Equally:
+		ratios = members
+	case p.Method == ByShares && sum(shareCounts) > 0:
+		ratios = shareCounts
+	}
+	if sum(ratios) == 0 {
+		ratios = members
+	}
+	if sum(ratios) == 0 {
+		return errors.New("chama has no members to distribute to")
+	}
+	parts, err := p.Surplus.Allocate(ratios...)
+	if err != nil {
+		return err
+	}
+	for i := range p.Payouts {
+		pay := &p.Payouts[i]
+		pay.Surplus = parts[i]
+		pay.Amount = money.New(pay.Savings.Amount+pay.Shares.Amount+pay.Surplus.Amount, p.Pool.Currency)
+	}
+	return nil
+}
+
+func sum(values []int64) int64 {
+	var total int64
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// Distribute pays the chama's funds out to its members by the current plan
+// and dissolves it. Every fund is swept into one and its rules lifted, each
+// member's savings and share capital are paid back with their share of the
+// surplus, the funds are closed and the chama is archived.
+func Distribute(ctx context.Context, db *sql.DB, chamaID string, entry audit.Entry) (Plan, error) {
+	status, err := Status(ctx, db, chamaID)
+	if err != nil {
+		return Plan{}, err
+	}
+	if status != StatusDissolving {
+		return Plan{}, ErrStatus
+	}
+	d, err := CurrentDissolution(ctx, db, chamaID)
+	if err != nil {
+		return Plan{}, err
+	}
+	running, err := loans.Running(ctx, db, chamaID)
+	if err != nil {
+		return Plan{}, err
+	}
+	if len(running) > 0 {
+		return Plan{}, ErrLoansOutstanding
+	}
+	p, err := BuildPlan(ctx, db, chamaID)
+	if err != nil {
+		return p, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return p, err
+	}
+	defer txn.Rollback(tx)
+
+	type fund struct {
+		id      string
+		balance int64
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, balance_minor FROM chama_accounts WHERE chama_id = ? AND status = 'active'
+		ORDER BY balance_minor DESC, created_at`, chamaID)
+	if err != nil {
+		return p, err
+	}
+	var funds []fund
+	var pool int64
+	for rows.Next() {
+		var f fund
+		if err := rows.Scan(&f.id, &f.balance); err != nil {
+			rows.Close()
+			return p, err
+		}
+		funds = append(funds, f)
+		pool += f.balance
+	}
+	rows.Close()
+	if pool != p.Pool.Amount {
+		return p, ErrPlanChanged
+	}
+	if len(funds) == 0 {
+		return p, errors.New("chama has no open funds")
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE chama_accounts SET allowed_debits = NULL, min_balance_minor = NULL
+		WHERE chama_id = ? AND status = 'active'`, chamaID); err != nil {
+		return p, err
+	}
+
+	now := time.Now().UTC()
+	post := func(legs ...ledger.Entry) error {
+		// Credits first, so the fund never dips below zero part way through
+		sort.SliceStable(legs, func(i, j int) bool { return legs[i].Amount.Amount > legs[j].Amount.Amount })
+		for _, e := range legs {
+			if e.Amount.IsZero() {
+				continue
+			}
+			e.ChamaID, e.Reference, e.EffectiveAt, e.CreatedBy = chamaID, d.ID, now, entry.UserID
+			if e.AccountID == "" {
+				e.AccountID = funds[0].id
+			}
+			if _, err := ledger.Post(ctx, tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, f := range funds[1:] {
+		amount := money.New(f.balance, p.Pool.Currency)
+		err := post(
+			ledger.Entry{AccountID: f.id, Type: ledger.TypeTransfer, Amount: amount.Negate(), Description: "Swept for dissolution"},
+			ledger.Entry{Type: ledger.TypeTransfer, Amount: amount, Description: "Swept for dissolution"},
+		)
+		if err != nil {
+			return p, err
+		}
+	}
+	for _, pay := range p.Payouts {
+		err := post(
+			ledger.Entry{MemberID: pay.MemberID, Type: ledger.TypeDistribution, Amount: pay.Surplus,
+				Description: "Share of surplus on dissolution"},
+			ledger.Entry{Type: ledger.TypeAdjustment, Amount: pay.Surplus.Negate(), Description: "Surplus shared on dissolution"},
+		)
+		if err != nil {
+			return p, err
+		}
+		err = post(
+			ledger.Entry{MemberID: pay.MemberID, Type: ledger.TypeDistribution,
+				Amount:      money.New(-(pay.Savings.Amount + pay.Surplus.Amount), p.Pool.Currency),
+				Description: "Final distribution"},
+			ledger.Entry{MemberID: pay.MemberID, Type: ledger.TypeShareCapital, Amount: pay.Shares.Negate(),
+				Description: "Share capital returned on dissolution"},
+		)
+		if err != nil {
+			return p, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO dissolution_payouts
+			(dissolution_id, member_id, savings_minor, shares_minor, surplus_minor, amount_minor, currency)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			d.ID, pay.MemberID, pay.Savings.Amount, pay.Shares.Amount, pay.Surplus.Amount, pay.Amount.Amount, pay.Amount.Currency)
+		if err != nil {
+			return p, fmt.Errorf("failed to record payout: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE chama_accounts SET status = 'closed', updated_at = CURRENT_TIMESTAMP WHERE chama_id = ? AND status = 'active'`,
+		chamaID); err != nil {
+		return p, err
+	}
+	if err := setStatus(ctx, tx, chamaID, StatusDissolving, StatusDissolved); err != nil {
+		return p, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE chama_dissolutions SET status = ?, method = ?, distributed_by = ?, distributed_at = ? WHERE id = ?`,
+		DissolutionCompleted, p.Method, entry.UserID, now.Format("2006-01-02 15:04:05"), d.ID); err != nil {
+		return p, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "chama.dissolved", "chama", chamaID
+	entry.NewValues = p
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return p, err
+	}
+	return p, txn.Commit(tx)
+}
+
+// Payouts returns what each member was paid when the chama was dissolved
+func Payouts(ctx context.Context, db *sql.DB, dissolutionID string) ([]Payout, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT p.member_id, TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')),
+		       p.savings_minor, p.shares_minor, p.surplus_minor, p.amount_minor, p.currency
+		FROM dissolution_payouts p LEFT JOIN users u ON u.user_id = p.member_id
+		WHERE p.dissolution_id = ? ORDER BY 2`, dissolutionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Payout{}
+	for rows.Next() {
+		var pay Payout
+		if err := rows.Scan(&pay.MemberID, &pay.Name, &pay.Savings.Amount, &pay.Shares.Amount, &pay.Surplus.Amount,
+			&pay.Amount.Amount, &pay.Amount.Currency); err != nil {
+			return nil, err
+		}
+		pay.Savings.Currency, pay.Shares.Currency, pay.Surplus.Currency = pay.Amount.Currency, pay.Amount.Currency, pay.Amount.Currency
+		list = append(list, pay)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,337 @@
+package lifecycle
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/reports"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// GetHandler returns the {chamaId} chama's lifecycle status and its latest
+// dissolution, if any
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var response struct {
+			Status      string       `json:"status"`
+			ArchivedAt  string       `json:"archivedAt,omitempty"`
+			Dissolution *Dissolution `json:"dissolution,omitempty"`
+		}
+		err := db.QueryRowContext(r.Context(), `SELECT status, COALESCE(archived_at, '') FROM chamas WHERE id = ?`, chamaID).
+			Scan(&response.Status, &response.ArchivedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d, err := CurrentDissolution(r.Context(), db, chamaID)
+		if err != nil && err != ErrNoDissolution {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			response.Dissolution = &d
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// PauseHandler makes the {chamaId} chama dormant. Admins and the chairperson only.
+func PauseHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, ok := authorizeLeader(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		err := Pause(r.Context(), db, chamaID, request.Reason, audit.FromRequest(r, audit.Entry{}))
+		writeStatus(w, r, db, chamaID, err)
+	}
+}
+
+// ResumeHandler makes the dormant {chamaId} chama active again. Admins and the chairperson only.
+func ResumeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, ok := authorizeLeader(db, w, r)
+		if !ok {
+			return
+		}
+		err := Resume(r.Context(), db, chamaID, audit.FromRequest(r, audit.Entry{}))
+		writeStatus(w, r, db, chamaID, err)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, r *http.Request, db *sql.DB, chamaID string, err error) {
+	switch {
+	case errors.Is(err, ErrStatus):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status, _ := Status(r.Context(), db, chamaID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// ProposeDissolutionHandler puts dissolving the {chamaId} chama to a vote of
+// its members. Admins and the chairperson only.
+func ProposeDissolutionHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, ok := authorizeLeader(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Reason string `json:"reason"`
+			Vote   struct {
+				ClosesAt   string `json:"closesAt"`
+				BallotType string `json:"ballotType"`
+			} `json:"vote"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("reason", request.Reason)
+		closesAt, err := time.Parse(time.RFC3339, request.Vote.ClosesAt)
+		if err != nil || closesAt.Before(time.Now()) {
+			v.Add("vote.closesAt", validation.CodeDate, nil)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		d, err := ProposeDissolution(r.Context(), db, chamaID, request.Reason, request.Vote.BallotType, closesAt,
+			audit.FromRequest(r, audit.Entry{}))
+		switch {
+		case errors.Is(err, ErrStatus), errors.Is(err, ErrDissolutionPending):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyMembers(r.Context(), db, notifier, chamaID, d.ID, "dissolution.proposed", "notification.dissolution_proposed")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// DistributionHandler previews how the {chamaId} chama's funds would be
+// distributed if it were dissolved today. Officials only.
+func DistributionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		p, err := BuildPlan(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// DistributeHandler pays out the funds of the dissolving {chamaId} chama and
+// archives it. Admins, the chairperson and the treasurer only.
+func DistributeHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		p, err := Distribute(r.Context(), db, chamaID, audit.FromRequest(r, audit.Entry{}))
+		switch {
+		case errors.Is(err, ErrStatus), errors.Is(err, ErrLoansOutstanding), errors.Is(err, ErrPlanChanged):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyMembers(r.Context(), db, notifier, chamaID, chamaID, "dissolution.distributed", "notification.dissolution_distributed")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// FinalStatement is a member's statement over their whole time in a dissolved
+// chama, with what they were paid on dissolution
+type FinalStatement struct {
+	*reports.MemberStatement
+	Payout *Payout `json:"payout,omitempty"`
+}
+
+// StatementHandler returns {memberId}'s final statement for the dissolved
+// {chamaId} chama as JSON, or PDF with ?format=pdf. Members can fetch their
+// own statement; officials can fetch any member's.
+func StatementHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		vars := mux.Vars(r)
+		chamaID, memberID := vars["chamaId"], vars["memberId"]
+		if memberID != userID && !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		d, err := CurrentDissolution(r.Context(), db, chamaID)
+		if err == nil && d.Status != DissolutionCompleted {
+			err = ErrNoDissolution
+		}
+		if err == ErrNoDissolution {
+			http.Error(w, "Chama has not been dissolved", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s, err := reports.BuildMemberStatement(r.Context(), db, chamaID, memberID, reports.Period{To: time.Now().UTC()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		payouts, err := Payouts(r.Context(), db, d.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		statement := FinalStatement{MemberStatement: s}
+		for i := range payouts {
+			if payouts[i].MemberID == memberID {
+				statement.Payout = &payouts[i]
+			}
+		}
+
+		if r.URL.Query().Get("format") == "pdf" {
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="final-statement.pdf"`)
+			reports.WriteStatementPDF(w, s, i18n.FromRequest(r))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statement)
+	}
+}
+
+// ReadOnly rejects changes to dissolved chamas. Reads still go through so
+// members can fetch their records from the archive.
+func ReadOnly(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				if chamaID := mux.Vars(r)["chamaId"]; chamaID != "" {
+					if status, err := Status(r.Context(), db, chamaID); err == nil && status == StatusDissolved {
+						http.Error(w, "Chama has been dissolved and is read-only", http.StatusConflict)
+						return
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authorizeLeader returns the {chamaId} chama for an admin or its
+// chairperson, writing an error response and returning false otherwise
+func authorizeLeader(db *sql.DB, w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return "", false
+	}
+	chamaID := mux.Vars(r)["chamaId"]
+	if !chamas.HasRole(db, chamaID, userID, chamas.RoleAdmin, chamas.RoleChairperson) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return chamaID, true
+}
+
+// notifyMembers tells every active member of the chama about its dissolution
+func notifyMembers(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, chamaID, relatedID, title, message string) {
+	if notifier == nil {
+		return
+	}
+	rows, err := db.QueryContext(ctx, `SELECT user_id FROM chama_members WHERE chama_id = ? AND status = 'active'`, chamaID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load members for dissolution notice", "chama_id", chamaID, "error", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	for _, id := range ids {
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, title, nil),
+			Message:   i18n.T(i18n.Default, message, nil),
+			Type:      notifications.TypeChama,
+			RelatedID: relatedID,
+		})
+	}
+}
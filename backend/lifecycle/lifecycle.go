@@ -0,0 +1,220 @@
+package lifecycle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/votes"
+
+	"github.com/google/uuid"
+)
+
+// Chama statuses. Dormant chamas keep their records but are skipped by the
+// interest and arrears jobs; dissolving chamas have voted to dissolve and are
+// waiting for their funds to be distributed; dissolved chamas are archived
+// and read-only.
+const (
+	StatusActive     = "active"
+	StatusDormant    = "dormant"
+	StatusDissolving = "dissolving"
+	StatusDissolved  = "dissolved"
+)
+
+// Dissolution statuses
+const (
+	DissolutionProposed  = "proposed"
+	DissolutionApproved  = "approved"
+	DissolutionRejected  = "rejected"
+	DissolutionCompleted = "completed"
+)
+
+var (
+	// ErrStatus is returned when a chama is not in a state that allows the change
+	ErrStatus = errors.New("chama is not in a state that allows this")
+	// ErrDissolutionPending is returned when proposing a dissolution while one is already being decided
+	ErrDissolutionPending = errors.New("a dissolution has already been proposed")
+	// ErrNoDissolution is returned when a chama has no dissolution on record
+	ErrNoDissolution = errors.New("chama has no dissolution")
+)
+
+// Status returns a chama's lifecycle status
+func Status(ctx context.Context, db *sql.DB, chamaID string) (string, error) {
+	var status string
+	err := db.QueryRowContext(ctx, `SELECT status FROM chamas WHERE id = ?`, chamaID).Scan(&status)
+	return status, err
+}
+
+// Pause makes an active chama dormant
+func Pause(ctx context.Context, db *sql.DB, chamaID, reason string, entry audit.Entry) error {
+	return transition(ctx, db, chamaID, StatusActive, StatusDormant, reason, entry)
+}
+
+// Resume makes a dormant chama active again
+func Resume(ctx context.Context, db *sql.DB, chamaID string, entry audit.Entry) error {
+	return transition(ctx, db, chamaID, StatusDormant, StatusActive, "", entry)
+}
+
+func transition(ctx context.Context, db *sql.DB, chamaID, from, to, reason string, entry audit.Entry) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setStatus(ctx, tx, chamaID, from, to); err != nil {
+		return err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "chama."+to, "chama", chamaID
+	entry.OldValues = map[string]string{"status": from}
+	entry.NewValues = map[string]string{"status": to, "reason": reason}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setStatus(ctx context.Context, tx *sql.Tx, chamaID, from, to string) error {
+	query := `UPDATE chamas SET status = ?, updated_at = CURRENT_TIMESTAMP`
+	if to == StatusDissolved {
+		query += `, archived_at = CURRENT_TIMESTAMP`
+	}
+	res, err := tx.ExecContext(ctx, query+` WHERE id = ? AND status = ?`, to, chamaID, from)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStatus
+	}
+	return nil
+}
+
+// Dissolution is a proposal, decided by a vote of the members, to wind a chama up
+type Dissolution struct {
+	ID            string    `json:"id"`
+	ChamaID       string    `json:"chamaId"`
+	Reason        string    `json:"reason"`
+	Status        string    `json:"status"`
+	VoteID        string    `json:"voteId,omitempty"`
+	Method        string    `json:"method,omitempty"`
+	ProposedBy    string    `json:"proposedBy"`
+	DistributedBy string    `json:"distributedBy,omitempty"`
+	DistributedAt string    `json:"distributedAt,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+const dissolutionColumns = `id, chama_id, reason, status, COALESCE(vote_id, ''), COALESCE(method, ''), proposed_by,
+	COALESCE(distributed_by, ''), COALESCE(distributed_at, ''), created_at`
+
+func scanDissolution(row interface{ Scan(...interface{}) error }) (Dissolution, error) {
+	var d Dissolution
+	err := row.Scan(&d.ID, &d.ChamaID, &d.Reason, &d.Status, &d.VoteID, &d.Method, &d.ProposedBy,
+		&d.DistributedBy, &d.DistributedAt, &d.CreatedAt)
+	return d, err
+}
+
+// CurrentDissolution returns the chama's latest dissolution proposal, or ErrNoDissolution
+func CurrentDissolution(ctx context.Context, db *sql.DB, chamaID string) (Dissolution, error) {
+	d, err := scanDissolution(db.QueryRowContext(ctx, `
+		SELECT `+dissolutionColumns+` FROM chama_dissolutions WHERE chama_id = ?
+		ORDER BY created_at DESC, rowid DESC LIMIT 1`, chamaID))
+	if err == sql.ErrNoRows {
+		return d, ErrNoDissolution
+	}
+	return d, err
+}
+
+// ProposeDissolution puts dissolving the chama to a vote of its members,
+// closing at closesAt. The chama starts dissolving if the vote passes.
+func ProposeDissolution(ctx context.Context, db *sql.DB, chamaID, reason, ballotType string, closesAt time.Time, entry audit.Entry) (Dissolution, error) {
+	status, err := Status(ctx, db, chamaID)
+	if err != nil {
+		return Dissolution{}, err
+	}
+	if status != StatusActive && status != StatusDormant {
+		return Dissolution{}, ErrStatus
+	}
+	current, err := CurrentDissolution(ctx, db, chamaID)
+	if err != nil && err != ErrNoDissolution {
+		return Dissolution{}, err
+	}
+	if err == nil && current.Status == DissolutionProposed {
+		return current, ErrDissolutionPending
+	}
+
+	d := Dissolution{ID: uuid.NewString(), ChamaID: chamaID, Reason: reason, Status: DissolutionProposed, ProposedBy: entry.UserID}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO chama_dissolutions (id, chama_id, reason, status, proposed_by) VALUES (?, ?, ?, ?, ?)`,
+		d.ID, d.ChamaID, d.Reason, d.Status, d.ProposedBy)
+	if err != nil {
+		return d, fmt.Errorf("failed to propose dissolution: %w", err)
+	}
+	vote, err := votes.Open(ctx, db, votes.Vote{
+		ChamaID:     chamaID,
+		SubjectType: votes.SubjectDissolution,
+		SubjectID:   d.ID,
+		Title:       "Dissolve the chama",
+		Description: reason,
+		BallotType:  ballotType,
+		ClosesAt:    closesAt,
+		CreatedBy:   entry.UserID,
+	}, entry)
+	if err != nil {
+		db.ExecContext(ctx, `DELETE FROM chama_dissolutions WHERE id = ?`, d.ID)
+		return d, err
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE chama_dissolutions SET vote_id = ? WHERE id = ?`, vote.ID, d.ID); err != nil {
+		return d, err
+	}
+	return CurrentDissolution(ctx, db, chamaID)
+}
+
+// RegisterVoteHook makes dissolution votes approve or reject the proposal
+// they were opened for. An approved dissolution starts the chama dissolving.
+func RegisterVoteHook() {
+	votes.OutcomeHooks[votes.SubjectDissolution] = func(ctx context.Context, db *sql.DB, v votes.Vote) error {
+		status := DissolutionRejected
+		if v.Status == votes.StatusPassed {
+			status = DissolutionApproved
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE chama_dissolutions SET status = ? WHERE id = ? AND status = ?`,
+			status, v.SubjectID, DissolutionProposed)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		if status == DissolutionApproved {
+			var from string
+			if err := tx.QueryRowContext(ctx, `SELECT status FROM chamas WHERE id = ?`, v.ChamaID).Scan(&from); err != nil {
+				return err
+			}
+			if from != StatusActive && from != StatusDormant {
+				return ErrStatus
+			}
+			if err := setStatus(ctx, tx, v.ChamaID, from, StatusDissolving); err != nil {
+				return err
+			}
+		}
+		err = audit.Record(ctx, tx, audit.Entry{
+			Action: "dissolution." + status, EntityType: "chama_dissolution", EntityID: v.SubjectID,
+			NewValues: map[string]string{"voteId": v.ID, "outcome": v.Status},
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+}
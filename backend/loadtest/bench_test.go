@@ -0,0 +1,229 @@
+package loadtest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/dashboard"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/reports"
+	"tujifund-app/backend/storage"
+)
+
+// The benchmarks run the app's hot paths against a database filled by
+// cmd/loadgen, and are skipped when there is none:
+//
+//	go test ./loadtest -run '^$' -bench . -loadtest.db ../data/loadtest.db > sqlite.txt
+//	go test ./loadtest -run '^$' -bench . -loadtest.driver postgres \
+//		-loadtest.dsn "host=localhost dbname=tujifund_load sslmode=disable" > postgres.txt
+//	benchstat -col /driver sqlite.txt postgres.txt
+//
+// The contribution benchmarks add contributions, so use a database made for
+// the purpose.
+var (
+	driverFlag = flag.String("loadtest.driver", "sqlite", "sqlite or postgres")
+	pathFlag   = flag.String("loadtest.db", "../data/loadtest.db", "SQLite database filled by cmd/loadgen")
+	dsnFlag    = flag.String("loadtest.dsn", "", "PostgreSQL database filled by cmd/loadgen")
+)
+
+// sampleSize is how many memberships the benchmarks spread their work over,
+// so they measure a database that does not fit in the cache
+const sampleSize = 1000
+
+// member is a chama membership benchmarks pick their subjects from
+type member struct {
+	chamaID  string
+	memberID string
+}
+
+// fixture is what the benchmarks run against, opened by the first one
+type fixture struct {
+	db      *sql.DB
+	store   storage.Backend
+	members []member
+	dir     string
+	next    atomic.Uint64
+}
+
+var (
+	openOnce  sync.Once
+	shared    *fixture
+	sharedErr error
+	errNoData = errors.New("no load-test database; run cmd/loadgen and pass -loadtest.db or -loadtest.dsn")
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	code := m.Run()
+	if shared != nil {
+		shared.db.Close()
+		os.RemoveAll(shared.dir)
+	}
+	os.Exit(code)
+}
+
+// load returns the shared fixture, skipping the benchmark when there is no
+// database to run against
+func load(b *testing.B) *fixture {
+	openOnce.Do(func() { shared, sharedErr = open(context.Background()) })
+	if sharedErr == errNoData {
+		b.Skip(sharedErr)
+	}
+	if sharedErr != nil {
+		b.Fatal(sharedErr)
+	}
+	return shared
+}
+
+// open samples memberships from the load-test database. Receipts are
+// written to a temporary directory, removed after the benchmarks.
+func open(ctx context.Context) (*fixture, error) {
+	dsn := *dsnFlag
+	switch *driverFlag {
+	case "sqlite":
+		if _, err := os.Stat(*pathFlag); err != nil {
+			return nil, errNoData
+		}
+		dsn = "file:" + *pathFlag + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	case "postgres":
+		if dsn == "" {
+			return nil, errNoData
+		}
+	}
+	db, err := Open(*driverFlag, dsn)
+	if err != nil {
+		return nil, err
+	}
+	f := &fixture{db: db}
+	// IDs are random UUIDs, so the first ones in order are a fair sample
+	rows, err := db.QueryContext(ctx, `
+		SELECT chama_id, user_id FROM chama_members WHERE status = 'active' ORDER BY id LIMIT ?`, sampleSize)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m member
+		if err := rows.Scan(&m.chamaID, &m.memberID); err != nil {
+			db.Close()
+			return nil, err
+		}
+		f.members = append(f.members, m)
+	}
+	if err := rows.Err(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if len(f.members) == 0 {
+		db.Close()
+		return nil, errNoData
+	}
+
+	if f.dir, err = os.MkdirTemp("", "loadbench-receipts-"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if f.store, err = storage.NewLocal(f.dir, "", nil); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// member returns the next sampled membership, round robin, so parallel
+// benchmarks spread over different chamas
+func (f *fixture) member() member {
+	return f.members[int(f.next.Add(1)-1)%len(f.members)]
+}
+
+// bench runs fn b.N times, labelled with the driver so runs against each
+// database can be compared with benchstat
+func bench(b *testing.B, fn func(ctx context.Context, f *fixture) error) {
+	f := load(b)
+	b.Run("driver="+*driverFlag, func(b *testing.B) {
+		b.ReportAllocs()
+		ctx := context.Background()
+		for i := 0; i < b.N; i++ {
+			if err := fn(ctx, f); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// benchParallel runs fn from GOMAXPROCS goroutines at once, showing how the
+// database copes with concurrent requests
+func benchParallel(b *testing.B, fn func(ctx context.Context, f *fixture) error) {
+	f := load(b)
+	b.Run("driver="+*driverFlag, func(b *testing.B) {
+		b.ReportAllocs()
+		ctx := context.Background()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := fn(ctx, f); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkRecordContribution(b *testing.B)         { bench(b, recordContribution) }
+func BenchmarkRecordContributionParallel(b *testing.B) { benchParallel(b, recordContribution) }
+func BenchmarkMemberStatement(b *testing.B)            { bench(b, memberStatement) }
+func BenchmarkChamaReport(b *testing.B)                { bench(b, chamaReport) }
+func BenchmarkChamaDashboard(b *testing.B)             { bench(b, chamaDashboard) }
+func BenchmarkChamaDashboardParallel(b *testing.B)     { benchParallel(b, chamaDashboard) }
+func BenchmarkMemberDashboard(b *testing.B)            { bench(b, memberDashboard) }
+
+// recordContribution posts a contribution paid straight to the paybill:
+// the insert, ledger posting, hash chain, summaries and receipt
+func recordContribution(ctx context.Context, f *fixture) error {
+	m := f.member()
+	_, err := contributions.Record(ctx, f.db, f.store, nil, contributions.Contribution{
+		ChamaID:   m.chamaID,
+		MemberID:  m.memberID,
+		Amount:    money.New(int64(500+rand.Intn(4500))*100, "KES"),
+		Date:      time.Now().UTC(),
+		Method:    "mpesa",
+		Reference: fmt.Sprintf("LB%08d", rand.Intn(100000000)),
+	}, m.memberID)
+	return err
+}
+
+// memberStatement builds a member's statement for the last year
+func memberStatement(ctx context.Context, f *fixture) error {
+	m := f.member()
+	now := time.Now().UTC()
+	_, err := reports.BuildMemberStatement(ctx, f.db, m.chamaID, m.memberID, reports.Period{From: now.AddDate(-1, 0, 0), To: now})
+	return err
+}
+
+// chamaReport builds the chama's report for the current month
+func chamaReport(ctx context.Context, f *fixture) error {
+	_, err := reports.BuildChamaReport(ctx, f.db, f.member().chamaID, reports.Month(time.Now().UTC()))
+	return err
+}
+
+func chamaDashboard(ctx context.Context, f *fixture) error {
+	_, err := dashboard.ChamaDashboard(ctx, f.db, f.member().chamaID)
+	return err
+}
+
+func memberDashboard(ctx context.Context, f *fixture) error {
+	m := f.member()
+	_, err := dashboard.MemberDashboard(ctx, f.db, m.chamaID, m.memberID)
+	return err
+}
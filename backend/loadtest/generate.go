@@ -0,0 +1,219 @@
+// Package loadtest fills a database with production-sized synthetic data and
+// benchmarks the app's hot paths against it, on SQLite or PostgreSQL, so the
+// volume at which SQLite stops keeping up can be measured rather than
+// guessed. cmd/loadgen fills a database, and the benchmarks in this
+// package's tests run against it.
+package loadtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/dashboard"
+	"tujifund-app/backend/ledger"
+
+	"github.com/google/uuid"
+)
+
+// Config sizes the generated data
+type Config struct {
+	Chamas          int
+	MembersPerChama int
+	Contributions   int // in total, spread evenly over the chamas
+	Months          int // contributions are dated over this many months back
+	Seed            int64
+}
+
+// DefaultConfig is the volume the SQLite to PostgreSQL comparison is made at
+var DefaultConfig = Config{
+	Chamas:          10000,
+	MembersPerChama: 10,
+	Contributions:   1000000,
+	Months:          12,
+	Seed:            1,
+}
+
+// Summary counts what Generate created
+type Summary struct {
+	Chamas        int `json:"chamas"`
+	Members       int `json:"members"`
+	Contributions int `json:"contributions"`
+}
+
+// batchRows is how many rows go in one INSERT, well under both databases'
+// limits on parameters per statement
+const batchRows = 500
+
+// Generate adds conf's volume of chamas, members and completed contributions
+// to db, with the ledger entries, balances and dashboard summaries the app
+// would have written for them. Each chama is written in a transaction of its
+// own, and progress is called after each one.
+func Generate(ctx context.Context, db *sql.DB, conf Config, progress func(Summary)) (Summary, error) {
+	var sum Summary
+	if conf.Chamas <= 0 || conf.MembersPerChama <= 0 {
+		return sum, fmt.Errorf("at least one chama with one member is needed")
+	}
+	if conf.Months <= 0 {
+		conf.Months = 1
+	}
+	rng := rand.New(rand.NewSource(conf.Seed))
+	run := uuid.NewString()[:8] // keeps usernames unique across runs on one database
+	now := time.Now().UTC()
+
+	for i := 0; i < conf.Chamas; i++ {
+		// The remainder goes to the first chamas
+		n := conf.Contributions / conf.Chamas
+		if i < conf.Contributions%conf.Chamas {
+			n++
+		}
+		chamaID, err := generateChama(ctx, db, rng, fmt.Sprintf("%s-%d", run, i), conf, n, now)
+		if err != nil {
+			return sum, err
+		}
+		if err := dashboard.Rebuild(ctx, db, chamaID); err != nil {
+			return sum, fmt.Errorf("failed to summarise chama %s: %w", chamaID, err)
+		}
+		sum.Chamas++
+		sum.Members += conf.MembersPerChama
+		sum.Contributions += n
+		if progress != nil {
+			progress(sum)
+		}
+	}
+	return sum, nil
+}
+
+// generateChama writes one chama with its fund, members and n contributions
+func generateChama(ctx context.Context, db *sql.DB, rng *rand.Rand, name string, conf Config, n int, now time.Time) (string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	chamaID, accountID := uuid.NewString(), uuid.NewString()
+	members := make([]string, conf.MembersPerChama)
+	users := make([][]interface{}, len(members))
+	memberships := make([][]interface{}, len(members))
+	started := now.AddDate(0, -conf.Months, 0)
+	for j := range members {
+		members[j] = uuid.NewString()
+		username := fmt.Sprintf("load-%s-%d", name, j)
+		users[j] = []interface{}{members[j], username, username + "@loadtest.invalid", "Load", fmt.Sprintf("Member %d", j)}
+		role := "member"
+		if j == 0 {
+			role = "admin"
+		}
+		memberships[j] = []interface{}{uuid.NewString(), chamaID, members[j], role, started.Format("2006-01-02 15:04:05")}
+	}
+	if err := insertRows(ctx, tx, "users", "user_id, username, email, first_name, last_name", users); err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO chamas (id, name, type, currency, created_by) VALUES (?, ?, 'savings', 'KES', ?)`,
+		chamaID, "Load test chama "+name, members[0])
+	if err != nil {
+		return "", fmt.Errorf("failed to create chama: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO chama_accounts (id, chama_id, name, account_type, currency) VALUES (?, ?, 'Savings', 'savings', 'KES')`,
+		accountID, chamaID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create fund: %w", err)
+	}
+	if err := insertRows(ctx, tx, "chama_members", "id, chama_id, user_id, role, join_date", memberships); err != nil {
+		return "", err
+	}
+
+	contributions := make([][]interface{}, 0, min(n, batchRows))
+	entries := make([][]interface{}, 0, min(n, batchRows))
+	var balance int64
+	flush := func() error {
+		if err := insertRows(ctx, tx, "contributions",
+			"id, chama_id, member_id, account_id, amount_minor, currency, contribution_date, payment_method, transaction_reference, status",
+			contributions); err != nil {
+			return err
+		}
+		if err := insertRows(ctx, tx, "ledger_entries",
+			"id, chama_id, account_id, member_id, entry_type, amount_minor, currency, reference, description, effective_at, created_at, seq",
+			entries); err != nil {
+			return err
+		}
+		contributions, entries = contributions[:0], entries[:0]
+		return nil
+	}
+	// Contributions are dated in order, as they would have been posted
+	span := now.Sub(started)
+	for k := 0; k < n; k++ {
+		id, member := uuid.NewString(), members[rng.Intn(len(members))]
+		at := started.Add(time.Duration(int64(span) / int64(n) * int64(k))).Format("2006-01-02 15:04:05")
+		amount := int64(500+rng.Intn(4500)) * 100
+		reference := fmt.Sprintf("LT%08d", rng.Intn(100000000))
+		contributions = append(contributions, []interface{}{id, chamaID, member, accountID, amount, "KES", at, "mpesa", reference, "completed"})
+		entries = append(entries, []interface{}{uuid.NewString(), chamaID, accountID, member, ledger.TypeContribution, amount, "KES",
+			id, "Contribution via mpesa " + reference, at, at, k + 1})
+		balance += amount
+		if len(contributions) == batchRows {
+			if err := flush(); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE chama_accounts SET balance_minor = ? WHERE id = ?`, balance, accountID); err != nil {
+		return "", err
+	}
+	// Entries are numbered in posting order above; later postings carry on
+	// from the last number
+	if _, err := tx.ExecContext(ctx, `INSERT INTO ledger_sequences (chama_id, last_seq) VALUES (?, ?)`, chamaID, n); err != nil {
+		return "", err
+	}
+	if err := ledger.RefreshBalances(ctx, tx, chamaID); err != nil {
+		return "", err
+	}
+	if err := ledger.Seal(ctx, tx, chamaID); err != nil {
+		return "", err
+	}
+	return chamaID, tx.Commit()
+}
+
+// insertRows inserts rows into table in statements of up to batchRows rows
+func insertRows(ctx context.Context, tx *sql.Tx, table, columns string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(rows[0])), ", ") + ")"
+	for start := 0; start < len(rows); start += batchRows {
+		batch := rows[start:min(start+batchRows, len(rows))]
+		values := make([]string, len(batch))
+		args := make([]interface{}, 0, len(batch)*len(batch[0]))
+		for i, row := range batch {
+			values[i] = placeholders
+			args = append(args, row...)
+		}
+		query := "INSERT INTO " + table + " (" + columns + ") VALUES " + strings.Join(values, ", ")
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to insert into %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// InitializeSchema creates the tables from the schema file at path, as the
+// database drivers do, ignoring tables that already exist
+func InitializeSchema(ctx context.Context, db *sql.DB, path string) error {
+	schema, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read schema file: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, string(schema)); err != nil && !strings.Contains(err.Error(), "already exists") {
+		return fmt.Errorf("failed to execute schema: %w", err)
+	}
+	return nil
+}
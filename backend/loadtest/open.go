@@ -0,0 +1,22 @@
+package loadtest
+
+import (
+	"database/sql"
+	"fmt"
+
+	"tujifund-app/backend/database/tenantdriver"
+
+	_ "modernc.org/sqlite"
+)
+
+// Open connects to a load-test database. PostgreSQL is reached through the
+// driver the app runs on, which rewrites the app's ? placeholders.
+func Open(dialect, dsn string) (*sql.DB, error) {
+	switch dialect {
+	case "sqlite":
+		return sql.Open("sqlite", dsn)
+	case "postgres":
+		return sql.Open(tenantdriver.DriverName, dsn)
+	}
+	return nil, fmt.Errorf("unknown driver %q; use sqlite or postgres", dialect)
+}
@@ -0,0 +1,151 @@
+package loans
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/validation"
+
+	"github.com/google/uuid"
+)
+
+// Application is a member's request to borrow under one of the chama's loan
+// products, with the members they have asked to guarantee it and anything
+// they have pledged as collateral
+type Application struct {
+	ID         string       `json:"id"`
+	ChamaID    string       `json:"chamaId"`
+	UserID     string       `json:"userId"`
+	ProductID  string       `json:"productId"`
+	Amount     money.Money  `json:"amount"`
+	Term       int          `json:"term"` // in days
+	Purpose    string       `json:"purpose,omitempty"`
+	Guarantors []string     `json:"guarantors"`
+	Collateral []Collateral `json:"collateral"`
+	// CollateralValue totals the officials' valuations of what is still pledged
+	CollateralValue money.Money `json:"collateralValue"`
+	Status          string      `json:"status"`
+	AppliedAt       time.Time   `json:"appliedAt"`
+}
+
+// Apply records a pending application once it fits the product's terms: the
+// amount within its range and the member's savings-based limit, the term
+// within its tenure limits and enough guarantors from among the chama's other
+// members. Terms it breaks are returned as validation.Errors.
+func Apply(ctx context.Context, db *sql.DB, a Application) (Application, error) {
+	p, err := GetProduct(ctx, txn.From(ctx, db), a.ChamaID, a.ProductID)
+	if err != nil {
+		return a, err
+	}
+	if !p.Active {
+		return a, ErrProductInactive
+	}
+	if a.Amount.Currency != p.MaxAmount.Currency {
+		return a, ledger.ErrCurrencyMismatch
+	}
+	savings, err := ledger.MemberSavings(ctx, db, a.ChamaID, a.UserID, time.Time{}, a.Amount.Currency)
+	if err != nil {
+		return a, err
+	}
+
+	v := validation.New()
+	if limit := p.Limit(savings); a.Amount.Amount < p.MinAmount.Amount || a.Amount.Amount > limit.Amount {
+		v.Add("amount", validation.CodeRange, map[string]string{"min": p.MinAmount.String(), "max": limit.String()})
+	}
+	if a.Term < p.MinTerm || a.Term > p.MaxTerm {
+		v.Add("term", validation.CodeRange, map[string]string{"min": strconv.Itoa(p.MinTerm), "max": strconv.Itoa(p.MaxTerm)})
+	}
+	seen := map[string]bool{}
+	for _, id := range a.Guarantors {
+		switch {
+		case seen[id]:
+			v.Add("guarantors", validation.CodeDuplicate, nil)
+		case id == a.UserID || !chamas.IsMemberContext(ctx, db, a.ChamaID, id):
+			v.Add("guarantors", validation.CodeNotFound, nil)
+		}
+		seen[id] = true
+	}
+	if len(seen) < p.RequiredGuarantors {
+		v.Add("guarantors", validation.CodeMinCount, map[string]string{"min": strconv.Itoa(p.RequiredGuarantors)})
+	}
+	if !v.Valid() {
+		return a, v.Errors()
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return a, err
+	}
+	defer txn.Rollback(tx)
+
+	a.ID, a.Status = uuid.NewString(), StatusPending
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loan_applications (id, chama_id, user_id, loan_product_id, amount_minor, currency, term, purpose, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.ChamaID, a.UserID, p.ID, a.Amount.Amount, a.Amount.Currency, a.Term, nullIfEmpty(a.Purpose), a.Status)
+	if err != nil {
+		return a, fmt.Errorf("failed to record loan application: %w", err)
+	}
+	for id := range seen {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO loan_application_guarantors (application_id, guarantor_id) VALUES (?, ?)`, a.ID, id); err != nil {
+			return a, err
+		}
+	}
+	if err := txn.Commit(tx); err != nil {
+		return a, err
+	}
+	return GetApplication(ctx, txn.From(ctx, db), a.ID)
+}
+
+// GetApplication returns a single loan application with its guarantors and
+// collateral, as officials review it for approval
+func GetApplication(ctx context.Context, q Querier, id string) (Application, error) {
+	var a Application
+	err := q.QueryRowContext(ctx, `
+		SELECT id, COALESCE(chama_id, ''), user_id, loan_product_id, amount_minor, currency, term, COALESCE(purpose, ''),
+		       status, application_date
+		FROM loan_applications WHERE id = ?`, id,
+	).Scan(&a.ID, &a.ChamaID, &a.UserID, &a.ProductID, &a.Amount.Amount, &a.Amount.Currency, &a.Term, &a.Purpose,
+		&a.Status, &a.AppliedAt)
+	if err != nil {
+		return a, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT guarantor_id FROM loan_application_guarantors WHERE application_id = ? ORDER BY guarantor_id`, id)
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+	a.Guarantors = []string{}
+	for rows.Next() {
+		var guarantor string
+		if err := rows.Scan(&guarantor); err != nil {
+			return a, err
+		}
+		a.Guarantors = append(a.Guarantors, guarantor)
+	}
+	if err := rows.Err(); err != nil {
+		return a, err
+	}
+	rows.Close()
+
+	if a.Collateral, err = ListCollateral(ctx, q, id); err != nil {
+		return a, err
+	}
+	a.CollateralValue = money.New(0, a.Amount.Currency)
+	for _, c := range a.Collateral {
+		if c.Status == CollateralPledged && c.Value.Currency == a.Amount.Currency {
+			a.CollateralValue.Amount += c.Value.Amount
+		}
+	}
+	return a, nil
+}
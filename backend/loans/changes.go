@@ -0,0 +1,350 @@
+package loans
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/calendar"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of loan change
+const (
+	KindRestructure = "restructure"
+	KindTopUp       = "top_up"
+)
+
+// Change statuses
+const (
+	ChangePending  = "pending"
+	ChangeApproved = "approved"
+	ChangeRejected = "rejected"
+)
+
+var (
+	// ErrDecided is returned when approving or rejecting a change that is no longer pending
+	ErrDecided = errors.New("loan change has already been decided")
+	// ErrSelfApproval is returned when the requester tries to approve their own change
+	ErrSelfApproval = errors.New("loan changes must be approved by someone other than the requester")
+	// ErrChangePending is returned when a loan already has a change awaiting approval
+	ErrChangePending = errors.New("loan already has a change awaiting approval")
+)
+
+// Change is a request to restructure a loan onto a new term and rate, or to
+// top it up by replacing it with a new loan for the principal still owed
+// plus TopUp. Either takes effect only once another official approves it.
+type Change struct {
+	ID              string      `json:"id"`
+	ChamaID         string      `json:"chamaId"`
+	LoanID          string      `json:"loanId"`
+	Kind            string      `json:"kind"`
+	Term            int         `json:"term"` // in days from approval
+	InterestRateBps int64       `json:"interestRateBps"`
+	InterestType    string      `json:"interestType"`
+	TopUp           money.Money `json:"topUp"`
+	AccountID       string      `json:"accountId,omitempty"` // fund a top-up is paid from
+	Reason          string      `json:"reason"`
+	Status          string      `json:"status"`
+	RequestedBy     string      `json:"requestedBy"`
+	DecidedBy       string      `json:"decidedBy,omitempty"`
+	DecidedAt       string      `json:"decidedAt,omitempty"`
+	RejectionReason string      `json:"rejectionReason,omitempty"`
+	NewLoanID       string      `json:"newLoanId,omitempty"`
+	CreatedAt       time.Time   `json:"createdAt"`
+}
+
+// RequestChange records a pending change to a running loan
+func RequestChange(ctx context.Context, db *sql.DB, c Change) (Change, error) {
+	l, err := Get(ctx, txn.From(ctx, db), c.LoanID)
+	if err != nil {
+		return c, err
+	}
+	if !l.Running() {
+		return c, ErrNotRunning
+	}
+	if c.Term <= 0 {
+		return c, errors.New("term must be positive")
+	}
+	if c.InterestRateBps < 0 {
+		return c, errors.New("interest rate cannot be negative")
+	}
+	if c.Kind == KindTopUp && c.TopUp.Amount <= 0 {
+		return c, errors.New("top-up amount must be positive")
+	}
+	if c.Kind == KindTopUp {
+		var accountChama string
+		err := txn.From(ctx, db).QueryRowContext(ctx, `SELECT chama_id FROM chama_accounts WHERE id = ?`, c.AccountID).Scan(&accountChama)
+		if err != nil || accountChama != l.ChamaID {
+			return c, fmt.Errorf("fund %s not found", c.AccountID)
+		}
+	} else {
+		c.TopUp, c.AccountID = money.New(0, l.Principal.Currency), ""
+	}
+	var pending bool
+	err = txn.From(ctx, db).QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM loan_changes WHERE loan_id = ? AND status = ?)`,
+		l.ID, ChangePending).Scan(&pending)
+	if err != nil {
+		return c, err
+	}
+	if pending {
+		return c, ErrChangePending
+	}
+
+	c.ID, c.ChamaID = uuid.NewString(), l.ChamaID
+	_, err = txn.From(ctx, db).ExecContext(ctx, `
+		INSERT INTO loan_changes
+		(id, chama_id, loan_id, kind, term, interest_rate_bps, interest_type, top_up_minor, currency, account_id, reason, requested_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.ChamaID, c.LoanID, c.Kind, c.Term, c.InterestRateBps, c.InterestType, c.TopUp.Amount, l.Principal.Currency,
+		nullIfEmpty(c.AccountID), c.Reason, c.RequestedBy,
+	)
+	if err != nil {
+		return c, fmt.Errorf("failed to request loan change: %w", err)
+	}
+	return GetChange(ctx, db, c.ID)
+}
+
+const changeColumns = `id, chama_id, loan_id, kind, term, interest_rate_bps, interest_type, top_up_minor, currency,
+	COALESCE(account_id, ''), reason, status, requested_by, COALESCE(decided_by, ''), COALESCE(decided_at, ''),
+	COALESCE(rejection_reason, ''), COALESCE(new_loan_id, ''), created_at`
+
+func scanChange(row interface{ Scan(...interface{}) error }) (Change, error) {
+	var c Change
+	err := row.Scan(&c.ID, &c.ChamaID, &c.LoanID, &c.Kind, &c.Term, &c.InterestRateBps, &c.InterestType,
+		&c.TopUp.Amount, &c.TopUp.Currency, &c.AccountID, &c.Reason, &c.Status, &c.RequestedBy, &c.DecidedBy,
+		&c.DecidedAt, &c.RejectionReason, &c.NewLoanID, &c.CreatedAt)
+	return c, err
+}
+
+// GetChange returns a single loan change
+func GetChange(ctx context.Context, db *sql.DB, id string) (Change, error) {
+	return scanChange(txn.From(ctx, db).QueryRowContext(ctx, `SELECT `+changeColumns+` FROM loan_changes WHERE id = ?`, id))
+}
+
+// ListChanges returns a chama's loan changes, newest first. status is an optional filter.
+func ListChanges(ctx context.Context, db *sql.DB, chamaID, status string) ([]Change, error) {
+	query := `SELECT ` + changeColumns + ` FROM loan_changes WHERE chama_id = ?`
+	args := []interface{}{chamaID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	rows, err := txn.From(ctx, db).QueryContext(ctx, query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Change{}
+	for rows.Next() {
+		c, err := scanChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// ApproveChange approves a pending change and applies it. A restructure moves
+// the loan onto a new schedule for the principal still owed; a top-up closes
+// the loan as refinanced and disburses a new one, paying out only the top-up.
+// Earlier schedules are kept either way.
+func ApproveChange(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Change, error) {
+	c, err := GetChange(ctx, db, id)
+	if err != nil {
+		return c, err
+	}
+	if c.Status != ChangePending {
+		return c, ErrDecided
+	}
+	if c.RequestedBy == entry.UserID {
+		return c, ErrSelfApproval
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return c, err
+	}
+	defer txn.Rollback(tx)
+
+	l, err := Get(ctx, tx, c.LoanID)
+	if err != nil {
+		return c, err
+	}
+	if !l.Running() {
+		return c, ErrNotRunning
+	}
+	now := time.Now().UTC()
+	newLoanID := ""
+	if c.Kind == KindTopUp {
+		newLoanID, err = topUp(ctx, tx, l, c, entry.UserID, now)
+	} else {
+		err = restructure(ctx, tx, l, c, now)
+	}
+	if err != nil {
+		return c, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE loan_changes SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP, new_loan_id = ?
+		WHERE id = ? AND status = ?`,
+		ChangeApproved, entry.UserID, nullIfEmpty(newLoanID), id, ChangePending)
+	if err != nil {
+		return c, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c, ErrDecided
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "loan."+c.Kind, "loan", l.ID
+	entry.OldValues = map[string]interface{}{
+		"term": l.Term, "interestRateBps": l.InterestRateBps, "interestType": l.InterestType,
+		"outstanding": l.Outstanding(), "status": l.Status,
+	}
+	entry.NewValues = map[string]interface{}{
+		"changeId": c.ID, "term": c.Term, "interestRateBps": c.InterestRateBps, "interestType": c.InterestType,
+		"topUp": c.TopUp, "newLoanId": newLoanID,
+	}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return c, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return c, err
+	}
+	return GetChange(ctx, db, id)
+}
+
+// restructure moves l onto a new term and rate from now. The loan keeps its
+// principal and repayments; the new schedule covers what is still owed.
+func restructure(ctx context.Context, tx *sql.Tx, l Loan, c Change, now time.Time) error {
+	cal, err := calendar.Load(ctx, tx, l.ChamaID)
+	if err != nil {
+		return err
+	}
+	s := Schedule{
+		StartsAt:        now,
+		Principal:       l.Outstanding(),
+		Repaid:          l.Repaid,
+		InterestRateBps: c.InterestRateBps,
+		InterestType:    c.InterestType,
+		Term:            c.Term,
+		ChangeID:        c.ID,
+		Instalments:     Build(l.Outstanding(), c.InterestRateBps, c.InterestType, c.Term, now, cal),
+	}
+	if err := replace(ctx, tx, l, s); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loans SET interest_rate_bps = ?, interest_type = ?, expected_end_date = ?, status = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		c.InterestRateBps, c.InterestType, now.AddDate(0, 0, c.Term).Format("2006-01-02 15:04:05"), StatusActive, l.ID)
+	return err
+}
+
+// topUp replaces l with a new loan for its outstanding principal plus the
+// top-up, paying the top-up out of the change's fund
+func topUp(ctx context.Context, tx *sql.Tx, l Loan, c Change, approvedBy string, now time.Time) (string, error) {
+	principal, err := l.Outstanding().Add(c.TopUp)
+	if err != nil {
+		return "", err
+	}
+	date := now.Format("2006-01-02 15:04:05")
+
+	applicationID := uuid.NewString()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loan_applications
+		(id, chama_id, user_id, loan_product_id, amount_minor, currency, term, purpose, status, approved_by, approval_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'disbursed', ?, ?)`,
+		applicationID, l.ChamaID, l.BorrowerID, l.ProductID, principal.Amount, principal.Currency, c.Term,
+		"Top-up: "+c.Reason, approvedBy, date)
+	if err != nil {
+		return "", fmt.Errorf("failed to record top-up application: %w", err)
+	}
+
+	newLoan := Loan{
+		ID: uuid.NewString(), Principal: principal, InterestRateBps: c.InterestRateBps,
+		InterestType: c.InterestType, Term: c.Term, DisbursedAt: now,
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loans
+		(id, application_id, chama_id, borrower_id, loan_product_id, principal_minor, currency, interest_rate_bps,
+		 interest_type, term, disbursement_date, expected_end_date, status, parent_loan_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		newLoan.ID, applicationID, l.ChamaID, l.BorrowerID, l.ProductID, principal.Amount, principal.Currency,
+		c.InterestRateBps, c.InterestType, c.Term, date, now.AddDate(0, 0, c.Term).Format("2006-01-02 15:04:05"),
+		StatusActive, l.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to record top-up loan: %w", err)
+	}
+	if err := carryCollateral(ctx, tx, l, applicationID); err != nil {
+		return "", err
+	}
+	first, err := original(ctx, tx, newLoan)
+	if err != nil {
+		return "", err
+	}
+	if err := store(ctx, tx, first); err != nil {
+		return "", err
+	}
+
+	// The old loan's schedule is kept as it stood, now superseded
+	var stored bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM loan_schedules WHERE loan_id = ?)`, l.ID).Scan(&stored); err != nil {
+		return "", err
+	}
+	if !stored {
+		old, err := original(ctx, tx, l)
+		if err != nil {
+			return "", err
+		}
+		if err := store(ctx, tx, old); err != nil {
+			return "", err
+		}
+	}
+	if err := supersede(ctx, tx, l.ID); err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loans SET status = ?, actual_end_date = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		StatusRefinanced, date, l.ID)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = ledger.Post(ctx, tx, ledger.Entry{
+		ChamaID:     l.ChamaID,
+		AccountID:   c.AccountID,
+		MemberID:    l.BorrowerID,
+		Type:        ledger.TypeLoanDisbursement,
+		Amount:      c.TopUp.Negate(),
+		Reference:   newLoan.ID,
+		Description: "Loan top-up",
+		EffectiveAt: now,
+		CreatedBy:   approvedBy,
+	})
+	return newLoan.ID, err
+}
+
+// RejectChange rejects a pending change; the loan is left as it is
+func RejectChange(ctx context.Context, db *sql.DB, id, rejectedBy, reason string) (Change, error) {
+	res, err := txn.From(ctx, db).ExecContext(ctx, `
+		UPDATE loan_changes SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP, rejection_reason = ?
+		WHERE id = ? AND status = ?`,
+		ChangeRejected, rejectedBy, reason, id, ChangePending)
+	if err != nil {
+		return Change{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Change{}, ErrDecided
+	}
+	return GetChange(ctx, db, id)
+}
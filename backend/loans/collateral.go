@@ -0,0 +1,313 @@
+package loans
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of collateral
+const (
+	CollateralTitleDeed = "title_deed"
+	CollateralLogbook   = "logbook"
+	CollateralShares    = "shares"
+	CollateralOther     = "other"
+)
+
+// Collateral statuses
+const (
+	CollateralPledged  = "pledged"
+	CollateralReleased = "released"
+)
+
+// MaxCollateralDocumentSize is the largest collateral document accepted
+const MaxCollateralDocumentSize = 10 << 20
+
+var (
+	// ErrCollateralNotFound is returned for collateral that does not exist
+	ErrCollateralNotFound = errors.New("collateral not found")
+	// ErrCollateralReleased is returned when changing collateral that has already been released
+	ErrCollateralReleased = errors.New("collateral has already been released")
+	// ErrLoanOutstanding is returned when releasing collateral whose loan is still being repaid
+	ErrLoanOutstanding = errors.New("collateral secures a loan that is still outstanding")
+	// ErrApplicationClosed is returned when pledging against an application that has been decided
+	ErrApplicationClosed = errors.New("loan application is no longer open")
+)
+
+// Collateral is an item pledged as security for a loan. It is pledged
+// against the application and follows it into the loan, and into any top-up
+// that replaces the loan, until the loan is repaid and it is released.
+type Collateral struct {
+	ID            string               `json:"id"`
+	ApplicationID string               `json:"applicationId"`
+	LoanID        string               `json:"loanId,omitempty"`
+	ChamaID       string               `json:"chamaId"`
+	OwnerID       string               `json:"ownerId"`
+	Kind          string               `json:"kind"`
+	Description   string               `json:"description"`
+	Reference     string               `json:"reference,omitempty"` // title, registration or certificate number
+	DeclaredValue money.Money          `json:"declaredValue"`       // the owner's estimate
+	Value         money.Money          `json:"value"`               // the officials' valuation, zero until valued
+	ValuedBy      string               `json:"valuedBy,omitempty"`
+	ValuedAt      *time.Time           `json:"valuedAt,omitempty"`
+	Status        string               `json:"status"`
+	ReleasedBy    string               `json:"releasedBy,omitempty"`
+	ReleasedAt    *time.Time           `json:"releasedAt,omitempty"`
+	Documents     []CollateralDocument `json:"documents"`
+	PledgedAt     time.Time            `json:"pledgedAt"`
+}
+
+// CollateralDocument is a scan or photo of a pledged item, such as the title
+// deed or logbook itself
+type CollateralDocument struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	StorageKey string    `json:"-"`
+	MimeType   string    `json:"mimeType"`
+	UploadedBy string    `json:"uploadedBy"`
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+// Pledge records c against its application, which must still be awaiting a decision
+func Pledge(ctx context.Context, db *sql.DB, c Collateral) (Collateral, error) {
+	a, err := GetApplication(ctx, txn.From(ctx, db), c.ApplicationID)
+	if err != nil {
+		return c, err
+	}
+	if a.Status != StatusPending && a.Status != "approved" {
+		return c, ErrApplicationClosed
+	}
+
+	c.ID, c.ChamaID, c.OwnerID = uuid.NewString(), a.ChamaID, a.UserID
+	_, err = txn.From(ctx, db).ExecContext(ctx, `
+		INSERT INTO loan_collateral
+		(id, application_id, chama_id, owner_id, kind, description, reference, declared_minor, currency)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.ApplicationID, c.ChamaID, c.OwnerID, c.Kind, c.Description, nullIfEmpty(c.Reference),
+		c.DeclaredValue.Amount, c.DeclaredValue.Currency)
+	if err != nil {
+		return c, fmt.Errorf("failed to pledge collateral: %w", err)
+	}
+	return GetCollateral(ctx, txn.From(ctx, db), c.ID)
+}
+
+const collateralColumns = `c.id, c.application_id, COALESCE(l.id, ''), c.chama_id, c.owner_id, c.kind, c.description,
+	COALESCE(c.reference, ''), c.declared_minor, c.value_minor, c.currency, COALESCE(c.valued_by, ''), c.valued_at,
+	c.status, COALESCE(c.released_by, ''), c.released_at, c.created_at`
+
+const collateralFrom = ` FROM loan_collateral c
+	LEFT JOIN loans l ON l.application_id = c.application_id AND l.deleted_at IS NULL`
+
+func scanCollateral(row interface{ Scan(...interface{}) error }) (Collateral, error) {
+	var c Collateral
+	var currency string
+	err := row.Scan(&c.ID, &c.ApplicationID, &c.LoanID, &c.ChamaID, &c.OwnerID, &c.Kind, &c.Description, &c.Reference,
+		&c.DeclaredValue.Amount, &c.Value.Amount, &currency, &c.ValuedBy, &c.ValuedAt, &c.Status, &c.ReleasedBy,
+		&c.ReleasedAt, &c.PledgedAt)
+	c.DeclaredValue.Currency, c.Value.Currency = currency, currency
+	return c, err
+}
+
+// GetCollateral returns a single pledged item with its documents
+func GetCollateral(ctx context.Context, q Querier, id string) (Collateral, error) {
+	c, err := scanCollateral(q.QueryRowContext(ctx, `SELECT `+collateralColumns+collateralFrom+` WHERE c.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return c, ErrCollateralNotFound
+	}
+	if err != nil {
+		return c, err
+	}
+	c.Documents, err = collateralDocuments(ctx, q, c.ID)
+	return c, err
+}
+
+// ListCollateral returns the items pledged against an application, oldest first
+func ListCollateral(ctx context.Context, q Querier, applicationID string) ([]Collateral, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+collateralColumns+collateralFrom+` WHERE c.application_id = ? ORDER BY c.created_at, c.id`, applicationID)
+	if err != nil {
+		return nil, err
+	}
+	list := []Collateral{}
+	for rows.Next() {
+		c, err := scanCollateral(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range list {
+		if list[i].Documents, err = collateralDocuments(ctx, q, list[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func collateralDocuments(ctx context.Context, q Querier, collateralID string) ([]CollateralDocument, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, name, storage_key, mime_type, uploaded_by, uploaded_at
+		FROM loan_collateral_documents WHERE collateral_id = ? ORDER BY uploaded_at, id`, collateralID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []CollateralDocument{}
+	for rows.Next() {
+		var d CollateralDocument
+		if err := rows.Scan(&d.ID, &d.Name, &d.StorageKey, &d.MimeType, &d.UploadedBy, &d.UploadedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// AddCollateralDocument records a document already stored at d.StorageKey
+// against pledged collateral
+func AddCollateralDocument(ctx context.Context, db *sql.DB, collateralID string, d CollateralDocument) (CollateralDocument, error) {
+	d.ID = uuid.NewString()
+	_, err := txn.From(ctx, db).ExecContext(ctx, `
+		INSERT INTO loan_collateral_documents (id, collateral_id, name, storage_key, mime_type, uploaded_by)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		d.ID, collateralID, d.Name, d.StorageKey, d.MimeType, d.UploadedBy)
+	if err != nil {
+		return d, fmt.Errorf("failed to record collateral document: %w", err)
+	}
+	d.UploadedAt = time.Now().UTC()
+	return d, nil
+}
+
+// Value records the officials' valuation of pledged collateral
+func Value(ctx context.Context, db *sql.DB, id string, value money.Money, entry audit.Entry) (Collateral, error) {
+	c, err := GetCollateral(ctx, txn.From(ctx, db), id)
+	if err != nil {
+		return c, err
+	}
+	if c.Status != CollateralPledged {
+		return c, ErrCollateralReleased
+	}
+	if value.Currency != c.DeclaredValue.Currency {
+		return c, errors.New("valuation must be in the currency the collateral was pledged in")
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return c, err
+	}
+	defer txn.Rollback(tx)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loan_collateral SET value_minor = ?, valued_by = ?, valued_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		value.Amount, entry.UserID, id)
+	if err != nil {
+		return c, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "collateral.value", "loan_collateral", id
+	entry.OldValues, entry.NewValues = map[string]interface{}{"value": c.Value}, map[string]interface{}{"value": value}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return c, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return c, err
+	}
+	return GetCollateral(ctx, txn.From(ctx, db), id)
+}
+
+// ReleaseCollateral hands pledged collateral back to its owner. It is
+// refused while the loan it secures is still outstanding.
+func ReleaseCollateral(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Collateral, error) {
+	c, err := GetCollateral(ctx, txn.From(ctx, db), id)
+	if err != nil {
+		return c, err
+	}
+	if c.Status != CollateralPledged {
+		return c, ErrCollateralReleased
+	}
+	if c.LoanID != "" {
+		l, err := Get(ctx, txn.From(ctx, db), c.LoanID)
+		if err != nil {
+			return c, err
+		}
+		if l.Status != StatusCompleted && l.Status != StatusRefinanced {
+			return c, ErrLoanOutstanding
+		}
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return c, err
+	}
+	defer txn.Rollback(tx)
+	if err := release(ctx, tx, `id = ?`, id, entry); err != nil {
+		return c, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return c, err
+	}
+	return GetCollateral(ctx, txn.From(ctx, db), id)
+}
+
+// releaseRepaid releases everything pledged for l once it is repaid
+func releaseRepaid(ctx context.Context, tx *sql.Tx, l Loan, entry audit.Entry) error {
+	return release(ctx, tx, `application_id = (SELECT application_id FROM loans WHERE id = ?)`, l.ID, entry)
+}
+
+// release marks the pledged collateral matching where as released and audits each item
+func release(ctx context.Context, tx *sql.Tx, where string, arg interface{}, entry audit.Entry) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM loan_collateral WHERE status = ? AND `+where, CollateralPledged, arg)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE loan_collateral SET status = ?, released_by = ?, released_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			CollateralReleased, nullIfEmpty(entry.UserID), id)
+		if err != nil {
+			return err
+		}
+		e := entry
+		e.Action, e.EntityType, e.EntityID = "collateral.release", "loan_collateral", id
+		if err := audit.Record(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// carryCollateral moves what is still pledged for a loan's application over
+// to the application of the loan replacing it
+func carryCollateral(ctx context.Context, tx *sql.Tx, l Loan, applicationID string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE loan_collateral SET application_id = ?
+		WHERE status = ? AND application_id = (SELECT application_id FROM loans WHERE id = ?)`,
+		applicationID, CollateralPledged, l.ID)
+	return err
+}
@@ -0,0 +1,871 @@
+package loans
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/rules"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/validation"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// managerRoles may request and approve loan changes
+var managerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// ScheduleHandler returns the {loanId} loan's current repayment schedule, or
+// every version of it with ?history=true. Borrowers may see their own loans.
+func ScheduleHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := loadOwnLoan(db, w, r)
+		if !ok {
+			return
+		}
+
+		var body interface{}
+		var err error
+		if r.URL.Query().Get("history") == "true" {
+			body, err = History(r.Context(), txn.From(r.Context(), db), l)
+		} else {
+			body, err = Current(r.Context(), txn.From(r.Context(), db), l)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// RestructureHandler requests a new term and rate for the {loanId} loan.
+// Another official must approve it before the schedule changes.
+func RestructureHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return requestHandler(db, notifier, KindRestructure)
+}
+
+// TopUpHandler requests a top-up of the {loanId} loan from {"accountId"}.
+// Another official must approve it before any money moves.
+func TopUpHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return requestHandler(db, notifier, KindTopUp)
+}
+
+func requestHandler(db *sql.DB, notifier *notifications.Notifier, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		l, err := Get(r.Context(), txn.From(r.Context(), db), mux.Vars(r)["loanId"])
+		if err == sql.ErrNoRows || (err == nil && !chamas.IsMemberContext(r.Context(), db, l.ChamaID, userID)) {
+			http.Error(w, "Loan not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !chamas.HasRoleContext(r.Context(), db, l.ChamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Term            int    `json:"term"`
+			InterestRateBps *int64 `json:"interestRateBps"`
+			InterestType    string `json:"interestType"`
+			Amount          string `json:"amount"`
+			AccountID       string `json:"accountId"`
+			Reason          string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		c := Change{
+			LoanID:          l.ID,
+			Kind:            kind,
+			Term:            request.Term,
+			InterestRateBps: l.InterestRateBps,
+			InterestType:    l.InterestType,
+			Reason:          request.Reason,
+			RequestedBy:     userID,
+		}
+		if request.InterestRateBps != nil {
+			c.InterestRateBps = *request.InterestRateBps
+		}
+		if request.InterestType != "" {
+			c.InterestType = request.InterestType
+		}
+
+		v := validation.New()
+		if request.Term <= 0 {
+			v.Add("term", validation.CodeRequired, nil)
+		}
+		if c.InterestRateBps < 0 {
+			v.Add("interestRateBps", validation.CodeAmount, nil)
+		}
+		v.OneOf("interestType", c.InterestType, InterestFlat, InterestReducing)
+		v.Required("reason", request.Reason)
+		if kind == KindTopUp {
+			v.Required("accountId", request.AccountID)
+			if v.Required("amount", request.Amount) {
+				v.Amount("amount", request.Amount)
+			}
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		if kind == KindTopUp {
+			c.TopUp, _ = money.Parse(request.Amount, l.Principal.Currency)
+			c.AccountID = request.AccountID
+		}
+
+		c, err = RequestChange(r.Context(), db, c)
+		switch {
+		case errors.Is(err, ErrNotRunning), errors.Is(err, ErrChangePending):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		notifyApprovers(r.Context(), db, notifier, c)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// ListChangesHandler lists the {chamaId} chama's loan changes, optionally filtered by ?status=
+func ListChangesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficialContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := ListChanges(r.Context(), db, chamaID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// ApproveChangeHandler approves the {changeId} loan change and applies it
+func ApproveChangeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decideHandler(db, w, r, func(id, userID string) (Change, error) {
+			return ApproveChange(r.Context(), db, id, audit.FromRequest(r, audit.Entry{}))
+		})
+	}
+}
+
+// RejectChangeHandler rejects the {changeId} loan change with a reason
+func RejectChangeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+			http.Error(w, "A reason is required when rejecting a loan change", http.StatusBadRequest)
+			return
+		}
+		decideHandler(db, w, r, func(id, userID string) (Change, error) {
+			return RejectChange(r.Context(), db, id, userID, request.Reason)
+		})
+	}
+}
+
+func decideHandler(db *sql.DB, w http.ResponseWriter, r *http.Request, decide func(id, userID string) (Change, error)) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	c, err := GetChange(r.Context(), db, mux.Vars(r)["changeId"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Loan change not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !chamas.HasRoleContext(r.Context(), db, c.ChamaID, userID, managerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	c, err = decide(c.ID, userID)
+	switch {
+	case errors.Is(err, ErrDecided), errors.Is(err, ErrSelfApproval), errors.Is(err, ErrNotRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ledger.ErrDebitNotAllowed), errors.Is(err, ledger.ErrInsufficientFunds), errors.Is(err, ledger.ErrAccountClosed),
+		errors.Is(err, ledger.ErrPeriodClosed), errors.Is(err, ledger.ErrPeriodLocked):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// notifyApprovers asks the chama's other managers to review c
+func notifyApprovers(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, c Change) {
+	if notifier == nil {
+		return
+	}
+	approvers, err := chamas.MembersWithRoleContext(ctx, db, c.ChamaID, managerRoles...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load loan change approvers", "change_id", c.ID, "error", err)
+		return
+	}
+	params := map[string]string{
+		"kind":   i18n.T(i18n.Default, "loan_change."+c.Kind, nil),
+		"term":   strconv.Itoa(c.Term),
+		"reason": c.Reason,
+	}
+	for _, id := range approvers {
+		if id == c.RequestedBy {
+			continue
+		}
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "loan_change.requested", nil),
+			Message:   i18n.T(i18n.Default, "notification.loan_change_requested", params),
+			Type:      notifications.TypeChama,
+			RelatedID: c.ID,
+		})
+	}
+}
+
+// SettlementHandler quotes the figure to pay the {loanId} loan off today,
+// with interest rebated by the chama's rules, so the borrower can confirm it
+func SettlementHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := loadOwnLoan(db, w, r)
+		if !ok {
+			return
+		}
+		rs, err := rules.Current(r.Context(), db, l.ChamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		st, err := Quote(r.Context(), txn.From(r.Context(), db), l, rs.LoanRebateMethod, time.Now().UTC())
+		if errors.Is(err, ErrNotRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	}
+}
+
+// SettleHandler pays the {loanId} loan off with {"amount"}, which must equal
+// the current settlement figure, and an optional {"accountId"},
+// {"paymentMethod"} and {"reference"}
+func SettleHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := loadOwnLoan(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Amount        string `json:"amount"`
+			AccountID     string `json:"accountId"`
+			PaymentMethod string `json:"paymentMethod"`
+			Reference     string `json:"reference"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("amount", request.Amount) {
+			v.Amount("amount", request.Amount)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		amount, _ := money.Parse(request.Amount, l.Principal.Currency)
+		rs, err := rules.Current(r.Context(), db, l.ChamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		st, err := Settle(r.Context(), db, l.ID, rs.LoanRebateMethod, Payment{
+			Amount:    amount,
+			AccountID: request.AccountID,
+			Method:    request.PaymentMethod,
+			Reference: request.Reference,
+		}, audit.FromRequest(r, audit.Entry{}))
+		switch {
+		case errors.Is(err, ErrNotRunning), errors.Is(err, ErrQuoteChanged):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, ErrNoAccount), errors.Is(err, ledger.ErrAccountClosed), errors.Is(err, ledger.ErrCurrencyMismatch):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	}
+}
+
+// loadOwnLoan loads the {loanId} loan for its borrower or one of the chama's
+// officials, writing an error response and returning false otherwise
+func loadOwnLoan(db *sql.DB, w http.ResponseWriter, r *http.Request) (Loan, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Loan{}, false
+	}
+	l, err := Get(r.Context(), txn.From(r.Context(), db), mux.Vars(r)["loanId"])
+	if err == sql.ErrNoRows || (err == nil && !chamas.IsMemberContext(r.Context(), db, l.ChamaID, userID)) {
+		http.Error(w, "Loan not found", http.StatusNotFound)
+		return l, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return l, false
+	}
+	if l.BorrowerID != userID && !chamas.IsOfficialContext(r.Context(), db, l.ChamaID, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return l, false
+	}
+	return l, true
+}
+
+// ListProductsHandler lists the loan products members of the {chamaId} chama
+// can apply for. Officials see withdrawn products too with ?all=true.
+func ListProductsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMemberContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		all := r.URL.Query().Get("all") == "true" && chamas.IsOfficialContext(r.Context(), db, chamaID, userID)
+
+		list, err := ListProducts(r.Context(), txn.From(r.Context(), db), chamaID, all)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// SaveProductHandler creates a loan product for the {chamaId} chama, or
+// updates the {productId} one when the route has it
+func SaveProductHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRoleContext(r.Context(), db, chamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Name                 string `json:"name"`
+			Description          string `json:"description"`
+			InterestRateBps      int64  `json:"interestRateBps"`
+			InterestType         string `json:"interestType"`
+			MinAmount            string `json:"minAmount"`
+			MaxAmount            string `json:"maxAmount"`
+			SavingsMultiplierBps int64  `json:"savingsMultiplierBps"`
+			MinTerm              int    `json:"minTerm"`
+			MaxTerm              int    `json:"maxTerm"`
+			GracePeriod          int    `json:"gracePeriod"`
+			RequiredGuarantors   int    `json:"requiredGuarantors"`
+			LatePaymentFee       string `json:"latePaymentFee"`
+			EarlyPaymentFee      string `json:"earlyPaymentFee"`
+			Active               *bool  `json:"active"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.InterestType == "" {
+			request.InterestType = InterestFlat
+		}
+
+		v := validation.New()
+		v.Required("name", request.Name)
+		v.OneOf("interestType", request.InterestType, InterestFlat, InterestReducing)
+		if v.Required("maxAmount", request.MaxAmount) {
+			v.Amount("maxAmount", request.MaxAmount)
+		}
+		for field, value := range map[string]string{
+			"minAmount": request.MinAmount, "latePaymentFee": request.LatePaymentFee, "earlyPaymentFee": request.EarlyPaymentFee,
+		} {
+			if value != "" {
+				v.Amount(field, value)
+			}
+		}
+		if request.MinTerm <= 0 {
+			v.Add("minTerm", validation.CodeRequired, nil)
+		}
+		if request.MaxTerm <= 0 {
+			v.Add("maxTerm", validation.CodeRequired, nil)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		currency, err := money.ChamaCurrencyContext(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		amount := func(s string) money.Money {
+			if s == "" {
+				return money.New(0, currency)
+			}
+			m, _ := money.Parse(s, currency)
+			return m
+		}
+		p := Product{
+			ID:                   mux.Vars(r)["productId"],
+			ChamaID:              chamaID,
+			Name:                 request.Name,
+			Description:          request.Description,
+			InterestRateBps:      request.InterestRateBps,
+			InterestType:         request.InterestType,
+			MinAmount:            amount(request.MinAmount),
+			MaxAmount:            amount(request.MaxAmount),
+			SavingsMultiplierBps: request.SavingsMultiplierBps,
+			MinTerm:              request.MinTerm,
+			MaxTerm:              request.MaxTerm,
+			GracePeriod:          request.GracePeriod,
+			RequiredGuarantors:   request.RequiredGuarantors,
+			LatePaymentFee:       amount(request.LatePaymentFee),
+			EarlyPaymentFee:      amount(request.EarlyPaymentFee),
+			Active:               request.Active == nil || *request.Active,
+		}
+		created := p.ID == ""
+
+		p, err = SaveProduct(r.Context(), db, p)
+		switch {
+		case errors.Is(err, ErrProductNotFound):
+			http.Error(w, "Loan product not found", http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// ApplyHandler applies for a loan from the {chamaId} chama under
+// {"productId"} for {"amount"} over {"term"} days, asking {"guarantors"} to
+// guarantee it. The product's terms are checked before it is recorded.
+func ApplyHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMemberContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			ProductID  string   `json:"productId"`
+			Amount     string   `json:"amount"`
+			Term       int      `json:"term"`
+			Purpose    string   `json:"purpose"`
+			Guarantors []string `json:"guarantors"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("productId", request.ProductID)
+		if v.Required("amount", request.Amount) {
+			v.Amount("amount", request.Amount)
+		}
+		if request.Term <= 0 {
+			v.Add("term", validation.CodeRequired, nil)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		currency, err := money.ChamaCurrencyContext(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		amount, _ := money.Parse(request.Amount, currency)
+
+		a, err := Apply(r.Context(), db, Application{
+			ChamaID:    chamaID,
+			UserID:     userID,
+			ProductID:  request.ProductID,
+			Amount:     amount,
+			Term:       request.Term,
+			Purpose:    request.Purpose,
+			Guarantors: request.Guarantors,
+		})
+		var invalid validation.Errors
+		switch {
+		case errors.As(err, &invalid):
+			validation.WriteErrors(w, r, invalid)
+			return
+		case errors.Is(err, ErrProductNotFound):
+			http.Error(w, "Loan product not found", http.StatusNotFound)
+			return
+		case errors.Is(err, ErrProductInactive), errors.Is(err, ledger.ErrCurrencyMismatch):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyGuarantors(r.Context(), db, notifier, a)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// notifyGuarantors asks each member named on a to guarantee it
+func notifyGuarantors(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, a Application) {
+	if notifier == nil || len(a.Guarantors) == 0 {
+		return
+	}
+	var name string
+	err := txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT COALESCE(NULLIF(TRIM(COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')), ''), username)
+		FROM users WHERE user_id = ?`, a.UserID).Scan(&name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load loan applicant", "application_id", a.ID, "error", err)
+		return
+	}
+	params := map[string]string{"member": name, "amount": a.Amount.String()}
+	for _, id := range a.Guarantors {
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "loan_application.guarantor_requested", nil),
+			Message:   i18n.T(i18n.Default, "notification.loan_guarantor_requested", params),
+			Type:      notifications.TypeChama,
+			RelatedID: a.ID,
+		})
+	}
+}
+
+// GetApplicationHandler returns the {applicationId} loan application with its
+// guarantors and collateral to the applicant or the chama's officials
+func GetApplicationHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a, _, ok := loadApplication(db, w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// PledgeHandler pledges collateral against the {applicationId} application:
+// {"kind"}, {"description"}, an optional {"reference"} such as the title or
+// registration number, and the owner's {"declaredValue"}
+func PledgeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a, _, ok := loadApplication(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Kind          string `json:"kind"`
+			Description   string `json:"description"`
+			Reference     string `json:"reference"`
+			DeclaredValue string `json:"declaredValue"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.OneOf("kind", request.Kind, CollateralTitleDeed, CollateralLogbook, CollateralShares, CollateralOther)
+		v.Required("description", request.Description)
+		if v.Required("declaredValue", request.DeclaredValue) {
+			v.Amount("declaredValue", request.DeclaredValue)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		declared, _ := money.Parse(request.DeclaredValue, a.Amount.Currency)
+
+		c, err := Pledge(r.Context(), db, Collateral{
+			ApplicationID: a.ID,
+			Kind:          request.Kind,
+			Description:   request.Description,
+			Reference:     request.Reference,
+			DeclaredValue: declared,
+		})
+		if errors.Is(err, ErrApplicationClosed) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// CollateralDocumentHandler attaches an uploaded "file", such as a scan of
+// the title deed or logbook, to the {collateralId} collateral
+func CollateralDocumentHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, userID, ok := loadCollateral(db, w, r)
+		if !ok {
+			return
+		}
+		if c.Status != CollateralPledged {
+			http.Error(w, ErrCollateralReleased.Error(), http.StatusConflict)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MaxCollateralDocumentSize+1<<20)
+		if err := r.ParseMultipartForm(MaxCollateralDocumentSize); err != nil {
+			http.Error(w, "Invalid upload or file too large", http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			v := validation.New()
+			v.Add("file", validation.CodeRequired, nil)
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		defer file.Close()
+		mimeType, err := storage.DetectType(file, header.Size, MaxCollateralDocumentSize, storage.DocumentTypes)
+		if errors.Is(err, storage.ErrTooLarge) {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Only JPEG, PNG and PDF files are accepted", http.StatusUnsupportedMediaType)
+			return
+		}
+		key := "collateral/" + c.ChamaID + "/" + c.ID + "/" + uuid.NewString() + storage.Extensions[mimeType]
+		if err := store.Put(r.Context(), key, file, header.Size, mimeType); err != nil {
+			http.Error(w, "Failed to store document", http.StatusInternalServerError)
+			return
+		}
+
+		d, err := AddCollateralDocument(r.Context(), db, c.ID, CollateralDocument{
+			Name: header.Filename, StorageKey: key, MimeType: mimeType, UploadedBy: userID,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// CollateralDocumentDownloadHandler redirects to a short-lived link to the
+// {documentId} document of the {collateralId} collateral
+func CollateralDocumentDownloadHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, _, ok := loadCollateral(db, w, r)
+		if !ok {
+			return
+		}
+		for _, d := range c.Documents {
+			if d.ID != mux.Vars(r)["documentId"] {
+				continue
+			}
+			url, err := store.SignedURL(d.StorageKey, 5*time.Minute)
+			if err != nil {
+				http.Error(w, "Failed to create download link", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+		http.Error(w, "Document not found", http.StatusNotFound)
+	}
+}
+
+// ValueCollateralHandler records the officials' {"value"} of the {collateralId} collateral
+func ValueCollateralHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, userID, ok := loadCollateral(db, w, r)
+		if !ok {
+			return
+		}
+		if !chamas.HasRoleContext(r.Context(), db, c.ChamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("value", request.Value) {
+			v.Amount("value", request.Value)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		value, _ := money.Parse(request.Value, c.DeclaredValue.Currency)
+
+		c, err := Value(r.Context(), db, c.ID, value, audit.FromRequest(r, audit.Entry{}))
+		if errors.Is(err, ErrCollateralReleased) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// ReleaseCollateralHandler hands the {collateralId} collateral back to its
+// owner. Collateral is released automatically when its loan is settled; this
+// covers loans repaid some other way and applications that never became loans.
+func ReleaseCollateralHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, userID, ok := loadCollateral(db, w, r)
+		if !ok {
+			return
+		}
+		if !chamas.HasRoleContext(r.Context(), db, c.ChamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		c, err := ReleaseCollateral(r.Context(), db, c.ID, audit.FromRequest(r, audit.Entry{}))
+		if errors.Is(err, ErrCollateralReleased) || errors.Is(err, ErrLoanOutstanding) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// loadApplication loads the {applicationId} application for its applicant or
+// one of the chama's officials, writing an error response and returning false otherwise
+func loadApplication(db *sql.DB, w http.ResponseWriter, r *http.Request) (Application, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Application{}, "", false
+	}
+	a, err := GetApplication(r.Context(), txn.From(r.Context(), db), mux.Vars(r)["applicationId"])
+	if err == sql.ErrNoRows || (err == nil && a.UserID != userID && !chamas.IsOfficialContext(r.Context(), db, a.ChamaID, userID)) {
+		http.Error(w, "Loan application not found", http.StatusNotFound)
+		return a, userID, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return a, userID, false
+	}
+	return a, userID, true
+}
+
+// loadCollateral loads the {collateralId} collateral for its owner or one of
+// the chama's officials, writing an error response and returning false otherwise
+func loadCollateral(db *sql.DB, w http.ResponseWriter, r *http.Request) (Collateral, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Collateral{}, "", false
+	}
+	c, err := GetCollateral(r.Context(), txn.From(r.Context(), db), mux.Vars(r)["collateralId"])
+	if errors.Is(err, ErrCollateralNotFound) || (err == nil && c.OwnerID != userID && !chamas.IsOfficialContext(r.Context(), db, c.ChamaID, userID)) {
+		http.Error(w, "Collateral not found", http.StatusNotFound)
+		return c, userID, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return c, userID, false
+	}
+	return c, userID, true
+}
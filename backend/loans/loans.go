@@ -0,0 +1,106 @@
+package loans
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"tujifund-app/backend/money"
+)
+
+// Loan statuses
+const (
+	StatusPending    = "pending"
+	StatusActive     = "active"
+	StatusCompleted  = "completed"
+	StatusDefaulted  = "defaulted"
+	StatusRefinanced = "refinanced" // replaced by a top-up loan
+)
+
+// Interest types
+const (
+	InterestFlat     = "flat"
+	InterestReducing = "reducing"
+)
+
+// ErrNotRunning is returned when changing or settling a loan that is not being repaid
+var ErrNotRunning = errors.New("loan is not active")
+
+// Querier is a *sql.DB or *sql.Tx
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Loan is a loan to a chama member. Repaid counts every repayment, so the
+// principal still owed is Principal less Repaid.
+type Loan struct {
+	ID              string      `json:"id"`
+	ChamaID         string      `json:"chamaId"`
+	BorrowerID      string      `json:"borrowerId"`
+	ProductID       string      `json:"productId"`
+	Principal       money.Money `json:"principal"`
+	Repaid          money.Money `json:"repaid"`
+	InterestRateBps int64       `json:"interestRateBps"`
+	InterestType    string      `json:"interestType"`
+	Term            int         `json:"term"` // in days
+	DisbursedAt     time.Time   `json:"disbursedAt"`
+	Status          string      `json:"status"`
+	ParentLoanID    string      `json:"parentLoanId,omitempty"`
+}
+
+const columns = `id, COALESCE(chama_id, ''), borrower_id, loan_product_id, principal_minor, total_repaid_minor, currency,
+	interest_rate_bps, interest_type, term, disbursement_date, status, COALESCE(parent_loan_id, '')`
+
+func scan(row interface{ Scan(...interface{}) error }) (Loan, error) {
+	var l Loan
+	var disbursed sql.NullTime
+	err := row.Scan(&l.ID, &l.ChamaID, &l.BorrowerID, &l.ProductID, &l.Principal.Amount, &l.Repaid.Amount,
+		&l.Principal.Currency, &l.InterestRateBps, &l.InterestType, &l.Term, &disbursed, &l.Status, &l.ParentLoanID)
+	l.Repaid.Currency = l.Principal.Currency
+	l.DisbursedAt = disbursed.Time
+	return l, err
+}
+
+// Get returns a single loan
+func Get(ctx context.Context, q Querier, id string) (Loan, error) {
+	return scan(q.QueryRowContext(ctx, `SELECT `+columns+` FROM loans WHERE id = ? AND deleted_at IS NULL`, id))
+}
+
+// Running returns a chama's disbursed loans that are still being repaid
+func Running(ctx context.Context, q Querier, chamaID string) ([]Loan, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+columns+` FROM loans
+		WHERE chama_id = ? AND status IN (?, ?) AND deleted_at IS NULL AND disbursement_date IS NOT NULL
+		ORDER BY disbursement_date`,
+		chamaID, StatusActive, StatusDefaulted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Loan{}
+	for rows.Next() {
+		l, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	return list, rows.Err()
+}
+
+// Outstanding is the principal still owed on l
+func (l Loan) Outstanding() money.Money {
+	owed := l.Principal.Amount - l.Repaid.Amount
+	if owed < 0 {
+		owed = 0
+	}
+	return money.New(owed, l.Principal.Currency)
+}
+
+// Running reports whether l is disbursed and still being repaid
+func (l Loan) Running() bool {
+	return (l.Status == StatusActive || l.Status == StatusDefaulted) && !l.DisbursedAt.IsZero()
+}
@@ -0,0 +1,156 @@
+package loans
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrProductNotFound is returned for a loan product that does not exist or belongs to another chama
+	ErrProductNotFound = errors.New("loan product not found")
+	// ErrProductInactive is returned when applying for a product the chama no longer offers
+	ErrProductInactive = errors.New("loan product is no longer offered")
+)
+
+// Product is a kind of loan a chama offers, such as emergency, development
+// or school fees, each with its own terms. A product without a chama is
+// offered to every chama.
+type Product struct {
+	ID                   string      `json:"id"`
+	ChamaID              string      `json:"chamaId,omitempty"`
+	Name                 string      `json:"name"`
+	Description          string      `json:"description,omitempty"`
+	InterestRateBps      int64       `json:"interestRateBps"`
+	InterestType         string      `json:"interestType"`
+	MinAmount            money.Money `json:"minAmount"`
+	MaxAmount            money.Money `json:"maxAmount"`
+	SavingsMultiplierBps int64       `json:"savingsMultiplierBps"` // 0 means the limit does not depend on savings, 30000 = 3x
+	MinTerm              int         `json:"minTerm"`              // in days
+	MaxTerm              int         `json:"maxTerm"`              // in days
+	GracePeriod          int         `json:"gracePeriod"`          // in days
+	RequiredGuarantors   int         `json:"requiredGuarantors"`
+	LatePaymentFee       money.Money `json:"latePaymentFee"`
+	EarlyPaymentFee      money.Money `json:"earlyPaymentFee"`
+	Active               bool        `json:"active"`
+}
+
+// Limit is the most a member with the given savings may borrow under p:
+// MaxAmount, further capped at SavingsMultiplierBps of their savings when set
+func (p Product) Limit(savings money.Money) money.Money {
+	if p.SavingsMultiplierBps <= 0 {
+		return p.MaxAmount
+	}
+	limit, err := savings.ApplyRate(p.SavingsMultiplierBps)
+	if err != nil {
+		return money.New(0, p.MaxAmount.Currency)
+	}
+	if limit.Amount > p.MaxAmount.Amount {
+		return p.MaxAmount
+	}
+	return limit
+}
+
+const productColumns = `id, COALESCE(chama_id, ''), name, COALESCE(description, ''), interest_rate_bps, interest_type,
+	min_amount_minor, max_amount_minor, currency, savings_multiplier_bps, min_term, max_term, grace_period,
+	required_guarantors, COALESCE(late_payment_fee_minor, 0), COALESCE(early_payment_fee_minor, 0), is_active`
+
+func scanProduct(row interface{ Scan(...interface{}) error }) (Product, error) {
+	var p Product
+	var currency string
+	err := row.Scan(&p.ID, &p.ChamaID, &p.Name, &p.Description, &p.InterestRateBps, &p.InterestType,
+		&p.MinAmount.Amount, &p.MaxAmount.Amount, &currency, &p.SavingsMultiplierBps, &p.MinTerm, &p.MaxTerm,
+		&p.GracePeriod, &p.RequiredGuarantors, &p.LatePaymentFee.Amount, &p.EarlyPaymentFee.Amount, &p.Active)
+	p.MinAmount.Currency, p.MaxAmount.Currency = currency, currency
+	p.LatePaymentFee.Currency, p.EarlyPaymentFee.Currency = currency, currency
+	return p, err
+}
+
+// GetProduct returns a loan product the chama may use: one of its own or a
+// system-wide one
+func GetProduct(ctx context.Context, q Querier, chamaID, id string) (Product, error) {
+	p, err := scanProduct(q.QueryRowContext(ctx, `
+		SELECT `+productColumns+` FROM loan_products WHERE id = ? AND (chama_id = ? OR chama_id IS NULL)`, id, chamaID))
+	if err == sql.ErrNoRows {
+		return p, ErrProductNotFound
+	}
+	return p, err
+}
+
+// ListProducts returns the chama's loan products and the system-wide ones
+// by name, only those still offered unless all is set
+func ListProducts(ctx context.Context, q Querier, chamaID string, all bool) ([]Product, error) {
+	query := `SELECT ` + productColumns + ` FROM loan_products WHERE (chama_id = ? OR chama_id IS NULL)`
+	if !all {
+		query += ` AND is_active = TRUE`
+	}
+	rows, err := q.QueryContext(ctx, query+` ORDER BY name`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// SaveProduct creates p, or updates it when it has an ID. Only a chama's own
+// products can be updated; system-wide ones are managed by the platform.
+func SaveProduct(ctx context.Context, db *sql.DB, p Product) (Product, error) {
+	if p.MinAmount.Amount < 0 || p.MaxAmount.Amount < p.MinAmount.Amount {
+		return p, errors.New("maximum amount must not be less than the minimum")
+	}
+	if p.MinTerm <= 0 || p.MaxTerm < p.MinTerm {
+		return p, errors.New("maximum term must not be less than the minimum")
+	}
+	if p.InterestRateBps < 0 || p.SavingsMultiplierBps < 0 || p.GracePeriod < 0 || p.RequiredGuarantors < 0 {
+		return p, errors.New("rates, grace period and guarantors must not be negative")
+	}
+
+	if p.ID == "" {
+		p.ID = uuid.NewString()
+		_, err := txn.From(ctx, db).ExecContext(ctx, `
+			INSERT INTO loan_products
+			(id, chama_id, name, description, interest_rate_bps, interest_type, min_amount_minor, max_amount_minor, currency,
+			 savings_multiplier_bps, min_term, max_term, grace_period, required_guarantors, late_payment_fee_minor,
+			 early_payment_fee_minor, is_active)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.ID, p.ChamaID, p.Name, nullIfEmpty(p.Description), p.InterestRateBps, p.InterestType, p.MinAmount.Amount,
+			p.MaxAmount.Amount, p.MaxAmount.Currency, p.SavingsMultiplierBps, p.MinTerm, p.MaxTerm, p.GracePeriod,
+			p.RequiredGuarantors, p.LatePaymentFee.Amount, p.EarlyPaymentFee.Amount, p.Active)
+		if err != nil {
+			return p, fmt.Errorf("failed to create loan product: %w", err)
+		}
+		return GetProduct(ctx, txn.From(ctx, db), p.ChamaID, p.ID)
+	}
+
+	res, err := txn.From(ctx, db).ExecContext(ctx, `
+		UPDATE loan_products SET name = ?, description = ?, interest_rate_bps = ?, interest_type = ?,
+			min_amount_minor = ?, max_amount_minor = ?, savings_multiplier_bps = ?, min_term = ?, max_term = ?,
+			grace_period = ?, required_guarantors = ?, late_payment_fee_minor = ?, early_payment_fee_minor = ?,
+			is_active = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND chama_id = ?`,
+		p.Name, nullIfEmpty(p.Description), p.InterestRateBps, p.InterestType, p.MinAmount.Amount, p.MaxAmount.Amount,
+		p.SavingsMultiplierBps, p.MinTerm, p.MaxTerm, p.GracePeriod, p.RequiredGuarantors, p.LatePaymentFee.Amount,
+		p.EarlyPaymentFee.Amount, p.Active, p.ID, p.ChamaID)
+	if err != nil {
+		return p, fmt.Errorf("failed to update loan product: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return p, ErrProductNotFound
+	}
+	return GetProduct(ctx, txn.From(ctx, db), p.ChamaID, p.ID)
+}
@@ -0,0 +1,264 @@
+package loans
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"tujifund-app/backend/calendar"
+	"tujifund-app/backend/money"
+)
+
+// InstalmentDays is the interval between loan instalments. Loans are repaid
+// in equal principal instalments over their term, the last falling due at
+// the end of the term.
+const InstalmentDays = 30
+
+// Instalment is one scheduled repayment
+type Instalment struct {
+	Number    int         `json:"number"`
+	DueDate   time.Time   `json:"dueDate"`
+	Principal money.Money `json:"principal"`
+	Interest  money.Money `json:"interest"`
+}
+
+// Schedule is one version of a loan's repayment plan. A restructure replaces
+// the current schedule with a new version covering the principal still owed;
+// Repaid is what had already been repaid when the version started.
+type Schedule struct {
+	LoanID          string       `json:"loanId"`
+	Version         int          `json:"version"`
+	StartsAt        time.Time    `json:"startsAt"`
+	Principal       money.Money  `json:"principal"`
+	Repaid          money.Money  `json:"repaid"`
+	InterestRateBps int64        `json:"interestRateBps"`
+	InterestType    string       `json:"interestType"`
+	Term            int          `json:"term"`
+	ChangeID        string       `json:"changeId,omitempty"`
+	SupersededAt    string       `json:"supersededAt,omitempty"`
+	Instalments     []Instalment `json:"instalments"`
+}
+
+// Build lays out principal over term days from start. Interest is an annual
+// rate: flat interest is charged on the full principal, reducing interest on
+// the principal still owed during each instalment period. An instalment
+// that would fall due on a day cal is closed is due on the next open day;
+// interest is still charged for the period up to the day it would have
+// fallen due, so moving it costs the borrower nothing.
+func Build(principal money.Money, rateBps int64, interestType string, term int, start time.Time, cal calendar.Calendar) []Instalment {
+	n := (term + InstalmentDays - 1) / InstalmentDays
+	if n < 1 {
+		n = 1
+	}
+	list := make([]Instalment, 0, n)
+	outstanding := principal.Amount
+	prev := start
+	for k := 1; k <= n; k++ {
+		due := start.AddDate(0, 0, k*InstalmentDays)
+		if k == n {
+			due = start.AddDate(0, 0, term)
+		}
+		// Equal instalments, with any remainder in the last
+		part := principal.Amount*int64(k)/int64(n) - principal.Amount*int64(k-1)/int64(n)
+		base := principal.Amount
+		if interestType == InterestReducing {
+			base = outstanding
+		}
+		days := int64(due.Sub(prev).Hours() / 24)
+		list = append(list, Instalment{
+			Number:    k,
+			DueDate:   cal.Next(due),
+			Principal: money.New(part, principal.Currency),
+			Interest:  money.New(Interest(base, rateBps, days), principal.Currency),
+		})
+		outstanding -= part
+		prev = due
+	}
+	return list
+}
+
+// Interest is base at an annual rate of bps for days, rounded half up
+func Interest(base, bps, days int64) int64 {
+	const denominator = 10000 * 365
+	return (base*bps*days + denominator/2) / denominator
+}
+
+// original is the schedule a loan was disbursed with, for loans that have
+// never been restructured and so have none stored. Its due dates follow the
+// chama's calendar as it is now.
+func original(ctx context.Context, q Querier, l Loan) (Schedule, error) {
+	cal, err := calendar.Load(ctx, q, l.ChamaID)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return Schedule{
+		LoanID:          l.ID,
+		Version:         1,
+		StartsAt:        l.DisbursedAt,
+		Principal:       l.Principal,
+		Repaid:          money.New(0, l.Principal.Currency),
+		InterestRateBps: l.InterestRateBps,
+		InterestType:    l.InterestType,
+		Term:            l.Term,
+		Instalments:     Build(l.Principal, l.InterestRateBps, l.InterestType, l.Term, l.DisbursedAt, cal),
+	}, nil
+}
+
+const scheduleColumns = `loan_id, version, starts_at, principal_minor, repaid_minor, currency, interest_rate_bps,
+	interest_type, term, COALESCE(change_id, ''), COALESCE(superseded_at, '')`
+
+// Current returns the schedule l is being repaid on
+func Current(ctx context.Context, q Querier, l Loan) (Schedule, error) {
+	s, err := scanSchedule(q.QueryRowContext(ctx, `
+		SELECT `+scheduleColumns+` FROM loan_schedules
+		WHERE loan_id = ? AND superseded_at IS NULL`, l.ID))
+	if err == sql.ErrNoRows {
+		return original(ctx, q, l)
+	}
+	if err != nil {
+		return s, err
+	}
+	s.Instalments, err = instalments(ctx, q, s)
+	return s, err
+}
+
+// History returns every version of l's schedule, oldest first
+func History(ctx context.Context, q Querier, l Loan) ([]Schedule, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+scheduleColumns+` FROM loan_schedules WHERE loan_id = ? ORDER BY version`, l.ID)
+	if err != nil {
+		return nil, err
+	}
+	var list []Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		s, err := original(ctx, q, l)
+		if err != nil {
+			return nil, err
+		}
+		return []Schedule{s}, nil
+	}
+	for i := range list {
+		if list[i].Instalments, err = instalments(ctx, q, list[i]); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func scanSchedule(row interface{ Scan(...interface{}) error }) (Schedule, error) {
+	var s Schedule
+	err := row.Scan(&s.LoanID, &s.Version, &s.StartsAt, &s.Principal.Amount, &s.Repaid.Amount, &s.Principal.Currency,
+		&s.InterestRateBps, &s.InterestType, &s.Term, &s.ChangeID, &s.SupersededAt)
+	s.Repaid.Currency = s.Principal.Currency
+	return s, err
+}
+
+func instalments(ctx context.Context, q Querier, s Schedule) ([]Instalment, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT number, due_date, principal_minor, interest_minor FROM loan_instalments
+		WHERE loan_id = ? AND version = ? ORDER BY number`, s.LoanID, s.Version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Instalment{}
+	for rows.Next() {
+		i := Instalment{Principal: money.New(0, s.Principal.Currency), Interest: money.New(0, s.Principal.Currency)}
+		if err := rows.Scan(&i.Number, &i.DueDate, &i.Principal.Amount, &i.Interest.Amount); err != nil {
+			return nil, err
+		}
+		list = append(list, i)
+	}
+	return list, rows.Err()
+}
+
+// replace supersedes l's current schedule with s. A loan with no stored
+// schedule first has its original one stored, so the history is complete.
+func replace(ctx context.Context, tx *sql.Tx, l Loan, s Schedule) error {
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM loan_schedules WHERE loan_id = ?`, l.ID).Scan(&version); err != nil {
+		return err
+	}
+	if version == 0 && !l.DisbursedAt.IsZero() {
+		first, err := original(ctx, tx, l)
+		if err != nil {
+			return err
+		}
+		if err := store(ctx, tx, first); err != nil {
+			return err
+		}
+		version = 1
+	}
+	if err := supersede(ctx, tx, l.ID); err != nil {
+		return err
+	}
+	s.LoanID, s.Version = l.ID, version+1
+	return store(ctx, tx, s)
+}
+
+// supersede ends l's current schedule, leaving it on record
+func supersede(ctx context.Context, tx *sql.Tx, loanID string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE loan_schedules SET superseded_at = CURRENT_TIMESTAMP
+		WHERE loan_id = ? AND superseded_at IS NULL`, loanID)
+	return err
+}
+
+func store(ctx context.Context, tx *sql.Tx, s Schedule) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO loan_schedules
+		(loan_id, version, starts_at, principal_minor, repaid_minor, currency, interest_rate_bps, interest_type, term, change_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.LoanID, s.Version, s.StartsAt.UTC().Format("2006-01-02 15:04:05"), s.Principal.Amount, s.Repaid.Amount,
+		s.Principal.Currency, s.InterestRateBps, s.InterestType, s.Term, nullIfEmpty(s.ChangeID))
+	if err != nil {
+		return err
+	}
+	for _, i := range s.Instalments {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO loan_instalments (loan_id, version, number, due_date, principal_minor, interest_minor)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			s.LoanID, s.Version, i.Number, i.DueDate.Format("2006-01-02"), i.Principal.Amount, i.Interest.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expected is the principal that should have been repaid on l by now under
+// s, allowing graceDays after each due date, and the due date of the
+// earliest instalment not yet covered by repaid
+func (s Schedule) Expected(repaid int64, graceDays int, now time.Time) (int64, time.Time) {
+	expected := s.Repaid.Amount
+	var due time.Time
+	for _, i := range s.Instalments {
+		if !now.After(i.DueDate.AddDate(0, 0, graceDays)) {
+			break
+		}
+		expected += i.Principal.Amount
+		if expected > repaid && due.IsZero() {
+			due = i.DueDate
+		}
+	}
+	return expected, due
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,97 @@
+package loans
+
+import (
+	"testing"
+	"time"
+
+	"tujifund-app/backend/calendar"
+	"tujifund-app/backend/money"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestBuild(t *testing.T) {
+	// 1 July is a Wednesday the chama has closed, so the first instalment
+	// falls due the next day
+	cal := calendar.Calendar{Days: map[string]calendar.Day{"2026-07-01": {Date: "2026-07-01", Open: false}}}
+	tests := []struct {
+		name         string
+		principal    int64
+		rateBps      int64
+		interestType string
+		term         int
+		want         []Instalment
+	}{
+		{
+			name: "flat, last instalment absorbs rounding", principal: 100000, rateBps: 1200, interestType: InterestFlat, term: 88,
+			want: []Instalment{
+				{Number: 1, DueDate: day("2026-07-02"), Principal: money.New(33333, "KES"), Interest: money.New(986, "KES")},
+				{Number: 2, DueDate: day("2026-07-31"), Principal: money.New(33333, "KES"), Interest: money.New(986, "KES")},
+				{Number: 3, DueDate: day("2026-08-28"), Principal: money.New(33334, "KES"), Interest: money.New(921, "KES")},
+			},
+		},
+		{
+			name: "reducing", principal: 100000, rateBps: 1200, interestType: InterestReducing, term: 88,
+			want: []Instalment{
+				{Number: 1, DueDate: day("2026-07-02"), Principal: money.New(33333, "KES"), Interest: money.New(986, "KES")},
+				{Number: 2, DueDate: day("2026-07-31"), Principal: money.New(33333, "KES"), Interest: money.New(658, "KES")},
+				{Number: 3, DueDate: day("2026-08-28"), Principal: money.New(33334, "KES"), Interest: money.New(307, "KES")},
+			},
+		},
+		{
+			name: "part period at the end", principal: 50000, rateBps: 1000, interestType: InterestFlat, term: 45,
+			want: []Instalment{
+				{Number: 1, DueDate: day("2026-07-02"), Principal: money.New(25000, "KES"), Interest: money.New(411, "KES")},
+				{Number: 2, DueDate: day("2026-07-16"), Principal: money.New(25000, "KES"), Interest: money.New(205, "KES")},
+			},
+		},
+		{
+			name: "no term, due after Madaraka Day", principal: 50000, rateBps: 1000, interestType: InterestFlat, term: 0,
+			want: []Instalment{
+				{Number: 1, DueDate: day("2026-06-02"), Principal: money.New(50000, "KES"), Interest: money.New(0, "KES")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Build(money.New(tt.principal, "KES"), tt.rateBps, tt.interestType, tt.term, day("2026-06-01"), cal)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Build() returned %d instalments, want %d", len(got), len(tt.want))
+			}
+			var total int64
+			for i, want := range tt.want {
+				if !got[i].DueDate.Equal(want.DueDate) || got[i].Number != want.Number ||
+					got[i].Principal != want.Principal || got[i].Interest != want.Interest {
+					t.Errorf("instalment %d = %+v, want %+v", i+1, got[i], want)
+				}
+				total += got[i].Principal.Amount
+			}
+			if total != tt.principal {
+				t.Errorf("instalments repay %d, want %d", total, tt.principal)
+			}
+		})
+	}
+}
+
+func TestInterest(t *testing.T) {
+	tests := []struct {
+		base, bps, days, want int64
+	}{
+		{100000, 1200, 30, 986},
+		{100000, 1200, 365, 12000},
+		{1, 5000, 365, 1},  // half a cent rounds up
+		{1, 4900, 365, 0},  // under half rounds down
+		{100000, 0, 30, 0}, // interest free
+	}
+	for _, tt := range tests {
+		if got := Interest(tt.base, tt.bps, tt.days); got != tt.want {
+			t.Errorf("Interest(%d, %d, %d) = %d, want %d", tt.base, tt.bps, tt.days, got, tt.want)
+		}
+	}
+}
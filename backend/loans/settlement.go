@@ -0,0 +1,263 @@
+package loans
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Rebate methods decide how much of a loan's scheduled interest is given back
+// when it is settled early
+const (
+	RebateProRata  = "pro_rata"   // in proportion to the days left in the term
+	RebateRuleOf78 = "rule_of_78" // by the sum of the digits of the instalments left
+)
+
+var (
+	// ErrQuoteChanged is returned when settling for an amount that is no longer the settlement figure
+	ErrQuoteChanged = errors.New("settlement figure has changed, fetch a new quote")
+	// ErrNoAccount is returned when there is no fund to receive a repayment into
+	ErrNoAccount = errors.New("no loans fund to receive the repayment")
+)
+
+// Settlement is what it costs to pay a loan off in full at AsOf. Interest is
+// everything scheduled on the current schedule; the borrower pays what was
+// earned by AsOf, less what they have already paid, plus any early payment fee.
+// Interest already accrued onto the loan is earned and is never rebated.
+type Settlement struct {
+	LoanID       string      `json:"loanId"`
+	AsOf         time.Time   `json:"asOf"`
+	Method       string      `json:"method"`
+	Principal    money.Money `json:"principal"`
+	Interest     money.Money `json:"interest"`
+	Rebate       money.Money `json:"rebate"`
+	InterestPaid money.Money `json:"interestPaid"`
+	InterestDue  money.Money `json:"interestDue"`
+	Accrued      money.Money `json:"accrued"` // part of InterestDue accrued onto the loan
+	Fee          money.Money `json:"fee"`
+	Total        money.Money `json:"total"`
+}
+
+// Rebate is the part of interest given back when a schedule is settled at
+// asOf. Pro-rata rebates the days left of the term; the rule of 78 weights
+// earlier instalments more heavily, so less is rebated early in the term.
+func (s Schedule) Rebate(interest int64, method string, asOf time.Time) int64 {
+	if method == RebateRuleOf78 {
+		n := int64(len(s.Instalments))
+		var left int64
+		for _, i := range s.Instalments {
+			if i.DueDate.After(asOf) {
+				left++
+			}
+		}
+		if n == 0 {
+			return 0
+		}
+		return interest * left * (left + 1) / (n * (n + 1))
+	}
+
+	if s.Term <= 0 {
+		return 0
+	}
+	elapsed := int64(asOf.Sub(s.StartsAt).Hours() / 24)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	left := int64(s.Term) - elapsed
+	if left <= 0 {
+		return 0
+	}
+	return interest * left / int64(s.Term)
+}
+
+// Quote works out the settlement figure for l at asOf
+func Quote(ctx context.Context, q Querier, l Loan, method string, asOf time.Time) (Settlement, error) {
+	if !l.Running() {
+		return Settlement{}, ErrNotRunning
+	}
+	if method != RebateRuleOf78 {
+		method = RebateProRata
+	}
+	s, err := Current(ctx, q, l)
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	var interest int64
+	for _, i := range s.Instalments {
+		interest += i.Interest.Amount
+	}
+	var paid, accrued int64
+	var fee sql.NullInt64
+	err = q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(interest_minor), 0) FROM loan_repayments
+		WHERE loan_id = ? AND status = 'completed' AND payment_date >= ?`,
+		l.ID, s.StartsAt.UTC().Format("2006-01-02 15:04:05")).Scan(&paid)
+	if err != nil {
+		return Settlement{}, err
+	}
+	err = q.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT SUM(amount_minor) FROM interest_accruals WHERE kind = 'loan' AND source_id = ?), 0)
+		     - COALESCE((SELECT SUM(interest_minor) FROM loan_repayments WHERE loan_id = ? AND status = 'completed'), 0)`,
+		l.ID, l.ID).Scan(&accrued)
+	if err != nil {
+		return Settlement{}, err
+	}
+	if accrued < 0 {
+		accrued = 0
+	}
+	err = q.QueryRowContext(ctx, `SELECT early_payment_fee_minor FROM loan_products WHERE id = ?`, l.ProductID).Scan(&fee)
+	if err != nil && err != sql.ErrNoRows {
+		return Settlement{}, err
+	}
+
+	rebate := s.Rebate(interest, method, asOf)
+	due := interest - rebate - paid
+	if due < accrued {
+		due = accrued
+		if rebate = interest - paid - due; rebate < 0 {
+			rebate = 0
+		}
+	}
+	if due < 0 {
+		due = 0
+	}
+	currency := l.Principal.Currency
+	principal := l.Outstanding()
+	return Settlement{
+		LoanID:       l.ID,
+		AsOf:         asOf,
+		Method:       method,
+		Principal:    principal,
+		Interest:     money.New(interest, currency),
+		Rebate:       money.New(rebate, currency),
+		InterestPaid: money.New(paid, currency),
+		InterestDue:  money.New(due, currency),
+		Accrued:      money.New(accrued, currency),
+		Fee:          money.New(fee.Int64, currency),
+		Total:        money.New(principal.Amount+due+fee.Int64, currency),
+	}, nil
+}
+
+// Payment is a settlement being paid
+type Payment struct {
+	Amount    money.Money // must equal the settlement figure
+	AccountID string      // fund receiving it; empty for the fund the loan was paid from
+	Method    string
+	Reference string
+}
+
+// Settle pays l off in full. The amount must match a fresh quote so a member
+// never pays against a stale figure. Principal and accrued interest are posted
+// as a loan repayment, clearing the loan balance, and the rest of the
+// interest and fees as interest, and the loan is completed and its
+// collateral released.
+func Settle(ctx context.Context, db *sql.DB, loanID, method string, p Payment, entry audit.Entry) (Settlement, error) {
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return Settlement{}, err
+	}
+	defer txn.Rollback(tx)
+
+	st, err := SettleTx(ctx, tx, loanID, method, p, entry)
+	if err != nil {
+		return st, err
+	}
+	return st, txn.Commit(tx)
+}
+
+// SettleTx is Settle within tx, for settling a loan as part of a larger change
+func SettleTx(ctx context.Context, tx *sql.Tx, loanID, method string, p Payment, entry audit.Entry) (Settlement, error) {
+	l, err := Get(ctx, tx, loanID)
+	if err != nil {
+		return Settlement{}, err
+	}
+	now := time.Now().UTC()
+	st, err := Quote(ctx, tx, l, method, now)
+	if err != nil {
+		return st, err
+	}
+	if p.Amount != st.Total {
+		return st, ErrQuoteChanged
+	}
+	if p.AccountID == "" {
+		if p.AccountID, err = Fund(ctx, tx, l); err != nil {
+			return st, err
+		}
+	}
+
+	date := now.Format("2006-01-02 15:04:05")
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loan_repayments
+		(id, loan_id, amount_minor, currency, principal_minor, interest_minor, penalties_minor, payment_date,
+		 payment_method, transaction_reference)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		uuid.NewString(), l.ID, st.Total.Amount, st.Total.Currency, st.Principal.Amount, st.InterestDue.Amount,
+		st.Fee.Amount, date, nullIfEmpty(p.Method), nullIfEmpty(p.Reference))
+	if err != nil {
+		return st, fmt.Errorf("failed to record settlement: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loans SET total_repaid_minor = total_repaid_minor + ?, status = ?, actual_end_date = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		st.Principal.Amount, StatusCompleted, date, l.ID)
+	if err != nil {
+		return st, err
+	}
+	if err := releaseRepaid(ctx, tx, l, entry); err != nil {
+		return st, err
+	}
+
+	legs := []ledger.Entry{
+		{Type: ledger.TypeLoanRepayment, Amount: money.New(st.Principal.Amount+st.Accrued.Amount, st.Total.Currency),
+			Description: "Early settlement"},
+		{Type: ledger.TypeInterest, Amount: money.New(st.InterestDue.Amount-st.Accrued.Amount+st.Fee.Amount, st.Total.Currency),
+			Description: "Early settlement interest and fees"},
+	}
+	for _, e := range legs {
+		if e.Amount.IsZero() {
+			continue
+		}
+		e.ChamaID, e.AccountID, e.MemberID, e.Reference = l.ChamaID, p.AccountID, l.BorrowerID, l.ID
+		e.EffectiveAt, e.CreatedBy = now, entry.UserID
+		if _, err := ledger.Post(ctx, tx, e); err != nil {
+			return st, err
+		}
+	}
+
+	entry.Action, entry.EntityType, entry.EntityID = "loan.settle", "loan", l.ID
+	entry.OldValues = map[string]interface{}{"status": l.Status, "outstanding": l.Outstanding()}
+	entry.NewValues = st
+	return st, audit.Record(ctx, tx, entry)
+}
+
+// Fund is the fund l was disbursed from, or failing that the chama's loans fund
+func Fund(ctx context.Context, q Querier, l Loan) (string, error) {
+	var id string
+	err := q.QueryRowContext(ctx, `
+		SELECT account_id FROM ledger_entries
+		WHERE chama_id = ? AND reference = ? AND entry_type = ?
+		ORDER BY effective_at LIMIT 1`,
+		l.ChamaID, l.ID, ledger.TypeLoanDisbursement).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+	err = q.QueryRowContext(ctx, `
+		SELECT id FROM chama_accounts
+		WHERE chama_id = ? AND account_type = 'loans' AND status = 'active'
+		ORDER BY created_at LIMIT 1`, l.ChamaID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", ErrNoAccount
+	}
+	return id, err
+}
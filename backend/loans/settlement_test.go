@@ -0,0 +1,46 @@
+package loans
+
+import (
+	"testing"
+	"time"
+
+	"tujifund-app/backend/calendar"
+	"tujifund-app/backend/money"
+)
+
+func TestRebate(t *testing.T) {
+	start := day("2026-06-01")
+	s := Schedule{
+		StartsAt: start, Principal: money.New(100000, "KES"), InterestRateBps: 1200, InterestType: InterestFlat, Term: 88,
+		Instalments: Build(money.New(100000, "KES"), 1200, InterestFlat, 88, start, calendar.Calendar{}),
+	}
+	const interest = 2893
+	tests := []struct {
+		name     string
+		schedule Schedule
+		method   string
+		asOf     time.Time
+		want     int64
+	}{
+		{"pro-rata at the start", s, RebateProRata, start, interest},
+		{"pro-rata before the start", s, RebateProRata, start.AddDate(0, 0, -3), interest},
+		{"pro-rata a quarter in", s, RebateProRata, start.AddDate(0, 0, 22), 2169},
+		{"pro-rata half way", s, RebateProRata, start.AddDate(0, 0, 44), 1446},
+		{"pro-rata with a month left", s, RebateProRata, start.AddDate(0, 0, 61), 887},
+		{"pro-rata at the end", s, RebateProRata, start.AddDate(0, 0, 88), 0},
+		{"pro-rata after the end", s, RebateProRata, start.AddDate(0, 0, 100), 0},
+		{"pro-rata without a term", Schedule{StartsAt: start}, RebateProRata, start, 0},
+		{"rule of 78 at the start", s, RebateRuleOf78, start, interest},
+		{"rule of 78 a quarter in", s, RebateRuleOf78, start.AddDate(0, 0, 22), interest},
+		{"rule of 78 half way", s, RebateRuleOf78, start.AddDate(0, 0, 44), interest * 3 / 6},
+		{"rule of 78 with a month left", s, RebateRuleOf78, start.AddDate(0, 0, 61), interest * 1 / 6},
+		{"rule of 78 at the end", s, RebateRuleOf78, start.AddDate(0, 0, 88), 0},
+		{"rule of 78 without instalments", Schedule{StartsAt: start, Term: 88}, RebateRuleOf78, start, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.schedule.Rebate(interest, tt.method, tt.asOf); got != tt.want {
+			t.Errorf("%s: Rebate() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+}
@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"tujifund-app/backend/secrets"
+
+	"github.com/google/uuid"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "requestID"
+
+// RequestIDHeader is read from incoming requests and echoed on responses
+const RequestIDHeader = "X-Request-ID"
+
+// level is the minimum level logged; SetLevel changes it while running
+var level slog.LevelVar
+
+// Setup installs the default slog logger. JSON output is meant for production
+// log aggregation, text output for local development.
+func Setup(jsonOutput bool, l slog.Level) *slog.Logger {
+	level.Set(l)
+	logger := New(os.Stdout, jsonOutput)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// New returns a logger writing to w at the level Setup and SetLevel set,
+// adding the request ID from the context to every record
+func New(w io.Writer, jsonOutput bool) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: &level, ReplaceAttr: redactSecrets}
+
+	var handler slog.Handler
+	if jsonOutput {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(&contextHandler{Handler: handler})
+}
+
+// SetLevel changes the minimum level logged
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// redactSecrets masks resolved secrets wherever they turn up in a record,
+// e.g. in an error echoing a provider's reply
+func redactSecrets(groups []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(secrets.Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(secrets.Redact(err.Error()))
+		}
+	}
+	return a
+}
+
+// LevelFromEnv reads LOG_LEVEL: debug, info (the default), warn or error
+func LevelFromEnv() (slog.Level, error) {
+	var l slog.Level
+	value := os.Getenv("LOG_LEVEL")
+	if value == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(value)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid LOG_LEVEL %q", value)
+	}
+	return l, nil
+}
+
+// contextHandler adds the request ID from the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID stored in ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// statusRecorder captures the status code written by the next handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Middleware assigns a request ID (reusing the caller's X-Request-ID if present),
+// stores it in the request context and logs each completed request
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := WithRequestID(r.Context(), id)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		slog.InfoContext(ctx, "request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// Query logs a database query at debug level along with its duration and error
+func Query(ctx context.Context, query string, start time.Time, err error) {
+	attrs := []any{"query", query, "duration_ms", time.Since(start).Milliseconds()}
+	if err != nil {
+		slog.ErrorContext(ctx, "db query failed", append(attrs, "error", err)...)
+		return
+	}
+	slog.DebugContext(ctx, "db query", attrs...)
+}
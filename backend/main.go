@@ -1,15 +1,101 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"tujifund-app/backend/account"
+	"tujifund-app/backend/accounting"
+	"tujifund-app/backend/accruals"
+	"tujifund-app/backend/admin"
+	"tujifund-app/backend/analytics"
+	"tujifund-app/backend/apikeys"
+	"tujifund-app/backend/approvals"
+	"tujifund-app/backend/archival"
+	"tujifund-app/backend/arrears"
+	"tujifund-app/backend/auditors"
 	"tujifund-app/backend/auth"
+	"tujifund-app/backend/backup"
+	"tujifund-app/backend/billing"
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/cache"
+	"tujifund-app/backend/calendar"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/dashboard"
 	"tujifund-app/backend/database"
+	"tujifund-app/backend/database/querywatch"
+	"tujifund-app/backend/disbursements"
+	"tujifund-app/backend/discovery"
+	"tujifund-app/backend/documents"
+	"tujifund-app/backend/duplicates"
+	"tujifund-app/backend/etag"
+	"tujifund-app/backend/events"
+	"tujifund-app/backend/exits"
+	"tujifund-app/backend/expenses"
+	"tujifund-app/backend/export"
+	"tujifund-app/backend/fines"
+	"tujifund-app/backend/flags"
+	"tujifund-app/backend/fraud"
+	"tujifund-app/backend/funds"
+	"tujifund-app/backend/fx"
+	"tujifund-app/backend/goals"
+	"tujifund-app/backend/graphql"
+	"tujifund-app/backend/handover"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/imports"
+	"tujifund-app/backend/investments"
+	"tujifund-app/backend/invitations"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/kyc"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/lifecycle"
+	"tujifund-app/backend/loans"
+	"tujifund-app/backend/logging"
+	"tujifund-app/backend/maintenance"
+	"tujifund-app/backend/meetings"
+	"tujifund-app/backend/milestones"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/offline"
+	"tujifund-app/backend/onboarding"
+	"tujifund-app/backend/otp"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/phone"
+	"tujifund-app/backend/privacy"
+	"tujifund-app/backend/quota"
+	"tujifund-app/backend/ratelimit"
+	"tujifund-app/backend/readonly"
+	"tujifund-app/backend/receipts"
+	"tujifund-app/backend/referrals"
+	"tujifund-app/backend/reload"
+	"tujifund-app/backend/reminders"
+	"tujifund-app/backend/reports"
+	"tujifund-app/backend/rpc"
+	"tujifund-app/backend/rules"
+	"tujifund-app/backend/search"
+	"tujifund-app/backend/secrets"
+	"tujifund-app/backend/shares"
+	"tujifund-app/backend/signing"
+	"tujifund-app/backend/statements"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/tenancy"
+	"tujifund-app/backend/twofactor"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/umbrellas"
+	"tujifund-app/backend/ussd"
+	"tujifund-app/backend/validation"
+	"tujifund-app/backend/votes"
+	"tujifund-app/backend/wallets"
+	"tujifund-app/backend/yearend"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -19,62 +105,888 @@ import (
 )
 
 func main() {
-	// Configure SQLite database
-	config := database.DBConfig{
-		Driver: "sqlite",
-		DBName: "data/tujifund.db",
+	// Overlay the env file named by CONFIG_FILE, if any; it is read again on reload
+	if err := reload.Load(); err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		os.Exit(1)
 	}
+	// Swap secret references (file:, vault:, sops:) for the secrets themselves
+	if err := secrets.Resolve(context.Background()); err != nil {
+		slog.Error("Failed to resolve secrets", "error", err)
+		os.Exit(1)
+	}
+
+	// Configure structured logging; LOG_FORMAT=json for production log aggregation
+	logLevel, err := logging.LevelFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure logging", "error", err)
+		os.Exit(1)
+	}
+	logging.Setup(os.Getenv("LOG_FORMAT") == "json", logLevel)
+
+	// Configure the database: SQLite, or PostgreSQL with DB_DRIVER=postgres
+	config, err := database.ConfigFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure database", "error", err)
+		os.Exit(1)
+	}
+	config.TenantDatabases = os.Getenv("TENANT_DATABASES") == "true"
+	config.TenantDir = "data/tenants"
+	config.DetectNPlusOne = os.Getenv("DETECT_N_PLUS_ONE") == "true"
 
 	// Create new database instance
 	db, err := database.NewDBInstance(config)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		slog.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
-			log.Printf("Error closing database: %v", err)
+			slog.Error("Error closing database", "error", err)
 		}
 	}()
 
+	// Setting up the database at startup, and a chama's own database when it
+	// is first used, writes even in read-only maintenance mode
+	setup := readonly.Allow(context.Background())
+
+	// Bring tables created by an older SQLite schema up to date before the
+	// schema runs, as its indexes may use the columns added since
+	if config.Driver == "sqlite" {
+		if err := database.Upgrade(setup, db.GetDB()); err != nil {
+			slog.Error("Failed to upgrade database", "error", err)
+			os.Exit(1)
+		}
+	}
+
 	// Initialize database schema
 	if err := db.InitializeDatabase(); err != nil {
-		log.Printf("Failed to initialize database: %v", err)
-		log.Println("Attempting to continue with existing schema...")
+		slog.Error("Failed to initialize database", "error", err)
+		slog.Info("Attempting to continue with existing schema...")
+	}
+
+	// Read-only maintenance mode, for migrations and backup restores, is held
+	// on by READ_ONLY=true or switched by platform staff
+	if err := readonly.FromEnv(); err != nil {
+		slog.Error("Failed to configure read-only mode", "error", err)
+		os.Exit(1)
 	}
 
+	// Each chama's data is kept apart: requests to {chamaId} routes are scoped
+	// to that chama, and chamas isolated by platform staff are served from
+	// a database of their own when TENANT_DATABASES=true
+	tenants := tenancy.NewTenants(db.GetDB(), config.TenantDatabases, func(chamaID string) (*sql.DB, error) {
+		tenant, err := database.NewDBInstance(database.DBConfig{
+			Driver:         "sqlite",
+			SQLitePath:     filepath.Join(config.TenantDir, chamaID+".db"),
+			DetectNPlusOne: config.DetectNPlusOne,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := database.Upgrade(setup, tenant.GetDB()); err != nil {
+			return nil, err
+		}
+		if err := tenant.InitializeDatabase(); err != nil {
+			return nil, err
+		}
+		if err := ledger.EnsureBalances(setup, tenant.GetDB()); err != nil {
+			return nil, err
+		}
+		return tenant.GetDB(), nil
+	})
+	defer tenants.Close()
+
 	// Create router
 	router := mux.NewRouter()
+	router.Use(readonly.Middleware)
+	router.Use(tenancy.Middleware)
+	router.Use(lifecycle.ReadOnly(db.GetDB()))
+	router.Use(billing.Enforce(db.GetDB()))
 
 	// Add CORS middleware
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173", "http://localhost:3000"}, // Allow both dev servers
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "If-None-Match"},
+		ExposedHeaders:   []string{"ETag"},
 		AllowCredentials: true,
 	})
 
-	// Wrap router with CORS
-	handler := c.Handler(router)
+	// Wrap router with CORS and request logging, and in development count
+	// each request's queries to catch N+1 patterns
+	var handler http.Handler = c.Handler(router)
+	if config.DetectNPlusOne {
+		handler = querywatch.Middleware(handler)
+	}
+	handler = logging.Middleware(handler)
 
 	// API endpoints
+	router.HandleFunc("/api/health", maintenance.HealthHandler(db.GetDB())).Methods("GET")
 	router.HandleFunc("/api/users", getUsersHandler(db)).Methods("GET")
 	// router.HandleFunc("/api/chamas", getChamasHandler(db)).Methods("GET")
 	// Add more endpoints as needed
 
+	// Rate limiters for endpoints that are attractive to brute force. Each
+	// group of endpoints has its own limiter, so that using one does not use
+	// up another's allowance, and endpoints that send codes have the OTP limit.
+	if err := ratelimit.SetTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		slog.Error("Failed to configure trusted proxies", "error", err)
+		os.Exit(1)
+	}
+	limiterStore, err := ratelimit.NewStoreFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure rate limits", "error", err)
+		os.Exit(1)
+	}
+	authLimits, err := ratelimit.ConfigFromEnv("RATE_LIMIT_AUTH", ratelimit.AuthConfig)
+	if err != nil {
+		slog.Error("Failed to configure rate limits", "error", err)
+		os.Exit(1)
+	}
+	otpLimits, err := ratelimit.ConfigFromEnv("RATE_LIMIT_OTP", ratelimit.OTPConfig)
+	if err != nil {
+		slog.Error("Failed to configure rate limits", "error", err)
+		os.Exit(1)
+	}
+	var authLimiters, otpLimiters []*ratelimit.Limiter
+	authLimiter := func(name string) *ratelimit.Limiter {
+		l := ratelimit.New(name, authLimits, limiterStore)
+		authLimiters = append(authLimiters, l)
+		return l
+	}
+	otpLimiter := func(name string) *ratelimit.Limiter {
+		l := ratelimit.New(name, otpLimits, limiterStore)
+		otpLimiters = append(otpLimiters, l)
+		return l
+	}
+
+	// Login tokens are signed with rotating keys published for other services
+	signingKeys := signing.New(db.GetDB())
+	if err := signingKeys.Ensure(setup); err != nil {
+		slog.Error("Failed to set up token signing keys", "error", err)
+		os.Exit(1)
+	}
+	router.HandleFunc("/.well-known/jwks.json", signing.JWKSHandler(signingKeys)).Methods("GET")
+
 	// Add authentication endpoints
-	router.HandleFunc("/api/register", registerHandler(db)).Methods("POST")
-	router.HandleFunc("/api/login", loginHandler(db)).Methods("POST")
-	router.HandleFunc("/api/verify", verifyHandler(db)).Methods("POST")
+	router.HandleFunc("/api/register", ratelimit.PerIP(authLimiter("register"), registerHandler(db))).Methods("POST")
+	router.HandleFunc("/api/login", ratelimit.PerIP(authLimiter("login"), ratelimit.PerEmail(authLimiter("login_email"), loginHandler(db, signingKeys)))).Methods("POST")
+	router.HandleFunc("/api/verify", ratelimit.PerIP(authLimiter("verify"), verifyHandler(db))).Methods("POST")
 	router.HandleFunc("/api/user/profile", HandleUserProfile(db)).Methods("GET")
 	router.HandleFunc("/auth/google/signin", auth.HandleGoogleLogin)
 	router.HandleFunc("/auth/callback", auth.HandleGoogleCallback)
 	router.HandleFunc("/api/protected", sessionMiddleware(db, protectedHandler)).Methods("GET")
 
+	// File storage for receipts, minutes, KYC documents and constitutions
+	store, err := storage.NewFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure file storage", "error", err)
+		os.Exit(1)
+	}
+	if local, ok := store.(*storage.Local); ok {
+		router.HandleFunc("/api/files", local.DownloadHandler()).Methods("GET")
+	}
+
+	// Continuous replication of the database to storage for point-in-time
+	// recovery; restore with cmd/restore
+	replicator, err := backup.FromEnv(config.DBName, store)
+	if err != nil {
+		slog.Error("Failed to configure database replication", "error", err)
+		os.Exit(1)
+	}
+	if replicator != nil {
+		if err := backup.DisableAutoCheckpoint(db.GetDB()); err != nil {
+			slog.Error("Failed to configure database replication", "error", err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := replicator.Run(context.Background()); err != nil {
+				slog.Error("Database replication stopped", "error", err)
+			}
+		}()
+	}
+
+	// Shared cache for hot reads; postings invalidate the chama's cached data
+	appCache, err := cache.NewFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure cache", "error", err)
+		os.Exit(1)
+	}
+	ledger.OnPost = append(ledger.OnPost, func(ctx context.Context, e ledger.Entry) {
+		cache.InvalidateChama(ctx, appCache, e.ChamaID)
+	})
+	// With event sourcing the ledger is derived from an append-only event stream
+	ledger.EventSourcing = os.Getenv("LEDGER_EVENT_SOURCING") == "true"
+	// Monthly balances for ledgers posted before they were kept
+	if err := ledger.EnsureBalances(tenancy.WithAllChamas(setup), db.GetDB()); err != nil {
+		slog.Error("Failed to compute monthly ledger balances", "error", err)
+	}
+
+	// Payment and loan events for external systems, published to the configured broker from the outbox
+	eventRelay, err := events.FromEnv(db.GetDB())
+	if err != nil {
+		slog.Error("Failed to configure event publishing", "error", err)
+		os.Exit(1)
+	}
+	if eventRelay != nil {
+		events.RegisterLedgerEvents()
+		go func() {
+			if err := eventRelay.Run(tenancy.WithAllChamas(context.Background())); err != nil {
+				slog.Error("Event publishing stopped", "error", err)
+			}
+		}()
+	}
+
+	// In-app notifications, also delivered through the configured channels as each user prefers
+	notifier := notifications.New(db.GetDB(), notifications.LogChannel{})
+	router.HandleFunc("/api/notifications", sessionMiddleware(db, notifications.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/notifications/preferences", sessionMiddleware(db, notifications.PreferencesHandler(notifier))).Methods("GET")
+	router.HandleFunc("/api/notifications/preferences", sessionMiddleware(db, notifications.UpdatePreferencesHandler(notifier))).Methods("PUT")
+	router.HandleFunc("/api/notifications/{id}/read", sessionMiddleware(db, notifications.MarkReadHandler(db.GetDB()))).Methods("POST")
+
+	// Receipt endpoints; receipts are issued with receipts.Issue when a payment is confirmed
+	router.HandleFunc("/api/receipts", sessionMiddleware(db, receipts.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/receipts/{id}/download", sessionMiddleware(db, receipts.DownloadHandler(db.GetDB(), store))).Methods("GET")
+
+	// API keys and OAuth2 client credentials for SACCO partners' systems, with per-key rate limits and metering
+	apiKeys := apikeys.NewGate(db.GetDB(), nil)
+	partner := func(scope string, h http.HandlerFunc) http.HandlerFunc {
+		return apiKeys.Allow(scope, h, sessionMiddleware(db, h))
+	}
+	router.HandleFunc("/oauth/token", ratelimit.PerIP(authLimiter("oauth_token"), apikeys.TokenHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/api-keys", sessionMiddleware(db, twofactor.Require(db.GetDB(), txn.Middleware(db.GetDB(), apikeys.CreateHandler(db.GetDB()))))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/api-keys", sessionMiddleware(db, apikeys.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/api-keys/{keyId}/revoke", sessionMiddleware(db, txn.Middleware(db.GetDB(), apikeys.RevokeHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/api-keys/{keyId}/usage", sessionMiddleware(db, apikeys.UsageHandler(db.GetDB()))).Methods("GET")
+
+	// Statements and financial reports. Heavy reads here and below carry ETags so the app can revalidate.
+	router.HandleFunc("/api/chamas/{chamaId}/members/{userId}/statement", sessionMiddleware(db, etag.Handler(reports.MemberStatementHandler(db.GetDB(), appCache)))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/reports/financial", partner(apikeys.ScopeReportsRead, reports.ChamaReportHandler(db.GetDB(), appCache))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/statement", sessionMiddleware(db, etag.Handler(reports.AccountStatementHandler(db.GetDB(), appCache)))).Methods("GET")
+
+	// Transaction and member lists with CSV/XLSX exports, served from the chama's own database if it has one.
+	// Partners can also call these with an API key or OAuth2 access token holding the route's scope.
+	router.HandleFunc("/api/chamas/{chamaId}/transactions", partner(apikeys.ScopeTransactionsRead, tenants.Handler(ledger.ListHandler))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/transactions/export", partner(apikeys.ScopeTransactionsRead, tenants.Handler(export.TransactionsHandler))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/contributions/export", partner(apikeys.ScopeContributionsRead, tenants.Handler(export.ContributionsHandler))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/members", etag.Handler(partner(apikeys.ScopeMembersRead, tenants.Handler(chamas.MembersHandler)))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/members/export", partner(apikeys.ScopeMembersRead, tenants.Handler(export.MembersHandler))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/accounting/accounts", sessionMiddleware(db, accounting.AccountsHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/accounting/accounts", sessionMiddleware(db, accounting.SetAccountsHandler(db.GetDB()))).Methods("PUT")
+	router.HandleFunc("/api/chamas/{chamaId}/accounting/export", sessionMiddleware(db, accounting.ExportHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/accounting/exports", sessionMiddleware(db, accounting.ExportsHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/archives", sessionMiddleware(db, archival.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/ledger/verify", sessionMiddleware(db, ledger.VerifyHandler(db.GetDB()))).Methods("GET")
+
+	// Year-end closing: locks the year, carries balances forward and queues the annual reports
+	router.HandleFunc("/api/chamas/{chamaId}/financial-years", sessionMiddleware(db, yearend.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/financial-years/close", sessionMiddleware(db, twofactor.Require(db.GetDB(), yearend.CloseHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/financial-years/{yearId}", sessionMiddleware(db, yearend.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/financial-years/{yearId}/reopen", sessionMiddleware(db, yearend.ReopenHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/financial-years/{yearId}/reopen/approve", sessionMiddleware(db, twofactor.Require(db.GetDB(), yearend.ApproveReopenHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/financial-years/{yearId}/reopen/reject", sessionMiddleware(db, yearend.RejectReopenHandler(db.GetDB()))).Methods("POST")
+
+	// Period locks: reconciled months refuse backdated postings until an official unlocks them
+	router.HandleFunc("/api/chamas/{chamaId}/period-locks", sessionMiddleware(db, ledger.PeriodLocksHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/period-locks", sessionMiddleware(db, ledger.LockPeriodHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/period-locks/{period}/unlock", sessionMiddleware(db, twofactor.Require(db.GetDB(), ledger.UnlockPeriodHandler(db.GetDB())))).Methods("POST")
+
+	// Bulk imports
+	router.HandleFunc("/api/chamas/{chamaId}/members/import", sessionMiddleware(db, imports.ImportMembersHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/history/import", sessionMiddleware(db, twofactor.Require(db.GetDB(), imports.ImportHistoryHandler(db.GetDB())))).Methods("POST")
+
+	// KYC endpoints
+	router.HandleFunc("/api/kyc", sessionMiddleware(db, kyc.StatusHandler(db.GetDB(), store))).Methods("GET")
+	router.HandleFunc("/api/kyc/documents", sessionMiddleware(db, kyc.UploadDocumentHandler(db.GetDB(), store))).Methods("POST")
+	router.HandleFunc("/api/kyc/{userId}/review", sessionMiddleware(db, twofactor.Require(db.GetDB(), kyc.ReviewHandler(db.GetDB(), store)))).Methods("POST")
+
+	// Meetings
+	router.HandleFunc("/api/chamas/{chamaId}/meetings", sessionMiddleware(db, meetings.CreateHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/meetings", sessionMiddleware(db, meetings.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/meetings/{meetingId}", sessionMiddleware(db, meetings.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/meetings/{meetingId}/rsvp", sessionMiddleware(db, meetings.RSVPHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/meetings/{meetingId}/attendance", sessionMiddleware(db, meetings.AttendanceHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/meetings/{meetingId}/complete", sessionMiddleware(db, meetings.CompleteHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/meetings/{meetingId}/cancel", sessionMiddleware(db, meetings.CancelHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/meetings/{meetingId}/minutes", sessionMiddleware(db, meetings.MinutesHandler(db.GetDB(), store, notifier))).Methods("POST")
+	router.HandleFunc("/api/meetings/{meetingId}/minutes/{minutesId}/publish", sessionMiddleware(db, meetings.PublishMinutesHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/meetings/{meetingId}/minutes/{minutesId}/download", sessionMiddleware(db, meetings.MinutesDownloadHandler(db.GetDB(), store))).Methods("GET")
+	router.HandleFunc("/api/meetings/{meetingId}/pack", sessionMiddleware(db, meetings.PackHandler(db.GetDB()))).Methods("GET")
+
+	// Document vault
+	router.HandleFunc("/api/chamas/{chamaId}/documents", sessionMiddleware(db, documents.CreateHandler(db.GetDB(), store))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/documents", sessionMiddleware(db, documents.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/documents/{documentId}", sessionMiddleware(db, documents.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/documents/{documentId}", sessionMiddleware(db, documents.UpdateHandler(db.GetDB()))).Methods("PUT")
+	router.HandleFunc("/api/documents/{documentId}", sessionMiddleware(db, documents.ArchiveHandler(db.GetDB()))).Methods("DELETE")
+	router.HandleFunc("/api/documents/{documentId}/versions", sessionMiddleware(db, documents.AddVersionHandler(db.GetDB(), store))).Methods("POST")
+	router.HandleFunc("/api/documents/{documentId}/versions/{version}/download", sessionMiddleware(db, documents.DownloadHandler(db.GetDB(), store))).Methods("GET")
+
+	// Fines
+	router.HandleFunc("/api/chamas/{chamaId}/fines", sessionMiddleware(db, fines.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/fines/{id}/pay", sessionMiddleware(db, fines.PayHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/fines/{id}/waive", sessionMiddleware(db, twofactor.Require(db.GetDB(), fines.WaiveHandler(db.GetDB())))).Methods("POST")
+
+	// Voting
+	router.HandleFunc("/api/chamas/{chamaId}/voting-settings", sessionMiddleware(db, votes.SettingsHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/voting-settings", sessionMiddleware(db, twofactor.Require(db.GetDB(), votes.UpdateSettingsHandler(db.GetDB())))).Methods("PUT")
+	router.HandleFunc("/api/chamas/{chamaId}/votes", sessionMiddleware(db, votes.CreateHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/votes", sessionMiddleware(db, votes.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/votes/{voteId}", sessionMiddleware(db, votes.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/votes/{voteId}/ballot", sessionMiddleware(db, votes.CastHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/votes/{voteId}/cancel", sessionMiddleware(db, votes.CancelHandler(db.GetDB()))).Methods("POST")
+
+	// Chama rules; rule changes can be put to a vote
+	rules.RegisterVoteHook()
+	router.HandleFunc("/api/chamas/{chamaId}/rules", sessionMiddleware(db, rules.CurrentHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/rules", sessionMiddleware(db, twofactor.Require(db.GetDB(), rules.PublishHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/rules/history", sessionMiddleware(db, rules.HistoryHandler(db.GetDB()))).Methods("GET")
+
+	// Business calendar: contribution and loan due dates are moved off
+	// weekends and public holidays, and each chama may open or close days
+	router.HandleFunc("/api/holidays", sessionMiddleware(db, calendar.HolidaysHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/admin/holidays/{date}", sessionMiddleware(db, admin.Require(db.GetDB(), "holidays.set", calendar.SetHolidayHandler(db.GetDB())))).Methods("PUT")
+	router.HandleFunc("/api/admin/holidays/{date}", sessionMiddleware(db, admin.Require(db.GetDB(), "holidays.remove", calendar.RemoveHolidayHandler(db.GetDB())))).Methods("DELETE")
+	router.HandleFunc("/api/chamas/{chamaId}/calendar", sessionMiddleware(db, calendar.ChamaHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/calendar", sessionMiddleware(db, calendar.UpdateChamaHandler(db.GetDB()))).Methods("PUT")
+	router.HandleFunc("/api/chamas/{chamaId}/calendar/days/{date}", sessionMiddleware(db, calendar.SetDayHandler(db.GetDB()))).Methods("PUT")
+	router.HandleFunc("/api/chamas/{chamaId}/calendar/days/{date}", sessionMiddleware(db, calendar.RemoveDayHandler(db.GetDB()))).Methods("DELETE")
+
+	// Approval tiers for spending; expenses and payouts above a tier's amount
+	// need more officials, or a vote of the members, to approve them
+	approvals.RegisterVoteHook()
+	expenses.RegisterApprovalHook(notifier)
+	disbursements.RegisterApprovalHook(notifier)
+	router.HandleFunc("/api/chamas/{chamaId}/approvals", sessionMiddleware(db, approvals.ListHandler(db.GetDB()))).Methods("GET")
+
+	// Savings goals
+	router.HandleFunc("/api/chamas/{chamaId}/goals", sessionMiddleware(db, goals.CreateHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/goals", sessionMiddleware(db, goals.ProgressHandler(db.GetDB(), notifier))).Methods("GET")
+	router.HandleFunc("/api/goals/{goalId}/cancel", sessionMiddleware(db, goals.CancelHandler(db.GetDB()))).Methods("POST")
+
+	// Investments; their valuations are included in chama balance sheets
+	investments.RegisterAssetSource()
+	router.HandleFunc("/api/chamas/{chamaId}/investments", sessionMiddleware(db, investments.CreateHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/investments", sessionMiddleware(db, investments.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/investments/{investmentId}", sessionMiddleware(db, investments.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/investments/{investmentId}/valuations", sessionMiddleware(db, investments.RevalueHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/investments/{investmentId}/transactions", sessionMiddleware(db, investments.TransactionHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/investments/{investmentId}/dispose", sessionMiddleware(db, twofactor.Require(db.GetDB(), investments.DisposeHandler(db.GetDB())))).Methods("POST")
+
+	// Expenses and budgets
+	router.HandleFunc("/api/chamas/{chamaId}/expenses", sessionMiddleware(db, expenses.CreateHandler(db.GetDB(), store, notifier))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/expenses", sessionMiddleware(db, expenses.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/budgets", sessionMiddleware(db, expenses.BudgetsHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/budgets", sessionMiddleware(db, twofactor.Require(db.GetDB(), expenses.SetBudgetHandler(db.GetDB())))).Methods("PUT")
+	router.HandleFunc("/api/expenses/{expenseId}/approve", sessionMiddleware(db, twofactor.Require(db.GetDB(), expenses.ApproveHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/expenses/{expenseId}/reject", sessionMiddleware(db, expenses.RejectHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/expenses/{expenseId}/attachment", sessionMiddleware(db, expenses.AttachmentHandler(db.GetDB(), store))).Methods("GET")
+
+	// Funds (chama accounts) and transfers between them
+	router.HandleFunc("/api/chamas/{chamaId}/funds", sessionMiddleware(db, funds.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/funds", sessionMiddleware(db, funds.CreateHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/funds/{fundId}/rules", sessionMiddleware(db, twofactor.Require(db.GetDB(), funds.UpdateRulesHandler(db.GetDB())))).Methods("PUT")
+	router.HandleFunc("/api/funds/{fundId}/close", sessionMiddleware(db, twofactor.Require(db.GetDB(), funds.CloseHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/transfers", sessionMiddleware(db, twofactor.Require(db.GetDB(), funds.TransferHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/transfers", sessionMiddleware(db, funds.ListTransfersHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/transfers/{transferId}/approve", sessionMiddleware(db, twofactor.Require(db.GetDB(), funds.ApproveTransferHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/transfers/{transferId}/reject", sessionMiddleware(db, funds.RejectTransferHandler(db.GetDB()))).Methods("POST")
+
+	// Umbrella organisations running several chamas: consolidated reports and transfers between their chamas
+	router.HandleFunc("/api/umbrellas", sessionMiddleware(db, umbrellas.CreateHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/umbrellas", sessionMiddleware(db, umbrellas.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/umbrellas/{umbrellaId}", sessionMiddleware(db, umbrellas.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/umbrellas/{umbrellaId}/admins", sessionMiddleware(db, umbrellas.AddAdminHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/umbrellas/{umbrellaId}/chamas", sessionMiddleware(db, umbrellas.AddChamaHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/umbrellas/{umbrellaId}/chamas/{chamaId}", sessionMiddleware(db, umbrellas.RemoveChamaHandler(db.GetDB()))).Methods("DELETE")
+	router.HandleFunc("/api/umbrellas/{umbrellaId}/report", sessionMiddleware(db, umbrellas.ReportHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/umbrellas/{umbrellaId}/transfers", sessionMiddleware(db, twofactor.Require(db.GetDB(), umbrellas.TransferHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/umbrellas/{umbrellaId}/transfers", sessionMiddleware(db, umbrellas.ListTransfersHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/umbrella-transfers/{transferId}/approve", sessionMiddleware(db, twofactor.Require(db.GetDB(), umbrellas.ApproveTransferHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/umbrella-transfers/{transferId}/reject", sessionMiddleware(db, umbrellas.RejectTransferHandler(db.GetDB()))).Methods("POST")
+
+	// Invitations and join requests. Codes are sent by SMS; LogSender logs them until an SMS gateway is configured.
+	// SMS and email go through breakers so that a gateway outage fails fast instead of holding requests open.
+	smsSender := billing.MeterSMS(db.GetDB(), quota.GuardSMS(db.GetDB(), otp.Guard(otp.LogSender{}, breaker.New("sms", breaker.Config{Rate: ratelimit.Config{Rate: 10, Burst: 20}}))))
+	router.HandleFunc("/api/chamas/{chamaId}/invitations", sessionMiddleware(db, twofactor.Require(db.GetDB(), invitations.CreateHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/invitations", sessionMiddleware(db, invitations.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/invitations/{invitationId}/revoke", sessionMiddleware(db, invitations.RevokeHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/invitations/{code}", ratelimit.PerIP(authLimiter("invitation_preview"), invitations.PreviewHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/invitations/{code}/accept", sessionMiddleware(db, invitations.AcceptHandler(db.GetDB(), smsSender))).Methods("POST")
+	router.HandleFunc("/api/join-requests", sessionMiddleware(db, invitations.MyRequestsHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/join-requests/{requestId}/verify", sessionMiddleware(db, invitations.VerifyHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/join-requests/{requestId}/resend", sessionMiddleware(db, invitations.ResendHandler(db.GetDB(), smsSender))).Methods("POST")
+	router.HandleFunc("/api/join-requests/{requestId}/decide", sessionMiddleware(db, txn.Middleware(db.GetDB(), invitations.DecideHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/join-requests", sessionMiddleware(db, invitations.RequestsHandler(db.GetDB()))).Methods("GET")
+
+	// Onboarding wizard: create a chama, set its rules, invite members and connect its paybill, then go live
+	router.HandleFunc("/api/onboarding/chamas", sessionMiddleware(db, onboarding.CreateHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/onboarding", sessionMiddleware(db, onboarding.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/onboarding/steps/{step}", sessionMiddleware(db, onboarding.CompleteStepHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/onboarding/go-live", sessionMiddleware(db, onboarding.GoLiveHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/paybill", sessionMiddleware(db, twofactor.Require(db.GetDB(), onboarding.PaybillHandler(db.GetDB())))).Methods("PUT")
+
+	// Chama discovery: public profiles people can search and ask to join from
+	router.HandleFunc("/api/discover/chamas", sessionMiddleware(db, discovery.SearchHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/discover/chamas/{chamaId}", sessionMiddleware(db, discovery.ProfileHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/discover/chamas/{chamaId}/join", sessionMiddleware(db, ratelimit.PerUser(authLimiter("discovery_join"), discovery.JoinHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/profile", sessionMiddleware(db, discovery.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/profile", sessionMiddleware(db, discovery.SetHandler(db.GetDB()))).Methods("PUT")
+
+	// Two-factor authentication. Routes wrapped in twofactor.Require ask for a second factor.
+	router.HandleFunc("/api/2fa", sessionMiddleware(db, twofactor.StatusHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/2fa/setup", sessionMiddleware(db, twofactor.SetupHandler(db.GetDB(), smsSender))).Methods("POST")
+	twoFactorLimiter := authLimiter("2fa")
+	router.HandleFunc("/api/2fa/enable", sessionMiddleware(db, ratelimit.PerUser(twoFactorLimiter, twofactor.EnableHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/2fa/sms", sessionMiddleware(db, twofactor.SendCodeHandler(db.GetDB(), smsSender))).Methods("POST")
+	router.HandleFunc("/api/2fa/verify", sessionMiddleware(db, ratelimit.PerUser(twoFactorLimiter, twofactor.VerifyHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/2fa/recovery-codes", sessionMiddleware(db, twofactor.Require(db.GetDB(), twofactor.RecoveryCodesHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/2fa/disable", sessionMiddleware(db, ratelimit.PerUser(twoFactorLimiter, twofactor.DisableHandler(db.GetDB())))).Methods("POST")
+
+	// Password reset and phone number changes, confirmed with one-time codes
+	mailer := otp.Guard(otp.LogMailer{}, breaker.New("email", breaker.Config{Rate: ratelimit.Config{Rate: 5, Burst: 10}}))
+	router.HandleFunc("/api/password/forgot", ratelimit.PerIP(otpLimiter("password_forgot"), account.ForgotPasswordHandler(db.GetDB(), mailer, smsSender))).Methods("POST")
+	router.HandleFunc("/api/password/reset", ratelimit.PerIP(authLimiter("password_reset"), account.ResetPasswordHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/account/phone", sessionMiddleware(db, twofactor.Require(db.GetDB(), ratelimit.PerUser(otpLimiter("phone_change"), account.PhoneChangeHandler(db.GetDB(), smsSender))))).Methods("POST")
+	router.HandleFunc("/api/account/phone/confirm", sessionMiddleware(db, ratelimit.PerUser(authLimiter("phone_confirm"), account.ConfirmPhoneHandler(db.GetDB(), smsSender)))).Methods("POST")
+
+	// Signed-in devices and password changes
+	router.HandleFunc("/api/logout", sessionMiddleware(db, account.LogoutHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/sessions", sessionMiddleware(db, account.SessionsHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/sessions/{sessionId}", sessionMiddleware(db, account.RevokeSessionHandler(db.GetDB()))).Methods("DELETE")
+	router.HandleFunc("/api/sessions/revoke-others", sessionMiddleware(db, account.RevokeOtherSessionsHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/account/password", sessionMiddleware(db, ratelimit.PerUser(authLimiter("password_change"), account.ChangePasswordHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/account/birthday", sessionMiddleware(db, account.BirthdayHandler(db.GetDB()))).Methods("PUT")
+	router.HandleFunc("/api/account/data-export", sessionMiddleware(db, privacy.ExportHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/account/erasure", sessionMiddleware(db, privacy.ErasureHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/account/erasure", sessionMiddleware(db, twofactor.Require(db.GetDB(), privacy.EraseHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/account/recover", ratelimit.PerIP(authLimiter("account_recover"), privacy.RecoverHandler(db.GetDB()))).Methods("POST")
+
+	// Back office for platform staff (users.role = 'admin'). Every call is audited.
+	router.HandleFunc("/api/admin/users", sessionMiddleware(db, admin.Require(db.GetDB(), "users.search", admin.UsersHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/users/{userId}/suspend", sessionMiddleware(db, admin.Require(db.GetDB(), "users.suspend", admin.SuspendHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/admin/users/{userId}/unsuspend", sessionMiddleware(db, admin.Require(db.GetDB(), "users.unsuspend", admin.UnsuspendHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/admin/users/{userId}/duplicates", sessionMiddleware(db, admin.Require(db.GetDB(), "users.duplicates", duplicates.CandidatesHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/users/{userId}/merge", sessionMiddleware(db, admin.Require(db.GetDB(), "users.merge", twofactor.Require(db.GetDB(), duplicates.MergeHandler(db.GetDB()))))).Methods("POST")
+	router.HandleFunc("/api/admin/duplicates", sessionMiddleware(db, admin.Require(db.GetDB(), "duplicates.list", duplicates.ListHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/duplicates/{duplicateId}/dismiss", sessionMiddleware(db, admin.Require(db.GetDB(), "duplicates.dismiss", duplicates.DismissHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/admin/users/{userId}/erase", sessionMiddleware(db, admin.Require(db.GetDB(), "users.erase", twofactor.Require(db.GetDB(), privacy.AdminEraseHandler(db.GetDB(), store))))).Methods("POST")
+	router.HandleFunc("/api/admin/chamas", sessionMiddleware(db, admin.Require(db.GetDB(), "chamas.search", admin.ChamasHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/chamas/{chamaId}/archives", sessionMiddleware(db, admin.Require(db.GetDB(), "chamas.archive_ledger", archival.RunHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/admin/chamas/{chamaId}/ledger/events", sessionMiddleware(db, admin.Require(db.GetDB(), "chamas.ledger_events", ledger.EventsHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/chamas/{chamaId}/ledger/rebuild", sessionMiddleware(db, admin.Require(db.GetDB(), "chamas.rebuild_ledger", twofactor.Require(db.GetDB(), ledger.RebuildHandler(db.GetDB()))))).Methods("POST")
+	router.HandleFunc("/api/admin/chamas/{chamaId}/isolate", sessionMiddleware(db, admin.Require(db.GetDB(), "chamas.isolate", twofactor.Require(db.GetDB(), tenancy.IsolateHandler(tenants))))).Methods("POST")
+	router.HandleFunc("/api/admin/metrics", sessionMiddleware(db, admin.Require(db.GetDB(), "metrics", admin.MetricsHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/payment-callbacks", sessionMiddleware(db, admin.Require(db.GetDB(), "callbacks.list", admin.CallbacksHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/payment-callbacks/{callbackId}/retry", sessionMiddleware(db, admin.Require(db.GetDB(), "callbacks.retry", admin.RetryCallbackHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/admin/audit-logs", sessionMiddleware(db, admin.Require(db.GetDB(), "audit.search", admin.AuditLogsHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/providers", sessionMiddleware(db, admin.Require(db.GetDB(), "providers.status", breaker.StatusHandler()))).Methods("GET")
+	router.HandleFunc("/api/admin/signing-keys", sessionMiddleware(db, admin.Require(db.GetDB(), "signing_keys.list", signing.ListHandler(signingKeys)))).Methods("GET")
+	router.HandleFunc("/api/admin/signing-keys/rotate", sessionMiddleware(db, admin.Require(db.GetDB(), "signing_keys.rotate", twofactor.Require(db.GetDB(), signing.RotateHandler(signingKeys))))).Methods("POST")
+	router.HandleFunc("/api/admin/secrets", sessionMiddleware(db, admin.Require(db.GetDB(), "secrets.list", secrets.SourcesHandler()))).Methods("GET")
+	router.HandleFunc(readonly.AdminPath, sessionMiddleware(db, admin.Require(db.GetDB(), "maintenance.read_only", readonly.StatusHandler()))).Methods("GET")
+	router.HandleFunc(readonly.AdminPath, sessionMiddleware(db, admin.Require(db.GetDB(), "maintenance.read_only", readonly.SetHandler()))).Methods("PUT")
+	router.HandleFunc("/api/admin/config/reload", sessionMiddleware(db, admin.Require(db.GetDB(), "config.reload", twofactor.Require(db.GetDB(), reload.Handler(db.GetDB()))))).Methods("POST")
+	router.HandleFunc("/api/admin/events", sessionMiddleware(db, admin.Require(db.GetDB(), "events.status", events.StatusHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/fraud-alerts", sessionMiddleware(db, admin.Require(db.GetDB(), "fraud_alerts.list", fraud.AdminListHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/fraud-alerts/{alertId}/review", sessionMiddleware(db, admin.Require(db.GetDB(), "fraud_alerts.review", fraud.AdminReviewHandler(db.GetDB())))).Methods("POST")
+
+	// Referral programme: codes, attributed sign-ups and rewards earned by referrers
+	router.HandleFunc("/api/referrals", sessionMiddleware(db, referrals.MineHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/admin/referrals/report", sessionMiddleware(db, admin.Require(db.GetDB(), "referrals.report", referrals.ReportHandler(db.GetDB())))).Methods("GET")
+
+	// Platform fees: usage is metered per chama and invoiced monthly; chamas with overdue invoices are suspended
+	router.HandleFunc("/api/chamas/{chamaId}/billing/usage", sessionMiddleware(db, billing.UsageHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/billing/invoices", sessionMiddleware(db, billing.InvoicesHandler(db.GetDB()))).Methods("GET")
+	// Plan limits: officials see their chama's usage, platform staff move
+	// chamas between plans and override limits
+	router.HandleFunc("/api/chamas/{chamaId}/quota", sessionMiddleware(db, quota.StatusHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/admin/chamas/{chamaId}/quota", sessionMiddleware(db, admin.Require(db.GetDB(), "quota.view", quota.AdminStatusHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/chamas/{chamaId}/quota/plan", sessionMiddleware(db, admin.Require(db.GetDB(), "quota.set_plan", txn.Middleware(db.GetDB(), quota.SetPlanHandler(db.GetDB()))))).Methods("PUT")
+	router.HandleFunc("/api/admin/chamas/{chamaId}/quota/overrides/{limit}", sessionMiddleware(db, admin.Require(db.GetDB(), "quota.override", txn.Middleware(db.GetDB(), quota.SetOverrideHandler(db.GetDB()))))).Methods("PUT")
+	router.HandleFunc("/api/admin/chamas/{chamaId}/quota/overrides/{limit}", sessionMiddleware(db, admin.Require(db.GetDB(), "quota.override", txn.Middleware(db.GetDB(), quota.RemoveOverrideHandler(db.GetDB()))))).Methods("DELETE")
+	router.HandleFunc("/api/admin/invoices", sessionMiddleware(db, admin.Require(db.GetDB(), "invoices.list", billing.AdminInvoicesHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/invoices/{invoiceId}/pay", sessionMiddleware(db, admin.Require(db.GetDB(), "invoices.pay", txn.Middleware(db.GetDB(), billing.PayHandler(db.GetDB()))))).Methods("POST")
+	router.HandleFunc("/api/admin/invoices/{invoiceId}/void", sessionMiddleware(db, admin.Require(db.GetDB(), "invoices.void", twofactor.Require(db.GetDB(), txn.Middleware(db.GetDB(), billing.VoidHandler(db.GetDB())))))).Methods("POST")
+
+	// Feature flags, evaluated in handlers with featureFlags.Enabled and managed by platform staff
+	featureFlags := flags.New(db.GetDB(), flags.DefaultTTL)
+	router.HandleFunc("/api/flags", sessionMiddleware(db, flags.StateHandler(featureFlags))).Methods("GET")
+	router.HandleFunc("/api/admin/flags", sessionMiddleware(db, admin.Require(db.GetDB(), "flags.list", flags.ListHandler(featureFlags)))).Methods("GET")
+	router.HandleFunc("/api/admin/flags/{key}", sessionMiddleware(db, admin.Require(db.GetDB(), "flags.set", flags.SetHandler(featureFlags)))).Methods("PUT")
+	router.HandleFunc("/api/admin/flags/{key}", sessionMiddleware(db, admin.Require(db.GetDB(), "flags.delete", flags.DeleteHandler(featureFlags)))).Methods("DELETE")
+
+	// Milestone celebrations, whose rules are managed by platform staff
+	if err := milestones.EnsureDefaults(tenancy.WithAllChamas(setup), db.GetDB()); err != nil {
+		slog.Error("Failed to add default milestone rules", "error", err)
+	}
+	router.HandleFunc("/api/milestones", sessionMiddleware(db, milestones.MineHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/admin/milestones", sessionMiddleware(db, admin.Require(db.GetDB(), "milestones.list", milestones.ListHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/milestones/{key}", sessionMiddleware(db, admin.Require(db.GetDB(), "milestones.set", milestones.SetHandler(db.GetDB())))).Methods("PUT")
+	router.HandleFunc("/api/admin/milestones/{key}", sessionMiddleware(db, admin.Require(db.GetDB(), "milestones.delete", milestones.DeleteHandler(db.GetDB())))).Methods("DELETE")
+
+	// Chart of accounts: built-in accounts, platform accounts added by staff and, behind a flag, a chama's own
+	router.HandleFunc("/api/chamas/{chamaId}/accounting/chart", sessionMiddleware(db, accounting.ChartHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/accounting/chart", sessionMiddleware(db, accounting.AddChartAccountHandler(db.GetDB(), featureFlags))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/accounting/chart/{accountId}", sessionMiddleware(db, accounting.DeleteChartAccountHandler(db.GetDB(), featureFlags))).Methods("DELETE")
+	router.HandleFunc("/api/admin/chart-of-accounts", sessionMiddleware(db, admin.Require(db.GetDB(), "chart_accounts.list", accounting.AdminChartHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/admin/chart-of-accounts", sessionMiddleware(db, admin.Require(db.GetDB(), "chart_accounts.create", accounting.AdminAddChartAccountHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/admin/chart-of-accounts/{accountId}", sessionMiddleware(db, admin.Require(db.GetDB(), "chart_accounts.delete", accounting.AdminDeleteChartAccountHandler(db.GetDB())))).Methods("DELETE")
+
+	// Dashboards, read from summaries the ledger keeps up to date
+	dashboard.RegisterProjection()
+	router.HandleFunc("/api/dashboard", sessionMiddleware(db, etag.Handler(dashboard.MineHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/dashboard", sessionMiddleware(db, etag.Handler(dashboard.ChamaHandler(db.GetDB(), appCache)))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/members/{userId}/dashboard", sessionMiddleware(db, etag.Handler(dashboard.MemberHandler(db.GetDB())))).Methods("GET")
+	// The treasurer dashboard's nested queries. They only read, so they are
+	// answered in read-only mode too.
+	readonly.PassThrough("/api/graphql")
+	router.HandleFunc("/api/graphql", sessionMiddleware(db, graphql.Handler(db.GetDB(), tenants))).Methods("POST")
+
+	// Chama health indicators for officials, and across chamas for platform staff
+	router.HandleFunc("/api/chamas/{chamaId}/health", sessionMiddleware(db, analytics.ChamaHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/admin/analytics/health", sessionMiddleware(db, admin.Require(db.GetDB(), "analytics.health", analytics.PlatformHandler(db.GetDB())))).Methods("GET")
+
+	// Search across members, chamas and transactions
+	searcher := search.New(db.GetDB(), config.Driver)
+	if pg, ok := searcher.(*search.Postgres); ok {
+		if err := pg.EnsureIndexes(setup); err != nil {
+			slog.Error("Failed to create search indexes", "error", err)
+		}
+	}
+	router.HandleFunc("/api/search", sessionMiddleware(db, search.Handler(searcher))).Methods("GET")
+
+	// Arrears and defaulters, detected daily and escalated as they age
+	router.HandleFunc("/api/chamas/{chamaId}/defaulters", sessionMiddleware(db, arrears.DefaultersHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/arrears/detect", sessionMiddleware(db, arrears.DetectHandler(db.GetDB(), notifier))).Methods("POST")
+
+	// Fraud alerts, raised hourly by the detection rules for officials and platform staff to review
+	router.HandleFunc("/api/chamas/{chamaId}/fraud-alerts", sessionMiddleware(db, fraud.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/fraud-alerts/detect", sessionMiddleware(db, fraud.DetectHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/fraud-alerts/{alertId}/review", sessionMiddleware(db, fraud.ReviewHandler(db.GetDB()))).Methods("POST")
+
+	// Contribution reminders on each chama's schedule, escalated to officials after repeated misses
+	router.HandleFunc("/api/chamas/{chamaId}/reminders/schedule", sessionMiddleware(db, reminders.ScheduleHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/reminders/schedule", sessionMiddleware(db, reminders.UpdateScheduleHandler(db.GetDB()))).Methods("PUT")
+	router.HandleFunc("/api/chamas/{chamaId}/reminders", sessionMiddleware(db, reminders.HistoryHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/reminders/run", sessionMiddleware(db, reminders.RunHandler(db.GetDB(), notifier))).Methods("POST")
+
+	// External auditors: officials grant time-limited read-only access, and every view is logged
+	router.HandleFunc("/api/chamas/{chamaId}/auditors", sessionMiddleware(db, twofactor.Require(db.GetDB(), auditors.GrantHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/auditors", sessionMiddleware(db, auditors.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/auditors/views", sessionMiddleware(db, auditors.ViewsHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/auditors/{accessId}/revoke", sessionMiddleware(db, auditors.RevokeHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/audits", sessionMiddleware(db, auditors.MineHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/audits/{chamaId}/ledger", sessionMiddleware(db, auditors.Require(db.GetDB(), "ledger", auditors.LedgerHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/audits/{chamaId}/ledger/verify", sessionMiddleware(db, auditors.Require(db.GetDB(), "verify", auditors.VerifyHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/audits/{chamaId}/statement", sessionMiddleware(db, auditors.Require(db.GetDB(), "statement", auditors.StatementHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/audits/{chamaId}/members/{userId}/statement", sessionMiddleware(db, auditors.Require(db.GetDB(), "member_statement", auditors.MemberStatementHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/audits/{chamaId}/audit-trail", sessionMiddleware(db, auditors.Require(db.GetDB(), "audit_trail", auditors.AuditTrailHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/audits/{chamaId}/documents", sessionMiddleware(db, auditors.Require(db.GetDB(), "documents", auditors.DocumentsHandler(db.GetDB())))).Methods("GET")
+	router.HandleFunc("/api/audits/{chamaId}/documents/{kind}/{documentId}", sessionMiddleware(db, auditors.Require(db.GetDB(), "document", auditors.DocumentHandler(db.GetDB(), store)))).Methods("GET")
+
+	// Loan products, applications and collateral, schedules, restructuring, top-ups and early settlement
+	router.HandleFunc("/api/loans/{loanId}/schedule", sessionMiddleware(db, loans.ScheduleHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/loans/{loanId}/restructure", sessionMiddleware(db, twofactor.Require(db.GetDB(), txn.Middleware(db.GetDB(), loans.RestructureHandler(db.GetDB(), notifier))))).Methods("POST")
+	router.HandleFunc("/api/loans/{loanId}/top-up", sessionMiddleware(db, twofactor.Require(db.GetDB(), txn.Middleware(db.GetDB(), loans.TopUpHandler(db.GetDB(), notifier))))).Methods("POST")
+	router.HandleFunc("/api/loans/{loanId}/settlement", sessionMiddleware(db, loans.SettlementHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/loans/{loanId}/settlement", sessionMiddleware(db, twofactor.Require(db.GetDB(), txn.Middleware(db.GetDB(), loans.SettleHandler(db.GetDB()))))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/loan-changes", sessionMiddleware(db, loans.ListChangesHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/loan-changes/{changeId}/approve", sessionMiddleware(db, twofactor.Require(db.GetDB(), txn.Middleware(db.GetDB(), loans.ApproveChangeHandler(db.GetDB()))))).Methods("POST")
+	router.HandleFunc("/api/loan-changes/{changeId}/reject", sessionMiddleware(db, txn.Middleware(db.GetDB(), loans.RejectChangeHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/loan-products", sessionMiddleware(db, loans.ListProductsHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/loan-products", sessionMiddleware(db, loans.SaveProductHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/loan-products/{productId}", sessionMiddleware(db, loans.SaveProductHandler(db.GetDB()))).Methods("PUT")
+	router.HandleFunc("/api/chamas/{chamaId}/loan-applications", sessionMiddleware(db, txn.Middleware(db.GetDB(), loans.ApplyHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/loan-applications/{applicationId}", sessionMiddleware(db, loans.GetApplicationHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/loan-applications/{applicationId}/collateral", sessionMiddleware(db, txn.Middleware(db.GetDB(), loans.PledgeHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/collateral/{collateralId}/documents", sessionMiddleware(db, loans.CollateralDocumentHandler(db.GetDB(), store))).Methods("POST")
+	router.HandleFunc("/api/collateral/{collateralId}/documents/{documentId}", sessionMiddleware(db, loans.CollateralDocumentDownloadHandler(db.GetDB(), store))).Methods("GET")
+	router.HandleFunc("/api/collateral/{collateralId}/valuation", sessionMiddleware(db, txn.Middleware(db.GetDB(), loans.ValueCollateralHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/collateral/{collateralId}/release", sessionMiddleware(db, txn.Middleware(db.GetDB(), loans.ReleaseCollateralHandler(db.GetDB())))).Methods("POST")
+
+	// Interest accrual report
+	router.HandleFunc("/api/chamas/{chamaId}/accruals", sessionMiddleware(db, accruals.ReportHandler(db.GetDB()))).Methods("GET")
+
+	// Share capital: configuration, purchases, transfers and the share register
+	router.HandleFunc("/api/chamas/{chamaId}/shares", sessionMiddleware(db, shares.RegisterHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/shares/config", sessionMiddleware(db, shares.ConfigHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/shares/config", sessionMiddleware(db, shares.UpdateConfigHandler(db.GetDB()))).Methods("PUT")
+	router.HandleFunc("/api/chamas/{chamaId}/shares/purchases", sessionMiddleware(db, twofactor.Require(db.GetDB(), shares.PurchaseHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/shares/transfers", sessionMiddleware(db, shares.TransferHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/shares/transactions", sessionMiddleware(db, shares.ListTransactionsHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/shares/dividend-preview", sessionMiddleware(db, shares.DividendPreviewHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/share-transfers/{transactionId}/approve", sessionMiddleware(db, twofactor.Require(db.GetDB(), shares.ApproveTransferHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/share-transfers/{transactionId}/reject", sessionMiddleware(db, shares.RejectTransferHandler(db.GetDB()))).Methods("POST")
+
+	// Treasurer handovers
+	router.HandleFunc("/api/chamas/{chamaId}/handovers", sessionMiddleware(db, handover.StartHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/handovers", sessionMiddleware(db, handover.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/handovers/{handoverId}", sessionMiddleware(db, handover.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/handovers/{handoverId}/acknowledge", sessionMiddleware(db, twofactor.Require(db.GetDB(), handover.AcknowledgeHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/handovers/{handoverId}/cancel", sessionMiddleware(db, handover.CancelHandler(db.GetDB()))).Methods("POST")
+
+	// Chama lifecycle; dissolution is put to a vote and dissolved chamas are read-only
+	lifecycle.RegisterVoteHook()
+	router.HandleFunc("/api/chamas/{chamaId}/lifecycle", sessionMiddleware(db, lifecycle.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/pause", sessionMiddleware(db, lifecycle.PauseHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/resume", sessionMiddleware(db, lifecycle.ResumeHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/dissolution", sessionMiddleware(db, lifecycle.ProposeDissolutionHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/dissolution/distribution", sessionMiddleware(db, lifecycle.DistributionHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/dissolution/distribute", sessionMiddleware(db, twofactor.Require(db.GetDB(), lifecycle.DistributeHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/dissolution/statements/{memberId}", sessionMiddleware(db, etag.Handler(lifecycle.StatementHandler(db.GetDB())))).Methods("GET")
+
+	// Member exits: notice, refund of savings less what is owed, and a final statement
+	router.HandleFunc("/api/chamas/{chamaId}/exits", sessionMiddleware(db, exits.RequestHandler(db.GetDB(), notifier))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/exits", sessionMiddleware(db, exits.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/members/{memberId}/refund", sessionMiddleware(db, exits.RefundHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/exits/{exitId}", sessionMiddleware(db, exits.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/exits/{exitId}/approve", sessionMiddleware(db, exits.ApproveHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/exits/{exitId}/reject", sessionMiddleware(db, exits.RejectHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/exits/{exitId}/cancel", sessionMiddleware(db, exits.CancelHandler(db.GetDB()))).Methods("POST")
+	router.HandleFunc("/api/exits/{exitId}/pay", sessionMiddleware(db, twofactor.Require(db.GetDB(), exits.PayHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/exits/{exitId}/release", sessionMiddleware(db, twofactor.Require(db.GetDB(), exits.ReleaseHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/exits/{exitId}/statement", sessionMiddleware(db, etag.Handler(exits.StatementHandler(db.GetDB())))).Methods("GET")
+
+	// Contributions by M-Pesa, Airtel Money or bank transfer. Mobile money is
+	// confirmed by the provider's callback, bank transfers by an official.
+	mpesa, err := payments.MpesaFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure M-Pesa", "error", err)
+		os.Exit(1)
+	}
+	if mpesa != nil {
+		payments.Register(mpesa)
+		for _, name := range []string{payments.ProviderMpesaB2C, payments.ProviderMpesaB2CTimeout, payments.ProviderMpesaB2CStatus} {
+			payments.Verifiers[name] = mpesa
+		}
+	}
+	airtel, err := payments.AirtelFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure Airtel Money", "error", err)
+		os.Exit(1)
+	}
+	if airtel != nil {
+		payments.Register(airtel)
+	}
+	if mpesa == nil && airtel == nil {
+		slog.Warn("No mobile money provider is configured; only bank transfer contributions are enabled")
+	}
+	card, err := payments.StripeFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure card payments", "error", err)
+		os.Exit(1)
+	}
+	if card != nil {
+		payments.Register(card)
+	}
+	payments.Register(payments.BankTransfer{})
+	for name, provider := range payments.Providers {
+		payments.Processors[name] = wallets.Processor(provider, contributions.Processor(provider, store, notifier))
+	}
+	paymentLimits, err := ratelimit.ConfigFromEnv("RATE_LIMIT_PAYMENTS", ratelimit.PaymentConfig)
+	if err != nil {
+		slog.Error("Failed to configure rate limits", "error", err)
+		os.Exit(1)
+	}
+	paymentLimiter := ratelimit.New("payments", paymentLimits, limiterStore)
+	// Providers' callbacks are stored in read-only mode rather than refused,
+	// and applied by the replay job once it ends
+	readonly.PassThrough("/api/payments/mpesa/callback", "/api/payments/airtel/callback", "/api/payments/card/webhook",
+		"/api/payments/mpesa/b2c/result", "/api/payments/mpesa/b2c/timeout", "/api/payments/mpesa/b2c/status")
+	router.HandleFunc("/api/payments/mpesa/callback", payments.CallbackHandler(db.GetDB(), payments.ProviderMpesa)).Methods("POST")
+	router.HandleFunc("/api/payments/airtel/callback", payments.CallbackHandler(db.GetDB(), payments.ProviderAirtel)).Methods("POST")
+	router.HandleFunc("/api/payments/card/webhook", payments.CallbackHandler(db.GetDB(), payments.ProviderCard)).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/contributions/mpesa", sessionMiddleware(db, ratelimit.PerUser(paymentLimiter, contributions.PayHandler(db.GetDB(), payments.ProviderMpesa)))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/contributions/airtel", sessionMiddleware(db, ratelimit.PerUser(paymentLimiter, contributions.PayHandler(db.GetDB(), payments.ProviderAirtel)))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/contributions/card", sessionMiddleware(db, ratelimit.PerUser(paymentLimiter, contributions.CardHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/contributions/bank-transfer", sessionMiddleware(db, ratelimit.PerUser(paymentLimiter, contributions.BankTransferHandler(db.GetDB(), store, notifier)))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/contributions/bank-transfer", sessionMiddleware(db, contributions.PendingHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/contributions/{contributionId}/confirm", sessionMiddleware(db, twofactor.Require(db.GetDB(), txn.Middleware(db.GetDB(), contributions.ConfirmHandler(db.GetDB(), store, notifier))))).Methods("POST")
+	router.HandleFunc("/api/contributions/{contributionId}/reject", sessionMiddleware(db, txn.Middleware(db.GetDB(), contributions.RejectHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/contributions/{contributionId}/check", sessionMiddleware(db, contributions.CheckHandler(db.GetDB(), store, notifier))).Methods("POST")
+	router.HandleFunc("/api/contributions/{contributionId}/proof", sessionMiddleware(db, contributions.ProofHandler(db.GetDB(), store))).Methods("GET")
+
+	// Exchange rates for members contributing from abroad, fetched daily and cached
+	rates, err := fx.FromEnv()
+	if err != nil {
+		slog.Error("Failed to configure exchange rates", "error", err)
+		os.Exit(1)
+	}
+	if rates != nil {
+		fx.Use(rates)
+	} else {
+		slog.Warn("No exchange-rate provider is configured; contributions in other currencies use cached rates only")
+	}
+	router.HandleFunc("/api/exchange-rates", sessionMiddleware(db, fx.RateHandler(db.GetDB()))).Methods("GET")
+
+	// Member wallets, topped up by M-Pesa, and standing orders paying contributions from them when due
+	router.HandleFunc("/api/wallet", sessionMiddleware(db, wallets.GetHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/wallet/top-up", sessionMiddleware(db, ratelimit.PerUser(paymentLimiter, wallets.TopUpHandler(db.GetDB(), payments.ProviderMpesa)))).Methods("POST")
+	router.HandleFunc("/api/wallet/standing-orders", sessionMiddleware(db, wallets.OrdersHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/standing-order", sessionMiddleware(db, wallets.SetOrderHandler(db.GetDB()))).Methods("PUT")
+	router.HandleFunc("/api/wallet/standing-orders/{orderId}", sessionMiddleware(db, wallets.CancelOrderHandler(db.GetDB()))).Methods("DELETE")
+
+	// Offline-first sync for the mobile app: queued mutations in, changes since the last sync out
+	syncer := offline.NewSyncer(db.GetDB(), store, notifier)
+	router.HandleFunc("/api/chamas/{chamaId}/sync", sessionMiddleware(db, offline.SyncHandler(syncer))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/statements", sessionMiddleware(db, twofactor.Require(db.GetDB(), statements.ImportHandler(db.GetDB(), store, notifier)))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/statements", sessionMiddleware(db, statements.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/chamas/{chamaId}/statement-lines", sessionMiddleware(db, statements.LinesHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/statement-lines/{lineId}/candidates", sessionMiddleware(db, statements.CandidatesHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/statement-lines/{lineId}/match", sessionMiddleware(db, twofactor.Require(db.GetDB(), statements.MatchHandler(db.GetDB(), store, notifier)))).Methods("POST")
+	router.HandleFunc("/api/statement-lines/{lineId}/ignore", sessionMiddleware(db, statements.IgnoreHandler(db.GetDB()))).Methods("POST")
+
+	// Payouts to members' phones: loan disbursements and merry-go-round turns
+	// by M-Pesa B2C, settled by the B2C result callback or, when the request
+	// times out, by the result of a transaction status query
+	if mpesa != nil && mpesa.CanDisburse() {
+		payments.RegisterDisburser(mpesa)
+		payments.Processors[payments.ProviderMpesaB2C] = disbursements.Processor(mpesa.Name(), mpesa.HandlePayoutResult, notifier)
+		payments.Processors[payments.ProviderMpesaB2CTimeout] = disbursements.TimeoutProcessor(mpesa, mpesa.HandlePayoutTimeout)
+		payments.Processors[payments.ProviderMpesaB2CStatus] = disbursements.Processor(mpesa.Name(), mpesa.HandlePayoutStatus, notifier)
+	}
+	router.HandleFunc("/api/payments/mpesa/b2c/result", payments.CallbackHandler(db.GetDB(), payments.ProviderMpesaB2C)).Methods("POST")
+	router.HandleFunc("/api/payments/mpesa/b2c/timeout", payments.CallbackHandler(db.GetDB(), payments.ProviderMpesaB2CTimeout)).Methods("POST")
+	router.HandleFunc("/api/payments/mpesa/b2c/status", payments.CallbackHandler(db.GetDB(), payments.ProviderMpesaB2CStatus)).Methods("POST")
+	router.HandleFunc("/api/loans/{loanId}/disburse", sessionMiddleware(db, twofactor.Require(db.GetDB(), disbursements.LoanHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/payouts", sessionMiddleware(db, twofactor.Require(db.GetDB(), disbursements.PayoutHandler(db.GetDB(), notifier)))).Methods("POST")
+	router.HandleFunc("/api/chamas/{chamaId}/disbursements", sessionMiddleware(db, disbursements.ListHandler(db.GetDB()))).Methods("GET")
+	router.HandleFunc("/api/disbursements/{disbursementId}/approve", sessionMiddleware(db, twofactor.Require(db.GetDB(), disbursements.ApproveHandler(db.GetDB())))).Methods("POST")
+	router.HandleFunc("/api/disbursements/{disbursementId}/reject", sessionMiddleware(db, txn.Middleware(db.GetDB(), disbursements.RejectHandler(db.GetDB(), notifier)))).Methods("POST")
+
+	// USSD menus and SMS commands (BAL, STATEMENT, PAY) for members on feature phones (Africa's Talking)
+	ussd.GatewayToken = os.Getenv("USSD_GATEWAY_TOKEN")
+	ussdService := ussd.NewService(db.GetDB())
+	router.HandleFunc("/api/ussd", ussd.Handler(ussdService)).Methods("POST")
+	router.HandleFunc("/api/sms/inbound", ussd.SMSHandler(ussd.NewSMSService(ussdService, smsSender))).Methods("POST")
+
+	// Background jobs
+	scheduler := jobs.NewScheduler(db.GetDB())
+	scheduler.PauseWhile(readonly.Enabled)
+	reports.RegisterMonthlyJob(scheduler, db.GetDB(), store, notifier)
+	yearend.RegisterReportsJob(scheduler, db.GetDB(), store, notifier)
+	meetings.RegisterReminderJob(scheduler, db.GetDB(), notifier)
+	meetings.RegisterPackJob(scheduler, db.GetDB(), store, notifier)
+	documents.RegisterExpiryReminderJob(scheduler, db.GetDB(), notifier)
+	votes.RegisterTallyJob(scheduler, db.GetDB(), notifier)
+	goals.RegisterTrackingJob(scheduler, db.GetDB(), notifier)
+	milestones.RegisterJob(scheduler, db.GetDB(), notifier)
+	scheduler.Register("session_cleanup", jobs.Every(time.Hour), func(ctx context.Context) error {
+		return database.CleanupExpiredSessions(ctx, db.GetDB())
+	})
+	dashboard.RegisterReconcileJob(scheduler, db.GetDB())
+	analytics.RegisterJob(scheduler, db.GetDB())
+	ledger.RegisterBalanceCheckJob(scheduler, db.GetDB())
+	wallets.RegisterStandingOrderJob(scheduler, db.GetDB(), store, notifier)
+	arrears.RegisterDetectionJob(scheduler, db.GetDB(), notifier)
+	fraud.RegisterDetectionJob(scheduler, db.GetDB(), notifier)
+	referrals.RegisterTrackingJob(scheduler, db.GetDB(), notifier)
+	reminders.RegisterJob(scheduler, db.GetDB(), notifier)
+	accruals.RegisterAccrualJob(scheduler, db.GetDB())
+	ussd.RegisterCleanupJob(scheduler, db.GetDB())
+	apikeys.RegisterCleanupJob(scheduler, db.GetDB())
+	privacy.RegisterDeletionJob(scheduler, db.GetDB(), store)
+	payments.RegisterReplayJob(scheduler, db.GetDB())
+	rotationInterval, err := signing.RotationIntervalFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure signing key rotation", "error", err)
+		os.Exit(1)
+	}
+	signing.RegisterRotationJob(scheduler, signingKeys, rotationInterval)
+	contributions.RegisterReconcileJob(scheduler, db.GetDB(), store, notifier)
+	fx.RegisterJob(scheduler, db.GetDB())
+	archival.RegisterJob(scheduler, db.GetDB())
+	billing.RegisterJobs(scheduler, db.GetDB(), notifier)
+	notifications.RegisterDigestJobs(scheduler, notifier)
+	events.RegisterPruneJob(scheduler, db.GetDB())
+	maintenanceWindow, err := maintenance.WindowFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure database maintenance", "error", err)
+		os.Exit(1)
+	}
+	maintenance.RegisterJob(scheduler, db.GetDB(), maintenanceWindow, maintenance.Options{Checkpoint: replicator == nil})
+	scheduler.Start(context.Background())
+
+	// Reload the log level, rate limits, feature flags, read-only mode and
+	// provider credentials on SIGHUP or POST /api/admin/config/reload. Anything else
+	// still needs a restart. Secrets are resolved first so that the rest see
+	// rotated values.
+	reload.Register("secrets", secrets.Resolve)
+	reload.Register("log level", func(ctx context.Context) error {
+		l, err := logging.LevelFromEnv()
+		if err != nil {
+			return err
+		}
+		logging.SetLevel(l)
+		return nil
+	})
+	reload.Register("rate limits", func(ctx context.Context) error {
+		auth, err := ratelimit.ConfigFromEnv("RATE_LIMIT_AUTH", ratelimit.AuthConfig)
+		if err != nil {
+			return err
+		}
+		codes, err := ratelimit.ConfigFromEnv("RATE_LIMIT_OTP", ratelimit.OTPConfig)
+		if err != nil {
+			return err
+		}
+		pay, err := ratelimit.ConfigFromEnv("RATE_LIMIT_PAYMENTS", ratelimit.PaymentConfig)
+		if err != nil {
+			return err
+		}
+		for _, l := range authLimiters {
+			l.SetConfig(auth)
+		}
+		for _, l := range otpLimiters {
+			l.SetConfig(codes)
+		}
+		paymentLimiter.SetConfig(pay)
+		return nil
+	})
+	reload.Register("feature flags", func(ctx context.Context) error {
+		featureFlags.Refresh()
+		return nil
+	})
+	reload.Register("read-only mode", func(ctx context.Context) error { return readonly.FromEnv() })
+	if mpesa != nil {
+		reload.Register(payments.ProviderMpesa, func(ctx context.Context) error { return mpesa.ReloadFromEnv() })
+	}
+	if airtel != nil {
+		reload.Register(payments.ProviderAirtel, func(ctx context.Context) error { return airtel.ReloadFromEnv() })
+	}
+	if card != nil {
+		reload.Register(payments.ProviderCard, func(ctx context.Context) error { return card.ReloadFromEnv() })
+	}
+	reload.Watch(context.Background())
+
+	// gRPC services for other backend services, on GRPC_ADDR (e.g. ":9090")
+	// next to the REST API. Callers must send RPC_TOKEN.
+	if addr := os.Getenv("GRPC_ADDR"); addr != "" {
+		rpcServer, err := rpc.NewServer(db.GetDB(), tenants, os.Getenv("RPC_TOKEN"))
+		if err != nil {
+			slog.Error("Failed to configure gRPC services", "error", err)
+			os.Exit(1)
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			slog.Error("Failed to listen for gRPC", "addr", addr, "error", err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := rpcServer.Serve(listener); err != nil {
+				slog.Error("gRPC server stopped", "error", err)
+			}
+		}()
+		slog.Info("gRPC services available", "addr", addr)
+	}
+
 	// Start server with CORS handler
-	log.Println("Starting server on http://localhost:8080")
-	log.Println("API endpoints available at http://localhost:8080/api/*")
+	slog.Info("Starting server on http://localhost:8080")
+	slog.Info("API endpoints available at http://localhost:8080/api/*")
 	if err := http.ListenAndServe(":8080", handler); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		slog.Error("Failed to start server", "error", err)
+		os.Exit(1)
 	}
 }
 
@@ -159,6 +1071,8 @@ func registerHandler(db *database.DBInstance) http.HandlerFunc {
 			Surname   string `json:"surname"`
 			Phone     string `json:"phone"`
 			Country   string `json:"country"`
+			// ReferralCode is the code of whoever referred the new user, if anyone
+			ReferralCode string `json:"referralCode"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
@@ -167,11 +1081,25 @@ func registerHandler(db *database.DBInstance) http.HandlerFunc {
 		}
 
 		// Log received data for debugging
-		log.Printf("Registering user: %s, email: %s", user.Username, user.Email)
+		slog.DebugContext(r.Context(), "Registering user", "username", user.Username, "email", user.Email)
 
-		// Validate required fields
-		if user.Username == "" || user.Email == "" {
-			http.Error(w, "Username, email and password are required", http.StatusBadRequest)
+		// Validate input fields
+		v := validation.New()
+		v.Required("username", user.Username)
+		if v.Required("email", user.Email) {
+			v.Email("email", user.Email)
+		}
+		if user.Phone != "" {
+			v.Phone("phone", user.Phone)
+			user.Phone = phone.Canonical(user.Phone)
+		}
+		if strings.TrimSpace(user.ReferralCode) != "" {
+			if _, err := referrals.Lookup(r.Context(), db.GetDB(), user.ReferralCode); errors.Is(err, referrals.ErrUnknownCode) {
+				v.Add("referralCode", validation.CodeNotFound, nil)
+			}
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
 			return
 		}
 
@@ -195,7 +1123,7 @@ func registerHandler(db *database.DBInstance) http.HandlerFunc {
 			1, // Set is_verified to 1 (true) for development
 		)
 		if err != nil {
-			log.Printf("Database error during registration: %v", err)
+			slog.ErrorContext(r.Context(), "Database error during registration", "error", err)
 
 			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 				http.Error(w, "Username or email already exists", http.StatusConflict)
@@ -205,6 +1133,18 @@ func registerHandler(db *database.DBInstance) http.HandlerFunc {
 			return
 		}
 
+		// Possible duplicates are left for staff to review rather than refused
+		if _, err := duplicates.Flag(r.Context(), db.GetDB(), userID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to check for duplicate members", "user_id", userID, "error", err)
+		}
+
+		// A referral that cannot be recorded must not stop the sign-up
+		if strings.TrimSpace(user.ReferralCode) != "" {
+			if err := referrals.Attribute(r.Context(), db.GetDB(), user.ReferralCode, userID); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to attribute referral", "user_id", userID, "error", err)
+			}
+		}
+
 		// Return success response
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
@@ -232,11 +1172,12 @@ func generateRandomString(length int) string {
 }
 
 // Login handler
-func loginHandler(db *database.DBInstance) http.HandlerFunc {
+func loginHandler(db *database.DBInstance, keys *signing.Keyring) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var credentials struct {
-			Email    string `json:"email"`
-			Password string `json:"password"`
+			Email      string `json:"email"`
+			Password   string `json:"password"`
+			DeviceName string `json:"deviceName"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
@@ -270,9 +1211,9 @@ func loginHandler(db *database.DBInstance) http.HandlerFunc {
 
 		var queryFields string
 		if hasIsVerified {
-			queryFields = "id, username, email, password_hash, first_name, last_name, phone_number, country, is_verified"
+			queryFields = "user_id, username, email, password_hash, first_name, last_name, phone_number, country, is_verified"
 		} else {
-			queryFields = "id, username, email, password_hash, first_name, last_name, phone_number, country"
+			queryFields = "user_id, username, email, password_hash, first_name, last_name, phone_number, country"
 		}
 
 		var row *sql.Row
@@ -295,7 +1236,7 @@ func loginHandler(db *database.DBInstance) http.HandlerFunc {
 
 		if err != nil {
 			if err == sql.ErrNoRows {
-				http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+				http.Error(w, i18n.T(i18n.FromRequest(r), "auth.invalid_credentials", nil), http.StatusUnauthorized)
 			} else {
 				http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 			}
@@ -304,25 +1245,47 @@ func loginHandler(db *database.DBInstance) http.HandlerFunc {
 
 		// Check password
 		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(credentials.Password)); err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			http.Error(w, i18n.T(i18n.FromRequest(r), "auth.invalid_credentials", nil), http.StatusUnauthorized)
 			return
 		}
 
 		// Only check verification if the column exists
 		if hasIsVerified && !user.IsVerified {
-			http.Error(w, "Account not verified", http.StatusForbidden)
+			http.Error(w, i18n.T(i18n.FromRequest(r), "auth.not_verified", nil), http.StatusForbidden)
+			return
+		}
+
+		// Suspended accounts cannot sign in until platform staff lift the suspension
+		suspended, err := admin.IsSuspended(db.GetDB(), user.ID)
+		if err != nil {
+			http.Error(w, "Failed to check account status", http.StatusInternalServerError)
+			return
+		}
+		if suspended {
+			http.Error(w, i18n.T(i18n.FromRequest(r), "auth.suspended", nil), http.StatusForbidden)
 			return
 		}
 
-		// Generate JWT token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		// Deleted accounts stay closed until recovered within the grace period
+		eraseAfter, pending, err := privacy.PendingDeletion(r.Context(), db.GetDB(), user.ID)
+		if err != nil {
+			http.Error(w, "Failed to check account status", http.StatusInternalServerError)
+			return
+		}
+		if pending {
+			http.Error(w, i18n.T(i18n.FromRequest(r), "auth.pending_deletion", map[string]string{"date": eraseAfter.Format("2006-01-02")}), http.StatusForbidden)
+			return
+		}
+
+		// Generate JWT token, verifiable by other services with the published keys
+		now := time.Now()
+		tokenString, err := keys.Sign(r.Context(), jwt.MapClaims{
 			"userId":   user.ID,
 			"email":    user.Email,
 			"username": user.Username,
-			"exp":      time.Now().Add(time.Hour * 24).Unix(),
+			"iat":      now.Unix(),
+			"exp":      now.Add(signing.TokenLifetime).Unix(),
 		})
-
-		tokenString, err := token.SignedString([]byte("your-secret-key"))
 		if err != nil {
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
@@ -331,23 +1294,34 @@ func loginHandler(db *database.DBInstance) http.HandlerFunc {
 		userAgent := r.UserAgent()
 
 		// Extract IP address
-		ip := r.RemoteAddr 
-		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-			ip = strings.Split(forwarded, ",")[0]
+		ip := ratelimit.ClientIP(r)
+
+		// Name the device so it can be recognised in the session list
+		deviceName := credentials.DeviceName
+		if deviceName == "" {
+			deviceName = account.DeviceName(userAgent)
 		}
 
-		sessionID, err := database.CreateSession(db.GetDB(), user.ID, tokenString,ip,userAgent, database.SessionTimeout)
+		sessionID, err := database.CreateSession(db.GetDB(), user.ID, tokenString, ip, userAgent, deviceName, database.SessionTimeout)
 		if err != nil {
 			http.Error(w, "Failed to create session", http.StatusInternalServerError)
 			return
 		}
 
+		// Sensitive operations will ask for a second factor on this session
+		twoFactor, err := twofactor.Enabled(r.Context(), db.GetDB(), user.ID)
+		if err != nil {
+			http.Error(w, "Failed to check two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+
 		// Return success response with token and user info
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"message": "Login successful",
 			"token":   tokenString,
 			"sessionId": sessionID,
+			"twoFactorEnabled": twoFactor,
 			"user": map[string]interface{}{
 				"id":          user.ID,
 				"username":    user.Username,
@@ -413,7 +1387,7 @@ func sessionMiddleware(db *database.DBInstance,next http.HandlerFunc) http.Handl
 			http.Error(w, "Session ID required", http.StatusUnauthorized)
 			return
 		}
-			valid, _ , err := database.IsValidSession(db.GetDB(), sessionID)
+			valid, userID, err := database.IsValidSession(db.GetDB(), sessionID)
 			if err != nil {
 				http.Error(w, "Failed to validate session", http.StatusInternalServerError)
 				return
@@ -427,7 +1401,9 @@ func sessionMiddleware(db *database.DBInstance,next http.HandlerFunc) http.Handl
 				return
 			}
 
-		next.ServeHTTP(w, r)
+		// Make the authenticated user available to handlers
+		ctx := context.WithValue(r.Context(), "userID", userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
@@ -0,0 +1,45 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/readonly"
+)
+
+// HealthHandler reports whether the database is reachable, with the latest
+// maintenance run. It answers 503 when the database cannot be reached or
+// failed its last integrity check, so load balancers stop sending traffic.
+// Read-only maintenance mode is reported, but reads are still served.
+func HealthHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		health := map[string]interface{}{"status": "ok", "database": "ok"}
+		code := http.StatusOK
+		if err := db.PingContext(ctx); err != nil {
+			health["status"], health["database"] = "unavailable", err.Error()
+			code = http.StatusServiceUnavailable
+		} else if run, err := Latest(ctx, db); err != nil {
+			health["maintenance"] = map[string]string{"error": err.Error()}
+		} else if run != nil {
+			health["maintenance"] = run
+			if run.Failed(TaskIntegrity) {
+				health["status"] = "degraded"
+				code = http.StatusServiceUnavailable
+			}
+		}
+
+		if state := readonly.Current(); state.Enabled {
+			health["readOnly"] = state
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(health)
+	}
+}
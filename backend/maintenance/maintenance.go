@@ -0,0 +1,254 @@
+// Package maintenance keeps the SQLite database healthy. Once a day, in a
+// low-traffic window, it checks the database's integrity, checkpoints and
+// truncates the WAL, refreshes the query planner's statistics with ANALYZE
+// and returns free pages to the filesystem with an incremental vacuum. Each
+// run is logged and recorded in maintenance_runs, and the latest one is
+// shown by the health endpoint.
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/jobs"
+
+	"github.com/google/uuid"
+)
+
+// VacuumPages is the most free pages one run returns to the filesystem, so a
+// large purge is reclaimed over several nights rather than in one long write
+const VacuumPages = 10000
+
+// Task names, in the order they run
+const (
+	TaskIntegrity  = "integrity_check"
+	TaskCheckpoint = "wal_checkpoint"
+	TaskAnalyze    = "analyze"
+	TaskVacuum     = "incremental_vacuum"
+)
+
+// Task is the outcome of one maintenance step
+type Task struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"` // ok, failed, skipped
+	Detail     string `json:"detail,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Run is one maintenance run
+type Run struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"` // ok, failed
+	Tasks      []Task    `json:"tasks"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// Failed reports whether the named task failed in this run
+func (r Run) Failed(name string) bool {
+	for _, t := range r.Tasks {
+		if t.Name == name {
+			return t.Status == "failed"
+		}
+	}
+	return false
+}
+
+// Options choose what a run does
+type Options struct {
+	// Checkpoint truncates the WAL. It must be off while the database is
+	// replicated, as only the replicator may checkpoint.
+	Checkpoint bool
+}
+
+// Perform runs the maintenance tasks on db and records the run. A failed
+// task does not stop the others, except that a database failing its
+// integrity check is not vacuumed. The run is returned with an error naming
+// the failed tasks, if any.
+func Perform(ctx context.Context, db *sql.DB, opts Options) (Run, error) {
+	run := Run{ID: uuid.NewString(), Status: "ok", StartedAt: time.Now().UTC()}
+
+	step := func(name string, fn func() (string, error)) {
+		start := time.Now()
+		t := Task{Name: name, Status: "ok"}
+		detail, err := fn()
+		switch {
+		case errors.Is(err, errSkipped):
+			t.Status, t.Detail = "skipped", detail
+		case err != nil:
+			t.Status, t.Detail = "failed", err.Error()
+			run.Status = "failed"
+		default:
+			t.Detail = detail
+		}
+		t.DurationMs = time.Since(start).Milliseconds()
+		run.Tasks = append(run.Tasks, t)
+		slog.InfoContext(ctx, "Maintenance task finished", "task", name, "status", t.Status, "detail", t.Detail, "duration_ms", t.DurationMs)
+	}
+
+	step(TaskIntegrity, func() (string, error) { return integrityCheck(ctx, db) })
+	step(TaskCheckpoint, func() (string, error) {
+		if !opts.Checkpoint {
+			return "the database is replicated; the replicator checkpoints", errSkipped
+		}
+		return checkpoint(ctx, db)
+	})
+	step(TaskAnalyze, func() (string, error) {
+		_, err := db.ExecContext(ctx, `ANALYZE`)
+		return "", err
+	})
+	step(TaskVacuum, func() (string, error) {
+		if run.Failed(TaskIntegrity) {
+			return "the integrity check failed", errSkipped
+		}
+		return incrementalVacuum(ctx, db)
+	})
+
+	run.FinishedAt = time.Now().UTC()
+	if err := record(ctx, db, run); err != nil {
+		slog.ErrorContext(ctx, "Failed to record maintenance run", "error", err)
+	}
+	if run.Status == "failed" {
+		var failed []string
+		for _, t := range run.Tasks {
+			if t.Status == "failed" {
+				failed = append(failed, t.Name)
+			}
+		}
+		return run, fmt.Errorf("maintenance failed: %s", strings.Join(failed, ", "))
+	}
+	return run, nil
+}
+
+var errSkipped = errors.New("skipped")
+
+// integrityCheck reports up to the first ten problems PRAGMA integrity_check finds
+func integrityCheck(ctx context.Context, db *sql.DB) (string, error) {
+	rows, err := db.QueryContext(ctx, `PRAGMA integrity_check(10)`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, "; "))
+	}
+	return "ok", nil
+}
+
+func checkpoint(ctx context.Context, db *sql.DB) (string, error) {
+	var busy, logFrames, checkpointed int
+	if err := db.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return "", err
+	}
+	if busy != 0 {
+		return "", errors.New("database busy; the WAL was not truncated")
+	}
+	return fmt.Sprintf("%d frames checkpointed", checkpointed), nil
+}
+
+// incrementalVacuum frees up to VacuumPages pages. It needs auto_vacuum to be
+// INCREMENTAL, which the schema sets for new databases; an older database
+// has to be converted once with a full VACUUM.
+func incrementalVacuum(ctx context.Context, db *sql.DB) (string, error) {
+	var mode int
+	if err := db.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return "", err
+	}
+	if mode != 2 {
+		return "auto_vacuum is not INCREMENTAL", errSkipped
+	}
+	before, err := freePages(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(`PRAGMA incremental_vacuum(%d)`, VacuumPages)); err != nil {
+		return "", err
+	}
+	after, err := freePages(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d pages freed, %d left", before-after, after), nil
+}
+
+func freePages(ctx context.Context, db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&n)
+	return n, err
+}
+
+func record(ctx context.Context, db *sql.DB, run Run) error {
+	tasks, err := json.Marshal(run.Tasks)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO maintenance_runs (id, status, tasks, started_at, finished_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		run.ID, run.Status, string(tasks), run.StartedAt, run.FinishedAt)
+	return err
+}
+
+// Latest returns the most recent maintenance run, or nil if there has been none
+func Latest(ctx context.Context, db *sql.DB) (*Run, error) {
+	var run Run
+	var tasks string
+	err := db.QueryRowContext(ctx, `
+		SELECT id, status, tasks, started_at, finished_at
+		FROM maintenance_runs ORDER BY started_at DESC LIMIT 1`).
+		Scan(&run.ID, &run.Status, &tasks, &run.StartedAt, &run.FinishedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tasks), &run.Tasks); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// WindowFromEnv reads the maintenance window from MAINTENANCE_WINDOW as
+// "from-to" in hours, local time, e.g. "23-4". It defaults to 2-5, when
+// chamas are least active.
+func WindowFromEnv() (jobs.Window, error) {
+	w := jobs.Window{From: 2, To: 5}
+	v := os.Getenv("MAINTENANCE_WINDOW")
+	if v == "" {
+		return w, nil
+	}
+	if _, err := fmt.Sscanf(v, "%d-%d", &w.From, &w.To); err != nil ||
+		w.From < 0 || w.From > 23 || w.To < 0 || w.To > 23 || w.From == w.To {
+		return w, fmt.Errorf("invalid MAINTENANCE_WINDOW %q: want hours as from-to, e.g. 2-5", v)
+	}
+	return w, nil
+}
+
+// RegisterJob runs maintenance once a day within window
+func RegisterJob(s *jobs.Scheduler, db *sql.DB, window jobs.Window, opts Options) {
+	s.Register("database_maintenance", window, func(ctx context.Context) error {
+		_, err := Perform(ctx, db, opts)
+		return err
+	})
+}
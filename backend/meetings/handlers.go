@@ -0,0 +1,475 @@
+package meetings
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/rules"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/validation"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// MaxMinutesSize is the largest accepted minutes document
+const MaxMinutesSize = 10 << 20 // 10 MB
+
+// managerRoles can schedule meetings, take attendance and record minutes
+var managerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleSecretary}
+
+// CreateHandler lets the secretary schedule a meeting for the {chamaId} chama
+func CreateHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Title       string       `json:"title"`
+			Description string       `json:"description"`
+			Date        string       `json:"meetingDate"`
+			Location    string       `json:"location"`
+			Type        string       `json:"meetingType"`
+			IsVirtual   bool         `json:"isVirtual"`
+			VirtualLink string       `json:"virtualMeetingLink"`
+			AbsenceFine string       `json:"absenceFine"`
+			Agenda      []AgendaItem `json:"agenda"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.Type == "" {
+			request.Type = TypeRegular
+		}
+
+		v := validation.New()
+		v.Required("title", request.Title)
+		v.OneOf("meetingType", request.Type, TypeRegular, TypeEmergency, TypeAGM)
+		date, err := time.Parse(time.RFC3339, request.Date)
+		if err != nil || date.Before(time.Now()) {
+			v.Add("meetingDate", validation.CodeDate, nil)
+		}
+		if request.IsVirtual {
+			v.Required("virtualMeetingLink", request.VirtualLink)
+		} else {
+			v.Required("location", request.Location)
+		}
+		if request.AbsenceFine != "" {
+			v.Amount("absenceFine", request.AbsenceFine)
+		}
+		for _, item := range request.Agenda {
+			v.Required("agenda.title", item.Title)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		currency, err := money.ChamaCurrency(db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		// Without an explicit fine the chama's rules decide
+		var fine money.Money
+		if request.AbsenceFine != "" {
+			fine, _ = money.Parse(request.AbsenceFine, currency)
+		} else {
+			current, err := rules.Current(r.Context(), db, chamaID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fine = money.New(current.AbsenceFineMinor, currency)
+		}
+
+		m, err := Create(r.Context(), db, Meeting{
+			ChamaID:     chamaID,
+			Title:       request.Title,
+			Description: request.Description,
+			Date:        date,
+			Location:    request.Location,
+			Type:        request.Type,
+			IsVirtual:   request.IsVirtual,
+			VirtualLink: request.VirtualLink,
+			AbsenceFine: fine,
+			CreatedBy:   userID,
+			Agenda:      request.Agenda,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyAttendees(r.Context(), db, notifier, m, "meeting.scheduled", "notification.meeting_scheduled")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(m)
+	}
+}
+
+// ListHandler lists the {chamaId} chama's meetings. ?upcoming=true shows only future scheduled meetings.
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := List(r.Context(), db, chamaID, r.URL.Query().Get("upcoming") == "true")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// GetHandler returns the {meetingId} meeting with its agenda, attendance and published minutes
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, userID, ok := loadMeeting(db, w, r)
+		if !ok {
+			return
+		}
+		attendance, err := Attendance(r.Context(), db, m.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		minutes, err := ListMinutes(r.Context(), db, m.ID, chamas.HasRole(db, m.ChamaID, userID, managerRoles...))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"meeting":    m,
+			"attendance": attendance,
+			"minutes":    minutes,
+		})
+	}
+}
+
+// RSVPHandler records the caller's response ("yes", "no" or "maybe") to the {meetingId} meeting
+func RSVPHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, userID, ok := loadMeeting(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Response string `json:"response"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.OneOf("response", request.Response, RSVPYes, RSVPNo, RSVPMaybe)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		if err := RSVP(r.Context(), db, m.ID, userID, request.Response); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// AttendanceHandler lets the secretary mark members present, absent or excused
+func AttendanceHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, _, ok := loadManagedMeeting(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Attendance []Attendee `json:"attendance"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Attendance) == 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		for _, a := range request.Attendance {
+			v.OneOf("status", a.Status, AttendancePresent, AttendanceAbsent, AttendanceExcused)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		err := RecordAttendance(r.Context(), db, m.ID, request.Attendance)
+		if errors.Is(err, ErrClosed) || errors.Is(err, ErrNotInvited) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeAttendance(w, r, db, m.ID)
+	}
+}
+
+// CompleteHandler closes the {meetingId} meeting and fines absent members
+func CompleteHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, userID, ok := loadManagedMeeting(db, w, r)
+		if !ok {
+			return
+		}
+		issued, err := Complete(r.Context(), db, m.ID, userID)
+		if errors.Is(err, ErrClosed) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if notifier != nil {
+			params := messageParams(r.Context(), db, m)
+			params["amount"] = m.AbsenceFine.String()
+			for _, f := range issued {
+				notifier.Notify(r.Context(), notifications.Notification{
+					UserID:    f.MemberID,
+					Title:     i18n.T(i18n.Default, "meeting.fine", nil),
+					Message:   i18n.T(i18n.Default, "notification.absence_fine", params),
+					Type:      notifications.TypeMeeting,
+					RelatedID: m.ID,
+				})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": StatusCompleted, "fines": issued})
+	}
+}
+
+// CancelHandler cancels the {meetingId} meeting and tells the members
+func CancelHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, _, ok := loadManagedMeeting(db, w, r)
+		if !ok {
+			return
+		}
+		if err := Cancel(r.Context(), db, m.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		notifyAttendees(r.Context(), db, notifier, m, "meeting.cancelled", "notification.meeting_cancelled")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// MinutesHandler records minutes for the {meetingId} meeting. It accepts a
+// multipart form with "content", an optional "file" (PDF or image) and
+// "publish"; published minutes are announced to members.
+func MinutesHandler(db *sql.DB, store storage.Backend, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, userID, ok := loadManagedMeeting(db, w, r)
+		if !ok {
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MaxMinutesSize+1<<20)
+		if err := r.ParseMultipartForm(MaxMinutesSize); err != nil {
+			http.Error(w, "Invalid upload or file too large", http.StatusBadRequest)
+			return
+		}
+		mins := Minutes{
+			MeetingID:   m.ID,
+			Content:     strings.TrimSpace(r.FormValue("content")),
+			RecordedBy:  userID,
+			IsPublished: r.FormValue("publish") == "true",
+		}
+
+		file, header, err := r.FormFile("file")
+		if err == nil {
+			defer file.Close()
+			mimeType, err := storage.DetectType(file, header.Size, MaxMinutesSize, storage.DocumentTypes)
+			if errors.Is(err, storage.ErrTooLarge) {
+				http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Only JPEG, PNG and PDF files are accepted", http.StatusUnsupportedMediaType)
+				return
+			}
+			key := "minutes/" + m.ChamaID + "/" + m.ID + "/" + uuid.NewString() + storage.Extensions[mimeType]
+			if err := store.Put(r.Context(), key, file, header.Size, mimeType); err != nil {
+				http.Error(w, "Failed to store document", http.StatusInternalServerError)
+				return
+			}
+			mins.StorageKey, mins.MimeType = key, mimeType
+		}
+		if mins.Content == "" && mins.StorageKey == "" {
+			v := validation.New()
+			v.Required("content", mins.Content)
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		mins, err = AddMinutes(r.Context(), db, mins)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if mins.IsPublished {
+			notifyAttendees(r.Context(), db, notifier, m, "meeting.minutes", "notification.minutes_published")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(mins)
+	}
+}
+
+// PublishMinutesHandler publishes the {minutesId} draft minutes of the {meetingId} meeting
+func PublishMinutesHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, _, ok := loadManagedMeeting(db, w, r)
+		if !ok {
+			return
+		}
+		mins, err := GetMinutes(r.Context(), db, mux.Vars(r)["minutesId"])
+		if err != nil || mins.MeetingID != m.ID {
+			http.Error(w, "Minutes not found", http.StatusNotFound)
+			return
+		}
+		if !mins.IsPublished {
+			if err := PublishMinutes(r.Context(), db, mins.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			notifyAttendees(r.Context(), db, notifier, m, "meeting.minutes", "notification.minutes_published")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// MinutesDownloadHandler redirects to a short-lived link for the {minutesId} document
+func MinutesDownloadHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, userID, ok := loadMeeting(db, w, r)
+		if !ok {
+			return
+		}
+		mins, err := GetMinutes(r.Context(), db, mux.Vars(r)["minutesId"])
+		if err != nil || mins.MeetingID != m.ID || mins.StorageKey == "" ||
+			(!mins.IsPublished && !chamas.HasRole(db, m.ChamaID, userID, managerRoles...)) {
+			http.Error(w, "Minutes not found", http.StatusNotFound)
+			return
+		}
+
+		url, err := store.SignedURL(mins.StorageKey, 5*time.Minute)
+		if err != nil {
+			http.Error(w, "Failed to create download link", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
+
+// loadMeeting loads the {meetingId} meeting for a member of its chama, writing
+// an error response and returning false otherwise
+func loadMeeting(db *sql.DB, w http.ResponseWriter, r *http.Request) (Meeting, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Meeting{}, "", false
+	}
+	m, err := Get(r.Context(), db, mux.Vars(r)["meetingId"])
+	if err == sql.ErrNoRows || (err == nil && !chamas.IsMember(db, m.ChamaID, userID)) {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+		return m, userID, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return m, userID, false
+	}
+	return m, userID, true
+}
+
+// loadManagedMeeting is loadMeeting restricted to the chama's secretary, chairperson and admins
+func loadManagedMeeting(db *sql.DB, w http.ResponseWriter, r *http.Request) (Meeting, string, bool) {
+	m, userID, ok := loadMeeting(db, w, r)
+	if !ok {
+		return m, userID, false
+	}
+	if !chamas.HasRole(db, m.ChamaID, userID, managerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return m, userID, false
+	}
+	return m, userID, true
+}
+
+func writeAttendance(w http.ResponseWriter, r *http.Request, db *sql.DB, meetingID string) {
+	attendance, err := Attendance(r.Context(), db, meetingID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attendance)
+}
+
+// PackHandler returns the {meetingId} meeting's pack as JSON or PDF
+// (?format=pdf), built fresh from the chama's records. Only officials can view it.
+func PackHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, userID, ok := loadMeeting(db, w, r)
+		if !ok {
+			return
+		}
+		if !chamas.IsOfficial(db, m.ChamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		p, err := BuildPack(r.Context(), db, m.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "pdf" {
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="meeting-pack.pdf"`)
+			WritePackPDF(w, p, i18n.FromRequest(r))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
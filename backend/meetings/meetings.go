@@ -0,0 +1,438 @@
+package meetings
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/fines"
+	"tujifund-app/backend/money"
+
+	"github.com/google/uuid"
+)
+
+// Meeting statuses
+const (
+	StatusScheduled = "scheduled"
+	StatusOngoing   = "ongoing"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+)
+
+// Meeting types
+const (
+	TypeRegular   = "regular"
+	TypeEmergency = "emergency"
+	TypeAGM       = "agm"
+)
+
+// Attendance statuses
+const (
+	AttendancePending = "pending"
+	AttendancePresent = "present"
+	AttendanceAbsent  = "absent"
+	AttendanceExcused = "excused"
+)
+
+// RSVP responses
+const (
+	RSVPYes   = "yes"
+	RSVPNo    = "no"
+	RSVPMaybe = "maybe"
+)
+
+var (
+	// ErrClosed is returned when changing a meeting that is completed or cancelled
+	ErrClosed = errors.New("meeting is already closed")
+	// ErrNotInvited is returned when a user has no attendance record for the meeting
+	ErrNotInvited = errors.New("member is not on this meeting's attendance list")
+)
+
+// AgendaItem is one item on a meeting's agenda
+type AgendaItem struct {
+	ID              string `json:"id"`
+	Title           string `json:"title"`
+	Description     string `json:"description,omitempty"`
+	Order           int    `json:"order"`
+	DurationMinutes int    `json:"durationMinutes,omitempty"`
+	PresenterID     string `json:"presenterId,omitempty"`
+}
+
+// Meeting is a scheduled chama meeting
+type Meeting struct {
+	ID          string       `json:"id"`
+	ChamaID     string       `json:"chamaId"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Date        time.Time    `json:"meetingDate"`
+	Location    string       `json:"location,omitempty"`
+	Type        string       `json:"meetingType"`
+	IsVirtual   bool         `json:"isVirtual"`
+	VirtualLink string       `json:"virtualMeetingLink,omitempty"`
+	Status      string       `json:"status"`
+	AbsenceFine money.Money  `json:"absenceFine"`
+	CreatedBy   string       `json:"createdBy"`
+	Agenda      []AgendaItem `json:"agenda,omitempty"`
+}
+
+// Attendee is a member's RSVP and attendance for a meeting
+type Attendee struct {
+	MemberID string `json:"memberId"`
+	Name     string `json:"name,omitempty"`
+	Status   string `json:"status"`
+	RSVP     string `json:"rsvp,omitempty"`
+	CheckIn  string `json:"checkInTime,omitempty"`
+	Notes    string `json:"notes,omitempty"`
+}
+
+// Minutes is a record of what was discussed, optionally with an attached document
+type Minutes struct {
+	ID          string `json:"id"`
+	MeetingID   string `json:"meetingId"`
+	Content     string `json:"content"`
+	StorageKey  string `json:"-"`
+	MimeType    string `json:"mimeType,omitempty"`
+	HasDocument bool   `json:"hasDocument"`
+	RecordedBy  string `json:"recordedBy"`
+	IsPublished bool   `json:"isPublished"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+// Create schedules m and adds every active member of the chama to its attendance list
+func Create(ctx context.Context, db *sql.DB, m Meeting) (Meeting, error) {
+	m.ID = uuid.NewString()
+	m.Status = StatusScheduled
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return m, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO meetings
+		(id, chama_id, title, description, meeting_date, location, meeting_type, is_virtual, virtual_meeting_link,
+		 status, absence_fine_minor, currency, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.ID, m.ChamaID, m.Title, m.Description, m.Date.UTC().Format("2006-01-02 15:04:05"), m.Location, m.Type,
+		m.IsVirtual, m.VirtualLink, m.Status, m.AbsenceFine.Amount, m.AbsenceFine.Currency, m.CreatedBy,
+	)
+	if err != nil {
+		return m, fmt.Errorf("failed to create meeting: %w", err)
+	}
+
+	for i := range m.Agenda {
+		item := &m.Agenda[i]
+		item.ID = uuid.NewString()
+		if item.Order == 0 {
+			item.Order = i + 1
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO meeting_agenda (id, meeting_id, title, description, order_index, duration_minutes, presenter_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			item.ID, m.ID, item.Title, item.Description, item.Order, item.DurationMinutes, nullIfEmpty(item.PresenterID),
+		)
+		if err != nil {
+			return m, fmt.Errorf("failed to add agenda item: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO meeting_attendance (id, meeting_id, member_id)
+		SELECT lower(hex(randomblob(16))), ?, user_id FROM chama_members
+		WHERE chama_id = ? AND status = 'active'`,
+		m.ID, m.ChamaID,
+	)
+	if err != nil {
+		return m, fmt.Errorf("failed to create attendance list: %w", err)
+	}
+	return m, tx.Commit()
+}
+
+const meetingColumns = `id, chama_id, title, COALESCE(description, ''), meeting_date, COALESCE(location, ''), meeting_type,
+	COALESCE(is_virtual, FALSE), COALESCE(virtual_meeting_link, ''), status, absence_fine_minor, currency, created_by`
+
+func scanMeeting(row interface{ Scan(...interface{}) error }) (Meeting, error) {
+	var m Meeting
+	err := row.Scan(&m.ID, &m.ChamaID, &m.Title, &m.Description, &m.Date, &m.Location, &m.Type,
+		&m.IsVirtual, &m.VirtualLink, &m.Status, &m.AbsenceFine.Amount, &m.AbsenceFine.Currency, &m.CreatedBy)
+	return m, err
+}
+
+// Get returns a meeting with its agenda
+func Get(ctx context.Context, db *sql.DB, id string) (Meeting, error) {
+	m, err := scanMeeting(db.QueryRowContext(ctx, `SELECT `+meetingColumns+` FROM meetings WHERE id = ?`, id))
+	if err != nil {
+		return m, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, title, COALESCE(description, ''), order_index, COALESCE(duration_minutes, 0), COALESCE(presenter_id, '')
+		FROM meeting_agenda WHERE meeting_id = ? ORDER BY order_index`, id)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a AgendaItem
+		if err := rows.Scan(&a.ID, &a.Title, &a.Description, &a.Order, &a.DurationMinutes, &a.PresenterID); err != nil {
+			return m, err
+		}
+		m.Agenda = append(m.Agenda, a)
+	}
+	return m, rows.Err()
+}
+
+// List returns a chama's meetings, soonest first. upcoming limits the list to
+// scheduled meetings that have not happened yet.
+func List(ctx context.Context, db *sql.DB, chamaID string, upcoming bool) ([]Meeting, error) {
+	query := `SELECT ` + meetingColumns + ` FROM meetings WHERE chama_id = ?`
+	if upcoming {
+		query += ` AND status = 'scheduled' AND meeting_date >= CURRENT_TIMESTAMP`
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY meeting_date`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Meeting{}
+	for rows.Next() {
+		m, err := scanMeeting(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+// Attendance returns the attendance list for a meeting
+func Attendance(ctx context.Context, db *sql.DB, meetingID string) ([]Attendee, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.member_id, TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')),
+		       a.attendance_status, COALESCE(a.rsvp, ''), COALESCE(a.check_in_time, ''), COALESCE(a.notes, '')
+		FROM meeting_attendance a LEFT JOIN users u ON u.user_id = a.member_id
+		WHERE a.meeting_id = ? ORDER BY u.first_name, u.last_name`, meetingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Attendee{}
+	for rows.Next() {
+		var a Attendee
+		if err := rows.Scan(&a.MemberID, &a.Name, &a.Status, &a.RSVP, &a.CheckIn, &a.Notes); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// RSVP records a member's response to a scheduled meeting
+func RSVP(ctx context.Context, db *sql.DB, meetingID, memberID, response string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE meeting_attendance SET rsvp = ?, rsvp_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE meeting_id = ? AND member_id = ?
+		  AND (SELECT status FROM meetings WHERE id = ?) IN ('scheduled', 'ongoing')`,
+		response, meetingID, memberID, meetingID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotInvited
+	}
+	return nil
+}
+
+// RecordAttendance sets the attendance status of the given members. Members
+// marked present are checked in at the time of recording.
+func RecordAttendance(ctx context.Context, db *sql.DB, meetingID string, attendees []Attendee) error {
+	m, err := scanMeeting(db.QueryRowContext(ctx, `SELECT `+meetingColumns+` FROM meetings WHERE id = ?`, meetingID))
+	if err != nil {
+		return err
+	}
+	if m.Status == StatusCompleted || m.Status == StatusCancelled {
+		return ErrClosed
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, a := range attendees {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE meeting_attendance SET attendance_status = ?, notes = ?,
+			       check_in_time = CASE WHEN ? = 'present' THEN COALESCE(check_in_time, CURRENT_TIMESTAMP) ELSE NULL END,
+			       updated_at = CURRENT_TIMESTAMP
+			WHERE meeting_id = ? AND member_id = ?`,
+			a.Status, nullIfEmpty(a.Notes), a.Status, meetingID, a.MemberID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("%w: %s", ErrNotInvited, a.MemberID)
+		}
+	}
+	if m.Status == StatusScheduled {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE meetings SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, StatusOngoing, meetingID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Complete closes a meeting. Anyone whose attendance was never recorded is
+// marked absent, and every absent member is fined the meeting's absence fine.
+// It returns the fines that were issued.
+func Complete(ctx context.Context, db *sql.DB, meetingID, closedBy string) ([]fines.Fine, error) {
+	m, err := Get(ctx, db, meetingID)
+	if err != nil {
+		return nil, err
+	}
+	if m.Status == StatusCompleted || m.Status == StatusCancelled {
+		return nil, ErrClosed
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE meeting_attendance SET attendance_status = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE meeting_id = ? AND attendance_status = ?`, AttendanceAbsent, meetingID, AttendancePending); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE meetings SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, StatusCompleted, meetingID); err != nil {
+		return nil, err
+	}
+
+	var issued []fines.Fine
+	if m.AbsenceFine.Amount > 0 {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT member_id FROM meeting_attendance WHERE meeting_id = ? AND attendance_status = ?`,
+			meetingID, AttendanceAbsent)
+		if err != nil {
+			return nil, err
+		}
+		var absent []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			absent = append(absent, id)
+		}
+		rows.Close()
+
+		for _, memberID := range absent {
+			f, ok, err := fines.Issue(ctx, tx, fines.Fine{
+				ChamaID:     m.ChamaID,
+				MemberID:    memberID,
+				Reason:      fines.ReasonAbsence,
+				Description: "Absent from " + m.Title + " on " + m.Date.Format("2 Jan 2006"),
+				Amount:      m.AbsenceFine,
+				RelatedID:   m.ID,
+				IssuedBy:    closedBy,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				issued = append(issued, f)
+			}
+		}
+	}
+	return issued, tx.Commit()
+}
+
+// Cancel marks a meeting that has not yet closed as cancelled
+func Cancel(ctx context.Context, db *sql.DB, meetingID string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE meetings SET status = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status IN ('scheduled', 'ongoing')`, StatusCancelled, meetingID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrClosed
+	}
+	return nil
+}
+
+// AddMinutes records minutes for a meeting
+func AddMinutes(ctx context.Context, db *sql.DB, mins Minutes) (Minutes, error) {
+	mins.ID = uuid.NewString()
+	mins.HasDocument = mins.StorageKey != ""
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO meeting_minutes (id, meeting_id, content, storage_key, mime_type, recorded_by, is_published)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		mins.ID, mins.MeetingID, mins.Content, nullIfEmpty(mins.StorageKey), nullIfEmpty(mins.MimeType), mins.RecordedBy, mins.IsPublished,
+	)
+	if err != nil {
+		return mins, fmt.Errorf("failed to save minutes: %w", err)
+	}
+	return mins, nil
+}
+
+const minutesColumns = `id, meeting_id, content, COALESCE(storage_key, ''), COALESCE(mime_type, ''), recorded_by,
+	COALESCE(is_published, FALSE), created_at`
+
+func scanMinutes(row interface{ Scan(...interface{}) error }) (Minutes, error) {
+	var mins Minutes
+	err := row.Scan(&mins.ID, &mins.MeetingID, &mins.Content, &mins.StorageKey, &mins.MimeType, &mins.RecordedBy, &mins.IsPublished, &mins.CreatedAt)
+	mins.HasDocument = mins.StorageKey != ""
+	return mins, err
+}
+
+// GetMinutes returns one set of minutes
+func GetMinutes(ctx context.Context, db *sql.DB, id string) (Minutes, error) {
+	return scanMinutes(db.QueryRowContext(ctx, `SELECT `+minutesColumns+` FROM meeting_minutes WHERE id = ?`, id))
+}
+
+// ListMinutes returns a meeting's minutes. Unpublished drafts are only included when drafts is set.
+func ListMinutes(ctx context.Context, db *sql.DB, meetingID string, drafts bool) ([]Minutes, error) {
+	query := `SELECT ` + minutesColumns + ` FROM meeting_minutes WHERE meeting_id = ?`
+	if !drafts {
+		query += ` AND is_published = TRUE`
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY created_at`, meetingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Minutes{}
+	for rows.Next() {
+		mins, err := scanMinutes(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, mins)
+	}
+	return list, rows.Err()
+}
+
+// PublishMinutes makes draft minutes visible to all members
+func PublishMinutes(ctx context.Context, db *sql.DB, id string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE meeting_minutes SET is_published = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
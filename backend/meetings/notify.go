@@ -0,0 +1,85 @@
+package meetings
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+)
+
+// notifyAttendees sends the same message to everyone on the meeting's attendance list
+func notifyAttendees(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, m Meeting, titleKey, messageKey string) {
+	if notifier == nil {
+		return
+	}
+	attendees, err := Attendance(ctx, db, m.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load meeting attendees", "meeting_id", m.ID, "error", err)
+		return
+	}
+	params := messageParams(ctx, db, m)
+	for _, a := range attendees {
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    a.MemberID,
+			Title:     i18n.T(i18n.Default, titleKey, nil),
+			Message:   i18n.T(i18n.Default, messageKey, params),
+			Type:      notifications.TypeMeeting,
+			RelatedID: m.ID,
+		})
+	}
+}
+
+func messageParams(ctx context.Context, db *sql.DB, m Meeting) map[string]string {
+	var chama string
+	db.QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, m.ChamaID).Scan(&chama)
+	location := m.Location
+	if m.IsVirtual && m.VirtualLink != "" {
+		location = m.VirtualLink
+	}
+	return map[string]string{
+		"chama":    chama,
+		"title":    m.Title,
+		"date":     m.Date.Format("Mon 2 Jan 2006 15:04"),
+		"location": location,
+	}
+}
+
+// RegisterReminderJob sends a reminder to every invited member about a day
+// before each scheduled meeting. Each meeting is reminded about only once.
+func RegisterReminderJob(s *jobs.Scheduler, db *sql.DB, notifier *notifications.Notifier) {
+	s.Register("meeting_reminders", jobs.Every(time.Hour), func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `
+			SELECT id FROM meetings
+			WHERE status = 'scheduled' AND meeting_date > ? AND meeting_date <= ?`,
+			time.Now().UTC().Format("2006-01-02 15:04:05"), time.Now().UTC().Add(24*time.Hour).Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		for _, id := range ids {
+			s.Run(ctx, "meeting_reminder", id, func(ctx context.Context) error {
+				m, err := Get(ctx, db, id)
+				if err != nil {
+					return err
+				}
+				notifyAttendees(ctx, db, notifier, m, "meeting.reminder", "notification.meeting_reminder")
+				return nil
+			})
+		}
+		return nil
+	})
+}
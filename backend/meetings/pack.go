@@ -0,0 +1,296 @@
+package meetings
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/arrears"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/dashboard"
+	"tujifund-app/backend/export/pdf"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/storage"
+)
+
+// PackLeadTime is how long before a meeting its pack is sent to the officials
+var PackLeadTime = 48 * time.Hour
+
+// Pack is what officials bring to a meeting: the agenda, the minutes of the
+// last meeting, how this cycle's collection stands, loan applications
+// waiting for a decision and the members in arrears
+type Pack struct {
+	Meeting         Meeting                `json:"meeting"`
+	ChamaName       string                 `json:"chamaName"`
+	PreviousMeeting *Meeting               `json:"previousMeeting,omitempty"`
+	PreviousMinutes []Minutes              `json:"previousMinutes"`
+	Collection      dashboard.ChamaSummary `json:"collection"`
+	PendingLoans    []PendingLoan          `json:"pendingLoans"`
+	Defaulters      []arrears.Arrear       `json:"defaulters"`
+	GeneratedAt     time.Time              `json:"generatedAt"`
+}
+
+// PendingLoan is a loan application awaiting approval
+type PendingLoan struct {
+	ID         string      `json:"id"`
+	MemberID   string      `json:"memberId"`
+	MemberName string      `json:"memberName"`
+	Product    string      `json:"product"`
+	Amount     money.Money `json:"amount"`
+	Purpose    string      `json:"purpose,omitempty"`
+	AppliedAt  time.Time   `json:"appliedAt"`
+}
+
+// BuildPack assembles the pack for a meeting
+func BuildPack(ctx context.Context, db *sql.DB, meetingID string) (Pack, error) {
+	p := Pack{GeneratedAt: time.Now().UTC(), PreviousMinutes: []Minutes{}}
+	var err error
+	if p.Meeting, err = Get(ctx, db, meetingID); err != nil {
+		return p, err
+	}
+	chamaID := p.Meeting.ChamaID
+	if err := db.QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, chamaID).Scan(&p.ChamaName); err != nil {
+		return p, err
+	}
+
+	var previousID string
+	err = db.QueryRowContext(ctx, `
+		SELECT id FROM meetings WHERE chama_id = ? AND status = ? AND meeting_date < ?
+		ORDER BY meeting_date DESC LIMIT 1`,
+		chamaID, StatusCompleted, p.Meeting.Date.UTC().Format("2006-01-02 15:04:05")).Scan(&previousID)
+	if err != nil && err != sql.ErrNoRows {
+		return p, err
+	}
+	if previousID != "" {
+		previous, err := Get(ctx, db, previousID)
+		if err != nil {
+			return p, err
+		}
+		p.PreviousMeeting = &previous
+		if p.PreviousMinutes, err = ListMinutes(ctx, db, previousID, false); err != nil {
+			return p, err
+		}
+	}
+
+	if p.Collection, err = dashboard.ChamaDashboard(ctx, db, chamaID); err != nil {
+		return p, err
+	}
+	if p.PendingLoans, err = pendingLoans(ctx, db, chamaID); err != nil {
+		return p, err
+	}
+	if p.Defaulters, err = arrears.List(ctx, db, chamaID, arrears.Filter{}); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// pendingLoans returns the chama's loan applications awaiting approval, oldest first
+func pendingLoans(ctx context.Context, db *sql.DB, chamaID string) ([]PendingLoan, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.id, a.user_id,
+		       COALESCE(NULLIF(TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')), ''), u.username, ''),
+		       COALESCE(p.name, ''), a.amount_minor, a.currency, COALESCE(a.purpose, ''), a.application_date
+		FROM loan_applications a
+		LEFT JOIN users u ON u.user_id = a.user_id
+		LEFT JOIN loan_products p ON p.id = a.loan_product_id
+		WHERE a.chama_id = ? AND a.status = 'pending'
+		ORDER BY a.application_date`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []PendingLoan{}
+	for rows.Next() {
+		var l PendingLoan
+		if err := rows.Scan(&l.ID, &l.MemberID, &l.MemberName, &l.Product, &l.Amount.Amount, &l.Amount.Currency,
+			&l.Purpose, &l.AppliedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	return list, rows.Err()
+}
+
+// WritePackPDF renders a meeting pack as PDF in lang
+func WritePackPDF(w io.Writer, p Pack, lang string) error {
+	t := func(key string) string { return i18n.T(lang, key, nil) }
+
+	doc := pdf.New(t("meeting_pack.title"))
+	doc.Heading(p.ChamaName + " - " + p.Meeting.Title)
+	doc.Line(p.Meeting.Date.Format("Mon 2 Jan 2006 15:04"))
+	if location := p.Meeting.Location; location != "" {
+		doc.Line(location)
+	}
+	if p.Meeting.IsVirtual && p.Meeting.VirtualLink != "" {
+		doc.Line(p.Meeting.VirtualLink)
+	}
+
+	doc.Heading(t("meeting_pack.agenda"))
+	if len(p.Meeting.Agenda) == 0 {
+		doc.Line(t("meeting_pack.none"))
+	}
+	for _, item := range p.Meeting.Agenda {
+		line := fmt.Sprintf("%d. %s", item.Order, item.Title)
+		if item.DurationMinutes > 0 {
+			line += fmt.Sprintf(" (%d min)", item.DurationMinutes)
+		}
+		doc.Line(line)
+		for _, l := range wrap(item.Description, 90) {
+			doc.Line("    " + l)
+		}
+	}
+
+	doc.Heading(t("meeting_pack.previous_minutes"))
+	if p.PreviousMeeting != nil {
+		doc.Line(p.PreviousMeeting.Title + " - " + p.PreviousMeeting.Date.Format("2 Jan 2006"))
+	}
+	if len(p.PreviousMinutes) == 0 {
+		doc.Line(t("meeting_pack.none"))
+	}
+	for _, mins := range p.PreviousMinutes {
+		for _, l := range wrap(mins.Content, 95) {
+			doc.Line(l)
+		}
+		doc.Space()
+	}
+
+	c := p.Collection
+	from, to := c.Cycle.Start.Format("2 Jan"), c.Cycle.End.AddDate(0, 0, -1).Format("2 Jan 2006")
+	doc.Heading(i18n.T(lang, "meeting_pack.collection", map[string]string{"from": from, "to": to}))
+	summary := []float64{300, 195}
+	doc.Row(false, summary, t("meeting_pack.expected"), c.Expected.String())
+	doc.Row(false, summary, t("meeting_pack.collected"), c.Collected.String())
+	doc.Row(false, summary, t("meeting_pack.collection_rate"), fmt.Sprintf("%d.%02d%%", c.CollectionRateBps/100, c.CollectionRateBps%100))
+	doc.Row(false, summary, t("meeting_pack.paid_members"), fmt.Sprintf("%d / %d", c.PaidMembers, c.Members))
+	doc.Row(false, summary, t("meeting_pack.total_savings"), c.TotalSavings.String())
+	doc.Row(false, summary, t("meeting_pack.outstanding_loans"), c.OutstandingLoans.String())
+
+	doc.Heading(t("meeting_pack.pending_loans"))
+	if len(p.PendingLoans) == 0 {
+		doc.Line(t("meeting_pack.none"))
+	} else {
+		widths := []float64{70, 120, 95, 130, 80}
+		doc.Row(true, widths, t("statement.date"), t("meeting_pack.member"), t("meeting_pack.product"), t("meeting_pack.purpose"), t("statement.amount"))
+		for _, l := range p.PendingLoans {
+			doc.Row(false, widths, l.AppliedAt.Format("2006-01-02"), l.MemberName, l.Product, l.Purpose, l.Amount.Decimal())
+		}
+	}
+
+	doc.Heading(t("meeting_pack.defaulters"))
+	if len(p.Defaulters) == 0 {
+		doc.Line(t("meeting_pack.none"))
+	} else {
+		widths := []float64{150, 90, 85, 80, 90}
+		doc.Row(true, widths, t("meeting_pack.member"), t("statement.type"), t("meeting_pack.due"), t("meeting_pack.days_overdue"), t("meeting_pack.owed"))
+		for _, a := range p.Defaulters {
+			doc.Row(false, widths, a.MemberName, a.Kind, a.DueDate, fmt.Sprint(a.DaysOverdue), a.Owed.Decimal())
+		}
+	}
+
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// wrap splits s into lines of at most width characters, breaking between words
+func wrap(s string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.TrimSpace(s), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			if line != "" && len(line)+1+len(word) > width {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// SendPack builds a meeting's pack, stores it and tells the chama's officials it is ready
+func SendPack(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, meetingID string) error {
+	p, err := BuildPack(ctx, db, meetingID)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := WritePackPDF(&buf, p, i18n.Default); err != nil {
+		return err
+	}
+	path := fmt.Sprintf("meetings/%s/%s/pack.pdf", p.Meeting.ChamaID, p.Meeting.ID)
+	if err := store.Put(ctx, path, &buf, int64(buf.Len()), "application/pdf"); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO meeting_packs (meeting_id, storage_key) VALUES (?, ?)
+		ON CONFLICT (meeting_id) DO UPDATE SET storage_key = excluded.storage_key, generated_at = CURRENT_TIMESTAMP`,
+		p.Meeting.ID, path)
+	if err != nil {
+		return err
+	}
+
+	if notifier == nil {
+		return nil
+	}
+	officials, err := chamas.MembersWithRole(db, p.Meeting.ChamaID, chamas.OfficialRoles...)
+	if err != nil {
+		return err
+	}
+	params := messageParams(ctx, db, p.Meeting)
+	for _, id := range officials {
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "meeting_pack.ready", nil),
+			Message:   i18n.T(i18n.Default, "notification.meeting_pack_ready", params),
+			Type:      notifications.TypeMeeting,
+			RelatedID: p.Meeting.ID,
+		})
+	}
+	return nil
+}
+
+// RegisterPackJob sends each scheduled meeting's pack to the officials once,
+// PackLeadTime before it starts
+func RegisterPackJob(s *jobs.Scheduler, db *sql.DB, store storage.Backend, notifier *notifications.Notifier) {
+	s.Register("meeting_packs", jobs.Every(time.Hour), func(ctx context.Context) error {
+		now := time.Now().UTC()
+		rows, err := db.QueryContext(ctx, `
+			SELECT id FROM meetings
+			WHERE status = 'scheduled' AND meeting_date > ? AND meeting_date <= ?`,
+			now.Format("2006-01-02 15:04:05"), now.Add(PackLeadTime).Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		for _, id := range ids {
+			s.Run(ctx, "meeting_pack", id, func(ctx context.Context) error {
+				return SendPack(ctx, db, store, notifier, id)
+			})
+		}
+		return nil
+	})
+}
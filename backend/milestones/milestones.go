@@ -0,0 +1,347 @@
+// Package milestones celebrates members' and chamas' milestones: chama and
+// membership anniversaries, birthdays, a member's 100th contribution, a loan
+// fully repaid. What counts as a milestone and what is said about it are
+// rules kept in the database, so new ones can be added from the admin API
+// without a deploy; the code only provides the triggers rules are built on.
+// Each milestone is celebrated once, with a notification to the member or to
+// the whole chama.
+package milestones
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+
+	"github.com/google/uuid"
+)
+
+// Audiences
+const (
+	AudienceMember = "member" // the member the milestone is about
+	AudienceChama  = "chama"  // every active member of the chama
+)
+
+var (
+	// ErrNotFound is returned for a rule that does not exist
+	ErrNotFound = errors.New("milestone rule not found")
+	// ErrInvalidRule is returned for a rule with an unknown trigger or audience
+	ErrInvalidRule = errors.New("invalid milestone rule")
+)
+
+var keyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,63}$`)
+
+// Rule is a milestone and the notification celebrating it. Title and
+// Message may use the placeholders {name} (the member's first name),
+// {chama}, {count}, {years} and {amount}, as their trigger provides them.
+type Rule struct {
+	Key         string    `json:"key"`
+	Description string    `json:"description"`
+	Trigger     string    `json:"trigger"`
+	Threshold   int       `json:"threshold"` // see the trigger for its meaning
+	Audience    string    `json:"audience"`
+	Title       string    `json:"title"`
+	Message     string    `json:"message"`
+	Enabled     bool      `json:"enabled"`
+	UpdatedBy   string    `json:"updatedBy,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Validate checks that r can be run
+func (r Rule) Validate() error {
+	if !keyPattern.MatchString(r.Key) {
+		return errors.New("rule keys are lowercase letters, digits and underscores")
+	}
+	if _, ok := detectors[r.Trigger]; !ok {
+		return ErrInvalidRule
+	}
+	if r.Audience != AudienceMember && r.Audience != AudienceChama {
+		return ErrInvalidRule
+	}
+	if r.Trigger == TriggerChamaAnniversary && r.Audience != AudienceChama {
+		return errors.New("chama anniversaries are celebrated with the whole chama")
+	}
+	if r.Trigger == TriggerContributionCount && r.Threshold <= 0 {
+		return errors.New("a contribution count milestone needs a threshold")
+	}
+	if r.Threshold < 0 || strings.TrimSpace(r.Title) == "" || strings.TrimSpace(r.Message) == "" {
+		return ErrInvalidRule
+	}
+	return nil
+}
+
+// Defaults are the rules every installation starts with. EnsureDefaults adds
+// any that are missing, so disable a default rather than delete it.
+var Defaults = []Rule{
+	{
+		Key: "chama_anniversary", Description: "Every year since the chama was started",
+		Trigger: TriggerChamaAnniversary, Audience: AudienceChama, Enabled: true,
+		Title:   "Happy anniversary!",
+		Message: "Happy anniversary, {chama}! Years of saving together: {years}. Thank you, everyone!",
+	},
+	{
+		Key: "member_anniversary", Description: "Every year since a member joined",
+		Trigger: TriggerMemberAnniversary, Audience: AudienceMember, Enabled: true,
+		Title:   "Happy anniversary!",
+		Message: "Hi {name}, happy anniversary with {chama}! Years as a member: {years}. Thank you for being part of it!",
+	},
+	{
+		Key: "birthday", Description: "Members' birthdays, for those who have shared their date of birth",
+		Trigger: TriggerBirthday, Audience: AudienceMember, Enabled: true,
+		Title:   "Happy birthday!",
+		Message: "Happy birthday, {name}! Wishing you a wonderful year ahead.",
+	},
+	{
+		Key: "contribution_100", Description: "A member's 100th contribution to a chama",
+		Trigger: TriggerContributionCount, Threshold: 100, Audience: AudienceMember, Enabled: true,
+		Title:   "100 contributions!",
+		Message: "Congratulations {name}, you have made {count} contributions to {chama}. Keep it up!",
+	},
+	{
+		Key: "loan_repaid", Description: "Every loan a member repays in full",
+		Trigger: TriggerLoanRepaid, Audience: AudienceMember, Enabled: true,
+		Title:   "Loan repaid!",
+		Message: "Well done {name}, your {amount} loan from {chama} is fully repaid.",
+	},
+}
+
+// EnsureDefaults adds the default rules that are not in the database yet
+func EnsureDefaults(ctx context.Context, db *sql.DB) error {
+	for _, r := range Defaults {
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO milestone_rules (key, description, trigger_type, threshold, audience, title, message, enabled)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(key) DO NOTHING`,
+			r.Key, r.Description, r.Trigger, r.Threshold, r.Audience, r.Title, r.Message, r.Enabled)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns every rule
+func List(ctx context.Context, db *sql.DB) ([]Rule, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT key, COALESCE(description, ''), trigger_type, threshold, audience, title, message, enabled,
+		       COALESCE(updated_by, ''), updated_at
+		FROM milestone_rules ORDER BY key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Rule{}
+	for rows.Next() {
+		var r Rule
+		if err := rows.Scan(&r.Key, &r.Description, &r.Trigger, &r.Threshold, &r.Audience, &r.Title, &r.Message,
+			&r.Enabled, &r.UpdatedBy, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// Set creates or replaces a rule. Milestones it has already celebrated are
+// not celebrated again, even if its trigger or threshold changes.
+func Set(ctx context.Context, db *sql.DB, r Rule, e audit.Entry) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO milestone_rules (key, description, trigger_type, threshold, audience, title, message, enabled, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(key) DO UPDATE SET
+			description = excluded.description, trigger_type = excluded.trigger_type, threshold = excluded.threshold,
+			audience = excluded.audience, title = excluded.title, message = excluded.message,
+			enabled = excluded.enabled, updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP`,
+		r.Key, r.Description, r.Trigger, r.Threshold, r.Audience, r.Title, r.Message, r.Enabled, e.UserID)
+	if err != nil {
+		return err
+	}
+	e.Action, e.EntityType, e.EntityID = "milestone_rule.set", "milestone_rule", r.Key
+	e.NewValues = r
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes a rule. The milestones it celebrated stay in members' history.
+func Delete(ctx context.Context, db *sql.DB, key string, e audit.Entry) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM milestone_rules WHERE key = ?`, key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	e.Action, e.EntityType, e.EntityID = "milestone_rule.delete", "milestone_rule", key
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Milestone is a celebrated milestone
+type Milestone struct {
+	ID        string    `json:"id"`
+	RuleKey   string    `json:"ruleKey"`
+	ChamaID   string    `json:"chamaId,omitempty"`
+	MemberID  string    `json:"memberId,omitempty"`
+	Title     string    `json:"title"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ForMember returns the milestones about a member, latest first
+func ForMember(ctx context.Context, db *sql.DB, memberID string) ([]Milestone, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, rule_key, chama_id, member_id, title, message, created_at
+		FROM milestone_events WHERE member_id = ? ORDER BY created_at DESC`, memberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Milestone{}
+	for rows.Next() {
+		var m Milestone
+		if err := rows.Scan(&m.ID, &m.RuleKey, &m.ChamaID, &m.MemberID, &m.Title, &m.Message, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+// Check runs every enabled rule as of now and celebrates the milestones they
+// find that have not been celebrated yet. A rule that fails is logged and
+// skipped.
+func Check(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, now time.Time) error {
+	rules, err := List(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		if !r.Enabled {
+			continue
+		}
+		detect, ok := detectors[r.Trigger]
+		if !ok {
+			slog.WarnContext(ctx, "Milestone rule has an unknown trigger", "rule", r.Key, "trigger", r.Trigger)
+			continue
+		}
+		found, err := detect(ctx, db, r, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check milestone rule", "rule", r.Key, "error", err)
+			continue
+		}
+		for _, f := range found {
+			if err := celebrate(ctx, db, notifier, r, f); err != nil {
+				slog.ErrorContext(ctx, "Failed to celebrate milestone", "rule", r.Key, "chama_id", f.chamaID,
+					"member_id", f.memberID, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// celebrate records f, and notifies its audience if it had not been
+// recorded before
+func celebrate(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, r Rule, f finding) error {
+	params := f.params
+	if params == nil {
+		params = map[string]string{}
+	}
+	if f.chamaID != "" && params["chama"] == "" {
+		var name string
+		db.QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, f.chamaID).Scan(&name)
+		params["chama"] = name
+	}
+	if f.memberID != "" && params["name"] == "" {
+		var name string
+		db.QueryRowContext(ctx, `SELECT COALESCE(NULLIF(first_name, ''), username) FROM users WHERE user_id = ?`,
+			f.memberID).Scan(&name)
+		params["name"] = name
+	}
+	m := Milestone{
+		ID:       uuid.NewString(),
+		RuleKey:  r.Key,
+		ChamaID:  f.chamaID,
+		MemberID: f.memberID,
+		Title:    render(r.Title, params),
+		Message:  render(r.Message, params),
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO milestone_events (id, rule_key, chama_id, member_id, occurrence, title, message)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(rule_key, chama_id, member_id, occurrence) DO NOTHING`,
+		m.ID, m.RuleKey, m.ChamaID, m.MemberID, f.occurrence, m.Title, m.Message)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 || notifier == nil {
+		return nil
+	}
+
+	recipients := []string{f.memberID}
+	if r.Audience == AudienceChama {
+		recipients, err = chamas.MembersWithRole(db, f.chamaID, chamas.RoleAdmin, chamas.RoleChairperson,
+			chamas.RoleTreasurer, chamas.RoleSecretary, chamas.RoleMember)
+		if err != nil {
+			return err
+		}
+	}
+	relatedID := f.relatedID
+	if relatedID == "" {
+		relatedID = f.chamaID
+	}
+	for _, userID := range recipients {
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    userID,
+			Title:     m.Title,
+			Message:   m.Message,
+			Type:      notifications.TypeChama,
+			RelatedID: relatedID,
+			Priority:  notifications.PriorityLow,
+		})
+	}
+	return nil
+}
+
+// render replaces template's {name} placeholders with params
+func render(template string, params map[string]string) string {
+	for k, v := range params {
+		template = strings.ReplaceAll(template, "{"+k+"}", v)
+	}
+	return template
+}
+
+// RegisterJob checks for milestones every morning
+func RegisterJob(s *jobs.Scheduler, db *sql.DB, notifier *notifications.Notifier) {
+	s.Register("milestones", jobs.Daily{Hour: 6}, func(ctx context.Context) error {
+		return Check(ctx, db, notifier, time.Now())
+	})
+}
@@ -0,0 +1,227 @@
+package milestones
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/money"
+)
+
+// Triggers rules are built on
+const (
+	// TriggerChamaAnniversary fires on the anniversary of a chama's start.
+	// Threshold is the anniversary to celebrate, or 0 for every one.
+	TriggerChamaAnniversary = "chama_anniversary"
+	// TriggerMemberAnniversary fires on the anniversary of a member joining
+	// a chama. Threshold is as for TriggerChamaAnniversary.
+	TriggerMemberAnniversary = "member_anniversary"
+	// TriggerBirthday fires on the birthday of a member who has given their
+	// date of birth. With AudienceChama it is announced in each of their chamas.
+	TriggerBirthday = "birthday"
+	// TriggerContributionCount fires on a member's Threshold-th completed
+	// contribution to a chama
+	TriggerContributionCount = "contribution_count"
+	// TriggerLoanRepaid fires when a member repays a chama loan in full.
+	// Threshold is the member's nth repaid loan in the chama to celebrate,
+	// or 0 for every one.
+	TriggerLoanRepaid = "loan_repaid"
+)
+
+// Triggers lists every trigger
+var Triggers = []string{TriggerChamaAnniversary, TriggerMemberAnniversary, TriggerBirthday, TriggerContributionCount, TriggerLoanRepaid}
+
+var (
+	// LookbackDays is how far back triggers look on each run, so milestones
+	// reached while the job was not running are still celebrated. Each is
+	// celebrated once, so overlapping runs do no harm, and milestones older
+	// than this are not celebrated when a rule is added.
+	LookbackDays = 3
+	// Location is the time zone dates are judged in
+	Location = time.FixedZone("EAT", 3*60*60)
+)
+
+// finding is a milestone found by a trigger. occurrence tells repeated
+// milestones apart, e.g. the year of an anniversary.
+type finding struct {
+	chamaID    string
+	memberID   string
+	occurrence string
+	relatedID  string
+	params     map[string]string
+}
+
+// detectors find each trigger's milestones as of now
+var detectors = map[string]func(ctx context.Context, db *sql.DB, r Rule, now time.Time) ([]finding, error){
+	TriggerChamaAnniversary:  chamaAnniversaries,
+	TriggerMemberAnniversary: memberAnniversaries,
+	TriggerBirthday:          birthdays,
+	TriggerContributionCount: contributionCounts,
+	TriggerLoanRepaid:        loansRepaid,
+}
+
+// days returns the dates of the last LookbackDays days up to now, as
+// YYYY-MM-DD. On 28 February of a common year it also returns 29 February,
+// so leap-day anniversaries are not skipped.
+func days(now time.Time) []string {
+	today := now.In(Location)
+	var list []string
+	for i := 0; i <= LookbackDays; i++ {
+		d := today.AddDate(0, 0, -i)
+		list = append(list, d.Format("2006-01-02"))
+		if d.Month() == time.February && d.Day() == 28 && d.AddDate(0, 0, 1).Month() == time.March {
+			list = append(list, d.Format("2006")+"-02-29")
+		}
+	}
+	return list
+}
+
+// anniversaries matches the dates in query's third column against the last
+// few days. query takes the month-days to match as its only arguments, and
+// returns a chama ID, a member ID and a date starting YYYY-MM-DD.
+func anniversaries(ctx context.Context, db *sql.DB, r Rule, now time.Time, query string) ([]finding, error) {
+	byMonthDay := map[string]string{} // MM-DD to the YYYY-MM-DD it falls on
+	var args []interface{}
+	for _, d := range days(now) {
+		byMonthDay[d[5:]] = d
+		args = append(args, d[5:])
+	}
+	query = strings.Replace(query, "(?)", "(?"+strings.Repeat(", ?", len(args)-1)+")", 1)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []finding
+	for rows.Next() {
+		var chamaID, memberID, date string
+		if err := rows.Scan(&chamaID, &memberID, &date); err != nil {
+			return nil, err
+		}
+		if len(date) < 10 {
+			continue
+		}
+		on, ok := byMonthDay[date[5:10]]
+		if !ok {
+			continue
+		}
+		years := atoi(on[:4]) - atoi(date[:4])
+		if years < 1 || (r.Threshold > 0 && years != r.Threshold) {
+			continue
+		}
+		found = append(found, finding{
+			chamaID: chamaID, memberID: memberID, occurrence: on[:4],
+			params: map[string]string{"years": strconv.Itoa(years)},
+		})
+	}
+	return found, rows.Err()
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func chamaAnniversaries(ctx context.Context, db *sql.DB, r Rule, now time.Time) ([]finding, error) {
+	return anniversaries(ctx, db, r, now, `
+		SELECT id, '', substr(created_at, 1, 10) FROM chamas
+		WHERE status = 'active' AND substr(created_at, 6, 5) IN (?)`)
+}
+
+func memberAnniversaries(ctx context.Context, db *sql.DB, r Rule, now time.Time) ([]finding, error) {
+	return anniversaries(ctx, db, r, now, `
+		SELECT m.chama_id, m.user_id, substr(m.join_date, 1, 10)
+		FROM chama_members m JOIN chamas c ON c.id = m.chama_id
+		WHERE m.status = 'active' AND c.status = 'active' AND substr(m.join_date, 6, 5) IN (?)`)
+}
+
+// birthdays finds members' birthdays. Said to the member, a birthday is
+// celebrated once whatever chamas they are in, so it has no chama.
+func birthdays(ctx context.Context, db *sql.DB, r Rule, now time.Time) ([]finding, error) {
+	query := `
+		SELECT '', u.user_id, u.date_of_birth FROM users u
+		WHERE u.date_of_birth IS NOT NULL AND u.erased_at IS NULL AND u.suspended_at IS NULL
+		  AND substr(u.date_of_birth, 6, 5) IN (?)`
+	if r.Audience == AudienceChama {
+		query = `
+			SELECT m.chama_id, u.user_id, u.date_of_birth
+			FROM users u JOIN chama_members m ON m.user_id = u.user_id AND m.status = 'active'
+			JOIN chamas c ON c.id = m.chama_id AND c.status = 'active'
+			WHERE u.date_of_birth IS NOT NULL AND u.erased_at IS NULL AND u.suspended_at IS NULL
+			  AND substr(u.date_of_birth, 6, 5) IN (?)`
+	}
+	// Ages are nobody else's business, so the rule's threshold is ignored
+	// and {years} is not offered
+	r.Threshold = 0
+	found, err := anniversaries(ctx, db, r, now, query)
+	for i := range found {
+		delete(found[i].params, "years")
+	}
+	return found, err
+}
+
+// contributionCounts finds members whose Threshold-th completed
+// contribution to a chama was completed in the last few days
+func contributionCounts(ctx context.Context, db *sql.DB, r Rule, now time.Time) ([]finding, error) {
+	from := now.UTC().AddDate(0, 0, -LookbackDays).Format("2006-01-02 15:04:05")
+	rows, err := db.QueryContext(ctx, `
+		SELECT chama_id, member_id, id FROM (
+			SELECT chama_id, member_id, id, updated_at,
+			       ROW_NUMBER() OVER (PARTITION BY chama_id, member_id ORDER BY contribution_date, id) AS n
+			FROM contributions
+			WHERE status = 'completed' AND member_id IN (
+				SELECT member_id FROM contributions WHERE status = 'completed' AND updated_at >= ?)
+		) c
+		WHERE n = ? AND updated_at >= ?`, from, r.Threshold, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []finding
+	for rows.Next() {
+		var f finding
+		if err := rows.Scan(&f.chamaID, &f.memberID, &f.relatedID); err != nil {
+			return nil, err
+		}
+		f.occurrence = strconv.Itoa(r.Threshold)
+		f.params = map[string]string{"count": f.occurrence}
+		found = append(found, f)
+	}
+	return found, rows.Err()
+}
+
+// loansRepaid finds chama loans repaid in full in the last few days
+func loansRepaid(ctx context.Context, db *sql.DB, r Rule, now time.Time) ([]finding, error) {
+	from := now.UTC().AddDate(0, 0, -LookbackDays).Format("2006-01-02 15:04:05")
+	rows, err := db.QueryContext(ctx, `
+		SELECT chama_id, borrower_id, id, principal_minor, currency, n FROM (
+			SELECT chama_id, borrower_id, id, principal_minor, currency, actual_end_date,
+			       ROW_NUMBER() OVER (PARTITION BY chama_id, borrower_id ORDER BY actual_end_date, id) AS n
+			FROM loans
+			WHERE status = 'completed' AND chama_id IS NOT NULL AND deleted_at IS NULL
+		) l
+		WHERE actual_end_date >= ? AND (? = 0 OR n = ?)`, from, r.Threshold, r.Threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []finding
+	for rows.Next() {
+		var f finding
+		var principal int64
+		var currency string
+		var n int
+		if err := rows.Scan(&f.chamaID, &f.memberID, &f.relatedID, &principal, &currency, &n); err != nil {
+			return nil, err
+		}
+		f.occurrence = f.relatedID
+		f.params = map[string]string{"amount": money.New(principal, currency).String(), "count": strconv.Itoa(n)}
+		found = append(found, f)
+	}
+	return found, rows.Err()
+}
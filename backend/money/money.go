@@ -0,0 +1,378 @@
+package money
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"tujifund-app/backend/tenancy"
+	"tujifund-app/backend/txn"
+)
+
+// DefaultCurrency is used when a chama has not configured one
+const DefaultCurrency = "KES"
+
+// Currency describes an ISO 4217 currency and the number of minor units in one major unit
+type Currency struct {
+	Code     string
+	Symbol   string
+	Exponent int
+}
+
+// Currencies lists the currencies the platform accepts
+var Currencies = map[string]Currency{
+	"KES": {Code: "KES", Symbol: "KSh", Exponent: 2},
+	"UGX": {Code: "UGX", Symbol: "USh", Exponent: 0},
+	"TZS": {Code: "TZS", Symbol: "TSh", Exponent: 2},
+	"USD": {Code: "USD", Symbol: "$", Exponent: 2},
+	"EUR": {Code: "EUR", Symbol: "€", Exponent: 2},
+	"GBP": {Code: "GBP", Symbol: "£", Exponent: 2},
+}
+
+// Codes lists the codes of the accepted currencies in order
+func Codes() []string {
+	codes := make([]string, 0, len(Currencies))
+	for code := range Currencies {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrOverflow         = errors.New("amount overflow")
+	ErrInvalidAmount    = errors.New("invalid amount")
+)
+
+// Money is an amount in integer minor units (e.g. cents) with its currency code.
+// All arithmetic is done on integers; never convert money to float64.
+type Money struct {
+	Amount   int64  `json:"amountMinor"`
+	Currency string `json:"currency"`
+}
+
+// New creates an amount from minor units
+func New(minor int64, currency string) Money {
+	return Money{Amount: minor, Currency: currency}
+}
+
+// Lookup returns the currency definition for code
+func Lookup(code string) (Currency, error) {
+	c, ok := Currencies[strings.ToUpper(code)]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %s", ErrUnknownCurrency, code)
+	}
+	return c, nil
+}
+
+// Parse converts a decimal string such as "1500.50" into Money without going
+// through floating point. A single leading "-" makes the amount negative; any
+// other sign is refused.
+func Parse(value, currency string) (Money, error) {
+	c, err := Lookup(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	value = strings.TrimSpace(strings.ReplaceAll(value, ",", ""))
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+
+	whole, frac, _ := strings.Cut(value, ".")
+	if !digits(whole) || (frac != "" && !digits(frac)) || len(frac) > c.Exponent {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	frac += strings.Repeat("0", c.Exponent-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Amount: minor, Currency: c.Code}, nil
+}
+
+// digits reports whether s is one or more ASCII digits
+func digits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the amount in major units, e.g. "KES 1,500.50"
+func (m Money) String() string {
+	c, err := Lookup(m.Currency)
+	if err != nil {
+		return fmt.Sprintf("%s %d", m.Currency, m.Amount)
+	}
+	return c.Code + " " + m.Decimal()
+}
+
+// Decimal formats the amount in major units with thousands separators and no currency code
+func (m Money) Decimal() string {
+	exp := 2
+	if c, err := Lookup(m.Currency); err == nil {
+		exp = c.Exponent
+	}
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+	}
+	digits := strconv.FormatUint(absUint(amount), 10)
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-exp], digits[len(digits)-exp:]
+
+	var grouped strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(r)
+	}
+	if exp == 0 {
+		return sign + grouped.String()
+	}
+	return sign + grouped.String() + "." + frac
+}
+
+func absUint(v int64) uint64 {
+	if v < 0 {
+		return uint64(-(v + 1)) + 1
+	}
+	return uint64(v)
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool { return m.Amount == 0 }
+
+// IsNegative reports whether the amount is below zero
+func (m Money) IsNegative() bool { return m.Amount < 0 }
+
+// Add returns m + o. Both amounts must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Sub returns m - o. Both amounts must be in the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(Money{Amount: -o.Amount, Currency: o.Currency})
+}
+
+// Negate returns -m
+func (m Money) Negate() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// MulInt returns m * n
+func (m Money) MulInt(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+	product := m.Amount * n
+	if product/n != m.Amount {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// ApplyRate returns m scaled by a rate in basis points (100 bps = 1%), rounding
+// half away from zero to the nearest minor unit
+func (m Money) ApplyRate(bps int64) (Money, error) {
+	product, err := m.MulInt(bps)
+	if err != nil {
+		return Money{}, err
+	}
+	q, r := product.Amount/10000, product.Amount%10000
+	if r >= 5000 {
+		q++
+	} else if r <= -5000 {
+		q--
+	}
+	return Money{Amount: q, Currency: m.Currency}, nil
+}
+
+// ParsePercent converts a percentage such as "12.5" or "12.5%" to basis
+// points (1250) without going through floating point. It refuses a
+// fraction of a basis point.
+func ParsePercent(value string) (int64, error) {
+	whole, frac, dot := strings.Cut(strings.TrimSuffix(strings.TrimSpace(value), "%"), ".")
+	if !digits(whole) || (dot && !digits(frac)) || len(frac) > 2 {
+		return 0, fmt.Errorf("%w: percentage %q", ErrInvalidAmount, value)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	bps, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: percentage %q", ErrInvalidAmount, value)
+	}
+	return bps, nil
+}
+
+// RateScale is the fixed-point scale exchange rates are kept at, so that a
+// rate of 129.25 is stored as 129250000
+const RateScale = 1000000
+
+// ParseRate converts a decimal exchange rate such as "129.25" to RateScale
+// without going through floating point
+func ParseRate(value string) (int64, error) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(value), ".")
+	if !digits(whole) || (frac != "" && !digits(frac)) {
+		return 0, fmt.Errorf("%w: rate %q", ErrInvalidAmount, value)
+	}
+	if len(frac) > 6 {
+		frac = frac[:6]
+	}
+	frac += strings.Repeat("0", 6-len(frac))
+	rate, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("%w: rate %q", ErrInvalidAmount, value)
+	}
+	return rate, nil
+}
+
+// FormatRate formats a RateScale exchange rate as a decimal, e.g. "129.25"
+func FormatRate(rate int64) string {
+	s := strconv.FormatInt(rate/RateScale, 10)
+	if frac := strings.TrimRight(fmt.Sprintf("%06d", rate%RateScale), "0"); frac != "" {
+		s += "." + frac
+	}
+	return s
+}
+
+// Convert returns m in currency at rate, the RateScale price of one major
+// unit of m's currency in currency, rounding half away from zero to the
+// nearest minor unit
+func (m Money) Convert(currency string, rate int64) (Money, error) {
+	from, err := Lookup(m.Currency)
+	if err != nil {
+		return Money{}, err
+	}
+	to, err := Lookup(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	num := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(rate))
+	den := big.NewInt(RateScale)
+	if shift := to.Exponent - from.Exponent; shift > 0 {
+		num.Mul(num, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(shift)), nil))
+	} else if shift < 0 {
+		den.Mul(den, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-shift)), nil))
+	}
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Abs(r).Mul(r, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+	if !q.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: q.Int64(), Currency: to.Code}, nil
+}
+
+// Allocate splits m into len(ratios) parts proportional to ratios. Leftover
+// minor units from rounding go to the first parts so the parts always sum to m.
+func (m Money) Allocate(ratios ...int64) ([]Money, error) {
+	var total int64
+	for _, r := range ratios {
+		if r < 0 {
+			return nil, fmt.Errorf("%w: negative ratio", ErrInvalidAmount)
+		}
+		total += r
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%w: ratios sum to zero", ErrInvalidAmount)
+	}
+
+	parts := make([]Money, len(ratios))
+	remainder := m.Amount
+	for i, r := range ratios {
+		share, err := m.MulInt(r)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = Money{Amount: share.Amount / total, Currency: m.Currency}
+		remainder -= parts[i].Amount
+	}
+
+	step := int64(1)
+	if remainder < 0 {
+		step = -1
+	}
+	for i := 0; remainder != 0; i = (i + 1) % len(parts) {
+		if ratios[i] == 0 {
+			continue
+		}
+		parts[i].Amount += step
+		remainder -= step
+	}
+	return parts, nil
+}
+
+// ChamaCurrency returns the currency configured for a chama, or DefaultCurrency
+func ChamaCurrency(db *sql.DB, chamaID string) (string, error) {
+	return ChamaCurrencyContext(context.Background(), db, chamaID)
+}
+
+// ChamaCurrencyContext is ChamaCurrency within the request's transaction, if
+// ctx carries one
+func ChamaCurrencyContext(ctx context.Context, db *sql.DB, chamaID string) (string, error) {
+	var currency sql.NullString
+	ctx = tenancy.WithChama(ctx, chamaID)
+	err := txn.From(ctx, db).QueryRowContext(ctx, `SELECT currency FROM chamas WHERE id = ?`, chamaID).Scan(&currency)
+	if err != nil {
+		return "", err
+	}
+	if !currency.Valid || currency.String == "" {
+		return DefaultCurrency, nil
+	}
+	return currency.String, nil
+}
+
+// SetChamaCurrency changes the currency of a chama. It is refused once the chama
+// has ledger entries, since existing amounts would otherwise change meaning.
+func SetChamaCurrency(db *sql.DB, chamaID, currency string) error {
+	c, err := Lookup(currency)
+	if err != nil {
+		return err
+	}
+
+	ctx := tenancy.WithChama(context.Background(), chamaID)
+	var entries int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM ledger_entries WHERE chama_id = ?`, chamaID).Scan(&entries); err != nil {
+		return err
+	}
+	if entries > 0 {
+		return errors.New("cannot change currency of a chama with ledger entries")
+	}
+
+	_, err = db.ExecContext(ctx, `UPDATE chamas SET currency = ? WHERE id = ?`, c.Code, chamaID)
+	return err
+}
@@ -0,0 +1,166 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     int64
+		err      error
+	}{
+		{"1500.50", "KES", 150050, nil},
+		{"1,500.5", "KES", 150050, nil},
+		{" 1500 ", "KES", 150000, nil},
+		{"1500.", "KES", 150000, nil},
+		{"0.01", "KES", 1, nil},
+		{"-5", "KES", -500, nil},
+		{"-0.5", "KES", -50, nil},
+		{"1500", "UGX", 1500, nil},
+		{"1500.5", "UGX", 0, ErrInvalidAmount},
+		{"1.005", "KES", 0, ErrInvalidAmount},
+		{"--5", "KES", 0, ErrInvalidAmount},
+		{"+5", "KES", 0, ErrInvalidAmount},
+		{"-+5", "KES", 0, ErrInvalidAmount},
+		{"+.50", "KES", 0, ErrInvalidAmount},
+		{"5.-1", "KES", 0, ErrInvalidAmount},
+		{".50", "KES", 0, ErrInvalidAmount},
+		{"", "KES", 0, ErrInvalidAmount},
+		{"-", "KES", 0, ErrInvalidAmount},
+		{"1e3", "KES", 0, ErrInvalidAmount},
+		{"1 500", "KES", 0, ErrInvalidAmount},
+		{"92233720368547758.08", "KES", 0, ErrInvalidAmount},
+		{"10", "XYZ", 0, ErrUnknownCurrency},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.value, tt.currency)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q, %q) error = %v, want %v", tt.value, tt.currency, err, tt.err)
+			continue
+		}
+		if err == nil && got.Amount != tt.want {
+			t.Errorf("Parse(%q, %q) = %d, want %d", tt.value, tt.currency, got.Amount, tt.want)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"129.25", 129250000, true},
+		{"1", 1000000, true},
+		{"0.0000001", 0, false},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"+1", 0, false},
+		{"1.-5", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"12", 1200, true},
+		{"12.5", 1250, true},
+		{"12.75%", 1275, true},
+		{" 0.01 ", 1, true},
+		{"0", 0, true},
+		{"12.505", 0, false},
+		{"-1", 0, false},
+		{"+1", 0, false},
+		{"1e2", 0, false},
+		{".5", 0, false},
+		{"12.", 0, false},
+		{"%", 0, false},
+		{"99999999999999999999", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParsePercent(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParsePercent(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestRounding(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func() (Money, error)
+		want Money
+	}{
+		{"rate rounds half up", func() (Money, error) { return New(150, "KES").ApplyRate(100) }, New(2, "KES")},
+		{"rate rounds down below half", func() (Money, error) { return New(149, "KES").ApplyRate(100) }, New(1, "KES")},
+		{"rate rounds half away from zero", func() (Money, error) { return New(-150, "KES").ApplyRate(100) }, New(-2, "KES")},
+		{"convert rounds half up", func() (Money, error) { return New(1, "USD").Convert("KES", 1500000) }, New(2, "KES")},
+		{"convert rounds negative away from zero", func() (Money, error) { return New(-1, "USD").Convert("KES", 1500000) }, New(-2, "KES")},
+		{"convert rounds down below half", func() (Money, error) { return New(1, "USD").Convert("KES", 1499999) }, New(1, "KES")},
+		{"convert to no minor units", func() (Money, error) { return New(150, "KES").Convert("UGX", 28500000) }, New(43, "UGX")},
+		{"convert from no minor units", func() (Money, error) { return New(1000, "UGX").Convert("KES", 35000) }, New(3500, "KES")},
+	}
+	for _, tt := range tests {
+		got, err := tt.fn()
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		amount int64
+		ratios []int64
+		want   []int64
+	}{
+		{100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{-100, []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{5, []int64{0, 1, 1}, []int64{0, 3, 2}},
+		{1000, []int64{70, 30}, []int64{700, 300}},
+	}
+	for _, tt := range tests {
+		parts, err := New(tt.amount, "KES").Allocate(tt.ratios...)
+		if err != nil {
+			t.Errorf("Allocate(%d, %v): %v", tt.amount, tt.ratios, err)
+			continue
+		}
+		for i, p := range parts {
+			if p.Amount != tt.want[i] {
+				t.Errorf("Allocate(%d, %v) = %v, want %v", tt.amount, tt.ratios, parts, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCurrencyMismatch(t *testing.T) {
+	kes, usd := New(100, "KES"), New(100, "USD")
+	tests := []struct {
+		name string
+		fn   func() (Money, error)
+	}{
+		{"add", func() (Money, error) { return kes.Add(usd) }},
+		{"sub", func() (Money, error) { return kes.Sub(usd) }},
+	}
+	for _, tt := range tests {
+		if _, err := tt.fn(); !errors.Is(err, ErrCurrencyMismatch) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, ErrCurrencyMismatch)
+		}
+	}
+	if sum, err := kes.Add(New(50, "KES")); err != nil || sum != New(150, "KES") {
+		t.Errorf("add in the same currency = %v, %v", sum, err)
+	}
+}
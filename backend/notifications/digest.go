@@ -0,0 +1,195 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/jobs"
+)
+
+// DigestHour is when daily digests are sent (local time)
+var DigestHour = 18
+
+// enqueue holds n back from the user's channels, for their digest or until
+// their quiet hours end
+func enqueue(ctx context.Context, db *sql.DB, n Notification, digest bool) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO notification_queue (notification_id, user_id, digest) VALUES (?, ?, ?)`,
+		n.ID, n.UserID, digest)
+	return err
+}
+
+// retry queues n to be delivered again through channels, whose providers
+// were unavailable, by the next FlushDeferred
+func retry(ctx context.Context, db *sql.DB, n Notification, channels []string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO notification_queue (notification_id, user_id, digest, channels) VALUES (?, ?, FALSE, ?)
+		ON CONFLICT(notification_id) DO UPDATE SET digest = FALSE, channels = excluded.channels`,
+		n.ID, n.UserID, strings.Join(channels, ","))
+	return err
+}
+
+// SendDigests delivers every queued notification, one message per user.
+// Users in their quiet hours get theirs once the quiet hours end.
+func (nt *Notifier) SendDigests(ctx context.Context, now time.Time) error {
+	return nt.flush(ctx, now, true)
+}
+
+// FlushDeferred delivers the notifications held back by quiet hours that
+// have since ended, and retries deliveries a provider outage interrupted
+func (nt *Notifier) FlushDeferred(ctx context.Context, now time.Time) error {
+	return nt.flush(ctx, now, false)
+}
+
+func (nt *Notifier) flush(ctx context.Context, now time.Time, digest bool) error {
+	query := `SELECT DISTINCT user_id FROM notification_queue WHERE digest = FALSE`
+	if digest {
+		query = `SELECT DISTINCT user_id FROM notification_queue`
+	}
+	users, err := queuedUsers(ctx, nt.db, query)
+	if err != nil {
+		return err
+	}
+	for _, userID := range users {
+		prefs, err := LoadPreferences(ctx, nt.db, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load notification preferences", "user_id", userID, "error", err)
+			continue
+		}
+		if prefs.Quiet(now) {
+			if digest {
+				// Hand the digest over to the quiet hours flush
+				_, err := nt.db.ExecContext(ctx, `UPDATE notification_queue SET digest = FALSE WHERE user_id = ?`, userID)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := nt.flushUser(ctx, prefs, userID, digest); err != nil {
+			slog.ErrorContext(ctx, "Failed to send queued notifications", "user_id", userID, "error", err)
+		}
+	}
+	return nil
+}
+
+// flushUser delivers the user's queued notifications, a single one as it
+// is and several as one digest, and removes them from the queue. Retries
+// are sent as they are, through the channels that missed them.
+func (nt *Notifier) flushUser(ctx context.Context, prefs Preferences, userID string, digest bool) error {
+	query := `
+		SELECT n.id, n.title, n.message, n.type, COALESCE(n.related_id, ''), n.created_at, COALESCE(q.channels, '')
+		FROM notification_queue q JOIN notifications n ON n.id = q.notification_id
+		WHERE q.user_id = ?`
+	if !digest {
+		query += ` AND q.digest = FALSE`
+	}
+	rows, err := nt.db.QueryContext(ctx, query+` ORDER BY n.created_at`, userID)
+	if err != nil {
+		return err
+	}
+	var queued, retries []Notification
+	var ids []interface{}
+	retryChannels := map[string][]string{}
+	for rows.Next() {
+		n := Notification{UserID: userID}
+		var channels string
+		if err := rows.Scan(&n.ID, &n.Title, &n.Message, &n.Type, &n.RelatedID, &n.CreatedAt, &channels); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, n.ID)
+		if channels != "" {
+			retries = append(retries, n)
+			retryChannels[n.ID] = strings.Split(channels, ",")
+			continue
+		}
+		queued = append(queued, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// Remove them first, so a failing channel cannot send them twice
+	_, err = nt.db.ExecContext(ctx, `DELETE FROM notification_queue WHERE notification_id IN (?`+
+		strings.Repeat(", ?", len(ids)-1)+`)`, ids...)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range retries {
+		if down := nt.deliver(ctx, prefs, n, retryChannels[n.ID]); len(down) > 0 {
+			if err := retry(ctx, nt.db, n, down); err != nil {
+				return err
+			}
+		}
+	}
+	var down []string
+	switch len(queued) {
+	case 0:
+		return nil
+	case 1:
+		down = nt.deliver(ctx, prefs, queued[0], nil)
+	default:
+		down = nt.deliver(ctx, prefs, Digest(userID, queued), nil)
+	}
+	if len(down) == 0 {
+		return nil
+	}
+	for _, n := range queued {
+		if err := retry(ctx, nt.db, n, down); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Digest combines notifications into one message
+func Digest(userID string, list []Notification) Notification {
+	lines := make([]string, len(list))
+	for i, n := range list {
+		lines[i] = fmt.Sprintf("- %s: %s", n.Title, n.Message)
+	}
+	return Notification{
+		UserID:  userID,
+		Title:   fmt.Sprintf("You have %d TujiFund updates", len(list)),
+		Message: strings.Join(lines, "\n"),
+		Type:    TypeSystem,
+	}
+}
+
+// RegisterDigestJobs sends the daily digest at DigestHour and, every 15
+// minutes, the notifications held back by quiet hours that have ended
+func RegisterDigestJobs(s *jobs.Scheduler, nt *Notifier) {
+	s.Register("notification_digest", jobs.Daily{Hour: DigestHour}, func(ctx context.Context) error {
+		return nt.SendDigests(ctx, time.Now())
+	})
+	s.Register("notification_deferred", jobs.Every(15*time.Minute), func(ctx context.Context) error {
+		return nt.FlushDeferred(ctx, time.Now())
+	})
+}
+
+func queuedUsers(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		users = append(users, id)
+	}
+	return users, rows.Err()
+}
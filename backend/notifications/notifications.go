@@ -0,0 +1,257 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Notification types
+const (
+	TypeSystem  = "system"
+	TypeChama   = "chama"
+	TypeMeeting = "meeting"
+	TypePayment = "payment"
+	TypeLoan    = "loan"
+)
+
+// Priorities. Low-priority notifications are held for the daily digest of
+// users who chose digest mode.
+const (
+	PriorityHigh = "high"
+	PriorityLow  = "low"
+)
+
+// Notification is an in-app message for a user. It is also pushed through
+// any configured delivery channels (SMS, email, push).
+type Notification struct {
+	ID        string `json:"id"`
+	UserID    string `json:"userId"`
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+	Type      string `json:"type"`
+	RelatedID string `json:"relatedId,omitempty"`
+	Priority  string `json:"-"` // PriorityHigh or PriorityLow; defaults by Type
+	IsRead    bool   `json:"isRead"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// Recipient holds the contact details channels need
+type Recipient struct {
+	UserID string
+	Name   string
+	Email  string
+	Phone  string
+}
+
+// Channel delivers a notification outside the app
+type Channel interface {
+	Name() string
+	Deliver(ctx context.Context, to Recipient, n Notification) error
+}
+
+// Notifier stores notifications and fans them out to channels
+type Notifier struct {
+	db       *sql.DB
+	channels []Channel
+}
+
+// New creates a notifier that delivers through channels
+func New(db *sql.DB, channels ...Channel) *Notifier {
+	return &Notifier{db: db, channels: channels}
+}
+
+// Notify stores n for the user and delivers it through the channels the
+// user has enabled. Low-priority notifications for users in digest mode are
+// queued for their digest, and deliveries in a user's quiet hours are
+// queued until the quiet hours end. Delivery failures are logged and do not
+// fail the call, since the in-app copy has already been saved; deliveries a
+// channel could not make because its provider is down are queued for retry.
+// Within a request run by txn.Middleware the notification is saved with
+// the request's changes and delivered once they are committed.
+func (nt *Notifier) Notify(ctx context.Context, n Notification) error {
+	n.ID = uuid.NewString()
+	_, err := txn.From(ctx, nt.db).ExecContext(ctx, `
+		INSERT INTO notifications (id, user_id, title, message, type, related_id)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		n.ID, n.UserID, n.Title, n.Message, n.Type, n.RelatedID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save notification: %w", err)
+	}
+
+	if len(nt.channels) == 0 {
+		return nil
+	}
+	txn.OnCommit(ctx, func(ctx context.Context) { nt.dispatch(ctx, n) })
+	return nil
+}
+
+// dispatch delivers a saved notification, or queues it for later
+func (nt *Notifier) dispatch(ctx context.Context, n Notification) {
+	prefs, err := LoadPreferences(ctx, nt.db, n.UserID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load notification preferences", "user_id", n.UserID, "error", err)
+		prefs = DefaultPreferences()
+	}
+	switch {
+	case prefs.Mode == ModeDigest && n.priority() == PriorityLow:
+		err = enqueue(ctx, nt.db, n, true)
+	case prefs.Quiet(time.Now()):
+		err = enqueue(ctx, nt.db, n, false)
+	default:
+		if down := nt.deliver(ctx, prefs, n, nil); len(down) > 0 {
+			err = retry(ctx, nt.db, n, down)
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to queue notification", "user_id", n.UserID, "error", err)
+	}
+}
+
+// deliver sends n through the channels prefs allow, or only through the
+// channels in only when it is not empty. It returns the channels that could
+// not deliver because their provider is unavailable.
+func (nt *Notifier) deliver(ctx context.Context, prefs Preferences, n Notification, only []string) []string {
+	to, err := lookupRecipient(ctx, nt.db, n.UserID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up notification recipient", "user_id", n.UserID, "error", err)
+		return nil
+	}
+	var down []string
+	for _, ch := range nt.channels {
+		if !prefs.Allows(ch.Name()) || (len(only) > 0 && !contains(only, ch.Name())) {
+			continue
+		}
+		if err := ch.Deliver(ctx, to, n); err != nil {
+			slog.ErrorContext(ctx, "Failed to deliver notification", "channel", ch.Name(), "user_id", n.UserID, "error", err)
+			if errors.Is(err, breaker.ErrUnavailable) {
+				down = append(down, ch.Name())
+			}
+		}
+	}
+	return down
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Channels returns the names of the notifier's channels
+func (nt *Notifier) Channels() []string {
+	names := make([]string, len(nt.channels))
+	for i, ch := range nt.channels {
+		names[i] = ch.Name()
+	}
+	return names
+}
+
+// priority returns n's priority, defaulting to high for money matters
+func (n Notification) priority() string {
+	if n.Priority != "" {
+		return n.Priority
+	}
+	switch n.Type {
+	case TypePayment, TypeLoan:
+		return PriorityHigh
+	}
+	return PriorityLow
+}
+
+func lookupRecipient(ctx context.Context, db *sql.DB, userID string) (Recipient, error) {
+	to := Recipient{UserID: userID}
+	var first, last, phone sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT email, first_name, last_name, phone_number FROM users WHERE user_id = ?`, userID,
+	).Scan(&to.Email, &first, &last, &phone)
+	to.Name = first.String
+	if last.String != "" {
+		to.Name += " " + last.String
+	}
+	to.Phone = phone.String
+	return to, err
+}
+
+// LogChannel writes notifications to the log instead of sending them. Use it in development.
+type LogChannel struct{}
+
+func (LogChannel) Name() string { return "log" }
+
+func (LogChannel) Deliver(ctx context.Context, to Recipient, n Notification) error {
+	slog.InfoContext(ctx, "Notification", "user_id", to.UserID, "phone", to.Phone, "title", n.Title, "message", n.Message)
+	return nil
+}
+
+// ListHandler returns the caller's notifications, newest first
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `
+			SELECT id, user_id, title, message, type, COALESCE(related_id, ''), is_read, created_at
+			FROM notifications WHERE user_id = ? ORDER BY created_at DESC LIMIT 100`, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		list := []Notification{}
+		for rows.Next() {
+			var n Notification
+			if err := rows.Scan(&n.ID, &n.UserID, &n.Title, &n.Message, &n.Type, &n.RelatedID, &n.IsRead, &n.CreatedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			list = append(list, n)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// MarkReadHandler marks the {id} notification as read for the caller
+func MarkReadHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		res, err := db.ExecContext(r.Context(), `
+			UPDATE notifications SET is_read = TRUE WHERE id = ? AND user_id = ?`,
+			mux.Vars(r)["id"], userID,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Notification not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
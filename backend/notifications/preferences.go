@@ -0,0 +1,175 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/validation"
+)
+
+// Delivery modes
+const (
+	ModeImmediate = "immediate" // every notification is delivered as it happens
+	ModeDigest    = "digest"    // low-priority notifications are batched into a daily digest
+)
+
+// Preferences are a user's choices for delivery outside the app. The in-app
+// copy of every notification is kept regardless.
+type Preferences struct {
+	// Channels lists the channels to deliver through; nil means all of them
+	Channels []string `json:"channels"`
+	Mode     string   `json:"mode"`
+	// QuietFrom and QuietTo bound the hours (local time) in which nothing is
+	// delivered, wrapping past midnight when QuietFrom > QuietTo. Both are
+	// nil when the user has no quiet hours.
+	QuietFrom *int `json:"quietFrom"`
+	QuietTo   *int `json:"quietTo"`
+}
+
+// DefaultPreferences delivers everything immediately through every channel
+func DefaultPreferences() Preferences {
+	return Preferences{Mode: ModeImmediate}
+}
+
+// Allows reports whether the user wants notifications through channel
+func (p Preferences) Allows(channel string) bool {
+	if p.Channels == nil {
+		return true
+	}
+	for _, c := range p.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Quiet reports whether t falls in the user's quiet hours
+func (p Preferences) Quiet(t time.Time) bool {
+	if p.QuietFrom == nil || p.QuietTo == nil || *p.QuietFrom == *p.QuietTo {
+		return false
+	}
+	h := t.Hour()
+	if *p.QuietFrom < *p.QuietTo {
+		return h >= *p.QuietFrom && h < *p.QuietTo
+	}
+	return h >= *p.QuietFrom || h < *p.QuietTo
+}
+
+// LoadPreferences returns the user's preferences, or the defaults if they
+// have not set any
+func LoadPreferences(ctx context.Context, db *sql.DB, userID string) (Preferences, error) {
+	p := DefaultPreferences()
+	var channels sql.NullString
+	var from, to sql.NullInt64
+	err := db.QueryRowContext(ctx, `
+		SELECT channels, mode, quiet_from, quiet_to FROM notification_preferences WHERE user_id = ?`, userID,
+	).Scan(&channels, &p.Mode, &from, &to)
+	if err == sql.ErrNoRows {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	if channels.Valid {
+		p.Channels = strings.FieldsFunc(channels.String, func(r rune) bool { return r == ',' })
+		if p.Channels == nil {
+			p.Channels = []string{}
+		}
+	}
+	if from.Valid && to.Valid {
+		f, t := int(from.Int64), int(to.Int64)
+		p.QuietFrom, p.QuietTo = &f, &t
+	}
+	return p, nil
+}
+
+// SavePreferences stores the user's preferences
+func SavePreferences(ctx context.Context, db *sql.DB, userID string, p Preferences) error {
+	var channels interface{}
+	if p.Channels != nil {
+		channels = strings.Join(p.Channels, ",")
+	}
+	var from, to interface{}
+	if p.QuietFrom != nil && p.QuietTo != nil {
+		from, to = *p.QuietFrom, *p.QuietTo
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO notification_preferences (user_id, channels, mode, quiet_from, quiet_to, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE SET channels = excluded.channels, mode = excluded.mode,
+			quiet_from = excluded.quiet_from, quiet_to = excluded.quiet_to, updated_at = excluded.updated_at`,
+		userID, channels, p.Mode, from, to)
+	return err
+}
+
+// PreferencesHandler returns the caller's notification preferences and the
+// channels they can choose from
+func PreferencesHandler(nt *Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		p, err := LoadPreferences(r.Context(), nt.db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"preferences":       p,
+			"availableChannels": nt.Channels(),
+			"digestHour":        DigestHour,
+		})
+	}
+}
+
+// UpdatePreferencesHandler replaces the caller's notification preferences
+func UpdatePreferencesHandler(nt *Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var p Preferences
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if p.Mode == "" {
+			p.Mode = ModeImmediate
+		}
+
+		v := validation.New()
+		v.OneOf("mode", p.Mode, ModeImmediate, ModeDigest)
+		for _, c := range p.Channels {
+			v.OneOf("channels", c, nt.Channels()...)
+		}
+		if (p.QuietFrom == nil) != (p.QuietTo == nil) {
+			v.Add("quietHours", validation.CodeRequired, nil)
+		}
+		for field, h := range map[string]*int{"quietFrom": p.QuietFrom, "quietTo": p.QuietTo} {
+			if h != nil && (*h < 0 || *h > 23) {
+				v.Add(field, validation.CodeOneOf, map[string]string{"options": "0-23"})
+			}
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		if err := SavePreferences(r.Context(), nt.db, userID, p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
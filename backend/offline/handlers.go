@@ -0,0 +1,68 @@
+package offline
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+
+	"github.com/gorilla/mux"
+)
+
+// SyncHandler is the {chamaId} chama's sync endpoint for the mobile app.
+// The app sends its cursor and queued mutations; the response holds each
+// mutation's result, the records changed since the cursor (including the
+// effects of the mutations) and the cursor for the next sync.
+func SyncHandler(s *Syncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(s.db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			Cursor    string     `json:"cursor"`
+			DeviceID  string     `json:"deviceId"`
+			Mutations []Mutation `json:"mutations"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		results, err := s.Apply(r.Context(), chamaID, userID, request.DeviceID, request.Mutations, audit.FromRequest(r, audit.Entry{}))
+		if errors.Is(err, ErrTooMany) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		changes, cursor, err := s.Changes(r.Context(), chamaID, userID, request.Cursor)
+		if errors.Is(err, ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if results == nil {
+			results = []Result{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"cursor":  cursor,
+			"results": results,
+			"changes": changes,
+		})
+	}
+}
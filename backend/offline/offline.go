@@ -0,0 +1,437 @@
+// Package offline lets the mobile app work without connectivity, as at
+// meetings in areas with no signal. The app queues what a member does
+// offline as mutations and, when back online, sends them with the cursor of
+// its last sync. The server applies each mutation once, detects conflicts
+// by comparing the record's updated_at with the one the app last saw (the
+// server's copy wins and is sent back), and returns the chama's records
+// changed since the cursor together with a new cursor.
+package offline
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/meetings"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/storage"
+)
+
+// Mutation types
+const (
+	TypeAttendance       = "attendance"        // a secretary marks a member present, absent or excused
+	TypeRSVP             = "rsvp"              // a member answers a meeting invitation
+	TypeCashContribution = "cash_contribution" // a treasurer records cash collected at a meeting
+)
+
+// Mutation outcomes
+const (
+	StatusApplied   = "applied"
+	StatusConflict  = "conflict"  // the server's copy changed since the app saw it and was kept
+	StatusRejected  = "rejected"  // the mutation is invalid or not allowed
+	StatusDuplicate = "duplicate" // the mutation was applied by an earlier sync
+)
+
+// MaxMutations caps the mutations accepted in one sync
+const MaxMutations = 500
+
+// cursorLayout matches CURRENT_TIMESTAMP, so cursors compare with updated_at
+const cursorLayout = "2006-01-02 15:04:05"
+
+var (
+	ErrInvalidCursor = errors.New("invalid sync cursor")
+	ErrTooMany       = fmt.Errorf("at most %d mutations can be sent in one sync", MaxMutations)
+	errForbidden     = errors.New("not allowed")
+)
+
+// Mutation is a change the app made offline. ID is generated by the app
+// and makes retrying a sync safe. BaseUpdatedAt is the record's updatedAt
+// when the app last synced it, empty for new records.
+type Mutation struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	BaseUpdatedAt string          `json:"baseUpdatedAt,omitempty"`
+	Data          json.RawMessage `json:"data"`
+	MadeAt        time.Time       `json:"madeAt"`
+}
+
+// Result is the outcome of a mutation. Current is the server's copy of the
+// record after the mutation, or the copy that won a conflict.
+type Result struct {
+	ID      string      `json:"id"`
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Current interface{} `json:"current,omitempty"`
+}
+
+// Changes are the chama's records changed since a cursor. Clients upsert
+// them by ID; records changed in the cursor's second may be sent again.
+type Changes struct {
+	Members       []Member                     `json:"members"`
+	Meetings      []meetings.Meeting           `json:"meetings"`
+	Attendance    []Attendance                 `json:"attendance"`
+	Contributions []contributions.Contribution `json:"contributions"`
+}
+
+// Member is a chama member as the app stores them
+type Member struct {
+	UserID    string `json:"userId"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Status    string `json:"status"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// Attendance is a member's attendance record for a meeting
+type Attendance struct {
+	MeetingID string `json:"meetingId"`
+	MemberID  string `json:"memberId"`
+	Status    string `json:"status"`
+	RSVP      string `json:"rsvp,omitempty"`
+	Notes     string `json:"notes,omitempty"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// Syncer applies mutations for a chama and collects its changes
+type Syncer struct {
+	db       *sql.DB
+	store    storage.Backend
+	notifier *notifications.Notifier
+}
+
+// NewSyncer creates a syncer. store and notifier are used when recording
+// contributions.
+func NewSyncer(db *sql.DB, store storage.Backend, notifier *notifications.Notifier) *Syncer {
+	return &Syncer{db: db, store: store, notifier: notifier}
+}
+
+// Apply applies mutations made by userID in the chama, in order. A failed
+// mutation does not stop the ones after it.
+func (s *Syncer) Apply(ctx context.Context, chamaID, userID, deviceID string, mutations []Mutation, entry audit.Entry) ([]Result, error) {
+	if len(mutations) > MaxMutations {
+		return nil, ErrTooMany
+	}
+	results := make([]Result, 0, len(mutations))
+	for _, m := range mutations {
+		if m.ID == "" {
+			results = append(results, Result{Status: StatusRejected, Error: "mutation id is required"})
+			continue
+		}
+		var status, message string
+		err := s.db.QueryRowContext(ctx, `
+			SELECT status, COALESCE(error, '') FROM sync_mutations WHERE id = ? AND user_id = ?`, m.ID, userID,
+		).Scan(&status, &message)
+		if err == nil {
+			results = append(results, Result{ID: m.ID, Status: StatusDuplicate, Error: message})
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return results, err
+		}
+
+		res := s.apply(ctx, chamaID, userID, m)
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO sync_mutations (id, chama_id, user_id, device_id, type, status, error, made_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			m.ID, chamaID, userID, nullIfEmpty(deviceID), m.Type, res.Status, nullIfEmpty(res.Error), m.MadeAt)
+		if err != nil {
+			return results, err
+		}
+		if res.Status == StatusApplied {
+			e := entry
+			e.UserID, e.Action, e.EntityType, e.EntityID = userID, "sync."+m.Type, "sync_mutation", m.ID
+			e.NewValues = map[string]interface{}{"chamaId": chamaID, "deviceId": deviceID, "data": m.Data, "madeAt": m.MadeAt}
+			if err := audit.Record(ctx, s.db, e); err != nil {
+				return results, err
+			}
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (s *Syncer) apply(ctx context.Context, chamaID, userID string, m Mutation) Result {
+	var current interface{}
+	var err error
+	switch m.Type {
+	case TypeAttendance:
+		current, err = s.attendance(ctx, chamaID, userID, m)
+	case TypeRSVP:
+		current, err = s.rsvp(ctx, chamaID, userID, m)
+	case TypeCashContribution:
+		current, err = s.cashContribution(ctx, chamaID, userID, m)
+	default:
+		err = fmt.Errorf("unknown mutation type %q", m.Type)
+	}
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		return Result{ID: m.ID, Status: StatusConflict, Current: conflict.current}
+	case err != nil:
+		return Result{ID: m.ID, Status: StatusRejected, Error: err.Error()}
+	}
+	return Result{ID: m.ID, Status: StatusApplied, Current: current}
+}
+
+// conflictError carries the server's copy of a record changed since the app saw it
+type conflictError struct {
+	current interface{}
+}
+
+func (e *conflictError) Error() string { return "record changed on the server" }
+
+func (s *Syncer) attendance(ctx context.Context, chamaID, userID string, m Mutation) (interface{}, error) {
+	var data struct {
+		MeetingID string `json:"meetingId"`
+		MemberID  string `json:"memberId"`
+		Status    string `json:"status"`
+		Notes     string `json:"notes"`
+	}
+	if err := json.Unmarshal(m.Data, &data); err != nil {
+		return nil, err
+	}
+	if !chamas.HasRole(s.db, chamaID, userID, chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleSecretary) {
+		return nil, errForbidden
+	}
+	switch data.Status {
+	case meetings.AttendancePresent, meetings.AttendanceAbsent, meetings.AttendanceExcused:
+	default:
+		return nil, fmt.Errorf("invalid attendance status %q", data.Status)
+	}
+	if err := s.checkBase(ctx, chamaID, data.MeetingID, data.MemberID, m.BaseUpdatedAt); err != nil {
+		return nil, err
+	}
+	err := meetings.RecordAttendance(ctx, s.db, data.MeetingID, []meetings.Attendee{
+		{MemberID: data.MemberID, Status: data.Status, Notes: data.Notes},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.attendanceRecord(ctx, chamaID, data.MeetingID, data.MemberID)
+}
+
+func (s *Syncer) rsvp(ctx context.Context, chamaID, userID string, m Mutation) (interface{}, error) {
+	var data struct {
+		MeetingID string `json:"meetingId"`
+		Response  string `json:"response"`
+	}
+	if err := json.Unmarshal(m.Data, &data); err != nil {
+		return nil, err
+	}
+	switch data.Response {
+	case meetings.RSVPYes, meetings.RSVPNo, meetings.RSVPMaybe:
+	default:
+		return nil, fmt.Errorf("invalid RSVP %q", data.Response)
+	}
+	if err := s.checkBase(ctx, chamaID, data.MeetingID, userID, m.BaseUpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := meetings.RSVP(ctx, s.db, data.MeetingID, userID, data.Response); err != nil {
+		return nil, err
+	}
+	return s.attendanceRecord(ctx, chamaID, data.MeetingID, userID)
+}
+
+// checkBase returns a conflict if the attendance record changed after base
+func (s *Syncer) checkBase(ctx context.Context, chamaID, meetingID, memberID, base string) error {
+	current, err := s.attendanceRecord(ctx, chamaID, meetingID, memberID)
+	if err == sql.ErrNoRows {
+		return meetings.ErrNotInvited
+	}
+	if err != nil || base == "" {
+		return err
+	}
+	seen, err := parseTime(base)
+	if err != nil {
+		return fmt.Errorf("invalid baseUpdatedAt: %w", err)
+	}
+	updated, err := parseTime(current.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if updated.After(seen) {
+		return &conflictError{current: current}
+	}
+	return nil
+}
+
+func (s *Syncer) cashContribution(ctx context.Context, chamaID, userID string, m Mutation) (interface{}, error) {
+	var data struct {
+		MemberID    string `json:"memberId"`
+		AccountID   string `json:"accountId"`
+		AmountMinor int64  `json:"amountMinor"`
+		Reference   string `json:"reference"`
+		Notes       string `json:"notes"`
+	}
+	if err := json.Unmarshal(m.Data, &data); err != nil {
+		return nil, err
+	}
+	if !chamas.HasRole(s.db, chamaID, userID, contributions.ConfirmerRoles...) {
+		return nil, errForbidden
+	}
+	if !chamas.IsMember(s.db, chamaID, data.MemberID) {
+		return nil, errors.New("not a member of this chama")
+	}
+	currency, err := money.ChamaCurrency(s.db, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	date := m.MadeAt
+	if date.IsZero() {
+		date = time.Now()
+	}
+	return contributions.Record(ctx, s.db, s.store, s.notifier, contributions.Contribution{
+		ChamaID:   chamaID,
+		MemberID:  data.MemberID,
+		AccountID: data.AccountID,
+		Amount:    money.New(data.AmountMinor, currency),
+		Date:      date.UTC(),
+		Method:    "cash",
+		Reference: data.Reference,
+		Notes:     data.Notes,
+	}, userID)
+}
+
+func (s *Syncer) attendanceRecord(ctx context.Context, chamaID, meetingID, memberID string) (Attendance, error) {
+	a := Attendance{MeetingID: meetingID, MemberID: memberID}
+	var updated time.Time
+	err := s.db.QueryRowContext(ctx, `
+		SELECT a.attendance_status, COALESCE(a.rsvp, ''), COALESCE(a.notes, ''), a.updated_at
+		FROM meeting_attendance a JOIN meetings m ON m.id = a.meeting_id
+		WHERE a.meeting_id = ? AND a.member_id = ? AND m.chama_id = ?`, meetingID, memberID, chamaID,
+	).Scan(&a.Status, &a.RSVP, &a.Notes, &updated)
+	a.UpdatedAt = formatTime(updated)
+	return a, err
+}
+
+// Changes returns the chama's records changed at or after cursor, or all of
+// them for an empty cursor, as seen by userID: officials get every
+// contribution and members their own. It returns the cursor for the next sync.
+func (s *Syncer) Changes(ctx context.Context, chamaID, userID, cursor string) (Changes, string, error) {
+	next := time.Now().UTC().Format(cursorLayout)
+	since := "0001-01-01 00:00:00"
+	if cursor != "" {
+		t, err := time.Parse(cursorLayout, cursor)
+		if err != nil {
+			return Changes{}, "", ErrInvalidCursor
+		}
+		since = t.Format(cursorLayout)
+	}
+	ch := Changes{Members: []Member{}, Meetings: []meetings.Meeting{}, Attendance: []Attendance{}, Contributions: []contributions.Contribution{}}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.user_id, TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')), m.role, m.status, m.updated_at
+		FROM chama_members m LEFT JOIN users u ON u.user_id = m.user_id
+		WHERE m.chama_id = ? AND m.updated_at >= ?`, chamaID, since)
+	if err != nil {
+		return ch, "", err
+	}
+	for rows.Next() {
+		var m Member
+		var updated time.Time
+		if err := rows.Scan(&m.UserID, &m.Name, &m.Role, &m.Status, &updated); err != nil {
+			rows.Close()
+			return ch, "", err
+		}
+		m.UpdatedAt = formatTime(updated)
+		ch.Members = append(ch.Members, m)
+	}
+	rows.Close()
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT id FROM meetings WHERE chama_id = ? AND updated_at >= ? ORDER BY meeting_date`, chamaID, since)
+	if err != nil {
+		return ch, "", err
+	}
+	var meetingIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return ch, "", err
+		}
+		meetingIDs = append(meetingIDs, id)
+	}
+	rows.Close()
+	for _, id := range meetingIDs {
+		m, err := meetings.Get(ctx, s.db, id)
+		if err != nil {
+			return ch, "", err
+		}
+		ch.Meetings = append(ch.Meetings, m)
+	}
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT a.meeting_id, a.member_id, a.attendance_status, COALESCE(a.rsvp, ''), COALESCE(a.notes, ''), a.updated_at
+		FROM meeting_attendance a JOIN meetings m ON m.id = a.meeting_id
+		WHERE m.chama_id = ? AND a.updated_at >= ?`, chamaID, since)
+	if err != nil {
+		return ch, "", err
+	}
+	for rows.Next() {
+		var a Attendance
+		var updated time.Time
+		if err := rows.Scan(&a.MeetingID, &a.MemberID, &a.Status, &a.RSVP, &a.Notes, &updated); err != nil {
+			rows.Close()
+			return ch, "", err
+		}
+		a.UpdatedAt = formatTime(updated)
+		ch.Attendance = append(ch.Attendance, a)
+	}
+	rows.Close()
+
+	query := `SELECT id FROM contributions WHERE chama_id = ? AND updated_at >= ?`
+	args := []interface{}{chamaID, since}
+	if !chamas.IsOfficial(s.db, chamaID, userID) {
+		query += ` AND member_id = ?`
+		args = append(args, userID)
+	}
+	rows, err = s.db.QueryContext(ctx, query+` ORDER BY contribution_date`, args...)
+	if err != nil {
+		return ch, "", err
+	}
+	var contributionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return ch, "", err
+		}
+		contributionIDs = append(contributionIDs, id)
+	}
+	rows.Close()
+	for _, id := range contributionIDs {
+		c, err := contributions.Get(ctx, s.db, id)
+		if err != nil {
+			return ch, "", err
+		}
+		ch.Contributions = append(ch.Contributions, c)
+	}
+	return ch, next, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseTime accepts the RFC 3339 times the server sends and SQLite's own format
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(cursorLayout, s)
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
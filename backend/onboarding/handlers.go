@@ -0,0 +1,205 @@
+package onboarding
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateHandler creates a chama from {"name", "description", "type",
+// "currency"} with the caller as its chairperson, starting its onboarding
+func CreateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		c := Chama{Type: "savings", Currency: "KES"}
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		c.Name, c.Description = strings.TrimSpace(c.Name), strings.TrimSpace(c.Description)
+		c.Currency = strings.ToUpper(c.Currency)
+
+		v := validation.New()
+		if v.Required("name", c.Name) {
+			v.MinLength("name", c.Name, 3)
+		}
+		v.OneOf("type", c.Type, Types...)
+		v.OneOf("currency", c.Currency, money.Codes()...)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		c, err := Create(r.Context(), db, c, userID, audit.FromRequest(r, audit.Entry{}))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p, err := Get(r.Context(), db, c.ID)
+		if !writeOnboardingError(w, r, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"chama": c, "onboarding": p})
+	}
+}
+
+// GetHandler returns the {chamaId} chama's progress through onboarding, for
+// its officials
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, _, ok := authorize(db, w, r, chamas.OfficialRoles...)
+		if !ok {
+			return
+		}
+		writeProgress(w, r, db, chamaID)
+	}
+}
+
+// CompleteStepHandler marks {step} done for the {chamaId} chama once its
+// work has been done, for its officials
+func CompleteStepHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, userID, ok := authorize(db, w, r, chamas.OfficialRoles...)
+		if !ok {
+			return
+		}
+		step := mux.Vars(r)["step"]
+		if !writeOnboardingError(w, r, Complete(r.Context(), db, chamaID, step, userID)) {
+			return
+		}
+		err := audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "onboarding.step_completed", EntityType: "chama", EntityID: chamaID,
+			NewValues: map[string]string{"step": step},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit onboarding step", "chama_id", chamaID, "step", step, "error", err)
+		}
+		writeProgress(w, r, db, chamaID)
+	}
+}
+
+// PaybillHandler connects the {"kind": "paybill" or "till", "number",
+// "accountReference"} members pay the {chamaId} chama on, for its officials
+func PaybillHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, userID, ok := authorize(db, w, r, chamas.OfficialRoles...)
+		if !ok {
+			return
+		}
+		var pb Paybill
+		if err := json.NewDecoder(r.Body).Decode(&pb); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		pb.Number, pb.AccountReference = strings.TrimSpace(pb.Number), strings.TrimSpace(pb.AccountReference)
+		pb.ConnectedBy = userID
+		if !writeOnboardingError(w, r, ConnectPaybill(r.Context(), db, chamaID, pb)) {
+			return
+		}
+		err := audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "chama.paybill_connected", EntityType: "chama", EntityID: chamaID,
+			NewValues: map[string]string{"kind": pb.Kind, "number": pb.Number, "accountReference": pb.AccountReference},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit paybill", "chama_id", chamaID, "error", err)
+		}
+		writeProgress(w, r, db, chamaID)
+	}
+}
+
+// GoLiveHandler takes the {chamaId} chama live once every step is done, for
+// its chairperson or admin
+func GoLiveHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID, userID, ok := authorize(db, w, r, chamas.RoleAdmin, chamas.RoleChairperson)
+		if !ok {
+			return
+		}
+		liveAt, err := GoLive(r.Context(), db, chamaID, userID)
+		if !writeOnboardingError(w, r, err) {
+			return
+		}
+		err = audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "onboarding.live", EntityType: "chama", EntityID: chamaID,
+			NewValues: map[string]interface{}{"liveAt": liveAt},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit chama going live", "chama_id", chamaID, "error", err)
+		}
+		writeProgress(w, r, db, chamaID)
+	}
+}
+
+func writeProgress(w http.ResponseWriter, r *http.Request, db *sql.DB, chamaID string) {
+	p, err := Get(r.Context(), db, chamaID)
+	if !writeOnboardingError(w, r, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// authorize returns the {chamaId} chama and the caller if they hold one of
+// roles in it, writing an error response and returning false otherwise
+func authorize(db *sql.DB, w http.ResponseWriter, r *http.Request, roles ...string) (string, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return "", "", false
+	}
+	chamaID := mux.Vars(r)["chamaId"]
+	if !chamas.HasRole(db, chamaID, userID, roles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", "", false
+	}
+	return chamaID, userID, true
+}
+
+// writeOnboardingError writes the response for err and reports whether it
+// was nil. Steps not yet done are listed, with a message in the client's
+// language.
+func writeOnboardingError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var incomplete *Incomplete
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &incomplete):
+		lang := i18n.FromRequest(r)
+		names := make([]string, len(incomplete.Steps))
+		for i, step := range incomplete.Steps {
+			names[i] = i18n.T(lang, "onboarding."+step, nil)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": i18n.T(lang, "onboarding.incomplete", map[string]string{"steps": strings.Join(names, ", ")}),
+			"steps": incomplete.Steps,
+		})
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "Chama not found", http.StatusNotFound)
+	case errors.Is(err, ErrUnknownStep), errors.Is(err, ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrLive):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
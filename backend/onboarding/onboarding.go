@@ -0,0 +1,345 @@
+// Package onboarding walks officials through setting up a new chama: create
+// it, set its rules, invite members and connect the paybill or till members
+// pay into. Each completed step is recorded against the chama, so the app
+// can resume setup on any device and officials can see what is left before
+// the chama goes live.
+//
+// Steps other than creating the chama are done through the usual APIs, e.g.
+// publishing rules, and then marked complete here, which checks they were
+// done. They may be completed in any order; the chama goes live once all
+// are. Chamas created before the wizard have no onboarding record and are
+// live.
+package onboarding
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+
+	"github.com/google/uuid"
+)
+
+// Steps
+const (
+	StepCreateChama    = "create_chama"
+	StepSetRules       = "set_rules"
+	StepInviteMembers  = "invite_members"
+	StepConnectPaybill = "connect_paybill"
+)
+
+// Steps lists the steps in the order the wizard shows them
+var Steps = []string{StepCreateChama, StepSetRules, StepInviteMembers, StepConnectPaybill}
+
+// Types are the kinds of chama that can be created
+var Types = []string{"savings", "investment", "merry-go-round", "welfare"}
+
+// Kinds of M-Pesa number a chama collects on
+const (
+	KindPaybill = "paybill"
+	KindTill    = "till"
+)
+
+var shortCodePattern = regexp.MustCompile(`^\d{5,7}$`)
+
+var (
+	ErrNotFound    = errors.New("chama not found")
+	ErrUnknownStep = errors.New("unknown onboarding step")
+	ErrLive        = errors.New("chama is already live")
+	ErrInvalid     = errors.New("paybill or till number must be 5 to 7 digits")
+	ErrIncomplete  = errors.New("onboarding steps not done")
+)
+
+// Incomplete is the error returned when a step is marked complete before it
+// was done, or a chama is taken live before every step is complete. It
+// matches ErrIncomplete.
+type Incomplete struct {
+	Steps []string `json:"steps"`
+}
+
+func (e *Incomplete) Error() string {
+	return fmt.Sprintf("%s: %s", ErrIncomplete, strings.Join(e.Steps, ", "))
+}
+
+func (e *Incomplete) Is(target error) bool { return target == ErrIncomplete }
+
+// Chama is what the first step needs to create a chama
+type Chama struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type"`
+	Currency    string `json:"currency"`
+}
+
+// Paybill is the M-Pesa paybill or till members pay the chama on
+type Paybill struct {
+	Kind             string    `json:"kind"`
+	Number           string    `json:"number"`
+	AccountReference string    `json:"accountReference,omitempty"` // paybills only, e.g. the chama's bank account
+	ConnectedBy      string    `json:"connectedBy,omitempty"`
+	ConnectedAt      time.Time `json:"connectedAt"`
+}
+
+// Step is one step of the wizard and whether it is done
+type Step struct {
+	Name        string     `json:"name"`
+	Completed   bool       `json:"completed"`
+	CompletedBy string     `json:"completedBy,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// Progress is how far a chama is through onboarding
+type Progress struct {
+	ChamaID string     `json:"chamaId"`
+	Steps   []Step     `json:"steps"`
+	Next    string     `json:"next,omitempty"` // the first step not done, for the app to resume at
+	Live    bool       `json:"live"`
+	LiveAt  *time.Time `json:"liveAt,omitempty"`
+	Paybill *Paybill   `json:"paybill,omitempty"`
+}
+
+// Create creates the chama with by as its chairperson and a savings fund,
+// and completes the first step
+func Create(ctx context.Context, db *sql.DB, c Chama, by string, e audit.Entry) (Chama, error) {
+	c.ID = uuid.NewString()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return c, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chamas (id, name, description, type, currency, created_by) VALUES (?, ?, ?, ?, ?, ?)`,
+		c.ID, c.Name, nullIfEmpty(c.Description), c.Type, c.Currency, by)
+	if err != nil {
+		return c, fmt.Errorf("failed to create chama: %w", err)
+	}
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO chama_members (id, chama_id, user_id, role) VALUES (?, ?, ?, ?)`,
+			[]interface{}{uuid.NewString(), c.ID, by, chamas.RoleChairperson}},
+		{`INSERT INTO chama_accounts (id, chama_id, name, account_type, currency) VALUES (?, ?, 'Savings', 'savings', ?)`,
+			[]interface{}{uuid.NewString(), c.ID, c.Currency}},
+		{`INSERT INTO chama_onboarding (chama_id, started_by) VALUES (?, ?)`,
+			[]interface{}{c.ID, by}},
+		{`INSERT INTO chama_onboarding_steps (chama_id, step, completed_by) VALUES (?, ?, ?)`,
+			[]interface{}{c.ID, StepCreateChama, by}},
+	}
+	for _, s := range statements {
+		if _, err := tx.ExecContext(ctx, s.query, s.args...); err != nil {
+			return c, err
+		}
+	}
+	e.UserID, e.Action, e.EntityType, e.EntityID = by, "chama.created", "chama", c.ID
+	e.NewValues = c
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return c, err
+	}
+	return c, tx.Commit()
+}
+
+// Get returns the chama's progress through onboarding
+func Get(ctx context.Context, db *sql.DB, chamaID string) (Progress, error) {
+	p := Progress{ChamaID: chamaID, Steps: []Step{}}
+	var exists int
+	err := db.QueryRowContext(ctx, `SELECT 1 FROM chamas WHERE id = ?`, chamaID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return p, ErrNotFound
+	}
+	if err != nil {
+		return p, err
+	}
+	var liveAt sql.NullTime
+	err = db.QueryRowContext(ctx, `SELECT live_at FROM chama_onboarding WHERE chama_id = ?`, chamaID).Scan(&liveAt)
+	switch {
+	case err == sql.ErrNoRows:
+		p.Live = true
+	case err != nil:
+		return p, err
+	case liveAt.Valid:
+		p.Live, p.LiveAt = true, &liveAt.Time
+	}
+
+	completed := map[string]Step{}
+	rows, err := db.QueryContext(ctx, `
+		SELECT step, COALESCE(completed_by, ''), completed_at FROM chama_onboarding_steps WHERE chama_id = ?`, chamaID)
+	if err != nil {
+		return p, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := Step{Completed: true}
+		var at time.Time
+		if err := rows.Scan(&s.Name, &s.CompletedBy, &at); err != nil {
+			return p, err
+		}
+		s.CompletedAt = &at
+		completed[s.Name] = s
+	}
+	if err := rows.Err(); err != nil {
+		return p, err
+	}
+	for _, name := range Steps {
+		s, ok := completed[name]
+		if !ok {
+			s = Step{Name: name}
+			if p.Next == "" && !p.Live {
+				p.Next = name
+			}
+		}
+		p.Steps = append(p.Steps, s)
+	}
+
+	pb, err := paybill(ctx, db, chamaID)
+	if err != nil && err != sql.ErrNoRows {
+		return p, err
+	}
+	if err == nil {
+		p.Paybill = &pb
+	}
+	return p, nil
+}
+
+// done reports whether the work of step has been done for the chama
+func done(ctx context.Context, db *sql.DB, chamaID, step string) (bool, error) {
+	var n int
+	var err error
+	switch step {
+	case StepCreateChama:
+		return true, nil
+	case StepSetRules:
+		err = db.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM chama_rules WHERE chama_id = ? AND status = 'active'`, chamaID).Scan(&n)
+	case StepInviteMembers:
+		err = db.QueryRowContext(ctx, `
+			SELECT (SELECT COUNT(*) FROM chama_invitations WHERE chama_id = ?)
+			     + (SELECT COUNT(*) FROM chama_members WHERE chama_id = ? AND status = 'active') - 1`,
+			chamaID, chamaID).Scan(&n)
+	case StepConnectPaybill:
+		err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM chama_paybills WHERE chama_id = ?`, chamaID).Scan(&n)
+	default:
+		return false, ErrUnknownStep
+	}
+	return n > 0, err
+}
+
+// onboarding returns ErrLive unless the chama is still being set up
+func onboarding(ctx context.Context, db *sql.DB, chamaID string) error {
+	p, err := Get(ctx, db, chamaID)
+	if err != nil {
+		return err
+	}
+	if p.Live {
+		return ErrLive
+	}
+	return nil
+}
+
+// Complete marks step done for the chama, once its work has been done:
+// rules published, someone invited or a paybill connected
+func Complete(ctx context.Context, db *sql.DB, chamaID, step, by string) error {
+	if err := onboarding(ctx, db, chamaID); err != nil {
+		return err
+	}
+	ok, err := done(ctx, db, chamaID, step)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &Incomplete{Steps: []string{step}}
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO chama_onboarding_steps (chama_id, step, completed_by) VALUES (?, ?, ?)
+		ON CONFLICT(chama_id, step) DO NOTHING`, chamaID, step, by)
+	return err
+}
+
+// ConnectPaybill records the paybill or till members pay the chama on and,
+// while the chama is being set up, completes that step. Officials of a live
+// chama use it to change the number.
+func ConnectPaybill(ctx context.Context, db *sql.DB, chamaID string, pb Paybill) error {
+	if !shortCodePattern.MatchString(pb.Number) || (pb.Kind != KindPaybill && pb.Kind != KindTill) {
+		return ErrInvalid
+	}
+	if pb.Kind == KindTill {
+		pb.AccountReference = ""
+	}
+	err := onboarding(ctx, db, chamaID)
+	if err != nil && !errors.Is(err, ErrLive) {
+		return err
+	}
+	live := errors.Is(err, ErrLive)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chama_paybills (chama_id, kind, number, account_reference, connected_by) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(chama_id) DO UPDATE SET kind = excluded.kind, number = excluded.number,
+		    account_reference = excluded.account_reference, connected_by = excluded.connected_by,
+		    connected_at = CURRENT_TIMESTAMP`,
+		chamaID, pb.Kind, pb.Number, nullIfEmpty(pb.AccountReference), pb.ConnectedBy)
+	if err != nil {
+		return err
+	}
+	if !live {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO chama_onboarding_steps (chama_id, step, completed_by) VALUES (?, ?, ?)
+			ON CONFLICT(chama_id, step) DO NOTHING`, chamaID, StepConnectPaybill, pb.ConnectedBy)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func paybill(ctx context.Context, db *sql.DB, chamaID string) (Paybill, error) {
+	var pb Paybill
+	err := db.QueryRowContext(ctx, `
+		SELECT kind, number, COALESCE(account_reference, ''), COALESCE(connected_by, ''), connected_at
+		FROM chama_paybills WHERE chama_id = ?`, chamaID).
+		Scan(&pb.Kind, &pb.Number, &pb.AccountReference, &pb.ConnectedBy, &pb.ConnectedAt)
+	return pb, err
+}
+
+// GoLive takes the chama live once every step is complete
+func GoLive(ctx context.Context, db *sql.DB, chamaID, by string) (time.Time, error) {
+	p, err := Get(ctx, db, chamaID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if p.Live {
+		return time.Time{}, ErrLive
+	}
+	var remaining []string
+	for _, s := range p.Steps {
+		if !s.Completed {
+			remaining = append(remaining, s.Name)
+		}
+	}
+	if len(remaining) > 0 {
+		return time.Time{}, &Incomplete{Steps: remaining}
+	}
+	now := time.Now().UTC()
+	_, err = db.ExecContext(ctx, `
+		UPDATE chama_onboarding SET live_at = ?, live_by = ? WHERE chama_id = ? AND live_at IS NULL`, now, by, chamaID)
+	return now, err
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,191 @@
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/phone"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// CodeLength is the number of digits in a code
+	CodeLength = 6
+	// TTL is how long a code stays valid
+	TTL = 10 * time.Minute
+	// ResendInterval is the minimum time between codes for the same destination
+	ResendInterval = time.Minute
+	// MaxAttempts is how many wrong guesses invalidate a code
+	MaxAttempts = 5
+)
+
+var (
+	ErrTooSoon  = errors.New("a code was sent recently, please wait before requesting another")
+	ErrInvalid  = errors.New("invalid or expired code")
+	ErrAttempts = errors.New("too many attempts, please request a new code")
+)
+
+// Sender delivers a text message to a phone number, or to an email address
+// for email senders
+type Sender interface {
+	Send(ctx context.Context, to, message string) error
+}
+
+// LogSender writes messages to the log instead of sending them. Use it in development.
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, phone, message string) error {
+	slog.InfoContext(ctx, "SMS", "phone", phone, "message", message)
+	return nil
+}
+
+// LogMailer is LogSender for email addresses
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, email, message string) error {
+	slog.InfoContext(ctx, "Email", "to", email, "message", message)
+	return nil
+}
+
+// Guard sends through b, so that while the SMS or email provider is down
+// messages fail at once with a breaker.UnavailableError instead of waiting
+// out timeouts
+func Guard(sender Sender, b *breaker.Breaker) Sender {
+	return guardedSender{next: sender, breaker: b}
+}
+
+type guardedSender struct {
+	next    Sender
+	breaker *breaker.Breaker
+}
+
+func (g guardedSender) Send(ctx context.Context, to, message string) error {
+	return g.breaker.Do(ctx, func(ctx context.Context) error {
+		return g.next.Send(ctx, to, message)
+	})
+}
+
+// Normalize puts a phone number in E.164 form, and lowercases an email
+// address, so codes are keyed consistently
+func Normalize(destination string) string {
+	if strings.Contains(destination, "@") {
+		return strings.ToLower(strings.TrimSpace(destination))
+	}
+	return phone.Canonical(strings.NewReplacer(" ", "", "-", "").Replace(destination))
+}
+
+// Issue generates a code for purpose and destination, stores its hash and sends
+// it with sender. message is formatted with the code, e.g. "Your code is %s".
+func Issue(ctx context.Context, db *sql.DB, sender Sender, purpose, destination, message string) error {
+	destination = Normalize(destination)
+
+	var last time.Time
+	err := db.QueryRowContext(ctx, `
+		SELECT created_at FROM otp_codes WHERE purpose = ? AND destination = ?
+		ORDER BY created_at DESC LIMIT 1`,
+		purpose, destination,
+	).Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && time.Since(last) < ResendInterval {
+		return ErrTooSoon
+	}
+
+	code, err := generate()
+	if err != nil {
+		return err
+	}
+	id := uuid.NewString()
+	// Issuing a new code invalidates any earlier one
+	_, err = db.ExecContext(ctx, `
+		UPDATE otp_codes SET consumed_at = CURRENT_TIMESTAMP
+		WHERE purpose = ? AND destination = ? AND consumed_at IS NULL`, purpose, destination)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO otp_codes (id, purpose, destination, code_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		id, purpose, destination, hash(code),
+		time.Now().UTC().Add(TTL).Format("2006-01-02 15:04:05"), time.Now().UTC().Format("2006-01-02 15:04:05"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store code: %w", err)
+	}
+	if err := sender.Send(ctx, destination, fmt.Sprintf(message, code)); err != nil {
+		// The code never arrived, so it must not hold back a retry
+		db.ExecContext(ctx, `DELETE FROM otp_codes WHERE id = ?`, id)
+		return err
+	}
+	return nil
+}
+
+// Verify checks code against the latest unused code for purpose and
+// destination and consumes it on success
+func Verify(ctx context.Context, db *sql.DB, purpose, destination, code string) error {
+	destination = Normalize(destination)
+
+	var id, codeHash string
+	var attempts int
+	err := db.QueryRowContext(ctx, `
+		SELECT id, code_hash, attempts FROM otp_codes
+		WHERE purpose = ? AND destination = ? AND consumed_at IS NULL AND expires_at > ?
+		ORDER BY created_at DESC LIMIT 1`,
+		purpose, destination, time.Now().UTC().Format("2006-01-02 15:04:05"),
+	).Scan(&id, &codeHash, &attempts)
+	if err == sql.ErrNoRows {
+		return ErrInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if attempts >= MaxAttempts {
+		return ErrAttempts
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash(strings.TrimSpace(code))), []byte(codeHash)) != 1 {
+		if _, err := db.ExecContext(ctx, `UPDATE otp_codes SET attempts = attempts + 1 WHERE id = ?`, id); err != nil {
+			return err
+		}
+		return ErrInvalid
+	}
+	res, err := db.ExecContext(ctx, `
+		UPDATE otp_codes SET consumed_at = CURRENT_TIMESTAMP WHERE id = ? AND consumed_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func generate() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < CodeLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", CodeLength, n), nil
+}
+
+func hash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
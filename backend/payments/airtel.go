@@ -0,0 +1,288 @@
+package payments
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"tujifund-app/backend/httpclient"
+)
+
+// ProviderAirtel names Airtel Money callbacks in the callback log
+const ProviderAirtel = "airtel"
+
+// AirtelConfig configures the Airtel Africa open API
+type AirtelConfig struct {
+	BaseURL      string // https://openapiuat.airtel.africa or https://openapi.airtel.africa
+	ClientID     string
+	ClientSecret string
+	Country      string // ISO country of the merchant wallet, KE by default
+	Currency     string // KES by default
+	// CallbackToken authenticates Airtel Money's callbacks. Airtel posts them
+	// to the callback URL registered for the app in its partner portal, which
+	// must carry it as ?token=.
+	CallbackToken string
+}
+
+// airtelDialCodes are the country codes of Airtel Money markets, stripped
+// from subscriber numbers
+var airtelDialCodes = map[string]string{
+	"KE": "254", "UG": "256", "TZ": "255", "RW": "250", "ZM": "260", "MW": "265",
+}
+
+// Airtel requests payments from members' Airtel Money wallets with a USSD push
+type Airtel struct {
+	conf   atomic.Pointer[AirtelConfig]
+	client *httpclient.Client
+	auth   *httpclient.Client // fetches tokens
+	tokens *httpclient.Tokens
+}
+
+// NewAirtel creates an Airtel Money client
+func NewAirtel(conf AirtelConfig) (*Airtel, error) {
+	conf, err := conf.check()
+	if err != nil {
+		return nil, err
+	}
+	a := &Airtel{auth: httpclient.New(httpclient.Config{Provider: ProviderAirtel, Redact: []string{"client_id"}})}
+	a.conf.Store(&conf)
+	a.tokens = httpclient.NewTokens(a.fetchToken)
+	a.client = httpclient.New(httpclient.Config{Provider: ProviderAirtel, Tokens: a.tokens})
+	return a, nil
+}
+
+// check validates conf and fills in defaults
+func (conf AirtelConfig) check() (AirtelConfig, error) {
+	if conf.ClientID == "" || conf.ClientSecret == "" {
+		return conf, errors.New("Airtel Money client ID and client secret are required")
+	}
+	if len(conf.CallbackToken) < 16 {
+		return conf, errors.New("Airtel Money callback token of at least 16 characters is required")
+	}
+	if conf.BaseURL == "" {
+		conf.BaseURL = "https://openapiuat.airtel.africa"
+	}
+	if conf.Country == "" {
+		conf.Country = "KE"
+	}
+	if conf.Currency == "" {
+		conf.Currency = "KES"
+	}
+	if _, ok := airtelDialCodes[conf.Country]; !ok {
+		return conf, fmt.Errorf("Airtel Money is not supported in %s", conf.Country)
+	}
+	conf.BaseURL = strings.TrimRight(conf.BaseURL, "/")
+	return conf, nil
+}
+
+// config returns the client's current settings
+func (a *Airtel) config() *AirtelConfig { return a.conf.Load() }
+
+// Reconfigure replaces the client's settings without disturbing requests in
+// flight, dropping the cached token
+func (a *Airtel) Reconfigure(conf AirtelConfig) error {
+	conf, err := conf.check()
+	if err != nil {
+		return err
+	}
+	a.conf.Store(&conf)
+	a.tokens.Invalidate()
+	return nil
+}
+
+// AirtelFromEnv builds an Airtel Money client from AIRTEL_* variables. It
+// returns nil without an error when Airtel Money is not configured.
+func AirtelFromEnv() (*Airtel, error) {
+	if os.Getenv("AIRTEL_CLIENT_ID") == "" {
+		return nil, nil
+	}
+	return NewAirtel(airtelConfigFromEnv())
+}
+
+// ReloadFromEnv reconfigures the client from AIRTEL_* variables
+func (a *Airtel) ReloadFromEnv() error {
+	return a.Reconfigure(airtelConfigFromEnv())
+}
+
+func airtelConfigFromEnv() AirtelConfig {
+	return AirtelConfig{
+		BaseURL:      os.Getenv("AIRTEL_BASE_URL"),
+		ClientID:     os.Getenv("AIRTEL_CLIENT_ID"),
+		ClientSecret: os.Getenv("AIRTEL_CLIENT_SECRET"),
+		Country:      os.Getenv("AIRTEL_COUNTRY"),
+		Currency:     os.Getenv("AIRTEL_CURRENCY"),
+
+		CallbackToken: os.Getenv("AIRTEL_CALLBACK_TOKEN"),
+	}
+}
+
+// Name implements Provider
+func (a *Airtel) Name() string { return ProviderAirtel }
+
+// fetchToken gets a new OAuth token
+func (a *Airtel) fetchToken(ctx context.Context) (string, time.Duration, error) {
+	conf := a.config()
+	req, err := httpclient.NewJSONRequest(ctx, http.MethodPost, conf.BaseURL+"/auth/oauth2/token", map[string]string{
+		"client_id": conf.ClientID, "client_secret": conf.ClientSecret, "grant_type": "client_credentials",
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	// Asking for another token has no side effects
+	resp, err := a.auth.Do(httpclient.Retryable(req))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get Airtel Money token: %w", err)
+	}
+	if err := httpclient.CheckStatus(resp); err != nil {
+		return "", 0, fmt.Errorf("failed to get Airtel Money token: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", 0, fmt.Errorf("invalid Airtel Money token response: %w", err)
+	}
+	return body.AccessToken, time.Duration(max(body.ExpiresIn, 60)) * time.Second, nil
+}
+
+// do sends an authenticated request and decodes the JSON reply into out
+func (a *Airtel) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	conf := a.config()
+	req, err := httpclient.NewJSONRequest(ctx, method, conf.BaseURL+path, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Country", conf.Country)
+	req.Header.Set("X-Currency", conf.Currency)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid Airtel Money response: %s", resp.Status)
+	}
+	return nil
+}
+
+// airtelStatus is the status block of every Airtel Money reply
+type airtelStatus struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	ResultCode string `json:"result_code"`
+	Success    bool   `json:"success"`
+}
+
+// InitiatePayment implements Provider. Airtel Money identifies the payment by
+// our own reference, which is returned as the provider reference.
+func (a *Airtel) InitiatePayment(ctx context.Context, req PaymentRequest) (string, error) {
+	conf := a.config()
+	if req.Amount.Currency != conf.Currency {
+		return "", fmt.Errorf("Airtel Money only accepts %s, not %s", conf.Currency, req.Amount.Currency)
+	}
+	if req.Amount.Amount <= 0 {
+		return "", errors.New("payment amount must be positive")
+	}
+	amount := json.Number(strings.ReplaceAll(req.Amount.Decimal(), ",", ""))
+	var body struct {
+		Status airtelStatus `json:"status"`
+	}
+	err := a.do(ctx, http.MethodPost, "/merchant/v1/payments/", map[string]interface{}{
+		"reference": truncate(req.Description, 64),
+		"subscriber": map[string]string{
+			"country": conf.Country, "currency": conf.Currency, "msisdn": a.msisdn(req.Phone),
+		},
+		"transaction": map[string]interface{}{
+			"amount": amount, "country": conf.Country, "currency": conf.Currency, "id": req.Reference,
+		},
+	}, &body)
+	if err != nil {
+		return "", fmt.Errorf("Airtel Money payment request failed: %w", err)
+	}
+	if !body.Status.Success {
+		return "", fmt.Errorf("Airtel Money payment request rejected: %s", body.Status.Message)
+	}
+	return req.Reference, nil
+}
+
+// VerifyCallback implements CallbackVerifier by checking the token in the
+// URL the callback was posted to
+func (a *Airtel) VerifyCallback(r *http.Request, payload []byte) error {
+	token := r.URL.Query().Get("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.config().CallbackToken)) != 1 {
+		return errors.New("Airtel Money callback token is invalid")
+	}
+	return nil
+}
+
+// HandleCallback implements Provider for Airtel Money payment callbacks
+func (a *Airtel) HandleCallback(payload []byte) (PaymentResult, error) {
+	var body struct {
+		Transaction struct {
+			ID            string `json:"id"`
+			Message       string `json:"message"`
+			StatusCode    string `json:"status_code"`
+			AirtelMoneyID string `json:"airtel_money_id"`
+		} `json:"transaction"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return PaymentResult{}, fmt.Errorf("invalid Airtel Money callback: %w", err)
+	}
+	t := body.Transaction
+	if t.ID == "" {
+		return PaymentResult{}, errors.New("Airtel Money callback has no transaction ID")
+	}
+	return airtelResult(t.ID, t.StatusCode, t.AirtelMoneyID, t.Message), nil
+}
+
+// QueryStatus implements Provider with the transaction enquiry API
+func (a *Airtel) QueryStatus(ctx context.Context, reference string) (PaymentResult, error) {
+	var body struct {
+		Data struct {
+			Transaction struct {
+				AirtelMoneyID string `json:"airtel_money_id"`
+				Message       string `json:"message"`
+				Status        string `json:"status"`
+			} `json:"transaction"`
+		} `json:"data"`
+		Status airtelStatus `json:"status"`
+	}
+	if err := a.do(ctx, http.MethodGet, "/standard/v1/payments/"+reference, nil, &body); err != nil {
+		return PaymentResult{}, fmt.Errorf("Airtel Money status query failed: %w", err)
+	}
+	if !body.Status.Success {
+		return PaymentResult{}, fmt.Errorf("Airtel Money status query rejected: %s", body.Status.Message)
+	}
+	t := body.Data.Transaction
+	return airtelResult(reference, t.Status, t.AirtelMoneyID, t.Message), nil
+}
+
+// airtelResult maps Airtel Money transaction statuses: TS succeeded, TF
+// failed, and anything else (TIP, TA) is still in progress
+func airtelResult(reference, status, receipt, message string) PaymentResult {
+	res := PaymentResult{ProviderReference: reference, Status: PaymentPending, Message: message}
+	switch status {
+	case "TS":
+		res.Status, res.Receipt = PaymentCompleted, receipt
+	case "TF":
+		res.Status = PaymentFailed
+	}
+	return res
+}
+
+// msisdn formats phone the way Airtel Money expects it, without the country code
+func (a *Airtel) msisdn(phone string) string {
+	phone = strings.NewReplacer(" ", "", "-", "", "+", "").Replace(phone)
+	phone = strings.TrimPrefix(phone, airtelDialCodes[a.config().Country])
+	return strings.TrimPrefix(phone, "0")
+}
@@ -0,0 +1,265 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"tujifund-app/backend/money"
+)
+
+// Callback providers for M-Pesa B2C results, queue timeouts and the
+// results of transaction status queries
+const (
+	ProviderMpesaB2C        = "mpesa_b2c"
+	ProviderMpesaB2CTimeout = "mpesa_b2c_timeout"
+	ProviderMpesaB2CStatus  = "mpesa_b2c_status"
+)
+
+// PayoutRequest asks a provider to send money to a member
+type PayoutRequest struct {
+	Reference string // our ID for the payout, e.g. the disbursement ID
+	Phone     string
+	Amount    money.Money
+	Remarks   string
+}
+
+// Disburser is a provider that can send money to members' phones. Payouts
+// are asynchronous: Disburse returns the provider's reference and the outcome
+// arrives later in a result callback.
+type Disburser interface {
+	Name() string
+	// Disburse sends a payout and returns the provider's reference for it
+	Disburse(ctx context.Context, req PayoutRequest) (string, error)
+	// HandlePayoutResult reads the outcome of a payout from a result callback
+	HandlePayoutResult(payload []byte) (PaymentResult, error)
+	// QueryPayout asks for the outcome of the payout reference whose request
+	// timed out, providerReference being what Disburse returned for it. The
+	// answer arrives in a status callback.
+	QueryPayout(ctx context.Context, reference, providerReference string) error
+}
+
+// Disbursers maps a provider name to its configured payout integration
+var Disbursers = map[string]Disburser{}
+
+// RegisterDisburser makes d available for payouts
+func RegisterDisburser(d Disburser) {
+	Disbursers[d.Name()] = d
+}
+
+// LookupDisburser returns the configured payout provider called name
+func LookupDisburser(name string) (Disburser, error) {
+	d, ok := Disbursers[name]
+	if !ok {
+		return nil, ErrNoProvider
+	}
+	return d, nil
+}
+
+// CanDisburse reports whether B2C payouts are configured
+func (m *Mpesa) CanDisburse() bool {
+	conf := m.config()
+	return conf.InitiatorName != "" && conf.SecurityCredential != "" &&
+		conf.B2CResultURL != "" && conf.B2CTimeoutURL != "" && conf.B2CStatusURL != ""
+}
+
+// b2cShortCode is the paybill payouts are sent from
+func (conf *MpesaConfig) b2cShortCode() string {
+	if conf.B2CShortCode != "" {
+		return conf.B2CShortCode
+	}
+	return conf.ShortCode
+}
+
+// Disburse implements Disburser with a B2C business payment from the B2C
+// short code to phone. It returns the conversation ID the result will carry.
+func (m *Mpesa) Disburse(ctx context.Context, req PayoutRequest) (string, error) {
+	if !m.CanDisburse() {
+		return "", ErrNoProvider
+	}
+	if req.Amount.Currency != "KES" {
+		return "", fmt.Errorf("M-Pesa only accepts KES, not %s", req.Amount.Currency)
+	}
+	if req.Amount.Amount%100 != 0 || req.Amount.Amount <= 0 {
+		return "", errors.New("M-Pesa amounts must be whole shillings")
+	}
+	conf := m.config()
+	var body struct {
+		ConversationID string `json:"ConversationID"`
+		ResponseCode   string `json:"ResponseCode"`
+		ResponseDesc   string `json:"ResponseDescription"`
+		ErrorMessage   string `json:"errorMessage"`
+	}
+	status, err := m.post(ctx, "/mpesa/b2c/v3/paymentrequest", map[string]interface{}{
+		"OriginatorConversationID": req.Reference,
+		"InitiatorName":            conf.InitiatorName,
+		"SecurityCredential":       conf.SecurityCredential,
+		"CommandID":                "BusinessPayment",
+		"Amount":                   req.Amount.Amount / 100,
+		"PartyA":                   conf.b2cShortCode(),
+		"PartyB":                   MSISDN(req.Phone),
+		"Remarks":                  truncate(req.Remarks, 100),
+		"QueueTimeOutURL":          conf.callbackURL(conf.B2CTimeoutURL),
+		"ResultURL":                conf.callbackURL(conf.B2CResultURL),
+		"Occasion":                 truncate(req.Reference, 100),
+	}, &body)
+	if err != nil {
+		return "", fmt.Errorf("M-Pesa B2C payment failed: %w", err)
+	}
+	if status != http.StatusOK || body.ResponseCode != "0" {
+		return "", fmt.Errorf("M-Pesa B2C payment rejected: %s%s", body.ResponseDesc, body.ErrorMessage)
+	}
+	return body.ConversationID, nil
+}
+
+// HandlePayoutResult implements Disburser for B2C result callbacks
+func (m *Mpesa) HandlePayoutResult(payload []byte) (PaymentResult, error) {
+	var body struct {
+		Result struct {
+			ResultCode       int    `json:"ResultCode"`
+			ResultDesc       string `json:"ResultDesc"`
+			ConversationID   string `json:"ConversationID"`
+			TransactionID    string `json:"TransactionID"`
+			ResultParameters struct {
+				ResultParameter []struct {
+					Key   string          `json:"Key"`
+					Value json.RawMessage `json:"Value"`
+				} `json:"ResultParameter"`
+			} `json:"ResultParameters"`
+		} `json:"Result"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return PaymentResult{}, fmt.Errorf("invalid M-Pesa B2C result: %w", err)
+	}
+	r := body.Result
+	if r.ConversationID == "" {
+		return PaymentResult{}, errors.New("M-Pesa B2C result has no conversation ID")
+	}
+	res := PaymentResult{ProviderReference: r.ConversationID, Status: PaymentFailed, Message: r.ResultDesc}
+	if r.ResultCode != 0 {
+		return res, nil
+	}
+	res.Status, res.Receipt = PaymentCompleted, r.TransactionID
+	for _, p := range r.ResultParameters.ResultParameter {
+		if p.Key == "TransactionAmount" {
+			amount, err := mpesaAmount(p.Value)
+			if err != nil {
+				return PaymentResult{}, err
+			}
+			res.Amount = amount
+		}
+	}
+	return res, nil
+}
+
+// HandlePayoutTimeout reads a B2C queue timeout. M-Pesa may still have
+// processed the payment, so the payout stays pending until its transaction
+// status is known.
+func (m *Mpesa) HandlePayoutTimeout(payload []byte) (PaymentResult, error) {
+	res, err := m.HandlePayoutResult(payload)
+	if err != nil {
+		return res, err
+	}
+	res.Status, res.Receipt, res.Amount = PaymentPending, "", 0
+	if res.Message == "" {
+		res.Message = "M-Pesa B2C request timed out"
+	}
+	return res, nil
+}
+
+// QueryPayout implements Disburser with a transaction status query for the
+// payout's originator conversation ID. The payout's conversation ID goes as
+// the occasion, which the status result echoes.
+func (m *Mpesa) QueryPayout(ctx context.Context, reference, providerReference string) error {
+	if !m.CanDisburse() {
+		return ErrNoProvider
+	}
+	conf := m.config()
+	var body struct {
+		ResponseCode string `json:"ResponseCode"`
+		ResponseDesc string `json:"ResponseDescription"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+	status, err := m.query(ctx, "/mpesa/transactionstatus/v1/query", map[string]interface{}{
+		"Initiator":                conf.InitiatorName,
+		"SecurityCredential":       conf.SecurityCredential,
+		"CommandID":                "TransactionStatusQuery",
+		"OriginatorConversationID": reference,
+		"PartyA":                   conf.b2cShortCode(),
+		"IdentifierType":           "4",
+		"ResultURL":                conf.callbackURL(conf.B2CStatusURL),
+		"QueueTimeOutURL":          conf.callbackURL(conf.B2CStatusURL),
+		"Remarks":                  "Payout status",
+		"Occasion":                 providerReference,
+	}, &body)
+	if err != nil {
+		return fmt.Errorf("M-Pesa transaction status query failed: %w", err)
+	}
+	if status != http.StatusOK || body.ResponseCode != "0" {
+		return fmt.Errorf("M-Pesa transaction status query rejected: %s%s", body.ResponseDesc, body.ErrorMessage)
+	}
+	return nil
+}
+
+// HandlePayoutStatus reads the result of a transaction status query sent by
+// QueryPayout. A query M-Pesa could not answer leaves the payout pending; it
+// can be asked again by replaying the timeout from the callback log.
+func (m *Mpesa) HandlePayoutStatus(payload []byte) (PaymentResult, error) {
+	var body struct {
+		Result struct {
+			ResultCode       int    `json:"ResultCode"`
+			ResultDesc       string `json:"ResultDesc"`
+			ResultParameters struct {
+				ResultParameter []struct {
+					Key   string          `json:"Key"`
+					Value json.RawMessage `json:"Value"`
+				} `json:"ResultParameter"`
+			} `json:"ResultParameters"`
+			ReferenceData struct {
+				ReferenceItem struct {
+					Key   string `json:"Key"`
+					Value string `json:"Value"`
+				} `json:"ReferenceItem"`
+			} `json:"ReferenceData"`
+		} `json:"Result"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return PaymentResult{}, fmt.Errorf("invalid M-Pesa transaction status result: %w", err)
+	}
+	r := body.Result
+	if r.ReferenceData.ReferenceItem.Key != "Occasion" || r.ReferenceData.ReferenceItem.Value == "" {
+		return PaymentResult{}, errors.New("M-Pesa transaction status result does not name the payout")
+	}
+	res := PaymentResult{ProviderReference: r.ReferenceData.ReferenceItem.Value, Status: PaymentPending, Message: r.ResultDesc}
+	if r.ResultCode != 0 {
+		return res, nil
+	}
+	var state, receipt string
+	var amount json.RawMessage
+	for _, p := range r.ResultParameters.ResultParameter {
+		switch p.Key {
+		case "TransactionStatus":
+			json.Unmarshal(p.Value, &state)
+		case "ReceiptNo":
+			json.Unmarshal(p.Value, &receipt)
+		case "Amount":
+			amount = p.Value
+		}
+	}
+	switch state {
+	case "Completed":
+		if receipt == "" || amount == nil {
+			return PaymentResult{}, errors.New("M-Pesa transaction status reports completion without a receipt number and amount")
+		}
+		paid, err := mpesaAmount(amount)
+		if err != nil {
+			return PaymentResult{}, err
+		}
+		res.Status, res.Receipt, res.Amount = PaymentCompleted, receipt, paid
+	case "Failed", "Cancelled", "Expired":
+		res.Status = PaymentFailed
+	}
+	return res, nil
+}
@@ -0,0 +1,39 @@
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+// ProviderBank names payments made by bank transfer
+const ProviderBank = "bank_transfer"
+
+// BankTransfer is the manual provider: the member pays into the chama's bank
+// account, uploads the deposit slip, and a chama official confirms it
+// against the bank statement. There is no callback, and a payment stays
+// pending until it is confirmed or rejected.
+type BankTransfer struct{}
+
+// Name implements Provider
+func (BankTransfer) Name() string { return ProviderBank }
+
+// InitiatePayment implements Provider. Nothing is sent anywhere; the payment
+// is known by our own reference, which the member quotes on the transfer.
+func (BankTransfer) InitiatePayment(ctx context.Context, req PaymentRequest) (string, error) {
+	if req.Amount.Amount <= 0 {
+		return "", errors.New("payment amount must be positive")
+	}
+	return req.Reference, nil
+}
+
+// HandleCallback implements Provider. Banks do not call back.
+func (BankTransfer) HandleCallback(payload []byte) (PaymentResult, error) {
+	return PaymentResult{}, ErrUnsupported
+}
+
+// QueryStatus implements Provider. Bank transfers are pending until an
+// official confirms them, which the provider cannot see.
+func (BankTransfer) QueryStatus(ctx context.Context, reference string) (PaymentResult, error) {
+	return PaymentResult{ProviderReference: reference, Status: PaymentPending,
+		Message: "Awaiting confirmation by a chama official"}, nil
+}
@@ -0,0 +1,204 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/readonly"
+
+	"github.com/google/uuid"
+)
+
+// Callback statuses
+const (
+	StatusReceived  = "received"
+	StatusProcessed = "processed"
+	StatusFailed    = "failed"
+)
+
+var (
+	ErrNoProcessor = errors.New("no processor is registered for this provider")
+	ErrProcessed   = errors.New("callback has already been processed")
+)
+
+// Processor applies a provider's callback payload, for example by confirming
+// the contribution it pays for. It must be safe to run again for the same payload.
+type Processor func(ctx context.Context, db *sql.DB, payload []byte) error
+
+// Processors maps a provider name to the processor for its callbacks.
+// Provider integrations add themselves here at startup.
+var Processors = map[string]Processor{}
+
+// Callback is a stored provider callback
+type Callback struct {
+	ID          string    `json:"id"`
+	Provider    string    `json:"provider"`
+	Payload     string    `json:"payload"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Attempts    int       `json:"attempts"`
+	ReceivedAt  time.Time `json:"receivedAt"`
+	ProcessedAt string    `json:"processedAt,omitempty"`
+}
+
+const columns = `id, provider, payload, status, COALESCE(error, ''), attempts, received_at, COALESCE(processed_at, '')`
+
+func scan(row interface{ Scan(...interface{}) error }) (Callback, error) {
+	var c Callback
+	err := row.Scan(&c.ID, &c.Provider, &c.Payload, &c.Status, &c.Error, &c.Attempts, &c.ReceivedAt, &c.ProcessedAt)
+	return c, err
+}
+
+// Receive stores a callback from provider and processes it. The callback is
+// kept even when processing fails so it can be replayed with Process. In
+// read-only maintenance mode it is only stored, so the provider's result is
+// not lost, and the replay job processes it once the mode ends.
+func Receive(ctx context.Context, db *sql.DB, provider string, payload []byte) (Callback, error) {
+	id := uuid.NewString()
+	_, err := db.ExecContext(readonly.Allow(ctx), `
+		INSERT INTO payment_callbacks (id, provider, payload) VALUES (?, ?, ?)`,
+		id, provider, string(payload))
+	if err != nil {
+		return Callback{}, fmt.Errorf("failed to store callback: %w", err)
+	}
+	if readonly.Enabled() {
+		slog.InfoContext(ctx, "Payment callback held for read-only mode", "callback_id", id, "provider", provider)
+		return Get(ctx, db, id)
+	}
+	return Process(ctx, db, id)
+}
+
+// replayAfter is how long a callback stays received before the replay job
+// takes it up, leaving the request that stored it time to process it
+const replayAfter = 5 * time.Minute
+
+// RegisterReplayJob processes the callbacks left received: those held while
+// read-only maintenance mode was on, which the scheduler only runs once it
+// is off, and those whose processing was cut short
+func RegisterReplayJob(s *jobs.Scheduler, db *sql.DB) {
+	s.Register("payment_callback_replay", jobs.Every(10*time.Minute), func(ctx context.Context) error {
+		_, err := Replay(ctx, db, time.Now().Add(-replayAfter))
+		return err
+	})
+}
+
+// Replay processes the callbacks received before cutoff and not processed
+// since, oldest first, and returns how many it processed
+func Replay(ctx context.Context, db *sql.DB, cutoff time.Time) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id FROM payment_callbacks WHERE status = ? AND received_at < ?
+		ORDER BY received_at`,
+		StatusReceived, cutoff.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for n, id := range ids {
+		c, err := Process(ctx, db, id)
+		if err != nil && !errors.Is(err, ErrProcessed) {
+			return n, fmt.Errorf("callback %s: %w", id, err)
+		}
+		if c.Status == StatusFailed {
+			slog.WarnContext(ctx, "Replayed payment callback failed", "callback_id", id, "provider", c.Provider, "error", c.Error)
+		}
+	}
+	return len(ids), nil
+}
+
+// Process runs the registered processor for a stored callback and records the
+// outcome. Processing errors are recorded on the callback rather than returned.
+func Process(ctx context.Context, db *sql.DB, id string) (Callback, error) {
+	c, err := Get(ctx, db, id)
+	if err != nil {
+		return c, err
+	}
+	if c.Status == StatusProcessed {
+		return c, ErrProcessed
+	}
+
+	status, message := StatusProcessed, ""
+	if process, ok := Processors[c.Provider]; !ok {
+		status, message = StatusFailed, ErrNoProcessor.Error()
+	} else if err := process(ctx, db, []byte(c.Payload)); err != nil {
+		status, message = StatusFailed, err.Error()
+		slog.ErrorContext(ctx, "Payment callback failed", "callback_id", id, "provider", c.Provider, "error", err)
+	}
+
+	processedAt := interface{}(nil)
+	if status == StatusProcessed {
+		processedAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE payment_callbacks SET status = ?, error = ?, attempts = attempts + 1, processed_at = ?
+		WHERE id = ?`,
+		status, nullIfEmpty(message), processedAt, id)
+	if err != nil {
+		return c, err
+	}
+	return Get(ctx, db, id)
+}
+
+// Get returns a stored callback
+func Get(ctx context.Context, db *sql.DB, id string) (Callback, error) {
+	return scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM payment_callbacks WHERE id = ?`, id))
+}
+
+// List returns stored callbacks, newest first, optionally filtered by provider and status
+func List(ctx context.Context, db *sql.DB, provider, status string, limit int) ([]Callback, error) {
+	query := `SELECT ` + columns + ` FROM payment_callbacks WHERE 1 = 1`
+	var args []interface{}
+	if provider != "" {
+		query += ` AND provider = ?`
+		args = append(args, provider)
+	}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query += ` ORDER BY received_at DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Callback{}
+	for rows.Next() {
+		c, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"tujifund-app/backend/database/stmthook"
+	"tujifund-app/backend/readonly"
+
+	_ "modernc.org/sqlite"
+)
+
+// callbackDB returns a database that refuses writes in read-only mode, as
+// the app's databases do
+func callbackDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := stmthook.Open("sqlite", "file:"+t.TempDir()+"/payments.db", stmthook.Hook{Before: readonly.CheckStatement})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	schema, err := os.ReadFile("../database/database_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestReceiveHoldsCallbacksInReadOnlyMode(t *testing.T) {
+	ctx := context.Background()
+	db := callbackDB(t)
+	var processed [][]byte
+	Processors["test"] = func(ctx context.Context, db *sql.DB, payload []byte) error {
+		processed = append(processed, payload)
+		return nil
+	}
+	defer delete(Processors, "test")
+	defer readonly.Set(false, "", "")
+
+	readonly.Set(true, "", "staff")
+	held, err := Receive(ctx, db, "test", []byte(`{"result":"held"}`))
+	if err != nil {
+		t.Fatalf("Receive() in read-only mode: %v", err)
+	}
+	if held.Status != StatusReceived || len(processed) != 0 {
+		t.Fatalf("held callback status = %s after %d runs, want %s unprocessed", held.Status, len(processed), StatusReceived)
+	}
+
+	readonly.Set(false, "", "")
+	direct, err := Receive(ctx, db, "test", []byte(`{"result":"direct"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if direct.Status != StatusProcessed {
+		t.Errorf("callback received with the mode off has status %s, want %s", direct.Status, StatusProcessed)
+	}
+
+	tests := []struct {
+		name   string
+		cutoff time.Time
+		want   int
+	}{
+		{"too recent", time.Now().Add(-time.Hour), 0},
+		{"held callback", time.Now().Add(time.Minute), 1},
+		{"already replayed", time.Now().Add(time.Minute), 0},
+	}
+	for _, tt := range tests {
+		n, err := Replay(ctx, db, tt.cutoff)
+		if err != nil {
+			t.Fatalf("%s: Replay() error = %v", tt.name, err)
+		}
+		if n != tt.want {
+			t.Errorf("%s: Replay() processed %d, want %d", tt.name, n, tt.want)
+		}
+	}
+	c, err := Get(ctx, db, held.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Status != StatusProcessed || len(processed) != 2 || string(processed[1]) != `{"result":"held"}` {
+		t.Errorf("held callback status = %s with payloads %q processed, want it processed last", c.Status, processed)
+	}
+}
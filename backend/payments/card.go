@@ -0,0 +1,294 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"tujifund-app/backend/httpclient"
+)
+
+// ProviderCard names card payments, taken on Stripe's hosted checkout
+const ProviderCard = "card"
+
+// stripeTolerance is how old a webhook's signature timestamp may be
+const stripeTolerance = 5 * time.Minute
+
+// Checkout is a payment page hosted by the provider that the member is sent
+// to, for payments such as cards that cannot be pushed to a phone
+type Checkout struct {
+	Reference string    `json:"reference"` // the provider's reference, as for InitiatePayment
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CheckoutProvider is a Provider whose payments are made on a hosted checkout page
+type CheckoutProvider interface {
+	Provider
+	// CreateCheckout starts a payment and returns the page to send the member to
+	CreateCheckout(ctx context.Context, req PaymentRequest) (Checkout, error)
+}
+
+// CallbackVerifier is a Provider that authenticates its callbacks, by
+// signing them or by a secret in the callback URL. Callbacks that fail
+// verification are refused before they are stored.
+type CallbackVerifier interface {
+	VerifyCallback(r *http.Request, payload []byte) error
+}
+
+// ErrBadSignature is returned for a callback whose signature does not verify
+var ErrBadSignature = errors.New("callback signature is invalid")
+
+// StripeConfig configures Stripe Checkout
+type StripeConfig struct {
+	BaseURL       string // https://api.stripe.com by default
+	SecretKey     string
+	WebhookSecret string // whsec_..., for verifying webhook signatures
+	SuccessURL    string // where members land after paying
+	CancelURL     string // where members land if they give up
+}
+
+// Stripe takes card payments, mostly from members abroad, on Stripe's hosted
+// checkout. The checkout session ID is the provider reference, and the
+// outcome arrives by webhook.
+type Stripe struct {
+	conf   atomic.Pointer[StripeConfig]
+	client *httpclient.Client
+}
+
+// NewStripe creates a Stripe client
+func NewStripe(conf StripeConfig) (*Stripe, error) {
+	conf, err := conf.check()
+	if err != nil {
+		return nil, err
+	}
+	s := &Stripe{client: httpclient.New(httpclient.Config{Provider: ProviderCard})}
+	s.conf.Store(&conf)
+	return s, nil
+}
+
+// check validates conf and fills in defaults
+func (conf StripeConfig) check() (StripeConfig, error) {
+	if conf.SecretKey == "" || conf.WebhookSecret == "" {
+		return conf, errors.New("Stripe secret key and webhook secret are required")
+	}
+	if conf.SuccessURL == "" || conf.CancelURL == "" {
+		return conf, errors.New("Stripe success and cancel URLs are required")
+	}
+	if conf.BaseURL == "" {
+		conf.BaseURL = "https://api.stripe.com"
+	}
+	conf.BaseURL = strings.TrimRight(conf.BaseURL, "/")
+	return conf, nil
+}
+
+// config returns the client's current settings
+func (s *Stripe) config() *StripeConfig { return s.conf.Load() }
+
+// Reconfigure replaces the client's settings, e.g. with a rolled secret
+// key, without disturbing requests in flight
+func (s *Stripe) Reconfigure(conf StripeConfig) error {
+	conf, err := conf.check()
+	if err != nil {
+		return err
+	}
+	s.conf.Store(&conf)
+	return nil
+}
+
+// StripeFromEnv builds a Stripe client from STRIPE_* variables. It returns
+// nil without an error when card payments are not configured.
+func StripeFromEnv() (*Stripe, error) {
+	if os.Getenv("STRIPE_SECRET_KEY") == "" {
+		return nil, nil
+	}
+	return NewStripe(stripeConfigFromEnv())
+}
+
+// ReloadFromEnv reconfigures the client from STRIPE_* variables
+func (s *Stripe) ReloadFromEnv() error {
+	return s.Reconfigure(stripeConfigFromEnv())
+}
+
+func stripeConfigFromEnv() StripeConfig {
+	return StripeConfig{
+		BaseURL:       os.Getenv("STRIPE_BASE_URL"),
+		SecretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+		WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		SuccessURL:    os.Getenv("STRIPE_SUCCESS_URL"),
+		CancelURL:     os.Getenv("STRIPE_CANCEL_URL"),
+	}
+}
+
+// Name implements Provider
+func (s *Stripe) Name() string { return ProviderCard }
+
+// do sends a form-encoded request and decodes the JSON reply into out
+func (s *Stripe) do(ctx context.Context, method, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	conf := s.config()
+	req, err := http.NewRequestWithContext(ctx, method, conf.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(conf.SecretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		// Stripe carries out a repeated request once, so it can be retried
+		req = httpclient.WithIdempotencyKey(req, idempotencyKey)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("%s: %s", resp.Status, body.Error.Message)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid Stripe response: %s", resp.Status)
+	}
+	return nil
+}
+
+// stripeSession is the part of a checkout session we use
+type stripeSession struct {
+	ID                string `json:"id"`
+	URL               string `json:"url"`
+	ExpiresAt         int64  `json:"expires_at"`
+	Status            string `json:"status"`         // open, complete or expired
+	PaymentStatus     string `json:"payment_status"` // paid, unpaid or no_payment_required
+	AmountTotal       int64  `json:"amount_total"`
+	PaymentIntent     string `json:"payment_intent"`
+	ClientReferenceID string `json:"client_reference_id"`
+}
+
+// CreateCheckout implements CheckoutProvider with a Stripe Checkout session
+// for req.Amount in its own currency. Our reference doubles as the
+// idempotency key, so a retried request does not open a second session.
+func (s *Stripe) CreateCheckout(ctx context.Context, req PaymentRequest) (Checkout, error) {
+	if req.Amount.Amount <= 0 {
+		return Checkout{}, errors.New("payment amount must be positive")
+	}
+	conf := s.config()
+	form := url.Values{
+		"mode":                                   {"payment"},
+		"client_reference_id":                    {req.Reference},
+		"success_url":                            {conf.SuccessURL},
+		"cancel_url":                             {conf.CancelURL},
+		"metadata[reference]":                    {req.Reference},
+		"line_items[0][quantity]":                {"1"},
+		"line_items[0][price_data][currency]":    {strings.ToLower(req.Amount.Currency)},
+		"line_items[0][price_data][unit_amount]": {strconv.FormatInt(req.Amount.Amount, 10)},
+		"line_items[0][price_data][product_data][name]": {truncate(req.Description, 250)},
+	}
+	var session stripeSession
+	if err := s.do(ctx, http.MethodPost, "/v1/checkout/sessions", form, req.Reference, &session); err != nil {
+		return Checkout{}, fmt.Errorf("Stripe checkout failed: %w", err)
+	}
+	return Checkout{Reference: session.ID, URL: session.URL, ExpiresAt: time.Unix(session.ExpiresAt, 0).UTC()}, nil
+}
+
+// InitiatePayment implements Provider. The member still has to be sent to
+// the checkout page, so callers should use CreateCheckout.
+func (s *Stripe) InitiatePayment(ctx context.Context, req PaymentRequest) (string, error) {
+	c, err := s.CreateCheckout(ctx, req)
+	return c.Reference, err
+}
+
+// VerifyCallback implements CallbackVerifier by checking the Stripe-Signature
+// header: an HMAC-SHA256 of the timestamp and payload with the webhook secret
+func (s *Stripe) VerifyCallback(r *http.Request, payload []byte) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrBadSignature
+	}
+	if age := time.Since(time.Unix(t, 0)); age > stripeTolerance || age < -stripeTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrBadSignature)
+	}
+	mac := hmac.New(sha256.New, []byte(s.config().WebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, sig := range signatures {
+		if got, err := hex.DecodeString(sig); err == nil && hmac.Equal(got, expected) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
+
+// HandleCallback implements Provider for Stripe Checkout webhook events.
+// Events other than a session completing, failing or expiring report the
+// payment as still pending.
+func (s *Stripe) HandleCallback(payload []byte) (PaymentResult, error) {
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object stripeSession `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return PaymentResult{}, fmt.Errorf("invalid Stripe webhook: %w", err)
+	}
+	session := event.Data.Object
+	if session.ID == "" {
+		return PaymentResult{}, errors.New("Stripe webhook has no checkout session")
+	}
+	switch event.Type {
+	case "checkout.session.async_payment_failed":
+		return PaymentResult{ProviderReference: session.ID, Status: PaymentFailed, Message: "Card payment failed"}, nil
+	case "checkout.session.expired":
+		return PaymentResult{ProviderReference: session.ID, Status: PaymentFailed, Message: "Checkout expired"}, nil
+	}
+	return stripeResult(session), nil
+}
+
+// QueryStatus implements Provider by retrieving the checkout session
+func (s *Stripe) QueryStatus(ctx context.Context, reference string) (PaymentResult, error) {
+	var session stripeSession
+	if err := s.do(ctx, http.MethodGet, "/v1/checkout/sessions/"+url.PathEscape(reference), nil, "", &session); err != nil {
+		return PaymentResult{}, fmt.Errorf("Stripe status query failed: %w", err)
+	}
+	return stripeResult(session), nil
+}
+
+// stripeResult maps a checkout session: paid is completed, expired is
+// failed, and anything else is still in progress
+func stripeResult(session stripeSession) PaymentResult {
+	res := PaymentResult{ProviderReference: session.ID, Status: PaymentPending}
+	switch {
+	case session.PaymentStatus == "paid":
+		res.Status, res.Receipt, res.Amount = PaymentCompleted, session.PaymentIntent, session.AmountTotal
+	case session.Status == "expired":
+		res.Status, res.Message = PaymentFailed, "Checkout expired"
+	}
+	return res
+}
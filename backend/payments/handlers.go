@@ -0,0 +1,42 @@
+package payments
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// CallbackHandler receives provider's callbacks. A callback is stored before
+// it is processed, and processing failures can be replayed from the admin
+// callback log, so the provider is always told it was accepted. Callbacks
+// from providers that authenticate them are refused unless they verify.
+func CallbackHandler(db *sql.DB, provider string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if v, ok := Verifiers[provider]; ok {
+			if err := v.VerifyCallback(r, payload); err != nil {
+				slog.WarnContext(r.Context(), "Rejected payment callback", "provider", provider, "error", err)
+				http.Error(w, "Invalid callback", http.StatusUnauthorized)
+				return
+			}
+		}
+		c, err := Receive(r.Context(), db, provider, payload)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to store payment callback", "provider", provider, "error", err)
+			http.Error(w, "Failed to store callback", http.StatusInternalServerError)
+			return
+		}
+		if c.Status == StatusFailed {
+			slog.WarnContext(r.Context(), "Payment callback failed", "provider", provider, "callback_id", c.ID, "error", c.Error)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"ResultCode": 0, "ResultDesc": "Accepted"})
+	}
+}
@@ -0,0 +1,447 @@
+package payments
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/httpclient"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/phone"
+	"tujifund-app/backend/ratelimit"
+)
+
+// ProviderMpesa names M-Pesa callbacks in the callback log
+const ProviderMpesa = "mpesa"
+
+// MpesaRate is the pace Daraja requests are held to, below the API's own
+// throttling so that a burst of STK pushes queues briefly instead of failing
+var MpesaRate = ratelimit.Config{Rate: 5, Burst: 10}
+
+// MpesaConfig configures the Safaricom Daraja API
+type MpesaConfig struct {
+	BaseURL        string // https://sandbox.safaricom.co.ke or https://api.safaricom.co.ke
+	ConsumerKey    string
+	ConsumerSecret string
+	ShortCode      string // paybill or till receiving payments
+	PassKey        string // Lipa na M-Pesa Online passkey
+	CallbackURL    string // public URL of the M-Pesa callback endpoint
+	// CallbackToken is added to every callback URL given to Daraja, which
+	// does not sign its callbacks, so that forged ones can be refused
+	CallbackToken string
+
+	// B2C payouts; left empty when the chama only collects
+	InitiatorName      string
+	SecurityCredential string // initiator password encrypted with the M-Pesa certificate
+	B2CShortCode       string // paybill payouts are sent from, ShortCode by default
+	B2CResultURL       string // public URL of the B2C result endpoint
+	B2CTimeoutURL      string // public URL of the B2C queue timeout endpoint
+	B2CStatusURL       string // public URL of the B2C transaction status endpoint
+}
+
+// Mpesa requests payments from members' phones with Lipa na M-Pesa Online
+// (STK push)
+type Mpesa struct {
+	conf    atomic.Pointer[MpesaConfig]
+	client  *httpclient.Client
+	auth    *httpclient.Client // fetches tokens, authenticated with the consumer key
+	tokens  *httpclient.Tokens
+	breaker *breaker.Breaker
+}
+
+// NewMpesa creates a Daraja client
+func NewMpesa(conf MpesaConfig) (*Mpesa, error) {
+	conf, err := conf.check()
+	if err != nil {
+		return nil, err
+	}
+	m := &Mpesa{
+		auth:    httpclient.New(httpclient.Config{Provider: ProviderMpesa, Timeout: 10 * time.Second}),
+		breaker: breaker.New(ProviderMpesa, breaker.Config{Timeout: 20 * time.Second, Rate: MpesaRate}),
+	}
+	m.conf.Store(&conf)
+	m.tokens = httpclient.NewTokens(m.fetchToken)
+	m.client = httpclient.New(httpclient.Config{Provider: ProviderMpesa, Timeout: 10 * time.Second, Tokens: m.tokens})
+	return m, nil
+}
+
+// check validates conf and fills in defaults
+func (conf MpesaConfig) check() (MpesaConfig, error) {
+	if conf.ConsumerKey == "" || conf.ConsumerSecret == "" || conf.ShortCode == "" || conf.PassKey == "" {
+		return conf, errors.New("M-Pesa consumer key, consumer secret, short code and passkey are required")
+	}
+	if conf.CallbackURL == "" {
+		return conf, errors.New("M-Pesa callback URL is required")
+	}
+	if len(conf.CallbackToken) < 16 {
+		return conf, errors.New("M-Pesa callback token of at least 16 characters is required")
+	}
+	if conf.BaseURL == "" {
+		conf.BaseURL = "https://sandbox.safaricom.co.ke"
+	}
+	conf.BaseURL = strings.TrimRight(conf.BaseURL, "/")
+	return conf, nil
+}
+
+// config returns the client's current settings
+func (m *Mpesa) config() *MpesaConfig { return m.conf.Load() }
+
+// Reconfigure replaces the client's settings, e.g. with rotated
+// credentials, without disturbing requests in flight. The cached token is
+// dropped so the next request signs in with the new credentials.
+func (m *Mpesa) Reconfigure(conf MpesaConfig) error {
+	conf, err := conf.check()
+	if err != nil {
+		return err
+	}
+	m.conf.Store(&conf)
+	m.tokens.Invalidate()
+	return nil
+}
+
+// MpesaFromEnv builds a Daraja client from MPESA_* variables. It returns nil
+// without an error when M-Pesa is not configured.
+func MpesaFromEnv() (*Mpesa, error) {
+	if os.Getenv("MPESA_CONSUMER_KEY") == "" {
+		return nil, nil
+	}
+	return NewMpesa(mpesaConfigFromEnv())
+}
+
+// ReloadFromEnv reconfigures the client from MPESA_* variables
+func (m *Mpesa) ReloadFromEnv() error {
+	return m.Reconfigure(mpesaConfigFromEnv())
+}
+
+func mpesaConfigFromEnv() MpesaConfig {
+	return MpesaConfig{
+		BaseURL:        os.Getenv("MPESA_BASE_URL"),
+		ConsumerKey:    os.Getenv("MPESA_CONSUMER_KEY"),
+		ConsumerSecret: os.Getenv("MPESA_CONSUMER_SECRET"),
+		ShortCode:      os.Getenv("MPESA_SHORT_CODE"),
+		PassKey:        os.Getenv("MPESA_PASSKEY"),
+		CallbackURL:    os.Getenv("MPESA_CALLBACK_URL"),
+		CallbackToken:  os.Getenv("MPESA_CALLBACK_TOKEN"),
+
+		InitiatorName:      os.Getenv("MPESA_INITIATOR_NAME"),
+		SecurityCredential: os.Getenv("MPESA_SECURITY_CREDENTIAL"),
+		B2CShortCode:       os.Getenv("MPESA_B2C_SHORT_CODE"),
+		B2CResultURL:       os.Getenv("MPESA_B2C_RESULT_URL"),
+		B2CTimeoutURL:      os.Getenv("MPESA_B2C_TIMEOUT_URL"),
+		B2CStatusURL:       os.Getenv("MPESA_B2C_STATUS_URL"),
+	}
+}
+
+// fetchToken gets a new OAuth token
+func (m *Mpesa) fetchToken(ctx context.Context) (string, time.Duration, error) {
+	conf := m.config()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		conf.BaseURL+"/oauth/v1/generate?grant_type=client_credentials", nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.SetBasicAuth(conf.ConsumerKey, conf.ConsumerSecret)
+	resp, err := m.auth.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get M-Pesa token: %w", err)
+	}
+	if err := httpclient.CheckStatus(resp); err != nil {
+		return "", 0, fmt.Errorf("failed to get M-Pesa token: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", 0, fmt.Errorf("invalid M-Pesa token response: %w", err)
+	}
+	// Tokens last an hour
+	seconds, err := strconv.Atoi(body.ExpiresIn)
+	if err != nil || seconds <= 0 {
+		seconds = 3600
+	}
+	return body.AccessToken, time.Duration(seconds) * time.Second, nil
+}
+
+// STKPush asks phone to pay amount to the chama's paybill, with reference
+// shown to the member as the account number. It returns the checkout request
+// ID that the callback will carry.
+func (m *Mpesa) STKPush(ctx context.Context, phone string, amount money.Money, reference, description string) (string, error) {
+	if amount.Currency != "KES" {
+		return "", fmt.Errorf("M-Pesa only accepts KES, not %s", amount.Currency)
+	}
+	if amount.Amount%100 != 0 || amount.Amount <= 0 {
+		return "", errors.New("M-Pesa amounts must be whole shillings")
+	}
+	conf := m.config()
+	timestamp, password := m.password(conf)
+	msisdn := MSISDN(phone)
+	var body struct {
+		CheckoutRequestID string `json:"CheckoutRequestID"`
+		ResponseCode      string `json:"ResponseCode"`
+		ResponseDesc      string `json:"ResponseDescription"`
+		ErrorMessage      string `json:"errorMessage"`
+	}
+	status, err := m.post(ctx, "/mpesa/stkpush/v1/processrequest", map[string]interface{}{
+		"BusinessShortCode": conf.ShortCode,
+		"Password":          password,
+		"Timestamp":         timestamp,
+		"TransactionType":   "CustomerPayBillOnline",
+		"Amount":            amount.Amount / 100,
+		"PartyA":            msisdn,
+		"PartyB":            conf.ShortCode,
+		"PhoneNumber":       msisdn,
+		"CallBackURL":       conf.callbackURL(conf.CallbackURL),
+		"AccountReference":  truncate(reference, 12),
+		"TransactionDesc":   truncate(description, 13),
+	}, &body)
+	if err != nil {
+		return "", fmt.Errorf("M-Pesa STK push failed: %w", err)
+	}
+	if status != http.StatusOK || body.ResponseCode != "0" {
+		return "", fmt.Errorf("M-Pesa STK push rejected: %s%s", body.ResponseDesc, body.ErrorMessage)
+	}
+	return body.CheckoutRequestID, nil
+}
+
+// password returns the timestamp and password Lipa na M-Pesa Online requests are signed with
+func (m *Mpesa) password(conf *MpesaConfig) (string, string) {
+	timestamp := time.Now().In(nairobi).Format("20060102150405")
+	return timestamp, base64.StdEncoding.EncodeToString([]byte(conf.ShortCode + conf.PassKey + timestamp))
+}
+
+// post sends payload to a Daraja endpoint and decodes the JSON reply into out,
+// returning the HTTP status. Daraja reports errors in the body, so a non-200
+// reply is decoded too. Requests go through the M-Pesa breaker: while Daraja
+// is down they fail at once with a breaker.UnavailableError. Daraja has no
+// idempotency keys, so requests that move money are never retried.
+func (m *Mpesa) post(ctx context.Context, path string, payload, out interface{}) (int, error) {
+	return m.call(ctx, path, payload, out, false)
+}
+
+// query is post for read-only requests, which are retried when Daraja fails
+func (m *Mpesa) query(ctx context.Context, path string, payload, out interface{}) (int, error) {
+	return m.call(ctx, path, payload, out, true)
+}
+
+func (m *Mpesa) call(ctx context.Context, path string, payload, out interface{}, retryable bool) (int, error) {
+	var status int
+	var sendErr error
+	err := m.breaker.Do(ctx, func(ctx context.Context) error {
+		status, sendErr = m.send(ctx, path, payload, out, retryable)
+		switch {
+		case sendErr != nil && status == 0:
+			return sendErr
+		case status >= http.StatusInternalServerError, status == http.StatusTooManyRequests:
+			return fmt.Errorf("M-Pesa %s: %d", path, status)
+		}
+		// Rejections of the request itself say nothing about Daraja's health
+		return nil
+	})
+	if status == 0 && sendErr == nil {
+		return 0, err // the breaker refused the call
+	}
+	return status, sendErr
+}
+
+// send makes one Daraja request for call
+func (m *Mpesa) send(ctx context.Context, path string, payload, out interface{}, retryable bool) (int, error) {
+	req, err := httpclient.NewJSONRequest(ctx, http.MethodPost, m.config().BaseURL+path, payload)
+	if err != nil {
+		return 0, err
+	}
+	if retryable {
+		req = httpclient.Retryable(req)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("invalid M-Pesa response: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Name implements Provider
+func (m *Mpesa) Name() string { return ProviderMpesa }
+
+// InitiatePayment implements Provider with an STK push
+func (m *Mpesa) InitiatePayment(ctx context.Context, req PaymentRequest) (string, error) {
+	return m.STKPush(ctx, req.Phone, req.Amount, req.Reference, req.Description)
+}
+
+// callbackURL adds the callback token to endpoint
+func (conf *MpesaConfig) callbackURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	q := u.Query()
+	q.Set("token", conf.CallbackToken)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// VerifyCallback implements CallbackVerifier by checking the token that
+// callbackURL added to the URL the callback was posted to
+func (m *Mpesa) VerifyCallback(r *http.Request, payload []byte) error {
+	token := r.URL.Query().Get("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(m.config().CallbackToken)) != 1 {
+		return errors.New("M-Pesa callback token is invalid")
+	}
+	return nil
+}
+
+// HandleCallback implements Provider for STK push callbacks
+func (m *Mpesa) HandleCallback(payload []byte) (PaymentResult, error) {
+	res, err := ParseSTKCallback(payload)
+	if err != nil {
+		return PaymentResult{}, err
+	}
+	return res.Result(), nil
+}
+
+// QueryStatus implements Provider with the STK push query API. M-Pesa does
+// not give the receipt number in query replies; the result carries none.
+func (m *Mpesa) QueryStatus(ctx context.Context, checkoutID string) (PaymentResult, error) {
+	conf := m.config()
+	timestamp, password := m.password(conf)
+	var body struct {
+		ResponseCode string `json:"ResponseCode"`
+		ResultCode   string `json:"ResultCode"`
+		ResultDesc   string `json:"ResultDesc"`
+		ErrorCode    string `json:"errorCode"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+	status, err := m.query(ctx, "/mpesa/stkpushquery/v1/query", map[string]interface{}{
+		"BusinessShortCode": conf.ShortCode,
+		"Password":          password,
+		"Timestamp":         timestamp,
+		"CheckoutRequestID": checkoutID,
+	}, &body)
+	if err != nil {
+		return PaymentResult{}, fmt.Errorf("M-Pesa status query failed: %w", err)
+	}
+
+	res := PaymentResult{ProviderReference: checkoutID, Status: PaymentPending, Message: body.ResultDesc}
+	switch {
+	case body.ErrorCode == mpesaStillProcessing:
+		res.Message = body.ErrorMessage
+	case status != http.StatusOK || body.ResponseCode != "0":
+		return PaymentResult{}, fmt.Errorf("M-Pesa status query rejected: %s", body.ErrorMessage)
+	case body.ResultCode == "0":
+		res.Status = PaymentCompleted
+	default:
+		res.Status = PaymentFailed
+	}
+	return res, nil
+}
+
+// mpesaStillProcessing is the error code of status queries for payments the
+// member has not yet answered
+const mpesaStillProcessing = "500.001.1001"
+
+var nairobi = time.FixedZone("EAT", 3*60*60)
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// MSISDN formats a Kenyan phone number the way M-Pesa expects it, 2547XXXXXXXX
+func MSISDN(number string) string {
+	return phone.MSISDN(number)
+}
+
+// STKResult is the outcome of an STK push, as reported in its callback
+type STKResult struct {
+	CheckoutRequestID string
+	ResultCode        int // 0 is success
+	ResultDesc        string
+	Receipt           string // M-Pesa transaction code, on success
+	Amount            int64  // in minor units, on success
+	Phone             string
+}
+
+// ParseSTKCallback reads an STK push callback payload
+func ParseSTKCallback(payload []byte) (STKResult, error) {
+	var body struct {
+		Body struct {
+			StkCallback struct {
+				CheckoutRequestID string `json:"CheckoutRequestID"`
+				ResultCode        int    `json:"ResultCode"`
+				ResultDesc        string `json:"ResultDesc"`
+				CallbackMetadata  struct {
+					Item []struct {
+						Name  string          `json:"Name"`
+						Value json.RawMessage `json:"Value"`
+					} `json:"Item"`
+				} `json:"CallbackMetadata"`
+			} `json:"stkCallback"`
+		} `json:"Body"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return STKResult{}, fmt.Errorf("invalid M-Pesa callback: %w", err)
+	}
+	cb := body.Body.StkCallback
+	if cb.CheckoutRequestID == "" {
+		return STKResult{}, errors.New("M-Pesa callback has no checkout request ID")
+	}
+	res := STKResult{CheckoutRequestID: cb.CheckoutRequestID, ResultCode: cb.ResultCode, ResultDesc: cb.ResultDesc}
+	for _, item := range cb.CallbackMetadata.Item {
+		switch item.Name {
+		case "MpesaReceiptNumber":
+			json.Unmarshal(item.Value, &res.Receipt)
+		case "Amount":
+			amount, err := mpesaAmount(item.Value)
+			if err != nil {
+				return STKResult{}, err
+			}
+			res.Amount = amount
+		case "PhoneNumber":
+			var phone json.Number
+			json.Unmarshal(item.Value, &phone)
+			res.Phone = phone.String()
+		}
+	}
+	if res.ResultCode == 0 && (res.Receipt == "" || res.Amount <= 0) {
+		return STKResult{}, errors.New("M-Pesa callback reports success without a receipt number and amount")
+	}
+	return res, nil
+}
+
+// mpesaAmount reads an amount in shillings as Daraja reports it, a JSON
+// number or string
+func mpesaAmount(raw json.RawMessage) (int64, error) {
+	m, err := money.Parse(strings.Trim(string(raw), `"`), "KES")
+	if err != nil {
+		return 0, fmt.Errorf("invalid M-Pesa amount %s: %w", raw, err)
+	}
+	return m.Amount, nil
+}
+
+// Result converts the callback to a provider-neutral PaymentResult
+func (r STKResult) Result() PaymentResult {
+	res := PaymentResult{ProviderReference: r.CheckoutRequestID, Status: PaymentCompleted,
+		Receipt: r.Receipt, Amount: r.Amount, Message: r.ResultDesc}
+	if r.ResultCode != 0 {
+		res.Status = PaymentFailed
+	}
+	return res
+}
@@ -0,0 +1,81 @@
+package payments
+
+import (
+	"fmt"
+	"testing"
+)
+
+func stkCallback(resultCode int, amount string) []byte {
+	return []byte(fmt.Sprintf(`{"Body":{"stkCallback":{
+		"MerchantRequestID":"29115-34620561-1","CheckoutRequestID":"ws_CO_191220191020363925",
+		"ResultCode":%d,"ResultDesc":"The service request is processed successfully.",
+		"CallbackMetadata":{"Item":[
+			{"Name":"Amount","Value":%s},
+			{"Name":"MpesaReceiptNumber","Value":"NLJ7RT61SV"},
+			{"Name":"TransactionDate","Value":20191219102115},
+			{"Name":"PhoneNumber","Value":254708374149}]}}}}`, resultCode, amount))
+}
+
+func TestParseSTKCallbackAmount(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   int64
+		ok     bool
+	}{
+		{`1`, 100, true},
+		{`1500`, 150000, true},
+		{`1500.50`, 150050, true},
+		{`"1500.50"`, 150050, true},
+		{`0.1`, 10, true},
+		{`19.99`, 1999, true},
+		{`1.005`, 0, false},
+		{`1e3`, 0, false},
+		{`-5`, 0, false},
+		{`"--5"`, 0, false},
+		{`"+5"`, 0, false},
+		{`0`, 0, false},
+		{`"abc"`, 0, false},
+	}
+	for _, tt := range tests {
+		res, err := ParseSTKCallback(stkCallback(0, tt.amount))
+		if (err == nil) != tt.ok {
+			t.Errorf("amount %s: error = %v, want ok %v", tt.amount, err, tt.ok)
+			continue
+		}
+		if err == nil && res.Amount != tt.want {
+			t.Errorf("amount %s: got %d, want %d", tt.amount, res.Amount, tt.want)
+		}
+	}
+}
+
+func TestParseSTKCallback(t *testing.T) {
+	res, err := ParseSTKCallback(stkCallback(0, `1500`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CheckoutRequestID != "ws_CO_191220191020363925" || res.Receipt != "NLJ7RT61SV" || res.Phone != "254708374149" {
+		t.Errorf("got %+v", res)
+	}
+	if r := res.Result(); r.Status != PaymentCompleted || r.Amount != 150000 {
+		t.Errorf("result = %+v", r)
+	}
+
+	// A cancelled payment carries no metadata
+	res, err = ParseSTKCallback([]byte(`{"Body":{"stkCallback":{"CheckoutRequestID":"ws_CO_1","ResultCode":1032,"ResultDesc":"Request cancelled by user"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := res.Result(); r.Status != PaymentFailed {
+		t.Errorf("cancelled result = %+v", r)
+	}
+
+	for _, payload := range []string{
+		`not json`,
+		`{"Body":{"stkCallback":{"ResultCode":0}}}`,
+		`{"Body":{"stkCallback":{"CheckoutRequestID":"ws_CO_1","ResultCode":0}}}`,
+	} {
+		if _, err := ParseSTKCallback([]byte(payload)); err == nil {
+			t.Errorf("ParseSTKCallback(%s) succeeded", payload)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"tujifund-app/backend/money"
+)
+
+// Payment statuses reported by providers
+const (
+	PaymentPending   = "pending"
+	PaymentCompleted = "completed"
+	PaymentFailed    = "failed"
+)
+
+var (
+	// ErrNoProvider is returned when paying with a provider that is not configured
+	ErrNoProvider = errors.New("payment provider is not configured")
+	// ErrUnsupported is returned by providers for operations they cannot perform
+	ErrUnsupported = errors.New("operation is not supported by this payment provider")
+	// ErrUnconfirmed is returned for a completed callback that the provider's
+	// status query does not bear out
+	ErrUnconfirmed = errors.New("payment is not confirmed by the provider")
+)
+
+// PaymentRequest asks a member to pay
+type PaymentRequest struct {
+	Reference   string // our ID for the payment, e.g. the contribution ID
+	Phone       string
+	Amount      money.Money
+	Description string
+}
+
+// PaymentResult is a provider's report on a payment
+type PaymentResult struct {
+	ProviderReference string // as returned by InitiatePayment
+	Status            string // PaymentPending, PaymentCompleted or PaymentFailed
+	Receipt           string // the provider's transaction code, once completed
+	Amount            int64  // in minor units; zero when the provider does not report it
+	Message           string
+	Confirmed         bool // reported or borne out by QueryStatus, not only by a callback
+}
+
+// Provider is a way for members to pay a chama. Mobile money providers push
+// a payment prompt to the member's phone and report back by callback; manual
+// providers such as bank transfer are confirmed by a chama official.
+type Provider interface {
+	// Name identifies the provider in callbacks and payment methods
+	Name() string
+	// InitiatePayment starts a payment and returns the provider's reference for it
+	InitiatePayment(ctx context.Context, req PaymentRequest) (string, error)
+	// HandleCallback reads the outcome of a payment from a callback payload
+	HandleCallback(payload []byte) (PaymentResult, error)
+	// QueryStatus asks the provider for the outcome of a payment
+	QueryStatus(ctx context.Context, providerReference string) (PaymentResult, error)
+}
+
+// Confirm checks a completed or failed result read from one of provider's
+// callbacks against its status query before anything is settled or failed
+// on it, since anyone who finds a callback endpoint can post to it. A
+// completed callback must carry the provider's receipt. Pending results are
+// returned as they are.
+func Confirm(ctx context.Context, provider Provider, res PaymentResult) (PaymentResult, error) {
+	switch res.Status {
+	case PaymentPending:
+		return res, nil
+	case PaymentCompleted:
+		if res.Receipt == "" {
+			return res, fmt.Errorf("%s callback for payment %s has no receipt", provider.Name(), res.ProviderReference)
+		}
+	}
+	status, err := provider.QueryStatus(ctx, res.ProviderReference)
+	if err != nil {
+		return res, fmt.Errorf("failed to confirm %s payment %s: %w", provider.Name(), res.ProviderReference, err)
+	}
+	if status.Status != res.Status {
+		return res, fmt.Errorf("%w: %s reports payment %s as %s, not %s", ErrUnconfirmed, provider.Name(), res.ProviderReference, status.Status, res.Status)
+	}
+	if res.Status == PaymentCompleted && status.Amount != 0 && status.Amount != res.Amount {
+		return res, fmt.Errorf("%w: %s reports %d paid on payment %s, not %d", ErrUnconfirmed, provider.Name(), status.Amount, res.ProviderReference, res.Amount)
+	}
+	res.Confirmed = true
+	return res, nil
+}
+
+// Providers maps a provider name to its configured integration. Providers
+// add themselves with Register at startup.
+var Providers = map[string]Provider{}
+
+// Verifiers maps a callback provider name to what authenticates its
+// callbacks. Register adds providers that authenticate their own.
+var Verifiers = map[string]CallbackVerifier{}
+
+// Register makes p available for payments
+func Register(p Provider) {
+	Providers[p.Name()] = p
+	if v, ok := p.(CallbackVerifier); ok {
+		Verifiers[p.Name()] = v
+	}
+}
+
+// Lookup returns the configured provider called name
+func Lookup(name string) (Provider, error) {
+	p, ok := Providers[name]
+	if !ok {
+		return nil, ErrNoProvider
+	}
+	return p, nil
+}
@@ -0,0 +1,96 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+// statusProvider answers status queries with status, or err
+type statusProvider struct {
+	Provider
+	status PaymentResult
+	err    error
+}
+
+func (p statusProvider) Name() string { return "test" }
+
+func (p statusProvider) QueryStatus(ctx context.Context, reference string) (PaymentResult, error) {
+	return p.status, p.err
+}
+
+// errAny stands for any error in test tables
+var errAny = errors.New("any error")
+
+func TestConfirm(t *testing.T) {
+	completed := PaymentResult{ProviderReference: "p1", Status: PaymentCompleted, Receipt: "R1", Amount: 150000}
+	failed := PaymentResult{ProviderReference: "p1", Status: PaymentFailed, Message: "Request cancelled by user"}
+	pending := PaymentResult{ProviderReference: "p1", Status: PaymentPending}
+	queryErr := errors.New("provider unavailable")
+
+	tests := []struct {
+		name      string
+		res       PaymentResult
+		status    PaymentResult
+		err       error
+		confirmed bool
+		wantErr   error // nil for no error, or what it must wrap
+	}{
+		{"completed and borne out", completed, PaymentResult{Status: PaymentCompleted, Amount: 150000}, nil, true, nil},
+		{"completed without an amount in the query", completed, PaymentResult{Status: PaymentCompleted}, nil, true, nil},
+		{"completed but pending", completed, PaymentResult{Status: PaymentPending}, nil, false, ErrUnconfirmed},
+		{"completed for another amount", completed, PaymentResult{Status: PaymentCompleted, Amount: 100}, nil, false, ErrUnconfirmed},
+		{"completed without a receipt", PaymentResult{ProviderReference: "p1", Status: PaymentCompleted}, PaymentResult{Status: PaymentCompleted}, nil, false, errAny},
+		{"failed and borne out", failed, PaymentResult{Status: PaymentFailed}, nil, true, nil},
+		{"forged failure of a pending payment", failed, PaymentResult{Status: PaymentPending}, nil, false, ErrUnconfirmed},
+		{"forged failure of a completed payment", failed, PaymentResult{Status: PaymentCompleted}, nil, false, ErrUnconfirmed},
+		{"failed but the query fails", failed, PaymentResult{}, queryErr, false, queryErr},
+		{"pending", pending, PaymentResult{}, queryErr, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Confirm(context.Background(), statusProvider{status: tt.status, err: tt.err}, tt.res)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("Confirm() error = %v", err)
+			case tt.wantErr != nil && err == nil:
+				t.Fatalf("Confirm() succeeded, want error %v", tt.wantErr)
+			case tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("Confirm() error = %v, want %v", err, tt.wantErr)
+			}
+			if res.Confirmed != tt.confirmed {
+				t.Errorf("Confirmed = %v, want %v", res.Confirmed, tt.confirmed)
+			}
+		})
+	}
+}
+
+func TestAirtelVerifyCallback(t *testing.T) {
+	const token = "0123456789abcdef"
+	if _, err := NewAirtel(AirtelConfig{ClientID: "id", ClientSecret: "secret"}); err == nil {
+		t.Error("NewAirtel() without a callback token succeeded")
+	}
+	a, err := NewAirtel(AirtelConfig{ClientID: "id", ClientSecret: "secret", CallbackToken: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := any(a).(CallbackVerifier); !ok {
+		t.Fatal("Airtel does not verify its callbacks")
+	}
+
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"/api/payments/airtel/callback?token=" + token, true},
+		{"/api/payments/airtel/callback?token=0123456789abcdeX", false},
+		{"/api/payments/airtel/callback", false},
+	}
+	for _, tt := range tests {
+		err := a.VerifyCallback(httptest.NewRequest("POST", tt.url, nil), []byte(`{"transaction":{"id":"p1","status_code":"TF"}}`))
+		if (err == nil) != tt.ok {
+			t.Errorf("VerifyCallback(%s) error = %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}
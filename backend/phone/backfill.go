@@ -0,0 +1,95 @@
+package phone
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// columns are the stored phone numbers that Backfill normalises
+var columns = []struct{ table, column string }{
+	{"users", "phone_number"},
+	{"join_requests", "phone"},
+	{"disbursements", "phone_number"},
+	{"statement_lines", "phone_number"},
+	{"ussd_sessions", "phone_number"},
+}
+
+// BackfillResult counts what Backfill found in one column
+type BackfillResult struct {
+	Table   string `json:"table"`
+	Column  string `json:"column"`
+	Checked int    `json:"checked"`
+	Updated int    `json:"updated"`
+	Invalid int    `json:"invalid"` // left as they were, e.g. masked or foreign numbers
+}
+
+// Backfill rewrites stored phone numbers written before numbers were
+// normalised into E.164 form, in one transaction. Numbers that are not
+// Kenyan mobile numbers are counted and left alone. With dryRun set nothing
+// is changed.
+func Backfill(ctx context.Context, db *sql.DB, dryRun bool) ([]BackfillResult, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var results []BackfillResult
+	for _, c := range columns {
+		res, err := backfill(ctx, tx, c.table, c.column, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("%s.%s: %w", c.table, c.column, err)
+		}
+		results = append(results, res)
+	}
+	if dryRun {
+		return results, nil
+	}
+	return results, tx.Commit()
+}
+
+func backfill(ctx context.Context, tx *sql.Tx, table, column string, dryRun bool) (BackfillResult, error) {
+	res := BackfillResult{Table: table, Column: column}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT rowid, %[2]s FROM %[1]s WHERE %[2]s IS NOT NULL AND %[2]s != ''`, table, column))
+	if err != nil {
+		return res, err
+	}
+	type change struct {
+		rowid int64
+		value string
+	}
+	var changes []change
+	for rows.Next() {
+		var rowid int64
+		var value string
+		if err := rows.Scan(&rowid, &value); err != nil {
+			rows.Close()
+			return res, err
+		}
+		res.Checked++
+		n, err := Normalize(value)
+		switch {
+		case err != nil:
+			res.Invalid++
+		case n != value:
+			changes = append(changes, change{rowid, n})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+
+	res.Updated = len(changes)
+	if dryRun {
+		return res, nil
+	}
+	for _, c := range changes {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET %s = ? WHERE rowid = ?`, table, column), c.value, c.rowid); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,153 @@
+// Package phone normalises Kenyan mobile numbers. Members type numbers as
+// 0712 345 678, 712345678, 254712345678 or +254-712-345-678, and payment
+// providers report them as 254712345678; everything is stored in E.164
+// form, +254712345678, so that numbers compare equal wherever they came
+// from and payments can be matched to members.
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+// CountryCode is Kenya's calling code
+const CountryCode = "254"
+
+// Mobile network operators
+const (
+	OperatorSafaricom = "safaricom"
+	OperatorAirtel    = "airtel"
+	OperatorTelkom    = "telkom"
+	OperatorEquitel   = "equitel"
+	OperatorFaiba     = "faiba"
+)
+
+// ErrInvalid is returned for a number that is not a Kenyan mobile number
+var ErrInvalid = errors.New("not a valid Kenyan mobile number")
+
+// prefixes maps the first three digits of a national mobile number (after
+// the leading 0) to its operator, as allocated by the Communications
+// Authority. Numbers ported between operators keep their prefix, so this is
+// the original operator only.
+var prefixes = map[string]string{}
+
+func init() {
+	ranges := []struct {
+		from, to int
+		operator string
+	}{
+		{700, 729, OperatorSafaricom},
+		{730, 739, OperatorAirtel},
+		{740, 746, OperatorSafaricom},
+		{747, 747, OperatorFaiba},
+		{748, 748, OperatorSafaricom},
+		{750, 756, OperatorAirtel},
+		{757, 759, OperatorSafaricom},
+		{762, 762, OperatorAirtel},
+		{763, 766, OperatorEquitel},
+		{768, 769, OperatorSafaricom},
+		{770, 779, OperatorTelkom},
+		{780, 789, OperatorAirtel},
+		{790, 799, OperatorSafaricom},
+		{100, 102, OperatorAirtel},
+		{110, 115, OperatorSafaricom},
+	}
+	for _, r := range ranges {
+		for p := r.from; p <= r.to; p++ {
+			prefixes[itoa3(p)] = r.operator
+		}
+	}
+}
+
+func itoa3(n int) string {
+	return string([]byte{byte('0' + n/100), byte('0' + n/10%10), byte('0' + n%10)})
+}
+
+// national returns the nine-digit national number of a Kenyan mobile
+// number in any of the accepted forms, or "" if raw is not one
+func national(raw string) string {
+	raw = strings.TrimSpace(raw)
+	plus := strings.HasPrefix(raw, "+")
+	var digits strings.Builder
+	for i, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0, r == ' ', r == '-', r == '.', r == '(', r == ')':
+		default:
+			return ""
+		}
+	}
+	d := digits.String()
+	switch {
+	case strings.HasPrefix(d, "00"+CountryCode) && !plus:
+		d = d[5:]
+	case strings.HasPrefix(d, CountryCode) && len(d) == 12:
+		d = d[3:]
+	case plus:
+		return "" // another country
+	case strings.HasPrefix(d, "0") && len(d) == 10:
+		d = d[1:]
+	}
+	if len(d) != 9 || (d[0] != '7' && d[0] != '1') {
+		return ""
+	}
+	return d
+}
+
+// Normalize returns raw in E.164 form, +254XXXXXXXXX
+func Normalize(raw string) (string, error) {
+	n := national(raw)
+	if n == "" {
+		return "", ErrInvalid
+	}
+	return "+" + CountryCode + n, nil
+}
+
+// Canonical returns raw in E.164 form, or raw unchanged if it is not a
+// Kenyan mobile number. Input that has been validated can be stored as
+// Canonical returns it.
+func Canonical(raw string) string {
+	if n, err := Normalize(raw); err == nil {
+		return n
+	}
+	return raw
+}
+
+// Valid reports whether raw is a Kenyan mobile number
+func Valid(raw string) bool {
+	return national(raw) != ""
+}
+
+// MSISDN returns raw as payment providers write it, 254XXXXXXXXX, or raw
+// with separators removed if it is not a Kenyan mobile number
+func MSISDN(raw string) string {
+	if n := national(raw); n != "" {
+		return CountryCode + n
+	}
+	return strings.NewReplacer(" ", "", "-", "", "+", "").Replace(raw)
+}
+
+// Local returns raw in the national form members are used to, 07XXXXXXXX
+func Local(raw string) string {
+	if n := national(raw); n != "" {
+		return "0" + n
+	}
+	return raw
+}
+
+// Operator returns the network raw was allocated to, or "" if the prefix is
+// unknown or raw is not a Kenyan mobile number
+func Operator(raw string) string {
+	n := national(raw)
+	if n == "" {
+		return ""
+	}
+	return prefixes[n[:3]]
+}
+
+// Equal reports whether a and b are the same number written differently
+func Equal(a, b string) bool {
+	na, nb := national(a), national(b)
+	return na != "" && na == nb
+}
@@ -0,0 +1,254 @@
+package portable
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/ledger"
+)
+
+// batchIDs is how many IDs go in one IN list, well under SQLite's limit on
+// parameters per statement
+const batchIDs = 500
+
+// scope is the condition selecting a table's rows that belong to the chama
+type scope struct {
+	where string
+	args  []interface{}
+}
+
+// scopes works out which tables hold the chama's rows, as tenancy's
+// Isolate does: the chama itself, every table with a chama_id column, and
+// tables that reference those through foreign keys, followed until no new
+// table is reached. Users and the audit log are exported separately.
+func scopes(ctx context.Context, db querier, all map[string]*table, chamaID string) (map[string]scope, map[string][]foreignKey, error) {
+	fks := map[string][]foreignKey{}
+	for name := range all {
+		list, err := foreignKeys(ctx, db, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		fks[name] = list
+	}
+
+	scoped := map[string]scope{"chamas": {where: "id = ?", args: []interface{}{chamaID}}}
+	for name, t := range all {
+		if t.has["chama_id"] && !skipped[name] && name != "chamas" {
+			scoped[name] = scope{where: "chama_id = ?", args: []interface{}{chamaID}}
+		}
+	}
+	for more := true; more; {
+		more = false
+		for name := range all {
+			if _, ok := scoped[name]; ok || skipped[name] || name == "users" || name == "audit_logs" {
+				continue
+			}
+			for _, fk := range fks[name] {
+				parent, ok := scoped[fk.parent]
+				if !ok {
+					continue
+				}
+				scoped[name] = scope{
+					where: fmt.Sprintf(`%q IN (SELECT %q FROM %q WHERE %s)`, fk.from, fk.to, fk.parent, parent.where),
+					args:  parent.args,
+				}
+				more = true
+				break
+			}
+		}
+	}
+	return scoped, fks, nil
+}
+
+// Export writes chamaID and everything it owns to w. The chama's members
+// and everyone else its rows name are included as users, along with the
+// audit log entries about its records. The rows are read in one transaction,
+// so the export is consistent even while the app is running.
+func Export(ctx context.Context, db *sql.DB, chamaID string, w io.Writer) (Trailer, error) {
+	trailer := Trailer{Rows: map[string]int{}, Documents: []Document{}}
+	var name string
+	err := db.QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, chamaID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return trailer, ErrNotFound
+	}
+	if err != nil {
+		return trailer, err
+	}
+	chain, err := ledger.Verify(ctx, db, chamaID)
+	if err != nil {
+		return trailer, fmt.Errorf("failed to verify ledger: %w", err)
+	}
+	if !chain.Valid {
+		return trailer, fmt.Errorf("%w at entry %s: %s", ErrLedgerBroken, chain.BrokenAt, chain.Reason)
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return trailer, err
+	}
+	defer tx.Rollback()
+
+	all, err := tables(ctx, tx)
+	if err != nil {
+		return trailer, err
+	}
+	scoped, fks, err := scopes(ctx, tx, all, chamaID)
+	if err != nil {
+		return trailer, err
+	}
+
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	err = enc.Encode(record{Header: &Header{
+		Format: Format, Version: Version, ChamaID: chamaID, ChamaName: name,
+		ExportedAt: time.Now().UTC(), Ledger: chain,
+	}})
+	if err != nil {
+		return trailer, err
+	}
+
+	// The chama first, then the rest in a fixed order, so exports of the
+	// same data compare equal
+	names := make([]string, 0, len(scoped))
+	for name := range scoped {
+		if name != "chamas" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{"chamas"}, names...)
+
+	users := map[string]bool{}
+	var ids []interface{}
+	for _, name := range names {
+		t := all[name]
+		userColumns := map[string]bool{}
+		for _, fk := range fks[name] {
+			if fk.parent == "users" {
+				userColumns[fk.from] = true
+			}
+		}
+		s := scoped[name]
+		err := writeRows(ctx, tx, enc, t, s.where, s.args, func(row map[string]interface{}) {
+			if id, ok := row["id"].(string); ok {
+				ids = append(ids, id)
+			}
+			for column := range userColumns {
+				if id, ok := row[column].(string); ok && id != "" {
+					users[id] = true
+				}
+			}
+			trailer.Documents = append(trailer.Documents, documents(name, row)...)
+			trailer.Rows[name]++
+		})
+		if err != nil {
+			return trailer, err
+		}
+	}
+
+	// Audit entries name their user without a foreign key to the users
+	// exported; entries by platform staff keep the staff member's ID
+	for i := 0; i < len(ids); i += batchIDs {
+		batch := ids[i:min(i+batchIDs, len(ids))]
+		where := "entity_id IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+		err := writeRows(ctx, tx, enc, all["audit_logs"], where, batch, func(map[string]interface{}) {
+			trailer.Rows["audit_logs"]++
+		})
+		if err != nil {
+			return trailer, err
+		}
+	}
+
+	sorted := make([]string, 0, len(users))
+	for id := range users {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	list := make([]interface{}, len(sorted))
+	for i, id := range sorted {
+		list[i] = id
+	}
+	for i := 0; i < len(list); i += batchIDs {
+		batch := list[i:min(i+batchIDs, len(list))]
+		where := "user_id IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+		err := writeRows(ctx, tx, enc, all["users"], where, batch, func(map[string]interface{}) {
+			trailer.Rows["users"]++
+		})
+		if err != nil {
+			return trailer, err
+		}
+	}
+
+	if err := enc.Encode(record{Trailer: &trailer}); err != nil {
+		return trailer, err
+	}
+	return trailer, gz.Close()
+}
+
+// writeRows writes t's rows matching where, in the order they were added,
+// calling seen with each
+func writeRows(ctx context.Context, tx *sql.Tx, enc *json.Encoder, t *table, where string, args []interface{}, seen func(map[string]interface{})) error {
+	var columns, selects []string
+	for _, c := range t.columns {
+		if c == t.rowidAlias {
+			continue
+		}
+		columns = append(columns, c)
+		// A bare column would be read by its declared type, turning stored
+		// timestamps into time.Time and changing how they are written back
+		selects = append(selects, fmt.Sprintf("+%q", c))
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM %q WHERE %s ORDER BY rowid`,
+		strings.Join(selects, ", "), t.name, where), args...)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", t.name, err)
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("failed to read %s: %w", t.name, err)
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			// No column holds binary data; text stored as a BLOB is text
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[c] = values[i]
+		}
+		seen(row)
+		if err := enc.Encode(record{Table: t.name, Row: row}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// documents returns the uploaded files row refers to
+func documents(table string, row map[string]interface{}) []Document {
+	var list []Document
+	for column := range fileColumns {
+		key, _ := row[column].(string)
+		if key == "" {
+			continue
+		}
+		d := Document{Table: table, Key: key}
+		d.MimeType, _ = row["mime_type"].(string)
+		d.SHA256, _ = row["sha256"].(string)
+		list = append(list, d)
+	}
+	return list
+}
@@ -0,0 +1,113 @@
+package portable
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tujifund-app/backend/storage"
+)
+
+// filePath is where key is kept under dir
+func filePath(dir, key string) (string, error) {
+	path := filepath.Join(dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("storage key %q leaves the files directory", key)
+	}
+	return path, nil
+}
+
+// SaveFiles downloads the listed documents from store into dir, under their
+// storage keys. It returns how many were saved.
+func SaveFiles(ctx context.Context, store storage.Backend, docs []Document, dir string) (int, error) {
+	saved := map[string]bool{}
+	for _, d := range docs {
+		if saved[d.Key] {
+			continue
+		}
+		path, err := filePath(dir, d.Key)
+		if err != nil {
+			return len(saved), err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return len(saved), err
+		}
+		if err := saveFile(ctx, store, d.Key, path); err != nil {
+			return len(saved), fmt.Errorf("failed to download %s: %w", d.Key, err)
+		}
+		saved[d.Key] = true
+	}
+	return len(saved), nil
+}
+
+func saveFile(ctx context.Context, store storage.Backend, key, path string) error {
+	src, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadFiles uploads the listed documents from dir into store under their
+// storage keys, checking each against its recorded checksum. It returns how
+// many were uploaded.
+func LoadFiles(ctx context.Context, store storage.Backend, docs []Document, dir string) (int, error) {
+	loaded := map[string]bool{}
+	for _, d := range docs {
+		if loaded[d.Key] {
+			continue
+		}
+		path, err := filePath(dir, d.Key)
+		if err != nil {
+			return len(loaded), err
+		}
+		if err := loadFile(ctx, store, d, path); err != nil {
+			return len(loaded), fmt.Errorf("failed to upload %s: %w", d.Key, err)
+		}
+		loaded[d.Key] = true
+	}
+	return len(loaded), nil
+}
+
+func loadFile(ctx context.Context, store storage.Backend, d Document, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if d.SHA256 != "" {
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		if hex.EncodeToString(h.Sum(nil)) != d.SHA256 {
+			return fmt.Errorf("file does not match its checksum")
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	mimeType := d.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return store.Put(ctx, d.Key, f, info.Size(), mimeType)
+}
@@ -0,0 +1,313 @@
+package portable
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/duplicates"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/phone"
+
+	"github.com/google/uuid"
+)
+
+// Options adjust an import
+type Options struct {
+	Name string // renames the chama; it keeps its exported name if empty
+	By   string // the user running the import, for the audit log
+}
+
+// Result describes an import
+type Result struct {
+	ChamaID       string         `json:"chamaId"`
+	SourceChamaID string         `json:"sourceChamaId"`
+	Rows          map[string]int `json:"rows"`
+	UsersCreated  int            `json:"usersCreated"`
+	UsersMatched  int            `json:"usersMatched"` // existing users, found by email or phone number
+	Documents     []Document     `json:"documents"`
+}
+
+// read calls fn with each row of the export in r and returns its header
+// and trailer, checking the file is complete
+func read(r io.Reader, fn func(table string, row map[string]interface{}) error) (Header, Trailer, error) {
+	var header Header
+	var trailer *Trailer
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return header, Trailer{}, ErrNotExport
+	}
+	dec := json.NewDecoder(gz)
+	dec.UseNumber()
+
+	counts := map[string]int{}
+	for first := true; ; first = false {
+		var rec record
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return header, Trailer{}, ErrTruncated
+		}
+		if err != nil {
+			return header, Trailer{}, fmt.Errorf("%w: %v", ErrNotExport, err)
+		}
+		switch {
+		case first:
+			if rec.Header == nil || rec.Header.Format != Format {
+				return header, Trailer{}, ErrNotExport
+			}
+			if rec.Header.Version > Version {
+				return header, Trailer{}, fmt.Errorf("export is version %d; this build reads up to version %d", rec.Header.Version, Version)
+			}
+			header = *rec.Header
+		case trailer != nil:
+			return header, Trailer{}, fmt.Errorf("%w: data after the trailer", ErrNotExport)
+		case rec.Trailer != nil:
+			trailer = rec.Trailer
+		case rec.Table != "":
+			counts[rec.Table]++
+			if err := fn(rec.Table, rec.Row); err != nil {
+				return header, Trailer{}, err
+			}
+		}
+	}
+	if trailer == nil {
+		return header, Trailer{}, ErrTruncated
+	}
+	for table, n := range trailer.Rows {
+		if counts[table] != n {
+			return header, *trailer, fmt.Errorf("%w: %d of %d %s rows", ErrTruncated, counts[table], n, table)
+		}
+	}
+	return header, *trailer, nil
+}
+
+// Import loads the export in r into db as a new chama. Every row gets a new
+// ID, and every reference to an exported ID, including those inside JSON
+// and references, is changed to match. Exported users who already have an
+// account here, by email or phone number, are linked to it rather than
+// created; new users are flagged for duplicate review as member imports
+// are. The ledger's hash chain is rebuilt over the new IDs. Nothing is
+// written unless the whole import succeeds.
+func Import(ctx context.Context, db *sql.DB, r io.ReadSeeker, opts Options) (Result, error) {
+	res := Result{Rows: map[string]int{}}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	// Rows arrive in table order, not in the order their references need
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return res, err
+	}
+	defer conn.ExecContext(context.Background(), `PRAGMA foreign_keys = ON`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	all, err := tables(ctx, tx)
+	if err != nil {
+		return res, err
+	}
+
+	// First pass: check the export fits this database and give every
+	// exported ID its new one
+	ids := map[string]string{}
+	var users []map[string]interface{}
+	header, trailer, err := read(r, func(name string, row map[string]interface{}) error {
+		t := all[name]
+		if t == nil {
+			return fmt.Errorf("this database has no %s table; upgrade it before importing", name)
+		}
+		for column, v := range row {
+			if !t.has[column] && v != nil {
+				return fmt.Errorf("this database has no %s.%s column; upgrade it before importing", name, column)
+			}
+		}
+		if name == "users" {
+			users = append(users, row)
+		} else if id, ok := row["id"].(string); ok && id != "" {
+			ids[id] = uuid.NewString()
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	res.SourceChamaID, res.ChamaID, res.Documents = header.ChamaID, ids[header.ChamaID], trailer.Documents
+	if res.ChamaID == "" {
+		return res, fmt.Errorf("%w: the chama's own row is missing", ErrTruncated)
+	}
+
+	matched := map[string]bool{}
+	for _, u := range users {
+		old, _ := u["user_id"].(string)
+		existing, err := findUser(ctx, tx, u)
+		if err != nil {
+			return res, err
+		}
+		if existing != "" {
+			ids[old], matched[old] = existing, true
+			res.UsersMatched++
+			continue
+		}
+		ids[old] = time.Now().Format("20060102150405") + "_" + uuid.NewString()[:8]
+	}
+
+	// Second pass: write the rows under their new IDs
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return res, err
+	}
+	var created []string
+	_, _, err = read(r, func(name string, row map[string]interface{}) error {
+		switch name {
+		case "users":
+			old, _ := row["user_id"].(string)
+			if matched[old] {
+				return nil
+			}
+			username, err := freeUsername(ctx, tx, row["username"])
+			if err != nil {
+				return err
+			}
+			row["username"] = username
+			created = append(created, ids[old])
+		case "chamas":
+			if opts.Name != "" {
+				row["name"] = opts.Name
+			}
+		}
+		if err := insert(ctx, tx, all[name], row, ids); err != nil {
+			return err
+		}
+		res.Rows[name]++
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	res.UsersCreated = len(created)
+
+	if err := ledger.Rechain(ctx, tx, res.ChamaID); err != nil {
+		return res, fmt.Errorf("failed to rebuild the ledger's hash chain: %w", err)
+	}
+	for _, userID := range created {
+		if _, err := duplicates.Flag(ctx, tx, userID); err != nil {
+			return res, err
+		}
+	}
+	err = audit.Record(ctx, tx, audit.Entry{
+		UserID:     opts.By,
+		Action:     "chama.imported",
+		EntityType: "chama",
+		EntityID:   res.ChamaID,
+		NewValues: map[string]interface{}{
+			"sourceChamaId": header.ChamaID,
+			"exportedAt":    header.ExportedAt,
+			"sourceLedger":  header.Ledger.Head,
+			"rows":          res.Rows,
+			"usersCreated":  res.UsersCreated,
+			"usersMatched":  res.UsersMatched,
+		},
+	})
+	if err != nil {
+		return res, err
+	}
+	return res, tx.Commit()
+}
+
+// insert writes row into t with its IDs remapped
+func insert(ctx context.Context, tx *sql.Tx, t *table, row map[string]interface{}, ids map[string]string) error {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		if t.has[column] && column != t.rowidAlias {
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+
+	quoted := make([]string, len(columns))
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		quoted[i] = fmt.Sprintf("%q", column)
+		switch v := row[column].(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				values[i] = n
+			} else if f, err := v.Float64(); err == nil {
+				values[i] = f
+			} else {
+				return fmt.Errorf("bad number %s in %s.%s", v, t.name, column)
+			}
+		case string:
+			if fileColumns[column] {
+				values[i] = v
+			} else {
+				values[i] = remap(v, ids)
+			}
+		default:
+			values[i] = v
+		}
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %q (%s) VALUES (?%s)`,
+		t.name, strings.Join(quoted, ", "), strings.Repeat(", ?", len(columns)-1)), values...)
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %w", t.name, err)
+	}
+	return nil
+}
+
+// findUser returns the existing user an exported user is, by email or else
+// by phone number when only one user has it
+func findUser(ctx context.Context, tx *sql.Tx, u map[string]interface{}) (string, error) {
+	var userID string
+	if email, _ := u["email"].(string); email != "" {
+		err := tx.QueryRowContext(ctx, `SELECT user_id FROM users WHERE LOWER(email) = LOWER(?)`, email).Scan(&userID)
+		if err != sql.ErrNoRows {
+			return userID, err
+		}
+	}
+	if number, _ := u["phone_number"].(string); number != "" {
+		rows, err := tx.QueryContext(ctx, `SELECT user_id FROM users WHERE phone_number IN (?, ?) LIMIT 2`,
+			number, phone.Canonical(number))
+		if err != nil {
+			return "", err
+		}
+		defer rows.Close()
+		var found []string
+		for rows.Next() {
+			if err := rows.Scan(&userID); err != nil {
+				return "", err
+			}
+			found = append(found, userID)
+		}
+		if len(found) == 1 {
+			return found[0], rows.Err()
+		}
+	}
+	return "", nil
+}
+
+// freeUsername returns username, with a suffix if it is taken here
+func freeUsername(ctx context.Context, tx *sql.Tx, v interface{}) (string, error) {
+	username, _ := v.(string)
+	var taken bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE username = ?)`, username).Scan(&taken)
+	if err != nil || !taken {
+		return username, err
+	}
+	return username + "_" + uuid.NewString()[:6], nil
+}
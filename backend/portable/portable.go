@@ -0,0 +1,194 @@
+// Package portable moves a whole chama from one deployment to another, e.g.
+// from a self-hosted pilot to the cloud, without hand-written SQL. Export
+// writes everything the chama owns to a single file; Import loads that file
+// into another database under new IDs, so nothing in it can clash with what
+// is already there.
+//
+// An export is gzip-compressed JSON Lines:
+//
+//	{"header": {...}}                   format version, the chama and its ledger's chain head
+//	{"table": "loans", "row": {...}}    one line per row, values by column name
+//	{"trailer": {...}}                  row counts and the documents manifest
+//
+// A file without its trailer was cut short and is refused. Uploaded files
+// are not in the export: the manifest lists their storage keys, which are
+// kept as they are on import, so the files can be copied across alongside
+// (see cmd/export-chama and cmd/import-chama).
+package portable
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"tujifund-app/backend/ledger"
+)
+
+// Format and Version identify an export file. Version changes when files
+// written by an older version can no longer be read.
+const (
+	Format  = "tujifund-chama"
+	Version = 1
+)
+
+var (
+	// ErrNotFound is returned when exporting a chama that does not exist
+	ErrNotFound = errors.New("chama not found")
+	// ErrLedgerBroken is returned when exporting a chama whose ledger fails
+	// its hash chain check, which an import under new IDs would paper over
+	ErrLedgerBroken = errors.New("chama's ledger fails its hash chain check")
+	// ErrNotExport is returned for files that are not chama exports
+	ErrNotExport = errors.New("not a chama export")
+	// ErrTruncated is returned for exports missing rows or their trailer
+	ErrTruncated = errors.New("export is incomplete")
+)
+
+// skipped are tables whose rows belong to the deployment rather than the
+// chama, and are left behind
+var skipped = map[string]bool{
+	"api_keys":            true, // secrets issued by this deployment
+	"tenant_databases":    true,
+	"event_outbox":        true, // published, or to be, from here
+	"sync_mutations":      true, // devices sync afresh against the new deployment
+	"usage_events":        true, // billing is per deployment
+	"billing_suspensions": true,
+	"platform_invoices":   true,
+	"chama_plans":         true, // plans and prices are per deployment
+	"quota_overrides":     true,
+	"umbrella_chamas":     true, // umbrellas stay where they are
+}
+
+// fileColumns hold storage keys of uploaded files. They are listed in the
+// documents manifest and never remapped.
+var fileColumns = map[string]bool{
+	"storage_key":       true,
+	"payment_proof_url": true,
+}
+
+// Header opens an export
+type Header struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ChamaID    string    `json:"chamaId"`
+	ChamaName  string    `json:"chamaName"`
+	ExportedAt time.Time `json:"exportedAt"`
+	// Ledger is the chain as exported. Imported entries are chained afresh
+	// under their new IDs, so its head only identifies the source.
+	Ledger ledger.Verification `json:"ledger"`
+}
+
+// Document is one uploaded file referenced by the export
+type Document struct {
+	Table    string `json:"table"`
+	Key      string `json:"key"`
+	MimeType string `json:"mimeType,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+}
+
+// Trailer closes an export
+type Trailer struct {
+	Rows      map[string]int `json:"rows"`
+	Documents []Document     `json:"documents"`
+}
+
+// record is one line of an export
+type record struct {
+	Header  *Header                `json:"header,omitempty"`
+	Table   string                 `json:"table,omitempty"`
+	Row     map[string]interface{} `json:"row,omitempty"`
+	Trailer *Trailer               `json:"trailer,omitempty"`
+}
+
+// table is a table's columns as the database has them
+type table struct {
+	name       string
+	columns    []string
+	rowidAlias string // an INTEGER PRIMARY KEY column, which means nothing in another database
+	has        map[string]bool
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// tables returns db's ordinary tables by name
+func tables(ctx context.Context, db querier) (map[string]*table, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.name, c.name, UPPER(c.type), c.pk FROM sqlite_master m, pragma_table_info(m.name) c
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND m.sql NOT LIKE 'CREATE VIRTUAL TABLE%'
+		ORDER BY m.name, c.cid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := map[string]*table{}
+	keys := map[string]int{}
+	for rows.Next() {
+		var name, column, typ string
+		var pk int
+		if err := rows.Scan(&name, &column, &typ, &pk); err != nil {
+			return nil, err
+		}
+		t := list[name]
+		if t == nil {
+			t = &table{name: name, has: map[string]bool{}}
+			list[name] = t
+		}
+		t.columns = append(t.columns, column)
+		t.has[column] = true
+		if pk > 0 {
+			keys[name]++
+			if typ == "INTEGER" {
+				t.rowidAlias = column
+			}
+		}
+	}
+	for name, n := range keys {
+		if n > 1 {
+			list[name].rowidAlias = ""
+		}
+	}
+	return list, rows.Err()
+}
+
+type foreignKey struct {
+	from, parent, to string
+}
+
+func foreignKeys(ctx context.Context, db querier, table string) ([]foreignKey, error) {
+	rows, err := db.QueryContext(ctx, `SELECT "from", "table", COALESCE("to", 'id') FROM pragma_foreign_key_list(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var fks []foreignKey
+	for rows.Next() {
+		var fk foreignKey
+		if err := rows.Scan(&fk.from, &fk.parent, &fk.to); err != nil {
+			return nil, err
+		}
+		fks = append(fks, fk)
+	}
+	return fks, rows.Err()
+}
+
+// idToken matches anything that could be a whole ID: UUIDs and the
+// timestamp_random user IDs alike
+var idToken = regexp.MustCompile(`[A-Za-z0-9_-]{8,}`)
+
+// remap replaces every ID in s found in ids, whether s is an ID itself or
+// has IDs inside it, as JSON payloads and references do
+func remap(s string, ids map[string]string) string {
+	if id, ok := ids[s]; ok {
+		return id
+	}
+	return idToken.ReplaceAllStringFunc(s, func(token string) string {
+		if id, ok := ids[token]; ok {
+			return id
+		}
+		return token
+	})
+}
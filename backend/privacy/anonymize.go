@@ -0,0 +1,292 @@
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Fake names are drawn from common Kenyan names, so anonymised data still
+// looks like the data it came from
+var (
+	fakeFirstNames = []string{
+		"Achieng", "Akinyi", "Amani", "Baraka", "Chebet", "Faith", "Grace", "Hassan", "Imani", "Jabari",
+		"Jepchirchir", "Kamau", "Kariuki", "Kemunto", "Kibet", "Mercy", "Moraa", "Mwangi", "Nafula", "Nekesa",
+		"Njeri", "Njoroge", "Odhiambo", "Omondi", "Otieno", "Wafula", "Wairimu", "Wambui", "Wanjiku", "Zawadi",
+	}
+	fakeLastNames = []string{
+		"Atieno", "Barasa", "Cheruiyot", "Gathoni", "Kipchoge", "Kiprotich", "Kimani", "Koech", "Macharia", "Mutua",
+		"Mwende", "Ndungu", "Njuguna", "Nyambura", "Ochieng", "Odera", "Ogola", "Oloo", "Onyango", "Wekesa",
+	}
+)
+
+// referenceColumns hold payment provider references, e.g. M-Pesa receipt
+// numbers, which identify a transaction at the provider. The same value is
+// replaced by the same fake everywhere so statement matching still works.
+var referenceColumns = []struct{ table, column string }{
+	{"contributions", "transaction_reference"},
+	{"contributions", "provider_reference"},
+	{"loan_repayments", "transaction_reference"},
+	{"receipts", "transaction_reference"},
+	{"disbursements", "provider_reference"},
+	{"disbursements", "receipt"},
+	{"statement_lines", "reference"},
+}
+
+// documentColumns point at uploaded files, which are not copied
+var documentColumns = []struct{ table, column string }{
+	{"kyc_documents", "storage_key"},
+	{"receipts", "storage_key"},
+	{"meeting_minutes", "storage_key"},
+	{"expenses", "storage_key"},
+	{"reports", "storage_key"},
+	{"contributions", "payment_proof_url"},
+}
+
+// textColumns are free text that may mention members by name, phone or email
+var textColumns = []struct{ table, column string }{
+	{"audit_logs", "old_values"},
+	{"audit_logs", "new_values"},
+	{"notifications", "message"},
+	{"ledger_entries", "description"},
+	{"ledger_entries_archive", "description"},
+	{"contributions", "notes"},
+	{"statement_lines", "description"},
+	{"expenses", "payee"},
+	{"expenses", "description"},
+}
+
+// AnonymizeSummary counts what Anonymize rewrote
+type AnonymizeSummary struct {
+	Users      int
+	Phones     int
+	References int
+	Documents  int
+}
+
+// anonymizer remembers each real value's fake, so a member's phone number
+// becomes the same fake on their profile, payouts and statement lines
+type anonymizer struct {
+	rng        *rand.Rand
+	phones     map[string]string
+	references map[string]string
+	used       map[string]bool // fakes handed out
+	replace    []string        // old, new pairs for free text
+}
+
+// Anonymize rewrites everyone in db with realistic fakes, for debugging
+// against production-shaped data. It must only ever be run on a copy.
+// Names, emails, phone numbers and payment references are replaced
+// consistently across tables and in free text, document references point
+// nowhere, secrets and sessions are deleted, and every account's password is
+// set to password. The same seed gives the same fakes.
+func Anonymize(ctx context.Context, db *sql.DB, seed int64, password string) (AnonymizeSummary, error) {
+	var sum AnonymizeSummary
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return sum, err
+	}
+	a := &anonymizer{rng: rand.New(rand.NewSource(seed)), phones: map[string]string{}, references: map[string]string{},
+		used: map[string]bool{}}
+
+	type user struct{ id, first, last, email, phone string }
+	var users []user
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_id, COALESCE(first_name, ''), COALESCE(last_name, ''), email, COALESCE(phone_number, '')
+		FROM users ORDER BY id`)
+	if err != nil {
+		return sum, err
+	}
+	for rows.Next() {
+		var u user
+		if err := rows.Scan(&u.id, &u.first, &u.last, &u.email, &u.phone); err != nil {
+			rows.Close()
+			return sum, err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return sum, err
+	}
+	defer tx.Rollback()
+
+	for n, u := range users {
+		first := fakeFirstNames[a.rng.Intn(len(fakeFirstNames))]
+		last := fakeLastNames[a.rng.Intn(len(fakeLastNames))]
+		handle := fmt.Sprintf("%s.%s%d", strings.ToLower(first), strings.ToLower(last), n+1)
+		phone := ""
+		if u.phone != "" {
+			phone = a.phone(u.phone)
+		}
+		_, err := tx.ExecContext(ctx, `
+			UPDATE users SET username = ?, email = ?, first_name = ?, last_name = ?, phone_number = NULLIF(?, ''),
+			       password_hash = ?, profile_image_url = NULL, bio = NULL, date_of_birth = NULL, metadata = NULL
+			WHERE user_id = ?`,
+			handle, handle+"@example.com", first, last, phone, string(hash), u.id)
+		if err != nil {
+			return sum, err
+		}
+		if name := strings.TrimSpace(u.first + " " + u.last); name != "" {
+			a.replace = append(a.replace, name, first+" "+last)
+		}
+		a.replace = append(a.replace, u.email, handle+"@example.com")
+	}
+	sum.Users = len(users)
+
+	for _, q := range []string{
+		`DELETE FROM sessions`, `DELETE FROM two_factor_sessions`, `DELETE FROM two_factor_recovery_codes`,
+		`DELETE FROM user_two_factor`, `DELETE FROM user_verification`, `DELETE FROM otp_codes`, `DELETE FROM ussd_sessions`,
+		`UPDATE audit_logs SET ip_address = NULL, user_agent = NULL`,
+		`UPDATE statement_lines SET payer_name = NULL, account_reference = NULL`,
+		`UPDATE statement_imports SET filename = 'statement-' || id || '.csv'`,
+		`UPDATE join_requests SET message = NULL`,
+	} {
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			return sum, err
+		}
+	}
+
+	for _, c := range []struct{ table, column string }{
+		{"join_requests", "phone"}, {"disbursements", "phone_number"}, {"statement_lines", "phone_number"},
+	} {
+		if err := a.rewrite(ctx, tx, c.table, c.column, a.phone); err != nil {
+			return sum, err
+		}
+	}
+	for _, c := range referenceColumns {
+		if err := a.rewrite(ctx, tx, c.table, c.column, a.reference); err != nil {
+			return sum, err
+		}
+	}
+	for _, c := range documentColumns {
+		res, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %[1]s SET %[2]s = 'anonymized/%[1]s/' || id WHERE %[2]s IS NOT NULL AND %[2]s != ''`,
+			c.table, c.column))
+		if err != nil {
+			return sum, err
+		}
+		n, _ := res.RowsAffected()
+		sum.Documents += int(n)
+	}
+
+	for real, fake := range a.phones {
+		a.replace = append(a.replace, real, fake)
+		if strings.HasPrefix(real, "254") {
+			a.replace = append(a.replace, "0"+real[3:], "0"+fake[3:])
+		}
+	}
+	replacer := strings.NewReplacer(longestFirst(a.replace)...)
+	for _, c := range textColumns {
+		if err := a.rewrite(ctx, tx, c.table, c.column, replacer.Replace); err != nil {
+			return sum, err
+		}
+	}
+	// Rebuild the search indexes so no real name or phone number is left in them
+	for _, index := range []string{"search_users", "search_ledger"} {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %[1]s (%[1]s) VALUES ('rebuild')`, index)); err != nil {
+			return sum, err
+		}
+	}
+	sum.Phones, sum.References = len(a.phones), len(a.references)
+	return sum, tx.Commit()
+}
+
+// rewrite replaces every non-empty value in table.column with fake(value).
+// Rows are updated by rowid, so a fake that happens to equal another real
+// value is not rewritten again.
+func (a *anonymizer) rewrite(ctx context.Context, tx *sql.Tx, table, column string, fake func(string) string) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT rowid, %s FROM %s WHERE %s IS NOT NULL AND %s != ''`,
+		column, table, column, column))
+	if err != nil {
+		return err
+	}
+	type value struct {
+		rowid int64
+		v     string
+	}
+	var values []value
+	for rows.Next() {
+		var v value
+		if err := rows.Scan(&v.rowid, &v.v); err != nil {
+			rows.Close()
+			return err
+		}
+		values = append(values, v)
+	}
+	rows.Close()
+
+	for _, v := range values {
+		if f := fake(v.v); f != v.v {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET %s = ? WHERE rowid = ?`, table, column), f, v.rowid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// phone returns the fake for a real phone number: a Safaricom-style 2547...
+// number, unique across the database. Masked numbers from statements become
+// a masked fake.
+func (a *anonymizer) phone(real string) string {
+	if f, ok := a.phones[real]; ok {
+		return f
+	}
+	for {
+		f := fmt.Sprintf("2547%08d", a.rng.Intn(100000000))
+		if !a.used[f] {
+			a.used[f] = true
+			if strings.Contains(real, "*") {
+				f = Mask(f)
+			}
+			a.phones[real] = f
+			return f
+		}
+	}
+}
+
+// reference returns the fake for a payment reference, in the ten character
+// form of an M-Pesa receipt number
+func (a *anonymizer) reference(real string) string {
+	if f, ok := a.references[real]; ok {
+		return f
+	}
+	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	for {
+		b := make([]byte, 10)
+		for i := range b {
+			b[i] = chars[a.rng.Intn(len(chars))]
+		}
+		if f := string(b); !a.used[f] {
+			a.used[f] = true
+			a.references[real] = f
+			return f
+		}
+	}
+}
+
+// longestFirst sorts old, new pairs by the length of old, longest first, so a
+// full name is replaced before a shorter value found inside it. Empty olds
+// are dropped.
+func longestFirst(pairs []string) []string {
+	type pair struct{ old, new string }
+	var list []pair
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] != "" {
+			list = append(list, pair{pairs[i], pairs[i+1]})
+		}
+	}
+	sort.SliceStable(list, func(i, k int) bool { return len(list[i].old) > len(list[k].old) })
+	out := make([]string, 0, 2*len(list))
+	for _, p := range list {
+		out = append(out, p.old, p.new)
+	}
+	return out
+}
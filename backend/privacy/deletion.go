@@ -0,0 +1,166 @@
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/account"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/storage"
+)
+
+// GracePeriod is how long a member who deletes their account has to change
+// their mind. Until then the account is only deactivated: they cannot sign
+// in, but can recover it with their password. After it the account is
+// erased.
+const GracePeriod = 30 * 24 * time.Hour
+
+var (
+	// ErrPendingDeletion is returned when deleting an account already
+	// waiting to be erased
+	ErrPendingDeletion = errors.New("account is already scheduled for deletion")
+	// ErrNoPendingDeletion is returned when recovering an account that is not
+	// waiting to be erased
+	ErrNoPendingDeletion = errors.New("account is not scheduled for deletion")
+)
+
+// Deletion is a member's request to delete their account
+type Deletion struct {
+	UserID      string    `json:"userId"`
+	Reason      string    `json:"reason,omitempty"`
+	RequestedAt time.Time `json:"requestedAt"`
+	EraseAfter  time.Time `json:"eraseAfter"`
+}
+
+// RequestDeletion deactivates userID and schedules their erasure after
+// GracePeriod. It is refused, as erasure is, while the member still owes or
+// guarantees a loan or belongs to a chama. They are signed out everywhere.
+func RequestDeletion(ctx context.Context, db *sql.DB, userID, reason string) (Deletion, error) {
+	now := time.Now().UTC()
+	d := Deletion{UserID: userID, Reason: reason, RequestedAt: now, EraseAfter: now.Add(GracePeriod)}
+	var erasedAt sql.NullTime
+	err := db.QueryRowContext(ctx, `SELECT erased_at FROM users WHERE user_id = ?`, userID).Scan(&erasedAt)
+	if err != nil {
+		return d, err
+	}
+	if erasedAt.Valid {
+		return d, ErrAlreadyErased
+	}
+	if _, pending, err := PendingDeletion(ctx, db, userID); err != nil || pending {
+		if err == nil {
+			err = ErrPendingDeletion
+		}
+		return d, err
+	}
+	reasons, err := Blockers(ctx, db, userID)
+	if err != nil {
+		return d, err
+	}
+	if len(reasons) > 0 {
+		return d, &BlockedError{Reasons: reasons}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return d, err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO account_deletions (user_id, reason, requested_at, erase_after) VALUES (?, ?, ?, ?)`,
+		userID, nullIfEmpty(reason), d.RequestedAt, d.EraseAfter)
+	if err != nil {
+		return d, err
+	}
+	if err := account.EndSessions(ctx, tx, userID); err != nil {
+		return d, err
+	}
+	return d, tx.Commit()
+}
+
+// PendingDeletion returns when userID's account is due to be erased, if
+// they have deleted it and not yet recovered it
+func PendingDeletion(ctx context.Context, db *sql.DB, userID string) (time.Time, bool, error) {
+	var eraseAfter time.Time
+	err := db.QueryRowContext(ctx, `SELECT erase_after FROM account_deletions WHERE user_id = ?`, userID).Scan(&eraseAfter)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	return eraseAfter, err == nil, err
+}
+
+// Recover reactivates userID's account within the grace period
+func Recover(ctx context.Context, db *sql.DB, userID string) (Deletion, error) {
+	d := Deletion{UserID: userID}
+	var reason sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT reason, requested_at, erase_after FROM account_deletions WHERE user_id = ?`, userID).
+		Scan(&reason, &d.RequestedAt, &d.EraseAfter)
+	if err == sql.ErrNoRows {
+		return d, ErrNoPendingDeletion
+	}
+	if err != nil {
+		return d, err
+	}
+	d.Reason = reason.String
+	_, err = db.ExecContext(ctx, `DELETE FROM account_deletions WHERE user_id = ?`, userID)
+	return d, err
+}
+
+// EraseDue erases the accounts whose grace period has ended, returning how
+// many were erased. A member who has since taken on an obligation, e.g. a
+// guarantee accepted on their behalf, is left deactivated and tried again on
+// the next run.
+func EraseDue(ctx context.Context, db *sql.DB, store storage.Backend, now time.Time) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_id, COALESCE(reason, '') FROM account_deletions WHERE erase_after <= ? ORDER BY erase_after`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	var due []Deletion
+	for rows.Next() {
+		var d Deletion
+		if err := rows.Scan(&d.UserID, &d.Reason); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	erased := 0
+	for _, d := range due {
+		_, err := Erase(ctx, db, store, d.UserID, d.UserID, d.Reason)
+		var blocked *BlockedError
+		switch {
+		case errors.As(err, &blocked):
+			slog.WarnContext(ctx, "Deleted account cannot be erased yet", "user_id", d.UserID, "blockers", blocked.Reasons)
+		case errors.Is(err, ErrAlreadyErased):
+			_, err = db.ExecContext(ctx, `DELETE FROM account_deletions WHERE user_id = ?`, d.UserID)
+			if err != nil {
+				return erased, err
+			}
+		case err != nil:
+			return erased, err
+		default:
+			erased++
+		}
+	}
+	return erased, nil
+}
+
+// RegisterDeletionJob erases accounts once their grace period has ended
+func RegisterDeletionJob(s *jobs.Scheduler, db *sql.DB, store storage.Backend) {
+	s.Register("account_deletions", jobs.Every(time.Hour), func(ctx context.Context) error {
+		n, err := EraseDue(ctx, db, store, time.Now())
+		if n > 0 {
+			slog.InfoContext(ctx, "Erased deleted accounts", "count", n)
+		}
+		return err
+	})
+}
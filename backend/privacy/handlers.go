@@ -0,0 +1,266 @@
+package privacy
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/export/pdf"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/storage"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ExportHandler downloads everything held about the signed-in member as
+// ?format=json (the default) or pdf
+func ExportHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "pdf" {
+			http.Error(w, "format must be json or pdf", http.StatusBadRequest)
+			return
+		}
+		rep, err := Export(r.Context(), db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "privacy.export", EntityType: "user", EntityID: userID,
+			NewValues: map[string]string{"format": format},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit data export", "user_id", userID, "error", err)
+		}
+
+		filename := "tujifund-data-" + rep.GeneratedAt.Format("20060102")
+		if format == "pdf" {
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, filename))
+			writePDF(w, rep)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+	}
+}
+
+// writePDF lists each section's rows as "column: value" lines
+func writePDF(w io.Writer, rep Report) error {
+	doc := pdf.New("Personal data")
+	doc.Heading("Personal data held by Tujifund")
+	doc.Line("User: " + rep.UserID)
+	doc.Line("Generated: " + rep.GeneratedAt.Format("2006-01-02 15:04 MST"))
+	for _, s := range rep.Sections {
+		doc.Space()
+		doc.Heading(fmt.Sprintf("%s (%d)", s.Name, len(s.Rows)))
+		for i, row := range s.Rows {
+			if i > 0 {
+				doc.Space()
+			}
+			columns := make([]string, 0, len(row))
+			for c := range row {
+				columns = append(columns, c)
+			}
+			sort.Strings(columns)
+			for _, c := range columns {
+				if row[c] == nil {
+					continue
+				}
+				doc.Line(fmt.Sprintf("%s: %v", c, row[c]))
+			}
+		}
+	}
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// ErasureHandler reports whether the signed-in member can delete their
+// account now, and if not what they must settle first
+func ErasureHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		reasons, err := Blockers(r.Context(), db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"eligible":        len(reasons) == 0,
+			"blockers":        reasons,
+			"gracePeriodDays": int(GracePeriod.Hours() / 24),
+		})
+	}
+}
+
+// EraseHandler deletes the signed-in member's account, with an optional
+// {"reason"}: it is deactivated at once, ending their session, and erased
+// after GracePeriod unless they recover it
+func EraseHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		d, err := RequestDeletion(r.Context(), db, userID, request.Reason)
+		if !writeBlocked(w, err) || !writeError(w, err) {
+			return
+		}
+		err = audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "privacy.deletion_requested", EntityType: "user", EntityID: userID,
+			NewValues: map[string]interface{}{"reason": d.Reason, "eraseAfter": d.EraseAfter},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit account deletion", "user_id", userID, "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// RecoverHandler reactivates a deleted account within its grace period. The
+// member is signed out, so they prove who they are with the {"email",
+// "password"} they sign in with, then sign in as usual.
+func RecoverHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		var userID string
+		var hash sql.NullString
+		err := db.QueryRowContext(r.Context(), `SELECT user_id, password_hash FROM users WHERE email = ?`, request.Email).
+			Scan(&userID, &hash)
+		if err == nil && bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(request.Password)) != nil {
+			err = sql.ErrNoRows
+		}
+		if err == sql.ErrNoRows {
+			http.Error(w, i18n.T(i18n.FromRequest(r), "auth.invalid_credentials", nil), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d, err := Recover(r.Context(), db, userID)
+		if !writeError(w, err) {
+			return
+		}
+		err = audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "privacy.deletion_recovered", EntityType: "user", EntityID: userID,
+			OldValues: map[string]interface{}{"requestedAt": d.RequestedAt, "eraseAfter": d.EraseAfter},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit account recovery", "user_id", userID, "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"userId":  userID,
+			"message": i18n.T(i18n.FromRequest(r), "auth.account_recovered", nil),
+		})
+	}
+}
+
+// AdminEraseHandler erases the {userId} account on the member's behalf, e.g.
+// for a request made by letter. A {"reason"} is required.
+func AdminEraseHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staffID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+			http.Error(w, "A reason is required to erase an account", http.StatusBadRequest)
+			return
+		}
+		erase(w, r, db, store, mux.Vars(r)["userId"], staffID, request.Reason)
+	}
+}
+
+func erase(w http.ResponseWriter, r *http.Request, db *sql.DB, store storage.Backend, userID, by, reason string) {
+	erasedAt, err := Erase(r.Context(), db, store, userID, by, reason)
+	if !writeBlocked(w, err) || !writeError(w, err) {
+		return
+	}
+	err = audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+		UserID: by, Action: "privacy.erase", EntityType: "user", EntityID: userID,
+		NewValues: map[string]interface{}{"reason": reason, "erasedAt": erasedAt},
+	}))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to audit erasure", "user_id", userID, "error", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"userId": userID, "erasedAt": erasedAt.Format(time.RFC3339)})
+}
+
+// writeBlocked writes a *BlockedError as 409 with what the member must
+// settle, and reports whether err was some other error or nil
+func writeBlocked(w http.ResponseWriter, err error) bool {
+	var blocked *BlockedError
+	if !errors.As(err, &blocked) {
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": ErrBlocked.Error(), "blockers": blocked.Reasons})
+	return false
+}
+
+// writeError writes err as a response and reports whether the handler may continue
+func writeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, ErrAlreadyErased), errors.Is(err, ErrPendingDeletion), errors.Is(err, ErrNoPendingDeletion):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
@@ -0,0 +1,310 @@
+// Package privacy lets members exercise their data protection rights under
+// the GDPR and Kenya's Data Protection Act: a copy of everything held about
+// them, and erasure. Erasure anonymises the member rather than deleting rows,
+// so chama ledgers, receipts and loan books still add up. Members deleting
+// their own account are erased only after a grace period, in which they can
+// recover it.
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/loans"
+	"tujifund-app/backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// The name an erased member is shown under
+const (
+	ErasedFirstName = "Erased"
+	ErasedLastName  = "Member"
+)
+
+var (
+	// ErrBlocked is returned when a member still has obligations to a chama
+	ErrBlocked = errors.New("account cannot be erased yet")
+	// ErrAlreadyErased is returned when erasing an erased account
+	ErrAlreadyErased = errors.New("account has already been erased")
+)
+
+// BlockedError lists what a member must settle before they can be erased
+type BlockedError struct {
+	Reasons []string
+}
+
+func (e *BlockedError) Error() string {
+	return ErrBlocked.Error() + ": " + strings.Join(e.Reasons, "; ")
+}
+
+func (e *BlockedError) Unwrap() error { return ErrBlocked }
+
+// Section is one kind of personal data, e.g. the member's loans
+type Section struct {
+	Name string                   `json:"name"`
+	Rows []map[string]interface{} `json:"rows"`
+}
+
+// Report is everything held about a member
+type Report struct {
+	UserID      string    `json:"userId"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Sections    []Section `json:"sections"`
+}
+
+// sources are the tables holding a member's data and the column naming them
+var sources = []struct{ name, table, column string }{
+	{"profile", "users", "user_id"},
+	{"kyc", "kyc_verifications", "user_id"},
+	{"kyc_documents", "kyc_documents", "user_id"},
+	{"kyc_history", "kyc_status_history", "user_id"},
+	{"memberships", "chama_members", "user_id"},
+	{"join_requests", "join_requests", "user_id"},
+	{"contributions", "contributions", "member_id"},
+	{"ledger_entries", "ledger_history", "member_id"},
+	{"loan_applications", "loan_applications", "user_id"},
+	{"loans", "loans", "borrower_id"},
+	{"loan_guarantees", "loan_guarantors", "guarantor_id"},
+	{"loan_guarantee_requests", "loan_application_guarantors", "guarantor_id"},
+	{"loan_collateral", "loan_collateral", "owner_id"},
+	{"fines", "fines", "member_id"},
+	{"arrears", "arrears", "member_id"},
+	{"shares", "member_shares", "member_id"},
+	{"share_transactions", "share_transactions", "member_id"},
+	{"disbursements", "disbursements", "member_id"},
+	{"exits", "member_exits", "member_id"},
+	{"meeting_attendance", "meeting_attendance", "member_id"},
+	{"ballots", "ballots", "voter_id"},
+	{"goals", "goals", "member_id"},
+	{"receipts", "receipts", "user_id"},
+	{"referral_code", "referral_codes", "user_id"},
+	{"referred_by", "referrals", "referred_id"},
+	{"referral_rewards", "referral_rewards", "referrer_id"},
+	{"wallets", "wallets", "user_id"},
+	{"wallet_transactions", "wallet_transactions", "user_id"},
+	{"standing_orders", "standing_orders", "user_id"},
+	{"umbrella_admin", "umbrella_admins", "user_id"},
+	{"notifications", "notifications", "user_id"},
+	{"sessions", "sessions", "user_id"},
+	{"two_factor", "user_two_factor", "user_id"},
+	{"activity", "audit_logs", "user_id"},
+}
+
+// secretColumns are left out of exports: credentials, and storage keys that
+// mean nothing outside the app
+var secretColumns = map[string]bool{
+	"password_hash": true, "token": true, "verification_token": true, "totp_secret": true, "code_hash": true,
+	"storage_key": true, "payment_proof_url": true,
+}
+
+// Export collects every row naming userID, leaving out secrets
+func Export(ctx context.Context, db *sql.DB, userID string) (Report, error) {
+	rep := Report{UserID: userID, GeneratedAt: time.Now().UTC()}
+	for _, s := range sources {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s WHERE %s = ?`, s.table, s.column), userID)
+		if err != nil {
+			return rep, fmt.Errorf("failed to export %s: %w", s.name, err)
+		}
+		section, err := readRows(rows)
+		rows.Close()
+		if err != nil {
+			return rep, fmt.Errorf("failed to export %s: %w", s.name, err)
+		}
+		section.Name = s.name
+		rep.Sections = append(rep.Sections, section)
+	}
+	return rep, nil
+}
+
+func readRows(rows *sql.Rows) (Section, error) {
+	s := Section{Rows: []map[string]interface{}{}}
+	columns, err := rows.Columns()
+	if err != nil {
+		return s, err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return s, err
+		}
+		row := map[string]interface{}{}
+		for i, c := range columns {
+			if secretColumns[c] {
+				continue
+			}
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[c] = values[i]
+		}
+		s.Rows = append(s.Rows, row)
+	}
+	return s, rows.Err()
+}
+
+// Blockers lists what stops userID being erased: chamas they still belong
+// to, which hold their savings, and loans they owe or guarantee
+func Blockers(ctx context.Context, db *sql.DB, userID string) ([]string, error) {
+	reasons := []string{}
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.name FROM chama_members m JOIN chamas c ON c.id = m.chama_id
+		WHERE m.user_id = ? AND m.status != 'inactive' ORDER BY c.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reasons = append(reasons, fmt.Sprintf("still a member of %s; exit the chama first", name))
+	}
+	rows.Close()
+
+	var owed, guaranteed int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM loans WHERE borrower_id = ? AND status IN (?, ?, ?)`,
+		userID, loans.StatusPending, loans.StatusActive, loans.StatusDefaulted).Scan(&owed)
+	if err != nil {
+		return nil, err
+	}
+	if owed > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d loan(s) not yet repaid", owed))
+	}
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM loan_guarantors g JOIN loans l ON l.id = g.loan_id
+		WHERE g.guarantor_id = ? AND g.status != 'rejected' AND l.status IN (?, ?, ?)`,
+		userID, loans.StatusPending, loans.StatusActive, loans.StatusDefaulted).Scan(&guaranteed)
+	if err != nil {
+		return nil, err
+	}
+	if guaranteed > 0 {
+		reasons = append(reasons, fmt.Sprintf("guarantor of %d loan(s) not yet repaid", guaranteed))
+	}
+	return reasons, nil
+}
+
+// Erase anonymises userID. Their name, contact details, identity documents,
+// sessions and notifications are removed; financial records keep their user
+// ID, now pointing at an anonymous "Erased Member", so every balance still
+// adds up. Phone numbers on payout and statement records are masked rather
+// than removed, as those records must be kept. KYC files are deleted from
+// store once the erasure is committed.
+func Erase(ctx context.Context, db *sql.DB, store storage.Backend, userID, requestedBy, reason string) (time.Time, error) {
+	var email, phone string
+	var erasedAt sql.NullTime
+	err := db.QueryRowContext(ctx, `SELECT email, COALESCE(phone_number, ''), erased_at FROM users WHERE user_id = ?`,
+		userID).Scan(&email, &phone, &erasedAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if erasedAt.Valid {
+		return erasedAt.Time, ErrAlreadyErased
+	}
+	reasons, err := Blockers(ctx, db, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(reasons) > 0 {
+		return time.Time{}, &BlockedError{Reasons: reasons}
+	}
+
+	var files []string
+	rows, err := db.QueryContext(ctx, `SELECT storage_key FROM kyc_documents WHERE user_id = ?`, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return time.Time{}, err
+		}
+		files = append(files, key)
+	}
+	rows.Close()
+
+	now := time.Now().UTC()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return now, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET username = ?, email = ?, password_hash = NULL, first_name = ?, last_name = ?,
+		       phone_number = NULL, profile_image_url = NULL, country = NULL, bio = NULL, date_of_birth = NULL,
+		       auth_provider = 'none', is_verified = 0, metadata = NULL, erased_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?`,
+		"erased-"+userID, "erased-"+userID+"@erased.invalid", ErasedFirstName, ErasedLastName, now, userID)
+	if err != nil {
+		return now, err
+	}
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`DELETE FROM sessions WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM two_factor_sessions WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM two_factor_recovery_codes WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM user_two_factor WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM user_verification WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM kyc_documents WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM kyc_status_history WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM kyc_verifications WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM notifications WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM account_deletions WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM ussd_sessions WHERE user_id = ? OR phone_number = ?`, []interface{}{userID, phone}},
+		{`DELETE FROM otp_codes WHERE destination IN (?, ?)`, []interface{}{email, phone}},
+		{`UPDATE join_requests SET phone = '', message = NULL WHERE user_id = ?`, []interface{}{userID}},
+		{`UPDATE audit_logs SET ip_address = NULL, user_agent = NULL WHERE user_id = ?`, []interface{}{userID}},
+		{`UPDATE disbursements SET phone_number = ? WHERE member_id = ?`, []interface{}{Mask(phone), userID}},
+		{`UPDATE statement_lines SET phone_number = ?, payer_name = NULL WHERE member_id = ?`, []interface{}{Mask(phone), userID}},
+	}
+	for _, s := range statements {
+		if _, err := tx.ExecContext(ctx, s.query, s.args...); err != nil {
+			return now, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO data_erasures (id, user_id, requested_by, reason, erased_at) VALUES (?, ?, ?, ?, ?)`,
+		uuid.NewString(), userID, requestedBy, nullIfEmpty(reason), now)
+	if err != nil {
+		return now, err
+	}
+	if err := tx.Commit(); err != nil {
+		return now, err
+	}
+
+	for _, key := range files {
+		if err := store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to delete KYC document of erased user", "user_id", userID, "key", key, "error", err)
+		}
+	}
+	return now, nil
+}
+
+// Mask hides all but the first four and last two digits of a phone number
+func Mask(phone string) string {
+	if len(phone) <= 6 {
+		return strings.Repeat("*", len(phone))
+	}
+	return phone[:4] + strings.Repeat("*", len(phone)-6) + phone[len(phone)-2:]
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,209 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: chamas/v1/chamas.proto
+
+package chamasv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ChamaService_GetChama_FullMethodName      = "/tujifund.chamas.v1.ChamaService/GetChama"
+	ChamaService_ListMembers_FullMethodName   = "/tujifund.chamas.v1.ChamaService/ListMembers"
+	ChamaService_GetMembership_FullMethodName = "/tujifund.chamas.v1.ChamaService/GetMembership"
+)
+
+// ChamaServiceClient is the client API for ChamaService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ChamaService reads chamas and who belongs to them
+type ChamaServiceClient interface {
+	// GetChama returns a chama by ID, or NOT_FOUND
+	GetChama(ctx context.Context, in *GetChamaRequest, opts ...grpc.CallOption) (*Chama, error)
+	// ListMembers lists a chama's members, optionally by status and role
+	ListMembers(ctx context.Context, in *ListMembersRequest, opts ...grpc.CallOption) (*ListMembersResponse, error)
+	// GetMembership returns a user's role in a chama, or NOT_FOUND when they
+	// are not an active member
+	GetMembership(ctx context.Context, in *GetMembershipRequest, opts ...grpc.CallOption) (*Membership, error)
+}
+
+type chamaServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChamaServiceClient(cc grpc.ClientConnInterface) ChamaServiceClient {
+	return &chamaServiceClient{cc}
+}
+
+func (c *chamaServiceClient) GetChama(ctx context.Context, in *GetChamaRequest, opts ...grpc.CallOption) (*Chama, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Chama)
+	err := c.cc.Invoke(ctx, ChamaService_GetChama_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chamaServiceClient) ListMembers(ctx context.Context, in *ListMembersRequest, opts ...grpc.CallOption) (*ListMembersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMembersResponse)
+	err := c.cc.Invoke(ctx, ChamaService_ListMembers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chamaServiceClient) GetMembership(ctx context.Context, in *GetMembershipRequest, opts ...grpc.CallOption) (*Membership, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Membership)
+	err := c.cc.Invoke(ctx, ChamaService_GetMembership_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChamaServiceServer is the server API for ChamaService service.
+// All implementations must embed UnimplementedChamaServiceServer
+// for forward compatibility.
+//
+// ChamaService reads chamas and who belongs to them
+type ChamaServiceServer interface {
+	// GetChama returns a chama by ID, or NOT_FOUND
+	GetChama(context.Context, *GetChamaRequest) (*Chama, error)
+	// ListMembers lists a chama's members, optionally by status and role
+	ListMembers(context.Context, *ListMembersRequest) (*ListMembersResponse, error)
+	// GetMembership returns a user's role in a chama, or NOT_FOUND when they
+	// are not an active member
+	GetMembership(context.Context, *GetMembershipRequest) (*Membership, error)
+	mustEmbedUnimplementedChamaServiceServer()
+}
+
+// UnimplementedChamaServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedChamaServiceServer struct{}
+
+func (UnimplementedChamaServiceServer) GetChama(context.Context, *GetChamaRequest) (*Chama, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChama not implemented")
+}
+func (UnimplementedChamaServiceServer) ListMembers(context.Context, *ListMembersRequest) (*ListMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMembers not implemented")
+}
+func (UnimplementedChamaServiceServer) GetMembership(context.Context, *GetMembershipRequest) (*Membership, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMembership not implemented")
+}
+func (UnimplementedChamaServiceServer) mustEmbedUnimplementedChamaServiceServer() {}
+func (UnimplementedChamaServiceServer) testEmbeddedByValue()                      {}
+
+// UnsafeChamaServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChamaServiceServer will
+// result in compilation errors.
+type UnsafeChamaServiceServer interface {
+	mustEmbedUnimplementedChamaServiceServer()
+}
+
+func RegisterChamaServiceServer(s grpc.ServiceRegistrar, srv ChamaServiceServer) {
+	// If the following call pancis, it indicates UnimplementedChamaServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ChamaService_ServiceDesc, srv)
+}
+
+func _ChamaService_GetChama_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetChamaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChamaServiceServer).GetChama(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChamaService_GetChama_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChamaServiceServer).GetChama(ctx, req.(*GetChamaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChamaService_ListMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChamaServiceServer).ListMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChamaService_ListMembers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChamaServiceServer).ListMembers(ctx, req.(*ListMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChamaService_GetMembership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMembershipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChamaServiceServer).GetMembership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChamaService_GetMembership_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChamaServiceServer).GetMembership(ctx, req.(*GetMembershipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ChamaService_ServiceDesc is the grpc.ServiceDesc for ChamaService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ChamaService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "tujifund.chamas.v1.ChamaService",
+	HandlerType: (*ChamaServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetChama",
+			Handler:    _ChamaService_GetChama_Handler,
+		},
+		{
+			MethodName: "ListMembers",
+			Handler:    _ChamaService_ListMembers_Handler,
+		},
+		{
+			MethodName: "GetMembership",
+			Handler:    _ChamaService_GetMembership_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "chamas/v1/chamas.proto",
+}
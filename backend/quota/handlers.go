@@ -0,0 +1,203 @@
+package quota
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/txn"
+
+	"github.com/gorilla/mux"
+)
+
+// WriteError writes the response for an *Exceeded error: 402, as with
+// suspension for unpaid fees, with a message in the client's language
+// naming the limit. Other errors are written as 500.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Exceeded
+	if !errors.As(err, &e) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	max := strconv.FormatInt(e.Max, 10)
+	if e.Limit == LimitStorage {
+		max = formatBytes(e.Max)
+	}
+	msg := i18n.T(i18n.FromRequest(r), "quota."+e.Limit, map[string]string{"max": max, "plan": e.Plan})
+	http.Error(w, msg, http.StatusPaymentRequired)
+}
+
+func formatBytes(n int64) string {
+	if n >= 1<<30 {
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	}
+	return fmt.Sprintf("%d MB", n>>20)
+}
+
+// StatusHandler returns the {chamaId} chama's plan and its usage of each
+// limit, for its officials
+func StatusHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		s, err := Get(r.Context(), db, chamaID, time.Now())
+		if !writeQuotaError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// AdminStatusHandler returns the {chamaId} chama's plan, usage and
+// overrides, with the limits of every plan
+func AdminStatusHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chamaID := mux.Vars(r)["chamaId"]
+		s, err := Get(r.Context(), db, chamaID, time.Now())
+		if !writeQuotaError(w, err) {
+			return
+		}
+		overrides, err := Overrides(r.Context(), db, chamaID)
+		if !writeQuotaError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    s,
+			"overrides": overrides,
+			"plans":     Plans,
+		})
+	}
+}
+
+// SetPlanHandler moves the {chamaId} chama to the plan in the body
+func SetPlanHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		chamaID := mux.Vars(r)["chamaId"]
+		var request struct {
+			Plan string `json:"plan"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		old, err := SetPlan(r.Context(), db, chamaID, request.Plan, userID)
+		if !writeQuotaError(w, err) {
+			return
+		}
+		err = audit.Record(r.Context(), txn.From(r.Context(), db), audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "quota.plan_changed", EntityType: "chama", EntityID: chamaID,
+			OldValues: map[string]interface{}{"plan": old},
+			NewValues: map[string]interface{}{"plan": request.Plan},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit plan change", "chama_id", chamaID, "error", err)
+		}
+		s, err := Get(r.Context(), db, chamaID, time.Now())
+		if !writeQuotaError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// SetOverrideHandler overrides the {chamaId} chama's {limit} with the body's
+// max (-1 for unlimited), reason and optional expiresAt
+func SetOverrideHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		chamaID := mux.Vars(r)["chamaId"]
+		var o Override
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		o.Limit, o.SetBy = mux.Vars(r)["limit"], userID
+		if o.Max < Unlimited {
+			http.Error(w, "max must be -1 (unlimited) or more", http.StatusBadRequest)
+			return
+		}
+		if o.Reason == "" {
+			http.Error(w, "reason is required", http.StatusBadRequest)
+			return
+		}
+		if o.ExpiresAt != nil && !o.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+			return
+		}
+		if !writeQuotaError(w, SetOverride(r.Context(), db, chamaID, o)) {
+			return
+		}
+		err := audit.Record(r.Context(), txn.From(r.Context(), db), audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "quota.override_set", EntityType: "chama", EntityID: chamaID,
+			NewValues: map[string]interface{}{"limit": o.Limit, "max": o.Max, "reason": o.Reason, "expiresAt": o.ExpiresAt},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit quota override", "chama_id", chamaID, "error", err)
+		}
+		s, err := Get(r.Context(), db, chamaID, time.Now())
+		if !writeQuotaError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// RemoveOverrideHandler puts the {chamaId} chama back on its plan's {limit}
+func RemoveOverrideHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		chamaID, limit := mux.Vars(r)["chamaId"], mux.Vars(r)["limit"]
+		removed, err := RemoveOverride(r.Context(), db, chamaID, limit)
+		if !writeQuotaError(w, err) {
+			return
+		}
+		if !removed {
+			http.Error(w, "Override not found", http.StatusNotFound)
+			return
+		}
+		err = audit.Record(r.Context(), txn.From(r.Context(), db), audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "quota.override_removed", EntityType: "chama", EntityID: chamaID,
+			OldValues: map[string]interface{}{"limit": limit},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit quota override removal", "chama_id", chamaID, "error", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeQuotaError writes the response for err and reports whether it was nil
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "Chama not found", http.StatusNotFound)
+	case errors.Is(err, ErrUnknownPlan), errors.Is(err, ErrUnknownLimit):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
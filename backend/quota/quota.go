@@ -0,0 +1,329 @@
+// Package quota holds chamas to the limits of their plan: how many active
+// members they may have, how many SMS may be sent on their behalf each
+// month and how much they may keep in the document vault. Each chama is on
+// a plan, DefaultPlan unless platform staff move it, and staff may override
+// any of a plan's limits for one chama, for good or until a date.
+//
+// Limits are soft: they are checked when something would grow past them,
+// and a chama already over a limit, e.g. after moving to a smaller plan,
+// keeps what it has but cannot add more. Usage at WarnAt of a limit is
+// flagged so officials can act before they are stopped.
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/billing"
+	"tujifund-app/backend/txn"
+)
+
+// Limits
+const (
+	LimitMembers = "members"       // active members
+	LimitSMS     = "sms_per_month" // SMS sent on the chama's behalf this calendar month (UTC)
+	LimitStorage = "storage_bytes" // every version of every document in the vault
+)
+
+// Names lists the limits in the order they are reported
+var Names = []string{LimitMembers, LimitSMS, LimitStorage}
+
+// Unlimited is the limit of a plan or override that does not limit
+const Unlimited int64 = -1
+
+// Plans
+const (
+	PlanFree     = "free"
+	PlanStandard = "standard"
+	PlanPremium  = "premium"
+)
+
+// Sources of a chama's limit
+const (
+	SourcePlan     = "plan"
+	SourceOverride = "override"
+)
+
+var (
+	// Plans are each plan's limits
+	Plans = map[string]map[string]int64{
+		PlanFree:     {LimitMembers: 15, LimitSMS: 50, LimitStorage: 100 << 20},
+		PlanStandard: {LimitMembers: 60, LimitSMS: 500, LimitStorage: 1 << 30},
+		PlanPremium:  {LimitMembers: 500, LimitSMS: 5000, LimitStorage: Unlimited},
+	}
+	// DefaultPlan is the plan of chamas that have not been moved to another
+	DefaultPlan = PlanStandard
+	// WarnAt is the share of a limit at which usage is flagged as near it
+	WarnAt = 0.8
+)
+
+var (
+	ErrExceeded     = errors.New("over the chama's plan limit")
+	ErrUnknownPlan  = errors.New("unknown plan")
+	ErrUnknownLimit = errors.New("unknown limit")
+	ErrNotFound     = errors.New("chama not found")
+)
+
+// Exceeded is the error returned when a change would take a chama over one
+// of its limits. It matches ErrExceeded.
+type Exceeded struct {
+	Limit string `json:"limit"`
+	Plan  string `json:"plan"`
+	Max   int64  `json:"max"`
+	Used  int64  `json:"used"`
+}
+
+func (e *Exceeded) Error() string {
+	return fmt.Sprintf("chama has used %d of the %d %s its plan allows", e.Used, e.Max, e.Limit)
+}
+
+func (e *Exceeded) Is(target error) bool { return target == ErrExceeded }
+
+// Override replaces one of a plan's limits for a chama
+type Override struct {
+	Limit     string     `json:"limit"`
+	Max       int64      `json:"max"` // Unlimited to lift the limit
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // the plan's limit applies again from then
+	SetBy     string     `json:"setBy,omitempty"`
+	SetAt     time.Time  `json:"setAt"`
+}
+
+// Usage is how much of one of its limits a chama has used
+type Usage struct {
+	Limit     string     `json:"limit"`
+	Used      int64      `json:"used"`
+	Max       int64      `json:"max"` // Unlimited when not limited
+	Source    string     `json:"source"`
+	Near      bool       `json:"near"` // at WarnAt of the limit or over it
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Status is a chama's plan and its usage of each limit
+type Status struct {
+	ChamaID string  `json:"chamaId"`
+	Plan    string  `json:"plan"`
+	Limits  []Usage `json:"limits"`
+}
+
+// known reports whether limit is one of Names
+func known(limit string) bool {
+	for _, name := range Names {
+		if name == limit {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanOf returns the chama's plan
+func PlanOf(ctx context.Context, db *sql.DB, chamaID string) (string, error) {
+	var plan string
+	err := txn.From(ctx, db).QueryRowContext(ctx, `SELECT plan FROM chama_plans WHERE chama_id = ?`, chamaID).Scan(&plan)
+	if err == sql.ErrNoRows {
+		return DefaultPlan, nil
+	}
+	return plan, err
+}
+
+// limitOf returns the chama's limit and where it comes from
+func limitOf(ctx context.Context, db *sql.DB, chamaID, plan, name string, now time.Time) (Usage, error) {
+	if !known(name) {
+		return Usage{}, ErrUnknownLimit
+	}
+	u := Usage{Limit: name, Max: Unlimited, Source: SourcePlan}
+	if max, ok := Plans[plan][name]; ok {
+		u.Max = max
+	}
+	var expires sql.NullTime
+	err := txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT max_value, expires_at FROM quota_overrides
+		WHERE chama_id = ? AND limit_name = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		chamaID, name, now.UTC()).Scan(&u.Max, &expires)
+	if err == sql.ErrNoRows {
+		return u, nil
+	}
+	if err != nil {
+		return u, err
+	}
+	u.Source = SourceOverride
+	if expires.Valid {
+		u.ExpiresAt = &expires.Time
+	}
+	return u, nil
+}
+
+// Used measures how much of limit the chama has used at now
+func Used(ctx context.Context, db *sql.DB, chamaID, limit string, now time.Time) (int64, error) {
+	var n int64
+	var err error
+	conn := txn.From(ctx, db)
+	switch limit {
+	case LimitMembers:
+		err = conn.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM chama_members WHERE chama_id = ? AND status = 'active'`, chamaID).Scan(&n)
+	case LimitSMS:
+		start := time.Date(now.UTC().Year(), now.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+		err = conn.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(quantity), 0) FROM usage_events
+			WHERE chama_id = ? AND kind = ? AND occurred_at >= ?`,
+			chamaID, billing.KindSMS, start.Format("2006-01-02 15:04:05")).Scan(&n)
+	case LimitStorage:
+		err = conn.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(v.size_bytes), 0)
+			FROM chama_document_versions v JOIN chama_documents d ON d.id = v.document_id
+			WHERE d.chama_id = ?`, chamaID).Scan(&n)
+	default:
+		return 0, ErrUnknownLimit
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", limit, err)
+	}
+	return n, nil
+}
+
+// Check returns an *Exceeded error if adding n to the chama's use of limit
+// would take it over the limit
+func Check(ctx context.Context, db *sql.DB, chamaID, limit string, n int64) error {
+	now := time.Now()
+	plan, err := PlanOf(ctx, db, chamaID)
+	if err != nil {
+		return err
+	}
+	u, err := limitOf(ctx, db, chamaID, plan, limit, now)
+	if err != nil || u.Max == Unlimited {
+		return err
+	}
+	used, err := Used(ctx, db, chamaID, limit, now)
+	if err != nil {
+		return err
+	}
+	if used+n > u.Max {
+		return &Exceeded{Limit: limit, Plan: plan, Max: u.Max, Used: used}
+	}
+	return nil
+}
+
+// Get returns the chama's plan and its usage of each limit
+func Get(ctx context.Context, db *sql.DB, chamaID string, now time.Time) (Status, error) {
+	s := Status{ChamaID: chamaID}
+	if err := exists(ctx, db, chamaID); err != nil {
+		return s, err
+	}
+	plan, err := PlanOf(ctx, db, chamaID)
+	if err != nil {
+		return s, err
+	}
+	s.Plan = plan
+	for _, name := range Names {
+		u, err := limitOf(ctx, db, chamaID, plan, name, now)
+		if err != nil {
+			return s, err
+		}
+		if u.Used, err = Used(ctx, db, chamaID, name, now); err != nil {
+			return s, err
+		}
+		u.Near = u.Max != Unlimited && float64(u.Used) >= WarnAt*float64(u.Max)
+		s.Limits = append(s.Limits, u)
+	}
+	return s, nil
+}
+
+// SetPlan moves the chama to plan, returning the plan it was on
+func SetPlan(ctx context.Context, db *sql.DB, chamaID, plan, by string) (string, error) {
+	if _, ok := Plans[plan]; !ok {
+		return "", ErrUnknownPlan
+	}
+	if err := exists(ctx, db, chamaID); err != nil {
+		return "", err
+	}
+	old, err := PlanOf(ctx, db, chamaID)
+	if err != nil {
+		return "", err
+	}
+	_, err = txn.From(ctx, db).ExecContext(ctx, `
+		INSERT INTO chama_plans (chama_id, plan, set_by, set_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(chama_id) DO UPDATE SET plan = excluded.plan, set_by = excluded.set_by, set_at = excluded.set_at`,
+		chamaID, plan, by)
+	return old, err
+}
+
+// SetOverride replaces one of the chama's plan limits with o
+func SetOverride(ctx context.Context, db *sql.DB, chamaID string, o Override) error {
+	if !known(o.Limit) {
+		return ErrUnknownLimit
+	}
+	if err := exists(ctx, db, chamaID); err != nil {
+		return err
+	}
+	var expires interface{}
+	if o.ExpiresAt != nil {
+		expires = o.ExpiresAt.UTC()
+	}
+	_, err := txn.From(ctx, db).ExecContext(ctx, `
+		INSERT INTO quota_overrides (chama_id, limit_name, max_value, reason, expires_at, set_by, set_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(chama_id, limit_name) DO UPDATE SET
+		    max_value = excluded.max_value, reason = excluded.reason, expires_at = excluded.expires_at,
+		    set_by = excluded.set_by, set_at = excluded.set_at`,
+		chamaID, o.Limit, o.Max, nullIfEmpty(o.Reason), expires, o.SetBy)
+	return err
+}
+
+// RemoveOverride puts the chama back on its plan's limit, reporting whether
+// there was an override to remove
+func RemoveOverride(ctx context.Context, db *sql.DB, chamaID, limit string) (bool, error) {
+	if !known(limit) {
+		return false, ErrUnknownLimit
+	}
+	res, err := txn.From(ctx, db).ExecContext(ctx, `
+		DELETE FROM quota_overrides WHERE chama_id = ? AND limit_name = ?`, chamaID, limit)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Overrides returns the chama's overrides, including expired ones
+func Overrides(ctx context.Context, db *sql.DB, chamaID string) ([]Override, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT limit_name, max_value, COALESCE(reason, ''), expires_at, COALESCE(set_by, ''), set_at
+		FROM quota_overrides WHERE chama_id = ? ORDER BY limit_name`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Override{}
+	for rows.Next() {
+		var o Override
+		var expires sql.NullTime
+		if err := rows.Scan(&o.Limit, &o.Max, &o.Reason, &expires, &o.SetBy, &o.SetAt); err != nil {
+			return nil, err
+		}
+		if expires.Valid {
+			o.ExpiresAt = &expires.Time
+		}
+		list = append(list, o)
+	}
+	return list, rows.Err()
+}
+
+func exists(ctx context.Context, db *sql.DB, chamaID string) error {
+	var one int
+	err := txn.From(ctx, db).QueryRowContext(ctx, `SELECT 1 FROM chamas WHERE id = ?`, chamaID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,39 @@
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"tujifund-app/backend/otp"
+	"tujifund-app/backend/tenancy"
+)
+
+// guardedSender refuses SMS a chama has no allowance left for
+type guardedSender struct {
+	db   *sql.DB
+	next otp.Sender
+}
+
+// GuardSMS wraps sender so that SMS sent while serving a chama's route are
+// refused with an *Exceeded error once the chama has used its monthly
+// allowance. Wrap it in billing.MeterSMS, which counts what is sent. SMS
+// sent outside a chama, such as sign-in codes, are never refused.
+func GuardSMS(db *sql.DB, sender otp.Sender) otp.Sender {
+	return guardedSender{db: db, next: sender}
+}
+
+func (g guardedSender) Send(ctx context.Context, to, message string) error {
+	if chamaID := tenancy.ChamaID(ctx); chamaID != "" {
+		err := Check(ctx, g.db, chamaID, LimitSMS, 1)
+		if errors.Is(err, ErrExceeded) {
+			return err
+		}
+		if err != nil {
+			// An allowance that cannot be measured does not stop the message
+			slog.ErrorContext(ctx, "Failed to check SMS allowance", "chama_id", chamaID, "error", err)
+		}
+	}
+	return g.next.Send(ctx, to, message)
+}
@@ -0,0 +1,307 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tujifund-app/backend/i18n"
+)
+
+// Config describes a token bucket: Burst tokens refilled at Rate tokens per second
+type Config struct {
+	Rate  float64
+	Burst int
+}
+
+// Default limits for the endpoints that are attractive to abuse
+var (
+	AuthConfig    = Config{Rate: 5.0 / 60, Burst: 5}  // 5 attempts a minute
+	OTPConfig     = Config{Rate: 3.0 / 300, Burst: 3} // 3 codes every 5 minutes
+	PaymentConfig = Config{Rate: 2.0 / 60, Burst: 2}  // 2 STK pushes a minute
+)
+
+// Store keeps bucket state. MemoryStore is used by default; RedisStore
+// shares limits across instances.
+type Store interface {
+	Take(key string, cfg Config, now time.Time) (allowed bool, retryAfter time.Duration)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Duration // how long the bucket takes to refill from empty
+}
+
+// MemoryStore is an in-process Store
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+// Take removes one token from the bucket for key if one is available
+func (s *MemoryStore) Take(key string, cfg Config, now time.Time) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(cfg.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.full = cfg.refill()
+
+	// Refill based on the time since the last request
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(float64(cfg.Burst), b.tokens+elapsed*cfg.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := (1 - b.tokens) / cfg.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely so the map does not
+// grow forever. Limiters sharing the store have different configs, so each
+// bucket is judged by the config it was last taken from.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.last) > b.full {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// refill returns how long an empty bucket takes to fill up again
+func (cfg Config) refill() time.Duration {
+	return time.Duration(float64(cfg.Burst) / cfg.Rate * float64(time.Second))
+}
+
+// Limiter applies one Config to a named group of endpoints
+type Limiter struct {
+	name  string
+	store Store
+
+	mu  sync.RWMutex
+	cfg Config
+}
+
+// New creates a limiter. Keys are prefixed with name so several limiters can share a store.
+func New(name string, cfg Config, store Store) *Limiter {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &Limiter{name: name, cfg: cfg, store: store}
+}
+
+// Allow reports whether a request for key may proceed and, if not, how long to wait
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.RLock()
+	cfg := l.cfg
+	l.mu.RUnlock()
+	return l.store.Take(l.name+":"+key, cfg, time.Now())
+}
+
+// SetConfig changes the limit, e.g. when the configuration is reloaded.
+// Buckets keep their tokens, capped at the new burst on their next request.
+func (l *Limiter) SetConfig(cfg Config) {
+	l.mu.Lock()
+	l.cfg = cfg
+	l.mu.Unlock()
+}
+
+// ParseConfig reads a limit written as "N/period", e.g. "5/1m" for five
+// requests a minute, allowing bursts of N
+func ParseConfig(s string) (Config, error) {
+	n, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	count, err := strconv.Atoi(n)
+	if !ok || err != nil || count <= 0 {
+		return Config{}, fmt.Errorf("invalid rate limit %q, expected e.g. 5/1m", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Config{}, fmt.Errorf("invalid rate limit %q, expected e.g. 5/1m", s)
+	}
+	return Config{Rate: float64(count) / d.Seconds(), Burst: count}, nil
+}
+
+// ConfigFromEnv reads a limit from the variable key, e.g. RATE_LIMIT_AUTH=5/1m,
+// returning def when it is not set
+func ConfigFromEnv(key string, def Config) (Config, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	cfg, err := ParseConfig(value)
+	if err != nil {
+		return def, fmt.Errorf("%s: %w", key, err)
+	}
+	return cfg, nil
+}
+
+// PerIP limits requests by client IP address
+func PerIP(l *Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, l, "ip:"+ClientIP(r)) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// PerUser limits requests by authenticated user, falling back to the client IP
+// for anonymous requests
+func PerUser(l *Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + ClientIP(r)
+		if userID, ok := r.Context().Value("userID").(string); ok && userID != "" {
+			key = "user:" + userID
+		}
+		if !allow(w, r, l, key) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// maxEmailBody caps how much of a request body PerEmail reads
+const maxEmailBody = 64 << 10
+
+// PerEmail limits requests by the email address in their JSON body, lowercased
+// and trimmed, so that guessing one account's password from many addresses is
+// still limited. Requests without an email are left to next to reject; the
+// body is restored for it to read.
+func PerEmail(l *Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEmailBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		var req struct {
+			Email string `json:"email"`
+		}
+		if json.Unmarshal(body, &req) == nil {
+			if email := strings.ToLower(strings.TrimSpace(req.Email)); email != "" && !allow(w, r, l, "email:"+email) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+func allow(w http.ResponseWriter, r *http.Request, l *Limiter, key string) bool {
+	ok, retryAfter := l.Allow(key)
+	if ok {
+		return true
+	}
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, i18n.T(i18n.FromRequest(r), "auth.too_many_requests", nil), http.StatusTooManyRequests)
+	return false
+}
+
+// trustedProxies are the networks whose X-Forwarded-For headers ClientIP
+// believes. It is set once at startup.
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the proxies allowed to report the client's address
+// in X-Forwarded-For, as a comma-separated list of IP addresses and CIDR
+// ranges, e.g. TRUSTED_PROXIES=10.0.0.0/8,192.168.1.10. With none set the
+// header is ignored, as any client could send it.
+func SetTrustedProxies(list string) error {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		nets = append(nets, n)
+	}
+	trustedProxies = nets
+	return nil
+}
+
+func trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the caller's IP. When the request comes from a trusted
+// proxy, X-Forwarded-For is read from the right, skipping the trusted
+// proxies each hop appended, and the first other address is the client's;
+// entries further left were written by the client and could be anything.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !trusted(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPerEmail(t *testing.T) {
+	l := New("login_email", Config{Rate: 1.0 / 60, Burst: 2}, NewMemoryStore())
+	var seen string
+	h := PerEmail(l, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		seen = string(b)
+	})
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"first attempt", `{"email":"Wanjiku@Example.com","password":"a"}`, http.StatusOK},
+		{"same address, other spelling", `{"email":" wanjiku@example.com ","password":"b"}`, http.StatusOK},
+		{"bucket empty", `{"email":"WANJIKU@EXAMPLE.COM","password":"c"}`, http.StatusTooManyRequests},
+		{"other address", `{"email":"otieno@example.com","password":"a"}`, http.StatusOK},
+		{"no email", `{"password":"a"}`, http.StatusOK},
+		{"not JSON", `email=wanjiku@example.com`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		seen = ""
+		req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(tt.body))
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && seen != tt.body {
+			t.Errorf("%s: handler read %q, want %q", tt.name, seen, tt.body)
+		}
+		if tt.want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After", tt.name)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/cache"
+)
+
+// takeScript is MemoryStore.Take run inside Redis, so that instances taking
+// from the same bucket at once cannot both spend its last token. A bucket
+// expires once it would have refilled completely.
+const takeScript = `
+local rate, burst, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens, last = tonumber(b[1]), tonumber(b[2])
+if tokens == nil or last == nil then
+	tokens, last = burst, now
+end
+tokens = math.min(burst, tokens + math.max(0, now - last) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+return {allowed, tostring(tokens)}`
+
+// RedisStore keeps buckets in Redis so that every instance behind a load
+// balancer enforces the same limits. While Redis cannot be reached each
+// instance falls back to limiting on its own.
+type RedisStore struct {
+	redis    *cache.Redis
+	fallback *MemoryStore
+}
+
+// NewRedisStore creates a store keeping its buckets in r
+func NewRedisStore(r *cache.Redis) *RedisStore {
+	return &RedisStore{redis: r, fallback: NewMemoryStore()}
+}
+
+// NewStoreFromEnv returns a RedisStore when REDIS_URL is set, otherwise a
+// MemoryStore
+func NewStoreFromEnv() (Store, error) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		return NewMemoryStore(), nil
+	}
+	r, err := cache.NewRedis(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return NewRedisStore(r), nil
+}
+
+// Take implements Store
+func (s *RedisStore) Take(key string, cfg Config, now time.Time) (bool, time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	v, err := s.redis.Eval(ctx, takeScript, []string{"ratelimit:" + key},
+		strconv.FormatFloat(cfg.Rate, 'g', -1, 64), strconv.Itoa(cfg.Burst),
+		strconv.FormatFloat(float64(now.UnixNano())/1e9, 'f', 6, 64))
+	allowed, tokens, err := takeReply(v, err)
+	if err != nil {
+		slog.Warn("Rate limit store unavailable, limiting locally", "error", err)
+		return s.fallback.Take(key, cfg, now)
+	}
+	if allowed {
+		return true, 0
+	}
+	wait := (1 - tokens) / cfg.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// takeReply reads takeScript's {allowed, tokens} reply
+func takeReply(v interface{}, err error) (bool, float64, error) {
+	if err != nil {
+		return false, 0, err
+	}
+	reply, ok := v.([]interface{})
+	if !ok || len(reply) != 2 {
+		return false, 0, fmt.Errorf("unexpected reply to rate limit script: %v", v)
+	}
+	allowed, ok := reply[0].(int64)
+	raw, ok2 := reply[1].([]byte)
+	if !ok || !ok2 {
+		return false, 0, fmt.Errorf("unexpected reply to rate limit script: %v", v)
+	}
+	tokens, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("unexpected reply to rate limit script: %v", v)
+	}
+	return allowed == 1, tokens, nil
+}
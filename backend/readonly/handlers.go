@@ -0,0 +1,117 @@
+package readonly
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"tujifund-app/backend/i18n"
+)
+
+// AdminPath is the admin route that switches the mode, which stays open
+// while it is on
+const AdminPath = "/api/admin/maintenance/read-only"
+
+// RetryAfter is the Retry-After, in seconds, sent with refused requests
+const RetryAfter = "300"
+
+// exempt are the changes let through while the mode is on: switching it off,
+// and signing in and out, so members can keep reading. Their statements may
+// write.
+var exempt = map[string]bool{
+	AdminPath:     true,
+	"/api/login":  true,
+	"/api/logout": true,
+}
+
+var (
+	passMu sync.RWMutex
+	pass   = map[string]bool{}
+)
+
+// PassThrough lets requests to paths through Middleware while the mode is
+// on, for requests that cannot be refused, such as a payment provider's
+// callbacks. Their statements are still refused unless run with a context
+// from Allow, so their handlers keep what they are sent to apply once the
+// mode ends.
+func PassThrough(paths ...string) {
+	passMu.Lock()
+	defer passMu.Unlock()
+	for _, p := range paths {
+		pass[p] = true
+	}
+}
+
+func passesThrough(path string) bool {
+	passMu.RLock()
+	defer passMu.RUnlock()
+	return pass[path]
+}
+
+// Middleware refuses requests that would change data while the mode is on,
+// with 503 and a message in the client's language. Install it with
+// router.Use.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if exempt[r.URL.Path] {
+				r = r.WithContext(Allow(r.Context()))
+			} else if enabled.Load() && !passesThrough(r.URL.Path) {
+				refuse(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Refused answers as Middleware does and returns true if err is from a
+// statement the mode refused, for reads that have to write, such as one
+// creating a member's referral code on first sight
+func Refused(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, ErrReadOnly) {
+		return false
+	}
+	refuse(w, r)
+	return true
+}
+
+func refuse(w http.ResponseWriter, r *http.Request) {
+	state := Current()
+	msg := i18n.T(i18n.FromRequest(r), "maintenance.read_only", nil)
+	if state.Message != "" {
+		msg += " " + state.Message
+	}
+	w.Header().Set("Retry-After", RetryAfter)
+	http.Error(w, msg, http.StatusServiceUnavailable)
+}
+
+// StatusHandler returns the mode's state. Clients find it in /api/health
+// too, to show a banner and disable their forms.
+func StatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Current())
+	}
+}
+
+// SetHandler switches staff's hold on the mode. Wrap it in admin.Require.
+func SetHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(string)
+		var req struct {
+			Enabled bool   `json:"enabled"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		state := Set(req.Enabled, req.Message, userID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	}
+}
@@ -0,0 +1,192 @@
+// Package readonly puts the API into read-only maintenance mode for
+// migrations and backup restores: reads are still served, while changes are
+// refused with a message saying why. The mode is switched on by config,
+// READ_ONLY=true (with READ_ONLY_MESSAGE shown to clients), or by platform
+// staff through the admin API. Either is enough: staff cannot switch off a
+// mode the config holds on, and a config reload leaves staff's switch
+// alone. Staff's switch lasts until it is turned off or the server restarts.
+//
+// Middleware enforces the mode for requests, CheckStatement refuses the
+// statements that write on every database connection, and the job
+// scheduler waits for it to end. Writes the mode must let through, such as
+// session bookkeeping so that signed-in members keep reading, run with a
+// context from Allow.
+package readonly
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReadOnly refuses a statement that writes while the mode is on
+var ErrReadOnly = errors.New("read-only maintenance mode is on")
+
+// Sources of the mode
+const (
+	SourceConfig = "config"
+	SourceAdmin  = "admin"
+)
+
+// State is whether the mode is on, and who turned it on
+type State struct {
+	Enabled bool      `json:"enabled"`
+	Source  string    `json:"source,omitempty"`
+	Message string    `json:"message,omitempty"` // shown to clients, e.g. when the work should be over
+	By      string    `json:"by,omitempty"`      // staff member who turned it on
+	Since   time.Time `json:"since,omitempty"`
+}
+
+var (
+	mu      sync.Mutex
+	config  State
+	admin   State
+	enabled atomic.Bool // config.Enabled || admin.Enabled, read on every write
+)
+
+// Enabled reports whether the mode is on
+func Enabled() bool {
+	return enabled.Load()
+}
+
+type allowKey struct{}
+
+// Allow returns a copy of ctx whose statements may write while the mode is
+// on
+func Allow(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allowKey{}, true)
+}
+
+// Allowed reports whether ctx comes from Allow
+func Allowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(allowKey{}).(bool)
+	return allowed
+}
+
+// CheckStatement returns ErrReadOnly for a statement that writes while the
+// mode is on, unless ctx comes from Allow. Databases run it before every
+// statement.
+func CheckStatement(ctx context.Context, query string) error {
+	if !enabled.Load() || Allowed(ctx) || !Writes(query) {
+		return nil
+	}
+	return ErrReadOnly
+}
+
+// writeStatements are the statements that change data or the schema, and
+// writeClauses the ones that do so within a WITH statement
+var (
+	writeStatements = map[string]bool{
+		"INSERT": true, "UPDATE": true, "DELETE": true, "REPLACE": true, "MERGE": true,
+		"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "REINDEX": true,
+	}
+	writeClauses = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true}
+)
+
+// Writes reports whether query changes data or the schema. Reads and
+// transaction control do not. A WITH statement writes if any of its clauses
+// does; string literals, quoted identifiers and comments are skipped.
+func Writes(query string) bool {
+	words := keywords(query)
+	if len(words) == 0 {
+		return false
+	}
+	if words[0] != "WITH" {
+		return writeStatements[words[0]]
+	}
+	for _, w := range words[1:] {
+		if writeClauses[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// keywords returns the upper-cased words of query outside string literals,
+// quoted identifiers and comments
+func keywords(query string) []string {
+	var words []string
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				return words
+			}
+			i += end + 2
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return words
+			}
+			i += end + 1
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return words
+			}
+			i += end + 4
+		case isWordByte(c):
+			j := i
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			words = append(words, strings.ToUpper(query[i:j]))
+			i = j
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Current returns the mode's state. The config's hold wins when both have
+// it on, as only a config change can end it.
+func Current() State {
+	mu.Lock()
+	defer mu.Unlock()
+	if config.Enabled {
+		return config
+	}
+	return admin
+}
+
+// Set switches staff's hold on the mode on or off
+func Set(on bool, message, by string) State {
+	mu.Lock()
+	if on {
+		admin = State{Enabled: true, Source: SourceAdmin, Message: message, By: by, Since: time.Now().UTC()}
+	} else {
+		admin = State{}
+	}
+	enabled.Store(config.Enabled || admin.Enabled)
+	mu.Unlock()
+	return Current()
+}
+
+// FromEnv reads the config's hold on the mode from READ_ONLY and
+// READ_ONLY_MESSAGE. It is run at startup and on every config reload.
+func FromEnv() error {
+	on := os.Getenv("READ_ONLY") == "true"
+	message := os.Getenv("READ_ONLY_MESSAGE")
+	mu.Lock()
+	defer mu.Unlock()
+	switch {
+	case on && !config.Enabled:
+		config = State{Enabled: true, Source: SourceConfig, Message: message, Since: time.Now().UTC()}
+	case on:
+		config.Message = message
+	default:
+		config = State{}
+	}
+	enabled.Store(config.Enabled || admin.Enabled)
+	return nil
+}
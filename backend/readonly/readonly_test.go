@@ -0,0 +1,105 @@
+package readonly
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrites(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{`SELECT * FROM chamas WHERE id = ?`, false},
+		{`  select count(*) from loans`, false},
+		{`INSERT INTO audit_logs (id) VALUES (?)`, true},
+		{`insert or ignore into referral_codes (user_id, code) values (?, ?)`, true},
+		{"\n\t\tUPDATE sessions SET last_activity = ? WHERE id = ?", true},
+		{`DELETE FROM sessions WHERE id = ?`, true},
+		{`REPLACE INTO settings (key, value) VALUES (?, ?)`, true},
+		{`CREATE TABLE IF NOT EXISTS users (id TEXT)`, true},
+		{`ALTER TABLE users ADD COLUMN national_id TEXT`, true},
+		{`DROP INDEX idx_users_email`, true},
+		{`-- the member's loans
+		SELECT * FROM loans`, false},
+		{`/* DELETE */ SELECT 1`, false},
+		{`SELECT 'DELETE' AS action, "update" FROM audit_logs`, false},
+		{`WITH paid AS (SELECT member_id FROM contributions) SELECT * FROM paid`, false},
+		{`WITH old AS (SELECT id FROM sessions) DELETE FROM sessions WHERE id IN (SELECT id FROM old)`, true},
+		{`WITH t AS (SELECT REPLACE(name, 'a', 'b') AS n FROM chamas) SELECT n FROM t`, false},
+		{`BEGIN`, false},
+		{`COMMIT`, false},
+		{`SAVEPOINT txn_1`, false},
+		{`PRAGMA table_info(users)`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := Writes(tt.query); got != tt.want {
+			t.Errorf("Writes(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestCheckStatement(t *testing.T) {
+	defer Set(false, "", "")
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		on    bool
+		ctx   context.Context
+		query string
+		want  error
+	}{
+		{"write with the mode off", false, ctx, `INSERT INTO chamas (id) VALUES (?)`, nil},
+		{"read with the mode on", true, ctx, `SELECT * FROM chamas`, nil},
+		{"write with the mode on", true, ctx, `INSERT INTO chamas (id) VALUES (?)`, ErrReadOnly},
+		{"allowed write with the mode on", true, Allow(ctx), `UPDATE sessions SET last_activity = ?`, nil},
+	}
+	for _, tt := range tests {
+		Set(tt.on, "", "")
+		if err := CheckStatement(tt.ctx, tt.query); err != tt.want {
+			t.Errorf("%s: CheckStatement() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	defer Set(false, "", "")
+	PassThrough("/api/payments/test/callback")
+
+	tests := []struct {
+		name, method, path string
+		on                 bool
+		status             int
+		allowed            bool // whether the handler's statements may write
+	}{
+		{"change with the mode off", http.MethodPost, "/api/chamas", false, http.StatusOK, false},
+		{"read with the mode on", http.MethodGet, "/api/chamas", true, http.StatusOK, false},
+		{"change with the mode on", http.MethodPost, "/api/chamas", true, http.StatusServiceUnavailable, false},
+		{"sign in with the mode on", http.MethodPost, "/api/login", true, http.StatusOK, true},
+		{"switch off", http.MethodPut, AdminPath, true, http.StatusOK, true},
+		{"callback passed through", http.MethodPost, "/api/payments/test/callback", true, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Set(tt.on, "Back at 6pm.", "staff")
+			var allowed bool
+			handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				allowed = Allowed(r.Context())
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v", allowed, tt.allowed)
+			}
+			if tt.status == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") != RetryAfter {
+				t.Errorf("Retry-After = %q, want %q", rec.Header().Get("Retry-After"), RetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package receipts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// ListHandler returns the caller's receipts
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		list, err := ListForUser(r.Context(), db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// DownloadHandler redirects to a short-lived link for the {id} receipt.
+// Members can download their own receipts; chama officials can download any
+// receipt issued by their chama.
+func DownloadHandler(db *sql.DB, store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		rc, err := Get(r.Context(), db, mux.Vars(r)["id"])
+		if err == sql.ErrNoRows {
+			http.Error(w, "Receipt not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if rc.UserID != userID && !chamas.IsOfficialContext(r.Context(), db, rc.ChamaID, userID) {
+			http.Error(w, "Receipt not found", http.StatusNotFound)
+			return
+		}
+
+		url, err := store.SignedURL(rc.StorageKey, 5*time.Minute)
+		if err != nil {
+			http.Error(w, "Failed to create download link", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
@@ -0,0 +1,230 @@
+package receipts
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Payment types that get a receipt
+const (
+	PaymentContribution  = "contribution"
+	PaymentLoanRepayment = "loan_repayment"
+)
+
+// Payment describes a confirmed payment to issue a receipt for
+type Payment struct {
+	ChamaID   string
+	UserID    string
+	Type      string
+	PaymentID string
+	Amount    money.Money
+	Method    string
+	Reference string
+	PaidAt    time.Time
+}
+
+// Receipt is an issued receipt
+type Receipt struct {
+	ID            string      `json:"id"`
+	Number        string      `json:"receiptNumber"`
+	ChamaID       string      `json:"chamaId"`
+	UserID        string      `json:"userId"`
+	PaymentType   string      `json:"paymentType"`
+	PaymentID     string      `json:"paymentId"`
+	Amount        money.Money `json:"amount"`
+	PaymentMethod string      `json:"paymentMethod,omitempty"`
+	Reference     string      `json:"transactionReference,omitempty"`
+	StorageKey    string      `json:"-"`
+	IssuedAt      string      `json:"issuedAt"`
+}
+
+var receiptTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Receipt {{.Number}}</title>
+<style>
+body { font-family: sans-serif; max-width: 480px; margin: 2em auto; color: #222; }
+h1 { font-size: 1.4em; margin-bottom: 0; }
+table { width: 100%; border-collapse: collapse; margin-top: 1em; }
+td { padding: 6px 0; border-bottom: 1px solid #eee; }
+td:last-child { text-align: right; }
+.amount { font-size: 1.3em; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>{{.ChamaName}}</h1>
+<p>Official receipt</p>
+<table>
+<tr><td>Receipt number</td><td>{{.Number}}</td></tr>
+<tr><td>Date</td><td>{{.Date}}</td></tr>
+<tr><td>Received from</td><td>{{.MemberName}}</td></tr>
+<tr><td>Payment for</td><td>{{.Description}}</td></tr>
+{{if .Method}}<tr><td>Payment method</td><td>{{.Method}}</td></tr>{{end}}
+{{if .Reference}}<tr><td>Reference</td><td>{{.Reference}}</td></tr>{{end}}
+<tr><td>Amount</td><td class="amount">{{.Amount}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// Issue numbers, renders and stores a receipt for p, then notifies the member.
+// Issuing twice for the same payment returns the existing receipt.
+func Issue(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, p Payment) (Receipt, error) {
+	if existing, err := findByPayment(ctx, db, p.Type, p.PaymentID); err == nil {
+		return existing, nil
+	} else if err != sql.ErrNoRows {
+		return Receipt{}, err
+	}
+
+	var chamaName, memberName string
+	err := txn.From(ctx, db).QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, p.ChamaID).Scan(&chamaName)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("failed to load chama: %w", err)
+	}
+	err = txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT COALESCE(NULLIF(TRIM(COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')), ''), username)
+		FROM users WHERE user_id = ?`, p.UserID,
+	).Scan(&memberName)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("failed to load member: %w", err)
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return Receipt{}, err
+	}
+	defer txn.Rollback(tx)
+
+	var seq int64
+	var series string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO receipt_sequences (chama_id, last_number) VALUES (?, 1)
+		ON CONFLICT(chama_id) DO UPDATE SET last_number = last_number + 1
+		RETURNING last_number, COALESCE(series, '')`, p.ChamaID,
+	).Scan(&seq, &series)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("failed to allocate receipt number: %w", err)
+	}
+
+	if p.PaidAt.IsZero() {
+		p.PaidAt = time.Now()
+	}
+	if series == "" {
+		series = strconv.Itoa(p.PaidAt.Year())
+	}
+	rc := Receipt{
+		ID:            uuid.NewString(),
+		Number:        fmt.Sprintf("RCT-%s-%06d", series, seq),
+		ChamaID:       p.ChamaID,
+		UserID:        p.UserID,
+		PaymentType:   p.Type,
+		PaymentID:     p.PaymentID,
+		Amount:        p.Amount,
+		PaymentMethod: p.Method,
+		Reference:     p.Reference,
+	}
+	rc.StorageKey = "receipts/" + p.ChamaID + "/" + rc.Number + ".html"
+
+	description := "Contribution"
+	if p.Type == PaymentLoanRepayment {
+		description = "Loan repayment"
+	}
+	var html bytes.Buffer
+	err = receiptTemplate.Execute(&html, map[string]string{
+		"ChamaName":   chamaName,
+		"Number":      rc.Number,
+		"Date":        p.PaidAt.Format("02 Jan 2006 15:04"),
+		"MemberName":  memberName,
+		"Description": description,
+		"Method":      p.Method,
+		"Reference":   p.Reference,
+		"Amount":      p.Amount.String(),
+	})
+	if err != nil {
+		return Receipt{}, fmt.Errorf("failed to render receipt: %w", err)
+	}
+	if err := store.Put(ctx, rc.StorageKey, bytes.NewReader(html.Bytes()), int64(html.Len()), "text/html"); err != nil {
+		return Receipt{}, fmt.Errorf("failed to store receipt: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO receipts
+		(id, receipt_number, chama_id, user_id, payment_type, payment_id, amount_minor, currency,
+		 payment_method, transaction_reference, storage_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rc.ID, rc.Number, rc.ChamaID, rc.UserID, rc.PaymentType, rc.PaymentID,
+		rc.Amount.Amount, rc.Amount.Currency, rc.PaymentMethod, rc.Reference, rc.StorageKey,
+	)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("failed to save receipt: %w", err)
+	}
+	if err := txn.Commit(tx); err != nil {
+		return Receipt{}, err
+	}
+	rc.IssuedAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+
+	if notifier != nil {
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    p.UserID,
+			Title:     "Receipt " + rc.Number,
+			Message:   fmt.Sprintf("%s received for %s. Receipt %s is available in the app.", p.Amount, chamaName, rc.Number),
+			Type:      notifications.TypePayment,
+			RelatedID: rc.ID,
+		})
+	}
+	return rc, nil
+}
+
+const receiptColumns = `id, receipt_number, chama_id, user_id, payment_type, payment_id, amount_minor, currency,
+	COALESCE(payment_method, ''), COALESCE(transaction_reference, ''), storage_key, issued_at`
+
+func scanReceipt(row interface{ Scan(...interface{}) error }) (Receipt, error) {
+	var rc Receipt
+	err := row.Scan(&rc.ID, &rc.Number, &rc.ChamaID, &rc.UserID, &rc.PaymentType, &rc.PaymentID,
+		&rc.Amount.Amount, &rc.Amount.Currency, &rc.PaymentMethod, &rc.Reference, &rc.StorageKey, &rc.IssuedAt)
+	return rc, err
+}
+
+func findByPayment(ctx context.Context, db *sql.DB, paymentType, paymentID string) (Receipt, error) {
+	return scanReceipt(txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT `+receiptColumns+` FROM receipts WHERE payment_type = ? AND payment_id = ?`,
+		paymentType, paymentID))
+}
+
+// Get returns a receipt by ID
+func Get(ctx context.Context, db *sql.DB, id string) (Receipt, error) {
+	return scanReceipt(txn.From(ctx, db).QueryRowContext(ctx, `SELECT `+receiptColumns+` FROM receipts WHERE id = ?`, id))
+}
+
+// ListForUser returns a member's receipts, newest first
+func ListForUser(ctx context.Context, db *sql.DB, userID string) ([]Receipt, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT `+receiptColumns+` FROM receipts WHERE user_id = ? ORDER BY issued_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Receipt{}
+	for rows.Next() {
+		rc, err := scanReceipt(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rc)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,69 @@
+package referrals
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/readonly"
+)
+
+// MineHandler returns the caller's referral code, the people they referred
+// and the rewards they have earned
+func MineHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		s, err := Mine(r.Context(), db, userID)
+		if readonly.Refused(w, r, err) {
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// ReportHandler returns the programme's results for platform staff between
+// ?from= and ?to= (dates, inclusive), this month so far by default
+func ReportHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		now := time.Now().UTC()
+		from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+		var err error
+		if v := q.Get("from"); v != "" {
+			if from, err = time.Parse("2006-01-02", v); err != nil {
+				http.Error(w, "from must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if to, err = time.Parse("2006-01-02", v); err != nil {
+				http.Error(w, "to must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+			to = to.AddDate(0, 0, 1)
+		}
+		if !to.After(from) {
+			http.Error(w, "to must not be before from", http.StatusBadRequest)
+			return
+		}
+
+		rep, err := BuildReport(r.Context(), db, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep)
+	}
+}
@@ -0,0 +1,413 @@
+// Package referrals runs the referral programme. Every user has a code they
+// can share; someone who signs up with it is attributed to them, and the
+// referrer earns rewards by Rewards: one for the sign-up and one when the
+// person they referred makes a first contribution of at least
+// MinContribution within WindowDays. Rewards are accrued here and paid out
+// by finance; each keeps the amount it was accrued at.
+package referrals
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+
+	"github.com/google/uuid"
+)
+
+// Referral statuses
+const (
+	StatusSignedUp    = "signed_up"
+	StatusContributed = "contributed"
+)
+
+// Reward kinds
+const (
+	RewardSignUp            = "sign_up"
+	RewardFirstContribution = "first_contribution"
+)
+
+// Rules are how referrers are rewarded, in minor units of Currency. A zero
+// reward is not accrued.
+type Rules struct {
+	Currency          string `json:"currency"`
+	SignUp            int64  `json:"signUp"`
+	FirstContribution int64  `json:"firstContribution"`
+	MinContribution   int64  `json:"minContribution"` // the first contribution must be at least this
+	WindowDays        int    `json:"windowDays"`      // and made this many days after signing up at most
+	MaxPerReferrer    int    `json:"maxPerReferrer"`  // rewards one referrer can accrue; 0 for no limit
+}
+
+// Rewards are the current rules
+var Rewards = Rules{Currency: "KES", FirstContribution: 10000, MinContribution: 50000, WindowDays: 90, MaxPerReferrer: 50}
+
+// ErrUnknownCode is returned for a referral code no user has
+var ErrUnknownCode = errors.New("referral code not found")
+
+// codeAlphabet avoids characters that are easily confused when read aloud or typed
+const codeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// codeLength is the length of referral codes
+const codeLength = 6
+
+// Referral is a sign-up attributed to a referrer
+type Referral struct {
+	ID                  string     `json:"id"`
+	ReferrerID          string     `json:"referrerId"`
+	ReferredID          string     `json:"referredId"`
+	ReferredName        string     `json:"referredName"`
+	Code                string     `json:"code"`
+	Status              string     `json:"status"`
+	SignedUpAt          time.Time  `json:"signedUpAt"`
+	FirstContributionAt *time.Time `json:"firstContributionAt,omitempty"`
+}
+
+// Reward is a reward accrued to a referrer
+type Reward struct {
+	ID         string      `json:"id"`
+	ReferralID string      `json:"referralId"`
+	ReferrerID string      `json:"referrerId"`
+	Kind       string      `json:"kind"`
+	Amount     money.Money `json:"amount"`
+	AccruedAt  time.Time   `json:"accruedAt"`
+}
+
+// Code returns userID's referral code, creating it the first time
+func Code(ctx context.Context, db *sql.DB, userID string) (string, error) {
+	var code string
+	err := db.QueryRowContext(ctx, `SELECT code FROM referral_codes WHERE user_id = ?`, userID).Scan(&code)
+	if err != sql.ErrNoRows {
+		return code, err
+	}
+	for attempt := 0; ; attempt++ {
+		if code, err = generateCode(); err != nil {
+			return "", err
+		}
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO referral_codes (user_id, code) VALUES (?, ?) ON CONFLICT (user_id) DO NOTHING`, userID, code)
+		if err == nil {
+			break
+		}
+		// Retry on the rare code collision
+		if attempt >= 3 || !strings.Contains(err.Error(), "UNIQUE") {
+			return "", fmt.Errorf("failed to create referral code: %w", err)
+		}
+	}
+	// A concurrent request may have created the code first
+	err = db.QueryRowContext(ctx, `SELECT code FROM referral_codes WHERE user_id = ?`, userID).Scan(&code)
+	return code, err
+}
+
+// Lookup returns the user whose referral code is code. Codes are case-insensitive.
+func Lookup(ctx context.Context, db *sql.DB, code string) (string, error) {
+	var userID string
+	err := db.QueryRowContext(ctx, `
+		SELECT r.user_id FROM referral_codes r JOIN users u ON u.user_id = r.user_id
+		WHERE r.code = ? AND u.suspended_at IS NULL`,
+		strings.ToUpper(strings.TrimSpace(code))).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrUnknownCode
+	}
+	return userID, err
+}
+
+// Attribute records that userID signed up with code and accrues the
+// referrer's sign-up reward. A user is only ever attributed once.
+func Attribute(ctx context.Context, db *sql.DB, code, userID string) error {
+	referrerID, err := Lookup(ctx, db, code)
+	if err != nil {
+		return err
+	}
+	if referrerID == userID {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id := uuid.NewString()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO referrals (id, referrer_id, referred_id, code) VALUES (?, ?, ?, ?)
+		ON CONFLICT (referred_id) DO NOTHING`,
+		id, referrerID, userID, strings.ToUpper(strings.TrimSpace(code)))
+	if err != nil {
+		return fmt.Errorf("failed to record referral: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	if _, err := accrue(ctx, tx, id, referrerID, RewardSignUp, Rewards.SignUp); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// accrue records a reward of amount for the referral, unless it was accrued
+// before, is zero, or the referrer has reached MaxPerReferrer. It reports
+// whether a reward was accrued.
+func accrue(ctx context.Context, tx *sql.Tx, referralID, referrerID, kind string, amount int64) (bool, error) {
+	if amount <= 0 {
+		return false, nil
+	}
+	if Rewards.MaxPerReferrer > 0 {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM referral_rewards WHERE referrer_id = ?`,
+			referrerID).Scan(&n); err != nil {
+			return false, err
+		}
+		if n >= Rewards.MaxPerReferrer {
+			return false, nil
+		}
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO referral_rewards (id, referral_id, referrer_id, kind, amount_minor, currency)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (referral_id, kind) DO NOTHING`,
+		uuid.NewString(), referralID, referrerID, kind, amount, Rewards.Currency)
+	if err != nil {
+		return false, fmt.Errorf("failed to accrue referral reward: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Track records the first completed contribution of everyone referred who
+// has not made one yet, and accrues their referrers' rewards. It returns the
+// rewards accrued.
+func Track(ctx context.Context, db *sql.DB) ([]Reward, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT r.id, r.referrer_id, r.signed_up_at, c.id, c.amount_minor, c.currency, c.created_at
+		FROM referrals r
+		JOIN contributions c ON c.id = (
+			SELECT id FROM contributions WHERE member_id = r.referred_id AND status = 'completed'
+			ORDER BY created_at, id LIMIT 1)
+		WHERE r.first_contribution_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	type first struct {
+		referralID, referrerID, contributionID string
+		signedUp, at                           time.Time
+		amount                                 money.Money
+	}
+	var found []first
+	for rows.Next() {
+		var f first
+		if err := rows.Scan(&f.referralID, &f.referrerID, &f.signedUp, &f.contributionID, &f.amount.Amount,
+			&f.amount.Currency, &f.at); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		found = append(found, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var accrued []Reward
+	for _, f := range found {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return accrued, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE referrals SET status = ?, first_contribution_id = ?, first_contribution_at = ?
+			WHERE id = ? AND first_contribution_at IS NULL`,
+			StatusContributed, f.contributionID, f.at.UTC().Format("2006-01-02 15:04:05"), f.referralID)
+		if err != nil {
+			tx.Rollback()
+			return accrued, err
+		}
+		ok := false
+		if f.amount.Amount >= Rewards.MinContribution &&
+			(Rewards.WindowDays <= 0 || !f.at.After(f.signedUp.AddDate(0, 0, Rewards.WindowDays))) {
+			ok, err = accrue(ctx, tx, f.referralID, f.referrerID, RewardFirstContribution, Rewards.FirstContribution)
+			if err != nil {
+				tx.Rollback()
+				return accrued, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return accrued, err
+		}
+		if ok {
+			accrued = append(accrued, Reward{
+				ReferralID: f.referralID, ReferrerID: f.referrerID, Kind: RewardFirstContribution,
+				Amount: money.New(Rewards.FirstContribution, Rewards.Currency), AccruedAt: time.Now().UTC(),
+			})
+		}
+	}
+	return accrued, nil
+}
+
+// Summary is a user's referral code and how their referrals are doing
+type Summary struct {
+	Code      string      `json:"code"`
+	Referrals []Referral  `json:"referrals"`
+	Rewards   []Reward    `json:"rewards"`
+	Earned    money.Money `json:"earned"`
+}
+
+// Mine returns userID's referral code, the people they referred and the
+// rewards they have accrued
+func Mine(ctx context.Context, db *sql.DB, userID string) (Summary, error) {
+	s := Summary{Referrals: []Referral{}, Rewards: []Reward{}, Earned: money.New(0, Rewards.Currency)}
+	var err error
+	if s.Code, err = Code(ctx, db, userID); err != nil {
+		return s, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT r.id, r.referrer_id, r.referred_id,
+		       COALESCE(NULLIF(TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')), ''), u.username, ''),
+		       r.code, r.status, r.signed_up_at, r.first_contribution_at
+		FROM referrals r LEFT JOIN users u ON u.user_id = r.referred_id
+		WHERE r.referrer_id = ? ORDER BY r.signed_up_at DESC`, userID)
+	if err != nil {
+		return s, err
+	}
+	for rows.Next() {
+		var r Referral
+		if err := rows.Scan(&r.ID, &r.ReferrerID, &r.ReferredID, &r.ReferredName, &r.Code, &r.Status, &r.SignedUpAt,
+			&r.FirstContributionAt); err != nil {
+			rows.Close()
+			return s, err
+		}
+		s.Referrals = append(s.Referrals, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT id, referral_id, referrer_id, kind, amount_minor, currency, accrued_at
+		FROM referral_rewards WHERE referrer_id = ? ORDER BY accrued_at DESC`, userID)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r Reward
+		if err := rows.Scan(&r.ID, &r.ReferralID, &r.ReferrerID, &r.Kind, &r.Amount.Amount, &r.Amount.Currency,
+			&r.AccruedAt); err != nil {
+			return s, err
+		}
+		s.Rewards = append(s.Rewards, r)
+		if r.Amount.Currency == s.Earned.Currency {
+			s.Earned.Amount += r.Amount.Amount
+		}
+	}
+	return s, rows.Err()
+}
+
+// ReferrerStats is one referrer's results over a report's period
+type ReferrerStats struct {
+	ReferrerID         string      `json:"referrerId"`
+	Name               string      `json:"name"`
+	SignUps            int         `json:"signUps"`
+	FirstContributions int         `json:"firstContributions"`
+	Rewards            money.Money `json:"rewards"`
+}
+
+// Report is the referral programme's results over a period
+type Report struct {
+	From               time.Time       `json:"from"`
+	To                 time.Time       `json:"to"`
+	SignUps            int             `json:"signUps"`
+	FirstContributions int             `json:"firstContributions"`
+	Rewards            money.Money     `json:"rewards"`
+	Referrers          []ReferrerStats `json:"referrers"`
+}
+
+// BuildReport summarises sign-ups, first contributions and rewards accrued
+// in [from, to), by referrer, most sign-ups first
+func BuildReport(ctx context.Context, db *sql.DB, from, to time.Time) (Report, error) {
+	rep := Report{From: from, To: to, Rewards: money.New(0, Rewards.Currency), Referrers: []ReferrerStats{}}
+	f, t := from.UTC().Format("2006-01-02 15:04:05"), to.UTC().Format("2006-01-02 15:04:05")
+	rows, err := db.QueryContext(ctx, `
+		SELECT x.referrer_id,
+		       COALESCE(NULLIF(TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')), ''), u.username, ''),
+		       SUM(x.sign_ups), SUM(x.contributions), SUM(x.rewards)
+		FROM (
+			SELECT referrer_id, 1 AS sign_ups, 0 AS contributions, 0 AS rewards FROM referrals
+			WHERE signed_up_at >= ? AND signed_up_at < ?
+			UNION ALL
+			SELECT referrer_id, 0, 1, 0 FROM referrals
+			WHERE first_contribution_at >= ? AND first_contribution_at < ?
+			UNION ALL
+			SELECT referrer_id, 0, 0, amount_minor FROM referral_rewards
+			WHERE currency = ? AND accrued_at >= ? AND accrued_at < ?
+		) x LEFT JOIN users u ON u.user_id = x.referrer_id
+		GROUP BY x.referrer_id, u.first_name, u.last_name, u.username
+		ORDER BY 3 DESC, 4 DESC, x.referrer_id`,
+		f, t, f, t, Rewards.Currency, f, t)
+	if err != nil {
+		return rep, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := ReferrerStats{Rewards: money.New(0, Rewards.Currency)}
+		if err := rows.Scan(&s.ReferrerID, &s.Name, &s.SignUps, &s.FirstContributions, &s.Rewards.Amount); err != nil {
+			return rep, err
+		}
+		rep.SignUps += s.SignUps
+		rep.FirstContributions += s.FirstContributions
+		rep.Rewards.Amount += s.Rewards.Amount
+		rep.Referrers = append(rep.Referrers, s)
+	}
+	return rep, rows.Err()
+}
+
+// RegisterTrackingJob looks for first contributions by referred users each
+// hour and tells referrers about the rewards they earn
+func RegisterTrackingJob(s *jobs.Scheduler, db *sql.DB, notifier *notifications.Notifier) {
+	s.Register("referral_tracking", jobs.Every(time.Hour), func(ctx context.Context) error {
+		accrued, err := Track(ctx, db)
+		for _, r := range accrued {
+			notify(ctx, notifier, r)
+		}
+		return err
+	})
+}
+
+func notify(ctx context.Context, notifier *notifications.Notifier, r Reward) {
+	if notifier == nil {
+		return
+	}
+	if err := notifier.Notify(ctx, notifications.Notification{
+		UserID:    r.ReferrerID,
+		Title:     i18n.T(i18n.Default, "referral.reward", nil),
+		Message:   i18n.T(i18n.Default, "notification.referral_reward", map[string]string{"amount": r.Amount.String()}),
+		Type:      notifications.TypeSystem,
+		RelatedID: r.ReferralID,
+	}); err != nil {
+		slog.ErrorContext(ctx, "Failed to notify referrer", "referrer_id", r.ReferrerID, "error", err)
+	}
+}
+
+func generateCode() (string, error) {
+	b := make([]byte, codeLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = codeAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
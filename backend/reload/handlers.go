@@ -0,0 +1,30 @@
+package reload
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"tujifund-app/backend/audit"
+)
+
+// Handler reloads the configuration, as SIGHUP does, and returns the
+// Report. The reload is audited with the names of the changed variables.
+func Handler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := Run(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		entry := audit.FromRequest(r, audit.Entry{
+			Action: "config.reload", EntityType: "config", EntityID: report.File, NewValues: report,
+		})
+		if err := audit.Record(r.Context(), db, entry); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
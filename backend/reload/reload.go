@@ -0,0 +1,193 @@
+// Package reload applies configuration changes without a restart. Settings
+// are read from the environment, optionally overlaid with an env file named
+// by CONFIG_FILE. On SIGHUP, or from the admin endpoint, the file is read
+// again and every registered reloader re-reads the settings it owns: the
+// log level, rate limits, feature flags and provider credentials. The
+// server keeps running throughout, so in-flight requests and payment
+// callbacks are not dropped. Structural settings such as the database,
+// storage backend and listen address still need a restart.
+package reload
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Result is the outcome of one reloader
+type Result struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// Report describes a reload
+type Report struct {
+	File     string    `json:"file,omitempty"`
+	Changed  []string  `json:"changed"` // names of the variables the file changed; values are not reported
+	Results  []Result  `json:"results"`
+	Reloaded time.Time `json:"reloadedAt"`
+}
+
+type reloader struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+var (
+	mu        sync.Mutex
+	reloaders []reloader
+	// fromFile holds the values the env file set, and original what each of
+	// those variables was before, so a variable removed from the file gets
+	// its old value back
+	fromFile = map[string]string{}
+	original = map[string]*string{}
+)
+
+// Register adds a reloader, run in registration order on every reload. A
+// reloader that fails should keep its current settings.
+func Register(name string, fn func(ctx context.Context) error) {
+	mu.Lock()
+	reloaders = append(reloaders, reloader{name: name, fn: fn})
+	mu.Unlock()
+}
+
+// File returns the env file named by CONFIG_FILE, or "" if there is none
+func File() string {
+	return os.Getenv("CONFIG_FILE")
+}
+
+// Load applies the env file, if there is one, over the environment. It is
+// called at startup before any setting is read.
+func Load() error {
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := apply(File())
+	return err
+}
+
+// Run reads the env file again and runs every reloader
+func Run(ctx context.Context) (Report, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	report := Report{File: File(), Changed: []string{}, Results: []Result{}, Reloaded: time.Now().UTC()}
+	changed, err := apply(report.File)
+	if err != nil {
+		return report, err
+	}
+	report.Changed = changed
+	for _, r := range reloaders {
+		res := Result{Name: r.name}
+		if err := r.fn(ctx); err != nil {
+			res.Error = err.Error()
+			slog.ErrorContext(ctx, "Failed to reload configuration", "component", r.name, "error", err)
+		}
+		report.Results = append(report.Results, res)
+	}
+	slog.InfoContext(ctx, "Configuration reloaded", "file", report.File, "changed", report.Changed)
+	return report, nil
+}
+
+// Watch reloads on every SIGHUP until ctx is done
+func Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if _, err := Run(ctx); err != nil {
+					slog.ErrorContext(ctx, "Failed to reload configuration", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// apply sets the variables in the env file at path and restores those the
+// file no longer sets, returning the names of the variables that changed.
+// An unreadable file changes nothing.
+func apply(path string) ([]string, error) {
+	values := map[string]string{}
+	if path != "" {
+		var err error
+		if values, err = parse(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var changed []string
+	for key, value := range values {
+		if _, seen := original[key]; !seen {
+			if old, ok := os.LookupEnv(key); ok {
+				original[key] = &old
+			} else {
+				original[key] = nil
+			}
+		}
+		if current, ok := os.LookupEnv(key); !ok || current != value {
+			changed = append(changed, key)
+		}
+		os.Setenv(key, value)
+	}
+	for key := range fromFile {
+		if _, still := values[key]; still {
+			continue
+		}
+		if old := original[key]; old != nil {
+			os.Setenv(key, *old)
+		} else {
+			os.Unsetenv(key)
+		}
+		delete(original, key)
+		changed = append(changed, key)
+	}
+	fromFile = values
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// parse reads KEY=VALUE lines. Blank lines and lines starting with # are
+// skipped, an "export " prefix is allowed, and values may be quoted.
+func parse(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return values, nil
+}
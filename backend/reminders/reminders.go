@@ -0,0 +1,416 @@
+// Package reminders reminds members of contributions on a schedule each
+// chama sets, as days before or after the due date, and tells the chama's
+// officials when a member has missed several cycles in a row. Each reminder
+// and escalation is sent once, recorded and written to the audit log.
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/dashboard"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/rules"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of reminder
+const (
+	KindReminder   = "reminder"
+	KindEscalation = "escalation"
+)
+
+// Limits on a schedule
+const (
+	MaxOffsets    = 10
+	MaxOffsetDays = 30
+)
+
+// Schedule is when a chama's members are reminded to contribute
+type Schedule struct {
+	ChamaID string `json:"chamaId"`
+	// Offsets are days relative to the due date: -3 is three days before,
+	// 0 the due date and 2 two days after
+	Offsets []int `json:"offsets"`
+	// EscalateAfter is how many cycles in a row a member misses before the
+	// officials are told; 0 turns escalation off
+	EscalateAfter int    `json:"escalateAfter"`
+	Enabled       bool   `json:"enabled"`
+	UpdatedBy     string `json:"updatedBy,omitempty"`
+	UpdatedAt     string `json:"updatedAt,omitempty"`
+}
+
+// DefaultSchedule applies to chamas that have not set their own
+func DefaultSchedule(chamaID string) Schedule {
+	return Schedule{ChamaID: chamaID, Offsets: []int{-3, 0, 2}, EscalateAfter: 2, Enabled: true}
+}
+
+// Sent is a reminder or escalation that has been sent
+type Sent struct {
+	ID         string    `json:"id"`
+	ChamaID    string    `json:"chamaId"`
+	MemberID   string    `json:"memberId"`
+	Kind       string    `json:"kind"`
+	CycleDue   string    `json:"cycleDue"`
+	OffsetDays int       `json:"offsetDays"`
+	Recipients int       `json:"recipients"`
+	SentAt     time.Time `json:"sentAt"`
+}
+
+var ErrInvalidSchedule = errors.New("offsets must be between -30 and 30 days, at most 10 of them")
+
+// Load returns the chama's schedule, or the default if it has not set one
+func Load(ctx context.Context, db *sql.DB, chamaID string) (Schedule, error) {
+	s := Schedule{ChamaID: chamaID}
+	var offsets string
+	var updatedBy sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT offsets, escalate_after, enabled, updated_by, updated_at FROM reminder_schedules WHERE chama_id = ?`,
+		chamaID).Scan(&offsets, &s.EscalateAfter, &s.Enabled, &updatedBy, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return DefaultSchedule(chamaID), nil
+	}
+	if err != nil {
+		return s, err
+	}
+	s.UpdatedBy = updatedBy.String
+	s.Offsets = []int{}
+	for _, f := range strings.Split(offsets, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(f)); err == nil {
+			s.Offsets = append(s.Offsets, n)
+		}
+	}
+	return s, nil
+}
+
+// Save replaces the chama's schedule, recording the change in the audit log
+func Save(ctx context.Context, db *sql.DB, s Schedule, entry audit.Entry) (Schedule, error) {
+	if len(s.Offsets) > MaxOffsets {
+		return s, ErrInvalidSchedule
+	}
+	seen := map[int]bool{}
+	offsets := []int{}
+	for _, o := range s.Offsets {
+		if o < -MaxOffsetDays || o > MaxOffsetDays {
+			return s, ErrInvalidSchedule
+		}
+		if !seen[o] {
+			seen[o] = true
+			offsets = append(offsets, o)
+		}
+	}
+	sort.Ints(offsets)
+	s.Offsets = offsets
+	if s.EscalateAfter < 0 {
+		s.EscalateAfter = 0
+	}
+
+	old, err := Load(ctx, db, s.ChamaID)
+	if err != nil {
+		return s, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return s, err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO reminder_schedules (chama_id, offsets, escalate_after, enabled, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (chama_id) DO UPDATE SET offsets = excluded.offsets, escalate_after = excluded.escalate_after,
+			enabled = excluded.enabled, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		s.ChamaID, joinInts(s.Offsets), s.EscalateAfter, s.Enabled, s.UpdatedBy)
+	if err != nil {
+		return s, err
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "reminder_schedule.update", "chama", s.ChamaID
+	entry.OldValues, entry.NewValues = old, s
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return s, err
+	}
+	if err := tx.Commit(); err != nil {
+		return s, err
+	}
+	return Load(ctx, db, s.ChamaID)
+}
+
+// History returns the reminders and escalations sent to the chama's
+// members, newest first
+func History(ctx context.Context, db *sql.DB, chamaID string, limit int) ([]Sent, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, chama_id, member_id, kind, cycle_due, offset_days, recipients, sent_at
+		FROM contribution_reminders WHERE chama_id = ? ORDER BY sent_at DESC LIMIT ?`, chamaID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Sent{}
+	for rows.Next() {
+		var s Sent
+		if err := rows.Scan(&s.ID, &s.ChamaID, &s.MemberID, &s.Kind, &s.CycleDue, &s.OffsetDays, &s.Recipients, &s.SentAt); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// Run sends the chama's reminders and escalations due on now's date. It is
+// safe to run more than once a day; nothing is sent twice.
+func Run(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, chamaID string, now time.Time) (int, error) {
+	s, err := Load(ctx, db, chamaID)
+	if err != nil || !s.Enabled {
+		return 0, err
+	}
+	r, err := rules.Current(ctx, db, chamaID)
+	if err != nil {
+		return 0, err
+	}
+	if r.ContributionAmountMinor <= 0 {
+		return 0, nil
+	}
+	var chamaName, currency string
+	err = db.QueryRowContext(ctx, `SELECT name, currency FROM chamas WHERE id = ?`, chamaID).Scan(&chamaName, &currency)
+	if err != nil {
+		return 0, err
+	}
+	if err := dashboard.Ensure(ctx, db, chamaID); err != nil {
+		return 0, err
+	}
+
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	sent := 0
+	for _, offset := range s.Offsets {
+		due := today.AddDate(0, 0, -offset)
+		c := dashboard.CycleAt(r, due)
+		if !c.Due.Equal(due) {
+			continue
+		}
+		short, err := shortfalls(ctx, db, chamaID, r, c)
+		if err != nil {
+			return sent, err
+		}
+		for memberID, owed := range short {
+			params := map[string]string{
+				"chama":  chamaName,
+				"amount": money.New(owed, currency).String(),
+				"due":    c.Due.Format("2 Jan 2006"),
+				"days":   strconv.Itoa(abs(offset)),
+			}
+			key := "notification.reminder_due"
+			if offset < 0 {
+				key = "notification.reminder_before"
+			} else if offset > 0 {
+				key = "notification.reminder_after"
+			}
+			ok, err := send(ctx, db, notifier, chamaID, memberID, KindReminder, c, offset, []string{memberID},
+				"reminder.title", key, params)
+			if err != nil {
+				return sent, err
+			}
+			if ok {
+				sent++
+			}
+		}
+	}
+
+	if s.EscalateAfter > 0 {
+		n, err := escalate(ctx, db, notifier, chamaID, chamaName, currency, r, s.EscalateAfter, now)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// escalate tells the officials about members who have missed the last
+// after closed cycles, once per cycle
+func escalate(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, chamaID, chamaName, currency string, r rules.Rules, after int, now time.Time) (int, error) {
+	cycles := []dashboard.Cycle{dashboard.LastClosedCycle(r, now)}
+	for len(cycles) < after {
+		cycles = append(cycles, dashboard.CycleAt(r, cycles[len(cycles)-1].Start.AddDate(0, 0, -1)))
+	}
+	var missed map[string]int64
+	for i, c := range cycles {
+		short, err := shortfalls(ctx, db, chamaID, r, c)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 {
+			missed = short
+			continue
+		}
+		for memberID := range missed {
+			if _, ok := short[memberID]; !ok {
+				delete(missed, memberID)
+			}
+		}
+	}
+	if len(missed) == 0 {
+		return 0, nil
+	}
+	officials, err := chamas.MembersWithRole(db, chamaID, chamas.OfficialRoles...)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for memberID, owed := range missed {
+		var member string
+		db.QueryRowContext(ctx, `
+			SELECT COALESCE(NULLIF(TRIM(COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')), ''), username)
+			FROM users WHERE user_id = ?`, memberID).Scan(&member)
+		params := map[string]string{
+			"member": member,
+			"chama":  chamaName,
+			"misses": strconv.Itoa(after),
+			"amount": money.New(owed, currency).String(),
+			"due":    cycles[0].Due.Format("2 Jan 2006"),
+		}
+		ok, err := send(ctx, db, notifier, chamaID, memberID, KindEscalation, cycles[0], 0, officials,
+			"reminder.escalation", "notification.reminder_escalation", params)
+		if err != nil {
+			return sent, err
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// shortfalls returns how much each active member who joined by the cycle's
+// due date is short of the expected contribution in cycle c
+func shortfalls(ctx context.Context, db *sql.DB, chamaID string, r rules.Rules, c dashboard.Cycle) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.user_id, COALESCE(SUM(d.amount_minor), 0)
+		FROM chama_members m
+		LEFT JOIN contribution_days d ON d.chama_id = m.chama_id AND d.member_id = m.user_id AND d.day >= ? AND d.day < ?
+		WHERE m.chama_id = ? AND m.status = 'active' AND m.join_date <= ?
+		GROUP BY m.user_id`,
+		c.Start.Format("2006-01-02"), c.End.Format("2006-01-02"), chamaID, c.Due.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	short := map[string]int64{}
+	for rows.Next() {
+		var memberID string
+		var paid int64
+		if err := rows.Scan(&memberID, &paid); err != nil {
+			return nil, err
+		}
+		if paid < r.ContributionAmountMinor {
+			short[memberID] = r.ContributionAmountMinor - paid
+		}
+	}
+	return short, rows.Err()
+}
+
+// send records and audits a reminder about memberID for cycle c, then
+// notifies recipients. It returns false if the reminder was sent before.
+func send(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, chamaID, memberID, kind string, c dashboard.Cycle, offset int, recipients []string, titleKey, messageKey string, params map[string]string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	id := uuid.NewString()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO contribution_reminders (id, chama_id, member_id, kind, cycle_due, offset_days, recipients)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chama_id, member_id, kind, cycle_due, offset_days) DO NOTHING`,
+		id, chamaID, memberID, kind, c.Due.Format("2006-01-02"), offset, len(recipients))
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	err = audit.Record(ctx, tx, audit.Entry{
+		Action: "contribution." + kind, EntityType: "contribution_reminder", EntityID: id,
+		NewValues: map[string]interface{}{
+			"chamaId": chamaID, "memberId": memberID, "cycleDue": c.Due.Format("2006-01-02"),
+			"offsetDays": offset, "recipients": recipients,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	if notifier != nil {
+		for _, userID := range recipients {
+			err := notifier.Notify(ctx, notifications.Notification{
+				UserID:    userID,
+				Title:     i18n.T(i18n.Default, titleKey, nil),
+				Message:   i18n.T(i18n.Default, messageKey, params),
+				Type:      notifications.TypePayment,
+				RelatedID: chamaID,
+			})
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to send contribution reminder", "chama_id", chamaID, "user_id", userID, "error", err)
+			}
+		}
+	}
+	return true, nil
+}
+
+// RegisterJob sends the day's reminders for every active chama each morning
+func RegisterJob(s *jobs.Scheduler, db *sql.DB, notifier *notifications.Notifier) {
+	s.Register("contribution_reminders", jobs.Daily{Hour: 8}, func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `SELECT id FROM chamas WHERE status = 'active'`)
+		if err != nil {
+			return err
+		}
+		var chamaIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			chamaIDs = append(chamaIDs, id)
+		}
+		rows.Close()
+
+		now := time.Now()
+		for _, id := range chamaIDs {
+			if _, err := Run(ctx, db, notifier, id, now); err != nil {
+				slog.ErrorContext(ctx, "Failed to send contribution reminders", "chama_id", id, "error", err)
+			}
+		}
+		return nil
+	})
+}
+
+func joinInts(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
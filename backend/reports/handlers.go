@@ -0,0 +1,182 @@
+package reports
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/cache"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+
+	"github.com/gorilla/mux"
+)
+
+// parsePeriod reads ?from=YYYY-MM-DD&to=YYYY-MM-DD (inclusive), defaulting to the previous month
+func parsePeriod(r *http.Request) (Period, error) {
+	q := r.URL.Query()
+	if q.Get("from") == "" && q.Get("to") == "" {
+		return Month(time.Now().UTC().AddDate(0, -1, 0)), nil
+	}
+	from, err := time.Parse("2006-01-02", q.Get("from"))
+	if err != nil {
+		return Period{}, err
+	}
+	to, err := time.Parse("2006-01-02", q.Get("to"))
+	if err != nil {
+		return Period{}, err
+	}
+	return Period{From: from, To: to.AddDate(0, 0, 1)}, nil
+}
+
+// CacheTTL bounds how stale a cached report can be. Ledger postings
+// invalidate the chama's reports straight away; the TTL covers other edits
+// such as renaming the chama or a member.
+const CacheTTL = 10 * time.Minute
+
+// periodKey identifies p within a cache key
+func periodKey(p Period) string {
+	return p.From.Format("20060102") + "-" + p.To.Format("20060102")
+}
+
+// MemberStatementHandler returns a member statement as JSON, PDF or XLSX (?format=).
+// Members can fetch their own statement; officials can fetch any member's.
+func MemberStatementHandler(db *sql.DB, c cache.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		vars := mux.Vars(r)
+		chamaID, memberID := vars["chamaId"], vars["userId"]
+		if memberID != userID && !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		period, err := parsePeriod(r)
+		if err != nil {
+			http.Error(w, "from and to must be dates in the format YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		key := cache.ChamaKey(r.Context(), c, chamaID, "statement:"+memberID+":"+periodKey(period))
+		s, err := cache.Fetch(r.Context(), c, key, CacheTTL, func() (*MemberStatement, error) {
+			return BuildMemberStatement(r.Context(), db, chamaID, memberID, period)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		lang := i18n.FromRequest(r)
+		switch r.URL.Query().Get("format") {
+		case "pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="statement.pdf"`)
+			WriteStatementPDF(w, s, lang)
+		case "xlsx":
+			w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			w.Header().Set("Content-Disposition", `attachment; filename="statement.xlsx"`)
+			WriteStatementXLSX(w, s, lang)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s)
+		}
+	}
+}
+
+// ChamaReportHandler returns the chama income statement and balance sheet as
+// JSON, PDF or XLSX (?format=). Only officials can view it.
+func ChamaReportHandler(db *sql.DB, c cache.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		period, err := parsePeriod(r)
+		if err != nil {
+			http.Error(w, "from and to must be dates in the format YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		key := cache.ChamaKey(r.Context(), c, chamaID, "report:"+periodKey(period))
+		rep, err := cache.Fetch(r.Context(), c, key, CacheTTL, func() (*ChamaReport, error) {
+			return BuildChamaReport(r.Context(), db, chamaID, period)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		lang := i18n.FromRequest(r)
+		switch r.URL.Query().Get("format") {
+		case "pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="financial-report.pdf"`)
+			WriteChamaReportPDF(w, rep, lang)
+		case "xlsx":
+			w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			w.Header().Set("Content-Disposition", `attachment; filename="financial-report.xlsx"`)
+			WriteChamaReportXLSX(w, rep, lang)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rep)
+		}
+	}
+}
+
+// AccountStatementHandler returns the chama's statement of account, for one
+// account with ?accountId= or for all of them, as JSON, PDF or XLSX
+// (?format=). Only officials can view it.
+func AccountStatementHandler(db *sql.DB, c cache.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		period, err := parsePeriod(r)
+		if err != nil {
+			http.Error(w, "from and to must be dates in the format YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		accountID := r.URL.Query().Get("accountId")
+		key := cache.ChamaKey(r.Context(), c, chamaID, "account-statement:"+accountID+":"+periodKey(period))
+		s, err := cache.Fetch(r.Context(), c, key, CacheTTL, func() (*AccountStatement, error) {
+			return BuildAccountStatement(r.Context(), db, chamaID, accountID, period)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		lang := i18n.FromRequest(r)
+		switch r.URL.Query().Get("format") {
+		case "pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="statement-of-account.pdf"`)
+			WriteAccountStatementPDF(w, s, lang)
+		case "xlsx":
+			w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			w.Header().Set("Content-Disposition", `attachment; filename="statement-of-account.xlsx"`)
+			WriteAccountStatementXLSX(w, s, lang)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// RegisterMonthlyJob schedules statements and financial reports for the
+// previous month to be generated on the 1st of every month
+func RegisterMonthlyJob(s *jobs.Scheduler, db *sql.DB, store storage.Backend, notifier *notifications.Notifier) {
+	s.Register("monthly_statements", jobs.Monthly{Day: 1, Hour: 2}, func(ctx context.Context) error {
+		return GenerateMonthly(ctx, db, store, notifier, Month(time.Now().UTC().AddDate(0, -1, 0)))
+	})
+}
+
+// GenerateMonthly stores a PDF statement for every active member and a PDF
+// financial report for every chama, then notifies members
+func GenerateMonthly(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, p Period) error {
+	return generate(ctx, db, store, notifier, "", p, p.From.Format("2006-01"), p.From.Format("January 2006"))
+}
+
+// GenerateYear stores the chama's financial report and a PDF statement for
+// every active member for a closed financial year, filed under key, then
+// notifies members
+func GenerateYear(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, chamaID string, p Period, key string) error {
+	from, to := p.Label()
+	return generate(ctx, db, store, notifier, chamaID, p, key, from+" - "+to)
+}
+
+// generate stores the reports and statements for p for one chama, or every
+// chama when chamaID is empty. label names the period in notifications.
+func generate(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, chamaID string, p Period, key, label string) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.chama_id, m.user_id, m.role FROM chama_members m
+		JOIN chamas c ON c.id = m.chama_id
+		WHERE m.status = 'active' AND (? = '' OR m.chama_id = ?) ORDER BY m.chama_id`, chamaID, chamaID)
+	if err != nil {
+		return err
+	}
+	type membership struct{ chamaID, userID, role string }
+	var members []membership
+	for rows.Next() {
+		var m membership
+		if err := rows.Scan(&m.chamaID, &m.userID, &m.role); err != nil {
+			rows.Close()
+			return err
+		}
+		members = append(members, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	done := map[string]bool{}
+	for _, m := range members {
+		if !done[m.chamaID] {
+			done[m.chamaID] = true
+			if err := storeChamaReport(ctx, db, store, m.chamaID, p, key); err != nil {
+				slog.ErrorContext(ctx, "Failed to generate chama report", "chama_id", m.chamaID, "error", err)
+			}
+		}
+
+		s, err := BuildMemberStatement(ctx, db, m.chamaID, m.userID, p)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to build statement", "chama_id", m.chamaID, "user_id", m.userID, "error", err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := WriteStatementPDF(&buf, s, i18n.Default); err != nil {
+			return err
+		}
+		path := fmt.Sprintf("statements/%s/%s/%s.pdf", m.chamaID, key, m.userID)
+		if err := save(ctx, db, store, m.chamaID, m.userID, "member_statement", key, path, &buf); err != nil {
+			slog.ErrorContext(ctx, "Failed to store statement", "chama_id", m.chamaID, "user_id", m.userID, "error", err)
+			continue
+		}
+
+		if notifier != nil {
+			notifier.Notify(ctx, notifications.Notification{
+				UserID: m.userID,
+				Title:  i18n.T(i18n.Default, "statement.title", nil),
+				Message: i18n.T(i18n.Default, "notification.statement_ready", map[string]string{
+					"name": s.MemberName, "chama": s.ChamaName, "period": label,
+				}),
+				Type:      notifications.TypeChama,
+				RelatedID: m.chamaID,
+			})
+		}
+	}
+	return nil
+}
+
+func storeChamaReport(ctx context.Context, db *sql.DB, store storage.Backend, chamaID string, p Period, key string) error {
+	rep, err := BuildChamaReport(ctx, db, chamaID, p)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := WriteChamaReportPDF(&buf, rep, i18n.Default); err != nil {
+		return err
+	}
+	path := fmt.Sprintf("reports/%s/%s/financial.pdf", chamaID, key)
+	return save(ctx, db, store, chamaID, "", "chama_financial", key, path, &buf)
+}
+
+func save(ctx context.Context, db *sql.DB, store storage.Backend, chamaID, userID, reportType, period, path string, buf *bytes.Buffer) error {
+	size := int64(buf.Len())
+	if err := store.Put(ctx, path, buf, size, "application/pdf"); err != nil {
+		return err
+	}
+	var user interface{}
+	if userID != "" {
+		user = userID
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO reports (id, chama_id, user_id, report_type, period, format, storage_key)
+		VALUES (?, ?, ?, ?, ?, 'pdf', ?)
+		ON CONFLICT(chama_id, COALESCE(user_id, ''), report_type, period, format) DO UPDATE SET
+			storage_key = excluded.storage_key, created_at = CURRENT_TIMESTAMP`,
+		uuid.NewString(), chamaID, user, reportType, period, path,
+	)
+	return err
+}
@@ -0,0 +1,233 @@
+package reports
+
+import (
+	"io"
+
+	"tujifund-app/backend/export/pdf"
+	"tujifund-app/backend/export/xlsx"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/money"
+)
+
+// label translates a ledger category or balance sheet item, falling back to the raw name
+func label(lang, name string) string {
+	if msg := i18n.Template(lang, "report."+name); msg != "" {
+		return msg
+	}
+	return name
+}
+
+// WriteStatementPDF renders a member statement as PDF in lang
+func WriteStatementPDF(w io.Writer, s *MemberStatement, lang string) error {
+	t := func(key string) string { return i18n.T(lang, key, nil) }
+	from, to := s.Period.Label()
+
+	doc := pdf.New(t("statement.title"))
+	doc.Heading(s.ChamaName + " - " + t("statement.title"))
+	doc.Line(t("statement.member") + ": " + s.MemberName)
+	doc.Line(i18n.T(lang, "statement.period", map[string]string{"from": from, "to": to}))
+	doc.Space()
+
+	widths := []float64{80, 100, 175, 70, 70}
+	doc.Row(true, widths, t("statement.date"), t("statement.type"), t("statement.description"), t("statement.amount"), t("statement.balance"))
+	doc.Row(false, widths, "", "", t("statement.opening_balance"), "", s.Opening.Decimal())
+	for _, l := range s.Lines {
+		doc.Row(false, widths, l.Date.Format("2006-01-02"), l.Type, describe(l), l.Amount.Decimal(), l.Balance.Decimal())
+	}
+	doc.Row(true, widths, "", "", t("statement.closing_balance"), "", s.Closing.Decimal())
+	doc.Space()
+
+	summary := []float64{300, 195}
+	doc.Row(false, summary, t("statement.contributions"), s.Contributions.String())
+	for _, paid := range s.PaidIn {
+		doc.Row(false, summary, i18n.T(lang, "statement.paid_in", map[string]string{"currency": paid.Currency}), paid.String())
+	}
+	doc.Row(false, summary, t("statement.fines"), s.Fines.String())
+	doc.Row(false, summary, t("statement.loan_disbursed"), s.LoanDisbursed.String())
+	doc.Row(false, summary, t("statement.loan_repayments"), s.LoanRepayments.String())
+	doc.Row(true, summary, t("statement.loan_outstanding"), s.LoanOutstanding.String())
+
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// WriteStatementXLSX renders a member statement as an Excel workbook in lang
+func WriteStatementXLSX(w io.Writer, s *MemberStatement, lang string) error {
+	t := func(key string) string { return i18n.T(lang, key, nil) }
+
+	x, err := xlsx.NewWriter(w, t("statement.title"))
+	if err != nil {
+		return err
+	}
+	from, to := s.Period.Label()
+	x.WriteRow(s.ChamaName, s.MemberName)
+	x.WriteRow(i18n.T(lang, "statement.period", map[string]string{"from": from, "to": to}))
+	x.WriteRow()
+	x.WriteRow(t("statement.date"), t("statement.type"), t("statement.description"), t("statement.reference"), t("statement.amount"), t("statement.balance"),
+		t("statement.paid"), t("statement.rate"))
+	x.WriteRow("", "", t("statement.opening_balance"), "", "", s.Opening.Decimal())
+	for _, l := range s.Lines {
+		x.WriteRow(l.Date, l.Type, l.Description, l.Reference, l.Amount.Decimal(), l.Balance.Decimal(), paidAmount(l), l.Rate)
+	}
+	x.WriteRow("", "", t("statement.closing_balance"), "", "", s.Closing.Decimal())
+	x.WriteRow()
+	x.WriteRow(t("statement.contributions"), s.Contributions.Decimal())
+	for _, paid := range s.PaidIn {
+		x.WriteRow(i18n.T(lang, "statement.paid_in", map[string]string{"currency": paid.Currency}), paid.Decimal())
+	}
+	x.WriteRow(t("statement.fines"), s.Fines.Decimal())
+	x.WriteRow(t("statement.loan_disbursed"), s.LoanDisbursed.Decimal())
+	x.WriteRow(t("statement.loan_repayments"), s.LoanRepayments.Decimal())
+	x.WriteRow(t("statement.loan_outstanding"), s.LoanOutstanding.Decimal())
+	return x.Close()
+}
+
+// WriteChamaReportPDF renders a chama financial report as PDF in lang
+func WriteChamaReportPDF(w io.Writer, rep *ChamaReport, lang string) error {
+	t := func(key string) string { return i18n.T(lang, key, nil) }
+	from, to := rep.Period.Label()
+	widths := []float64{300, 195}
+
+	doc := pdf.New(t("report.title"))
+	doc.Heading(rep.ChamaName + " - " + t("report.title"))
+	doc.Line(i18n.T(lang, "statement.period", map[string]string{"from": from, "to": to}))
+	doc.Space()
+
+	doc.Row(true, widths, t("report.income"), "")
+	for _, c := range rep.Income {
+		doc.Row(false, widths, label(lang, c.Category), c.Amount.Decimal())
+	}
+	doc.Row(true, widths, t("report.total_income"), rep.TotalIncome.String())
+	doc.Space()
+	doc.Row(true, widths, t("report.expenses"), "")
+	for _, c := range rep.Expenses {
+		doc.Row(false, widths, label(lang, c.Category), c.Amount.Decimal())
+	}
+	doc.Row(true, widths, t("report.total_expenses"), rep.TotalExpenses.String())
+	doc.Row(true, widths, t("report.net_income"), rep.NetIncome.String())
+	doc.Space()
+
+	doc.Heading(i18n.T(lang, "report.balance_sheet", map[string]string{"date": to}))
+	doc.Row(true, widths, t("report.assets"), "")
+	for _, a := range rep.Assets {
+		doc.Row(false, widths, label(lang, a.Name), a.Amount.Decimal())
+	}
+	doc.Row(true, widths, t("report.total_assets"), rep.TotalAssets.String())
+	doc.Space()
+	doc.Row(false, widths, t("report.member_savings"), rep.MemberSavings.Decimal())
+	doc.Row(false, widths, t("report.share_capital"), rep.ShareCapital.Decimal())
+	doc.Row(false, widths, t("report.reserves"), rep.Reserves.Decimal())
+
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// WriteChamaReportXLSX renders a chama financial report as an Excel workbook in lang
+func WriteChamaReportXLSX(w io.Writer, rep *ChamaReport, lang string) error {
+	t := func(key string) string { return i18n.T(lang, key, nil) }
+	from, to := rep.Period.Label()
+
+	x, err := xlsx.NewWriter(w, t("report.title"))
+	if err != nil {
+		return err
+	}
+	x.WriteRow(rep.ChamaName, t("report.title"))
+	x.WriteRow(i18n.T(lang, "statement.period", map[string]string{"from": from, "to": to}))
+	x.WriteRow()
+	x.WriteRow(t("report.income"))
+	for _, c := range rep.Income {
+		x.WriteRow(label(lang, c.Category), c.Amount.Decimal())
+	}
+	x.WriteRow(t("report.total_income"), rep.TotalIncome.Decimal())
+	x.WriteRow(t("report.expenses"))
+	for _, c := range rep.Expenses {
+		x.WriteRow(label(lang, c.Category), c.Amount.Decimal())
+	}
+	x.WriteRow(t("report.total_expenses"), rep.TotalExpenses.Decimal())
+	x.WriteRow(t("report.net_income"), rep.NetIncome.Decimal())
+	x.WriteRow()
+	x.WriteRow(i18n.T(lang, "report.balance_sheet", map[string]string{"date": to}))
+	for _, a := range rep.Assets {
+		x.WriteRow(label(lang, a.Name), a.Amount.Decimal())
+	}
+	x.WriteRow(t("report.total_assets"), rep.TotalAssets.Decimal())
+	x.WriteRow(t("report.member_savings"), rep.MemberSavings.Decimal())
+	x.WriteRow(t("report.share_capital"), rep.ShareCapital.Decimal())
+	x.WriteRow(t("report.reserves"), rep.Reserves.Decimal())
+	return x.Close()
+}
+
+// WriteAccountStatementPDF renders an account statement as PDF in lang
+func WriteAccountStatementPDF(w io.Writer, s *AccountStatement, lang string) error {
+	t := func(key string) string { return i18n.T(lang, key, nil) }
+	from, to := s.Period.Label()
+
+	doc := pdf.New(t("statement.account_title"))
+	doc.Heading(s.ChamaName + " - " + t("statement.account_title"))
+	if s.AccountName != "" {
+		doc.Line(t("statement.account") + ": " + s.AccountName)
+	}
+	doc.Line(i18n.T(lang, "statement.period", map[string]string{"from": from, "to": to}))
+	doc.Space()
+
+	widths := []float64{70, 90, 145, 60, 60, 70}
+	doc.Row(true, widths, t("statement.date"), t("statement.type"), t("statement.description"),
+		t("statement.debit"), t("statement.credit"), t("statement.balance"))
+	doc.Row(false, widths, "", "", t("statement.opening_balance"), "", "", s.Opening.Decimal())
+	for _, l := range s.Lines {
+		doc.Row(false, widths, l.Date.Format("2006-01-02"), label(lang, l.Type), describe(l),
+			blankIfZero(l.Debit), blankIfZero(l.Credit), l.Balance.Decimal())
+	}
+	doc.Row(true, widths, "", "", t("statement.totals"), s.TotalDebits.Decimal(), s.TotalCredits.Decimal(), "")
+	doc.Row(true, widths, "", "", t("statement.closing_balance"), "", "", s.Closing.Decimal())
+
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// WriteAccountStatementXLSX renders an account statement as an Excel workbook in lang
+func WriteAccountStatementXLSX(w io.Writer, s *AccountStatement, lang string) error {
+	t := func(key string) string { return i18n.T(lang, key, nil) }
+
+	x, err := xlsx.NewWriter(w, t("statement.account_title"))
+	if err != nil {
+		return err
+	}
+	from, to := s.Period.Label()
+	x.WriteRow(s.ChamaName, s.AccountName)
+	x.WriteRow(i18n.T(lang, "statement.period", map[string]string{"from": from, "to": to}))
+	x.WriteRow()
+	x.WriteRow(t("statement.date"), t("statement.type"), t("statement.description"), t("statement.reference"),
+		t("statement.debit"), t("statement.credit"), t("statement.balance"), t("statement.paid"), t("statement.rate"))
+	x.WriteRow("", "", t("statement.opening_balance"), "", "", "", s.Opening.Decimal())
+	for _, l := range s.Lines {
+		x.WriteRow(l.Date, label(lang, l.Type), l.Description, l.Reference, blankIfZero(l.Debit), blankIfZero(l.Credit), l.Balance.Decimal(),
+			paidAmount(l), l.Rate)
+	}
+	x.WriteRow("", "", t("statement.totals"), "", s.TotalDebits.Decimal(), s.TotalCredits.Decimal(), "")
+	x.WriteRow("", "", t("statement.closing_balance"), "", "", "", s.Closing.Decimal())
+	return x.Close()
+}
+
+// describe is a line's description, noting what was paid when it came in another currency
+func describe(l StatementLine) string {
+	if note := l.paidNote(); note != "" {
+		return l.Description + " (" + note + ")"
+	}
+	return l.Description
+}
+
+// paidAmount is the amount paid in another currency for a line, or blank
+func paidAmount(l StatementLine) string {
+	if l.Paid == nil {
+		return ""
+	}
+	return l.Paid.String()
+}
+
+func blankIfZero(m money.Money) string {
+	if m.IsZero() {
+		return ""
+	}
+	return m.Decimal()
+}
@@ -0,0 +1,346 @@
+package reports
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+)
+
+// savingsTypes are the ledger entry types that make up a member's savings balance
+var savingsTypes = map[string]bool{
+	ledger.TypeContribution:    true,
+	ledger.TypeOpeningBalance:  true,
+	ledger.TypeSavingsInterest: true,
+	ledger.TypeDistribution:    true,
+	ledger.TypeWithdrawal:      true,
+}
+
+var savingsTypeList = []string{ledger.TypeContribution, ledger.TypeOpeningBalance, ledger.TypeSavingsInterest,
+	ledger.TypeDistribution, ledger.TypeWithdrawal}
+
+// Period is a half-open date range [From, To)
+type Period struct {
+	From time.Time
+	To   time.Time
+}
+
+// Month returns the period covering the calendar month containing t
+func Month(t time.Time) Period {
+	from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return Period{From: from, To: from.AddDate(0, 1, 0)}
+}
+
+// Label formats the period for display, using inclusive end dates
+func (p Period) Label() (string, string) {
+	return p.From.Format("02 Jan 2006"), p.To.AddDate(0, 0, -1).Format("02 Jan 2006")
+}
+
+// StatementLine is one entry on a statement. Debit and Credit split Amount
+// by sign, following the ledger's convention that money in is a credit.
+type StatementLine struct {
+	Date        time.Time   `json:"date"`
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Reference   string      `json:"reference,omitempty"`
+	Amount      money.Money `json:"amount"`
+	Debit       money.Money `json:"debit"`
+	Credit      money.Money `json:"credit"`
+	Balance     money.Money `json:"balance"`
+	// Paid is what the member paid in another currency, converted into Amount at Rate
+	Paid *money.Money `json:"paid,omitempty"`
+	Rate string       `json:"rate,omitempty"`
+}
+
+// newLine makes the statement line for e with the balance after it
+func newLine(e ledger.Entry, balance money.Money) StatementLine {
+	l := StatementLine{
+		Date:        e.EffectiveAt,
+		Type:        e.Type,
+		Description: e.Description,
+		Reference:   e.Reference,
+		Amount:      e.Amount,
+		Debit:       money.New(0, e.Amount.Currency),
+		Credit:      money.New(0, e.Amount.Currency),
+		Balance:     balance,
+	}
+	if e.Amount.IsNegative() {
+		l.Debit = e.Amount.Negate()
+	} else {
+		l.Credit = e.Amount
+	}
+	if e.FX != nil {
+		l.Paid, l.Rate = &e.FX.Original, money.FormatRate(e.FX.Rate)
+	}
+	return l
+}
+
+// paidNote describes a line paid in another currency, e.g. "USD 100.00 @ 129.25"
+func (l StatementLine) paidNote() string {
+	if l.Paid == nil {
+		return ""
+	}
+	return l.Paid.String() + " @ " + l.Rate
+}
+
+// MemberStatement summarises a member's activity in a chama for a period.
+// Balance is the member's savings balance; loan and fine lines are listed
+// but do not change it.
+type MemberStatement struct {
+	ChamaID         string          `json:"chamaId"`
+	ChamaName       string          `json:"chamaName"`
+	MemberID        string          `json:"memberId"`
+	MemberName      string          `json:"memberName"`
+	Period          Period          `json:"period"`
+	Opening         money.Money     `json:"openingBalance"`
+	Lines           []StatementLine `json:"lines"`
+	Closing         money.Money     `json:"closingBalance"`
+	Contributions   money.Money     `json:"contributions"`
+	Fines           money.Money     `json:"fines"`
+	LoanDisbursed   money.Money     `json:"loanDisbursed"`
+	LoanRepayments  money.Money     `json:"loanRepayments"`
+	LoanOutstanding money.Money     `json:"loanOutstanding"`
+	// PaidIn totals the contributions paid in other currencies, in the
+	// currency they were paid in, for members contributing from abroad
+	PaidIn []money.Money `json:"paidIn,omitempty"`
+}
+
+// BuildMemberStatement assembles a member statement from the ledger
+func BuildMemberStatement(ctx context.Context, db *sql.DB, chamaID, memberID string, p Period) (*MemberStatement, error) {
+	s := &MemberStatement{ChamaID: chamaID, MemberID: memberID, Period: p}
+
+	var currency string
+	err := db.QueryRowContext(ctx, `SELECT name, currency FROM chamas WHERE id = ?`, chamaID).Scan(&s.ChamaName, &currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chama: %w", err)
+	}
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(NULLIF(TRIM(COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')), ''), username)
+		FROM users WHERE user_id = ?`, memberID,
+	).Scan(&s.MemberName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load member: %w", err)
+	}
+
+	zero := money.New(0, currency)
+	s.Opening, s.Contributions, s.Fines, s.LoanDisbursed, s.LoanRepayments = zero, zero, zero, zero, zero
+
+	// Savings balance brought forward
+	s.Opening, err = ledger.Sum(ctx, db, ledger.Filter{ChamaID: chamaID, MemberID: memberID, Types: savingsTypeList, To: p.From}, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ledger.List(ctx, db, ledger.Filter{ChamaID: chamaID, MemberID: memberID, From: p.From, To: p.To})
+	if err != nil {
+		return nil, err
+	}
+	if err := ledger.WithFX(ctx, db, entries); err != nil {
+		return nil, err
+	}
+
+	balance := s.Opening
+	for _, e := range entries {
+		if savingsTypes[e.Type] {
+			if balance, err = balance.Add(e.Amount); err != nil {
+				return nil, err
+			}
+		}
+		var total *money.Money
+		switch e.Type {
+		case ledger.TypeContribution:
+			total = &s.Contributions
+		case ledger.TypeFine:
+			total = &s.Fines
+		case ledger.TypeLoanDisbursement:
+			total = &s.LoanDisbursed
+		case ledger.TypeLoanRepayment:
+			total = &s.LoanRepayments
+		}
+		if total != nil {
+			amount := e.Amount
+			// Disbursements are debits; a credit reverses one that failed to reach the member
+			if amount.IsNegative() || e.Type == ledger.TypeLoanDisbursement {
+				amount = amount.Negate()
+			}
+			if *total, err = total.Add(amount); err != nil {
+				return nil, err
+			}
+		}
+
+		if e.Type == ledger.TypeContribution && e.FX != nil {
+			s.PaidIn = addPaid(s.PaidIn, e.FX.Original)
+		}
+
+		s.Lines = append(s.Lines, newLine(e, balance))
+	}
+	s.Closing = balance
+
+	s.LoanOutstanding = zero
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(principal_minor - total_repaid_minor), 0) FROM loans
+		WHERE chama_id = ? AND borrower_id = ? AND status IN ('active', 'defaulted') AND deleted_at IS NULL`,
+		chamaID, memberID,
+	).Scan(&s.LoanOutstanding.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// addPaid adds m to the total for its currency in totals
+func addPaid(totals []money.Money, m money.Money) []money.Money {
+	for i := range totals {
+		if totals[i].Currency == m.Currency {
+			totals[i].Amount += m.Amount
+			return totals
+		}
+	}
+	return append(totals, m)
+}
+
+// CategoryTotal is a total for one ledger entry type
+type CategoryTotal struct {
+	Category string      `json:"category"`
+	Amount   money.Money `json:"amount"`
+}
+
+// BalanceSheetItem is one line in the assets section
+type BalanceSheetItem struct {
+	Name   string      `json:"name"`
+	Amount money.Money `json:"amount"`
+}
+
+// AssetSource adds extra asset lines (e.g. investments) to the balance sheet
+type AssetSource func(ctx context.Context, db *sql.DB, chamaID string, asAt time.Time) ([]BalanceSheetItem, error)
+
+// AssetSources are consulted when building a chama report
+var AssetSources []AssetSource
+
+// ChamaReport is a chama's income statement for a period and its balance sheet at the period end
+type ChamaReport struct {
+	ChamaID       string             `json:"chamaId"`
+	ChamaName     string             `json:"chamaName"`
+	Period        Period             `json:"period"`
+	Income        []CategoryTotal    `json:"income"`
+	TotalIncome   money.Money        `json:"totalIncome"`
+	Expenses      []CategoryTotal    `json:"expenses"`
+	TotalExpenses money.Money        `json:"totalExpenses"`
+	NetIncome     money.Money        `json:"netIncome"`
+	Assets        []BalanceSheetItem `json:"assets"`
+	TotalAssets   money.Money        `json:"totalAssets"`
+	MemberSavings money.Money        `json:"memberSavings"`
+	ShareCapital  money.Money        `json:"shareCapital"`
+	Reserves      money.Money        `json:"reserves"`
+}
+
+// incomeTypes are ledger entry types counted as chama income
+var incomeTypes = []string{ledger.TypeFine, ledger.TypeInterest, ledger.TypeIncome}
+
+// BuildChamaReport assembles the income statement and balance sheet for a chama
+func BuildChamaReport(ctx context.Context, db *sql.DB, chamaID string, p Period) (*ChamaReport, error) {
+	rep := &ChamaReport{ChamaID: chamaID, Period: p}
+
+	var currency string
+	err := db.QueryRowContext(ctx, `SELECT name, currency FROM chamas WHERE id = ?`, chamaID).Scan(&rep.ChamaName, &currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chama: %w", err)
+	}
+	zero := money.New(0, currency)
+	rep.TotalIncome, rep.TotalExpenses, rep.TotalAssets = zero, zero, zero
+
+	from, to := p.From.Format("2006-01-02 15:04:05"), p.To.Format("2006-01-02 15:04:05")
+
+	// Income and expenses for the period, by category
+	rows, err := db.QueryContext(ctx, `
+		SELECT entry_type, SUM(amount_minor) FROM `+ledger.History+`
+		WHERE chama_id = ? AND effective_at >= ? AND effective_at < ?
+		  AND entry_type IN (?, ?, ?, ?)
+		GROUP BY entry_type ORDER BY entry_type`,
+		chamaID, from, to, incomeTypes[0], incomeTypes[1], incomeTypes[2], ledger.TypeExpense,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t CategoryTotal
+		t.Amount.Currency = currency
+		if err := rows.Scan(&t.Category, &t.Amount.Amount); err != nil {
+			return nil, err
+		}
+		if t.Category == ledger.TypeExpense {
+			t.Amount = t.Amount.Negate()
+			rep.Expenses = append(rep.Expenses, t)
+			rep.TotalExpenses, _ = rep.TotalExpenses.Add(t.Amount)
+		} else {
+			rep.Income = append(rep.Income, t)
+			rep.TotalIncome, _ = rep.TotalIncome.Add(t.Amount)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rep.NetIncome, _ = rep.TotalIncome.Sub(rep.TotalExpenses)
+
+	// Account balances at the end of the period
+	balances, err := ledger.SumByAccount(ctx, db, ledger.Filter{ChamaID: chamaID, To: p.To})
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := db.QueryContext(ctx, `SELECT id, name FROM chama_accounts WHERE chama_id = ? ORDER BY name`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer accounts.Close()
+	for accounts.Next() {
+		var id string
+		item := BalanceSheetItem{Amount: zero}
+		if err := accounts.Scan(&id, &item.Name); err != nil {
+			return nil, err
+		}
+		item.Amount.Amount = balances[id]
+		rep.Assets = append(rep.Assets, item)
+	}
+	if err := accounts.Err(); err != nil {
+		return nil, err
+	}
+
+	receivable := BalanceSheetItem{Name: "loans_receivable", Amount: zero}
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(principal_minor - total_repaid_minor), 0) FROM loans
+		WHERE chama_id = ? AND status IN ('active', 'defaulted') AND deleted_at IS NULL`, chamaID,
+	).Scan(&receivable.Amount.Amount)
+	if err != nil {
+		return nil, err
+	}
+	rep.Assets = append(rep.Assets, receivable)
+
+	for _, source := range AssetSources {
+		items, err := source(ctx, db, chamaID, p.To)
+		if err != nil {
+			return nil, err
+		}
+		rep.Assets = append(rep.Assets, items...)
+	}
+	for _, item := range rep.Assets {
+		if rep.TotalAssets, err = rep.TotalAssets.Add(item.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	rep.MemberSavings, err = ledger.Sum(ctx, db, ledger.Filter{ChamaID: chamaID, WithMember: true, Types: savingsTypeList, To: p.To}, currency)
+	if err != nil {
+		return nil, err
+	}
+	rep.ShareCapital, err = ledger.Sum(ctx, db, ledger.Filter{ChamaID: chamaID, Type: ledger.TypeShareCapital, To: p.To}, currency)
+	if err != nil {
+		return nil, err
+	}
+	rep.Reserves, _ = rep.TotalAssets.Sub(rep.MemberSavings)
+	rep.Reserves, _ = rep.Reserves.Sub(rep.ShareCapital)
+	return rep, nil
+}
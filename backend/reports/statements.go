@@ -0,0 +1,77 @@
+package reports
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+)
+
+// AccountStatement lists every movement on a chama account, or on all the
+// chama's accounts, with the running balance
+type AccountStatement struct {
+	ChamaID      string          `json:"chamaId"`
+	ChamaName    string          `json:"chamaName"`
+	AccountID    string          `json:"accountId,omitempty"`
+	AccountName  string          `json:"accountName,omitempty"`
+	Period       Period          `json:"period"`
+	Opening      money.Money     `json:"openingBalance"`
+	Lines        []StatementLine `json:"lines"`
+	TotalDebits  money.Money     `json:"totalDebits"`
+	TotalCredits money.Money     `json:"totalCredits"`
+	Closing      money.Money     `json:"closingBalance"`
+}
+
+// BuildAccountStatement assembles a statement for the chama's accountID, or
+// for all its accounts together when accountID is empty
+func BuildAccountStatement(ctx context.Context, db *sql.DB, chamaID, accountID string, p Period) (*AccountStatement, error) {
+	s := &AccountStatement{ChamaID: chamaID, AccountID: accountID, Period: p, Lines: []StatementLine{}}
+
+	var currency string
+	err := db.QueryRowContext(ctx, `SELECT name, currency FROM chamas WHERE id = ?`, chamaID).Scan(&s.ChamaName, &currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chama: %w", err)
+	}
+	if accountID != "" {
+		err = db.QueryRowContext(ctx, `SELECT name, currency FROM chama_accounts WHERE id = ? AND chama_id = ?`,
+			accountID, chamaID).Scan(&s.AccountName, &currency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load account: %w", err)
+		}
+	}
+
+	s.Opening = money.New(0, currency)
+	if !p.From.IsZero() {
+		s.Opening, err = ledger.Sum(ctx, db, ledger.Filter{ChamaID: chamaID, AccountID: accountID, To: p.From}, currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.TotalDebits, s.TotalCredits = money.New(0, currency), money.New(0, currency)
+
+	entries, err := ledger.List(ctx, db, ledger.Filter{ChamaID: chamaID, AccountID: accountID, From: p.From, To: p.To})
+	if err != nil {
+		return nil, err
+	}
+	if err := ledger.WithFX(ctx, db, entries); err != nil {
+		return nil, err
+	}
+	balance := s.Opening
+	for _, e := range entries {
+		if balance, err = balance.Add(e.Amount); err != nil {
+			return nil, err
+		}
+		l := newLine(e, balance)
+		if s.TotalDebits, err = s.TotalDebits.Add(l.Debit); err != nil {
+			return nil, err
+		}
+		if s.TotalCredits, err = s.TotalCredits.Add(l.Credit); err != nil {
+			return nil, err
+		}
+		s.Lines = append(s.Lines, l)
+	}
+	s.Closing = balance
+	return s, nil
+}
@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"tujifund-app/backend/chamas"
+	chamasv1 "tujifund-app/backend/proto/chamas/v1"
+	"tujifund-app/backend/tenancy"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type chamaServer struct {
+	chamasv1.UnimplementedChamaServiceServer
+	db      *sql.DB
+	tenants *tenancy.Tenants
+}
+
+func (s *chamaServer) GetChama(ctx context.Context, req *chamasv1.GetChamaRequest) (*chamasv1.Chama, error) {
+	if err := required("chama_id", req.ChamaId); err != nil {
+		return nil, err
+	}
+	ctx = tenancy.WithChama(ctx, req.ChamaId)
+	var c chamasv1.Chama
+	err := s.db.QueryRowContext(ctx, `SELECT id, name, type, currency, status FROM chamas WHERE id = ?`, req.ChamaId).
+		Scan(&c.Id, &c.Name, &c.Type, &c.Currency, &c.Status)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &c, nil
+}
+
+func (s *chamaServer) ListMembers(ctx context.Context, req *chamasv1.ListMembersRequest) (*chamasv1.ListMembersResponse, error) {
+	if err := required("chama_id", req.ChamaId); err != nil {
+		return nil, err
+	}
+	ctx = tenancy.WithChama(ctx, req.ChamaId)
+	db, err := s.tenants.For(ctx, req.ChamaId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	query, args := chamas.MembersQuery(req.ChamaId, req.Status, req.Role)
+	rows, err := tenancy.Scoped(db).On("m.chama_id").QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	defer rows.Close()
+
+	res := &chamasv1.ListMembersResponse{}
+	for rows.Next() {
+		m, err := chamas.ScanMember(rows)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		res.Members = append(res.Members, &chamasv1.Member{
+			UserId: m.UserID, Username: m.Username, FirstName: m.FirstName, LastName: m.LastName, Email: m.Email,
+			PhoneNumber: m.Phone, Role: m.Role, Status: m.Status, JoinDate: m.JoinDate,
+		})
+	}
+	return res, toStatus(rows.Err())
+}
+
+func (s *chamaServer) GetMembership(ctx context.Context, req *chamasv1.GetMembershipRequest) (*chamasv1.Membership, error) {
+	if err := required("chama_id", req.ChamaId, "user_id", req.UserId); err != nil {
+		return nil, err
+	}
+	role, err := chamas.MemberRoleContext(ctx, s.db, req.ChamaId, req.UserId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if role == "" {
+		return nil, status.Error(codes.NotFound, "not an active member of this chama")
+	}
+	return &chamasv1.Membership{
+		ChamaId: req.ChamaId, UserId: req.UserId, Role: role, Official: slices.Contains(chamas.OfficialRoles, role),
+	}, nil
+}
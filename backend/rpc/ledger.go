@@ -0,0 +1,122 @@
+package rpc
+
+import (
+	"context"
+	"slices"
+
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	ledgerv1 "tujifund-app/backend/proto/ledger/v1"
+	moneyv1 "tujifund-app/backend/proto/money/v1"
+	"tujifund-app/backend/tenancy"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type ledgerServer struct {
+	ledgerv1.UnimplementedLedgerServiceServer
+	tenants *tenancy.Tenants
+}
+
+func (s *ledgerServer) PostEntry(ctx context.Context, req *ledgerv1.PostEntryRequest) (*ledgerv1.Entry, error) {
+	e := req.Entry
+	if e == nil || e.Amount == nil {
+		return nil, status.Error(codes.InvalidArgument, "entry and its amount are required")
+	}
+	if err := required("chama_id", e.ChamaId, "account_id", e.AccountId, "type", e.Type, "currency", e.Amount.Currency); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(ledger.Types, e.Type) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown entry type %q", e.Type)
+	}
+	if e.Amount.AmountMinor == 0 {
+		return nil, status.Error(codes.InvalidArgument, "amount must not be zero")
+	}
+
+	ctx = tenancy.WithChama(ctx, e.ChamaId)
+	db, err := s.tenants.For(ctx, e.ChamaId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	entry := ledger.Entry{
+		ID: e.Id, ChamaID: e.ChamaId, AccountID: e.AccountId, MemberID: e.MemberId, Type: e.Type,
+		Amount: money.New(e.Amount.AmountMinor, e.Amount.Currency), Reference: e.Reference,
+		Description: e.Description, CreatedBy: e.CreatedBy,
+	}
+	if e.EffectiveAt != nil {
+		entry.EffectiveAt = e.EffectiveAt.AsTime()
+	}
+	entry, err = ledger.PostOne(ctx, db, entry)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toEntry(entry), nil
+}
+
+func (s *ledgerServer) ListEntries(ctx context.Context, req *ledgerv1.ListEntriesRequest) (*ledgerv1.ListEntriesResponse, error) {
+	if err := required("chama_id", req.ChamaId); err != nil {
+		return nil, err
+	}
+	ctx = tenancy.WithChama(ctx, req.ChamaId)
+	db, err := s.tenants.For(ctx, req.ChamaId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	f := ledger.Filter{ChamaID: req.ChamaId, AccountID: req.AccountId, MemberID: req.MemberId, Type: req.Type}
+	if req.From != nil {
+		f.From = req.From.AsTime()
+	}
+	if req.To != nil {
+		f.To = req.To.AsTime()
+	}
+	entries, err := ledger.List(ctx, db, f)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	res := &ledgerv1.ListEntriesResponse{}
+	for _, e := range entries {
+		res.Entries = append(res.Entries, toEntry(e))
+	}
+	return res, nil
+}
+
+// GetBalance totals the entries in the account's currency, or the chama's
+// when no account is given
+func (s *ledgerServer) GetBalance(ctx context.Context, req *ledgerv1.GetBalanceRequest) (*ledgerv1.Balance, error) {
+	if err := required("chama_id", req.ChamaId); err != nil {
+		return nil, err
+	}
+	ctx = tenancy.WithChama(ctx, req.ChamaId)
+	db, err := s.tenants.For(ctx, req.ChamaId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	var currency string
+	if req.AccountId != "" {
+		err = tenancy.Scoped(db).QueryRowContext(ctx, `SELECT currency FROM chama_accounts WHERE id = ?`, req.AccountId).Scan(&currency)
+	} else {
+		currency, err = money.ChamaCurrencyContext(ctx, s.tenants.Shared, req.ChamaId)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	balance, err := ledger.Sum(ctx, db, ledger.Filter{ChamaID: req.ChamaId, AccountID: req.AccountId, MemberID: req.MemberId}, currency)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ledgerv1.Balance{Balance: toMoney(balance)}, nil
+}
+
+func toEntry(e ledger.Entry) *ledgerv1.Entry {
+	return &ledgerv1.Entry{
+		Id: e.ID, ChamaId: e.ChamaID, AccountId: e.AccountID, MemberId: e.MemberID, Type: e.Type,
+		Amount: toMoney(e.Amount), Reference: e.Reference, Description: e.Description,
+		EffectiveAt: timestamppb.New(e.EffectiveAt), CreatedBy: e.CreatedBy,
+	}
+}
+
+func toMoney(m money.Money) *moneyv1.Money {
+	return &moneyv1.Money{AmountMinor: m.Amount, Currency: m.Currency}
+}
@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/phone"
+	paymentsv1 "tujifund-app/backend/proto/payments/v1"
+	"tujifund-app/backend/tenancy"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MobileProviders are the providers contributions may be requested with, as
+// with the REST API's contributions/{provider} routes
+var MobileProviders = []string{payments.ProviderMpesa, payments.ProviderAirtel}
+
+type paymentServer struct {
+	paymentsv1.UnimplementedPaymentServiceServer
+	db *sql.DB
+}
+
+func (s *paymentServer) RequestContribution(ctx context.Context, req *paymentsv1.RequestContributionRequest) (*paymentsv1.Contribution, error) {
+	if err := required("chama_id", req.ChamaId, "member_id", req.MemberId, "provider", req.Provider); err != nil {
+		return nil, err
+	}
+	if req.AmountMinor <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "amount must be positive")
+	}
+	if !slices.Contains(MobileProviders, req.Provider) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown provider %q", req.Provider)
+	}
+	ctx = tenancy.WithChama(ctx, req.ChamaId)
+	if !chamas.IsMemberContext(ctx, s.db, req.ChamaId, req.MemberId) {
+		return nil, status.Error(codes.FailedPrecondition, "not an active member of this chama")
+	}
+	number := req.Phone
+	if number == "" {
+		s.db.QueryRowContext(tenancy.WithAllChamas(ctx), `SELECT COALESCE(phone_number, '') FROM users WHERE user_id = ?`, req.MemberId).
+			Scan(&number)
+	}
+	if !phone.Valid(number) {
+		return nil, status.Error(codes.InvalidArgument, "a valid phone number is required")
+	}
+
+	currency, err := money.ChamaCurrencyContext(ctx, s.db, req.ChamaId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	p, err := payments.Lookup(req.Provider)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	c, err := contributions.Request(ctx, s.db, p, contributions.Contribution{
+		ChamaID: req.ChamaId, MemberID: req.MemberId, AccountID: req.AccountId, Amount: money.New(req.AmountMinor, currency),
+	}, phone.Canonical(number))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toContribution(c), nil
+}
+
+func (s *paymentServer) GetContribution(ctx context.Context, req *paymentsv1.GetContributionRequest) (*paymentsv1.Contribution, error) {
+	if err := required("id", req.Id); err != nil {
+		return nil, err
+	}
+	c, err := contributions.Get(tenancy.WithAllChamas(ctx), s.db, req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toContribution(c), nil
+}
+
+func toContribution(c contributions.Contribution) *paymentsv1.Contribution {
+	return &paymentsv1.Contribution{
+		Id: c.ID, ChamaId: c.ChamaID, MemberId: c.MemberID, AccountId: c.AccountID, Amount: toMoney(c.Amount),
+		Date: timestamppb.New(c.Date), PaymentMethod: c.Method, Reference: c.Reference, Status: c.Status,
+	}
+}
@@ -0,0 +1,148 @@
+// Package rpc serves the users, chamas, ledger and payments services over
+// gRPC for other backend services, next to the REST API and over the same
+// domain packages, so that the backend can later be split into services
+// without rewriting them. The contracts are in backend/proto.
+//
+// Callers are other services on the private network, not members: every
+// call must carry the shared token given to NewServer, and the services do
+// not check chama membership on a member's behalf.
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/payments"
+	chamasv1 "tujifund-app/backend/proto/chamas/v1"
+	ledgerv1 "tujifund-app/backend/proto/ledger/v1"
+	paymentsv1 "tujifund-app/backend/proto/payments/v1"
+	usersv1 "tujifund-app/backend/proto/users/v1"
+	"tujifund-app/backend/readonly"
+	"tujifund-app/backend/tenancy"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoToken is returned when serving or dialling without a shared token
+var ErrNoToken = errors.New("RPC_TOKEN is not set")
+
+// NewServer returns a gRPC server with every service registered. db is the
+// shared database; chama records are read from the database tenants holds
+// them in. Calls without token are refused.
+func NewServer(db *sql.DB, tenants *tenancy.Tenants, token string) (*grpc.Server, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+	s := grpc.NewServer(grpc.UnaryInterceptor(authenticate(token)))
+	usersv1.RegisterUserServiceServer(s, &userServer{db: db})
+	chamasv1.RegisterChamaServiceServer(s, &chamaServer{db: db, tenants: tenants})
+	ledgerv1.RegisterLedgerServiceServer(s, &ledgerServer{tenants: tenants})
+	paymentsv1.RegisterPaymentServiceServer(s, &paymentServer{db: db})
+	return s, nil
+}
+
+// authenticate refuses calls that do not carry token as a bearer token
+func authenticate(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			given, ok := strings.CutPrefix(v, "Bearer ")
+			if ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+				return handler(ctx, req)
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid token")
+	}
+}
+
+// Client holds a client for each service
+type Client struct {
+	Users    usersv1.UserServiceClient
+	Chamas   chamasv1.ChamaServiceClient
+	Ledger   ledgerv1.LedgerServiceClient
+	Payments paymentsv1.PaymentServiceClient
+
+	conn *grpc.ClientConn
+}
+
+// Dial returns a client of the server at target that sends token with every
+// call. The connection is unencrypted unless opts give transport
+// credentials, so it is for the private network only.
+func Dial(target, token string, opts ...grpc.DialOption) (*Client, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(bearer(token)),
+	}, opts...)
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		Users:    usersv1.NewUserServiceClient(conn),
+		Chamas:   chamasv1.NewChamaServiceClient(conn),
+		Ledger:   ledgerv1.NewLedgerServiceClient(conn),
+		Payments: paymentsv1.NewPaymentServiceClient(conn),
+		conn:     conn,
+	}, nil
+}
+
+// Close closes the client's connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// bearer sends a shared token as per-call credentials
+type bearer string
+
+func (b bearer) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(b)}, nil
+}
+
+func (b bearer) RequireTransportSecurity() bool { return false }
+
+// toStatus maps an error from the domain packages to a gRPC status, the
+// way the REST handlers map them to HTTP statuses
+func toStatus(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		return status.Error(codes.NotFound, "not found")
+	case errors.Is(err, ledger.ErrCurrencyMismatch), errors.Is(err, ledger.ErrDebitNotAllowed):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ledger.ErrAccountClosed), errors.Is(err, ledger.ErrInsufficientFunds),
+		errors.Is(err, ledger.ErrPeriodClosed), errors.Is(err, ledger.ErrPeriodLocked),
+		errors.Is(err, contributions.ErrNoFund):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, readonly.ErrReadOnly), errors.Is(err, payments.ErrNoProvider), errors.Is(err, breaker.ErrUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case strings.Contains(err.Error(), "UNIQUE constraint failed"):
+		return status.Error(codes.AlreadyExists, "already exists")
+	}
+	slog.Error("RPC failed", "error", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+// required returns an INVALID_ARGUMENT status naming the first empty field
+func required(fields ...string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i+1] == "" {
+			return status.Errorf(codes.InvalidArgument, "%s is required", fields[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"os"
+	"testing"
+
+	"tujifund-app/backend/payments"
+	chamasv1 "tujifund-app/backend/proto/chamas/v1"
+	ledgerv1 "tujifund-app/backend/proto/ledger/v1"
+	moneyv1 "tujifund-app/backend/proto/money/v1"
+	paymentsv1 "tujifund-app/backend/proto/payments/v1"
+	usersv1 "tujifund-app/backend/proto/users/v1"
+	"tujifund-app/backend/tenancy"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	_ "modernc.org/sqlite"
+)
+
+const testToken = "s3cret"
+
+// testDB returns a KES chama with a treasurer, u1, and a member, u2, and a
+// savings account, a1
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+t.TempDir()+"/rpc.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	schema, err := os.ReadFile("../database/database_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		string(schema),
+		`INSERT INTO users (user_id, username, email, first_name, phone_number, is_verified) VALUES
+			('u1', 'achieng', 'u1@example.com', 'Achieng', '0712345678', 1), ('u2', 'baraka', 'u2@example.com', 'Baraka', '0722000111', 0),
+			('u3', 'chebet', 'u3@example.com', 'Chebet', NULL, 0)`,
+		`INSERT INTO chamas (id, name, type, created_by) VALUES ('c1', 'Umoja', 'savings', 'u1')`,
+		`INSERT INTO chama_members (id, chama_id, user_id, role) VALUES ('m1', 'c1', 'u1', 'treasurer'), ('m2', 'c1', 'u2', 'member')`,
+		`INSERT INTO chama_accounts (id, chama_id, name, account_type) VALUES ('a1', 'c1', 'Savings', 'savings')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// fakeProvider accepts every payment request
+type fakeProvider struct{}
+
+func (fakeProvider) Name() string { return payments.ProviderMpesa }
+func (fakeProvider) InitiatePayment(ctx context.Context, req payments.PaymentRequest) (string, error) {
+	return "ws_CO_" + req.Reference, nil
+}
+func (fakeProvider) HandleCallback(payload []byte) (payments.PaymentResult, error) {
+	return payments.PaymentResult{}, payments.ErrUnsupported
+}
+func (fakeProvider) QueryStatus(ctx context.Context, ref string) (payments.PaymentResult, error) {
+	return payments.PaymentResult{ProviderReference: ref, Status: payments.PaymentPending}, nil
+}
+
+// serve starts the services on an in-memory listener and returns a client
+// dialled with token
+func serve(t *testing.T, db *sql.DB, token string) *Client {
+	t.Helper()
+	s, err := NewServer(db, tenancy.NewTenants(db, false, nil), testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	c, err := Dial("passthrough:///bufnet", token, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func kes(minor int64) *moneyv1.Money {
+	return &moneyv1.Money{AmountMinor: minor, Currency: "KES"}
+}
+
+func TestServices(t *testing.T) {
+	saved := payments.Providers[payments.ProviderMpesa]
+	payments.Providers[payments.ProviderMpesa] = fakeProvider{}
+	t.Cleanup(func() {
+		if saved == nil {
+			delete(payments.Providers, payments.ProviderMpesa)
+		} else {
+			payments.Providers[payments.ProviderMpesa] = saved
+		}
+	})
+
+	db := testDB(t)
+	c := serve(t, db, testToken)
+	ctx := context.Background()
+
+	// Run in order: the ledger and payment calls see what earlier ones posted
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{"user by ID", func() error {
+			u, err := c.Users.GetUser(ctx, &usersv1.GetUserRequest{UserId: "u1"})
+			if err == nil && (u.Username != "achieng" || !u.Verified || u.PhoneNumber != "0712345678") {
+				t.Errorf("user = %+v", u)
+			}
+			return err
+		}, codes.OK},
+		{"user by email ignores case", func() error {
+			u, err := c.Users.GetUserByEmail(ctx, &usersv1.GetUserByEmailRequest{Email: " U2@Example.com"})
+			if err == nil && u.UserId != "u2" {
+				t.Errorf("user = %+v", u)
+			}
+			return err
+		}, codes.OK},
+		{"unknown user", func() error {
+			_, err := c.Users.GetUser(ctx, &usersv1.GetUserRequest{UserId: "nobody"})
+			return err
+		}, codes.NotFound},
+		{"user ID required", func() error {
+			_, err := c.Users.GetUser(ctx, &usersv1.GetUserRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"chama", func() error {
+			ch, err := c.Chamas.GetChama(ctx, &chamasv1.GetChamaRequest{ChamaId: "c1"})
+			if err == nil && (ch.Name != "Umoja" || ch.Currency != "KES" || ch.Status != "active") {
+				t.Errorf("chama = %+v", ch)
+			}
+			return err
+		}, codes.OK},
+		{"members by role", func() error {
+			res, err := c.Chamas.ListMembers(ctx, &chamasv1.ListMembersRequest{ChamaId: "c1", Role: "member"})
+			if err == nil && (len(res.Members) != 1 || res.Members[0].UserId != "u2") {
+				t.Errorf("members = %+v", res.Members)
+			}
+			return err
+		}, codes.OK},
+		{"official's membership", func() error {
+			m, err := c.Chamas.GetMembership(ctx, &chamasv1.GetMembershipRequest{ChamaId: "c1", UserId: "u1"})
+			if err == nil && (m.Role != "treasurer" || !m.Official) {
+				t.Errorf("membership = %+v", m)
+			}
+			return err
+		}, codes.OK},
+		{"non-member", func() error {
+			_, err := c.Chamas.GetMembership(ctx, &chamasv1.GetMembershipRequest{ChamaId: "c1", UserId: "u3"})
+			return err
+		}, codes.NotFound},
+		{"post entry", func() error {
+			e, err := c.Ledger.PostEntry(ctx, &ledgerv1.PostEntryRequest{Entry: &ledgerv1.Entry{
+				Id: "e1", ChamaId: "c1", AccountId: "a1", MemberId: "u2", Type: "contribution", Amount: kes(50000),
+			}})
+			if err == nil && (e.EffectiveAt == nil || e.Amount.AmountMinor != 50000) {
+				t.Errorf("entry = %+v", e)
+			}
+			return err
+		}, codes.OK},
+		{"entry posted again", func() error {
+			_, err := c.Ledger.PostEntry(ctx, &ledgerv1.PostEntryRequest{Entry: &ledgerv1.Entry{
+				Id: "e1", ChamaId: "c1", AccountId: "a1", MemberId: "u2", Type: "contribution", Amount: kes(50000),
+			}})
+			return err
+		}, codes.AlreadyExists},
+		{"entry in another currency", func() error {
+			_, err := c.Ledger.PostEntry(ctx, &ledgerv1.PostEntryRequest{Entry: &ledgerv1.Entry{
+				ChamaId: "c1", AccountId: "a1", Type: "income", Amount: &moneyv1.Money{AmountMinor: 100, Currency: "UGX"},
+			}})
+			return err
+		}, codes.InvalidArgument},
+		{"entry of unknown type", func() error {
+			_, err := c.Ledger.PostEntry(ctx, &ledgerv1.PostEntryRequest{Entry: &ledgerv1.Entry{
+				ChamaId: "c1", AccountId: "a1", Type: "gift", Amount: kes(100),
+			}})
+			return err
+		}, codes.InvalidArgument},
+		{"entry on unknown account", func() error {
+			_, err := c.Ledger.PostEntry(ctx, &ledgerv1.PostEntryRequest{Entry: &ledgerv1.Entry{
+				ChamaId: "c1", AccountId: "a9", Type: "income", Amount: kes(100),
+			}})
+			return err
+		}, codes.NotFound},
+		{"entries", func() error {
+			res, err := c.Ledger.ListEntries(ctx, &ledgerv1.ListEntriesRequest{ChamaId: "c1", MemberId: "u2"})
+			if err == nil && (len(res.Entries) != 1 || res.Entries[0].Id != "e1") {
+				t.Errorf("entries = %+v", res.Entries)
+			}
+			return err
+		}, codes.OK},
+		{"account balance", func() error {
+			b, err := c.Ledger.GetBalance(ctx, &ledgerv1.GetBalanceRequest{ChamaId: "c1", AccountId: "a1"})
+			if err == nil && (b.Balance.AmountMinor != 50000 || b.Balance.Currency != "KES") {
+				t.Errorf("balance = %+v", b.Balance)
+			}
+			return err
+		}, codes.OK},
+		{"request contribution", func() error {
+			k, err := c.Payments.RequestContribution(ctx, &paymentsv1.RequestContributionRequest{
+				ChamaId: "c1", MemberId: "u2", AmountMinor: 20000, Provider: payments.ProviderMpesa,
+			})
+			if err != nil {
+				return err
+			}
+			if k.Status != "pending" || k.AccountId != "a1" || k.Amount.AmountMinor != 20000 || k.PaymentMethod != payments.ProviderMpesa {
+				t.Errorf("contribution = %+v", k)
+			}
+			got, err := c.Payments.GetContribution(ctx, &paymentsv1.GetContributionRequest{Id: k.Id})
+			if err == nil && (got.Id != k.Id || got.Status != "pending") {
+				t.Errorf("got contribution %+v", got)
+			}
+			return err
+		}, codes.OK},
+		{"contribution from non-member", func() error {
+			_, err := c.Payments.RequestContribution(ctx, &paymentsv1.RequestContributionRequest{
+				ChamaId: "c1", MemberId: "u3", AmountMinor: 20000, Provider: payments.ProviderMpesa, Phone: "0733000222",
+			})
+			return err
+		}, codes.FailedPrecondition},
+		{"contribution by bank transfer", func() error {
+			_, err := c.Payments.RequestContribution(ctx, &paymentsv1.RequestContributionRequest{
+				ChamaId: "c1", MemberId: "u2", AmountMinor: 20000, Provider: "bank",
+			})
+			return err
+		}, codes.InvalidArgument},
+		{"unknown contribution", func() error {
+			_, err := c.Payments.GetContribution(ctx, &paymentsv1.GetContributionRequest{Id: "k9"})
+			return err
+		}, codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(tt.call()); got != tt.want {
+				t.Errorf("code = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	db := testDB(t)
+	tests := []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"shared token", testToken, codes.OK},
+		{"wrong token", "guess", codes.Unauthenticated},
+		{"token prefix", testToken[:3], codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := serve(t, db, tt.token)
+			_, err := c.Chamas.GetChama(context.Background(), &chamasv1.GetChamaRequest{ChamaId: "c1"})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("code = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewServer(db, nil, ""); err != ErrNoToken {
+		t.Errorf("server without a token: %v", err)
+	}
+	if _, err := Dial("localhost:9090", ""); err != ErrNoToken {
+		t.Errorf("client without a token: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+
+	"tujifund-app/backend/otp"
+	usersv1 "tujifund-app/backend/proto/users/v1"
+	"tujifund-app/backend/tenancy"
+)
+
+type userServer struct {
+	usersv1.UnimplementedUserServiceServer
+	db *sql.DB
+}
+
+const userColumns = `user_id, username, email, COALESCE(first_name, ''), COALESCE(last_name, ''),
+	COALESCE(phone_number, ''), COALESCE(country, ''), COALESCE(is_verified, 0) = 1, suspended_at IS NOT NULL`
+
+func (s *userServer) GetUser(ctx context.Context, req *usersv1.GetUserRequest) (*usersv1.User, error) {
+	if err := required("user_id", req.UserId); err != nil {
+		return nil, err
+	}
+	return s.get(ctx, `SELECT `+userColumns+` FROM users WHERE user_id = ?`, req.UserId)
+}
+
+func (s *userServer) GetUserByEmail(ctx context.Context, req *usersv1.GetUserByEmailRequest) (*usersv1.User, error) {
+	if err := required("email", req.Email); err != nil {
+		return nil, err
+	}
+	return s.get(ctx, `SELECT `+userColumns+` FROM users WHERE lower(email) = ?`, otp.Normalize(req.Email))
+}
+
+func (s *userServer) get(ctx context.Context, query string, args ...interface{}) (*usersv1.User, error) {
+	ctx = tenancy.WithAllChamas(ctx)
+	var u usersv1.User
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&u.UserId, &u.Username, &u.Email, &u.FirstName, &u.LastName,
+		&u.PhoneNumber, &u.Country, &u.Verified, &u.Suspended)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &u, nil
+}
@@ -0,0 +1,223 @@
+package rules
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/validation"
+	"tujifund-app/backend/votes"
+
+	"github.com/gorilla/mux"
+)
+
+// CurrentHandler returns the rules in force for the {chamaId} chama, or at ?at=YYYY-MM-DD
+func CurrentHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMemberContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		at := time.Now()
+		if v := r.URL.Query().Get("at"); v != "" {
+			t, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				http.Error(w, "at must be a date in the format YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			at = t.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		current, version, err := At(r.Context(), db, chamaID, at)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"version": version, "rules": current})
+	}
+}
+
+// HistoryHandler lists every version of the {chamaId} chama's rules
+func HistoryHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMemberContext(r.Context(), db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := History(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// PublishHandler lets the chairperson or an admin publish a new rules version.
+// When "vote" is given the version is proposed and a rule-change vote decides it.
+func PublishHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRoleContext(r.Context(), db, chamaID, userID, chamas.RoleAdmin, chamas.RoleChairperson) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		request := struct {
+			Rules         Rules  `json:"rules"`
+			EffectiveFrom string `json:"effectiveFrom"`
+			Notes         string `json:"notes"`
+			Vote          *struct {
+				ClosesAt   string `json:"closesAt"`
+				BallotType string `json:"ballotType"`
+			} `json:"vote"`
+		}{Rules: Defaults}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		v := validation.New()
+		validate(v, request.Rules)
+		var effective time.Time
+		if request.EffectiveFrom != "" {
+			v.Date("effectiveFrom", request.EffectiveFrom)
+			effective, _ = time.Parse("2006-01-02", request.EffectiveFrom)
+		}
+		var closesAt time.Time
+		if request.Vote != nil {
+			t, err := time.Parse(time.RFC3339, request.Vote.ClosesAt)
+			if err != nil || t.Before(time.Now()) {
+				v.Add("vote.closesAt", validation.CodeDate, nil)
+			}
+			closesAt = t
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		version := Version{
+			ChamaID:       chamaID,
+			Rules:         request.Rules,
+			EffectiveFrom: effective,
+			Notes:         request.Notes,
+			CreatedBy:     userID,
+		}
+		if request.Vote != nil {
+			version.Status = StatusProposed
+		}
+		version, err := Publish(r.Context(), db, version, audit.FromRequest(r, audit.Entry{}))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if request.Vote != nil {
+			vote, err := votes.Open(r.Context(), db, votes.Vote{
+				ChamaID:     chamaID,
+				SubjectType: votes.SubjectRuleChange,
+				SubjectID:   version.ID,
+				Title:       "Adopt rules version " + strconv.Itoa(version.Version),
+				Description: request.Notes,
+				BallotType:  request.Vote.BallotType,
+				ClosesAt:    closesAt,
+				CreatedBy:   userID,
+			}, audit.FromRequest(r, audit.Entry{}))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := AttachVote(r.Context(), db, version.ID, vote.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			version.VoteID = vote.ID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(version)
+	}
+}
+
+func validate(v *validation.Validator, r Rules) {
+	v.OneOf("contributionFrequency", r.ContributionFrequency, FrequencyWeekly, FrequencyBiweekly, FrequencyMonthly)
+	v.OneOf("loanInterestType", r.LoanInterestType, "flat", "reducing")
+	v.OneOf("loanRebateMethod", r.LoanRebateMethod, "pro_rata", "rule_of_78")
+	v.OneOf("interestAccrual", r.InterestAccrual, "daily", "monthly")
+	v.OneOf("dissolutionDistribution", r.DissolutionDistribution, "savings", "equal", "shares")
+	if r.ContributionFrequency == FrequencyMonthly && (r.ContributionDueDay < 1 || r.ContributionDueDay > 31) {
+		v.Add("contributionDueDay", validation.CodeOneOf, map[string]string{"options": "1-31"})
+	}
+	if r.ContributionFrequency != FrequencyMonthly && (r.ContributionDueDay < 0 || r.ContributionDueDay > 6) {
+		v.Add("contributionDueDay", validation.CodeOneOf, map[string]string{"options": "0-6"})
+	}
+	if r.FinancialYearStartMonth < 1 || r.FinancialYearStartMonth > 12 {
+		v.Add("financialYearStartMonth", validation.CodeOneOf, map[string]string{"options": "1-12"})
+	}
+	for _, f := range []struct {
+		field string
+		n     int64
+	}{
+		{"contributionAmountMinor", r.ContributionAmountMinor},
+		{"contributionGraceDays", int64(r.ContributionGraceDays)},
+		{"lateContributionFineMinor", r.LateContributionFineMinor},
+		{"absenceFineMinor", r.AbsenceFineMinor},
+		{"loanInterestRateBps", r.LoanInterestRateBps},
+		{"loanMultiplierBps", r.LoanMultiplierBps},
+		{"loanMaxTermDays", int64(r.LoanMaxTermDays)},
+		{"loanGraceDays", int64(r.LoanGraceDays)},
+		{"lateRepaymentFineBps", r.LateRepaymentFineBps},
+		{"savingsInterestRateBps", r.SavingsInterestRateBps},
+		{"exitNoticeDays", int64(r.ExitNoticeDays)},
+	} {
+		if f.n < 0 {
+			v.Add(f.field, validation.CodeAmount, nil)
+		}
+	}
+	validateTiers(v, r.ApprovalTiers)
+}
+
+// validateTiers checks that tiers rise in amount, only the last is open-ended
+// and each names who approves
+func validateTiers(v *validation.Validator, tiers []ApprovalTier) {
+	var below int64
+	for i, t := range tiers {
+		field := "approvalTiers[" + strconv.Itoa(i) + "]"
+		last := i == len(tiers)-1
+		if (t.UpToMinor == 0 && !last) || (t.UpToMinor != 0 && t.UpToMinor <= below) {
+			v.Add(field+".upToMinor", validation.CodeAmount, nil)
+		}
+		below = t.UpToMinor
+		if !t.Vote && (t.Approvers < 1 || t.Approvers > 5) {
+			v.Add(field+".approvers", validation.CodeOneOf, map[string]string{"options": "1-5"})
+		}
+		for _, role := range t.Roles {
+			v.OneOf(field+".roles", role, chamas.OfficialRoles...)
+		}
+	}
+}
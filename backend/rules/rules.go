@@ -0,0 +1,314 @@
+package rules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/calendar"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+	"tujifund-app/backend/votes"
+
+	"github.com/google/uuid"
+)
+
+// Contribution frequencies
+const (
+	FrequencyWeekly   = "weekly"
+	FrequencyBiweekly = "biweekly"
+	FrequencyMonthly  = "monthly"
+)
+
+// Version statuses
+const (
+	StatusProposed = "proposed"
+	StatusActive   = "active"
+	StatusRejected = "rejected"
+)
+
+// Rules are a chama's operating rules. Amounts are in the chama's currency's
+// minor units and rates in basis points.
+type Rules struct {
+	ContributionAmountMinor   int64  `json:"contributionAmountMinor"`
+	ContributionFrequency     string `json:"contributionFrequency"`
+	ContributionDueDay        int    `json:"contributionDueDay"` // day of month, or weekday (0 = Sunday) for weekly cycles
+	ContributionGraceDays     int    `json:"contributionGraceDays"`
+	LateContributionFineMinor int64  `json:"lateContributionFineMinor"`
+	AbsenceFineMinor          int64  `json:"absenceFineMinor"`
+	LoanInterestRateBps       int64  `json:"loanInterestRateBps"`
+	LoanInterestType          string `json:"loanInterestType"`  // flat, reducing
+	LoanMultiplierBps         int64  `json:"loanMultiplierBps"` // loan limit as a multiple of savings, 30000 = 3x
+	LoanMaxTermDays           int    `json:"loanMaxTermDays"`
+	LoanGraceDays             int    `json:"loanGraceDays"`
+	LateRepaymentFineBps      int64  `json:"lateRepaymentFineBps"`    // of the overdue instalment
+	LoanRebateMethod          string `json:"loanRebateMethod"`        // pro_rata, rule_of_78: interest given back on early settlement
+	SavingsInterestRateBps    int64  `json:"savingsInterestRateBps"`  // a year, paid on members' savings; 0 for none
+	InterestAccrual           string `json:"interestAccrual"`         // daily, monthly: how often interest is posted
+	DissolutionDistribution   string `json:"dissolutionDistribution"` // savings, equal, shares: how a surplus is shared on dissolution
+	ExitNoticeDays            int    `json:"exitNoticeDays"`          // notice a member gives before their savings are refunded
+	FinancialYearStartMonth   int    `json:"financialYearStartMonth"` // 1 = January
+	ResetReceiptNumbers       bool   `json:"resetReceiptNumbers"`     // receipt numbers restart from 1 when a financial year is closed
+	// ApprovalTiers set who must approve expenses and payouts by amount, in
+	// ascending order of UpToMinor. None means the usual single approval.
+	ApprovalTiers []ApprovalTier `json:"approvalTiers,omitempty"`
+	// Calendar is the days the chama can pay on, which due dates are moved
+	// to. It is kept apart from the rules and loaded with them.
+	Calendar calendar.Calendar `json:"-"`
+}
+
+// ApprovalTier is who must approve spending of up to an amount
+type ApprovalTier struct {
+	UpToMinor int64    `json:"upToMinor"`       // amounts below this; 0 for no upper limit, on the last tier only
+	Approvers int      `json:"approvers"`       // distinct officials who must approve
+	Roles     []string `json:"roles,omitempty"` // who may approve; any official when empty
+	Vote      bool     `json:"vote"`            // put to a vote of the members instead
+}
+
+// ApprovalTier returns the tier amount falls in, or false when the chama has
+// no tiers or amount is beyond the last
+func (r Rules) ApprovalTier(amount money.Money) (ApprovalTier, bool) {
+	for _, t := range r.ApprovalTiers {
+		if t.UpToMinor == 0 || amount.Amount < t.UpToMinor {
+			return t, true
+		}
+	}
+	return ApprovalTier{}, false
+}
+
+// Defaults apply to chamas that have not published any rules
+var Defaults = Rules{
+	ContributionFrequency:   FrequencyMonthly,
+	ContributionDueDay:      5,
+	ContributionGraceDays:   3,
+	LoanInterestRateBps:     1000,
+	LoanInterestType:        "flat",
+	LoanMultiplierBps:       30000,
+	LoanMaxTermDays:         365,
+	LoanRebateMethod:        "pro_rata",
+	InterestAccrual:         "monthly",
+	DissolutionDistribution: "savings",
+	ExitNoticeDays:          30,
+	FinancialYearStartMonth: 1,
+}
+
+// Version is one published or proposed version of a chama's rules
+type Version struct {
+	ID            string    `json:"id"`
+	ChamaID       string    `json:"chamaId"`
+	Version       int       `json:"version"`
+	Rules         Rules     `json:"rules"`
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+	Status        string    `json:"status"`
+	VoteID        string    `json:"voteId,omitempty"`
+	Notes         string    `json:"notes,omitempty"`
+	CreatedBy     string    `json:"createdBy"`
+	CreatedAt     string    `json:"createdAt"`
+}
+
+// ErrNotProposed is returned when deciding on a version that is not awaiting a vote
+var ErrNotProposed = errors.New("rules version is not awaiting a vote")
+
+// MaxLoan is the most a member with the given savings may borrow
+func (r Rules) MaxLoan(savings money.Money) money.Money {
+	limit, err := savings.ApplyRate(r.LoanMultiplierBps)
+	if err != nil {
+		return money.New(0, savings.Currency)
+	}
+	return limit
+}
+
+// ContributionAmount returns the expected contribution per cycle in currency
+func (r Rules) ContributionAmount(currency string) money.Money {
+	return money.New(r.ContributionAmountMinor, currency)
+}
+
+// ContributionDueDate returns the due date the rules set for the
+// contribution cycle that contains t, before it is moved off a closed day
+// (see dashboard.CycleAt)
+func (r Rules) ContributionDueDate(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch r.ContributionFrequency {
+	case FrequencyWeekly, FrequencyBiweekly:
+		offset := (r.ContributionDueDay - int(day.Weekday()) + 7) % 7
+		return day.AddDate(0, 0, offset)
+	default:
+		due := r.ContributionDueDay
+		if last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day(); due > last {
+			due = last
+		}
+		return time.Date(t.Year(), t.Month(), due, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// FinancialYear returns the financial year [start, end) that contains t
+func (r Rules) FinancialYear(t time.Time) (time.Time, time.Time) {
+	month := time.Month(r.FinancialYearStartMonth)
+	if month < time.January || month > time.December {
+		month = time.January
+	}
+	start := time.Date(t.Year(), month, 1, 0, 0, 0, 0, t.Location())
+	if start.After(t) {
+		start = start.AddDate(-1, 0, 0)
+	}
+	return start, start.AddDate(1, 0, 0)
+}
+
+// Current returns the rules in force now
+func Current(ctx context.Context, db *sql.DB, chamaID string) (Rules, error) {
+	r, _, err := At(ctx, db, chamaID, time.Now())
+	return r, err
+}
+
+// At returns the rules in force at t and the version they come from. When the
+// chama has no active version by then, Defaults and version 0 are returned.
+func At(ctx context.Context, db *sql.DB, chamaID string, t time.Time) (Rules, int, error) {
+	var doc string
+	var version int
+	err := txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT rules, version FROM chama_rules
+		WHERE chama_id = ? AND status = ? AND effective_from <= ?
+		ORDER BY effective_from DESC, version DESC LIMIT 1`,
+		chamaID, StatusActive, t.UTC().Format("2006-01-02 15:04:05"),
+	).Scan(&doc, &version)
+	if err != nil && err != sql.ErrNoRows {
+		return Defaults, 0, err
+	}
+	r := Defaults
+	if err == nil {
+		if err := json.Unmarshal([]byte(doc), &r); err != nil {
+			return Defaults, 0, fmt.Errorf("invalid rules document for chama %s version %d: %w", chamaID, version, err)
+		}
+	}
+	if r.Calendar, err = calendar.Load(ctx, txn.From(ctx, db), chamaID); err != nil {
+		return Defaults, 0, fmt.Errorf("failed to load the chama's calendar: %w", err)
+	}
+	return r, version, nil
+}
+
+// History returns every version of a chama's rules, newest first
+func History(ctx context.Context, db *sql.DB, chamaID string) ([]Version, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `SELECT `+columns+` FROM chama_rules WHERE chama_id = ? ORDER BY version DESC`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Version{}
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// Get returns one rules version
+func Get(ctx context.Context, db *sql.DB, id string) (Version, error) {
+	return scan(txn.From(ctx, db).QueryRowContext(ctx, `SELECT `+columns+` FROM chama_rules WHERE id = ?`, id))
+}
+
+const columns = `id, chama_id, version, rules, effective_from, status, COALESCE(vote_id, ''), COALESCE(notes, ''), created_by, created_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Version, error) {
+	var v Version
+	var doc string
+	if err := row.Scan(&v.ID, &v.ChamaID, &v.Version, &doc, &v.EffectiveFrom, &v.Status, &v.VoteID, &v.Notes, &v.CreatedBy, &v.CreatedAt); err != nil {
+		return v, err
+	}
+	v.Rules = Defaults
+	return v, json.Unmarshal([]byte(doc), &v.Rules)
+}
+
+// Publish stores v as the chama's next rules version. Active versions take
+// effect from EffectiveFrom; proposed versions wait for a vote (see Decide).
+func Publish(ctx context.Context, db *sql.DB, v Version, entry audit.Entry) (Version, error) {
+	doc, err := json.Marshal(v.Rules)
+	if err != nil {
+		return v, err
+	}
+	if v.Status == "" {
+		v.Status = StatusActive
+	}
+	if v.EffectiveFrom.IsZero() {
+		v.EffectiveFrom = time.Now().UTC()
+	}
+	v.ID = uuid.NewString()
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return v, err
+	}
+	defer txn.Rollback(tx)
+
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(version), 0) + 1 FROM chama_rules WHERE chama_id = ?`, v.ChamaID,
+	).Scan(&v.Version); err != nil {
+		return v, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chama_rules (id, chama_id, version, rules, effective_from, status, vote_id, notes, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		v.ID, v.ChamaID, v.Version, string(doc), v.EffectiveFrom.UTC().Format("2006-01-02 15:04:05"), v.Status,
+		nullIfEmpty(v.VoteID), nullIfEmpty(v.Notes), v.CreatedBy)
+	if err != nil {
+		return v, fmt.Errorf("failed to save rules: %w", err)
+	}
+
+	entry.Action, entry.EntityType, entry.EntityID, entry.NewValues = "rules."+v.Status, "chama", v.ChamaID, v
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return v, err
+	}
+	return v, txn.Commit(tx)
+}
+
+// AttachVote links a proposed version to the vote that decides it
+func AttachVote(ctx context.Context, db *sql.DB, versionID, voteID string) error {
+	_, err := txn.From(ctx, db).ExecContext(ctx, `UPDATE chama_rules SET vote_id = ? WHERE id = ?`, voteID, versionID)
+	return err
+}
+
+// Decide activates or rejects a proposed version. An activated version whose
+// effective date has already passed takes effect immediately.
+func Decide(ctx context.Context, db *sql.DB, versionID string, approved bool) error {
+	status := StatusRejected
+	if approved {
+		status = StatusActive
+	}
+	res, err := txn.From(ctx, db).ExecContext(ctx, `
+		UPDATE chama_rules SET status = ?,
+		       effective_from = CASE WHEN ? = 'active' AND effective_from < CURRENT_TIMESTAMP THEN CURRENT_TIMESTAMP ELSE effective_from END
+		WHERE id = ? AND status = ?`,
+		status, status, versionID, StatusProposed)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotProposed
+	}
+	return audit.Record(ctx, txn.From(ctx, db), audit.Entry{Action: "rules." + status, EntityType: "chama_rules", EntityID: versionID})
+}
+
+// RegisterVoteHook makes rule-change votes activate or reject the proposed
+// rules version they were opened for
+func RegisterVoteHook() {
+	votes.OutcomeHooks[votes.SubjectRuleChange] = func(ctx context.Context, db *sql.DB, v votes.Vote) error {
+		if v.Status == votes.StatusCancelled {
+			return nil
+		}
+		return Decide(ctx, db, v.SubjectID, v.Status == votes.StatusPassed)
+	}
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
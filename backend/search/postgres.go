@@ -0,0 +1,123 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Postgres searches with tsvector expressions backed by GIN indexes. Terms
+// are matched as prefixes; phone numbers also match anywhere in the number.
+type Postgres struct {
+	db *sql.DB
+}
+
+// Documents indexed for each kind, with columns prefixed by alias. Queries
+// must use exactly these expressions for Postgres to use the indexes.
+func userVector(alias string) string {
+	return `to_tsvector('simple', coalesce(` + alias + `username, '') || ' ' || coalesce(` + alias + `first_name, '') || ' ' || ` +
+		`coalesce(` + alias + `last_name, '') || ' ' || coalesce(` + alias + `email, ''))`
+}
+
+func chamaVector(alias string) string {
+	return `to_tsvector('simple', coalesce(` + alias + `name, '') || ' ' || coalesce(` + alias + `description, ''))`
+}
+
+func ledgerVector(alias string) string {
+	return `to_tsvector('simple', coalesce(` + alias + `reference, '') || ' ' || coalesce(` + alias + `description, ''))`
+}
+
+// EnsureIndexes creates the search indexes. Run it once at startup.
+func (p *Postgres) EnsureIndexes(ctx context.Context) error {
+	for _, ddl := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_users_search ON users USING GIN ((` + userVector("") + `))`,
+		`CREATE INDEX IF NOT EXISTS idx_chamas_search ON chamas USING GIN ((` + chamaVector("") + `))`,
+		`CREATE INDEX IF NOT EXISTS idx_ledger_entries_search ON ledger_entries USING GIN ((` + ledgerVector("") + `))`,
+	} {
+		if _, err := p.db.ExecContext(ctx, ddl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tsquery builds a query requiring a prefix match on every term. Terms are
+// passed as lexemes so user input cannot use tsquery syntax.
+func tsquery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = `'` + strings.ReplaceAll(t, `'`, `''`) + `':*`
+	}
+	return strings.Join(parts, " & ")
+}
+
+// rebind converts ? placeholders to Postgres's $1, $2, ...
+func rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (p *Postgres) Search(ctx context.Context, q Query) (Results, error) {
+	res := Results{Members: []Member{}, Chamas: []Chama{}, Transactions: []Transaction{}}
+	terms, err := Terms(q.Text)
+	if err != nil {
+		return res, err
+	}
+	expr, limit := tsquery(terms), clampLimit(q.Limit)
+	phone := ""
+	if digits := strings.Join(terms, ""); strings.Trim(digits, "+0123456789") == "" {
+		phone = "%" + strings.TrimPrefix(digits, "+") + "%"
+	}
+
+	rows, err := p.db.QueryContext(ctx, rebind(`
+		SELECT u.user_id, u.username, TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')),
+		       u.email, COALESCE(u.phone_number, '')
+		FROM users u
+		WHERE (`+userVector("u.")+` @@ to_tsquery('simple', ?) OR (? <> '' AND u.phone_number LIKE ?))
+		  AND u.user_id IN (`+sharedMembers+`)
+		ORDER BY ts_rank(`+userVector("u.")+`, to_tsquery('simple', ?)) DESC LIMIT ?`),
+		expr, phone, phone, q.UserID, expr, limit)
+	if err != nil {
+		return res, err
+	}
+	if res.Members, err = scanMembers(rows); err != nil {
+		return res, err
+	}
+
+	rows, err = p.db.QueryContext(ctx, rebind(`
+		SELECT c.id, c.name, COALESCE(c.description, '')
+		FROM chamas c
+		WHERE `+chamaVector("c.")+` @@ to_tsquery('simple', ?) AND c.id IN (`+memberChamas+`)
+		ORDER BY ts_rank(`+chamaVector("c.")+`, to_tsquery('simple', ?)) DESC LIMIT ?`),
+		expr, q.UserID, expr, limit)
+	if err != nil {
+		return res, err
+	}
+	if res.Chamas, err = scanChamas(rows); err != nil {
+		return res, err
+	}
+
+	roles, roleArgs := officialRoles()
+	args := append([]interface{}{expr, q.UserID}, roleArgs...)
+	rows, err = p.db.QueryContext(ctx, rebind(`
+		SELECT `+transactionColumns+`
+		FROM ledger_entries e
+		WHERE `+ledgerVector("e.")+` @@ to_tsquery('simple', ?) AND (`+visibleTransactions(roles)+`)
+		ORDER BY e.effective_at DESC LIMIT ?`),
+		append(args, q.UserID, limit)...)
+	if err != nil {
+		return res, err
+	}
+	res.Transactions, err = scanTransactions(rows)
+	return res, err
+}
@@ -0,0 +1,110 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/money"
+)
+
+// ErrQueryTooShort is returned when no search term has at least MinTermLength characters
+var ErrQueryTooShort = errors.New("search for at least 3 characters")
+
+// MinTermLength is the shortest term that is searched for; shorter ones are ignored
+const MinTermLength = 3
+
+// Query is a search on behalf of a user. Results are limited to what the
+// user may see: members and chamas they share a chama with, and
+// transactions in chamas where they are an official, plus their own.
+type Query struct {
+	Text   string
+	UserID string
+	Limit  int // per kind
+}
+
+// Member is a member search result
+type Member struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Phone    string `json:"phone,omitempty"`
+}
+
+// Chama is a chama search result
+type Chama struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Transaction is a ledger entry search result
+type Transaction struct {
+	ID          string      `json:"id"`
+	ChamaID     string      `json:"chamaId"`
+	MemberID    string      `json:"memberId,omitempty"`
+	Type        string      `json:"type"`
+	Amount      money.Money `json:"amount"`
+	Reference   string      `json:"reference,omitempty"`
+	Description string      `json:"description,omitempty"`
+	EffectiveAt time.Time   `json:"effectiveAt"`
+}
+
+// Results are search results grouped by kind, best matches first
+type Results struct {
+	Members      []Member      `json:"members"`
+	Chamas       []Chama       `json:"chamas"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// Searcher runs searches against one database engine
+type Searcher interface {
+	Search(ctx context.Context, q Query) (Results, error)
+}
+
+// New returns the searcher for the database driver, "sqlite" or "postgres"
+func New(db *sql.DB, driver string) Searcher {
+	if driver == "postgres" {
+		return &Postgres{db: db}
+	}
+	return &SQLite{db: db}
+}
+
+// Terms splits text into search terms, dropping ones that are too short.
+// Phone numbers typed in local format (0712...) also match numbers stored
+// in international format (+254712...), so their leading zero is dropped.
+func Terms(text string) ([]string, error) {
+	var terms []string
+	for _, t := range strings.Fields(strings.ToLower(text)) {
+		if len(t) > MinTermLength && t[0] == '0' && strings.Trim(t, "0123456789") == "" {
+			t = t[1:]
+		}
+		if len([]rune(t)) >= MinTermLength {
+			terms = append(terms, t)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, ErrQueryTooShort
+	}
+	return terms, nil
+}
+
+func clampLimit(limit int) int {
+	if limit <= 0 || limit > 50 {
+		return 10
+	}
+	return limit
+}
+
+// officialRoles returns placeholders and arguments for chamas.OfficialRoles
+func officialRoles() (string, []interface{}) {
+	args := make([]interface{}, len(chamas.OfficialRoles))
+	for i, r := range chamas.OfficialRoles {
+		args[i] = r
+	}
+	return "?" + strings.Repeat(", ?", len(args)-1), args
+}
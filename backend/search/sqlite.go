@@ -0,0 +1,129 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// SQLite searches the FTS5 indexes defined in the schema
+type SQLite struct {
+	db *sql.DB
+}
+
+// match builds an FTS5 query that requires every term. Terms are quoted so
+// user input cannot use FTS5 query syntax.
+func match(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (s *SQLite) Search(ctx context.Context, q Query) (Results, error) {
+	res := Results{Members: []Member{}, Chamas: []Chama{}, Transactions: []Transaction{}}
+	terms, err := Terms(q.Text)
+	if err != nil {
+		return res, err
+	}
+	expr, limit := match(terms), clampLimit(q.Limit)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT u.user_id, u.username, TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')),
+		       u.email, COALESCE(u.phone_number, '')
+		FROM search_users JOIN users u ON u.id = search_users.rowid
+		WHERE search_users MATCH ? AND u.user_id IN (`+sharedMembers+`)
+		ORDER BY search_users.rank LIMIT ?`,
+		expr, q.UserID, limit)
+	if err != nil {
+		return res, err
+	}
+	if res.Members, err = scanMembers(rows); err != nil {
+		return res, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT c.id, c.name, COALESCE(c.description, '')
+		FROM search_chamas JOIN chamas c ON c.rowid = search_chamas.rowid
+		WHERE search_chamas MATCH ? AND c.id IN (`+memberChamas+`)
+		ORDER BY search_chamas.rank LIMIT ?`,
+		expr, q.UserID, limit)
+	if err != nil {
+		return res, err
+	}
+	if res.Chamas, err = scanChamas(rows); err != nil {
+		return res, err
+	}
+
+	roles, roleArgs := officialRoles()
+	args := append([]interface{}{expr, q.UserID}, roleArgs...)
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT `+transactionColumns+`
+		FROM search_ledger JOIN ledger_entries e ON e.rowid = search_ledger.rowid
+		WHERE search_ledger MATCH ? AND (`+visibleTransactions(roles)+`)
+		ORDER BY search_ledger.rank LIMIT ?`,
+		append(args, q.UserID, limit)...)
+	if err != nil {
+		return res, err
+	}
+	res.Transactions, err = scanTransactions(rows)
+	return res, err
+}
+
+// Scoping subqueries shared by both engines. Each takes the user ID.
+const (
+	memberChamas  = `SELECT chama_id FROM chama_members WHERE user_id = ? AND status = 'active'`
+	sharedMembers = `SELECT user_id FROM chama_members WHERE status = 'active' AND chama_id IN (` + memberChamas + `)`
+)
+
+// visibleTransactions limits entries to chamas where the user holds one of
+// roles, or to entries for the user. It takes the user ID, the roles and the
+// user ID again.
+func visibleTransactions(roles string) string {
+	return `e.chama_id IN (SELECT chama_id FROM chama_members WHERE user_id = ? AND status = 'active' AND role IN (` +
+		roles + `)) OR e.member_id = ?`
+}
+
+const transactionColumns = `e.id, e.chama_id, COALESCE(e.member_id, ''), e.entry_type, e.amount_minor, e.currency,
+	COALESCE(e.reference, ''), COALESCE(e.description, ''), e.effective_at`
+
+func scanMembers(rows *sql.Rows) ([]Member, error) {
+	defer rows.Close()
+	list := []Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Name, &m.Email, &m.Phone); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+func scanChamas(rows *sql.Rows) ([]Chama, error) {
+	defer rows.Close()
+	list := []Chama{}
+	for rows.Next() {
+		var c Chama
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+func scanTransactions(rows *sql.Rows) ([]Transaction, error) {
+	defer rows.Close()
+	list := []Transaction{}
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.ChamaID, &t.MemberID, &t.Type, &t.Amount.Amount, &t.Amount.Currency,
+			&t.Reference, &t.Description, &t.EffectiveAt); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,15 @@
+package secrets
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// SourcesHandler lists the variables resolved from secret references and
+// when each secret last changed. Values are never shown.
+func SourcesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Sources())
+	}
+}
@@ -0,0 +1,192 @@
+// Package secrets keeps secrets out of plaintext configuration. Any
+// variable may hold a reference in place of the secret itself:
+//
+//	file:/run/secrets/mpesa_consumer_secret                  a file's contents, e.g. a Docker or Kubernetes secret
+//	vault:secret/data/tujifund#mpesa_consumer_secret         a field of a Vault KV secret
+//	sops:/etc/tujifund/secrets.enc.yaml#mpesa.consumer_secret a value in a SOPS-encrypted file
+//
+// Resolve replaces each reference with the secret it points at, so the rest
+// of the server reads secrets with os.Getenv as before, and values injected
+// straight into the environment keep working. Resolve runs again on every
+// configuration reload, picking up rotated secrets. Resolved values are
+// masked in the logs.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider reads the secrets stored at a path, keyed by field. A path that
+// holds a single secret keys it by "".
+type Provider interface {
+	Read(ctx context.Context, path string) (map[string]string, error)
+}
+
+// ProviderFunc adapts a function to Provider
+type ProviderFunc func(ctx context.Context, path string) (map[string]string, error)
+
+// Read implements Provider
+func (f ProviderFunc) Read(ctx context.Context, path string) (map[string]string, error) {
+	return f(ctx, path)
+}
+
+// Source describes where a variable's secret came from, without its value
+type Source struct {
+	Name       string    `json:"name"`
+	Reference  string    `json:"reference"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+}
+
+// minMasked is the shortest value masked in logs; shorter values would
+// mask ordinary words
+const minMasked = 6
+
+var (
+	// resolving serialises Resolve, which calls out to providers and logs,
+	// so it must not hold mu, which log masking takes
+	resolving sync.Mutex
+
+	mu        sync.RWMutex
+	providers = map[string]Provider{
+		"file":  ProviderFunc(readFile),
+		"vault": ProviderFunc(readVault),
+		"sops":  ProviderFunc(readSOPS),
+	}
+	// sources holds the reference behind each resolved variable and values
+	// what it resolved to, so a reference is resolved again on every pass
+	// unless the variable has since been set to something else
+	sources = map[string]Source{}
+	values  = map[string]string{}
+)
+
+// Register adds a provider for references starting with scheme followed by
+// a colon
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	providers[scheme] = p
+	mu.Unlock()
+}
+
+// Resolve replaces every secret reference in the environment with the
+// secret it points at. A variable that cannot be resolved keeps its
+// current value and is reported in the error, by name only.
+func Resolve(ctx context.Context) error {
+	resolving.Lock()
+	defer resolving.Unlock()
+
+	mu.RLock()
+	oldSources, oldValues := sources, values
+	mu.RUnlock()
+	newSources, newValues := map[string]Source{}, map[string]string{}
+
+	// Each document is read once a pass however many variables use it
+	docs := map[string]map[string]string{}
+	var errs []error
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		ref := value
+		if s, ok := oldSources[name]; ok && value == oldValues[name] {
+			ref = s.Reference
+		}
+		scheme, path, field, p := parse(ref)
+		if p == nil {
+			continue
+		}
+		// Until it resolves, a variable keeps its last secret
+		if s, ok := oldSources[name]; ok && s.Reference == ref {
+			newSources[name], newValues[name] = s, oldValues[name]
+		}
+
+		doc, ok := docs[scheme+":"+path]
+		if !ok {
+			var err error
+			if doc, err = p.Read(ctx, path); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			docs[scheme+":"+path] = doc
+		}
+		secret, ok := doc[field]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s:%s has no field %q", name, scheme, path, field))
+			continue
+		}
+		source := Source{Name: name, Reference: ref, ResolvedAt: time.Now().UTC()}
+		if old, ok := oldSources[name]; ok && old.Reference == ref && oldValues[name] == secret {
+			source = old
+		}
+		newSources[name], newValues[name] = source, secret
+		// Mask the secret before anything could log it
+		mu.Lock()
+		values[name] = secret
+		mu.Unlock()
+		os.Setenv(name, secret)
+	}
+
+	mu.Lock()
+	sources, values = newSources, newValues
+	mu.Unlock()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	slog.InfoContext(ctx, "Secrets resolved", "count", len(newSources))
+	return nil
+}
+
+// parse splits a reference into its scheme, path and field and returns the
+// provider for it. Values that are not references return a nil provider.
+func parse(ref string) (scheme, path, field string, p Provider) {
+	scheme, rest, found := strings.Cut(ref, ":")
+	if !found {
+		return "", "", "", nil
+	}
+	mu.RLock()
+	p = providers[scheme]
+	mu.RUnlock()
+	path, field, _ = strings.Cut(rest, "#")
+	if p == nil || path == "" {
+		return "", "", "", nil
+	}
+	return scheme, path, field, p
+}
+
+// Sources lists the variables resolved from references, by name
+func Sources() []Source {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]Source, 0, len(sources))
+	for _, s := range sources {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Redact returns s with every resolved secret in it replaced by "[redacted]"
+func Redact(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, v := range values {
+		if len(v) >= minMasked && strings.Contains(s, v) {
+			s = strings.ReplaceAll(s, v, "[redacted]")
+		}
+	}
+	return s
+}
+
+// readFile reads a secret kept in a file of its own, ignoring a trailing newline
+func readFile(ctx context.Context, path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return map[string]string{"": strings.TrimRight(string(data), "\r\n")}, nil
+}
@@ -0,0 +1,36 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// readSOPS decrypts a SOPS-encrypted YAML, JSON or env file with the sops
+// binary, SOPS_BIN or sops on the PATH. sops finds the key itself, from
+// SOPS_AGE_KEY_FILE or the cloud KMS credentials in the environment. Nested
+// fields are named by their dotted path, e.g. mpesa.consumer_secret.
+func readSOPS(ctx context.Context, path string) (map[string]string, error) {
+	bin := os.Getenv("SOPS_BIN")
+	if bin == "" {
+		bin = "sops"
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, "--decrypt", "--output-type", "json", path)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		// sops explains itself on stderr, which never holds decrypted values
+		msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n")
+		return nil, fmt.Errorf("failed to decrypt %s: %w: %s", path, err, msg)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &doc); err != nil {
+		return nil, fmt.Errorf("invalid decrypted document %s: %w", path, err)
+	}
+	delete(doc, "sops")
+	return flatten(doc, ""), nil
+}
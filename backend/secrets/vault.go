@@ -0,0 +1,90 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/httpclient"
+)
+
+// readVault reads a secret from the Vault server at VAULT_ADDR, signing in
+// with VAULT_TOKEN or the token in VAULT_TOKEN_FILE. The file is read on
+// every pass, so a token renewed by Vault Agent is picked up. The path is
+// the secret's API path under /v1, e.g. secret/data/tujifund for the
+// tujifund secret of a KV version 2 engine mounted at secret/.
+func readVault(ctx context.Context, path string) (map[string]string, error) {
+	addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if file := os.Getenv("VAULT_TOKEN_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vault token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return nil, errors.New("VAULT_TOKEN or VAULT_TOKEN_FILE is required")
+	}
+
+	client := httpclient.New(httpclient.Config{Provider: "vault", Timeout: 10 * time.Second, Sensitive: true})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Vault secret %s: %w", path, err)
+	}
+	if err := httpclient.CheckStatus(resp); err != nil {
+		return nil, fmt.Errorf("failed to read Vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid Vault response for %s: %w", path, err)
+	}
+	// KV version 2 nests the secret in data.data beside its metadata
+	data := body.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	return flatten(data, ""), nil
+}
+
+// flatten turns a decoded document into fields named by their dotted path.
+// Strings are kept as they are and other values are JSON-encoded.
+func flatten(doc map[string]interface{}, prefix string) map[string]string {
+	out := map[string]string{}
+	for k, v := range doc {
+		switch v := v.(type) {
+		case string:
+			out[prefix+k] = v
+		case map[string]interface{}:
+			for fk, fv := range flatten(v, prefix+k+".") {
+				out[fk] = fv
+			}
+		default:
+			data, _ := json.Marshal(v)
+			out[prefix+k] = string(data)
+		}
+	}
+	return out
+}
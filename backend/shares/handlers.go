@@ -0,0 +1,389 @@
+package shares
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// managerRoles may configure shares, record purchases and approve transfers
+var managerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// ConfigHandler returns the {chamaId} chama's share configuration
+func ConfigHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		c, err := GetConfig(r.Context(), db, chamaID)
+		if err == ErrNotConfigured {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// UpdateConfigHandler sets the {chamaId} chama's share value and per-member limits
+func UpdateConfigHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			ShareValue string `json:"shareValue"`
+			MinShares  int64  `json:"minShares"`
+			MaxShares  int64  `json:"maxShares"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("shareValue", request.ShareValue) {
+			v.Amount("shareValue", request.ShareValue)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		currency, err := money.ChamaCurrency(db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		value, _ := money.Parse(request.ShareValue, currency)
+		c, err := SetConfig(r.Context(), db, Config{
+			ChamaID:    chamaID,
+			ShareValue: value,
+			MinShares:  request.MinShares,
+			MaxShares:  request.MaxShares,
+		}, audit.FromRequest(r, audit.Entry{}))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// PurchaseHandler records a member's payment for new shares in the {chamaId}
+// chama. Managers record purchases once the money has been received.
+func PurchaseHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			MemberID  string `json:"memberId"`
+			Shares    int64  `json:"shares"`
+			AccountID string `json:"accountId"`
+			Reference string `json:"reference"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("memberId", request.MemberID) && !chamas.IsMember(db, chamaID, request.MemberID) {
+			v.Add("memberId", validation.CodeNotFound, nil)
+		}
+		v.Required("accountId", request.AccountID)
+		if request.Shares <= 0 {
+			v.Add("shares", validation.CodeAmount, nil)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		t, err := Buy(r.Context(), db, Purchase{
+			ChamaID:   chamaID,
+			MemberID:  request.MemberID,
+			Shares:    request.Shares,
+			AccountID: request.AccountID,
+			Reference: request.Reference,
+		}, audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+// TransferHandler requests a transfer of the caller's shares in the {chamaId}
+// chama to another member. A manager must approve it before the shares move.
+func TransferHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			RecipientID string `json:"recipientId"`
+			Shares      int64  `json:"shares"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("recipientId", request.RecipientID) && !chamas.IsMember(db, chamaID, request.RecipientID) {
+			v.Add("recipientId", validation.CodeNotFound, nil)
+		}
+		if request.Shares <= 0 {
+			v.Add("shares", validation.CodeAmount, nil)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		t, err := RequestTransfer(r.Context(), db, Transfer{
+			ChamaID:     chamaID,
+			MemberID:    userID,
+			RecipientID: request.RecipientID,
+			Shares:      request.Shares,
+		})
+		if !writeError(w, err) {
+			return
+		}
+		notifyApprovers(r.Context(), db, notifier, t)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+// ApproveTransferHandler approves the {transactionId} share transfer and moves the shares
+func ApproveTransferHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decideHandler(db, w, r, func(id, userID string) (Transaction, error) {
+			return ApproveTransfer(r.Context(), db, id, audit.FromRequest(r, audit.Entry{}))
+		})
+	}
+}
+
+// RejectTransferHandler rejects the {transactionId} share transfer with a reason
+func RejectTransferHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+			http.Error(w, "A reason is required when rejecting a transfer", http.StatusBadRequest)
+			return
+		}
+		decideHandler(db, w, r, func(id, userID string) (Transaction, error) {
+			return RejectTransfer(r.Context(), db, id, userID, request.Reason)
+		})
+	}
+}
+
+func decideHandler(db *sql.DB, w http.ResponseWriter, r *http.Request, decide func(id, userID string) (Transaction, error)) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	t, err := GetTransaction(r.Context(), db, mux.Vars(r)["transactionId"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Share transfer not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !chamas.HasRole(db, t.ChamaID, userID, managerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	t, err = decide(t.ID, userID)
+	if !writeError(w, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// RegisterHandler returns the {chamaId} chama's share register
+func RegisterHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		reg, err := Register(r.Context(), db, chamaID)
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reg)
+	}
+}
+
+// ListTransactionsHandler lists the {chamaId} chama's share purchases and
+// transfers, optionally filtered by ?status=
+func ListTransactionsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		list, err := ListTransactions(r.Context(), db, chamaID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// DividendPreviewHandler shows how ?amount= would be shared between the
+// {chamaId} chama's shareholders if paid out as a dividend. Officials only.
+func DividendPreviewHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		currency, err := money.ChamaCurrency(db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		amount, err := money.Parse(r.URL.Query().Get("amount"), currency)
+		if err != nil || amount.Amount <= 0 {
+			http.Error(w, "amount must be a positive amount", http.StatusBadRequest)
+			return
+		}
+		weights, err := Allocate(r.Context(), db, chamaID, amount)
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(weights)
+	}
+}
+
+// writeError writes the response for err, returning true if there was none
+func writeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrNotConfigured):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrDecided), errors.Is(err, ErrSelfApproval):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrLimit), errors.Is(err, ErrInsufficientShares),
+		errors.Is(err, ledger.ErrAccountClosed), errors.Is(err, ledger.ErrCurrencyMismatch):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// notifyApprovers asks the chama's managers to review t
+func notifyApprovers(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, t Transaction) {
+	if notifier == nil {
+		return
+	}
+	approvers, err := chamas.MembersWithRole(db, t.ChamaID, managerRoles...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load share transfer approvers", "transaction_id", t.ID, "error", err)
+		return
+	}
+	params := map[string]string{"shares": strconv.FormatInt(t.Shares, 10), "value": t.Total.String()}
+	for _, id := range approvers {
+		if id == t.RequestedBy {
+			continue
+		}
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "share_transfer.requested", nil),
+			Message:   i18n.T(i18n.Default, "notification.share_transfer_requested", params),
+			Type:      notifications.TypeChama,
+			RelatedID: t.ID,
+		})
+	}
+}
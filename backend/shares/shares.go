@@ -0,0 +1,403 @@
+package shares
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Share transaction types
+const (
+	TypePurchase = "purchase"
+	TypeTransfer = "transfer"
+)
+
+// Share transaction statuses. Purchases are completed when recorded;
+// transfers wait for an official's approval.
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusRejected  = "rejected"
+)
+
+var (
+	// ErrNotConfigured is returned when trading shares before the chama has set a share value
+	ErrNotConfigured = errors.New("shares have not been set up for this chama")
+	// ErrLimit is returned when a member would hold more or fewer shares than the chama allows
+	ErrLimit = errors.New("member's shareholding would be outside the chama's limits")
+	// ErrInsufficientShares is returned when transferring more shares than the member has free
+	ErrInsufficientShares = errors.New("member does not hold enough shares")
+	// ErrDecided is returned when approving or rejecting a transfer that is no longer pending
+	ErrDecided = errors.New("share transfer has already been decided")
+	// ErrSelfApproval is returned when the requester tries to approve their own transfer
+	ErrSelfApproval = errors.New("share transfers must be approved by someone other than the requester")
+)
+
+// Config is how a chama's shares are priced and how many each member may hold.
+// MaxShares is zero when there is no cap.
+type Config struct {
+	ChamaID    string      `json:"chamaId"`
+	ShareValue money.Money `json:"shareValue"`
+	MinShares  int64       `json:"minShares"`
+	MaxShares  int64       `json:"maxShares,omitempty"`
+	UpdatedBy  string      `json:"updatedBy,omitempty"`
+	UpdatedAt  time.Time   `json:"updatedAt"`
+}
+
+// Querier is a *sql.DB or *sql.Tx
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// GetConfig returns the chama's share configuration, or ErrNotConfigured
+func GetConfig(ctx context.Context, q Querier, chamaID string) (Config, error) {
+	c := Config{ChamaID: chamaID}
+	var max sql.NullInt64
+	var updatedBy sql.NullString
+	err := q.QueryRowContext(ctx, `
+		SELECT share_value_minor, currency, min_shares_per_member, max_shares_per_member, updated_by, updated_at
+		FROM shares_config WHERE chama_id = ?`, chamaID,
+	).Scan(&c.ShareValue.Amount, &c.ShareValue.Currency, &c.MinShares, &max, &updatedBy, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return c, ErrNotConfigured
+	}
+	c.MaxShares, c.UpdatedBy = max.Int64, updatedBy.String
+	return c, err
+}
+
+// SetConfig creates or replaces the chama's share configuration. Changing the
+// share value prices future purchases only; shares already held keep what
+// was paid for them.
+func SetConfig(ctx context.Context, db *sql.DB, c Config, entry audit.Entry) (Config, error) {
+	if c.ShareValue.Amount <= 0 {
+		return c, errors.New("share value must be positive")
+	}
+	if c.MinShares < 0 || (c.MaxShares > 0 && c.MaxShares < c.MinShares) {
+		return c, errors.New("maximum shares must not be below the minimum")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return c, err
+	}
+	defer txn.Rollback(tx)
+
+	old, err := GetConfig(ctx, tx, c.ChamaID)
+	if err != nil && err != ErrNotConfigured {
+		return c, err
+	}
+	var max interface{}
+	if c.MaxShares > 0 {
+		max = c.MaxShares
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO shares_config (id, chama_id, share_value_minor, currency, min_shares_per_member, max_shares_per_member, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chama_id) DO UPDATE SET share_value_minor = excluded.share_value_minor,
+			currency = excluded.currency, min_shares_per_member = excluded.min_shares_per_member,
+			max_shares_per_member = excluded.max_shares_per_member, updated_by = excluded.updated_by,
+			updated_at = CURRENT_TIMESTAMP`,
+		uuid.NewString(), c.ChamaID, c.ShareValue.Amount, c.ShareValue.Currency, c.MinShares, max, entry.UserID)
+	if err != nil {
+		return c, fmt.Errorf("failed to save share configuration: %w", err)
+	}
+
+	entry.Action, entry.EntityType, entry.EntityID = "shares.configure", "chama", c.ChamaID
+	if old.ShareValue.Currency != "" {
+		entry.OldValues = old
+	}
+	entry.NewValues = c
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return c, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return c, err
+	}
+	return GetConfig(ctx, db, c.ChamaID)
+}
+
+// Transaction is a purchase of new shares by a member, or a transfer of
+// shares from one member to another
+type Transaction struct {
+	ID              string      `json:"id"`
+	ChamaID         string      `json:"chamaId"`
+	MemberID        string      `json:"memberId"`
+	Type            string      `json:"type"`
+	Shares          int64       `json:"shares"`
+	SharePrice      money.Money `json:"sharePrice"`
+	Total           money.Money `json:"total"`
+	RecipientID     string      `json:"recipientId,omitempty"`
+	Status          string      `json:"status"`
+	RequestedBy     string      `json:"requestedBy"`
+	DecidedBy       string      `json:"decidedBy,omitempty"`
+	DecidedAt       string      `json:"decidedAt,omitempty"`
+	RejectionReason string      `json:"rejectionReason,omitempty"`
+	LedgerEntryID   string      `json:"ledgerEntryId,omitempty"`
+	Date            time.Time   `json:"date"`
+}
+
+const transactionColumns = `id, chama_id, member_id, transaction_type, shares_count, share_price_minor, total_minor,
+	currency, COALESCE(recipient_id, ''), status, requested_by, COALESCE(decided_by, ''), COALESCE(decided_at, ''),
+	COALESCE(rejection_reason, ''), COALESCE(ledger_entry_id, ''), transaction_date`
+
+func scanTransaction(row interface{ Scan(...interface{}) error }) (Transaction, error) {
+	var t Transaction
+	err := row.Scan(&t.ID, &t.ChamaID, &t.MemberID, &t.Type, &t.Shares, &t.SharePrice.Amount, &t.Total.Amount,
+		&t.Total.Currency, &t.RecipientID, &t.Status, &t.RequestedBy, &t.DecidedBy, &t.DecidedAt,
+		&t.RejectionReason, &t.LedgerEntryID, &t.Date)
+	t.SharePrice.Currency = t.Total.Currency
+	return t, err
+}
+
+// GetTransaction returns a single share transaction
+func GetTransaction(ctx context.Context, q Querier, id string) (Transaction, error) {
+	return scanTransaction(q.QueryRowContext(ctx, `SELECT `+transactionColumns+` FROM share_transactions WHERE id = ?`, id))
+}
+
+// ListTransactions returns a chama's share transactions, newest first,
+// optionally only those with status
+func ListTransactions(ctx context.Context, db *sql.DB, chamaID, status string) ([]Transaction, error) {
+	query := `SELECT ` + transactionColumns + ` FROM share_transactions WHERE chama_id = ?`
+	args := []interface{}{chamaID}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY transaction_date DESC, created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Transaction{}
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// Purchase is a member buying new shares, paid into AccountID
+type Purchase struct {
+	ChamaID   string
+	MemberID  string
+	Shares    int64
+	AccountID string
+	Reference string
+}
+
+// Buy records a member's payment for new shares at the current share value.
+// The payment is posted to the fund as share capital against the member, and
+// their holding may not go above the chama's maximum or end below its minimum.
+func Buy(ctx context.Context, db *sql.DB, p Purchase, entry audit.Entry) (Transaction, error) {
+	if p.Shares <= 0 {
+		return Transaction{}, errors.New("number of shares must be positive")
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Transaction{}, err
+	}
+	defer txn.Rollback(tx)
+
+	c, err := GetConfig(ctx, tx, p.ChamaID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	held, err := holding(ctx, tx, p.ChamaID, p.MemberID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if !c.allows(held + p.Shares) {
+		return Transaction{}, ErrLimit
+	}
+
+	total := money.New(c.ShareValue.Amount*p.Shares, c.ShareValue.Currency)
+	now := time.Now().UTC()
+	t := Transaction{
+		ID: uuid.NewString(), ChamaID: p.ChamaID, MemberID: p.MemberID, Type: TypePurchase, Shares: p.Shares,
+		SharePrice: c.ShareValue, Total: total, Status: StatusCompleted, RequestedBy: entry.UserID, Date: now,
+	}
+	description := fmt.Sprintf("Purchase of %d shares", p.Shares)
+	if p.Reference != "" {
+		description += " (" + p.Reference + ")"
+	}
+	e, err := ledger.Post(ctx, tx, ledger.Entry{
+		ChamaID: p.ChamaID, AccountID: p.AccountID, MemberID: p.MemberID, Type: ledger.TypeShareCapital,
+		Amount: total, Reference: t.ID, Description: description, EffectiveAt: now, CreatedBy: entry.UserID,
+	})
+	if err != nil {
+		return t, err
+	}
+	t.LedgerEntryID = e.ID
+
+	if err := adjust(ctx, tx, p.ChamaID, p.MemberID, p.Shares, total); err != nil {
+		return t, err
+	}
+	if err := insert(ctx, tx, t); err != nil {
+		return t, err
+	}
+
+	entry.Action, entry.EntityType, entry.EntityID = "shares.purchase", "share_transaction", t.ID
+	entry.NewValues = t
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return t, err
+	}
+	return t, txn.Commit(tx)
+}
+
+// allows reports whether a member may hold n shares
+func (c Config) allows(n int64) bool {
+	return n >= c.MinShares && (c.MaxShares == 0 || n <= c.MaxShares)
+}
+
+// holding is how many shares a member holds
+func holding(ctx context.Context, q Querier, chamaID, memberID string) (int64, error) {
+	var n int64
+	err := q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(shares_count), 0) FROM member_shares WHERE chama_id = ? AND member_id = ?`,
+		chamaID, memberID).Scan(&n)
+	return n, err
+}
+
+// adjust adds shares and their value to a member's entry in the register
+func adjust(ctx context.Context, tx *sql.Tx, chamaID, memberID string, shares int64, value money.Money) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO member_shares (id, chama_id, member_id, shares_count, total_value_minor, currency)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chama_id, member_id) DO UPDATE SET shares_count = shares_count + excluded.shares_count,
+			total_value_minor = total_value_minor + excluded.total_value_minor, updated_at = CURRENT_TIMESTAMP`,
+		uuid.NewString(), chamaID, memberID, shares, value.Amount, value.Currency)
+	if err != nil {
+		return fmt.Errorf("failed to update share register: %w", err)
+	}
+	return nil
+}
+
+func insert(ctx context.Context, tx *sql.Tx, t Transaction) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO share_transactions
+		(id, chama_id, member_id, transaction_type, shares_count, share_price_minor, total_minor, currency,
+		 recipient_id, transaction_date, status, requested_by, ledger_entry_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.ChamaID, t.MemberID, t.Type, t.Shares, t.SharePrice.Amount, t.Total.Amount, t.Total.Currency,
+		nullIfEmpty(t.RecipientID), t.Date.Format("2006-01-02 15:04:05"), t.Status, t.RequestedBy,
+		nullIfEmpty(t.LedgerEntryID))
+	if err != nil {
+		return fmt.Errorf("failed to record share transaction: %w", err)
+	}
+	return nil
+}
+
+// Holding is one member's line in the share register
+type Holding struct {
+	MemberID string      `json:"memberId"`
+	Name     string      `json:"name"`
+	Shares   int64       `json:"shares"`
+	Value    money.Money `json:"value"` // what the member paid for their shares
+	Percent  float64     `json:"percent"`
+}
+
+// ShareRegister is every member's shareholding in a chama
+type ShareRegister struct {
+	ChamaID     string      `json:"chamaId"`
+	ShareValue  money.Money `json:"shareValue"`
+	TotalShares int64       `json:"totalShares"`
+	TotalValue  money.Money `json:"totalValue"`
+	Holdings    []Holding   `json:"holdings"`
+}
+
+// Register returns the chama's share register, largest holdings first
+func Register(ctx context.Context, db *sql.DB, chamaID string) (ShareRegister, error) {
+	c, err := GetConfig(ctx, db, chamaID)
+	if err != nil {
+		return ShareRegister{}, err
+	}
+	reg := ShareRegister{
+		ChamaID:    chamaID,
+		ShareValue: c.ShareValue,
+		TotalValue: money.New(0, c.ShareValue.Currency),
+		Holdings:   []Holding{},
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT ms.member_id, TRIM(COALESCE(u.first_name, '') || ' ' || COALESCE(u.last_name, '')), ms.shares_count, ms.total_value_minor, ms.currency
+		FROM member_shares ms
+		LEFT JOIN users u ON u.user_id = ms.member_id
+		WHERE ms.chama_id = ? AND ms.shares_count > 0
+		ORDER BY ms.shares_count DESC, u.first_name`, chamaID)
+	if err != nil {
+		return reg, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h Holding
+		if err := rows.Scan(&h.MemberID, &h.Name, &h.Shares, &h.Value.Amount, &h.Value.Currency); err != nil {
+			return reg, err
+		}
+		reg.Holdings = append(reg.Holdings, h)
+		reg.TotalShares += h.Shares
+		if reg.TotalValue, err = reg.TotalValue.Add(h.Value); err != nil {
+			return reg, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return reg, err
+	}
+	for i := range reg.Holdings {
+		reg.Holdings[i].Percent = float64(reg.Holdings[i].Shares) * 100 / float64(reg.TotalShares)
+	}
+	return reg, nil
+}
+
+// Weight is one member's share of a distribution
+type Weight struct {
+	MemberID string      `json:"memberId"`
+	Shares   int64       `json:"shares"`
+	Amount   money.Money `json:"amount"`
+}
+
+// Allocate splits total between the chama's shareholders by the number of
+// shares each holds, leaving no remainder. It is the share-weighted input for
+// dividends; shares still pending transfer count towards their current holder.
+func Allocate(ctx context.Context, db *sql.DB, chamaID string, total money.Money) ([]Weight, error) {
+	reg, err := Register(ctx, db, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	weights := []Weight{}
+	if reg.TotalShares == 0 {
+		return weights, nil
+	}
+	ratios := make([]int64, len(reg.Holdings))
+	for i, h := range reg.Holdings {
+		ratios[i] = h.Shares
+	}
+	parts, err := total.Allocate(ratios...)
+	if err != nil {
+		return nil, err
+	}
+	for i, h := range reg.Holdings {
+		weights = append(weights, Weight{MemberID: h.MemberID, Shares: h.Shares, Amount: parts[i]})
+	}
+	return weights, nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+package shares
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"tujifund-app/backend/money"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestAllocate(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", "file:"+t.TempDir()+"/shares.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	schema, err := os.ReadFile("../database/database_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		string(schema),
+		`INSERT INTO users (user_id, username, email, first_name) VALUES
+			('u1', 'u1', 'u1@example.com', 'Achieng'), ('u2', 'u2', 'u2@example.com', 'Baraka'),
+			('u3', 'u3', 'u3@example.com', 'Chebet'), ('u4', 'u4', 'u4@example.com', 'Duma')`,
+		`INSERT INTO chamas (id, name, type, created_by) VALUES ('c1', 'Chama', 'savings', 'u1'), ('c2', 'Empty', 'savings', 'u1')`,
+		`INSERT INTO shares_config (id, chama_id, share_value_minor) VALUES ('s1', 'c1', 100000), ('s2', 'c2', 100000)`,
+		`INSERT INTO member_shares (id, chama_id, member_id, shares_count, total_value_minor) VALUES
+			('m1', 'c1', 'u1', 3, 300000), ('m2', 'c1', 'u2', 2, 200000), ('m3', 'c1', 'u3', 2, 180000), ('m4', 'c1', 'u4', 0, 0)`,
+		// a transfer still awaiting approval leaves the shares with u1
+		`INSERT INTO share_transactions (id, chama_id, member_id, transaction_type, shares_count, share_price_minor, total_minor,
+			recipient_id, status, requested_by) VALUES ('t1', 'c1', 'u1', 'transfer', 1, 100000, 100000, 'u2', 'pending', 'u1')`,
+	} {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		chamaID string
+		total   int64
+		want    []Weight
+		err     error
+	}{
+		{
+			name: "by shares held, remainder to the largest holding", chamaID: "c1", total: 100000,
+			want: []Weight{
+				{MemberID: "u1", Shares: 3, Amount: money.New(42858, "KES")},
+				{MemberID: "u2", Shares: 2, Amount: money.New(28571, "KES")},
+				{MemberID: "u3", Shares: 2, Amount: money.New(28571, "KES")},
+			},
+		},
+		{
+			name: "exact split", chamaID: "c1", total: 700,
+			want: []Weight{
+				{MemberID: "u1", Shares: 3, Amount: money.New(300, "KES")},
+				{MemberID: "u2", Shares: 2, Amount: money.New(200, "KES")},
+				{MemberID: "u3", Shares: 2, Amount: money.New(200, "KES")},
+			},
+		},
+		{
+			name: "nothing to distribute", chamaID: "c1", total: 0,
+			want: []Weight{
+				{MemberID: "u1", Shares: 3, Amount: money.New(0, "KES")},
+				{MemberID: "u2", Shares: 2, Amount: money.New(0, "KES")},
+				{MemberID: "u3", Shares: 2, Amount: money.New(0, "KES")},
+			},
+		},
+		{name: "no shareholders", chamaID: "c2", total: 100000, want: []Weight{}},
+		{name: "shares not configured", chamaID: "c3", total: 100000, err: ErrNotConfigured},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Allocate(ctx, db, tt.chamaID, money.New(tt.total, "KES"))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Allocate() error = %v, want %v", err, tt.err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Allocate() = %+v, want %+v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("weight %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
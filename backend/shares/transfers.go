@@ -0,0 +1,192 @@
+package shares
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/money"
+
+	"github.com/google/uuid"
+)
+
+// Transfer is a request by a member to pass some of their shares to another member
+type Transfer struct {
+	ChamaID     string
+	MemberID    string
+	RecipientID string
+	Shares      int64
+}
+
+// RequestTransfer records a pending transfer of shares between two members.
+// The member may only transfer shares not already promised in another
+// pending transfer, and must either keep the chama's minimum or give up all
+// their shares.
+func RequestTransfer(ctx context.Context, db *sql.DB, t Transfer) (Transaction, error) {
+	if t.Shares <= 0 {
+		return Transaction{}, errors.New("number of shares must be positive")
+	}
+	if t.MemberID == t.RecipientID {
+		return Transaction{}, errors.New("cannot transfer shares to yourself")
+	}
+	c, err := GetConfig(ctx, db, t.ChamaID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	held, value, err := holdingValue(ctx, db, t.ChamaID, t.MemberID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	var pending int64
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(shares_count), 0) FROM share_transactions
+		WHERE chama_id = ? AND member_id = ? AND transaction_type = ? AND status = ?`,
+		t.ChamaID, t.MemberID, TypeTransfer, StatusPending).Scan(&pending)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if t.Shares > held-pending {
+		return Transaction{}, ErrInsufficientShares
+	}
+	if left := held - pending - t.Shares; left != 0 && !c.allows(left) {
+		return Transaction{}, ErrLimit
+	}
+	received, err := holding(ctx, db, t.ChamaID, t.RecipientID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if !c.allows(received + t.Shares) {
+		return Transaction{}, ErrLimit
+	}
+
+	total := money.New(value.Amount*t.Shares/held, value.Currency)
+	tr := Transaction{
+		ID: uuid.NewString(), ChamaID: t.ChamaID, MemberID: t.MemberID, Type: TypeTransfer, Shares: t.Shares,
+		SharePrice: money.New(total.Amount/t.Shares, total.Currency), Total: total, RecipientID: t.RecipientID,
+		Status: StatusPending, RequestedBy: t.MemberID, Date: time.Now().UTC(),
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return tr, err
+	}
+	defer tx.Rollback()
+	if err := insert(ctx, tx, tr); err != nil {
+		return tr, err
+	}
+	if err := tx.Commit(); err != nil {
+		return tr, err
+	}
+	return GetTransaction(ctx, db, tr.ID)
+}
+
+// ApproveTransfer moves the shares of a pending transfer from the member to
+// the recipient. The value paid for them moves with them, in proportion to
+// the member's holding when the transfer is approved.
+func ApproveTransfer(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Transaction, error) {
+	t, err := GetTransaction(ctx, db, id)
+	if err != nil {
+		return t, err
+	}
+	if t.Type != TypeTransfer || t.Status != StatusPending {
+		return t, ErrDecided
+	}
+	if t.RequestedBy == entry.UserID {
+		return t, ErrSelfApproval
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback()
+
+	c, err := GetConfig(ctx, tx, t.ChamaID)
+	if err != nil {
+		return t, err
+	}
+	held, value, err := holdingValue(ctx, tx, t.ChamaID, t.MemberID)
+	if err != nil {
+		return t, err
+	}
+	if t.Shares > held {
+		return t, ErrInsufficientShares
+	}
+	received, err := holding(ctx, tx, t.ChamaID, t.RecipientID)
+	if err != nil {
+		return t, err
+	}
+	if !c.allows(received + t.Shares) {
+		return t, ErrLimit
+	}
+
+	moved := money.New(value.Amount*t.Shares/held, value.Currency)
+	if t.Shares == held {
+		moved = value
+	}
+	if err := adjust(ctx, tx, t.ChamaID, t.MemberID, -t.Shares, moved.Negate()); err != nil {
+		return t, err
+	}
+	if err := adjust(ctx, tx, t.ChamaID, t.RecipientID, t.Shares, moved); err != nil {
+		return t, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE share_transactions SET status = ?, total_minor = ?, share_price_minor = ?, decided_by = ?,
+			decided_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?`,
+		StatusCompleted, moved.Amount, moved.Amount/t.Shares, entry.UserID, id, StatusPending)
+	if err != nil {
+		return t, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return t, ErrDecided
+	}
+
+	entry.Action, entry.EntityType, entry.EntityID = "shares.transfer", "share_transaction", t.ID
+	entry.OldValues = map[string]interface{}{"status": t.Status}
+	entry.NewValues = map[string]interface{}{
+		"status": StatusCompleted, "from": t.MemberID, "to": t.RecipientID, "shares": t.Shares, "value": moved,
+	}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return t, err
+	}
+	if err := tx.Commit(); err != nil {
+		return t, err
+	}
+	return GetTransaction(ctx, db, id)
+}
+
+// RejectTransfer rejects a pending transfer with a reason
+func RejectTransfer(ctx context.Context, db *sql.DB, id, rejectedBy, reason string) (Transaction, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE share_transactions SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP,
+			rejection_reason = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND transaction_type = ? AND status = ?`,
+		StatusRejected, rejectedBy, reason, id, TypeTransfer, StatusPending)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Transaction{}, ErrDecided
+	}
+	return GetTransaction(ctx, db, id)
+}
+
+// holdingValue is how many shares a member holds and what they paid for them
+func holdingValue(ctx context.Context, q Querier, chamaID, memberID string) (int64, money.Money, error) {
+	var n int64
+	var value money.Money
+	err := q.QueryRowContext(ctx, `
+		SELECT shares_count, total_value_minor, currency FROM member_shares WHERE chama_id = ? AND member_id = ?`,
+		chamaID, memberID).Scan(&n, &value.Amount, &value.Currency)
+	if err == sql.ErrNoRows {
+		return 0, value, ErrInsufficientShares
+	}
+	if err != nil {
+		return 0, value, fmt.Errorf("failed to load shareholding: %w", err)
+	}
+	return n, value, nil
+}
@@ -0,0 +1,63 @@
+package signing
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"tujifund-app/backend/audit"
+)
+
+// JWKSHandler serves the public keys at /.well-known/jwks.json. Verifiers
+// may cache the set for a while; new keys are published well before use.
+func JWKSHandler(k *Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := k.JWKS(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to load signing keys", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=900")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}
+}
+
+// ListHandler lists the live keys without their private parts. Wrap it in
+// admin.Require.
+func ListHandler(k *Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := k.Keys(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// RotateHandler replaces the current key. The new key is published an hour
+// before use unless revoke is set, which replaces it at once and
+// invalidates every token the old keys signed. Wrap it in admin.Require and
+// twofactor.Require.
+func RotateHandler(k *Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Revoke bool `json:"revoke"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		key, err := k.Rotate(r.Context(), PublishAhead, req.Revoke, audit.FromRequest(r, audit.Entry{}))
+		if err != nil {
+			http.Error(w, "Failed to rotate signing key", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(key)
+	}
+}
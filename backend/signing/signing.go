@@ -0,0 +1,435 @@
+// Package signing manages the keys login tokens are signed with. Tokens are
+// signed with ES256 so that other services can verify them with the public
+// keys published at /.well-known/jwks.json, without sharing a secret.
+//
+// Several keys are live at once. A new key is published PublishAhead before
+// it signs anything, so verifiers caching the key set see it in time, and
+// the key it replaces stays published until every token it signed has
+// expired. Keys are rotated by a job every JWT_ROTATION_INTERVAL, 30 days
+// by default, or by platform staff, at once if a key has leaked. Private
+// keys are kept in the database, encrypted with JWT_KEY_ENCRYPTION_KEY when
+// it is set.
+package signing
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/jobs"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// Algorithm is the JWS algorithm tokens are signed with
+	Algorithm = "ES256"
+	// TokenLifetime is how long a login token is valid, and so how long a
+	// replaced key stays published
+	TokenLifetime = 24 * time.Hour
+	// PublishAhead is how long a new key is published before it is used
+	PublishAhead = time.Hour
+	// DefaultRotationInterval is how often keys are replaced unless
+	// JWT_ROTATION_INTERVAL says otherwise
+	DefaultRotationInterval = 30 * 24 * time.Hour
+	// refreshInterval is how often keys are reloaded, so that rotations made
+	// by another server instance are picked up
+	refreshInterval = time.Minute
+)
+
+// ErrNoKey is returned when there is no key to sign with
+var ErrNoKey = errors.New("no signing key is active")
+
+// ErrInvalidToken is returned for tokens that fail verification
+var ErrInvalidToken = errors.New("invalid token")
+
+// Key is a signing key's metadata
+type Key struct {
+	ID          string     `json:"kid"`
+	Algorithm   string     `json:"alg"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ActivatesAt time.Time  `json:"activatesAt"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"` // set once the key has been replaced
+	Status      string     `json:"status"`              // pending, current or retiring
+	CreatedBy   string     `json:"createdBy,omitempty"`
+
+	private *ecdsa.PrivateKey
+}
+
+// Keyring holds the live keys, reloaded from the database periodically
+type Keyring struct {
+	db *sql.DB
+
+	mu     sync.RWMutex
+	keys   []Key // newest first
+	loaded time.Time
+}
+
+// New creates a keyring
+func New(db *sql.DB) *Keyring {
+	return &Keyring{db: db}
+}
+
+// Ensure creates a key, active at once, if there is none. It is called at
+// startup so that the first login can be signed.
+func (k *Keyring) Ensure(ctx context.Context) error {
+	keys, err := k.live(ctx)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return nil
+	}
+	_, err = k.create(ctx, k.db, time.Now().UTC(), "")
+	if err == nil {
+		k.Refresh()
+	}
+	return err
+}
+
+// Refresh drops the cached keys so the next use reloads them
+func (k *Keyring) Refresh() {
+	k.mu.Lock()
+	k.keys = nil
+	k.mu.Unlock()
+}
+
+// Keys lists the live keys, newest first
+func (k *Keyring) Keys(ctx context.Context) ([]Key, error) {
+	return k.live(ctx)
+}
+
+// Sign signs claims with the current key, naming it in the kid header
+func (k *Keyring) Sign(ctx context.Context, claims jwt.MapClaims) (string, error) {
+	keys, err := k.live(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range keys {
+		if key.Status == "current" {
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+			token.Header["kid"] = key.ID
+			return token.SignedString(key.private)
+		}
+	}
+	return "", ErrNoKey
+}
+
+// Verify checks a token's signature against the published keys and its
+// expiry, returning its claims
+func (k *Keyring) Verify(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	keys, err := k.live(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != Algorithm {
+			return nil, fmt.Errorf("unexpected algorithm %s", t.Method.Alg())
+		}
+		kid, _ := t.Header["kid"].(string)
+		for _, key := range keys {
+			if key.ID == kid {
+				return &key.private.PublicKey, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key %q", kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Rotate creates a key that replaces the current one after ahead, and sets
+// the keys it replaces to expire once the tokens they sign have. With revoke
+// the new key is used at once and the old ones expire at once, invalidating
+// the tokens they signed, as when a key has leaked.
+func (k *Keyring) Rotate(ctx context.Context, ahead time.Duration, revoke bool, e audit.Entry) (Key, error) {
+	if revoke {
+		ahead = 0
+	}
+	tx, err := k.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Key{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	key, err := k.create(ctx, tx, now.Add(ahead), e.UserID)
+	if err != nil {
+		return Key{}, err
+	}
+	expires := key.ActivatesAt.Add(TokenLifetime)
+	if revoke {
+		expires = now
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE signing_keys SET expires_at = ?
+		WHERE id != ? AND (expires_at IS NULL OR expires_at > ?)`,
+		expires, key.ID, expires); err != nil {
+		return Key{}, err
+	}
+	e.Action, e.EntityType, e.EntityID = "signing_key.rotate", "signing_key", key.ID
+	e.NewValues = map[string]interface{}{"activatesAt": key.ActivatesAt, "revoke": revoke}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return Key{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Key{}, err
+	}
+	k.Refresh()
+	slog.InfoContext(ctx, "Signing key rotated", "kid", key.ID, "activates_at", key.ActivatesAt, "revoke", revoke)
+	return key, nil
+}
+
+// RotationIntervalFromEnv reads JWT_ROTATION_INTERVAL, e.g. 720h
+func RotationIntervalFromEnv() (time.Duration, error) {
+	v := os.Getenv("JWT_ROTATION_INTERVAL")
+	if v == "" {
+		return DefaultRotationInterval, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 2*TokenLifetime {
+		return 0, fmt.Errorf("invalid JWT_ROTATION_INTERVAL %q, expected a duration of at least %s", v, 2*TokenLifetime)
+	}
+	return d, nil
+}
+
+// RegisterRotationJob replaces the newest key once it is older than
+// interval and deletes keys that are no longer published
+func RegisterRotationJob(s *jobs.Scheduler, k *Keyring, interval time.Duration) {
+	s.Register("signing_key_rotation", jobs.Every(time.Hour), func(ctx context.Context) error {
+		var newest time.Time
+		err := k.db.QueryRowContext(ctx, `SELECT created_at FROM signing_keys ORDER BY created_at DESC LIMIT 1`).Scan(&newest)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil && time.Since(newest) >= interval {
+			if _, err := k.Rotate(ctx, PublishAhead, false, audit.Entry{}); err != nil {
+				return err
+			}
+		}
+		_, err = k.db.ExecContext(ctx, `DELETE FROM signing_keys WHERE expires_at <= ?`, time.Now().UTC())
+		return err
+	})
+}
+
+// live returns the published keys, reloading them when the cache is stale
+func (k *Keyring) live(ctx context.Context) ([]Key, error) {
+	k.mu.RLock()
+	keys, loaded := k.keys, k.loaded
+	k.mu.RUnlock()
+	if keys != nil && time.Since(loaded) < refreshInterval {
+		return statuses(keys, time.Now()), nil
+	}
+
+	now := time.Now().UTC()
+	rows, err := k.db.QueryContext(ctx, `
+		SELECT id, algorithm, private_key, created_at, activates_at, expires_at, COALESCE(created_by, '')
+		FROM signing_keys WHERE expires_at IS NULL OR expires_at > ?
+		ORDER BY activates_at DESC, created_at DESC`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys = []Key{}
+	for rows.Next() {
+		var key Key
+		var private string
+		var expires sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Algorithm, &private, &key.CreatedAt, &key.ActivatesAt, &expires, &key.CreatedBy); err != nil {
+			return nil, err
+		}
+		if expires.Valid {
+			key.ExpiresAt = &expires.Time
+		}
+		if key.private, err = openKey(private); err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", key.ID, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	k.keys, k.loaded = keys, time.Now()
+	k.mu.Unlock()
+	return statuses(keys, now), nil
+}
+
+// statuses returns keys marked pending, current or retiring at now. The
+// newest key already active is current; older ones are retiring.
+func statuses(keys []Key, now time.Time) []Key {
+	out := make([]Key, len(keys))
+	current := false
+	for i, key := range keys {
+		switch {
+		case key.ActivatesAt.After(now):
+			key.Status = "pending"
+		case !current && (key.ExpiresAt == nil || key.ExpiresAt.After(now)):
+			key.Status, current = "current", true
+		default:
+			key.Status = "retiring"
+		}
+		out[i] = key
+	}
+	return out
+}
+
+// create generates a key that signs from activates. by is empty for keys
+// created by the server itself.
+func (k *Keyring) create(ctx context.Context, db audit.Execer, activates time.Time, by string) (Key, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Key{}, err
+	}
+	sealed, err := sealKey(private)
+	if err != nil {
+		return Key{}, err
+	}
+	key := Key{
+		ID: Thumbprint(&private.PublicKey), Algorithm: Algorithm,
+		CreatedAt: time.Now().UTC(), ActivatesAt: activates, CreatedBy: by, private: private,
+	}
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO signing_keys (id, algorithm, private_key, created_at, activates_at, created_by)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, ''))`,
+		key.ID, key.Algorithm, sealed, key.CreatedAt, key.ActivatesAt, by); err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// Thumbprint is the RFC 7638 JWK thumbprint of pub, used as its kid
+func Thumbprint(pub *ecdsa.PublicKey) string {
+	jwk := publicJWK(pub, "")
+	data := fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk.Curve, jwk.KeyType, jwk.X, jwk.Y)
+	sum := sha256.Sum256([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// sealKey encodes a private key for storage, encrypted with
+// JWT_KEY_ENCRYPTION_KEY when it is set
+func sealKey(private *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+	block := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	gcm, err := keyCipher()
+	if err != nil || gcm == nil {
+		return string(block), err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return "v1:" + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, block, nil)), nil
+}
+
+// openKey decodes a private key sealed by sealKey
+func openKey(sealed string) (*ecdsa.PrivateKey, error) {
+	block := []byte(sealed)
+	if rest, ok := strings.CutPrefix(sealed, "v1:"); ok {
+		gcm, err := keyCipher()
+		if err != nil {
+			return nil, err
+		}
+		if gcm == nil {
+			return nil, errors.New("key is encrypted but JWT_KEY_ENCRYPTION_KEY is not set")
+		}
+		data, err := base64.StdEncoding.DecodeString(rest)
+		if err != nil || len(data) < gcm.NonceSize() {
+			return nil, errors.New("invalid encrypted key")
+		}
+		if block, err = gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil); err != nil {
+			return nil, errors.New("failed to decrypt key; has JWT_KEY_ENCRYPTION_KEY changed?")
+		}
+	}
+	p, _ := pem.Decode(block)
+	if p == nil {
+		return nil, errors.New("invalid key encoding")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(p.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an ECDSA key")
+	}
+	return private, nil
+}
+
+// keyCipher returns the AES-GCM cipher keys are stored with, or nil if
+// JWT_KEY_ENCRYPTION_KEY is not set
+func keyCipher() (cipher.AEAD, error) {
+	secret := os.Getenv("JWT_KEY_ENCRYPTION_KEY")
+	if secret == "" {
+		return nil, nil
+	}
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// JWK is a public key in JSON Web Key form
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+func publicJWK(pub *ecdsa.PublicKey, kid string) JWK {
+	// An uncompressed point is 0x04 followed by X and Y
+	point, _ := pub.ECDH()
+	raw := point.Bytes()
+	size := (len(raw) - 1) / 2
+	return JWK{
+		KeyType: "EC", Curve: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(raw[1 : 1+size]),
+		Y:   base64.RawURLEncoding.EncodeToString(raw[1+size:]),
+		Use: "sig", Algorithm: Algorithm, KeyID: kid,
+	}
+}
+
+// JWKS returns the published public keys, pending and retiring ones included
+func (k *Keyring) JWKS(ctx context.Context) ([]JWK, error) {
+	keys, err := k.live(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set := make([]JWK, 0, len(keys))
+	for _, key := range keys {
+		set = append(set, publicJWK(&key.private.PublicKey, key.ID))
+	}
+	return set, nil
+}
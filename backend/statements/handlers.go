@@ -0,0 +1,276 @@
+package statements
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/imports"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/storage"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// ImportHandler accepts a multipart upload of the {chamaId} chama's M-Pesa
+// paybill statement or bank export (CSV or XLSX) in "file", with "source"
+// (mpesa or bank) and optionally "accountId", the fund new contributions go to
+func ImportHandler(db *sql.DB, store storage.Backend, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, contributions.ConfirmerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if err := r.ParseMultipartForm(imports.MaxFileSize); err != nil {
+			http.Error(w, "Invalid upload or file too large", http.StatusBadRequest)
+			return
+		}
+		source := r.FormValue("source")
+		v := validation.New()
+		if v.Required("source", source) {
+			v.OneOf("source", source, Sources...)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "File is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		t, err := imports.Read(file, header.Filename)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res, err := Import(r.Context(), db, store, notifier, chamaID, r.FormValue("accountId"), source, header.Filename, userID, t)
+		switch {
+		case errors.Is(err, ErrUnknownFormat):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrUnknownAccount), errors.Is(err, contributions.ErrNoFund):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+			UserID: userID, Action: "statement.import", EntityType: "statement_import", EntityID: res.ID,
+			NewValues: map[string]interface{}{
+				"source": source, "filename": res.Filename, "total": res.Total, "matched": res.Matched,
+				"unmatched": res.Unmatched, "duplicates": res.Duplicates,
+			},
+		}))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit statement import", "import_id", res.ID, "error", err)
+		}
+
+		lang := i18n.FromRequest(r)
+		for i := range res.Invalid {
+			res.Invalid[i].Errors = res.Invalid[i].Errors.Localize(lang)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// ListHandler lists the {chamaId} chama's uploaded statements
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := List(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// LinesHandler lists the {chamaId} chama's statement lines. Supports ?status=
+// (e.g. unmatched for the reconciliation queue) and ?importId=.
+func LinesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		q := r.URL.Query()
+		list, err := Lines(r.Context(), db, chamaID, q.Get("status"), q.Get("importId"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// CandidatesHandler lists the pending contributions the {lineId} line could be
+// matched to
+func CandidatesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := officialLine(db, w, r)
+		if !ok {
+			return
+		}
+		list, err := Candidates(r.Context(), db, l)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// MatchHandler matches the unmatched {lineId} line by hand. The body takes
+// either contributionId, a pending contribution it pays, or memberId, the
+// member who paid it.
+func MatchHandler(db *sql.DB, store storage.Backend, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := officialLine(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			MemberID       string `json:"memberId"`
+			ContributionID string `json:"contributionId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.ContributionID == "" {
+			v := validation.New()
+			v.Required("memberId", request.MemberID)
+			if !v.Valid() {
+				validation.WriteErrors(w, r, v.Errors())
+				return
+			}
+		}
+
+		userID := r.Context().Value("userID").(string)
+		l, err := MatchLine(r.Context(), db, store, notifier, l.ID, request.MemberID, request.ContributionID, userID)
+		if !writeError(w, err) {
+			return
+		}
+		audited(r, db, userID, "statement.match", l)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l)
+	}
+}
+
+// IgnoreHandler sets aside the unmatched {lineId} line as not a
+// contribution. The body may give a note, e.g. "Loan repayment".
+func IgnoreHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := officialLine(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Note string `json:"note"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		userID := r.Context().Value("userID").(string)
+		l, err := IgnoreLine(r.Context(), db, l.ID, userID, request.Note)
+		if !writeError(w, err) {
+			return
+		}
+		audited(r, db, userID, "statement.ignore", l)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l)
+	}
+}
+
+// officialLine loads the {lineId} line for one of its chama's confirmers,
+// writing an error response and returning false otherwise
+func officialLine(db *sql.DB, w http.ResponseWriter, r *http.Request) (Line, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Line{}, false
+	}
+	l, err := GetLine(r.Context(), db, mux.Vars(r)["lineId"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Statement line not found", http.StatusNotFound)
+		return l, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return l, false
+	}
+	if !chamas.HasRole(db, l.ChamaID, userID, contributions.ConfirmerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return l, false
+	}
+	return l, true
+}
+
+func audited(r *http.Request, db *sql.DB, userID, action string, l Line) {
+	err := audit.Record(r.Context(), db, audit.FromRequest(r, audit.Entry{
+		UserID: userID, Action: action, EntityType: "statement_line", EntityID: l.ID,
+		NewValues: map[string]interface{}{
+			"status": l.Status, "memberId": l.MemberID, "contributionId": l.ContributionID, "note": l.Note,
+		},
+	}))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to audit statement line", "line_id", l.ID, "error", err)
+	}
+}
+
+// writeError writes the response for err and reports whether there was none
+func writeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		http.Error(w, "Contribution not found", http.StatusNotFound)
+	case errors.Is(err, ErrNotUnmatched), errors.Is(err, contributions.ErrNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrAmountMismatch), errors.Is(err, ErrNotMember), errors.Is(err, contributions.ErrNoFund),
+		errors.Is(err, ledger.ErrAccountClosed), errors.Is(err, ledger.ErrCurrencyMismatch):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package statements
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"tujifund-app/backend/export/xlsx"
+	"tujifund-app/backend/imports"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/validation"
+)
+
+// Column names accepted for each field, after imports.Read has normalised the
+// header and punctuation is dropped, so "Receipt No." is receipt_no and
+// "A/C No." is ac_no. The M-Pesa names are those of the paybill statement
+// downloaded from the M-Pesa org portal.
+var (
+	dateColumns        = []string{"completion_time", "transaction_date", "date", "value_date", "posting_date", "txn_date", "initiation_time"}
+	amountColumns      = []string{"paid_in", "credit", "credit_amount", "money_in", "deposit", "deposits", "amount"}
+	referenceColumns   = []string{"receipt_no", "receipt", "reference", "ref", "ref_no", "transaction_reference", "transaction_id", "cheque_no"}
+	descriptionColumns = []string{"details", "description", "narrative", "narration", "particulars", "transaction_details"}
+	partyColumns       = []string{"other_party_info", "other_party"}
+	accountRefColumns  = []string{"ac_no", "account_no", "account_number", "bill_reference", "bill_ref"}
+	phoneColumns       = []string{"phone", "phone_number", "msisdn", "mobile"}
+	nameColumns        = []string{"payer", "payer_name", "name", "sender"}
+	statusColumns      = []string{"transaction_status", "status"}
+)
+
+// dateLayouts are the date formats seen on M-Pesa and bank exports. Numeric
+// dates are day first, as printed in Kenya.
+var dateLayouts = []string{
+	"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02",
+	"02-01-2006 15:04:05", "02/01/2006 15:04:05", "02-01-2006 15:04", "02/01/2006 15:04",
+	"02-01-2006", "02/01/2006", "2/1/2006", "02.01.2006",
+	"02 Jan 2006", "2 Jan 2006", "02-Jan-2006", "02-Jan-06", "Jan 2, 2006",
+}
+
+// parse reads the credit rows of a statement in currency, numbering them from
+// first, the file line of the first data row. Debits, incomplete M-Pesa
+// transactions and blank rows are skipped; rows whose date or amount cannot be
+// read are returned as invalid.
+func parse(t imports.Table, first int, currency string) (lines []Line, invalid []imports.RowResult, skipped int) {
+	col := columnIndex(t.Header)
+	for i := range t.Rows {
+		if t.Blank(i) {
+			continue
+		}
+		get := func(names []string) string {
+			for _, n := range names {
+				if c, ok := col[n]; ok && c < len(t.Rows[i]) {
+					if v := strings.TrimSpace(t.Rows[i][c]); v != "" {
+						return v
+					}
+				}
+			}
+			return ""
+		}
+		if status := strings.ToLower(get(statusColumns)); status != "" && status != "completed" && status != "success" {
+			skipped++
+			continue
+		}
+		row := first + i
+		v := validation.New()
+
+		raw := strings.NewReplacer(currency, "", " ", "").Replace(get(amountColumns))
+		if raw == "" {
+			skipped++ // a withdrawal or a charge
+			continue
+		}
+		amount, err := money.Parse(strings.Trim(raw, "()"), currency)
+		if err != nil {
+			v.Add("amount", validation.CodeAmount, nil)
+		} else if amount.Amount <= 0 || strings.HasPrefix(raw, "(") {
+			skipped++
+			continue
+		}
+		date, ok := parseDate(get(dateColumns))
+		if !ok {
+			v.Add("date", validation.CodeDate, nil)
+		}
+		if !v.Valid() {
+			invalid = append(invalid, imports.RowResult{Row: row, Errors: v.Errors()})
+			continue
+		}
+
+		l := Line{
+			Row:         row,
+			Date:        date,
+			Reference:   get(referenceColumns),
+			Phone:       get(phoneColumns),
+			PayerName:   get(nameColumns),
+			AccountRef:  get(accountRefColumns),
+			Description: get(descriptionColumns),
+			Amount:      amount,
+		}
+		// M-Pesa gives the payer as "254712345678 - JANE WANJIRU", often
+		// with the middle digits masked
+		if party := get(partyColumns); party != "" {
+			phone, name, found := strings.Cut(party, " - ")
+			if !found {
+				phone, name = "", party
+			}
+			if l.Phone == "" {
+				l.Phone = strings.TrimSpace(phone)
+			}
+			if l.PayerName == "" {
+				l.PayerName = strings.TrimSpace(name)
+			}
+		}
+		if l.Phone != "" {
+			l.Phone = payments.MSISDN(l.Phone)
+		}
+		lines = append(lines, l)
+	}
+	return lines, invalid, skipped
+}
+
+// columnIndex maps each header, stripped of punctuation, to its column
+func columnIndex(header []string) map[string]int {
+	col := map[string]int{}
+	for c, h := range header {
+		h = strings.Map(func(r rune) rune {
+			if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, h)
+		if _, seen := col[h]; !seen {
+			col[h] = c
+		}
+	}
+	return col
+}
+
+// parseDate reads a statement date in any of dateLayouts, or an Excel serial
+func parseDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 {
+		return xlsx.SerialDate(serial), true
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,517 @@
+package statements
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/imports"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// Statement sources
+const (
+	SourceMpesa = "mpesa" // M-Pesa paybill statement
+	SourceBank  = "bank"  // bank CSV or XLSX export
+)
+
+// Sources are the statement formats that can be imported
+var Sources = []string{SourceMpesa, SourceBank}
+
+// Statement line statuses
+const (
+	LineMatched   = "matched"   // recorded as, or completed, a contribution
+	LineUnmatched = "unmatched" // waiting for an official to match it
+	LineDuplicate = "duplicate" // already recorded or imported before
+	LineIgnored   = "ignored"   // not a contribution, e.g. a loan repayment or a refund
+)
+
+var (
+	// ErrUnknownFormat is returned for a file with no recognisable amount column
+	ErrUnknownFormat = errors.New("statement has no paid in, credit or amount column")
+	// ErrUnknownAccount is returned when the fund is not one of the chama's
+	ErrUnknownAccount = errors.New("fund not found in this chama")
+	// ErrNotUnmatched is returned when matching or ignoring a settled line
+	ErrNotUnmatched = errors.New("statement line has already been matched or ignored")
+	// ErrAmountMismatch is returned when matching a line to a contribution of a different amount
+	ErrAmountMismatch = errors.New("statement amount does not match the contribution")
+	// ErrNotMember is returned when matching a line to someone outside the chama
+	ErrNotMember = errors.New("payer is not a member of this chama")
+)
+
+// Statement is an uploaded statement
+type Statement struct {
+	ID         string    `json:"id"`
+	ChamaID    string    `json:"chamaId"`
+	AccountID  string    `json:"accountId"`
+	Source     string    `json:"source"`
+	Filename   string    `json:"filename"`
+	Total      int       `json:"total"`
+	Matched    int       `json:"matched"`
+	Unmatched  int       `json:"unmatched"`
+	Duplicates int       `json:"duplicates"`
+	ImportedBy string    `json:"importedBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Result is an imported statement with the lines read from the file. Skipped rows are
+// debits and incomplete transactions; invalid rows could not be read.
+type Result struct {
+	Statement
+	Skipped int                 `json:"skipped"`
+	Invalid []imports.RowResult `json:"invalid,omitempty"`
+	Lines   []Line              `json:"lines"`
+}
+
+// Line is a payment into the chama read from a statement
+type Line struct {
+	ID             string      `json:"id"`
+	ImportID       string      `json:"importId"`
+	ChamaID        string      `json:"chamaId"`
+	Row            int         `json:"row"`
+	Date           time.Time   `json:"date"`
+	Reference      string      `json:"reference,omitempty"`
+	Phone          string      `json:"phone,omitempty"`
+	PayerName      string      `json:"payerName,omitempty"`
+	AccountRef     string      `json:"accountReference,omitempty"`
+	Description    string      `json:"description,omitempty"`
+	Amount         money.Money `json:"amount"`
+	Status         string      `json:"status"`
+	MemberID       string      `json:"memberId,omitempty"`
+	ContributionID string      `json:"contributionId,omitempty"`
+	MatchedBy      string      `json:"matchedBy,omitempty"`
+	Note           string      `json:"note,omitempty"`
+}
+
+const lineColumns = `id, import_id, chama_id, row_number, transaction_date, COALESCE(reference, ''),
+	COALESCE(phone_number, ''), COALESCE(payer_name, ''), COALESCE(account_reference, ''), COALESCE(description, ''),
+	amount_minor, currency, status, COALESCE(member_id, ''), COALESCE(contribution_id, ''), COALESCE(matched_by, ''),
+	COALESCE(note, '')`
+
+func scanLine(row interface{ Scan(...interface{}) error }) (Line, error) {
+	var l Line
+	err := row.Scan(&l.ID, &l.ImportID, &l.ChamaID, &l.Row, &l.Date, &l.Reference, &l.Phone, &l.PayerName,
+		&l.AccountRef, &l.Description, &l.Amount.Amount, &l.Amount.Currency, &l.Status, &l.MemberID,
+		&l.ContributionID, &l.MatchedBy, &l.Note)
+	return l, err
+}
+
+// GetLine returns a single statement line
+func GetLine(ctx context.Context, db *sql.DB, id string) (Line, error) {
+	return scanLine(db.QueryRowContext(ctx, `SELECT `+lineColumns+` FROM statement_lines WHERE id = ?`, id))
+}
+
+// Lines returns a chama's statement lines, newest first, optionally filtered
+// by status and import
+func Lines(ctx context.Context, db *sql.DB, chamaID, status, importID string) ([]Line, error) {
+	query := `SELECT ` + lineColumns + ` FROM statement_lines WHERE chama_id = ?`
+	args := []interface{}{chamaID}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	if importID != "" {
+		query += ` AND import_id = ?`
+		args = append(args, importID)
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY transaction_date DESC, row_number`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Line{}
+	for rows.Next() {
+		l, err := scanLine(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	return list, rows.Err()
+}
+
+// List returns a chama's uploaded statements, newest first
+func List(ctx context.Context, db *sql.DB, chamaID string) ([]Statement, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, chama_id, account_id, source, filename, total_lines, matched_lines, unmatched_lines,
+		       duplicate_lines, imported_by, created_at
+		FROM statement_imports WHERE chama_id = ? ORDER BY created_at DESC`, chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Statement{}
+	for rows.Next() {
+		var i Statement
+		if err := rows.Scan(&i.ID, &i.ChamaID, &i.AccountID, &i.Source, &i.Filename, &i.Total, &i.Matched,
+			&i.Unmatched, &i.Duplicates, &i.ImportedBy, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, i)
+	}
+	return list, rows.Err()
+}
+
+// Import reads a statement and matches each payment in it. A payment whose
+// reference is already recorded is a duplicate. One that names a pending bank
+// transfer's reference, or a member's pending contribution of the same amount,
+// completes it. Any other payment from a member's phone is recorded as a new
+// contribution to accountID, or the chama's default fund. The rest are left
+// unmatched for an official to match by hand.
+func Import(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, chamaID, accountID, source, filename, importedBy string, t imports.Table) (Result, error) {
+	t, first := findHeader(t)
+	if _, ok := firstColumn(columnIndex(t.Header), amountColumns); !ok {
+		return Result{}, ErrUnknownFormat
+	}
+	currency, err := money.ChamaCurrency(db, chamaID)
+	if err != nil {
+		return Result{}, err
+	}
+	if accountID == "" {
+		if accountID, err = contributions.DefaultFund(ctx, db, chamaID); err != nil {
+			return Result{}, err
+		}
+	} else {
+		var found int
+		db.QueryRowContext(ctx, `SELECT COUNT(*) FROM chama_accounts WHERE id = ? AND chama_id = ?`,
+			accountID, chamaID).Scan(&found)
+		if found == 0 {
+			return Result{}, ErrUnknownAccount
+		}
+	}
+
+	lines, invalid, skipped := parse(t, first, currency)
+	res := Result{
+		Statement: Statement{
+			ID: uuid.NewString(), ChamaID: chamaID, AccountID: accountID, Source: source, Filename: filename,
+			ImportedBy: importedBy, CreatedAt: time.Now().UTC(),
+		},
+		Skipped: skipped,
+		Invalid: invalid,
+		Lines:   []Line{},
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO statement_imports (id, chama_id, account_id, source, filename, imported_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		res.ID, chamaID, accountID, source, filename, importedBy, res.CreatedAt)
+	if err != nil {
+		return res, fmt.Errorf("failed to record statement: %w", err)
+	}
+
+	phones, err := memberPhones(ctx, db, chamaID)
+	if err != nil {
+		return res, err
+	}
+	for _, l := range lines {
+		l.ID, l.ImportID, l.ChamaID = uuid.NewString(), res.ID, chamaID
+		match(ctx, db, store, notifier, res.Statement, &l, phones)
+		if err := insertLine(ctx, db, l); err != nil {
+			return res, err
+		}
+		res.Total++
+		switch l.Status {
+		case LineMatched:
+			res.Matched++
+		case LineDuplicate:
+			res.Duplicates++
+		default:
+			res.Unmatched++
+		}
+		res.Lines = append(res.Lines, l)
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE statement_imports SET total_lines = ?, matched_lines = ?, unmatched_lines = ?, duplicate_lines = ?
+		WHERE id = ?`,
+		res.Total, res.Matched, res.Unmatched, res.Duplicates, res.ID)
+	return res, err
+}
+
+// match settles l automatically if it can, leaving it unmatched otherwise
+func match(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, imp Statement, l *Line, phones map[string]string) {
+	l.Status = LineUnmatched
+	if l.Reference != "" {
+		err := db.QueryRowContext(ctx, `
+			SELECT id FROM contributions WHERE chama_id = ? AND transaction_reference = ? AND status = ?`,
+			l.ChamaID, l.Reference, contributions.StatusCompleted).Scan(&l.ContributionID)
+		if err == nil {
+			l.Status, l.Note = LineDuplicate, "Already recorded"
+			return
+		}
+		var earlier string
+		err = db.QueryRowContext(ctx, `
+			SELECT id FROM statement_lines WHERE chama_id = ? AND reference = ? AND status != ? LIMIT 1`,
+			l.ChamaID, l.Reference, LineDuplicate).Scan(&earlier)
+		if err == nil {
+			l.Status, l.Note = LineDuplicate, "Already imported"
+			return
+		}
+	}
+
+	// A bank transfer the member told us about, by its reference
+	var pendingID string
+	if l.Reference != "" || l.Description != "" {
+		db.QueryRowContext(ctx, `
+			SELECT id FROM contributions
+			WHERE chama_id = ? AND payment_method = ? AND status = ? AND amount_minor = ?
+			  AND transaction_reference IS NOT NULL AND transaction_reference != ''
+			  AND (UPPER(transaction_reference) = UPPER(?) OR INSTR(UPPER(?), UPPER(transaction_reference)) > 0)
+			ORDER BY contribution_date LIMIT 1`,
+			l.ChamaID, payments.ProviderBank, contributions.StatusPending, l.Amount.Amount,
+			l.Reference, l.Description).Scan(&pendingID)
+	}
+
+	if pendingID == "" {
+		l.MemberID = findMember(phones, l.Phone)
+		if l.MemberID == "" {
+			l.MemberID = findMember(phones, l.AccountRef)
+		}
+		if l.MemberID == "" {
+			return
+		}
+		// A payment the member started in the app whose callback never came
+		db.QueryRowContext(ctx, `
+			SELECT id FROM contributions
+			WHERE chama_id = ? AND member_id = ? AND status = ? AND amount_minor = ?
+			ORDER BY contribution_date LIMIT 1`,
+			l.ChamaID, l.MemberID, contributions.StatusPending, l.Amount.Amount).Scan(&pendingID)
+	}
+
+	var c contributions.Contribution
+	var err error
+	if pendingID != "" {
+		c, err = contributions.Match(ctx, db, store, notifier, pendingID, l.Reference, imp.ImportedBy)
+	} else {
+		c, err = contributions.Record(ctx, db, store, notifier, contributions.Contribution{
+			ChamaID: l.ChamaID, MemberID: l.MemberID, AccountID: imp.AccountID, Amount: l.Amount, Date: l.Date,
+			Method: method(imp.Source), Reference: l.Reference, Notes: "Imported from statement " + imp.Filename,
+		}, imp.ImportedBy)
+	}
+	if err != nil {
+		l.Note = err.Error()
+		return
+	}
+	l.Status, l.MemberID, l.ContributionID, l.MatchedBy = LineMatched, c.MemberID, c.ID, imp.ImportedBy
+}
+
+// MatchLine settles an unmatched line by hand: against the pending
+// contribution contributionID if given, or else as a new contribution from
+// memberID
+func MatchLine(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, lineID, memberID, contributionID, matchedBy string) (Line, error) {
+	l, err := GetLine(ctx, db, lineID)
+	if err != nil {
+		return l, err
+	}
+	if l.Status != LineUnmatched {
+		return l, ErrNotUnmatched
+	}
+	var imp Statement
+	err = db.QueryRowContext(ctx, `SELECT account_id, source, filename FROM statement_imports WHERE id = ?`,
+		l.ImportID).Scan(&imp.AccountID, &imp.Source, &imp.Filename)
+	if err != nil {
+		return l, err
+	}
+
+	var c contributions.Contribution
+	if contributionID != "" {
+		if c, err = contributions.Get(ctx, db, contributionID); err != nil || c.ChamaID != l.ChamaID {
+			return l, sql.ErrNoRows
+		}
+		if c.Amount != l.Amount {
+			return l, ErrAmountMismatch
+		}
+		c, err = contributions.Match(ctx, db, store, notifier, c.ID, l.Reference, matchedBy)
+	} else {
+		if !chamas.IsMember(db, l.ChamaID, memberID) {
+			return l, ErrNotMember
+		}
+		c, err = contributions.Record(ctx, db, store, notifier, contributions.Contribution{
+			ChamaID: l.ChamaID, MemberID: memberID, AccountID: imp.AccountID, Amount: l.Amount, Date: l.Date,
+			Method: method(imp.Source), Reference: l.Reference, Notes: "Imported from statement " + imp.Filename,
+		}, matchedBy)
+	}
+	if err != nil {
+		return l, err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		UPDATE statement_lines
+		SET status = ?, member_id = ?, contribution_id = ?, matched_by = ?, matched_at = CURRENT_TIMESTAMP, note = NULL
+		WHERE id = ?`,
+		LineMatched, c.MemberID, c.ID, matchedBy, l.ID)
+	if err != nil {
+		return l, err
+	}
+	if err := recount(ctx, db, l.ImportID); err != nil {
+		return l, err
+	}
+	return GetLine(ctx, db, l.ID)
+}
+
+// IgnoreLine sets aside an unmatched line that is not a contribution
+func IgnoreLine(ctx context.Context, db *sql.DB, lineID, ignoredBy, note string) (Line, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE statement_lines SET status = ?, matched_by = ?, matched_at = CURRENT_TIMESTAMP, note = ?
+		WHERE id = ? AND status = ?`,
+		LineIgnored, ignoredBy, nullIfEmpty(note), lineID, LineUnmatched)
+	if err != nil {
+		return Line{}, err
+	}
+	l, err := GetLine(ctx, db, lineID)
+	if err != nil {
+		return l, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return l, ErrNotUnmatched
+	}
+	return l, recount(ctx, db, l.ImportID)
+}
+
+// Candidates returns the chama's pending contributions of the same amount as
+// l, the likeliest matches for it
+func Candidates(ctx context.Context, db *sql.DB, l Line) ([]contributions.Contribution, error) {
+	pending, err := contributions.List(ctx, db, l.ChamaID, "", contributions.StatusPending)
+	if err != nil {
+		return nil, err
+	}
+	list := []contributions.Contribution{}
+	for _, c := range pending {
+		if c.Amount == l.Amount {
+			list = append(list, c)
+		}
+	}
+	return list, nil
+}
+
+// recount refreshes an import's unmatched count after lines are settled by hand
+func recount(ctx context.Context, db *sql.DB, importID string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE statement_imports SET
+			matched_lines = (SELECT COUNT(*) FROM statement_lines WHERE import_id = ? AND status = ?),
+			unmatched_lines = (SELECT COUNT(*) FROM statement_lines WHERE import_id = ? AND status IN (?, ?))
+		WHERE id = ?`,
+		importID, LineMatched, importID, LineUnmatched, LineIgnored, importID)
+	return err
+}
+
+func insertLine(ctx context.Context, db *sql.DB, l Line) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO statement_lines (id, import_id, chama_id, row_number, transaction_date, reference, phone_number,
+		                             payer_name, account_reference, description, amount_minor, currency, status,
+		                             member_id, contribution_id, matched_by, note)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		l.ID, l.ImportID, l.ChamaID, l.Row, l.Date, nullIfEmpty(l.Reference), nullIfEmpty(l.Phone),
+		nullIfEmpty(l.PayerName), nullIfEmpty(l.AccountRef), nullIfEmpty(l.Description), l.Amount.Amount,
+		l.Amount.Currency, l.Status, nullIfEmpty(l.MemberID), nullIfEmpty(l.ContributionID),
+		nullIfEmpty(l.MatchedBy), nullIfEmpty(l.Note))
+	if err != nil {
+		return fmt.Errorf("failed to record statement line: %w", err)
+	}
+	return nil
+}
+
+// memberPhones maps the phone numbers of the chama's active members, in
+// international form, to their user IDs
+func memberPhones(ctx context.Context, db *sql.DB, chamaID string) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.user_id, u.phone_number FROM chama_members m JOIN users u ON u.user_id = m.user_id
+		WHERE m.chama_id = ? AND m.status = 'active' AND u.phone_number IS NOT NULL AND u.phone_number != ''`,
+		chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	phones := map[string]string{}
+	for rows.Next() {
+		var userID, phone string
+		if err := rows.Scan(&userID, &phone); err != nil {
+			return nil, err
+		}
+		phones[payments.MSISDN(phone)] = userID
+	}
+	return phones, rows.Err()
+}
+
+// findMember returns the member whose phone is value. M-Pesa masks the
+// middle digits of a payer's number, e.g. 254722***678, so a masked number
+// matches when exactly one member's number fits it.
+func findMember(phones map[string]string, value string) string {
+	if value == "" {
+		return ""
+	}
+	phone := payments.MSISDN(value)
+	if !strings.Contains(phone, "*") {
+		return phones[phone]
+	}
+	prefix := phone[:strings.Index(phone, "*")]
+	suffix := phone[strings.LastIndex(phone, "*")+1:]
+	found := ""
+	for p, userID := range phones {
+		if len(p) > len(prefix)+len(suffix) && strings.HasPrefix(p, prefix) && strings.HasSuffix(p, suffix) {
+			if found != "" {
+				return ""
+			}
+			found = userID
+		}
+	}
+	return found
+}
+
+// findHeader skips the summary rows at the top of a statement, such as the
+// account name and period on an M-Pesa statement, to the row naming the
+// columns. It also returns the file line of the first data row.
+func findHeader(t imports.Table) (imports.Table, int) {
+	if _, ok := firstColumn(columnIndex(t.Header), amountColumns); ok {
+		return t, 2
+	}
+	for i, row := range t.Rows {
+		if i >= 20 {
+			break
+		}
+		header := make([]string, len(row))
+		for c, h := range row {
+			h = strings.ToLower(strings.TrimSpace(h))
+			header[c] = strings.NewReplacer(" ", "_", "-", "_").Replace(h)
+		}
+		if _, ok := firstColumn(columnIndex(header), amountColumns); ok {
+			return imports.Table{Header: header, Rows: t.Rows[i+1:]}, i + 3
+		}
+	}
+	return t, 2
+}
+
+func firstColumn(col map[string]int, names []string) (int, bool) {
+	for _, n := range names {
+		if c, ok := col[n]; ok {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// method is the payment method recorded for contributions from a source
+func method(source string) string {
+	if source == SourceBank {
+		return payments.ProviderBank
+	}
+	return payments.ProviderMpesa
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
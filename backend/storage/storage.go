@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Backend stores opaque blobs under string keys such as "kyc/<user>/<id>.jpg"
+type Backend interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, mimeType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a time-limited download URL for key
+	SignedURL(key string, expiry time.Duration) (string, error)
+}
+
+var (
+	ErrNotFound        = errors.New("file not found")
+	ErrTooLarge        = errors.New("file too large")
+	ErrUnsupportedType = errors.New("unsupported file type")
+)
+
+// Common MIME type sets used by callers
+var (
+	ImageTypes    = []string{"image/jpeg", "image/png"}
+	DocumentTypes = []string{"image/jpeg", "image/png", "application/pdf"}
+)
+
+// Extensions maps accepted MIME types to file extensions
+var Extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+	"text/html":       ".html",
+	"text/csv":        ".csv",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": ".xlsx",
+}
+
+// DetectType sniffs the MIME type of r from its content, checks it against
+// allowed and the size against maxSize, and rewinds r to the start
+func DetectType(r io.ReadSeeker, size, maxSize int64, allowed []string) (string, error) {
+	if size > maxSize {
+		return "", fmt.Errorf("%w: %d bytes (max %d)", ErrTooLarge, size, maxSize)
+	}
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(r, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	mimeType := http.DetectContentType(sniff[:n])
+	for _, a := range allowed {
+		if mimeType == a {
+			return mimeType, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedType, mimeType)
+}
+
+// NewFromEnv builds the backend selected by STORAGE_BACKEND ("local" by default, or "s3")
+func NewFromEnv() (Backend, error) {
+	switch os.Getenv("STORAGE_BACKEND") {
+	case "", "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "data/files"
+		}
+		baseURL := os.Getenv("STORAGE_BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:8080/api/files"
+		}
+		return NewLocal(dir, baseURL, []byte(os.Getenv("STORAGE_SIGNING_KEY")))
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", os.Getenv("STORAGE_BACKEND"))
+	}
+}
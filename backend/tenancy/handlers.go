@@ -0,0 +1,34 @@
+package tenancy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// IsolateHandler moves the {chamaId} chama to a database of its own, for
+// platform staff
+func IsolateHandler(t *Tenants) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staffID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		err := t.Isolate(r.Context(), chamaID, staffID)
+		switch {
+		case errors.Is(err, ErrShared), errors.Is(err, ErrAlreadyIsolated):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"chamaId": chamaID, "status": "isolated"})
+	}
+}
@@ -0,0 +1,292 @@
+// Package tenancy keeps each chama's data apart. The chama a request is for
+// is taken from its {chamaId} route variable and carried in the request
+// context; queries run through DB are scoped to that chama automatically,
+// so a handler cannot read or change another chama's rows by forgetting a
+// condition. Large SACCOs that require physical isolation can be given a
+// database of their own (see Tenants).
+package tenancy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type contextKey string
+
+const (
+	chamaKey contextKey = "chamaID"
+	allKey   contextKey = "allChamas"
+)
+
+var (
+	// ErrNoChama is returned when a scoped query runs without a chama in its context
+	ErrNoChama = errors.New("no chama in context")
+	// ErrUnscopable is returned for queries the scoper cannot safely rewrite
+	ErrUnscopable = errors.New("query cannot be scoped to a chama")
+)
+
+// WithChama returns a copy of ctx scoped to chamaID
+func WithChama(ctx context.Context, chamaID string) context.Context {
+	return context.WithValue(ctx, chamaKey, chamaID)
+}
+
+// ChamaID returns the chama ctx is scoped to, or an empty string
+func ChamaID(ctx context.Context) string {
+	id, _ := ctx.Value(chamaKey).(string)
+	return id
+}
+
+// WithAllChamas returns a copy of ctx that may see every chama's rows, for
+// background jobs and routes that are not under one chama. PostgreSQL's
+// row-level security shows a context with neither this nor a chama no rows.
+func WithAllChamas(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allKey, true)
+}
+
+// AllChamas reports whether ctx may see every chama's rows. A chama set
+// with WithChama takes precedence.
+func AllChamas(ctx context.Context) bool {
+	all, _ := ctx.Value(allKey).(bool)
+	return all && ChamaID(ctx) == ""
+}
+
+// Middleware scopes requests to routes with a {chamaId} variable to that
+// chama. Other routes look chama data up by its own IDs and check the
+// caller's membership themselves, so they may see every chama. It must be
+// installed with router.Use, so route variables are set.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if chamaID := mux.Vars(r)["chamaId"]; chamaID != "" {
+			r = r.WithContext(WithChama(r.Context(), chamaID))
+		} else {
+			r = r.WithContext(WithAllChamas(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// DB runs queries scoped to the chama in their context: a "chama_id = ?"
+// condition is added to the top-level WHERE clause of every SELECT, UPDATE
+// and DELETE. INSERTs are run as written, as they name their chama. Queries
+// that join tables must set Column to the qualified column, e.g. "l.chama_id".
+type DB struct {
+	*sql.DB
+	Column string // defaults to chama_id
+}
+
+// Scoped returns db scoped by the chama_id column
+func Scoped(db *sql.DB) DB {
+	return DB{DB: db, Column: "chama_id"}
+}
+
+// On returns a copy of d scoped by column
+func (d DB) On(column string) DB {
+	d.Column = column
+	return d
+}
+
+// QueryContext runs a scoped query
+func (d DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	query, args, err := d.scope(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return d.DB.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs a scoped query returning one row. Scoping errors are
+// returned by Scan.
+func (d DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) Row {
+	query, args, err := d.scope(ctx, query, args)
+	if err != nil {
+		return Row{err: err}
+	}
+	return Row{row: d.DB.QueryRowContext(ctx, query, args...)}
+}
+
+// Row is the result of QueryRowContext
+type Row struct {
+	row *sql.Row
+	err error
+}
+
+// Scan copies the row's columns into dest, as sql.Row.Scan does
+func (r Row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.row.Scan(dest...)
+}
+
+// ExecContext runs a scoped statement
+func (d DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	query, args, err := d.scope(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return d.DB.ExecContext(ctx, query, args...)
+}
+
+func (d DB) scope(ctx context.Context, query string, args []interface{}) (string, []interface{}, error) {
+	chamaID := ChamaID(ctx)
+	if chamaID == "" {
+		return "", nil, ErrNoChama
+	}
+	column := d.Column
+	if column == "" {
+		column = "chama_id"
+	}
+	scoped, at, err := Scope(query, column)
+	if err != nil {
+		return "", nil, err
+	}
+	if at < 0 {
+		return query, args, nil
+	}
+	if at > len(args) {
+		return "", nil, fmt.Errorf("%w: %d placeholders before the WHERE clause but %d arguments", ErrUnscopable, at, len(args))
+	}
+	scopedArgs := make([]interface{}, 0, len(args)+1)
+	scopedArgs = append(scopedArgs, args[:at]...)
+	scopedArgs = append(scopedArgs, chamaID)
+	scopedArgs = append(scopedArgs, args[at:]...)
+	return scoped, scopedArgs, nil
+}
+
+// clauseEnds are the keywords that end a WHERE clause
+var clauseEnds = []string{"GROUP", "HAVING", "WINDOW", "ORDER", "LIMIT", "RETURNING"}
+
+// Scope adds "column = ?" to the top-level WHERE clause of query, wrapping
+// the existing conditions in parentheses, or adds a WHERE clause if it has
+// none. It returns the rewritten query and the index its placeholder must
+// be bound at, or -1 for an INSERT, which is returned unchanged. Compound
+// SELECTs and statements with a WITH clause are refused.
+func Scope(query, column string) (string, int, error) {
+	words := topLevelWords(query)
+	if len(words) == 0 {
+		return "", 0, ErrUnscopable
+	}
+	switch words[0].text {
+	case "INSERT", "REPLACE":
+		return query, -1, nil
+	case "SELECT", "UPDATE", "DELETE":
+	default:
+		return "", 0, fmt.Errorf("%w: %s statement", ErrUnscopable, words[0].text)
+	}
+
+	where, end := -1, len(query)
+	for _, w := range words {
+		switch {
+		case w.text == "UNION" || w.text == "INTERSECT" || w.text == "EXCEPT":
+			return "", 0, fmt.Errorf("%w: compound SELECT", ErrUnscopable)
+		case w.text == "WHERE" && where < 0:
+			where = w.end
+		case end == len(query) && contains(clauseEnds, w.text):
+			end = w.start
+		}
+	}
+	// Trailing semicolons and whitespace stay at the end
+	tail := strings.TrimRight(query[:end], " \t\r\n;")
+	end = len(tail)
+
+	cond := column + " = ?"
+	var b strings.Builder
+	var at int
+	if where >= 0 {
+		at = placeholders(query[:where])
+		b.WriteString(query[:where])
+		b.WriteString(" " + cond + " AND (")
+		b.WriteString(query[where:end])
+		b.WriteString(")")
+	} else {
+		at = placeholders(query[:end])
+		b.WriteString(query[:end])
+		b.WriteString(" WHERE " + cond)
+	}
+	b.WriteString(query[end:])
+	return b.String(), at, nil
+}
+
+type word struct {
+	text       string // upper-cased
+	start, end int
+}
+
+// topLevelWords returns the keywords and identifiers of query that are not
+// inside parentheses, string literals or comments
+func topLevelWords(query string) []word {
+	var words []word
+	depth := 0
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j := strings.IndexByte(query[i+1:], c)
+			if j < 0 {
+				return words
+			}
+			i += j + 2
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				return words
+			}
+			i += j + 1
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case isWordByte(c):
+			j := i
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			if depth == 0 {
+				words = append(words, word{text: strings.ToUpper(query[i:j]), start: i, end: j})
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+// placeholders counts the ? placeholders in s outside string literals
+func placeholders(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '"', '`':
+			j := strings.IndexByte(s[i+1:], s[i])
+			if j < 0 {
+				return n
+			}
+			i += j + 1
+		case '?':
+			n++
+		}
+	}
+	return n
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package tenancy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+var (
+	// ErrAlreadyIsolated is returned when isolating a chama that has its own database
+	ErrAlreadyIsolated = errors.New("chama already has its own database")
+	// ErrShared is returned when isolating a chama while database-per-tenant mode is off
+	ErrShared = errors.New("database-per-tenant mode is not enabled")
+)
+
+// Opener opens (creating and initialising if needed) a chama's own database
+type Opener func(chamaID string) (*sql.DB, error)
+
+// Tenants finds the database holding a chama's data. In the default shared
+// mode every chama lives in the shared database. In database-per-tenant
+// mode, chamas listed in tenant_databases have a database of their own,
+// opened with Open on first use and kept open.
+//
+// Routes opt in with Handler. Routes that find records by their own ID
+// rather than by chama, and cross-chama views such as a member's dashboard,
+// are served from the shared database, which keeps the chama directory
+// (chamas, chama_members and users) for every tenant.
+type Tenants struct {
+	Shared   *sql.DB
+	Separate bool // database-per-tenant mode
+	Open     Opener
+
+	mu  sync.Mutex
+	dbs map[string]*sql.DB
+}
+
+// NewTenants returns the tenants of shared. With separate set, isolated
+// chamas are served from the databases open returns.
+func NewTenants(shared *sql.DB, separate bool, open Opener) *Tenants {
+	return &Tenants{Shared: shared, Separate: separate, Open: open, dbs: map[string]*sql.DB{}}
+}
+
+// For returns the database holding chamaID's data
+func (t *Tenants) For(ctx context.Context, chamaID string) (*sql.DB, error) {
+	if !t.Separate || chamaID == "" {
+		return t.Shared, nil
+	}
+	t.mu.Lock()
+	db, ok := t.dbs[chamaID]
+	t.mu.Unlock()
+	if ok {
+		return db, nil
+	}
+
+	var isolated int
+	err := t.Shared.QueryRowContext(ctx, `SELECT COUNT(*) FROM tenant_databases WHERE chama_id = ?`, chamaID).Scan(&isolated)
+	if err != nil {
+		return nil, err
+	}
+	if isolated == 0 {
+		return t.Shared, nil
+	}
+	return t.open(chamaID)
+}
+
+func (t *Tenants) open(chamaID string) (*sql.DB, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if db, ok := t.dbs[chamaID]; ok {
+		return db, nil
+	}
+	db, err := t.Open(chamaID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database for chama %s: %w", chamaID, err)
+	}
+	t.dbs[chamaID] = db
+	return db, nil
+}
+
+// DB returns the scoped database for the chama in ctx
+func (t *Tenants) DB(ctx context.Context) (DB, error) {
+	chamaID := ChamaID(ctx)
+	if chamaID == "" {
+		return DB{}, ErrNoChama
+	}
+	db, err := t.For(ctx, chamaID)
+	if err != nil {
+		return DB{}, err
+	}
+	return Scoped(db), nil
+}
+
+// Handler serves a {chamaId} route from the chama's database. build is
+// called per request with that database, as handlers here are constructed
+// around the database they use.
+func (t *Tenants) Handler(build func(db *sql.DB) http.HandlerFunc) http.HandlerFunc {
+	if !t.Separate {
+		return build(t.Shared)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		db, err := t.For(r.Context(), ChamaID(r.Context()))
+		if err != nil {
+			http.Error(w, "Failed to open chama database", http.StatusInternalServerError)
+			return
+		}
+		build(db)(w, r)
+	}
+}
+
+// Close closes the tenant databases opened so far
+func (t *Tenants) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var errs []error
+	for id, db := range t.dbs {
+		errs = append(errs, db.Close())
+		delete(t.dbs, id)
+	}
+	return errors.Join(errs...)
+}
+
+// Isolate gives chamaID a database of its own. Rows of every table with a
+// chama_id column are copied to it, along with rows that reference them
+// through foreign keys, the chama itself and its members' user records.
+// The chama is then served from the new database. The rows are left in the
+// shared database, to be purged once the copy has been checked.
+func (t *Tenants) Isolate(ctx context.Context, chamaID, by string) error {
+	if !t.Separate {
+		return ErrShared
+	}
+	var isolated int
+	if err := t.Shared.QueryRowContext(ctx, `SELECT COUNT(*) FROM tenant_databases WHERE chama_id = ?`, chamaID).Scan(&isolated); err != nil {
+		return err
+	}
+	if isolated > 0 {
+		return ErrAlreadyIsolated
+	}
+
+	tenant, err := t.open(chamaID)
+	if err != nil {
+		return err
+	}
+	var path string
+	if err := tenant.QueryRowContext(ctx, `SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&path); err != nil {
+		return err
+	}
+	if err := copyChama(ctx, t.Shared, path, chamaID); err != nil {
+		return err
+	}
+	_, err = t.Shared.ExecContext(ctx, `
+		INSERT INTO tenant_databases (chama_id, path, isolated_by) VALUES (?, ?, ?)`,
+		chamaID, path, nullIfEmpty(by))
+	return err
+}
+
+// copyChama copies chamaID's rows from shared into the database at path
+func copyChama(ctx context.Context, shared *sql.DB, path, chamaID string) error {
+	conn, err := shared.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Foreign keys are checked once the copy is complete
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `PRAGMA foreign_keys = ON`)
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS tenant`, path); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `DETACH DATABASE tenant`)
+
+	tables, err := tableColumns(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	copied := map[string]bool{}
+	copyRows := func(table, where string, args ...interface{}) error {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT OR IGNORE INTO tenant.%q SELECT * FROM main.%q WHERE %s`, table, table, where), args...)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", table, err)
+		}
+		copied[table] = true
+		return nil
+	}
+
+	if err := copyRows("chamas", "id = ?", chamaID); err != nil {
+		return err
+	}
+	for table, columns := range tables {
+		if columns["chama_id"] {
+			if err := copyRows(table, "chama_id = ?", chamaID); err != nil {
+				return err
+			}
+		}
+	}
+	if err := copyRows("users", "id IN (SELECT user_id FROM tenant.chama_members)"); err != nil {
+		return err
+	}
+
+	// Tables without a chama_id column belong to the chama through a parent,
+	// e.g. loan_guarantors through loans; follow foreign keys until no new
+	// table is reached
+	for more := true; more; {
+		more = false
+		for table := range tables {
+			if copied[table] {
+				continue
+			}
+			refs, err := foreignKeys(ctx, tx, table)
+			if err != nil {
+				return err
+			}
+			for _, fk := range refs {
+				if copied[fk.parent] && fk.parent != "users" && fk.parent != "chamas" {
+					where := fmt.Sprintf(`%q IN (SELECT %q FROM tenant.%q)`, fk.from, fk.to, fk.parent)
+					if err := copyRows(table, where); err != nil {
+						return err
+					}
+					more = true
+					break
+				}
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+type foreignKey struct {
+	from, parent, to string
+}
+
+func foreignKeys(ctx context.Context, tx *sql.Tx, table string) ([]foreignKey, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT "from", "table", COALESCE("to", 'id') FROM pragma_foreign_key_list(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var fks []foreignKey
+	for rows.Next() {
+		var fk foreignKey
+		if err := rows.Scan(&fk.from, &fk.parent, &fk.to); err != nil {
+			return nil, err
+		}
+		fks = append(fks, fk)
+	}
+	return fks, rows.Err()
+}
+
+// tableColumns returns the shared database's tables with their column names
+func tableColumns(ctx context.Context, conn *sql.Conn) (map[string]map[string]bool, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT m.name, c.name FROM main.sqlite_master m, pragma_table_info(m.name) c
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND m.name != 'tenant_databases'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := map[string]map[string]bool{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		if tables[table] == nil {
+			tables[table] = map[string]bool{}
+		}
+		tables[table][column] = true
+	}
+	return tables, rows.Err()
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,198 @@
+package twofactor
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/otp"
+	"tujifund-app/backend/validation"
+)
+
+// StatusHandler returns the caller's two-factor settings
+func StatusHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		status, err := GetStatus(r.Context(), db, userID)
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// SetupHandler starts enrolment with {"method": "totp"|"sms"}
+func SetupHandler(db *sql.DB, sender otp.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Method string `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if v.Required("method", request.Method) {
+			v.OneOf("method", request.Method, MethodTOTP, MethodSMS)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		enrollment, err := Setup(r.Context(), db, sender, userID, request.Method)
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(enrollment)
+	}
+}
+
+// EnableHandler completes enrolment with the first code and returns the
+// recovery codes. They are only ever shown here and by RecoveryCodesHandler.
+func EnableHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, code, ok := decodeCode(w, r)
+		if !ok {
+			return
+		}
+		codes, err := Enable(r.Context(), db, userID, code, audit.FromRequest(r, audit.Entry{}))
+		if !writeError(w, err) {
+			return
+		}
+		// The code just entered also counts as verifying this session
+		verifiedUntil, err := MarkSession(r.Context(), db, r.Header.Get("X-Session-ID"), userID)
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"recoveryCodes": codes,
+			"verifiedUntil": verifiedUntil,
+		})
+	}
+}
+
+// SendCodeHandler sends an SMS code to the caller's phone
+func SendCodeHandler(db *sql.DB, sender otp.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		if !writeError(w, SendCode(r.Context(), db, sender, userID)) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// VerifyHandler checks a second factor and marks the caller's session as verified
+func VerifyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, code, ok := decodeCode(w, r)
+		if !ok {
+			return
+		}
+		if !writeError(w, Verify(r.Context(), db, userID, code)) {
+			return
+		}
+		verifiedUntil, err := MarkSession(r.Context(), db, r.Header.Get("X-Session-ID"), userID)
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"verifiedUntil": verifiedUntil})
+	}
+}
+
+// RecoveryCodesHandler replaces the caller's recovery codes. Wrap it in Require.
+func RecoveryCodesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		codes, err := RegenerateRecoveryCodes(r.Context(), db, userID)
+		if !writeError(w, err) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": codes})
+	}
+}
+
+// DisableHandler turns two-factor authentication off after checking a current code
+func DisableHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, code, ok := decodeCode(w, r)
+		if !ok {
+			return
+		}
+		if !writeError(w, Verify(r.Context(), db, userID, code)) {
+			return
+		}
+		if !writeError(w, Disable(r.Context(), db, userID, audit.FromRequest(r, audit.Entry{}))) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// decodeCode reads {"code": "..."} from the request body
+func decodeCode(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return "", "", false
+	}
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return "", "", false
+	}
+	return userID, request.Code, true
+}
+
+// writeError writes err as a response and reports whether the handler may continue
+func writeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, ErrInvalid), errors.Is(err, otp.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, ErrEnabled), errors.Is(err, ErrNotEnabled), errors.Is(err, ErrNotStarted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrRequired):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrNoPhone):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, otp.ErrTooSoon), errors.Is(err, otp.ErrAttempts):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, breaker.ErrUnavailable):
+		breaker.WriteError(w, err)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package twofactor
+
+import (
+	"database/sql"
+	"net/http"
+
+	"tujifund-app/backend/i18n"
+)
+
+// Require guards sensitive operations such as approving payouts and changing
+// chama settings. It must run inside the session middleware. Users with
+// two-factor authentication must have verified this session within
+// StepUpWindow; officials who have not enrolled yet are refused until they do.
+// The X-Two-Factor response header tells the client which step is missing.
+func Require(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		enabled, err := Enabled(r.Context(), db, userID)
+		if err != nil {
+			http.Error(w, "Failed to check two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			required, err := Required(db, userID)
+			if err != nil {
+				http.Error(w, "Failed to check two-factor authentication", http.StatusInternalServerError)
+				return
+			}
+			if required {
+				w.Header().Set("X-Two-Factor", "enroll")
+				http.Error(w, i18n.T(i18n.FromRequest(r), "auth.two_factor_enroll", nil), http.StatusForbidden)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		verified, err := SessionVerified(r.Context(), db, r.Header.Get("X-Session-ID"), userID)
+		if err != nil {
+			http.Error(w, "Failed to check two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		if !verified {
+			w.Header().Set("X-Two-Factor", "verify")
+			http.Error(w, i18n.T(i18n.FromRequest(r), "auth.two_factor_required", nil), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,88 @@
+package twofactor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults every authenticator app supports.
+const (
+	Issuer     = "TujiFund"
+	Period     = 30
+	Digits     = 6
+	secretSize = 20
+	// skew is how many steps either side of now are accepted, to allow for clock drift
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random base32 TOTP secret
+func NewSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth:// URI for secret. Authenticator apps enrol by
+// scanning it as a QR code.
+func URI(secret, account string) string {
+	label := url.PathEscape(Issuer + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", Issuer)
+	q.Set("period", fmt.Sprint(Period))
+	q.Set("digits", fmt.Sprint(Digits))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// step returns the TOTP time step for t
+func step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// code computes the TOTP code for secret at step s
+func code(secret string, s int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(s))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, n%mod), nil
+}
+
+// matchStep returns the step within the skew window at which input is a valid
+// code for secret, or 0 if it is not valid
+func matchStep(secret, input string, now time.Time) int64 {
+	current := step(now)
+	for s := current - skew; s <= current+skew; s++ {
+		c, err := code(secret, s)
+		if err != nil {
+			return 0
+		}
+		if hmac.Equal([]byte(c), []byte(input)) {
+			return s
+		}
+	}
+	return 0
+}
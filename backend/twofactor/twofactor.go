@@ -0,0 +1,408 @@
+package twofactor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/otp"
+
+	"github.com/google/uuid"
+)
+
+// Methods
+const (
+	MethodTOTP = "totp"
+	MethodSMS  = "sms"
+)
+
+const (
+	// OTPPurpose keys SMS codes sent for two-factor checks
+	OTPPurpose = "two_factor"
+	// StepUpWindow is how long a session stays verified after a second-factor check
+	StepUpWindow = 30 * time.Minute
+	// RecoveryCodes is how many recovery codes are issued at a time
+	RecoveryCodes = 10
+
+	otpMessage       = "Your TujiFund security code is %s. Never share it with anyone."
+	recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+var (
+	ErrEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrNotStarted = errors.New("two-factor setup has not been started")
+	ErrNoPhone    = errors.New("add a phone number to your profile to use SMS codes")
+	ErrRequired   = errors.New("two-factor authentication is required for chama officials")
+	ErrInvalid    = errors.New("invalid code")
+)
+
+// Status describes a user's two-factor settings
+type Status struct {
+	Enabled           bool   `json:"enabled"`
+	Method            string `json:"method,omitempty"`
+	Required          bool   `json:"required"`
+	HasPhone          bool   `json:"hasPhone"`
+	RecoveryCodesLeft int    `json:"recoveryCodesLeft"`
+}
+
+// Enrollment is returned when setup starts. Secret and URI are only set for TOTP.
+type Enrollment struct {
+	Method string `json:"method"`
+	Secret string `json:"secret,omitempty"`
+	URI    string `json:"uri,omitempty"`
+}
+
+// settings is the user_two_factor row plus the contact details checks need
+type settings struct {
+	method   string
+	secret   string
+	lastStep int64
+	enabled  bool
+	phone    string
+	email    string
+}
+
+func load(ctx context.Context, db *sql.DB, userID string) (settings, error) {
+	var s settings
+	var method, secret sql.NullString
+	var enabledAt sql.NullTime
+	err := db.QueryRowContext(ctx, `
+		SELECT tf.method, tf.totp_secret, COALESCE(tf.totp_last_step, 0), tf.enabled_at,
+		       COALESCE(u.phone_number, ''), u.email
+		FROM users u
+		LEFT JOIN user_two_factor tf ON tf.user_id = u.user_id
+		WHERE u.user_id = ?`, userID,
+	).Scan(&method, &secret, &s.lastStep, &enabledAt, &s.phone, &s.email)
+	s.method, s.secret, s.enabled = method.String, secret.String, enabledAt.Valid
+	return s, err
+}
+
+// Required reports whether the user must use two-factor authentication
+func Required(db *sql.DB, userID string) (bool, error) {
+	return chamas.IsOfficialAnywhere(db, userID)
+}
+
+// Enabled reports whether the user has two-factor authentication turned on
+func Enabled(ctx context.Context, db *sql.DB, userID string) (bool, error) {
+	s, err := load(ctx, db, userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return s.enabled, err
+}
+
+// GetStatus returns the user's two-factor settings
+func GetStatus(ctx context.Context, db *sql.DB, userID string) (Status, error) {
+	s, err := load(ctx, db, userID)
+	if err != nil {
+		return Status{}, err
+	}
+	status := Status{Enabled: s.enabled, HasPhone: s.phone != ""}
+	if s.enabled {
+		status.Method = s.method
+	}
+	if status.Required, err = Required(db, userID); err != nil {
+		return status, err
+	}
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM two_factor_recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID,
+	).Scan(&status.RecoveryCodesLeft)
+	return status, err
+}
+
+// Setup starts enrolling the user with method. For TOTP it returns a new
+// secret to add to an authenticator app; for SMS it sends a code to the
+// phone number on the user's profile. Enable completes enrolment.
+func Setup(ctx context.Context, db *sql.DB, sender otp.Sender, userID, method string) (Enrollment, error) {
+	s, err := load(ctx, db, userID)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	if s.enabled {
+		return Enrollment{}, ErrEnabled
+	}
+
+	enrollment := Enrollment{Method: method}
+	switch method {
+	case MethodTOTP:
+		if enrollment.Secret, err = NewSecret(); err != nil {
+			return enrollment, err
+		}
+		enrollment.URI = URI(enrollment.Secret, s.email)
+	case MethodSMS:
+		if s.phone == "" {
+			return enrollment, ErrNoPhone
+		}
+	default:
+		return enrollment, fmt.Errorf("unknown two-factor method %q", method)
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO user_two_factor (user_id, method, totp_secret) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			method = excluded.method, totp_secret = excluded.totp_secret, totp_last_step = 0`,
+		userID, method, nullIfEmpty(enrollment.Secret),
+	)
+	if err != nil {
+		return enrollment, err
+	}
+	if method == MethodSMS {
+		return enrollment, otp.Issue(ctx, db, sender, OTPPurpose, s.phone, otpMessage)
+	}
+	return enrollment, nil
+}
+
+// Enable confirms enrolment with the first code from the chosen method and
+// returns a fresh set of recovery codes
+func Enable(ctx context.Context, db *sql.DB, userID, code string, e audit.Entry) ([]string, error) {
+	s, err := load(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if s.enabled {
+		return nil, ErrEnabled
+	}
+	if s.method == "" {
+		return nil, ErrNotStarted
+	}
+	if err := check(ctx, db, userID, s, code, false); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE user_two_factor SET enabled_at = CURRENT_TIMESTAMP WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	e.Action, e.EntityType, e.EntityID = "two_factor.enable", "user", userID
+	e.NewValues = map[string]string{"method": s.method}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// SendCode sends an SMS code to the user's phone. It works for either method,
+// so TOTP users who lose their authenticator can fall back to SMS.
+func SendCode(ctx context.Context, db *sql.DB, sender otp.Sender, userID string) error {
+	s, err := load(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if !s.enabled {
+		return ErrNotEnabled
+	}
+	if s.phone == "" {
+		return ErrNoPhone
+	}
+	return otp.Issue(ctx, db, sender, OTPPurpose, s.phone, otpMessage)
+}
+
+// Verify checks a second factor: an authenticator code, an SMS code or a
+// recovery code
+func Verify(ctx context.Context, db *sql.DB, userID, code string) error {
+	s, err := load(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if !s.enabled {
+		return ErrNotEnabled
+	}
+	return check(ctx, db, userID, s, code, true)
+}
+
+// check verifies code against s. Recovery codes are only accepted once
+// two-factor authentication is enabled.
+func check(ctx context.Context, db *sql.DB, userID string, s settings, code string, allowRecovery bool) error {
+	code = strings.TrimSpace(code)
+	if allowRecovery && len(code) > Digits {
+		return useRecoveryCode(ctx, db, userID, code)
+	}
+
+	if s.secret != "" {
+		if matched := matchStep(s.secret, code, time.Now()); matched > s.lastStep {
+			// Recording the step stops the same code being used twice
+			res, err := db.ExecContext(ctx, `
+				UPDATE user_two_factor SET totp_last_step = ? WHERE user_id = ? AND totp_last_step < ?`,
+				matched, userID, matched)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 1 {
+				return nil
+			}
+		}
+		// An authenticator user may have asked for an SMS code instead
+		if !s.enabled || s.phone == "" {
+			return ErrInvalid
+		}
+	}
+
+	err := otp.Verify(ctx, db, OTPPurpose, s.phone, code)
+	if err == otp.ErrInvalid {
+		return ErrInvalid
+	}
+	return err
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes
+func RegenerateRecoveryCodes(ctx context.Context, db *sql.DB, userID string) ([]string, error) {
+	enabled, err := Enabled(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrNotEnabled
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Disable turns two-factor authentication off. Officials cannot disable it.
+func Disable(ctx context.Context, db *sql.DB, userID string, e audit.Entry) error {
+	required, err := Required(db, userID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrRequired
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{
+		`DELETE FROM user_two_factor WHERE user_id = ?`,
+		`DELETE FROM two_factor_recovery_codes WHERE user_id = ?`,
+		`DELETE FROM two_factor_sessions WHERE user_id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, userID); err != nil {
+			return err
+		}
+	}
+	e.Action, e.EntityType, e.EntityID = "two_factor.disable", "user", userID
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MarkSession records that the session passed a second-factor check
+func MarkSession(ctx context.Context, db *sql.DB, sessionID, userID string) (time.Time, error) {
+	now := time.Now().UTC()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO two_factor_sessions (session_id, user_id, verified_at) VALUES (?, ?, ?)
+		ON CONFLICT(session_id) DO UPDATE SET user_id = excluded.user_id, verified_at = excluded.verified_at`,
+		sessionID, userID, now.Format("2006-01-02 15:04:05"),
+	)
+	return now.Add(StepUpWindow), err
+}
+
+// SessionVerified reports whether the session passed a second-factor check
+// within StepUpWindow
+func SessionVerified(ctx context.Context, db *sql.DB, sessionID, userID string) (bool, error) {
+	var verifiedAt time.Time
+	err := db.QueryRowContext(ctx, `
+		SELECT verified_at FROM two_factor_sessions WHERE session_id = ? AND user_id = ?`,
+		sessionID, userID,
+	).Scan(&verifiedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil && time.Since(verifiedAt) < StepUpWindow, err
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM two_factor_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, RecoveryCodes)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO two_factor_recovery_codes (id, user_id, code_hash) VALUES (?, ?, ?)`,
+			uuid.NewString(), userID, hashRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+	return codes, nil
+}
+
+func useRecoveryCode(ctx context.Context, db *sql.DB, userID, code string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE two_factor_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// generateRecoveryCode returns a code like "k7pm-2xqa"
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 9)
+	for i := range b {
+		if i == 4 {
+			b[i] = '-'
+			continue
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = recoveryAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// hashRecoveryCode ignores case and the separator so codes can be typed loosely
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
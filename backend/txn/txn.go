@@ -0,0 +1,288 @@
+// Package txn runs all of a request's writes in one transaction. Middleware
+// opens the transaction for a mutating request and puts it in the request
+// context; code that reaches the database through From joins it. The
+// transaction commits if the handler succeeds and rolls back if it answers
+// with an error status or panics, so a request that fails half way leaves
+// nothing behind.
+//
+// The middleware is opt-in, for routes whose handlers do several writes.
+// Everything such a handler calls must go through From: with SQLite, a write
+// made on another connection while the request's transaction holds the
+// write lock fails as busy.
+//
+// Code that needs a transaction of its own starts it with Begin, which joins
+// the request's transaction when there is one, and ends it with Commit and
+// Rollback.
+//
+// Work that must only happen once a transaction's changes are saved, such
+// as invalidating cached balances, is registered with AfterCommit. It runs
+// when the transaction is committed with Commit, and is dropped when it is
+// rolled back with Rollback. OnCommit does the same for the request's
+// transaction, for work such as notifying members.
+package txn
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// Conn is what *sql.DB and *sql.Tx have in common
+type Conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type contextKey struct{}
+
+// From returns the request's transaction if ctx carries one, and db otherwise
+func From(ctx context.Context, db *sql.DB) Conn {
+	if tx := requestTx(ctx); tx != nil {
+		return tx
+	}
+	return db
+}
+
+func requestTx(ctx context.Context) *sql.Tx {
+	tx, _ := ctx.Value(contextKey{}).(*sql.Tx)
+	return tx
+}
+
+var (
+	mu          sync.Mutex
+	afterCommit = map[*sql.Tx][]func(){}
+	requests    = map[*sql.Tx]*request{}
+)
+
+// request tracks the code that has joined a request's transaction
+type request struct {
+	savepoints []*savepoint
+}
+
+// savepoint marks where code that joined the request's transaction began
+type savepoint struct {
+	ctx      context.Context
+	name     string
+	hooks    int  // work registered with AfterCommit before it
+	released bool // by Commit, so the deferred Rollback that follows does nothing
+}
+
+// Begin starts a transaction. Within a request run by Middleware it joins
+// the request's transaction instead, at a savepoint: Commit keeps the
+// caller's writes for the middleware to commit with the rest of the
+// request, and Rollback undoes just them, so code that carries on past a
+// step that failed does not lose what came before it.
+func Begin(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
+	tx := requestTx(ctx)
+	if tx == nil {
+		return db.BeginTx(ctx, nil)
+	}
+	mu.Lock()
+	req, ok := requests[tx]
+	var sp *savepoint
+	if ok {
+		sp = &savepoint{ctx: ctx, name: fmt.Sprintf("txn_%d", len(req.savepoints)+1), hooks: len(afterCommit[tx])}
+		req.savepoints = append(req.savepoints, sp)
+	}
+	mu.Unlock()
+	if !ok {
+		return nil, errors.New("the request's transaction has ended")
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+sp.name); err != nil {
+		mu.Lock()
+		req.savepoints = req.savepoints[:len(req.savepoints)-1]
+		mu.Unlock()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// OnCommit runs fn once the request's transaction has committed, or at once
+// when ctx carries none. fn is given a context without the transaction.
+func OnCommit(ctx context.Context, fn func(ctx context.Context)) {
+	tx := requestTx(ctx)
+	if tx == nil {
+		fn(ctx)
+		return
+	}
+	AfterCommit(tx, func() { fn(context.WithValue(ctx, contextKey{}, (*sql.Tx)(nil))) })
+}
+
+// AfterCommit arranges for fn to run once tx has been committed with
+// Commit. Code that begins a transaction it passes to functions that may
+// register work must end it with Commit or Rollback.
+func AfterCommit(tx *sql.Tx, fn func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	afterCommit[tx] = append(afterCommit[tx], fn)
+}
+
+// Commit commits tx and then runs the work registered for it with
+// AfterCommit, in the order it was registered. For a request's transaction
+// joined with Begin it releases the savepoint, leaving the commit to the
+// middleware.
+func Commit(tx *sql.Tx) error {
+	mu.Lock()
+	req, ok := requests[tx]
+	var sp *savepoint
+	if ok {
+		for i := len(req.savepoints) - 1; i >= 0; i-- {
+			if !req.savepoints[i].released {
+				sp = req.savepoints[i]
+				sp.released = true
+				break
+			}
+		}
+	}
+	mu.Unlock()
+	switch {
+	case !ok:
+		return commit(tx)
+	case sp == nil:
+		return errors.New("commit without a matching begin")
+	}
+	_, err := tx.ExecContext(sp.ctx, "RELEASE SAVEPOINT "+sp.name)
+	return err
+}
+
+func commit(tx *sql.Tx) error {
+	err := tx.Commit()
+	fns := take(tx)
+	if err != nil {
+		return err
+	}
+	for _, fn := range fns {
+		fn()
+	}
+	return nil
+}
+
+// Rollback rolls tx back and drops the work registered for it. Like
+// tx.Rollback, it may be deferred and is harmless after Commit. For a
+// request's transaction joined with Begin it rolls back to the savepoint,
+// dropping the work registered since.
+func Rollback(tx *sql.Tx) error {
+	mu.Lock()
+	req, ok := requests[tx]
+	var sp *savepoint
+	if ok && len(req.savepoints) > 0 {
+		sp = req.savepoints[len(req.savepoints)-1]
+		req.savepoints = req.savepoints[:len(req.savepoints)-1]
+		if hooks := afterCommit[tx]; !sp.released && len(hooks) > sp.hooks {
+			afterCommit[tx] = hooks[:sp.hooks]
+		}
+	}
+	mu.Unlock()
+	switch {
+	case !ok:
+		return rollback(tx)
+	case sp == nil || sp.released:
+		return nil
+	}
+	if _, err := tx.ExecContext(sp.ctx, "ROLLBACK TO SAVEPOINT "+sp.name); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(sp.ctx, "RELEASE SAVEPOINT "+sp.name)
+	return err
+}
+
+func rollback(tx *sql.Tx) error {
+	take(tx)
+	return tx.Rollback()
+}
+
+func take(tx *sql.Tx) []func() {
+	mu.Lock()
+	defer mu.Unlock()
+	fns := afterCommit[tx]
+	delete(afterCommit, tx)
+	return fns
+}
+
+// Middleware runs next in a transaction when the request is a POST, PUT,
+// PATCH or DELETE. The response is held back until the transaction has
+// committed, so a client is never told a change succeeded when it was not
+// saved.
+func Middleware(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next(w, r)
+			return
+		}
+		ctx := r.Context()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+			return
+		}
+		mu.Lock()
+		requests[tx] = &request{}
+		mu.Unlock()
+		done := false
+		defer func() {
+			mu.Lock()
+			delete(requests, tx)
+			mu.Unlock()
+			// A panic rolls back before the recovery middleware answers
+			if !done {
+				rollback(tx)
+			}
+		}()
+
+		buf := &bufferedWriter{header: http.Header{}, status: http.StatusOK}
+		next(buf, r.WithContext(context.WithValue(ctx, contextKey{}, tx)))
+
+		mu.Lock()
+		delete(requests, tx)
+		mu.Unlock()
+		if buf.status >= 400 {
+			rollback(tx)
+			done = true
+			buf.flush(w)
+			return
+		}
+		err = commit(tx)
+		done = true
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to commit request transaction", "method", r.Method, "path", r.URL.Path, "error", err)
+			http.Error(w, "Failed to save changes", http.StatusInternalServerError)
+			return
+		}
+		buf.flush(w)
+	}
+}
+
+// bufferedWriter holds a response until the transaction is settled
+type bufferedWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bufferedWriter) Header() http.Header { return b.header }
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	if !b.wroteHeader {
+		b.status, b.wroteHeader = status, true
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+// flush sends the held response
+func (b *bufferedWriter) flush(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
@@ -0,0 +1,436 @@
+package umbrellas
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// managerRoles may request and approve transfers out of their chama
+var managerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// canView reports whether userID administers the umbrella or is an official of one of its chamas
+func canView(ctx context.Context, db *sql.DB, u Umbrella, userID string) bool {
+	if IsAdmin(ctx, db, u.ID, userID) {
+		return true
+	}
+	for _, c := range u.Chamas {
+		if chamas.IsOfficial(db, c.ChamaID, userID) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadUmbrella loads the {umbrellaId} umbrella for a user who may see it,
+// writing an error response and returning false otherwise
+func loadUmbrella(db *sql.DB, w http.ResponseWriter, r *http.Request) (Umbrella, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Umbrella{}, "", false
+	}
+	u, err := Get(r.Context(), db, mux.Vars(r)["umbrellaId"])
+	if errors.Is(err, ErrNotFound) || (err == nil && !canView(r.Context(), db, u, userID)) {
+		http.Error(w, "Umbrella not found", http.StatusNotFound)
+		return u, userID, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return u, userID, false
+	}
+	return u, userID, true
+}
+
+// CreateHandler creates an umbrella administered by the caller
+func CreateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("name", strings.TrimSpace(request.Name))
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		u, err := Create(r.Context(), db, Umbrella{
+			Name: strings.TrimSpace(request.Name), Description: request.Description, CreatedBy: userID,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(u)
+	}
+}
+
+// ListHandler lists the umbrellas the caller administers or belongs to
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		list, err := ForUser(r.Context(), db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// GetHandler returns the {umbrellaId} umbrella with its chamas
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _, ok := loadUmbrella(db, w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u)
+	}
+}
+
+// AddAdminHandler lets another user administer the {umbrellaId} umbrella.
+// The body takes userId.
+func AddAdminHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, userID, ok := loadUmbrella(db, w, r)
+		if !ok {
+			return
+		}
+		if !IsAdmin(r.Context(), db, u.ID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			UserID string `json:"userId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("userId", request.UserID)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		if err := AddAdmin(r.Context(), db, u.ID, request.UserID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		u, _ = Get(r.Context(), db, u.ID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u)
+	}
+}
+
+// AddChamaHandler brings a chama under the {umbrellaId} umbrella. The
+// caller must administer the umbrella and be an official of the chama,
+// named by chamaId in the body.
+func AddChamaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, userID, ok := loadUmbrella(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			ChamaID string `json:"chamaId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("chamaId", request.ChamaID)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		if !IsAdmin(r.Context(), db, u.ID, userID) || !chamas.IsOfficial(db, request.ChamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		err := AddChama(r.Context(), db, u.ID, request.ChamaID, userID)
+		if errors.Is(err, ErrAlreadyJoined) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		u, _ = Get(r.Context(), db, u.ID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u)
+	}
+}
+
+// RemoveChamaHandler takes the {chamaId} chama out of the {umbrellaId}
+// umbrella. Its admins and the chama's own officials may do so.
+func RemoveChamaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, userID, ok := loadUmbrella(db, w, r)
+		if !ok {
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !IsAdmin(r.Context(), db, u.ID, userID) && !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		err := RemoveChama(r.Context(), db, u.ID, chamaID)
+		if errors.Is(err, ErrNotInUmbrella) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ReportHandler returns the {umbrellaId} umbrella's consolidated report for
+// ?from=YYYY-MM-DD&to=YYYY-MM-DD (inclusive), defaulting to the previous month
+func ReportHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _, ok := loadUmbrella(db, w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		now := time.Now().UTC()
+		from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+		to := from.AddDate(0, 1, 0)
+		if q.Get("from") != "" || q.Get("to") != "" {
+			f, err := time.Parse("2006-01-02", q.Get("from"))
+			if err != nil {
+				http.Error(w, "Invalid from date", http.StatusBadRequest)
+				return
+			}
+			t, err := time.Parse("2006-01-02", q.Get("to"))
+			if err != nil || t.Before(f) {
+				http.Error(w, "Invalid to date", http.StatusBadRequest)
+				return
+			}
+			from, to = f, t.AddDate(0, 0, 1)
+		}
+
+		report, err := BuildReport(r.Context(), db, u.ID, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// TransferHandler requests a transfer from one of the {umbrellaId}
+// umbrella's chamas to another. The caller must manage the source chama,
+// and another of its managers must approve before any money moves.
+func TransferHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, userID, ok := loadUmbrella(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			FromChamaID   string `json:"fromChamaId"`
+			FromAccountID string `json:"fromAccountId"`
+			ToChamaID     string `json:"toChamaId"`
+			ToAccountID   string `json:"toAccountId"`
+			Amount        string `json:"amount"`
+			Reason        string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("fromChamaId", request.FromChamaID)
+		v.Required("fromAccountId", request.FromAccountID)
+		v.Required("toChamaId", request.ToChamaID)
+		v.Required("toAccountId", request.ToAccountID)
+		if v.Required("amount", request.Amount) {
+			v.Amount("amount", request.Amount)
+		}
+		v.Required("reason", request.Reason)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		if !chamas.HasRole(db, request.FromChamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		currency, err := money.ChamaCurrency(db, request.FromChamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		amount, _ := money.Parse(request.Amount, currency)
+		t, err := RequestTransfer(r.Context(), db, Transfer{
+			UmbrellaID:    u.ID,
+			FromChamaID:   request.FromChamaID,
+			FromAccountID: request.FromAccountID,
+			ToChamaID:     request.ToChamaID,
+			ToAccountID:   request.ToAccountID,
+			Amount:        amount,
+			Reason:        request.Reason,
+			RequestedBy:   userID,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		notifyApprovers(r.Context(), db, notifier, t)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+// ListTransfersHandler lists the {umbrellaId} umbrella's transfers, optionally filtered by ?status=
+func ListTransfersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _, ok := loadUmbrella(db, w, r)
+		if !ok {
+			return
+		}
+		list, err := ListTransfers(r.Context(), db, u.ID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// ApproveTransferHandler approves the {transferId} transfer and posts it to both chamas' ledgers
+func ApproveTransferHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decideHandler(db, w, r, func(id string, e audit.Entry) (Transfer, error) {
+			return ApproveTransfer(r.Context(), db, id, e)
+		})
+	}
+}
+
+// RejectTransferHandler rejects the {transferId} transfer with a reason
+func RejectTransferHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+			http.Error(w, "A reason is required when rejecting a transfer", http.StatusBadRequest)
+			return
+		}
+		decideHandler(db, w, r, func(id string, e audit.Entry) (Transfer, error) {
+			return RejectTransfer(r.Context(), db, id, request.Reason, e)
+		})
+	}
+}
+
+func decideHandler(db *sql.DB, w http.ResponseWriter, r *http.Request, decide func(id string, e audit.Entry) (Transfer, error)) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	t, err := GetTransfer(r.Context(), db, mux.Vars(r)["transferId"])
+	if errors.Is(err, ErrTransferNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !chamas.HasRole(db, t.FromChamaID, userID, managerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	t, err = decide(t.ID, audit.FromRequest(r, audit.Entry{UserID: userID}))
+	switch {
+	case errors.Is(err, ErrDecided), errors.Is(err, ErrSelfApproval), errors.Is(err, ErrNotInUmbrella):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ledger.ErrDebitNotAllowed), errors.Is(err, ledger.ErrInsufficientFunds), errors.Is(err, ledger.ErrAccountClosed):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// notifyApprovers asks the source chama's other managers to review t
+func notifyApprovers(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, t Transfer) {
+	if notifier == nil {
+		return
+	}
+	approvers, err := chamas.MembersWithRole(db, t.FromChamaID, managerRoles...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load transfer approvers", "transfer_id", t.ID, "error", err)
+		return
+	}
+	var from, to string
+	db.QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, t.FromChamaID).Scan(&from)
+	db.QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, t.ToChamaID).Scan(&to)
+	params := map[string]string{"amount": t.Amount.String(), "from": from, "to": to, "reason": t.Reason}
+	for _, id := range approvers {
+		if id == t.RequestedBy {
+			continue
+		}
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "umbrella_transfer.requested", nil),
+			Message:   i18n.T(i18n.Default, "notification.umbrella_transfer_requested", params),
+			Type:      notifications.TypeChama,
+			RelatedID: t.ID,
+		})
+	}
+}
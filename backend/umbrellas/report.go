@@ -0,0 +1,113 @@
+package umbrellas
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+)
+
+// ChamaReport is one chama's figures in an umbrella report. In and Out leave
+// out inter-chama transfers, which are netted in Transfers instead.
+type ChamaReport struct {
+	ChamaID   string      `json:"chamaId"`
+	Name      string      `json:"name"`
+	Members   int         `json:"members"`
+	Opening   money.Money `json:"opening"`
+	In        money.Money `json:"in"`
+	Out       money.Money `json:"out"`
+	Transfers money.Money `json:"transfers"` // net received from the other chamas
+	Closing   money.Money `json:"closing"`
+}
+
+// Total consolidates the umbrella's chamas that keep books in one currency.
+// Transfers between them cancel out, so they appear in neither In nor Out.
+type Total struct {
+	Currency string      `json:"currency"`
+	Members  int         `json:"members"`
+	Opening  money.Money `json:"opening"`
+	In       money.Money `json:"in"`
+	Out      money.Money `json:"out"`
+	Closing  money.Money `json:"closing"`
+}
+
+// Report is an umbrella's consolidated report for [From, To)
+type Report struct {
+	UmbrellaID string        `json:"umbrellaId"`
+	Name       string        `json:"name"`
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Chamas     []ChamaReport `json:"chamas"`
+	Totals     []Total       `json:"totals"`
+}
+
+// BuildReport reports on each of the umbrella's chamas over [from, to) and
+// totals them per currency
+func BuildReport(ctx context.Context, db *sql.DB, umbrellaID string, from, to time.Time) (Report, error) {
+	u, err := Get(ctx, db, umbrellaID)
+	if err != nil {
+		return Report{}, err
+	}
+	r := Report{UmbrellaID: u.ID, Name: u.Name, From: from, To: to, Chamas: []ChamaReport{}, Totals: []Total{}}
+	totals := map[string]*Total{}
+	for _, c := range u.Chamas {
+		cr, err := chamaReport(ctx, db, c, from, to)
+		if err != nil {
+			return r, err
+		}
+		r.Chamas = append(r.Chamas, cr)
+
+		t, ok := totals[c.Currency]
+		if !ok {
+			zero := money.New(0, c.Currency)
+			t = &Total{Currency: c.Currency, Opening: zero, In: zero, Out: zero, Closing: zero}
+			totals[c.Currency] = t
+		}
+		t.Members += cr.Members
+		t.Opening.Amount += cr.Opening.Amount
+		t.In.Amount += cr.In.Amount
+		t.Out.Amount += cr.Out.Amount
+		t.Closing.Amount += cr.Closing.Amount
+	}
+	for _, c := range u.Chamas {
+		if t, ok := totals[c.Currency]; ok {
+			r.Totals = append(r.Totals, *t)
+			delete(totals, c.Currency)
+		}
+	}
+	return r, nil
+}
+
+// chamaReport works out one chama's figures from its ledger
+func chamaReport(ctx context.Context, db *sql.DB, c Chama, from, to time.Time) (ChamaReport, error) {
+	cr := ChamaReport{ChamaID: c.ChamaID, Name: c.Name}
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM chama_members WHERE chama_id = ? AND status = 'active'`, c.ChamaID).Scan(&cr.Members)
+	if err != nil {
+		return cr, err
+	}
+
+	start, end := from.UTC().Format("2006-01-02 15:04:05"), to.UTC().Format("2006-01-02 15:04:05")
+	var opening, in, out, transfers int64
+	err = db.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(CASE WHEN effective_at < ? THEN amount_minor END), 0),
+			COALESCE(SUM(CASE WHEN effective_at >= ? AND entry_type != ? AND amount_minor > 0 THEN amount_minor END), 0),
+			COALESCE(SUM(CASE WHEN effective_at >= ? AND entry_type != ? AND amount_minor < 0 THEN amount_minor END), 0),
+			COALESCE(SUM(CASE WHEN effective_at >= ? AND entry_type = ? THEN amount_minor END), 0)
+		FROM `+ledger.History+` WHERE chama_id = ? AND effective_at < ?`,
+		start, start, ledger.TypeInterChama, start, ledger.TypeInterChama, start, ledger.TypeInterChama,
+		c.ChamaID, end,
+	).Scan(&opening, &in, &out, &transfers)
+	if err != nil {
+		return cr, err
+	}
+	cr.Opening = money.New(opening, c.Currency)
+	cr.In = money.New(in, c.Currency)
+	cr.Out = money.New(-out, c.Currency)
+	cr.Transfers = money.New(transfers, c.Currency)
+	cr.Closing = money.New(opening+in+out+transfers, c.Currency)
+	return cr, nil
+}
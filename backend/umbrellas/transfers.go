@@ -0,0 +1,237 @@
+package umbrellas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Transfer statuses
+const (
+	TransferPending  = "pending"
+	TransferApproved = "approved"
+	TransferRejected = "rejected"
+)
+
+var (
+	// ErrTransferNotFound is returned for a transfer that does not exist
+	ErrTransferNotFound = errors.New("transfer not found")
+	// ErrDecided is returned when approving or rejecting a transfer that is no longer pending
+	ErrDecided = errors.New("transfer has already been decided")
+	// ErrSelfApproval is returned when the requester tries to approve their own transfer
+	ErrSelfApproval = errors.New("transfers must be approved by someone other than the requester")
+	// ErrSameChama is returned for a transfer between a chama's own funds, which the chama's fund transfers handle
+	ErrSameChama = errors.New("use a fund transfer to move money within a chama")
+	// ErrCurrencyMismatch is returned when the two chamas keep their books in different currencies
+	ErrCurrencyMismatch = errors.New("both chamas must use the same currency")
+)
+
+// Transfer is a request to move money from one of an umbrella's chamas to
+// another. The source chama's officials approve it, as it is their money.
+type Transfer struct {
+	ID              string      `json:"id"`
+	UmbrellaID      string      `json:"umbrellaId"`
+	FromChamaID     string      `json:"fromChamaId"`
+	FromAccountID   string      `json:"fromAccountId"`
+	ToChamaID       string      `json:"toChamaId"`
+	ToAccountID     string      `json:"toAccountId"`
+	Amount          money.Money `json:"amount"`
+	Reason          string      `json:"reason"`
+	Status          string      `json:"status"`
+	RequestedBy     string      `json:"requestedBy"`
+	DecidedBy       string      `json:"decidedBy,omitempty"`
+	DecidedAt       *time.Time  `json:"decidedAt,omitempty"`
+	RejectionReason string      `json:"rejectionReason,omitempty"`
+	CreatedAt       time.Time   `json:"createdAt"`
+}
+
+// RequestTransfer records a pending transfer between two of the umbrella's chamas
+func RequestTransfer(ctx context.Context, db *sql.DB, t Transfer) (Transfer, error) {
+	if t.Amount.Amount <= 0 {
+		return t, errors.New("transfer amount must be positive")
+	}
+	if t.FromChamaID == t.ToChamaID {
+		return t, ErrSameChama
+	}
+	for _, chamaID := range []string{t.FromChamaID, t.ToChamaID} {
+		id, err := UmbrellaOf(ctx, db, chamaID)
+		if err != nil {
+			return t, err
+		}
+		if id != t.UmbrellaID {
+			return t, ErrNotInUmbrella
+		}
+	}
+	for _, leg := range []struct{ chamaID, accountID string }{{t.FromChamaID, t.FromAccountID}, {t.ToChamaID, t.ToAccountID}} {
+		var currency string
+		err := db.QueryRowContext(ctx, `
+			SELECT currency FROM chama_accounts WHERE id = ? AND chama_id = ? AND status != 'closed'`,
+			leg.accountID, leg.chamaID).Scan(&currency)
+		if err == sql.ErrNoRows {
+			return t, fmt.Errorf("fund %s not found", leg.accountID)
+		}
+		if err != nil {
+			return t, err
+		}
+		if currency != t.Amount.Currency {
+			return t, ErrCurrencyMismatch
+		}
+	}
+
+	t.ID = uuid.NewString()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO umbrella_transfers
+		(id, umbrella_id, from_chama_id, from_account_id, to_chama_id, to_account_id, amount_minor, currency, reason, requested_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.UmbrellaID, t.FromChamaID, t.FromAccountID, t.ToChamaID, t.ToAccountID, t.Amount.Amount,
+		t.Amount.Currency, t.Reason, t.RequestedBy)
+	if err != nil {
+		return t, fmt.Errorf("failed to request transfer: %w", err)
+	}
+	return GetTransfer(ctx, db, t.ID)
+}
+
+const transferColumns = `id, umbrella_id, from_chama_id, from_account_id, to_chama_id, to_account_id, amount_minor,
+	currency, reason, status, requested_by, COALESCE(decided_by, ''), decided_at, COALESCE(rejection_reason, ''), created_at`
+
+func scanTransfer(row interface{ Scan(...interface{}) error }) (Transfer, error) {
+	var t Transfer
+	err := row.Scan(&t.ID, &t.UmbrellaID, &t.FromChamaID, &t.FromAccountID, &t.ToChamaID, &t.ToAccountID,
+		&t.Amount.Amount, &t.Amount.Currency, &t.Reason, &t.Status, &t.RequestedBy, &t.DecidedBy, &t.DecidedAt,
+		&t.RejectionReason, &t.CreatedAt)
+	return t, err
+}
+
+// GetTransfer returns a single transfer
+func GetTransfer(ctx context.Context, db *sql.DB, id string) (Transfer, error) {
+	t, err := scanTransfer(db.QueryRowContext(ctx, `SELECT `+transferColumns+` FROM umbrella_transfers WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return t, ErrTransferNotFound
+	}
+	return t, err
+}
+
+// ListTransfers returns the umbrella's transfers, newest first. status is an optional filter.
+func ListTransfers(ctx context.Context, db *sql.DB, umbrellaID, status string) ([]Transfer, error) {
+	query := `SELECT ` + transferColumns + ` FROM umbrella_transfers WHERE umbrella_id = ?`
+	args := []interface{}{umbrellaID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Transfer{}
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// ApproveTransfer approves a pending transfer and posts it in one
+// transaction: a debit on the source chama's fund and a credit on the
+// destination's. Both chamas must still be in the umbrella, and the source
+// fund's rules apply to the debit.
+func ApproveTransfer(ctx context.Context, db *sql.DB, id string, e audit.Entry) (Transfer, error) {
+	t, err := GetTransfer(ctx, db, id)
+	if err != nil {
+		return t, err
+	}
+	if t.Status != TransferPending {
+		return t, ErrDecided
+	}
+	if t.RequestedBy == e.UserID {
+		return t, ErrSelfApproval
+	}
+	for _, chamaID := range []string{t.FromChamaID, t.ToChamaID} {
+		umbrellaID, err := UmbrellaOf(ctx, db, chamaID)
+		if err != nil {
+			return t, err
+		}
+		if umbrellaID != t.UmbrellaID {
+			return t, ErrNotInUmbrella
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return t, err
+	}
+	defer txn.Rollback(tx)
+
+	legs := []ledger.Entry{
+		{ChamaID: t.FromChamaID, AccountID: t.FromAccountID, Amount: t.Amount.Negate()},
+		{ChamaID: t.ToChamaID, AccountID: t.ToAccountID, Amount: t.Amount},
+	}
+	for _, leg := range legs {
+		leg.Type, leg.Reference, leg.Description, leg.CreatedBy = ledger.TypeInterChama, t.ID, t.Reason, e.UserID
+		if _, err := ledger.Post(ctx, tx, leg); err != nil {
+			return t, err
+		}
+	}
+	if err := decide(ctx, tx, id, TransferApproved, e.UserID, ""); err != nil {
+		return t, err
+	}
+	e.Action, e.EntityType, e.EntityID = "umbrella_transfer.approve", "umbrella_transfer", t.ID
+	e.NewValues = map[string]interface{}{"fromChamaId": t.FromChamaID, "toChamaId": t.ToChamaID, "amount": t.Amount}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return t, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return t, err
+	}
+	return GetTransfer(ctx, db, id)
+}
+
+// RejectTransfer rejects a pending transfer; nothing is posted to either ledger
+func RejectTransfer(ctx context.Context, db *sql.DB, id, reason string, e audit.Entry) (Transfer, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Transfer{}, err
+	}
+	defer txn.Rollback(tx)
+
+	if err := decide(ctx, tx, id, TransferRejected, e.UserID, reason); err != nil {
+		return Transfer{}, err
+	}
+	e.Action, e.EntityType, e.EntityID = "umbrella_transfer.reject", "umbrella_transfer", id
+	e.NewValues = map[string]string{"reason": reason}
+	if err := audit.Record(ctx, tx, e); err != nil {
+		return Transfer{}, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return Transfer{}, err
+	}
+	return GetTransfer(ctx, db, id)
+}
+
+func decide(ctx context.Context, tx *sql.Tx, id, status, by, reason string) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE umbrella_transfers SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP, rejection_reason = ?
+		WHERE id = ? AND status = ?`,
+		status, by, nullIfEmpty(reason), id, TransferPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrDecided
+	}
+	return nil
+}
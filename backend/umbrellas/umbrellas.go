@@ -0,0 +1,218 @@
+// Package umbrellas groups chamas run by one organisation, such as a SACCO
+// with several sub-chamas. An umbrella reports on its chamas together and
+// lets them move money between each other. Each chama keeps its own books:
+// a transfer is posted as money out of one chama's fund and money into
+// another's, so every chama's ledger still balances on its own.
+package umbrellas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotFound is returned for an umbrella that does not exist
+	ErrNotFound = errors.New("umbrella not found")
+	// ErrAlreadyJoined is returned when adding a chama that already belongs to an umbrella
+	ErrAlreadyJoined = errors.New("chama already belongs to an umbrella")
+	// ErrNotInUmbrella is returned for a chama that is not part of the umbrella
+	ErrNotInUmbrella = errors.New("chama is not part of this umbrella")
+)
+
+// Umbrella is an organisation running several chamas
+type Umbrella struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedBy   string    `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Chamas      []Chama   `json:"chamas"`
+	Admins      []string  `json:"admins"`
+}
+
+// Chama is one of an umbrella's chamas
+type Chama struct {
+	ChamaID  string    `json:"chamaId"`
+	Name     string    `json:"name"`
+	Currency string    `json:"currency"`
+	AddedBy  string    `json:"addedBy"`
+	AddedAt  time.Time `json:"addedAt"`
+}
+
+// Create records a new umbrella with its creator as its first admin
+func Create(ctx context.Context, db *sql.DB, u Umbrella) (Umbrella, error) {
+	u.ID = uuid.NewString()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return u, err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `INSERT INTO umbrellas (id, name, description, created_by) VALUES (?, ?, ?, ?)`,
+		u.ID, u.Name, nullIfEmpty(u.Description), u.CreatedBy)
+	if err != nil {
+		return u, fmt.Errorf("failed to create umbrella: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO umbrella_admins (umbrella_id, user_id) VALUES (?, ?)`,
+		u.ID, u.CreatedBy); err != nil {
+		return u, err
+	}
+	if err := tx.Commit(); err != nil {
+		return u, err
+	}
+	return Get(ctx, db, u.ID)
+}
+
+// Get returns an umbrella with its chamas and admins
+func Get(ctx context.Context, db *sql.DB, id string) (Umbrella, error) {
+	var u Umbrella
+	err := db.QueryRowContext(ctx, `
+		SELECT id, name, COALESCE(description, ''), created_by, created_at FROM umbrellas WHERE id = ?`, id,
+	).Scan(&u.ID, &u.Name, &u.Description, &u.CreatedBy, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return u, ErrNotFound
+	}
+	if err != nil {
+		return u, err
+	}
+	if u.Chamas, err = Chamas(ctx, db, id); err != nil {
+		return u, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT user_id FROM umbrella_admins WHERE umbrella_id = ? ORDER BY added_at, user_id`, id)
+	if err != nil {
+		return u, err
+	}
+	defer rows.Close()
+	u.Admins = []string{}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return u, err
+		}
+		u.Admins = append(u.Admins, userID)
+	}
+	return u, rows.Err()
+}
+
+// ForUser returns the umbrellas userID administers or has a chama in
+func ForUser(ctx context.Context, db *sql.DB, userID string) ([]Umbrella, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id FROM umbrellas WHERE id IN (
+			SELECT umbrella_id FROM umbrella_admins WHERE user_id = ?
+			UNION
+			SELECT uc.umbrella_id FROM umbrella_chamas uc
+			JOIN chama_members m ON m.chama_id = uc.chama_id AND m.user_id = ? AND m.status = 'active'
+		) ORDER BY name`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := []Umbrella{}
+	for _, id := range ids {
+		u, err := Get(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+// Chamas returns the umbrella's chamas by name
+func Chamas(ctx context.Context, db *sql.DB, umbrellaID string) ([]Chama, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT uc.chama_id, c.name, c.currency, uc.added_by, uc.added_at
+		FROM umbrella_chamas uc JOIN chamas c ON c.id = uc.chama_id
+		WHERE uc.umbrella_id = ? ORDER BY c.name`, umbrellaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Chama{}
+	for rows.Next() {
+		var c Chama
+		if err := rows.Scan(&c.ChamaID, &c.Name, &c.Currency, &c.AddedBy, &c.AddedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// IsAdmin reports whether userID administers the umbrella
+func IsAdmin(ctx context.Context, db *sql.DB, umbrellaID, userID string) bool {
+	var ok bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM umbrella_admins WHERE umbrella_id = ? AND user_id = ?)`, umbrellaID, userID).Scan(&ok)
+	return err == nil && ok
+}
+
+// AddAdmin lets another user administer the umbrella
+func AddAdmin(ctx context.Context, db *sql.DB, umbrellaID, userID string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO umbrella_admins (umbrella_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, umbrellaID, userID)
+	return err
+}
+
+// UmbrellaOf returns the ID of the umbrella chamaID belongs to, or "" when
+// it stands alone
+func UmbrellaOf(ctx context.Context, db *sql.DB, chamaID string) (string, error) {
+	var id string
+	err := db.QueryRowContext(ctx, `SELECT umbrella_id FROM umbrella_chamas WHERE chama_id = ?`, chamaID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// AddChama brings a chama under the umbrella. A chama belongs to at most one umbrella.
+func AddChama(ctx context.Context, db *sql.DB, umbrellaID, chamaID, addedBy string) error {
+	current, err := UmbrellaOf(ctx, db, chamaID)
+	if err != nil {
+		return err
+	}
+	if current != "" {
+		return ErrAlreadyJoined
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO umbrella_chamas (umbrella_id, chama_id, added_by) VALUES (?, ?, ?)`,
+		umbrellaID, chamaID, addedBy)
+	return err
+}
+
+// RemoveChama takes a chama out of the umbrella. Transfers it has already
+// made stay in both chamas' books.
+func RemoveChama(ctx context.Context, db *sql.DB, umbrellaID, chamaID string) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM umbrella_chamas WHERE umbrella_id = ? AND chama_id = ?`, umbrellaID, chamaID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotInUmbrella
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,84 @@
+package ussd
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// GatewayToken is the secret given to Africa's Talking as the token query
+// parameter of the USSD and SMS callback URLs. The gateway does not sign its
+// callbacks, so without it anyone could post a session as any phone number.
+// Callbacks are refused while it is empty.
+var GatewayToken string
+
+// fromGateway reports whether r carries GatewayToken
+func fromGateway(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	return GatewayToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(GatewayToken)) == 1
+}
+
+// Handler serves the Africa's Talking USSD callback. The gateway posts the
+// session ID, the caller's phone number and text, every entry made so far
+// joined by "*", and shows the reply, starting "CON" to ask for more input
+// or "END" to close the session. Callbacks without GatewayToken are refused.
+func Handler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !fromGateway(r) {
+			slog.WarnContext(r.Context(), "Rejected gateway callback", "path", r.URL.Path)
+			http.Error(w, "Invalid callback", http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		sessionID, phone := r.PostFormValue("sessionId"), r.PostFormValue("phoneNumber")
+		if sessionID == "" || phone == "" {
+			http.Error(w, "sessionId and phoneNumber are required", http.StatusBadRequest)
+			return
+		}
+		text := r.PostFormValue("text")
+		input := text[strings.LastIndex(text, "*")+1:]
+
+		reply, err := s.Handle(r.Context(), sessionID, phone, input)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "USSD session failed", "session_id", sessionID, "error", err)
+			reply = end("Sorry, something went wrong. Please try again later.")
+		}
+		prefix := "CON "
+		if reply.End {
+			prefix = "END "
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(prefix + reply.Text))
+	}
+}
+
+// SMSHandler serves the Africa's Talking incoming-message callback. The
+// gateway posts the sender's number as from and the message as text; the
+// answer goes back as a separate SMS, so the callback only acknowledges.
+// Callbacks without GatewayToken are refused.
+func SMSHandler(s *SMSService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !fromGateway(r) {
+			slog.WarnContext(r.Context(), "Rejected gateway callback", "path", r.URL.Path)
+			http.Error(w, "Invalid callback", http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		phone := r.PostFormValue("from")
+		if phone == "" {
+			http.Error(w, "from is required", http.StatusBadRequest)
+			return
+		}
+		if err := s.Receive(r.Context(), phone, r.PostFormValue("text")); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to reply to SMS command", "error", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,177 @@
+package ussd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/otp"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/ratelimit"
+	"tujifund-app/backend/tenancy"
+)
+
+// SMS commands. A command may be followed by the number of a chama as
+// listed by BAL when the member belongs to more than one.
+const (
+	commandBalance   = "BAL"       // BAL
+	commandStatement = "STATEMENT" // STATEMENT [chama]
+	commandPay       = "PAY"       // PAY <amount> [chama]
+	commandHelp      = "HELP"
+)
+
+// CommandLimits are how often each command may be sent from one phone.
+// Messages over the limit are dropped without a reply so that a flood of
+// texts does not become a flood of paid SMS.
+var CommandLimits = map[string]ratelimit.Config{
+	commandBalance:   {Rate: 5.0 / 3600, Burst: 5}, // 5 an hour
+	commandStatement: {Rate: 3.0 / 3600, Burst: 3}, // 3 an hour
+	commandPay:       ratelimit.PaymentConfig,
+	commandHelp:      {Rate: 2.0 / 3600, Burst: 2}, // also used for anything not understood
+}
+
+const smsHelp = "TujiFund commands:\nBAL - balances\nSTATEMENT - mini-statement\nPAY 500 - contribute by M-Pesa\n" +
+	"Add the chama number from BAL if you are in more than one, e.g. PAY 500 2"
+
+// SMSService answers commands texted to the platform's short code, for
+// members who cannot rely on USSD. Replies only ever go to the phone that
+// sent the command, and only about the member registered to that number,
+// so a forged message cannot reveal anyone's balance to the forger.
+type SMSService struct {
+	*Service
+	sender   otp.Sender
+	limiters map[string]*ratelimit.Limiter
+}
+
+// NewSMSService creates an SMS command service replying through sender
+func NewSMSService(s *Service, sender otp.Sender) *SMSService {
+	limiters := make(map[string]*ratelimit.Limiter, len(CommandLimits))
+	store := ratelimit.NewMemoryStore()
+	for command, cfg := range CommandLimits {
+		limiters[command] = ratelimit.New("sms_"+strings.ToLower(command), cfg, store)
+	}
+	return &SMSService{Service: s, sender: sender, limiters: limiters}
+}
+
+// Receive answers the text sent from phone. The reply is sent by SMS; texts
+// over the command's rate limit get none.
+func (s *SMSService) Receive(ctx context.Context, phone, text string) error {
+	fields := strings.Fields(strings.ToUpper(text))
+	command := commandHelp
+	if len(fields) > 0 {
+		if _, ok := CommandLimits[fields[0]]; ok {
+			command = fields[0]
+		}
+	}
+	if ok, _ := s.limiters[command].Allow(payments.MSISDN(phone)); !ok {
+		slog.WarnContext(ctx, "SMS command rate limited", "command", command)
+		return nil
+	}
+
+	reply, chamaID, err := s.command(ctx, phone, command, fields)
+	if err != nil {
+		slog.ErrorContext(ctx, "SMS command failed", "command", command, "error", err)
+		reply, chamaID = "Sorry, something went wrong. Please try again later.", ""
+	}
+	if chamaID != "" {
+		ctx = tenancy.WithChama(ctx, chamaID)
+	}
+	return s.sender.Send(ctx, phone, reply)
+}
+
+// command carries out a command and returns the reply and the chama it was about
+func (s *SMSService) command(ctx context.Context, phone, command string, fields []string) (string, string, error) {
+	if command == commandHelp {
+		return smsHelp, "", nil
+	}
+	userID, err := userByPhone(ctx, s.db, phone)
+	if err != nil {
+		return "", "", err
+	}
+	if userID == "" {
+		return "This number is not registered with TujiFund. Register in the app or ask your chama treasurer.", "", nil
+	}
+	list, err := s.chamas(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if len(list) == 0 {
+		return "You are not a member of any active chama.", "", nil
+	}
+
+	args := fields[1:]
+	switch command {
+	case commandBalance:
+		var b strings.Builder
+		for i, c := range list {
+			text, err := s.balance(ctx, c.id, userID)
+			if err != nil {
+				return "", "", err
+			}
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			if len(list) > 1 {
+				fmt.Fprintf(&b, "%d. ", i+1)
+			}
+			b.WriteString(text)
+		}
+		if len(list) == 1 {
+			return b.String(), list[0].id, nil
+		}
+		return b.String(), "", nil
+
+	case commandStatement:
+		c, ok := pick(list, args)
+		if !ok {
+			return "Which chama? Send STATEMENT followed by its number from BAL, e.g. STATEMENT 2", "", nil
+		}
+		text, err := s.statement(ctx, c.id, userID)
+		return text, c.id, err
+
+	default: // commandPay
+		if len(args) == 0 {
+			return "Send PAY followed by the amount, e.g. PAY 500", "", nil
+		}
+		c, ok := pick(list, args[1:])
+		if !ok {
+			return "Which chama? Send PAY, the amount and its number from BAL, e.g. PAY 500 2", "", nil
+		}
+		if s.mpesa == nil {
+			return "M-Pesa contributions are not available right now.", c.id, nil
+		}
+		currency, err := money.ChamaCurrency(s.db, c.id)
+		if err != nil {
+			return "", "", err
+		}
+		amount, err := money.Parse(args[0], currency)
+		if err != nil || amount.Amount <= 0 || amount.Amount%100 != 0 {
+			return "Send a whole amount in " + currency + ", e.g. PAY 500", c.id, nil
+		}
+		_, err = contributions.Request(ctx, s.db, s.mpesa, contributions.Contribution{
+			ChamaID: c.id, MemberID: userID, Amount: amount, Notes: "SMS",
+		}, phone)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to request SMS contribution", "chama_id", c.id, "error", err)
+			return "We could not start the M-Pesa payment. Please try again later.", c.id, nil
+		}
+		return fmt.Sprintf("Enter your M-Pesa PIN on the prompt to pay %s to %s.", amount, c.name), c.id, nil
+	}
+}
+
+// pick returns the chama numbered by args[0] in list, or the only one when
+// no number is given
+func pick(list []chama, args []string) (chama, bool) {
+	if len(args) == 0 {
+		return list[0], len(list) == 1
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(list) {
+		return chama{}, false
+	}
+	return list[n-1], true
+}
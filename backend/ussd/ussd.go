@@ -0,0 +1,327 @@
+package ussd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/phone"
+	"tujifund-app/backend/reports"
+)
+
+// Session states
+const (
+	stateMenu    = "menu"    // main menu shown, waiting for a choice
+	stateChama   = "chama"   // chama list shown, waiting for a choice
+	stateAmount  = "amount"  // waiting for a contribution amount
+	stateConfirm = "confirm" // waiting to confirm a contribution
+)
+
+// Menu choices
+const (
+	actionBalance    = "1"
+	actionContribute = "2"
+	actionStatement  = "3"
+)
+
+// statementLines is how many transactions fit on a mini-statement screen
+const statementLines = 5
+
+// SessionTTL is how long a USSD session is kept. Gateways end sessions
+// after a few minutes; stale rows are removed by the cleanup job.
+const SessionTTL = 10 * time.Minute
+
+// Session is one member's pass through the USSD menus
+type Session struct {
+	ID      string `json:"-"`
+	Phone   string `json:"-"`
+	UserID  string `json:"-"`
+	State   string `json:"-"`
+	ChamaID string `json:"chamaId,omitempty"`
+	Action  string `json:"action,omitempty"`
+	Amount  int64  `json:"amount,omitempty"`
+}
+
+// Reply is a screen sent back to the phone. End closes the session.
+type Reply struct {
+	Text string
+	End  bool
+}
+
+func next(text string) Reply { return Reply{Text: text} }
+func end(text string) Reply  { return Reply{Text: text, End: true} }
+
+// Service runs USSD sessions against the chama services
+type Service struct {
+	db    *sql.DB
+	mpesa payments.Provider
+}
+
+// NewService creates a USSD service. Contributions are paid with the M-Pesa
+// provider and turned away when it is not registered.
+func NewService(db *sql.DB) *Service {
+	mpesa, _ := payments.Lookup(payments.ProviderMpesa)
+	return &Service{db: db, mpesa: mpesa}
+}
+
+// Handle moves the session sessionID from phone on by input, the member's
+// latest entry (empty when the session starts), and returns the next screen
+func (s *Service) Handle(ctx context.Context, sessionID, phone, input string) (Reply, error) {
+	sess, err := s.load(ctx, sessionID, phone)
+	if err != nil {
+		return Reply{}, err
+	}
+	if sess.UserID == "" {
+		return end("This number is not registered with TujiFund. Register in the app or ask your chama treasurer."), nil
+	}
+
+	reply, err := s.step(ctx, &sess, strings.TrimSpace(input))
+	if err != nil {
+		return Reply{}, err
+	}
+	if reply.End {
+		_, err = s.db.ExecContext(ctx, `DELETE FROM ussd_sessions WHERE id = ?`, sess.ID)
+	} else {
+		err = s.save(ctx, sess)
+	}
+	return reply, err
+}
+
+func (s *Service) step(ctx context.Context, sess *Session, input string) (Reply, error) {
+	switch sess.State {
+	case "":
+		sess.State = stateMenu
+		return s.menu(ctx, sess)
+
+	case stateMenu:
+		if input != actionBalance && input != actionContribute && input != actionStatement {
+			return s.menu(ctx, sess)
+		}
+		sess.Action = input
+		list, err := s.chamas(ctx, sess.UserID)
+		if err != nil {
+			return Reply{}, err
+		}
+		switch len(list) {
+		case 0:
+			return end("You are not a member of any active chama."), nil
+		case 1:
+			sess.ChamaID = list[0].id
+			return s.act(ctx, sess)
+		}
+		sess.State = stateChama
+		return next(chamaMenu(list)), nil
+
+	case stateChama:
+		list, err := s.chamas(ctx, sess.UserID)
+		if err != nil {
+			return Reply{}, err
+		}
+		n, err := strconv.Atoi(input)
+		if err != nil || n < 1 || n > len(list) {
+			return next("Invalid choice.\n" + chamaMenu(list)), nil
+		}
+		sess.ChamaID = list[n-1].id
+		return s.act(ctx, sess)
+
+	case stateAmount:
+		currency, err := money.ChamaCurrency(s.db, sess.ChamaID)
+		if err != nil {
+			return Reply{}, err
+		}
+		amount, err := money.Parse(input, currency)
+		if err != nil || amount.Amount <= 0 || amount.Amount%100 != 0 {
+			return next("Enter a whole amount in " + currency + ":"), nil
+		}
+		sess.Amount, sess.State = amount.Amount, stateConfirm
+		return next(fmt.Sprintf("Pay %s to %s from %s?\n1. Confirm\n2. Cancel",
+			amount, s.chamaName(ctx, sess.ChamaID), sess.Phone)), nil
+
+	case stateConfirm:
+		if input != "1" {
+			return end("Contribution cancelled."), nil
+		}
+		currency, err := money.ChamaCurrency(s.db, sess.ChamaID)
+		if err != nil {
+			return Reply{}, err
+		}
+		_, err = contributions.Request(ctx, s.db, s.mpesa, contributions.Contribution{
+			ChamaID: sess.ChamaID, MemberID: sess.UserID, Amount: money.New(sess.Amount, currency), Notes: "USSD",
+		}, sess.Phone)
+		if err != nil {
+			return end("We could not start the M-Pesa payment. Please try again later."), nil
+		}
+		return end("Enter your M-Pesa PIN on the prompt to complete your contribution."), nil
+	}
+	return end("Session expired. Please dial again."), nil
+}
+
+// act carries out the chosen action once a chama has been picked
+func (s *Service) act(ctx context.Context, sess *Session) (Reply, error) {
+	switch sess.Action {
+	case actionContribute:
+		if s.mpesa == nil {
+			return end("M-Pesa contributions are not available right now."), nil
+		}
+		sess.State = stateAmount
+		return next("Enter amount to contribute:"), nil
+	case actionBalance:
+		text, err := s.balance(ctx, sess.ChamaID, sess.UserID)
+		if err != nil {
+			return Reply{}, err
+		}
+		return end(text), nil
+	default:
+		text, err := s.statement(ctx, sess.ChamaID, sess.UserID)
+		if err != nil {
+			return Reply{}, err
+		}
+		return end(text), nil
+	}
+}
+
+// balance describes userID's savings and loan balance in a chama
+func (s *Service) balance(ctx context.Context, chamaID, userID string) (string, error) {
+	st, err := reports.BuildMemberStatement(ctx, s.db, chamaID, userID, reports.Period{To: time.Now().UTC()})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\nSavings: %s\nLoan balance: %s", st.ChamaName, st.Closing, st.LoanOutstanding), nil
+}
+
+// statement lists userID's latest transactions in a chama, newest first
+func (s *Service) statement(ctx context.Context, chamaID, userID string) (string, error) {
+	st, err := reports.BuildMemberStatement(ctx, s.db, chamaID, userID, reports.Period{To: time.Now().UTC()})
+	if err != nil {
+		return "", err
+	}
+	lines := st.Lines
+	if len(lines) > statementLines {
+		lines = lines[len(lines)-statementLines:]
+	}
+	var b strings.Builder
+	b.WriteString(st.ChamaName + "\n")
+	if len(lines) == 0 {
+		b.WriteString("No transactions yet.\n")
+	}
+	for i := len(lines) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%s %s %s\n", lines[i].Date.Format("02/01"), label(lines[i].Type), lines[i].Amount.Decimal())
+	}
+	fmt.Fprintf(&b, "Savings: %s", st.Closing)
+	return b.String(), nil
+}
+
+func (s *Service) menu(ctx context.Context, sess *Session) (Reply, error) {
+	var name string
+	s.db.QueryRowContext(ctx, `SELECT COALESCE(first_name, '') FROM users WHERE user_id = ?`, sess.UserID).Scan(&name)
+	return next(strings.TrimSpace("Welcome to TujiFund "+name) +
+		"\n1. Check balance\n2. Contribute (M-Pesa)\n3. Mini-statement"), nil
+}
+
+// label shortens a ledger entry type for a narrow screen
+func label(entryType string) string {
+	words := strings.Split(entryType, "_")
+	for i, w := range words {
+		if len(w) > 6 {
+			words[i] = w[:6]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+type chama struct{ id, name string }
+
+// chamas lists the active chamas userID belongs to, as many as fit on a menu
+func (s *Service) chamas(ctx context.Context, userID string) ([]chama, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.id, c.name FROM chama_members m JOIN chamas c ON c.id = m.chama_id
+		WHERE m.user_id = ? AND m.status = 'active' AND c.status IN ('active', 'dormant')
+		ORDER BY c.name LIMIT 9`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []chama
+	for rows.Next() {
+		var c chama
+		if err := rows.Scan(&c.id, &c.name); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+func chamaMenu(list []chama) string {
+	var b strings.Builder
+	b.WriteString("Choose chama:")
+	for i, c := range list {
+		fmt.Fprintf(&b, "\n%d. %s", i+1, c.name)
+	}
+	return b.String()
+}
+
+func (s *Service) chamaName(ctx context.Context, chamaID string) string {
+	var name string
+	s.db.QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, chamaID).Scan(&name)
+	return name
+}
+
+// load returns the stored session, or a new one for the user registered to phone
+func (s *Service) load(ctx context.Context, sessionID, phone string) (Session, error) {
+	sess := Session{ID: sessionID, Phone: phone}
+	var data string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(user_id, ''), state, data FROM ussd_sessions WHERE id = ? AND phone_number = ?`,
+		sessionID, phone).Scan(&sess.UserID, &sess.State, &data)
+	if err == nil {
+		return sess, json.Unmarshal([]byte(data), &sess)
+	}
+	if err != sql.ErrNoRows {
+		return sess, err
+	}
+	sess.UserID, err = userByPhone(ctx, s.db, phone)
+	return sess, err
+}
+
+// userByPhone returns the user registered to number, or "" if there is none.
+// Numbers stored before they were normalised may be in the local or
+// international form, so all three are tried.
+func userByPhone(ctx context.Context, db *sql.DB, number string) (string, error) {
+	var userID string
+	err := db.QueryRowContext(ctx, `
+		SELECT user_id FROM users WHERE REPLACE(REPLACE(phone_number, ' ', ''), '-', '') IN (?, ?, ?)
+		ORDER BY id LIMIT 1`,
+		phone.Canonical(number), phone.Local(number), phone.MSISDN(number)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return userID, err
+}
+
+func (s *Service) save(ctx context.Context, sess Session) error {
+	data, _ := json.Marshal(sess)
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO ussd_sessions (id, phone_number, user_id, state, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET state = excluded.state, data = excluded.data, updated_at = CURRENT_TIMESTAMP`,
+		sess.ID, sess.Phone, sess.UserID, sess.State, string(data))
+	return err
+}
+
+// RegisterCleanupJob removes sessions the gateway abandoned
+func RegisterCleanupJob(s *jobs.Scheduler, db *sql.DB) {
+	s.Register("ussd_session_cleanup", jobs.Every(time.Hour), func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, `DELETE FROM ussd_sessions WHERE updated_at < ?`,
+			time.Now().UTC().Add(-SessionTTL).Format("2006-01-02 15:04:05"))
+		return err
+	})
+}
@@ -0,0 +1,174 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/phone"
+)
+
+// Message codes. Each code is a key in the i18n catalogs.
+const (
+	CodeRequired   = "validation.required"
+	CodeEmail      = "validation.email"
+	CodePhone      = "validation.phone"
+	CodeAmount     = "validation.amount"
+	CodeNationalID = "validation.national_id"
+	CodeDate       = "validation.date"
+	CodeMinLength  = "validation.min_length"
+	CodeOneOf      = "validation.one_of"
+	CodeDuplicate  = "validation.duplicate"
+	CodeMember     = "validation.already_member"
+	CodeNotFound   = "validation.not_found"
+	CodeRange      = "validation.range"
+	CodeMinCount   = "validation.min_count"
+)
+
+var (
+	amountPattern     = regexp.MustCompile(`^\d+(?:\.\d{1,2})?$`)
+	nationalIDPattern = regexp.MustCompile(`^\d{7,8}$`)
+)
+
+// FieldError describes a single invalid input field
+type FieldError struct {
+	Field   string            `json:"field"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Params  map[string]string `json:"-"`
+}
+
+// Errors is a list of field errors that also satisfies the error interface
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Localize returns a copy of the errors with messages rendered in lang
+func (e Errors) Localize(lang string) Errors {
+	out := make(Errors, len(e))
+	for i, fe := range e {
+		fe.Message = i18n.T(lang, fe.Code, fe.Params)
+		out[i] = fe
+	}
+	return out
+}
+
+// Validator collects field errors for one request
+type Validator struct {
+	errs Errors
+}
+
+// New creates an empty validator
+func New() *Validator {
+	return &Validator{}
+}
+
+// Valid reports whether no errors have been recorded
+func (v *Validator) Valid() bool {
+	return len(v.errs) == 0
+}
+
+// Errors returns the recorded field errors
+func (v *Validator) Errors() Errors {
+	return v.errs
+}
+
+// Add records an error for field with the given code and template parameters
+func (v *Validator) Add(field, code string, params map[string]string) {
+	if params == nil {
+		params = map[string]string{}
+	}
+	params["field"] = field
+	v.errs = append(v.errs, FieldError{
+		Field:   field,
+		Code:    code,
+		Message: i18n.T(i18n.Default, code, params),
+		Params:  params,
+	})
+}
+
+// Required checks that value is not blank
+func (v *Validator) Required(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, CodeRequired, nil)
+		return false
+	}
+	return true
+}
+
+// Email checks that value is a plain email address
+func (v *Validator) Email(field, value string) {
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		v.Add(field, CodeEmail, nil)
+	}
+}
+
+// Phone checks that value is a Kenyan mobile number in local or international format
+func (v *Validator) Phone(field, value string) {
+	if !phone.Valid(value) {
+		v.Add(field, CodePhone, nil)
+	}
+}
+
+// Amount checks that value is a positive decimal amount with at most two decimal places
+func (v *Validator) Amount(field, value string) {
+	if !amountPattern.MatchString(value) || strings.Trim(value, "0.") == "" {
+		v.Add(field, CodeAmount, nil)
+	}
+}
+
+// NationalID checks that value looks like a Kenyan national ID number
+func (v *Validator) NationalID(field, value string) {
+	if !nationalIDPattern.MatchString(value) {
+		v.Add(field, CodeNationalID, nil)
+	}
+}
+
+// Date checks that value is a calendar date in YYYY-MM-DD format
+func (v *Validator) Date(field, value string) {
+	if _, err := time.Parse("2006-01-02", value); err != nil {
+		v.Add(field, CodeDate, nil)
+	}
+}
+
+// MinLength checks that value has at least min characters
+func (v *Validator) MinLength(field, value string, min int) {
+	if len([]rune(value)) < min {
+		v.Add(field, CodeMinLength, map[string]string{"min": fmt.Sprint(min)})
+	}
+}
+
+// OneOf checks that value is one of the allowed options
+func (v *Validator) OneOf(field, value string, options ...string) {
+	for _, o := range options {
+		if value == o {
+			return
+		}
+	}
+	v.Add(field, CodeOneOf, map[string]string{"options": strings.Join(options, ", ")})
+}
+
+// WriteErrors responds with 422 and the field-level errors as JSON, translated
+// into the language requested by r
+func WriteErrors(w http.ResponseWriter, r *http.Request, errs Errors) {
+	lang := i18n.FromRequest(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", lang)
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": i18n.T(lang, "validation.failed", nil),
+		"errors":  errs.Localize(lang),
+	})
+}
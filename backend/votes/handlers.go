@@ -0,0 +1,299 @@
+package votes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// SettingsHandler returns the {chamaId} chama's voting thresholds
+func SettingsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		s, err := GetSettings(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// UpdateSettingsHandler lets the chairperson or an admin change the chama's voting thresholds
+func UpdateSettingsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, chamas.RoleAdmin, chamas.RoleChairperson) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var s Settings
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		s.ChamaID = chamaID
+		v := validation.New()
+		validateThresholds(v, s.QuorumBps, s.MajorityBps)
+		v.OneOf("ballotType", s.BallotType, BallotOpen, BallotAnonymous)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		if err := SaveSettings(r.Context(), db, s, audit.FromRequest(r, audit.Entry{})); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// CreateHandler lets an official put a motion to the {chamaId} chama's members
+func CreateHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			SubjectType string `json:"subjectType"`
+			SubjectID   string `json:"subjectId"`
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			BallotType  string `json:"ballotType"`
+			QuorumBps   int    `json:"quorumBps"`
+			MajorityBps int    `json:"majorityBps"`
+			ClosesAt    string `json:"closesAt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.SubjectType == "" {
+			request.SubjectType = SubjectGeneral
+		}
+
+		v := validation.New()
+		v.Required("title", request.Title)
+		v.OneOf("subjectType", request.SubjectType, SubjectLoanApproval, SubjectRuleChange, SubjectExpenditure, SubjectGeneral)
+		if request.SubjectType != SubjectGeneral {
+			v.Required("subjectId", request.SubjectID)
+		}
+		if request.BallotType != "" {
+			v.OneOf("ballotType", request.BallotType, BallotOpen, BallotAnonymous)
+		}
+		if request.QuorumBps != 0 || request.MajorityBps != 0 {
+			validateThresholds(v, request.QuorumBps, request.MajorityBps)
+		}
+		closesAt, err := time.Parse(time.RFC3339, request.ClosesAt)
+		if err != nil || closesAt.Before(time.Now()) {
+			v.Add("closesAt", validation.CodeDate, nil)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		vote, err := Open(r.Context(), db, Vote{
+			ChamaID:     chamaID,
+			SubjectType: request.SubjectType,
+			SubjectID:   request.SubjectID,
+			Title:       request.Title,
+			Description: request.Description,
+			BallotType:  request.BallotType,
+			QuorumBps:   request.QuorumBps,
+			MajorityBps: request.MajorityBps,
+			ClosesAt:    closesAt,
+			CreatedBy:   userID,
+		}, audit.FromRequest(r, audit.Entry{}))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyMembers(r.Context(), db, notifier, vote, "vote.opened", "notification.vote_opened")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(vote)
+	}
+}
+
+// ListHandler lists the {chamaId} chama's votes, optionally filtered by ?status
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := List(r.Context(), db, chamaID, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// GetHandler returns the {voteId} vote with whether the caller has voted, the
+// ballots of open (non-anonymous) votes and the vote's audit trail
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vote, userID, ok := loadVote(db, w, r)
+		if !ok {
+			return
+		}
+		response := map[string]interface{}{
+			"vote":     vote,
+			"hasVoted": HasVoted(r.Context(), db, vote.ID, userID),
+		}
+		if vote.BallotType == BallotOpen {
+			ballots, err := Ballots(r.Context(), db, vote.ID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			response["ballots"] = ballots
+		}
+		trail, err := audit.List(r.Context(), db, "vote", vote.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response["auditTrail"] = trail
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// CastHandler records the caller's ballot ("yes", "no" or "abstain") on the {voteId} vote
+func CastHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vote, userID, ok := loadVote(db, w, r)
+		if !ok {
+			return
+		}
+		var request struct {
+			Choice string `json:"choice"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.OneOf("choice", request.Choice, ChoiceYes, ChoiceNo, ChoiceAbstain)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		vote, err := Cast(r.Context(), db, vote.ID, userID, request.Choice)
+		switch {
+		case errors.Is(err, ErrNotEligible):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, ErrClosed), errors.Is(err, ErrAlreadyVoted):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if vote.Status != StatusOpen {
+			notifyMembers(r.Context(), db, notifier, vote, "vote.closed", "notification.vote_result")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(vote)
+	}
+}
+
+// CancelHandler lets an official withdraw the {voteId} vote before it closes
+func CancelHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vote, userID, ok := loadVote(db, w, r)
+		if !ok {
+			return
+		}
+		if !chamas.IsOfficial(db, vote.ChamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err := Cancel(r.Context(), db, vote.ID, audit.FromRequest(r, audit.Entry{})); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// loadVote loads the {voteId} vote for a member of its chama, writing an
+// error response and returning false otherwise
+func loadVote(db *sql.DB, w http.ResponseWriter, r *http.Request) (Vote, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Vote{}, "", false
+	}
+	vote, err := Get(r.Context(), db, mux.Vars(r)["voteId"])
+	if err == sql.ErrNoRows || (err == nil && !chamas.IsMember(db, vote.ChamaID, userID)) {
+		http.Error(w, "Vote not found", http.StatusNotFound)
+		return vote, userID, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return vote, userID, false
+	}
+	return vote, userID, true
+}
+
+// validateThresholds checks that quorum is 1-10000 and majority 0-9999 basis points
+func validateThresholds(v *validation.Validator, quorumBps, majorityBps int) {
+	if quorumBps < 1 || quorumBps > 10000 {
+		v.Add("quorumBps", validation.CodeOneOf, map[string]string{"options": "1-10000"})
+	}
+	if majorityBps < 0 || majorityBps > 9999 {
+		v.Add("majorityBps", validation.CodeOneOf, map[string]string{"options": "0-9999"})
+	}
+}
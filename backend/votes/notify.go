@@ -0,0 +1,71 @@
+package votes
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/txn"
+)
+
+// notifyMembers sends a message about v to every member eligible to vote on it
+func notifyMembers(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, v Vote, titleKey, messageKey string) {
+	if notifier == nil {
+		return
+	}
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `SELECT member_id FROM vote_participants WHERE vote_id = ?`, v.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load vote participants", "vote_id", v.ID, "error", err)
+		return
+	}
+	var members []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			members = append(members, id)
+		}
+	}
+	rows.Close()
+
+	var chama string
+	txn.From(ctx, db).QueryRowContext(ctx, `SELECT name FROM chamas WHERE id = ?`, v.ChamaID).Scan(&chama)
+	params := map[string]string{
+		"chama":   chama,
+		"title":   v.Title,
+		"date":    v.ClosesAt.Format("Mon 2 Jan 2006 15:04"),
+		"result":  i18n.T(i18n.Default, "vote."+v.Status, nil),
+		"yes":     strconv.Itoa(v.Tally.Yes),
+		"no":      strconv.Itoa(v.Tally.No),
+		"abstain": strconv.Itoa(v.Tally.Abstain),
+	}
+	for _, id := range members {
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, titleKey, nil),
+			Message:   i18n.T(i18n.Default, messageKey, params),
+			Type:      notifications.TypeChama,
+			RelatedID: v.ID,
+		})
+	}
+}
+
+// NotifyOpened tells every member eligible to vote on v that it is open
+func NotifyOpened(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, v Vote) {
+	notifyMembers(ctx, db, notifier, v, "vote.opened", "notification.vote_opened")
+}
+
+// RegisterTallyJob closes votes whose deadline has passed and announces the results
+func RegisterTallyJob(s *jobs.Scheduler, db *sql.DB, notifier *notifications.Notifier) {
+	s.Register("vote_tally", jobs.Every(5*time.Minute), func(ctx context.Context) error {
+		closed, err := CloseExpired(ctx, db)
+		for _, v := range closed {
+			notifyMembers(ctx, db, notifier, v, "vote.closed", "notification.vote_result")
+		}
+		return err
+	})
+}
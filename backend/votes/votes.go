@@ -0,0 +1,476 @@
+package votes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/txn"
+
+	"github.com/google/uuid"
+)
+
+// Subject types
+const (
+	SubjectLoanApproval = "loan_approval"
+	SubjectRuleChange   = "rule_change"
+	SubjectExpenditure  = "expenditure"
+	SubjectDissolution  = "dissolution"
+	SubjectGeneral      = "general"
+	SubjectApproval     = "approval" // spending above a chama's approval tiers, opened by the approvals module
+)
+
+// Ballot types
+const (
+	BallotOpen      = "open"
+	BallotAnonymous = "anonymous"
+)
+
+// Vote statuses
+const (
+	StatusOpen      = "open"
+	StatusPassed    = "passed"
+	StatusRejected  = "rejected"
+	StatusNoQuorum  = "no_quorum"
+	StatusCancelled = "cancelled"
+)
+
+// Choices
+const (
+	ChoiceYes     = "yes"
+	ChoiceNo      = "no"
+	ChoiceAbstain = "abstain"
+)
+
+var (
+	// ErrClosed is returned when casting on or cancelling a vote that is no longer open
+	ErrClosed = errors.New("vote is closed")
+	// ErrNotEligible is returned when the member was not eligible when the vote opened
+	ErrNotEligible = errors.New("member is not eligible to vote")
+	// ErrAlreadyVoted is returned on a second ballot from the same member
+	ErrAlreadyVoted = errors.New("member has already voted")
+)
+
+// OutcomeHooks run after a vote on a subject type closes, so the owning module
+// can act on the result (e.g. disburse an approved loan). Hook errors are
+// logged and do not change the recorded result.
+var OutcomeHooks = map[string]func(ctx context.Context, db *sql.DB, v Vote) error{}
+
+// Settings are a chama's default voting thresholds
+type Settings struct {
+	ChamaID     string `json:"chamaId"`
+	QuorumBps   int    `json:"quorumBps"`
+	MajorityBps int    `json:"majorityBps"`
+	BallotType  string `json:"ballotType"`
+}
+
+// DefaultSettings apply to chamas that have not configured voting: half the
+// members must vote and a simple majority carries
+var DefaultSettings = Settings{QuorumBps: 5000, MajorityBps: 5000, BallotType: BallotOpen}
+
+// Vote is a motion put to the members of a chama
+type Vote struct {
+	ID            string     `json:"id"`
+	ChamaID       string     `json:"chamaId"`
+	SubjectType   string     `json:"subjectType"`
+	SubjectID     string     `json:"subjectId,omitempty"`
+	Title         string     `json:"title"`
+	Description   string     `json:"description,omitempty"`
+	BallotType    string     `json:"ballotType"`
+	QuorumBps     int        `json:"quorumBps"`
+	MajorityBps   int        `json:"majorityBps"`
+	EligibleCount int        `json:"eligibleCount"`
+	ClosesAt      time.Time  `json:"closesAt"`
+	Status        string     `json:"status"`
+	Tally         Tally      `json:"tally"`
+	CreatedBy     string     `json:"createdBy"`
+	ClosedAt      *time.Time `json:"closedAt,omitempty"`
+}
+
+// Tally is the count of ballots cast
+type Tally struct {
+	Yes     int `json:"yes"`
+	No      int `json:"no"`
+	Abstain int `json:"abstain"`
+}
+
+// Turnout is the number of members who voted
+func (t Tally) Turnout() int { return t.Yes + t.No + t.Abstain }
+
+// Ballot is one member's choice. VoterID is empty for anonymous votes.
+type Ballot struct {
+	VoterID string `json:"voterId,omitempty"`
+	Choice  string `json:"choice"`
+	CastAt  string `json:"castAt,omitempty"`
+}
+
+// Outcome decides the result of a tally: quorum is met when turnout reaches
+// QuorumBps of eligible members, and the motion carries when yes votes are
+// strictly more than MajorityBps of yes and no votes. Abstentions count
+// towards quorum only.
+func (v Vote) Outcome() string {
+	if v.EligibleCount == 0 || v.Tally.Turnout()*10000 < v.QuorumBps*v.EligibleCount {
+		return StatusNoQuorum
+	}
+	decided := v.Tally.Yes + v.Tally.No
+	if decided > 0 && v.Tally.Yes*10000 > v.MajorityBps*decided {
+		return StatusPassed
+	}
+	return StatusRejected
+}
+
+// GetSettings returns a chama's voting settings, or DefaultSettings
+func GetSettings(ctx context.Context, db *sql.DB, chamaID string) (Settings, error) {
+	s := DefaultSettings
+	s.ChamaID = chamaID
+	err := txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT quorum_bps, majority_bps, ballot_type FROM voting_settings WHERE chama_id = ?`, chamaID,
+	).Scan(&s.QuorumBps, &s.MajorityBps, &s.BallotType)
+	if err == sql.ErrNoRows {
+		return s, nil
+	}
+	return s, err
+}
+
+// SaveSettings stores a chama's voting settings and records the change in the audit log
+func SaveSettings(ctx context.Context, db *sql.DB, s Settings, entry audit.Entry) error {
+	old, err := GetSettings(ctx, db, s.ChamaID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback(tx)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO voting_settings (chama_id, quorum_bps, majority_bps, ballot_type, updated_by)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (chama_id) DO UPDATE SET quorum_bps = excluded.quorum_bps, majority_bps = excluded.majority_bps,
+			ballot_type = excluded.ballot_type, updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP`,
+		s.ChamaID, s.QuorumBps, s.MajorityBps, s.BallotType, entry.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to save voting settings: %w", err)
+	}
+
+	entry.Action, entry.EntityType, entry.EntityID = "voting_settings.update", "chama", s.ChamaID
+	entry.OldValues, entry.NewValues = old, s
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+	return txn.Commit(tx)
+}
+
+// Open starts a vote. Thresholds and ballot type not set on v are taken from
+// the chama's settings, and every active member becomes eligible.
+func Open(ctx context.Context, db *sql.DB, v Vote, entry audit.Entry) (Vote, error) {
+	settings, err := GetSettings(ctx, db, v.ChamaID)
+	if err != nil {
+		return v, err
+	}
+	if v.QuorumBps == 0 {
+		v.QuorumBps = settings.QuorumBps
+	}
+	if v.MajorityBps == 0 {
+		v.MajorityBps = settings.MajorityBps
+	}
+	if v.BallotType == "" {
+		v.BallotType = settings.BallotType
+	}
+	v.ID = uuid.NewString()
+	v.Status = StatusOpen
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return v, err
+	}
+	defer txn.Rollback(tx)
+
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM chama_members WHERE chama_id = ? AND status = 'active'`, v.ChamaID,
+	).Scan(&v.EligibleCount); err != nil {
+		return v, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO votes
+		(id, chama_id, subject_type, subject_id, title, description, ballot_type, quorum_bps, majority_bps,
+		 eligible_count, closes_at, status, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		v.ID, v.ChamaID, v.SubjectType, nullIfEmpty(v.SubjectID), v.Title, nullIfEmpty(v.Description), v.BallotType,
+		v.QuorumBps, v.MajorityBps, v.EligibleCount, v.ClosesAt.UTC().Format("2006-01-02 15:04:05"), v.Status, v.CreatedBy)
+	if err != nil {
+		return v, fmt.Errorf("failed to open vote: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO vote_participants (vote_id, member_id)
+		SELECT ?, user_id FROM chama_members WHERE chama_id = ? AND status = 'active'`, v.ID, v.ChamaID); err != nil {
+		return v, err
+	}
+
+	entry.Action, entry.EntityType, entry.EntityID, entry.NewValues = "vote.open", "vote", v.ID, v
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return v, err
+	}
+	return v, txn.Commit(tx)
+}
+
+const columns = `id, chama_id, subject_type, COALESCE(subject_id, ''), title, COALESCE(description, ''), ballot_type,
+	quorum_bps, majority_bps, eligible_count, closes_at, status, yes_count, no_count, abstain_count, created_by, closed_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Vote, error) {
+	var v Vote
+	var closedAt sql.NullTime
+	err := row.Scan(&v.ID, &v.ChamaID, &v.SubjectType, &v.SubjectID, &v.Title, &v.Description, &v.BallotType,
+		&v.QuorumBps, &v.MajorityBps, &v.EligibleCount, &v.ClosesAt, &v.Status,
+		&v.Tally.Yes, &v.Tally.No, &v.Tally.Abstain, &v.CreatedBy, &closedAt)
+	if closedAt.Valid {
+		v.ClosedAt = &closedAt.Time
+	}
+	return v, err
+}
+
+// Get returns a vote
+func Get(ctx context.Context, db *sql.DB, id string) (Vote, error) {
+	return scan(txn.From(ctx, db).QueryRowContext(ctx, `SELECT `+columns+` FROM votes WHERE id = ?`, id))
+}
+
+// List returns a chama's votes, newest first, optionally filtered by status
+func List(ctx context.Context, db *sql.DB, chamaID, status string) ([]Vote, error) {
+	query := `SELECT ` + columns + ` FROM votes WHERE chama_id = ?`
+	args := []interface{}{chamaID}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	rows, err := txn.From(ctx, db).QueryContext(ctx, query+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Vote{}
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// Cast records a member's ballot. For anonymous votes the choice is stored
+// without the voter or time, and only the fact that the member voted is kept.
+// Once every eligible member has voted the vote is tallied straight away.
+func Cast(ctx context.Context, db *sql.DB, voteID, memberID, choice string) (Vote, error) {
+	v, err := Get(ctx, db, voteID)
+	if err != nil {
+		return v, err
+	}
+	if v.Status != StatusOpen || time.Now().After(v.ClosesAt) {
+		return v, ErrClosed
+	}
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return v, err
+	}
+	defer txn.Rollback(tx)
+
+	var votedAt sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT voted_at FROM vote_participants WHERE vote_id = ? AND member_id = ?`, voteID, memberID,
+	).Scan(&votedAt)
+	if err == sql.ErrNoRows {
+		return v, ErrNotEligible
+	}
+	if err != nil {
+		return v, err
+	}
+	if votedAt.Valid {
+		return v, ErrAlreadyVoted
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE vote_participants SET voted_at = CURRENT_TIMESTAMP WHERE vote_id = ? AND member_id = ?`,
+		voteID, memberID); err != nil {
+		return v, err
+	}
+	if v.BallotType == BallotAnonymous {
+		_, err = tx.ExecContext(ctx, `INSERT INTO ballots (id, vote_id, choice, cast_at) VALUES (?, ?, ?, NULL)`,
+			uuid.NewString(), voteID, choice)
+	} else {
+		_, err = tx.ExecContext(ctx, `INSERT INTO ballots (id, vote_id, voter_id, choice) VALUES (?, ?, ?, ?)`,
+			uuid.NewString(), voteID, memberID, choice)
+	}
+	if err != nil {
+		return v, fmt.Errorf("failed to record ballot: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE votes SET yes_count = yes_count + (? = 'yes'), no_count = no_count + (? = 'no'),
+		       abstain_count = abstain_count + (? = 'abstain')
+		WHERE id = ?`, choice, choice, choice, voteID); err != nil {
+		return v, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return v, err
+	}
+
+	v, err = Get(ctx, db, voteID)
+	if err == nil && v.Tally.Turnout() >= v.EligibleCount {
+		return Close(ctx, db, voteID)
+	}
+	return v, err
+}
+
+// Ballots returns the individual ballots of a vote. Anonymous ballots have no voter or time.
+func Ballots(ctx context.Context, db *sql.DB, voteID string) ([]Ballot, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT COALESCE(voter_id, ''), choice, COALESCE(cast_at, '') FROM ballots
+		WHERE vote_id = ? ORDER BY cast_at`, voteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Ballot{}
+	for rows.Next() {
+		var b Ballot
+		if err := rows.Scan(&b.VoterID, &b.Choice, &b.CastAt); err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return list, rows.Err()
+}
+
+// HasVoted reports whether the member has cast a ballot on the vote
+func HasVoted(ctx context.Context, db *sql.DB, voteID, memberID string) bool {
+	var voted int
+	txn.From(ctx, db).QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM vote_participants WHERE vote_id = ? AND member_id = ? AND voted_at IS NOT NULL`,
+		voteID, memberID).Scan(&voted)
+	return voted > 0
+}
+
+// Close tallies an open vote, records the result in the audit log and runs
+// the subject type's outcome hook
+func Close(ctx context.Context, db *sql.DB, voteID string) (Vote, error) {
+	v, err := Get(ctx, db, voteID)
+	if err != nil {
+		return v, err
+	}
+	if v.Status != StatusOpen {
+		return v, ErrClosed
+	}
+	v.Status = v.Outcome()
+
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return v, err
+	}
+	defer txn.Rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE votes SET status = ?, closed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		v.Status, voteID, StatusOpen)
+	if err != nil {
+		return v, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return v, ErrClosed
+	}
+	err = audit.Record(ctx, tx, audit.Entry{
+		Action:     "vote.tally",
+		EntityType: "vote",
+		EntityID:   v.ID,
+		NewValues: map[string]interface{}{
+			"status": v.Status, "tally": v.Tally, "eligible": v.EligibleCount,
+			"quorumBps": v.QuorumBps, "majorityBps": v.MajorityBps,
+		},
+	})
+	if err != nil {
+		return v, err
+	}
+	if err := txn.Commit(tx); err != nil {
+		return v, err
+	}
+
+	if hook, ok := OutcomeHooks[v.SubjectType]; ok {
+		if err := hook(ctx, db, v); err != nil {
+			slog.ErrorContext(ctx, "Vote outcome hook failed", "vote_id", v.ID, "subject_type", v.SubjectType, "error", err)
+		}
+	}
+	return Get(ctx, db, voteID)
+}
+
+// Cancel withdraws an open vote
+func Cancel(ctx context.Context, db *sql.DB, voteID string, entry audit.Entry) error {
+	tx, err := txn.Begin(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE votes SET status = ?, closed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		StatusCancelled, voteID, StatusOpen)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrClosed
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "vote.cancel", "vote", voteID
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+	return txn.Commit(tx)
+}
+
+// CloseExpired tallies every open vote whose deadline has passed and returns them
+func CloseExpired(ctx context.Context, db *sql.DB) ([]Vote, error) {
+	rows, err := txn.From(ctx, db).QueryContext(ctx, `
+		SELECT id FROM votes WHERE status = ? AND closes_at <= ?`,
+		StatusOpen, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	var closed []Vote
+	for _, id := range ids {
+		v, err := Close(ctx, db, id)
+		if errors.Is(err, ErrClosed) {
+			continue
+		}
+		if err != nil {
+			return closed, err
+		}
+		closed = append(closed, v)
+	}
+	return closed, nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,195 @@
+package wallets
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"tujifund-app/backend/breaker"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/payments"
+	"tujifund-app/backend/phone"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// GetHandler returns the caller's wallet balances and latest transactions
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		balances, err := Balances(r.Context(), db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		list, err := Transactions(r.Context(), db, userID, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"balances": balances, "transactions": list})
+	}
+}
+
+// TopUpHandler loads the caller's wallet by mobile money. The body takes
+// amount, currency (KES by default) and phone, which defaults to the
+// caller's own number.
+func TopUpHandler(db *sql.DB, provider string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Amount   string `json:"amount"`
+			Currency string `json:"currency"`
+			Phone    string `json:"phone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.Phone == "" {
+			db.QueryRowContext(r.Context(), `SELECT COALESCE(phone_number, '') FROM users WHERE user_id = ?`, userID).
+				Scan(&request.Phone)
+		}
+		if request.Currency == "" {
+			request.Currency = "KES"
+		}
+		v := validation.New()
+		if v.Required("amount", request.Amount) {
+			v.Amount("amount", request.Amount)
+		}
+		if v.Required("phone", request.Phone) {
+			v.Phone("phone", request.Phone)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+		request.Phone = phone.Canonical(request.Phone)
+
+		amount, err := money.Parse(request.Amount, request.Currency)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, _ := payments.Lookup(provider)
+		t, err := TopUp(r.Context(), db, p, userID, amount, request.Phone)
+		switch {
+		case errors.Is(err, payments.ErrNoProvider):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case errors.Is(err, breaker.ErrUnavailable):
+			breaker.WriteError(w, err)
+			return
+		case errors.Is(err, ErrInvalidAmount):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+// OrdersHandler lists the caller's standing orders
+func OrdersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		list, err := Orders(r.Context(), db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// SetOrderHandler sets the caller's standing order to the {chamaId} chama.
+// The body takes an optional amount; without one the order pays the chama's
+// contribution amount.
+func SetOrderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsMember(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			Amount string `json:"amount"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		if request.Amount != "" {
+			v.Amount("amount", request.Amount)
+		}
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		currency, err := money.ChamaCurrency(db, chamaID)
+		if err != nil {
+			http.Error(w, "Chama not found", http.StatusNotFound)
+			return
+		}
+		amount := money.New(0, currency)
+		if request.Amount != "" {
+			amount, _ = money.Parse(request.Amount, currency)
+		}
+		o, err := SetOrder(r.Context(), db, Order{UserID: userID, ChamaID: chamaID, Amount: amount})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(o)
+	}
+}
+
+// CancelOrderHandler stops the caller's {orderId} standing order
+func CancelOrderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		err := CancelOrder(r.Context(), db, userID, mux.Vars(r)["orderId"])
+		if errors.Is(err, ErrOrderNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,253 @@
+package wallets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/contributions"
+	"tujifund-app/backend/dashboard"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/rules"
+	"tujifund-app/backend/storage"
+
+	"github.com/google/uuid"
+)
+
+// Standing order statuses
+const (
+	OrderActive    = "active"
+	OrderCancelled = "cancelled"
+)
+
+// ErrOrderNotFound is returned for a standing order that does not exist
+var ErrOrderNotFound = errors.New("standing order not found")
+
+// Order is a standing order to pay a member's contribution to a chama from
+// their wallet each cycle. A zero amount pays whatever the chama's rules ask
+// for at the time.
+type Order struct {
+	ID        string      `json:"id"`
+	UserID    string      `json:"userId"`
+	ChamaID   string      `json:"chamaId"`
+	ChamaName string      `json:"chamaName"`
+	Amount    money.Money `json:"amount"`
+	Status    string      `json:"status"`
+	// LastPaidDue is the due date of the last cycle the order paid for
+	LastPaidDue string    `json:"lastPaidDue,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+const orderColumns = `o.id, o.user_id, o.chama_id, COALESCE(c.name, ''), o.amount_minor, o.currency, o.status,
+	COALESCE(o.last_paid_due, ''), o.created_at`
+
+func scanOrder(row interface{ Scan(...interface{}) error }) (Order, error) {
+	var o Order
+	err := row.Scan(&o.ID, &o.UserID, &o.ChamaID, &o.ChamaName, &o.Amount.Amount, &o.Amount.Currency, &o.Status,
+		&o.LastPaidDue, &o.CreatedAt)
+	return o, err
+}
+
+// Orders returns userID's active standing orders
+func Orders(ctx context.Context, db *sql.DB, userID string) ([]Order, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+orderColumns+` FROM standing_orders o LEFT JOIN chamas c ON c.id = o.chama_id
+		WHERE o.user_id = ? AND o.status = ? ORDER BY c.name`, userID, OrderActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Order{}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+	}
+	return list, rows.Err()
+}
+
+// SetOrder creates or replaces the member's standing order to a chama
+func SetOrder(ctx context.Context, db *sql.DB, o Order) (Order, error) {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO standing_orders (id, user_id, chama_id, amount_minor, currency, status)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, chama_id) DO UPDATE SET amount_minor = excluded.amount_minor,
+			currency = excluded.currency, status = excluded.status, updated_at = CURRENT_TIMESTAMP`,
+		uuid.NewString(), o.UserID, o.ChamaID, o.Amount.Amount, o.Amount.Currency, OrderActive)
+	if err != nil {
+		return o, err
+	}
+	return scanOrder(db.QueryRowContext(ctx, `
+		SELECT `+orderColumns+` FROM standing_orders o LEFT JOIN chamas c ON c.id = o.chama_id
+		WHERE o.user_id = ? AND o.chama_id = ?`, o.UserID, o.ChamaID))
+}
+
+// CancelOrder stops one of userID's standing orders
+func CancelOrder(ctx context.Context, db *sql.DB, userID, id string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE standing_orders SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND status = ?`,
+		OrderCancelled, id, userID, OrderActive)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+// RunOrders pays every standing order whose contribution has fallen due in
+// the current cycle and is not yet paid. Whatever the member has already
+// paid this cycle by other means is taken off. When the wallet cannot cover
+// it the member is told once and the order is tried again each day until the
+// cycle ends. It returns how many orders were paid.
+func RunOrders(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, now time.Time) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+orderColumns+`, COALESCE(o.last_shortfall_due, '')
+		FROM standing_orders o
+		JOIN chamas c ON c.id = o.chama_id AND c.status = 'active'
+		JOIN chama_members m ON m.chama_id = o.chama_id AND m.user_id = o.user_id AND m.status = 'active'
+		WHERE o.status = ?`, OrderActive)
+	if err != nil {
+		return 0, err
+	}
+	type due struct {
+		order     Order
+		shortfall string
+	}
+	var orders []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.order.ID, &d.order.UserID, &d.order.ChamaID, &d.order.ChamaName, &d.order.Amount.Amount,
+			&d.order.Amount.Currency, &d.order.Status, &d.order.LastPaidDue, &d.order.CreatedAt, &d.shortfall); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		orders = append(orders, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	paid := 0
+	for _, d := range orders {
+		ok, err := run(ctx, db, store, notifier, d.order, d.shortfall, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to run standing order", "order_id", d.order.ID, "error", err)
+			continue
+		}
+		if ok {
+			paid++
+		}
+	}
+	return paid, nil
+}
+
+// run pays one standing order if its contribution is due, and reports whether it paid
+func run(ctx context.Context, db *sql.DB, store storage.Backend, notifier *notifications.Notifier, o Order, shortfall string, now time.Time) (bool, error) {
+	r, err := rules.Current(ctx, db, o.ChamaID)
+	if err != nil {
+		return false, err
+	}
+	cycle := dashboard.CycleAt(r, now)
+	dueDate := cycle.Due.Format("2006-01-02")
+	if now.Before(cycle.Due) || o.LastPaidDue == dueDate {
+		return false, nil
+	}
+
+	currency, err := money.ChamaCurrency(db, o.ChamaID)
+	if err != nil {
+		return false, err
+	}
+	amount := o.Amount
+	if amount.Amount <= 0 {
+		amount = r.ContributionAmount(currency)
+	}
+	var already int64
+	if err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount_minor), 0) FROM contribution_days
+		WHERE chama_id = ? AND member_id = ? AND day >= ? AND day < ?`,
+		o.ChamaID, o.UserID, cycle.Start.Format("2006-01-02"), cycle.End.Format("2006-01-02")).Scan(&already); err != nil {
+		return false, err
+	}
+	amount.Amount -= already
+	if amount.Amount <= 0 {
+		return false, markPaid(ctx, db, o.ID, dueDate)
+	}
+
+	t, err := Debit(ctx, db, o.UserID, o.ChamaID, amount)
+	if errors.Is(err, ErrInsufficientFunds) {
+		if shortfall == dueDate {
+			return false, nil
+		}
+		notifyShortfall(ctx, db, notifier, o, amount)
+		_, err = db.ExecContext(ctx, `
+			UPDATE standing_orders SET last_shortfall_due = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			dueDate, o.ID)
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	c, err := contributions.Record(ctx, db, store, notifier, contributions.Contribution{
+		ChamaID: o.ChamaID, MemberID: o.UserID, Amount: amount, Date: now, Method: MethodWallet,
+		Reference: t.ID, Notes: "Standing order",
+	}, "")
+	if err != nil {
+		if rerr := Refund(ctx, db, t, err.Error()); rerr != nil {
+			return false, fmt.Errorf("failed to refund %s after %v: %w", t.ID, err, rerr)
+		}
+		return false, err
+	}
+	if err := SetReference(ctx, db, t.ID, c.ID); err != nil {
+		slog.ErrorContext(ctx, "Failed to link wallet debit to contribution", "transaction_id", t.ID, "error", err)
+	}
+	return true, markPaid(ctx, db, o.ID, dueDate)
+}
+
+func markPaid(ctx context.Context, db *sql.DB, id, dueDate string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE standing_orders SET last_paid_due = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, dueDate, id)
+	return err
+}
+
+// notifyShortfall tells a member their wallet cannot cover a standing order
+func notifyShortfall(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, o Order, amount money.Money) {
+	if notifier == nil {
+		return
+	}
+	balance := money.New(0, amount.Currency)
+	db.QueryRowContext(ctx, `SELECT balance_minor FROM wallets WHERE user_id = ? AND currency = ?`,
+		o.UserID, amount.Currency).Scan(&balance.Amount)
+	err := notifier.Notify(ctx, notifications.Notification{
+		UserID: o.UserID,
+		Title:  i18n.T(i18n.Default, "wallet.insufficient_funds", nil),
+		Message: i18n.T(i18n.Default, "notification.wallet_insufficient_funds", map[string]string{
+			"amount": amount.String(), "chama": o.ChamaName, "balance": balance.String(),
+		}),
+		Type:      notifications.TypePayment,
+		RelatedID: o.ID,
+		Priority:  notifications.PriorityHigh,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to notify wallet shortfall", "order_id", o.ID, "error", err)
+	}
+}
+
+// RegisterStandingOrderJob pays standing orders each morning, before arrears are detected
+func RegisterStandingOrderJob(s *jobs.Scheduler, db *sql.DB, store storage.Backend, notifier *notifications.Notifier) {
+	s.Register("standing_orders", jobs.Daily{Hour: 5}, func(ctx context.Context) error {
+		_, err := RunOrders(ctx, db, store, notifier, time.Now().UTC())
+		return err
+	})
+}
@@ -0,0 +1,320 @@
+// Package wallets keeps money members have loaded in advance. A member tops
+// up their wallet by M-Pesa and sets standing orders, which pay their
+// contribution to a chama out of the wallet when it falls due. Wallets hold
+// one balance per currency and never go below zero.
+package wallets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/payments"
+
+	"github.com/google/uuid"
+)
+
+// MethodWallet is the payment method of contributions paid from a wallet
+const MethodWallet = "wallet"
+
+// Transaction kinds
+const (
+	KindTopUp        = "top_up"
+	KindContribution = "contribution"
+	KindRefund       = "refund" // a contribution that could not be recorded, paid back
+)
+
+// Transaction statuses
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+var (
+	// ErrInsufficientFunds is returned when a wallet cannot cover a payment
+	ErrInsufficientFunds = errors.New("insufficient funds in wallet")
+	// ErrInvalidAmount is returned for a top-up or payment that is not positive
+	ErrInvalidAmount = errors.New("amount must be positive")
+)
+
+// Balance is what a wallet holds in one currency
+type Balance struct {
+	Amount    money.Money `json:"amount"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+}
+
+// Transaction is money into or out of a wallet. Amounts are positive into
+// the wallet and negative out of it.
+type Transaction struct {
+	ID                string      `json:"id"`
+	UserID            string      `json:"userId"`
+	Kind              string      `json:"kind"`
+	Amount            money.Money `json:"amount"`
+	Status            string      `json:"status"`
+	Method            string      `json:"method,omitempty"`
+	ProviderReference string      `json:"-"`
+	Receipt           string      `json:"receipt,omitempty"`
+	ChamaID           string      `json:"chamaId,omitempty"`
+	Reference         string      `json:"reference,omitempty"` // the contribution paid, for contributions and refunds
+	Notes             string      `json:"notes,omitempty"`
+	CreatedAt         time.Time   `json:"createdAt"`
+}
+
+const columns = `id, user_id, kind, amount_minor, currency, status, COALESCE(method, ''),
+	COALESCE(provider_reference, ''), COALESCE(receipt, ''), COALESCE(chama_id, ''), COALESCE(reference, ''),
+	COALESCE(notes, ''), created_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Transaction, error) {
+	var t Transaction
+	err := row.Scan(&t.ID, &t.UserID, &t.Kind, &t.Amount.Amount, &t.Amount.Currency, &t.Status, &t.Method,
+		&t.ProviderReference, &t.Receipt, &t.ChamaID, &t.Reference, &t.Notes, &t.CreatedAt)
+	return t, err
+}
+
+// Balances returns userID's balance in each currency they have held
+func Balances(ctx context.Context, db *sql.DB, userID string) ([]Balance, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT balance_minor, currency, updated_at FROM wallets WHERE user_id = ? ORDER BY currency`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Balance{}
+	for rows.Next() {
+		var b Balance
+		if err := rows.Scan(&b.Amount.Amount, &b.Amount.Currency, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return list, rows.Err()
+}
+
+// Transactions returns userID's latest wallet transactions, newest first
+func Transactions(ctx context.Context, db *sql.DB, userID string, limit int) ([]Transaction, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+columns+` FROM wallet_transactions WHERE user_id = ? ORDER BY created_at DESC, id LIMIT ?`,
+		userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Transaction{}
+	for rows.Next() {
+		t, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// TopUp asks provider to collect amount from phone into userID's wallet. The
+// wallet is credited when the provider's callback reports the payment.
+func TopUp(ctx context.Context, db *sql.DB, provider payments.Provider, userID string, amount money.Money, phone string) (Transaction, error) {
+	if provider == nil {
+		return Transaction{}, payments.ErrNoProvider
+	}
+	if amount.Amount <= 0 {
+		return Transaction{}, ErrInvalidAmount
+	}
+	t := Transaction{
+		ID: uuid.NewString(), UserID: userID, Kind: KindTopUp, Amount: amount, Status: StatusPending,
+		Method: provider.Name(), CreatedAt: time.Now().UTC(),
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO wallet_transactions (id, user_id, kind, amount_minor, currency, status, method)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.UserID, t.Kind, t.Amount.Amount, t.Amount.Currency, t.Status, t.Method)
+	if err != nil {
+		return t, fmt.Errorf("failed to record top-up: %w", err)
+	}
+
+	ref, err := provider.InitiatePayment(ctx, payments.PaymentRequest{
+		Reference: t.ID, Phone: phone, Amount: amount, Description: "Wallet top-up",
+	})
+	if err != nil {
+		db.ExecContext(ctx, `UPDATE wallet_transactions SET status = ?, notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			StatusFailed, err.Error(), t.ID)
+		return t, err
+	}
+	t.ProviderReference = ref
+	_, err = db.ExecContext(ctx, `
+		UPDATE wallet_transactions SET provider_reference = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, ref, t.ID)
+	return t, err
+}
+
+// Processor credits wallets from provider's callbacks for top-ups and hands
+// every other callback to next, so top-ups can share the provider's callback
+// URL with contributions. A completed top-up is only credited once the
+// provider confirms it. Replays of settled top-ups are ignored.
+func Processor(provider payments.Provider, next payments.Processor) payments.Processor {
+	return func(ctx context.Context, db *sql.DB, payload []byte) error {
+		res, err := provider.HandleCallback(payload)
+		if err != nil {
+			return err
+		}
+		t, err := scan(db.QueryRowContext(ctx, `
+			SELECT `+columns+` FROM wallet_transactions WHERE kind = ? AND method = ? AND provider_reference = ?`,
+			KindTopUp, provider.Name(), res.ProviderReference))
+		if err == sql.ErrNoRows {
+			return next(ctx, db, payload)
+		}
+		if err != nil {
+			return err
+		}
+		if res, err = payments.Confirm(ctx, provider, res); err != nil {
+			return err
+		}
+		return credit(ctx, db, t, res)
+	}
+}
+
+// credit settles the pending top-up t as the provider reports
+func credit(ctx context.Context, db *sql.DB, t Transaction, res payments.PaymentResult) error {
+	if t.Status != StatusPending {
+		return nil
+	}
+	switch res.Status {
+	case payments.PaymentPending:
+		return nil
+	case payments.PaymentFailed:
+		if !res.Confirmed {
+			return fmt.Errorf("%w: %s payment %s", payments.ErrUnconfirmed, t.Method, res.ProviderReference)
+		}
+		_, err := db.ExecContext(ctx, `
+			UPDATE wallet_transactions SET status = ?, notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+			StatusFailed, res.Message, t.ID, StatusPending)
+		return err
+	}
+	switch {
+	case !res.Confirmed:
+		return fmt.Errorf("%w: %s payment %s", payments.ErrUnconfirmed, t.Method, res.ProviderReference)
+	case res.Amount == 0:
+	case res.Amount != t.Amount.Amount:
+		return fmt.Errorf("%s paid %d but top-up %s is for %d", t.Method, res.Amount, t.ID, t.Amount.Amount)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	r, err := tx.ExecContext(ctx, `
+		UPDATE wallet_transactions SET status = ?, receipt = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		StatusCompleted, nullIfEmpty(res.Receipt), t.ID, StatusPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := r.RowsAffected(); n == 0 {
+		return nil
+	}
+	if err := adjust(ctx, tx, t.UserID, t.Amount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// adjust adds amount, which may be negative, to a wallet's balance. Debits
+// that would take the balance below zero fail with ErrInsufficientFunds.
+func adjust(ctx context.Context, tx *sql.Tx, userID string, amount money.Money) error {
+	if amount.Amount >= 0 {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO wallets (user_id, currency, balance_minor) VALUES (?, ?, ?)
+			ON CONFLICT (user_id, currency) DO UPDATE SET
+				balance_minor = wallets.balance_minor + excluded.balance_minor, updated_at = CURRENT_TIMESTAMP`,
+			userID, amount.Currency, amount.Amount)
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE wallets SET balance_minor = balance_minor + ?, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND currency = ? AND balance_minor >= ?`,
+		amount.Amount, userID, amount.Currency, -amount.Amount)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
+
+// Debit takes amount out of userID's wallet to pay a chama and returns the
+// transaction. It fails with ErrInsufficientFunds, leaving the wallet as it
+// was, when the balance does not cover it.
+func Debit(ctx context.Context, db *sql.DB, userID, chamaID string, amount money.Money) (Transaction, error) {
+	if amount.Amount <= 0 {
+		return Transaction{}, ErrInvalidAmount
+	}
+	t := Transaction{
+		ID: uuid.NewString(), UserID: userID, Kind: KindContribution, Amount: amount.Negate(),
+		Status: StatusCompleted, Method: MethodWallet, ChamaID: chamaID, CreatedAt: time.Now().UTC(),
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return t, err
+	}
+	defer tx.Rollback()
+	if err := adjust(ctx, tx, userID, t.Amount); err != nil {
+		return t, err
+	}
+	if err := insert(ctx, tx, t); err != nil {
+		return t, err
+	}
+	return t, tx.Commit()
+}
+
+// Refund pays a debit back into the wallet, when what it paid for could not
+// be recorded
+func Refund(ctx context.Context, db *sql.DB, debit Transaction, reason string) error {
+	t := Transaction{
+		ID: uuid.NewString(), UserID: debit.UserID, Kind: KindRefund, Amount: debit.Amount.Negate(),
+		Status: StatusCompleted, Method: MethodWallet, ChamaID: debit.ChamaID, Reference: debit.ID, Notes: reason,
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := adjust(ctx, tx, t.UserID, t.Amount); err != nil {
+		return err
+	}
+	if err := insert(ctx, tx, t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetReference records what a wallet transaction paid for
+func SetReference(ctx context.Context, db *sql.DB, id, reference string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE wallet_transactions SET reference = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, reference, id)
+	return err
+}
+
+func insert(ctx context.Context, tx *sql.Tx, t Transaction) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO wallet_transactions (id, user_id, kind, amount_minor, currency, status, method, chama_id, reference, notes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.UserID, t.Kind, t.Amount.Amount, t.Amount.Currency, t.Status, nullIfEmpty(t.Method),
+		nullIfEmpty(t.ChamaID), nullIfEmpty(t.Reference), nullIfEmpty(t.Notes))
+	if err != nil {
+		return fmt.Errorf("failed to record wallet transaction: %w", err)
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
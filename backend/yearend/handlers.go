@@ -0,0 +1,231 @@
+package yearend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/chamas"
+	"tujifund-app/backend/i18n"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// managerRoles may close and reopen financial years
+var managerRoles = []string{chamas.RoleAdmin, chamas.RoleChairperson, chamas.RoleTreasurer}
+
+// ListHandler lists the {chamaId} chama's closed financial years to its officials
+func ListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.IsOfficial(db, chamaID, userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		list, err := List(r.Context(), db, chamaID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// CloseHandler closes the {chamaId} chama's financial year containing
+// {"date"}, or the year before the current one when it is left out
+func CloseHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(string)
+		if !ok {
+			http.Error(w, "User not authenticated", http.StatusUnauthorized)
+			return
+		}
+		chamaID := mux.Vars(r)["chamaId"]
+		if !chamas.HasRole(db, chamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			Date string `json:"date"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		t := time.Now().UTC().AddDate(-1, 0, 0)
+		if request.Date != "" {
+			v := validation.New()
+			v.Date("date", request.Date)
+			if !v.Valid() {
+				validation.WriteErrors(w, r, v.Errors())
+				return
+			}
+			t, _ = time.Parse("2006-01-02", request.Date)
+		}
+
+		y, err := Close(r.Context(), db, chamaID, t, audit.FromRequest(r, audit.Entry{}))
+		switch {
+		case errors.Is(err, ErrNotEnded), errors.Is(err, ErrAlreadyClosed):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(y)
+	}
+}
+
+// GetHandler returns the {yearId} financial year with its carried-forward balances
+func GetHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		y, _, ok := loadYear(db, w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(y)
+	}
+}
+
+// ReopenHandler asks for the {yearId} financial year to be reopened with a
+// {"reason"}. The chama's other managers are asked to approve.
+func ReopenHandler(db *sql.DB, notifier *notifications.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		y, userID, ok := loadYear(db, w, r)
+		if !ok {
+			return
+		}
+		if !chamas.HasRole(db, y.ChamaID, userID, managerRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var request struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		v := validation.New()
+		v.Required("reason", request.Reason)
+		if !v.Valid() {
+			validation.WriteErrors(w, r, v.Errors())
+			return
+		}
+
+		y, err := RequestReopen(r.Context(), db, y.ID, request.Reason, userID)
+		if errors.Is(err, ErrNotClosed) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyApprovers(r.Context(), db, notifier, y)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(y)
+	}
+}
+
+// ApproveReopenHandler approves the request to reopen the {yearId} financial year
+func ApproveReopenHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decideHandler(db, w, r, func(id string) (Year, error) {
+			return ApproveReopen(r.Context(), db, id, audit.FromRequest(r, audit.Entry{}))
+		})
+	}
+}
+
+// RejectReopenHandler turns down the request to reopen the {yearId} financial year
+func RejectReopenHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decideHandler(db, w, r, func(id string) (Year, error) {
+			return RejectReopen(r.Context(), db, id)
+		})
+	}
+}
+
+func decideHandler(db *sql.DB, w http.ResponseWriter, r *http.Request, decide func(id string) (Year, error)) {
+	y, userID, ok := loadYear(db, w, r)
+	if !ok {
+		return
+	}
+	if !chamas.HasRole(db, y.ChamaID, userID, managerRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	y, err := decide(y.ID)
+	switch {
+	case errors.Is(err, ErrNoReopenRequest), errors.Is(err, ErrSelfApproval):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(y)
+}
+
+// loadYear loads the {yearId} financial year for one of the chama's
+// officials, writing an error response and returning false otherwise
+func loadYear(db *sql.DB, w http.ResponseWriter, r *http.Request) (Year, string, bool) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return Year{}, "", false
+	}
+	y, err := Get(r.Context(), db, mux.Vars(r)["yearId"])
+	if errors.Is(err, ErrNotFound) || (err == nil && !chamas.IsOfficial(db, y.ChamaID, userID)) {
+		http.Error(w, "Financial year not found", http.StatusNotFound)
+		return y, userID, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return y, userID, false
+	}
+	return y, userID, true
+}
+
+// notifyApprovers asks the chama's other managers to review a request to reopen y
+func notifyApprovers(ctx context.Context, db *sql.DB, notifier *notifications.Notifier, y Year) {
+	if notifier == nil {
+		return
+	}
+	approvers, err := chamas.MembersWithRole(db, y.ChamaID, managerRoles...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load reopen approvers", "year_id", y.ID, "error", err)
+		return
+	}
+	params := map[string]string{"year": y.Label, "reason": y.ReopenReason}
+	for _, id := range approvers {
+		if id == y.ReopenRequestedBy {
+			continue
+		}
+		notifier.Notify(ctx, notifications.Notification{
+			UserID:    id,
+			Title:     i18n.T(i18n.Default, "financial_year.reopen_requested", nil),
+			Message:   i18n.T(i18n.Default, "notification.financial_year_reopen_requested", params),
+			Type:      notifications.TypeChama,
+			RelatedID: y.ID,
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package yearend
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"tujifund-app/backend/jobs"
+	"tujifund-app/backend/notifications"
+	"tujifund-app/backend/reports"
+	"tujifund-app/backend/storage"
+)
+
+// RegisterReportsJob generates the financial report and member statements
+// for each year closed since it last ran
+func RegisterReportsJob(s *jobs.Scheduler, db *sql.DB, store storage.Backend, notifier *notifications.Notifier) {
+	s.Register("year_end_reports", jobs.Every(time.Hour), func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `
+			SELECT `+columns+` FROM financial_years WHERE status = ? AND reports_generated_at IS NULL ORDER BY closed_at`,
+			StatusClosed)
+		if err != nil {
+			return err
+		}
+		var years []Year
+		for rows.Next() {
+			y, err := scan(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			years = append(years, y)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, y := range years {
+			p := reports.Period{From: y.Start, To: y.End}
+			if err := reports.GenerateYear(ctx, db, store, notifier, y.ChamaID, p, "FY"+y.Label); err != nil {
+				slog.ErrorContext(ctx, "Failed to generate year-end reports", "chama_id", y.ChamaID, "year", y.Label, "error", err)
+				continue
+			}
+			if _, err := db.ExecContext(ctx, `
+				UPDATE financial_years SET reports_generated_at = CURRENT_TIMESTAMP WHERE id = ?`, y.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
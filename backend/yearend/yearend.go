@@ -0,0 +1,316 @@
+// Package yearend closes a chama's financial year. Closing locks the year so
+// nothing more can be posted into it, records every fund's closing balance
+// and every member's savings as the next year's opening figures, restarts
+// receipt numbering when the chama's rules ask for it, and queues the
+// year's financial report and member statements. A closed year can only be
+// reopened once a second official approves the request.
+package yearend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"tujifund-app/backend/audit"
+	"tujifund-app/backend/ledger"
+	"tujifund-app/backend/money"
+	"tujifund-app/backend/rules"
+
+	"github.com/google/uuid"
+)
+
+// Year statuses. A year is locked unless it has been reopened.
+const (
+	StatusClosed          = "closed"
+	StatusReopenRequested = "reopen_requested"
+	StatusReopened        = "reopened"
+)
+
+var (
+	// ErrNotFound is returned for a financial year that has not been closed
+	ErrNotFound = errors.New("financial year not found")
+	// ErrNotEnded is returned when closing a year that is still running
+	ErrNotEnded = errors.New("financial year has not ended yet")
+	// ErrAlreadyClosed is returned when closing a year that is already closed
+	ErrAlreadyClosed = errors.New("financial year is already closed")
+	// ErrNotClosed is returned when asking to reopen a year that is not closed
+	ErrNotClosed = errors.New("financial year is not closed")
+	// ErrNoReopenRequest is returned when deciding on a year nobody has asked to reopen
+	ErrNoReopenRequest = errors.New("financial year has no reopen request")
+	// ErrSelfApproval is returned when the official who asked to reopen a year tries to approve it
+	ErrSelfApproval = errors.New("reopening must be approved by someone other than the requester")
+)
+
+// Year is one of a chama's closed financial years, [Start, End)
+type Year struct {
+	ID                 string     `json:"id"`
+	ChamaID            string     `json:"chamaId"`
+	Label              string     `json:"label"`
+	Start              time.Time  `json:"start"`
+	End                time.Time  `json:"end"`
+	Status             string     `json:"status"`
+	ClosedBy           string     `json:"closedBy"`
+	ClosedAt           time.Time  `json:"closedAt"`
+	ReopenReason       string     `json:"reopenReason,omitempty"`
+	ReopenRequestedBy  string     `json:"reopenRequestedBy,omitempty"`
+	ReopenedBy         string     `json:"reopenedBy,omitempty"`
+	ReopenedAt         *time.Time `json:"reopenedAt,omitempty"`
+	ReportsGeneratedAt *time.Time `json:"reportsGeneratedAt,omitempty"`
+	Balances           []Balance  `json:"balances,omitempty"`
+}
+
+// Balance is a closing balance carried forward into the next year: a fund's
+// balance, or a member's savings when MemberID is set
+type Balance struct {
+	AccountID string      `json:"accountId,omitempty"`
+	MemberID  string      `json:"memberId,omitempty"`
+	Balance   money.Money `json:"balance"`
+}
+
+// savingsTypes are the entry types that make up a member's savings
+var savingsTypes = []interface{}{ledger.TypeContribution, ledger.TypeOpeningBalance, ledger.TypeSavingsInterest,
+	ledger.TypeDistribution, ledger.TypeWithdrawal}
+
+// Label names the financial year starting at start: "2025" for a calendar
+// year, "2025-26" for one that straddles two
+func Label(start time.Time) string {
+	if start.Month() == time.January {
+		return start.Format("2006")
+	}
+	return fmt.Sprintf("%d-%02d", start.Year(), (start.Year()+1)%100)
+}
+
+const columns = `id, chama_id, label, start_date, end_date, status, closed_by, closed_at, COALESCE(reopen_reason, ''),
+	COALESCE(reopen_requested_by, ''), COALESCE(reopened_by, ''), reopened_at, reports_generated_at`
+
+func scan(row interface{ Scan(...interface{}) error }) (Year, error) {
+	var y Year
+	err := row.Scan(&y.ID, &y.ChamaID, &y.Label, &y.Start, &y.End, &y.Status, &y.ClosedBy, &y.ClosedAt,
+		&y.ReopenReason, &y.ReopenRequestedBy, &y.ReopenedBy, &y.ReopenedAt, &y.ReportsGeneratedAt)
+	if err == sql.ErrNoRows {
+		return y, ErrNotFound
+	}
+	return y, err
+}
+
+// Get returns a closed financial year with its carried-forward balances
+func Get(ctx context.Context, db *sql.DB, id string) (Year, error) {
+	y, err := scan(db.QueryRowContext(ctx, `SELECT `+columns+` FROM financial_years WHERE id = ?`, id))
+	if err != nil {
+		return y, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(account_id, ''), COALESCE(member_id, ''), balance_minor, currency
+		FROM financial_year_balances WHERE year_id = ? ORDER BY member_id IS NOT NULL, account_id, member_id`, id)
+	if err != nil {
+		return y, err
+	}
+	defer rows.Close()
+	y.Balances = []Balance{}
+	for rows.Next() {
+		var b Balance
+		if err := rows.Scan(&b.AccountID, &b.MemberID, &b.Balance.Amount, &b.Balance.Currency); err != nil {
+			return y, err
+		}
+		y.Balances = append(y.Balances, b)
+	}
+	return y, rows.Err()
+}
+
+// List returns the chama's closed financial years, latest first
+func List(ctx context.Context, db *sql.DB, chamaID string) ([]Year, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+columns+` FROM financial_years WHERE chama_id = ? ORDER BY start_date DESC`,
+		chamaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Year{}
+	for rows.Next() {
+		y, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, y)
+	}
+	return list, rows.Err()
+}
+
+// Close closes the chama's financial year containing t, which must have
+// ended. A reopened year is closed again, replacing its closing balances.
+func Close(ctx context.Context, db *sql.DB, chamaID string, t time.Time, entry audit.Entry) (Year, error) {
+	rs, _, err := rules.At(ctx, db, chamaID, t)
+	if err != nil {
+		return Year{}, err
+	}
+	start, end := rs.FinancialYear(t.UTC())
+	if end.After(time.Now().UTC()) {
+		return Year{}, ErrNotEnded
+	}
+	current, err := rules.Current(ctx, db, chamaID)
+	if err != nil {
+		return Year{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Year{}, err
+	}
+	defer tx.Rollback()
+
+	y := Year{ID: uuid.NewString(), ChamaID: chamaID, Label: Label(start), Start: start, End: end}
+	var id, status string
+	err = tx.QueryRowContext(ctx, `SELECT id, status FROM financial_years WHERE chama_id = ? AND label = ?`,
+		chamaID, y.Label).Scan(&id, &status)
+	switch {
+	case err == nil && status != StatusReopened:
+		return y, ErrAlreadyClosed
+	case err == nil:
+		y.ID = id
+		_, err = tx.ExecContext(ctx, `
+			UPDATE financial_years SET status = ?, closed_by = ?, closed_at = CURRENT_TIMESTAMP, reopen_reason = NULL,
+				reopen_requested_by = NULL, reopened_by = NULL, reopened_at = NULL, reports_generated_at = NULL
+			WHERE id = ?`, StatusClosed, entry.UserID, y.ID)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM financial_year_balances WHERE year_id = ?`, y.ID)
+		}
+	case err == sql.ErrNoRows:
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO financial_years (id, chama_id, label, start_date, end_date, status, closed_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			y.ID, chamaID, y.Label, start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"),
+			StatusClosed, entry.UserID)
+	}
+	if err != nil {
+		return y, fmt.Errorf("failed to close financial year: %w", err)
+	}
+
+	if err := carryForward(ctx, tx, y); err != nil {
+		return y, err
+	}
+	if current.ResetReceiptNumbers {
+		next := "FY" + Label(end)
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO receipt_sequences (chama_id, last_number, series) VALUES (?, 0, ?)
+			ON CONFLICT (chama_id) DO UPDATE SET last_number = 0, series = excluded.series
+			WHERE COALESCE(receipt_sequences.series, '') < excluded.series`, chamaID, next)
+		if err != nil {
+			return y, err
+		}
+	}
+
+	entry.Action, entry.EntityType, entry.EntityID = "financial_year.close", "financial_year", y.ID
+	entry.NewValues = map[string]interface{}{"chamaId": chamaID, "label": y.Label}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return y, err
+	}
+	if err := tx.Commit(); err != nil {
+		return y, err
+	}
+	return Get(ctx, db, y.ID)
+}
+
+// carryForward records each fund's balance and each member's savings at the
+// end of y as the next year's opening figures
+func carryForward(ctx context.Context, tx *sql.Tx, y Year) error {
+	end := y.End.Format("2006-01-02 15:04:05")
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO financial_year_balances (year_id, account_id, balance_minor, currency)
+		SELECT ?, a.id, COALESCE(SUM(h.amount_minor), 0), a.currency
+		FROM chama_accounts a
+		LEFT JOIN `+ledger.History+` h ON h.account_id = a.id AND h.effective_at < ?
+		WHERE a.chama_id = ?
+		GROUP BY a.id, a.currency`,
+		y.ID, end, y.ChamaID)
+	if err != nil {
+		return fmt.Errorf("failed to carry forward fund balances: %w", err)
+	}
+
+	args := append([]interface{}{y.ID, y.ChamaID, end}, savingsTypes...)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO financial_year_balances (year_id, member_id, balance_minor, currency)
+		SELECT ?, member_id, SUM(amount_minor), currency FROM `+ledger.History+`
+		WHERE chama_id = ? AND member_id IS NOT NULL AND effective_at < ? AND entry_type IN (?, ?, ?, ?, ?)
+		GROUP BY member_id, currency`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to carry forward member savings: %w", err)
+	}
+	return nil
+}
+
+// RequestReopen asks for a closed year to be reopened. It stays locked until
+// another official approves.
+func RequestReopen(ctx context.Context, db *sql.DB, id, reason, by string) (Year, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE financial_years SET status = ?, reopen_reason = ?, reopen_requested_by = ? WHERE id = ? AND status = ?`,
+		StatusReopenRequested, reason, by, id, StatusClosed)
+	if err != nil {
+		return Year{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := Get(ctx, db, id); err != nil {
+			return Year{}, err
+		}
+		return Year{}, ErrNotClosed
+	}
+	return Get(ctx, db, id)
+}
+
+// ApproveReopen reopens a year so entries can be posted into it again. It
+// stays open until it is closed again.
+func ApproveReopen(ctx context.Context, db *sql.DB, id string, entry audit.Entry) (Year, error) {
+	y, err := Get(ctx, db, id)
+	if err != nil {
+		return y, err
+	}
+	if y.Status != StatusReopenRequested {
+		return y, ErrNoReopenRequest
+	}
+	if y.ReopenRequestedBy == entry.UserID {
+		return y, ErrSelfApproval
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return y, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		UPDATE financial_years SET status = ?, reopened_by = ?, reopened_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		StatusReopened, entry.UserID, id, StatusReopenRequested)
+	if err != nil {
+		return y, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return y, ErrNoReopenRequest
+	}
+	entry.Action, entry.EntityType, entry.EntityID = "financial_year.reopen", "financial_year", id
+	entry.NewValues = map[string]interface{}{"label": y.Label, "reason": y.ReopenReason, "requestedBy": y.ReopenRequestedBy}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return y, err
+	}
+	if err := tx.Commit(); err != nil {
+		return y, err
+	}
+	return Get(ctx, db, id)
+}
+
+// RejectReopen turns down a request to reopen a year, which stays closed
+func RejectReopen(ctx context.Context, db *sql.DB, id string) (Year, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE financial_years SET status = ?, reopen_reason = NULL, reopen_requested_by = NULL WHERE id = ? AND status = ?`,
+		StatusClosed, id, StatusReopenRequested)
+	if err != nil {
+		return Year{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := Get(ctx, db, id); err != nil {
+			return Year{}, err
+		}
+		return Year{}, ErrNoReopenRequest
+	}
+	return Get(ctx, db, id)
+}